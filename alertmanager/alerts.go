@@ -23,6 +23,8 @@ import (
 	"github.com/filecoin-project/curio/build"
 	"github.com/filecoin-project/curio/deps/config"
 	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/tasks/ddo"
+	"github.com/filecoin-project/curio/tasks/rollup"
 
 	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/types"
@@ -89,7 +91,9 @@ func NowCheck(al *alerts) {
 // It queries the database for the configuration of each layer and decodes it using the toml.Decode function.
 // It then iterates over the addresses in the configuration and curates a list of unique addresses.
 // If an address is not found in the chain node, it adds an alert to the alert map.
-// If the balance of an address is below MinimumWalletBalance, it adds an alert to the alert map.
+// If the balance of an address is below its threshold in wallet_balance_thresholds, or MinimumWalletBalance
+// when it has none, it adds an alert to the alert map. Addresses with a threshold are checked even when they
+// don't appear in the configuration.
 // If there are any errors encountered during the process, the err field of the alert map is populated.
 func balanceCheck(al *alerts) {
 	Name := "Balance Check"
@@ -103,6 +107,40 @@ func balanceCheck(al *alerts) {
 		return
 	}
 
+	var thresholdRows []struct {
+		Address    string `db:"address"`
+		MinBalance string `db:"min_balance"`
+	}
+	err = al.db.Select(al.ctx, &thresholdRows, `SELECT address, min_balance::TEXT AS min_balance FROM wallet_balance_thresholds`)
+	if err != nil {
+		al.alertMap[Name].err = xerrors.Errorf("getting wallet thresholds: %w", err)
+		return
+	}
+
+	thresholds := map[address.Address]abi.TokenAmount{}
+	for _, t := range thresholdRows {
+		addr, err := address.NewFromString(t.Address)
+		if err != nil {
+			al.alertMap[Name].err = xerrors.Errorf("parsing threshold address %s: %w", t.Address, err)
+			return
+		}
+		minBalance, err := big.FromString(t.MinBalance)
+		if err != nil {
+			al.alertMap[Name].err = xerrors.Errorf("parsing threshold for %s: %w", t.Address, err)
+			return
+		}
+		thresholds[addr] = minBalance
+		if !lo.Contains(uniqueAddrs, addr) {
+			uniqueAddrs = append(uniqueAddrs, addr)
+		}
+	}
+
+	remoteSigned, err := al.remoteSignerAddrs()
+	if err != nil {
+		al.alertMap[Name].err = err
+		return
+	}
+
 	for _, addr := range uniqueAddrs {
 		keyAddr, err := al.api.StateAccountKey(al.ctx, addr, types.EmptyTSK)
 		if err != nil {
@@ -116,7 +154,7 @@ func balanceCheck(al *alerts) {
 			return
 		}
 
-		if !has {
+		if !has && !remoteSigned[keyAddr] {
 			ret += fmt.Sprintf("Wallet %s was not found in chain node. ", keyAddr)
 		}
 
@@ -125,8 +163,16 @@ func balanceCheck(al *alerts) {
 			al.alertMap[Name].err = err
 		}
 
-		if abi.TokenAmount(al.cfg.MinimumWalletBalance).GreaterThanEqual(balance) {
-			ret += fmt.Sprintf("Balance for wallet %s (%s) is below 5 Fil. ", addr, keyAddr)
+		minBalance, ok := thresholds[addr]
+		if !ok {
+			minBalance, ok = thresholds[keyAddr]
+		}
+		if !ok {
+			minBalance = abi.TokenAmount(al.cfg.MinimumWalletBalance)
+		}
+
+		if minBalance.GreaterThanEqual(balance) {
+			ret += fmt.Sprintf("Balance for wallet %s (%s) is below %s. ", addr, keyAddr, types.FIL(minBalance).Short())
 		}
 	}
 	if ret != "" {
@@ -134,6 +180,45 @@ func balanceCheck(al *alerts) {
 	}
 }
 
+// remoteSignerAddrs returns key addresses signed by remote signers in any config layer. Their keys are not
+// expected in the chain node wallet.
+func (al *alerts) remoteSignerAddrs() (map[address.Address]bool, error) {
+	var configs []string
+	err := al.db.Select(al.ctx, &configs, `SELECT config FROM harmony_config`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting db configs: %w", err)
+	}
+
+	out := map[address.Address]bool{}
+	for _, cfg := range configs {
+		var info struct {
+			Apis struct {
+				RemoteSigners []struct {
+					Addresses []string
+				}
+			}
+		}
+		if err := toml.Unmarshal([]byte(cfg), &info); err != nil {
+			continue // layers are validated when set, other checks report unreadable ones
+		}
+
+		for _, rs := range info.Apis.RemoteSigners {
+			for _, as := range rs.Addresses {
+				a, err := address.NewFromString(as)
+				if err != nil {
+					continue
+				}
+				ka, err := al.api.StateAccountKey(al.ctx, a, types.EmptyTSK)
+				if err != nil {
+					return nil, xerrors.Errorf("getting account key of %s: %w", a, err)
+				}
+				out[ka] = true
+			}
+		}
+	}
+	return out, nil
+}
+
 // taskFailureCheck retrieves the task failure counts from the database for a specific time period.
 // It then checks for specific sealing tasks and tasks with more than 5 failures to generate alerts.
 func taskFailureCheck(al *alerts) {
@@ -292,6 +377,45 @@ func permanentStorageCheck(al *alerts) {
 	}
 }
 
+// storageForecastCheck alerts when long-term storage is projected to fill up within Alerting.StorageFullDays.
+func storageForecastCheck(al *alerts) {
+	Name := "StorageForecast"
+	al.alertMap[Name] = &alertOut{}
+
+	if al.cfg.StorageFullDays <= 0 {
+		return
+	}
+
+	paths, classes, err := rollup.ForecastStorage(al.ctx, al.db, 7*24*time.Hour)
+	if err != nil {
+		al.alertMap[Name].err = err
+		return
+	}
+
+	threshold := float64(al.cfg.StorageFullDays)
+	isStore := func(f rollup.StorageForecast) bool {
+		return f.Class == rollup.PathClassStore || f.Class == rollup.PathClassSealStore
+	}
+
+	var msgs []string
+	for _, c := range classes {
+		if isStore(c) && c.DaysUntilFull != nil && *c.DaysUntilFull < threshold {
+			msgs = append(msgs, fmt.Sprintf("%s storage projected to fill up in %.1f days (%s available, growing %s/day)",
+				c.Class, *c.DaysUntilFull, humanize.IBytes(uint64(c.Available)), humanize.IBytes(uint64(c.GrowthPerDay))))
+		}
+	}
+	for _, p := range paths {
+		if isStore(p) && p.DaysUntilFull != nil && *p.DaysUntilFull < threshold {
+			msgs = append(msgs, fmt.Sprintf("Storage path %s projected to fill up in %.1f days (%s available)",
+				p.ID, *p.DaysUntilFull, humanize.IBytes(uint64(p.Available))))
+		}
+	}
+
+	if len(msgs) > 0 {
+		al.alertMap[Name].alertString = strings.Join(msgs, "; ")
+	}
+}
+
 // getAddresses retrieves machine details from the database, stores them in an array and compares layers for uniqueness.
 // It employs addrMap to handle unique addresses, and generated slices for configuration fields and MinerAddresses.
 // The function iterates over layers, storing decoded configuration and verifying address existence in addrMap.
@@ -711,3 +835,103 @@ func chainSyncCheck(al *alerts) {
 		}
 	}
 }
+
+// actorEventCheck alerts on sector terminations and on deadlines closed with faults or missed proofs, from the
+// actor event index. Nothing is reported unless Subsystems.EnableActorEventIndex is set on some node.
+func actorEventCheck(al *alerts) {
+	Name := "ActorEvents"
+	al.alertMap[Name] = &alertOut{}
+
+	head, err := al.api.ChainHead(al.ctx)
+	if err != nil {
+		al.alertMap[Name].err = err
+		return
+	}
+	from := head.Height() - abi.ChainEpoch(math.Ceil(AlertMangerInterval.Seconds()/float64(build.BlockDelaySecs))) - 1
+
+	var terminated []struct {
+		SpID  int64 `db:"sp_id"`
+		Count int64 `db:"count"`
+	}
+	err = al.db.Select(al.ctx, &terminated, `SELECT sp_id, COUNT(*) AS count FROM actor_events
+		WHERE event_type = 'sector-terminated' AND epoch >= $1 GROUP BY sp_id ORDER BY sp_id`, from)
+	if err != nil {
+		al.alertMap[Name].err = xerrors.Errorf("getting terminations: %w", err)
+		return
+	}
+	for _, t := range terminated {
+		maddr, err := address.NewIDAddress(uint64(t.SpID))
+		if err != nil {
+			al.alertMap[Name].err = err
+			return
+		}
+		al.alertMap[Name].alertString += fmt.Sprintf("%d sectors of %s were terminated. ", t.Count, maddr)
+	}
+
+	var deadlines []struct {
+		SpID       int64 `db:"sp_id"`
+		Deadline   int64 `db:"deadline"`
+		CloseEpoch int64 `db:"close_epoch"`
+		Partitions int64 `db:"partitions"`
+		Proven     int64 `db:"proven_partitions"`
+		Faulty     int64 `db:"faulty_sectors"`
+	}
+	err = al.db.Select(al.ctx, &deadlines, `SELECT sp_id, deadline, close_epoch, partitions, proven_partitions, faulty_sectors
+		FROM actor_deadlines WHERE close_epoch >= $1 AND (faulty_sectors > 0 OR proven_partitions < partitions)
+		ORDER BY sp_id, close_epoch`, from)
+	if err != nil {
+		al.alertMap[Name].err = xerrors.Errorf("getting deadlines: %w", err)
+		return
+	}
+	for _, d := range deadlines {
+		maddr, err := address.NewIDAddress(uint64(d.SpID))
+		if err != nil {
+			al.alertMap[Name].err = err
+			return
+		}
+		al.alertMap[Name].alertString += fmt.Sprintf("Deadline %d of %s closed at epoch %d with %d/%d partitions proven and %d faulty sectors. ",
+			d.Deadline, maddr, d.CloseEpoch, d.Proven, d.Partitions, d.Faulty)
+	}
+}
+
+// ddoAllocationCheck alerts on DDO allocations tracked by Curio which aren't claimed yet and expire soon, or which
+// failed to be added to a sector.
+func ddoAllocationCheck(al *alerts) {
+	Name := "DDOAllocations"
+	al.alertMap[Name] = &alertOut{}
+
+	head, err := al.api.ChainHead(al.ctx)
+	if err != nil {
+		al.alertMap[Name].err = err
+		return
+	}
+
+	var allocs []struct {
+		ClientID     int64   `db:"client_id"`
+		AllocationID int64   `db:"allocation_id"`
+		SpID         int64   `db:"sp_id"`
+		Expiration   int64   `db:"expiration"`
+		State        string  `db:"state"`
+		Error        *string `db:"error"`
+	}
+	err = al.db.Select(al.ctx, &allocs, `SELECT client_id, allocation_id, sp_id, expiration, state, error FROM ddo_allocations
+		WHERE state = 'failed' OR (state IN ('pending', 'assigned') AND expiration < $1)
+		ORDER BY expiration`, int64(head.Height()+ddo.ExpirationAlertEpochs))
+	if err != nil {
+		al.alertMap[Name].err = xerrors.Errorf("getting allocations: %w", err)
+		return
+	}
+
+	for _, a := range allocs {
+		if a.State == ddo.StateFailed {
+			var msg string
+			if a.Error != nil {
+				msg = *a.Error
+			}
+			al.alertMap[Name].alertString += fmt.Sprintf("Allocation %d of client f0%d for f0%d failed: %s. ", a.AllocationID, a.ClientID, a.SpID, msg)
+			continue
+		}
+		al.alertMap[Name].alertString += fmt.Sprintf("Allocation %d of client f0%d for f0%d is %s and expires in %d epochs. ",
+			a.AllocationID, a.ClientID, a.SpID, a.State, abi.ChainEpoch(a.Expiration)-head.Height())
+	}
+}
@@ -0,0 +1,50 @@
+package alertmanager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+// recordAlerts stores the results of a check run in alert_history, resolving alerts
+// which are no longer reported. It returns the subset of details which should be
+// sent to alert plugins, i.e. without acknowledged and silenced alerts.
+func recordAlerts(ctx context.Context, db *harmonydb.DB, details map[string]interface{}) (map[string]interface{}, error) {
+	var suppressed []string
+
+	_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		for name, detail := range details {
+			_, err := tx.Exec(`INSERT INTO alert_history (name, message) VALUES ($1, $2)
+				ON CONFLICT (name) WHERE resolved_at IS NULL
+				DO UPDATE SET message = EXCLUDED.message, last_seen = current_timestamp`, name, fmt.Sprint(detail))
+			if err != nil {
+				return false, xerrors.Errorf("recording alert %s: %w", name, err)
+			}
+		}
+
+		_, err = tx.Exec(`UPDATE alert_history SET resolved_at = current_timestamp
+			WHERE resolved_at IS NULL AND NOT (name = ANY($1))`, lo.Keys(details))
+		if err != nil {
+			return false, xerrors.Errorf("resolving alerts: %w", err)
+		}
+
+		suppressed = nil
+		err = tx.Select(&suppressed, `SELECT name FROM alert_history WHERE resolved_at IS NULL AND acked_at IS NOT NULL
+			UNION
+			SELECT name FROM alert_silences WHERE silenced_until > current_timestamp`)
+		if err != nil {
+			return false, xerrors.Errorf("getting suppressed alerts: %w", err)
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return nil, err
+	}
+
+	return lo.OmitByKeys(details, suppressed), nil
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/dline"
@@ -66,10 +67,13 @@ var AlertFuncs = []AlertFunc{
 	balanceCheck,
 	taskFailureCheck,
 	permanentStorageCheck,
+	storageForecastCheck,
 	wdPostCheck,
 	wnPostCheck,
 	NowCheck,
 	chainSyncCheck,
+	actorEventCheck,
+	ddoAllocationCheck,
 }
 
 func NewAlertTask(
@@ -87,11 +91,6 @@ func NewAlertTask(
 }
 
 func (a *AlertTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
-	if len(a.plugins) == 0 {
-		log.Warnf("No alert plugins enabled, not sending an alert")
-		return true, nil
-	}
-
 	ctx := context.Background()
 
 	alMap := make(map[string]*alertOut)
@@ -129,6 +128,16 @@ func (a *AlertTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 		}
 	}
 
+	details, err = recordAlerts(ctx, a.db, details)
+	if err != nil {
+		return false, xerrors.Errorf("recording alerts: %w", err)
+	}
+
+	if len(a.plugins) == 0 {
+		log.Warnf("No alert plugins enabled, not sending an alert")
+		return true, nil
+	}
+
 	// Alert only if required
 	if len(details) > 0 {
 		payloadData := &plugin.AlertPayload{
@@ -35,6 +35,7 @@ type Curio interface {
 	StorageDetachLocal(ctx context.Context, path string) error                                                                                                             //perm:admin
 	StorageList(ctx context.Context) (map[storiface.ID][]storiface.Decl, error)                                                                                            //perm:admin
 	StorageLocal(ctx context.Context) (map[storiface.ID]string, error)                                                                                                     //perm:admin
+	StorageSetRestrictions(ctx context.Context, id storiface.ID, r storiface.PathRestrictions) error                                                                       //perm:admin
 	StorageStat(ctx context.Context, id storiface.ID) (fsutil.FsStat, error)                                                                                               //perm:admin
 	StorageInfo(context.Context, storiface.ID) (storiface.StorageInfo, error)                                                                                              //perm:admin
 	StorageFindSector(ctx context.Context, sector abi.SectorID, ft storiface.SectorFileType, ssize abi.SectorSize, allowFetch bool) ([]storiface.SectorStorageInfo, error) //perm:admin
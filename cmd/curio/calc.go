@@ -32,6 +32,14 @@ It provides detailed information about CPU utilization for batch sealing operati
 distribution for different batch sizes.`,
 	Flags: []cli.Flag{
 		&cli.BoolFlag{Name: "dual-hashers", Value: true},
+		&cli.IntFlag{
+			Name:  "numa-node",
+			Usage: "NUMA node (CPU socket) to place the batch sealer on",
+		},
+		&cli.IntFlag{
+			Name:  "max-cores",
+			Usage: "maximum number of cores to use, rounded down to whole CCXs, 0 uses all cores of the node",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		info, err := sealsupra.GetSystemInfo()
@@ -52,6 +60,11 @@ distribution for different batch sizes.`,
 		ccxFreeThreads := ccxFreeCores * info.ThreadsPerCore
 		fmt.Printf("Hasher Threads per CCX: %d\n", ccxFreeThreads)
 
+		tuning := sealsupra.SupraSealTuning{
+			NUMANode: cctx.Int("numa-node"),
+			MaxCores: cctx.Int("max-cores"),
+		}
+
 		sectorsPerThread := 1
 		if cctx.Bool("dual-hashers") {
 			sectorsPerThread = 2
@@ -66,7 +79,7 @@ distribution for different batch sizes.`,
 			fmt.Printf("Batch Size: %s sectors\n", color.CyanString("%d", batchSize))
 			fmt.Println()
 
-			config, err := sealsupra.GenerateSupraSealConfig(*info, cctx.Bool("dual-hashers"), batchSize, nil)
+			config, err := sealsupra.GenerateSupraSealConfig(*info, cctx.Bool("dual-hashers"), batchSize, nil, tuning)
 			if err != nil {
 				fmt.Printf("Error generating config: %s\n", err)
 				return
@@ -76,7 +89,7 @@ distribution for different batch sizes.`,
 			fmt.Printf("Required CCX: %d\n", config.RequiredCCX)
 			fmt.Printf("Required Cores: %d hasher (+4 minimum for non-hashers)\n", config.RequiredCores)
 
-			enoughCores := config.RequiredCores <= info.CoreCount
+			enoughCores := config.RequiredCores <= config.Cores
 			if enoughCores {
 				fmt.Printf("Enough cores available for hashers %s\n", color.GreenString("✔"))
 			} else {
@@ -84,7 +97,7 @@ distribution for different batch sizes.`,
 				return
 			}
 
-			fmt.Printf("Non-hasher cores: %d\n", info.CoreCount-config.RequiredCores)
+			fmt.Printf("Non-hasher cores: %d\n", config.Cores-config.RequiredCores)
 
 			if config.P2WrRdOverlap {
 				color.Yellow("! P2 writer will share a core with P2 reader, performance may be impacted")
@@ -110,7 +123,9 @@ distribution for different batch sizes.`,
 			fmt.Printf("c1 reader: %d\n", config.Topology.C1Reader)
 			fmt.Println()
 
-			fmt.Printf("Unoccupied Cores: %d\n\n", config.UnoccupiedCores)
+			fmt.Printf("Unoccupied Cores: %d\n", config.UnoccupiedCores)
+			fmt.Printf("First Core: %d\n", config.FirstCore)
+			fmt.Printf("Used Threads: %d\n\n", config.UsedThreads)
 
 			fmt.Println("{")
 			fmt.Printf("  sectors = %d;\n", batchSize)
@@ -156,9 +171,20 @@ The config can be used directly with SupraSeal binaries to test it without invol
 			Aliases:  []string{"b"},
 			Required: true,
 		},
+		&cli.IntFlag{
+			Name:  "numa-node",
+			Usage: "NUMA node (CPU socket) to place the batch sealer on",
+		},
+		&cli.IntFlag{
+			Name:  "max-cores",
+			Usage: "maximum number of cores to use, rounded down to whole CCXs, 0 uses all cores of the node",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
-		cstr, err := sealsupra.GenerateSupraSealConfigString(cctx.Bool("dual-hashers"), cctx.Int("batch-size"), nil)
+		cstr, _, err := sealsupra.GenerateSupraSealConfigString(cctx.Bool("dual-hashers"), cctx.Int("batch-size"), nil, sealsupra.SupraSealTuning{
+			NUMANode: cctx.Int("numa-node"),
+			MaxCores: cctx.Int("max-cores"),
+		})
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,118 @@
+package guidedsetup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-statestore"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/lib/types/sector"
+	"github.com/filecoin-project/curio/tasks/lmimport"
+
+	"github.com/filecoin-project/lotus/node/repo"
+)
+
+// LMImportSummary is what was staged for an import
+type LMImportSummary struct {
+	ImportID     int64
+	Miner        address.Address
+	Sectors      int
+	Unmigratable map[sector.SectorState]int
+	StoragePaths []lmimport.StoragePath
+}
+
+// StageLMImport reads the sector metadata and storage layout of a locked lotus-miner repo and stages them as an
+// import, which the LMImport tasks validate against chain state and apply. Sectors in states which can't be
+// imported are recorded as skipped, unless unmigSectorShouldFail is set, in which case nothing is staged.
+func StageLMImport(ctx context.Context, lr repo.LockedRepo, minerRepo, stagedBy string, db *harmonydb.DB, unmigSectorShouldFail bool) (LMImportSummary, error) {
+	mmeta, err := lr.Datastore(ctx, "/metadata")
+	if err != nil {
+		return LMImportSummary{}, xerrors.Errorf("opening miner metadata datastore: %w", err)
+	}
+
+	maddrBytes, err := mmeta.Get(ctx, datastore.NewKey("miner-address"))
+	if err != nil {
+		return LMImportSummary{}, xerrors.Errorf("getting miner address datastore entry: %w", err)
+	}
+
+	maddr, err := address.NewFromBytes(maddrBytes)
+	if err != nil {
+		return LMImportSummary{}, xerrors.Errorf("parsing miner actor address: %w", err)
+	}
+
+	sts := statestore.New(namespace.Wrap(mmeta, datastore.NewKey(sectorStorePrefix)))
+
+	var sectors []SectorInfo
+	if err := sts.List(&sectors); err != nil {
+		return LMImportSummary{}, xerrors.Errorf("getting sector list: %w", err)
+	}
+
+	summary := LMImportSummary{
+		Miner:        maddr,
+		Sectors:      len(sectors),
+		Unmigratable: map[sector.SectorState]int{},
+	}
+
+	staged := make([]lmimport.StagedSector, 0, len(sectors))
+	for _, sectr := range sectors {
+		meta, err := toSectorMeta(sectr)
+		if err != nil {
+			return LMImportSummary{}, err
+		}
+
+		ss := lmimport.StagedSector{State: string(sectr.State), Meta: meta}
+		switch {
+		case !migratableState(sectr.State):
+			summary.Unmigratable[sectr.State]++
+			ss.SkipReason = "sector is still in the lotus-miner sealing pipeline in state " + string(sectr.State)
+		case sectr.State == sector.Removed:
+			ss.SkipReason = "sector was removed in lotus-miner"
+		}
+		staged = append(staged, ss)
+	}
+
+	if len(summary.Unmigratable) > 0 && unmigSectorShouldFail {
+		return summary, xerrors.Errorf("aborting import because sectors were found that are not migratable")
+	}
+
+	sc, err := lr.GetStorage()
+	if err != nil {
+		return LMImportSummary{}, xerrors.Errorf("getting lotus-miner storage config: %w", err)
+	}
+
+	for _, lp := range sc.StoragePaths {
+		mb, err := os.ReadFile(filepath.Join(lp.Path, paths.MetaFile))
+		if err != nil {
+			return LMImportSummary{}, xerrors.Errorf("reading storage metadata of %s: %w", lp.Path, err)
+		}
+
+		var meta storiface.LocalStorageMeta
+		if err := json.Unmarshal(mb, &meta); err != nil {
+			return LMImportSummary{}, xerrors.Errorf("unmarshalling storage metadata of %s: %w", lp.Path, err)
+		}
+
+		summary.StoragePaths = append(summary.StoragePaths, lmimport.StoragePath{
+			ID:       string(meta.ID),
+			Path:     lp.Path,
+			CanSeal:  meta.CanSeal,
+			CanStore: meta.CanStore,
+		})
+	}
+
+	summary.ImportID, err = lmimport.Stage(ctx, db, maddr, minerRepo, stagedBy, staged, summary.StoragePaths)
+	if err != nil {
+		return LMImportSummary{}, xerrors.Errorf("staging import: %w", err)
+	}
+
+	return summary, nil
+}
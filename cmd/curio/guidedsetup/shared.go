@@ -28,6 +28,7 @@ import (
 	"github.com/filecoin-project/curio/harmony/harmonydb"
 	"github.com/filecoin-project/curio/lib/storiface"
 	"github.com/filecoin-project/curio/lib/types/sector"
+	"github.com/filecoin-project/curio/tasks/lmimport"
 
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/node/repo"
@@ -332,15 +333,6 @@ func MigrateSectors(ctx context.Context, maddr address.Address, mmeta datastore.
 
 	logMig(len(sectors))
 
-	migratableState := func(state sector.SectorState) bool {
-		switch state {
-		case sector.Proving, sector.Available, sector.UpdateActivating, sector.Removed:
-			return true
-		default:
-			return false
-		}
-	}
-
 	unmigratable := map[sector.SectorState]int{}
 
 	for _, sector := range sectors {
@@ -369,112 +361,17 @@ func MigrateSectors(ctx context.Context, maddr address.Address, mmeta datastore.
 			fmt.Printf("Migration: %d / %d (%0.2f%%)\n", i, len(sectors), float64(i)/float64(len(sectors))*100)
 		}
 
-		_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
-			// Insert sector metadata
-			_, err := tx.Exec(`
-        INSERT INTO sectors_meta (sp_id, sector_num, reg_seal_proof, ticket_epoch, ticket_value,
-                                  orig_sealed_cid, orig_unsealed_cid, cur_sealed_cid, cur_unsealed_cid,
-                                  msg_cid_precommit, msg_cid_commit, msg_cid_update, seed_epoch, seed_value)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
-        ON CONFLICT (sp_id, sector_num) DO UPDATE
-        SET reg_seal_proof = excluded.reg_seal_proof, ticket_epoch = excluded.ticket_epoch, ticket_value = excluded.ticket_value,
-            orig_sealed_cid = excluded.orig_sealed_cid, orig_unsealed_cid = excluded.orig_unsealed_cid, cur_sealed_cid = excluded.cur_sealed_cid,
-            cur_unsealed_cid = excluded.cur_unsealed_cid, msg_cid_precommit = excluded.msg_cid_precommit, msg_cid_commit = excluded.msg_cid_commit,
-            msg_cid_update = excluded.msg_cid_update, seed_epoch = excluded.seed_epoch, seed_value = excluded.seed_value`,
-				mid,
-				sectr.SectorNumber,
-				sectr.SectorType,
-				sectr.TicketEpoch,
-				sectr.TicketValue,
-				cidPtrToStrptr(sectr.CommR),
-				cidPtrToStrptr(sectr.CommD),
-				cidPtrToStrptr(coalescePtrs(sectr.UpdateSealed, sectr.CommR)),
-				cidPtrToStrptr(coalescePtrs(sectr.UpdateUnsealed, sectr.CommD)),
-				cidPtrToStrptr(sectr.PreCommitMessage),
-				cidPtrToStrptr(sectr.CommitMessage),
-				cidPtrToStrptr(sectr.ReplicaUpdateMessage),
-				sectr.SeedEpoch,
-				sectr.SeedValue,
-			)
-			if err != nil {
+		meta, err := toSectorMeta(sectr)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+			if err := lmimport.InsertSectorMeta(tx, int64(mid), meta); err != nil {
 				b, _ := json.MarshalIndent(sectr, "", "  ")
 				fmt.Println(string(b))
 
-				return false, xerrors.Errorf("inserting/updating sectors_meta for sector %d: %w", sectr.SectorNumber, err)
-			}
-
-			// Process each piece within the sector
-			for j, piece := range sectr.Pieces {
-				dealID := int64(0)
-				startEpoch := int64(0)
-				endEpoch := int64(0)
-				var pamJSON *string
-				var dealProposalJSONStr *string
-
-				if piece.HasDealInfo() {
-					dealInfo := piece.DealInfo()
-					if dealInfo.Impl().DealProposal != nil {
-						dealID = int64(dealInfo.Impl().DealID)
-					}
-
-					startEpoch = int64(must.One(dealInfo.StartEpoch()))
-					endEpoch = int64(must.One(dealInfo.EndEpoch()))
-					if piece.Impl().PieceActivationManifest != nil {
-						pam, err := json.Marshal(piece.Impl().PieceActivationManifest)
-						if err != nil {
-							return false, xerrors.Errorf("error marshalling JSON for piece %d in sector %d: %w", j, sectr.SectorNumber, err)
-						}
-						ps := string(pam)
-						pamJSON = &ps
-					}
-					if piece.Impl().DealProposal != nil {
-						dealProposalJSON, err := json.Marshal(piece.Impl().DealProposal)
-						if err != nil {
-							return false, xerrors.Errorf("error marshalling deal proposal JSON for piece %d in sector %d: %w", j, sectr.SectorNumber, err)
-						}
-						dp := string(dealProposalJSON)
-						dealProposalJSONStr = &dp
-					}
-
-				}
-
-				// Splitting the SQL statement for readability and adding new fields
-				_, err = tx.Exec(`
-					INSERT INTO sectors_meta_pieces (
-						sp_id, sector_num, piece_num, piece_cid, piece_size, 
-						requested_keep_data, raw_data_size, start_epoch, orig_end_epoch,
-						f05_deal_id, ddo_pam, f05_deal_proposal
-					) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-					ON CONFLICT (sp_id, sector_num, piece_num) DO UPDATE
-					SET 
-						piece_cid = excluded.piece_cid, 
-						piece_size = excluded.piece_size, 
-						requested_keep_data = excluded.requested_keep_data, 
-						raw_data_size = excluded.raw_data_size,
-						start_epoch = excluded.start_epoch, 
-						orig_end_epoch = excluded.orig_end_epoch,
-						f05_deal_id = excluded.f05_deal_id, 
-						ddo_pam = excluded.ddo_pam,
-						f05_deal_proposal = excluded.f05_deal_proposal`,
-					mid,
-					sectr.SectorNumber,
-					j,
-					piece.PieceCID(),
-					piece.Piece().Size,
-					piece.HasDealInfo(),
-					nil, // raw_data_size might be calculated based on the piece size, or retrieved if available
-					startEpoch,
-					endEpoch,
-					dealID,
-					pamJSON,
-					dealProposalJSONStr,
-				)
-				if err != nil {
-					b, _ := json.MarshalIndent(sectr, "", "  ")
-					fmt.Println(string(b))
-
-					return false, xerrors.Errorf("inserting/updating sector_meta_pieces for sector %d, piece %d: %w", sectr.SectorNumber, j, err)
-				}
+				return false, err
 			}
 
 			return true, nil
@@ -487,6 +384,74 @@ func MigrateSectors(ctx context.Context, maddr address.Address, mmeta datastore.
 	return nil
 }
 
+func migratableState(state sector.SectorState) bool {
+	switch state {
+	case sector.Proving, sector.Available, sector.UpdateActivating, sector.Removed:
+		return true
+	default:
+		return false
+	}
+}
+
+// toSectorMeta converts a lotus-miner sector to its sectors_meta and sectors_meta_pieces rows
+func toSectorMeta(sectr SectorInfo) (lmimport.SectorMeta, error) {
+	meta := lmimport.SectorMeta{
+		SectorNum:       int64(sectr.SectorNumber),
+		RegSealProof:    int64(sectr.SectorType),
+		TicketEpoch:     int64(sectr.TicketEpoch),
+		TicketValue:     sectr.TicketValue,
+		OrigSealedCID:   cidPtrToStrptr(sectr.CommR),
+		OrigUnsealedCID: cidPtrToStrptr(sectr.CommD),
+		CurSealedCID:    cidPtrToStrptr(coalescePtrs(sectr.UpdateSealed, sectr.CommR)),
+		CurUnsealedCID:  cidPtrToStrptr(coalescePtrs(sectr.UpdateUnsealed, sectr.CommD)),
+		MsgCidPrecommit: cidPtrToStrptr(sectr.PreCommitMessage),
+		MsgCidCommit:    cidPtrToStrptr(sectr.CommitMessage),
+		MsgCidUpdate:    cidPtrToStrptr(sectr.ReplicaUpdateMessage),
+		SeedEpoch:       int64(sectr.SeedEpoch),
+		SeedValue:       sectr.SeedValue,
+	}
+
+	// Process each piece within the sector
+	for j, piece := range sectr.Pieces {
+		pm := lmimport.PieceMeta{
+			PieceNum:          int64(j),
+			PieceCID:          piece.PieceCID().String(),
+			PieceSize:         int64(piece.Piece().Size),
+			RequestedKeepData: piece.HasDealInfo(),
+		}
+
+		if piece.HasDealInfo() {
+			dealInfo := piece.DealInfo()
+			if dealInfo.Impl().DealProposal != nil {
+				pm.F05DealID = int64(dealInfo.Impl().DealID)
+			}
+
+			pm.StartEpoch = int64(must.One(dealInfo.StartEpoch()))
+			pm.OrigEndEpoch = int64(must.One(dealInfo.EndEpoch()))
+			if piece.Impl().PieceActivationManifest != nil {
+				pam, err := json.Marshal(piece.Impl().PieceActivationManifest)
+				if err != nil {
+					return lmimport.SectorMeta{}, xerrors.Errorf("error marshalling JSON for piece %d in sector %d: %w", j, sectr.SectorNumber, err)
+				}
+				ps := string(pam)
+				pm.DDOPam = &ps
+			}
+			if piece.Impl().DealProposal != nil {
+				dealProposalJSON, err := json.Marshal(piece.Impl().DealProposal)
+				if err != nil {
+					return lmimport.SectorMeta{}, xerrors.Errorf("error marshalling deal proposal JSON for piece %d in sector %d: %w", j, sectr.SectorNumber, err)
+				}
+				dp := string(dealProposalJSON)
+				pm.F05DealProposal = &dp
+			}
+		}
+
+		meta.Pieces = append(meta.Pieces, pm)
+	}
+
+	return meta, nil
+}
+
 type SectorInfo struct {
 	State        sector.SectorState
 	SectorNumber abi.SectorNumber
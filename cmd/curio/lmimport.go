@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/cmd/curio/guidedsetup"
+	"github.com/filecoin-project/curio/deps"
+	"github.com/filecoin-project/curio/lib/reqcontext"
+	"github.com/filecoin-project/curio/tasks/lmimport"
+
+	"github.com/filecoin-project/lotus/node/repo"
+)
+
+var sealLMImportCmd = &cli.Command{
+	Name:  "lm-import",
+	Usage: "Import the sectors and storage of a lotus-miner",
+	Description: `Stages the sector metadata and storage paths of a lotus-miner repo as an import. The cluster
+validates each sector against chain state and imports the sectors which match; once the storage
+paths are attached to Curio nodes with 'curio storage attach', the nodes scan them for the sector
+files. Imports survive restarts and can be inspected with 'lm-import status'.`,
+	Subcommands: []*cli.Command{
+		lmImportStartCmd,
+		lmImportListCmd,
+		lmImportStatusCmd,
+		lmImportRetryCmd,
+	},
+}
+
+var lmImportStartCmd = &cli.Command{
+	Name:  "start",
+	Usage: "Stage the sectors and storage paths of a lotus-miner repo for import",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "miner-repo",
+			Usage: "Path to miner repo",
+			Value: "~/.lotusminer",
+		},
+		&cli.BoolFlag{
+			Name:  "seal-ignore",
+			Usage: "Skip sectors still in the lotus-miner sealing pipeline instead of aborting",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := reqcontext.ReqContext(cctx)
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		r, err := repo.NewFS(cctx.String("miner-repo"))
+		if err != nil {
+			return err
+		}
+
+		ok, err := r.Exists()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("repo not initialized at: %s", cctx.String("miner-repo"))
+		}
+
+		lr, err := r.LockRO(guidedsetup.StorageMiner)
+		if err != nil {
+			return fmt.Errorf("locking repo: %w", err)
+		}
+		defer func() {
+			err = lr.Close()
+			if err != nil {
+				fmt.Println("error closing repo: ", err)
+			}
+		}()
+
+		host, err := os.Hostname()
+		if err != nil {
+			return xerrors.Errorf("getting hostname: %w", err)
+		}
+
+		summary, err := guidedsetup.StageLMImport(ctx, lr, cctx.String("miner-repo"), host, db, !cctx.Bool("seal-ignore"))
+		if len(summary.Unmigratable) > 0 {
+			fmt.Println("The following sector states are not migratable:")
+			for state, count := range summary.Unmigratable {
+				fmt.Printf("  %s: %d\n", state, count)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Staged import %d of %s: %d sectors, %d storage paths\n", summary.ImportID, summary.Miner, summary.Sectors, len(summary.StoragePaths))
+		for _, p := range summary.StoragePaths {
+			fmt.Printf("  %s %s (seal: %t, store: %t)\n", p.ID, p.Path, p.CanSeal, p.CanStore)
+		}
+		fmt.Println("Attach the storage paths to Curio nodes with 'curio storage attach' (without --init), and follow the import with:")
+		fmt.Printf("  curio seal lm-import status %d\n", summary.ImportID)
+		return nil
+	},
+}
+
+var lmImportListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List lotus-miner imports",
+	Action: func(cctx *cli.Context) error {
+		ctx := reqcontext.ReqContext(cctx)
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		imports, err := lmimport.Imports(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%-6s %-10s %-10s %-10s %-10s %-10s %-10s %-28s %-10s\n", "ID", "Miner", "Pending", "Imported", "Invalid", "Skipped", "Storage", "Created", "Complete")
+		for _, i := range imports {
+			maddr, err := address.NewIDAddress(uint64(i.SpID))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-6d %-10s %-10d %-10d %-10d %-10d %-10s %-28s %-10t\n",
+				i.ID, maddr, i.Pending, i.Imported, i.Invalid, i.Skipped,
+				fmt.Sprintf("%d/%d", i.StorageRedeclared, i.StoragePaths),
+				i.CreatedAt.Format(time.RFC3339), i.CompletedAt != nil)
+		}
+		return nil
+	},
+}
+
+var lmImportStatusCmd = &cli.Command{
+	Name:      "status",
+	Usage:     "Show the audit trail of a lotus-miner import",
+	ArgsUsage: "<import id>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "sectors",
+			Usage: "List sectors with this status: pending, imported, invalid, skipped or all",
+			Value: lmimport.StatusInvalid,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+		importID, err := strconv.ParseInt(cctx.Args().First(), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing import id: %w", err)
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		storage, err := lmimport.Storage(ctx, db, importID)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Storage paths:")
+		for _, s := range storage {
+			state := "not attached"
+			switch {
+			case s.Error != nil:
+				state = "scan failed: " + *s.Error
+			case s.RedeclaredAt != nil:
+				state = fmt.Sprintf("scanned by %s at %s", *s.RedeclaredBy, s.RedeclaredAt.Format(time.RFC3339))
+			case s.Attached:
+				state = "attached, waiting for scan"
+			}
+			fmt.Printf("  %s %s: %s\n", s.StorageID, s.Path, state)
+		}
+
+		status := cctx.String("sectors")
+		if status == "all" {
+			status = ""
+		}
+		sectors, err := lmimport.Sectors(ctx, db, importID, status)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Sectors (%s): %d\n", cctx.String("sectors"), len(sectors))
+		fmt.Printf("%-10s %-24s %-10s %-28s %s\n", "Sector", "LM State", "Status", "Validated", "Error")
+		for _, s := range sectors {
+			validated := ""
+			if s.ValidatedAt != nil {
+				validated = s.ValidatedAt.Format(time.RFC3339)
+			}
+			errStr := ""
+			if s.Error != nil {
+				errStr = *s.Error
+			}
+			fmt.Printf("%-10d %-24s %-10s %-28s %s\n", s.SectorNum, s.LMState, s.Status, validated, errStr)
+		}
+		return nil
+	},
+}
+
+var lmImportRetryCmd = &cli.Command{
+	Name:      "retry",
+	Usage:     "Validate the invalid sectors of an import again and rescan storage paths whose scan failed",
+	ArgsUsage: "<import id>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+		importID, err := strconv.ParseInt(cctx.Args().First(), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing import id: %w", err)
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		return lmimport.Retry(ctx, db, importID)
+	},
+}
@@ -69,6 +69,7 @@ func main() {
 		fetchParamCmd,
 		ffiCmd,
 		calcCmd,
+		offlineSignCmd,
 	}
 
 	jaeger := tracing.SetupJaegerTracing("curio")
@@ -2,9 +2,15 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"os"
 	"sort"
 	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/ipfs/go-cid"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/xerrors"
 
@@ -15,6 +21,7 @@ import (
 	"github.com/filecoin-project/curio/lib/reqcontext"
 	"github.com/filecoin-project/curio/market"
 	"github.com/filecoin-project/curio/market/lmrpc"
+	"github.com/filecoin-project/curio/tasks/piece"
 )
 
 var marketCmd = &cli.Command{
@@ -22,6 +29,8 @@ var marketCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		marketRPCInfoCmd,
 		marketSealCmd,
+		marketMirrorCmd,
+		marketCommPCmd,
 	},
 }
 
@@ -120,3 +129,270 @@ var marketSealCmd = &cli.Command{
 		return market.SealNow(ctx, dep.Chain, dep.DB, act, abi.SectorNumber(sector), cctx.Bool("synthetic"))
 	},
 }
+
+var marketMirrorCmd = &cli.Command{
+	Name:  "mirror",
+	Usage: "Manage mirror URLs of piece data",
+	Description: `Mirrors are alternative sources of the data of a piece, used when downloading from the data URL of the
+piece fails or returns data which doesn't match the piece CID.`,
+	Subcommands: []*cli.Command{
+		marketMirrorAddCmd,
+		marketMirrorRemoveCmd,
+		marketMirrorListCmd,
+	},
+}
+
+var marketMirrorAddCmd = &cli.Command{
+	Name:      "add",
+	Usage:     "Add a mirror URL to a piece",
+	ArgsUsage: "<piece cid> <url>",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "header",
+			Usage: "header to send with requests to the mirror, in 'Name: value' format",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 2 {
+			return xerrors.Errorf("expected 2 arguments")
+		}
+
+		pcid, err := cid.Parse(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("parsing piece cid: %w", err)
+		}
+
+		hdrs := http.Header{}
+		for _, h := range cctx.StringSlice("header") {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				return xerrors.Errorf("invalid header '%s', expected 'Name: value'", h)
+			}
+			hdrs.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		return piece.AddMirror(ctx, db, pcid, cctx.Args().Get(1), hdrs)
+	},
+}
+
+var marketMirrorRemoveCmd = &cli.Command{
+	Name:      "remove",
+	Usage:     "Remove a mirror URL of a piece",
+	ArgsUsage: "<piece cid> <url>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 2 {
+			return xerrors.Errorf("expected 2 arguments")
+		}
+
+		pcid, err := cid.Parse(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("parsing piece cid: %w", err)
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		return piece.RemoveMirror(ctx, db, pcid, cctx.Args().Get(1))
+	},
+}
+
+var marketMirrorListCmd = &cli.Command{
+	Name:      "list",
+	Usage:     "List mirror URLs of pieces",
+	ArgsUsage: "[piece cid]",
+	Action: func(cctx *cli.Context) error {
+		pcid := cid.Undef
+		if cctx.Args().Present() {
+			var err error
+			pcid, err = cid.Parse(cctx.Args().First())
+			if err != nil {
+				return xerrors.Errorf("parsing piece cid: %w", err)
+			}
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		mirrors, err := piece.ListMirrors(ctx, db, pcid)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "Piece CID\tIndex\tParked\tURL")
+		for _, m := range mirrors {
+			_, _ = fmt.Fprintf(w, "%s\t%d\t%t\t%s\n", m.PieceCID, m.MirrorIndex, m.Complete, m.DataURL)
+		}
+		return w.Flush()
+	},
+}
+
+var marketCommPCmd = &cli.Command{
+	Name:  "commp",
+	Usage: "Calculate piece commitments of data on the cluster",
+	Description: `Piece commitments are calculated by CommP tasks on nodes with Subsystems.EnableCommP set. Data at an URL
+is read by any such node, data in a storage path only by nodes the path is attached to. Results are
+cached by the sha256 of the data, requests with a known sha256 complete without reading the data again.`,
+	Subcommands: []*cli.Command{
+		marketCommPRequestCmd,
+		marketCommPStatusCmd,
+	},
+}
+
+var marketCommPRequestCmd = &cli.Command{
+	Name:      "request",
+	Usage:     "Request the piece commitment of some data",
+	ArgsUsage: "<url | storage-id:path>",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "header",
+			Usage: "header to send with requests to the URL, in 'Name: value' format",
+		},
+		&cli.Int64Flag{
+			Name:  "raw-size",
+			Usage: "expected size of the data in bytes",
+		},
+		&cli.StringFlag{
+			Name:  "sha256",
+			Usage: "expected hex encoded sha256 of the data",
+		},
+		&cli.BoolFlag{
+			Name:  "wait",
+			Usage: "wait for the piece commitment to be calculated",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+
+		src := piece.CommPSource{
+			RawSize:     cctx.Int64("raw-size"),
+			ContentHash: cctx.String("sha256"),
+		}
+
+		arg := cctx.Args().First()
+		if strings.Contains(arg, "://") {
+			src.DataURL = arg
+			src.DataHeaders = http.Header{}
+			for _, h := range cctx.StringSlice("header") {
+				name, value, ok := strings.Cut(h, ":")
+				if !ok {
+					return xerrors.Errorf("invalid header '%s', expected 'Name: value'", h)
+				}
+				src.DataHeaders.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+			}
+		} else {
+			id, file, ok := strings.Cut(arg, ":")
+			if !ok {
+				return xerrors.Errorf("expected an URL or storage-id:path")
+			}
+			src.StorageID, src.StorageFile = id, file
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		id, err := piece.RequestCommP(ctx, db, src)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Request %d\n", id)
+
+		if !cctx.Bool("wait") {
+			return nil
+		}
+
+		for {
+			req, err := piece.GetCommPRequest(ctx, db, id)
+			if err != nil {
+				return err
+			}
+			if req.CompleteTime != nil {
+				printCommPRequest(req)
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+		}
+	},
+}
+
+var marketCommPStatusCmd = &cli.Command{
+	Name:      "status",
+	Usage:     "Show the status of a piece commitment request",
+	ArgsUsage: "<request id>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+
+		id, err := strconv.ParseInt(cctx.Args().First(), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing request id: %w", err)
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		req, err := piece.GetCommPRequest(ctx, db, id)
+		if err != nil {
+			return err
+		}
+		printCommPRequest(req)
+		return nil
+	},
+}
+
+func printCommPRequest(req piece.CommPRequest) {
+	switch {
+	case req.DataURL != nil:
+		fmt.Printf("Data: %s\n", *req.DataURL)
+	case req.StorageID != nil:
+		fmt.Printf("Data: %s:%s\n", *req.StorageID, *req.StorageFile)
+	}
+	if req.RawSize != nil {
+		fmt.Printf("Raw Size: %d\n", *req.RawSize)
+	}
+	if req.ContentHash != nil {
+		fmt.Printf("SHA256: %s\n", *req.ContentHash)
+	}
+
+	switch {
+	case req.Error != nil:
+		fmt.Printf("Failed: %s\n", *req.Error)
+	case req.PieceCID != nil:
+		fmt.Printf("Piece CID: %s\n", *req.PieceCID)
+		fmt.Printf("Piece Size: %d\n", *req.PieceSize)
+		fmt.Printf("Cached: %t\n", req.Cached)
+	case req.TaskID != nil:
+		fmt.Printf("Running in task %d\n", *req.TaskID)
+		if req.TaskError != nil {
+			fmt.Printf("Last error: %s\n", *req.TaskError)
+		}
+	default:
+		fmt.Println("Pending")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/deps"
+	"github.com/filecoin-project/curio/lib/reqcontext"
+	"github.com/filecoin-project/curio/tasks/message"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var offlineSignCmd = &cli.Command{
+	Name:  "offline-sign",
+	Usage: "Sign messages from addresses in Apis.OfflineSignAddresses on an air-gapped machine",
+	Description: `Messages from offline signed addresses are queued until signed:
+  1. 'curio offline-sign export --output bundle.json' exports the queued messages, assigning their nonces
+  2. on the air-gapped machine, fill in the Signature of each message, e.g. with 'lotus wallet sign <From> <SigningBytes>'
+  3. 'curio offline-sign import bundle.json' checks and stores the signatures, the messages are then broadcast`,
+	Subcommands: []*cli.Command{
+		offlineSignExportCmd,
+		offlineSignImportCmd,
+	},
+}
+
+var offlineSignExportCmd = &cli.Command{
+	Name:  "export",
+	Usage: "Export messages awaiting offline signature",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "only export messages from this address",
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "file to write the bundle to, stdout if not set",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		from := address.Undef
+		if cctx.IsSet("from") {
+			var err error
+			from, err = address.NewFromString(cctx.String("from"))
+			if err != nil {
+				return xerrors.Errorf("parsing --from: %w", err)
+			}
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		dep, err := deps.GetDepsCLI(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		bundle, err := message.ExportOfflineMessages(ctx, dep.DB, dep.Chain, from)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(bundle, "", "  ")
+		if err != nil {
+			return xerrors.Errorf("marshaling bundle: %w", err)
+		}
+
+		if !cctx.IsSet("output") {
+			fmt.Println(string(data))
+			return nil
+		}
+		if err := os.WriteFile(cctx.String("output"), data, 0600); err != nil {
+			return xerrors.Errorf("writing bundle: %w", err)
+		}
+
+		for _, m := range bundle.Messages {
+			fmt.Printf("task %d: %s nonce %d -> %s, value %s (%s)\n", m.SendTaskID, m.Message.From, m.Message.Nonce,
+				m.Message.To, types.FIL(m.Message.Value).Short(), m.Reason)
+		}
+		fmt.Printf("Exported %d messages to %s\n", len(bundle.Messages), cctx.String("output"))
+		return nil
+	},
+}
+
+var offlineSignImportCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "Import signatures of messages signed offline",
+	ArgsUsage: "<bundle file>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return cli.ShowCommandHelp(cctx, "import")
+		}
+
+		data, err := os.ReadFile(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("reading bundle: %w", err)
+		}
+		var bundle message.OfflineBundle
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return xerrors.Errorf("parsing bundle: %w", err)
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		n, err := message.ImportOfflineSignatures(ctx, db, &bundle)
+		fmt.Printf("Imported %d signatures\n", n)
+		return err
+	},
+}
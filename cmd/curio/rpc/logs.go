@@ -0,0 +1,192 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/filecoin-project/go-jsonrpc/auth"
+
+	lapi "github.com/filecoin-project/lotus/api"
+)
+
+// logStreamHandler tails this node's logs as server-sent events. Each event
+// carries one JSON encoded log entry.
+//
+// Query parameters:
+//   - level: minimum level (default info)
+//   - subsystem: comma-separated list of logger names, empty for all
+//
+// While the stream is open the selected subsystems log at least at the
+// requested level, so their more verbose entries also reach the node's
+// regular log output. Without a subsystem selection logger levels are left
+// alone, and entries below each logger's own level are not streamed.
+func logStreamHandler(permissioned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if permissioned && !auth.HasPerm(r.Context(), lapi.DefaultPerms, lapi.PermAdmin) {
+			w.WriteHeader(401)
+			_ = json.NewEncoder(w).Encode(struct{ Error string }{"unauthorized: missing admin permission"})
+			return
+		}
+
+		streamLogs(w, r)
+	}
+}
+
+func streamLogs(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	level := logging.LevelInfo
+	if l := r.URL.Query().Get("level"); l != "" {
+		var err error
+		level, err = logging.LevelFromString(l)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	subsystems := map[string]struct{}{}
+	for _, s := range strings.Split(r.URL.Query().Get("subsystem"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			subsystems[s] = struct{}{}
+		}
+	}
+
+	// only existing loggers, logging.Logger would register unknown names
+	for _, s := range logging.GetSubsystems() {
+		if _, ok := subsystems[s]; !ok {
+			continue
+		}
+		raiseLogLevel(s, level)
+		defer lowerLogLevel(s, level)
+	}
+
+	pr := logging.NewPipeReader(logging.PipeFormat(logging.JSONOutput), logging.PipeLevel(level))
+	go func() {
+		<-r.Context().Done()
+		_ = pr.Close()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		if len(subsystems) > 0 {
+			var entry struct {
+				Logger string `json:"logger"`
+			}
+			if err := json.Unmarshal(line, &entry); err != nil {
+				continue
+			}
+			if _, ok := subsystems[entry.Logger]; !ok {
+				continue
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", line); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// streamLevels tracks the levels requested by open log streams, per subsystem,
+// so that a subsystem returns to its own level once the last stream ends.
+var streamLevels = struct {
+	sync.Mutex
+	subs map[string]*streamLevel
+}{subs: map[string]*streamLevel{}}
+
+type streamLevel struct {
+	orig      logging.LogLevel // level before the first stream raised it
+	set       logging.LogLevel // level last set by a stream
+	requested []logging.LogLevel
+}
+
+func raiseLogLevel(subsystem string, level logging.LogLevel) {
+	streamLevels.Lock()
+	defer streamLevels.Unlock()
+
+	sl, ok := streamLevels.subs[subsystem]
+	if !ok {
+		sl = &streamLevel{orig: currentLogLevel(subsystem)}
+		sl.set = sl.orig
+		streamLevels.subs[subsystem] = sl
+	}
+	sl.requested = append(sl.requested, level)
+	sl.apply(subsystem)
+}
+
+func lowerLogLevel(subsystem string, level logging.LogLevel) {
+	streamLevels.Lock()
+	defer streamLevels.Unlock()
+
+	sl, ok := streamLevels.subs[subsystem]
+	if !ok {
+		return
+	}
+	for i, l := range sl.requested {
+		if l == level {
+			sl.requested = append(sl.requested[:i], sl.requested[i+1:]...)
+			break
+		}
+	}
+	sl.apply(subsystem)
+	if len(sl.requested) == 0 {
+		delete(streamLevels.subs, subsystem)
+	}
+}
+
+// apply sets the subsystem to the most verbose of its own level and the levels
+// requested by open streams. A level changed by someone else in the meantime
+// (e.g. LogSetLevel) is kept.
+func (sl *streamLevel) apply(subsystem string) {
+	if currentLogLevel(subsystem) != sl.set {
+		sl.orig = currentLogLevel(subsystem)
+	}
+
+	want := sl.orig
+	for _, l := range sl.requested {
+		if l < want {
+			want = l
+		}
+	}
+	if want == sl.set && want == currentLogLevel(subsystem) {
+		return
+	}
+
+	if err := logging.SetLogLevel(subsystem, zapcore.Level(want).String()); err != nil {
+		return
+	}
+	sl.set = want
+}
+
+// currentLogLevel returns the most verbose level the subsystem logs at
+func currentLogLevel(subsystem string) logging.LogLevel {
+	core := logging.Logger(subsystem).Desugar().Core()
+	for l := zapcore.DebugLevel; l < zapcore.FatalLevel; l++ {
+		if core.Enabled(l) {
+			return logging.LogLevel(l)
+		}
+	}
+	return logging.LogLevel(zapcore.FatalLevel)
+}
@@ -30,10 +30,13 @@ import (
 	"github.com/filecoin-project/curio/api/client"
 	"github.com/filecoin-project/curio/build"
 	"github.com/filecoin-project/curio/deps"
+	"github.com/filecoin-project/curio/lib/ffi"
 	"github.com/filecoin-project/curio/lib/metrics"
 	"github.com/filecoin-project/curio/lib/paths"
 	"github.com/filecoin-project/curio/lib/repo"
 	storiface "github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/market"
+	"github.com/filecoin-project/curio/market/retrieval"
 	"github.com/filecoin-project/curio/web"
 
 	lapi "github.com/filecoin-project/lotus/api"
@@ -53,6 +56,7 @@ var permissioned = os.Getenv("LOTUS_DISABLE_AUTH_PERMISSIONED") != "1"
 func CurioHandler(
 	authv func(ctx context.Context, token string) ([]auth.Permission, error),
 	remote http.HandlerFunc,
+	piecePush http.HandlerFunc,
 	a api.Curio,
 	permissioned bool) http.Handler {
 	mux := mux.NewRouter()
@@ -73,6 +77,10 @@ func CurioHandler(
 	mux.Handle("/rpc/streams/v0/push/{uuid}", readerHandler)
 	mux.PathPrefix("/remote").HandlerFunc(remote)
 	mux.Handle("/debug/metrics", metrics.Exporter())
+	mux.HandleFunc("/debug/logs", logStreamHandler(permissioned))
+	if piecePush != nil {
+		mux.Handle("/market/piece/{cid}", piecePush)
+	}
 	mux.PathPrefix("/").Handler(http.DefaultServeMux) // pprof
 
 	if !permissioned {
@@ -89,7 +97,9 @@ func CurioHandler(
 type CurioAPI struct {
 	*deps.Deps
 	paths.SectorIndex
-	ShutdownChan chan struct{}
+
+	// TriggerShutdown starts the node shutdown, it is safe to call more than once
+	TriggerShutdown func()
 }
 
 func (p *CurioAPI) Version(context.Context) ([]int, error) {
@@ -120,28 +130,12 @@ func (p *CurioAPI) StorageDetachLocal(ctx context.Context, path string) error {
 		return xerrors.Errorf("no local paths match '%s'", path)
 	}
 
-	// drop from the persisted storage.json
-	var found bool
-	if err := p.LocalPaths.SetStorage(func(sc *storiface.StorageConfig) {
-		out := make([]storiface.LocalPath, 0, len(sc.StoragePaths))
-		for _, storagePath := range sc.StoragePaths {
-			if storagePath.Path != path {
-				out = append(out, storagePath)
-				continue
-			}
-			found = true
-		}
-		sc.StoragePaths = out
-	}); err != nil {
-		return xerrors.Errorf("set storage config: %w", err)
-	}
-	if !found {
-		// maybe this is fine?
-		return xerrors.Errorf("path not found in storage.json")
-	}
+	// drop from the persisted storage.json, unregister locally, drop from sector index
+	return p.LocalStore.DetachPath(ctx, localPath.ID)
+}
 
-	// unregister locally, drop from sector index
-	return p.LocalStore.ClosePath(ctx, localPath.ID)
+func (p *CurioAPI) StorageSetRestrictions(ctx context.Context, id storiface.ID, r storiface.PathRestrictions) error {
+	return p.LocalStore.SetPathRestrictions(ctx, id, r)
 }
 
 func (p *CurioAPI) StorageLocal(ctx context.Context) (map[storiface.ID]string, error) {
@@ -184,7 +178,7 @@ func (p *CurioAPI) AllocatePieceToSector(ctx context.Context, maddr address.Addr
 
 // Trigger shutdown
 func (p *CurioAPI) Shutdown(context.Context) error {
-	close(p.ShutdownChan)
+	p.TriggerShutdown()
 	return nil
 }
 
@@ -249,7 +243,7 @@ func (p *CurioAPI) LogSetLevel(ctx context.Context, subsystem, level string) err
 	return logging.SetLogLevel(subsystem, level)
 }
 
-func ListenAndServe(ctx context.Context, dependencies *deps.Deps, shutdownChan chan struct{}) error {
+func ListenAndServe(ctx context.Context, dependencies *deps.Deps, triggerShutdown func()) error {
 	fh := &paths.FetchHandler{Local: dependencies.LocalStore, PfHandler: &paths.DefaultPartialFileHandler{}}
 	remoteHandler := func(w http.ResponseWriter, r *http.Request) {
 		if !auth.HasPerm(r.Context(), nil, lapi.PermAdmin) {
@@ -276,12 +270,18 @@ func ListenAndServe(ctx context.Context, dependencies *deps.Deps, shutdownChan c
 			return payload.Allow, nil
 		}
 	}
+	var piecePush http.HandlerFunc
+	if dependencies.Cfg.Ingest.EnableDataPush {
+		piecePush = market.PiecePushHandler(dependencies.DB, ffi.NewSealCalls(dependencies.Stor, dependencies.LocalStore, dependencies.Si))
+	}
+
 	// Serve the RPC.
 	srv := &http.Server{
 		Handler: CurioHandler(
 			authVerify,
 			remoteHandler,
-			&CurioAPI{dependencies, dependencies.Si, shutdownChan},
+			piecePush,
+			&CurioAPI{dependencies, dependencies.Si, triggerShutdown},
 			permissioned),
 		ReadHeaderTimeout: time.Minute * 3,
 		BaseContext: func(listener net.Listener) context.Context {
@@ -320,6 +320,29 @@ func ListenAndServe(ctx context.Context, dependencies *deps.Deps, shutdownChan c
 		log.Infof("GUI:  http://%s", uiAddress)
 		eg.Go(web.ListenAndServe)
 	}
+
+	if rcfg := dependencies.Cfg.Retrieval; rcfg.ListenAddress != "" {
+		rs, err := retrieval.NewServer(dependencies.DB, ffi.NewSealCalls(dependencies.Stor, dependencies.LocalStore, dependencies.Si), rcfg)
+		if err != nil {
+			return xerrors.Errorf("setting up retrieval server: %w", err)
+		}
+
+		rsrv := &http.Server{
+			Handler:           rs.Handler(),
+			ReadHeaderTimeout: time.Minute,
+			Addr:              rcfg.ListenAddress,
+		}
+
+		go func() {
+			<-ctx.Done()
+			if err := rsrv.Shutdown(context.Background()); err != nil {
+				log.Errorf("shutting down retrieval server failed: %s", err)
+			}
+		}()
+
+		log.Infof("Setting up retrieval server at %s", rcfg.ListenAddress)
+		eg.Go(rsrv.ListenAndServe)
+	}
 	return eg.Wait()
 }
 
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
@@ -98,6 +99,8 @@ var runCmd = &cli.Command{
 
 		ctx := context.Background()
 		shutdownChan := make(chan struct{})
+		// both the Shutdown API and a restart requested by the cluster can trigger the shutdown
+		triggerShutdown := sync.OnceFunc(func() { close(shutdownChan) })
 		{
 			var ctxclose func()
 			ctx, ctxclose = context.WithCancel(ctx)
@@ -131,11 +134,20 @@ var runCmd = &cli.Command{
 		}
 		defer taskEngine.GracefullyTerminate()
 
+		go func() {
+			select {
+			case <-taskEngine.RestartRequested():
+				log.Warn("Restart requested through the cluster API, shutting down")
+				triggerShutdown()
+			case <-ctx.Done():
+			}
+		}()
+
 		if err := lmrpc.ServeCurioMarketRPCFromConfig(dependencies.DB, dependencies.Chain, dependencies.Cfg); err != nil {
 			return xerrors.Errorf("starting market RPCs: %w", err)
 		}
 
-		err = rpc.ListenAndServe(ctx, dependencies, shutdownChan) // Monitor for shutdown.
+		err = rpc.ListenAndServe(ctx, dependencies, triggerShutdown) // Monitor for shutdown.
 		if err != nil {
 			return err
 		}
@@ -31,6 +31,7 @@ var sealCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		sealStartCmd,
 		sealMigrateLMSectorsCmd,
+		sealLMImportCmd,
 		sealEventsCmd,
 	},
 }
@@ -36,6 +36,7 @@ stored while moving through the sealing pipeline (references as 'seal').`,
 	Subcommands: []*cli.Command{
 		storageAttachCmd,
 		storageDetachCmd,
+		storageSetCmd,
 		storageListCmd,
 		storageFindCmd,
 		/*storageDetachCmd,
@@ -67,6 +68,42 @@ Data for the sealing process will be stored here
 Store
 Finalized sectors that will be moved here for long term storage and be proven
 over time
+
+Tier
+Storage tier label of the path, e.g. 'hot' or 'cold'. Sealed sectors are moved
+between tiers according to the Storage.Tiering configuration
+
+QoS limits
+Read/write bandwidth and IOPS caps for sector fetches, moves and unsealed reads
+on this path. PoSt challenge reads are not limited. Limits can be changed in
+sectorstore.json and are reloaded when the path is redeclared
+
+Unsealed encryption
+Unsealed sector files are encrypted as they are written to the path with the
+key from the Storage.Encryption config, and decrypted transparently when read.
+Only storage paths can encrypt unsealed files, sealing uses them directly
+
+Shared filesystem
+Set for paths mounted by multiple machines (NFS, CephFS). Sector files in the
+path are written and removed only under cluster-wide leases, so machines sharing
+the path never work on the same files at once
+
+Content
+Restricts the path to kinds of content: 'unsealed', 'sealed' (sealed and cache
+files), 'update' (snap-deal update and update cache files) and 'piece' (parked
+pieces). Restrictions can be changed later with 'storage set'
+
+Archive
+Set for read-only archive paths, e.g. a tape gateway or a snapshotted volume.
+Sector files in the path are read for proving and retrievals, but nothing is
+allocated in, moved out of, or removed from the path, including by garbage
+collection. Archive paths must be storage paths. On read-only mounts create
+sectorstore.json before mounting, and attach without --init
+
+Read IO
+Direct IO and readahead size for reading sector files from the path, overriding
+the Storage.ReadIO config for the path. Bypassing the page cache helps proving
+from large HDD-backed paths
    `,
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
@@ -98,6 +135,46 @@ over time
 			Name:  "allow-to",
 			Usage: "path groups allowed to pull data from this path (allow all if not specified)",
 		},
+		&cli.StringFlag{
+			Name:  "tier",
+			Usage: "(for init) storage tier label, e.g. hot or cold",
+		},
+		&cli.StringFlag{
+			Name:  "max-read-bandwidth",
+			Usage: "(for init) limit read bandwidth per second, e.g. 200MiB",
+		},
+		&cli.StringFlag{
+			Name:  "max-write-bandwidth",
+			Usage: "(for init) limit write bandwidth per second, e.g. 200MiB",
+		},
+		&cli.Uint64Flag{
+			Name:  "max-iops",
+			Usage: "(for init) limit read and write operations per second",
+		},
+		&cli.BoolFlag{
+			Name:  "encrypt-unsealed",
+			Usage: "(for init) encrypt unsealed sector files at rest, requires Storage.Encryption keys, storage paths only",
+		},
+		&cli.BoolFlag{
+			Name:  "shared-fs",
+			Usage: "(for init) the path is on a filesystem mounted by multiple machines",
+		},
+		&cli.BoolFlag{
+			Name:  "archive",
+			Usage: "(for init) the path is a read-only archive, which is never written to",
+		},
+		&cli.StringSliceFlag{
+			Name:  "content",
+			Usage: "(for init) only allow these kinds of content in the path: unsealed, sealed, update, piece",
+		},
+		&cli.BoolFlag{
+			Name:  "direct-io",
+			Usage: "(for init) read sector files bypassing the page cache, overrides Storage.ReadIO",
+		},
+		&cli.StringFlag{
+			Name:  "readahead",
+			Usage: "(for init) read ahead size for sector file reads, e.g. 4MiB, 0 disables readahead; overrides Storage.ReadIO",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		minerApi, closer, err := rpc.GetCurioAPI(cctx)
@@ -126,20 +203,70 @@ over time
 				}
 			}
 
+			var maxRead, maxWrite int64
+			if cctx.IsSet("max-read-bandwidth") {
+				maxRead, err = units.RAMInBytes(cctx.String("max-read-bandwidth"))
+				if err != nil {
+					return xerrors.Errorf("parsing max-read-bandwidth: %w", err)
+				}
+			}
+			if cctx.IsSet("max-write-bandwidth") {
+				maxWrite, err = units.RAMInBytes(cctx.String("max-write-bandwidth"))
+				if err != nil {
+					return xerrors.Errorf("parsing max-write-bandwidth: %w", err)
+				}
+			}
+
 			cfg := storiface.LocalStorageMeta{
-				ID:         storiface.ID(uuid.New().String()),
-				Weight:     cctx.Uint64("weight"),
-				CanSeal:    cctx.Bool("seal"),
-				CanStore:   cctx.Bool("store"),
-				MaxStorage: uint64(maxStor),
-				Groups:     cctx.StringSlice("groups"),
-				AllowTo:    cctx.StringSlice("allow-to"),
+				ID:                storiface.ID(uuid.New().String()),
+				Weight:            cctx.Uint64("weight"),
+				CanSeal:           cctx.Bool("seal"),
+				CanStore:          cctx.Bool("store"),
+				MaxStorage:        uint64(maxStor),
+				Groups:            cctx.StringSlice("groups"),
+				AllowTo:           cctx.StringSlice("allow-to"),
+				Tier:              cctx.String("tier"),
+				MaxReadBandwidth:  uint64(maxRead),
+				MaxWriteBandwidth: uint64(maxWrite),
+				MaxIOPS:           cctx.Uint64("max-iops"),
+				EncryptUnsealed:   cctx.Bool("encrypt-unsealed"),
+				SharedFS:          cctx.Bool("shared-fs"),
+				Archive:           cctx.Bool("archive"),
 			}
 
 			if !(cfg.CanStore || cfg.CanSeal) {
 				return xerrors.Errorf("must specify at least one of --store or --seal")
 			}
 
+			if cfg.Archive && (cfg.CanSeal || !cfg.CanStore) {
+				return xerrors.Errorf("archive paths must be storage paths, use --store without --seal")
+			}
+
+			if cfg.EncryptUnsealed && cfg.CanSeal {
+				return xerrors.Errorf("sealing paths can't encrypt unsealed files, use --encrypt-unsealed without --seal")
+			}
+
+			if cctx.IsSet("content") {
+				cfg.AllowTypes, err = storiface.ContentKindTypes(cctx.StringSlice("content"))
+				if err != nil {
+					return err
+				}
+			}
+
+			if cctx.IsSet("direct-io") || cctx.IsSet("readahead") {
+				cfg.ReadIO = &storiface.PathReadIO{DirectIO: cctx.Bool("direct-io")}
+				if cctx.IsSet("readahead") {
+					ra, err := units.RAMInBytes(cctx.String("readahead"))
+					if err != nil {
+						return xerrors.Errorf("parsing readahead: %w", err)
+					}
+					if ra == 0 {
+						ra = -1
+					}
+					cfg.ReadIO.Readahead = ra
+				}
+			}
+
 			if err := minerApi.StorageInit(ctx, p, cfg); err != nil {
 				return xerrors.Errorf("init storage: %w", err)
 			}
@@ -183,6 +310,115 @@ var storageDetachCmd = &cli.Command{
 	},
 }
 
+var storageSetCmd = &cli.Command{
+	Name:      "set",
+	Usage:     "set what a local storage path can be used for",
+	ArgsUsage: "[path]",
+	Description: `Updates the restrictions in the sectorstore.json of a local storage path, and
+redeclares it so that they apply to new allocations on all nodes. Only the
+given flags are changed. Passing an empty value, e.g. --allow-types '', clears
+a list. Data already in the path is not moved.`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "seal",
+			Usage: "use path for sealing",
+		},
+		&cli.BoolFlag{
+			Name:  "store",
+			Usage: "use path for long-term storage",
+		},
+		&cli.StringSliceFlag{
+			Name:  "content",
+			Usage: "only allow these kinds of content in the path: unsealed, sealed, update, piece; replaces --allow-types",
+		},
+		&cli.StringSliceFlag{
+			Name:  "allow-types",
+			Usage: "sector file types allowed in the path",
+		},
+		&cli.StringSliceFlag{
+			Name:  "deny-types",
+			Usage: "sector file types denied in the path",
+		},
+		&cli.StringSliceFlag{
+			Name:  "allow-miners",
+			Usage: "miner IDs allowed to store data in the path",
+		},
+		&cli.StringSliceFlag{
+			Name:  "deny-miners",
+			Usage: "miner IDs denied to store data in the path",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		minerApi, closer, err := rpc.GetCurioAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := reqcontext.ReqContext(cctx)
+
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("incorrect number of arguments, got %d", cctx.NArg())
+		}
+
+		p, err := homedir.Expand(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("expanding path: %w", err)
+		}
+
+		local, err := minerApi.StorageLocal(ctx)
+		if err != nil {
+			return err
+		}
+
+		var id storiface.ID
+		for lid, lp := range local {
+			if lp == p {
+				id = lid
+				break
+			}
+		}
+		if id == "" {
+			return xerrors.Errorf("no local paths match '%s'", p)
+		}
+
+		list := func(name string) []string {
+			if !cctx.IsSet(name) {
+				return nil
+			}
+			out := []string{}
+			for _, v := range cctx.StringSlice(name) {
+				if v != "" {
+					out = append(out, v)
+				}
+			}
+			return out
+		}
+
+		r := storiface.PathRestrictions{
+			AllowTypes:  list("allow-types"),
+			DenyTypes:   list("deny-types"),
+			AllowMiners: list("allow-miners"),
+			DenyMiners:  list("deny-miners"),
+		}
+		if cctx.IsSet("seal") {
+			v := cctx.Bool("seal")
+			r.CanSeal = &v
+		}
+		if cctx.IsSet("store") {
+			v := cctx.Bool("store")
+			r.CanStore = &v
+		}
+		if cctx.IsSet("content") {
+			r.AllowTypes, err = storiface.ContentKindTypes(list("content"))
+			if err != nil {
+				return err
+			}
+		}
+
+		return minerApi.StorageSetRestrictions(ctx, id, r)
+	},
+}
+
 var storageListCmd = &cli.Command{
 	Name:  "list",
 	Usage: "list local storage paths",
@@ -357,6 +593,9 @@ var storageListCmd = &cli.Command{
 			if len(si.AllowTo) > 0 {
 				fmt.Printf("\tAllowTo: %s\n", strings.Join(si.AllowTo, ", "))
 			}
+			if si.Tier != "" {
+				fmt.Printf("\tTier: %s\n", si.Tier)
+			}
 
 			if len(si.AllowTypes) > 0 || len(si.DenyTypes) > 0 {
 				denied := storiface.FTAll.SubAllowed(si.AllowTypes, si.DenyTypes)
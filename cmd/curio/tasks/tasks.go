@@ -3,6 +3,7 @@ package tasks
 
 import (
 	"context"
+	"encoding/json"
 	"os"
 	"sort"
 	"strings"
@@ -29,19 +30,37 @@ import (
 	"github.com/filecoin-project/curio/lib/curiochain"
 	"github.com/filecoin-project/curio/lib/fastparamfetch"
 	"github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/lib/hugepageutil"
 	"github.com/filecoin-project/curio/lib/multictladdr"
 	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/remotesign"
 	"github.com/filecoin-project/curio/lib/slotmgr"
 	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/market/mk12"
+	"github.com/filecoin-project/curio/tasks/actorevents"
+	"github.com/filecoin-project/curio/tasks/ddo"
+	"github.com/filecoin-project/curio/tasks/dealvalidate"
+	"github.com/filecoin-project/curio/tasks/evacuation"
 	"github.com/filecoin-project/curio/tasks/f3"
 	"github.com/filecoin-project/curio/tasks/gc"
+	"github.com/filecoin-project/curio/tasks/indexing"
+	"github.com/filecoin-project/curio/tasks/ingestqueue"
+	"github.com/filecoin-project/curio/tasks/inventory"
+	"github.com/filecoin-project/curio/tasks/lmimport"
 	"github.com/filecoin-project/curio/tasks/message"
 	"github.com/filecoin-project/curio/tasks/metadata"
+	mk12tasks "github.com/filecoin-project/curio/tasks/mk12"
 	piece2 "github.com/filecoin-project/curio/tasks/piece"
+	"github.com/filecoin-project/curio/tasks/pledge"
+	"github.com/filecoin-project/curio/tasks/repair"
+	"github.com/filecoin-project/curio/tasks/rollup"
 	"github.com/filecoin-project/curio/tasks/scrub"
 	"github.com/filecoin-project/curio/tasks/seal"
 	"github.com/filecoin-project/curio/tasks/sealsupra"
+	"github.com/filecoin-project/curio/tasks/sectorops"
 	"github.com/filecoin-project/curio/tasks/snap"
+	"github.com/filecoin-project/curio/tasks/storagehealth"
+	"github.com/filecoin-project/curio/tasks/tiering"
 	"github.com/filecoin-project/curio/tasks/unseal"
 	window2 "github.com/filecoin-project/curio/tasks/window"
 	"github.com/filecoin-project/curio/tasks/winning"
@@ -94,9 +113,71 @@ func StartTasks(ctx context.Context, dependencies *deps.Deps) (*harmonytask.Task
 	machine := dependencies.ListenAddr
 	var activeTasks []harmonytask.TaskInterface
 
-	sender, sendTask := message.NewSender(full, full, db)
+	// fail on bad synthetic PoRep rules at startup rather than when sectors are created
+	if _, err := seal.NewSynthPolicy(cfg); err != nil {
+		return nil, err
+	}
+
+	var signer message.SignerAPI = full
+	if len(cfg.Apis.RemoteSigners) > 0 {
+		rs, closeSigners, err := remotesign.New(ctx, full, full, cfg.Apis.RemoteSigners)
+		if err != nil {
+			return nil, xerrors.Errorf("setting up remote signers: %w", err)
+		}
+		go func() {
+			<-ctx.Done()
+			closeSigners()
+		}()
+		signer = rs
+	}
+
+	sender, sendTask := message.NewSender(full, signer, db)
 	activeTasks = append(activeTasks, sendTask)
 
+	offlineSigners, err := message.NewOfflineSigners(ctx, full, cfg.Apis.OfflineSignAddresses)
+	if err != nil {
+		return nil, xerrors.Errorf("setting up offline signing: %w", err)
+	}
+	sender.SetOfflineSigners(offlineSigners)
+
+	dryRun, err := message.NewDryRun(ctx, full, cfg.Subsystems.DryRunSends, cfg.Addresses)
+	if err != nil {
+		return nil, xerrors.Errorf("setting up dry-run: %w", err)
+	}
+	if dryRun != nil {
+		log.Warnw("dry-run enabled, messages will be captured instead of sent", "all", cfg.Subsystems.DryRunSends)
+	}
+	sender.SetDryRun(dryRun)
+
+	gasOracles, err := message.NewGasOracles(full, cfg.Fees.GasPricing)
+	if err != nil {
+		return nil, xerrors.Errorf("setting up gas pricing: %w", err)
+	}
+	sender.SetGasOracles(gasOracles)
+
+	sendWindows, err := message.NewSendWindows(full, cfg.Fees.SendWindows)
+	if err != nil {
+		return nil, xerrors.Errorf("setting up send windows: %w", err)
+	}
+	sender.SetSendWindows(sendWindows)
+
+	spendCaps, err := message.NewSpendCaps(db, cfg.Fees.SpendCaps)
+	if err != nil {
+		return nil, xerrors.Errorf("setting up spend caps: %w", err)
+	}
+	sender.SetSpendCaps(spendCaps, dependencies.Alert)
+
+	topUpTask, err := message.NewWalletTopUpTask(db, full, sender, cfg.Addresses)
+	if err != nil {
+		return nil, xerrors.Errorf("setting up wallet top-ups: %w", err)
+	}
+	if topUpTask != nil {
+		activeTasks = append(activeTasks, topUpTask)
+	}
+	if cfg.Fees.ReplaceStuck.After > 0 {
+		activeTasks = append(activeTasks, message.NewReplaceStuckTask(db, full, sender, cfg.Fees.ReplaceStuck))
+	}
+
 	chainSched := chainsched.New(full)
 
 	// paramfetch
@@ -167,8 +248,16 @@ func StartTasks(ctx context.Context, dependencies *deps.Deps) (*harmonytask.Task
 		}
 	}
 
+	// huge pages are reserved before the batch sealer allocates its buffers
+	memStatus := hugepageutil.Preflight(cfg.Seal.HugePages, cfg.Seal.HugePagesNUMANode, cfg.Seal.PC1NUMANode)
+	for _, w := range memStatus.Warnings {
+		log.Warnw("memory pre-flight check", "warning", w)
+	}
+
 	slrLazy := lazy.MakeLazy(func() (*ffi.SealCalls, error) {
-		return ffi.NewSealCalls(stor, lstor, si), nil
+		sc := ffi.NewSealCalls(stor, lstor, si)
+		sc.PinSDR(cfg.Seal.PC1NUMANode)
+		return sc, nil
 	})
 
 	{
@@ -178,8 +267,62 @@ func StartTasks(ctx context.Context, dependencies *deps.Deps) (*harmonytask.Task
 			if err != nil {
 				return nil, err
 			}
-			cleanupPieceTask := piece2.NewCleanupPieceTask(db, must.One(slrLazy.Val()), 0)
-			activeTasks = append(activeTasks, parkPieceTask, cleanupPieceTask)
+			cleanupPieceTask := piece2.NewCleanupPieceTask(db, must.One(slrLazy.Val()), 0,
+				time.Duration(cfg.Storage.PieceGC.GracePeriod), cfg.Storage.PieceGC.DryRun)
+			replicatePieceTask := piece2.NewReplicatePieceTask(db, stor, lstor, si, cfg.Storage.PieceReplication, cfg.Subsystems.ParkPieceMaxTasks)
+			activeTasks = append(activeTasks, parkPieceTask, cleanupPieceTask, replicatePieceTask)
+			if cfg.Storage.PieceReplication.Copies > 1 && cfg.Storage.PieceReplication.CheckInterval > 0 {
+				activeTasks = append(activeTasks, piece2.NewCheckReplicasTask(replicatePieceTask))
+			}
+		}
+
+		if cfg.Subsystems.EnableCommP {
+			activeTasks = append(activeTasks, piece2.NewCommPTask(db, lstor, cfg.Subsystems.CommPMaxTasks))
+		}
+
+		if cfg.Subsystems.EnableIndexPiece {
+			activeTasks = append(activeTasks, indexing.NewIndexPieceTask(db, must.One(slrLazy.Val()), cfg.Subsystems.IndexPieceMaxTasks))
+		}
+
+		if cfg.Subsystems.EnableDDOAllocations {
+			activeTasks = append(activeTasks, ddo.NewClaimTask(db, full, cfg), ddo.NewTrackTask(db, full))
+		}
+
+		if cfg.Subsystems.EnableIngestQueue {
+			activeTasks = append(activeTasks, ingestqueue.NewIngestQueueTask(db, full, cfg))
+		}
+
+		if cfg.Subsystems.EnableDealValidation {
+			activeTasks = append(activeTasks, dealvalidate.NewValidateTask(db, full, cfg.Subsystems.DealValidationMaxTasks))
+		}
+
+		if cfg.Ingest.Libp2p.Enable {
+			var miners []address.Address
+			for m := range maddrs {
+				miners = append(miners, address.Address(m))
+			}
+			srv, err := mk12.Start(ctx, db, full, cfg, miners, sender)
+			if err != nil {
+				return nil, xerrors.Errorf("starting libp2p deal listener: %w", err)
+			}
+
+			publishTask, err := mk12tasks.NewPublishTask(db, full, sender, as, cfg)
+			if err != nil {
+				return nil, err
+			}
+			addDealTask, err := mk12tasks.NewAddDealTask(db, full, cfg)
+			if err != nil {
+				return nil, err
+			}
+			activeTasks = append(activeTasks, publishTask, addDealTask)
+
+			if cfg.Ingest.Libp2p.Renewal.Enable {
+				renewParkTask, err := mk12tasks.NewRenewParkTask(db, full, must.One(slrLazy.Val()), cfg, cfg.Subsystems.ParkPieceMaxTasks)
+				if err != nil {
+					return nil, err
+				}
+				activeTasks = append(activeTasks, mk12tasks.NewRenewalTask(db, full, cfg, srv.Miners()), renewParkTask)
+			}
 		}
 	}
 
@@ -188,6 +331,7 @@ func StartTasks(ctx context.Context, dependencies *deps.Deps) (*harmonytask.Task
 		cfg.Subsystems.EnableSendPrecommitMsg ||
 		cfg.Subsystems.EnablePoRepProof ||
 		cfg.Subsystems.EnableMoveStorage ||
+		cfg.Seal.LongHaul.Enable ||
 		cfg.Subsystems.EnableSendCommitMsg ||
 		cfg.Subsystems.EnableBatchSeal ||
 		cfg.Subsystems.EnableUpdateEncode ||
@@ -200,11 +344,70 @@ func StartTasks(ctx context.Context, dependencies *deps.Deps) (*harmonytask.Task
 			return nil, err
 		}
 		activeTasks = append(activeTasks, sealingTasks...)
+
+		// Sealing nodes also execute batch sector operations, extensions and terminations requested through the web API
+		batchOpTask := sectorops.NewBatchOpTask(db, stor, lstor, si)
+		extendTask := sectorops.NewExtendSectorsTask(db, full, bstore, sender, as)
+		terminateTask := sectorops.NewTerminateSectorsTask(db, full, bstore, sender, as)
+		activeTasks = append(activeTasks, batchOpTask, extendTask, terminateTask)
+	}
+
+	if cfg.Subsystems.EnableCCPledge {
+		activeTasks = append(activeTasks, pledge.NewPledgeTask(db, full, cfg))
+	}
+
+	if cfg.Subsystems.EnableAutoExtend {
+		activeTasks = append(activeTasks, sectorops.NewAutoExtendTask(db, full, bstore, cfg))
+	}
+
+	if cfg.Subsystems.EnableDealCleanup {
+		activeTasks = append(activeTasks, sectorops.NewDealCleanupTask(db, full, bstore, cfg))
 	}
 
 	amTask := alertmanager.NewAlertTask(full, db, cfg.Alerting, dependencies.Al)
 	activeTasks = append(activeTasks, amTask)
 
+	chartRollupTask := rollup.NewChartRollupTask(db, full)
+	activeTasks = append(activeTasks, chartRollupTask)
+
+	// lotus-miner imports staged with `curio seal lm-import`, the storage paths are scanned by the nodes they are attached to
+	activeTasks = append(activeTasks, lmimport.NewImportTask(db, full), lmimport.NewRedeclareTask(db, lstor, machine))
+
+	if cfg.Subsystems.EnableStorageTiering {
+		tierMoveTask := tiering.NewStorageTierMoveTask(db, full, stor, lstor, si, cfg.Storage.Tiering, cfg.Subsystems.StorageTieringMaxTasks)
+		activeTasks = append(activeTasks, tierMoveTask)
+	}
+
+	if cfg.Subsystems.EnableScrubSealed {
+		scrubSealedTask := scrub.NewScrubSealedTask(db, stor, lstor, si, dependencies.Alert, cfg.Storage.Scrub, cfg.Subsystems.ScrubSealedMaxTasks)
+		activeTasks = append(activeTasks, scrubSealedTask)
+	}
+
+	storagehealth.StartProber(ctx, db, stor, machine, cfg.Storage.HealthProbe)
+
+	if cfg.Subsystems.EnableSectorRepair {
+		repairTask := repair.NewRepairTask(db, stor, lstor, si, cfg.Subsystems.SectorRepairMaxTasks)
+		activeTasks = append(activeTasks, repairTask)
+	}
+
+	if cfg.Subsystems.EnableUnsealedRegen {
+		regenTask := unseal.NewUnsealedRegenTask(db, must.One(slrLazy.Val()), cfg.Storage.UnsealedRegen, cfg.Subsystems.UnsealedRegenMaxTasks)
+		activeTasks = append(activeTasks, regenTask)
+	}
+
+	evacuation.StartDetacher(ctx, db, lstor)
+	unseal.StartAccessRecorder(ctx, db, lstor)
+
+	if cfg.Subsystems.EnableStorageEvacuation {
+		evacuateTask := evacuation.NewStorageEvacuateTask(db, stor, lstor, si, cfg.Subsystems.StorageEvacuationMaxTasks)
+		activeTasks = append(activeTasks, evacuateTask)
+	}
+
+	if cfg.Subsystems.EnableStorageInventory {
+		inventoryTask := inventory.NewStorageInventoryTask(db, lstor, si, full, bstore, cfg.Storage.Inventory, cfg.Subsystems.StorageInventoryMaxTasks)
+		activeTasks = append(activeTasks, inventoryTask)
+	}
+
 	minerAddresses := make([]string, 0, len(maddrs))
 	for k := range maddrs {
 		minerAddresses = append(minerAddresses, address.Address(k).String())
@@ -222,17 +425,28 @@ func StartTasks(ctx context.Context, dependencies *deps.Deps) (*harmonytask.Task
 	if err != nil {
 		return nil, err
 	}
-	go machineDetails(dependencies, activeTasks, ht.ResourcesAvailable().MachineID, dependencies.Name)
+	go machineDetails(dependencies, activeTasks, ht.ResourcesAvailable().MachineID, dependencies.Name, memStatus)
 
 	if hasAnySealingTask {
-		watcher, err := message.NewMessageWatcher(db, ht, chainSched, full)
+		confidence, err := message.NewConfidences(cfg.Fees.MessageConfidence)
+		if err != nil {
+			return nil, xerrors.Errorf("setting up message confidence: %w", err)
+		}
+
+		watcher, err := message.NewMessageWatcher(db, ht, chainSched, full, confidence)
 		if err != nil {
 			return nil, err
 		}
 		_ = watcher
 	}
 
-	if cfg.Subsystems.EnableWindowPost || hasAnySealingTask {
+	if cfg.Subsystems.EnableActorEventIndex {
+		if _, err := actorevents.NewIndexer(db, full, chainSched, maddrs); err != nil {
+			return nil, xerrors.Errorf("setting up actor event indexer: %w", err)
+		}
+	}
+
+	if cfg.Subsystems.EnableWindowPost || hasAnySealingTask || cfg.Subsystems.EnableActorEventIndex {
 		go chainSched.Run(ctx)
 	}
 
@@ -250,7 +464,11 @@ func addSealingTasks(
 	var sp *seal.SealPoller
 	var slr *ffi.SealCalls
 	if hasAnySealingTask {
-		sp = seal.NewPoller(db, full)
+		var longHaulHost string
+		if cfg.Seal.LongHaul.Enable {
+			longHaulHost = machineHostPort
+		}
+		sp = seal.NewPoller(db, full, cfg.Batching, longHaulHost)
 		go sp.RunPoller(ctx)
 
 		slr = must.One(slrLazy.Val())
@@ -274,6 +492,10 @@ func addSealingTasks(
 			cfg.Seal.BatchSealPipelines,
 			!cfg.Seal.SingleHasherPerThread,
 			cfg.Seal.LayerNVMEDevices,
+			sealsupra.SupraSealTuning{
+				NUMANode: cfg.Seal.BatchSealNUMANode,
+				MaxCores: cfg.Seal.BatchSealMaxCores,
+			},
 			machineHostPort, slotMgr, db, full, stor, si)
 		if err != nil {
 			return nil, xerrors.Errorf("setting up batch sealer: %w", err)
@@ -291,19 +513,30 @@ func addSealingTasks(
 		activeTasks = append(activeTasks, sdrTask, keyTask)
 	}
 	if cfg.Subsystems.EnableSealSDRTrees {
-		treeDTask := seal.NewTreeDTask(sp, db, slr, cfg.Subsystems.SealSDRTreesMaxTasks)
-		treeRCTask := seal.NewTreeRCTask(sp, db, slr, cfg.Subsystems.SealSDRTreesMaxTasks)
-		synthTask := seal.NewSyntheticProofTask(sp, db, slr, cfg.Subsystems.SyntheticPoRepMaxTasks)
-		activeTasks = append(activeTasks, treeDTask, synthTask, treeRCTask)
+		if !cfg.Subsystems.NoTreeD {
+			treeDTask := seal.NewTreeDTask(sp, db, slr, cfg.Subsystems.SealSDRTreesMaxTasks, cfg.Seal.SplitTrees)
+			activeTasks = append(activeTasks, treeDTask)
+		}
+		if !cfg.Subsystems.NoTreeRC {
+			treeRCTask := seal.NewTreeRCTask(sp, db, slr, cfg.Subsystems.SealSDRTreesMaxTasks, cfg.Seal.SplitTrees)
+			synthTask := seal.NewSyntheticProofTask(sp, db, slr, cfg.Subsystems.SyntheticPoRepMaxTasks)
+			activeTasks = append(activeTasks, synthTask, treeRCTask)
+		}
 		addFinalize = true
 	}
 	if addFinalize {
-		finalizeTask := seal.NewFinalizeTask(cfg.Subsystems.FinalizeMaxTasks, sp, slr, db, slotMgr)
-		activeTasks = append(activeTasks, finalizeTask)
+		purgePolicy, err := seal.ParseCachePurge(cfg.Seal.CachePurge)
+		if err != nil {
+			return nil, err
+		}
+
+		finalizeTask := seal.NewFinalizeTask(cfg.Subsystems.FinalizeMaxTasks, sp, slr, db, slotMgr, purgePolicy)
+		purgeCacheTask := seal.NewPurgeCacheTask(slr, db, cfg.Subsystems.FinalizeMaxTasks)
+		activeTasks = append(activeTasks, finalizeTask, purgeCacheTask)
 	}
 
 	if cfg.Subsystems.EnableSendPrecommitMsg {
-		precommitTask := seal.NewSubmitPrecommitTask(sp, db, full, sender, as, cfg.Fees.MaxPreCommitGasFee, cfg.Fees.CollateralFromMinerBalance, cfg.Fees.DisableCollateralFallback)
+		precommitTask := seal.NewSubmitPrecommitTask(sp, db, full, sender, as, cfg.Fees.MaxPreCommitGasFee, cfg.Fees.MaxPreCommitBatchGasFee, cfg.Fees.CollateralFromMinerBalance, cfg.Fees.DisableCollateralFallback)
 		activeTasks = append(activeTasks, precommitTask)
 	}
 	if cfg.Subsystems.EnablePoRepProof {
@@ -317,8 +550,16 @@ func addSealingTasks(
 
 		if !cfg.Subsystems.NoUnsealedDecode {
 			unsealTask := unseal.NewTaskUnsealDecode(slr, db, cfg.Subsystems.MoveStorageMaxTasks, full)
-			activeTasks = append(activeTasks, unsealTask)
+			unsealRangeTask := unseal.NewTaskUnsealRange(slr, db, cfg.Subsystems.MoveStorageMaxTasks)
+			activeTasks = append(activeTasks, unsealTask, unsealRangeTask)
+		}
+	}
+	if cfg.Seal.LongHaul.Enable {
+		longHaulUploadTask, err := seal.NewLongHaulUploadTask(sp, slr, db, cfg.Seal.LongHaul)
+		if err != nil {
+			return nil, xerrors.Errorf("setting up long-haul upload: %w", err)
 		}
+		activeTasks = append(activeTasks, longHaulUploadTask)
 	}
 	if cfg.Subsystems.EnableSendCommitMsg {
 		commitTask := seal.NewSubmitCommitTask(sp, db, full, sender, as, cfg)
@@ -348,13 +589,18 @@ func addSealingTasks(
 
 		sectorMetadataTask := metadata.NewSectorMetadataTask(db, bstore, full)
 
-		activeTasks = append(activeTasks, storageEndpointGcTask, pipelineGcTask, storageGcMarkTask, storageGcSweepTask, sectorMetadataTask)
+		unsealedCacheEvictTask, err := unseal.NewUnsealedCacheEvictTask(db, cfg.Storage.UnsealedCache)
+		if err != nil {
+			return nil, err
+		}
+
+		activeTasks = append(activeTasks, storageEndpointGcTask, pipelineGcTask, storageGcMarkTask, storageGcSweepTask, sectorMetadataTask, unsealedCacheEvictTask)
 	}
 
 	return activeTasks, nil
 }
 
-func machineDetails(deps *deps.Deps, activeTasks []harmonytask.TaskInterface, machineID int, machineName string) {
+func machineDetails(deps *deps.Deps, activeTasks []harmonytask.TaskInterface, machineID int, machineName string, memStatus hugepageutil.MemoryStatus) {
 	taskNames := lo.Map(activeTasks, func(item harmonytask.TaskInterface, _ int) string {
 		return item.TypeDetails().Name
 	})
@@ -364,11 +610,17 @@ func machineDetails(deps *deps.Deps, activeTasks []harmonytask.TaskInterface, ma
 	})
 	sort.Strings(miners)
 
-	_, err := deps.DB.Exec(context.Background(), `INSERT INTO harmony_machine_details 
-		(tasks, layers, startup_time, miners, machine_id, machine_name) VALUES ($1, $2, $3, $4, $5, $6)
-		ON CONFLICT (machine_id) DO UPDATE SET tasks=$1, layers=$2, startup_time=$3, miners=$4, machine_id=$5, machine_name=$6`,
+	memJson, err := json.Marshal(memStatus)
+	if err != nil {
+		log.Errorf("failed to marshal memory status: %s", err)
+		return
+	}
+
+	_, err = deps.DB.Exec(context.Background(), `INSERT INTO harmony_machine_details 
+		(tasks, layers, startup_time, miners, machine_id, machine_name, memory_status) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (machine_id) DO UPDATE SET tasks=$1, layers=$2, startup_time=$3, miners=$4, machine_id=$5, machine_name=$6, memory_status=$7`,
 		strings.Join(taskNames, ","), strings.Join(deps.Layers, ","),
-		time.Now(), strings.Join(miners, ","), machineID, machineName)
+		time.Now(), strings.Join(miners, ","), machineID, machineName, memJson)
 
 	if err != nil {
 		log.Errorf("failed to update machine details: %s", err)
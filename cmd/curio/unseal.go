@@ -17,12 +17,14 @@ import (
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
 
 	"github.com/filecoin-project/curio/deps"
 	"github.com/filecoin-project/curio/lib/dealdata"
 	"github.com/filecoin-project/curio/lib/paths"
 	"github.com/filecoin-project/curio/lib/reqcontext"
 	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/unseal"
 )
 
 var unsealCmd = &cli.Command{
@@ -32,6 +34,8 @@ var unsealCmd = &cli.Command{
 		unsealInfoCmd,
 		listUnsealPipelineCmd,
 		setTargetUnsealStateCmd,
+		unsealRangeCmd,
+		unsealRegenCmd,
 		unsealCheckCmd,
 	},
 }
@@ -397,12 +401,15 @@ var setTargetUnsealStateCmd = &cli.Command{
 	Description: `Set the target unseal state for a specific sector.
    <miner-id>: The storage provider ID
    <sector-number>: The sector number
-   <target-state>: The target state (true, false, or none)
+   <target-state>: The target state (true, false, none or cache)
 
    The unseal target state indicates to curio how an unsealed copy of the sector should be maintained.
 	   If the target state is true, curio will ensure that the sector is unsealed.
 	   If the target state is false, curio will ensure that there is no unsealed copy of the sector.
 	   If the target state is none, curio will not change the current state of the sector.
+	   If the target state is cache, curio will unseal the sector and keep the unsealed copy in the unsealed cache,
+	   where it is removed once the cache is over the Storage.UnsealedCache.Budget and the copy is among the least
+	   recently read ones. Sectors which already have the target state true are left as they are.
 
    Currently when the curio will only start new unseal processes when the target state changes from another state to true.
 
@@ -438,10 +445,10 @@ var setTargetUnsealStateCmd = &cli.Command{
 		case "false":
 			falseVal := false
 			targetState = &falseVal
-		case "none":
+		case "none", "cache":
 			targetState = nil
 		default:
-			return xerrors.Errorf("invalid target-state: must be true, false, or none")
+			return xerrors.Errorf("invalid target-state: must be true, false, none or cache")
 		}
 
 		ctx := reqcontext.ReqContext(cctx)
@@ -450,6 +457,27 @@ var setTargetUnsealStateCmd = &cli.Command{
 			return err
 		}
 
+		sid := abi.SectorID{Miner: abi.ActorID(spID), Number: abi.SectorNumber(sectorNum)}
+
+		if targetStateStr == "cache" {
+			added, err := unseal.CacheSector(ctx, dep.DB, sid)
+			if err != nil {
+				return xerrors.Errorf("failed to add sector to unsealed cache: %w", err)
+			}
+			if !added {
+				fmt.Printf("SP %d, sector %d is already kept unsealed\n", spID, sectorNum)
+				return nil
+			}
+
+			fmt.Printf("Successfully added SP %d, sector %d to the unsealed cache\n", spID, sectorNum)
+			return nil
+		}
+
+		// a target state set by hand takes the sector out of the unsealed cache
+		if err := unseal.Uncache(ctx, dep.DB, sid); err != nil {
+			return err
+		}
+
 		_, err = dep.DB.Exec(ctx, `
 			UPDATE sectors_meta
 			SET target_unseal_state = $1
@@ -464,6 +492,128 @@ var setTargetUnsealStateCmd = &cli.Command{
 	},
 }
 
+var unsealRangeCmd = &cli.Command{
+	Name:      "range",
+	Usage:     "Unseal a range of sector data on demand",
+	ArgsUsage: "<miner-id> <sector-number> <offset> <length>",
+	Description: `Request an on-demand unseal of a range of a sector, like retrievals of data without an unsealed copy do.
+   <offset>, <length>: The range of the sector data, in unpadded bytes, e.g. the offset and size of a piece
+
+   The range is decoded into the unsealed file of the sector by the UnsealRange task, which is kept in the unsealed
+   cache afterwards.
+`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "wait",
+			Usage: "wait for the range to be unsealed",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 4 {
+			return cli.ShowSubcommandHelp(cctx)
+		}
+
+		sp, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("invalid storage provider address: %w", err)
+		}
+
+		spID, err := address.IDFromAddress(sp)
+		if err != nil {
+			return xerrors.Errorf("failed to get storage provider id: %w", err)
+		}
+
+		sectorNum, err := strconv.ParseUint(cctx.Args().Get(1), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("invalid sector-number: %w", err)
+		}
+
+		offset, err := strconv.ParseUint(cctx.Args().Get(2), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("invalid offset: %w", err)
+		}
+		uoffset := storiface.UnpaddedByteIndex(offset)
+		if err := uoffset.Valid(); err != nil {
+			return xerrors.Errorf("invalid offset: %w", err)
+		}
+
+		length, err := strconv.ParseUint(cctx.Args().Get(3), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("invalid length: %w", err)
+		}
+		ulength := abi.UnpaddedPieceSize(length)
+		if err := ulength.Validate(); err != nil {
+			return xerrors.Errorf("invalid length: %w", err)
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		dep, err := deps.GetDepsCLI(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		sid := abi.SectorID{Miner: abi.ActorID(spID), Number: abi.SectorNumber(sectorNum)}
+		if err := unseal.RequestRange(ctx, dep.DB, sid, uoffset.Padded(), ulength.Padded()); err != nil {
+			return err
+		}
+		fmt.Printf("Requested unseal of SP %d, sector %d, range %d+%d\n", spID, sectorNum, offset, length)
+
+		if !cctx.Bool("wait") {
+			return nil
+		}
+		if err := unseal.WaitRange(ctx, dep.DB, sid, uoffset.Padded(), ulength.Padded()); err != nil {
+			return err
+		}
+		fmt.Println("Range unsealed")
+		return nil
+	},
+}
+
+var unsealRegenCmd = &cli.Command{
+	Name:      "regen",
+	Usage:     "Regenerate a missing unsealed copy of a sector",
+	ArgsUsage: "<miner-id> <sector-number>",
+	Description: `Request the unsealed copy of a sector to be rebuilt if there is none in the storage index.
+   The copy is written from the parked piece data of the sector when all of its pieces are still parked,
+   otherwise the sector is unsealed from the sealed data. Requests are executed by nodes with
+   Subsystems.EnableUnsealedRegen set.
+`,
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 2 {
+			return cli.ShowSubcommandHelp(cctx)
+		}
+
+		sp, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("invalid storage provider address: %w", err)
+		}
+
+		spID, err := address.IDFromAddress(sp)
+		if err != nil {
+			return xerrors.Errorf("failed to get storage provider id: %w", err)
+		}
+
+		sectorNum, err := strconv.ParseUint(cctx.Args().Get(1), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("invalid sector-number: %w", err)
+		}
+
+		ctx := reqcontext.ReqContext(cctx)
+		dep, err := deps.GetDepsCLI(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		sid := abi.SectorID{Miner: abi.ActorID(spID), Number: abi.SectorNumber(sectorNum)}
+		if err := unseal.ScheduleRegen(ctx, dep.DB, sid, unseal.RegenByManual, "requested from the cli"); err != nil {
+			return err
+		}
+
+		fmt.Printf("Requested regeneration of the unsealed copy of SP %d, sector %d\n", spID, sectorNum)
+		return nil
+	},
+}
+
 func formatNullableInt64(v *int64) string {
 	if v == nil {
 		return ""
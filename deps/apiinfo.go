@@ -7,7 +7,9 @@ import (
 	"math/rand"
 	"net/http"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
@@ -26,7 +28,9 @@ import (
 
 var clog = logging.Logger("curio/chain")
 
-func GetFullNodeAPIV1Curio(ctx *cli.Context, ainfoCfg []string) (api.Chain, jsonrpc.ClientCloser, error) {
+// GetFullNodeAPIV1Curio connects to the configured chain nodes. Calls fail over between healthy nodes, and with
+// balanceReads, read calls are spread across them.
+func GetFullNodeAPIV1Curio(ctx *cli.Context, ainfoCfg []string, balanceReads bool) (api.Chain, jsonrpc.ClientCloser, error) {
 	if tn, ok := ctx.App.Metadata["testnode-full"]; ok {
 		return tn.(api.Chain), func() {}, nil
 	}
@@ -74,7 +78,7 @@ func GetFullNodeAPIV1Curio(ctx *cli.Context, ainfoCfg []string) (api.Chain, json
 	}
 
 	var v1API api.ChainStruct
-	FullNodeProxy(fullNodes, &v1API)
+	FullNodeProxy(fullNodes, &v1API, balanceReads)
 
 	return &v1API, finalCloser, nil
 }
@@ -114,9 +118,24 @@ var errorsToRetry = []error{&jsonrpc.RPCConnectionError{}, &jsonrpc.ErrClient{}}
 
 const preferredAllBad = -1
 
-// FullNodeProxy creates a proxy for the Chain API
-func FullNodeProxy[T api.Chain](ins []T, outstr *api.ChainStruct) {
+// pinnedMethodPrefixes are prefixes of methods which always go to the preferred node when reads are balanced, as
+// their results depend on node local state (mpool nonces, wallet keys) or on earlier calls to the same node.
+var pinnedMethodPrefixes = []string{"Mpool", "Wallet", "Sync", "Miner", "Auth", "ChainNotify", "Shutdown", "Session"}
+
+func isPinnedMethod(name string) bool {
+	for _, p := range pinnedMethodPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// FullNodeProxy creates a proxy for the Chain API. Calls go to the first healthy node, and fail over to the next
+// healthy one. With balanceReads, calls which don't depend on node local state are spread across healthy nodes.
+func FullNodeProxy[T api.Chain](ins []T, outstr *api.ChainStruct, balanceReads bool) {
 	providerCount := len(ins)
+	var nextRead atomic.Uint64
 
 	var healthyLk sync.Mutex
 	unhealthyProviders := make([]bool, providerCount)
@@ -217,6 +236,8 @@ func FullNodeProxy[T api.Chain](ins []T, outstr *api.ChainStruct) {
 				providerFuncs = append(providerFuncs, mv)
 			}
 
+			balanced := balanceReads && providerCount > 1 && !isPinnedMethod(field.Name)
+
 			rOutStruct.Field(f).Set(reflect.MakeFunc(field.Type, func(args []reflect.Value) (results []reflect.Value) {
 				starWatchOnce.Do(func() {
 					go startWatch()
@@ -224,8 +245,13 @@ func FullNodeProxy[T api.Chain](ins []T, outstr *api.ChainStruct) {
 
 				ctx := args[0].Interface().(context.Context)
 
+				start := 0
+				if balanced {
+					start = int(nextRead.Add(1) % uint64(providerCount))
+				}
+
 				preferredProvider := new(int)
-				*preferredProvider = nextHealthyProvider(0)
+				*preferredProvider = nextHealthyProvider(start)
 				if *preferredProvider == preferredAllBad {
 					// select at random, retry will do it's best
 					*preferredProvider = rand.Intn(providerCount)
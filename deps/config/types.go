@@ -29,10 +29,14 @@ func DefaultCurioConfig() *CurioConfig {
 			},
 
 			MaxTerminateGasFee:         types.MustParseFIL("0.5"),
+			MaxExtendGasFee:            types.MustParseFIL("0.5"),
 			MaxWindowPoStGasFee:        types.MustParseFIL("5"),
 			MaxPublishDealsFee:         types.MustParseFIL("0.05"),
 			CollateralFromMinerBalance: false,
 			DisableCollateralFallback:  false,
+			ReplaceStuck: MessageReplaceConfig{
+				After: Duration(10 * time.Minute),
+			},
 		},
 		Addresses: []CurioAddresses{{
 			PreCommitControl: []string{},
@@ -49,6 +53,29 @@ func DefaultCurioConfig() *CurioConfig {
 			BatchSealPipelines:  2,
 			BatchSealBatchSize:  32,
 			BatchSealSectorSize: "32GiB",
+			HugePagesNUMANode:   -1,
+			PC1NUMANode:         -1,
+			CachePurge: CachePurgeConfig{
+				Layers:   "finalize",
+				Trees:    "finalize",
+				Unsealed: "finalize",
+			},
+			LongHaul: LongHaulConfig{
+				MaxParallelUploads: 1,
+				ChunkSize:          "256MiB",
+			},
+		},
+		Batching: CurioBatchingConfig{
+			PreCommit: BatchingConfig{
+				MaxSectors:       1,
+				MaxWait:          Duration(1 * time.Hour),
+				BaseFeeThreshold: types.MustParseFIL("0.00000000032"),
+			},
+			Commit: BatchingConfig{
+				MaxSectors:       1,
+				MaxWait:          Duration(1 * time.Hour),
+				BaseFeeThreshold: types.MustParseFIL("0.00000000015"),
+			},
 		},
 		Ingest: CurioIngestConfig{
 			MaxQueueDealSector: 8, // default to 8 sectors open(or in process of opening) for deals
@@ -60,9 +87,80 @@ func DefaultCurioConfig() *CurioConfig {
 			MaxQueueSnapProve:  0,
 
 			MaxDealWaitTime: Duration(1 * time.Hour),
+
+			SnapSectorSelection: SnapSectorSelectionConfig{
+				Policy:                 "expiration",
+				ExpirationBuffer:       Duration(48 * time.Hour),
+				PreferredStorageGroups: []string{},
+			},
+			DealFilter: DealFilterConfig{
+				MinPricePerEpochPerGiB:         types.MustParseFIL("0"),
+				MinVerifiedPricePerEpochPerGiB: types.MustParseFIL("0"),
+				ClientAllowList:                []string{},
+				ClientDenyList:                 []string{},
+				Timeout:                        Duration(30 * time.Second),
+			},
+			Libp2p: Libp2pDealsConfig{
+				ListenAddresses:       []string{"/ip4/0.0.0.0/tcp/24001"},
+				AnnounceAddresses:     []string{},
+				Miners:                []string{},
+				MinStartDelay:         Duration(8 * time.Hour),
+				MaxDealsPerPublishMsg: 8,
+				PublishMsgPeriod:      Duration(1 * time.Hour),
+				Renewal: DealRenewalConfig{
+					Window:          Duration(30 * 24 * time.Hour),
+					StartDelay:      Duration(3 * 24 * time.Hour),
+					MaxOffersPerRun: 1000,
+				},
+			},
+		},
+		Storage: CurioStorageConfig{
+			Placement: StoragePlacementConfig{
+				Strategy: "available",
+			},
+			Tiering: StorageTieringConfig{
+				HotTier:         "hot",
+				ColdTier:        "cold",
+				MigrateAfter:    Duration(24 * time.Hour),
+				PinDeadlines:    2,
+				MaxPendingMoves: 16,
+			},
+			Scrub: StorageScrubConfig{
+				SampleInterval:   Duration(30 * 24 * time.Hour),
+				FullReadInterval: 0,
+				MaxPendingChecks: 8,
+				AutoRepair:       true,
+			},
+			PieceGC: StoragePieceGCConfig{
+				GracePeriod: Duration(1 * time.Hour),
+			},
+			PieceReplication: StoragePieceReplicationConfig{
+				Copies:           1,
+				DistinctMachines: true,
+				CheckInterval:    Duration(10 * time.Minute),
+			},
+			HealthProbe: StorageHealthProbeConfig{
+				Interval:      Duration(5 * time.Minute),
+				SlowThreshold: Duration(5 * time.Second),
+			},
+			Inventory: StorageInventoryConfig{
+				Interval:    Duration(24 * time.Hour),
+				GracePeriod: Duration(1 * time.Hour),
+			},
+			UnsealedCache: StorageUnsealedCacheConfig{
+				EvictInterval: Duration(10 * time.Minute),
+			},
+			UnsealedRegen: StorageUnsealedRegenConfig{
+				ScanInterval: Duration(time.Hour),
+				MaxPerScan:   100,
+			},
+			RemovalHook: StorageRemovalHookConfig{
+				Timeout: Duration(30 * time.Minute),
+			},
 		},
 		Alerting: CurioAlertingConfig{
 			MinimumWalletBalance: types.MustParseFIL("5"),
+			StorageFullDays:      14,
 			PagerDuty: PagerDutyConfig{
 				PagerDutyEventURL: "https://events.pagerduty.com/v2/enqueue",
 			},
@@ -82,9 +180,13 @@ type CurioConfig struct {
 	Addresses []CurioAddresses
 	Proving   CurioProvingConfig
 	Ingest    CurioIngestConfig
+	Retrieval CurioRetrievalConfig
 	Seal      CurioSealConfig
+	Batching  CurioBatchingConfig
 	Apis      ApisConfig
 	Alerting  CurioAlertingConfig
+	Web       CurioWebConfig
+	Storage   CurioStorageConfig
 }
 
 func DefaultDefaultMaxFee() types.FIL {
@@ -123,6 +225,13 @@ type CurioSubsystemsConfig struct {
 	EnableParkPiece   bool
 	ParkPieceMaxTasks int
 
+	// EnableIndexPiece enables the block indexing task on this node. The task reads parked pieces holding CAR files
+	// and records the location of each block in the piece, so that retrievals of blocks by CID are served with range
+	// reads of parked pieces or unsealed sector data, instead of reading whole pieces or unsealing whole sectors.
+	// Pieces are indexed while they are parked, so it should be enabled on nodes with EnableParkPiece.
+	EnableIndexPiece   bool
+	IndexPieceMaxTasks int
+
 	// EnableSealSDR enables SDR tasks to run. SDR is the long sequential computation
 	// creating 11 layer files in sector cache directory.
 	//
@@ -170,6 +279,14 @@ type CurioSubsystemsConfig struct {
 	// also be bounded by resources available on the machine.
 	SealSDRTreesMaxTasks int
 
+	// NoTreeD keeps this node from running the TreeD task when EnableSealSDRTrees is set. With Seal.SplitTrees,
+	// set it on GPU nodes which should only run TreeRC.
+	NoTreeD bool
+
+	// NoTreeRC keeps this node from running the TreeRC and SyntheticProofs tasks when EnableSealSDRTrees is set.
+	// With Seal.SplitTrees, set it on nodes without a GPU which should only run TreeD, e.g. SDR nodes.
+	NoTreeRC bool
+
 	// FinalizeMaxTasks is the maximum amount of finalize tasks that can run simultaneously.
 	// The finalize task is enabled on all machines which also handle SDRTrees tasks. Finalize ALWAYS runs on whichever
 	// machine holds sector cache files, as it removes unneeded tree data after PoRep is computed.
@@ -240,9 +357,14 @@ type CurioSubsystemsConfig struct {
 	UpdateProveMaxTasks int
 
 	// EnableCommP enabled the commP task on te node. CommP is calculated before sending PublishDealMessage for a Mk12
-	// deal, and when checking sector data with 'curio unseal check'.
+	// deal, and when checking sector data with 'curio unseal check'. The node also calculates the piece commitment of
+	// data requested with 'curio market commp', reading data at URLs and data in storage paths attached to it.
 	EnableCommP bool
 
+	// The maximum number of piece commitment calculations requested with 'curio market commp' that can run
+	// simultaneously on this node. Each calculation hashes in parallel on all CPUs.
+	CommPMaxTasks int
+
 	// BoostAdapters is a list of tuples of miner address and port/ip to listen for market (e.g. boost) requests.
 	// This interface is compatible with the lotus-miner RPC, implementing a subset needed for storage market operations.
 	// Strings should be in the format "actor:ip:port". IP cannot be 0.0.0.0. We recommend using a private IP.
@@ -270,15 +392,123 @@ type CurioSubsystemsConfig struct {
 	GuiAddress string
 
 	// UseSyntheticPoRep enables the synthetic PoRep for all new sectors. When set to true, will reduce the amount of
-	// cache data held on disk after the completion of TreeRC task to 11GiB.
+	// cache data held on disk after the completion of TreeRC task to 11GiB. Seal.SyntheticPoRep rules override it
+	// for some miners or sector sizes.
 	UseSyntheticPoRep bool
 
 	// The maximum amount of SyntheticPoRep tasks that can run simultaneously. Note that the maximum number of tasks will
 	// also be bounded by resources available on the machine.
 	SyntheticPoRepMaxTasks int
 
-	// Batch Seal
+	// EnableBatchSeal enables the SupraSeal batch sealer on this node, which runs SDR and tree building (PC1/PC2) for
+	// whole batches of CC sectors with NVMe layer storage. It's configured by the Seal section, and is meant to be
+	// enabled in a per-machine layer together with the machine's Seal settings, as it requires dedicated NVMe devices,
+	// 1GiB huge pages and most of the CPU cores of the machine. While a batch is sealing, the cores used by the batch
+	// sealer are not available to other tasks on the node.
 	EnableBatchSeal bool
+
+	// EnableStorageTiering enables migration of sealed sectors between storage tiers on this node, following the
+	// Storage.Tiering policy. Migrations run on nodes with a local storage path in the destination tier, and any
+	// node with tiering enabled plans migrations for the whole cluster.
+	EnableStorageTiering bool
+
+	// The maximum number of storage tier migrations that can run simultaneously on this node.
+	StorageTieringMaxTasks int
+
+	// EnableScrubSealed enables background scrubbing of sealed sector data in storage paths attached to this node,
+	// following the Storage.Scrub policy. Corrupted sectors are reported through alerts.
+	EnableScrubSealed bool
+
+	// The maximum number of sealed data scrub checks that can run simultaneously on this node. Full reads are
+	// IO heavy, so this should be kept low on nodes which also serve PoSt or retrievals.
+	ScrubSealedMaxTasks int
+
+	// EnableSectorRepair enables repairs of damaged sector file copies held in storage paths attached to this node.
+	// Damaged copies are replaced from a good copy in another path, unsealed copies without one are regenerated
+	// from the sealed data by the unseal pipeline.
+	EnableSectorRepair bool
+
+	// The maximum number of sector repairs that can run simultaneously on this node.
+	SectorRepairMaxTasks int
+
+	// EnableUnsealedRegen enables regeneration of missing unsealed sector copies, requested by hand or found by
+	// the Storage.UnsealedRegen scan. Copies are rebuilt from parked piece data when it is still available, other
+	// sectors are queued in the unseal pipeline.
+	EnableUnsealedRegen bool
+
+	// The maximum number of unsealed copy regenerations that can run simultaneously on this node.
+	UnsealedRegenMaxTasks int
+
+	// EnableStorageEvacuation enables moving data out of storage paths which are being evacuated, into storage
+	// paths attached to this node. Evacuations are started from the web UI; the evacuated path is marked read-only,
+	// and detached from all nodes once it is empty.
+	EnableStorageEvacuation bool
+
+	// The maximum number of evacuation moves that can run simultaneously on this node.
+	StorageEvacuationMaxTasks int
+
+	// EnableStorageInventory enables checking the storage index against the files in storage paths attached to
+	// this node, and against sectors live on chain, following the Storage.Inventory policy. Results are shown in
+	// the web UI.
+	EnableStorageInventory bool
+
+	// The maximum number of storage inventory checks that can run simultaneously on this node.
+	StorageInventoryMaxTasks int
+
+	// EnableActorEventIndex enables indexing of chain events concerning the miner addresses in the config on this
+	// node: sector events of the miner actors, deal events of the market actor with the miners as the provider,
+	// and the outcome of each closed proving deadline. The index is read by the web UI and alerts. One or two
+	// nodes in the cluster are enough, the chain node must have actor events enabled (Events.EnableActorEventsAPI).
+	EnableActorEventIndex bool
+
+	// EnableDDOAllocations enables onboarding of verified allocations requested through the web API on this node.
+	// Once the piece of a requested allocation is parked, for example pushed by the client, it is added to a sector
+	// of the miner, and the allocation is followed on chain until it is claimed or expires. Allocations close to
+	// expiring before being claimed raise an alert.
+	EnableDDOAllocations bool
+
+	// EnableCCPledge enables the CC pledge scheduler on this node. For each miner with a pledge schedule, set in the
+	// web UI, it adds CC sectors to the sealing pipeline at the scheduled number of sectors per day, while the
+	// number of sectors in the pipeline is below the schedule's maximum. Pledging stops while the pipeline of the
+	// miner is paused, or when long-term storage or collateral funds wouldn't cover the sectors in the pipeline.
+	// One node in the cluster is enough.
+	EnableCCPledge bool
+
+	// EnableAutoExtend enables the automatic sector extension policies on this node. For each miner with a policy,
+	// set in the web UI, it periodically estimates the block reward of extending the sectors expiring within the
+	// policy window against the message fees, and queues an extension of the sectors worth extending. The messages
+	// are sent by the ExtendSectors task, which runs on sealing nodes. One node in the cluster is enough.
+	EnableAutoExtend bool
+
+	// EnableDealCleanup enables the deal cleanup policies on this node. For each miner with a policy, set in the web
+	// UI, it periodically finds the sectors whose deals all ended and, following the policy, reports them, keeps them
+	// from automatic extension so that they expire, or queues their termination with their files marked for removal.
+	// Terminations are sent by the TerminateSectors task, which runs on sealing nodes. One node in the cluster is
+	// enough.
+	EnableDealCleanup bool
+
+	// EnableIngestQueue enables the shared ingest queue on this node. Pieces added to the queue through the web API
+	// are assigned to one of the miners taking pieces from the queue, set in the web UI, which accepts the duration
+	// and region of the piece and has the most room in its sealing pipeline. Queued pieces are onboarded as direct
+	// data without a deal. The node also needs access to the piece data, like a market node. One node in the
+	// cluster is enough.
+	EnableIngestQueue bool
+
+	// EnableDealValidation enables validation of deal proposals submitted by market adapters with
+	// Ingest.OffloadDealValidation set on this node. Proposals are checked against chain state: the client address is
+	// resolved, published deals are matched against the deal on chain, the signature of unpublished proposals is
+	// verified, and verified deals need an allocation or the datacap of the client.
+	EnableDealValidation bool
+
+	// The maximum number of deal validation tasks that can run simultaneously on this node. Each task validates up
+	// to 100 proposals.
+	DealValidationMaxTasks int
+
+	// DryRunSends makes tasks on this node capture the messages they would send in the message_dry_runs table,
+	// for review in the web UI, instead of broadcasting them. Set it in the base layer to stop all sends of the
+	// cluster, e.g. on staging clusters or while rehearsing a migration. Tasks waiting for a captured message to
+	// land don't progress.
+	DryRunSends bool
 }
 type CurioFees struct {
 	DefaultMaxFee      types.FIL
@@ -290,6 +520,8 @@ type CurioFees struct {
 	MaxCommitBatchGasFee    BatchFeeConfig
 
 	MaxTerminateGasFee types.FIL
+	// Maximum fee for each ExtendSectorExpiration2 message sent for sector extensions requested in the web UI.
+	MaxExtendGasFee types.FIL
 	// WindowPoSt is a high-value operation, so the default fee should be high.
 	MaxWindowPoStGasFee types.FIL
 	MaxPublishDealsFee  types.FIL
@@ -297,6 +529,128 @@ type CurioFees struct {
 	CollateralFromMinerBalance bool
 	// Don't send collateral with messages even if there is no available balance in the miner actor
 	DisableCollateralFallback bool
+
+	// ReplaceStuck configures fee bump replacements of sent messages which are not included on chain.
+	ReplaceStuck MessageReplaceConfig
+
+	// GasPricing selects how gas fee caps and premiums are set for messages sent for given reasons. Messages
+	// sent for reasons without a strategy use the chain node estimate. The maximum fee of each message still
+	// applies.
+	GasPricing []GasPricingConfig
+
+	// SendWindows defer messages sent for non-urgent reasons until sending is cheap or allowed, e.g. sending
+	// precommits only while the base fee is low. Proving messages ('wdpost', 'declare-recoveries') can't be deferred.
+	// Deferred messages are queued and sent by the send task once the window opens, the task sending them doesn't wait.
+	SendWindows []SendWindowConfig
+
+	// SpendCaps limit the gas spent on messages sent for given reasons over the last day and week, counted from the
+	// gas paid by executed messages. When a cap is reached an alert is raised, and messages sent for those reasons
+	// are paused until the spend falls under the cap. Proving messages ('wdpost', 'declare-recoveries') are never
+	// paused, reaching their cap only alerts. Paused messages are queued, the task sending them doesn't wait.
+	SpendCaps []SpendCapConfig
+
+	// MessageConfidence sets how settled the execution of messages sent for given reasons must be before the tasks
+	// waiting for them act on it. Messages sent for other reasons wait for 6 epochs.
+	MessageConfidence []MessageConfidenceConfig
+}
+
+type MessageConfidenceConfig struct {
+	// Reasons are the send reasons the confidence applies to, e.g. 'precommit', 'commit', 'update', 'extend-sectors'.
+	Reasons []string
+
+	// Confidence is one of:
+	// 'included' - act as soon as the message is executed, even though the execution can still be reorged out
+	// '<N>' - act once the message was executed N epochs below the chain head
+	// 'finalized' - act once the execution is final, per F3 when the chain node runs it, otherwise after 900 epochs
+	Confidence string
+}
+
+type SpendCapConfig struct {
+	// Reasons are the send reasons whose spend is counted against the caps together, e.g. 'precommit', 'commit',
+	// 'update', 'wdpost'.
+	Reasons []string
+
+	// Daily caps the gas spent over the last 24 hours. 0 disables the daily cap.
+	Daily types.FIL
+
+	// Weekly caps the gas spent over the last 7 days. 0 disables the weekly cap.
+	Weekly types.FIL
+}
+
+type SendWindowConfig struct {
+	// Reasons are the send reasons deferred by the window, e.g. 'precommit', 'commit', 'update', 'extend-sectors'.
+	Reasons []string
+
+	// MaxBaseFee holds messages while the base fee (per gas unit) is above it. 0 ignores the base fee.
+	MaxBaseFee types.FIL
+
+	// BaseFeePercentile holds messages until the base fee dips to or below this percentile of the base fees over
+	// the last BaseFeeLookback epochs, e.g. 20 to send only during the cheapest fifth of recent epochs. Held messages
+	// are released together when the base fee dips. 0 disables it.
+	BaseFeePercentile int
+
+	// BaseFeeLookback is the number of recent epochs the base fee percentile is learned from, e.g. 2880 for a day.
+	BaseFeeLookback int
+
+	// Hours are UTC time ranges 'HH:MM-HH:MM' during which messages are sent, e.g. '22:00-06:00'. Empty allows
+	// sending at any time.
+	Hours []string
+
+	// MaxDelay is the longest a message is held, it is sent when the window doesn't open in time. Keep it well
+	// below the deadlines of the deferred messages, e.g. the precommit expiry for commits. Required.
+	MaxDelay Duration
+}
+
+type GasPricingConfig struct {
+	// Reasons are the send reasons the strategy applies to, e.g. 'wdpost', 'precommit', 'commit', 'update'.
+	// '*' matches all reasons without their own strategy.
+	Reasons []string
+
+	// Strategy is one of:
+	// 'estimate' - the chain node estimate, with the premium scaled by PremiumMultiplier
+	// 'base-fee-percentile' - fee cap of Percentile of base fees over the last Lookback epochs, scaled by
+	//   FeeCapMultiplier, plus the premium
+	// 'external' - fee cap and premium returned by an HTTP GET to URL, as JSON
+	//   {"GasFeeCap": "<attoFIL>", "GasPremium": "<attoFIL>"}; the premium is optional
+	// When a strategy fails, the chain node estimate is used.
+	Strategy string
+
+	// PremiumMultiplier scales the estimated gas premium, for the estimate and base-fee-percentile strategies.
+	// 0 keeps the estimate.
+	PremiumMultiplier float64
+
+	// Percentile of recent base fees, for the base-fee-percentile strategy.
+	Percentile int
+
+	// Lookback is the number of epochs of base fees considered, for the base-fee-percentile strategy.
+	Lookback int
+
+	// FeeCapMultiplier scales the base fee percentile, for the base-fee-percentile strategy. 0 keeps it as is.
+	FeeCapMultiplier float64
+
+	// URL of the gas price endpoint, for the external strategy. The from and to addresses of the message are
+	// passed as query parameters.
+	URL string
+}
+
+type MessageReplaceConfig struct {
+	// After is how long a sent message may stay pending before it is replaced with a message paying a higher
+	// gas premium. A replacement which is not included is replaced again after the same time. 0 disables
+	// replacements. (Default: 10m, 20 epochs)
+	After Duration
+
+	// ReasonMaxFees limits the fee (fee cap * gas limit) of replacements by the reason the message was sent for,
+	// e.g. 'wdpost', 'precommit', 'commit', 'update' or 'declare-recoveries'. Replacements of messages sent for
+	// other reasons are limited to the maximum fee of the message they replace.
+	ReasonMaxFees []ReasonMaxFee
+}
+
+type ReasonMaxFee struct {
+	// Reason is the send reason of messages the limit applies to.
+	Reason string
+
+	// MaxFee is the maximum fee of a replacement message.
+	MaxFee types.FIL
 }
 
 type CurioAddresses struct {
@@ -315,8 +669,53 @@ type CurioAddresses struct {
 	// over the worker address if this flag is set.
 	DisableWorkerFallback bool
 
+	// SenderSelection is how an address is picked among the control addresses of a role, before falling back to
+	// the worker and owner:
+	// 'first' - the first address, in configuration order, with enough funds for the message (default)
+	// 'balance' - the address with the most balance
+	// 'pending' - the address with the fewest messages queued or pending inclusion, then the most balance
+	SenderSelection string
+
+	// MinSenderBalance skips control addresses with a lower balance, unless all of them have a lower balance.
+	// 0 disables the minimum.
+	MinSenderBalance types.FIL
+
 	// MinerAddresses are the addresses of the miner actors to use for sending messages
 	MinerAddresses []string
+
+	// FundsMultisig is a multisig which funds PreCommit, Commit, snap update and sector extension messages of
+	// the miners. Instead of sending them from a control address, Curio proposes them to the multisig, which
+	// sends them once enough signers approve. The multisig must be set as a control address of the miners.
+	FundsMultisig string
+
+	// FundsMultisigProposer is a signer of FundsMultisig which proposes the messages. It only pays for gas.
+	FundsMultisigProposer string
+
+	// TopUp keeps the worker and control addresses of the miners funded from a source wallet.
+	TopUp WalletTopUpConfig
+
+	// DryRunSends captures messages sent for the miners, through FundsMultisig or from the TopUp source instead
+	// of broadcasting them, like Subsystems.DryRunSends for the whole cluster.
+	DryRunSends bool
+}
+
+type WalletTopUpConfig struct {
+	// Source is the wallet top-ups are sent from, it must be available to the cluster for signing. Empty disables
+	// top-ups.
+	Source string
+
+	// Below is the balance under which an address is topped up. Addresses with a low balance threshold set in
+	// the web UI use that threshold instead.
+	Below types.FIL
+
+	// Target is the balance an address is topped up to.
+	Target types.FIL
+
+	// MaxTransfer limits the value of a single top-up.
+	MaxTransfer types.FIL
+
+	// MaxDaily limits the value of all top-ups sent from Source over the last 24 hours.
+	MaxDaily types.FIL
 }
 
 type CurioProvingConfig struct {
@@ -419,13 +818,208 @@ type CurioIngestConfig struct {
 	// DoSnap enables the snap deal process for deals ingested by this instance. Unlike in lotus-miner there is no
 	// fallback to porep when no sectors are available to snap into. When enabled all deals will be snap deals.
 	DoSnap bool
+
+	// SnapSectorSelection configures how CC sectors are chosen for snap deal upgrades when deal data arrives
+	// and DoSnap is enabled.
+	SnapSectorSelection SnapSectorSelectionConfig
+
+	// EnableDataPush enables the /market/piece/<piece cid> endpoint of the Curio API on this node. Clients push
+	// deal data to it with HTTP PUT requests, authenticated with a Curio API token with write permission, instead
+	// of hosting the data at an URL. The data is checked against the piece CID while it is received, parked, and
+	// used by pending deals for the piece in place of their data URLs, and by deals for the piece made within
+	// 24 hours of the push. The node needs storage for parked pieces.
+	EnableDataPush bool
+
+	// OffloadDealValidation makes market adapters (BoostAdapters) on this node hand the validation of deal proposals
+	// to DealValidate tasks, which run on nodes with Subsystems.EnableDealValidation, instead of validating them
+	// themselves. This keeps the adapter responsive under bursts of proposals. Results are recorded per proposal
+	// either way.
+	OffloadDealValidation bool
+
+	// DealFilter decides which deals handed to Curio by market adapters (BoostAdapters) are accepted. Decisions are
+	// recorded per deal and shown in the web UI.
+	DealFilter DealFilterConfig
+
+	// ClientLimits keep a single client from flooding the ingest pipeline. The first rule matching the client of a
+	// deal accepted by a market adapter, or the API token adding a piece to the ingest queue, applies. Deals of
+	// clients matching no rule aren't limited. Deals over a limit are rejected and can be retried by the client
+	// later. Limits are counted across the cluster, concurrent deals of a client can go slightly over them.
+	ClientLimits []ClientIngestLimit
+
+	// Libp2p configures the storage deal protocol listener, which takes deal proposals from clients over libp2p
+	// like Boost does, so no separate market node is needed to receive deals.
+	Libp2p Libp2pDealsConfig
+}
+
+type Libp2pDealsConfig struct {
+	// Enable starts the deal protocol listener (/fil/storage/mk/1.2.x and the deal status protocol) on this node.
+	// Accepted deals are checked like deals from market adapters, including DealFilter and ClientLimits. Their data
+	// is fetched from the client by ParkPiece tasks, then the deals are published and added to sectors by the
+	// MK12Publish and MK12AddDeal tasks, which run on nodes with the listener enabled. All listeners in the cluster
+	// share one libp2p identity, so clients reach any of them with the peer ID published on chain. Only online
+	// deals with http transfers are supported, and offline deals renewing pieces the provider holds, see Renewal.
+	Enable bool
+
+	// ListenAddresses are the libp2p multiaddresses the listener binds to.
+	ListenAddresses []string
+
+	// AnnounceAddresses are the multiaddresses clients reach the listener at, e.g. "/dns4/deals.example.com/tcp/24001".
+	// When empty the listen addresses are announced.
+	AnnounceAddresses []string
+
+	// Miners are the miner actors deals are accepted for, e.g. ["f01234"]. Empty accepts deals for all miners in
+	// Addresses.
+	Miners []string
+
+	// PublishPeerInfo sets the peer ID and multiaddresses of the miners on chain to those of the listener when they
+	// differ, with messages sent from the worker address. It should be enabled on one node of the cluster.
+	PublishPeerInfo bool
+
+	// MinStartDelay rejects deals starting sooner than this after they are proposed, as their sectors won't be
+	// sealed in time.
+	MinStartDelay Duration
+
+	// MaxDealsPerPublishMsg is the maximum number of deals published in a single PublishStorageDeals message.
+	MaxDealsPerPublishMsg int
+
+	// PublishMsgPeriod is how long accepted deals wait for more deals to be published with, unless
+	// MaxDealsPerPublishMsg deals with received data are waiting. Deals are only published once their data is received.
+	PublishMsgPeriod Duration
+
+	// Renewal offers renewals of deals which are about to end to their clients, and accepts renewal deals.
+	Renewal DealRenewalConfig
+}
+
+type DealRenewalConfig struct {
+	// Enable makes the MK12Renewal task record a renewal offer for each deal of the miners which ends within Window
+	// while the provider still holds its data, parked or in an unsealed sector copy. Offers are unsigned proposals for
+	// the same piece, client, price and duration starting StartDelay in the future, listed by the DealRenewals web API.
+	// The listener then accepts offline deals for pieces the provider holds, whether they were offered or not, and
+	// seals them into new sectors without transferring the data again. Pieces which aren't parked anymore are parked
+	// from an unsealed sector copy by MK12RenewPark tasks, which run on nodes with the listener enabled.
+	Enable bool
+
+	// Window is how long before the end of a deal its renewal is offered.
+	Window Duration
+
+	// StartDelay is how far in the future offered renewal deals start. It has to be longer than MinStartDelay,
+	// leaving the client time to sign the offer. Offers which would start too soon are moved to a new start epoch.
+	StartDelay Duration
+
+	// MaxOffersPerRun limits the number of new offers recorded for each miner by a run of the MK12Renewal task.
+	MaxOffersPerRun int
+}
+
+type ClientIngestLimit struct {
+	// Clients the rule applies to, as deal client addresses, e.g. ["f01234"], or API token names prefixed with
+	// 'token:', e.g. ["token:ingest-bot"]. Empty applies to all clients.
+	Clients []string
+
+	// MaxConcurrentTransfers is the number of deals of the client whose data can be received by market adapters at
+	// the same time. 0 = unlimited
+	MaxConcurrentTransfers int
+
+	// MaxBytesPerDay is the amount of deal data accepted from the client over the last 24 hours, e.g. "10TiB".
+	// Empty = unlimited
+	MaxBytesPerDay string
+
+	// MaxQueuedDeals is the number of deals of the client accepted but not yet in a committed sector.
+	// 0 = unlimited
+	MaxQueuedDeals int
+}
+
+type CurioRetrievalConfig struct {
+	// ListenAddress enables the HTTP retrieval server on this node when set, e.g. '0.0.0.0:12310'. The server is
+	// separate from the Curio API and the web UI so it can be exposed to clients. It serves pieces at
+	// /piece/<piece cid>, with support for range requests, and data found in the block index (see
+	// Subsystems.EnableIndexPiece) at /ipfs/<cid>[/<path>] following the IPFS trustless gateway spec, as raw blocks or
+	// CARs. Data is read from parked pieces or unsealed sector copies.
+	ListenAddress string
+
+	// RequireToken makes the retrieval server reject requests without a valid retrieval token. Tokens are created
+	// in the web UI and passed in the 'Authorization: Bearer <token>' header or the 'token' query parameter.
+	// Requests with an invalid token are always rejected.
+	RequireToken bool
+
+	// ClientBandwidth limits the rate at which data is sent to each client, per second, e.g. '50MiB'. Requests with
+	// a token are limited per token, which can have its own limit, other requests per remote address. Empty means
+	// unlimited.
+	ClientBandwidth string
+
+	// UnsealOnDemand makes requests for pieces which are only in sealed sectors request unsealing of the piece.
+	// Such requests get a 503 response with a Retry-After header until the piece is unsealed. When disabled,
+	// they get a 404 response.
+	UnsealOnDemand bool
+}
+
+type DealFilterConfig struct {
+	// MinPricePerEpochPerGiB is the lowest storage price per epoch per GiB of piece size accepted for unverified
+	// deals. Zero accepts any price.
+	MinPricePerEpochPerGiB types.FIL
+
+	// MinVerifiedPricePerEpochPerGiB is the lowest storage price per epoch per GiB of piece size accepted for
+	// verified deals. Zero accepts any price.
+	MinVerifiedPricePerEpochPerGiB types.FIL
+
+	// ClientAllowList lists the only clients whose deals are accepted, as addresses. Empty allows all clients.
+	ClientAllowList []string
+
+	// ClientDenyList lists clients whose deals are rejected, as addresses.
+	ClientDenyList []string
+
+	// MinPieceSize is the smallest padded piece size accepted, e.g. "1MiB". Empty accepts any size.
+	MinPieceSize string
+
+	// MaxPieceSize is the largest padded piece size accepted, e.g. "32GiB". Empty accepts any size.
+	MaxPieceSize string
+
+	// MaxStartDelay is the longest time until the start epoch of a deal which is accepted. Deals starting later
+	// are rejected. Zero accepts any start epoch.
+	// Time duration string (e.g., "1h2m3s") in TOML format.
+	MaxStartDelay Duration
+
+	// Command is an external filter, run for each deal which passes the rules above. The deal is passed as JSON on
+	// stdin, the deal is accepted when the command exits with status 0, otherwise it is rejected with the command
+	// output as the reason.
+	Command string
+
+	// URL is an HTTP filter, called for each deal which passes the rules above and the command. The deal is POSTed
+	// as JSON, the response must be a JSON object like {"Accept": true, "Reason": ""}.
+	URL string
+
+	// Timeout is how long the command or HTTP filter can take before the deal is rejected.
+	// Time duration string (e.g., "1h2m3s") in TOML format.
+	Timeout Duration
+}
+
+type SnapSectorSelectionConfig struct {
+	// Policy orders the active CC sectors whose expiration fits the deal:
+	// "expiration" picks the sector expiring closest to ExpirationBuffer after the deal end, leaving room for
+	// deals ending slightly later in the same sector;
+	// "shortest-lifetime" picks the sector expiring soonest, keeping long-lived CC sectors for long deals;
+	// "longest-lifetime" picks the sector expiring last.
+	Policy string
+
+	// ExpirationBuffer is the ideal sector lifetime beyond the deal end for the "expiration" policy.
+	// Time duration string (e.g., "1h2m3s") in TOML format.
+	ExpirationBuffer Duration
+
+	// PreferredStorageGroups lists storage path groups whose CC sectors are picked before sectors stored
+	// elsewhere, e.g. to upgrade sectors stored close to the nodes running snap encoding.
+	PreferredStorageGroups []string
 }
 
 type CurioAlertingConfig struct {
 	// MinimumWalletBalance is the minimum balance all active wallets. If the balance is below this value, an
-	// alerts will be triggered for the wallet
+	// alerts will be triggered for the wallet. Per-address thresholds can be set with the WalletSetThreshold
+	// web API method.
 	MinimumWalletBalance types.FIL
 
+	// StorageFullDays triggers an alert when long-term storage paths are projected to fill up within this many
+	// days, based on their usage trend over the last week. Usage history is recorded by the ChartRollup task.
+	// Zero disables the alert.
+	StorageFullDays int
+
 	// PagerDutyConfig is the configuration for the PagerDuty alerting integration.
 	PagerDuty PagerDutyConfig
 
@@ -460,6 +1054,133 @@ type CurioSealConfig struct {
 	//
 	// Example: ["0000:01:00.0", "0000:01:00.1"]
 	LayerNVMEDevices []string
+
+	// BatchSealNUMANode is the NUMA node (CPU socket) whose cores run the batch sealer on multi-socket machines.
+	// The cores of other nodes are left to other tasks.
+	BatchSealNUMANode int
+
+	// BatchSealMaxCores limits the number of CPU cores used by the batch sealer, 0 uses all cores of the NUMA node.
+	// The limit is rounded down to whole L3 cache groups (CCX). Cores above the limit are left to other tasks.
+	//
+	// Like LayerNVMEDevices, the NUMA and core settings are machine-specific and are best set in a per-machine layer.
+	BatchSealMaxCores int
+
+	// HugePages is the number of 1GiB huge pages reserved on this machine when Curio starts, 0 leaves the kernel
+	// setting. The batch sealer allocates its SDR buffers from 1GiB huge pages. Reserving needs root or
+	// CAP_SYS_ADMIN and can fall short once memory is fragmented, reserving on the kernel command line is more
+	// reliable. The outcome, with the other memory pre-flight checks, is shown in the machine info of the web UI.
+	HugePages int
+
+	// HugePagesNUMANode is the NUMA node the huge pages are reserved on, -1 lets the kernel spread them across
+	// nodes. On multi-socket batch sealing machines set it to BatchSealNUMANode.
+	HugePagesNUMANode int
+
+	// PC1NUMANode pins SDR (PC1) of single sectors, run by the SDR and unseal tasks, to a NUMA node: it runs on the
+	// cores of the node, with its memory allocated from the node, which avoids cross-socket memory access and
+	// keeps SDR times consistent. -1 doesn't pin SDR.
+	//
+	// Like the batch seal settings, the huge page and NUMA settings are machine-specific and are best set in a
+	// per-machine layer.
+	PC1NUMANode int
+
+	// SyntheticPoRep rules decide whether new sectors of a miner and sector size use synthetic PoRep. The first
+	// rule matching a new sector applies, sectors matching no rule follow Subsystems.UseSyntheticPoRep. Rules apply
+	// to sectors created after the config is loaded, each sector keeps the mode it was created with.
+	SyntheticPoRep []SyntheticPoRepRule
+
+	// CachePurge sets when sealing intermediates of sectors sealed with SDR are removed. Keeping them longer costs
+	// sealing storage, but lets a failed PoRep or commit be retried without redoing SDR and trees.
+	CachePurge CachePurgeConfig
+
+	// SplitTrees lets TreeD and TreeRC of a sector run on different machines. TreeD runs on any node with it
+	// enabled, GPU or not, preferring nodes which hold the SDR layers of the sector. TreeRC prefers nodes holding the
+	// sector cache. Another GPU node takes it once it waited 5 minutes for one of them, or right away when none of
+	// them runs TreeRC, and fetches the cache (SDR layers and TreeD) from the TreeD node over the network, about 12x
+	// the sector size. Used with Subsystems.NoTreeD and Subsystems.NoTreeRC this runs TreeD on memory-heavy SDR nodes
+	// and TreeRC on GPU nodes.
+	//
+	// This changes how tasks are scheduled across the cluster, set it in a layer used by all sealing nodes.
+	SplitTrees bool
+
+	// LongHaul configures sealing on machines which reach the cluster over a WAN link. It's machine-specific, set it
+	// in a per-machine layer.
+	LongHaul LongHaulConfig
+}
+
+type LongHaulConfig struct {
+	// Enable makes sectors whose SDR runs on this machine stay on it until they are finalized: TreeD, TreeRC,
+	// synthetic proofs and PoRep run here, and the machine takes no tasks of other sectors. The finalized sector,
+	// the sealed file, tree-r-last and the unsealed copy when there is one, is then uploaded to storage of another
+	// node by the LongHaulUpload task, in checksummed chunks which are resumed after an interrupted upload.
+	// Other nodes don't have to reach this machine, but it has to reach the storage URLs of the cluster.
+	Enable bool
+
+	// UploadBandwidth is the bandwidth available to uploads, e.g. "100MiB" per second. Uploads share it, and new
+	// uploads don't start while the running ones use most of it. Empty doesn't limit uploads.
+	UploadBandwidth string
+
+	// MaxParallelUploads is the maximum number of sectors uploaded at once, 0 means unlimited.
+	MaxParallelUploads int
+
+	// ChunkSize is the size of upload chunks, e.g. "256MiB". An interrupted chunk is sent again, so smaller chunks
+	// lose less data on unreliable links.
+	ChunkSize string
+}
+
+type SyntheticPoRepRule struct {
+	// Miners the rule applies to, e.g. ["f01234"]. Empty applies to all miners.
+	Miners []string
+
+	// SectorSizes the rule applies to, e.g. ["32GiB"]. Empty applies to all sector sizes.
+	SectorSizes []string
+
+	// Enable makes matching sectors use synthetic PoRep, false makes them use regular PoRep.
+	Enable bool
+}
+
+type CachePurgeConfig struct {
+	// Layers is when the SDR layer files are removed from the sector cache. Each value is 'finalize', removed by
+	// the Finalize task right after PoRep, 'commit', removed once the commit message landed, or a duration, e.g.
+	// '72h', removed that long after the commit message landed. Sectors whose layers or trees are kept stay in
+	// sealing storage until they are removed, MoveStorage waits for it. Layers of batch sealed sectors aren't in
+	// the cache, so this has no effect on them.
+	Layers string
+
+	// Trees is when the TreeC files, and the TreeD file when it doesn't become the unsealed copy, are removed.
+	Trees string
+
+	// Unsealed is when the unsealed copy of a sector with deals which didn't ask to keep one is removed. Removal
+	// goes through the storage removal marks, approved by policy.
+	Unsealed string
+}
+
+type CurioBatchingConfig struct {
+	// PreCommit configures batching of sectors into PreCommitSectorBatch2 messages.
+	PreCommit BatchingConfig
+
+	// Commit configures batching of sectors into ProveCommitSectors3 messages. Proofs of batches of at least 4
+	// sectors are aggregated when the base fee is at or above BaseFeeThreshold, below it the sector proofs are
+	// sent individually in the batch message.
+	Commit BatchingConfig
+}
+
+type BatchingConfig struct {
+	// MaxSectors is the maximum number of sectors in one message. 1 disables batching, each sector is sent in
+	// its own message as soon as it is ready.
+	MaxSectors int
+
+	// MinSectors is the number of ready sectors at which a batch is sent without waiting for MaxWait. 0 means
+	// that only full batches are sent before MaxWait.
+	MinSectors int
+
+	// MaxWait is the maximum time a ready sector waits for its batch to fill. Keep it well below the precommit
+	// ticket and prove commit deadlines.
+	// Time duration string (e.g., "1h2m3s") in TOML format.
+	MaxWait Duration
+
+	// BaseFeeThreshold is the network base fee below which batching isn't worth waiting for, ready sectors are
+	// sent right away.
+	BaseFeeThreshold types.FIL
 }
 
 type PagerDutyConfig struct {
@@ -493,10 +1214,324 @@ type SlackWebhookConfig struct {
 	WebHookURL string
 }
 
+type CurioWebConfig struct {
+	// RequireTokenAuth makes the web API reject requests which don't carry a valid API token. When disabled (the
+	// default), requests without a token get full access while requests with a token are limited to its scopes.
+	// Tokens are passed in the 'Authorization: Bearer <token>' header, the 'token' query parameter or the
+	// 'curio_token' cookie, and can be created with the WebTokenCreate web API method.
+	//
+	// Available scopes: read, tasks:write, config:write, alerts:write, admin. Every token can read,
+	// admin grants all scopes including token management.
+	RequireTokenAuth bool
+
+	// EnableGraphQL serves a read-only GraphQL endpoint at /api/graphql exposing machines, tasks, sectors,
+	// deals, storage paths and messages.
+	EnableGraphQL bool
+
+	// RateLimit is the number of web API requests per second allowed for each token. Requests without a token
+	// are limited per remote address. Every HTTP request and every web RPC call requiring more than the read
+	// scope counts against the limit. Zero disables rate limiting.
+	//
+	// Calls requiring more than the read scope are always recorded in the web_api_audit table, which can be
+	// viewed with the WebAuditLog web API method.
+	RateLimit float64
+
+	// RateLimitBurst is the number of requests which can be made at once before RateLimit applies. Zero means
+	// RateLimit rounded up.
+	RateLimitBurst int
+
+	// BasePath is the URL prefix the web UI and API are served under, e.g. '/curio', for running behind a
+	// reverse proxy or ingress which forwards a sub-path without stripping it. Empty serves from the root.
+	BasePath string
+
+	// CORSAllowedOrigins lists origins allowed to make cross-origin requests to the web API, e.g.
+	// 'https://dashboard.example.com'. '*' allows any origin. Empty disables cross-origin requests.
+	CORSAllowedOrigins []string
+
+	// ClusterName identifies this cluster in federated views, of this node and of other nodes federating
+	// this cluster. Defaults to 'local'.
+	ClusterName string
+
+	// Federation lists other Curio clusters whose machines, sectors and alerts are merged into the federated
+	// views of this node's web API, for operators running a cluster per region.
+	Federation []FederatedCluster
+}
+
+type FederatedCluster struct {
+	// Name identifies the cluster in federated views.
+	Name string
+
+	// URL is the base URL of a web server of the cluster, including its BasePath if set,
+	// e.g. 'http://curio-eu.example.com:4701'.
+	URL string
+
+	// Token is a web API token of the cluster, required if the cluster has RequireTokenAuth set. Only the
+	// read scope is needed.
+	Token string
+}
+
+type CurioStorageConfig struct {
+	// Placement is the policy for choosing which storage path gets new sector data.
+	Placement StoragePlacementConfig
+
+	// Tiering is the policy for migrating sealed sectors between storage tiers, see Subsystems.EnableStorageTiering.
+	// Storage paths are assigned to tiers with the Tier field of their sectorstore.json.
+	Tiering StorageTieringConfig
+
+	// Scrub is the policy for background verification of sealed data, see Subsystems.EnableScrubSealed.
+	Scrub StorageScrubConfig
+
+	// Encryption holds the keys for encrypting unsealed data at rest in storage paths which have EncryptUnsealed
+	// set in their sectorstore.json. All nodes reading encrypted data need the same keys.
+	Encryption StorageEncryptionConfig
+
+	// PieceGC is the policy for removing parked piece data which is no longer referenced by any sector or deal.
+	PieceGC StoragePieceGCConfig
+
+	// PieceReplication is the policy for keeping more than one copy of parked pieces, so that deal data waiting
+	// to be sealed isn't lost with a single storage path or node.
+	PieceReplication StoragePieceReplicationConfig
+
+	// HealthProbe is the policy for probing storage paths from every node. Paths which fail probes from most
+	// nodes are marked degraded, and reads which have a copy of the data in a healthy path avoid them.
+	HealthProbe StorageHealthProbeConfig
+
+	// Inventory is the policy for checking the storage index against files on disk and chain state, see
+	// Subsystems.EnableStorageInventory.
+	Inventory StorageInventoryConfig
+
+	// ReadIO sets how sector files are read from storage paths by readers of unsealed data, sector fetches served
+	// to other nodes and vanilla proof generation. Paths can override it with ReadIO in their sectorstore.json.
+	ReadIO StorageReadIOConfig
+
+	// UnsealedCache is the policy for unsealed sector copies created on demand, e.g. for retrievals, which are
+	// kept until the cache is over its budget instead of being kept forever or removed right away.
+	UnsealedCache StorageUnsealedCacheConfig
+
+	// UnsealedRegen configures the regeneration of missing unsealed copies, see Subsystems.EnableUnsealedRegen.
+	UnsealedRegen StorageUnsealedRegenConfig
+
+	// RemovalHook is called before sector and piece files are removed from storage paths attached to this node,
+	// e.g. by garbage collection or sector removal, so that the data can be snapshotted or copied to backup storage
+	// first. Removal is blocked until the hook confirms it.
+	RemovalHook StorageRemovalHookConfig
+}
+
+type StoragePlacementConfig struct {
+	// Strategy ranks storage paths with enough space for new sector data:
+	// 'available' prefers paths with the most available space, scaled by path weight;
+	// 'free-percent' prefers paths with the highest percentage of free space, scaled by path weight;
+	// 'weight' prefers paths with the highest weight, breaking ties by available space.
+	Strategy string
+
+	// PreferTiers lists storage tiers in order of preference for new sector data. Paths in tiers which aren't
+	// listed are only used when no path in a listed tier can fit the data. Empty means tiers are ignored.
+	PreferTiers []string
+
+	// MinerAffinity prefers paths which list the miner in their AllowMiners over paths shared by all miners.
+	MinerAffinity bool
+}
+
+type StorageTieringConfig struct {
+	// HotTier is the tier of paths sectors are migrated out of, typically fast NVMe storage which finalized
+	// sectors land on.
+	HotTier string
+
+	// ColdTier is the tier of paths sectors are migrated to, e.g. HDD or archive storage.
+	ColdTier string
+
+	// MigrateAfter is how long sectors stay in the hot tier after their commit message landed on chain.
+	MigrateAfter Duration
+
+	// PinDeadlines keeps sectors in the hot tier while their proving deadline is open or is one of the next
+	// PinDeadlines deadlines, so data isn't moved while it is being proven.
+	PinDeadlines int
+
+	// MaxPendingMoves bounds the number of planned migrations waiting or running across the cluster.
+	MaxPendingMoves int
+}
+
+type StorageScrubConfig struct {
+	// SampleInterval is how often each sealed sector copy is sampled. Sampling generates vanilla PoSt proofs for
+	// random challenges, reading a small part of the sealed file and checking it against the sector commitments.
+	// Zero disables sampling.
+	SampleInterval Duration
+
+	// FullReadInterval is how often each sealed sector copy is fully read and checksummed. The first full read of
+	// a copy records its checksums, later reads compare against them. Zero disables full reads.
+	FullReadInterval Duration
+
+	// MaxPendingChecks bounds the number of planned scrub checks waiting or running across the cluster.
+	MaxPendingChecks int
+
+	// AutoRepair schedules a repair of sector copies which fail a scrub check, see Subsystems.EnableSectorRepair.
+	AutoRepair bool
+}
+
+type StorageEncryptionConfig struct {
+	// Key is the hex or base64 encoded 256-bit AES key used to encrypt new data. Empty disables encryption
+	// unless KeyCommand is set.
+	Key string
+
+	// KeyCommand is a shell command printing the key, for fetching it from a KMS or secret store at startup
+	// instead of storing it in the config, e.g. 'vault kv get -field=key secret/curio'. Overrides Key.
+	KeyCommand string
+
+	// PreviousKeys are keys which were used before a key rotation, still needed to decrypt data encrypted
+	// with them.
+	PreviousKeys []string
+}
+
+type StorageHealthProbeConfig struct {
+	// Interval is how often this node probes every URL of every storage path. A probe stats the path and reads
+	// a small random part of a sector file. Zero disables probing from this node.
+	Interval Duration
+
+	// SlowThreshold is the probe latency or read time above which a probe counts as failed.
+	SlowThreshold Duration
+}
+
+type StoragePieceGCConfig struct {
+	// GracePeriod is how long parked pieces are kept after their last reference was dropped, so that pieces
+	// re-added shortly after, e.g. by a retried deal, don't have to be fetched again. Pieces with their own
+	// grace period override this.
+	GracePeriod Duration
+
+	// DryRun only logs the parked pieces which would be removed, without removing them. The full list of
+	// unreferenced pieces and the reason each is kept or removed is available in the web UI.
+	DryRun bool
+}
+
+type StoragePieceReplicationConfig struct {
+	// Copies is the number of copies of every referenced parked piece to keep in distinct storage paths.
+	// Pieces with fewer live copies, e.g. after a path holding one was detached or stopped responding, are
+	// copied again by the ReplicatePiece task. 1 keeps only the copy written when the piece was parked.
+	Copies int
+
+	// DistinctMachines only counts copies held by different nodes, and only places new copies on nodes which
+	// don't have one yet. Paths attached to the same set of nodes count as a single copy.
+	DistinctMachines bool
+
+	// CheckInterval is how often the number of live copies of parked pieces is verified. The check runs as the
+	// CheckReplicas task on a single node of the cluster.
+	CheckInterval Duration
+}
+
+type StorageInventoryConfig struct {
+	// Interval is how often every storage path, and the sectors live on chain, are checked against the storage
+	// index. Zero disables periodic checks, checks can still be started from the web UI.
+	Interval Duration
+
+	// AutoReconcile fixes the index for problems found by periodic checks: files missing on disk are dropped from
+	// the index, and files on disk which aren't in the index are declared. Duplicates and lost sectors are only
+	// reported.
+	AutoReconcile bool
+
+	// GracePeriod skips files modified more recently, which may still be written by running tasks.
+	GracePeriod Duration
+}
+
+type StorageReadIOConfig struct {
+	// Store applies to paths used for long-term storage, which serve PoSt challenge reads. Default page cache
+	// behavior can hurt proving from large HDD-backed paths.
+	Store StoragePathReadIOConfig
+
+	// Seal applies to paths only used for sealing.
+	Seal StoragePathReadIOConfig
+}
+
+type StoragePathReadIOConfig struct {
+	// DirectIO reads sector files with O_DIRECT, bypassing the page cache. Challenge reads done by the proofs
+	// library can't bypass it, so the pages they read are evicted after each vanilla proof instead. Linux only.
+	DirectIO bool
+
+	// Readahead is the amount of data read ahead of reads of sector files, e.g. '4MiB'. Empty keeps the kernel
+	// default, '0' disables readahead. Ignored with DirectIO. Linux only.
+	Readahead string
+}
+
+type StorageUnsealedCacheConfig struct {
+	// Budget is the total size of cached unsealed copies, e.g. '4TiB'. Once it is exceeded the least recently read
+	// copies are removed. Sectors set to be kept unsealed by hand don't count against the budget. Empty disables
+	// eviction.
+	Budget string
+
+	// EvictInterval is how often the cache is checked against its budget.
+	EvictInterval Duration
+}
+
+type StorageUnsealedRegenConfig struct {
+	// Auto requests regeneration of sectors which should have an unsealed copy, because they are set to be kept
+	// unsealed or one of their deals asked to keep the data, but have none in the storage index.
+	Auto bool
+
+	// ScanInterval is how often sectors are checked for missing unsealed copies.
+	ScanInterval Duration
+
+	// MaxPerScan bounds the number of regenerations requested by one scan, so that losing a storage path doesn't
+	// queue unsealing of all its sectors at once.
+	MaxPerScan int
+}
+
+type StorageRemovalHookConfig struct {
+	// Command is a shell command run before a file is removed. The file is described by the CURIO_REMOVE_SP_ID,
+	// CURIO_REMOVE_SECTOR_NUM, CURIO_REMOVE_FILE_TYPE, CURIO_REMOVE_STORAGE_ID, CURIO_REMOVE_PATH and
+	// CURIO_REMOVE_LAST_COPY environment variables. The file is only removed if the command exits with status 0.
+	Command string
+
+	// URL is an HTTP endpoint the description of the file is POSTed to as JSON before it is removed. The file is
+	// only removed if the endpoint responds with a 2xx status. With both Command and URL set, both must confirm.
+	URL string
+
+	// AllCopies calls the hook for every removed copy of a file. By default it is only called when the removed
+	// copy is the last one in the storage index, so that dropping duplicate copies isn't blocked.
+	AllCopies bool
+
+	// Timeout bounds a single call of the hook, a timed out call blocks the removal.
+	Timeout Duration
+}
+
 type ApisConfig struct {
-	// ChainApiInfo is the API endpoint for the Lotus daemon.
+	// ChainApiInfo is the API endpoint for the Lotus daemon. With multiple endpoints, calls go to the first
+	// healthy node, and fail over to the next one when it errors or falls behind the others.
 	ChainApiInfo []string
 
+	// ChainApiLoadBalance spreads read calls across all healthy nodes in ChainApiInfo. Calls depending on node
+	// local state (mpool, wallet) still go to a single node.
+	ChainApiLoadBalance bool
+
 	// Chain API auth secret for the Curio nodes to use.
 	StorageRPCSecret string
+
+	// RemoteSigners are wallets which sign messages from some addresses instead of the chain node wallet, so
+	// that keys of high-value addresses (e.g. owner) are not held by the chain node. Messages are only sent from
+	// those addresses by nodes with the remote signer configured.
+	RemoteSigners []RemoteSignerConfig
+
+	// OfflineSignAddresses are addresses whose messages are signed on an air-gapped machine, e.g. owner addresses
+	// used for owner changes and withdrawals. Their messages are queued until exported with
+	// 'curio offline-sign export', signed offline, and imported with 'curio offline-sign import'.
+	OfflineSignAddresses []string
+}
+
+type RemoteSignerConfig struct {
+	// ApiInfo is the API endpoint of a lotus-wallet compatible wallet, e.g. 'TOKEN:/ip4/10.0.0.5/tcp/1777/http'.
+	// To sign with a Ledger, run 'lotus-wallet run --ledger' on the machine the Ledger is connected to.
+	ApiInfo string
+
+	// URL of an HTTPS signing service, used instead of ApiInfo, e.g. 'https://signer.internal:8443'. The service
+	// answers GET <URL>/has?address=<address> with {"Has": true}, and POST <URL>/sign with
+	// {"Address": "<address>", "Digest": "<base64>", "Message": "<base64>"} with {"Type": <sig type>, "Data": "<base64>"}.
+	// Digest is the data to sign (the message CID bytes), Message is the serialized message.
+	URL string
+
+	// ClientCert and ClientKey are PEM files of the client certificate presented to the signing service at URL.
+	ClientCert string
+	ClientKey  string
+
+	// CACert is a PEM file of the CA verifying the signing service certificate. Empty uses the system CAs.
+	CACert string
+
+	// Addresses whose messages are signed by this wallet.
+	Addresses []string
 }
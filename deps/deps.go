@@ -13,11 +13,14 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/docker/go-units"
 	"github.com/gbrlsnchs/jwt/v3"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/samber/lo"
@@ -34,6 +37,7 @@ import (
 	"github.com/filecoin-project/curio/api"
 	"github.com/filecoin-project/curio/deps/config"
 	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/cryptfile"
 	"github.com/filecoin-project/curio/lib/curiochain"
 	"github.com/filecoin-project/curio/lib/multictladdr"
 	"github.com/filecoin-project/curio/lib/paths"
@@ -235,7 +239,7 @@ func (deps *Deps) PopulateRemainingDeps(ctx context.Context, cctx *cli.Context,
 	}
 
 	if deps.As == nil {
-		deps.As, err = multictladdr.AddressSelector(deps.Cfg.Addresses)()
+		deps.As, err = multictladdr.AddressSelector(deps.DB, deps.Cfg.Addresses)()
 		if err != nil {
 			return err
 		}
@@ -246,7 +250,11 @@ func (deps *Deps) PopulateRemainingDeps(ctx context.Context, cctx *cli.Context,
 	}
 
 	if deps.Si == nil {
-		deps.Si = paths.NewDBIndex(deps.Al, deps.DB)
+		dbi := paths.NewDBIndex(deps.Al, deps.DB)
+		if err := dbi.SetPlacementPolicy(placementPolicy(deps.Cfg.Storage.Placement)); err != nil {
+			return xerrors.Errorf("storage placement policy: %w", err)
+		}
+		deps.Si = dbi
 	}
 
 	if deps.Chain == nil {
@@ -255,7 +263,7 @@ func (deps *Deps) PopulateRemainingDeps(ctx context.Context, cctx *cli.Context,
 		if v := os.Getenv("FULLNODE_API_INFO"); v != "" {
 			cfgApiInfo = []string{v}
 		}
-		deps.Chain, fullCloser, err = GetFullNodeAPIV1Curio(cctx, cfgApiInfo)
+		deps.Chain, fullCloser, err = GetFullNodeAPIV1Curio(cctx, cfgApiInfo, deps.Cfg.Apis.ChainApiLoadBalance)
 		if err != nil {
 			return err
 		}
@@ -303,6 +311,28 @@ func (deps *Deps) PopulateRemainingDeps(ctx context.Context, cctx *cli.Context,
 		if err != nil {
 			return err
 		}
+
+		kr, err := encryptionKeyring(ctx, deps.Cfg.Storage.Encryption)
+		if err != nil {
+			return xerrors.Errorf("storage encryption keys: %w", err)
+		}
+		if kr != nil {
+			deps.LocalStore.EnableEncryption(kr)
+		}
+
+		sealIO, err := pathReadIO(deps.Cfg.Storage.ReadIO.Seal)
+		if err != nil {
+			return xerrors.Errorf("Storage.ReadIO.Seal: %w", err)
+		}
+		storeIO, err := pathReadIO(deps.Cfg.Storage.ReadIO.Store)
+		if err != nil {
+			return xerrors.Errorf("Storage.ReadIO.Store: %w", err)
+		}
+		deps.LocalStore.SetReadIODefaults(sealIO, storeIO)
+
+		if hook := removalHook(deps.Cfg.Storage.RemovalHook); hook != nil {
+			deps.LocalStore.SetRemovalHook(hook, deps.Cfg.Storage.RemovalHook.AllCopies)
+		}
 	}
 
 	sa, err := StorageAuth(deps.Cfg.Apis.StorageRPCSecret)
@@ -351,6 +381,89 @@ Get it with: jq .PrivateKey ~/.lotus-miner/keystore/MF2XI2BNNJ3XILLQOJUXMYLUMU`,
 	return nil
 }
 
+func placementPolicy(cfg config.StoragePlacementConfig) paths.PlacementPolicy {
+	return paths.PlacementPolicy{
+		Strategy:      paths.PlacementStrategy(cfg.Strategy),
+		PreferTiers:   cfg.PreferTiers,
+		MinerAffinity: cfg.MinerAffinity,
+	}
+}
+
+// pathReadIO parses the read IO options of a storage path class
+func pathReadIO(cfg config.StoragePathReadIOConfig) (storiface.PathReadIO, error) {
+	rio := storiface.PathReadIO{DirectIO: cfg.DirectIO}
+	if cfg.Readahead == "" {
+		return rio, nil
+	}
+
+	ra, err := units.RAMInBytes(cfg.Readahead)
+	if err != nil {
+		return storiface.PathReadIO{}, xerrors.Errorf("parsing Readahead: %w", err)
+	}
+	if ra == 0 {
+		ra = -1
+	}
+	rio.Readahead = ra
+	return rio, nil
+}
+
+// removalHook builds the hook called before files are removed from local paths, nil if none is configured
+func removalHook(cfg config.StorageRemovalHookConfig) paths.RemovalHook {
+	timeout := time.Duration(cfg.Timeout)
+
+	var hooks []paths.RemovalHook
+	if cfg.Command != "" {
+		hooks = append(hooks, paths.CommandRemovalHook(cfg.Command, timeout))
+	}
+	if cfg.URL != "" {
+		hooks = append(hooks, paths.HTTPRemovalHook(cfg.URL, timeout))
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	return func(ctx context.Context, req paths.RemovalRequest) error {
+		for _, h := range hooks {
+			if err := h(ctx, req); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// encryptionKeyring loads the storage encryption keys, returning nil if encryption isn't configured
+func encryptionKeyring(ctx context.Context, cfg config.StorageEncryptionConfig) (*cryptfile.Keyring, error) {
+	keyStr := cfg.Key
+	if cfg.KeyCommand != "" {
+		out, err := exec.CommandContext(ctx, "sh", "-c", cfg.KeyCommand).Output()
+		if err != nil {
+			return nil, xerrors.Errorf("running key command: %w", err)
+		}
+		keyStr = string(out)
+	}
+	if keyStr == "" {
+		return nil, nil
+	}
+
+	key, err := cryptfile.ParseKey(keyStr)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing key: %w", err)
+	}
+
+	var previous []*cryptfile.Key
+	for i, s := range cfg.PreviousKeys {
+		k, err := cryptfile.ParseKey(s)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing previous key %d: %w", i, err)
+		}
+		previous = append(previous, k)
+	}
+
+	log.Infow("storage encryption enabled", "key", key.ID)
+	return cryptfile.NewKeyring(key, previous...), nil
+}
+
 func sealProofType(maddr dtypes.MinerAddress, fnapi api.Chain) (abi.RegisteredSealProof, error) {
 	mi, err := fnapi.StateMinerInfo(context.TODO(), address.Address(maddr), types.EmptyTSK)
 	if err != nil {
@@ -443,7 +556,7 @@ func GetAPI(ctx context.Context, cctx *cli.Context) (*harmonydb.DB, *config.Curi
 		return nil, nil, nil, nil, err
 	}
 
-	full, fullCloser, err := GetFullNodeAPIV1Curio(cctx, cfg.Apis.ChainApiInfo)
+	full, fullCloser, err := GetFullNodeAPIV1Curio(cctx, cfg.Apis.ChainApiInfo, cfg.Apis.ChainApiLoadBalance)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
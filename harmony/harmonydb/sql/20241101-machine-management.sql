@@ -0,0 +1,5 @@
+-- Operator controls for cluster machines.
+-- unschedulable: machine finishes running tasks but accepts no new work (cordon).
+-- restart_request: machine should cordon itself, drain, then exit so that the service manager restarts it.
+ALTER TABLE harmony_machines ADD COLUMN unschedulable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE harmony_machines ADD COLUMN restart_request TIMESTAMPTZ;
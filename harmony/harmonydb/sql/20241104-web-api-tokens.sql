@@ -0,0 +1,12 @@
+-- Scoped tokens for the web API. Only a hash of the token is stored.
+CREATE TABLE web_api_tokens (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    token_hash BYTEA NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ,
+    last_used TIMESTAMPTZ,
+    revoked BOOLEAN NOT NULL DEFAULT FALSE
+);
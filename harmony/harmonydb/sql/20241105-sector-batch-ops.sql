@@ -0,0 +1,29 @@
+-- Batch sector operations requested through the web API. Each op expands
+-- its selector into items at creation time, the SectorBatchOp task then
+-- works through the items and records per-sector results.
+CREATE TABLE sector_batch_ops (
+    op_id BIGSERIAL PRIMARY KEY,
+
+    action TEXT NOT NULL, -- remove, unseal, redeclare, retry
+    stage TEXT NOT NULL DEFAULT '', -- pipeline stage for retry
+    selector JSONB NOT NULL,
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    complete_time TIMESTAMP WITH TIME ZONE,
+
+    task_id BIGINT
+);
+
+CREATE TABLE sector_batch_op_items (
+    op_id BIGINT NOT NULL REFERENCES sector_batch_ops (op_id) ON DELETE CASCADE,
+
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+
+    complete BOOLEAN NOT NULL DEFAULT FALSE,
+    error TEXT,
+
+    PRIMARY KEY (op_id, sp_id, sector_number)
+);
+
+CREATE INDEX sector_batch_ops_task_id ON sector_batch_ops (task_id);
@@ -0,0 +1,23 @@
+-- Results of alert checks, one row per occurrence of an alert.
+-- An alert is active until a check run no longer reports it.
+CREATE TABLE alert_history (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL, -- check name, e.g. 'Balance Check'
+    message TEXT NOT NULL,
+
+    first_seen TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    last_seen TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    resolved_at TIMESTAMP WITH TIME ZONE,
+
+    -- acknowledged alerts are not sent to alert plugins until they resolve
+    acked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX alert_history_active_name ON alert_history (name) WHERE resolved_at IS NULL;
+
+-- Silenced alerts are recorded, but not sent to alert plugins.
+CREATE TABLE alert_silences (
+    name TEXT PRIMARY KEY,
+    silenced_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp
+);
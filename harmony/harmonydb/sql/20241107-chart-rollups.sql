@@ -0,0 +1,34 @@
+-- Periodic aggregates of history tables used by the web UI charts.
+-- Maintained by the ChartRollup task.
+
+CREATE TABLE chart_task_hourly (
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL, -- start of the hour
+    task_name TEXT NOT NULL,
+
+    completed BIGINT NOT NULL,
+    failed BIGINT NOT NULL,
+    avg_duration_s DOUBLE PRECISION NOT NULL,
+    max_duration_s DOUBLE PRECISION NOT NULL,
+
+    PRIMARY KEY (bucket, task_name)
+);
+
+CREATE TABLE chart_sealed_daily (
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL, -- start of the day
+    sp_id BIGINT NOT NULL,
+
+    sectors BIGINT NOT NULL,
+    bytes BIGINT NOT NULL, -- raw sector bytes
+
+    PRIMARY KEY (bucket, sp_id)
+);
+
+CREATE TABLE chart_gas_daily (
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL, -- start of the day the messages landed on chain
+    send_reason TEXT NOT NULL,
+
+    messages BIGINT NOT NULL,
+    gas_used BIGINT NOT NULL,
+
+    PRIMARY KEY (bucket, send_reason)
+);
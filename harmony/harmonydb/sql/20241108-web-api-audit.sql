@@ -0,0 +1,18 @@
+-- Audit log of web API calls which need more than the read scope.
+CREATE TABLE web_api_audit (
+    id BIGSERIAL PRIMARY KEY,
+    at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    token_id INT, -- NULL for requests without a token
+    token_name TEXT NOT NULL DEFAULT '',
+    remote TEXT NOT NULL,
+
+    endpoint TEXT NOT NULL, -- 'POST /api/sector/batch' or 'rpc CurioWeb.WebTokenCreate'
+    payload_hash BYTEA NOT NULL, -- sha256 of the request body or JSON encoded rpc params
+
+    status TEXT NOT NULL, -- 'ok' or 'error'
+    error TEXT
+);
+
+CREATE INDEX web_api_audit_at ON web_api_audit (at);
+CREATE INDEX web_api_audit_token_id ON web_api_audit (token_id);
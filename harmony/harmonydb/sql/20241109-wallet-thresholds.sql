@@ -0,0 +1,8 @@
+-- Per-address low balance thresholds for the balance alert. Addresses without
+-- an entry use Alerting.MinimumWalletBalance.
+CREATE TABLE wallet_balance_thresholds (
+    address TEXT PRIMARY KEY,
+    min_balance NUMERIC(78, 0) NOT NULL, -- attoFIL
+
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,26 @@
+-- Sector expiration extensions requested through the web API. The
+-- ExtendSectors task plans ExtendSectorExpiration2 messages for the
+-- selected sectors at execution time and records the sent messages.
+CREATE TABLE sector_extend_ops (
+    op_id BIGSERIAL PRIMARY KEY,
+
+    sp_id BIGINT NOT NULL,
+    sectors BIGINT[] NOT NULL,
+
+    extension BIGINT, -- epochs added to the current expiration
+    new_expiration BIGINT, -- absolute new expiration, exclusive with extension
+    drop_claims BOOLEAN NOT NULL DEFAULT FALSE,
+
+    max_fee TEXT NOT NULL, -- attoFIL, per message
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    complete_time TIMESTAMP WITH TIME ZONE,
+
+    task_id BIGINT,
+
+    extended BIGINT, -- number of sectors in the sent messages
+    message_cids TEXT[] NOT NULL DEFAULT '{}',
+    error TEXT
+);
+
+CREATE INDEX sector_extend_ops_task_id ON sector_extend_ops (task_id);
@@ -0,0 +1,11 @@
+-- Hourly storage path usage snapshots, maintained by the ChartRollup task and
+-- used to forecast when storage paths fill up. Kept for 90 days.
+CREATE TABLE chart_storage_hourly (
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL, -- start of the hour
+    storage_id TEXT NOT NULL,
+
+    capacity BIGINT NOT NULL,
+    available BIGINT NOT NULL,
+
+    PRIMARY KEY (bucket, storage_id)
+);
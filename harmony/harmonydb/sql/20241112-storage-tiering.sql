@@ -0,0 +1,32 @@
+-- Storage tier label of a path, set in the path's sectorstore.json. Empty means
+-- the path doesn't take part in tiering.
+ALTER TABLE storage_path ADD COLUMN tier TEXT NOT NULL DEFAULT '';
+
+-- Sector migrations between storage tiers. Rows are placement decisions made by
+-- the tiering policy, executed by StorageTierMove tasks on a node with a local
+-- path in the destination tier.
+CREATE TABLE storage_tier_moves (
+    move_id BIGSERIAL PRIMARY KEY,
+
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+    reg_seal_proof INT NOT NULL,
+    file_types INT NOT NULL, -- storiface.SectorFileType bitmask
+
+    from_storage_id TEXT NOT NULL,
+    from_tier TEXT NOT NULL,
+    to_tier TEXT NOT NULL,
+    to_storage_id TEXT, -- set when the move is done
+
+    reason TEXT NOT NULL,
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    complete_time TIMESTAMP WITH TIME ZONE,
+
+    task_id BIGINT,
+    error TEXT
+);
+
+-- at most one pending move per sector
+CREATE UNIQUE INDEX storage_tier_moves_pending ON storage_tier_moves (sp_id, sector_number) WHERE complete_time IS NULL;
+CREATE INDEX storage_tier_moves_task_id ON storage_tier_moves (task_id);
@@ -0,0 +1,40 @@
+-- Background scrub checks of sealed sector data, one row per sector copy in a
+-- storage path. Sampled checks generate vanilla PoSt proofs for random
+-- challenges, full reads checksum every file of the copy.
+CREATE TABLE scrub_sealed_checks (
+    check_id BIGSERIAL PRIMARY KEY,
+
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+    storage_id TEXT NOT NULL,
+    file_types INT NOT NULL, -- storiface.SectorFileType bitmask of the files held in the path
+
+    full_read BOOLEAN NOT NULL,
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    complete_time TIMESTAMP WITH TIME ZONE,
+
+    task_id BIGINT,
+
+    ok BOOLEAN,
+    message TEXT
+);
+
+-- at most one pending check per sector copy
+CREATE UNIQUE INDEX scrub_sealed_checks_pending ON scrub_sealed_checks (sp_id, sector_number, storage_id) WHERE complete_time IS NULL;
+CREATE INDEX scrub_sealed_checks_task_id ON scrub_sealed_checks (task_id);
+CREATE INDEX scrub_sealed_checks_copy ON scrub_sealed_checks (sp_id, sector_number, storage_id, full_read, create_time);
+
+-- Checksums recorded by the first full read of a sector copy. For cache
+-- directories the checksum covers all files in name order.
+CREATE TABLE scrub_sealed_checksums (
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+    file_type INT NOT NULL,
+    storage_id TEXT NOT NULL,
+
+    checksum TEXT NOT NULL, -- hex sha256
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+
+    PRIMARY KEY (sp_id, sector_number, file_type, storage_id)
+);
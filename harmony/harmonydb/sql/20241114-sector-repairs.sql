@@ -0,0 +1,27 @@
+-- Repairs of damaged sector file copies. A repair replaces the copy held in
+-- storage_id with a good copy fetched from another path, or for unsealed
+-- copies, regenerates it from the sealed data through the unseal pipeline.
+CREATE TABLE sector_repairs (
+    repair_id BIGSERIAL PRIMARY KEY,
+
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+    storage_id TEXT NOT NULL, -- path holding the damaged copy
+    file_types INT NOT NULL, -- storiface.SectorFileType bitmask
+
+    detected_by TEXT NOT NULL, -- scrub, manual
+    reason TEXT NOT NULL,
+
+    method TEXT, -- fetch or regenerate, set when done
+    source_storage_id TEXT, -- path the good copy was fetched from
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    complete_time TIMESTAMP WITH TIME ZONE,
+
+    task_id BIGINT,
+    error TEXT
+);
+
+-- at most one pending repair per sector copy
+CREATE UNIQUE INDEX sector_repairs_pending ON sector_repairs (sp_id, sector_number, storage_id) WHERE complete_time IS NULL;
+CREATE INDEX sector_repairs_task_id ON sector_repairs (task_id);
@@ -0,0 +1,23 @@
+-- Storage space reservations held by nodes, recorded as leases. The holding node
+-- renews expire_time while the reservation is in use; leases of nodes which went
+-- away expire and are cleaned up by any other node.
+CREATE TABLE storage_reservations (
+    lease_id BIGSERIAL PRIMARY KEY,
+
+    storage_id TEXT NOT NULL,
+    holder TEXT NOT NULL, -- URLs of the node holding the reservation
+
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+    file_type INT NOT NULL, -- storiface.SectorFileType
+    reserved_bytes BIGINT NOT NULL,
+
+    owner_task_id BIGINT, -- harmony task the reservation is held for, if any
+    purpose TEXT NOT NULL,
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    expire_time TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX storage_reservations_storage_id ON storage_reservations (storage_id);
+CREATE INDEX storage_reservations_expire_time ON storage_reservations (expire_time);
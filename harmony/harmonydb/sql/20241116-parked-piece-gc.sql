@@ -0,0 +1,40 @@
+-- Parked pieces are only removed after they have been unreferenced for a grace period, and while no hold
+-- keeps them. unreferenced_at is maintained by a trigger on parked_piece_refs.
+ALTER TABLE parked_pieces ADD COLUMN unreferenced_at TIMESTAMP WITH TIME ZONE;
+
+-- per-piece grace period, NULL uses the cluster default from Storage.PieceGC.GracePeriod
+ALTER TABLE parked_pieces ADD COLUMN gc_grace_period INTERVAL;
+
+UPDATE parked_pieces SET unreferenced_at = current_timestamp
+    WHERE NOT EXISTS (SELECT 1 FROM parked_piece_refs WHERE piece_id = parked_pieces.id);
+
+CREATE OR REPLACE FUNCTION trig_parked_piece_refs_unreferenced() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE parked_pieces SET unreferenced_at = NULL WHERE id = NEW.piece_id AND unreferenced_at IS NOT NULL;
+    ELSIF TG_OP = 'DELETE' THEN
+        UPDATE parked_pieces SET unreferenced_at = current_timestamp
+            WHERE id = OLD.piece_id AND NOT EXISTS (SELECT 1 FROM parked_piece_refs WHERE piece_id = OLD.piece_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trig_parked_piece_refs_unreferenced
+    AFTER INSERT OR DELETE ON parked_piece_refs
+    FOR EACH ROW EXECUTE FUNCTION trig_parked_piece_refs_unreferenced();
+
+-- Holds keep unreferenced pieces from being removed, e.g. while a proof over the piece data is pending.
+-- hold_until NULL holds the piece until the hold is removed.
+CREATE TABLE parked_piece_holds (
+    hold_id BIGSERIAL PRIMARY KEY,
+    piece_id BIGINT NOT NULL,
+
+    reason TEXT NOT NULL,
+    hold_until TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+
+    FOREIGN KEY (piece_id) REFERENCES parked_pieces(id) ON DELETE CASCADE
+);
+
+CREATE INDEX parked_piece_holds_piece_id ON parked_piece_holds (piece_id);
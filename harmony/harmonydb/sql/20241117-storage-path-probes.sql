@@ -0,0 +1,24 @@
+-- Results of storage path health probes. Every node probes every URL of every storage path, so that
+-- problems seen only from some machines (e.g. a flaky NFS mount or network link) are visible.
+CREATE TABLE storage_path_probes (
+    storage_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    prober TEXT NOT NULL, -- address of the probing node
+
+    probe_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    ok BOOLEAN NOT NULL, -- false when the probe failed or was slower than the configured threshold
+
+    latency_ms BIGINT,
+    read_ms BIGINT,
+    read_bytes BIGINT,
+    available BIGINT,
+    error TEXT,
+
+    PRIMARY KEY (storage_id, url, prober),
+    FOREIGN KEY (storage_id) REFERENCES storage_path (storage_id) ON DELETE CASCADE
+);
+
+-- Paths which fail probes from most probing nodes are marked degraded, and are read from only when no
+-- healthy copy of the data exists.
+ALTER TABLE storage_path ADD COLUMN degraded BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE storage_path ADD COLUMN degraded_reason TEXT;
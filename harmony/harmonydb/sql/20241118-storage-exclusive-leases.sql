@@ -0,0 +1,6 @@
+-- Reservations in paths shared by multiple machines (SharedFS in sectorstore.json) are exclusive: only one
+-- node can hold a lease on a sector file in such a path at a time, so machines mounting the same filesystem
+-- don't write or remove the same files concurrently.
+ALTER TABLE storage_reservations ADD COLUMN exclusive BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE UNIQUE INDEX storage_reservations_exclusive ON storage_reservations (storage_id, sp_id, sector_number, file_type) WHERE exclusive;
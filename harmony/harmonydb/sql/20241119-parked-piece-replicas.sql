@@ -0,0 +1,5 @@
+-- Parked pieces can be kept in more than one storage path, see Storage.PieceReplication. replicas is the number
+-- of live copies found by the latest check, replicate_task_id is the task copying the piece to another path.
+ALTER TABLE parked_pieces ADD COLUMN replicas INT;
+ALTER TABLE parked_pieces ADD COLUMN replicas_checked_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE parked_pieces ADD COLUMN replicate_task_id BIGINT;
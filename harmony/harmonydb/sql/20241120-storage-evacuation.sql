@@ -0,0 +1,46 @@
+-- Read-only paths keep serving the data they hold but aren't picked for new data. Set while a path is evacuated.
+ALTER TABLE storage_path ADD COLUMN read_only BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Path evacuations move all data out of a storage path and then detach it, for decommissioning disks.
+-- state is one of:
+--   moving    - moves are being planned and executed for everything left in the path
+--   detaching - the path is empty, nodes which have it attached detach it
+--   done      - the path declaration was removed
+--   cancelled - the evacuation was cancelled, the path is writable again
+CREATE TABLE storage_evacuations (
+    evacuation_id BIGSERIAL PRIMARY KEY,
+    storage_id TEXT NOT NULL,
+
+    state TEXT NOT NULL DEFAULT 'moving',
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    complete_time TIMESTAMP WITH TIME ZONE
+);
+
+-- at most one active evacuation per path
+CREATE UNIQUE INDEX storage_evacuations_active ON storage_evacuations (storage_id) WHERE complete_time IS NULL;
+
+-- Moves of sector and piece files out of an evacuated path, executed by StorageEvacuate tasks on a node with
+-- a local path which can take the files. Files which have a copy in another path are only removed.
+CREATE TABLE storage_evacuation_moves (
+    move_id BIGSERIAL PRIMARY KEY,
+    evacuation_id BIGINT NOT NULL REFERENCES storage_evacuations (evacuation_id) ON DELETE CASCADE,
+
+    sp_id BIGINT NOT NULL, -- 0 for parked pieces
+    sector_number BIGINT NOT NULL,
+    reg_seal_proof INT NOT NULL,
+    file_types INT NOT NULL, -- storiface.SectorFileType bitmask
+
+    method TEXT, -- 'move' or 'drop', set when the move is done
+    to_storage_id TEXT,
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    complete_time TIMESTAMP WITH TIME ZONE,
+
+    task_id BIGINT,
+    error TEXT
+);
+
+-- at most one pending move per sector
+CREATE UNIQUE INDEX storage_evacuation_moves_pending ON storage_evacuation_moves (evacuation_id, sp_id, sector_number) WHERE complete_time IS NULL;
+CREATE INDEX storage_evacuation_moves_task_id ON storage_evacuation_moves (task_id);
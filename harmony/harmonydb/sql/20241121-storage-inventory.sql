@@ -0,0 +1,38 @@
+-- Inventory checks cross-check the storage index against the files in a storage path, or against chain state.
+-- Checks of a path run on a node which has the path attached, chain checks run anywhere.
+CREATE TABLE storage_inventory_checks (
+    check_id BIGSERIAL PRIMARY KEY,
+    storage_id TEXT, -- null for a chain state check
+
+    -- reconcile fixes the index for missing and orphan files found by the check
+    reconcile BOOLEAN NOT NULL DEFAULT FALSE,
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    complete_time TIMESTAMP WITH TIME ZONE,
+
+    task_id BIGINT,
+    error TEXT
+);
+
+-- at most one pending check per path, and one pending chain check
+CREATE UNIQUE INDEX storage_inventory_checks_pending ON storage_inventory_checks (COALESCE(storage_id, '')) WHERE complete_time IS NULL;
+CREATE INDEX storage_inventory_checks_task_id ON storage_inventory_checks (task_id);
+
+-- Problems found by inventory checks. kind is one of:
+--   missing   - the index lists the file in the path, but it isn't on disk
+--   orphan    - the file is on disk, but the index doesn't list it in the path
+--   duplicate - the file is also indexed in another path
+--   lost      - the sector is live on chain, but no path has a complete sealed or updated copy
+CREATE TABLE storage_inventory_issues (
+    check_id BIGINT NOT NULL REFERENCES storage_inventory_checks (check_id) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+
+    sp_id BIGINT NOT NULL, -- 0 for parked pieces
+    sector_num BIGINT NOT NULL,
+    sector_filetype INT NOT NULL, -- storiface.SectorFileType bitmask
+    storage_id TEXT, -- null for lost sectors
+
+    reconciled BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX storage_inventory_issues_check_id ON storage_inventory_issues (check_id);
@@ -0,0 +1,14 @@
+-- Unsealed sector copies kept in the unsealed cache. Cached copies are created on demand, e.g. for retrievals,
+-- and are removed by least recent access once the cache is over its size budget. Sectors in the cache have
+-- target_unseal_state set to TRUE until they are evicted, which sets it to FALSE.
+CREATE TABLE unsealed_cache (
+    sp_id BIGINT NOT NULL,
+    sector_num BIGINT NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    last_access TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+
+    PRIMARY KEY (sp_id, sector_num)
+);
+
+CREATE INDEX unsealed_cache_last_access ON unsealed_cache (last_access);
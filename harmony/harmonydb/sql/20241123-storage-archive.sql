@@ -0,0 +1,3 @@
+-- Read-only archive paths (Archive in sectorstore.json) are read from, but never allocated in, moved out of or
+-- garbage collected. Unlike read_only, which is set while a path is evacuated, the flag comes from the path config.
+ALTER TABLE storage_path ADD COLUMN archive BOOLEAN NOT NULL DEFAULT FALSE;
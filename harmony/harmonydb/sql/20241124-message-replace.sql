@@ -0,0 +1,8 @@
+-- Messages queued in place of an earlier message at the same nonce (fee bumps) point at the
+-- first message sent at that nonce, which is the one message waits are keyed by.
+ALTER TABLE message_sends ADD COLUMN replaces_signed_cid TEXT;
+
+-- The latest replacement queued for a waited message. The wait still resolves through the original CID, chain
+-- lookups match replacements of the same call.
+ALTER TABLE message_waits ADD COLUMN replaced_by_send_task_id BIGINT;
+ALTER TABLE message_waits ADD COLUMN replacements INT NOT NULL DEFAULT 0;
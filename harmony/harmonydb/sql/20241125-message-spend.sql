@@ -0,0 +1,6 @@
+-- Gas paid by executed messages (base fee burn, over-estimation burn and miner
+-- tip), counted against Fees.SpendCaps.
+ALTER TABLE message_waits ADD COLUMN executed_gas_cost NUMERIC(78, 0); -- attoFIL
+ALTER TABLE message_waits ADD COLUMN executed_at TIMESTAMPTZ;
+
+CREATE INDEX message_waits_executed_at_index ON message_waits (executed_at);
@@ -0,0 +1,45 @@
+-- Built-in actor events concerning the configured SPs: miner actor sector events, and market actor deal events
+-- where the SP is the provider. Written by the actor event indexer.
+CREATE TABLE actor_events (
+    sp_id BIGINT NOT NULL,
+    epoch BIGINT NOT NULL, -- height of the tipset including the message which emitted the event
+    tipset_cid TEXT NOT NULL,
+    msg_cid TEXT NOT NULL,
+    event_index INT NOT NULL, -- order of the event among events of the message in the tipset
+
+    event_type TEXT NOT NULL, -- $type entry, e.g. 'sector-activated', 'sector-terminated', 'deal-activated'
+    sector_number BIGINT,
+    deal_id BIGINT,
+    entries JSONB NOT NULL, -- all entries, key to decoded value
+
+    PRIMARY KEY (tipset_cid, msg_cid, event_index)
+);
+
+CREATE INDEX actor_events_sp_epoch_index ON actor_events (sp_id, epoch);
+CREATE INDEX actor_events_type_epoch_index ON actor_events (event_type, epoch);
+
+-- Summary of each closed proving deadline of the configured SPs, taken after the deadline end cron.
+CREATE TABLE actor_deadlines (
+    sp_id BIGINT NOT NULL,
+    proving_period_start BIGINT NOT NULL,
+    deadline BIGINT NOT NULL,
+    close_epoch BIGINT NOT NULL,
+
+    partitions INT NOT NULL,
+    proven_partitions INT NOT NULL, -- partitions with a PoSt submitted before the last epoch of the deadline
+    live_sectors BIGINT NOT NULL,
+    faulty_sectors BIGINT NOT NULL,
+    recovering_sectors BIGINT NOT NULL,
+
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (sp_id, proving_period_start, deadline)
+);
+
+CREATE INDEX actor_deadlines_close_epoch_index ON actor_deadlines (close_epoch);
+
+-- Highest epoch up to which events of each SP were indexed.
+CREATE TABLE actor_event_index_state (
+    sp_id BIGINT PRIMARY KEY,
+    indexed_epoch BIGINT NOT NULL
+);
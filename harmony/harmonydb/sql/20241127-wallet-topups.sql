@@ -0,0 +1,20 @@
+-- Transfers sent by the wallet top-up task to keep worker and control addresses funded.
+CREATE TABLE wallet_topups (
+    id BIGSERIAL PRIMARY KEY,
+
+    source TEXT NOT NULL,
+    target TEXT NOT NULL,
+    sp_id BIGINT NOT NULL, -- miner whose address was topped up
+
+    amount NUMERIC(78, 0) NOT NULL, -- attoFIL
+    balance_before NUMERIC(78, 0) NOT NULL, -- attoFIL, balance of target when the top-up was sent
+    threshold NUMERIC(78, 0) NOT NULL, -- attoFIL
+    limited_by TEXT, -- 'max-transfer' or 'max-daily' when the amount was capped
+
+    signed_cid TEXT NOT NULL, -- message_waits tracks the transfer
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX wallet_topups_source_created_at_index ON wallet_topups (source, created_at);
+CREATE INDEX wallet_topups_target_index ON wallet_topups (target);
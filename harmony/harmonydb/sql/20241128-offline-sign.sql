@@ -0,0 +1,14 @@
+-- Messages from addresses signed offline (Apis.OfflineSignAddresses). The send task of such a message isn't
+-- executed until a signature is imported with 'curio offline-sign import', after which it is broadcast as usual.
+CREATE TABLE message_offline_signs (
+    send_task_id BIGINT NOT NULL,
+    from_key TEXT NOT NULL,
+
+    queued_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    exported_at TIMESTAMPTZ, -- last export, the message nonce is assigned on the first export
+    signed_at TIMESTAMPTZ, -- when the signature was imported
+
+    PRIMARY KEY (send_task_id, from_key)
+);
+
+CREATE INDEX message_offline_signs_pending_index ON message_offline_signs (from_key) WHERE signed_at IS NULL;
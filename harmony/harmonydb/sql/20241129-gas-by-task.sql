@@ -0,0 +1,21 @@
+-- Daily gas of executed messages attributed to the task type which sent them and the SP they were sent for.
+-- Maintained by the ChartRollup task.
+CREATE TABLE chart_gas_task_daily (
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL, -- start of the day the messages landed on chain
+    sp_id BIGINT NOT NULL, -- 0 for messages not sent for an SP
+    task_name TEXT NOT NULL, -- sending task type, the send reason for messages not sent by a task
+
+    messages BIGINT NOT NULL,
+    gas_used BIGINT NOT NULL,
+    gas_cost NUMERIC(78, 0) NOT NULL, -- attoFIL, base fee burn, over-estimation burn and miner tip
+
+    PRIMARY KEY (bucket, sp_id, task_name)
+);
+
+CREATE INDEX chart_gas_task_daily_sp_index ON chart_gas_task_daily (sp_id, bucket);
+
+-- Daily gas by send reason also records what the gas cost. Rebuilt with costs from message history by the next
+-- ChartRollup run.
+ALTER TABLE chart_gas_daily ADD COLUMN gas_cost NUMERIC(78, 0) NOT NULL DEFAULT 0; -- attoFIL
+
+DELETE FROM chart_gas_daily;
@@ -0,0 +1,19 @@
+-- Messages which would have been sent while dry-run was enabled (Subsystems.DryRunSends, Addresses.DryRunSends).
+-- Captured after gas estimation, they are not signed and never broadcast.
+CREATE TABLE message_dry_runs (
+    id BIGSERIAL PRIMARY KEY,
+
+    from_key TEXT NOT NULL,
+    to_addr TEXT NOT NULL,
+    send_reason TEXT NOT NULL,
+    sp_id BIGINT, -- SP the message was sent for, NULL when not known
+
+    unsigned_cid TEXT NOT NULL, -- returned to the sending task in place of the signed message CID
+    unsigned_data BYTEA NOT NULL,
+    unsigned_json JSONB NOT NULL,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX message_dry_runs_created_at_index ON message_dry_runs (created_at);
+CREATE INDEX message_dry_runs_sp_id_index ON message_dry_runs (sp_id, created_at);
@@ -0,0 +1,7 @@
+-- Time at which a sector became ready for its precommit / commit message. Sectors wait up to
+-- Batching.PreCommit.MaxWait / Batching.Commit.MaxWait from then for their batch to fill.
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN precommit_ready_at TIMESTAMPTZ;
+
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN commit_ready_at TIMESTAMPTZ;
@@ -0,0 +1,7 @@
+-- SPs whose sealing pipeline is paused by an operator. Sectors of paused SPs don't start SDR or get precommitted,
+-- sectors already past those stages continue through the pipeline.
+CREATE TABLE sealing_pauses (
+    sp_id BIGINT PRIMARY KEY,
+    reason TEXT NOT NULL DEFAULT '',
+    paused_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
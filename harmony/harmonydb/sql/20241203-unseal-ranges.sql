@@ -0,0 +1,21 @@
+-- Ranges of sector data to unseal on demand, e.g. for retrievals of data without an unsealed copy. Ranges are
+-- decoded into the unsealed file of the sector, a partial file when the sector had no unsealed copy, which is then
+-- kept in the unsealed cache. Offsets and sizes are in padded bytes.
+CREATE TABLE sectors_unseal_ranges (
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+    reg_seal_proof BIGINT NOT NULL,
+
+    piece_offset BIGINT NOT NULL,
+    piece_size BIGINT NOT NULL,
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+
+    task_id BIGINT, -- regenerates the sector key and decodes all pending ranges of the sector
+    after_unseal BOOLEAN NOT NULL DEFAULT FALSE,
+    unsealed_at TIMESTAMP WITH TIME ZONE,
+
+    PRIMARY KEY (sp_id, sector_number, piece_offset, piece_size)
+);
+
+CREATE INDEX sectors_unseal_ranges_task_id ON sectors_unseal_ranges (task_id);
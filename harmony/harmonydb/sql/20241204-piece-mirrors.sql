@@ -0,0 +1,16 @@
+/*
+ * Alternative sources of the data of parked pieces. The ParkPiece task tries the data_url of the piece refs first,
+ * then the mirrors in order of mirror_index, switching sources when one fails mid-download.
+ */
+create table parked_piece_mirrors (
+    piece_id bigint not null,
+    mirror_index int not null,
+
+    data_url text not null,
+    data_headers jsonb not null default '{}',
+
+    primary key (piece_id, mirror_index),
+    unique (piece_id, data_url),
+
+    foreign key (piece_id) references parked_pieces(id) on delete cascade
+);
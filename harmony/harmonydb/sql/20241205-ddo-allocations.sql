@@ -0,0 +1,33 @@
+-- Verified allocations of clients which are being onboarded with direct data onboarding (DDO). Allocations are added
+-- with a claim request, assigned to a sector by the DDOClaim task once their piece is parked, and tracked until the
+-- claim appears on chain or the allocation expires.
+CREATE TABLE ddo_allocations (
+    client_id BIGINT NOT NULL,
+    allocation_id BIGINT NOT NULL,
+    sp_id BIGINT NOT NULL,
+
+    piece_cid TEXT NOT NULL,
+    piece_size BIGINT NOT NULL, -- padded
+    term_min BIGINT NOT NULL,
+    term_max BIGINT NOT NULL,
+    expiration BIGINT NOT NULL, -- epoch by which the allocation must be claimed
+
+    -- pending: waiting for the piece to be parked and assigned to a sector
+    -- assigned: added to a sector being sealed
+    -- claimed: the claim is on chain
+    -- expired: the allocation expired before it was claimed
+    -- failed: assigning to a sector failed, see error
+    state TEXT NOT NULL DEFAULT 'pending',
+    sector_number BIGINT,
+    claim_id BIGINT,
+    error TEXT,
+
+    task_id BIGINT,
+
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+
+    PRIMARY KEY (client_id, allocation_id)
+);
+
+CREATE INDEX ddo_allocations_state ON ddo_allocations (state);
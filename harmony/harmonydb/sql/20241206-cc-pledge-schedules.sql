@@ -0,0 +1,17 @@
+-- CC sector pledge schedules. The CCPledge task adds CC sectors to the sealing pipeline of each SP at the target rate,
+-- while the number of sectors in the pipeline is below max_pipeline, as long as there is storage and collateral for
+-- them.
+CREATE TABLE cc_pledge_schedules (
+    sp_id BIGINT PRIMARY KEY,
+
+    sectors_per_day INT NOT NULL CHECK (sectors_per_day > 0),
+    max_pipeline INT NOT NULL CHECK (max_pipeline > 0),
+    duration_epochs BIGINT, -- NULL for the default sector duration
+
+    -- outcome of the last scheduler run
+    last_run TIMESTAMPTZ,
+    last_pledged INT NOT NULL DEFAULT 0,
+    limited_by TEXT, -- why fewer sectors than scheduled were pledged in the last run, NULL if not limited
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,20 @@
+-- Decisions of the deal filter on deals handed to Curio by market adapters, see Ingest.DealFilter.
+CREATE TABLE market_deal_filter_decisions (
+    id BIGSERIAL PRIMARY KEY,
+
+    sp_id BIGINT NOT NULL,
+    piece_cid TEXT NOT NULL,
+    piece_size BIGINT NOT NULL,
+    deal_id BIGINT, -- NULL for DDO pieces
+    client TEXT, -- NULL for DDO pieces without an allocation
+    start_epoch BIGINT NOT NULL,
+    end_epoch BIGINT NOT NULL,
+
+    accepted BOOLEAN NOT NULL,
+    filter TEXT NOT NULL, -- the rejecting filter (rules, command or http), or all filters of an accepted deal
+    reason TEXT NOT NULL DEFAULT '',
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX market_deal_filter_decisions_created_at ON market_deal_filter_decisions (created_at);
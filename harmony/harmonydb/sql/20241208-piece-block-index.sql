@@ -0,0 +1,29 @@
+-- Locations of the blocks of pieces holding CARs, written by the IndexPiece task. Rows are keyed by multihash first
+-- so that lookups of a block are a single range in the distributed store, whatever the number of pieces.
+CREATE TABLE piece_block_index (
+    multihash BYTEA NOT NULL,
+    piece_cid TEXT NOT NULL,
+
+    block_offset BIGINT NOT NULL, -- offset of the block data in the unpadded piece
+    block_size BIGINT NOT NULL,
+
+    PRIMARY KEY (multihash, piece_cid)
+);
+
+CREATE INDEX piece_block_index_piece_cid ON piece_block_index (piece_cid);
+
+-- Indexing state of parked pieces
+CREATE TABLE piece_index_state (
+    piece_cid TEXT PRIMARY KEY,
+
+    state TEXT NOT NULL DEFAULT 'indexing', -- indexing, indexed, not-car, failed
+    task_id BIGINT,
+
+    blocks BIGINT NOT NULL DEFAULT 0,
+    error TEXT,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    indexed_at TIMESTAMPTZ
+);
+
+CREATE INDEX piece_index_state_task_id ON piece_index_state (task_id);
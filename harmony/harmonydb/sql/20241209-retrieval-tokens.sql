@@ -0,0 +1,15 @@
+-- Tokens of clients of the HTTP retrieval server, see Retrieval.RequireToken. Only token hashes are stored.
+CREATE TABLE retrieval_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    token_hash BYTEA NOT NULL UNIQUE,
+
+    bandwidth_limit BIGINT, -- bytes per second, NULL = Retrieval.ClientBandwidth
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ,
+    last_used TIMESTAMPTZ,
+    revoked BOOLEAN NOT NULL DEFAULT FALSE,
+
+    bytes_served BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,127 @@
+-- Times at which deal pieces reached each stage of the deal pipeline, kept after the sealing pipeline entries of
+-- their sectors are removed. Rows are written by triggers on the sector piece and pipeline tables. Indexing is
+-- tracked in piece_index_state.
+CREATE TABLE market_deal_stages (
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+    piece_index BIGINT NOT NULL,
+
+    piece_cid TEXT NOT NULL,
+    piece_size BIGINT NOT NULL,
+    deal_id BIGINT, -- f05 deal ID, NULL for DDO pieces
+    is_snap BOOLEAN NOT NULL,
+
+    received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp, -- piece assigned to a sector
+    ingested_at TIMESTAMP WITH TIME ZONE, -- piece data written to the sector, TreeD or snap encode done
+    sealed_at TIMESTAMP WITH TIME ZONE, -- PoRep or snap proof computed
+    activated_at TIMESTAMP WITH TIME ZONE, -- commit or prove replica update message landed
+
+    failed_at TIMESTAMP WITH TIME ZONE,
+    failed_stage TEXT, -- ingest, seal or activate
+    failed_reason TEXT,
+
+    PRIMARY KEY (sp_id, sector_number, piece_index)
+);
+
+CREATE INDEX market_deal_stages_received_at ON market_deal_stages (received_at);
+CREATE INDEX market_deal_stages_piece_cid ON market_deal_stages (piece_cid);
+
+CREATE OR REPLACE FUNCTION trig_deal_stages_received() RETURNS TRIGGER AS $$
+DECLARE
+    v_deal_id BIGINT;
+    v_is_snap BOOLEAN;
+BEGIN
+    -- the piece tables have different deal columns, NEW fields are only read for the table they exist in
+    IF TG_TABLE_NAME = 'open_sector_pieces' THEN
+        v_deal_id := NEW.f05_deal_id;
+        v_is_snap := NEW.is_snap;
+    ELSIF TG_TABLE_NAME = 'sectors_sdr_initial_pieces' THEN
+        v_deal_id := NEW.f05_deal_id;
+        v_is_snap := FALSE;
+    ELSE
+        v_is_snap := TRUE;
+    END IF;
+
+    INSERT INTO market_deal_stages (sp_id, sector_number, piece_index, piece_cid, piece_size, deal_id, is_snap)
+    VALUES (NEW.sp_id, NEW.sector_number, NEW.piece_index, NEW.piece_cid, NEW.piece_size, v_deal_id, v_is_snap)
+    ON CONFLICT (sp_id, sector_number, piece_index) DO NOTHING;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- pieces normally enter through open_sector_pieces and are moved to the pipeline piece tables, the other triggers
+-- catch pieces added to sectors directly
+CREATE TRIGGER trig_deal_stages_received
+    AFTER INSERT ON open_sector_pieces
+    FOR EACH ROW EXECUTE FUNCTION trig_deal_stages_received();
+
+CREATE TRIGGER trig_deal_stages_received
+    AFTER INSERT ON sectors_sdr_initial_pieces
+    FOR EACH ROW EXECUTE FUNCTION trig_deal_stages_received();
+
+CREATE TRIGGER trig_deal_stages_received
+    AFTER INSERT ON sectors_snap_initial_pieces
+    FOR EACH ROW EXECUTE FUNCTION trig_deal_stages_received();
+
+-- deal_stages_advance records the pipeline stages a sector reached in an update of its pipeline entry
+CREATE OR REPLACE FUNCTION deal_stages_advance(
+    v_sp_id BIGINT,
+    v_sector_number BIGINT,
+    v_ingested BOOLEAN,
+    v_sealed BOOLEAN,
+    v_activated BOOLEAN,
+    v_failed BOOLEAN,
+    v_reset BOOLEAN,
+    v_failed_reason TEXT
+) RETURNS VOID AS $$
+BEGIN
+    UPDATE market_deal_stages SET
+        ingested_at = CASE WHEN v_ingested THEN COALESCE(ingested_at, current_timestamp) ELSE ingested_at END,
+        sealed_at = CASE WHEN v_sealed THEN COALESCE(sealed_at, current_timestamp) ELSE sealed_at END,
+        activated_at = CASE WHEN v_activated THEN COALESCE(activated_at, current_timestamp) ELSE activated_at END,
+        failed_at = CASE WHEN v_failed THEN current_timestamp WHEN v_reset THEN NULL ELSE failed_at END,
+        failed_stage = CASE
+            WHEN v_failed THEN CASE
+                WHEN ingested_at IS NULL AND NOT v_ingested THEN 'ingest'
+                WHEN sealed_at IS NULL AND NOT v_sealed THEN 'seal'
+                ELSE 'activate' END
+            WHEN v_reset THEN NULL
+            ELSE failed_stage END,
+        failed_reason = CASE WHEN v_failed THEN v_failed_reason WHEN v_reset THEN NULL ELSE failed_reason END
+    WHERE sp_id = v_sp_id AND sector_number = v_sector_number;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION trig_deal_stages_sdr_pipeline() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM deal_stages_advance(NEW.sp_id, NEW.sector_number,
+        NEW.after_tree_d AND NOT OLD.after_tree_d,
+        NEW.after_porep AND NOT OLD.after_porep,
+        NEW.after_commit_msg_success AND NOT OLD.after_commit_msg_success,
+        NEW.failed AND NOT OLD.failed,
+        OLD.failed AND NOT NEW.failed,
+        NEW.failed_reason || ': ' || NEW.failed_reason_msg);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trig_deal_stages_sdr_pipeline
+    AFTER UPDATE ON sectors_sdr_pipeline
+    FOR EACH ROW EXECUTE FUNCTION trig_deal_stages_sdr_pipeline();
+
+CREATE OR REPLACE FUNCTION trig_deal_stages_snap_pipeline() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM deal_stages_advance(NEW.sp_id, NEW.sector_number,
+        NEW.after_encode AND NOT OLD.after_encode,
+        NEW.after_prove AND NOT OLD.after_prove,
+        NEW.after_prove_msg_success AND NOT OLD.after_prove_msg_success,
+        NEW.failed AND NOT OLD.failed,
+        OLD.failed AND NOT NEW.failed,
+        NEW.failed_reason || ': ' || NEW.failed_reason_msg);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trig_deal_stages_snap_pipeline
+    AFTER UPDATE ON sectors_snap_pipeline
+    FOR EACH ROW EXECUTE FUNCTION trig_deal_stages_snap_pipeline();
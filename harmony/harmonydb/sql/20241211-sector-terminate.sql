@@ -0,0 +1,49 @@
+-- Sector terminations requested through the web API. The TerminateSectors task batches pending sectors into
+-- TerminateSectors messages, deferring sectors in deadlines which can't be changed yet, and retries sectors of
+-- messages which failed on chain.
+CREATE TABLE sector_terminate_ops (
+    op_id BIGSERIAL PRIMARY KEY,
+
+    sp_id BIGINT NOT NULL,
+    remove_data BOOLEAN NOT NULL DEFAULT FALSE, -- mark sector files for removal once terminated
+    max_fee TEXT NOT NULL, -- attoFIL, per message
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    complete_time TIMESTAMP WITH TIME ZONE,
+
+    task_id BIGINT,
+    next_attempt TIMESTAMP WITH TIME ZONE, -- when deferred sectors or sent messages are checked again
+
+    error TEXT
+);
+
+CREATE INDEX sector_terminate_ops_task_id ON sector_terminate_ops (task_id);
+
+CREATE TABLE sector_terminate_messages (
+    signed_message_cid TEXT PRIMARY KEY,
+    op_id BIGINT NOT NULL REFERENCES sector_terminate_ops (op_id) ON DELETE CASCADE,
+
+    sectors BIGINT NOT NULL,
+    sent_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+
+    -- set once the message landed
+    exit_code BIGINT,
+    fee_burnt TEXT -- attoFIL, termination fees burnt by the message
+);
+
+CREATE INDEX sector_terminate_messages_op_id ON sector_terminate_messages (op_id);
+
+CREATE TABLE sector_terminate_items (
+    op_id BIGINT NOT NULL REFERENCES sector_terminate_ops (op_id) ON DELETE CASCADE,
+    sector_number BIGINT NOT NULL,
+
+    state TEXT NOT NULL DEFAULT 'pending', -- pending, sent, terminated, skipped, failed
+    attempts INT NOT NULL DEFAULT 0,
+    signed_message_cid TEXT,
+
+    termination_fee TEXT, -- attoFIL, simulated fee of terminating the sector alone when its message was sent
+    terminated_at TIMESTAMP WITH TIME ZONE,
+    error TEXT,
+
+    PRIMARY KEY (op_id, sector_number)
+);
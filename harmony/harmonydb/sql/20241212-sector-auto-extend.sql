@@ -0,0 +1,32 @@
+-- Automatic sector extension policies. The AutoExtend task scans sectors of each SP with a policy which expire within
+-- window_days, estimates the reward of extending each one against its share of the message fees, and queues an
+-- extension of the sectors worth extending in sector_extend_ops, executed by the ExtendSectors task.
+CREATE TABLE sector_auto_extend_policies (
+    sp_id BIGINT PRIMARY KEY,
+
+    window_days INT NOT NULL CHECK (window_days > 0),
+    extension_days INT NOT NULL CHECK (extension_days > 0),
+
+    -- sectors are extended when the expected block reward over the extension is at least this multiple of the fees
+    min_reward_ratio DOUBLE PRECISION NOT NULL DEFAULT 1 CHECK (min_reward_ratio >= 0),
+
+    include_cc BOOLEAN NOT NULL DEFAULT TRUE,
+    include_deals BOOLEAN NOT NULL DEFAULT TRUE,
+    drop_claims BOOLEAN NOT NULL DEFAULT FALSE, -- drop verified claims ending before the new expiration, where allowed
+
+    max_sectors_per_run INT NOT NULL DEFAULT 10000 CHECK (max_sectors_per_run > 0),
+
+    -- outcome of the last run
+    last_run TIMESTAMPTZ,
+    last_candidates INT NOT NULL DEFAULT 0,
+    last_queued INT NOT NULL DEFAULT 0,
+    last_unprofitable INT NOT NULL DEFAULT 0,
+    last_skipped INT NOT NULL DEFAULT 0, -- sectors which can't be extended under the policy
+    last_op_id BIGINT,
+    last_error TEXT,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- extensions queued by the AutoExtend task
+ALTER TABLE sector_extend_ops ADD COLUMN auto_extend BOOLEAN NOT NULL DEFAULT FALSE;
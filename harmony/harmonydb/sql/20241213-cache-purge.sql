@@ -0,0 +1,37 @@
+-- Sealing intermediates of SDR sectors, recorded by the Finalize task. Parts removed at finalize are recorded as
+-- purged right away, parts kept by the Seal.CachePurge config are removed by the PurgeCache task once due_at passes.
+-- The space of each part is kept for the reclaim report.
+CREATE TABLE sector_cache_purges (
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+    part TEXT NOT NULL, -- layers, trees or unsealed
+
+    bytes BIGINT NOT NULL,
+    delay_seconds BIGINT, -- purge delay after the commit message landed, NULL for parts purged at finalize
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    due_at TIMESTAMPTZ, -- set once the commit message landed
+
+    task_id BIGINT,
+    purged_at TIMESTAMPTZ,
+    note TEXT,
+
+    PRIMARY KEY (sp_id, sector_number, part),
+    FOREIGN KEY (sp_id, sector_number) REFERENCES sectors_sdr_pipeline (sp_id, sector_number) ON DELETE CASCADE
+);
+
+CREATE INDEX sector_cache_purges_due ON sector_cache_purges (due_at) WHERE purged_at IS NULL;
+CREATE INDEX sector_cache_purges_task_id ON sector_cache_purges (task_id);
+
+CREATE OR REPLACE FUNCTION trig_cache_purges_commit_landed() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE sector_cache_purges SET due_at = current_timestamp + make_interval(secs => delay_seconds)
+    WHERE sp_id = NEW.sp_id AND sector_number = NEW.sector_number AND due_at IS NULL AND purged_at IS NULL;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trig_cache_purges_commit_landed
+    AFTER UPDATE OF after_commit_msg_success ON sectors_sdr_pipeline
+    FOR EACH ROW WHEN (NEW.after_commit_msg_success AND NOT OLD.after_commit_msg_success)
+    EXECUTE FUNCTION trig_cache_purges_commit_landed();
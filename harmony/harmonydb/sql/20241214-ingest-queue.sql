@@ -0,0 +1,45 @@
+-- SPs taking pieces from the shared ingest queue, with the pieces they accept and their pipeline capacity
+CREATE TABLE ingest_queue_providers (
+    sp_id BIGINT PRIMARY KEY,
+
+    region TEXT, -- NULL only takes pieces without a region
+    min_duration_epochs BIGINT NOT NULL,
+    max_duration_epochs BIGINT NOT NULL,
+    max_pipeline BIGINT NOT NULL, -- sectors in the pipeline, including open deal sectors, above which no pieces are assigned
+
+    last_assigned_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+-- Pieces waiting to be assigned to one of the ingest queue providers
+CREATE TABLE ingest_queue (
+    id BIGSERIAL PRIMARY KEY,
+
+    piece_cid TEXT NOT NULL,
+    piece_size BIGINT NOT NULL, -- padded
+    raw_size BIGINT NOT NULL,
+    data_url TEXT NOT NULL,
+    data_headers JSONB NOT NULL DEFAULT '{}',
+    keep_unsealed BOOLEAN NOT NULL DEFAULT TRUE,
+
+    duration_epochs BIGINT NOT NULL,
+    region TEXT, -- NULL for any provider
+
+    -- queued: waiting for a provider with capacity
+    -- assigned: added to a sector of sp_id
+    -- failed: adding to a sector failed, see error
+    state TEXT NOT NULL DEFAULT 'queued',
+    sp_id BIGINT,
+    sector_number BIGINT,
+    attempted_at TIMESTAMPTZ,
+    waiting_reason TEXT, -- why a queued piece wasn't assigned on the last attempt
+    error TEXT,
+
+    task_id BIGINT,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    assigned_at TIMESTAMPTZ
+);
+
+CREATE INDEX ingest_queue_state ON ingest_queue (state, created_at);
+CREATE INDEX ingest_queue_task_id ON ingest_queue (task_id);
@@ -0,0 +1,2 @@
+-- Huge page and NUMA setup of a machine with the outcome of its memory pre-flight checks, see hugepageutil.MemoryStatus
+ALTER TABLE harmony_machine_details ADD COLUMN memory_status JSONB;
@@ -0,0 +1,55 @@
+-- Imports of lotus-miner sector metadata and storage layout, staged by `curio seal lm-import start`
+CREATE TABLE lm_imports (
+    import_id BIGSERIAL PRIMARY KEY,
+    sp_id BIGINT NOT NULL,
+
+    miner_repo TEXT NOT NULL,
+    staged_by TEXT NOT NULL, -- host which read the lotus-miner repo
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    sectors_done_at TIMESTAMPTZ, -- all staged sectors were validated and imported, or rejected
+    completed_at TIMESTAMPTZ, -- sectors done and all storage paths redeclared
+
+    task_id BIGINT -- LMImport task importing the pending sectors
+);
+
+CREATE INDEX lm_imports_task_id ON lm_imports (task_id);
+
+-- Sectors of an import with the outcome of their validation, which is the audit trail of the import
+CREATE TABLE lm_import_sectors (
+    import_id BIGINT NOT NULL REFERENCES lm_imports (import_id) ON DELETE CASCADE,
+    sector_num BIGINT NOT NULL,
+
+    lm_state TEXT NOT NULL, -- sector state in lotus-miner
+    meta JSONB NOT NULL, -- sectors_meta and sectors_meta_pieces rows to import
+
+    -- pending: waiting for validation
+    -- imported: matched chain state and was written to sectors_meta
+    -- invalid: didn't match chain state, see error
+    -- skipped: not in a state which can be imported, see error
+    status TEXT NOT NULL DEFAULT 'pending',
+    error TEXT,
+    validated_at TIMESTAMPTZ,
+
+    PRIMARY KEY (import_id, sector_num)
+);
+
+CREATE INDEX lm_import_sectors_pending ON lm_import_sectors (import_id) WHERE status = 'pending';
+
+-- Storage paths of the lotus-miner, redeclared once attached to a Curio node and the sectors are imported
+CREATE TABLE lm_import_storage (
+    import_id BIGINT NOT NULL REFERENCES lm_imports (import_id) ON DELETE CASCADE,
+    storage_id TEXT NOT NULL,
+
+    path TEXT NOT NULL, -- path on the lotus-miner host
+    can_seal BOOLEAN NOT NULL,
+    can_store BOOLEAN NOT NULL,
+
+    redeclared_at TIMESTAMPTZ,
+    redeclared_by TEXT, -- host of the node which scanned the path
+    error TEXT,
+
+    task_id BIGINT, -- LMImportRedeclare task
+
+    PRIMARY KEY (import_id, storage_id)
+);
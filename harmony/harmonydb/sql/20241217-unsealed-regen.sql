@@ -0,0 +1,25 @@
+-- Requests to rebuild a missing unsealed copy of a sector, made by hand or by the automatic scan of sectors which
+-- should have an unsealed copy
+CREATE TABLE unsealed_regens (
+    regen_id BIGSERIAL PRIMARY KEY,
+
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+
+    requested_by TEXT NOT NULL, -- manual, auto
+    reason TEXT NOT NULL DEFAULT '',
+
+    -- present: an unsealed copy was found, nothing was done
+    -- fetch: rebuilt from the parked copies of the piece data
+    -- unseal: queued in the unseal pipeline, which decodes the sealed data with the sector key
+    method TEXT,
+    error TEXT,
+
+    create_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+    complete_time TIMESTAMP WITH TIME ZONE,
+
+    task_id BIGINT
+);
+
+CREATE UNIQUE INDEX unsealed_regens_open ON unsealed_regens (sp_id, sector_number) WHERE complete_time IS NULL;
+CREATE INDEX unsealed_regens_task_id ON unsealed_regens (task_id);
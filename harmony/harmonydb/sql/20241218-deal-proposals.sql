@@ -0,0 +1,29 @@
+-- Validation of deal proposals handed to Curio by market adapters. With Ingest.OffloadDealValidation set, proposals
+-- are validated by DealValidate tasks on any node with Subsystems.EnableDealValidation, the adapter waits for the
+-- result; otherwise the adapter validates them itself and records the result right away.
+CREATE TABLE market_deal_proposals (
+    proposal_id BIGSERIAL PRIMARY KEY,
+
+    sp_id BIGINT NOT NULL,
+    piece_cid TEXT NOT NULL,
+    piece_size BIGINT NOT NULL,
+    deal_id BIGINT, -- NULL for DDO pieces and proposals which aren't published yet
+    client TEXT, -- as given in the proposal, NULL for DDO pieces without an allocation
+    verified BOOLEAN NOT NULL DEFAULT FALSE,
+
+    proposal JSONB NOT NULL, -- the PieceDealInfo of the deal
+    client_signature BYTEA, -- signature of the deal proposal, required for proposals which aren't published yet
+
+    create_time TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    -- result
+    client_id BIGINT, -- the resolved client
+    valid BOOLEAN,
+    reason TEXT,
+    validated_at TIMESTAMPTZ,
+
+    task_id BIGINT
+);
+
+CREATE INDEX market_deal_proposals_pending ON market_deal_proposals (proposal_id) WHERE validated_at IS NULL AND task_id IS NULL;
+CREATE INDEX market_deal_proposals_task_id ON market_deal_proposals (task_id);
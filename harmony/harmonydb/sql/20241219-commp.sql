@@ -0,0 +1,41 @@
+-- Piece commitment (CommP) calculations of ingested data, done by CommP tasks on any node with
+-- Subsystems.EnableCommP, see 'curio market commp'. Data in a storage path is read by a node the path is attached to.
+CREATE TABLE commp_requests (
+    request_id BIGSERIAL PRIMARY KEY,
+
+    -- source of the data, either an URL or a file in a storage path
+    data_url TEXT,
+    data_headers JSONB NOT NULL DEFAULT '{}',
+    storage_id TEXT,
+    storage_file TEXT, -- relative to the root of the storage path
+
+    -- expected raw size and sha256 of the data, when known
+    raw_size BIGINT,
+    content_hash TEXT,
+
+    create_time TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    -- result
+    piece_cid TEXT,
+    piece_size BIGINT,
+    cached BOOLEAN NOT NULL DEFAULT FALSE, -- the result was found in commp_cache
+    error TEXT,
+    complete_time TIMESTAMPTZ,
+
+    task_id BIGINT,
+
+    CHECK ((data_url IS NULL) <> (storage_id IS NULL))
+);
+
+CREATE INDEX commp_requests_task_id ON commp_requests (task_id);
+
+-- Computed piece commitments by the sha256 of the data, so that data which was seen before isn't read again
+CREATE TABLE commp_cache (
+    content_hash TEXT PRIMARY KEY,
+    raw_size BIGINT NOT NULL,
+
+    piece_cid TEXT NOT NULL,
+    piece_size BIGINT NOT NULL,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
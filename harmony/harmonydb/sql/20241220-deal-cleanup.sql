@@ -0,0 +1,46 @@
+-- Cleanup policies for sectors whose deals all ended. Such sectors earn like CC sectors while holding data nobody
+-- pays for anymore. The DealCleanup task finds them for each SP with a policy and records them in
+-- sector_deal_cleanup_candidates. Depending on the action of the policy the sectors are only reported, kept from
+-- automatic extension so that they expire and their files are removed by storage GC, or queued for termination in
+-- sector_terminate_ops with their files marked for removal.
+CREATE TABLE sector_deal_cleanup_policies (
+    sp_id BIGINT PRIMARY KEY,
+
+    action TEXT NOT NULL DEFAULT 'report' CHECK (action IN ('report', 'expire', 'terminate')),
+
+    -- sectors become candidates this many days after their last deal ended
+    grace_days INT NOT NULL DEFAULT 0 CHECK (grace_days >= 0),
+
+    -- terminate: sectors with a higher simulated termination fee are only reported, attoFIL, NULL for no limit
+    max_termination_fee TEXT,
+
+    max_sectors_per_run INT NOT NULL DEFAULT 1000 CHECK (max_sectors_per_run > 0),
+
+    -- outcome of the last run
+    last_run TIMESTAMPTZ,
+    last_candidates INT NOT NULL DEFAULT 0,
+    last_acted INT NOT NULL DEFAULT 0, -- sectors newly kept from extension or queued for termination
+    last_op_id BIGINT,
+    last_error TEXT,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE sector_deal_cleanup_candidates (
+    sp_id BIGINT NOT NULL,
+    sector_num BIGINT NOT NULL,
+
+    pieces INT NOT NULL,
+    deals_end_epoch BIGINT NOT NULL, -- end epoch of the last deal of the sector
+    expiration_epoch BIGINT NOT NULL,
+
+    -- report candidates are found again on every run, expire and terminate candidates are kept
+    action TEXT NOT NULL CHECK (action IN ('report', 'expire', 'terminate')),
+    terminate_op_id BIGINT,
+    note TEXT, -- why a sector is only reported under a terminate policy
+
+    found_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    acted_at TIMESTAMPTZ,
+
+    PRIMARY KEY (sp_id, sector_num)
+);
@@ -0,0 +1,26 @@
+-- Deals counted against the ingest limits of their client (Ingest.ClientLimits). Clients are deal client ID
+-- addresses, e.g. 'f01234', or 'token:<name>' for pieces added through the web API with a token.
+-- Rows are added when a deal is accepted by a market adapter or a piece is added to the ingest queue, and removed
+-- 30 days later.
+CREATE TABLE market_client_ingest (
+    id BIGSERIAL PRIMARY KEY,
+
+    client TEXT NOT NULL,
+    sp_id BIGINT, -- NULL for pieces in the ingest queue which aren't assigned yet
+    piece_cid TEXT NOT NULL,
+    piece_size BIGINT NOT NULL, -- padded
+    raw_size BIGINT NOT NULL,
+    start_epoch BIGINT, -- deals stop counting as queued once they can't start anymore
+
+    create_time TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    -- FALSE while the adapter receives the deal data
+    transferred BOOLEAN NOT NULL DEFAULT FALSE,
+    transfer_end TIMESTAMPTZ,
+
+    -- set when the deal wasn't added to a sector, it no longer counts as queued
+    failed BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX market_client_ingest_client ON market_client_ingest (client, create_time);
+CREATE INDEX market_client_ingest_create_time ON market_client_ingest (create_time);
@@ -0,0 +1,56 @@
+-- Who piece data is transferred for, set by market adapters on the refs they add. Refs added otherwise have NULLs.
+ALTER TABLE parked_piece_refs ADD COLUMN client TEXT; -- deal client ID address
+ALTER TABLE parked_piece_refs ADD COLUMN sp_id BIGINT;
+ALTER TABLE parked_piece_refs ADD COLUMN deal_id BIGINT; -- NULL for DDO pieces
+
+-- Inbound piece data transfers, one row per ParkPiece attempt, attributed to the first ref of the piece with a
+-- client. Maintained by ParkPiece, rolled up into the chart_transfer_* tables and removed after 30 days by the
+-- ChartRollup task.
+CREATE TABLE piece_transfers (
+    id BIGSERIAL PRIMARY KEY,
+
+    task_id BIGINT NOT NULL,
+    piece_id BIGINT NOT NULL,
+    piece_cid TEXT NOT NULL,
+    machine TEXT NOT NULL, -- host_and_port of the machine receiving the data
+
+    client TEXT,
+    sp_id BIGINT,
+    deal_id BIGINT,
+
+    bytes BIGINT NOT NULL, -- received, including data of failed attempts
+    start_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    success BOOLEAN NOT NULL
+);
+
+CREATE INDEX piece_transfers_end_time ON piece_transfers (end_time);
+
+CREATE TABLE chart_transfer_hourly (
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL, -- start of the hour the transfers ended
+    client TEXT NOT NULL, -- '' for unattributed transfers
+    sp_id BIGINT NOT NULL, -- 0 for unattributed transfers
+    machine TEXT NOT NULL,
+
+    transfers BIGINT NOT NULL,
+    failed BIGINT NOT NULL,
+    bytes BIGINT NOT NULL,
+    transfer_s DOUBLE PRECISION NOT NULL, -- total duration of the transfers
+
+    PRIMARY KEY (bucket, client, sp_id, machine)
+);
+
+CREATE TABLE chart_transfer_deals_daily (
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL, -- start of the day the transfers ended
+    sp_id BIGINT NOT NULL, -- 0 for unattributed transfers
+    piece_cid TEXT NOT NULL,
+    deal_id BIGINT NOT NULL, -- 0 for DDO pieces and unattributed transfers
+    client TEXT NOT NULL,
+
+    transfers BIGINT NOT NULL,
+    bytes BIGINT NOT NULL,
+
+    PRIMARY KEY (bucket, sp_id, piece_cid, deal_id)
+);
+
+CREATE INDEX chart_transfer_deals_daily_client ON chart_transfer_deals_daily (client, bucket);
@@ -0,0 +1,44 @@
+-- Storage deals proposed to Curio by clients over the libp2p deal protocol. Accepted deals wait for their data to be
+-- parked, are published in batches by the MK12Publish task and added to sectors by the MK12AddDeal task.
+CREATE TABLE market_mk12_deals (
+    uuid TEXT PRIMARY KEY,
+    sp_id BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT current_timestamp,
+
+    client TEXT NOT NULL, -- client address from the proposal
+    proposal JSONB NOT NULL, -- market.DealProposal
+    proposal_cid TEXT NOT NULL, -- cid of the signed proposal
+    client_signature BYTEA NOT NULL,
+
+    piece_cid TEXT NOT NULL,
+    piece_size BIGINT NOT NULL,
+    raw_size BIGINT NOT NULL, -- transfer size
+    start_epoch BIGINT NOT NULL,
+    end_epoch BIGINT NOT NULL,
+    keep_unsealed BOOLEAN NOT NULL,
+
+    piece_ref BIGINT, -- parked_piece_refs.ref_id of the deal data, NULL once the deal failed
+    limit_id BIGINT, -- market_client_ingest entry counting the deal against the client limits
+
+    publish_task_id BIGINT,
+    publish_cid TEXT,
+    publish_index INT, -- index of the deal in the PublishStorageDeals message
+    add_task_id BIGINT,
+
+    deal_id BIGINT,
+    sector_number BIGINT,
+    sector_offset BIGINT,
+
+    error TEXT, -- set when the deal failed
+    complete BOOLEAN NOT NULL DEFAULT FALSE -- added to a sector, or failed
+);
+
+CREATE INDEX market_mk12_deals_pending ON market_mk12_deals (sp_id, created_at) WHERE NOT complete;
+CREATE INDEX market_mk12_deals_publish_cid ON market_mk12_deals (publish_cid);
+
+-- The libp2p identity of the deal protocol listeners, shared by all nodes of the cluster so that the peer ID
+-- published on chain reaches any of them.
+CREATE TABLE market_libp2p_key (
+    singleton BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (singleton),
+    priv_key BYTEA NOT NULL -- marshaled libp2p private key
+);
@@ -0,0 +1,34 @@
+-- Renewals of storage deals which are about to end. The MK12Renewal task finds f05 deals of the miners accepting
+-- libp2p deals which end within Ingest.Libp2p.Renewal.Window while their data is still held, parked or in an unsealed
+-- sector copy, and records an offer for each: an unsigned proposal for the same piece, client, price and duration
+-- with a new schedule. A client renews by proposing an offline deal for the piece, the renewal deal is recorded in
+-- market_mk12_deals and sealed into a new sector from the held data, which is parked again from the unsealed sector
+-- copy by the MK12RenewPark task when it isn't parked anymore.
+CREATE TABLE market_deal_renewals (
+    id BIGSERIAL PRIMARY KEY,
+    sp_id BIGINT NOT NULL,
+    client TEXT NOT NULL, -- client ID address
+    piece_cid TEXT NOT NULL,
+    piece_size BIGINT NOT NULL, -- padded size
+
+    -- the expiring deal, NULL for renewals proposed by clients without an offer
+    deal_id BIGINT,
+    sector_num BIGINT,
+    deal_end_epoch BIGINT,
+
+    offer JSONB, -- market.DealProposal offered to the client, unsigned
+    offer_start_epoch BIGINT,
+
+    state TEXT NOT NULL DEFAULT 'offered' CHECK (state IN ('offered', 'accepted', 'declined', 'expired')),
+
+    -- accepted renewals
+    deal_uuid TEXT, -- market_mk12_deals.uuid of the renewal deal
+    needs_park BOOLEAN NOT NULL DEFAULT FALSE, -- the piece has to be parked from an unsealed sector copy
+    park_task_id BIGINT,
+
+    found_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    accepted_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX market_deal_renewals_deal ON market_deal_renewals (sp_id, deal_id) WHERE deal_id IS NOT NULL;
+CREATE INDEX market_deal_renewals_offered ON market_deal_renewals (sp_id, client, piece_cid) WHERE state = 'offered';
@@ -0,0 +1,4 @@
+-- Times a sector failed to be prepared for a commit batch and was put back for another batch. The sector is
+-- marked failed once this reaches MaxCommitBatchFailures.
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN commit_batch_failures INT NOT NULL DEFAULT 0;
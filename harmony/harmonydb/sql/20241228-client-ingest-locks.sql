@@ -0,0 +1,5 @@
+-- One row per client with ingest limits. Counting a deal against the limits of its client locks the row of the
+-- client, so concurrent deals of a client are checked against the limits one after the other.
+CREATE TABLE market_client_ingest_locks (
+    client TEXT PRIMARY KEY
+);
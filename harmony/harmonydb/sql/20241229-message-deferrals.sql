@@ -0,0 +1,22 @@
+-- Messages queued by Sender.Send without waiting for them to be sent, e.g. held by a send window or spend cap.
+-- Send returns held_cid, the unsigned CID at queue time, in place of the signed CID, and message waits are keyed
+-- by it.
+ALTER TABLE message_sends ADD COLUMN held_cid TEXT;
+-- MessageSendSpec.MaxFee of held messages (attoFIL), bounding their gas when they're estimated again at release.
+ALTER TABLE message_sends ADD COLUMN max_fee NUMERIC(78, 0);
+
+CREATE INDEX message_sends_held_cid_index ON message_sends (held_cid);
+
+-- Messages deferred by a send window (Fees.SendWindows) or a reached spend cap (Fees.SpendCaps). The send task of
+-- such a message isn't executed until the window opens or the message waited for the window's MaxDelay, and the
+-- spend is back under the cap.
+CREATE TABLE message_deferrals (
+    send_task_id BIGINT NOT NULL,
+    from_key TEXT NOT NULL,
+    send_reason TEXT NOT NULL,
+
+    queued_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    queued_base_fee NUMERIC(78, 0), -- attoFIL, NULL when the window doesn't check the base fee
+
+    PRIMARY KEY (send_task_id, from_key)
+);
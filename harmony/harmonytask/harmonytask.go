@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -130,6 +131,14 @@ type TaskEngine struct {
 	lastFollowTime time.Time
 	lastCleanup    atomic.Value
 	WorkOrigin     string
+
+	restartOnce sync.Once
+	restartCh   chan struct{}
+
+	// operator controls of this machine, re-read every FOLLOW_FREQUENCY by the poller
+	machineStateTime time.Time
+	unschedulable    bool
+	restartRequest   *time.Time
 }
 type followStruct struct {
 	f    func(TaskID, AddTaskFunc) (bool, error)
@@ -161,6 +170,7 @@ func New(
 		taskMap:     make(map[string]*taskTypeHandler, len(impls)),
 		follows:     make(map[string][]followStruct),
 		hostAndPort: hostnameAndPort,
+		restartCh:   make(chan struct{}),
 	}
 	e.lastCleanup.Store(time.Now())
 	for _, c := range impls {
@@ -282,9 +292,11 @@ func (e *TaskEngine) poller() {
 		}
 		nextWait = POLL_DURATION
 
-		accepted := e.pollerTryAllWork()
-		if accepted {
-			nextWait = POLL_NEXT_DURATION
+		if e.schedulable() {
+			accepted := e.pollerTryAllWork()
+			if accepted {
+				nextWait = POLL_NEXT_DURATION
+			}
 		}
 		if time.Since(e.lastFollowTime) > FOLLOW_FREQUENCY {
 			e.followWorkInDB()
@@ -308,6 +320,45 @@ func (e *TaskEngine) poller() {
 	}
 }
 
+// schedulable checks the operator controls for this machine, reading them from harmony_machines every
+// FOLLOW_FREQUENCY.
+// Cordoned machines finish their running tasks but don't pick up new ones. A restart
+// request implies a cordon; once no tasks are running RestartRequested is signalled.
+func (e *TaskEngine) schedulable() bool {
+	if time.Since(e.machineStateTime) > FOLLOW_FREQUENCY {
+		var unschedulable bool
+		var restartRequest *time.Time
+		err := e.db.QueryRow(e.ctx, `SELECT unschedulable, restart_request FROM harmony_machines WHERE id=$1`, e.ownerID).Scan(&unschedulable, &restartRequest)
+		if err != nil {
+			log.Errorw("Could not read machine scheduling state", "error", err)
+			// don't stop working because of a transient DB error, keep the last known state
+		} else {
+			e.machineStateTime = time.Now()
+			e.unschedulable, e.restartRequest = unschedulable, restartRequest
+		}
+	}
+
+	unschedulable, restartRequest := e.unschedulable, e.restartRequest
+	if restartRequest != nil {
+		running := lo.SumBy(e.handlers, func(h *taskTypeHandler) int { return h.Max.ActiveThis() })
+		if running == 0 {
+			e.restartOnce.Do(func() {
+				log.Warnw("Restart requested and no tasks running, requesting shutdown", "requested", *restartRequest)
+				close(e.restartCh)
+			})
+		}
+		return false
+	}
+
+	return !unschedulable
+}
+
+// RestartRequested returns a channel which is closed when an operator requested a
+// restart of this machine and all running tasks have finished.
+func (e *TaskEngine) RestartRequested() <-chan struct{} {
+	return e.restartCh
+}
+
 // followWorkInDB implements "Follows"
 func (e *TaskEngine) followWorkInDB() {
 	// Step 1: What are we following?
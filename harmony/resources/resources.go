@@ -59,7 +59,7 @@ func Register(db *harmonydb.DB, hostnameAndPort string) (*Reg, error) {
 		err := db.QueryRow(ctx, `
 			WITH upsert AS (
 				UPDATE harmony_machines
-				SET cpu = $2, ram = $3, gpu = $4, last_contact = CURRENT_TIMESTAMP
+				SET cpu = $2, ram = $3, gpu = $4, last_contact = CURRENT_TIMESTAMP, restart_request = NULL
 				WHERE host_and_port = $1
 				RETURNING id
 			),
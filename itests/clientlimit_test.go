@@ -0,0 +1,104 @@
+package itests
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/market/clientlimit"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// clientLimitAPI has a single-tipset chain, clients are all ID addresses
+type clientLimitAPI struct{}
+
+func (clientLimitAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	empty := cid.MustParse("bafkqaaa") // identity CID of no data
+	return types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 builtin.SystemActorAddr,
+		Height:                100,
+		ParentStateRoot:       empty,
+		ParentMessageReceipts: empty,
+		Messages:              empty,
+	}})
+}
+
+func (clientLimitAPI) StateLookupID(_ context.Context, addr address.Address, _ types.TipSetKey) (address.Address, error) {
+	return address.Undef, xerrors.Errorf("actor %s not found", addr)
+}
+
+func TestClientLimitBeginConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := harmonydb.NewFromConfigWithITestID(t, harmonydb.ITestNewID())
+	require.NoError(t, err)
+
+	l, err := clientlimit.New(db, clientLimitAPI{}, []config.ClientIngestLimit{{Clients: []string{"f01234"}, MaxQueuedDeals: 3}})
+	require.NoError(t, err)
+
+	deal := func(i int) clientlimit.Deal {
+		return clientlimit.Deal{
+			Client:    "f01234",
+			SpID:      1000,
+			PieceCID:  "baga6ea4seaqpiece" + string(rune('a'+i)),
+			PieceSize: abi.PaddedPieceSize(2048),
+			RawSize:   2000,
+		}
+	}
+
+	// deals begun at the same time are counted one after the other, so no more than the limit are accepted
+	type began struct {
+		id     int64
+		reason string
+		err    error
+	}
+	results := make([]began, 10)
+
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, reason, err := l.Begin(ctx, deal(i))
+			results[i] = began{id: id, reason: reason, err: err}
+		}(i)
+	}
+	wg.Wait()
+
+	var accepted, rejected int
+	for _, r := range results {
+		require.NoError(t, r.err)
+		if r.reason == "" {
+			require.NotZero(t, r.id)
+			accepted++
+		} else {
+			require.Contains(t, r.reason, "deals queued")
+			rejected++
+		}
+	}
+	require.Equal(t, 3, accepted)
+	require.Equal(t, 7, rejected)
+
+	// clients without a rule aren't counted
+	id, reason, err := l.Begin(ctx, clientlimit.Deal{Client: "f05", PieceCID: "baga6ea4seaqother"})
+	require.NoError(t, err)
+	require.Empty(t, reason)
+	require.Zero(t, id)
+
+	usage, err := clientlimit.ClientUsage(ctx, db, 0)
+	require.NoError(t, err)
+	require.Len(t, usage, 1)
+	require.Equal(t, int64(3), usage[0].Queued)
+	require.Equal(t, int64(6000), usage[0].BytesDay)
+}
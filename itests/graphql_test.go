@@ -0,0 +1,87 @@
+package itests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/web/api/graphql"
+)
+
+type graphqlResponse struct {
+	Data   json.RawMessage
+	Errors []struct{ Message string }
+}
+
+func graphqlQuery(t *testing.T, h http.Handler, query string) graphqlResponse {
+	body, err := json.Marshal(map[string]string{"query": query})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res graphqlResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	return res
+}
+
+func TestGraphQLResolvers(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := harmonydb.NewFromConfigWithITestID(t, harmonydb.ITestNewID())
+	require.NoError(t, err)
+
+	var machineID int32
+	err = db.QueryRow(ctx, `INSERT INTO harmony_machines (host_and_port, cpu, ram, gpu) VALUES ('10.0.0.1:12300', 16, 1073741824, 0)
+		RETURNING id`).Scan(&machineID)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = db.Exec(ctx, `INSERT INTO harmony_task (name, posted_time, added_by, owner_id) VALUES ('SDR', current_timestamp, $1, $1)`, machineID)
+		require.NoError(t, err)
+	}
+
+	var out struct {
+		Machines []struct {
+			ID      int32
+			Address string
+			RAM     int64
+			Tasks   []struct {
+				Name  string
+				Owner struct{ ID int32 }
+			}
+		}
+		Tasks []struct{ ID int32 }
+	}
+	res := graphqlQuery(t, graphql.Handler(db, 20000), `{
+		machines { id address ram tasks { name owner { id } } }
+		tasks(limit: 2, offset: 1) { id }
+	}`)
+	require.Empty(t, res.Errors)
+	require.NoError(t, json.Unmarshal(res.Data, &out))
+
+	require.Len(t, out.Machines, 1)
+	require.Equal(t, machineID, out.Machines[0].ID)
+	require.Equal(t, "10.0.0.1:12300", out.Machines[0].Address)
+	require.Equal(t, int64(1073741824), out.Machines[0].RAM)
+	require.Len(t, out.Machines[0].Tasks, 3)
+	for _, tk := range out.Machines[0].Tasks {
+		require.Equal(t, "SDR", tk.Name)
+		require.Equal(t, machineID, tk.Owner.ID)
+	}
+	require.Len(t, out.Tasks, 2)
+
+	// machines: 1 query + 1 row, tasks: 1 query + 3 rows, owners: 3 queries + 3 rows
+	res = graphqlQuery(t, graphql.Handler(db, 12), `{ machines { tasks { owner { id } } } }`)
+	require.Empty(t, res.Errors)
+
+	res = graphqlQuery(t, graphql.Handler(db, 11), `{ machines { tasks { owner { id } } } }`)
+	require.NotEmpty(t, res.Errors)
+	require.Contains(t, res.Errors[0].Message, "cost limit of 11")
+}
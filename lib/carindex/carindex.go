@@ -0,0 +1,179 @@
+// Package carindex reads the block layout of CAR files, so that blocks of pieces holding CARs can be found and read
+// with range reads.
+package carindex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// ErrNotCar is returned when the data doesn't start with a CARv1 or CARv2 header
+var ErrNotCar = xerrors.New("data is not a CAR")
+
+// MaxHeaderSize limits the size of CARv1 headers, larger headers are treated as non-CAR data
+const MaxHeaderSize = 32 << 10
+
+// MaxSectionSize limits the size of a CID and block section
+const MaxSectionSize = 32 << 20
+
+// carV2Pragma is the header of CARv2 files, a CARv1 header with only {"version": 2}
+var carV2Pragma = []byte{0xa1, 0x67, 'v', 'e', 'r', 's', 'i', 'o', 'n', 0x02}
+
+// carV2HeaderSize is the size of the fixed CARv2 header following the pragma
+const carV2HeaderSize = 40
+
+// Block is the location of the data of a block in a CAR
+type Block struct {
+	Cid cid.Cid
+
+	// Offset of the block data, without the section length and CID, from the start of the CAR
+	Offset uint64
+	Size   uint64
+}
+
+type countingReader struct {
+	r *bufio.Reader
+	n uint64
+
+	// readErr is the last error of the underlying reader other than EOF
+	readErr error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += uint64(n)
+	c.noteErr(err)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	c.noteErr(err)
+	return b, err
+}
+
+func (c *countingReader) noteErr(err error) {
+	if err != nil && err != io.EOF {
+		c.readErr = err
+	}
+}
+
+func (c *countingReader) skip(n uint64) error {
+	d, err := c.r.Discard(int(n))
+	c.n += uint64(d)
+	return err
+}
+
+// Walk calls fn for every block of the CARv1 or CARv2 in r, in the order of the CAR. ErrNotCar is returned when r
+// doesn't start with a CAR header. Reading stops at the end of r, at the end of the data of a CARv2, or at a zero
+// section length, which some writers use as padding after the last block.
+func Walk(r io.Reader, fn func(Block) error) error {
+	cr := &countingReader{r: bufio.NewReaderSize(r, 1<<20)}
+
+	hdr, err := readHeader(cr)
+	if err != nil {
+		return err
+	}
+
+	var limit uint64 // 0 = until EOF
+	if bytes.Equal(hdr, carV2Pragma) {
+		var v2hdr [carV2HeaderSize]byte
+		if _, err := io.ReadFull(cr, v2hdr[:]); err != nil {
+			return xerrors.Errorf("reading CARv2 header: %w", err)
+		}
+
+		// characteristics (16 bytes), data offset, data size, index offset
+		dataOffset := binary.LittleEndian.Uint64(v2hdr[16:24])
+		dataSize := binary.LittleEndian.Uint64(v2hdr[24:32])
+		if dataOffset < cr.n {
+			return xerrors.Errorf("CARv2 data offset %d is inside the header", dataOffset)
+		}
+		if err := cr.skip(dataOffset - cr.n); err != nil {
+			return xerrors.Errorf("skipping to CARv2 data: %w", err)
+		}
+
+		if _, err := readHeader(cr); err != nil {
+			return xerrors.Errorf("reading CARv2 inner header: %w", err)
+		}
+		limit = dataOffset + dataSize
+	}
+
+	for limit == 0 || cr.n < limit {
+		sectionStart := cr.n
+
+		sectionLen, err := binary.ReadUvarint(cr)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return xerrors.Errorf("reading section length at %d: %w", sectionStart, err)
+		}
+		if sectionLen == 0 {
+			return nil
+		}
+		if sectionLen > MaxSectionSize {
+			return xerrors.Errorf("section at %d is %d bytes, more than the maximum of %d", sectionStart, sectionLen, MaxSectionSize)
+		}
+
+		cidStart := cr.n
+		cidLen, c, err := cid.CidFromReader(cr)
+		if err != nil {
+			return xerrors.Errorf("reading CID at %d: %w", cidStart, err)
+		}
+		if uint64(cidLen) > sectionLen {
+			return xerrors.Errorf("CID at %d is longer than its section", cidStart)
+		}
+
+		b := Block{
+			Cid:    c,
+			Offset: cr.n,
+			Size:   sectionLen - uint64(cidLen),
+		}
+		if err := cr.skip(b.Size); err != nil {
+			return xerrors.Errorf("reading block %s at %d: %w", c, b.Offset, err)
+		}
+
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readHeader reads a CARv1 header, returning its CBOR bytes
+func readHeader(cr *countingReader) ([]byte, error) {
+	hdrLen, err := binary.ReadUvarint(cr)
+	if err != nil {
+		if cr.readErr != nil {
+			return nil, xerrors.Errorf("reading header length: %w", cr.readErr)
+		}
+		return nil, ErrNotCar
+	}
+	if hdrLen == 0 || hdrLen > MaxHeaderSize {
+		return nil, ErrNotCar
+	}
+
+	hdr := make([]byte, hdrLen)
+	if _, err := io.ReadFull(cr, hdr); err != nil {
+		if cr.readErr != nil {
+			return nil, xerrors.Errorf("reading header: %w", cr.readErr)
+		}
+		return nil, ErrNotCar
+	}
+
+	// the header is a CBOR map with a version key
+	if hdr[0]&0xe0 != 0xa0 || !bytes.Contains(hdr, []byte("version")) {
+		return nil, ErrNotCar
+	}
+
+	return hdr, nil
+}
@@ -0,0 +1,112 @@
+package carindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+// header of a CARv1 with no roots: {"roots": [], "version": 1}
+var testHeader = append([]byte{0xa2, 0x65}, append([]byte("roots"), append([]byte{0x80, 0x67}, append([]byte("version"), 0x01)...)...)...)
+
+func testCar(t *testing.T, blocks [][]byte) ([]byte, []Block) {
+	var buf bytes.Buffer
+	buf.Write(binary.AppendUvarint(nil, uint64(len(testHeader))))
+	buf.Write(testHeader)
+
+	pref := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}
+
+	var expect []Block
+	for _, data := range blocks {
+		c, err := pref.Sum(data)
+		require.NoError(t, err)
+
+		buf.Write(binary.AppendUvarint(nil, uint64(len(c.Bytes())+len(data))))
+		buf.Write(c.Bytes())
+		expect = append(expect, Block{Cid: c, Offset: uint64(buf.Len()), Size: uint64(len(data))})
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), expect
+}
+
+func walkAll(t *testing.T, data []byte) ([]Block, error) {
+	var got []Block
+	err := Walk(bytes.NewReader(data), func(b Block) error {
+		got = append(got, b)
+		return nil
+	})
+	return got, err
+}
+
+func TestWalkV1(t *testing.T) {
+	car, expect := testCar(t, [][]byte{[]byte("one"), []byte("two"), bytes.Repeat([]byte("x"), 300)})
+
+	got, err := walkAll(t, car)
+	require.NoError(t, err)
+	require.Equal(t, expect, got)
+
+	for _, b := range got {
+		data := car[b.Offset : b.Offset+b.Size]
+		c, err := b.Cid.Prefix().Sum(data)
+		require.NoError(t, err)
+		require.Equal(t, b.Cid, c)
+	}
+
+	// zero padding after the last block
+	got, err = walkAll(t, append(car, make([]byte, 127)...))
+	require.NoError(t, err)
+	require.Equal(t, expect, got)
+}
+
+func TestWalkV2(t *testing.T) {
+	inner, innerBlocks := testCar(t, [][]byte{[]byte("one"), []byte("two")})
+
+	var car bytes.Buffer
+	car.WriteByte(byte(len(carV2Pragma)))
+	car.Write(carV2Pragma)
+
+	dataOffset := uint64(car.Len() + carV2HeaderSize + 5) // some bytes between the header and the data
+	var hdr [carV2HeaderSize]byte
+	binary.LittleEndian.PutUint64(hdr[16:], dataOffset)
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(len(inner)))
+	binary.LittleEndian.PutUint64(hdr[32:], dataOffset+uint64(len(inner)))
+	car.Write(hdr[:])
+	car.Write(make([]byte, 5))
+	car.Write(inner)
+	car.WriteString("index which must not be read as blocks")
+
+	var expect []Block
+	for _, b := range innerBlocks {
+		b.Offset += dataOffset
+		expect = append(expect, b)
+	}
+
+	got, err := walkAll(t, car.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, expect, got)
+}
+
+func TestWalkNotCar(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		[]byte("plain text which is not a CAR"),
+		[]byte(strings.Repeat("\xff", 64)),
+		{0x00, 0x01},
+	} {
+		_, err := walkAll(t, data)
+		require.ErrorIs(t, err, ErrNotCar)
+	}
+}
+
+func TestWalkTruncated(t *testing.T) {
+	car, _ := testCar(t, [][]byte{[]byte("one"), []byte("two")})
+
+	_, err := walkAll(t, car[:len(car)-1])
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNotCar)
+}
@@ -8,6 +8,8 @@ import (
 	"go.opencensus.io/trace"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-state-types/abi"
+
 	"github.com/filecoin-project/curio/build"
 
 	"github.com/filecoin-project/lotus/api"
@@ -17,16 +19,21 @@ import (
 
 var log = logging.Logger("curio/chainsched")
 
+// NotifyStallTimeout is how long the scheduler waits for a chain notification before resubscribing, which moves
+// the subscription to another chain node when the current one stalled.
+var NotifyStallTimeout = 5 * time.Duration(build.BlockDelaySecs) * time.Second
+
 type NodeAPI interface {
 	ChainHead(context.Context) (*types.TipSet, error)
 	ChainNotify(context.Context) (<-chan []*api.HeadChange, error)
+	ChainGetPath(ctx context.Context, from, to types.TipSetKey) ([]*api.HeadChange, error)
 }
 
 type CurioChainSched struct {
 	api NodeAPI
 
-	callbacks []UpdateFunc
-	started   bool
+	handlers []*handler
+	started  bool
 }
 
 func New(api NodeAPI) *CurioChainSched {
@@ -37,39 +44,75 @@ func New(api NodeAPI) *CurioChainSched {
 
 type UpdateFunc func(ctx context.Context, revert, apply *types.TipSet) error
 
-func (s *CurioChainSched) AddHandler(ch UpdateFunc) error {
+// AddHandler registers a handler called with head changes. Each handler runs in its own goroutine, with a timeout
+// and filters set by opts.
+func (s *CurioChainSched) AddHandler(ch UpdateFunc, opts ...HandlerOption) error {
+	if s.started {
+		return xerrors.Errorf("cannot add handler after start")
+	}
+
+	s.handlers = append(s.handlers, newHandler(ch, opts))
+	return nil
+}
+
+// RevertFunc is called after the chain was reorged, with the lowest reverted epoch and the new head. Work derived
+// from chain state at or after the reverted epoch may no longer be valid.
+type RevertFunc func(ctx context.Context, reverted abi.ChainEpoch, apply *types.TipSet) error
+
+// AddRevertHandler registers a handler called only after reverts. Reverts arriving while the handler runs are
+// coalesced, so that a deep reorg delivered in several notifications is handled once from its lowest epoch.
+func (s *CurioChainSched) AddRevertHandler(rh RevertFunc, opts ...HandlerOption) error {
 	if s.started {
 		return xerrors.Errorf("cannot add handler after start")
 	}
 
-	s.callbacks = append(s.callbacks, ch)
+	s.handlers = append(s.handlers, newRevertHandler(rh, opts))
 	return nil
 }
 
 func (s *CurioChainSched) Run(ctx context.Context) {
 	s.started = true
 
+	for _, h := range s.handlers {
+		go h.run(ctx)
+	}
+
 	var (
-		notifs <-chan []*api.HeadChange
-		err    error
-		gotCur bool
+		notifs       <-chan []*api.HeadChange
+		cancelNotifs = func() {}
+		err          error
+		gotCur       bool
+
+		// pollingSince is set while head changes are polled because the chain node couldn't push them
+		pollingSince time.Time
 	)
 
 	// not fine to panic after this point
 	for {
 		if notifs == nil {
-			notifs, err = s.api.ChainNotify(ctx)
-			if err != nil {
-				log.Errorf("ChainNotify error: %+v", err)
+			var nctx context.Context
+			nctx, cancelNotifs = context.WithCancel(ctx)
 
-				build.Clock.Sleep(10 * time.Second)
-				continue
+			notifs, err = s.api.ChainNotify(nctx)
+			if err != nil {
+				log.Warnw("ChainNotify failed, polling the chain head instead", "error", err, "interval", HeadPollInterval)
+				notifs = s.pollNotify(nctx)
+				pollingSince = build.Clock.Now()
+			} else {
+				pollingSince = time.Time{}
 			}
 
 			gotCur = false
 			log.Info("restarting chain scheduler")
 		}
 
+		if !pollingSince.IsZero() && build.Clock.Since(pollingSince) > NotifyRetryInterval {
+			// go back to pushed head changes once the chain node supports them again
+			cancelNotifs()
+			notifs = nil
+			continue
+		}
+
 		select {
 		case changes, ok := <-notifs:
 			if !ok {
@@ -117,7 +160,12 @@ func (s *CurioChainSched) Run(ctx context.Context) {
 			s.update(ctx, lowest, highest)
 
 			span.End()
+		case <-build.Clock.After(NotifyStallTimeout):
+			log.Warnw("no chain notifications, resubscribing", "timeout", NotifyStallTimeout)
+			cancelNotifs()
+			notifs = nil
 		case <-ctx.Done():
+			cancelNotifs()
 			return
 		}
 	}
@@ -129,9 +177,7 @@ func (s *CurioChainSched) update(ctx context.Context, revert, apply *types.TipSe
 		return
 	}
 
-	for _, ch := range s.callbacks {
-		if err := ch(ctx, revert, apply); err != nil {
-			log.Errorf("handling head updates in curio chain sched: %+v", err)
-		}
+	for _, h := range s.handlers {
+		h.push(revert, apply)
 	}
 }
@@ -0,0 +1,169 @@
+package chainsched
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/build"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// DefaultHandlerTimeout bounds a single call to a handler, unless set with WithTimeout.
+var DefaultHandlerTimeout = 2 * time.Minute
+
+type HandlerOption func(*handler)
+
+// EveryEpochs runs the handler only when the chain advanced at least n epochs since its last run. Reverts always
+// run the handler.
+func EveryEpochs(n abi.ChainEpoch) HandlerOption {
+	return func(h *handler) {
+		h.every = n
+	}
+}
+
+// When runs the handler only for head changes matching pred.
+func When(pred func(revert, apply *types.TipSet) bool) HandlerOption {
+	return func(h *handler) {
+		h.pred = pred
+	}
+}
+
+// WithTimeout sets the context timeout of a single call to the handler. 0 disables the timeout.
+func WithTimeout(d time.Duration) HandlerOption {
+	return func(h *handler) {
+		h.timeout = d
+	}
+}
+
+// handler runs an UpdateFunc in its own goroutine, so that a slow handler doesn't delay the others. Head changes
+// arriving while the handler runs are coalesced into the latest applied tipset and the lowest reverted one.
+type handler struct {
+	name string
+	fn   UpdateFunc
+
+	every   abi.ChainEpoch
+	pred    func(revert, apply *types.TipSet) bool
+	timeout time.Duration
+
+	lk            sync.Mutex
+	revert, apply *types.TipSet
+	wake          chan struct{}
+
+	lastRun abi.ChainEpoch
+}
+
+func newHandler(fn UpdateFunc, opts []HandlerOption) *handler {
+	h := &handler{
+		name:    funcName(fn),
+		fn:      fn,
+		timeout: DefaultHandlerTimeout,
+		wake:    make(chan struct{}, 1),
+		lastRun: -1,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func funcName(fn any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// newRevertHandler wraps a RevertFunc into a handler which only runs for head changes with a revert.
+func newRevertHandler(fn RevertFunc, opts []HandlerOption) *handler {
+	h := newHandler(func(ctx context.Context, revert, apply *types.TipSet) error {
+		return fn(ctx, revert.Height(), apply)
+	}, opts)
+	h.name = funcName(fn)
+
+	pred := h.pred
+	h.pred = func(revert, apply *types.TipSet) bool {
+		return revert != nil && (pred == nil || pred(revert, apply))
+	}
+	return h
+}
+
+// push queues a head change for the handler.
+func (h *handler) push(revert, apply *types.TipSet) {
+	h.lk.Lock()
+	if h.revert != nil && (revert == nil || h.revert.Height() < revert.Height()) {
+		revert = h.revert
+	}
+	h.revert, h.apply = revert, apply
+	h.lk.Unlock()
+
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (h *handler) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.wake:
+		}
+
+		h.lk.Lock()
+		revert, apply := h.revert, h.apply
+		h.revert, h.apply = nil, nil
+		h.lk.Unlock()
+
+		if apply == nil || !h.due(revert, apply) {
+			continue
+		}
+
+		h.lastRun = apply.Height()
+		if err := h.call(ctx, revert, apply); err != nil {
+			log.Errorw("handling head updates in curio chain sched", "handler", h.name, "height", apply.Height(), "error", err)
+		}
+	}
+}
+
+func (h *handler) due(revert, apply *types.TipSet) bool {
+	if h.pred != nil && !h.pred(revert, apply) {
+		return false
+	}
+	if h.every <= 1 || revert != nil || h.lastRun < 0 {
+		return true
+	}
+	return apply.Height() >= h.lastRun+h.every || apply.Height() < h.lastRun
+}
+
+func (h *handler) call(ctx context.Context, revert, apply *types.TipSet) (err error) {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = xerrors.Errorf("handler panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	start := time.Now()
+	err = h.fn(ctx, revert, apply)
+	if took := time.Since(start); took > time.Duration(build.BlockDelaySecs)*time.Second/2 {
+		log.Warnw("slow chain sched handler", "handler", h.name, "height", apply.Height(), "took", took)
+	}
+	return err
+}
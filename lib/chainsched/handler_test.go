@@ -0,0 +1,142 @@
+package chainsched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func tipsetAt(t *testing.T, h abi.ChainEpoch) *types.TipSet {
+	empty := cid.MustParse("bafkqaaa") // identity CID of no data
+
+	ts, err := types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 builtin.SystemActorAddr,
+		Height:                h,
+		ParentStateRoot:       empty,
+		ParentMessageReceipts: empty,
+		Messages:              empty,
+	}})
+	require.NoError(t, err)
+	return ts
+}
+
+func TestHandlerDue(t *testing.T) {
+	h := newHandler(func(ctx context.Context, revert, apply *types.TipSet) error { return nil }, []HandlerOption{EveryEpochs(10)})
+
+	require.True(t, h.due(nil, tipsetAt(t, 100)))
+	h.lastRun = 100
+	require.False(t, h.due(nil, tipsetAt(t, 105)))
+	require.True(t, h.due(nil, tipsetAt(t, 110)))
+	require.True(t, h.due(tipsetAt(t, 104), tipsetAt(t, 105)), "reverts always run the handler")
+
+	h = newHandler(func(ctx context.Context, revert, apply *types.TipSet) error { return nil }, []HandlerOption{
+		When(func(revert, apply *types.TipSet) bool { return apply.Height()%2 == 0 }),
+	})
+	require.True(t, h.due(nil, tipsetAt(t, 100)))
+	require.False(t, h.due(nil, tipsetAt(t, 101)))
+}
+
+func TestHandlerIsolation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	block := make(chan struct{})
+	slow := newHandler(func(ctx context.Context, revert, apply *types.TipSet) error {
+		<-block
+		return nil
+	}, nil)
+
+	got := make(chan abi.ChainEpoch, 10)
+	fast := newHandler(func(ctx context.Context, revert, apply *types.TipSet) error {
+		got <- apply.Height()
+		return nil
+	}, nil)
+
+	panics := newHandler(func(ctx context.Context, revert, apply *types.TipSet) error {
+		panic("boom")
+	}, nil)
+
+	for _, h := range []*handler{slow, fast, panics} {
+		go h.run(ctx)
+	}
+
+	for _, h := range []*handler{slow, fast, panics} {
+		h.push(nil, tipsetAt(t, 1))
+	}
+
+	select {
+	case e := <-got:
+		require.Equal(t, abi.ChainEpoch(1), e)
+	case <-time.After(5 * time.Second):
+		t.Fatal("fast handler was delayed")
+	}
+	close(block)
+}
+
+func TestHandlerCoalesce(t *testing.T) {
+	h := newHandler(func(ctx context.Context, revert, apply *types.TipSet) error { return nil }, nil)
+
+	h.push(tipsetAt(t, 5), tipsetAt(t, 6))
+	h.push(nil, tipsetAt(t, 7))
+	h.push(tipsetAt(t, 6), tipsetAt(t, 8))
+
+	require.Equal(t, abi.ChainEpoch(5), h.revert.Height())
+	require.Equal(t, abi.ChainEpoch(8), h.apply.Height())
+}
+
+func TestRevertHandlerDeepReorg(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	block := make(chan struct{})
+	got := make(chan abi.ChainEpoch, 10)
+	h := newRevertHandler(func(ctx context.Context, reverted abi.ChainEpoch, apply *types.TipSet) error {
+		got <- reverted
+		<-block
+		return nil
+	}, nil)
+	require.Equal(t, "chainsched.TestRevertHandlerDeepReorg.func1", h.name)
+
+	require.False(t, h.due(nil, tipsetAt(t, 100)), "revert handlers don't run for plain applies")
+	require.True(t, h.due(tipsetAt(t, 99), tipsetAt(t, 100)))
+
+	go h.run(ctx)
+
+	h.push(tipsetAt(t, 100), tipsetAt(t, 101))
+	select {
+	case e := <-got:
+		require.Equal(t, abi.ChainEpoch(100), e)
+	case <-time.After(5 * time.Second):
+		t.Fatal("revert handler not called")
+	}
+
+	// a deep reorg delivered in several notifications while the handler is busy is handled once, from the lowest
+	// reverted epoch
+	h.push(tipsetAt(t, 95), tipsetAt(t, 102))
+	h.push(nil, tipsetAt(t, 103))
+	h.push(tipsetAt(t, 80), tipsetAt(t, 104))
+	h.push(tipsetAt(t, 90), tipsetAt(t, 105))
+	block <- struct{}{}
+
+	select {
+	case e := <-got:
+		require.Equal(t, abi.ChainEpoch(80), e)
+	case <-time.After(5 * time.Second):
+		t.Fatal("revert handler not called")
+	}
+	close(block)
+
+	select {
+	case e := <-got:
+		t.Fatalf("unexpected revert handler call from %d", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
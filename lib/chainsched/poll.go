@@ -0,0 +1,74 @@
+package chainsched
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/curio/build"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// HeadPollInterval is how often the chain head is polled when the chain node can't push head changes, e.g. when it
+// is reached over plain HTTP through a proxy without websocket support.
+var HeadPollInterval = time.Duration(build.BlockDelaySecs) * time.Second / 6
+
+// NotifyRetryInterval is how long the scheduler polls the chain head before trying to subscribe to head changes
+// again.
+var NotifyRetryInterval = 10 * time.Minute
+
+// pollNotify emulates ChainNotify by polling the chain head, reporting the path from the previous head as reverts
+// and applies. The channel is closed when ctx is done.
+func (s *CurioChainSched) pollNotify(ctx context.Context) <-chan []*api.HeadChange {
+	out := make(chan []*api.HeadChange)
+
+	go func() {
+		defer close(out)
+
+		var cur *types.TipSet
+		for {
+			changes, head, err := s.pollChanges(ctx, cur)
+			if err != nil {
+				log.Warnw("polling chain head", "error", err)
+			} else if len(changes) > 0 {
+				select {
+				case out <- changes:
+					cur = head
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-build.Clock.After(HeadPollInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// pollChanges returns the head changes since cur, starting with the current head when cur is nil.
+func (s *CurioChainSched) pollChanges(ctx context.Context, cur *types.TipSet) ([]*api.HeadChange, *types.TipSet, error) {
+	head, err := s.api.ChainHead(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case cur == nil:
+		return []*api.HeadChange{{Type: store.HCCurrent, Val: head}}, head, nil
+	case head.Equals(cur):
+		return nil, head, nil
+	}
+
+	path, err := s.api.ChainGetPath(ctx, cur.Key(), head.Key())
+	if err != nil {
+		return nil, nil, err
+	}
+	return path, head, nil
+}
@@ -0,0 +1,93 @@
+package chainsched
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// httpOnlyNode is a chain node which can't push head changes.
+type httpOnlyNode struct {
+	lk   sync.Mutex
+	head *types.TipSet
+	path []*api.HeadChange
+}
+
+func (n *httpOnlyNode) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	return n.head, nil
+}
+
+func (n *httpOnlyNode) ChainNotify(ctx context.Context) (<-chan []*api.HeadChange, error) {
+	return nil, xerrors.New("channels not supported over http")
+}
+
+func (n *httpOnlyNode) ChainGetPath(ctx context.Context, from, to types.TipSetKey) ([]*api.HeadChange, error) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	return n.path, nil
+}
+
+func (n *httpOnlyNode) set(head *types.TipSet, path ...*api.HeadChange) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	n.head, n.path = head, path
+}
+
+func TestPollFallback(t *testing.T) {
+	defer func(i time.Duration) { HeadPollInterval = i }(HeadPollInterval)
+	HeadPollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node := &httpOnlyNode{}
+	node.set(tipsetAt(t, 100))
+
+	type change struct{ revert, apply abi.ChainEpoch }
+	got := make(chan change, 10)
+
+	s := New(node)
+	require.NoError(t, s.AddHandler(func(ctx context.Context, revert, apply *types.TipSet) error {
+		c := change{apply: apply.Height()}
+		if revert != nil {
+			c.revert = revert.Height()
+		}
+		got <- c
+		return nil
+	}))
+	go s.Run(ctx)
+
+	next := func() change {
+		select {
+		case c := <-got:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler not called")
+			return change{}
+		}
+	}
+
+	require.Equal(t, change{apply: 100}, next())
+
+	node.set(tipsetAt(t, 101), &api.HeadChange{Type: store.HCApply, Val: tipsetAt(t, 101)})
+	require.Equal(t, change{apply: 101}, next())
+
+	node.set(tipsetAt(t, 102),
+		&api.HeadChange{Type: store.HCRevert, Val: tipsetAt(t, 101)},
+		&api.HeadChange{Type: store.HCRevert, Val: tipsetAt(t, 100)},
+		&api.HeadChange{Type: store.HCApply, Val: tipsetAt(t, 101)},
+		&api.HeadChange{Type: store.HCApply, Val: tipsetAt(t, 102)})
+	require.Equal(t, change{revert: 100, apply: 102}, next())
+}
@@ -0,0 +1,341 @@
+// Package cryptfile implements an encrypted file format for sector data at rest.
+//
+// The plaintext is split into segments of SegmentSize bytes, each sealed with AES-256-GCM,
+// which allows random access reads without decrypting the whole file. The header is
+// authenticated as additional data of every segment, and the segment index is part of
+// the nonce, so segments can't be reordered, truncated or moved between files.
+//
+//	[magic 8B][version 1B][key ID 8B][nonce prefix 8B][plaintext size 8B LE][segment 0]...[segment n-1]
+//
+// Each segment holds up to SegmentSize bytes of ciphertext followed by a 16 byte GCM tag.
+package cryptfile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+const SegmentSize = 1 << 20
+
+// HeaderSize is the size of the header of encrypted files
+const HeaderSize = 8 + 1 + 8 + 8 + 8
+
+const (
+	version = 1
+	tagSize = 16
+	keySize = 32
+)
+
+var magic = [8]byte{'C', 'U', 'R', 'I', 'O', 'E', 'N', 'C'}
+
+type KeyID [8]byte
+
+func (id KeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Key is an AES-256 key used to encrypt files
+type Key struct {
+	ID KeyID
+
+	aead cipher.AEAD
+}
+
+// ParseKey parses a hex or base64 encoded 256-bit key
+func ParseKey(s string) (*Key, error) {
+	s = strings.TrimSpace(s)
+
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		raw, err = base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, xerrors.Errorf("key is neither hex nor base64 encoded")
+		}
+	}
+	if len(raw) != keySize {
+		return nil, xerrors.Errorf("expected a %d byte key, got %d bytes", keySize, len(raw))
+	}
+
+	return NewKey(raw)
+}
+
+func NewKey(raw []byte) (*Key, error) {
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, xerrors.Errorf("creating cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, xerrors.Errorf("creating GCM: %w", err)
+	}
+
+	sum := sha256.Sum256(raw)
+
+	var id KeyID
+	copy(id[:], sum[:])
+	return &Key{ID: id, aead: aead}, nil
+}
+
+// Keyring holds the key used to encrypt new files and older keys which are still
+// needed to decrypt files encrypted before a key rotation
+type Keyring struct {
+	active *Key
+	keys   map[KeyID]*Key
+}
+
+func NewKeyring(active *Key, previous ...*Key) *Keyring {
+	kr := &Keyring{
+		active: active,
+		keys:   map[KeyID]*Key{active.ID: active},
+	}
+	for _, k := range previous {
+		kr.keys[k.ID] = k
+	}
+	return kr
+}
+
+// Active returns the key used to encrypt new files
+func (kr *Keyring) Active() *Key {
+	return kr.active
+}
+
+type header struct {
+	keyID  KeyID
+	prefix [8]byte
+	size   int64
+}
+
+func (h header) bytes() []byte {
+	b := make([]byte, HeaderSize)
+	copy(b[0:8], magic[:])
+	b[8] = version
+	copy(b[9:17], h.keyID[:])
+	copy(b[17:25], h.prefix[:])
+	binary.LittleEndian.PutUint64(b[25:33], uint64(h.size))
+	return b
+}
+
+func (h header) nonce(segment int64) []byte {
+	n := make([]byte, 12)
+	copy(n[:8], h.prefix[:])
+	binary.BigEndian.PutUint32(n[8:], uint32(segment))
+	return n
+}
+
+// EncryptedSize returns the size of an encrypted file holding size bytes of plaintext
+func EncryptedSize(size int64) int64 {
+	segments := (size + SegmentSize - 1) / SegmentSize
+	return HeaderSize + size + segments*tagSize
+}
+
+// IsEncrypted checks if r starts with an encrypted file header
+func IsEncrypted(r io.ReaderAt) (bool, error) {
+	var m [8]byte
+	_, err := r.ReadAt(m[:], 0)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return m == magic, nil
+}
+
+// Encrypt reads size bytes from src and writes them encrypted with the key to dst
+func Encrypt(key *Key, dst io.Writer, src io.Reader, size int64) error {
+	w, err := NewWriter(key, dst, size)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(w, src, size); err != nil {
+		return xerrors.Errorf("reading plaintext: %w", err)
+	}
+	return w.Close()
+}
+
+// Writer encrypts size bytes of plaintext as they are written. Segments are written to dst as soon as they are
+// complete, Close writes the last one and fails if fewer than size bytes were written.
+type Writer struct {
+	dst io.Writer
+	key *Key
+	h   header
+	hb  []byte
+
+	seg int64
+	buf []byte
+	n   int64 // plaintext bytes written
+}
+
+// NewWriter writes the header of an encrypted file holding size bytes of plaintext to dst
+func NewWriter(key *Key, dst io.Writer, size int64) (*Writer, error) {
+	if size < 0 {
+		return nil, xerrors.Errorf("negative size")
+	}
+	if size/SegmentSize >= 1<<32 {
+		return nil, xerrors.Errorf("file too large to encrypt")
+	}
+
+	h := header{keyID: key.ID, size: size}
+	if _, err := rand.Read(h.prefix[:]); err != nil {
+		return nil, xerrors.Errorf("generating nonce prefix: %w", err)
+	}
+
+	hb := h.bytes()
+	if _, err := dst.Write(hb); err != nil {
+		return nil, xerrors.Errorf("writing header: %w", err)
+	}
+
+	return &Writer{
+		dst: dst,
+		key: key,
+		h:   h,
+		hb:  hb,
+		buf: make([]byte, 0, SegmentSize+tagSize),
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.h.size-w.n {
+		return 0, xerrors.Errorf("writing past the %d bytes of plaintext", w.h.size)
+	}
+
+	var written int
+	for written < len(p) {
+		n := copy(w.buf[len(w.buf):SegmentSize], p[written:])
+		w.buf = w.buf[:len(w.buf)+n]
+		written += n
+		w.n += int64(n)
+
+		if len(w.buf) == SegmentSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (w *Writer) flush() error {
+	ct := w.key.aead.Seal(w.buf[:0], w.h.nonce(w.seg), w.buf, w.hb)
+	if _, err := w.dst.Write(ct); err != nil {
+		return xerrors.Errorf("writing segment %d: %w", w.seg, err)
+	}
+	w.seg++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the last segment, it doesn't close dst
+func (w *Writer) Close() error {
+	if w.n != w.h.size {
+		return xerrors.Errorf("got %d bytes of plaintext, expected %d", w.n, w.h.size)
+	}
+	if len(w.buf) > 0 {
+		return w.flush()
+	}
+	return nil
+}
+
+// Reader decrypts an encrypted file. It is safe for concurrent use.
+type Reader struct {
+	r   io.ReaderAt
+	key *Key
+	h   header
+	hb  []byte
+
+	lk     sync.Mutex
+	cached int64
+	plain  []byte
+}
+
+// NewReader opens an encrypted file for reading with a key from the keyring
+func NewReader(kr *Keyring, r io.ReaderAt) (*Reader, error) {
+	hb := make([]byte, HeaderSize)
+	if _, err := r.ReadAt(hb, 0); err != nil {
+		return nil, xerrors.Errorf("reading header: %w", err)
+	}
+	if !bytes.Equal(hb[:8], magic[:]) {
+		return nil, xerrors.Errorf("not an encrypted file")
+	}
+	if hb[8] != version {
+		return nil, xerrors.Errorf("unsupported encrypted file version %d", hb[8])
+	}
+
+	var h header
+	copy(h.keyID[:], hb[9:17])
+	copy(h.prefix[:], hb[17:25])
+	h.size = int64(binary.LittleEndian.Uint64(hb[25:33]))
+
+	if kr == nil {
+		return nil, xerrors.Errorf("file is encrypted with key %s, but no encryption keys are configured", h.keyID)
+	}
+	key, ok := kr.keys[h.keyID]
+	if !ok {
+		return nil, xerrors.Errorf("file is encrypted with unknown key %s", h.keyID)
+	}
+
+	return &Reader{r: r, key: key, h: h, hb: hb, cached: -1}, nil
+}
+
+// Size returns the plaintext size
+func (r *Reader) Size() int64 {
+	return r.h.size
+}
+
+func (r *Reader) segment(seg int64) ([]byte, error) {
+	if seg == r.cached {
+		return r.plain, nil
+	}
+
+	n := min(r.h.size-seg*SegmentSize, SegmentSize)
+	buf := make([]byte, n+tagSize)
+	if _, err := r.r.ReadAt(buf, HeaderSize+seg*(SegmentSize+tagSize)); err != nil {
+		return nil, xerrors.Errorf("reading segment %d: %w", seg, err)
+	}
+
+	plain, err := r.key.aead.Open(buf[:0], r.h.nonce(seg), buf, r.hb)
+	if err != nil {
+		return nil, xerrors.Errorf("decrypting segment %d: %w", seg, err)
+	}
+
+	r.cached, r.plain = seg, plain
+	return plain, nil
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset")
+	}
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	var read int
+	for read < len(p) {
+		pos := off + int64(read)
+		if pos >= r.h.size {
+			return read, io.EOF
+		}
+
+		plain, err := r.segment(pos / SegmentSize)
+		if err != nil {
+			return read, err
+		}
+		read += copy(p[read:], plain[pos%SegmentSize:])
+	}
+
+	return read, nil
+}
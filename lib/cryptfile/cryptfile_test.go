@@ -0,0 +1,137 @@
+package cryptfile
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T, seed byte) *Key {
+	k, err := NewKey(bytes.Repeat([]byte{seed}, keySize))
+	require.NoError(t, err)
+	return k
+}
+
+func encrypt(t *testing.T, k *Key, data []byte) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, Encrypt(k, &buf, bytes.NewReader(data), int64(len(data))))
+	require.EqualValues(t, EncryptedSize(int64(len(data))), buf.Len())
+	return buf.Bytes()
+}
+
+func TestRoundtrip(t *testing.T) {
+	k := testKey(t, 1)
+
+	for _, size := range []int{0, 1, SegmentSize - 1, SegmentSize, 2*SegmentSize + 123} {
+		data := make([]byte, size)
+		_, _ = rand.New(rand.NewSource(int64(size))).Read(data)
+
+		enc := encrypt(t, k, data)
+
+		ok, err := IsEncrypted(bytes.NewReader(enc))
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		r, err := NewReader(NewKeyring(k), bytes.NewReader(enc))
+		require.NoError(t, err)
+		require.EqualValues(t, size, r.Size())
+
+		got, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+
+		if size > 10 {
+			// reads across segment boundaries
+			off := int64(size / 2)
+			p := make([]byte, size-int(off))
+			_, err := r.ReadAt(p, off)
+			require.NoError(t, err)
+			require.Equal(t, data[off:], p)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	k := testKey(t, 1)
+
+	data := make([]byte, 2*SegmentSize+123)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+
+	// writes which don't line up with segments
+	var buf bytes.Buffer
+	w, err := NewWriter(k, &buf, int64(len(data)))
+	require.NoError(t, err)
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 333333)
+		_, err := w.Write(rest[:n])
+		require.NoError(t, err)
+		rest = rest[n:]
+	}
+	require.NoError(t, w.Close())
+	require.EqualValues(t, EncryptedSize(int64(len(data))), buf.Len())
+
+	r, err := NewReader(NewKeyring(k), bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	got, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	// short and long writes
+	w, err = NewWriter(k, io.Discard, 10)
+	require.NoError(t, err)
+	_, err = w.Write(make([]byte, 5))
+	require.NoError(t, err)
+	require.ErrorContains(t, w.Close(), "expected 10")
+	_, err = w.Write(make([]byte, 6))
+	require.ErrorContains(t, err, "writing past")
+}
+
+func TestPlaintextNotEncrypted(t *testing.T) {
+	ok, err := IsEncrypted(bytes.NewReader([]byte("plain sector data")))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = IsEncrypted(bytes.NewReader(nil))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestKeys(t *testing.T) {
+	old, cur := testKey(t, 1), testKey(t, 2)
+	data := []byte("unsealed sector data")
+	enc := encrypt(t, old, data)
+
+	_, err := NewReader(NewKeyring(cur), bytes.NewReader(enc))
+	require.ErrorContains(t, err, "unknown key")
+
+	r, err := NewReader(NewKeyring(cur, old), bytes.NewReader(enc))
+	require.NoError(t, err)
+	got, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	_, err = ParseKey("00")
+	require.Error(t, err)
+	k, err := ParseKey(" " + "0101010101010101010101010101010101010101010101010101010101010101" + "\n")
+	require.NoError(t, err)
+	require.Equal(t, old.ID, k.ID)
+}
+
+func TestTamper(t *testing.T) {
+	k := testKey(t, 1)
+	data := make([]byte, SegmentSize+10)
+	enc := encrypt(t, k, data)
+
+	enc[HeaderSize+SegmentSize+tagSize+1] ^= 1
+
+	r, err := NewReader(NewKeyring(k), bytes.NewReader(enc))
+	require.NoError(t, err)
+
+	_, err = r.ReadAt(make([]byte, 10), 0)
+	require.NoError(t, err)
+	_, err = r.ReadAt(make([]byte, 10), SegmentSize)
+	require.ErrorContains(t, err, "decrypting segment 1")
+}
@@ -2,7 +2,9 @@ package dealdata
 
 import (
 	"context"
+	"encoding/json"
 	"io"
+	"net/http"
 	"net/url"
 	"strconv"
 
@@ -36,6 +38,9 @@ type dealMetadata struct {
 	DataRawSize *int64  `db:"data_raw_size"`
 
 	DataDelOnFinalize bool `db:"data_delete_on_finalize"`
+
+	// ParkedPieceID reads the piece from parked piece storage, used when rebuilding sector data of sealed sectors
+	ParkedPieceID *int64 `db:"parked_piece_id"`
 }
 
 type DealData struct {
@@ -73,6 +78,34 @@ func DealDataSnap(ctx context.Context, db *harmonydb.DB, sc *ffi.SealCalls, spId
 	return getDealMetadata(ctx, db, sc, spt, pieces, false)
 }
 
+var ErrPiecesNotParked = xerrors.New("piece data isn't parked")
+
+// DealDataParked returns the data of a sealed sector rebuilt from the parked copies of its pieces. It fails with
+// ErrPiecesNotParked when a piece of the sector has no complete parked copy.
+func DealDataParked(ctx context.Context, db *harmonydb.DB, sc *ffi.SealCalls, spId, sectorNumber int64, spt abi.RegisteredSealProof) (*DealData, error) {
+	var pieces []dealMetadata
+	err := db.Select(ctx, &pieces, `
+		SELECT smp.piece_num AS piece_index, smp.piece_cid, smp.piece_size, pp.piece_raw_size AS data_raw_size, pp.id AS parked_piece_id
+		FROM sectors_meta_pieces smp
+		LEFT JOIN parked_pieces pp ON pp.piece_cid = smp.piece_cid AND pp.piece_padded_size = smp.piece_size
+			AND pp.complete = TRUE AND pp.cleanup_task_id IS NULL
+		WHERE smp.sp_id = $1 AND smp.sector_num = $2 ORDER BY smp.piece_num ASC`, spId, sectorNumber)
+	if err != nil {
+		return nil, xerrors.Errorf("getting pieces: %w", err)
+	}
+	if len(pieces) == 0 {
+		return nil, xerrors.Errorf("sector has no pieces")
+	}
+
+	for _, p := range pieces {
+		if p.ParkedPieceID == nil {
+			return nil, xerrors.Errorf("piece %s: %w", p.PieceCID, ErrPiecesNotParked)
+		}
+	}
+
+	return getDealMetadata(ctx, db, sc, spt, pieces, false)
+}
+
 func UnsealedCidFromPieces(ctx context.Context, db *harmonydb.DB, spId, sectorNumber int64) (cid.Cid, error) {
 	var sectorParams []struct {
 		RegSealProof int64 `db:"reg_seal_proof"`
@@ -171,7 +204,17 @@ func getDealMetadata(ctx context.Context, db *harmonydb.DB, sc *ffi.SealCalls, s
 
 			// make pieceReader
 			if !commDOnly {
-				if p.DataUrl != nil {
+				if p.ParkedPieceID != nil {
+					pr, err := sc.PieceReader(ctx, storiface.PieceNumber(*p.ParkedPieceID))
+					if err != nil {
+						return nil, xerrors.Errorf("getting piece reader: %w", err)
+					}
+
+					closers = append(closers, pr)
+
+					reader, _ := padreader.New(pr, uint64(*p.DataRawSize))
+					pieceReaders = append(pieceReaders, reader)
+				} else if p.DataUrl != nil {
 					dataUrl := *p.DataUrl
 
 					goUrl, err := url.Parse(dataUrl)
@@ -210,7 +253,17 @@ func getDealMetadata(ctx context.Context, db *harmonydb.DB, sc *ffi.SealCalls, s
 						reader, _ := padreader.New(pr, uint64(*p.DataRawSize))
 						pieceReaders = append(pieceReaders, reader)
 					} else {
-						reader, _ := padreader.New(NewUrlReader(dataUrl, *p.DataRawSize), uint64(*p.DataRawSize))
+						var hdrs http.Header
+						if p.DataHeaders != nil {
+							if err := json.Unmarshal(*p.DataHeaders, &hdrs); err != nil {
+								return nil, xerrors.Errorf("parsing data headers: %w", err)
+							}
+						}
+
+						upr := NewUrlReader(ctx, dataUrl, hdrs, *p.DataRawSize)
+						closers = append(closers, upr)
+
+						reader, _ := padreader.New(upr, uint64(*p.DataRawSize))
 						pieceReaders = append(pieceReaders, reader)
 					}
 
@@ -1,25 +1,69 @@
 package dealdata
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"golang.org/x/xerrors"
 )
 
+// UrlReaderRetries is the number of times NewUrlReader readers retry a failed request to a source before moving on
+// to the next mirror
+var UrlReaderRetries = 4
+
+// UrlReaderRetryBackoff is the wait before the first retry of a failed request, doubled with each following retry
+var UrlReaderRetryBackoff = 5 * time.Second
+
+// UrlReaderMaxRetryBackoff caps the wait between retries of a failed request
+var UrlReaderMaxRetryBackoff = 2 * time.Minute
+
+// PieceSource is an URL serving piece data, with the headers to send with requests to it
+type PieceSource struct {
+	Url     string
+	Headers http.Header
+}
+
 type UrlPieceReader struct {
 	Url     string
+	Headers http.Header
 	RawSize int64 // the exact number of bytes read, if we read more or less that's an error
 
+	// Mirrors are alternative sources of the same data, used in order when Url fails
+	Mirrors []PieceSource
+
+	// Retries is the number of times a failed request to a source is retried before moving on to the next one.
+	// Requests made after some data was read resume from the first byte not yet read with a range request.
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubled with each following one up to UrlReaderMaxRetryBackoff
+	RetryBackoff time.Duration
+
+	// ctx cancels requests and waits between retries, nil for readers not made with NewUrlReader
+	ctx context.Context
+
 	readSoFar int64
 	closed    bool
 	active    io.ReadCloser // auto-closed on EOF
+
+	source  int // index of the current source, 0 is Url
+	attempt int // failed attempts on the current source since data was last read
 }
 
-func NewUrlReader(p string, rs int64) *UrlPieceReader {
+// NewUrlReader reads rs bytes from the URL p, or its mirrors when it fails. Requests and the waits between their
+// retries end when ctx is cancelled.
+func NewUrlReader(ctx context.Context, p string, headers http.Header, rs int64, mirrors ...PieceSource) *UrlPieceReader {
 	return &UrlPieceReader{
+		ctx: ctx,
+
 		Url:     p,
+		Headers: headers,
 		RawSize: rs,
+		Mirrors: mirrors,
+
+		Retries:      UrlReaderRetries,
+		RetryBackoff: UrlReaderRetryBackoff,
 	}
 }
 
@@ -29,55 +73,174 @@ func (u *UrlPieceReader) Read(p []byte) (n int, err error) {
 		return 0, io.EOF
 	}
 
-	// If 'active' is nil, initiate the HTTP request
-	if u.active == nil {
-		resp, err := http.Get(u.Url)
-		if err != nil {
-			return 0, err
+	for {
+		// If 'active' is nil, initiate the HTTP request
+		if u.active == nil {
+			if err := u.open(); err != nil {
+				if !u.next(err) {
+					return 0, err
+				}
+				continue
+			}
 		}
 
-		// Set 'active' to the response body
-		u.active = resp.Body
+		// Calculate the maximum number of bytes we can read without exceeding RawSize
+		toRead := u.RawSize - u.readSoFar
+		if int64(len(p)) > toRead {
+			p = p[:toRead]
+		}
+
+		n, err = u.active.Read(p)
+
+		// Update the number of bytes read so far
+		u.readSoFar += int64(n)
+		if n > 0 {
+			u.attempt = 0
+		}
+
+		// If the number of bytes read exceeds RawSize, return an error
+		if u.readSoFar > u.RawSize {
+			return n, xerrors.New("read beyond the specified RawSize")
+		}
+
+		// if we're below the RawSize, the source ended the response early
+		if err == io.EOF && u.readSoFar < u.RawSize {
+			log.Warnw("unexpected EOF", "readSoFar", u.readSoFar, "rawSize", u.RawSize, "url", u.currentUrl())
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err != nil && err != io.EOF {
+			u.closeActive()
+
+			if n > 0 {
+				// the next read resumes from where this one stopped
+				return n, nil
+			}
+			if !u.next(err) {
+				return 0, err
+			}
+			continue
+		}
+
+		// If EOF is reached, close the reader
+		if err == io.EOF {
+			u.closeActive()
+			u.closed = true
+		}
+
+		return n, err
+	}
+}
+
+// ReadSoFar returns the number of bytes read from all sources
+func (u *UrlPieceReader) ReadSoFar() int64 {
+	return u.readSoFar
+}
+
+// open requests the data not read yet from the current source
+func (u *UrlPieceReader) open() error {
+	src := u.sources()[u.source]
+
+	req, err := http.NewRequestWithContext(u.context(), http.MethodGet, src.Url, nil)
+	if err != nil {
+		return xerrors.Errorf("creating request: %w", err)
+	}
+	if src.Headers != nil {
+		req.Header = src.Headers.Clone()
+	}
+	if u.readSoFar > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", u.readSoFar))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
 
-	// Calculate the maximum number of bytes we can read without exceeding RawSize
-	toRead := u.RawSize - u.readSoFar
-	if int64(len(p)) > toRead {
-		p = p[:toRead]
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if u.readSoFar > 0 {
+			// the source doesn't support range requests, skip the data we already have
+			if _, err := io.CopyN(io.Discard, resp.Body, u.readSoFar); err != nil {
+				_ = resp.Body.Close()
+				return xerrors.Errorf("skipping %d bytes already read: %w", u.readSoFar, err)
+			}
+		}
+	default:
+		_ = resp.Body.Close()
+		return xerrors.Errorf("non-200 response: %d", resp.StatusCode)
 	}
 
-	n, err = u.active.Read(p)
+	// Set 'active' to the response body
+	u.active = resp.Body
+	return nil
+}
 
-	// Update the number of bytes read so far
-	u.readSoFar += int64(n)
+// next picks the source to retry a failed request with, waiting before retries of the same source. It returns false
+// when no sources are left, or when the reader's context is cancelled.
+func (u *UrlPieceReader) next(err error) bool {
+	log.Warnw("piece data request failed", "url", u.currentUrl(), "readSoFar", u.readSoFar, "rawSize", u.RawSize, "attempt", u.attempt, "error", err)
 
-	// If the number of bytes read exceeds RawSize, return an error
-	if u.readSoFar > u.RawSize {
-		return n, xerrors.New("read beyond the specified RawSize")
+	ctx := u.context()
+	if ctx.Err() != nil {
+		return false
 	}
 
-	// If EOF is reached, close the reader
-	if err == io.EOF {
-		cerr := u.active.Close()
-		u.closed = true
-		if cerr != nil {
-			log.Errorf("error closing http piece reader: %s", cerr)
+	if u.attempt < u.Retries {
+		backoff := u.RetryBackoff << u.attempt
+		if backoff > UrlReaderMaxRetryBackoff || backoff <= 0 {
+			backoff = UrlReaderMaxRetryBackoff
 		}
 
-		// if we're below the RawSize, return an unexpected EOF error
-		if u.readSoFar < u.RawSize {
-			log.Errorw("unexpected EOF", "readSoFar", u.readSoFar, "rawSize", u.RawSize, "url", u.Url)
-			return n, io.ErrUnexpectedEOF
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false
 		}
+		u.attempt++
+		return true
 	}
 
-	return n, err
+	if u.source+1 < len(u.sources()) {
+		u.source++
+		u.attempt = 0
+		log.Warnw("switching to piece data mirror", "url", u.currentUrl(), "readSoFar", u.readSoFar)
+		return true
+	}
+
+	return false
+}
+
+func (u *UrlPieceReader) context() context.Context {
+	if u.ctx == nil {
+		return context.Background()
+	}
+	return u.ctx
+}
+
+func (u *UrlPieceReader) sources() []PieceSource {
+	return append([]PieceSource{{Url: u.Url, Headers: u.Headers}}, u.Mirrors...)
+}
+
+func (u *UrlPieceReader) currentUrl() string {
+	return u.sources()[u.source].Url
+}
+
+func (u *UrlPieceReader) closeActive() {
+	if u.active == nil {
+		return
+	}
+	if err := u.active.Close(); err != nil {
+		log.Errorf("error closing http piece reader: %s", err)
+	}
+	u.active = nil
 }
 
 func (u *UrlPieceReader) Close() error {
 	if !u.closed {
 		u.closed = true
-		return u.active.Close()
+		u.closeActive()
 	}
 
 	return nil
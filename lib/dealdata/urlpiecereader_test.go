@@ -1,10 +1,14 @@
 package dealdata
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -72,3 +76,81 @@ func TestUrlPieceReader_Read_Error(t *testing.T) {
 		t.Errorf("Expected an error, but got nil")
 	}
 }
+
+// TestUrlPieceReader_Resume tests that a dropped download resumes with a range request
+func TestUrlPieceReader_Resume(t *testing.T) {
+	testData := strings.Repeat("0123456789", 100)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			// promise all data, but drop the connection half way
+			w.Header().Set("Content-Length", fmt.Sprint(len(testData)))
+			_, _ = io.WriteString(w, testData[:len(testData)/2])
+			return
+		}
+
+		var start int
+		_, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+		require.NoError(t, err)
+		require.Equal(t, "v", r.Header.Get("X-Test"))
+
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = io.WriteString(w, testData[start:])
+	}))
+	defer ts.Close()
+
+	reader := NewUrlReader(context.Background(), ts.URL, http.Header{"X-Test": []string{"v"}}, int64(len(testData)))
+	reader.RetryBackoff = time.Millisecond
+
+	buffer, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, testData, string(buffer))
+	require.Equal(t, 2, requests)
+}
+
+// TestUrlPieceReader_Mirror tests failing over to a mirror when the source keeps failing
+func TestUrlPieceReader_Mirror(t *testing.T) {
+	testData := "This is a test string."
+
+	var failed int
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failed++
+		http.Error(w, "error", http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, testData)
+	}))
+	defer good.Close()
+
+	reader := NewUrlReader(context.Background(), bad.URL, nil, int64(len(testData)), PieceSource{Url: good.URL})
+	reader.Retries = 2
+	reader.RetryBackoff = time.Millisecond
+
+	buffer, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, testData, string(buffer))
+	require.Equal(t, 3, failed)
+}
+
+// TestUrlPieceReader_Cancel tests that a cancelled reader stops waiting for its next retry
+func TestUrlPieceReader_Cancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "error", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	reader := NewUrlReader(ctx, ts.URL, nil, 10)
+	reader.RetryBackoff = time.Hour
+
+	start := time.Now()
+	_, err := io.ReadAll(reader)
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 10*time.Second)
+}
@@ -0,0 +1,89 @@
+package dealdata
+
+import (
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/lib/proof"
+)
+
+// ErrPieceCIDMismatch is returned by readers from NewPieceVerifyReader when the data read doesn't match the piece CID
+var ErrPieceCIDMismatch = xerrors.New("piece CID mismatch")
+
+type pieceVerifyReader struct {
+	r        io.Reader
+	rawSize  int64
+	pieceCID cid.Cid
+	size     abi.PaddedPieceSize
+
+	read int64
+	cw   *proof.DataCidWriter
+}
+
+// NewPieceVerifyReader computes the piece CID of rawSize bytes of piece data read from r, and fails the read which
+// completes the data when it doesn't match pieceCID of a piece with the given padded size.
+func NewPieceVerifyReader(r io.Reader, rawSize int64, pieceCID cid.Cid, size abi.PaddedPieceSize) io.Reader {
+	return &pieceVerifyReader{
+		r:        r,
+		rawSize:  rawSize,
+		pieceCID: pieceCID,
+		size:     size,
+		cw:       new(proof.DataCidWriter),
+	}
+}
+
+func (v *pieceVerifyReader) Read(p []byte) (int, error) {
+	if v.read >= v.rawSize {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > v.rawSize-v.read {
+		p = p[:v.rawSize-v.read]
+	}
+
+	n, err := v.r.Read(p)
+	_, _ = v.cw.Write(p[:n])
+	v.read += int64(n)
+
+	if v.read == v.rawSize {
+		if verr := v.verify(); verr != nil {
+			return n, verr
+		}
+	}
+
+	return n, err
+}
+
+func (v *pieceVerifyReader) verify() error {
+	sum, err := v.cw.Sum()
+	if err != nil {
+		return xerrors.Errorf("computing piece CID: %w", err)
+	}
+
+	got := sum.PieceCID
+	if sum.PieceSize < v.size {
+		// the data is zero-padded up to the piece size
+		rawCommP, err := commcid.CIDToPieceCommitmentV1(got)
+		if err != nil {
+			return xerrors.Errorf("getting commP: %w", err)
+		}
+		padded, err := commp.PadCommP(rawCommP, uint64(sum.PieceSize), uint64(v.size))
+		if err != nil {
+			return xerrors.Errorf("padding commP: %w", err)
+		}
+		got, err = commcid.PieceCommitmentV1ToCID(padded)
+		if err != nil {
+			return xerrors.Errorf("converting commP to CID: %w", err)
+		}
+	}
+
+	if got != v.pieceCID {
+		return xerrors.Errorf("expected %s, got %s: %w", v.pieceCID, got, ErrPieceCIDMismatch)
+	}
+	return nil
+}
@@ -98,6 +98,38 @@ func GetParams(ctx context.Context, paramBytes []byte, srsBytes []byte, storageS
 	return ft.wait(ctx)
 }
 
+// MissingParams returns the names of parameter files GetParams would fetch for the given sector size which
+// aren't present in the parameter directory. Files are not checksummed.
+func MissingParams(paramBytes []byte, srsBytes []byte, storageSize uint64) ([]string, error) {
+	var params, srs map[string]paramFile
+	if err := json.Unmarshal(paramBytes, &params); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(srsBytes, &srs); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	check := func(name string) {
+		fi, err := os.Stat(filepath.Join(getParamDir(), name))
+		if err != nil || fi.Size() == 0 {
+			missing = append(missing, name)
+		}
+	}
+
+	for name, info := range params {
+		if storageSize != info.SectorSize && strings.HasSuffix(name, ".params") {
+			continue
+		}
+		check(name)
+	}
+	for name := range srs {
+		check(name)
+	}
+
+	return missing, nil
+}
+
 // getFsLock tries to acquire the filesystem lock. If it fails, it will retry until it succeeds. Returns whether
 // there was lock contention (true if we needed more than one try)
 func (ft *fetch) getFsLock() bool {
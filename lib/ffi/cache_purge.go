@@ -0,0 +1,125 @@
+package ffi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+
+	ffi "github.com/filecoin-project/filecoin-ffi"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/lib/proofpaths"
+	storiface "github.com/filecoin-project/curio/lib/storiface"
+)
+
+// CacheKeep selects the sealing intermediates FinalizeSector leaves in the sector cache, to be removed later with
+// PurgeSectorCache
+type CacheKeep struct {
+	// Layers keeps the SDR layer files, and synthetic proofs of sectors using synthetic PoRep
+	Layers bool
+
+	// Trees keeps the TreeC files, and the TreeD file when it isn't turned into the unsealed copy
+	Trees bool
+}
+
+// CacheUsage is the space used by the sealing intermediates of a sector when it was finalized
+type CacheUsage struct {
+	Layers   int64
+	Trees    int64
+	Unsealed int64
+}
+
+// cacheUsage measures the sealing intermediates in a sector cache directory. The TreeD file is counted as unsealed
+// data when it becomes the unsealed copy.
+func cacheUsage(cache string, ssize abi.SectorSize, keepUnsealed bool) (CacheUsage, error) {
+	var u CacheUsage
+
+	ents, err := os.ReadDir(cache)
+	if err != nil {
+		return u, xerrors.Errorf("reading cache dir: %w", err)
+	}
+	for _, ent := range ents {
+		name := ent.Name()
+		if !proofpaths.IsLayerFile(name) && !proofpaths.IsFileTreeC(name) && !proofpaths.IsFileTreeD(name) {
+			continue
+		}
+
+		fi, err := ent.Info()
+		if err != nil {
+			return u, xerrors.Errorf("stat %s: %w", name, err)
+		}
+
+		switch {
+		case proofpaths.IsLayerFile(name):
+			u.Layers += fi.Size()
+		case proofpaths.IsFileTreeD(name) && keepUnsealed:
+		default:
+			u.Trees += fi.Size()
+		}
+	}
+
+	if keepUnsealed {
+		u.Unsealed = int64(ssize)
+	}
+	return u, nil
+}
+
+// purgeCacheFiles removes the selected sealing intermediates from a sector cache directory
+func purgeCacheFiles(cache string, spt abi.RegisteredSealProof, layers, trees bool) error {
+	ssize, err := spt.SectorSize()
+	if err != nil {
+		return xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	if layers && abi.Synthetic[spt] {
+		if err := ffi.ClearSyntheticProofs(uint64(ssize), cache); err != nil {
+			return xerrors.Errorf("clearing synthetic proofs: %w", err)
+		}
+	}
+
+	ents, err := os.ReadDir(cache)
+	if err != nil {
+		return xerrors.Errorf("reading cache dir: %w", err)
+	}
+	for _, ent := range ents {
+		name := ent.Name()
+		purge := (layers && proofpaths.IsLayerFile(name)) || (trees && (proofpaths.IsFileTreeC(name) || proofpaths.IsFileTreeD(name)))
+		if !purge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(cache, name)); err != nil && !os.IsNotExist(err) {
+			return xerrors.Errorf("removing %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// PurgeSectorCache removes sealing intermediates FinalizeSector kept in the cache of a sector. With clearAll the
+// cache is cleared like in FinalizeSector once the selected files are removed, which must only be set when no other
+// intermediates are kept.
+func (sb *SealCalls) PurgeSectorCache(ctx context.Context, sector storiface.SectorRef, layers, trees, clearAll bool) error {
+	sectorPaths, _, releaseSector, err := sb.sectors.AcquireSector(ctx, nil, sector, storiface.FTCache, storiface.FTNone, storiface.PathSealing)
+	if err != nil {
+		return xerrors.Errorf("acquiring sector paths: %w", err)
+	}
+	defer releaseSector()
+
+	if err := purgeCacheFiles(sectorPaths.Cache, sector.ProofType, layers, trees); err != nil {
+		return err
+	}
+
+	if clearAll {
+		ssize, err := sector.ProofType.SectorSize()
+		if err != nil {
+			return xerrors.Errorf("getting sector size: %w", err)
+		}
+		if err := ffi.ClearCache(uint64(ssize), sectorPaths.Cache); err != nil {
+			return xerrors.Errorf("clearing cache: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -64,7 +64,33 @@ func DecodeSnap(spt abi.RegisteredSealProof, commD, commK cid.Cid, key, replica
 		return xerrors.Errorf("failed to get sector size: %w", err)
 	}
 
+	return DecodeSnapRange(spt, commD, commK, key, replica, out, 0, uint64(ssize))
+}
+
+// DecodeSnapRange decodes size bytes of an updated replica starting at byte offset in the sector. The key and
+// replica readers start at offset. Offset and size must be multiples of the node size.
+func DecodeSnapRange(spt abi.RegisteredSealProof, commD, commK cid.Cid, key, replica io.Reader, out io.Writer, offset, size uint64) error {
+	ssize, err := spt.SectorSize()
+	if err != nil {
+		return xerrors.Errorf("failed to get sector size: %w", err)
+	}
+
+	if offset%proof.NODE_SIZE != 0 || size%proof.NODE_SIZE != 0 {
+		return xerrors.Errorf("range %d+%d is not aligned to nodes", offset, size)
+	}
+	if offset+size > uint64(ssize) {
+		return xerrors.Errorf("range %d+%d is out of the sector", offset, size)
+	}
+	if size == 0 {
+		return nil
+	}
+
 	nodesCount := uint64(ssize / proof.NODE_SIZE)
+	startNode := offset / proof.NODE_SIZE
+	rangeNodes := size / proof.NODE_SIZE
+
+	key = io.LimitReader(key, int64(size))
+	replica = io.LimitReader(replica, int64(size))
 
 	commDNew, err := commcid.CIDToDataCommitmentV1(commD)
 	if err != nil {
@@ -82,20 +108,13 @@ func DecodeSnap(spt abi.RegisteredSealProof, commD, commK cid.Cid, key, replica
 		return xerrors.Errorf("failed to calculate phi: %w", err)
 	}
 
-	// Precompute all rho^-1 values
+	// Precompute the rho^-1 values of the range
 	h := hDefault(nodesCount)
-	rhoInvs, err := NewInv(phi, h, nodesCount)
+	rhoInvs, err := NewInvRange(phi, h, nodesCount, startNode, rangeNodes)
 	if err != nil {
 		return xerrors.Errorf("failed to compute rho inverses: %w", err)
 	}
 
-	// Convert rhoInvs to byte slice
-	rhoInvsBytes := make([]byte, nodesCount*32)
-	for i := uint64(0); i < nodesCount; i++ {
-		rhoInv := rhoInvs.Get(i)
-		copy(rhoInvsBytes[i*32:(i+1)*32], rhoInv[:])
-	}
-
 	workers := nWorkers
 	if runtime.NumCPU() < workers {
 		workers = runtime.NumCPU()
@@ -109,7 +128,7 @@ func DecodeSnap(spt abi.RegisteredSealProof, commD, commK cid.Cid, key, replica
 	// Start worker goroutines
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go workerSnap(&wg, jobChan, resultChan, rhoInvs)
+		go workerSnap(&wg, jobChan, resultChan, rhoInvs, startNode)
 	}
 
 	// Start a goroutine to close the job channel when all reading is done
@@ -215,13 +234,13 @@ type resultSnap struct {
 	chunkID int64
 }
 
-func workerSnap(wg *sync.WaitGroup, jobs <-chan jobSnap, results chan<- resultSnap, rhos *Rhos) {
+func workerSnap(wg *sync.WaitGroup, jobs <-chan jobSnap, results chan<- resultSnap, rhos *Rhos, firstNode uint64) {
 	defer wg.Done()
 	for j := range jobs {
 		obuf := pool.Get(j.size)
 
 		// Calculate the starting node index for this chunk
-		startNode := uint64(j.chunkID) * uint64(bufSz) / proof.NODE_SIZE
+		startNode := firstNode + uint64(j.chunkID)*uint64(bufSz)/proof.NODE_SIZE
 		nodeCount := uint64(j.size) / proof.NODE_SIZE
 
 		// Convert rhoInvs to byte slice
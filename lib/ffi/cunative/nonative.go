@@ -14,6 +14,10 @@ func DecodeSnap(spt abi.RegisteredSealProof, commD, commK cid.Cid, key, replica
 	panic("DecodeSnap: cunative build tag not enabled")
 }
 
+func DecodeSnapRange(spt abi.RegisteredSealProof, commD, commK cid.Cid, key, replica io.Reader, out io.Writer, offset, size uint64) error {
+	panic("DecodeSnapRange: cunative build tag not enabled")
+}
+
 func Decode(replica, key io.Reader, out io.Writer) error {
 	panic("Decode: cunative build tag not enabled")
 }
@@ -8,6 +8,8 @@ import (
 
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-state-types/abi"
+
 	"github.com/filecoin-project/curio/harmony/harmonytask"
 	storiface "github.com/filecoin-project/curio/lib/storiface"
 )
@@ -71,6 +73,12 @@ func (sb *SealCalls) PieceReader(ctx context.Context, id storiface.PieceNumber)
 	return sb.sectors.storage.ReaderSeq(ctx, id.Ref(), storiface.FTPiece)
 }
 
+// PieceRangeReader reads size bytes of a parked piece starting at offset. Parked pieces are stored without fr32
+// padding, so offsets are offsets in the unpadded piece.
+func (sb *SealCalls) PieceRangeReader(ctx context.Context, id storiface.PieceNumber, offset, size uint64) (io.ReadCloser, error) {
+	return sb.sectors.storage.ReaderSeqRange(ctx, id.Ref(), storiface.FTPiece, storiface.PaddedByteIndex(offset), abi.PaddedPieceSize(size))
+}
+
 func (sb *SealCalls) RemovePiece(ctx context.Context, id storiface.PieceNumber) error {
 	return sb.sectors.storage.Remove(ctx, id.Ref().ID, storiface.FTPiece, true, nil)
 }
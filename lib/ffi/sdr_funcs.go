@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/KarpelesLab/reflink"
@@ -18,6 +19,7 @@ import (
 
 	"github.com/filecoin-project/curio/harmony/harmonytask"
 	"github.com/filecoin-project/curio/lib/ffiselect"
+	"github.com/filecoin-project/curio/lib/hugepageutil"
 	"github.com/filecoin-project/curio/lib/proof"
 	storiface "github.com/filecoin-project/curio/lib/storiface"
 
@@ -45,6 +47,9 @@ type ExternPrecommit2 func(ctx context.Context, sector storiface.SectorRef, cach
 type SealCalls struct {
 	sectors *storageProvider
 
+	// sdrNUMANode is the NUMA node SDR runs on, -1 when it isn't pinned
+	sdrNUMANode int
+
 	/*// externCalls cointain overrides for calling alternative sealing logic
 	externCalls ExternalSealer*/
 }
@@ -57,9 +62,35 @@ func NewSealCalls(st *paths.Remote, ls *paths.Local, si paths.SectorIndex) *Seal
 			sindex:              si,
 			storageReservations: xsync.NewIntegerMapOf[harmonytask.TaskID, *StorageReservation](),
 		},
+		sdrNUMANode: -1,
 	}
 }
 
+// PinSDR makes SDR (PC1) of single sectors run on the cores of a NUMA node, with its memory allocated from the
+// node. -1 doesn't pin SDR.
+func (sb *SealCalls) PinSDR(node int) {
+	sb.sdrNUMANode = node
+}
+
+// runSDR runs an SDR call, pinned to the configured NUMA node. The pinned thread is discarded after the call.
+func (sb *SealCalls) runSDR(cb func() error) error {
+	if sb.sdrNUMANode < 0 {
+		return cb()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		// never unlocked, so the thread with the changed affinity and memory policy exits with the goroutine
+		runtime.LockOSThread()
+
+		if err := hugepageutil.BindThread(sb.sdrNUMANode); err != nil {
+			log.Warnw("pinning SDR to NUMA node failed, running unpinned", "node", sb.sdrNUMANode, "error", err)
+		}
+		errCh <- cb()
+	}()
+	return <-errCh
+}
+
 type storageProvider struct {
 	storage             *paths.Remote
 	localStore          *paths.Local
@@ -113,7 +144,13 @@ func (l *storageProvider) AcquireSector(ctx context.Context, taskID *harmonytask
 			return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, err
 		}
 
-		releaseStorage, err = l.localStore.Reserve(ctx, sector, allocate, storageIDs, storiface.FSOverheadSeal, paths.MinFreeStoragePercentage)
+		linfo := paths.ReservationLeaseInfo{Purpose: "sector acquire"}
+		if taskID != nil {
+			tid := int64(*taskID)
+			linfo.TaskID = &tid
+		}
+
+		releaseStorage, err = l.localStore.Reserve(paths.WithReservationLease(ctx, linfo), sector, allocate, storageIDs, storiface.FSOverheadSeal, paths.MinFreeStoragePercentage)
 		if err != nil {
 			return storiface.SectorPaths{}, storiface.SectorPaths{}, nil, xerrors.Errorf("reserving storage space: %w", err)
 		}
@@ -176,11 +213,13 @@ func (sb *SealCalls) GenerateSDR(ctx context.Context, taskID harmonytask.TaskID,
 	}
 
 	// generate new sector key
-	err = ffi.GenerateSDR(
-		sector.ProofType,
-		intoTemp,
-		replicaID,
-	)
+	err = sb.runSDR(func() error {
+		return ffi.GenerateSDR(
+			sector.ProofType,
+			intoTemp,
+			replicaID,
+		)
+	})
 	if err != nil {
 		return xerrors.Errorf("generating SDR %d (%s): %w", sector.ID.Number, intoTemp, err)
 	}
@@ -525,23 +564,31 @@ func changePathType(path string, newType storiface.SectorFileType) (string, erro
 
 	return newPath, nil
 }
-func (sb *SealCalls) FinalizeSector(ctx context.Context, sector storiface.SectorRef, keepUnsealed bool) error {
+
+// FinalizeSector clears the sealing intermediates of a sector from its cache, except the ones selected by keep, and
+// turns TreeD into the unsealed copy with keepUnsealed. Returns the space the intermediates used.
+func (sb *SealCalls) FinalizeSector(ctx context.Context, sector storiface.SectorRef, keepUnsealed bool, keep CacheKeep) (CacheUsage, error) {
 	sectorPaths, pathIDs, releaseSector, err := sb.sectors.AcquireSector(ctx, nil, sector, storiface.FTCache, storiface.FTNone, storiface.PathSealing)
 	if err != nil {
-		return xerrors.Errorf("acquiring sector paths: %w", err)
+		return CacheUsage{}, xerrors.Errorf("acquiring sector paths: %w", err)
 	}
 	defer releaseSector()
 
 	ssize, err := sector.ProofType.SectorSize()
 	if err != nil {
-		return xerrors.Errorf("getting sector size: %w", err)
+		return CacheUsage{}, xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	usage, err := cacheUsage(sectorPaths.Cache, ssize, keepUnsealed)
+	if err != nil {
+		return CacheUsage{}, xerrors.Errorf("measuring cache: %w", err)
 	}
 
 	if keepUnsealed {
 		// We are going to be moving the unsealed file, no need to allocate storage specifically for it
 		sectorPaths.Unsealed, err = changePathType(sectorPaths.Cache, storiface.FTUnsealed)
 		if err != nil {
-			return xerrors.Errorf("changing path type: %w", err)
+			return CacheUsage{}, xerrors.Errorf("changing path type: %w", err)
 		}
 
 		pathIDs.Unsealed = pathIDs.Cache // this is just an uuid string
@@ -580,9 +627,9 @@ func (sb *SealCalls) FinalizeSector(ctx context.Context, sector storiface.Sector
 							// so we can just resume where the previous attempt left off
 							goto retryUnsealedMove
 						}
-						return xerrors.Errorf("neither unsealed file nor temp-unsealed file exists")
+						return CacheUsage{}, xerrors.Errorf("neither unsealed file nor temp-unsealed file exists")
 					}
-					return xerrors.Errorf("stat unsealed file: %w", err)
+					return CacheUsage{}, xerrors.Errorf("stat unsealed file: %w", err)
 				}
 				if st.Size() != int64(ssize) {
 					if tempUnsealedExists {
@@ -590,50 +637,57 @@ func (sb *SealCalls) FinalizeSector(ctx context.Context, sector storiface.Sector
 						// so we can just resume where the previous attempt left off with some cleanup
 
 						if err := os.Remove(sectorPaths.Unsealed); err != nil {
-							return xerrors.Errorf("removing unsealed file from last attempt: %w", err)
+							return CacheUsage{}, xerrors.Errorf("removing unsealed file from last attempt: %w", err)
 						}
 
 						goto retryUnsealedMove
 					}
-					return xerrors.Errorf("unsealed file is not the right size: %d != %d and temp unsealed is missing", st.Size(), ssize)
+					return CacheUsage{}, xerrors.Errorf("unsealed file is not the right size: %d != %d and temp unsealed is missing", st.Size(), ssize)
 				}
 
 				// all good, just log that this edge case happened
 				log.Warnw("unsealed file exists but tree-d is missing, skipping move", "sector", sector.ID, "unsealed", sectorPaths.Unsealed, "cache", sectorPaths.Cache)
 				goto afterUnsealedMove
 			}
-			return xerrors.Errorf("stat tree-d file: %w", err)
+			return CacheUsage{}, xerrors.Errorf("stat tree-d file: %w", err)
 		}
 
 		// If the state in clean do the move
 
 		// move tree-d to temp file
 		if err := os.Rename(filepath.Join(sectorPaths.Cache, proofpaths.TreeDName), tempUnsealed); err != nil {
-			return xerrors.Errorf("moving tree-d to temp file: %w", err)
+			return CacheUsage{}, xerrors.Errorf("moving tree-d to temp file: %w", err)
 		}
 
 	retryUnsealedMove:
 
 		// truncate sealed file to sector size
 		if err := os.Truncate(tempUnsealed, int64(ssize)); err != nil {
-			return xerrors.Errorf("truncating unsealed file to sector size: %w", err)
+			return CacheUsage{}, xerrors.Errorf("truncating unsealed file to sector size: %w", err)
 		}
 
 		// move temp file to unsealed location
 		if err := paths.Move(tempUnsealed, sectorPaths.Unsealed); err != nil {
-			return xerrors.Errorf("move temp unsealed sector to final location (%s -> %s): %w", tempUnsealed, sectorPaths.Unsealed, err)
+			return CacheUsage{}, xerrors.Errorf("move temp unsealed sector to final location (%s -> %s): %w", tempUnsealed, sectorPaths.Unsealed, err)
 		}
 	}
 
 afterUnsealedMove:
-	if abi.Synthetic[sector.ProofType] {
-		if err = ffi.ClearSyntheticProofs(uint64(ssize), sectorPaths.Cache); err != nil {
-			return xerrors.Errorf("Unable to delete Synth cache: %w", err)
+	if keep.Layers || keep.Trees {
+		// only remove what isn't kept, the rest is cleared by PurgeSectorCache
+		if err := purgeCacheFiles(sectorPaths.Cache, sector.ProofType, !keep.Layers, !keep.Trees); err != nil {
+			return CacheUsage{}, xerrors.Errorf("purging cache files: %w", err)
+		}
+	} else {
+		if abi.Synthetic[sector.ProofType] {
+			if err = ffi.ClearSyntheticProofs(uint64(ssize), sectorPaths.Cache); err != nil {
+				return CacheUsage{}, xerrors.Errorf("Unable to delete Synth cache: %w", err)
+			}
 		}
-	}
 
-	if err := ffi.ClearCache(uint64(ssize), sectorPaths.Cache); err != nil {
-		return xerrors.Errorf("clearing cache: %w", err)
+		if err := ffi.ClearCache(uint64(ssize), sectorPaths.Cache); err != nil {
+			return CacheUsage{}, xerrors.Errorf("clearing cache: %w", err)
+		}
 	}
 
 	maybeUns := storiface.FTUnsealed
@@ -642,10 +696,10 @@ afterUnsealedMove:
 	}
 
 	if err := sb.ensureOneCopy(ctx, sector.ID, pathIDs, storiface.FTCache|maybeUns); err != nil {
-		return xerrors.Errorf("ensure one copy: %w", err)
+		return CacheUsage{}, xerrors.Errorf("ensure one copy: %w", err)
 	}
 
-	return nil
+	return usage, nil
 }
 
 func (sb *SealCalls) MoveStorage(ctx context.Context, sector storiface.SectorRef, taskID *harmonytask.TaskID) error {
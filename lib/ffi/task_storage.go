@@ -121,7 +121,8 @@ func (t *TaskStorage) Claim(taskID int) (func() error, error) {
 	// B: Create a reservation for existing files to be fetched into local storage
 	// C: Create a reservation for existing files in local storage which may be extended (e.g. sector cache when computing Trees)
 
-	ctx := context.Background()
+	tid := int64(taskID)
+	ctx := storagePaths.WithReservationLease(context.Background(), storagePaths.ReservationLeaseInfo{TaskID: &tid, Purpose: "task storage claim"})
 
 	sectorRef, err := t.taskToSectorRef(harmonytask.TaskID(taskID))
 	if err != nil {
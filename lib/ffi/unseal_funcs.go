@@ -1,6 +1,7 @@
 package ffi
 
 import (
+	"bufio"
 	"context"
 	"io"
 	"os"
@@ -9,9 +10,16 @@ import (
 	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-state-types/abi"
+
 	"github.com/filecoin-project/curio/harmony/harmonytask"
 	"github.com/filecoin-project/curio/lib/ffi/cunative"
+	"github.com/filecoin-project/curio/lib/partialfile"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/proof"
 	"github.com/filecoin-project/curio/lib/storiface"
+
+	"github.com/filecoin-project/lotus/storage/sealer/fr32"
 )
 
 func (sb *SealCalls) decodeCommon(ctx context.Context, taskID harmonytask.TaskID, sector storiface.SectorRef, fileType storiface.SectorFileType, decodeFunc func(sealReader, keyReader io.Reader, outFile io.Writer) error) error {
@@ -24,6 +32,24 @@ func (sb *SealCalls) decodeCommon(ctx context.Context, taskID harmonytask.TaskID
 	}
 	defer releaseSector()
 
+	if err := sb.decodeInto(ctx, sector, fileType, paths.Unsealed, storiface.ID(pathIDs.Unsealed), decodeFunc); err != nil {
+		return err
+	}
+
+	if err := sb.ensureOneCopy(ctx, sector.ID, pathIDs, storiface.FTUnsealed); err != nil {
+		return xerrors.Errorf("ensure one copy: %w", err)
+	}
+
+	if err := sb.sectors.storage.Remove(ctx, sector.ID, storiface.FTKey, true, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// decodeInto decodes the whole replica into the unsealed file at unsealedPath in the local path unsealedID,
+// replacing the file if it exists. The file is encrypted as it is written if the path encrypts unsealed files.
+func (sb *SealCalls) decodeInto(ctx context.Context, sector storiface.SectorRef, fileType storiface.SectorFileType, unsealedPath string, unsealedID storiface.ID, decodeFunc func(sealReader, keyReader io.Reader, outFile io.Writer) error) error {
 	sealReader, err := sb.sectors.storage.ReaderSeq(ctx, sector, fileType)
 	if err != nil {
 		return xerrors.Errorf("getting sealed sector reader: %w", err)
@@ -34,7 +60,12 @@ func (sb *SealCalls) decodeCommon(ctx context.Context, taskID harmonytask.TaskID
 		return xerrors.Errorf("getting key reader: %w", err)
 	}
 
-	tempDest := paths.Unsealed + storiface.TempSuffix
+	ssize, err := sector.ProofType.SectorSize()
+	if err != nil {
+		return xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	tempDest := unsealedPath + storiface.TempSuffix
 
 	outFile, err := os.Create(tempDest)
 	if err != nil {
@@ -42,21 +73,109 @@ func (sb *SealCalls) decodeCommon(ctx context.Context, taskID harmonytask.TaskID
 	}
 	defer outFile.Close()
 
+	out, err := sb.sectors.localStore.UnsealedWriter(unsealedID, outFile, int64(ssize))
+	if err != nil {
+		return xerrors.Errorf("opening unsealed file writer: %w", err)
+	}
+
 	start := time.Now()
 
-	err = decodeFunc(sealReader, keyReader, outFile)
+	err = decodeFunc(sealReader, keyReader, out)
 	if err != nil {
 		return xerrors.Errorf("decoding sealed sector: %w", err)
 	}
+	if err := out.Close(); err != nil {
+		return xerrors.Errorf("writing unsealed file: %w", err)
+	}
 
 	end := time.Now()
 
+	log.Infow("decoded sector", "sectorID", sector, "duration", end.Sub(start), "MiB/s", float64(ssize)/(1<<20)/end.Sub(start).Seconds())
+
+	if err := os.Rename(tempDest, unsealedPath); err != nil {
+		return xerrors.Errorf("renaming to unsealed file: %w", err)
+	}
+
+	return nil
+}
+
+func (sb *SealCalls) DecodeSDR(ctx context.Context, taskID harmonytask.TaskID, sector storiface.SectorRef) error {
+	return sb.decodeCommon(ctx, taskID, sector, storiface.FTSealed, func(sealReader, keyReader io.Reader, outFile io.Writer) error {
+		return cunative.Decode(sealReader, keyReader, outFile)
+	})
+}
+
+func (sb *SealCalls) DecodeSnap(ctx context.Context, taskID harmonytask.TaskID, commD, commK cid.Cid, sector storiface.SectorRef) error {
+	return sb.decodeCommon(ctx, taskID, sector, storiface.FTUpdate, func(sealReader, keyReader io.Reader, outFile io.Writer) error {
+		return cunative.DecodeSnap(sector.ProofType, commD, commK, keyReader, sealReader, outFile)
+	})
+}
+
+// WriteUnsealed writes the unsealed copy of a sector from its unpadded sector data, e.g. rebuilt from the data of
+// its pieces. The data is checked against commD before the copy is declared.
+func (sb *SealCalls) WriteUnsealed(ctx context.Context, taskID harmonytask.TaskID, sector storiface.SectorRef, commD cid.Cid, data io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	ssize, err := sector.ProofType.SectorSize()
 	if err != nil {
 		return xerrors.Errorf("getting sector size: %w", err)
 	}
 
-	log.Infow("decoded sector", "sectorID", sector, "duration", end.Sub(start), "MiB/s", float64(ssize)/(1<<20)/end.Sub(start).Seconds())
+	paths, pathIDs, releaseSector, err := sb.sectors.AcquireSector(ctx, &taskID, sector, storiface.FTNone, storiface.FTUnsealed, storiface.PathStorage)
+	if err != nil {
+		return xerrors.Errorf("acquiring sector paths: %w", err)
+	}
+	defer releaseSector()
+
+	tempDest := paths.Unsealed + storiface.TempSuffix
+
+	outFile, err := os.Create(tempDest)
+	if err != nil {
+		return xerrors.Errorf("creating unsealed file: %w", err)
+	}
+	defer outFile.Close()     // nolint:errcheck
+	defer os.Remove(tempDest) // nolint:errcheck
+
+	start := time.Now()
+
+	bw := bufio.NewWriterSize(outFile, 1<<20)
+	ew, err := sb.sectors.localStore.UnsealedWriter(storiface.ID(pathIDs.Unsealed), bw, int64(ssize))
+	if err != nil {
+		return xerrors.Errorf("opening unsealed file writer: %w", err)
+	}
+	pw := fr32.NewPadWriter(ew)
+	cc := new(proof.DataCidWriter)
+
+	n, err := io.CopyBuffer(io.MultiWriter(pw, cc), io.LimitReader(data, int64(abi.PaddedPieceSize(ssize).Unpadded())), make([]byte, abi.PaddedPieceSize(1<<20).Unpadded()))
+	if err != nil {
+		return xerrors.Errorf("writing unsealed data: %w", err)
+	}
+	if n != int64(abi.PaddedPieceSize(ssize).Unpadded()) {
+		return xerrors.Errorf("sector data is %d bytes, expected %d", n, abi.PaddedPieceSize(ssize).Unpadded())
+	}
+	if err := pw.Close(); err != nil {
+		return xerrors.Errorf("padding unsealed data: %w", err)
+	}
+	if err := ew.Close(); err != nil {
+		return xerrors.Errorf("encrypting unsealed data: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return xerrors.Errorf("flushing unsealed file: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return xerrors.Errorf("closing unsealed file: %w", err)
+	}
+
+	dc, err := cc.Sum()
+	if err != nil {
+		return xerrors.Errorf("computing unsealed CID: %w", err)
+	}
+	if dc.PieceCID != commD {
+		return xerrors.Errorf("unsealed CID %s of the written data doesn't match %s", dc.PieceCID, commD)
+	}
+
+	log.Infow("wrote unsealed sector", "sectorID", sector, "duration", time.Since(start), "MiB/s", float64(ssize)/(1<<20)/time.Since(start).Seconds())
 
 	if err := os.Rename(tempDest, paths.Unsealed); err != nil {
 		return xerrors.Errorf("renaming to unsealed file: %w", err)
@@ -66,6 +185,190 @@ func (sb *SealCalls) decodeCommon(ctx context.Context, taskID harmonytask.TaskID
 		return xerrors.Errorf("ensure one copy: %w", err)
 	}
 
+	return nil
+}
+
+// UnsealRange is a range of sector data, in padded bytes
+type UnsealRange struct {
+	Offset storiface.PaddedByteIndex
+	Size   abi.PaddedPieceSize
+}
+
+// IsUnsealed checks whether the range is in an unsealed copy of the sector, on this node or on any other.
+func (sb *SealCalls) IsUnsealed(ctx context.Context, sector storiface.SectorRef, r UnsealRange) (bool, error) {
+	return sb.sectors.storage.CheckIsUnsealed(ctx, sector, abi.PaddedPieceSize(r.Offset), r.Size)
+}
+
+// ReadUnsealed reads size bytes of sector data at an unpadded offset from an unsealed copy of the sector. The fr32
+// chunks holding the range are read and unpadded, the caller should check that they are unsealed with IsUnsealed.
+func (sb *SealCalls) ReadUnsealed(ctx context.Context, sector storiface.SectorRef, offset storiface.UnpaddedByteIndex, size uint64) ([]byte, error) {
+	rd, err := sb.UnsealedReader(ctx, sector, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close() // nolint:errcheck
+
+	out := make([]byte, size)
+	if _, err := io.ReadFull(rd, out); err != nil {
+		return nil, xerrors.Errorf("reading unsealed sector data: %w", err)
+	}
+	return out, nil
+}
+
+// UnsealedReader is a streaming ReadUnsealed, unpadding the fr32 chunks holding the range as they are read.
+func (sb *SealCalls) UnsealedReader(ctx context.Context, sector storiface.SectorRef, offset storiface.UnpaddedByteIndex, size uint64) (io.ReadCloser, error) {
+	r := UnsealedChunks(offset, size)
+
+	rd, err := sb.sectors.storage.ReaderSeqRange(ctx, sector, storiface.FTUnsealed, r.Offset, r.Size)
+	if err != nil {
+		return nil, xerrors.Errorf("getting unsealed sector reader: %w", err)
+	}
+
+	// chunks small enough for fr32.Unpad to not split the work across threads, which needs power of two sizes
+	bufSize := min(uint64(fr32.MTTresh), uint64(r.Size))
+	return &unpadRangeReader{
+		src:      rd,
+		skip:     uint64(offset) - uint64(r.Offset)/128*127,
+		left:     size,
+		padded:   make([]byte, bufSize),
+		unpadded: make([]byte, bufSize/128*127),
+	}, nil
+}
+
+type unpadRangeReader struct {
+	src io.ReadCloser
+
+	// skip is the number of unpadded bytes before the range in the first chunk
+	skip uint64
+	left uint64
+
+	padded, unpadded []byte
+	buf              []byte // unpadded data not read yet
+}
+
+func (u *unpadRangeReader) Read(p []byte) (int, error) {
+	for len(u.buf) == 0 {
+		if u.left == 0 {
+			return 0, io.EOF
+		}
+
+		want := min(uint64(len(u.padded)), (u.skip+u.left+126)/127*128)
+		if _, err := io.ReadFull(u.src, u.padded[:want]); err != nil {
+			return 0, err
+		}
+
+		out := u.unpadded[:want/128*127]
+		fr32.Unpad(u.padded[:want], out)
+
+		out = out[u.skip:]
+		u.skip = 0
+		if uint64(len(out)) > u.left {
+			out = out[:u.left]
+		}
+		u.left -= uint64(len(out))
+		u.buf = out
+	}
+
+	n := copy(p, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
+}
+
+func (u *unpadRangeReader) Close() error {
+	return u.src.Close()
+}
+
+// UnsealedChunks returns the padded range of the fr32 chunks holding size bytes of sector data at an unpadded offset.
+func UnsealedChunks(offset storiface.UnpaddedByteIndex, size uint64) UnsealRange {
+	first := uint64(offset) / 127
+	last := (uint64(offset) + size + 126) / 127
+	return UnsealRange{
+		Offset: storiface.PaddedByteIndex(first * 128),
+		Size:   abi.PaddedPieceSize((last - first) * 128),
+	}
+}
+
+// DecodeRanges decodes ranges of the sealed replica, or of the updated replica of snap sectors, into the unsealed
+// file of the sector using the sector key. Sectors without an unsealed file get a partial unsealed file holding
+// only the decoded ranges. Unsealed files in paths which encrypt them can't be written in place, so these sectors
+// are decoded whole. Like DecodeSDR and DecodeSnap, the sector key is dropped at the end.
+func (sb *SealCalls) DecodeRanges(ctx context.Context, taskID harmonytask.TaskID, sector storiface.SectorRef, snap bool, commD, commK cid.Cid, ranges []UnsealRange) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ssize, err := sector.ProofType.SectorSize()
+	if err != nil {
+		return xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	existing, allocate := storiface.FTUnsealed, storiface.FTNone
+	si, err := sb.sectors.sindex.StorageFindSector(ctx, sector.ID, storiface.FTUnsealed, 0, false)
+	if err != nil {
+		return xerrors.Errorf("finding unsealed file: %w", err)
+	}
+	if len(si) == 0 {
+		existing, allocate = storiface.FTNone, storiface.FTUnsealed
+	}
+
+	// the storage reserved for the task is for the sector key, the unsealed file is acquired on its own
+	sectorPaths, pathIDs, releaseSector, err := sb.sectors.AcquireSector(ctx, nil, sector, existing, allocate, storiface.PathStorage)
+	if err != nil {
+		return xerrors.Errorf("acquiring sector paths: %w", err)
+	}
+	defer releaseSector()
+
+	fileType := storiface.FTSealed
+	if snap {
+		fileType = storiface.FTUpdate
+	}
+
+	// encrypted partial files can't be written in place, sectors unsealed into encrypted files are decoded whole
+	if sb.sectors.localStore.EncryptsUnsealed(storiface.ID(pathIDs.Unsealed)) || (allocate == storiface.FTNone && paths.IsEncryptedFile(sectorPaths.Unsealed)) {
+		err := sb.decodeInto(ctx, sector, fileType, sectorPaths.Unsealed, storiface.ID(pathIDs.Unsealed), func(sealReader, keyReader io.Reader, outFile io.Writer) error {
+			if snap {
+				return cunative.DecodeSnap(sector.ProofType, commD, commK, keyReader, sealReader, outFile)
+			}
+			return cunative.Decode(sealReader, keyReader, outFile)
+		})
+		if err != nil {
+			return err
+		}
+
+		return sb.finishDecodeRanges(ctx, sector, pathIDs, allocate)
+	}
+
+	if allocate == storiface.FTUnsealed {
+		pf, err := partialfile.CreatePartialFile(abi.PaddedPieceSize(ssize), sectorPaths.Unsealed)
+		if err != nil {
+			return xerrors.Errorf("creating unsealed file: %w", err)
+		}
+		if err := pf.Close(); err != nil {
+			return xerrors.Errorf("closing unsealed file: %w", err)
+		}
+	}
+
+	start := time.Now()
+	var decoded abi.PaddedPieceSize
+	for _, r := range ranges {
+		if err := sb.decodeRange(ctx, sector, fileType, snap, commD, commK, abi.PaddedPieceSize(ssize), sectorPaths.Unsealed, r); err != nil {
+			return xerrors.Errorf("decoding range %d+%d: %w", r.Offset, r.Size, err)
+		}
+		decoded += r.Size
+	}
+
+	log.Infow("decoded sector ranges", "sectorID", sector, "ranges", len(ranges), "duration", time.Since(start), "MiB/s", float64(decoded)/(1<<20)/time.Since(start).Seconds())
+
+	return sb.finishDecodeRanges(ctx, sector, pathIDs, allocate)
+}
+
+// finishDecodeRanges declares an allocated unsealed file as the only copy and drops the sector key
+func (sb *SealCalls) finishDecodeRanges(ctx context.Context, sector storiface.SectorRef, pathIDs storiface.SectorPaths, allocate storiface.SectorFileType) error {
+	if allocate == storiface.FTUnsealed {
+		if err := sb.ensureOneCopy(ctx, sector.ID, pathIDs, storiface.FTUnsealed); err != nil {
+			return xerrors.Errorf("ensure one copy: %w", err)
+		}
+	}
+
 	if err := sb.sectors.storage.Remove(ctx, sector.ID, storiface.FTKey, true, nil); err != nil {
 		return err
 	}
@@ -73,14 +376,47 @@ func (sb *SealCalls) decodeCommon(ctx context.Context, taskID harmonytask.TaskID
 	return nil
 }
 
-func (sb *SealCalls) DecodeSDR(ctx context.Context, taskID harmonytask.TaskID, sector storiface.SectorRef) error {
-	return sb.decodeCommon(ctx, taskID, sector, storiface.FTSealed, func(sealReader, keyReader io.Reader, outFile io.Writer) error {
-		return cunative.Decode(sealReader, keyReader, outFile)
-	})
-}
+// decodeRange decodes one range into the unsealed partial file. The file is opened for each range, as the
+// allocation trailer is written from the state read when the file was opened.
+func (sb *SealCalls) decodeRange(ctx context.Context, sector storiface.SectorRef, fileType storiface.SectorFileType, snap bool, commD, commK cid.Cid, ssize abi.PaddedPieceSize, unsealedPath string, r UnsealRange) error {
+	sealReader, err := sb.sectors.storage.ReaderSeqRange(ctx, sector, fileType, r.Offset, r.Size)
+	if err != nil {
+		return xerrors.Errorf("getting sealed sector reader: %w", err)
+	}
+	defer sealReader.Close() // nolint:errcheck
 
-func (sb *SealCalls) DecodeSnap(ctx context.Context, taskID harmonytask.TaskID, commD, commK cid.Cid, sector storiface.SectorRef) error {
-	return sb.decodeCommon(ctx, taskID, sector, storiface.FTUpdate, func(sealReader, keyReader io.Reader, outFile io.Writer) error {
-		return cunative.DecodeSnap(sector.ProofType, commD, commK, keyReader, sealReader, outFile)
-	})
+	keyReader, err := sb.sectors.storage.ReaderSeqRange(ctx, sector, storiface.FTKey, r.Offset, r.Size)
+	if err != nil {
+		return xerrors.Errorf("getting key reader: %w", err)
+	}
+	defer keyReader.Close() // nolint:errcheck
+
+	pf, err := partialfile.OpenPartialFile(ssize, unsealedPath)
+	if err != nil {
+		return xerrors.Errorf("opening unsealed file: %w", err)
+	}
+
+	err = func() error {
+		w, err := pf.Writer(r.Offset, r.Size)
+		if err != nil {
+			return xerrors.Errorf("getting unsealed file writer: %w", err)
+		}
+
+		if snap {
+			err = cunative.DecodeSnapRange(sector.ProofType, commD, commK, keyReader, sealReader, w, uint64(r.Offset), uint64(r.Size))
+		} else {
+			err = cunative.Decode(io.LimitReader(sealReader, int64(r.Size)), io.LimitReader(keyReader, int64(r.Size)), w)
+		}
+		if err != nil {
+			return xerrors.Errorf("decoding: %w", err)
+		}
+
+		return pf.MarkAllocated(r.Offset, r.Size)
+	}()
+	if err != nil {
+		_ = pf.Close()
+		return err
+	}
+
+	return pf.Close()
 }
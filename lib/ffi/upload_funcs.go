@@ -0,0 +1,56 @@
+package ffi
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// LongHaulTypes returns the sector files of a finalized sector which still have to be uploaded from this node: the
+// sealed file, the cache with tree-r-last and the unsealed file when there is one.
+func (sb *SealCalls) LongHaulTypes(ctx context.Context, sector storiface.SectorRef) (storiface.SectorFileType, error) {
+	ls, err := sb.sectors.localStore.Local(ctx)
+	if err != nil {
+		return storiface.FTNone, xerrors.Errorf("getting local storage: %w", err)
+	}
+	local := map[storiface.ID]struct{}{}
+	for _, l := range ls {
+		local[l.ID] = struct{}{}
+	}
+
+	out := storiface.FTNone
+	for _, ft := range (storiface.FTCache | storiface.FTSealed | storiface.FTUnsealed).AllSet() {
+		stores, err := sb.sectors.sindex.StorageFindSector(ctx, sector.ID, ft, 0, false)
+		if err != nil {
+			return storiface.FTNone, xerrors.Errorf("finding sector: %w", err)
+		}
+		for _, s := range stores {
+			if _, ok := local[s.ID]; ok {
+				out |= ft
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// UploadDestination picks the storage path of another node to upload sector files to
+func (sb *SealCalls) UploadDestination(ctx context.Context, sector storiface.SectorRef, types storiface.SectorFileType) (storiface.ID, error) {
+	return sb.sectors.storage.UploadDestination(ctx, sector, types)
+}
+
+// UploadSector uploads local sector files to the dest storage path of another node, removing the local copies once
+// they are stored there.
+func (sb *SealCalls) UploadSector(ctx context.Context, sector storiface.SectorRef, types storiface.SectorFileType, dest storiface.ID, opts paths.UploadOptions) error {
+	if types == storiface.FTNone {
+		return nil
+	}
+
+	if err := sb.sectors.storage.UploadSector(ctx, sector, types, dest, opts); err != nil {
+		return xerrors.Errorf("uploading sector: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package hugepageutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+// mpolBind is MPOL_BIND from linux/mempolicy.h
+const mpolBind = 2
+
+// BindThread pins the calling OS thread to the cores of a NUMA node and binds its memory allocations to the node.
+// Threads started by the thread afterwards inherit both. The caller must lock its goroutine to the thread, and
+// should let the thread exit with the goroutine instead of unlocking it.
+func BindThread(node int) error {
+	cpuList, err := os.ReadFile(filepath.Join(sysNodes, fmt.Sprintf("node%d", node), "cpulist"))
+	if err != nil {
+		return xerrors.Errorf("reading cpus of NUMA node %d: %w", node, err)
+	}
+	cpus, err := parseCPUList(string(cpuList))
+	if err != nil {
+		return err
+	}
+
+	var set unix.CPUSet
+	set.Zero()
+	for _, c := range cpus {
+		set.Set(c)
+	}
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return xerrors.Errorf("setting cpu affinity: %w", err)
+	}
+
+	mask := make([]uint64, node/64+1)
+	mask[node/64] |= 1 << (node % 64)
+	_, _, errno := unix.Syscall(unix.SYS_SET_MEMPOLICY, mpolBind, uintptr(unsafe.Pointer(&mask[0])), uintptr(len(mask)*64+1))
+	if errno != 0 {
+		return xerrors.Errorf("setting memory policy: %w", errno)
+	}
+
+	return nil
+}
+
+// parseCPUList parses a kernel CPU list, e.g. "0-15,32-47"
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(s), ",") {
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing cpu list %q: %w", s, err)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil {
+				return nil, xerrors.Errorf("parsing cpu list %q: %w", s, err)
+			}
+		}
+		for c := first; c <= last; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package hugepageutil
+
+import "golang.org/x/xerrors"
+
+// BindThread pins the calling OS thread to a NUMA node, which is only supported on Linux.
+func BindThread(node int) error {
+	return xerrors.Errorf("NUMA binding is only supported on linux")
+}
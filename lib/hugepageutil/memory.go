@@ -0,0 +1,218 @@
+package hugepageutil
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	sysHugePages1G = "/sys/kernel/mm/hugepages/hugepages-1048576kB"
+	sysNodes       = "/sys/devices/system/node"
+	sysTHP         = "/sys/kernel/mm/transparent_hugepage/enabled"
+)
+
+// NUMANode is the memory and huge pages of one NUMA node
+type NUMANode struct {
+	Node       int
+	CPUs       string
+	MemTotalKB int64
+
+	HugePages1GTotal int64
+	HugePages1GFree  int64
+}
+
+// MemoryStatus is the huge page and NUMA setup of the machine, with the outcome of the pre-flight checks of the
+// PC1 memory config
+type MemoryStatus struct {
+	HugePageSizeKB int64
+	HugePagesTotal int64
+	HugePagesFree  int64
+
+	HugePages1GTotal int64
+	HugePages1GFree  int64
+
+	// TransparentHugePages is the selected transparent huge page mode, always, madvise or never
+	TransparentHugePages string
+
+	Nodes []NUMANode
+
+	// ReservedHugePages is the number of 1GiB pages the config reserves, PC1NUMANode the node PC1 is pinned to
+	ReservedHugePages int
+	PC1NUMANode       int
+
+	Warnings []string
+}
+
+// ReadMemoryStatus reads the huge page and NUMA setup of the machine
+func ReadMemoryStatus() (MemoryStatus, error) {
+	st := MemoryStatus{PC1NUMANode: -1}
+
+	mi, err := readKBFile("/proc/meminfo", "")
+	if err != nil {
+		return st, err
+	}
+	st.HugePageSizeKB = mi["Hugepagesize"]
+	st.HugePagesTotal = mi["HugePages_Total"]
+	st.HugePagesFree = mi["HugePages_Free"]
+
+	st.HugePages1GTotal, _ = readInt(filepath.Join(sysHugePages1G, "nr_hugepages"))
+	st.HugePages1GFree, _ = readInt(filepath.Join(sysHugePages1G, "free_hugepages"))
+
+	if thp, err := os.ReadFile(sysTHP); err == nil {
+		// e.g. "always [madvise] never"
+		s := string(thp)
+		if i, j := strings.Index(s, "["), strings.Index(s, "]"); i >= 0 && j > i {
+			st.TransparentHugePages = s[i+1 : j]
+		}
+	}
+
+	nodes, err := filepath.Glob(filepath.Join(sysNodes, "node[0-9]*"))
+	if err != nil {
+		return st, xerrors.Errorf("listing NUMA nodes: %w", err)
+	}
+	for _, dir := range nodes {
+		n, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+
+		node := NUMANode{Node: n}
+		if cpus, err := os.ReadFile(filepath.Join(dir, "cpulist")); err == nil {
+			node.CPUs = strings.TrimSpace(string(cpus))
+		}
+		if nmi, err := readKBFile(filepath.Join(dir, "meminfo"), fmt.Sprintf("Node %d ", n)); err == nil {
+			node.MemTotalKB = nmi["MemTotal"]
+		}
+		node.HugePages1GTotal, _ = readInt(filepath.Join(dir, "hugepages", "hugepages-1048576kB", "nr_hugepages"))
+		node.HugePages1GFree, _ = readInt(filepath.Join(dir, "hugepages", "hugepages-1048576kB", "free_hugepages"))
+
+		st.Nodes = append(st.Nodes, node)
+	}
+	sort.Slice(st.Nodes, func(i, j int) bool {
+		return st.Nodes[i].Node < st.Nodes[j].Node
+	})
+
+	return st, nil
+}
+
+// ReserveHugePages sets the number of 1GiB huge pages of the machine, or of one NUMA node when node isn't
+// negative. The kernel may reserve fewer pages than asked when memory is fragmented, which is returned as an
+// error. Needs root or CAP_SYS_ADMIN.
+func ReserveHugePages(pages int, node int) error {
+	path := filepath.Join(sysHugePages1G, "nr_hugepages")
+	if node >= 0 {
+		path = filepath.Join(sysNodes, fmt.Sprintf("node%d", node), "hugepages", "hugepages-1048576kB", "nr_hugepages")
+	}
+
+	if err := os.WriteFile(path, []byte(strconv.Itoa(pages)), 0644); err != nil {
+		return xerrors.Errorf("reserving huge pages: %w", err)
+	}
+
+	got, err := readInt(path)
+	if err != nil {
+		return err
+	}
+	if got < int64(pages) {
+		return xerrors.Errorf("only %d of %d 1GiB huge pages were reserved, reserve them early after boot or on the kernel command line", got, pages)
+	}
+	return nil
+}
+
+// Preflight reserves the configured huge pages and checks the PC1 memory config against the machine. Problems are
+// returned as warnings in the status, they don't stop sealing.
+func Preflight(hugePages, hugePagesNode, pc1Node int) MemoryStatus {
+	var warnings []string
+
+	if hugePages > 0 {
+		if err := ReserveHugePages(hugePages, hugePagesNode); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+
+	st, err := ReadMemoryStatus()
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("reading memory status: %s", err))
+	}
+	st.ReservedHugePages = hugePages
+	st.PC1NUMANode = pc1Node
+
+	hasNode := func(n int) bool {
+		for _, node := range st.Nodes {
+			if node.Node == n {
+				return true
+			}
+		}
+		return false
+	}
+
+	if hugePagesNode >= 0 && !hasNode(hugePagesNode) {
+		warnings = append(warnings, fmt.Sprintf("huge pages NUMA node %d doesn't exist, the machine has %d nodes", hugePagesNode, len(st.Nodes)))
+	}
+	if pc1Node >= 0 {
+		if !hasNode(pc1Node) {
+			warnings = append(warnings, fmt.Sprintf("PC1 NUMA node %d doesn't exist, the machine has %d nodes, PC1 isn't pinned", pc1Node, len(st.Nodes)))
+		} else if len(st.Nodes) == 1 {
+			warnings = append(warnings, "the machine has a single NUMA node, pinning PC1 only restricts its cores")
+		}
+	}
+	if hugePages > 0 && st.HugePages1GFree == 0 {
+		warnings = append(warnings, "no free 1GiB huge pages")
+	}
+	if pc1Node >= 0 && st.TransparentHugePages == "never" {
+		warnings = append(warnings, "transparent huge pages are disabled, PC1 runs without huge pages outside the batch sealer")
+	}
+
+	st.Warnings = warnings
+	return st
+}
+
+// readKBFile reads a meminfo style file into a map of values, in kB for sizes. prefix is stripped from each line.
+func readKBFile(path, prefix string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close() // nolint:errcheck
+
+	out := map[string]int64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimPrefix(scanner.Text(), prefix)
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(v)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		out[strings.TrimSpace(k)] = n
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("reading %s: %w", path, err)
+	}
+	return out, nil
+}
+
+func readInt(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, xerrors.Errorf("reading %s: %w", path, err)
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, xerrors.Errorf("parsing %s: %w", path, err)
+	}
+	return n, nil
+}
@@ -4,22 +4,48 @@ import (
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
 
 	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
 
 	"github.com/filecoin-project/lotus/api"
 )
 
-func AddressSelector(addrConf []config.CurioAddresses) func() (*MultiAddressSelector, error) {
+// AddressSelector creates the address selector of the configured miners. db is used to count pending messages for
+// the 'pending' sender selection, and may be nil when it isn't used.
+func AddressSelector(db *harmonydb.DB, addrConf []config.CurioAddresses) func() (*MultiAddressSelector, error) {
 	return func() (*MultiAddressSelector, error) {
 		as := &MultiAddressSelector{
-			MinerMap: make(map[address.Address]api.AddressConfig),
+			MinerMap:    make(map[address.Address]api.AddressConfig),
+			MultisigMap: make(map[address.Address]MultisigFunds),
+			PolicyMap:   make(map[address.Address]SelectionPolicy),
+			db:          db,
 		}
 		if addrConf == nil {
 			return as, nil
 		}
 
 		for _, addrConf := range addrConf {
+			var msig *MultisigFunds
+			if addrConf.FundsMultisig != "" {
+				m, err := address.NewFromString(addrConf.FundsMultisig)
+				if err != nil {
+					return nil, xerrors.Errorf("parsing funds multisig address: %w", err)
+				}
+				p, err := address.NewFromString(addrConf.FundsMultisigProposer)
+				if err != nil {
+					return nil, xerrors.Errorf("parsing funds multisig proposer address: %w", err)
+				}
+				msig = &MultisigFunds{Multisig: m, Proposer: p}
+			}
+
+			strategy, err := parseStrategy(addrConf.SenderSelection)
+			if err != nil {
+				return nil, err
+			}
+			pol := SelectionPolicy{Strategy: strategy, MinBalance: abi.TokenAmount(addrConf.MinSenderBalance)}
+
 			for _, minerID := range addrConf.MinerAddresses {
 				tmp := api.AddressConfig{
 					DisableOwnerFallback:  addrConf.DisableOwnerFallback,
@@ -57,6 +83,10 @@ func AddressSelector(addrConf []config.CurioAddresses) func() (*MultiAddressSele
 					return nil, xerrors.Errorf("parsing miner address %s: %w", minerID, err)
 				}
 				as.MinerMap[a] = tmp
+				as.PolicyMap[a] = pol
+				if msig != nil {
+					as.MultisigMap[a] = *msig
+				}
 			}
 		}
 		return as, nil
@@ -9,6 +9,8 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/storage/ctladdr"
@@ -17,7 +19,11 @@ import (
 var log = logging.Logger("curio/multictladdr")
 
 type MultiAddressSelector struct {
-	MinerMap map[address.Address]api.AddressConfig
+	MinerMap    map[address.Address]api.AddressConfig
+	MultisigMap map[address.Address]MultisigFunds
+	PolicyMap   map[address.Address]SelectionPolicy
+
+	db *harmonydb.DB
 }
 
 func (as *MultiAddressSelector) AddressFor(ctx context.Context, a ctladdr.NodeApi, minerID address.Address, mi api.MinerInfo, use api.AddrUse, goodFunds, minFunds abi.TokenAmount) (address.Address, abi.TokenAmount, error) {
@@ -51,6 +57,10 @@ func (as *MultiAddressSelector) AddressFor(ctx context.Context, a ctladdr.NodeAp
 		configCtl = append(configCtl, tmp.CommitControl...)
 		configCtl = append(configCtl, tmp.TerminateControl...)
 		configCtl = append(configCtl, tmp.DealPublishControl...)
+		if mf, ok := as.Multisig(minerID); ok {
+			// multisigs can't sign, they only send messages proposed to them
+			configCtl = append(configCtl, mf.Multisig)
+		}
 
 		for _, addr := range configCtl {
 			if addr.Protocol() != address.ID {
@@ -70,6 +80,10 @@ func (as *MultiAddressSelector) AddressFor(ctx context.Context, a ctladdr.NodeAp
 		}
 	}
 
+	if pol := as.PolicyMap[minerID]; !pol.isDefault() && len(addrs) > 0 {
+		addrs = as.orderAddresses(ctx, a, addrs, pol)
+	}
+
 	if len(addrs) == 0 || !tmp.DisableWorkerFallback {
 		addrs = append(addrs, mi.Worker)
 	}
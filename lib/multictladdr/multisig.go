@@ -0,0 +1,59 @@
+package multictladdr
+
+import (
+	"bytes"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	msig13 "github.com/filecoin-project/go-state-types/builtin/v13/multisig"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// MultisigFunds is a multisig which pays for, and sends, funded messages of a miner. The multisig must be a control
+// address of the miner; Proposer is a signer of the multisig which proposes the messages and pays their gas.
+type MultisigFunds struct {
+	Multisig address.Address
+	Proposer address.Address
+}
+
+// Multisig returns the funds multisig configured for the miner.
+func (as *MultiAddressSelector) Multisig(minerID address.Address) (MultisigFunds, bool) {
+	if as == nil {
+		return MultisigFunds{}, false
+	}
+	mf, ok := as.MultisigMap[minerID]
+	return mf, ok
+}
+
+// MultisigProposal wraps msg, sent to the miner actor, in a proposal to the funds multisig of the miner. When no
+// multisig is configured for the miner, msg is returned as is with false.
+func (as *MultiAddressSelector) MultisigProposal(minerID address.Address, msg *types.Message) (*types.Message, bool, error) {
+	mf, ok := as.Multisig(minerID)
+	if !ok {
+		return msg, false, nil
+	}
+
+	params := &msig13.ProposeParams{
+		To:     msg.To,
+		Value:  msg.Value,
+		Method: msg.Method,
+		Params: msg.Params,
+	}
+
+	var pbuf bytes.Buffer
+	if err := params.MarshalCBOR(&pbuf); err != nil {
+		return nil, false, xerrors.Errorf("serializing propose params: %w", err)
+	}
+
+	return &types.Message{
+		From:   mf.Proposer,
+		To:     mf.Multisig,
+		Method: builtin.MethodsMultisig.Propose,
+		Params: pbuf.Bytes(),
+		Value:  big.Zero(),
+	}, true, nil
+}
@@ -0,0 +1,138 @@
+package multictladdr
+
+import (
+	"context"
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/storage/ctladdr"
+)
+
+const (
+	// SelectFirst picks the first address, in configuration order, with enough funds for the message
+	SelectFirst = "first"
+	// SelectBalance picks the address with the most balance
+	SelectBalance = "balance"
+	// SelectPending picks the address with the fewest messages pending inclusion, then the most balance
+	SelectPending = "pending"
+)
+
+// SelectionPolicy is how a sending address is picked among the addresses of a role.
+type SelectionPolicy struct {
+	Strategy   string
+	MinBalance abi.TokenAmount
+}
+
+func (p SelectionPolicy) isDefault() bool {
+	return (p.Strategy == SelectFirst || p.Strategy == "") && p.MinBalance.NilOrZero()
+}
+
+func parseStrategy(s string) (string, error) {
+	switch s {
+	case "", SelectFirst:
+		return SelectFirst, nil
+	case SelectBalance, SelectPending:
+		return s, nil
+	default:
+		return "", xerrors.Errorf("unknown sender selection '%s'", s)
+	}
+}
+
+type candidate struct {
+	addr    address.Address
+	balance abi.TokenAmount
+	pending int
+}
+
+// orderAddresses orders addrs by the selection policy for ctladdr.PickAddress, which uses the first address with
+// enough funds. Addresses below the minimum balance are dropped, unless all are.
+func (as *MultiAddressSelector) orderAddresses(ctx context.Context, a ctladdr.NodeApi, addrs []address.Address, pol SelectionPolicy) []address.Address {
+	var pending map[string]int
+	if pol.Strategy == SelectPending {
+		var err error
+		pending, err = as.pendingMessages(ctx)
+		if err != nil {
+			log.Warnw("getting pending messages, selecting by balance", "error", err)
+		}
+	}
+
+	var cands []candidate
+	for _, addr := range addrs {
+		b, err := a.WalletBalance(ctx, addr)
+		if err != nil {
+			log.Errorw("checking control address balance", "addr", addr, "error", err)
+			continue
+		}
+		if !pol.MinBalance.NilOrZero() && b.LessThan(pol.MinBalance) {
+			log.Debugw("skipping address below minimum balance", "addr", addr, "balance", types.FIL(b), "min", types.FIL(pol.MinBalance))
+			continue
+		}
+
+		c := candidate{addr: addr, balance: b}
+		if pending != nil {
+			k, err := a.StateAccountKey(ctx, addr, types.EmptyTSK)
+			if err != nil {
+				log.Errorw("getting account key", "addr", addr, "error", err)
+				continue
+			}
+			c.pending = pending[k.String()]
+		}
+		cands = append(cands, c)
+	}
+
+	if len(cands) == 0 {
+		log.Warnw("no address above the minimum balance, selecting from all addresses", "min", types.FIL(pol.MinBalance))
+		return addrs
+	}
+
+	switch pol.Strategy {
+	case SelectBalance:
+		sort.SliceStable(cands, func(i, j int) bool {
+			return cands[i].balance.GreaterThan(cands[j].balance)
+		})
+	case SelectPending:
+		sort.SliceStable(cands, func(i, j int) bool {
+			if cands[i].pending != cands[j].pending {
+				return cands[i].pending < cands[j].pending
+			}
+			return cands[i].balance.GreaterThan(cands[j].balance)
+		})
+	}
+
+	out := make([]address.Address, len(cands))
+	for i, c := range cands {
+		out[i] = c.addr
+	}
+	return out
+}
+
+// pendingMessages counts messages queued or sent from each key address which are not executed yet.
+func (as *MultiAddressSelector) pendingMessages(ctx context.Context) (map[string]int, error) {
+	if as.db == nil {
+		return nil, xerrors.Errorf("no database")
+	}
+
+	var counts []struct {
+		FromKey string `db:"from_key"`
+		Count   int    `db:"count"`
+	}
+	err := as.db.Select(ctx, &counts, `SELECT s.from_key, COUNT(*) AS count FROM message_sends s
+		LEFT JOIN message_waits w ON w.signed_message_cid = COALESCE(s.replaces_signed_cid, s.held_cid, s.signed_cid)
+		WHERE s.send_success IS NULL
+		   OR (s.send_success = TRUE AND w.signed_message_cid IS NOT NULL AND w.executed_tsk_epoch IS NULL)
+		GROUP BY s.from_key`)
+	if err != nil {
+		return nil, xerrors.Errorf("counting pending messages: %w", err)
+	}
+
+	out := make(map[string]int, len(counts))
+	for _, c := range counts {
+		out[c.FromKey] = c.Count
+	}
+	return out, nil
+}
@@ -0,0 +1,63 @@
+package multictladdr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type testNodeApi struct {
+	balances map[address.Address]big.Int
+}
+
+func (t *testNodeApi) WalletBalance(ctx context.Context, a address.Address) (types.BigInt, error) {
+	return t.balances[a], nil
+}
+
+func (t *testNodeApi) WalletHas(ctx context.Context, a address.Address) (bool, error) {
+	return true, nil
+}
+
+func (t *testNodeApi) StateAccountKey(ctx context.Context, a address.Address, tsk types.TipSetKey) (address.Address, error) {
+	return a, nil
+}
+
+func (t *testNodeApi) StateLookupID(ctx context.Context, a address.Address, tsk types.TipSetKey) (address.Address, error) {
+	return a, nil
+}
+
+func TestOrderAddresses(t *testing.T) {
+	ctx := context.Background()
+
+	a1, _ := address.NewIDAddress(1001)
+	a2, _ := address.NewIDAddress(1002)
+	a3, _ := address.NewIDAddress(1003)
+
+	node := &testNodeApi{balances: map[address.Address]big.Int{
+		a1: types.FromFil(1),
+		a2: types.FromFil(5),
+		a3: types.FromFil(3),
+	}}
+	as := &MultiAddressSelector{}
+	addrs := []address.Address{a1, a2, a3}
+
+	ordered := as.orderAddresses(ctx, node, addrs, SelectionPolicy{Strategy: SelectBalance})
+	require.Equal(t, []address.Address{a2, a3, a1}, ordered)
+
+	ordered = as.orderAddresses(ctx, node, addrs, SelectionPolicy{Strategy: SelectFirst, MinBalance: types.FromFil(2)})
+	require.Equal(t, []address.Address{a2, a3}, ordered)
+
+	// all below the minimum, nothing is dropped
+	ordered = as.orderAddresses(ctx, node, addrs, SelectionPolicy{Strategy: SelectFirst, MinBalance: types.FromFil(10)})
+	require.Equal(t, addrs, ordered)
+
+	// without a database pending counts are unknown, addresses are ordered by balance
+	ordered = as.orderAddresses(ctx, node, addrs, SelectionPolicy{Strategy: SelectPending})
+	require.Equal(t, []address.Address{a2, a3, a1}, ordered)
+}
@@ -36,9 +36,15 @@ type PartialFile struct {
 	path      string
 	allocated rlepluslazy.RLE
 
+	// file is nil for read-only partial files
 	file *os.File
+
+	data   io.ReaderAt
+	closer io.Closer
 }
 
+var errReadOnly = xerrors.New("partial file opened read-only")
+
 func writeTrailer(maxPieceSize int64, w *os.File, r rlepluslazy.RunIterator) error {
 	trailer, err := rlepluslazy.EncodeRuns(r, nil)
 	if err != nil {
@@ -105,12 +111,27 @@ func OpenPartialFile(maxPieceSize abi.PaddedPieceSize, path string) (*PartialFil
 
 	st, err := f.Stat()
 	if err != nil {
+		_ = f.Close()
 		return nil, xerrors.Errorf("stat '%s': %w", path, err)
 	}
-	if st.Size() < int64(maxPieceSize) {
-		return nil, xerrors.Errorf("sector file '%s' was smaller than the sector size %d < %d", path, st.Size(), maxPieceSize)
+
+	pf, err := OpenPartialFileReader(maxPieceSize, path, f, st.Size(), f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	pf.file = f
+
+	return pf, nil
+}
+
+// OpenPartialFileReader opens a read-only partial file of the given size backed by r, e.g. a
+// decrypting reader. The closer is called when the partial file is closed.
+func OpenPartialFileReader(maxPieceSize abi.PaddedPieceSize, path string, r io.ReaderAt, size int64, closer io.Closer) (*PartialFile, error) {
+	if size < int64(maxPieceSize) {
+		return nil, xerrors.Errorf("sector file '%s' was smaller than the sector size %d < %d", path, size, maxPieceSize)
 	}
-	if st.Size() == int64(maxPieceSize) {
+	if size == int64(maxPieceSize) {
 		log.Debugw("no partial file trailer, assuming fully allocated", "path", path)
 
 		allAlloc := &rlepluslazy.RunSliceIterator{Runs: []rlepluslazy.Run{{Val: true, Len: uint64(maxPieceSize)}}}
@@ -128,15 +149,16 @@ func OpenPartialFile(maxPieceSize abi.PaddedPieceSize, path string) (*PartialFil
 			maxPiece:  maxPieceSize,
 			path:      path,
 			allocated: rle,
-			file:      f,
+			data:      r,
+			closer:    closer,
 		}, nil
 	}
 
 	var rle rlepluslazy.RLE
-	err = func() error {
+	err := func() error {
 		// read trailer
 		var tlen [4]byte
-		_, err = f.ReadAt(tlen[:], st.Size()-int64(len(tlen)))
+		_, err := r.ReadAt(tlen[:], size-int64(len(tlen)))
 		if err != nil {
 			return xerrors.Errorf("reading trailer length: %w", err)
 		}
@@ -144,20 +166,20 @@ func OpenPartialFile(maxPieceSize abi.PaddedPieceSize, path string) (*PartialFil
 		// sanity-check the length
 		trailerLen := binary.LittleEndian.Uint32(tlen[:])
 		expectLen := int64(trailerLen) + int64(len(tlen)) + int64(maxPieceSize)
-		if expectLen != st.Size() {
-			return xerrors.Errorf("file '%s' has inconsistent length; has %d bytes; expected %d (%d trailer, %d sector data)", path, st.Size(), expectLen, int64(trailerLen)+int64(len(tlen)), maxPieceSize)
+		if expectLen != size {
+			return xerrors.Errorf("file '%s' has inconsistent length; has %d bytes; expected %d (%d trailer, %d sector data)", path, size, expectLen, int64(trailerLen)+int64(len(tlen)), maxPieceSize)
 		}
 		if trailerLen > veryLargeRle {
 			log.Warnf("Partial file '%s' has a VERY large trailer with %d bytes", path, trailerLen)
 		}
 
-		trailerStart := st.Size() - int64(len(tlen)) - int64(trailerLen)
+		trailerStart := size - int64(len(tlen)) - int64(trailerLen)
 		if trailerStart != int64(maxPieceSize) {
 			return xerrors.Errorf("expected sector size to equal trailer start index")
 		}
 
 		trailerBytes := make([]byte, trailerLen)
-		_, err = f.ReadAt(trailerBytes, trailerStart)
+		_, err = r.ReadAt(trailerBytes, trailerStart)
 		if err != nil {
 			return xerrors.Errorf("reading trailer: %w", err)
 		}
@@ -188,7 +210,6 @@ func OpenPartialFile(maxPieceSize abi.PaddedPieceSize, path string) (*PartialFil
 		return nil
 	}()
 	if err != nil {
-		_ = f.Close()
 		return nil, err
 	}
 
@@ -196,15 +217,20 @@ func OpenPartialFile(maxPieceSize abi.PaddedPieceSize, path string) (*PartialFil
 		maxPiece:  maxPieceSize,
 		path:      path,
 		allocated: rle,
-		file:      f,
+		data:      r,
+		closer:    closer,
 	}, nil
 }
 
 func (pf *PartialFile) Close() error {
-	return pf.file.Close()
+	return pf.closer.Close()
 }
 
 func (pf *PartialFile) Writer(offset storiface.PaddedByteIndex, size abi.PaddedPieceSize) (io.Writer, error) {
+	if pf.file == nil {
+		return nil, errReadOnly
+	}
+
 	if _, err := pf.file.Seek(int64(offset), io.SeekStart); err != nil {
 		return nil, xerrors.Errorf("seek piece start: %w", err)
 	}
@@ -234,6 +260,10 @@ func (pf *PartialFile) Writer(offset storiface.PaddedByteIndex, size abi.PaddedP
 }
 
 func (pf *PartialFile) MarkAllocated(offset storiface.PaddedByteIndex, size abi.PaddedPieceSize) error {
+	if pf.file == nil {
+		return errReadOnly
+	}
+
 	have, err := pf.allocated.RunIterator()
 	if err != nil {
 		return err
@@ -252,6 +282,10 @@ func (pf *PartialFile) MarkAllocated(offset storiface.PaddedByteIndex, size abi.
 }
 
 func (pf *PartialFile) Free(offset storiface.PaddedByteIndex, size abi.PaddedPieceSize) error {
+	if pf.file == nil {
+		return errReadOnly
+	}
+
 	have, err := pf.allocated.RunIterator()
 	if err != nil {
 		return err
@@ -277,8 +311,10 @@ func (pf *PartialFile) Free(offset storiface.PaddedByteIndex, size abi.PaddedPie
 // starting at the given offset and reading the given size. Safe for concurrent
 // use.
 func (pf *PartialFile) Reader(offset storiface.PaddedByteIndex, size abi.PaddedPieceSize) (io.Reader, error) {
-	if _, err := pf.file.Seek(int64(offset), io.SeekStart); err != nil {
-		return nil, xerrors.Errorf("seek piece start: %w", err)
+	if pf.file != nil {
+		if _, err := pf.file.Seek(int64(offset), io.SeekStart); err != nil {
+			return nil, xerrors.Errorf("seek piece start: %w", err)
+		}
 	}
 
 	{
@@ -302,7 +338,7 @@ func (pf *PartialFile) Reader(offset storiface.PaddedByteIndex, size abi.PaddedP
 		}
 	}
 
-	return io.LimitReader(readerutil.NewReadSeekerFromReaderAt(pf.file, int64(offset)), int64(size)), nil
+	return io.LimitReader(readerutil.NewReadSeekerFromReaderAt(pf.data, int64(offset)), int64(size)), nil
 }
 
 func (pf *PartialFile) Allocated() (rlepluslazy.RunIterator, error) {
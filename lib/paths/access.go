@@ -0,0 +1,38 @@
+package paths
+
+import (
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// noteUnsealedAccess records a read of the local unsealed file of a sector
+func (st *Local) noteUnsealedAccess(sid abi.SectorID) {
+	st.accessLk.Lock()
+	defer st.accessLk.Unlock()
+
+	if st.accesses == nil {
+		st.accesses = map[abi.SectorID]time.Time{}
+	}
+	st.accesses[sid] = time.Now()
+}
+
+// TakeUnsealedAccesses returns the time of the last read of unsealed files of each sector read through this
+// store since the previous call
+func (st *Local) TakeUnsealedAccesses() map[abi.SectorID]time.Time {
+	st.accessLk.Lock()
+	defer st.accessLk.Unlock()
+
+	out := st.accesses
+	st.accesses = nil
+	return out
+}
+
+// storeNoteUnsealedAccess records a read of an unsealed file in a local store
+func storeNoteUnsealedAccess(s Store, sid abi.SectorID) {
+	l, ok := s.(*Local)
+	if !ok {
+		return
+	}
+	l.noteUnsealedAccess(sid)
+}
@@ -37,6 +37,8 @@ type DBIndex struct {
 	alerting   alertinginterface.AlertingInterface
 	pathAlerts map[storiface.ID]alertinginterface.AlertType
 
+	placement PlacementPolicy
+
 	harmonyDB *harmonydb.DB
 }
 
@@ -46,6 +48,8 @@ func NewDBIndex(al alertinginterface.AlertingInterface, db *harmonydb.DB) *DBInd
 
 		alerting:   al,
 		pathAlerts: map[storiface.ID]alertinginterface.AlertType{},
+
+		placement: DefaultPlacementPolicy,
 	}
 }
 
@@ -207,7 +211,7 @@ func (dbi *DBIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 			currUrls = union(currUrls, si.URLs)
 
 			_, err = tx.Exec(
-				"UPDATE storage_path set urls=$1, weight=$2, max_storage=$3, can_seal=$4, can_store=$5, groups=$6, allow_to=$7, allow_types=$8, deny_types=$9, allow_miners=$10, deny_miners=$11, last_heartbeat=NOW() WHERE storage_id=$12",
+				"UPDATE storage_path set urls=$1, weight=$2, max_storage=$3, can_seal=$4, can_store=$5, groups=$6, allow_to=$7, allow_types=$8, deny_types=$9, allow_miners=$10, deny_miners=$11, tier=$12, archive=$13, last_heartbeat=NOW() WHERE storage_id=$14",
 				strings.Join(currUrls, URLSeparator),
 				si.Weight,
 				si.MaxStorage,
@@ -219,6 +223,8 @@ func (dbi *DBIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 				strings.Join(si.DenyTypes, ","),
 				strings.Join(si.AllowMiners, ","),
 				strings.Join(si.DenyMiners, ","),
+				si.Tier,
+				si.Archive,
 				si.ID)
 			if err != nil {
 				return false, xerrors.Errorf("storage attach UPDATE fails: %w", err)
@@ -229,8 +235,8 @@ func (dbi *DBIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 
 		// Insert storage id
 		_, err = tx.Exec(
-			"INSERT INTO storage_path (storage_id, urls, weight, max_storage, can_seal, can_store, groups, allow_to, allow_types, deny_types, capacity, available, fs_available, reserved, used, last_heartbeat, heartbeat_err, allow_miners, deny_miners)"+
-				"Values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, NOW(), NULL, $16, $17)",
+			"INSERT INTO storage_path (storage_id, urls, weight, max_storage, can_seal, can_store, groups, allow_to, allow_types, deny_types, capacity, available, fs_available, reserved, used, last_heartbeat, heartbeat_err, allow_miners, deny_miners, tier, archive)"+
+				"Values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, NOW(), NULL, $16, $17, $18, $19)",
 			si.ID,
 			strings.Join(si.URLs, ","),
 			si.Weight,
@@ -247,7 +253,9 @@ func (dbi *DBIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 			st.Reserved,
 			st.Used,
 			strings.Join(si.AllowMiners, ","),
-			strings.Join(si.DenyMiners, ","))
+			strings.Join(si.DenyMiners, ","),
+			si.Tier,
+			si.Archive)
 		if err != nil {
 			return false, xerrors.Errorf("StorageAttach insert fails: %w", err)
 		}
@@ -572,6 +580,8 @@ func (dbi *DBIndex) StorageFindSector(ctx context.Context, s abi.SectorID, ft st
 		AllowTo    string
 		AllowTypes string
 		DenyTypes  string
+		Degraded   bool
+		Archive    bool
 	}
 
 	var rows []dbRes
@@ -590,7 +600,9 @@ func (dbi *DBIndex) StorageFindSector(ctx context.Context, s abi.SectorID, ft st
 						  groups,
 						  allow_to,
 						  allow_types,
-						  deny_types
+						  deny_types,
+						  degraded,
+						  archive
 						FROM sector_location sec
 						JOIN storage_path stor ON sec.storage_id = stor.storage_id 
 						WHERE sec.miner_id = $1
@@ -624,6 +636,8 @@ func (dbi *DBIndex) StorageFindSector(ctx context.Context, s abi.SectorID, ft st
 			CanSeal:    row.CanSeal,
 			CanStore:   row.CanStore,
 			Primary:    row.IsPrimary,
+			Degraded:   row.Degraded,
+			Archive:    row.Archive,
 			AllowTypes: splitString(row.AllowTypes),
 			DenyTypes:  splitString(row.DenyTypes),
 		})
@@ -652,9 +666,10 @@ func (dbi *DBIndex) StorageFindSector(ctx context.Context, s abi.SectorID, ft st
 		// 2. Available >= spaceReq
 		// 3. curr_time - last_heartbeat < SkippedHeartbeatThresh
 		// 4. heartbeat_err is NULL
-		// 5. not one of the earlier picked storage ids
-		// 6. !ft.AnyAllowed(st.info.AllowTypes, st.info.DenyTypes)
-		// 7. Storage path is part of the groups which are allowed from the storage paths which already hold the sector
+		// 5. not read-only or an archive
+		// 6. not one of the earlier picked storage ids
+		// 7. !ft.AnyAllowed(st.info.AllowTypes, st.info.DenyTypes)
+		// 8. Storage path is part of the groups which are allowed from the storage paths which already hold the sector
 
 		var rows []struct {
 			StorageId   string
@@ -683,7 +698,9 @@ func (dbi *DBIndex) StorageFindSector(ctx context.Context, s abi.SectorID, ft st
 				WHERE can_seal=true 
 				  and available >= $1 
 				  and NOW()-($2 * INTERVAL '1 second') < last_heartbeat
-				  and heartbeat_err is null`,
+				  and heartbeat_err is null
+				  and not read_only
+				  and not archive`,
 			spaceReq, SkippedHeartbeatThresh.Seconds())
 		if err != nil {
 			return nil, xerrors.Errorf("Selecting allowfetch storage paths from DB fails err: %w", err)
@@ -768,10 +785,13 @@ func (dbi *DBIndex) StorageInfo(ctx context.Context, id storiface.ID) (storiface
 		DenyTypes   string
 		AllowMiners string
 		DenyMiners  string
+		Tier        string
+		Archive     bool
+		ReadOnly    bool
 	}
 
 	err := dbi.harmonyDB.Select(ctx, &qResults,
-		"SELECT urls, weight, max_storage, can_seal, can_store, groups, allow_to, allow_types, deny_types, allow_miners, deny_miners "+
+		"SELECT urls, weight, max_storage, can_seal, can_store, groups, allow_to, allow_types, deny_types, allow_miners, deny_miners, tier, archive, read_only "+
 			"FROM storage_path WHERE storage_id=$1", string(id))
 	if err != nil {
 		return storiface.StorageInfo{}, xerrors.Errorf("StorageInfo query fails: %w", err)
@@ -790,11 +810,77 @@ func (dbi *DBIndex) StorageInfo(ctx context.Context, id storiface.ID) (storiface
 	sinfo.DenyTypes = splitString(qResults[0].DenyTypes)
 	sinfo.AllowMiners = splitString(qResults[0].AllowMiners)
 	sinfo.DenyMiners = splitString(qResults[0].DenyMiners)
+	sinfo.Tier = qResults[0].Tier
+	sinfo.Archive = qResults[0].Archive
+	sinfo.ReadOnly = qResults[0].ReadOnly
 
 	return sinfo, nil
 }
 
 func (dbi *DBIndex) StorageBestAlloc(ctx context.Context, allocate storiface.SectorFileType, ssize abi.SectorSize, pathType storiface.PathType, miner abi.ActorID) ([]storiface.StorageInfo, error) {
+	spaceReq, err := allocSpaceReq(ctx, allocate, ssize, pathType)
+	if err != nil {
+		return nil, err
+	}
+
+	cands, err := dbi.allocCandidates(ctx, spaceReq, pathType, miner)
+	if err != nil {
+		return nil, err
+	}
+
+	// paths restricted to other content are never allocated in; with multiple file types, paths which take
+	// any of them are returned and callers pick paths per file type
+	allowed := cands[:0]
+	for _, c := range cands {
+		if allocate.AnyAllowed(c.AllowTypes, c.DenyTypes) {
+			allowed = append(allowed, c)
+		}
+	}
+	cands = allowed
+
+	dbi.placement.Sort(cands, miner)
+
+	var result []storiface.StorageInfo
+	for _, c := range cands {
+		result = append(result, c.StorageInfo)
+	}
+
+	return result, nil
+}
+
+// StoragePlacementPreview simulates where the next n allocations of the file type for the miner would land
+// under the placement policy of the index, assuming the allocating node has access to all paths.
+func (dbi *DBIndex) StoragePlacementPreview(ctx context.Context, allocate storiface.SectorFileType, ssize abi.SectorSize, pathType storiface.PathType, miner abi.ActorID, n int) ([]PlacementStep, error) {
+	spaceReq, err := allocSpaceReq(ctx, allocate, ssize, pathType)
+	if err != nil {
+		return nil, err
+	}
+
+	cands, err := dbi.allocCandidates(ctx, spaceReq, pathType, miner)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := cands[:0]
+	for _, c := range cands {
+		if allocate.Allowed(c.AllowTypes, c.DenyTypes) {
+			allowed = append(allowed, c)
+		}
+	}
+
+	return dbi.placement.Preview(allowed, miner, spaceReq, n), nil
+}
+
+// SetPlacementPolicy sets the policy used to rank paths in StorageBestAlloc
+func (dbi *DBIndex) SetPlacementPolicy(p PlacementPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	dbi.placement = p
+	return nil
+}
+
+func allocSpaceReq(ctx context.Context, allocate storiface.SectorFileType, ssize abi.SectorSize, pathType storiface.PathType) (uint64, error) {
 	var err error
 	var spaceReq uint64
 
@@ -807,13 +893,19 @@ func (dbi *DBIndex) StorageBestAlloc(ctx context.Context, allocate storiface.Sec
 		case storiface.PathStorage:
 			spaceReq, err = allocate.StoreSpaceUse(ssize)
 		default:
-			return nil, xerrors.Errorf("unexpected path type")
+			return 0, xerrors.Errorf("unexpected path type")
 		}
 	}
 	if err != nil {
-		return nil, xerrors.Errorf("estimating required space: %w", err)
+		return 0, xerrors.Errorf("estimating required space: %w", err)
 	}
 
+	return spaceReq, nil
+}
+
+// allocCandidates returns healthy, writable paths of the path type with at least spaceReq bytes available
+// which accept data of the miner
+func (dbi *DBIndex) allocCandidates(ctx context.Context, spaceReq uint64, pathType storiface.PathType, miner abi.ActorID) ([]PlacementCandidate, error) {
 	var rows []struct {
 		StorageId   string
 		Urls        string
@@ -827,9 +919,12 @@ func (dbi *DBIndex) StorageBestAlloc(ctx context.Context, allocate storiface.Sec
 		DenyTypes   string
 		AllowMiners string
 		DenyMiners  string
+		Tier        string
+		Capacity    int64
+		Available   int64
 	}
 
-	err = dbi.harmonyDB.Select(ctx, &rows,
+	err := dbi.harmonyDB.Select(ctx, &rows,
 		`SELECT storage_id, 
 								urls, 
 								weight,
@@ -841,11 +936,16 @@ func (dbi *DBIndex) StorageBestAlloc(ctx context.Context, allocate storiface.Sec
 								allow_types, 
 								deny_types,
 								allow_miners,
-								deny_miners
+								deny_miners,
+								tier,
+								capacity,
+								available
 						 FROM storage_path 
 						 WHERE available >= $1
 						 and NOW()-($2 * INTERVAL '1 second') < last_heartbeat
 						 and heartbeat_err IS NULL
+						 and NOT read_only
+						 and NOT archive
 						 and (($3 and can_seal = TRUE) or ($4 and can_store = TRUE))
 						order by (available::numeric * weight) desc`,
 		spaceReq,
@@ -857,7 +957,7 @@ func (dbi *DBIndex) StorageBestAlloc(ctx context.Context, allocate storiface.Sec
 		return nil, xerrors.Errorf("Querying for best storage sectors fails with err %w: ", err)
 	}
 
-	var result []storiface.StorageInfo
+	var result []PlacementCandidate
 
 	for _, row := range rows {
 		// Matching with 0 as a workaround to avoid having minerID
@@ -875,19 +975,24 @@ func (dbi *DBIndex) StorageBestAlloc(ctx context.Context, allocate storiface.Sec
 			}
 		}
 
-		result = append(result, storiface.StorageInfo{
-			ID:          storiface.ID(row.StorageId),
-			URLs:        splitString(row.Urls),
-			Weight:      row.Weight,
-			MaxStorage:  row.MaxStorage,
-			CanSeal:     row.CanSeal,
-			CanStore:    row.CanStore,
-			Groups:      splitString(row.Groups),
-			AllowTo:     splitString(row.AllowTo),
-			AllowTypes:  splitString(row.AllowTypes),
-			DenyTypes:   splitString(row.DenyTypes),
-			AllowMiners: splitString(row.AllowMiners),
-			DenyMiners:  splitString(row.DenyMiners),
+		result = append(result, PlacementCandidate{
+			StorageInfo: storiface.StorageInfo{
+				ID:          storiface.ID(row.StorageId),
+				URLs:        splitString(row.Urls),
+				Weight:      row.Weight,
+				MaxStorage:  row.MaxStorage,
+				CanSeal:     row.CanSeal,
+				CanStore:    row.CanStore,
+				Groups:      splitString(row.Groups),
+				AllowTo:     splitString(row.AllowTo),
+				AllowTypes:  splitString(row.AllowTypes),
+				DenyTypes:   splitString(row.DenyTypes),
+				AllowMiners: splitString(row.AllowMiners),
+				DenyMiners:  splitString(row.DenyMiners),
+				Tier:        row.Tier,
+			},
+			Capacity:  row.Capacity,
+			Available: row.Available,
 		})
 	}
 
@@ -0,0 +1,254 @@
+package paths
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/lib/cryptfile"
+	"github.com/filecoin-project/curio/lib/partialfile"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// EnableEncryption enables reading encrypted sector files with keys from the keyring, and encrypting unsealed
+// files as they are written to local paths which have EncryptUnsealed set.
+func (st *Local) EnableEncryption(kr *cryptfile.Keyring) {
+	st.localLk.Lock()
+	st.keys = kr
+	st.localLk.Unlock()
+}
+
+func (st *Local) keyring() *cryptfile.Keyring {
+	st.localLk.RLock()
+	defer st.localLk.RUnlock()
+
+	return st.keys
+}
+
+// checkEncryptUnsealed refuses EncryptUnsealed on sealing paths. Sealing, snap encoding and the proofs library
+// read and write unsealed files in sealing paths directly, which can't go through the decrypting reader.
+func checkEncryptUnsealed(meta storiface.LocalStorageMeta) error {
+	if meta.EncryptUnsealed && meta.CanSeal {
+		return xerrors.Errorf("EncryptUnsealed can't be set on sealing paths")
+	}
+	return nil
+}
+
+// EncryptsUnsealed returns true if unsealed files written to the local path are encrypted
+func (st *Local) EncryptsUnsealed(id storiface.ID) bool {
+	st.localLk.RLock()
+	defer st.localLk.RUnlock()
+
+	p, ok := st.paths[id]
+	return ok && p.encryptUnsealed
+}
+
+// encryptKey returns the key sector files of type ft written to the local path are encrypted with, nil if they
+// aren't encrypted
+func (st *Local) encryptKey(id storiface.ID, ft storiface.SectorFileType) (*cryptfile.Key, error) {
+	if ft != storiface.FTUnsealed || !st.EncryptsUnsealed(id) {
+		return nil, nil
+	}
+
+	kr := st.keyring()
+	if kr == nil {
+		return nil, xerrors.Errorf("path %s has EncryptUnsealed set, but no Storage.Encryption key is configured", id)
+	}
+	return kr.Active(), nil
+}
+
+// storeEncryptKey is encryptKey of a local store, nil for other stores
+func storeEncryptKey(s Store, id storiface.ID, ft storiface.SectorFileType) (*cryptfile.Key, error) {
+	l, ok := s.(*Local)
+	if !ok {
+		return nil, nil
+	}
+	return l.encryptKey(id, ft)
+}
+
+// UnsealedWriter wraps a writer of an unsealed file of size bytes in the local path, encrypting the data if the
+// path has EncryptUnsealed set. Closing the returned writer doesn't close w.
+func (st *Local) UnsealedWriter(id storiface.ID, w io.Writer, size int64) (io.WriteCloser, error) {
+	key, err := st.encryptKey(id, storiface.FTUnsealed)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nopWriteCloser{w}, nil
+	}
+	return cryptfile.NewWriter(key, w, size)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// storeKeyring returns the encryption keyring of a local store, nil if encryption isn't enabled
+func storeKeyring(s Store) *cryptfile.Keyring {
+	l, ok := s.(*Local)
+	if !ok {
+		return nil
+	}
+	return l.keyring()
+}
+
+// decryptedFile is a read-only view of a sector file which is transparently decrypted
+type decryptedFile struct {
+	io.ReaderAt
+	io.Closer
+
+	size int64
+}
+
+func (f *decryptedFile) ReadSeeker() io.ReadSeeker {
+	return io.NewSectionReader(f, 0, f.size)
+}
+
+// IsEncryptedFile checks if the file at path is encrypted, files which can't be read are
+// reported as not encrypted
+func IsEncryptedFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close() // nolint:errcheck
+
+	enc, err := cryptfile.IsEncrypted(f)
+	return err == nil && enc
+}
+
+// openDecrypted opens a sector file for reading with the read IO options of its path, decrypting it
+// if it is encrypted
+func openDecrypted(kr *cryptfile.Keyring, rio storiface.PathReadIO, path string) (*decryptedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, xerrors.Errorf("stat '%s': %w", path, err)
+	}
+
+	enc, err := cryptfile.IsEncrypted(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, xerrors.Errorf("checking file encryption '%s': %w", path, err)
+	}
+
+	data, closer := openReadIO(f, path, rio)
+	if !enc {
+		return &decryptedFile{ReaderAt: data, Closer: closer, size: st.Size()}, nil
+	}
+
+	r, err := cryptfile.NewReader(kr, data)
+	if err != nil {
+		_ = closer.Close()
+		return nil, xerrors.Errorf("opening encrypted file '%s': %w", path, err)
+	}
+
+	return &decryptedFile{ReaderAt: r, Closer: closer, size: r.Size()}, nil
+}
+
+// openUnsealed opens an unsealed sector file, which may be encrypted, as a partial file.
+// Encrypted partial files, and partial files opened with read IO options, are read-only.
+func openUnsealed(pfh PartialFileHandler, kr *cryptfile.Keyring, rio storiface.PathReadIO, maxPieceSize abi.PaddedPieceSize, path string) (*partialfile.PartialFile, error) {
+	if rio == (storiface.PathReadIO{}) && !IsEncryptedFile(path) {
+		return pfh.OpenPartialFile(maxPieceSize, path)
+	}
+
+	f, err := openDecrypted(kr, rio, path)
+	if err != nil {
+		return nil, err
+	}
+
+	pf, err := partialfile.OpenPartialFileReader(maxPieceSize, path, f, f.size, f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return pf, nil
+}
+
+// moveSectorFile moves a sector file into a local path, encrypting it on the way if the file is written
+// encrypted there. The encrypted copy is written next to the destination and renamed into place once complete.
+func (st *Local) moveSectorFile(ft storiface.SectorFileType, from, to string, toID storiface.ID) error {
+	key, err := st.encryptKey(toID, ft)
+	if err != nil {
+		return err
+	}
+	if key == nil || IsEncryptedFile(from) {
+		return Move(from, to)
+	}
+
+	tmp, err := tempFetchDest(to, true)
+	if err != nil {
+		return err
+	}
+	tmp += encryptTempSuffix
+
+	start := time.Now()
+	if err := encryptFile(key, from, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return xerrors.Errorf("encrypting %s: %w", from, err)
+	}
+
+	if err := os.Rename(tmp, to); err != nil {
+		_ = os.Remove(tmp)
+		return xerrors.Errorf("moving encrypted file into place: %w", err)
+	}
+	if err := os.Remove(from); err != nil {
+		return xerrors.Errorf("removing source file: %w", err)
+	}
+
+	log.Infow("moved sector file encrypted", "from", from, "to", to, "took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// encryptTempSuffix is the suffix of files being encrypted in the fetch temp directory of a path
+const encryptTempSuffix = ".enc"
+
+// encryptFile writes the encrypted copy of the plaintext file from to the new file to
+func encryptFile(key *cryptfile.Key, from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close() // nolint:errcheck
+
+	sst, err := src.Stat()
+	if err != nil {
+		return xerrors.Errorf("stat: %w", err)
+	}
+
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(dst, CopyBuf)
+	rd := bufio.NewReaderSize(src, CopyBuf)
+	if err := cryptfile.Encrypt(key, bw, rd, sst.Size()); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,105 @@
+package paths
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/lib/cryptfile"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+func testKeyring(t *testing.T) *cryptfile.Keyring {
+	k, err := cryptfile.NewKey(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	return cryptfile.NewKeyring(k)
+}
+
+func readDecrypted(t *testing.T, kr *cryptfile.Keyring, path string) []byte {
+	require.True(t, IsEncryptedFile(path))
+
+	f, err := openDecrypted(kr, storiface.PathReadIO{}, path)
+	require.NoError(t, err)
+	defer f.Close() // nolint:errcheck
+
+	data, err := io.ReadAll(f.ReadSeeker())
+	require.NoError(t, err)
+	return data
+}
+
+func TestMoveSectorFileEncrypts(t *testing.T) {
+	kr := testKeyring(t)
+	st := &Local{
+		paths: map[storiface.ID]*path{
+			"enc":   {encryptUnsealed: true},
+			"plain": {},
+		},
+	}
+
+	data := make([]byte, 3<<20+5)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+
+	name := storiface.SectorName(abi.SectorID{Miner: 1000, Number: 1})
+	write := func(dir string) string {
+		p := filepath.Join(t.TempDir(), dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, data, 0644))
+		return p
+	}
+	dest := func() string {
+		return filepath.Join(t.TempDir(), name)
+	}
+
+	// without keys unsealed files aren't moved into paths which encrypt them
+	from, to := write("unsealed"), dest()
+	require.ErrorContains(t, st.moveSectorFile(storiface.FTUnsealed, from, to, "enc"), "no Storage.Encryption key")
+	require.FileExists(t, from)
+
+	st.EnableEncryption(kr)
+
+	require.NoError(t, st.moveSectorFile(storiface.FTUnsealed, from, to, "enc"))
+	require.NoFileExists(t, from)
+	require.Equal(t, data, readDecrypted(t, kr, to))
+
+	// encrypted files are moved as they are
+	to2 := dest()
+	require.NoError(t, st.moveSectorFile(storiface.FTUnsealed, to, to2, "plain"))
+	require.Equal(t, data, readDecrypted(t, kr, to2))
+
+	// other files and paths aren't encrypted
+	from, to = write("sealed"), dest()
+	require.NoError(t, st.moveSectorFile(storiface.FTSealed, from, to, "enc"))
+	require.False(t, IsEncryptedFile(to))
+
+	from, to = write("unsealed"), dest()
+	require.NoError(t, st.moveSectorFile(storiface.FTUnsealed, from, to, "plain"))
+	require.False(t, IsEncryptedFile(to))
+}
+
+func TestFetchEncrypted(t *testing.T) {
+	kr := testKeyring(t)
+
+	data := make([]byte, 2<<20+7)
+	_, _ = rand.New(rand.NewSource(2)).Read(data)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, fetch(context.Background(), srv.URL, out, http.Header{}, nil, kr.Active()))
+	require.Equal(t, data, readDecrypted(t, kr, out))
+}
@@ -1,6 +1,7 @@
 package paths
 
 import (
+	"bufio"
 	"context"
 	"io"
 	"mime"
@@ -11,6 +12,7 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/curio/lib/cryptfile"
 	"github.com/filecoin-project/curio/lib/tarutil"
 )
 
@@ -19,9 +21,15 @@ func init() {
 	tarutil.CacheFileConstraints["commit-phase1-output"] = 20_000_000
 }
 
-func fetch(ctx context.Context, url, outname string, header http.Header) (rerr error) {
+// fetch downloads url into outname, with writes limited by lim. Files are encrypted as they are written when key
+// isn't nil, such fetches aren't ranged and can't be resumed.
+func fetch(ctx context.Context, url, outname string, header http.Header, lim *pathLimiter, key *cryptfile.Key) (rerr error) {
 	log.Infof("Fetch %s -> %s", url, outname)
 
+	if size, algo, ok := probeRanged(ctx, url, header); ok && key == nil {
+		return fetchRanged(ctx, url, outname, size, algo, header, lim)
+	}
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return xerrors.Errorf("request: %w", err)
@@ -55,17 +63,35 @@ func fetch(ctx context.Context, url, outname string, header http.Header) (rerr e
 	if err := os.RemoveAll(outname); err != nil {
 		return xerrors.Errorf("removing dest: %w", err)
 	}
+	if err := os.RemoveAll(outname + fetchStateSuffix); err != nil {
+		return xerrors.Errorf("removing stale fetch state: %w", err)
+	}
 
-	switch mediatype {
-	case "application/x-tar":
-		bytes, err = tarutil.ExtractTar(tarutil.CacheFileConstraints, resp.Body, outname, make([]byte, CopyBuf))
+	body := lim.limitWrites(ctx, resp.Body)
+
+	switch {
+	case mediatype == "application/x-tar" && key != nil:
+		return xerrors.Errorf("can't encrypt directories")
+	case mediatype == "application/x-tar":
+		bytes, err = tarutil.ExtractTar(tarutil.CacheFileConstraints, body, outname, make([]byte, CopyBuf))
 		return err
-	case "application/octet-stream":
+	case mediatype == "application/octet-stream" && key != nil:
 		f, err := os.Create(outname)
 		if err != nil {
 			return err
 		}
-		bytes, err = io.CopyBuffer(f, resp.Body, make([]byte, CopyBuf))
+		bytes, err = fetchEncrypted(f, body, resp.ContentLength, key)
+		if err != nil {
+			f.Close() // nolint
+			return err
+		}
+		return f.Close()
+	case mediatype == "application/octet-stream":
+		f, err := os.Create(outname)
+		if err != nil {
+			return err
+		}
+		bytes, err = io.CopyBuffer(f, body, make([]byte, CopyBuf))
 		if err != nil {
 			f.Close() // nolint
 			return err
@@ -76,6 +102,28 @@ func fetch(ctx context.Context, url, outname string, header http.Header) (rerr e
 	}
 }
 
+// fetchEncrypted writes the size bytes of body to f encrypted with the key
+func fetchEncrypted(f *os.File, body io.Reader, size int64, key *cryptfile.Key) (int64, error) {
+	if size < 0 {
+		return 0, xerrors.Errorf("response without a content length can't be encrypted")
+	}
+
+	bw := bufio.NewWriterSize(f, CopyBuf)
+	ew, err := cryptfile.NewWriter(key, bw, size)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.CopyBuffer(ew, body, make([]byte, CopyBuf))
+	if err != nil {
+		return n, err
+	}
+	if err := ew.Close(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
 // FetchWithTemp fetches data into a temp 'fetching' directory, then moves the file to destination
 // The set of URLs must refer to the same object, if one fails, another one will be tried.
 func FetchWithTemp(ctx context.Context, urls []string, dest string, header http.Header) (string, error) {
@@ -90,7 +138,7 @@ func FetchWithTemp(ctx context.Context, urls []string, dest string, header http.
 			return "", xerrors.Errorf("removing dest: %w", err)
 		}
 
-		err = fetch(ctx, url, tempDest, header)
+		err = fetch(ctx, url, tempDest, header, nil, nil)
 		if err != nil {
 			merr = multierror.Append(merr, xerrors.Errorf("fetch error %s -> %s: %w", url, tempDest, err))
 			continue
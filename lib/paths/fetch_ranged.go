@@ -0,0 +1,396 @@
+package paths
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/blake2b-simd"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/xerrors"
+)
+
+// ParallelFetchRanges is the number of ranges of a single sector file fetched in parallel
+// from a remote node. 1 disables ranged fetches.
+var ParallelFetchRanges = 8
+
+// FetchRangeSize is the size of a single ranged request. Files smaller than two ranges are
+// fetched with a single request.
+var FetchRangeSize int64 = 128 << 20
+
+// FetchRangeRetries is the number of times a failed or corrupted range is re-requested
+var FetchRangeRetries = 3
+
+const (
+	// rangeChecksumHeader is set by nodes able to send a checksum of ranged responses. Requests
+	// set it to the checksum algorithm to ask for, the checksum is sent in the trailer of the
+	// algorithm.
+	rangeChecksumHeader  = "X-Curio-Range-Checksum"
+	rangeChecksumTrailer = "X-Curio-Range-Sha256"
+	rangeChecksumSha256  = "sha256"
+
+	// rangeChecksumAlgosHeader lists all checksum algorithms a node can send. Nodes which don't
+	// set it only send sha256, which rangeChecksumHeader is always set to for their sake.
+	rangeChecksumAlgosHeader = "X-Curio-Range-Checksum-Algos"
+	rangeBlake2bTrailer      = "X-Curio-Range-Blake2b"
+	rangeChecksumBlake2b     = "blake2b-256"
+
+	fetchStateSuffix = ".fetchstate"
+)
+
+// rangeChecksumAlgos are the supported range checksum algorithms, preferred first
+var rangeChecksumAlgos = []string{rangeChecksumBlake2b, rangeChecksumSha256}
+
+// rangeHasher returns a new hash for the range checksum algorithm and the trailer its sums are
+// sent in
+func rangeHasher(algo string) (hash.Hash, string, bool) {
+	switch algo {
+	case rangeChecksumBlake2b:
+		return blake2b.New256(), rangeBlake2bTrailer, true
+	case rangeChecksumSha256:
+		return sha256.New(), rangeChecksumTrailer, true
+	default:
+		return nil, "", false
+	}
+}
+
+// rangedFetchState records the ranges of a file which were already fetched, so that
+// a failed fetch can be resumed
+type rangedFetchState struct {
+	Size      int64
+	RangeSize int64
+	Done      []bool
+
+	// Sums are the checksums of the fetched ranges sent by the remote, in the Algo algorithm.
+	// Fetched ranges are verified against them before a fetch is resumed.
+	Algo string
+	Sums []string
+}
+
+// probeRanged checks if the url serves a single file which can be fetched in checksummed
+// ranges, returning its size and the preferred checksum algorithm supported by the remote
+func probeRanged(ctx context.Context, url string, header http.Header) (int64, string, bool) {
+	if ParallelFetchRanges <= 1 {
+		return 0, "", false
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0, "", false
+	}
+	req.Header = header.Clone()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Debugw("ranged fetch probe failed", "url", url, "error", err)
+		return 0, "", false
+	}
+	_ = resp.Body.Close()
+
+	// nodes without ranged fetch support don't handle HEAD requests
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", false
+	}
+
+	mediatype, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediatype != "application/octet-stream" {
+		return 0, "", false
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" || resp.Header.Get(rangeChecksumHeader) != rangeChecksumSha256 {
+		return 0, "", false
+	}
+	if resp.ContentLength < 2*FetchRangeSize {
+		return 0, "", false
+	}
+
+	algo := rangeChecksumSha256
+	for _, a := range strings.Split(resp.Header.Get(rangeChecksumAlgosHeader), ",") {
+		if strings.TrimSpace(a) == rangeChecksumBlake2b {
+			algo = rangeChecksumBlake2b
+			break
+		}
+	}
+
+	return resp.ContentLength, algo, true
+}
+
+// fetchRanged downloads a file of the given size from url into outname using parallel ranged
+// requests. Each range is checked against the checksum sent by the remote node, computed with
+// the algo algorithm. Fetched ranges and their checksums are recorded next to outname, so calling
+// fetchRanged again after a failure only fetches the missing ranges, after checking that the
+// ranges already on disk are intact.
+func fetchRanged(ctx context.Context, url, outname string, size int64, algo string, header http.Header, lim *pathLimiter) (rerr error) {
+	statePath := outname + fetchStateSuffix
+
+	st := loadFetchState(statePath, outname, size)
+	if st == nil {
+		if err := os.RemoveAll(outname); err != nil {
+			return xerrors.Errorf("removing dest: %w", err)
+		}
+		n := (size + FetchRangeSize - 1) / FetchRangeSize
+		st = &rangedFetchState{
+			Size:      size,
+			RangeSize: FetchRangeSize,
+			Done:      make([]bool, n),
+			Algo:      algo,
+			Sums:      make([]string, n),
+		}
+	}
+
+	f, err := os.OpenFile(outname, os.O_CREATE|os.O_RDWR, 0644) // nolint
+	if err != nil {
+		return xerrors.Errorf("opening dest: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil && rerr == nil {
+			rerr = xerrors.Errorf("closing dest: %w", err)
+		}
+	}()
+
+	if err := f.Truncate(size); err != nil {
+		return xerrors.Errorf("truncating dest: %w", err)
+	}
+
+	if err := verifyFetchedRanges(ctx, f, st, algo); err != nil {
+		return xerrors.Errorf("verifying fetched ranges: %w", err)
+	}
+
+	var todo []int
+	for i, done := range st.Done {
+		if !done {
+			todo = append(todo, i)
+		}
+	}
+
+	start := time.Now()
+	var fetched int64
+	defer func() {
+		took := time.Since(start)
+		mibps := float64(fetched) / 1024 / 1024 * float64(time.Second) / float64(took)
+		log.Infow("Ranged fetch done", "url", url, "out", outname, "took", took.Round(time.Millisecond), "bytes", fetched,
+			"resumed", len(st.Done)-len(todo), "ranges", len(st.Done), "MiB/s", mibps, "err", rerr)
+	}()
+
+	var stLk sync.Mutex
+	ranges := make(chan int)
+
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		defer close(ranges)
+		for _, i := range todo {
+			select {
+			case ranges <- i:
+			case <-ectx.Done():
+				return nil
+			}
+		}
+		return nil
+	})
+
+	for w := 0; w < ParallelFetchRanges; w++ {
+		eg.Go(func() error {
+			for i := range ranges {
+				off := int64(i) * st.RangeSize
+				end := min(off+st.RangeSize, size)
+
+				var sum string
+				var err error
+				for try := 0; try <= FetchRangeRetries; try++ {
+					if sum, err = fetchRange(ectx, url, f, off, end, algo, header, lim); err == nil {
+						break
+					}
+					if ectx.Err() != nil {
+						return err
+					}
+					log.Warnw("fetching range failed", "url", url, "range", i, "try", try, "error", err)
+				}
+				if err != nil {
+					return xerrors.Errorf("fetching range %d-%d: %w", off, end, err)
+				}
+
+				// make sure the range is on disk before recording it as fetched
+				if err := f.Sync(); err != nil {
+					return xerrors.Errorf("syncing dest: %w", err)
+				}
+
+				stLk.Lock()
+				st.Done[i] = true
+				st.Sums[i] = sum
+				fetched += end - off
+				err = saveFetchState(statePath, st)
+				stLk.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(statePath); err != nil {
+		return xerrors.Errorf("removing fetch state: %w", err)
+	}
+	return nil
+}
+
+// fetchRange fetches bytes [off, end) of the file served at url into f, returning the checksum
+// of the range
+func fetchRange(ctx context.Context, url string, f *os.File, off, end int64, algo string, header http.Header, lim *pathLimiter) (string, error) {
+	h, trailer, ok := rangeHasher(algo)
+	if !ok {
+		return "", xerrors.Errorf("unsupported range checksum algorithm %q", algo)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", xerrors.Errorf("request: %w", err)
+	}
+	req.Header = header.Clone()
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end-1))
+	req.Header.Set(rangeChecksumHeader, algo)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", xerrors.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return "", xerrors.Errorf("non-206 code: %d", resp.StatusCode)
+	}
+
+	w := io.MultiWriter(io.NewOffsetWriter(f, off), h)
+
+	n, err := io.CopyBuffer(w, io.LimitReader(lim.limitWrites(ctx, resp.Body), end-off), make([]byte, CopyBuf))
+	if err != nil {
+		return "", xerrors.Errorf("reading range: %w", err)
+	}
+	if n != end-off {
+		return "", xerrors.Errorf("short range read: got %d bytes, expected %d", n, end-off)
+	}
+
+	// trailers are only available after the body was read to EOF
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return "", xerrors.Errorf("reading range trailer: %w", err)
+	}
+
+	sum := resp.Trailer.Get(trailer)
+	if sum == "" {
+		return "", xerrors.Errorf("remote didn't send a range checksum")
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return "", xerrors.Errorf("range checksum mismatch: got %s, remote sent %s", got, sum)
+	}
+
+	return sum, nil
+}
+
+// verifyFetchedRanges re-reads the ranges of a resumed fetch which are recorded as fetched, and
+// marks ranges which don't match their recorded checksums for fetching again, e.g. when writes
+// were lost in a crash. The checksums of intact ranges are converted to the algo algorithm used
+// by the resumed fetch.
+func verifyFetchedRanges(ctx context.Context, f *os.File, st *rangedFetchState, algo string) error {
+	var bad, verified int
+	buf := make([]byte, CopyBuf)
+
+	for i, done := range st.Done {
+		if !done {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		stored, _, _ := rangeHasher(st.Algo)
+		cur, _, ok := rangeHasher(algo)
+		if !ok {
+			return xerrors.Errorf("unsupported range checksum algorithm %q", algo)
+		}
+
+		off := int64(i) * st.RangeSize
+		end := min(off+st.RangeSize, st.Size)
+
+		_, err := io.CopyBuffer(io.MultiWriter(stored, cur), io.NewSectionReader(f, off, end-off), buf)
+		if err != nil {
+			return xerrors.Errorf("reading range %d-%d: %w", off, end, err)
+		}
+
+		if hex.EncodeToString(stored.Sum(nil)) != st.Sums[i] {
+			st.Done[i], st.Sums[i] = false, ""
+			bad++
+			continue
+		}
+
+		st.Sums[i] = hex.EncodeToString(cur.Sum(nil))
+		verified++
+	}
+	st.Algo = algo
+
+	if verified > 0 || bad > 0 {
+		log.Infow("verified ranges of resumed fetch", "file", f.Name(), "ok", verified, "refetch", bad)
+	}
+	return nil
+}
+
+// fetchResumable returns true if a failed fetch into outname can be resumed
+func fetchResumable(outname string) bool {
+	_, err := os.Stat(outname + fetchStateSuffix)
+	return err == nil
+}
+
+func loadFetchState(statePath, outname string, size int64) *rangedFetchState {
+	b, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil
+	}
+
+	var st rangedFetchState
+	if err := json.Unmarshal(b, &st); err != nil {
+		log.Warnw("discarding corrupt fetch state", "path", statePath, "error", err)
+		return nil
+	}
+	if st.Size != size || st.RangeSize <= 0 || int64(len(st.Done)) != (size+st.RangeSize-1)/st.RangeSize {
+		return nil
+	}
+	// states without checksums can't be verified before resuming
+	if _, _, ok := rangeHasher(st.Algo); !ok || len(st.Sums) != len(st.Done) {
+		return nil
+	}
+
+	fst, err := os.Stat(outname)
+	if err != nil || fst.Size() != size {
+		return nil
+	}
+
+	return &st
+}
+
+func saveFetchState(statePath string, st *rangedFetchState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return xerrors.Errorf("marshaling fetch state: %w", err)
+	}
+
+	tmp := statePath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil { // nolint
+		return xerrors.Errorf("writing fetch state: %w", err)
+	}
+	if err := os.Rename(tmp, statePath); err != nil {
+		return xerrors.Errorf("writing fetch state: %w", err)
+	}
+	return nil
+}
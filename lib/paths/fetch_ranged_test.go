@@ -0,0 +1,158 @@
+package paths
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flippingWriter corrupts data after the server computed its checksum
+type flippingWriter struct {
+	http.ResponseWriter
+}
+
+func (w flippingWriter) Write(p []byte) (int, error) {
+	c := bytes.Clone(p)
+	c[0] ^= 0xff
+	return w.ResponseWriter.Write(c)
+}
+
+// rangedTestServer serves data like the fetch handler. Servers with sha256Only set behave like
+// nodes which don't advertise checksum algorithms.
+func rangedTestServer(data []byte, corrupt *atomic.Int64, sha256Only bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(rangeChecksumHeader, rangeChecksumSha256)
+		if !sha256Only {
+			w.Header().Set(rangeChecksumAlgosHeader, strings.Join(rangeChecksumAlgos, ","))
+		}
+
+		algo := r.Header.Get(rangeChecksumHeader)
+		if r.Header.Get("Range") != "" && (algo == rangeChecksumSha256 || (!sha256Only && algo != "")) {
+			if corrupt.Add(-1) == 0 {
+				w = flippingWriter{w}
+			}
+			serveChecksummedRange(w, r, bytes.NewReader(data), int64(len(data)), algo)
+			return
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+}
+
+func TestFetchRanged(t *testing.T) {
+	defer func(rs int64, p int) { FetchRangeSize, ParallelFetchRanges = rs, p }(FetchRangeSize, ParallelFetchRanges)
+	FetchRangeSize = 1000
+	ParallelFetchRanges = 3
+
+	data := make([]byte, 10_500)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+
+	for _, sha256Only := range []bool{false, true} {
+		var corrupt atomic.Int64
+		srv := rangedTestServer(data, &corrupt, sha256Only)
+
+		ctx := context.Background()
+		out := filepath.Join(t.TempDir(), "s-t01000-1")
+
+		size, algo, ok := probeRanged(ctx, srv.URL, http.Header{})
+		require.True(t, ok)
+		require.EqualValues(t, len(data), size)
+		if sha256Only {
+			require.Equal(t, rangeChecksumSha256, algo)
+		} else {
+			require.Equal(t, rangeChecksumBlake2b, algo)
+		}
+
+		// the third range request is corrupted and retried
+		corrupt.Store(3)
+		require.NoError(t, fetchRanged(ctx, srv.URL, out, size, algo, http.Header{}, nil))
+
+		got, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+		require.False(t, fetchResumable(out))
+
+		srv.Close()
+	}
+}
+
+func TestFetchRangedResume(t *testing.T) {
+	defer func(rs int64, p, r int) {
+		FetchRangeSize, ParallelFetchRanges, FetchRangeRetries = rs, p, r
+	}(FetchRangeSize, ParallelFetchRanges, FetchRangeRetries)
+	FetchRangeSize = 1000
+	ParallelFetchRanges = 1
+	FetchRangeRetries = 0
+
+	data := make([]byte, 5_000)
+	_, _ = rand.New(rand.NewSource(2)).Read(data)
+
+	var corrupt atomic.Int64
+	srv := rangedTestServer(data, &corrupt, false)
+	defer srv.Close()
+
+	ctx := context.Background()
+	out := filepath.Join(t.TempDir(), "s-t01000-1")
+
+	corrupt.Store(3)
+	require.Error(t, fetchRanged(ctx, srv.URL, out, int64(len(data)), rangeChecksumSha256, http.Header{}, nil))
+	require.True(t, fetchResumable(out))
+
+	st := loadFetchState(out+fetchStateSuffix, out, int64(len(data)))
+	require.NotNil(t, st)
+	require.Equal(t, []bool{true, true, false, false, false}, st.Done)
+
+	// damage a fetched range on disk, it is fetched again when resuming
+	f, err := os.OpenFile(out, os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{data[1500] ^ 0xff}, 1500)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// resuming with a different algorithm converts the recorded checksums
+	var requests atomic.Int64
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			requests.Add(1)
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counting.Close()
+
+	require.NoError(t, fetchRanged(ctx, counting.URL, out, int64(len(data)), rangeChecksumBlake2b, http.Header{}, nil))
+	require.EqualValues(t, 4, requests.Load())
+
+	got, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+}
+
+func TestParseByteRange(t *testing.T) {
+	off, n, err := parseByteRange("bytes=10-19", 100)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, off)
+	require.EqualValues(t, 10, n)
+
+	off, n, err = parseByteRange("bytes=90-", 100)
+	require.NoError(t, err)
+	require.EqualValues(t, 90, off)
+	require.EqualValues(t, 10, n)
+
+	_, _, err = parseByteRange("bytes=0-1,5-6", 100)
+	require.Error(t, err)
+	_, _, err = parseByteRange("bytes=-10", 100)
+	require.Error(t, err)
+	_, _, err = parseByteRange("bytes=100-", 100)
+	require.Error(t, err)
+}
@@ -0,0 +1,211 @@
+package paths
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+
+	"github.com/filecoin-project/lotus/storage/sealer/fsutil"
+)
+
+// ProbeReadSize is the number of bytes read from a sector file by a storage health probe
+var ProbeReadSize int64 = 1 << 20
+
+// probeDirEntries bounds the number of directory entries listed when picking a file for the read test
+const probeDirEntries = 64
+
+// ProbeReadResult is the result of a storage path health probe executed by the node holding the path
+type ProbeReadResult struct {
+	Stat fsutil.FsStat
+
+	// File is the file read by the read test
+	File      string
+	ReadBytes int64
+	ReadTime  time.Duration
+}
+
+// StorageProbe is the result of probing a storage path through one of its URLs
+type StorageProbe struct {
+	ProbeReadResult
+
+	// Latency is the round trip time of the probe request, not including the read test on the remote node
+	Latency time.Duration
+}
+
+// ProbeRead stats the path and reads a random part of one of its sector files, to check that the underlying
+// storage responds in reasonable time
+func (st *Local) ProbeRead(ctx context.Context, id storiface.ID) (ProbeReadResult, error) {
+	st.localLk.RLock()
+	p, ok := st.paths[id]
+	if !ok {
+		st.localLk.RUnlock()
+		return ProbeReadResult{}, errPathNotFound
+	}
+	fsst, _, err := p.stat(st.localStorage)
+	local := p.local
+	st.localLk.RUnlock()
+	if err != nil {
+		return ProbeReadResult{}, xerrors.Errorf("stat: %w", err)
+	}
+
+	file := probeFile(local)
+
+	start := time.Now()
+	n, err := probeReadFile(ctx, file)
+	if err != nil {
+		return ProbeReadResult{}, xerrors.Errorf("read test %s: %w", file, err)
+	}
+
+	return ProbeReadResult{
+		Stat:      fsst,
+		File:      file,
+		ReadBytes: n,
+		ReadTime:  time.Since(start),
+	}, nil
+}
+
+// probeFile picks a sector file to read from, falling back to the path metadata file for paths without sectors
+func probeFile(local string) string {
+	for _, ft := range []storiface.SectorFileType{storiface.FTSealed, storiface.FTUpdate, storiface.FTUnsealed} {
+		d, err := os.Open(filepath.Join(local, ft.String()))
+		if err != nil {
+			continue
+		}
+		ents, _ := d.ReadDir(probeDirEntries)
+		_ = d.Close()
+
+		var files []string
+		for _, ent := range ents {
+			if ent.Type().IsRegular() {
+				files = append(files, ent.Name())
+			}
+		}
+		if len(files) > 0 {
+			return filepath.Join(local, ft.String(), files[rand.Intn(len(files))])
+		}
+	}
+
+	return filepath.Join(local, MetaFile)
+}
+
+func probeReadFile(ctx context.Context, file string) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close() // nolint:errcheck
+
+	fst, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var off int64
+	if fst.Size() > ProbeReadSize {
+		off = rand.Int63n(fst.Size() - ProbeReadSize)
+	}
+
+	type res struct {
+		n   int64
+		err error
+	}
+	done := make(chan res, 1)
+	go func() {
+		n, err := io.Copy(io.Discard, io.NewSectionReader(f, off, ProbeReadSize))
+		done <- res{n, err}
+	}()
+
+	// reads from hung network filesystems may never return, don't wait for them longer than the caller wants
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (handler *FetchHandler) remoteProbe(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := storiface.ID(vars["id"])
+
+	l, ok := handler.Local.(*Local)
+	if !ok {
+		w.WriteHeader(404)
+		return
+	}
+
+	res, err := l.ProbeRead(r.Context(), id)
+	switch err {
+	case errPathNotFound:
+		w.WriteHeader(404)
+		return
+	case nil:
+	default:
+		log.Warnw("storage health probe failed", "storage", id, "error", err)
+		w.WriteHeader(500)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&res); err != nil {
+		log.Warnf("error writing probe response: %+v", err)
+	}
+}
+
+// ProbeUrl probes the health of a storage path through the given URL
+func (r *Remote) ProbeUrl(ctx context.Context, urlStr string, id storiface.ID) (StorageProbe, error) {
+	rl, err := url.Parse(urlStr)
+	if err != nil {
+		return StorageProbe{}, xerrors.Errorf("parsing URL: %w", err)
+	}
+
+	rl.Path = gopath.Join(rl.Path, "probe", string(id))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rl.String(), nil)
+	if err != nil {
+		return StorageProbe{}, xerrors.Errorf("creating request failed: %w", err)
+	}
+	req.Header = r.auth.Clone()
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return StorageProbe{}, xerrors.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return StorageProbe{}, xerrors.Errorf("endpoint failed %s: %d %s", rl.String(), resp.StatusCode, string(b))
+	}
+
+	out := StorageProbe{Latency: time.Since(start)}
+	if err := json.NewDecoder(resp.Body).Decode(&out.ProbeReadResult); err != nil {
+		return StorageProbe{}, xerrors.Errorf("decoding response failed: %w", err)
+	}
+	// the response is only sent after the read test
+	out.Latency -= out.ReadTime
+
+	return out, nil
+}
+
+// healthyFirst moves copies in degraded paths to the end of si, keeping the order otherwise, so that reads
+// only go to degraded paths when no healthy copy is available
+func healthyFirst(si []storiface.SectorStorageInfo) {
+	sort.SliceStable(si, func(i, j int) bool {
+		return !si[i].Degraded && si[j].Degraded
+	})
+}
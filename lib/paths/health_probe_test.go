@@ -0,0 +1,47 @@
+package paths
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+func TestHealthyFirst(t *testing.T) {
+	si := []storiface.SectorStorageInfo{
+		{ID: "a", Degraded: true},
+		{ID: "b"},
+		{ID: "c", Degraded: true},
+		{ID: "d"},
+	}
+	healthyFirst(si)
+
+	var ids []storiface.ID
+	for _, s := range si {
+		ids = append(ids, s.ID)
+	}
+	require.Equal(t, []storiface.ID{"b", "d", "a", "c"}, ids)
+}
+
+func TestProbeRead(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, MetaFile), []byte("{}"), 0644))
+
+	// paths without sector files read the metadata file
+	require.Equal(t, filepath.Join(dir, MetaFile), probeFile(dir))
+
+	sealed := filepath.Join(dir, storiface.FTSealed.String())
+	require.NoError(t, os.Mkdir(sealed, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sealed, "s-t01000-1"), make([]byte, 3*ProbeReadSize), 0644))
+
+	file := probeFile(dir)
+	require.Equal(t, filepath.Join(sealed, "s-t01000-1"), file)
+
+	n, err := probeReadFile(context.Background(), file)
+	require.NoError(t, err)
+	require.Equal(t, ProbeReadSize, n)
+}
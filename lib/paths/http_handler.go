@@ -2,16 +2,20 @@ package paths
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-state-types/abi"
 
@@ -54,11 +58,15 @@ func (handler *FetchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	mux := mux.NewRouter()
 
 	mux.HandleFunc("/remote/stat/{id}", handler.remoteStatFs).Methods("GET")
+	mux.HandleFunc("/remote/probe/{id}", handler.remoteProbe).Methods("GET")
 	mux.HandleFunc("/remote/vanilla/single", handler.generateSingleVanillaProof).Methods("POST")
 	mux.HandleFunc("/remote/vanilla/porep", handler.generatePoRepVanillaProof).Methods("POST")
 	mux.HandleFunc("/remote/vanilla/snap", handler.readSnapVanillaProof).Methods("POST")
+	mux.HandleFunc("/remote/upload/{type}/{id}/{storage}", handler.remoteUploadStatus).Methods("GET")
+	mux.HandleFunc("/remote/upload/{type}/{id}/{storage}", handler.remoteUploadChunk).Methods("PUT")
+	mux.HandleFunc("/remote/upload/{type}/{id}/{storage}/done", handler.remoteUploadDone).Methods("POST")
 	mux.HandleFunc("/remote/{type}/{id}/{spt}/allocated/{offset}/{size}", handler.remoteGetAllocated).Methods("GET")
-	mux.HandleFunc("/remote/{type}/{id}", handler.remoteGetSector).Methods("GET")
+	mux.HandleFunc("/remote/{type}/{id}", handler.remoteGetSector).Methods("GET", "HEAD")
 	mux.HandleFunc("/remote/{type}/{id}", handler.remoteDeleteSector).Methods("DELETE")
 
 	mux.ServeHTTP(w, r)
@@ -112,7 +120,7 @@ func (handler *FetchHandler) remoteGetSector(w http.ResponseWriter, r *http.Requ
 		ProofType: 0,
 	}
 
-	paths, _, err := handler.Local.AcquireSector(r.Context(), si, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
+	paths, stores, err := handler.Local.AcquireSector(r.Context(), si, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
 	if err != nil {
 		log.Errorf("AcquireSector: %+v", err)
 		w.WriteHeader(500)
@@ -121,6 +129,9 @@ func (handler *FetchHandler) remoteGetSector(w http.ResponseWriter, r *http.Requ
 
 	// TODO: reserve local storage here
 
+	lim := storeLimiter(handler.Local, storiface.ID(storiface.PathByType(stores, ft)))
+	rio := storeReadIO(handler.Local, storiface.ID(storiface.PathByType(stores, ft)))
+
 	path := storiface.PathByType(paths, ft)
 	if path == "" {
 		log.Error("acquired path was empty")
@@ -128,6 +139,10 @@ func (handler *FetchHandler) remoteGetSector(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if ft == storiface.FTUnsealed && r.Method == http.MethodGet {
+		storeNoteUnsealedAccess(handler.Local, id)
+	}
+
 	stat, err := os.Stat(path)
 	if err != nil {
 		log.Errorf("os.Stat: %+v", err)
@@ -145,25 +160,126 @@ func (handler *FetchHandler) remoteGetSector(w http.ResponseWriter, r *http.Requ
 		w.Header().Set("Content-Type", "application/x-tar")
 		w.WriteHeader(200)
 
+		if r.Method == http.MethodHead {
+			return
+		}
+
 		constraints := tarutil.CacheFileConstraints
 		if _, ok := r.URL.Query()["mincache"]; ok {
 			constraints = tarutil.FinCacheFileConstraints
 		}
 
-		err := tarutil.TarDirectory(constraints, path, w, make([]byte, CopyBuf))
+		err := tarutil.TarDirectory(constraints, path, lim.limitReadsTo(r.Context(), w), make([]byte, CopyBuf))
 		if err != nil {
 			log.Errorf("send tar: %+v", err)
 			return
 		}
 	} else {
 		w.Header().Set("Content-Type", "application/octet-stream")
-		// will do a ranged read over the file at the given path if the caller has asked for a ranged read in the request headers.
-		http.ServeFile(w, r, path)
+		w.Header().Set(rangeChecksumHeader, rangeChecksumSha256)
+		w.Header().Set(rangeChecksumAlgosHeader, strings.Join(rangeChecksumAlgos, ","))
+
+		algo := r.Header.Get(rangeChecksumHeader)
+		if _, has := r.Header["Range"]; has && r.Method == http.MethodGet && algo != "" {
+			f, err := openDecrypted(storeKeyring(handler.Local), rio, path)
+			if err != nil {
+				log.Errorf("opening sector file: %+v", err)
+				w.WriteHeader(500)
+				return
+			}
+			defer f.Close() // nolint:errcheck
+
+			serveChecksummedRange(w, r, lim.limitReadSeeker(r.Context(), f.ReadSeeker()), f.size, algo)
+		} else if lim == nil && rio == (storiface.PathReadIO{}) && !IsEncryptedFile(path) {
+			// will do a ranged read over the file at the given path if the caller has asked for a ranged read in the request headers.
+			http.ServeFile(w, r, path)
+		} else {
+			f, err := openDecrypted(storeKeyring(handler.Local), rio, path)
+			if err != nil {
+				log.Errorf("opening sector file: %+v", err)
+				w.WriteHeader(500)
+				return
+			}
+			defer f.Close() // nolint:errcheck
+
+			// ranged reads are handled by ServeContent the same way as ServeFile, encrypted files are
+			// served decrypted
+			http.ServeContent(w, r, "", stat.ModTime(), lim.limitReadSeeker(r.Context(), f.ReadSeeker()))
+		}
 	}
 
 	log.Debugf("served sector file/dir, sectorID=%+v, fileType=%s, path=%s", id, ft, path)
 }
 
+// serveChecksummedRange serves a single byte range of rs, sending the checksum of the served
+// data in a trailer so that parallel ranged fetches can verify each range
+func serveChecksummedRange(w http.ResponseWriter, r *http.Request, rs io.ReadSeeker, size int64, algo string) {
+	h, trailer, ok := rangeHasher(algo)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported range checksum algorithm %q", algo), http.StatusBadRequest)
+		return
+	}
+
+	off, n, err := parseByteRange(r.Header.Get("Range"), size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if _, err := rs.Seek(off, io.SeekStart); err != nil {
+		log.Errorf("seeking sector file: %+v", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// the response is chunked so that the trailer can be sent, so Content-Length isn't set
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", off, off+n-1, size))
+	w.Header().Set("Trailer", trailer)
+	w.WriteHeader(http.StatusPartialContent)
+
+	if _, err := io.CopyBuffer(io.MultiWriter(w, h), io.LimitReader(rs, n), make([]byte, CopyBuf)); err != nil {
+		// without the trailer the client will retry the range
+		log.Errorf("serving range: %+v", err)
+		return
+	}
+
+	w.Header().Set(trailer, hex.EncodeToString(h.Sum(nil)))
+}
+
+// parseByteRange parses a single 'bytes=start-end' range, returning its offset and length
+func parseByteRange(h string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(h, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, xerrors.Errorf("unsupported range '%s'", h)
+	}
+
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok || startStr == "" {
+		return 0, 0, xerrors.Errorf("unsupported range '%s'", h)
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil {
+		return 0, 0, xerrors.Errorf("parsing range start: %w", err)
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil {
+			return 0, 0, xerrors.Errorf("parsing range end: %w", err)
+		}
+		end = min(end, size-1)
+	}
+
+	if start < 0 || start > end {
+		return 0, 0, xerrors.Errorf("range '%s' not satisfiable for size %d", h, size)
+	}
+
+	return start, end - start + 1, nil
+}
+
 func (handler *FetchHandler) remoteDeleteSector(w http.ResponseWriter, r *http.Request) {
 	log.Infof("SERVE DELETE %s", r.URL)
 	vars := mux.Vars(r)
@@ -267,7 +383,7 @@ func (handler *FetchHandler) remoteGetAllocated(w http.ResponseWriter, r *http.R
 	}
 
 	// open the Unsealed file and check if it has the Unsealed sector for the piece at the given offset and size.
-	pf, err := handler.PfHandler.OpenPartialFile(abi.PaddedPieceSize(ssize), path)
+	pf, err := openUnsealed(handler.PfHandler, storeKeyring(handler.Local), storiface.PathReadIO{}, abi.PaddedPieceSize(ssize), path)
 	if err != nil {
 		log.Error("opening partial file: ", err)
 		w.WriteHeader(500)
@@ -22,6 +22,7 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/proof"
 
+	"github.com/filecoin-project/curio/lib/cryptfile"
 	cuproof "github.com/filecoin-project/curio/lib/proof"
 	"github.com/filecoin-project/curio/lib/storiface"
 	"github.com/filecoin-project/curio/lib/supraffi"
@@ -64,7 +65,27 @@ type Local struct {
 
 	paths map[storiface.ID]*path
 
+	// keys decrypt encrypted sector files, nil if encryption isn't enabled
+	keys *cryptfile.Keyring
+
+	// read IO options of paths which don't set their own, by path class
+	readIOSeal, readIOStore storiface.PathReadIO
+
+	// called before sector files are removed, see SetRemovalHook
+	removalHook          RemovalHook
+	removalHookAllCopies bool
+
 	localLk sync.RWMutex
+
+	leaseLk sync.Mutex
+	leases  map[*reservationLease]struct{}
+
+	accessLk sync.Mutex
+	accesses map[abi.SectorID]time.Time
+
+	// space reservations of sector uploads being received, held until the upload is moved into place
+	uploadLk sync.Mutex
+	uploads  map[uploadKey]func()
 }
 
 type sectorFile struct {
@@ -78,6 +99,51 @@ type path struct {
 
 	reserved     int64
 	reservations map[sectorFile]int64
+
+	limiter *pathLimiter
+
+	encryptUnsealed bool
+
+	// sharedFS paths are mounted by multiple machines, file writes and removals need exclusive leases
+	sharedFS bool
+
+	// readIO overrides the read IO options of the path class, canStore selects the class
+	readIO   *storiface.PathReadIO
+	canStore bool
+
+	// content restrictions from the path metadata, enforced when reserving space
+	allowTypes, denyTypes   []string
+	allowMiners, denyMiners []string
+
+	// archive paths are read-only, nothing is written to or removed from them
+	archive bool
+}
+
+// allows checks whether the path restrictions allow placing the file type of the miner in the path
+func (p *path) allows(ft storiface.SectorFileType, miner abi.ActorID) error {
+	if p.archive {
+		return xerrors.Errorf("path '%s' is a read-only archive", p.local)
+	}
+	if !ft.Allowed(p.allowTypes, p.denyTypes) {
+		return xerrors.Errorf("path '%s' doesn't allow %s files", p.local, ft)
+	}
+	if miner == NoMinerFilter {
+		return nil
+	}
+	ok, msg, err := MinerFilter(p.allowMiners, p.denyMiners, miner)
+	if err != nil {
+		return xerrors.Errorf("checking miner restrictions of '%s': %w", p.local, err)
+	}
+	if !ok {
+		return xerrors.Errorf("path '%s' doesn't accept data of miner %d: %s", p.local, miner, msg)
+	}
+	return nil
+}
+
+func (p *path) setRestrictions(meta storiface.LocalStorageMeta) {
+	p.allowTypes, p.denyTypes = meta.AllowTypes, meta.DenyTypes
+	p.allowMiners, p.denyMiners = meta.AllowMiners, meta.DenyMiners
+	p.archive = meta.Archive
 }
 
 // statExistingSectorForReservation is optional parameter for stat method
@@ -227,7 +293,9 @@ func NewLocal(ctx context.Context, ls LocalStorage, index SectorIndex, urls []st
 		index:        index,
 		urls:         urls,
 
-		paths: map[storiface.ID]*path{},
+		paths:   map[storiface.ID]*path{},
+		leases:  map[*reservationLease]struct{}{},
+		uploads: map[uploadKey]func(){},
 	}
 	return l, l.open(ctx)
 }
@@ -250,6 +318,10 @@ func (st *Local) OpenPath(ctx context.Context, p string) error {
 		return xerrors.Errorf("path with ID %s already opened: '%s'", meta.ID, p.local)
 	}
 
+	if err := checkEncryptUnsealed(meta); err != nil {
+		return xerrors.Errorf("storage metadata for %s: %w", p, err)
+	}
+
 	// TODO: Check existing / dedupe
 
 	out := &path{
@@ -258,7 +330,16 @@ func (st *Local) OpenPath(ctx context.Context, p string) error {
 		maxStorage:   meta.MaxStorage,
 		reserved:     0,
 		reservations: map[sectorFile]int64{},
+
+		limiter: newPathLimiter(meta),
+
+		encryptUnsealed: meta.EncryptUnsealed,
+		sharedFS:        meta.SharedFS,
+
+		readIO:   meta.ReadIO,
+		canStore: meta.CanStore,
 	}
+	out.setRestrictions(meta)
 
 	fst, _, err := out.stat(st.localStorage)
 	if err != nil {
@@ -278,6 +359,8 @@ func (st *Local) OpenPath(ctx context.Context, p string) error {
 		DenyTypes:   meta.DenyTypes,
 		AllowMiners: meta.AllowMiners,
 		DenyMiners:  meta.DenyMiners,
+		Tier:        meta.Tier,
+		Archive:     meta.Archive,
 	}, fst)
 	if err != nil {
 		return xerrors.Errorf("declaring storage in index: %w", err)
@@ -292,6 +375,85 @@ func (st *Local) OpenPath(ctx context.Context, p string) error {
 	return nil
 }
 
+// DetachPath removes an opened path from the persisted storage config, so that it isn't opened again on
+// restart, and closes it
+func (st *Local) DetachPath(ctx context.Context, id storiface.ID) error {
+	st.localLk.RLock()
+	p, ok := st.paths[id]
+	st.localLk.RUnlock()
+	if !ok {
+		return xerrors.Errorf("path with ID %s isn't opened", id)
+	}
+
+	var found bool
+	if err := st.localStorage.SetStorage(func(sc *storiface.StorageConfig) {
+		out := make([]storiface.LocalPath, 0, len(sc.StoragePaths))
+		for _, storagePath := range sc.StoragePaths {
+			if storagePath.Path != p.local {
+				out = append(out, storagePath)
+				continue
+			}
+			found = true
+		}
+		sc.StoragePaths = out
+	}); err != nil {
+		return xerrors.Errorf("set storage config: %w", err)
+	}
+	if !found {
+		// maybe this is fine?
+		return xerrors.Errorf("path not found in storage.json")
+	}
+
+	// unregister locally, drop from sector index
+	return st.ClosePath(ctx, id)
+}
+
+// SetPathRestrictions updates what the path can be used for in its metadata file, and redeclares it so that
+// the new restrictions apply to allocations on all nodes. Data already in the path is not moved.
+func (st *Local) SetPathRestrictions(ctx context.Context, id storiface.ID, r storiface.PathRestrictions) error {
+	err := func() error {
+		st.localLk.Lock()
+		defer st.localLk.Unlock()
+
+		p, ok := st.paths[id]
+		if !ok {
+			return xerrors.Errorf("path with ID %s isn't opened", id)
+		}
+
+		metaPath := filepath.Join(p.local, MetaFile)
+		mb, err := os.ReadFile(metaPath)
+		if err != nil {
+			return xerrors.Errorf("reading storage metadata for %s: %w", p.local, err)
+		}
+
+		var meta storiface.LocalStorageMeta
+		if err := json.Unmarshal(mb, &meta); err != nil {
+			return xerrors.Errorf("unmarshalling storage metadata for %s: %w", p.local, err)
+		}
+
+		if err := r.Apply(&meta); err != nil {
+			return xerrors.Errorf("invalid restrictions: %w", err)
+		}
+
+		mb, err = json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return xerrors.Errorf("marshaling storage metadata: %w", err)
+		}
+		if err := os.WriteFile(metaPath+storiface.TempSuffix, mb, 0644); err != nil {
+			return xerrors.Errorf("writing storage metadata: %w", err)
+		}
+		if err := os.Rename(metaPath+storiface.TempSuffix, metaPath); err != nil {
+			return xerrors.Errorf("replacing storage metadata: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	return st.Redeclare(ctx, &id, false)
+}
+
 func (st *Local) ClosePath(ctx context.Context, id storiface.ID) error {
 	st.localLk.Lock()
 	defer st.localLk.Unlock()
@@ -332,6 +494,7 @@ func (st *Local) open(ctx context.Context) error {
 	log.Infof("declared %d sectors in %s, %d/s", declareCtr, time.Since(startTime), int(float64(declareCtr)/time.Since(startTime).Seconds()))
 
 	go st.reportHealth(ctx)
+	go st.leaseLoop(ctx)
 
 	return nil
 }
@@ -365,6 +528,16 @@ func (st *Local) Redeclare(ctx context.Context, filterId *storiface.ID, dropMiss
 		if filterId != nil && *filterId != id {
 			continue
 		}
+		if err := checkEncryptUnsealed(meta); err != nil {
+			log.Errorf("not redeclaring storage path %s: %s", p.local, err)
+			continue
+		}
+
+		p.limiter = newPathLimiter(meta)
+		p.encryptUnsealed = meta.EncryptUnsealed
+		p.sharedFS = meta.SharedFS
+		p.readIO, p.canStore = meta.ReadIO, meta.CanStore
+		p.setRestrictions(meta)
 
 		err = st.index.StorageAttach(ctx, storiface.StorageInfo{
 			ID:          id,
@@ -379,6 +552,8 @@ func (st *Local) Redeclare(ctx context.Context, filterId *storiface.ID, dropMiss
 			DenyTypes:   meta.DenyTypes,
 			AllowMiners: meta.AllowMiners,
 			DenyMiners:  meta.DenyMiners,
+			Tier:        meta.Tier,
+			Archive:     meta.Archive,
 		}, fst)
 		if err != nil {
 			return xerrors.Errorf("redeclaring storage in index: %w", err)
@@ -510,10 +685,21 @@ func (st *Local) reportStorage(ctx context.Context) {
 
 func (st *Local) Reserve(ctx context.Context, sid storiface.SectorRef, ft storiface.SectorFileType,
 	storageIDs storiface.SectorPaths, overheadTab map[storiface.SectorFileType]int, minFreePercentage float64) (func(), error) {
-	ssize, err := sid.ProofType.SectorSize()
+	release, entries, err := st.reserve(sid, ft, storageIDs, overheadTab, minFreePercentage)
 	if err != nil {
 		return nil, err
 	}
+
+	return st.leaseReservation(ctx, sid.ID, entries, release)
+}
+
+func (st *Local) reserve(sid storiface.SectorRef, ft storiface.SectorFileType,
+	storageIDs storiface.SectorPaths, overheadTab map[storiface.SectorFileType]int, minFreePercentage float64) (func(), []reservationEntry, error) {
+	ssize, err := sid.ProofType.SectorSize()
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries []reservationEntry
 	release := func() {}
 
 	st.localLk.Lock()
@@ -528,20 +714,31 @@ func (st *Local) Reserve(ctx context.Context, sid storiface.SectorRef, ft storif
 		}
 	}()
 
+	// check all paths before reserving anything, so that nothing has to be released on a restriction error
+	for _, fileType := range ft.AllSet() {
+		p, ok := st.paths[storiface.ID(storiface.PathByType(storageIDs, fileType))]
+		if !ok {
+			return nil, nil, errPathNotFound
+		}
+		if err := p.allows(fileType, sid.ID.Miner); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	for _, fileType := range ft.AllSet() {
 		fileType := fileType
 		id := storiface.ID(storiface.PathByType(storageIDs, fileType))
 
 		p, ok := st.paths[id]
 		if !ok {
-			return nil, errPathNotFound
+			return nil, nil, errPathNotFound
 		}
 
 		overhead := int64(overheadTab[fileType]) * int64(ssize) / storiface.FSOverheadDen
 
 		stat, resvOnDisk, err := p.stat(st.localStorage, statExistingSectorForReservation{sid.ID, fileType, overhead})
 		if err != nil {
-			return nil, xerrors.Errorf("getting local storage stat: %w", err)
+			return nil, nil, xerrors.Errorf("getting local storage stat: %w", err)
 		}
 
 		if overhead-resvOnDisk < 0 {
@@ -552,13 +749,13 @@ func (st *Local) Reserve(ctx context.Context, sid storiface.SectorRef, ft storif
 		overheadOnDisk := overhead - resvOnDisk
 
 		if stat.Available < overheadOnDisk {
-			return nil, storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("can't reserve %d bytes in '%s' (id:%s), only %d available", overhead, p.local, id, stat.Available))
+			return nil, nil, storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("can't reserve %d bytes in '%s' (id:%s), only %d available", overhead, p.local, id, stat.Available))
 		}
 
 		freePercentag := (float64(stat.Available-overheadOnDisk) / float64(stat.Available)) * 100.0
 
 		if freePercentag < minFreePercentage {
-			return nil, storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("can't reserve %d bytes in '%s' (id:%s), free disk percentage %f will be lower than minimum %f", overhead, p.local, id, freePercentag, minFreePercentage))
+			return nil, nil, storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("can't reserve %d bytes in '%s' (id:%s), free disk percentage %f will be lower than minimum %f", overhead, p.local, id, freePercentag, minFreePercentage))
 		}
 
 		resID := sectorFile{sid.ID, fileType}
@@ -567,6 +764,7 @@ func (st *Local) Reserve(ctx context.Context, sid storiface.SectorRef, ft storif
 
 		p.reserved += overhead
 		p.reservations[resID] = overhead
+		entries = append(entries, reservationEntry{id: id, ft: fileType, bytes: overhead, exclusive: p.sharedFS})
 
 		old_r := release
 		release = func() {
@@ -579,7 +777,7 @@ func (st *Local) Reserve(ctx context.Context, sid storiface.SectorRef, ft storif
 		}
 	}
 
-	return release, nil
+	return release, entries, nil
 }
 
 // DoubleCallWrap wraps a function to make sure it's not called twice
@@ -662,6 +860,10 @@ func (st *Local) AcquireSector(ctx context.Context, sid storiface.SectorRef, exi
 			}
 
 			if allocate.Has(fileType) {
+				if p.archive {
+					continue
+				}
+
 				ok, err := allocPathOk(info.CanSeal, info.CanStore, info.AllowTypes, info.DenyTypes, info.AllowMiners, info.DenyMiners, fileType, sid.ID.Miner)
 				if err != nil {
 					log.Debug(err)
@@ -702,7 +904,7 @@ func (st *Local) AcquireSector(ctx context.Context, sid storiface.SectorRef, exi
 				continue
 			}
 
-			if p.local == "" { // TODO: can that even be the case?
+			if p.local == "" || p.archive { // TODO: can that even be the case?
 				continue
 			}
 
@@ -783,6 +985,9 @@ func (st *Local) Remove(ctx context.Context, sid abi.SectorID, typ storiface.Sec
 
 storeLoop:
 	for _, info := range si {
+		if info.Archive {
+			continue
+		}
 		for _, id := range keepIn {
 			if id == info.ID {
 				continue storeLoop
@@ -821,7 +1026,7 @@ func (st *Local) RemoveCopies(ctx context.Context, sid abi.SectorID, typ storifa
 	}
 
 	for _, info := range si {
-		if info.Primary {
+		if info.Primary || info.Archive {
 			continue
 		}
 
@@ -843,6 +1048,22 @@ func (st *Local) removeSector(ctx context.Context, sid abi.SectorID, typ storifa
 		return nil
 	}
 
+	if p.archive {
+		return xerrors.Errorf("not removing sector %d(t:%d) from read-only archive path %s", sid, typ, storage)
+	}
+
+	if err := st.callRemovalHook(ctx, sid, typ, storage, p.sectorPath(sid, typ)); err != nil {
+		return err
+	}
+
+	if p.sharedFS {
+		release, err := st.leaseSharedFile(ctx, sid, typ, storage, "remove")
+		if err != nil {
+			return xerrors.Errorf("removing sector from shared path: %w", err)
+		}
+		defer release()
+	}
+
 	if err := st.index.StorageDropSector(ctx, storage, sid, typ); err != nil {
 		return xerrors.Errorf("dropping sector from index: %w", err)
 	}
@@ -909,13 +1130,18 @@ func (st *Local) MoveStorage(ctx context.Context, s storiface.SectorRef, types s
 			continue
 		}
 
+		if sst.Archive {
+			log.Debugf("not moving %v(%d); source is a read-only archive", s, fileType)
+			continue
+		}
+
 		log.Debugf("moving %v(%d) to storage: %s(se:%t; st:%t) -> %s(se:%t; st:%t)", s, fileType, sst.ID, sst.CanSeal, sst.CanStore, dst.ID, dst.CanSeal, dst.CanStore)
 
 		if err := st.index.StorageDropSector(ctx, storiface.ID(storiface.PathByType(srcIds, fileType)), s.ID, fileType); err != nil {
 			return xerrors.Errorf("dropping source sector from index: %w", err)
 		}
 
-		if err := Move(storiface.PathByType(src, fileType), storiface.PathByType(dest, fileType)); err != nil {
+		if err := st.moveSectorFile(fileType, storiface.PathByType(src, fileType), storiface.PathByType(dest, fileType), dst.ID); err != nil {
 			// TODO: attempt some recovery (check if src is still there, re-declare)
 			return xerrors.Errorf("moving sector %v(%d): %w", s, fileType, err)
 		}
@@ -987,11 +1213,22 @@ func (st *Local) GenerateSingleVanillaProof(ctx context.Context, minerID abi.Act
 		SealedSectorPath: sealed,
 	}
 
+	dropSealed := st.readIO(storiface.ID(sealedID)).DirectIO
+	dropCache := st.readIO(storiface.ID(cacheID)).DirectIO
+
 	start := time.Now()
 
 	resCh := make(chan result.Result[[]byte], 1)
 	go func() {
 		resCh <- result.Wrap(ffi.GenerateSingleVanillaProof(psi, si.Challenge))
+
+		// the proofs library reads through the page cache, evict what it read from paths set to bypass it
+		if dropSealed {
+			dropPageCache(sealed)
+		}
+		if dropCache {
+			dropPageCache(cache)
+		}
 	}()
 
 	select {
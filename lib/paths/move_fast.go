@@ -0,0 +1,74 @@
+package paths
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// errNoFastMove is returned by moveFast when the data can't be moved without copying it
+var errNoFastMove = errors.New("fast move not possible")
+
+// moveFast moves sector data without copying it: with a rename when both paths are on the same filesystem, or
+// by cloning file extents (reflink) when the filesystem supports sharing extents across mount points. When
+// neither is possible errNoFastMove is returned and nothing is modified.
+func moveFast(from, to string) (method string, err error) {
+	err = os.Rename(from, to)
+	if err == nil {
+		return "rename", nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		// leave reporting the error to the regular move
+		return "", errNoFastMove
+	}
+
+	if err := cloneMove(from, to); err != nil {
+		return "reflink", err
+	}
+	return "reflink", nil
+}
+
+// cloneMove clones a file, or the regular files of a directory, into to and removes from
+func cloneMove(from, to string) error {
+	st, err := os.Stat(from)
+	if err != nil {
+		return errNoFastMove
+	}
+
+	if !st.IsDir() {
+		if err := cloneFile(from, to); err != nil {
+			log.Debugw("reflink not possible", "from", from, "to", to, "error", err)
+			return errNoFastMove
+		}
+		return os.Remove(from)
+	}
+
+	ents, err := os.ReadDir(from)
+	if err != nil {
+		return errNoFastMove
+	}
+	for _, ent := range ents {
+		if !ent.Type().IsRegular() {
+			return errNoFastMove
+		}
+	}
+
+	if _, err := os.Stat(to); err == nil {
+		// let the regular move handle existing destinations
+		return errNoFastMove
+	}
+	if err := os.Mkdir(to, st.Mode().Perm()); err != nil {
+		return errNoFastMove
+	}
+
+	for _, ent := range ents {
+		if err := cloneFile(filepath.Join(from, ent.Name()), filepath.Join(to, ent.Name())); err != nil {
+			log.Debugw("reflink not possible", "from", from, "to", to, "error", err)
+			_ = os.RemoveAll(to)
+			return errNoFastMove
+		}
+	}
+
+	return os.RemoveAll(from)
+}
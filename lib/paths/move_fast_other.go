@@ -0,0 +1,9 @@
+//go:build !linux
+
+package paths
+
+import "errors"
+
+func cloneFile(src, dst string) error {
+	return errors.New("reflink not supported on this platform")
+}
@@ -0,0 +1,54 @@
+package paths
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoveRename(t *testing.T) {
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "a", "s-t01000-1")
+	require.NoError(t, os.MkdirAll(src, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "p_aux"), []byte("aux"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "b"), 0755))
+
+	dst := filepath.Join(dir, "b", "s-t01000-1")
+	method, err := moveFast(src, dst)
+	require.NoError(t, err)
+	require.Equal(t, "rename", method)
+
+	b, err := os.ReadFile(filepath.Join(dst, "p_aux"))
+	require.NoError(t, err)
+	require.Equal(t, "aux", string(b))
+	require.NoDirExists(t, src)
+}
+
+func TestCloneMove(t *testing.T) {
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "a", "s-t01000-1")
+	require.NoError(t, os.MkdirAll(src, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "sc-02-data-tree-r-last.dat"), []byte("tree"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "p_aux"), []byte("aux"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "b"), 0755))
+
+	dst := filepath.Join(dir, "b", "s-t01000-1")
+	err := cloneMove(src, dst)
+	if errors.Is(err, errNoFastMove) {
+		// no reflink support in the test filesystem, nothing may be modified
+		require.DirExists(t, src)
+		require.NoDirExists(t, dst)
+		return
+	}
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(dst, "p_aux"))
+	require.NoError(t, err)
+	require.Equal(t, "aux", string(b))
+	require.NoDirExists(t, src)
+}
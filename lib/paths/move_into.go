@@ -0,0 +1,64 @@
+package paths
+
+import (
+	"context"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// MoveSectorInto moves sector files into the local path dest, with space reserved there for the duration of the
+// move. Store paths reserve space for finalized files, sealing paths for files being sealed.
+func MoveSectorInto(ctx context.Context, stor *Remote, lstor *Local, index SectorIndex, sref storiface.SectorRef, ft storiface.SectorFileType, dest storiface.StoragePath, pathType storiface.PathType) error {
+	var into storiface.PathsWithIDs
+	for _, f := range ft.AllSet() {
+		storiface.SetPathByType(&into.Paths, f, filepath.Join(dest.LocalPath, f.String(), storiface.SectorName(sref.ID)))
+		storiface.SetPathByType(&into.IDs, f, string(dest.ID))
+	}
+
+	overhead := storiface.FSOverheadSeal
+	if pathType == storiface.PathStorage {
+		overhead = storiface.FsOverheadFinalized
+	}
+
+	release, err := lstor.Reserve(ctx, sref, ft, into.IDs, overhead, MinFreeStoragePercentage)
+	if err != nil {
+		return xerrors.Errorf("reserving space in %s: %w", dest.ID, err)
+	}
+	defer release()
+
+	// files held by other nodes are fetched straight into the destination,
+	// declared there and removed from the source; files in other local paths
+	// are returned where they are and moved below
+	src, srcIDs, err := stor.AcquireSector(ctx, sref, ft, storiface.FTNone, pathType, storiface.AcquireMove, storiface.AcquireInto(into))
+	if err != nil {
+		return xerrors.Errorf("acquiring sector: %w", err)
+	}
+
+	for _, f := range ft.AllSet() {
+		srcPath := storiface.PathByType(src, f)
+		dstPath := storiface.PathByType(into.Paths, f)
+		if srcPath == dstPath {
+			continue
+		}
+
+		srcID := storiface.ID(storiface.PathByType(srcIDs, f))
+		if srcID == dest.ID {
+			continue
+		}
+
+		if err := lstor.moveSectorFile(f, srcPath, dstPath, dest.ID); err != nil {
+			return xerrors.Errorf("moving %s from %s: %w", f, srcID, err)
+		}
+		if err := index.StorageDeclareSector(ctx, dest.ID, sref.ID, f, pathType == storiface.PathStorage); err != nil {
+			return xerrors.Errorf("declaring %s in %s: %w", f, dest.ID, err)
+		}
+		if err := index.StorageDropSector(ctx, srcID, sref.ID, f); err != nil {
+			return xerrors.Errorf("dropping %s from %s: %w", f, srcID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package paths
+
+import (
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+type PlacementStrategy string
+
+const (
+	// PlacementAvailable prefers paths with the most available space, scaled by path weight
+	PlacementAvailable PlacementStrategy = "available"
+	// PlacementFreePercent prefers paths with the highest fraction of free space, scaled by path weight
+	PlacementFreePercent PlacementStrategy = "free-percent"
+	// PlacementWeight prefers paths with the highest weight, breaking ties by available space
+	PlacementWeight PlacementStrategy = "weight"
+)
+
+// PlacementPolicy decides which of the storage paths with enough space gets new sector data
+type PlacementPolicy struct {
+	Strategy PlacementStrategy
+
+	// PreferTiers lists path tiers in order of preference; paths in other tiers rank after all listed tiers
+	PreferTiers []string
+
+	// MinerAffinity ranks paths which explicitly allow the miner in AllowMiners before shared paths
+	MinerAffinity bool
+}
+
+var DefaultPlacementPolicy = PlacementPolicy{Strategy: PlacementAvailable}
+
+func (p PlacementPolicy) Validate() error {
+	switch p.Strategy {
+	case "", PlacementAvailable, PlacementFreePercent, PlacementWeight:
+		return nil
+	default:
+		return xerrors.Errorf("unknown placement strategy '%s'", p.Strategy)
+	}
+}
+
+// PlacementCandidate is a storage path considered for allocating new sector data
+type PlacementCandidate struct {
+	storiface.StorageInfo
+
+	Capacity  int64
+	Available int64
+}
+
+// Sort orders candidates from the most to the least preferred for allocating data of the miner
+func (p PlacementPolicy) Sort(cands []PlacementCandidate, miner abi.ActorID) {
+	tierRank := func(c PlacementCandidate) int {
+		for i, t := range p.PreferTiers {
+			if t == c.Tier {
+				return i
+			}
+		}
+		return len(p.PreferTiers)
+	}
+
+	affine := func(c PlacementCandidate) bool {
+		if !p.MinerAffinity || miner == NoMinerFilter || len(c.AllowMiners) == 0 {
+			return false
+		}
+		ok, _, err := MinerFilter(c.AllowMiners, nil, miner)
+		return err == nil && ok
+	}
+
+	sort.SliceStable(cands, func(i, j int) bool {
+		a, b := cands[i], cands[j]
+
+		if ra, rb := tierRank(a), tierRank(b); ra != rb {
+			return ra < rb
+		}
+		if aa, ab := affine(a), affine(b); aa != ab {
+			return aa
+		}
+
+		switch p.Strategy {
+		case PlacementFreePercent:
+			return freeFraction(a)*float64(a.Weight) > freeFraction(b)*float64(b.Weight)
+		case PlacementWeight:
+			if a.Weight != b.Weight {
+				return a.Weight > b.Weight
+			}
+			return a.Available > b.Available
+		default:
+			return float64(a.Available)*float64(a.Weight) > float64(b.Available)*float64(b.Weight)
+		}
+	})
+}
+
+func freeFraction(c PlacementCandidate) float64 {
+	if c.Capacity <= 0 {
+		return 0
+	}
+	return float64(c.Available) / float64(c.Capacity)
+}
+
+// PlacementStep is one simulated allocation of a placement preview
+type PlacementStep struct {
+	ID storiface.ID
+
+	// Available is the space left in the path after the allocation
+	Available int64
+}
+
+// Preview simulates allocating n sectors needing spaceReq bytes each, returning the
+// path chosen for each allocation. The preview stops early when no path has enough space.
+func (p PlacementPolicy) Preview(cands []PlacementCandidate, miner abi.ActorID, spaceReq uint64, n int) []PlacementStep {
+	cands = append([]PlacementCandidate(nil), cands...)
+
+	var out []PlacementStep
+	for len(out) < n {
+		fit := make([]PlacementCandidate, 0, len(cands))
+		for _, c := range cands {
+			if c.Available >= int64(spaceReq) {
+				fit = append(fit, c)
+			}
+		}
+		if len(fit) == 0 {
+			break
+		}
+
+		p.Sort(fit, miner)
+
+		for i := range cands {
+			if cands[i].ID == fit[0].ID {
+				cands[i].Available -= int64(spaceReq)
+				out = append(out, PlacementStep{ID: cands[i].ID, Available: cands[i].Available})
+				break
+			}
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,56 @@
+package paths
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+func placementIDs(cands []PlacementCandidate) []storiface.ID {
+	var out []storiface.ID
+	for _, c := range cands {
+		out = append(out, c.ID)
+	}
+	return out
+}
+
+func TestPlacementSort(t *testing.T) {
+	cands := []PlacementCandidate{
+		{StorageInfo: storiface.StorageInfo{ID: "big", Weight: 10, Tier: "cold"}, Capacity: 1000, Available: 600},
+		{StorageInfo: storiface.StorageInfo{ID: "empty", Weight: 10, Tier: "hot"}, Capacity: 100, Available: 90},
+		{StorageInfo: storiface.StorageInfo{ID: "heavy", Weight: 50, Tier: "hot", AllowMiners: []string{"f01000"}}, Capacity: 1000, Available: 100},
+	}
+
+	sorted := func(p PlacementPolicy) []storiface.ID {
+		c := append([]PlacementCandidate(nil), cands...)
+		p.Sort(c, 1000)
+		return placementIDs(c)
+	}
+
+	require.Equal(t, []storiface.ID{"big", "heavy", "empty"}, sorted(PlacementPolicy{Strategy: PlacementAvailable}))
+	require.Equal(t, []storiface.ID{"empty", "big", "heavy"}, sorted(PlacementPolicy{Strategy: PlacementFreePercent}))
+	require.Equal(t, []storiface.ID{"heavy", "big", "empty"}, sorted(PlacementPolicy{Strategy: PlacementWeight}))
+	require.Equal(t, []storiface.ID{"heavy", "empty", "big"}, sorted(PlacementPolicy{Strategy: PlacementAvailable, PreferTiers: []string{"hot"}}))
+	require.Equal(t, []storiface.ID{"heavy", "empty", "big"}, sorted(PlacementPolicy{Strategy: PlacementFreePercent, MinerAffinity: true}))
+}
+
+func TestPlacementPreview(t *testing.T) {
+	cands := []PlacementCandidate{
+		{StorageInfo: storiface.StorageInfo{ID: "a", Weight: 1}, Capacity: 100, Available: 30},
+		{StorageInfo: storiface.StorageInfo{ID: "b", Weight: 1}, Capacity: 100, Available: 25},
+	}
+
+	steps := PlacementPolicy{Strategy: PlacementAvailable}.Preview(cands, 1000, 10, 10)
+	require.Equal(t, []PlacementStep{
+		{ID: "a", Available: 20},
+		{ID: "b", Available: 15},
+		{ID: "a", Available: 10},
+		{ID: "b", Available: 5},
+		{ID: "a", Available: 0},
+	}, steps)
+
+	// the input isn't modified
+	require.EqualValues(t, 30, cands[0].Available)
+}
@@ -0,0 +1,171 @@
+package paths
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+const (
+	minQoSBurst = 64 << 10
+	maxQoSBurst = 16 << 20
+)
+
+// pathLimiter enforces the bandwidth and IOPS limits of a storage path on IO
+// going through the path store: sector fetches into the path, sector files
+// served to other nodes and local sector readers. Challenge reads done by the
+// proofs library don't go through the limiter, so background moves and unsealing
+// can't starve them.
+//
+// A nil *pathLimiter doesn't limit anything.
+type pathLimiter struct {
+	read, write *rate.Limiter
+	ops         *rate.Limiter
+}
+
+func newPathLimiter(meta storiface.LocalStorageMeta) *pathLimiter {
+	if meta.MaxReadBandwidth == 0 && meta.MaxWriteBandwidth == 0 && meta.MaxIOPS == 0 {
+		return nil
+	}
+
+	return &pathLimiter{
+		read:  bandwidthLimiter(meta.MaxReadBandwidth),
+		write: bandwidthLimiter(meta.MaxWriteBandwidth),
+		ops:   opsLimiter(meta.MaxIOPS),
+	}
+}
+
+func bandwidthLimiter(bps uint64) *rate.Limiter {
+	if bps == 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bps), int(min(max(bps, minQoSBurst), maxQoSBurst)))
+}
+
+func opsLimiter(iops uint64) *rate.Limiter {
+	if iops == 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(iops), int(max(iops/10, 1)))
+}
+
+// take waits until an operation of up to n bytes is allowed, returning the
+// number of bytes the operation may transfer
+func (l *pathLimiter) take(ctx context.Context, bw *rate.Limiter, n int) (int, error) {
+	if l.ops != nil {
+		if err := l.ops.Wait(ctx); err != nil {
+			return 0, err
+		}
+	}
+	if bw != nil && n > 0 {
+		n = min(n, bw.Burst())
+		if err := bw.WaitN(ctx, n); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// limitReads limits reading data of the path from r
+func (l *pathLimiter) limitReads(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, l: l, bw: l.read}
+}
+
+// limitWrites limits data read from r which is being written into the path
+func (l *pathLimiter) limitWrites(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, l: l, bw: l.write}
+}
+
+// limitReadsTo limits data of the path written to w
+func (l *pathLimiter) limitReadsTo(ctx context.Context, w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &limitedWriter{ctx: ctx, w: w, l: l, bw: l.read}
+}
+
+// limitReadSeeker limits reads of the path from rs, keeping it seekable
+func (l *pathLimiter) limitReadSeeker(ctx context.Context, rs io.ReadSeeker) io.ReadSeeker {
+	if l == nil {
+		return rs
+	}
+	return struct {
+		io.Reader
+		io.Seeker
+	}{
+		Reader: l.limitReads(ctx, rs),
+		Seeker: rs,
+	}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *pathLimiter
+	bw  *rate.Limiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.l.take(lr.ctx, lr.bw, len(p))
+	if err != nil {
+		return 0, err
+	}
+	return lr.r.Read(p[:n])
+}
+
+type limitedWriter struct {
+	ctx context.Context
+	w   io.Writer
+	l   *pathLimiter
+	bw  *rate.Limiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	var written int
+	for written < len(p) {
+		n, err := lw.l.take(lw.ctx, lw.bw, len(p)-written)
+		if err != nil {
+			return written, err
+		}
+		n, err = lw.w.Write(p[written : written+n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// limiter returns the QoS limiter of a local path, nil if the path is unknown or unlimited
+func (st *Local) limiter(id storiface.ID) *pathLimiter {
+	st.localLk.RLock()
+	defer st.localLk.RUnlock()
+
+	p, ok := st.paths[id]
+	if !ok {
+		return nil
+	}
+	return p.limiter
+}
+
+// storeLimiter returns the QoS limiter of a path attached to a local store
+func storeLimiter(s Store, id storiface.ID) *pathLimiter {
+	l, ok := s.(*Local)
+	if !ok {
+		return nil
+	}
+	return l.limiter(id)
+}
+
+func (r *Remote) limiter(id storiface.ID) *pathLimiter {
+	return storeLimiter(r.local, id)
+}
@@ -0,0 +1,52 @@
+package paths
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+func TestPathLimiterUnlimited(t *testing.T) {
+	require.Nil(t, newPathLimiter(storiface.LocalStorageMeta{}))
+
+	var l *pathLimiter
+	r := bytes.NewReader([]byte("data"))
+	require.Equal(t, io.Reader(r), l.limitReads(context.Background(), r))
+	require.Equal(t, io.Reader(r), l.limitWrites(context.Background(), r))
+}
+
+func TestPathLimiterTransfersAll(t *testing.T) {
+	ctx := context.Background()
+	l := newPathLimiter(storiface.LocalStorageMeta{
+		MaxReadBandwidth:  1 << 30,
+		MaxWriteBandwidth: 1 << 30,
+		MaxIOPS:           1 << 20,
+	})
+	require.NotNil(t, l)
+
+	data := bytes.Repeat([]byte{0xaa}, 3*maxQoSBurst+17)
+
+	read, err := io.ReadAll(l.limitReads(ctx, bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+
+	var buf bytes.Buffer
+	n, err := l.limitReadsTo(ctx, &buf).Write(data)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	require.Equal(t, data, buf.Bytes())
+}
+
+func TestPathLimiterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	l := newPathLimiter(storiface.LocalStorageMeta{MaxReadBandwidth: 1})
+	_, err := l.limitReads(ctx, bytes.NewReader([]byte("data"))).Read(make([]byte, 4))
+	require.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package paths
+
+import (
+	"io"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// SetReadIODefaults sets the read IO options of paths which don't set ReadIO in their metadata.
+// Paths used for long-term storage get the store options, paths only used for sealing the seal options.
+func (st *Local) SetReadIODefaults(seal, store storiface.PathReadIO) {
+	st.localLk.Lock()
+	defer st.localLk.Unlock()
+
+	st.readIOSeal, st.readIOStore = seal, store
+}
+
+// readIO returns the read IO options of a local path, zero if the path is unknown
+func (st *Local) readIO(id storiface.ID) storiface.PathReadIO {
+	st.localLk.RLock()
+	defer st.localLk.RUnlock()
+
+	p, ok := st.paths[id]
+	if !ok {
+		return storiface.PathReadIO{}
+	}
+	if p.readIO != nil {
+		return *p.readIO
+	}
+	if p.canStore {
+		return st.readIOStore
+	}
+	return st.readIOSeal
+}
+
+// storeReadIO returns the read IO options of a path attached to a local store
+func storeReadIO(s Store, id storiface.ID) storiface.PathReadIO {
+	l, ok := s.(*Local)
+	if !ok {
+		return storiface.PathReadIO{}
+	}
+	return l.readIO(id)
+}
+
+func (r *Remote) readIO(id storiface.ID) storiface.PathReadIO {
+	return storeReadIO(r.local, id)
+}
+
+// closers closes all of the closers, returning the first error
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var err error
+	for _, c := range cs {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
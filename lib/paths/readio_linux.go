@@ -0,0 +1,153 @@
+package paths
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+const (
+	// directIOAlign is the alignment of offsets, lengths and buffers of O_DIRECT reads, large enough for
+	// both 512 byte and 4KiB logical block sizes
+	directIOAlign = 4096
+
+	directIOBufSize = 1 << 20
+)
+
+var directIOBufs = sync.Pool{
+	New: func() any {
+		b := alignedBuffer(directIOBufSize)
+		return &b
+	},
+}
+
+// openReadIO applies the read IO options of its path to a sector file opened for reading, returning the
+// reader of the file data. The returned closer also closes f.
+func openReadIO(f *os.File, path string, rio storiface.PathReadIO) (io.ReaderAt, io.Closer) {
+	if rio.DirectIO {
+		df, err := os.OpenFile(path, os.O_RDONLY|unix.O_DIRECT, 0)
+		if err == nil {
+			return &directReaderAt{f: df}, closers{df, f}
+		}
+
+		// some filesystems, e.g. tmpfs, don't support O_DIRECT
+		log.Warnw("opening sector file for direct IO, using buffered reads", "path", path, "error", err)
+	}
+
+	switch {
+	case rio.Readahead < 0:
+		if err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_RANDOM); err != nil {
+			log.Warnw("disabling readahead", "path", path, "error", err)
+		}
+	case rio.Readahead > 0:
+		if err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_RANDOM); err != nil {
+			log.Warnw("disabling kernel readahead", "path", path, "error", err)
+			return f, f
+		}
+		return &readaheadReaderAt{f: f, window: rio.Readahead}, f
+	}
+
+	return f, f
+}
+
+// directReaderAt reads a file opened with O_DIRECT through aligned buffers, so that reads can have any
+// offset and length
+type directReaderAt struct {
+	f *os.File
+}
+
+func (d *directReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	bp := directIOBufs.Get().(*[]byte)
+	defer directIOBufs.Put(bp)
+	buf := *bp
+
+	var read int
+	for read < len(p) {
+		pos := off + int64(read)
+		start := pos &^ (directIOAlign - 1)
+		skip := int(pos - start)
+		want := min(len(buf), (skip+len(p)-read+directIOAlign-1)&^(directIOAlign-1))
+
+		n, err := d.f.ReadAt(buf[:want], start)
+		if n > skip {
+			read += copy(p[read:], buf[skip:n])
+		}
+		if err != nil {
+			if err == io.EOF && read == len(p) {
+				return read, nil
+			}
+			return read, err
+		}
+	}
+
+	return read, nil
+}
+
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+directIOAlign)
+	o := int(uintptr(unsafe.Pointer(&b[0])) & (directIOAlign - 1))
+	if o != 0 {
+		o = directIOAlign - o
+	}
+	return b[o : o+size]
+}
+
+// readaheadReaderAt replaces kernel readahead with hints to read the given window following each read,
+// issued when reads get close to the end of the previous window or leave it
+type readaheadReaderAt struct {
+	f      *os.File
+	window int64
+
+	lk   sync.Mutex
+	next int64 // end of the last hinted window
+}
+
+func (r *readaheadReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+
+	r.lk.Lock()
+	if end+r.window/2 > r.next || end < r.next-2*r.window {
+		_ = unix.Fadvise(int(r.f.Fd()), end, r.window, unix.FADV_WILLNEED)
+		r.next = end + r.window
+	}
+	r.lk.Unlock()
+
+	return r.f.ReadAt(p, off)
+}
+
+// dropPageCache evicts a file, or the files in a directory, from the page cache
+func dropPageCache(path string) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	if st.IsDir() {
+		ents, err := os.ReadDir(path)
+		if err != nil {
+			return
+		}
+		for _, ent := range ents {
+			if ent.Type().IsRegular() {
+				dropPageCache(filepath.Join(path, ent.Name()))
+			}
+		}
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close() // nolint:errcheck
+
+	if err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED); err != nil {
+		log.Debugw("dropping file from page cache", "path", path, "error", err)
+	}
+}
@@ -0,0 +1,58 @@
+package paths
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+func TestReadIO(t *testing.T) {
+	data := make([]byte, 3*directIOBufSize+1234)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+
+	file := filepath.Join(t.TempDir(), "s-t01000-1")
+	require.NoError(t, os.WriteFile(file, data, 0644))
+
+	reads := [][2]int64{
+		{0, 1},
+		{100, 5000},
+		{4095, 2},
+		{directIOBufSize - 10, 2*directIOBufSize + 20},
+		{int64(len(data)) - 100, 100},
+	}
+
+	for _, rio := range []storiface.PathReadIO{{DirectIO: true}, {Readahead: 1 << 20}, {Readahead: -1}} {
+		f, err := os.Open(file)
+		require.NoError(t, err)
+
+		r, closer := openReadIO(f, file, rio)
+		if _, ok := r.(*directReaderAt); rio.DirectIO && !ok {
+			_ = closer.Close()
+			t.Log("filesystem doesn't support direct IO")
+			continue
+		}
+
+		for _, rd := range reads {
+			buf := make([]byte, rd[1])
+			n, err := r.ReadAt(buf, rd[0])
+			require.NoError(t, err)
+			require.Equal(t, int(rd[1]), n)
+			require.Equal(t, data[rd[0]:rd[0]+rd[1]], buf)
+		}
+
+		// reads past the end of the file are short
+		buf := make([]byte, 200)
+		n, err := r.ReadAt(buf, int64(len(data))-100)
+		require.ErrorIs(t, err, io.EOF)
+		require.Equal(t, 100, n)
+		require.Equal(t, data[len(data)-100:], buf[:n])
+
+		require.NoError(t, closer.Close())
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package paths
+
+import (
+	"io"
+	"os"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+func openReadIO(f *os.File, path string, rio storiface.PathReadIO) (io.ReaderAt, io.Closer) {
+	return f, f
+}
+
+func dropPageCache(path string) {}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/filecoin-project/go-state-types/abi"
 
+	"github.com/filecoin-project/curio/lib/cryptfile"
 	"github.com/filecoin-project/curio/lib/partialfile"
 	storiface "github.com/filecoin-project/curio/lib/storiface"
 
@@ -176,7 +177,14 @@ func (r *Remote) AcquireSector(ctx context.Context, s storiface.SectorRef, exist
 		// If any path types weren't found in local storage, try fetching them
 
 		// First reserve storage
-		releaseStorage, err := r.local.Reserve(ctx, s, toFetch, fetchIDs, overheadTable, MinFreeStoragePercentage)
+		linfo, _ := ctx.Value(reservationLeaseCtxKey{}).(ReservationLeaseInfo)
+		if linfo.Purpose == "" {
+			linfo.Purpose = "fetch"
+		} else {
+			linfo.Purpose += " (fetch)"
+		}
+
+		releaseStorage, err := r.local.Reserve(WithReservationLease(ctx, linfo), s, toFetch, fetchIDs, overheadTable, MinFreeStoragePercentage)
 		if err != nil {
 			return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.Errorf("reserving storage space: %w", err)
 		}
@@ -192,7 +200,7 @@ func (r *Remote) AcquireSector(ctx context.Context, s storiface.SectorRef, exist
 		dest := storiface.PathByType(fetchPaths, fileType)
 		storageID := storiface.PathByType(fetchIDs, fileType)
 
-		url, err := r.acquireFromRemote(ctx, s.ID, fileType, dest)
+		url, err := r.acquireFromRemote(ctx, s.ID, fileType, dest, storiface.ID(storageID))
 		if err != nil {
 			return storiface.SectorPaths{}, storiface.SectorPaths{}, err
 		}
@@ -228,7 +236,7 @@ func tempFetchDest(spath string, create bool) (string, error) {
 	return filepath.Join(tempdir, b), nil
 }
 
-func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType storiface.SectorFileType, dest string) (string, error) {
+func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType storiface.SectorFileType, dest string, into storiface.ID) (string, error) {
 	si, err := r.index.StorageFindSector(ctx, s, fileType, 0, false)
 	if err != nil {
 		return "", err
@@ -241,6 +249,13 @@ func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType
 	sort.Slice(si, func(i, j int) bool {
 		return si[i].Weight < si[j].Weight
 	})
+	healthyFirst(si)
+
+	// unsealed files fetched into paths which encrypt them are encrypted as they are written
+	key, err := storeEncryptKey(r.local, into, fileType)
+	if err != nil {
+		return "", err
+	}
 
 	var merr error
 	for _, info := range si {
@@ -256,9 +271,13 @@ func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType
 				return "", xerrors.Errorf("removing dest: %w", err)
 			}
 
-			err = r.fetchThrottled(ctx, url, tempDest)
+			err = r.fetchThrottled(ctx, url, tempDest, r.limiter(into), key)
 			if err != nil {
 				merr = multierror.Append(merr, xerrors.Errorf("fetch error %s (storage %s) -> %s: %w", url, info.ID, tempDest, err))
+				if fetchResumable(tempDest) {
+					// keep the fetched ranges, the next url or acquire call resumes the fetch
+					continue
+				}
 				// fetching failed, remove temp file
 				if rerr := os.RemoveAll(tempDest); rerr != nil {
 					merr = multierror.Append(merr, xerrors.Errorf("removing temp dest (post-err cleanup): %w", rerr))
@@ -280,7 +299,7 @@ func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType
 	return "", xerrors.Errorf("failed to acquire sector %v from remote (tried %v): %w", s, si, merr)
 }
 
-func (r *Remote) fetchThrottled(ctx context.Context, url, outname string) (rerr error) {
+func (r *Remote) fetchThrottled(ctx context.Context, url, outname string, lim *pathLimiter, key *cryptfile.Key) (rerr error) {
 	if len(r.limit) >= cap(r.limit) {
 		log.Infof("Throttling fetch, %d already running", len(r.limit))
 	}
@@ -296,7 +315,7 @@ func (r *Remote) fetchThrottled(ctx context.Context, url, outname string) (rerr
 		return xerrors.Errorf("context error while waiting for fetch limiter: %w", ctx.Err())
 	}
 
-	return fetch(ctx, url, outname, r.auth)
+	return fetch(ctx, url, outname, r.auth, lim, key)
 }
 
 func (r *Remote) checkAllocated(ctx context.Context, url string, spt abi.RegisteredSealProof, offset, size abi.PaddedPieceSize) (bool, error) {
@@ -350,6 +369,9 @@ func (r *Remote) Remove(ctx context.Context, sid abi.SectorID, typ storiface.Sec
 
 storeLoop:
 	for _, info := range si {
+		if info.Archive {
+			continue
+		}
 		for _, id := range keepIn {
 			if id == info.ID {
 				continue storeLoop
@@ -533,7 +555,7 @@ func (r *Remote) CheckIsUnsealed(ctx context.Context, s storiface.SectorRef, off
 		}
 
 		// open the unsealed sector file for the given sector size located at the given path.
-		pf, err := r.pfHandler.OpenPartialFile(abi.PaddedPieceSize(ssize), path)
+		pf, err := openUnsealed(r.pfHandler, storeKeyring(r.local), storiface.PathReadIO{}, abi.PaddedPieceSize(ssize), path)
 		if err != nil {
 			return false, xerrors.Errorf("opening partial file: %w", err)
 		}
@@ -574,6 +596,7 @@ func (r *Remote) CheckIsUnsealed(ctx context.Context, s storiface.SectorRef, off
 	sort.Slice(si, func(i, j int) bool {
 		return si[i].Weight < si[j].Weight
 	})
+	healthyFirst(si)
 
 	for _, info := range si {
 		for _, url := range info.URLs {
@@ -606,7 +629,7 @@ func (r *Remote) Reader(ctx context.Context, s storiface.SectorRef, offset, size
 	ft := storiface.FTUnsealed
 
 	// check if we have the unsealed sector file locally
-	paths, _, err := r.local.AcquireSector(ctx, s, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
+	paths, stores, err := r.local.AcquireSector(ctx, s, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
 	if err != nil {
 		return nil, xerrors.Errorf("acquire local: %w", err)
 	}
@@ -623,8 +646,10 @@ func (r *Remote) Reader(ctx context.Context, s storiface.SectorRef, offset, size
 		}
 		log.Debugf("fetched sector size %s (+%d,%d)", path, offset, size)
 
+		rio := r.readIO(storiface.ID(storiface.PathByType(stores, ft)))
+
 		// open the unsealed sector file for the given sector size located at the given path.
-		pf, err := r.pfHandler.OpenPartialFile(abi.PaddedPieceSize(ssize), path)
+		pf, err := openUnsealed(r.pfHandler, storeKeyring(r.local), rio, abi.PaddedPieceSize(ssize), path)
 		if err != nil {
 			return nil, xerrors.Errorf("opening partial file: %w", err)
 		}
@@ -675,7 +700,7 @@ func (r *Remote) Reader(ctx context.Context, s storiface.SectorRef, offset, size
 					// got closed in the meantime, reopen
 
 					var err error
-					pf, err = r.pfHandler.OpenPartialFile(abi.PaddedPieceSize(ssize), path)
+					pf, err = openUnsealed(r.pfHandler, storeKeyring(r.local), rio, abi.PaddedPieceSize(ssize), path)
 					if err != nil {
 						return nil, nil, xerrors.Errorf("reopening partial file: %w", err)
 					}
@@ -701,16 +726,17 @@ func (r *Remote) Reader(ctx context.Context, s storiface.SectorRef, offset, size
 					return nil, xerrors.Errorf("getting partialfile handle: %w", err)
 				}
 
-				r, err := r.pfHandler.Reader(pf, storiface.PaddedByteIndex(offset)+startOffsetAligned, abi.PaddedPieceSize(endOffsetAligned-startOffsetAligned))
+				rd, err := r.pfHandler.Reader(pf, storiface.PaddedByteIndex(offset)+startOffsetAligned, abi.PaddedPieceSize(endOffsetAligned-startOffsetAligned))
 				if err != nil {
 					return nil, err
 				}
+				storeNoteUnsealedAccess(r.local, s.ID)
 
 				return struct {
 					io.Reader
 					io.Closer
 				}{
-					Reader: r,
+					Reader: r.limiter(storiface.ID(storiface.PathByType(stores, ft))).limitReads(ctx, rd),
 					Closer: funcCloser(done),
 				}, nil
 			}, nil
@@ -741,6 +767,7 @@ func (r *Remote) Reader(ctx context.Context, s storiface.SectorRef, offset, size
 	sort.Slice(si, func(i, j int) bool {
 		return si[i].Weight > si[j].Weight
 	})
+	healthyFirst(si)
 
 	var lastErr error
 	for _, info := range si {
@@ -780,14 +807,39 @@ func (r *Remote) Reader(ctx context.Context, s storiface.SectorRef, offset, size
 // ReaderSeq creates a simple sequential reader for a file. Does not work for
 // file types which are a directory (e.g. FTCache).
 func (r *Remote) ReaderSeq(ctx context.Context, s storiface.SectorRef, ft storiface.SectorFileType) (io.ReadCloser, error) {
-	paths, _, err := r.local.AcquireSector(ctx, s, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
+	return r.ReaderSeqRange(ctx, s, ft, 0, 0)
+}
+
+// ReaderSeqRange creates a simple sequential reader for size bytes of a file starting at offset, or for the
+// rest of the file when size is 0. Does not work for file types which are a directory (e.g. FTCache).
+func (r *Remote) ReaderSeqRange(ctx context.Context, s storiface.SectorRef, ft storiface.SectorFileType, offset storiface.PaddedByteIndex, size abi.PaddedPieceSize) (io.ReadCloser, error) {
+	paths, stores, err := r.local.AcquireSector(ctx, s, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
 	if err != nil {
 		return nil, xerrors.Errorf("acquire local: %w", err)
 	}
 
 	path := storiface.PathByType(paths, ft)
 	if path != "" {
-		return os.Open(path)
+		f, err := openDecrypted(storeKeyring(r.local), r.readIO(storiface.ID(storiface.PathByType(stores, ft))), path)
+		if err != nil {
+			return nil, err
+		}
+		if ft == storiface.FTUnsealed {
+			storeNoteUnsealedAccess(r.local, s.ID)
+		}
+
+		n := f.size - int64(offset)
+		if size != 0 && int64(size) < n {
+			n = int64(size)
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: r.limiter(storiface.ID(storiface.PathByType(stores, ft))).limitReads(ctx, io.NewSectionReader(f, int64(offset), n)),
+			Closer: f,
+		}, nil
 	}
 
 	si, err := r.index.StorageFindSector(ctx, s.ID, ft, 0, false)
@@ -803,10 +855,11 @@ func (r *Remote) ReaderSeq(ctx context.Context, s storiface.SectorRef, ft storif
 	sort.Slice(si, func(i, j int) bool {
 		return si[i].Weight > si[j].Weight
 	})
+	healthyFirst(si)
 
 	for _, info := range si {
 		for _, url := range info.URLs {
-			rd, err := r.readRemote(ctx, url, 0, 0)
+			rd, err := r.readRemote(ctx, url, abi.PaddedPieceSize(offset), size)
 			if err != nil {
 				log.Warnw("reading from remote", "url", url, "error", err)
 				continue
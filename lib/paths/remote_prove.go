@@ -45,6 +45,7 @@ func (r *Remote) ReadMinCacheInto(ctx context.Context, s storiface.SectorRef, ft
 	sort.Slice(si, func(i, j int) bool {
 		return si[i].Weight > si[j].Weight
 	})
+	healthyFirst(si)
 
 	for _, info := range si {
 		for _, u := range info.URLs {
@@ -108,6 +109,8 @@ func (r *Remote) GenerateSingleVanillaProof(ctx context.Context, minerID abi.Act
 		return nil, xerrors.Errorf("finding sector %d failed: %w", sid, err)
 	}
 
+	healthyFirst(si)
+
 	requestParams := SingleVanillaParams{
 		Miner:     minerID,
 		Sector:    sinfo,
@@ -200,6 +203,8 @@ func (r *Remote) GeneratePoRepVanillaProof(ctx context.Context, sr storiface.Sec
 		return nil, xerrors.Errorf("finding sector %d failed: %w", sr.ID, err)
 	}
 
+	healthyFirst(si)
+
 	// Prepare request parameters
 	requestParams := PoRepVanillaParams{
 		Sector:   sr,
@@ -290,6 +295,8 @@ func (r *Remote) ReadSnapVanillaProof(ctx context.Context, sr storiface.SectorRe
 		return nil, xerrors.Errorf("finding sector %d failed: %w", sr.ID, err)
 	}
 
+	healthyFirst(si)
+
 	// Prepare request parameters
 	requestParams := SnapVanillaParams{
 		Sector: sr,
@@ -0,0 +1,160 @@
+package paths
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// RemovalRequest describes a sector file about to be removed from a local storage path
+type RemovalRequest struct {
+	SpID         abi.ActorID
+	SectorNumber abi.SectorNumber
+	FileType     string
+	StorageID    storiface.ID
+	Path         string
+
+	// LastCopy is set when the storage index has no other copy of the file
+	LastCopy bool
+}
+
+// RemovalHook is called before a sector file is removed from a local storage path, e.g. to snapshot or back up
+// the data. The file is only removed once the hook returns nil.
+type RemovalHook func(ctx context.Context, req RemovalRequest) error
+
+// SetRemovalHook sets the hook called before sector and piece files are removed from local paths. Unless allCopies
+// is set the hook is only called for the last indexed copy of a file, so that dropping duplicates, e.g. after
+// fetching a sector into long-term storage, isn't blocked by it. Nil removes the hook.
+func (st *Local) SetRemovalHook(h RemovalHook, allCopies bool) {
+	st.localLk.Lock()
+	defer st.localLk.Unlock()
+
+	st.removalHook, st.removalHookAllCopies = h, allCopies
+}
+
+func (st *Local) callRemovalHook(ctx context.Context, sid abi.SectorID, typ storiface.SectorFileType, storage storiface.ID, spath string) error {
+	st.localLk.RLock()
+	hook, allCopies := st.removalHook, st.removalHookAllCopies
+	st.localLk.RUnlock()
+
+	if hook == nil {
+		return nil
+	}
+
+	si, err := st.index.StorageFindSector(ctx, sid, typ, 0, false)
+	if err != nil {
+		return xerrors.Errorf("finding other copies of sector %d(t:%d): %w", sid, typ, err)
+	}
+	lastCopy := true
+	for _, info := range si {
+		if info.ID != storage {
+			lastCopy = false
+			break
+		}
+	}
+
+	if !lastCopy && !allCopies {
+		return nil
+	}
+
+	req := RemovalRequest{
+		SpID:         sid.Miner,
+		SectorNumber: sid.Number,
+		FileType:     typ.String(),
+		StorageID:    storage,
+		Path:         spath,
+		LastCopy:     lastCopy,
+	}
+
+	log.Infow("calling removal hook", "id", sid, "type", typ, "storage", storage, "lastCopy", lastCopy)
+	if err := hook(ctx, req); err != nil {
+		return xerrors.Errorf("removal hook rejected removing sector %d(t:%d) from %s: %w", sid, typ, storage, err)
+	}
+
+	return nil
+}
+
+// CommandRemovalHook returns a hook running a shell command, with the details of the removed file in CURIO_REMOVE_*
+// environment variables. The removal is confirmed by the command exiting with status 0.
+func CommandRemovalHook(command string, timeout time.Duration) RemovalHook {
+	return func(ctx context.Context, req RemovalRequest) error {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = append(os.Environ(),
+			fmt.Sprintf("CURIO_REMOVE_SP_ID=%d", req.SpID),
+			fmt.Sprintf("CURIO_REMOVE_SECTOR_NUM=%d", req.SectorNumber),
+			"CURIO_REMOVE_FILE_TYPE="+req.FileType,
+			"CURIO_REMOVE_STORAGE_ID="+string(req.StorageID),
+			"CURIO_REMOVE_PATH="+req.Path,
+			fmt.Sprintf("CURIO_REMOVE_LAST_COPY=%t", req.LastCopy),
+		)
+		// don't wait for children of a killed command holding the output open
+		cmd.WaitDelay = time.Second
+
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return xerrors.Errorf("running removal hook command: %w; output: %s", err, truncateOutput(out))
+		}
+		return nil
+	}
+}
+
+// HTTPRemovalHook returns a hook POSTing the removal request as JSON to the URL. The removal is confirmed by a
+// 2xx response.
+func HTTPRemovalHook(url string, timeout time.Duration) RemovalHook {
+	return func(ctx context.Context, req RemovalRequest) error {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return xerrors.Errorf("marshaling removal request: %w", err)
+		}
+
+		hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return xerrors.Errorf("creating removal hook request: %w", err)
+		}
+		hreq.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(hreq)
+		if err != nil {
+			return xerrors.Errorf("calling removal hook: %w", err)
+		}
+		defer resp.Body.Close() // nolint
+
+		if resp.StatusCode/100 != 2 {
+			out, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return xerrors.Errorf("removal hook returned status %d: %s", resp.StatusCode, truncateOutput(out))
+		}
+		return nil
+	}
+}
+
+func truncateOutput(out []byte) string {
+	const maxOutput = 1024
+	if len(out) > maxOutput {
+		out = out[len(out)-maxOutput:]
+	}
+	return string(bytes.TrimSpace(out))
+}
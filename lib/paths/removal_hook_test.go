@@ -0,0 +1,61 @@
+package paths
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandRemovalHook(t *testing.T) {
+	ctx := context.Background()
+	out := filepath.Join(t.TempDir(), "out")
+
+	req := RemovalRequest{SpID: 1000, SectorNumber: 5, FileType: "sealed", StorageID: "st", Path: "/data/sealed/s-t01000-5", LastCopy: true}
+
+	hook := CommandRemovalHook(`echo "$CURIO_REMOVE_SP_ID $CURIO_REMOVE_SECTOR_NUM $CURIO_REMOVE_FILE_TYPE $CURIO_REMOVE_STORAGE_ID $CURIO_REMOVE_PATH $CURIO_REMOVE_LAST_COPY" > `+out, time.Minute)
+	require.NoError(t, hook(ctx, req))
+
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "1000 5 sealed st /data/sealed/s-t01000-5 true\n", string(b))
+
+	hook = CommandRemovalHook("echo no backup space; exit 1", time.Minute)
+	err = hook(ctx, req)
+	require.ErrorContains(t, err, "no backup space")
+
+	hook = CommandRemovalHook("sleep 10", 100*time.Millisecond)
+	require.Error(t, hook(ctx, req))
+}
+
+func TestHTTPRemovalHook(t *testing.T) {
+	ctx := context.Background()
+
+	var got RemovalRequest
+	reject := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		if reject {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte("snapshot failed"))
+		}
+	}))
+	defer srv.Close()
+
+	req := RemovalRequest{SpID: 1000, SectorNumber: 5, FileType: "unsealed", StorageID: "st", Path: "/data/unsealed/s-t01000-5"}
+
+	hook := HTTPRemovalHook(srv.URL, time.Minute)
+	require.NoError(t, hook(ctx, req))
+	require.Equal(t, req, got)
+
+	reject = true
+	err := hook(ctx, req)
+	require.ErrorContains(t, err, "409")
+	require.ErrorContains(t, err, "snapshot failed")
+}
@@ -0,0 +1,334 @@
+package paths
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// ReservationLeaseTTL is how long a storage reservation lease stays valid without being renewed.
+// Leases are renewed by the holding node every third of the TTL, so leases of nodes which went
+// away expire after at most this long.
+var ReservationLeaseTTL = 10 * time.Minute
+
+// MaxUnownedReservationAge is how long a reservation which isn't owned by a harmony task can be
+// held before it is considered leaked and released
+var MaxUnownedReservationAge = 24 * time.Hour
+
+type reservationLeaseCtxKey struct{}
+
+// ReservationLeaseInfo describes who a storage reservation is held for
+type ReservationLeaseInfo struct {
+	// TaskID is the harmony task owning the reservation. When set, the reservation is
+	// released as soon as the task no longer exists.
+	TaskID *int64
+
+	// Purpose is a short human-readable description of what the space is reserved for
+	Purpose string
+}
+
+// WithReservationLease attaches lease information to reservations made with the returned context
+func WithReservationLease(ctx context.Context, info ReservationLeaseInfo) context.Context {
+	return context.WithValue(ctx, reservationLeaseCtxKey{}, info)
+}
+
+func reservationLeaseFromCtx(ctx context.Context) ReservationLeaseInfo {
+	info, ok := ctx.Value(reservationLeaseCtxKey{}).(ReservationLeaseInfo)
+	if !ok || info.Purpose == "" {
+		info.Purpose = "unknown"
+	}
+	return info
+}
+
+// reservationEntry is space reserved for a single file type in a single path
+type reservationEntry struct {
+	id    storiface.ID
+	ft    storiface.SectorFileType
+	bytes int64
+
+	// exclusive entries are in SharedFS paths, only one node can hold a lease on the file
+	exclusive bool
+}
+
+// errFileLeaseHeld is returned when an exclusive lease on a file in a SharedFS path is held by someone else
+var errFileLeaseHeld = xerrors.New("file lease held")
+
+// reservationLeaseIndex is implemented by indexes which persist reservation leases
+type reservationLeaseIndex interface {
+	createReservationLeases(ctx context.Context, holder string, sector abi.SectorID, entries []reservationEntry, info ReservationLeaseInfo, ttl time.Duration) ([]int64, error)
+	renewReservationLeases(ctx context.Context, leaseIDs []int64, ttl time.Duration) error
+	releaseReservationLeases(ctx context.Context, leaseIDs []int64) error
+	deadReservationOwners(ctx context.Context, taskIDs []int64) ([]int64, error)
+	expireReservationLeases(ctx context.Context) (int, error)
+}
+
+type reservationLease struct {
+	sector  abi.SectorID
+	entries []reservationEntry
+	info    ReservationLeaseInfo
+	created time.Time
+
+	dbIDs   []int64
+	release func()
+}
+
+func (dbi *DBIndex) createReservationLeases(ctx context.Context, holder string, sector abi.SectorID, entries []reservationEntry, info ReservationLeaseInfo, ttl time.Duration) ([]int64, error) {
+	ids := make([]int64, 0, len(entries))
+	expire := time.Now().Add(ttl)
+
+	for _, e := range entries {
+		id, err := dbi.createReservationLease(ctx, holder, sector, e, info, expire)
+		if err != nil {
+			if len(ids) > 0 {
+				_ = dbi.releaseReservationLeases(ctx, ids)
+			}
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (dbi *DBIndex) createReservationLease(ctx context.Context, holder string, sector abi.SectorID, e reservationEntry, info ReservationLeaseInfo, expire time.Time) (int64, error) {
+	if e.exclusive {
+		// leases of nodes which went away don't block the file until the next cleanup
+		_, err := dbi.harmonyDB.Exec(ctx, `DELETE FROM storage_reservations
+			WHERE storage_id = $1 AND sp_id = $2 AND sector_number = $3 AND file_type = $4 AND exclusive AND expire_time < current_timestamp`,
+			e.id, sector.Miner, sector.Number, e.ft)
+		if err != nil {
+			return 0, xerrors.Errorf("deleting expired exclusive lease: %w", err)
+		}
+	}
+
+	var id int64
+	err := dbi.harmonyDB.QueryRow(ctx, `INSERT INTO storage_reservations (storage_id, holder, sp_id, sector_number, file_type, reserved_bytes, owner_task_id, purpose, expire_time, exclusive)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING lease_id`,
+		e.id, holder, sector.Miner, sector.Number, e.ft, e.bytes, info.TaskID, info.Purpose, expire, e.exclusive).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !e.exclusive || !harmonydb.IsErrUniqueContraint(err) {
+		return 0, xerrors.Errorf("inserting reservation lease: %w", err)
+	}
+
+	var otherHolder, otherPurpose string
+	err = dbi.harmonyDB.QueryRow(ctx, `SELECT holder, purpose FROM storage_reservations
+		WHERE storage_id = $1 AND sp_id = $2 AND sector_number = $3 AND file_type = $4 AND exclusive`,
+		e.id, sector.Miner, sector.Number, e.ft).Scan(&otherHolder, &otherPurpose)
+	if err != nil {
+		return 0, xerrors.Errorf("sector %v %s in shared path %s: %w", sector, e.ft, e.id, errFileLeaseHeld)
+	}
+	return 0, xerrors.Errorf("sector %v %s in shared path %s is leased by %s for %s: %w", sector, e.ft, e.id, otherHolder, otherPurpose, errFileLeaseHeld)
+}
+
+func (dbi *DBIndex) renewReservationLeases(ctx context.Context, leaseIDs []int64, ttl time.Duration) error {
+	_, err := dbi.harmonyDB.Exec(ctx, `UPDATE storage_reservations SET expire_time = $2 WHERE lease_id = ANY($1)`, leaseIDs, time.Now().Add(ttl))
+	if err != nil {
+		return xerrors.Errorf("renewing reservation leases: %w", err)
+	}
+	return nil
+}
+
+func (dbi *DBIndex) releaseReservationLeases(ctx context.Context, leaseIDs []int64) error {
+	_, err := dbi.harmonyDB.Exec(ctx, `DELETE FROM storage_reservations WHERE lease_id = ANY($1)`, leaseIDs)
+	if err != nil {
+		return xerrors.Errorf("deleting reservation leases: %w", err)
+	}
+	return nil
+}
+
+// deadReservationOwners returns the task IDs which don't exist anymore
+func (dbi *DBIndex) deadReservationOwners(ctx context.Context, taskIDs []int64) ([]int64, error) {
+	var dead []int64
+	err := dbi.harmonyDB.Select(ctx, &dead, `SELECT t.id FROM UNNEST($1::BIGINT[]) AS t(id)
+		WHERE NOT EXISTS (SELECT 1 FROM harmony_task WHERE harmony_task.id = t.id)`, taskIDs)
+	if err != nil {
+		return nil, xerrors.Errorf("checking reservation owner tasks: %w", err)
+	}
+	return dead, nil
+}
+
+// expireReservationLeases removes leases which weren't renewed in time, from any node
+func (dbi *DBIndex) expireReservationLeases(ctx context.Context) (int, error) {
+	n, err := dbi.harmonyDB.Exec(ctx, `DELETE FROM storage_reservations WHERE expire_time < current_timestamp`)
+	if err != nil {
+		return 0, xerrors.Errorf("deleting expired reservation leases: %w", err)
+	}
+	return n, nil
+}
+
+var _ reservationLeaseIndex = &DBIndex{}
+
+// leaseReservation records a reservation made by reserve as a lease, and returns a release
+// function which releases both the reservation and the lease. Failing to record the lease is
+// only an error when an entry is exclusive, in which case the reservation is released.
+func (st *Local) leaseReservation(ctx context.Context, sector abi.SectorID, entries []reservationEntry, release func()) (func(), error) {
+	if len(entries) == 0 {
+		return release, nil
+	}
+
+	var exclusive bool
+	for _, e := range entries {
+		exclusive = exclusive || e.exclusive
+	}
+
+	lease := &reservationLease{
+		sector:  sector,
+		entries: entries,
+		info:    reservationLeaseFromCtx(ctx),
+		created: time.Now(),
+	}
+
+	li, persist := st.index.(reservationLeaseIndex)
+	if persist {
+		ids, err := li.createReservationLeases(ctx, strings.Join(st.urls, ","), sector, entries, lease.info, ReservationLeaseTTL)
+		if err != nil {
+			if exclusive {
+				release()
+				return nil, xerrors.Errorf("leasing files in shared path: %w", err)
+			}
+
+			// the reservation is still tracked locally, it just won't be visible to other nodes
+			log.Errorw("recording storage reservation lease", "sector", sector, "error", err)
+		}
+		lease.dbIDs = ids
+	} else if exclusive {
+		log.Warnw("sector index doesn't support leases, files in shared paths are not protected from concurrent writes", "sector", sector)
+	}
+
+	var once sync.Once
+	lease.release = func() {
+		once.Do(func() {
+			release()
+
+			st.leaseLk.Lock()
+			delete(st.leases, lease)
+			st.leaseLk.Unlock()
+
+			if persist && len(lease.dbIDs) > 0 {
+				if err := li.releaseReservationLeases(context.Background(), lease.dbIDs); err != nil {
+					log.Errorw("releasing storage reservation lease", "sector", sector, "error", err)
+				}
+			}
+		})
+	}
+
+	st.leaseLk.Lock()
+	st.leases[lease] = struct{}{}
+	st.leaseLk.Unlock()
+
+	return lease.release, nil
+}
+
+func (st *Local) leaseLoop(ctx context.Context) {
+	li, ok := st.index.(reservationLeaseIndex)
+	if !ok {
+		return
+	}
+
+	for {
+		select {
+		case <-time.After(ReservationLeaseTTL / 3):
+		case <-ctx.Done():
+			return
+		}
+
+		st.checkLeases(ctx, li)
+	}
+}
+
+func (st *Local) checkLeases(ctx context.Context, li reservationLeaseIndex) {
+	st.leaseLk.Lock()
+	leases := make([]*reservationLease, 0, len(st.leases))
+	for l := range st.leases {
+		leases = append(leases, l)
+	}
+	st.leaseLk.Unlock()
+
+	var owners []int64
+	for _, l := range leases {
+		if l.info.TaskID != nil {
+			owners = append(owners, *l.info.TaskID)
+		}
+	}
+
+	dead := map[int64]bool{}
+	if len(owners) > 0 {
+		d, err := li.deadReservationOwners(ctx, owners)
+		if err != nil {
+			log.Errorw("checking storage reservation owners", "error", err)
+		}
+		for _, id := range d {
+			dead[id] = true
+		}
+	}
+
+	var renew []int64
+	for _, l := range leases {
+		switch {
+		case l.info.TaskID != nil && dead[*l.info.TaskID]:
+			log.Warnw("releasing storage reservation of a task which no longer exists", "sector", l.sector, "task", *l.info.TaskID, "purpose", l.info.Purpose)
+			l.release()
+		case l.info.TaskID == nil && time.Since(l.created) > MaxUnownedReservationAge:
+			log.Warnw("releasing leaked storage reservation", "sector", l.sector, "purpose", l.info.Purpose, "age", time.Since(l.created).Round(time.Second))
+			l.release()
+		default:
+			renew = append(renew, l.dbIDs...)
+		}
+	}
+
+	if len(renew) > 0 {
+		if err := li.renewReservationLeases(ctx, renew, ReservationLeaseTTL); err != nil {
+			log.Errorw("renewing storage reservation leases", "error", err)
+		}
+	}
+
+	n, err := li.expireReservationLeases(ctx)
+	if err != nil {
+		log.Errorw("expiring storage reservation leases", "error", err)
+	} else if n > 0 {
+		log.Infow("removed expired storage reservation leases", "count", n)
+	}
+}
+
+// leaseSharedFile takes an exclusive lease on a sector file in a SharedFS path for an operation which doesn't
+// reserve space, like removing the file. Files leased by this node, e.g. by the task doing the removal, can be
+// used without a new lease.
+func (st *Local) leaseSharedFile(ctx context.Context, sector abi.SectorID, ft storiface.SectorFileType, id storiface.ID, purpose string) (func(), error) {
+	ctx = WithReservationLease(ctx, ReservationLeaseInfo{Purpose: purpose})
+	release, err := st.leaseReservation(ctx, sector, []reservationEntry{{id: id, ft: ft, exclusive: true}}, func() {})
+	if err == nil {
+		return release, nil
+	}
+	if !errors.Is(err, errFileLeaseHeld) || !st.holdsLease(sector, ft, id) {
+		return nil, err
+	}
+	return func() {}, nil
+}
+
+func (st *Local) holdsLease(sector abi.SectorID, ft storiface.SectorFileType, id storiface.ID) bool {
+	st.leaseLk.Lock()
+	defer st.leaseLk.Unlock()
+
+	for l := range st.leases {
+		if l.sector != sector {
+			continue
+		}
+		for _, e := range l.entries {
+			if e.id == id && e.ft == ft && e.exclusive {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package paths
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+type testLeaseIndex struct {
+	SectorIndex
+
+	next      int64
+	leases    map[int64]ReservationLeaseInfo
+	exclusive map[int64]reservationEntry
+	renewed   []int64
+	liveTask  map[int64]bool
+}
+
+func (ti *testLeaseIndex) createReservationLeases(ctx context.Context, holder string, sector abi.SectorID, entries []reservationEntry, info ReservationLeaseInfo, ttl time.Duration) ([]int64, error) {
+	for _, e := range entries {
+		for id, x := range ti.exclusive {
+			if _, ok := ti.leases[id]; ok && e.exclusive && x.id == e.id && x.ft == e.ft {
+				return nil, errFileLeaseHeld
+			}
+		}
+	}
+
+	var ids []int64
+	for _, e := range entries {
+		ti.next++
+		ti.leases[ti.next] = info
+		if e.exclusive {
+			ti.exclusive[ti.next] = e
+		}
+		ids = append(ids, ti.next)
+	}
+	return ids, nil
+}
+
+func (ti *testLeaseIndex) renewReservationLeases(ctx context.Context, leaseIDs []int64, ttl time.Duration) error {
+	ti.renewed = append(ti.renewed, leaseIDs...)
+	return nil
+}
+
+func (ti *testLeaseIndex) releaseReservationLeases(ctx context.Context, leaseIDs []int64) error {
+	for _, id := range leaseIDs {
+		delete(ti.leases, id)
+	}
+	return nil
+}
+
+func (ti *testLeaseIndex) deadReservationOwners(ctx context.Context, taskIDs []int64) ([]int64, error) {
+	var dead []int64
+	for _, id := range taskIDs {
+		if !ti.liveTask[id] {
+			dead = append(dead, id)
+		}
+	}
+	return dead, nil
+}
+
+func (ti *testLeaseIndex) expireReservationLeases(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func TestReservationLeases(t *testing.T) {
+	ti := &testLeaseIndex{leases: map[int64]ReservationLeaseInfo{}, exclusive: map[int64]reservationEntry{}, liveTask: map[int64]bool{1: true}}
+	st := &Local{index: ti, leases: map[*reservationLease]struct{}{}}
+
+	entries := []reservationEntry{{id: "a", ft: storiface.FTSealed, bytes: 10}, {id: "a", ft: storiface.FTCache, bytes: 5}}
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+
+	var released []string
+	lease := func(task *int64, name string) func() {
+		ctx := WithReservationLease(context.Background(), ReservationLeaseInfo{TaskID: task, Purpose: name})
+		release, err := st.leaseReservation(ctx, sector, entries, func() { released = append(released, name) })
+		require.NoError(t, err)
+		return release
+	}
+
+	live, dead := int64(1), int64(2)
+	liveRelease := lease(&live, "live")
+	lease(&dead, "dead")
+	lease(nil, "unowned")
+	require.Len(t, ti.leases, 6)
+
+	st.checkLeases(context.Background(), ti)
+	require.Equal(t, []string{"dead"}, released)
+	require.Len(t, ti.leases, 4)
+	require.Len(t, ti.renewed, 4)
+
+	// releasing twice only releases the reservation once
+	liveRelease()
+	liveRelease()
+	require.Equal(t, []string{"dead", "live"}, released)
+	require.Len(t, ti.leases, 2)
+
+	defer func(age time.Duration) { MaxUnownedReservationAge = age }(MaxUnownedReservationAge)
+	MaxUnownedReservationAge = 0
+
+	st.checkLeases(context.Background(), ti)
+	require.Equal(t, []string{"dead", "live", "unowned"}, released)
+	require.Empty(t, ti.leases)
+	require.Empty(t, st.leases)
+}
+
+func TestExclusiveReservationLeases(t *testing.T) {
+	ti := &testLeaseIndex{leases: map[int64]ReservationLeaseInfo{}, exclusive: map[int64]reservationEntry{}}
+	st := &Local{index: ti, leases: map[*reservationLease]struct{}{}}
+	other := &Local{index: ti, leases: map[*reservationLease]struct{}{}}
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	entries := []reservationEntry{{id: "shared", ft: storiface.FTSealed, bytes: 10, exclusive: true}}
+	ctx := context.Background()
+
+	release, err := st.leaseReservation(ctx, sector, entries, func() {})
+	require.NoError(t, err)
+
+	// another node can't reserve the file, and its local reservation is released
+	var otherReleased bool
+	_, err = other.leaseReservation(ctx, sector, entries, func() { otherReleased = true })
+	require.ErrorIs(t, err, errFileLeaseHeld)
+	require.True(t, otherReleased)
+
+	// removal is allowed on the node holding the lease, but not on others
+	rmRelease, err := st.leaseSharedFile(ctx, sector, storiface.FTSealed, "shared", "remove")
+	require.NoError(t, err)
+	rmRelease()
+	_, err = other.leaseSharedFile(ctx, sector, storiface.FTSealed, "shared", "remove")
+	require.ErrorIs(t, err, errFileLeaseHeld)
+
+	release()
+	rmRelease, err = other.leaseSharedFile(ctx, sector, storiface.FTSealed, "shared", "remove")
+	require.NoError(t, err)
+	rmRelease()
+	require.Empty(t, ti.leases)
+}
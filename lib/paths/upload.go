@@ -0,0 +1,742 @@
+package paths
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/lib/cryptfile"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// Sector uploads move sector files from a machine to a storage path of another node, for machines which can reach
+// the cluster but can't be reached by it, e.g. remote sealing sites on a WAN link. Files are sent in chunks, each
+// checked with a sha256 checksum and written at its offset in a staging file of the receiving node, so interrupted
+// uploads resume from the last complete chunk. Once all files are received the staging files are moved into place and
+// declared in the index.
+
+const (
+	uploadChecksumHeader = "X-Curio-Upload-Sha256"
+	uploadStagingSuffix  = ".upload"
+)
+
+// UploadChunkSize is the default size of upload chunks
+var UploadChunkSize int64 = 256 << 20
+
+// UploadChunkRetries is the number of times a failed chunk is sent again before the upload fails
+var UploadChunkRetries = 3
+
+// ErrUploadDestRejected is returned by UploadSector when the destination path no longer accepts the sector files,
+// e.g. because it is being evacuated. The upload has to be restarted to another destination.
+var ErrUploadDestRejected = xerrors.New("upload destination doesn't accept the sector files")
+
+// UploadStatus lists the files of an upload of a sector file type with their size. Single file types have one file
+// named "", cache directories one file per cache file.
+type UploadStatus struct {
+	Files map[string]int64
+
+	// Encrypted is set when the destination path encrypts unsealed files, they are uploaded encrypted
+	Encrypted bool
+}
+
+type UploadOptions struct {
+	// ChunkSize is the size of upload chunks, UploadChunkSize when 0
+	ChunkSize int64
+
+	// Limiter limits the upload bandwidth, nil doesn't limit it
+	Limiter *rate.Limiter
+
+	// Progress is called with the number of bytes sent as the upload goes on, including chunks which are sent again,
+	// can be nil
+	Progress func(n int64)
+}
+
+// UploadDestination picks the storage path of another node which sector files of the given types are uploaded to.
+func (r *Remote) UploadDestination(ctx context.Context, s storiface.SectorRef, types storiface.SectorFileType) (storiface.ID, error) {
+	ssize, err := s.ProofType.SectorSize()
+	if err != nil {
+		return "", err
+	}
+
+	local := map[storiface.ID]struct{}{}
+	if l, ok := r.local.(*Local); ok {
+		lps, err := l.Local(ctx)
+		if err != nil {
+			return "", xerrors.Errorf("getting local paths: %w", err)
+		}
+		for _, lp := range lps {
+			local[lp.ID] = struct{}{}
+		}
+	}
+
+	cands, err := r.index.StorageBestAlloc(ctx, types, ssize, storiface.PathStorage, s.ID.Miner)
+	if err != nil {
+		return "", xerrors.Errorf("finding upload destination: %w", err)
+	}
+	for _, c := range cands {
+		if _, ok := local[c.ID]; ok || len(c.URLs) == 0 {
+			continue
+		}
+		return c.ID, nil
+	}
+
+	return "", xerrors.Errorf("no storage path of another node can store %s of sector %d", types, s.ID)
+}
+
+// UploadSector uploads sector files of the given types from local storage to the dest storage path of another node,
+// resuming an earlier upload to dest. Once the files are declared in dest the local copies are removed.
+func (r *Remote) UploadSector(ctx context.Context, s storiface.SectorRef, types storiface.SectorFileType, dest storiface.ID, opts UploadOptions) error {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = UploadChunkSize
+	}
+
+	src, _, err := r.local.AcquireSector(ctx, s, types, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
+	if err != nil {
+		return xerrors.Errorf("acquire local sector: %w", err)
+	}
+
+	si, err := r.index.StorageInfo(ctx, dest)
+	if err != nil {
+		return xerrors.Errorf("getting destination storage info: %w", err)
+	}
+	if si.Archive || si.ReadOnly {
+		return xerrors.Errorf("%w: path %s is read-only", ErrUploadDestRejected, dest)
+	}
+	for _, ft := range types.AllSet() {
+		if !ft.Allowed(si.AllowTypes, si.DenyTypes) {
+			return xerrors.Errorf("%w: path %s doesn't allow %s files", ErrUploadDestRejected, dest, ft)
+		}
+	}
+
+	for _, ft := range types.AllSet() {
+		p := storiface.PathByType(src, ft)
+		if p == "" {
+			return xerrors.Errorf("sector %d has no local %s", s.ID, ft)
+		}
+
+		files, err := uploadFiles(p)
+		if err != nil {
+			return err
+		}
+
+		var merr error
+		for _, u := range si.URLs {
+			base := fmt.Sprintf("%s/upload/%s/%s/%s", u, ft, storiface.SectorName(s.ID), dest)
+			if err := r.uploadTo(ctx, base, s.ProofType, p, files, opts); err != nil {
+				log.Warnw("uploading sector file failed", "sector", s.ID, "type", ft, "url", u, "error", err)
+				merr = multierror.Append(merr, err)
+				continue
+			}
+			merr = nil
+			break
+		}
+		if merr != nil {
+			return xerrors.Errorf("uploading %s of sector %d: %w", ft, s.ID, merr)
+		}
+	}
+
+	for _, ft := range types.AllSet() {
+		if err := r.local.Remove(ctx, s.ID, ft, true, []storiface.ID{dest}); err != nil {
+			return xerrors.Errorf("removing uploaded %s of sector %d: %w", ft, s.ID, err)
+		}
+		if ft == storiface.FTUnsealed {
+			if err := removeUploadEncrypted(storiface.PathByType(src, ft)); err != nil {
+				log.Warnw("removing encrypted copy of an uploaded file", "sector", s.ID, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// uploadEncryptedPath returns the path of the encrypted copy of a file uploaded to a path which encrypts it. The
+// copy is kept until the upload is done, so interrupted uploads resume with the same ciphertext.
+func uploadEncryptedPath(p string, create bool) (string, error) {
+	tmp, err := tempFetchDest(p, create)
+	if err != nil {
+		return "", err
+	}
+	return tmp + uploadStagingSuffix + encryptTempSuffix, nil
+}
+
+// encryptForUpload returns the encrypted copy of the file at p, writing it if it doesn't exist yet
+func (r *Remote) encryptForUpload(p string) (string, error) {
+	kr := storeKeyring(r.local)
+	if kr == nil {
+		return "", xerrors.Errorf("the destination encrypts unsealed files, but no Storage.Encryption key is configured")
+	}
+
+	enc, err := uploadEncryptedPath(p, true)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(enc); err == nil {
+		return enc, nil
+	}
+
+	if err := encryptFile(kr.Active(), p, enc+storiface.TempSuffix); err != nil {
+		_ = os.Remove(enc + storiface.TempSuffix)
+		return "", err
+	}
+	if err := os.Rename(enc+storiface.TempSuffix, enc); err != nil {
+		return "", err
+	}
+	return enc, nil
+}
+
+func removeUploadEncrypted(p string) error {
+	enc, err := uploadEncryptedPath(p, false)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(enc); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// uploadFiles lists the files of a sector file or cache directory
+func uploadFiles(p string) (map[string]int64, error) {
+	st, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return map[string]int64{"": st.Size()}, nil
+	}
+
+	ents, err := os.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]int64{}
+	for _, ent := range ents {
+		if ent.IsDir() {
+			return nil, xerrors.Errorf("can't upload %s, %s is a directory", p, ent.Name())
+		}
+		info, err := ent.Info()
+		if err != nil {
+			return nil, err
+		}
+		files[ent.Name()] = info.Size()
+	}
+	return files, nil
+}
+
+func (r *Remote) uploadTo(ctx context.Context, base string, proof abi.RegisteredSealProof, p string, files map[string]int64, opts UploadOptions) error {
+	var have UploadStatus
+	if err := r.uploadRequest(ctx, http.MethodGet, base, nil, nil, &have); err != nil {
+		return xerrors.Errorf("getting upload status: %w", err)
+	}
+
+	if have.Encrypted && !IsEncryptedFile(p) {
+		enc, err := r.encryptForUpload(p)
+		if err != nil {
+			return xerrors.Errorf("encrypting %s for the upload: %w", p, err)
+		}
+		if files, err = uploadFiles(enc); err != nil {
+			return err
+		}
+		p = enc
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		size := files[name]
+		fp := p
+		if name != "" {
+			fp = filepath.Join(p, name)
+		}
+
+		off, ok := have.Files[name]
+		if off > size {
+			off = 0
+		}
+		if ok && off == size {
+			continue
+		}
+
+		// empty files are sent as a single empty chunk
+		for first := true; first || off < size; first = false {
+			n := min(opts.ChunkSize, size-off)
+
+			var err error
+			for try := 0; try <= UploadChunkRetries; try++ {
+				if err = r.uploadChunk(ctx, base, proof, name, fp, off, n, opts); err == nil {
+					break
+				}
+				log.Warnw("uploading chunk failed", "url", base, "file", name, "offset", off, "try", try, "error", err)
+			}
+			if err != nil {
+				return xerrors.Errorf("uploading %s at %d: %w", fp, off, err)
+			}
+
+			off += n
+		}
+	}
+
+	return r.uploadRequest(ctx, http.MethodPost, base+"/done", nil, &UploadStatus{Files: files}, nil)
+}
+
+func (r *Remote) uploadChunk(ctx context.Context, base string, proof abi.RegisteredSealProof, name, fp string, off, n int64, opts UploadOptions) error {
+	f, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, off, n)); err != nil {
+		return xerrors.Errorf("reading chunk: %w", err)
+	}
+
+	var body io.Reader = io.NewSectionReader(f, off, n)
+	if opts.Limiter != nil || opts.Progress != nil {
+		body = &uploadReader{ctx: ctx, r: body, lim: opts.Limiter, progress: opts.Progress}
+	}
+
+	q := url.Values{}
+	q.Set("file", name)
+	q.Set("offset", strconv.FormatInt(off, 10))
+	q.Set("proof", strconv.FormatInt(int64(proof), 10))
+
+	hdr := http.Header{}
+	hdr.Set(uploadChecksumHeader, hex.EncodeToString(h.Sum(nil)))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, base+"?"+q.Encode(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = n
+	return r.doUpload(req, hdr, nil)
+}
+
+func (r *Remote) uploadRequest(ctx context.Context, method, u string, hdr http.Header, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	return r.doUpload(req, hdr, out)
+}
+
+func (r *Remote) doUpload(req *http.Request, hdr http.Header, out any) error {
+	if r.auth != nil {
+		req.Header = r.auth.Clone()
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return xerrors.Errorf("non-200 code: %d: %s", resp.StatusCode, string(msg))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// uploadReader limits the bandwidth of upload data and reports its progress
+type uploadReader struct {
+	ctx      context.Context
+	r        io.Reader
+	lim      *rate.Limiter
+	progress func(n int64)
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	if u.lim != nil {
+		if b := u.lim.Burst(); b > 0 && len(p) > b {
+			p = p[:b]
+		}
+	}
+	n, err := u.r.Read(p)
+	if n > 0 {
+		if u.lim != nil {
+			if werr := u.lim.WaitN(u.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+		if u.progress != nil {
+			u.progress(int64(n))
+		}
+	}
+	return n, err
+}
+
+// uploadKey identifies an upload of a sector file into a local storage path
+type uploadKey struct {
+	storage storiface.ID
+	sectorFile
+}
+
+// uploadAllowed checks that the storage path accepts uploads of the sector file type of the miner. Archive paths,
+// paths being evacuated and paths with restrictions excluding the file don't.
+func (st *Local) uploadAllowed(ctx context.Context, storage storiface.ID, miner abi.ActorID, ft storiface.SectorFileType) error {
+	st.localLk.RLock()
+	p, ok := st.paths[storage]
+	var err error
+	if ok {
+		err = p.allows(ft, miner)
+	}
+	st.localLk.RUnlock()
+	if !ok {
+		return errPathNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	si, err := st.index.StorageInfo(ctx, storage)
+	if err != nil {
+		return xerrors.Errorf("getting storage info: %w", err)
+	}
+	if si.ReadOnly {
+		return xerrors.Errorf("path %s is being evacuated", storage)
+	}
+	if !si.CanStore {
+		return xerrors.Errorf("path %s is not a storage path", storage)
+	}
+	return nil
+}
+
+// reserveUpload checks that the storage path accepts the upload and reserves space for the finalized sector file in
+// it, once per upload. The reservation is held until the upload is moved into place.
+func (st *Local) reserveUpload(ctx context.Context, storage storiface.ID, sref storiface.SectorRef, ft storiface.SectorFileType) error {
+	key := uploadKey{storage: storage, sectorFile: sectorFile{sref.ID, ft}}
+
+	st.uploadLk.Lock()
+	defer st.uploadLk.Unlock()
+
+	if _, ok := st.uploads[key]; ok {
+		return nil
+	}
+
+	if err := st.uploadAllowed(ctx, storage, sref.ID.Miner, ft); err != nil {
+		return err
+	}
+
+	var ids storiface.SectorPaths
+	storiface.SetPathByType(&ids, ft, string(storage))
+
+	// uploads span many requests, the lease isn't tied to any of them
+	lctx := WithReservationLease(context.Background(), ReservationLeaseInfo{Purpose: "sector upload"})
+	release, err := st.Reserve(lctx, sref, ft, ids, storiface.FsOverheadFinalized, MinFreeStoragePercentage)
+	if err != nil {
+		return xerrors.Errorf("reserving space for the upload: %w", err)
+	}
+
+	st.uploads[key] = release
+	return nil
+}
+
+// releaseUpload releases the space reserved for an upload
+func (st *Local) releaseUpload(storage storiface.ID, sid abi.SectorID, ft storiface.SectorFileType) {
+	key := uploadKey{storage: storage, sectorFile: sectorFile{sid, ft}}
+
+	st.uploadLk.Lock()
+	release, ok := st.uploads[key]
+	delete(st.uploads, key)
+	st.uploadLk.Unlock()
+
+	if ok {
+		release()
+	}
+}
+
+// uploadPaths returns the final and staging paths of a sector file uploaded into a local storage path
+func (st *Local) uploadPaths(storage storiface.ID, sid abi.SectorID, ft storiface.SectorFileType) (string, string, error) {
+	st.localLk.RLock()
+	p, ok := st.paths[storage]
+	st.localLk.RUnlock()
+	if !ok {
+		return "", "", errPathNotFound
+	}
+
+	return p.sectorPath(sid, ft),
+		filepath.Join(p.local, ft.String(), FetchTempSubdir, storiface.SectorName(sid)+uploadStagingSuffix), nil
+}
+
+// uploadStatus lists the files received so far of an upload
+func (st *Local) uploadStatus(storage storiface.ID, sid abi.SectorID, ft storiface.SectorFileType) (UploadStatus, error) {
+	out := UploadStatus{Files: map[string]int64{}}
+
+	_, staging, err := st.uploadPaths(storage, sid, ft)
+	if err != nil {
+		return out, err
+	}
+
+	out.Encrypted = ft == storiface.FTUnsealed && st.EncryptsUnsealed(storage)
+
+	files, err := uploadFiles(staging)
+	if os.IsNotExist(err) {
+		return out, nil
+	}
+	if err != nil {
+		return out, err
+	}
+	out.Files = files
+	return out, nil
+}
+
+// writeUploadChunk writes a chunk of a file at offset, dropping data received past offset by an interrupted chunk.
+// The chunk is removed again when it doesn't match its checksum. The first chunk received reserves space for the
+// upload.
+func (st *Local) writeUploadChunk(ctx context.Context, storage storiface.ID, sref storiface.SectorRef, ft storiface.SectorFileType, name string, offset int64, sum []byte, data io.Reader) error {
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return xerrors.Errorf("invalid file name %q", name)
+	}
+
+	if err := st.reserveUpload(ctx, storage, sref, ft); err != nil {
+		return err
+	}
+
+	sid := sref.ID
+	_, staging, err := st.uploadPaths(storage, sid, ft)
+	if err != nil {
+		return err
+	}
+
+	fp := staging
+	if name != "" {
+		if err := os.MkdirAll(staging, 0755); err != nil {
+			return err
+		}
+		fp = filepath.Join(staging, name)
+	} else if err := os.MkdirAll(filepath.Dir(staging), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < offset {
+		return xerrors.Errorf("chunk at %d past the %d bytes received", offset, fi.Size())
+	}
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if offset == 0 && ft == storiface.FTUnsealed && st.EncryptsUnsealed(storage) {
+		br := bufio.NewReader(data)
+		head, _ := br.Peek(cryptfile.HeaderSize)
+		if enc, _ := cryptfile.IsEncrypted(bytes.NewReader(head)); !enc {
+			return xerrors.Errorf("path %s encrypts unsealed files, they have to be uploaded encrypted", storage)
+		}
+		data = br
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), data); err != nil {
+		_ = f.Truncate(offset)
+		return xerrors.Errorf("receiving chunk: %w", err)
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		_ = f.Truncate(offset)
+		return xerrors.Errorf("chunk at %d doesn't match its checksum", offset)
+	}
+
+	return f.Sync()
+}
+
+// finishUpload moves a complete upload into place and declares it as a primary copy
+func (st *Local) finishUpload(ctx context.Context, storage storiface.ID, sid abi.SectorID, ft storiface.SectorFileType, files map[string]int64) error {
+	final, staging, err := st.uploadPaths(storage, sid, ft)
+	if err != nil {
+		return err
+	}
+
+	if err := st.uploadAllowed(ctx, storage, sid.Miner, ft); err != nil {
+		return err
+	}
+
+	have, err := uploadFiles(staging)
+	switch {
+	case os.IsNotExist(err):
+		// a retried request of an upload which was already moved into place
+		if _, err := os.Stat(final); err != nil {
+			return xerrors.Errorf("no upload of %s of sector %d: %w", ft, sid, err)
+		}
+	case err != nil:
+		return err
+	default:
+		if len(have) != len(files) {
+			return xerrors.Errorf("upload has %d files, expected %d", len(have), len(files))
+		}
+		for name, size := range files {
+			if have[name] != size {
+				return xerrors.Errorf("upload of %q has %d bytes, expected %d", name, have[name], size)
+			}
+		}
+
+		if _, err := os.Stat(final); err == nil {
+			return xerrors.Errorf("%s already exists", final)
+		}
+		if err := os.Rename(staging, final); err != nil {
+			return xerrors.Errorf("moving upload into place: %w", err)
+		}
+	}
+
+	if err := st.index.StorageDeclareSector(ctx, storage, sid, ft, true); err != nil {
+		return xerrors.Errorf("declaring uploaded sector: %w", err)
+	}
+
+	st.releaseUpload(storage, sid, ft)
+	st.reportStorage(ctx)
+	return nil
+}
+
+func uploadVars(r *http.Request) (storiface.ID, abi.SectorID, storiface.SectorFileType, error) {
+	vars := mux.Vars(r)
+
+	id, err := storiface.ParseSectorID(vars["id"])
+	if err != nil {
+		return "", abi.SectorID{}, 0, err
+	}
+	ft, err := FileTypeFromString(vars["type"])
+	if err != nil {
+		return "", abi.SectorID{}, 0, err
+	}
+	return storiface.ID(vars["storage"]), id, ft, nil
+}
+
+func (handler *FetchHandler) uploadStore(w http.ResponseWriter) (*Local, bool) {
+	l, ok := handler.Local.(*Local)
+	if !ok {
+		http.Error(w, "uploads not supported", http.StatusNotImplemented)
+	}
+	return l, ok
+}
+
+func (handler *FetchHandler) remoteUploadStatus(w http.ResponseWriter, r *http.Request) {
+	l, ok := handler.uploadStore(w)
+	if !ok {
+		return
+	}
+	storage, id, ft, err := uploadVars(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	st, err := l.uploadStatus(storage, id, ft)
+	if err != nil {
+		log.Errorw("getting upload status", "sector", id, "type", ft, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&st); err != nil {
+		log.Warnf("error writing upload status response: %+v", err)
+	}
+}
+
+func (handler *FetchHandler) remoteUploadChunk(w http.ResponseWriter, r *http.Request) {
+	l, ok := handler.uploadStore(w)
+	if !ok {
+		return
+	}
+	storage, id, ft, err := uploadVars(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("parsing offset: %s", err), http.StatusBadRequest)
+		return
+	}
+	proof, err := strconv.ParseInt(r.URL.Query().Get("proof"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("parsing proof type: %s", err), http.StatusBadRequest)
+		return
+	}
+	sum, err := hex.DecodeString(r.Header.Get(uploadChecksumHeader))
+	if err != nil || len(sum) != sha256.Size {
+		http.Error(w, "missing or invalid chunk checksum", http.StatusBadRequest)
+		return
+	}
+
+	sref := storiface.SectorRef{ID: id, ProofType: abi.RegisteredSealProof(proof)}
+	lim := storeLimiter(handler.Local, storage)
+	if err := l.writeUploadChunk(r.Context(), storage, sref, ft, r.URL.Query().Get("file"), offset, sum, lim.limitWrites(r.Context(), r.Body)); err != nil {
+		log.Warnw("receiving upload chunk", "sector", id, "type", ft, "offset", offset, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (handler *FetchHandler) remoteUploadDone(w http.ResponseWriter, r *http.Request) {
+	l, ok := handler.uploadStore(w)
+	if !ok {
+		return
+	}
+	storage, id, ft, err := uploadVars(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var expect UploadStatus
+	if err := json.NewDecoder(r.Body).Decode(&expect); err != nil {
+		http.Error(w, fmt.Sprintf("decoding upload files: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := l.finishUpload(r.Context(), storage, id, ft, expect.Files); err != nil {
+		log.Errorw("finishing upload", "sector", id, "type", ft, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Infow("sector upload received", "sector", id, "type", ft, "storage", storage)
+}
@@ -2,6 +2,7 @@ package paths
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -29,6 +30,15 @@ func Move(from, to string) error {
 
 	log.Debugw("move sector data", "from", from, "to", to)
 
+	method, err := moveFast(from, to)
+	switch {
+	case err == nil:
+		log.Debugw("moved sector data", "from", from, "to", to, "method", method)
+		return nil
+	case !errors.Is(err, errNoFastMove):
+		return xerrors.Errorf("move (%s): %w", method, err)
+	}
+
 	toDir := filepath.Dir(to)
 
 	// `mv` has decades of experience in moving files quickly; don't pretend we
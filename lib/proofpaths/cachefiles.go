@@ -33,6 +33,12 @@ func IsTreeFile(baseName string) bool {
 	return IsFileTreeD(baseName) || IsFileTreeRLast(baseName) || IsFileTreeC(baseName)
 }
 
+func IsLayerFile(baseName string) bool {
+	// sc-02-data-layer-<int>.dat
+	reg := fmt.Sprintf(`^%slayer-\d+\.dat$`, dataFilePrefix)
+	return regexp.MustCompile(reg).MatchString(baseName)
+}
+
 func LayerFileName(layer int) string {
 	return fmt.Sprintf("%slayer-%d.dat", dataFilePrefix, layer)
 }
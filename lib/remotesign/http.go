@@ -0,0 +1,140 @@
+package remotesign
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/curio/deps/config"
+
+	lapi "github.com/filecoin-project/lotus/api"
+)
+
+// SignRequest is POSTed as JSON to <URL>/sign of a signing service.
+type SignRequest struct {
+	Address string
+	// Digest is the data to sign, the CID bytes of the message
+	Digest []byte
+	// Message is the serialized message, so that the service can check what it signs
+	Message []byte
+}
+
+// SignResponse is the JSON response of <URL>/sign.
+type SignResponse struct {
+	Type crypto.SigType
+	Data []byte
+}
+
+// HasResponse is the JSON response of GET <URL>/has?address=<address>.
+type HasResponse struct {
+	Has bool
+}
+
+// httpSigner signs with a signing service over HTTPS, authenticating with a client certificate.
+type httpSigner struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSigner(rc config.RemoteSignerConfig) (*httpSigner, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if rc.ClientCert != "" || rc.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(rc.ClientCert, rc.ClientKey)
+		if err != nil {
+			return nil, xerrors.Errorf("loading client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if rc.CACert != "" {
+		pem, err := os.ReadFile(rc.CACert)
+		if err != nil {
+			return nil, xerrors.Errorf("reading CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, xerrors.Errorf("no certificates in %s", rc.CACert)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	return &httpSigner{
+		url: strings.TrimSuffix(rc.URL, "/"),
+		client: &http.Client{
+			Timeout:   time.Minute,
+			Transport: &http.Transport{TLSClientConfig: tlsCfg},
+		},
+	}, nil
+}
+
+func (h *httpSigner) WalletHas(ctx context.Context, addr address.Address) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url+"/has?address="+url.QueryEscape(addr.String()), nil)
+	if err != nil {
+		return false, xerrors.Errorf("creating request: %w", err)
+	}
+
+	var resp HasResponse
+	if err := h.do(req, &resp); err != nil {
+		return false, err
+	}
+	return resp.Has, nil
+}
+
+func (h *httpSigner) WalletSign(ctx context.Context, addr address.Address, data []byte, meta lapi.MsgMeta) (*crypto.Signature, error) {
+	body, err := json.Marshal(SignRequest{
+		Address: addr.String(),
+		Digest:  data,
+		Message: meta.Extra,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("marshaling sign request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url+"/sign", bytes.NewReader(body))
+	if err != nil {
+		return nil, xerrors.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp SignResponse
+	if err := h.do(req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, xerrors.Errorf("signing service returned an empty signature")
+	}
+
+	return &crypto.Signature{Type: resp.Type, Data: resp.Data}, nil
+}
+
+func (h *httpSigner) do(req *http.Request, out any) error {
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("calling signing service: %w", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return xerrors.Errorf("signing service returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return xerrors.Errorf("decoding signing service response: %w", err)
+	}
+	return nil
+}
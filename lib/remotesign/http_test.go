@@ -0,0 +1,55 @@
+package remotesign
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/curio/deps/config"
+
+	lapi "github.com/filecoin-project/lotus/api"
+)
+
+func TestHTTPSigner(t *testing.T) {
+	ctx := context.Background()
+
+	owner, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	var got SignRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/has":
+			_ = json.NewEncoder(w).Encode(HasResponse{Has: r.URL.Query().Get("address") == owner.String()})
+		case "/sign":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+			_ = json.NewEncoder(w).Encode(SignResponse{Type: crypto.SigTypeSecp256k1, Data: []byte("sig")})
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	hs, err := newHTTPSigner(config.RemoteSignerConfig{URL: srv.URL + "/"})
+	require.NoError(t, err)
+
+	has, err := hs.WalletHas(ctx, owner)
+	require.NoError(t, err)
+	require.True(t, has)
+
+	sig, err := hs.WalletSign(ctx, owner, []byte("digest"), lapi.MsgMeta{Type: lapi.MTChainMsg, Extra: []byte("msg")})
+	require.NoError(t, err)
+	require.Equal(t, crypto.SigTypeSecp256k1, sig.Type)
+	require.Equal(t, []byte("sig"), sig.Data)
+	require.Equal(t, SignRequest{Address: owner.String(), Digest: []byte("digest"), Message: []byte("msg")}, got)
+
+	_, err = newHTTPSigner(config.RemoteSignerConfig{URL: srv.URL, ClientCert: "missing.pem", ClientKey: "missing.key"})
+	require.Error(t, err)
+}
@@ -0,0 +1,164 @@
+// Package remotesign signs messages of selected addresses with remote wallets, so that their keys don't need to
+// be held by the chain node. Remote wallets speak the lotus-wallet API, which also fronts Ledger devices
+// ('lotus-wallet run --ledger' on the machine the Ledger is connected to), or are signing services reached over
+// HTTPS with a client certificate.
+package remotesign
+
+import (
+	"context"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/curio/deps/config"
+
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/client"
+	"github.com/filecoin-project/lotus/chain/types"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+)
+
+var log = logging.Logger("remotesign")
+
+type SignerAPI interface {
+	WalletSignMessage(context.Context, address.Address, *types.Message) (*types.SignedMessage, error)
+}
+
+type KeyAPI interface {
+	StateAccountKey(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+}
+
+// remoteWallet is the part of the lotus-wallet API used for remote signing.
+type remoteWallet interface {
+	WalletHas(context.Context, address.Address) (bool, error)
+	WalletSign(context.Context, address.Address, []byte, lapi.MsgMeta) (*crypto.Signature, error)
+}
+
+// Signer signs messages from addresses with a remote wallet configured with that wallet, and all other messages
+// with the fallback signer.
+type Signer struct {
+	remote   map[address.Address]remoteWallet
+	fallback SignerAPI
+}
+
+// New connects to the configured remote wallets. Configured addresses are resolved to key addresses, and must be
+// present in their wallet.
+func New(ctx context.Context, keys KeyAPI, fallback SignerAPI, cfg []config.RemoteSignerConfig) (*Signer, func(), error) {
+	s := &Signer{
+		remote:   map[address.Address]remoteWallet{},
+		fallback: fallback,
+	}
+
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	for _, rc := range cfg {
+		var (
+			wallet remoteWallet
+			addr   string
+		)
+		switch {
+		case rc.URL != "" && rc.ApiInfo != "":
+			closeAll()
+			return nil, nil, xerrors.Errorf("remote signer can't have both ApiInfo and URL set")
+		case rc.URL != "":
+			hs, err := newHTTPSigner(rc)
+			if err != nil {
+				closeAll()
+				return nil, nil, xerrors.Errorf("remote signer %s: %w", rc.URL, err)
+			}
+			wallet, addr = hs, rc.URL
+		default:
+			ainfo := cliutil.ParseApiInfo(rc.ApiInfo)
+			var err error
+			addr, err = ainfo.DialArgs("v0")
+			if err != nil {
+				closeAll()
+				return nil, nil, xerrors.Errorf("remote signer api info: %w", err)
+			}
+
+			w, closer, err := client.NewWalletRPCV0(ctx, addr, ainfo.AuthHeader())
+			if err != nil {
+				closeAll()
+				return nil, nil, xerrors.Errorf("connecting to remote signer %s: %w", addr, err)
+			}
+			closers = append(closers, closer)
+			wallet = w
+		}
+
+		for _, as := range rc.Addresses {
+			a, err := address.NewFromString(as)
+			if err != nil {
+				closeAll()
+				return nil, nil, xerrors.Errorf("parsing remote signer address %s: %w", as, err)
+			}
+			ka, err := keys.StateAccountKey(ctx, a, types.EmptyTSK)
+			if err != nil {
+				closeAll()
+				return nil, nil, xerrors.Errorf("getting key address of %s: %w", a, err)
+			}
+
+			has, err := wallet.WalletHas(ctx, ka)
+			if err != nil {
+				closeAll()
+				return nil, nil, xerrors.Errorf("checking remote signer %s for %s: %w", addr, ka, err)
+			}
+			if !has {
+				closeAll()
+				return nil, nil, xerrors.Errorf("remote signer %s doesn't have key %s (%s)", addr, ka, a)
+			}
+
+			if _, ok := s.remote[ka]; ok {
+				closeAll()
+				return nil, nil, xerrors.Errorf("address %s is configured for more than one remote signer", ka)
+			}
+			s.remote[ka] = wallet
+
+			log.Infow("using remote signer", "address", a, "key", ka, "signer", addr)
+		}
+	}
+
+	return s, closeAll, nil
+}
+
+func (s *Signer) WalletSignMessage(ctx context.Context, from address.Address, msg *types.Message) (*types.SignedMessage, error) {
+	wallet, ok := s.remote[from]
+	if !ok {
+		if s.fallback == nil {
+			return nil, xerrors.Errorf("no signer for %s", from)
+		}
+		return s.fallback.WalletSignMessage(ctx, from, msg)
+	}
+
+	mb, err := msg.ToStorageBlock()
+	if err != nil {
+		return nil, xerrors.Errorf("serializing message: %w", err)
+	}
+
+	// the message bytes are passed along so that the signer (e.g. a Ledger) can show what is being signed
+	sig, err := wallet.WalletSign(ctx, from, mb.Cid().Bytes(), lapi.MsgMeta{
+		Type:  lapi.MTChainMsg,
+		Extra: mb.RawData(),
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("remote signing message from %s: %w", from, err)
+	}
+
+	return &types.SignedMessage{
+		Message:   *msg,
+		Signature: *sig,
+	}, nil
+}
+
+// Has returns whether messages from the key address are signed remotely.
+func (s *Signer) Has(from address.Address) bool {
+	_, ok := s.remote[from]
+	return ok
+}
@@ -0,0 +1,70 @@
+package remotesign
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type testWallet struct {
+	lapi.Wallet
+
+	signed []lapi.MsgMeta
+}
+
+func (w *testWallet) WalletSign(ctx context.Context, a address.Address, b []byte, meta lapi.MsgMeta) (*crypto.Signature, error) {
+	w.signed = append(w.signed, meta)
+	return &crypto.Signature{Type: crypto.SigTypeSecp256k1, Data: []byte("remote")}, nil
+}
+
+type testFallback struct {
+	signed []address.Address
+}
+
+func (f *testFallback) WalletSignMessage(ctx context.Context, a address.Address, msg *types.Message) (*types.SignedMessage, error) {
+	f.signed = append(f.signed, a)
+	return &types.SignedMessage{Message: *msg, Signature: crypto.Signature{Type: crypto.SigTypeSecp256k1, Data: []byte("local")}}, nil
+}
+
+func TestSignerRouting(t *testing.T) {
+	ctx := context.Background()
+
+	owner, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	worker, err := address.NewIDAddress(1002)
+	require.NoError(t, err)
+
+	w := &testWallet{}
+	f := &testFallback{}
+	s := &Signer{
+		remote:   map[address.Address]remoteWallet{owner: w},
+		fallback: f,
+	}
+
+	msg := &types.Message{From: owner, To: worker, Value: big.NewInt(1), GasFeeCap: big.Zero(), GasPremium: big.Zero()}
+	sm, err := s.WalletSignMessage(ctx, owner, msg)
+	require.NoError(t, err)
+	require.Equal(t, []byte("remote"), sm.Signature.Data)
+	require.Len(t, w.signed, 1)
+	require.Equal(t, lapi.MsgType(lapi.MTChainMsg), w.signed[0].Type)
+
+	mb, err := msg.ToStorageBlock()
+	require.NoError(t, err)
+	require.Equal(t, mb.RawData(), w.signed[0].Extra)
+
+	msg.From = worker
+	sm, err = s.WalletSignMessage(ctx, worker, msg)
+	require.NoError(t, err)
+	require.Equal(t, []byte("local"), sm.Signature.Data)
+	require.Equal(t, []address.Address{worker}, f.signed)
+	require.True(t, s.Has(owner))
+	require.False(t, s.Has(worker))
+}
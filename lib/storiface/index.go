@@ -94,6 +94,15 @@ type StorageInfo struct {
 	// DenyMiners lists miner IDs which are denied to store their sector data into
 	// this path
 	DenyMiners []string
+
+	// Tier is the storage tier label of the path, empty when the path isn't tiered
+	Tier string
+
+	// Archive is set for read-only archive paths, which are never written to
+	Archive bool
+
+	// ReadOnly is set while the path is evacuated, no new sector files are placed in it
+	ReadOnly bool
 }
 
 type HealthReport struct {
@@ -112,6 +121,12 @@ type SectorStorageInfo struct {
 
 	Primary bool
 
+	// Degraded is set when health probes of the path are failing or slow
+	Degraded bool
+
+	// Archive is set for read-only archive paths, copies in them are never removed
+	Archive bool
+
 	AllowTypes  []string
 	DenyTypes   []string
 	AllowMiners []string
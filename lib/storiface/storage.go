@@ -6,7 +6,9 @@ import (
 	"net/http"
 
 	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/proof"
 )
@@ -129,6 +131,7 @@ type LocalStorageMeta struct {
 	// - "cache"
 	// - "update"
 	// - "update-cache"
+	// - "piece"
 	// Any other value will generate a warning and be ignored.
 	AllowTypes []string
 
@@ -141,6 +144,7 @@ type LocalStorageMeta struct {
 	// - "cache"
 	// - "update"
 	// - "update-cache"
+	// - "piece"
 	// Any other value will generate a warning and be ignored.
 	DenyTypes []string
 
@@ -151,4 +155,129 @@ type LocalStorageMeta struct {
 	// DenyMiners lists miner IDs which are denied to store their sector data into
 	// this path
 	DenyMiners []string
+
+	// Tier labels the storage tier of this path, e.g. "hot" or "cold". Sealed sectors are
+	// migrated between tiers according to the storage tiering policy. Empty means the path
+	// doesn't take part in tiering.
+	Tier string
+
+	// MaxReadBandwidth and MaxWriteBandwidth cap the bytes per second read from and
+	// written to this path by sector fetches, moves, and readers serving unsealed
+	// data. PoSt challenge reads are not limited. 0 = unlimited
+	MaxReadBandwidth  uint64
+	MaxWriteBandwidth uint64
+
+	// MaxIOPS caps the read and write operations per second done on this path by
+	// the same IO as the bandwidth limits. 0 = unlimited
+	MaxIOPS uint64
+
+	// EncryptUnsealed encrypts unsealed sector files stored in this path at rest
+	// with the key from the Storage.Encryption config. Files are encrypted as they
+	// are written, and decrypted transparently when read. Sealing uses unsealed
+	// files directly, so sealing paths can't set it.
+	EncryptUnsealed bool
+
+	// SharedFS marks a path on a filesystem mounted by multiple machines, e.g. NFS or CephFS.
+	// Sector files in the path are only written and removed under cluster-wide leases held
+	// in the database, so that machines sharing the path never touch the same files at once.
+	SharedFS bool
+
+	// ReadIO tunes how sector files in this path are read, overriding the Storage.ReadIO
+	// defaults for the class of the path. Nil uses the defaults.
+	ReadIO *PathReadIO
+
+	// Archive marks a read-only archive path, e.g. a tape gateway or a snapshotted volume.
+	// Sector files in the path are read for proving and retrievals, but nothing is ever
+	// allocated in, moved out of, or removed from it, including by garbage collection.
+	Archive bool
+}
+
+// PathReadIO are the IO options for reading sector files from a storage path, used by readers
+// of unsealed data, sector files served to other nodes and vanilla proof generation. Only
+// supported on Linux.
+type PathReadIO struct {
+	// DirectIO reads sector files with O_DIRECT, bypassing the page cache. Challenge reads done
+	// by the proofs library can't bypass it, so the pages they read are evicted after each
+	// vanilla proof instead.
+	DirectIO bool
+
+	// Readahead is the number of bytes read ahead of reads of sector files. 0 keeps the
+	// kernel default, -1 disables readahead. Ignored with DirectIO.
+	Readahead int64
+}
+
+// ContentKinds are named sets of sector file types, for restricting a path to one kind of content
+var ContentKinds = map[string]SectorFileType{
+	"unsealed": FTUnsealed,
+	"sealed":   FTSealed | FTCache,
+	"update":   FTUpdate | FTUpdateCache,
+	"piece":    FTPiece,
+}
+
+// ContentKindTypes returns the names of the sector file types of the content kinds, for use in AllowTypes
+func ContentKindTypes(kinds []string) ([]string, error) {
+	var ft SectorFileType
+	for _, k := range kinds {
+		kt, ok := ContentKinds[k]
+		if !ok {
+			return nil, xerrors.Errorf("unknown content kind %q", k)
+		}
+		ft |= kt
+	}
+	return ft.Strings(), nil
+}
+
+// PathRestrictions are the parts of LocalStorageMeta which limit what is placed in a storage path. Nil fields
+// are left unchanged when the restrictions of a path are updated, empty lists clear the restriction.
+type PathRestrictions struct {
+	// CanSeal and CanStore select scratch space for sealing, long-term storage, or both
+	CanSeal  *bool
+	CanStore *bool
+
+	AllowTypes  []string
+	DenyTypes   []string
+	AllowMiners []string
+	DenyMiners  []string
+}
+
+// Apply updates the storage metadata with the set restrictions, and checks that the result is valid
+func (r PathRestrictions) Apply(meta *LocalStorageMeta) error {
+	if r.CanSeal != nil {
+		meta.CanSeal = *r.CanSeal
+	}
+	if r.CanStore != nil {
+		meta.CanStore = *r.CanStore
+	}
+	if r.AllowTypes != nil {
+		meta.AllowTypes = r.AllowTypes
+	}
+	if r.DenyTypes != nil {
+		meta.DenyTypes = r.DenyTypes
+	}
+	if r.AllowMiners != nil {
+		meta.AllowMiners = r.AllowMiners
+	}
+	if r.DenyMiners != nil {
+		meta.DenyMiners = r.DenyMiners
+	}
+
+	if !(meta.CanSeal || meta.CanStore) {
+		return xerrors.Errorf("path must be usable for sealing or long-term storage")
+	}
+	for _, t := range append(append([]string{}, meta.AllowTypes...), meta.DenyTypes...) {
+		if _, err := TypeFromString(t); err != nil {
+			return err
+		}
+	}
+	for _, m := range append(append([]string{}, meta.AllowMiners...), meta.DenyMiners...) {
+		maddr, err := address.NewFromString(m)
+		if err != nil {
+			return xerrors.Errorf("parsing miner address %q: %w", m, err)
+		}
+		if _, err := address.IDFromAddress(maddr); err != nil {
+			return xerrors.Errorf("miner address %q is not an ID address: %w", m, err)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,305 @@
+// Package clientlimit enforces the per-client ingest limits of Ingest.ClientLimits.
+package clientlimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/docker/go-units"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/yugabyte/pgx/v5"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var log = logging.Logger("clientlimit")
+
+// TokenPrefix prefixes the names of API tokens used as client identities
+const TokenPrefix = "token:"
+
+type LimitAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+}
+
+type rule struct {
+	all    bool
+	addrs  []address.Address
+	tokens []string
+
+	maxTransfers   int
+	maxBytesPerDay int64
+	maxQueued      int
+}
+
+// Limiter counts deals of clients in market_client_ingest and rejects deals over the limits of their client
+type Limiter struct {
+	db    *harmonydb.DB
+	api   LimitAPI
+	rules []rule
+
+	// ID addresses of rule clients, resolved when first needed
+	idLk sync.Mutex
+	ids  map[address.Address]address.Address
+}
+
+func New(db *harmonydb.DB, api LimitAPI, cfg []config.ClientIngestLimit) (*Limiter, error) {
+	l := &Limiter{
+		db:  db,
+		api: api,
+		ids: map[address.Address]address.Address{},
+	}
+
+	for i, c := range cfg {
+		r := rule{
+			all:          len(c.Clients) == 0,
+			maxTransfers: c.MaxConcurrentTransfers,
+			maxQueued:    c.MaxQueuedDeals,
+		}
+		for _, s := range c.Clients {
+			if strings.HasPrefix(s, TokenPrefix) {
+				r.tokens = append(r.tokens, s)
+				continue
+			}
+			a, err := address.NewFromString(s)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing client %s of ClientLimits rule %d: %w", s, i, err)
+			}
+			r.addrs = append(r.addrs, a)
+		}
+		if c.MaxBytesPerDay != "" {
+			b, err := units.RAMInBytes(c.MaxBytesPerDay)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing MaxBytesPerDay of ClientLimits rule %d: %w", i, err)
+			}
+			r.maxBytesPerDay = b
+		}
+		l.rules = append(l.rules, r)
+	}
+
+	return l, nil
+}
+
+// Deal is a deal counted against the limits of its client
+type Deal struct {
+	// Client is the ID address of the deal client, or TokenPrefix and the name of an API token
+	Client string
+
+	// SpID is 0 for pieces which aren't assigned to a miner yet
+	SpID      int64
+	PieceCID  string
+	PieceSize abi.PaddedPieceSize
+	RawSize   int64
+
+	// StartEpoch is 0 for pieces without a start epoch
+	StartEpoch abi.ChainEpoch
+
+	// Transfer is set when the deal data is received by the caller, counting against concurrent transfers until
+	// Transferred is called
+	Transfer bool
+}
+
+// Begin counts a deal against the limits of its client. A deal over a limit isn't counted and is rejected with the
+// reason. The returned ID is 0 for deals of clients without limits.
+func (l *Limiter) Begin(ctx context.Context, d Deal) (id int64, rejected string, err error) {
+	if d.Client == "" {
+		return 0, "", nil
+	}
+	r, err := l.rule(ctx, d.Client)
+	if err != nil {
+		return 0, "", err
+	}
+	if r == nil {
+		return 0, "", nil
+	}
+
+	head, err := l.api.ChainHead(ctx)
+	if err != nil {
+		return 0, "", xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	var spID *int64
+	if d.SpID != 0 {
+		spID = &d.SpID
+	}
+	var startEpoch *int64
+	if d.StartEpoch != 0 {
+		se := int64(d.StartEpoch)
+		startEpoch = &se
+	}
+
+	_, err = l.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		// deals of the client counted concurrently wait here until this one is counted or rejected, otherwise both
+		// could see the usage without the other and go over the limit together
+		_, err = tx.Exec(`INSERT INTO market_client_ingest_locks (client) VALUES ($1)
+			ON CONFLICT (client) DO UPDATE SET client = EXCLUDED.client`, d.Client)
+		if err != nil {
+			return false, xerrors.Errorf("locking client: %w", err)
+		}
+
+		_, err = tx.Exec(`DELETE FROM market_client_ingest WHERE create_time < NOW() - INTERVAL '30 days'`)
+		if err != nil {
+			return false, xerrors.Errorf("removing old client ingest records: %w", err)
+		}
+
+		var usage Usage
+		err = tx.QueryRow(usageQuery+` WHERE i.client = $2 GROUP BY i.client`, int64(head.Height()), d.Client).Scan(&usage.Client, &usage.Transfers, &usage.BytesDay, &usage.Queued)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return false, xerrors.Errorf("getting client usage: %w", err)
+		}
+
+		switch {
+		case d.Transfer && r.maxTransfers > 0 && usage.Transfers >= int64(r.maxTransfers):
+			rejected = fmt.Sprintf("client %s has %d transfers running, the limit is %d", d.Client, usage.Transfers, r.maxTransfers)
+		case r.maxBytesPerDay > 0 && usage.BytesDay+d.RawSize > r.maxBytesPerDay:
+			rejected = fmt.Sprintf("client %s sent %s over the last day, the limit is %s", d.Client,
+				units.BytesSize(float64(usage.BytesDay)), units.BytesSize(float64(r.maxBytesPerDay)))
+		case r.maxQueued > 0 && usage.Queued >= int64(r.maxQueued):
+			rejected = fmt.Sprintf("client %s has %d deals queued, the limit is %d", d.Client, usage.Queued, r.maxQueued)
+		}
+		if rejected != "" {
+			return false, nil
+		}
+
+		err = tx.QueryRow(`INSERT INTO market_client_ingest (client, sp_id, piece_cid, piece_size, raw_size, start_epoch, transferred, transfer_end)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, CASE WHEN $7 THEN CURRENT_TIMESTAMP END) RETURNING id`,
+			d.Client, spID, d.PieceCID, int64(d.PieceSize), d.RawSize, startEpoch, !d.Transfer).Scan(&id)
+		if err != nil {
+			return false, xerrors.Errorf("recording client ingest: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return 0, "", err
+	}
+
+	if rejected != "" {
+		log.Infow("deal rejected by client limits", "client", d.Client, "piece_cid", d.PieceCID, "reason", rejected)
+	}
+	return id, rejected, nil
+}
+
+// Transferred marks the data of a deal as received
+func (l *Limiter) Transferred(ctx context.Context, id int64) {
+	if id == 0 {
+		return
+	}
+	_, err := l.db.Exec(ctx, `UPDATE market_client_ingest SET transferred = TRUE, transfer_end = CURRENT_TIMESTAMP
+		WHERE id = $1 AND NOT transferred`, id)
+	if err != nil {
+		log.Errorw("marking client ingest transferred", "id", id, "error", err)
+	}
+}
+
+// Failed stops counting a deal which wasn't added to a sector. Data which was received still counts against the
+// daily limit.
+func (l *Limiter) Failed(ctx context.Context, id int64) {
+	if id == 0 {
+		return
+	}
+	_, err := l.db.Exec(ctx, `UPDATE market_client_ingest SET failed = TRUE, transfer_end = COALESCE(transfer_end, CURRENT_TIMESTAMP)
+		WHERE id = $1`, id)
+	if err != nil {
+		log.Errorw("marking client ingest failed", "id", id, "error", err)
+	}
+}
+
+func (l *Limiter) rule(ctx context.Context, client string) (*rule, error) {
+	var clientAddr address.Address
+	if !strings.HasPrefix(client, TokenPrefix) {
+		var err error
+		clientAddr, err = address.NewFromString(client)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing client address: %w", err)
+		}
+	}
+
+	for i := range l.rules {
+		r := &l.rules[i]
+		if r.all {
+			return r, nil
+		}
+		if clientAddr == address.Undef {
+			for _, t := range r.tokens {
+				if t == client {
+					return r, nil
+				}
+			}
+			continue
+		}
+		for _, a := range r.addrs {
+			id, err := l.lookupID(ctx, a)
+			if err != nil {
+				return nil, err
+			}
+			if id == clientAddr {
+				return r, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (l *Limiter) lookupID(ctx context.Context, a address.Address) (address.Address, error) {
+	if a.Protocol() == address.ID {
+		return a, nil
+	}
+
+	l.idLk.Lock()
+	id, ok := l.ids[a]
+	l.idLk.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := l.api.StateLookupID(ctx, a, types.EmptyTSK)
+	if err != nil {
+		// clients in the config which aren't on chain yet can't have deals
+		log.Debugw("resolving ClientLimits client", "client", a, "error", err)
+		return address.Undef, nil
+	}
+
+	l.idLk.Lock()
+	l.ids[a] = id
+	l.idLk.Unlock()
+	return id, nil
+}
+
+// Usage is what a client is counted for
+type Usage struct {
+	Client    string `db:"client"`
+	Transfers int64  `db:"transfers"`
+	BytesDay  int64  `db:"bytes_day"`
+	Queued    int64  `db:"queued"`
+}
+
+// usageQuery counts the running transfers, the data received over the last day and the queued deals of clients.
+// Transfers not done within two days are taken as abandoned. Deals stop being queued when their piece is in a sector
+// of the miner, or they can't start anymore.
+const usageQuery = `SELECT i.client,
+		COUNT(*) FILTER (WHERE NOT i.transferred AND NOT i.failed AND i.create_time > NOW() - INTERVAL '2 days') AS transfers,
+		COALESCE(SUM(i.raw_size) FILTER (WHERE i.create_time > NOW() - INTERVAL '1 day' AND (i.transferred OR NOT i.failed)), 0) AS bytes_day,
+		COUNT(*) FILTER (WHERE NOT i.failed AND (i.start_epoch IS NULL OR i.start_epoch > $1)
+			AND NOT EXISTS (SELECT 1 FROM sectors_meta_pieces p
+				WHERE p.piece_cid = i.piece_cid AND (i.sp_id IS NULL OR p.sp_id = i.sp_id))) AS queued
+	FROM market_client_ingest i`
+
+// ClientUsage returns the usage of all clients with deals counted over the last 30 days
+func ClientUsage(ctx context.Context, db *harmonydb.DB, epoch abi.ChainEpoch) ([]Usage, error) {
+	var out []Usage
+	err := db.Select(ctx, &out, usageQuery+` GROUP BY i.client ORDER BY i.client`, int64(epoch))
+	if err != nil {
+		return nil, xerrors.Errorf("getting client usage: %w", err)
+	}
+	return out, nil
+}
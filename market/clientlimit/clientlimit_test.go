@@ -0,0 +1,111 @@
+package clientlimit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/deps/config"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type testAPI struct {
+	ids map[address.Address]address.Address
+}
+
+// ChainHead is only used when deals are counted, which needs the database
+func (a *testAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	return nil, xerrors.New("no chain")
+}
+
+func (a *testAPI) StateLookupID(_ context.Context, addr address.Address, _ types.TipSetKey) (address.Address, error) {
+	id, ok := a.ids[addr]
+	if !ok {
+		return address.Undef, xerrors.Errorf("actor %s not found", addr)
+	}
+	return id, nil
+}
+
+func mustAddr(t *testing.T, s string) address.Address {
+	a, err := address.NewFromString(s)
+	require.NoError(t, err)
+	return a
+}
+
+func TestNewRules(t *testing.T) {
+	l, err := New(nil, nil, []config.ClientIngestLimit{
+		{Clients: []string{"f01234", "token:bot"}, MaxConcurrentTransfers: 2, MaxBytesPerDay: "1KiB"},
+		{MaxQueuedDeals: 5},
+	})
+	require.NoError(t, err)
+	require.Len(t, l.rules, 2)
+
+	require.False(t, l.rules[0].all)
+	require.Equal(t, []address.Address{mustAddr(t, "f01234")}, l.rules[0].addrs)
+	require.Equal(t, []string{"token:bot"}, l.rules[0].tokens)
+	require.Equal(t, 2, l.rules[0].maxTransfers)
+	require.Equal(t, int64(1024), l.rules[0].maxBytesPerDay)
+
+	require.True(t, l.rules[1].all)
+	require.Equal(t, 5, l.rules[1].maxQueued)
+
+	_, err = New(nil, nil, []config.ClientIngestLimit{{Clients: []string{"not an address"}}})
+	require.ErrorContains(t, err, "parsing client")
+
+	_, err = New(nil, nil, []config.ClientIngestLimit{{MaxBytesPerDay: "lots"}})
+	require.ErrorContains(t, err, "parsing MaxBytesPerDay")
+}
+
+func TestRuleMatching(t *testing.T) {
+	key, err := address.NewActorAddress([]byte("client"))
+	require.NoError(t, err)
+	unknown, err := address.NewActorAddress([]byte("not on chain"))
+	require.NoError(t, err)
+	api := &testAPI{ids: map[address.Address]address.Address{key: mustAddr(t, "f0777")}}
+
+	l, err := New(nil, api, []config.ClientIngestLimit{
+		{Clients: []string{key.String()}, MaxQueuedDeals: 1},
+		{Clients: []string{"f01234", "token:bot"}, MaxQueuedDeals: 2},
+		// not on chain, matches no client
+		{Clients: []string{unknown.String()}, MaxQueuedDeals: 3},
+	})
+	require.NoError(t, err)
+
+	cases := []struct {
+		client string
+		queued int // 0 for no rule
+	}{
+		{"f0777", 1}, // the ID of the key address of the first rule
+		{"f01234", 2},
+		{"token:bot", 2},
+		{"token:other", 0},
+		{"f05", 0},
+	}
+	for _, c := range cases {
+		t.Run(c.client, func(t *testing.T) {
+			r, err := l.rule(context.Background(), c.client)
+			require.NoError(t, err)
+			if c.queued == 0 {
+				require.Nil(t, r)
+				return
+			}
+			require.NotNil(t, r)
+			require.Equal(t, c.queued, r.maxQueued)
+		})
+	}
+
+	_, err = l.rule(context.Background(), "garbage")
+	require.Error(t, err)
+
+	// a catch-all rule applies to clients not matched by earlier rules
+	l, err = New(nil, api, []config.ClientIngestLimit{{Clients: []string{"f01234"}, MaxQueuedDeals: 1}, {MaxQueuedDeals: 9}})
+	require.NoError(t, err)
+	r, err := l.rule(context.Background(), "token:anyone")
+	require.NoError(t, err)
+	require.Equal(t, 9, r.maxQueued)
+}
@@ -1 +1,68 @@
 package market
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/tasks/seal"
+)
+
+// NewIngester starts the piece ingester of a miner as configured in the Ingest section, snapping deals into CC
+// sectors with Ingest.DoSnap and sealing them into new sectors otherwise.
+func NewIngester(ctx context.Context, db *harmonydb.DB, api PieceIngesterApi, maddr address.Address, cfg *config.CurioConfig) (Ingester, error) {
+	if cfg.Ingest.DoSnap {
+		return NewPieceIngesterSnap(ctx, db, api, maddr, false, time.Duration(cfg.Ingest.MaxDealWaitTime), cfg.Ingest.SnapSectorSelection)
+	}
+
+	synth, err := seal.NewSynthPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewPieceIngester(ctx, db, api, maddr, false, time.Duration(cfg.Ingest.MaxDealWaitTime), synth)
+}
+
+// Ingesters starts the piece ingesters of miners on first use and keeps them, for tasks adding pieces to sectors of
+// any miner.
+type Ingesters struct {
+	db  *harmonydb.DB
+	api PieceIngesterApi
+	cfg *config.CurioConfig
+
+	lk        sync.Mutex
+	ingesters map[address.Address]Ingester
+}
+
+func NewIngesters(db *harmonydb.DB, api PieceIngesterApi, cfg *config.CurioConfig) *Ingesters {
+	return &Ingesters{
+		db:  db,
+		api: api,
+		cfg: cfg,
+
+		ingesters: map[address.Address]Ingester{},
+	}
+}
+
+// Get returns the piece ingester of a miner, configured the same way as the ingesters of market RPC servers
+func (i *Ingesters) Get(maddr address.Address) (Ingester, error) {
+	i.lk.Lock()
+	defer i.lk.Unlock()
+
+	if pin, ok := i.ingesters[maddr]; ok {
+		return pin, nil
+	}
+
+	pin, err := NewIngester(context.Background(), i.db, i.api, maddr, i.cfg)
+	if err != nil {
+		return nil, xerrors.Errorf("starting piece ingester for %s: %w", maddr, err)
+	}
+
+	i.ingesters[maddr] = pin
+	return pin, nil
+}
@@ -70,7 +70,7 @@ type PieceIngester struct {
 	miner               address.Address
 	mid                 uint64 // miner ID
 	windowPoStProofType abi.RegisteredPoStProof
-	synth               bool
+	synth               seal.SynthPolicy
 	sectorSize          abi.SectorSize
 	sealRightNow        bool // Should be true only for CurioAPI AllocatePieceToSector method
 	maxWaitTime         time.Duration
@@ -82,7 +82,7 @@ type verifiedDeal struct {
 	tmax       abi.ChainEpoch
 }
 
-func NewPieceIngester(ctx context.Context, db *harmonydb.DB, api PieceIngesterApi, maddr address.Address, sealRightNow bool, maxWaitTime time.Duration, synth bool) (*PieceIngester, error) {
+func NewPieceIngester(ctx context.Context, db *harmonydb.DB, api PieceIngesterApi, maddr address.Address, sealRightNow bool, maxWaitTime time.Duration, synth seal.SynthPolicy) (*PieceIngester, error) {
 	mi, err := api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
 	if err != nil {
 		return nil, err
@@ -200,7 +200,7 @@ func (p *PieceIngester) Seal() error {
 	return nil
 }
 
-func (p *PieceIngester) AllocatePieceToSector(ctx context.Context, maddr address.Address, piece lpiece.PieceDealInfo, rawSize int64, source url.URL, header http.Header) (api.SectorOffset, error) {
+func (p *PieceIngester) AllocatePieceToSector(ctx context.Context, maddr address.Address, piece lpiece.PieceDealInfo, rawSize int64, source url.URL, header http.Header) (_ api.SectorOffset, rerr error) {
 	if maddr != p.miner {
 		return api.SectorOffset{}, xerrors.Errorf("miner address doesn't match")
 	}
@@ -218,6 +218,16 @@ func (p *PieceIngester) AllocatePieceToSector(ctx context.Context, maddr address
 		return api.SectorOffset{}, xerrors.Errorf("raw size doesn't match padded piece size")
 	}
 
+	source, header, dedupRef, err := parkedPieceSource(ctx, p.db, piece.PieceCID(), source, header)
+	if err != nil {
+		return api.SectorOffset{}, err
+	}
+	defer func() {
+		if rerr != nil {
+			dropPieceRef(ctx, p.db, dedupRef)
+		}
+	}()
+
 	var propJson []byte
 
 	dataHdrJson, err := json.Marshal(header)
@@ -571,5 +581,5 @@ func (p *PieceIngester) getSealProofType() (abi.RegisteredSealProof, error) {
 		return 0, xerrors.Errorf("getting network version: %w", err)
 	}
 
-	return miner.PreferredSealProofTypeFromWindowPoStType(nv, p.windowPoStProofType, p.synth)
+	return p.synth.SealProofType(nv, abi.ActorID(p.mid), p.windowPoStProofType)
 }
@@ -19,7 +19,9 @@ import (
 	verifreg13 "github.com/filecoin-project/go-state-types/builtin/v13/verifreg"
 	"github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
 
+	"github.com/filecoin-project/curio/deps/config"
 	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/storiface"
 
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/market"
@@ -30,7 +32,6 @@ import (
 	lpiece "github.com/filecoin-project/lotus/storage/pipeline/piece"
 )
 
-const IdealEndEpochBuffer = 2 * builtin.EpochsInDay
 const MaxEndEpochBufferUnverified = 180 * builtin.EpochsInDay
 
 // assuming that snap takes up to 20min to get to submitting the message we want to avoid sectors from deadlines which will
@@ -48,9 +49,31 @@ type PieceIngesterSnap struct {
 	sectorSize          abi.SectorSize
 	sealRightNow        bool // Should be true only for CurioAPI AllocatePieceToSector method
 	maxWaitTime         time.Duration
+	selection           snapSelection
 }
 
-func NewPieceIngesterSnap(ctx context.Context, db *harmonydb.DB, api PieceIngesterApi, maddr address.Address, sealRightNow bool, maxWaitTime time.Duration) (*PieceIngesterSnap, error) {
+// snapSelection is the policy ordering CC sectors which can take a deal, see config.SnapSectorSelectionConfig.
+type snapSelection struct {
+	policy          string
+	idealBuffer     abi.ChainEpoch
+	preferredGroups []string
+}
+
+const (
+	SnapSelectExpiration       = "expiration"
+	SnapSelectShortestLifetime = "shortest-lifetime"
+	SnapSelectLongestLifetime  = "longest-lifetime"
+)
+
+func NewPieceIngesterSnap(ctx context.Context, db *harmonydb.DB, api PieceIngesterApi, maddr address.Address, sealRightNow bool, maxWaitTime time.Duration, selection config.SnapSectorSelectionConfig) (*PieceIngesterSnap, error) {
+	switch selection.Policy {
+	case "":
+		selection.Policy = SnapSelectExpiration
+	case SnapSelectExpiration, SnapSelectShortestLifetime, SnapSelectLongestLifetime:
+	default:
+		return nil, xerrors.Errorf("unknown snap sector selection policy %q", selection.Policy)
+	}
+
 	mi, err := api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
 	if err != nil {
 		return nil, err
@@ -71,6 +94,11 @@ func NewPieceIngesterSnap(ctx context.Context, db *harmonydb.DB, api PieceIngest
 		sectorSize:          mi.SectorSize,
 		windowPoStProofType: mi.WindowPoStProofType,
 		mid:                 mid,
+		selection: snapSelection{
+			policy:          selection.Policy,
+			idealBuffer:     abi.ChainEpoch(time.Duration(selection.ExpirationBuffer).Seconds()) / builtin.EpochDurationSeconds,
+			preferredGroups: selection.PreferredStorageGroups,
+		},
 	}
 
 	go pi.start()
@@ -167,7 +195,7 @@ func (p *PieceIngesterSnap) Seal() error {
 	return nil
 }
 
-func (p *PieceIngesterSnap) AllocatePieceToSector(ctx context.Context, maddr address.Address, piece lpiece.PieceDealInfo, rawSize int64, source url.URL, header http.Header) (api.SectorOffset, error) {
+func (p *PieceIngesterSnap) AllocatePieceToSector(ctx context.Context, maddr address.Address, piece lpiece.PieceDealInfo, rawSize int64, source url.URL, header http.Header) (_ api.SectorOffset, rerr error) {
 	if maddr != p.miner {
 		return api.SectorOffset{}, xerrors.Errorf("miner address doesn't match")
 	}
@@ -185,6 +213,16 @@ func (p *PieceIngesterSnap) AllocatePieceToSector(ctx context.Context, maddr add
 		return api.SectorOffset{}, xerrors.Errorf("raw size doesn't match padded piece size")
 	}
 
+	source, header, dedupRef, err := parkedPieceSource(ctx, p.db, piece.PieceCID(), source, header)
+	if err != nil {
+		return api.SectorOffset{}, err
+	}
+	defer func() {
+		if rerr != nil {
+			dropPieceRef(ctx, p.db, dedupRef)
+		}
+	}()
+
 	var propJson []byte
 
 	dataHdrJson, err := json.Marshal(header)
@@ -312,7 +350,9 @@ func (p *PieceIngesterSnap) AllocatePieceToSector(ctx context.Context, maddr add
 	// now we have a list of all sectors that are CC
 	// We're selecting the best sector for *this* piece, not any other / pending pieces (like lotus-miner, which made this logic needlessly complex)
 	// We want a sector with an expiration above the piece expiration but below termMax
-	// Ideally we want the sector expiration te be a day or two above the deal expiration, so that it can accomodate other deals with slightly longer expirations
+	// Candidates are ordered by the configured selection policy, sectors in preferred storage groups first. With the
+	// default policy the sector expiration is ideally a day or two above the deal expiration, so that it can
+	// accomodate other deals with slightly longer expirations
 	// Nice to have: use preloaded sectors
 
 	// /TX
@@ -338,8 +378,18 @@ func (p *PieceIngesterSnap) AllocatePieceToSector(ctx context.Context, maddr add
 			  AND sm.expiration_epoch > $1
 			  AND ($2 = 0 OR sm.expiration_epoch < $2)
 			  AND deadline IS NOT NULL AND deadline NOT IN ($5, $6, $7)
-			ORDER BY ABS(sm.expiration_epoch - ($1 + $3))
-		`, int64(piece.DealSchedule.EndEpoch), maxExpiration, IdealEndEpochBuffer, p.mid, dlIdxImmutableCur, dlIdxImmutableNext, dlIdxImmutableNextNext)
+			ORDER BY
+			  EXISTS (SELECT 1 FROM sector_location sl
+			          JOIN storage_path sp ON sp.storage_id = sl.storage_id
+			          WHERE sl.miner_id = sm.sp_id AND sl.sector_num = sm.sector_num AND sl.sector_filetype = $10
+			            AND string_to_array(sp.groups, ',') && $9::TEXT[]) DESC,
+			  CASE $8
+			    WHEN 'shortest-lifetime' THEN sm.expiration_epoch
+			    WHEN 'longest-lifetime' THEN -sm.expiration_epoch
+			    ELSE ABS(sm.expiration_epoch - ($1 + $3))
+			  END
+		`, int64(piece.DealSchedule.EndEpoch), maxExpiration, p.selection.idealBuffer, p.mid, dlIdxImmutableCur, dlIdxImmutableNext, dlIdxImmutableNextNext,
+			p.selection.policy, p.selection.preferredGroups, storiface.FTSealed)
 		if err != nil {
 			return false, xerrors.Errorf("allocating sector numbers: %w", err)
 		}
@@ -436,6 +486,7 @@ func (p *PieceIngesterSnap) AllocatePieceToSector(ctx context.Context, maddr add
 			"sector", candidate.Sector,
 			"expiration", si.Expiration,
 			"sectorLifeTime", sectorLifeTime,
+			"selectionPolicy", p.selection.policy,
 			"dealStartEpoch", piece.DealSchedule.StartEpoch,
 			"dealEndEpoch", piece.DealSchedule.EndEpoch,
 			"maxExpiration", maxExpiration,
@@ -0,0 +1,187 @@
+// Package dealfilter decides which deals handed to Curio by market adapters are accepted.
+package dealfilter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	lpiece "github.com/filecoin-project/lotus/storage/pipeline/piece"
+)
+
+var log = logging.Logger("dealfilter")
+
+// Deal is what filters know about a deal. It is the JSON passed to external filters.
+type Deal struct {
+	Miner     string
+	PieceCID  string
+	PieceSize abi.PaddedPieceSize
+
+	// DealID is nil for DDO pieces
+	DealID *abi.DealID
+	// Client is empty for DDO pieces without a verified allocation
+	Client   string
+	Verified bool
+
+	StoragePricePerEpoch abi.TokenAmount
+	ProviderCollateral   abi.TokenAmount
+
+	StartEpoch   abi.ChainEpoch
+	EndEpoch     abi.ChainEpoch
+	CurrentEpoch abi.ChainEpoch
+}
+
+// Decision is the outcome of a filter
+type Decision struct {
+	Accept bool
+	Reason string
+}
+
+// Filter is one way of deciding on deals
+type Filter interface {
+	// Name identifies the filter in recorded decisions
+	Name() string
+	Filter(ctx context.Context, d Deal) (Decision, error)
+}
+
+type FilterAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+}
+
+// DealFilter runs the rules of the config, then the external command and HTTP filters when they are configured.
+// The first filter rejecting a deal decides, a deal is accepted when all filters accept it.
+type DealFilter struct {
+	db      *harmonydb.DB
+	api     FilterAPI
+	filters []Filter
+}
+
+func New(db *harmonydb.DB, api FilterAPI, cfg config.DealFilterConfig) (*DealFilter, error) {
+	rules, err := NewRules(api, cfg)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing deal filter rules: %w", err)
+	}
+
+	df := &DealFilter{
+		db:      db,
+		api:     api,
+		filters: []Filter{rules},
+	}
+
+	if cfg.Command != "" {
+		df.filters = append(df.filters, NewCommandFilter(cfg.Command, time.Duration(cfg.Timeout)))
+	}
+	if cfg.URL != "" {
+		df.filters = append(df.filters, NewHTTPFilter(cfg.URL, time.Duration(cfg.Timeout)))
+	}
+
+	return df, nil
+}
+
+// Check decides on a deal of a miner and records the decision. Deals are rejected when a filter fails.
+func (df *DealFilter) Check(ctx context.Context, maddr address.Address, piece lpiece.PieceDealInfo) (Decision, error) {
+	head, err := df.api.ChainHead(ctx)
+	if err != nil {
+		return Decision{}, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	d := makeDeal(maddr, piece, head.Height())
+
+	dec := Decision{Accept: true}
+	var names []string
+	for _, f := range df.filters {
+		names = append(names, f.Name())
+
+		dec, err = f.Filter(ctx, d)
+		if err != nil {
+			dec = Decision{Accept: false, Reason: xerrors.Errorf("filter error: %w", err).Error()}
+		}
+		if !dec.Accept {
+			break
+		}
+	}
+
+	// rejections are attributed to the rejecting filter, acceptances to all filters
+	by := strings.Join(names, ",")
+	if !dec.Accept {
+		by = names[len(names)-1]
+	}
+	df.record(ctx, maddr, d, dec, by)
+
+	if !dec.Accept {
+		log.Infow("deal rejected", "miner", maddr, "piece_cid", d.PieceCID, "deal_id", d.DealID, "client", d.Client, "filter", by, "reason", dec.Reason)
+	}
+	return dec, nil
+}
+
+func (df *DealFilter) record(ctx context.Context, maddr address.Address, d Deal, dec Decision, by string) {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		log.Errorw("recording deal filter decision", "error", err)
+		return
+	}
+
+	var dealID *int64
+	if d.DealID != nil {
+		id := int64(*d.DealID)
+		dealID = &id
+	}
+	var client *string
+	if d.Client != "" {
+		client = &d.Client
+	}
+
+	_, err = df.db.Exec(ctx, `INSERT INTO market_deal_filter_decisions (sp_id, piece_cid, piece_size, deal_id, client, start_epoch, end_epoch,
+			accepted, filter, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		int64(mid), d.PieceCID, int64(d.PieceSize), dealID, client, int64(d.StartEpoch), int64(d.EndEpoch), dec.Accept, by, dec.Reason)
+	if err != nil {
+		log.Errorw("recording deal filter decision", "piece_cid", d.PieceCID, "error", err)
+	}
+}
+
+func makeDeal(maddr address.Address, piece lpiece.PieceDealInfo, height abi.ChainEpoch) Deal {
+	d := Deal{
+		Miner:                maddr.String(),
+		PieceCID:             piece.PieceCID().String(),
+		StoragePricePerEpoch: big.Zero(),
+		ProviderCollateral:   big.Zero(),
+		StartEpoch:           piece.DealSchedule.StartEpoch,
+		EndEpoch:             piece.DealSchedule.EndEpoch,
+		CurrentEpoch:         height,
+	}
+
+	if piece.DealProposal != nil {
+		dealID := piece.DealID
+		d.DealID = &dealID
+		d.PieceSize = piece.DealProposal.PieceSize
+		d.Client = piece.DealProposal.Client.String()
+		d.Verified = piece.DealProposal.VerifiedDeal
+		d.StoragePricePerEpoch = piece.DealProposal.StoragePricePerEpoch
+		d.ProviderCollateral = piece.DealProposal.ProviderCollateral
+		d.StartEpoch = piece.DealProposal.StartEpoch
+		d.EndEpoch = piece.DealProposal.EndEpoch
+		return d
+	}
+
+	d.PieceSize = piece.PieceActivationManifest.Size
+	if vak := piece.PieceActivationManifest.VerifiedAllocationKey; vak != nil {
+		d.Verified = true
+		if client, err := address.NewIDAddress(uint64(vak.Client)); err == nil {
+			d.Client = client.String()
+		}
+	}
+	return d
+}
@@ -0,0 +1,114 @@
+package dealfilter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// CommandFilter runs an external command for each deal, which gets the deal as JSON on stdin. The deal is accepted
+// when the command exits with status 0, otherwise its output is the reason of the rejection.
+type CommandFilter struct {
+	cmd     string
+	timeout time.Duration
+}
+
+func NewCommandFilter(cmd string, timeout time.Duration) *CommandFilter {
+	return &CommandFilter{cmd: cmd, timeout: timeout}
+}
+
+func (c *CommandFilter) Name() string {
+	return "command"
+}
+
+func (c *CommandFilter) Filter(ctx context.Context, d Deal) (Decision, error) {
+	in, err := json.Marshal(d)
+	if err != nil {
+		return Decision{}, xerrors.Errorf("marshaling deal: %w", err)
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.cmd)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// children of the shell may keep the output open after it is killed on timeout, don't wait for them
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	if err == nil {
+		return Decision{Accept: true}, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		reason := strings.TrimSpace(out.String())
+		if reason == "" {
+			reason = fmt.Sprintf("filter command exited with status %d", exitErr.ExitCode())
+		}
+		return Decision{Accept: false, Reason: reason}, nil
+	}
+	return Decision{}, xerrors.Errorf("running filter command: %w", err)
+}
+
+// HTTPFilter POSTs each deal as JSON to an URL, which responds with a JSON Decision.
+type HTTPFilter struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPFilter(url string, timeout time.Duration) *HTTPFilter {
+	return &HTTPFilter{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (h *HTTPFilter) Name() string {
+	return "http"
+}
+
+func (h *HTTPFilter) Filter(ctx context.Context, d Deal) (Decision, error) {
+	in, err := json.Marshal(d)
+	if err != nil {
+		return Decision{}, xerrors.Errorf("marshaling deal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(in))
+	if err != nil {
+		return Decision{}, xerrors.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return Decision{}, xerrors.Errorf("calling filter: %w", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return Decision{}, xerrors.Errorf("filter responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var dec Decision
+	if err := json.NewDecoder(resp.Body).Decode(&dec); err != nil {
+		return Decision{}, xerrors.Errorf("decoding filter response: %w", err)
+	}
+	return dec, nil
+}
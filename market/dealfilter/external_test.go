@@ -0,0 +1,115 @@
+package dealfilter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/big"
+)
+
+func testDeal() Deal {
+	return Deal{
+		Miner:                "f01000",
+		PieceCID:             "baga6ea4seaqtest",
+		PieceSize:            2048,
+		Client:               "f0100",
+		StoragePricePerEpoch: big.NewInt(7),
+		ProviderCollateral:   big.Zero(),
+		StartEpoch:           100,
+		EndEpoch:             200,
+	}
+}
+
+func TestCommandFilter(t *testing.T) {
+	cases := []struct {
+		name   string
+		cmd    string
+		accept bool
+		reason string
+		err    string
+	}{
+		{"accept", "true", true, "", ""},
+		{"reject with reason", "echo 'too cheap'; exit 1", false, "too cheap", ""},
+		{"reason from stderr", "echo 'no room' >&2; exit 3", false, "no room", ""},
+		{"reject without reason", "exit 2", false, "filter command exited with status 2", ""},
+		{"deal json on stdin", `in=$(cat); echo "$in" | grep -q '"PieceCID":"baga6ea4seaqtest"' && echo "$in" | grep -q '"StoragePricePerEpoch":"7"'`, true, "", ""},
+		{"timeout", "sleep 5", false, "", "running filter command"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start := time.Now()
+			dec, err := NewCommandFilter(c.cmd, 200*time.Millisecond).Filter(context.Background(), testDeal())
+			if c.err != "" {
+				require.ErrorContains(t, err, c.err)
+				require.Less(t, time.Since(start), 3*time.Second)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.accept, dec.Accept)
+			require.Equal(t, c.reason, dec.Reason)
+		})
+	}
+}
+
+func TestHTTPFilter(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/decide", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var d Deal
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&d))
+		dec := Decision{Accept: d.Client == "f0100"}
+		if !dec.Accept {
+			dec.Reason = "unknown client " + d.Client
+		}
+		_ = json.NewEncoder(w).Encode(dec)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database down", http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/garbage", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("accept"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	filter := func(path string) *HTTPFilter {
+		return NewHTTPFilter(srv.URL+path, 200*time.Millisecond)
+	}
+	ctx := context.Background()
+
+	dec, err := filter("/decide").Filter(ctx, testDeal())
+	require.NoError(t, err)
+	require.True(t, dec.Accept)
+
+	d := testDeal()
+	d.Client = "f0999"
+	dec, err = filter("/decide").Filter(ctx, d)
+	require.NoError(t, err)
+	require.False(t, dec.Accept)
+	require.Equal(t, "unknown client f0999", dec.Reason)
+
+	_, err = filter("/broken").Filter(ctx, testDeal())
+	require.ErrorContains(t, err, "status 500: database down")
+
+	_, err = filter("/garbage").Filter(ctx, testDeal())
+	require.ErrorContains(t, err, "decoding filter response")
+
+	start := time.Now()
+	_, err = filter("/slow").Filter(ctx, testDeal())
+	require.ErrorContains(t, err, "calling filter")
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,186 @@
+package dealfilter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/build"
+	"github.com/filecoin-project/curio/deps/config"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Rules is the built-in filter, checking deals against the price, client, piece size and start epoch limits of the
+// config.
+type Rules struct {
+	api FilterAPI
+
+	minPrice         abi.TokenAmount
+	minVerifiedPrice abi.TokenAmount
+	minSize, maxSize abi.PaddedPieceSize
+	maxStartDelay    abi.ChainEpoch
+
+	allow, deny []address.Address
+
+	// ID addresses of the allow and deny lists, resolved when first needed
+	idLk sync.Mutex
+	ids  map[address.Address]address.Address
+}
+
+func NewRules(api FilterAPI, cfg config.DealFilterConfig) (*Rules, error) {
+	r := &Rules{
+		api:              api,
+		minPrice:         abi.TokenAmount(cfg.MinPricePerEpochPerGiB),
+		minVerifiedPrice: abi.TokenAmount(cfg.MinVerifiedPricePerEpochPerGiB),
+		maxStartDelay:    abi.ChainEpoch(time.Duration(cfg.MaxStartDelay) / (time.Duration(build.BlockDelaySecs) * time.Second)),
+		ids:              map[address.Address]address.Address{},
+	}
+	if r.minPrice.Nil() {
+		r.minPrice = big.Zero()
+	}
+	if r.minVerifiedPrice.Nil() {
+		r.minVerifiedPrice = big.Zero()
+	}
+
+	var err error
+	if r.minSize, err = parsePieceSize(cfg.MinPieceSize); err != nil {
+		return nil, xerrors.Errorf("parsing MinPieceSize: %w", err)
+	}
+	if r.maxSize, err = parsePieceSize(cfg.MaxPieceSize); err != nil {
+		return nil, xerrors.Errorf("parsing MaxPieceSize: %w", err)
+	}
+
+	for _, s := range cfg.ClientAllowList {
+		a, err := address.NewFromString(s)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing ClientAllowList address %s: %w", s, err)
+		}
+		r.allow = append(r.allow, a)
+	}
+	for _, s := range cfg.ClientDenyList {
+		a, err := address.NewFromString(s)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing ClientDenyList address %s: %w", s, err)
+		}
+		r.deny = append(r.deny, a)
+	}
+
+	return r, nil
+}
+
+func parsePieceSize(s string) (abi.PaddedPieceSize, error) {
+	if s == "" {
+		return 0, nil
+	}
+	b, err := units.RAMInBytes(s)
+	if err != nil {
+		return 0, err
+	}
+	return abi.PaddedPieceSize(b), nil
+}
+
+func (r *Rules) Name() string {
+	return "rules"
+}
+
+func (r *Rules) Filter(ctx context.Context, d Deal) (Decision, error) {
+	if r.minSize != 0 && d.PieceSize < r.minSize {
+		return reject("piece size %s below the minimum of %s", units.BytesSize(float64(d.PieceSize)), units.BytesSize(float64(r.minSize))), nil
+	}
+	if r.maxSize != 0 && d.PieceSize > r.maxSize {
+		return reject("piece size %s above the maximum of %s", units.BytesSize(float64(d.PieceSize)), units.BytesSize(float64(r.maxSize))), nil
+	}
+
+	if r.maxStartDelay != 0 && d.StartEpoch-d.CurrentEpoch > r.maxStartDelay {
+		return reject("deal starts in %d epochs, more than the maximum of %d", d.StartEpoch-d.CurrentEpoch, r.maxStartDelay), nil
+	}
+
+	if d.DealID != nil && d.PieceSize > 0 {
+		minPrice := r.minPrice
+		if d.Verified {
+			minPrice = r.minVerifiedPrice
+		}
+
+		// price per epoch per GiB
+		price := big.Div(big.Mul(d.StoragePricePerEpoch, big.NewInt(1<<30)), big.NewInt(int64(d.PieceSize)))
+		if price.LessThan(minPrice) {
+			return reject("price of %s/GiB/epoch below the minimum of %s", types.FIL(price).Short(), types.FIL(minPrice).Short()), nil
+		}
+	}
+
+	if len(r.allow) > 0 || len(r.deny) > 0 {
+		if d.Client == "" {
+			if len(r.allow) > 0 {
+				return reject("unknown client is not in the allow list"), nil
+			}
+			return Decision{Accept: true}, nil
+		}
+
+		client, err := address.NewFromString(d.Client)
+		if err != nil {
+			return Decision{}, xerrors.Errorf("parsing client address: %w", err)
+		}
+		clientID, err := r.api.StateLookupID(ctx, client, types.EmptyTSK)
+		if err != nil {
+			return Decision{}, xerrors.Errorf("looking up client ID: %w", err)
+		}
+
+		denied, err := r.listed(ctx, r.deny, clientID)
+		if err != nil {
+			return Decision{}, err
+		}
+		if denied {
+			return reject("client %s is in the deny list", d.Client), nil
+		}
+
+		if len(r.allow) > 0 {
+			allowed, err := r.listed(ctx, r.allow, clientID)
+			if err != nil {
+				return Decision{}, err
+			}
+			if !allowed {
+				return reject("client %s is not in the allow list", d.Client), nil
+			}
+		}
+	}
+
+	return Decision{Accept: true}, nil
+}
+
+// listed checks if the client is in a list by comparing ID addresses. Addresses of the list which don't resolve to
+// an actor yet can't match.
+func (r *Rules) listed(ctx context.Context, list []address.Address, clientID address.Address) (bool, error) {
+	r.idLk.Lock()
+	defer r.idLk.Unlock()
+
+	for _, a := range list {
+		id, ok := r.ids[a]
+		if !ok {
+			var err error
+			id, err = r.api.StateLookupID(ctx, a, types.EmptyTSK)
+			if err != nil {
+				log.Debugw("listed client address not found", "address", a, "error", err)
+				continue
+			}
+			r.ids[a] = id
+		}
+
+		if id == clientID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func reject(format string, args ...any) Decision {
+	return Decision{Accept: false, Reason: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,139 @@
+package dealfilter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/deps/config"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type testAPI struct {
+	ids map[address.Address]address.Address
+}
+
+func (a *testAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	return nil, xerrors.New("not implemented")
+}
+
+func (a *testAPI) StateLookupID(_ context.Context, addr address.Address, _ types.TipSetKey) (address.Address, error) {
+	if addr.Protocol() == address.ID {
+		return addr, nil
+	}
+	id, ok := a.ids[addr]
+	if !ok {
+		return address.Undef, xerrors.Errorf("actor %s not found", addr)
+	}
+	return id, nil
+}
+
+func TestRules(t *testing.T) {
+	key, err := address.NewActorAddress([]byte("client"))
+	require.NoError(t, err)
+	api := &testAPI{ids: map[address.Address]address.Address{key: mustID(t, 300)}}
+
+	dealID := abi.DealID(1)
+	// 1 GiB piece, so the price per epoch is the price per GiB per epoch
+	deal := func(mod func(d *Deal)) Deal {
+		d := Deal{
+			PieceSize:            1 << 30,
+			DealID:               &dealID,
+			Client:               "f0100",
+			StoragePricePerEpoch: big.NewInt(1000),
+			StartEpoch:           1100,
+			CurrentEpoch:         1000,
+		}
+		if mod != nil {
+			mod(&d)
+		}
+		return d
+	}
+
+	cases := []struct {
+		name   string
+		cfg    config.DealFilterConfig
+		deal   Deal
+		reject string
+	}{
+		{"no rules", config.DealFilterConfig{}, deal(nil), ""},
+
+		{"price ok", config.DealFilterConfig{MinPricePerEpochPerGiB: types.FIL(big.NewInt(1000))}, deal(nil), ""},
+		{"price low", config.DealFilterConfig{MinPricePerEpochPerGiB: types.FIL(big.NewInt(1001))}, deal(nil), "below the minimum"},
+		{"price per GiB of a small piece", config.DealFilterConfig{MinPricePerEpochPerGiB: types.FIL(big.NewInt(1000))},
+			deal(func(d *Deal) { d.PieceSize = 1 << 20; d.StoragePricePerEpoch = big.NewInt(1) }), ""},
+		{"verified price", config.DealFilterConfig{MinPricePerEpochPerGiB: types.FIL(big.NewInt(5000))},
+			deal(func(d *Deal) { d.Verified = true }), ""},
+		{"verified price low", config.DealFilterConfig{MinVerifiedPricePerEpochPerGiB: types.FIL(big.NewInt(5000))},
+			deal(func(d *Deal) { d.Verified = true }), "below the minimum"},
+		{"ddo pieces have no price", config.DealFilterConfig{MinPricePerEpochPerGiB: types.FIL(big.NewInt(5000))},
+			deal(func(d *Deal) { d.DealID = nil; d.StoragePricePerEpoch = big.Zero() }), ""},
+
+		{"size ok", config.DealFilterConfig{MinPieceSize: "1GiB", MaxPieceSize: "1GiB"}, deal(nil), ""},
+		{"size small", config.DealFilterConfig{MinPieceSize: "2GiB"}, deal(nil), "below the minimum"},
+		{"size big", config.DealFilterConfig{MaxPieceSize: "512MiB"}, deal(nil), "above the maximum"},
+
+		{"start ok", config.DealFilterConfig{MaxStartDelay: config.Duration(time.Hour)}, deal(nil), ""},
+		{"start late", config.DealFilterConfig{MaxStartDelay: config.Duration(time.Hour)},
+			deal(func(d *Deal) { d.StartEpoch = 1121 }), "deal starts in 121 epochs"},
+
+		{"allowed", config.DealFilterConfig{ClientAllowList: []string{"f0100"}}, deal(nil), ""},
+		{"not allowed", config.DealFilterConfig{ClientAllowList: []string{"f0101"}}, deal(nil), "not in the allow list"},
+		{"allowed by key address", config.DealFilterConfig{ClientAllowList: []string{key.String()}},
+			deal(func(d *Deal) { d.Client = "f0300" }), ""},
+		{"client by key address", config.DealFilterConfig{ClientAllowList: []string{"f0300"}},
+			deal(func(d *Deal) { d.Client = key.String() }), ""},
+		{"denied", config.DealFilterConfig{ClientDenyList: []string{"f0100"}}, deal(nil), "in the deny list"},
+		{"deny wins over allow", config.DealFilterConfig{ClientAllowList: []string{"f0100"}, ClientDenyList: []string{"f0100"}},
+			deal(nil), "in the deny list"},
+		{"not denied", config.DealFilterConfig{ClientDenyList: []string{"f0101"}}, deal(nil), ""},
+		{"unknown client with allow list", config.DealFilterConfig{ClientAllowList: []string{"f0100"}},
+			deal(func(d *Deal) { d.Client = "" }), "unknown client"},
+		{"unknown client with deny list", config.DealFilterConfig{ClientDenyList: []string{"f0100"}},
+			deal(func(d *Deal) { d.Client = "" }), ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := NewRules(api, c.cfg)
+			require.NoError(t, err)
+
+			dec, err := r.Filter(context.Background(), c.deal)
+			require.NoError(t, err)
+			if c.reject == "" {
+				require.True(t, dec.Accept, dec.Reason)
+				return
+			}
+			require.False(t, dec.Accept)
+			require.Contains(t, dec.Reason, c.reject)
+		})
+	}
+}
+
+func TestRulesErrors(t *testing.T) {
+	_, err := NewRules(&testAPI{}, config.DealFilterConfig{MinPieceSize: "big"})
+	require.ErrorContains(t, err, "MinPieceSize")
+	_, err = NewRules(&testAPI{}, config.DealFilterConfig{ClientDenyList: []string{"nope"}})
+	require.ErrorContains(t, err, "ClientDenyList")
+
+	// clients which aren't on chain fail the filter when they have to be checked against a list
+	unknown, err := address.NewActorAddress([]byte("unknown"))
+	require.NoError(t, err)
+	r, err := NewRules(&testAPI{}, config.DealFilterConfig{ClientDenyList: []string{"f0100"}})
+	require.NoError(t, err)
+	_, err = r.Filter(context.Background(), Deal{Client: unknown.String()})
+	require.ErrorContains(t, err, "looking up client ID")
+}
+
+func mustID(t *testing.T, id uint64) address.Address {
+	a, err := address.NewIDAddress(id)
+	require.NoError(t, err)
+	return a
+}
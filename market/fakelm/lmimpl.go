@@ -10,6 +10,7 @@ import (
 
 	"github.com/gbrlsnchs/jwt/v3"
 	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
 	typegen "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/xerrors"
@@ -26,6 +27,7 @@ import (
 	"github.com/filecoin-project/curio/lib/paths"
 	storiface "github.com/filecoin-project/curio/lib/storiface"
 	"github.com/filecoin-project/curio/market"
+	"github.com/filecoin-project/curio/tasks/unseal"
 
 	lapi "github.com/filecoin-project/lotus/api"
 	market2 "github.com/filecoin-project/lotus/chain/actors/builtin/market"
@@ -492,6 +494,33 @@ func (l *LMRPCProvider) IsUnsealed(ctx context.Context, sectorNum abi.SectorNumb
 	return len(si) > 0, nil
 }
 
+// SectorsUnsealPiece is called by the market node to retrieve data without an unsealed copy. The range is
+// unsealed on demand by the UnsealRange task, the call blocks until it is.
+func (l *LMRPCProvider) SectorsUnsealPiece(ctx context.Context, sector storiface.SectorRef, offset storiface.UnpaddedByteIndex, size abi.UnpaddedPieceSize, randomness abi.SealRandomness, commd *cid.Cid) error {
+	if sector.ID.Miner != l.minerID {
+		return xerrors.Errorf("sector %d/%d doesn't belong to miner %s", sector.ID.Miner, sector.ID.Number, l.maddr)
+	}
+	if err := offset.Valid(); err != nil {
+		return xerrors.Errorf("invalid offset: %w", err)
+	}
+	if err := size.Validate(); err != nil {
+		return xerrors.Errorf("invalid size: %w", err)
+	}
+
+	poffset, psize := offset.Padded(), size.Padded()
+
+	if err := unseal.RequestRange(ctx, l.db, sector.ID, poffset, psize); err != nil {
+		return xerrors.Errorf("requesting unseal: %w", err)
+	}
+
+	log.Infow("waiting for on-demand unseal", "sector", sector.ID, "offset", poffset, "size", psize)
+	if err := unseal.WaitRange(ctx, l.db, sector.ID, poffset, psize); err != nil {
+		return xerrors.Errorf("waiting for unseal: %w", err)
+	}
+
+	return nil
+}
+
 func (l *LMRPCProvider) ComputeDataCid(ctx context.Context, pieceSize abi.UnpaddedPieceSize, pieceData storiface.Data) (abi.PieceInfo, error) {
 	return abi.PieceInfo{}, xerrors.Errorf("not supported")
 }
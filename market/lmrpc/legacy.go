@@ -0,0 +1,164 @@
+package lmrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/market/clientlimit"
+	"github.com/filecoin-project/curio/market/dealfilter"
+	"github.com/filecoin-project/curio/tasks/dealvalidate"
+
+	lpiece "github.com/filecoin-project/lotus/storage/pipeline/piece"
+)
+
+// maxLegacyDealBody limits the size of deal requests, which carry deal info and a data reference but no data
+const maxLegacyDealBody = 1 << 20
+
+// LegacyDeal is a published storage market deal handed to Curio with a reference to its piece data, the way
+// lotus-miner based markets onboarded offline deals. Unlike deals added through SectorAddPieceToAny the data isn't
+// streamed through the adapter, sealing nodes download it from DataURL.
+type LegacyDeal struct {
+	DealInfo lpiece.PieceDealInfo
+
+	// RawSize is the size of the piece data, defaults to the unpadded size of the piece
+	RawSize abi.UnpaddedPieceSize
+
+	DataURL     string
+	DataHeaders http.Header
+}
+
+// legacyDealHandler serves POST /deal, adding a published deal to a sector and responding with the sector offset.
+// Deals are checked like deals from Boost, including the client limits. When the ingest pipeline is full the handler
+// responds with 503 and callers should retry later.
+func legacyDealHandler(maddr address.Address, conf *config.CurioConfig, pin PieceIngester, vapi dealvalidate.ValidateAPI, df *dealfilter.DealFilter, cl *clientlimit.Limiter, db *harmonydb.DB, ssize abi.SectorSize) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "bad method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var deal LegacyDeal
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLegacyDealBody)).Decode(&deal); err != nil {
+			http.Error(w, fmt.Sprintf("decoding deal: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		dataUrl, err := checkLegacyDeal(&deal)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+
+		vres, err := ValidateProposal(ctx, db, vapi, conf.Ingest.OffloadDealValidation, dealvalidate.Proposal{Miner: maddr, Deal: deal.DealInfo})
+		if err != nil {
+			log.Errorw("validating legacy deal", "deal", deal.DealInfo.DealID, "error", err)
+			http.Error(w, fmt.Sprintf("validating deal proposal: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if !vres.Valid {
+			http.Error(w, fmt.Sprintf("invalid deal proposal: %s", vres.Reason), http.StatusForbidden)
+			return
+		}
+
+		dec, err := df.Check(ctx, maddr, deal.DealInfo)
+		if err != nil {
+			log.Errorw("checking legacy deal filter", "deal", deal.DealInfo.DealID, "error", err)
+			http.Error(w, fmt.Sprintf("checking deal filter: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if !dec.Accept {
+			http.Error(w, fmt.Sprintf("deal rejected by deal filter: %s", dec.Reason), http.StatusForbidden)
+			return
+		}
+
+		full, err := IngestFull(ctx, db, conf, ssize)
+		if err != nil {
+			log.Errorw("checking backpressure", "error", err)
+			http.Error(w, fmt.Sprintf("checking backpressure: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if full {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(backpressureWaitTime.Seconds())))
+			http.Error(w, "ingest pipeline is full", http.StatusServiceUnavailable)
+			return
+		}
+
+		// sealing nodes fetch the data, the deal only counts against the daily and queued limits
+		limitID, rejected, err := cl.Begin(ctx, LimitDeal(maddr, vres, deal.DealInfo, int64(deal.RawSize), false))
+		if err != nil {
+			log.Errorw("checking legacy deal client limits", "deal", deal.DealInfo.DealID, "error", err)
+			http.Error(w, fmt.Sprintf("checking client limits: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if rejected != "" {
+			http.Error(w, fmt.Sprintf("deal rejected by client limits: %s", rejected), http.StatusTooManyRequests)
+			return
+		}
+
+		so, err := pin.AllocatePieceToSector(ctx, maddr, deal.DealInfo, int64(deal.RawSize), *dataUrl, deal.DataHeaders)
+		if err != nil {
+			cl.Failed(ctx, limitID)
+			log.Errorw("adding legacy deal to sector", "deal", deal.DealInfo.DealID, "error", err)
+			http.Error(w, fmt.Sprintf("adding deal to sector: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		log.Infow("legacy deal assigned to sector", "deal", deal.DealInfo.DealID, "piece_cid", deal.DealInfo.PieceCID(), "sector", so.Sector, "offset", so.Offset)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(so); err != nil {
+			log.Warnw("writing legacy deal response", "error", err)
+		}
+	}
+}
+
+// checkLegacyDeal checks that a deal is a published market deal with a usable data reference, and fills in the
+// default raw size
+func checkLegacyDeal(deal *LegacyDeal) (*url.URL, error) {
+	di := deal.DealInfo
+	if di.DealProposal == nil || di.PieceActivationManifest != nil {
+		return nil, xerrors.Errorf("deal info must have a deal proposal")
+	}
+	if di.PublishCid == nil || di.DealID == 0 {
+		return nil, xerrors.Errorf("deal must be published, deal info must have a publish cid and deal id")
+	}
+
+	if deal.RawSize == 0 {
+		deal.RawSize = di.DealProposal.PieceSize.Unpadded()
+	}
+	if deal.RawSize > di.DealProposal.PieceSize.Unpadded() {
+		return nil, xerrors.Errorf("raw size %d doesn't fit in piece of size %d", deal.RawSize, di.DealProposal.PieceSize)
+	}
+
+	u, err := url.Parse(deal.DataURL)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing data url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, xerrors.Errorf("data url must be an http or https url")
+	}
+
+	return u, nil
+}
+
+// IngestFull tells whether the ingest backpressure limits are reached
+func IngestFull(ctx context.Context, db *harmonydb.DB, conf *config.CurioConfig, ssize abi.SectorSize) (bool, error) {
+	var full bool
+	_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		full, err = maybeApplyBackpressure(tx, conf.Ingest, ssize)
+		return false, err
+	})
+	return full, err
+}
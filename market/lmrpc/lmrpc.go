@@ -31,7 +31,10 @@ import (
 	"github.com/filecoin-project/curio/lib/paths"
 	"github.com/filecoin-project/curio/lib/storiface"
 	cumarket "github.com/filecoin-project/curio/market"
+	"github.com/filecoin-project/curio/market/clientlimit"
+	"github.com/filecoin-project/curio/market/dealfilter"
 	"github.com/filecoin-project/curio/market/fakelm"
+	"github.com/filecoin-project/curio/tasks/dealvalidate"
 
 	lapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/build/buildconstants"
@@ -155,16 +158,19 @@ func forEachMarketRPC(cfg *config.CurioConfig, cb func(string, string) error) er
 func ServeCurioMarketRPC(db *harmonydb.DB, full api.Chain, maddr address.Address, conf *config.CurioConfig, listen string) error {
 	ctx := context.Background()
 
-	var pin cumarket.Ingester
-	var err error
-	if conf.Ingest.DoSnap {
-		pin, err = cumarket.NewPieceIngesterSnap(ctx, db, full, maddr, false, time.Duration(conf.Ingest.MaxDealWaitTime))
-	} else {
-		pin, err = cumarket.NewPieceIngester(ctx, db, full, maddr, false, time.Duration(conf.Ingest.MaxDealWaitTime), conf.Subsystems.UseSyntheticPoRep)
+	pin, err := cumarket.NewIngester(ctx, db, full, maddr, conf)
+	if err != nil {
+		return xerrors.Errorf("starting piece ingestor")
 	}
 
+	df, err := dealfilter.New(db, full, conf.Ingest.DealFilter)
 	if err != nil {
-		return xerrors.Errorf("starting piece ingestor")
+		return xerrors.Errorf("setting up deal filter: %w", err)
+	}
+
+	cl, err := clientlimit.New(db, full, conf.Ingest.ClientLimits)
+	if err != nil {
+		return xerrors.Errorf("setting up client limits: %w", err)
 	}
 
 	si := paths.NewDBIndex(nil, db)
@@ -216,7 +222,8 @@ func ServeCurioMarketRPC(db *harmonydb.DB, full api.Chain, maddr address.Address
 	ast.Internal.SectorsListInStates = lp.SectorsListInStates
 	adaptFunc(&ast.Internal.StorageRedeclareLocal, lp.StorageRedeclareLocal)
 	adaptFunc(&ast.Internal.ComputeDataCid, lp.ComputeDataCid)
-	ast.Internal.SectorAddPieceToAny = sectorAddPieceToAnyOperation(maddr, rootUrl, conf, pieceInfoLk, pieceInfos, pin, db, mi.SectorSize)
+	adaptFunc(&ast.Internal.SectorsUnsealPiece, lp.SectorsUnsealPiece)
+	ast.Internal.SectorAddPieceToAny = sectorAddPieceToAnyOperation(maddr, rootUrl, conf, pieceInfoLk, pieceInfos, pin, full, df, cl, db, mi.SectorSize)
 	adaptFunc(&ast.Internal.StorageList, si.StorageList)
 	adaptFunc(&ast.Internal.StorageDetach, si.StorageDetach)
 	adaptFunc(&ast.Internal.StorageReportHealth, si.StorageReportHealth)
@@ -297,6 +304,7 @@ func ServeCurioMarketRPC(db *harmonydb.DB, full api.Chain, maddr address.Address
 
 	mux := http.NewServeMux()
 	mux.Handle("/piece", pieceHandler)
+	mux.Handle("/deal", legacyDealHandler(maddr, conf, pin, full, df, cl, db, mi.SectorSize))
 	mux.Handle("/", mh) // todo: create a method for sealNow for sectors
 
 	server := &http.Server{
@@ -369,12 +377,42 @@ type PieceIngester interface {
 	AllocatePieceToSector(ctx context.Context, maddr address.Address, piece lpiece.PieceDealInfo, rawSize int64, source url.URL, header http.Header) (lapi.SectorOffset, error)
 }
 
-func sectorAddPieceToAnyOperation(maddr address.Address, rootUrl url.URL, conf *config.CurioConfig, pieceInfoLk *sync.Mutex, pieceInfos map[uuid.UUID][]pieceInfo, pin PieceIngester, db *harmonydb.DB, ssize abi.SectorSize) func(ctx context.Context, pieceSize abi.UnpaddedPieceSize, pieceData storiface.Data, deal lpiece.PieceDealInfo) (lapi.SectorOffset, error) {
-	return func(ctx context.Context, pieceSize abi.UnpaddedPieceSize, pieceData storiface.Data, deal lpiece.PieceDealInfo) (lapi.SectorOffset, error) {
+func sectorAddPieceToAnyOperation(maddr address.Address, rootUrl url.URL, conf *config.CurioConfig, pieceInfoLk *sync.Mutex, pieceInfos map[uuid.UUID][]pieceInfo, pin PieceIngester, vapi dealvalidate.ValidateAPI, df *dealfilter.DealFilter, cl *clientlimit.Limiter, db *harmonydb.DB, ssize abi.SectorSize) func(ctx context.Context, pieceSize abi.UnpaddedPieceSize, pieceData storiface.Data, deal lpiece.PieceDealInfo) (lapi.SectorOffset, error) {
+	return func(ctx context.Context, pieceSize abi.UnpaddedPieceSize, pieceData storiface.Data, deal lpiece.PieceDealInfo) (so lapi.SectorOffset, err error) {
 		if (deal.PieceActivationManifest == nil && deal.DealProposal == nil) || (deal.PieceActivationManifest != nil && deal.DealProposal != nil) {
 			return lapi.SectorOffset{}, xerrors.Errorf("deal info must have either deal proposal or piece manifest")
 		}
 
+		vres, err := ValidateProposal(ctx, db, vapi, conf.Ingest.OffloadDealValidation, dealvalidate.Proposal{Miner: maddr, Deal: deal})
+		if err != nil {
+			return lapi.SectorOffset{}, xerrors.Errorf("validating deal proposal: %w", err)
+		}
+		if !vres.Valid {
+			return lapi.SectorOffset{}, xerrors.Errorf("invalid deal proposal: %s", vres.Reason)
+		}
+
+		dec, err := df.Check(ctx, maddr, deal)
+		if err != nil {
+			return lapi.SectorOffset{}, xerrors.Errorf("checking deal filter: %w", err)
+		}
+		if !dec.Accept {
+			return lapi.SectorOffset{}, xerrors.Errorf("deal rejected by deal filter: %s", dec.Reason)
+		}
+
+		ld := LimitDeal(maddr, vres, deal, int64(pieceSize), true)
+		limitID, rejected, err := cl.Begin(ctx, ld)
+		if err != nil {
+			return lapi.SectorOffset{}, xerrors.Errorf("checking client limits: %w", err)
+		}
+		if rejected != "" {
+			return lapi.SectorOffset{}, xerrors.Errorf("deal rejected by client limits: %s", rejected)
+		}
+		defer func() {
+			if err != nil {
+				cl.Failed(ctx, limitID)
+			}
+		}()
+
 		origPieceData := pieceData
 		defer func() {
 			closer, ok := origPieceData.(io.Closer)
@@ -410,7 +448,7 @@ func sectorAddPieceToAnyOperation(maddr address.Address, rootUrl url.URL, conf *
 		dataUrl.RawQuery = "piece_id=" + pieceUUID.String()
 
 		// add piece entry
-		refID, pieceWasCreated, err := addPieceEntry(ctx, db, conf, deal, pieceSize, dataUrl, ssize)
+		refID, pieceWasCreated, err := addPieceEntry(ctx, db, conf, deal, pieceSize, dataUrl, ssize, ld.Client, ld.SpID)
 		if err != nil {
 			return lapi.SectorOffset{}, err
 		}
@@ -448,6 +486,7 @@ func sectorAddPieceToAnyOperation(maddr address.Address, rootUrl url.URL, conf *
 				}
 
 				if complete {
+					cl.Transferred(ctx, limitID)
 					break
 				}
 
@@ -489,7 +528,7 @@ func sectorAddPieceToAnyOperation(maddr address.Address, rootUrl url.URL, conf *
 		}
 
 		// make a sector
-		so, err := pin.AllocatePieceToSector(ctx, maddr, deal, int64(pieceSize), pieceIDUrl, nil)
+		so, err = pin.AllocatePieceToSector(ctx, maddr, deal, int64(pieceSize), pieceIDUrl, nil)
 		if err != nil {
 			return lapi.SectorOffset{}, err
 		}
@@ -500,10 +539,55 @@ func sectorAddPieceToAnyOperation(maddr address.Address, rootUrl url.URL, conf *
 	}
 }
 
-func addPieceEntry(ctx context.Context, db *harmonydb.DB, conf *config.CurioConfig, deal lpiece.PieceDealInfo, pieceSize abi.UnpaddedPieceSize, dataUrl url.URL, ssize abi.SectorSize) (int64, bool, error) {
+// LimitDeal is the deal counted against the client limits, deals without a resolved client aren't limited
+func LimitDeal(maddr address.Address, vres dealvalidate.Result, deal lpiece.PieceDealInfo, rawSize int64, transfer bool) clientlimit.Deal {
+	d := clientlimit.Deal{
+		PieceCID:  deal.PieceCID().String(),
+		PieceSize: deal.Size(),
+		RawSize:   rawSize,
+		Transfer:  transfer,
+	}
+	if start, err := deal.StartEpoch(); err == nil {
+		d.StartEpoch = start
+	}
+	if vres.ClientID != address.Undef {
+		d.Client = vres.ClientID.String()
+	}
+	if mid, err := address.IDFromAddress(maddr); err == nil {
+		d.SpID = int64(mid)
+	}
+	return d
+}
+
+// ValidateProposal validates a deal proposal in the adapter, or in a DealValidate task when offload is set
+func ValidateProposal(ctx context.Context, db *harmonydb.DB, vapi dealvalidate.ValidateAPI, offload bool, p dealvalidate.Proposal) (dealvalidate.Result, error) {
+	if !offload {
+		return dealvalidate.Check(ctx, db, vapi, p)
+	}
+
+	id, err := dealvalidate.Submit(ctx, db, p)
+	if err != nil {
+		return dealvalidate.Result{}, err
+	}
+	return dealvalidate.Wait(ctx, db, id)
+}
+
+// addPieceEntry adds a ref to the parked piece of a deal, parking the piece when it isn't parked yet. The ref records
+// the client and SP the data is transferred for, client is empty for deals without a resolved client.
+func addPieceEntry(ctx context.Context, db *harmonydb.DB, conf *config.CurioConfig, deal lpiece.PieceDealInfo, pieceSize abi.UnpaddedPieceSize, dataUrl url.URL, ssize abi.SectorSize, client string, spID int64) (int64, bool, error) {
 	var refID int64
 	var pieceWasCreated bool
 
+	var clientStr *string
+	if client != "" {
+		clientStr = &client
+	}
+	var dealID *int64
+	if deal.DealProposal != nil {
+		id := int64(deal.DealID)
+		dealID = &id
+	}
+
 	for {
 		var backpressureWait bool
 
@@ -543,8 +627,8 @@ func addPieceEntry(ctx context.Context, db *harmonydb.DB, conf *config.CurioConf
 			}
 
 			// Add parked_piece_ref
-			err = tx.QueryRow(`INSERT INTO parked_piece_refs (piece_id, data_url)
-        			VALUES ($1, $2) RETURNING ref_id`, pieceID, dataUrl.String()).Scan(&refID)
+			err = tx.QueryRow(`INSERT INTO parked_piece_refs (piece_id, data_url, client, sp_id, deal_id)
+        			VALUES ($1, $2, $3, $4, $5) RETURNING ref_id`, pieceID, dataUrl.String(), clientStr, spID, dealID).Scan(&refID)
 			if err != nil {
 				return false, xerrors.Errorf("inserting parked piece ref: %w", err)
 			}
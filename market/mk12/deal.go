@@ -0,0 +1,348 @@
+package mk12
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/yugabyte/pgx/v5"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/market/lmrpc"
+	"github.com/filecoin-project/curio/tasks/dealvalidate"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	lpiece "github.com/filecoin-project/lotus/storage/pipeline/piece"
+)
+
+// processDeal checks a deal proposal and records the accepted deal, with a parked piece ref fetching its data from
+// the client. Offline deals are accepted as renewals of pieces the provider already holds.
+func (s *Server) processDeal(ctx context.Context, params *DealParams) DealResponse {
+	reject := func(format string, args ...any) DealResponse {
+		return DealResponse{Message: fmt.Sprintf(format, args...)}
+	}
+
+	prop := params.ClientDealProposal.Proposal
+
+	renewal := params.IsOffline
+	if renewal && !s.cfg.Ingest.Libp2p.Renewal.Enable {
+		return reject("offline deals are not supported")
+	}
+
+	var dataUrl *url.URL
+	var hdr http.Header
+	if !renewal {
+		var err error
+		dataUrl, hdr, err = transferSource(params.Transfer)
+		if err != nil {
+			return reject("%s", err)
+		}
+	}
+
+	if err := checkPieceSize(prop, params.Transfer.Size, renewal); err != nil {
+		return reject("%s", err)
+	}
+	rawSize := abi.UnpaddedPieceSize(params.Transfer.Size)
+
+	maddr, err := s.api.StateLookupID(ctx, prop.Provider, types.EmptyTSK)
+	if err != nil {
+		return reject("looking up provider %s: %s", prop.Provider, err)
+	}
+	if _, ok := s.miners[maddr]; !ok {
+		return reject("deals for provider %s are not accepted", prop.Provider)
+	}
+
+	var held *heldPiece
+	if renewal {
+		held, err = s.heldPiece(ctx, maddr, prop.PieceCID, prop.PieceSize)
+		if err != nil {
+			log.Errorw("checking held piece", "piece_cid", prop.PieceCID, "error", err)
+			return reject("internal error")
+		}
+		if held == nil {
+			return reject("offline deals are only accepted to renew pieces the provider holds, piece %s isn't held", prop.PieceCID)
+		}
+		rawSize = abi.UnpaddedPieceSize(held.RawSize)
+	}
+
+	head, err := s.api.ChainHead(ctx)
+	if err != nil {
+		log.Errorw("getting chain head", "error", err)
+		return reject("internal error")
+	}
+	minStart := head.Height() + abi.ChainEpoch(time.Duration(s.cfg.Ingest.Libp2p.MinStartDelay)/(builtin.EpochDurationSeconds*time.Second))
+	if prop.StartEpoch < minStart {
+		return reject("deal starts at epoch %d, deals must start after epoch %d", prop.StartEpoch, minStart)
+	}
+
+	mi, err := s.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+	if err != nil {
+		log.Errorw("getting miner info", "miner", maddr, "error", err)
+		return reject("internal error")
+	}
+	if abi.PaddedPieceSize(mi.SectorSize) < prop.PieceSize {
+		return reject("piece of size %d doesn't fit in sectors of size %d", prop.PieceSize, mi.SectorSize)
+	}
+
+	var exists bool
+	err = s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM market_mk12_deals WHERE uuid = $1)`, params.DealUUID.String()).Scan(&exists)
+	if err != nil {
+		log.Errorw("checking deal uuid", "error", err)
+		return reject("internal error")
+	}
+	if exists {
+		return reject("deal with uuid %s already exists", params.DealUUID)
+	}
+
+	deal := lpiece.PieceDealInfo{
+		DealProposal: &prop,
+		DealSchedule: lpiece.DealSchedule{
+			StartEpoch: prop.StartEpoch,
+			EndEpoch:   prop.EndEpoch,
+		},
+		KeepUnsealed: !params.RemoveUnsealedCopy,
+	}
+
+	vres, err := lmrpc.ValidateProposal(ctx, s.db, s.api, s.cfg.Ingest.OffloadDealValidation, dealvalidate.Proposal{
+		Miner:           maddr,
+		Deal:            deal,
+		ClientSignature: &params.ClientDealProposal.ClientSignature,
+	})
+	if err != nil {
+		log.Errorw("validating deal proposal", "uuid", params.DealUUID, "error", err)
+		return reject("validating deal proposal: %s", err)
+	}
+	if !vres.Valid {
+		return reject("invalid deal proposal: %s", vres.Reason)
+	}
+
+	var match *renewalMatch
+	if renewal {
+		var reason string
+		match, reason, err = s.matchRenewal(ctx, maddr, vres.ClientID, prop, head.Height())
+		if err != nil {
+			log.Errorw("matching renewal", "uuid", params.DealUUID, "error", err)
+			return reject("internal error")
+		}
+		if match == nil {
+			return reject("%s", reason)
+		}
+	}
+
+	dec, err := s.df.Check(ctx, maddr, deal)
+	if err != nil {
+		log.Errorw("checking deal filter", "uuid", params.DealUUID, "error", err)
+		return reject("checking deal filter: %s", err)
+	}
+	if !dec.Accept {
+		return reject("deal rejected by deal filter: %s", dec.Reason)
+	}
+
+	full, err := lmrpc.IngestFull(ctx, s.db, s.cfg, mi.SectorSize)
+	if err != nil {
+		log.Errorw("checking backpressure", "error", err)
+		return reject("internal error")
+	}
+	if full {
+		return reject("storage provider is busy, try again later")
+	}
+
+	ld := lmrpc.LimitDeal(maddr, vres, deal, int64(rawSize), !renewal)
+	limitID, rejected, err := s.cl.Begin(ctx, ld)
+	if err != nil {
+		log.Errorw("checking client limits", "uuid", params.DealUUID, "error", err)
+		return reject("internal error")
+	}
+	if rejected != "" {
+		return reject("deal rejected by client limits: %s", rejected)
+	}
+
+	if renewal {
+		err = s.insertRenewal(ctx, params, maddr, held, match, limitID)
+	} else {
+		err = s.insertDeal(ctx, params, maddr, dataUrl, hdr, ld.Client, limitID)
+	}
+	if err != nil {
+		s.cl.Failed(ctx, limitID)
+		log.Errorw("recording deal", "uuid", params.DealUUID, "error", err)
+		return reject("internal error")
+	}
+
+	log.Infow("deal accepted", "uuid", params.DealUUID, "provider", maddr, "client", prop.Client, "piece_cid", prop.PieceCID,
+		"size", rawSize, "renewal", renewal)
+	return DealResponse{Accepted: true}
+}
+
+// transferSource returns the url and headers the data of a deal is fetched with
+func transferSource(tr Transfer) (*url.URL, http.Header, error) {
+	if tr.Type != "http" {
+		return nil, nil, xerrors.Errorf("transfer type %q is not supported, only http transfers are", tr.Type)
+	}
+
+	var req HttpRequest
+	if err := json.Unmarshal(tr.Params, &req); err != nil {
+		return nil, nil, xerrors.Errorf("decoding http transfer params: %w", err)
+	}
+	dataUrl, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("parsing transfer url: %w", err)
+	}
+	if dataUrl.Scheme != "http" && dataUrl.Scheme != "https" {
+		return nil, nil, xerrors.Errorf("transfer url must be an http or https url")
+	}
+
+	hdr := http.Header{}
+	for k, v := range req.Headers {
+		hdr.Set(k, v)
+	}
+	return dataUrl, hdr, nil
+}
+
+// checkPieceSize checks that the piece size of a proposal is valid, and that the transferred data fits in the
+// piece. Renewals have no transfer, their data is already held.
+func checkPieceSize(prop market.DealProposal, transferSize uint64, renewal bool) error {
+	if err := prop.PieceSize.Validate(); err != nil {
+		return xerrors.Errorf("invalid piece size: %w", err)
+	}
+	rawSize := abi.UnpaddedPieceSize(transferSize)
+	if !renewal && (rawSize == 0 || rawSize > prop.PieceSize.Unpadded()) {
+		return xerrors.Errorf("transfer size %d doesn't fit in piece of size %d", rawSize, prop.PieceSize)
+	}
+	return nil
+}
+
+// insertDeal records an accepted deal, and parks its piece with the transfer url as the data source
+func (s *Server) insertDeal(ctx context.Context, params *DealParams, maddr address.Address, dataUrl *url.URL, hdr http.Header, client string, limitID int64) error {
+	prop := params.ClientDealProposal.Proposal
+
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return err
+	}
+
+	row, err := newDealRow(params, mid, int64(params.Transfer.Size), limitID)
+	if err != nil {
+		return err
+	}
+	hdrJson, err := json.Marshal(hdr)
+	if err != nil {
+		return xerrors.Errorf("marshaling data headers: %w", err)
+	}
+
+	var clientStr *string
+	if client != "" {
+		clientStr = &client
+	}
+
+	comm, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		var pieceID int64
+		err = tx.QueryRow(`SELECT id FROM parked_pieces WHERE piece_cid = $1`, prop.PieceCID.String()).Scan(&pieceID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = tx.QueryRow(`INSERT INTO parked_pieces (piece_cid, piece_padded_size, piece_raw_size)
+				VALUES ($1, $2, $3) RETURNING id`, prop.PieceCID.String(), int64(prop.PieceSize), int64(params.Transfer.Size)).Scan(&pieceID)
+			if err != nil {
+				return false, xerrors.Errorf("inserting parked piece: %w", err)
+			}
+		} else if err != nil {
+			return false, xerrors.Errorf("checking existing parked piece: %w", err)
+		}
+
+		var refID int64
+		err = tx.QueryRow(`INSERT INTO parked_piece_refs (piece_id, data_url, data_headers, client, sp_id)
+			VALUES ($1, $2, $3, $4, $5) RETURNING ref_id`, pieceID, dataUrl.String(), hdrJson, clientStr, int64(mid)).Scan(&refID)
+		if err != nil {
+			return false, xerrors.Errorf("inserting parked piece ref: %w", err)
+		}
+
+		return row.insert(tx, &refID)
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return err
+	}
+	if !comm {
+		return xerrors.Errorf("deal with uuid %s already exists", params.DealUUID)
+	}
+	return nil
+}
+
+// dealRow is an accepted deal as it's recorded in market_mk12_deals
+type dealRow struct {
+	uuid        string
+	spID        int64
+	client      string
+	proposal    []byte
+	proposalCid string
+	signature   []byte
+
+	pieceCid     string
+	pieceSize    int64
+	rawSize      int64
+	startEpoch   int64
+	endEpoch     int64
+	keepUnsealed bool
+
+	limitID *int64
+}
+
+func newDealRow(params *DealParams, mid uint64, rawSize int64, limitID int64) (*dealRow, error) {
+	prop := params.ClientDealProposal.Proposal
+
+	propJson, err := json.Marshal(prop)
+	if err != nil {
+		return nil, xerrors.Errorf("marshaling proposal: %w", err)
+	}
+	sig, err := params.ClientDealProposal.ClientSignature.MarshalBinary()
+	if err != nil {
+		return nil, xerrors.Errorf("marshaling client signature: %w", err)
+	}
+	nd, err := cborutil.AsIpld(&params.ClientDealProposal)
+	if err != nil {
+		return nil, xerrors.Errorf("computing signed proposal cid: %w", err)
+	}
+
+	var limit *int64
+	if limitID != 0 {
+		limit = &limitID
+	}
+
+	return &dealRow{
+		uuid:         params.DealUUID.String(),
+		spID:         int64(mid),
+		client:       prop.Client.String(),
+		proposal:     propJson,
+		proposalCid:  nd.Cid().String(),
+		signature:    sig,
+		pieceCid:     prop.PieceCID.String(),
+		pieceSize:    int64(prop.PieceSize),
+		rawSize:      rawSize,
+		startEpoch:   int64(prop.StartEpoch),
+		endEpoch:     int64(prop.EndEpoch),
+		keepUnsealed: !params.RemoveUnsealedCopy,
+		limitID:      limit,
+	}, nil
+}
+
+// insert adds the deal with its piece ref, which is nil when the data isn't parked yet. It returns false when a deal
+// with the same uuid exists.
+func (d *dealRow) insert(tx *harmonydb.Tx, pieceRef *int64) (bool, error) {
+	n, err := tx.Exec(`INSERT INTO market_mk12_deals (uuid, sp_id, client, proposal, proposal_cid, client_signature,
+			piece_cid, piece_size, raw_size, start_epoch, end_epoch, keep_unsealed, piece_ref, limit_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) ON CONFLICT (uuid) DO NOTHING`,
+		d.uuid, d.spID, d.client, d.proposal, d.proposalCid, d.signature,
+		d.pieceCid, d.pieceSize, d.rawSize, d.startEpoch, d.endEpoch, d.keepUnsealed, pieceRef, d.limitID)
+	if err != nil {
+		return false, xerrors.Errorf("inserting deal: %w", err)
+	}
+	return n == 1, nil
+}
@@ -0,0 +1,119 @@
+package mk12
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/lotus/lib/sigs"
+)
+
+func httpTransfer(t *testing.T, req HttpRequest) Transfer {
+	params, err := json.Marshal(req)
+	require.NoError(t, err)
+	return Transfer{Type: "http", Params: params, Size: 1000}
+}
+
+func TestTransferSource(t *testing.T) {
+	u, hdr, err := transferSource(httpTransfer(t, HttpRequest{
+		URL:     "https://client.example/data.car",
+		Headers: map[string]string{"authorization": "Bearer x"},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "https://client.example/data.car", u.String())
+	require.Equal(t, "Bearer x", hdr.Get("Authorization"))
+
+	cases := []struct {
+		name string
+		tr   Transfer
+		err  string
+	}{
+		{"libp2p transfer", Transfer{Type: "libp2p"}, `transfer type "libp2p" is not supported`},
+		{"bad params", Transfer{Type: "http", Params: []byte("{")}, "decoding http transfer params"},
+		{"bad url", httpTransfer(t, HttpRequest{URL: "http://[::1"}), "parsing transfer url"},
+		{"file url", httpTransfer(t, HttpRequest{URL: "file:///etc/passwd"}), "must be an http or https url"},
+		{"no scheme", httpTransfer(t, HttpRequest{URL: "client.example/data.car"}), "must be an http or https url"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, _, err := transferSource(c.tr)
+			require.ErrorContains(t, err, c.err)
+		})
+	}
+}
+
+func TestCheckPieceSize(t *testing.T) {
+	prop := func(size abi.PaddedPieceSize) market.DealProposal {
+		return market.DealProposal{PieceSize: size}
+	}
+
+	cases := []struct {
+		name     string
+		prop     market.DealProposal
+		transfer uint64
+		renewal  bool
+		err      string
+	}{
+		{"fits", prop(2048), 2000, false, ""},
+		{"full piece", prop(2048), 2032, false, ""},
+		{"too big", prop(2048), 2033, false, "doesn't fit in piece of size 2048"},
+		{"empty", prop(2048), 0, false, "doesn't fit in piece of size 2048"},
+		{"not a power of two", prop(3000), 1000, false, "invalid piece size"},
+		{"too small", prop(64), 10, false, "invalid piece size"},
+		{"renewal without transfer", prop(2048), 0, true, ""},
+		{"invalid renewal", prop(3000), 0, true, "invalid piece size"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkPieceSize(c.prop, c.transfer, c.renewal)
+			if c.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, c.err)
+		})
+	}
+}
+
+func testKey(t *testing.T) ([]byte, address.Address) {
+	pk, err := sigs.Generate(crypto.SigTypeSecp256k1)
+	require.NoError(t, err)
+	pub, err := sigs.ToPublic(crypto.SigTypeSecp256k1, pk)
+	require.NoError(t, err)
+	addr, err := address.NewSecp256k1Address(pub)
+	require.NoError(t, err)
+	return pk, addr
+}
+
+func TestCheckStatusSignature(t *testing.T) {
+	clientPk, client := testKey(t)
+	otherPk, _ := testKey(t)
+
+	id := uuid.New()
+	signed := func(pk []byte, msg []byte) *DealStatusRequest {
+		sig, err := sigs.Sign(crypto.SigTypeSecp256k1, pk, msg)
+		require.NoError(t, err)
+		return &DealStatusRequest{DealUUID: id, Signature: *sig}
+	}
+
+	require.NoError(t, checkStatusSignature(signed(clientPk, id[:]), client))
+
+	// signed by someone else than the client
+	require.Error(t, checkStatusSignature(signed(otherPk, id[:]), client))
+
+	// a signature over another deal doesn't give the status of this one
+	other := uuid.New()
+	require.Error(t, checkStatusSignature(signed(clientPk, other[:]), client))
+
+	// the uuid string isn't what is signed
+	require.Error(t, checkStatusSignature(signed(clientPk, []byte(id.String())), client))
+
+	require.Error(t, checkStatusSignature(&DealStatusRequest{DealUUID: id}, client))
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	gen "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/curio/market/mk12"
+)
+
+func main() {
+	err := gen.WriteMapEncodersToFile("./cbor_gen.go", "mk12",
+		mk12.DealParams{},
+		mk12.Transfer{},
+		mk12.DealResponse{},
+		mk12.DealStatusRequest{},
+		mk12.DealStatusResponse{},
+		mk12.DealStatus{},
+	)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,93 @@
+package mk12
+
+import (
+	"bytes"
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v9/miner"
+
+	"github.com/filecoin-project/curio/tasks/message"
+
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// publishPeerInfo sets the peer ID and multiaddresses of the miners on chain to those of the listener when they
+// differ. Messages are sent from the worker address, which is the only address allowed to change them.
+func (s *Server) publishPeerInfo(ctx context.Context, sender *message.Sender) error {
+	var addrs []abi.Multiaddrs
+	for _, a := range s.host.Addrs() {
+		addrs = append(addrs, a.Bytes())
+	}
+
+	for maddr := range s.miners {
+		mi, err := s.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info of %s: %w", maddr, err)
+		}
+
+		if mi.PeerId == nil || *mi.PeerId != s.host.ID() {
+			params, err := actors.SerializeParams(&miner.ChangePeerIDParams{NewID: abi.PeerID(s.host.ID())})
+			if err != nil {
+				return xerrors.Errorf("serializing params: %w", err)
+			}
+			if err := s.sendMinerMessage(ctx, sender, maddr, mi.Worker, builtin.MethodsMiner.ChangePeerID, params, "change-peer-id"); err != nil {
+				return xerrors.Errorf("changing peer id of %s: %w", maddr, err)
+			}
+		}
+
+		if !sameAddrs(mi.Multiaddrs, addrs) {
+			params, err := actors.SerializeParams(&miner.ChangeMultiaddrsParams{NewMultiaddrs: addrs})
+			if err != nil {
+				return xerrors.Errorf("serializing params: %w", err)
+			}
+			if err := s.sendMinerMessage(ctx, sender, maddr, mi.Worker, builtin.MethodsMiner.ChangeMultiaddrs, params, "change-multiaddrs"); err != nil {
+				return xerrors.Errorf("changing multiaddrs of %s: %w", maddr, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) sendMinerMessage(ctx context.Context, sender *message.Sender, maddr, from address.Address, method abi.MethodNum, params []byte, reason string) error {
+	msg := &types.Message{
+		To:     maddr,
+		From:   from,
+		Value:  big.Zero(),
+		Method: method,
+		Params: params,
+	}
+
+	mcid, err := sender.Send(ctx, msg, &lapi.MessageSendSpec{MaxFee: abi.TokenAmount(s.cfg.Fees.DefaultMaxFee)}, reason)
+	if err != nil {
+		return xerrors.Errorf("sending message: %w", err)
+	}
+
+	_, err = s.db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid) VALUES ($1)`, mcid)
+	if err != nil {
+		return xerrors.Errorf("inserting into message_waits: %w", err)
+	}
+
+	log.Infow("sent peer info message", "miner", maddr, "reason", reason, "cid", mcid)
+	return nil
+}
+
+func sameAddrs(a, b []abi.Multiaddrs) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
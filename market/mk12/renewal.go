@@ -0,0 +1,292 @@
+package mk12
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Renewal states
+const (
+	// RenewalOffered is a renewal offered to the client of a deal which is about to end
+	RenewalOffered = "offered"
+	// RenewalAccepted is a renewal the client proposed a deal for
+	RenewalAccepted = "accepted"
+	// RenewalDeclined is an offer withdrawn by the provider
+	RenewalDeclined = "declined"
+	// RenewalExpired is an offer which wasn't accepted before the deal ended
+	RenewalExpired = "expired"
+)
+
+// Renewal is a renewal offer of a deal, or a renewal deal proposed by a client
+type Renewal struct {
+	ID        int64  `db:"id"`
+	SpID      int64  `db:"sp_id"`
+	Client    string `db:"client"`
+	PieceCID  string `db:"piece_cid"`
+	PieceSize int64  `db:"piece_size"`
+
+	DealID       *int64 `db:"deal_id"`
+	SectorNum    *int64 `db:"sector_num"`
+	DealEndEpoch *int64 `db:"deal_end_epoch"`
+
+	// Offer is the unsigned market.DealProposal offered to the client
+	Offer           json.RawMessage `db:"offer"`
+	OfferStartEpoch *int64          `db:"offer_start_epoch"`
+
+	State      string     `db:"state"`
+	DealUUID   *string    `db:"deal_uuid"`
+	NeedsPark  bool       `db:"needs_park"`
+	FoundAt    time.Time  `db:"found_at"`
+	AcceptedAt *time.Time `db:"accepted_at"`
+
+	// progress of the renewal deal
+	RenewalDealID *int64  `db:"renewal_deal_id"`
+	RenewalSector *int64  `db:"renewal_sector"`
+	RenewalError  *string `db:"renewal_error"`
+}
+
+// Renewals lists the renewal offers and accepted renewals of a miner.
+func Renewals(ctx context.Context, db *harmonydb.DB, maddr address.Address) ([]Renewal, error) {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner id: %w", err)
+	}
+
+	var out []Renewal
+	err = db.Select(ctx, &out, `SELECT r.id, r.sp_id, r.client, r.piece_cid, r.piece_size, r.deal_id, r.sector_num, r.deal_end_epoch,
+			r.offer, r.offer_start_epoch, r.state, r.deal_uuid, r.needs_park, r.found_at, r.accepted_at,
+			d.deal_id AS renewal_deal_id, d.sector_number AS renewal_sector, d.error AS renewal_error
+		FROM market_deal_renewals r
+			LEFT JOIN market_mk12_deals d ON d.uuid = r.deal_uuid
+		WHERE r.sp_id = $1 ORDER BY r.deal_end_epoch NULLS LAST, r.id`, int64(mid))
+	if err != nil {
+		return nil, xerrors.Errorf("getting deal renewals: %w", err)
+	}
+	return out, nil
+}
+
+// DeclineRenewal withdraws a renewal offer. Renewal deals the client proposes for the piece afterwards are rejected.
+func DeclineRenewal(ctx context.Context, db *harmonydb.DB, id int64) error {
+	n, err := db.Exec(ctx, `UPDATE market_deal_renewals SET state = 'declined' WHERE id = $1 AND state = 'offered'`, id)
+	if err != nil {
+		return xerrors.Errorf("declining renewal: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("no open renewal offer with id %d", id)
+	}
+	return nil
+}
+
+// heldPiece is the data of a piece the provider already holds
+type heldPiece struct {
+	RawSize int64
+	// ParkedID is the parked piece holding the data, nil when the data is in an unsealed sector copy and has to be
+	// parked from it
+	ParkedID *int64
+}
+
+// heldPiece returns the data of a piece held by the provider, or nil when the provider doesn't hold it
+func (s *Server) heldPiece(ctx context.Context, maddr address.Address, pieceCID cid.Cid, size abi.PaddedPieceSize) (*heldPiece, error) {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var parked []struct {
+		ID      int64 `db:"id"`
+		RawSize int64 `db:"piece_raw_size"`
+	}
+	err = s.db.Select(ctx, &parked, `SELECT id, piece_raw_size FROM parked_pieces
+		WHERE piece_cid = $1 AND piece_padded_size = $2 AND complete = TRUE`, pieceCID.String(), int64(size))
+	if err != nil {
+		return nil, xerrors.Errorf("getting parked piece: %w", err)
+	}
+	if len(parked) > 0 {
+		return &heldPiece{RawSize: parked[0].RawSize, ParkedID: &parked[0].ID}, nil
+	}
+
+	var unsealed bool
+	err = s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sectors_meta_pieces p
+			JOIN sector_location sl ON sl.miner_id = p.sp_id AND sl.sector_num = p.sector_num AND sl.sector_filetype = 1 -- 1 is unsealed
+		WHERE p.sp_id = $1 AND p.piece_cid = $2 AND p.piece_size = $3)`, int64(mid), pieceCID.String(), int64(size)).Scan(&unsealed)
+	if err != nil {
+		return nil, xerrors.Errorf("checking unsealed sector copies: %w", err)
+	}
+	if !unsealed {
+		return nil, nil
+	}
+
+	// the piece is parked from the sector with its zero padding
+	return &heldPiece{RawSize: int64(size.Unpadded())}, nil
+}
+
+// renewalMatch is what a renewal deal renews: an open offer, or an expiring deal of the client which wasn't offered
+// yet
+type renewalMatch struct {
+	client address.Address
+
+	// offerID is the renewal offer accepted by the deal, 0 when the expiring deal wasn't offered
+	offerID      int64
+	dealID       int64
+	sectorNum    int64
+	dealEndEpoch int64
+}
+
+// matchRenewal finds the offer or the expiring deal a renewal deal of a client renews. When there is none, it returns
+// the reason the renewal is rejected. Renewals of pieces whose offer was declined are rejected.
+func (s *Server) matchRenewal(ctx context.Context, maddr, client address.Address, prop market.DealProposal, height abi.ChainEpoch) (*renewalMatch, string, error) {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if client == address.Undef {
+		client, err = s.api.StateLookupID(ctx, prop.Client, types.EmptyTSK)
+		if err != nil {
+			return nil, "", xerrors.Errorf("looking up client %s: %w", prop.Client, err)
+		}
+	}
+
+	var offers []struct {
+		ID     int64  `db:"id"`
+		DealID *int64 `db:"deal_id"`
+		State  string `db:"state"`
+	}
+	err = s.db.Select(ctx, &offers, `SELECT id, deal_id, state FROM market_deal_renewals
+		WHERE sp_id = $1 AND client = $2 AND piece_cid = $3 AND state IN ('offered', 'declined')
+		ORDER BY state = 'offered' DESC, deal_end_epoch`, int64(mid), client.String(), prop.PieceCID.String())
+	if err != nil {
+		return nil, "", xerrors.Errorf("getting renewal offers: %w", err)
+	}
+	if len(offers) > 0 {
+		if offers[0].State != RenewalOffered {
+			return nil, fmt.Sprintf("renewal of piece %s was declined by the provider", prop.PieceCID), nil
+		}
+		return &renewalMatch{client: client, offerID: offers[0].ID}, "", nil
+	}
+
+	// deals ending within the renewal window which weren't offered yet, or whose offer expired
+	window := abi.ChainEpoch(time.Duration(s.cfg.Ingest.Libp2p.Renewal.Window) / (builtin.EpochDurationSeconds * time.Second))
+	var deals []struct {
+		SectorNum int64           `db:"sector_num"`
+		DealID    int64           `db:"f05_deal_id"`
+		Proposal  json.RawMessage `db:"f05_deal_proposal"`
+		EndEpoch  int64           `db:"orig_end_epoch"`
+	}
+	err = s.db.Select(ctx, &deals, `SELECT p.sector_num, p.f05_deal_id, p.f05_deal_proposal, p.orig_end_epoch
+		FROM sectors_meta_pieces p
+		WHERE p.sp_id = $1 AND p.piece_cid = $2 AND p.piece_size = $3
+			AND p.f05_deal_id IS NOT NULL AND p.f05_deal_proposal IS NOT NULL
+			AND p.orig_end_epoch > $4 AND p.orig_end_epoch <= $5
+			AND NOT EXISTS (SELECT 1 FROM market_deal_renewals r WHERE r.sp_id = p.sp_id AND r.deal_id = p.f05_deal_id)
+		ORDER BY p.orig_end_epoch, p.f05_deal_id`,
+		int64(mid), prop.PieceCID.String(), int64(prop.PieceSize), int64(height), int64(height+window))
+	if err != nil {
+		return nil, "", xerrors.Errorf("getting expiring deals: %w", err)
+	}
+
+	for _, d := range deals {
+		var orig market.DealProposal
+		if err := json.Unmarshal(d.Proposal, &orig); err != nil {
+			log.Warnw("unmarshaling proposal of expiring deal", "miner", maddr, "deal", d.DealID, "error", err)
+			continue
+		}
+
+		origClient, err := s.api.StateLookupID(ctx, orig.Client, types.EmptyTSK)
+		if err != nil {
+			return nil, "", xerrors.Errorf("looking up client %s: %w", orig.Client, err)
+		}
+		if origClient != client {
+			continue
+		}
+
+		return &renewalMatch{
+			client:       client,
+			dealID:       d.DealID,
+			sectorNum:    d.SectorNum,
+			dealEndEpoch: d.EndEpoch,
+		}, "", nil
+	}
+
+	return nil, fmt.Sprintf("no renewal offer or expiring deal of piece %s for client %s", prop.PieceCID, client), nil
+}
+
+// insertRenewal records an accepted renewal deal for a held piece, in place of the offer or for the expiring deal it
+// renews.
+func (s *Server) insertRenewal(ctx context.Context, params *DealParams, maddr address.Address, held *heldPiece, match *renewalMatch, limitID int64) error {
+	prop := params.ClientDealProposal.Proposal
+
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return err
+	}
+
+	row, err := newDealRow(params, mid, held.RawSize, limitID)
+	if err != nil {
+		return err
+	}
+
+	client := match.client.String()
+
+	comm, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		var ref *int64
+		if held.ParkedID != nil {
+			var refID int64
+			err = tx.QueryRow(`INSERT INTO parked_piece_refs (piece_id, data_url, client, sp_id)
+				VALUES ($1, NULL, $2, $3) RETURNING ref_id`, *held.ParkedID, client, int64(mid)).Scan(&refID)
+			if err != nil {
+				return false, xerrors.Errorf("inserting parked piece ref: %w", err)
+			}
+			ref = &refID
+		}
+
+		ok, err := row.insert(tx, ref)
+		if err != nil || !ok {
+			return false, err
+		}
+
+		needsPark := held.ParkedID == nil
+		if match.offerID != 0 {
+			n, err := tx.Exec(`UPDATE market_deal_renewals SET state = 'accepted', deal_uuid = $2, needs_park = $3, accepted_at = current_timestamp
+				WHERE id = $1 AND state = 'offered'`, match.offerID, row.uuid, needsPark)
+			if err != nil {
+				return false, xerrors.Errorf("accepting renewal offer: %w", err)
+			}
+			if n == 0 {
+				return false, xerrors.Errorf("renewal offer %d was withdrawn", match.offerID)
+			}
+			return true, nil
+		}
+
+		_, err = tx.Exec(`INSERT INTO market_deal_renewals (sp_id, client, piece_cid, piece_size, deal_id, sector_num, deal_end_epoch,
+				state, deal_uuid, needs_park, accepted_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, 'accepted', $8, $9, current_timestamp)`,
+			int64(mid), client, prop.PieceCID.String(), int64(prop.PieceSize), match.dealID, match.sectorNum, match.dealEndEpoch,
+			row.uuid, needsPark)
+		if err != nil {
+			return false, xerrors.Errorf("inserting renewal: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return err
+	}
+	if !comm {
+		return xerrors.Errorf("deal with uuid %s already exists", params.DealUUID)
+	}
+	return nil
+}
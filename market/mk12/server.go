@@ -0,0 +1,254 @@
+// Package mk12 serves the storage deal protocols clients use to make deals with storage providers over libp2p,
+// compatible with Boost. Accepted deals are recorded in market_mk12_deals with a parked piece ref for their data,
+// and published and added to sectors by the tasks in tasks/mk12.
+package mk12
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/yugabyte/pgx/v5"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/curio/api"
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/market/clientlimit"
+	"github.com/filecoin-project/curio/market/dealfilter"
+	"github.com/filecoin-project/curio/tasks/message"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var log = logging.Logger("mk12")
+
+// Protocols served by the listener. Both deal protocol versions use DealParams, 1.2.1 only adds fields which are
+// ignored by Curio.
+const (
+	DealProtocolv120ID      = protocol.ID("/fil/storage/mk/1.2.0")
+	DealProtocolv121ID      = protocol.ID("/fil/storage/mk/1.2.1")
+	DealStatusV12ProtocolID = protocol.ID("/fil/storage/status/1.2.0")
+)
+
+const (
+	// streamReadTimeout limits how long a client can take to send its request
+	streamReadTimeout = 30 * time.Second
+	// streamWriteTimeout limits how long a client can take to read the response
+	streamWriteTimeout = 30 * time.Second
+	// dealCheckTimeout limits the checks of a deal proposal, including validation offloaded to DealValidate tasks
+	dealCheckTimeout = 5 * time.Minute
+)
+
+// Server takes storage deal proposals and status requests from clients over libp2p
+type Server struct {
+	db  *harmonydb.DB
+	api api.Chain
+	cfg *config.CurioConfig
+
+	df *dealfilter.DealFilter
+	cl *clientlimit.Limiter
+
+	// miners deals are accepted for, as ID addresses
+	miners map[address.Address]struct{}
+
+	host host.Host
+}
+
+// Start starts the deal protocol listener with the cluster libp2p identity. Deals are accepted for the miners
+// in Ingest.Libp2p.Miners, or for maddrs when it's empty. When Ingest.Libp2p.PublishPeerInfo is set the peer info of
+// the miners is updated on chain with messages sent by sender. The listener stops when ctx is done.
+func Start(ctx context.Context, db *harmonydb.DB, full api.Chain, cfg *config.CurioConfig, maddrs []address.Address, sender *message.Sender) (*Server, error) {
+	lcfg := cfg.Ingest.Libp2p
+
+	df, err := dealfilter.New(db, full, cfg.Ingest.DealFilter)
+	if err != nil {
+		return nil, xerrors.Errorf("creating deal filter: %w", err)
+	}
+	cl, err := clientlimit.New(db, full, cfg.Ingest.ClientLimits)
+	if err != nil {
+		return nil, xerrors.Errorf("creating client limiter: %w", err)
+	}
+
+	s := &Server{
+		db:     db,
+		api:    full,
+		cfg:    cfg,
+		df:     df,
+		cl:     cl,
+		miners: map[address.Address]struct{}{},
+	}
+
+	if len(lcfg.Miners) > 0 {
+		maddrs = nil
+		for _, m := range lcfg.Miners {
+			maddr, err := address.NewFromString(m)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing Ingest.Libp2p.Miners entry %q: %w", m, err)
+			}
+			maddrs = append(maddrs, maddr)
+		}
+	}
+	for _, maddr := range maddrs {
+		id, err := full.StateLookupID(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("looking up miner %s: %w", maddr, err)
+		}
+		s.miners[id] = struct{}{}
+	}
+	if len(s.miners) == 0 {
+		return nil, xerrors.Errorf("no miners to accept libp2p deals for")
+	}
+
+	key, err := clusterKey(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var announce []multiaddr.Multiaddr
+	for _, a := range lcfg.AnnounceAddresses {
+		ma, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing Ingest.Libp2p.AnnounceAddresses entry %q: %w", a, err)
+		}
+		announce = append(announce, ma)
+	}
+
+	opts := []libp2p.Option{
+		libp2p.Identity(key),
+		libp2p.ListenAddrStrings(lcfg.ListenAddresses...),
+	}
+	if len(announce) > 0 {
+		opts = append(opts, libp2p.AddrsFactory(func([]multiaddr.Multiaddr) []multiaddr.Multiaddr {
+			return announce
+		}))
+	}
+
+	s.host, err = libp2p.New(opts...)
+	if err != nil {
+		return nil, xerrors.Errorf("starting libp2p host: %w", err)
+	}
+
+	s.host.SetStreamHandler(DealProtocolv120ID, s.handleDealStream)
+	s.host.SetStreamHandler(DealProtocolv121ID, s.handleDealStream)
+	s.host.SetStreamHandler(DealStatusV12ProtocolID, s.handleStatusStream)
+
+	log.Infow("libp2p deal protocol listener started", "peer", s.host.ID(), "addrs", s.host.Addrs())
+
+	go func() {
+		<-ctx.Done()
+		if err := s.host.Close(); err != nil {
+			log.Warnw("closing libp2p host", "error", err)
+		}
+	}()
+
+	if lcfg.PublishPeerInfo {
+		go func() {
+			if err := s.publishPeerInfo(ctx, sender); err != nil {
+				log.Errorw("publishing peer info on chain", "error", err)
+			}
+		}()
+	}
+
+	return s, nil
+}
+
+// Miners returns the ID addresses of the miners deals are accepted for
+func (s *Server) Miners() []address.Address {
+	out := make([]address.Address, 0, len(s.miners))
+	for m := range s.miners {
+		out = append(out, m)
+	}
+	return out
+}
+
+// clusterKey returns the libp2p identity of the cluster, creating it when there is none yet
+func clusterKey(ctx context.Context, db *harmonydb.DB) (crypto.PrivKey, error) {
+	for {
+		var kb []byte
+		err := db.QueryRow(ctx, `SELECT priv_key FROM market_libp2p_key`).Scan(&kb)
+		if err == nil {
+			key, err := crypto.UnmarshalPrivateKey(kb)
+			if err != nil {
+				return nil, xerrors.Errorf("unmarshaling libp2p key: %w", err)
+			}
+			return key, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, xerrors.Errorf("getting libp2p key: %w", err)
+		}
+
+		key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			return nil, xerrors.Errorf("generating libp2p key: %w", err)
+		}
+		kb, err = crypto.MarshalPrivateKey(key)
+		if err != nil {
+			return nil, xerrors.Errorf("marshaling libp2p key: %w", err)
+		}
+
+		// another node may create the key at the same time, the key which is stored first is used
+		_, err = db.Exec(ctx, `INSERT INTO market_libp2p_key (priv_key) VALUES ($1) ON CONFLICT DO NOTHING`, kb)
+		if err != nil {
+			return nil, xerrors.Errorf("storing libp2p key: %w", err)
+		}
+	}
+}
+
+func (s *Server) handleDealStream(stream network.Stream) {
+	defer stream.Close() // nolint:errcheck
+
+	_ = stream.SetReadDeadline(time.Now().Add(streamReadTimeout))
+	var params DealParams
+	if err := cborutil.ReadCborRPC(stream, &params); err != nil {
+		log.Warnw("reading deal proposal", "peer", stream.Conn().RemotePeer(), "error", err)
+		return
+	}
+	_ = stream.SetReadDeadline(time.Time{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), dealCheckTimeout)
+	defer cancel()
+
+	res := s.processDeal(ctx, &params)
+	if !res.Accepted {
+		log.Infow("deal proposal rejected", "uuid", params.DealUUID, "peer", stream.Conn().RemotePeer(), "reason", res.Message)
+	}
+
+	_ = stream.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if err := cborutil.WriteCborRPC(stream, &res); err != nil {
+		log.Warnw("writing deal response", "uuid", params.DealUUID, "error", err)
+	}
+}
+
+func (s *Server) handleStatusStream(stream network.Stream) {
+	defer stream.Close() // nolint:errcheck
+
+	_ = stream.SetReadDeadline(time.Now().Add(streamReadTimeout))
+	var req DealStatusRequest
+	if err := cborutil.ReadCborRPC(stream, &req); err != nil {
+		log.Warnw("reading deal status request", "peer", stream.Conn().RemotePeer(), "error", err)
+		return
+	}
+	_ = stream.SetReadDeadline(time.Time{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), streamReadTimeout)
+	defer cancel()
+
+	res := s.dealStatus(ctx, &req)
+
+	_ = stream.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if err := cborutil.WriteCborRPC(stream, &res); err != nil {
+		log.Warnw("writing deal status response", "uuid", req.DealUUID, "error", err)
+	}
+}
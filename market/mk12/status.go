@@ -0,0 +1,123 @@
+package mk12
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/bls"
+	_ "github.com/filecoin-project/lotus/lib/sigs/delegated"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp"
+)
+
+// dealStatus answers a deal status request. Only the client of the deal can ask for its status.
+func (s *Server) dealStatus(ctx context.Context, req *DealStatusRequest) DealStatusResponse {
+	res := DealStatusResponse{DealUUID: req.DealUUID}
+
+	var deals []struct {
+		Client       string  `db:"client"`
+		Proposal     []byte  `db:"proposal"`
+		ProposalCid  string  `db:"proposal_cid"`
+		RawSize      int64   `db:"raw_size"`
+		Transferred  bool    `db:"transferred"`
+		PublishCid   *string `db:"publish_cid"`
+		DealID       *int64  `db:"deal_id"`
+		SectorNumber *int64  `db:"sector_number"`
+		Error        *string `db:"error"`
+	}
+	err := s.db.Select(ctx, &deals, `SELECT d.client, d.proposal, d.proposal_cid, d.raw_size, COALESCE(pp.complete, FALSE) AS transferred,
+			d.publish_cid, d.deal_id, d.sector_number, d.error
+		FROM market_mk12_deals d
+		LEFT JOIN parked_piece_refs r ON r.ref_id = d.piece_ref
+		LEFT JOIN parked_pieces pp ON pp.id = r.piece_id
+		WHERE d.uuid = $1`, req.DealUUID.String())
+	if err != nil {
+		log.Errorw("getting deal status", "uuid", req.DealUUID, "error", err)
+		res.Error = "internal error"
+		return res
+	}
+	if len(deals) == 0 {
+		res.Error = fmt.Sprintf("deal %s not found", req.DealUUID)
+		return res
+	}
+	d := deals[0]
+
+	client, err := address.NewFromString(d.Client)
+	if err != nil {
+		log.Errorw("parsing deal client", "uuid", req.DealUUID, "error", err)
+		res.Error = "internal error"
+		return res
+	}
+	key, err := s.api.StateAccountKey(ctx, client, types.EmptyTSK)
+	if err != nil {
+		log.Errorw("getting client key", "uuid", req.DealUUID, "client", client, "error", err)
+		res.Error = "internal error"
+		return res
+	}
+	if err := checkStatusSignature(req, key); err != nil {
+		res.Error = "request signature doesn't match the deal client"
+		return res
+	}
+
+	var prop market.DealProposal
+	if err := json.Unmarshal(d.Proposal, &prop); err != nil {
+		log.Errorw("unmarshaling deal proposal", "uuid", req.DealUUID, "error", err)
+		res.Error = "internal error"
+		return res
+	}
+	propCid, err := cid.Parse(d.ProposalCid)
+	if err != nil {
+		log.Errorw("parsing proposal cid", "uuid", req.DealUUID, "error", err)
+		res.Error = "internal error"
+		return res
+	}
+
+	st := &DealStatus{
+		Status:            StatusAccepted,
+		Proposal:          prop,
+		SignedProposalCid: propCid,
+	}
+
+	res.TransferSize = uint64(d.RawSize)
+	if d.Transferred || d.PublishCid != nil {
+		res.NBytesReceived = uint64(d.RawSize)
+		st.Status = StatusTransferred
+	}
+	if d.PublishCid != nil {
+		pc, err := cid.Parse(*d.PublishCid)
+		if err != nil {
+			log.Errorw("parsing publish cid", "uuid", req.DealUUID, "error", err)
+			res.Error = "internal error"
+			return res
+		}
+		st.PublishCid = &pc
+		st.Status = StatusPublished
+	}
+	if d.DealID != nil {
+		st.ChainDealID = abi.DealID(*d.DealID)
+		st.Status = StatusPublishConfirmed
+	}
+	if d.SectorNumber != nil {
+		st.Status = StatusAddedPiece
+		st.SealingStatus = fmt.Sprintf("added to sector %d", *d.SectorNumber)
+	}
+	if d.Error != nil {
+		st.Error = *d.Error
+	}
+
+	res.DealStatus = st
+	return res
+}
+
+// checkStatusSignature checks that a status request is signed over the deal uuid by the key of the deal client
+func checkStatusSignature(req *DealStatusRequest, clientKey address.Address) error {
+	return sigs.Verify(&req.Signature, clientKey, req.DealUUID[:])
+}
@@ -0,0 +1,94 @@
+package mk12
+
+import (
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/filecoin-project/go-state-types/crypto"
+)
+
+//go:generate go run ./gen
+
+// The types below are the messages of the storage deal protocols, compatible with the protocols served by Boost.
+// They are encoded as CBOR maps, so messages of newer protocol versions with additional fields decode as well.
+
+// DealParams is the deal proposal sent by a client on the deal protocol
+type DealParams struct {
+	DealUUID           uuid.UUID
+	IsOffline          bool
+	ClientDealProposal market.ClientDealProposal
+	DealDataRoot       cid.Cid
+	Transfer           Transfer // zero value for offline deals
+	RemoveUnsealedCopy bool
+	SkipIPNIAnnounce   bool
+}
+
+// Transfer is how the deal data is fetched from the client
+type Transfer struct {
+	// Type is the transfer protocol, only "http" is supported
+	Type string
+	// ClientID is an identifier the client can use to match transfers to its deals
+	ClientID string
+	// Params are the protocol parameters, a JSON encoded HttpRequest for http transfers
+	Params []byte
+	// Size is the size of the data in bytes
+	Size uint64
+}
+
+// HttpRequest are the parameters of http transfers
+type HttpRequest struct {
+	URL     string
+	Headers map[string]string
+}
+
+// DealResponse is the answer to a deal proposal
+type DealResponse struct {
+	Accepted bool
+	// Message is the reason the deal was rejected
+	Message string
+}
+
+// DealStatusRequest asks for the status of a deal, signed by the client of the deal over the deal UUID
+type DealStatusRequest struct {
+	DealUUID  uuid.UUID
+	Signature crypto.Signature
+}
+
+// DealStatusResponse is the answer to a deal status request
+type DealStatusResponse struct {
+	DealUUID uuid.UUID
+	// Error is set when the status couldn't be returned, e.g. because the deal is unknown
+	Error      string
+	DealStatus *DealStatus
+
+	IsOffline      bool
+	TransferSize   uint64
+	NBytesReceived uint64
+}
+
+// DealStatus is the status of a deal
+type DealStatus struct {
+	// Error is set when the deal failed
+	Error string
+	// Status is the last checkpoint the deal reached, one of the Status* constants
+	Status string
+	// SealingStatus describes the progress of the sector holding the deal
+	SealingStatus string
+
+	Proposal          market.DealProposal
+	SignedProposalCid cid.Cid
+
+	PublishCid  *cid.Cid
+	ChainDealID abi.DealID
+}
+
+// Deal checkpoints reported in DealStatus.Status, named as reported by Boost
+const (
+	StatusAccepted         = "Accepted"
+	StatusTransferred      = "Transferred"
+	StatusPublished        = "Published"
+	StatusPublishConfirmed = "PublishConfirmed"
+	StatusAddedPiece       = "AddedPiece"
+)
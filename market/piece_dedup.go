@@ -0,0 +1,61 @@
+package market
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/ipfs/go-cid"
+	"github.com/yugabyte/pgx/v5"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+// parkedPieceSource returns a pieceref source for a deal piece when the piece is already parked, so that the data
+// which is stored once is placed in every sector needing it instead of being downloaded for each deal. The original
+// source is kept as the data URL of the new ref. refID is 0 when the source wasn't replaced.
+func parkedPieceSource(ctx context.Context, db *harmonydb.DB, pieceCID cid.Cid, source url.URL, header http.Header) (url.URL, http.Header, int64, error) {
+	if source.Scheme == "pieceref" {
+		return source, header, 0, nil
+	}
+
+	hdrJson, err := json.Marshal(header)
+	if err != nil {
+		return source, header, 0, xerrors.Errorf("json.Marshal(header): %w", err)
+	}
+	if header == nil {
+		hdrJson = []byte("{}")
+	}
+
+	var refID int64
+	err = db.QueryRow(ctx, `INSERT INTO parked_piece_refs (piece_id, data_url, data_headers)
+		SELECT id, $2, $3 FROM parked_pieces WHERE piece_cid = $1 AND complete = TRUE
+		RETURNING ref_id`, pieceCID.String(), source.String(), hdrJson).Scan(&refID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return source, header, 0, nil
+	}
+	if err != nil {
+		return source, header, 0, xerrors.Errorf("adding parked piece ref: %w", err)
+	}
+
+	log.Infow("deal piece is already parked, using parked data", "piece_cid", pieceCID, "ref", refID)
+
+	return url.URL{
+		Scheme: "pieceref",
+		Opaque: fmt.Sprintf("%d", refID),
+	}, nil, refID, nil
+}
+
+// dropPieceRef removes a ref added by parkedPieceSource for a piece which wasn't added to a sector.
+func dropPieceRef(ctx context.Context, db *harmonydb.DB, refID int64) {
+	if refID == 0 {
+		return
+	}
+	if _, err := db.Exec(ctx, `DELETE FROM parked_piece_refs WHERE ref_id = $1`, refID); err != nil {
+		log.Errorw("removing parked piece ref", "ref", refID, "error", err)
+	}
+}
@@ -0,0 +1,239 @@
+package market
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-cid"
+	"github.com/yugabyte/pgx/v5"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc/auth"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/dealdata"
+	"github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/piece"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/lib/nullreader"
+)
+
+// PiecePushResult is the response to a piece push
+type PiecePushResult struct {
+	PieceID int64
+	// Uploaded is false when the piece was already parked, and the pushed data wasn't stored
+	Uploaded bool
+	// LinkedDeals is the number of pending deal pieces which now use the pushed data
+	LinkedDeals int
+	// HeldUntil is when piece GC may remove the piece if no deal references it by then
+	HeldUntil time.Time
+}
+
+// PushedPieceHold is how long pushed pieces are kept without a deal referencing them, so that data can be pushed
+// before the deal using it is made.
+const PushedPieceHold = 24 * time.Hour
+
+// PiecePushHandler accepts deal data pushed by clients:
+//
+//	PUT /market/piece/{cid}?size=<padded piece size>
+//
+// The body is the piece data without fr32 padding, at most the unpadded piece size, and must have a Content-Length.
+// The data is zero-padded to the piece size and checked against the piece CID while it's written to storage. It is
+// then parked and linked to the pending deal pieces with that CID which still fetch their data from an URL.
+//
+// Deals made later for the piece use the parked data too. Piece GC doesn't remove a pushed piece for
+// PushedPieceHold after the push, after that it is removed like any other piece no deal references.
+func PiecePushHandler(db *harmonydb.DB, sc *ffi.SealCalls) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auth.HasPerm(r.Context(), nil, api.PermWrite) {
+			http.Error(w, "unauthorized: missing write permission", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPut {
+			http.Error(w, "bad method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		pieceCID, err := cid.Parse(mux.Vars(r)["cid"])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad piece cid: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		size, err := strconv.ParseUint(r.URL.Query().Get("size"), 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad piece size: %s", err), http.StatusBadRequest)
+			return
+		}
+		psize := abi.PaddedPieceSize(size)
+		if err := psize.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("bad piece size: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		if r.ContentLength < 0 {
+			http.Error(w, "content length required", http.StatusLengthRequired)
+			return
+		}
+		if r.ContentLength > int64(psize.Unpadded()) {
+			http.Error(w, fmt.Sprintf("data larger than the piece, max %d bytes", psize.Unpadded()), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		start := time.Now()
+
+		res, err := pushPiece(r.Context(), db, sc, pieceCID, psize, r.ContentLength, r.Body, PushedPieceHold)
+		if err != nil {
+			log.Errorw("piece push failed", "piece", pieceCID, "from", r.RemoteAddr, "error", err)
+
+			status := http.StatusInternalServerError
+			if errors.Is(err, dealdata.ErrPieceCIDMismatch) {
+				status = http.StatusBadRequest
+			} else if errors.Is(err, errPieceParking) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		log.Infow("piece pushed", "piece", pieceCID, "from", r.RemoteAddr, "id", res.PieceID, "uploaded", res.Uploaded,
+			"linked", res.LinkedDeals, "held_until", res.HeldUntil, "took", time.Since(start))
+
+		w.Header().Set("Content-Type", "application/json")
+		if res.Uploaded {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+// ParkPieceData parks a piece from data, the piece without fr32 padding including its zero padding, unless the piece
+// is parked already. Pending deal pieces with the piece CID are linked to the parked piece like with pushed pieces.
+func ParkPieceData(ctx context.Context, db *harmonydb.DB, sc *ffi.SealCalls, pieceCID cid.Cid, psize abi.PaddedPieceSize, data io.Reader) (PiecePushResult, error) {
+	return pushPiece(ctx, db, sc, pieceCID, psize, int64(psize.Unpadded()), data, 0)
+}
+
+var errPieceParking = xerrors.New("piece is being parked from another source")
+
+// pushPiece parks the piece unless it is parked already and links pending deals to it. With a non-zero hold the piece
+// is held for that long, so that it isn't removed before a deal using it is made.
+func pushPiece(ctx context.Context, db *harmonydb.DB, sc *ffi.SealCalls, pieceCID cid.Cid, psize abi.PaddedPieceSize, dataSize int64, data io.Reader, hold time.Duration) (PiecePushResult, error) {
+	var res PiecePushResult
+	if hold > 0 {
+		res.HeldUntil = time.Now().Add(hold)
+	}
+
+	var complete bool
+	err := db.QueryRow(ctx, `SELECT id, complete FROM parked_pieces WHERE piece_cid = $1`, pieceCID.String()).Scan(&res.PieceID, &complete)
+	switch {
+	case err == nil && !complete:
+		return res, errPieceParking
+	case err == nil:
+		// already parked, only link pending deals
+		if hold > 0 {
+			_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+				if err := holdPushedPiece(tx, res.PieceID, res.HeldUntil); err != nil {
+					return false, err
+				}
+				return true, nil
+			}, harmonydb.OptionRetry())
+			if err != nil {
+				return res, err
+			}
+		}
+	case errors.Is(err, pgx.ErrNoRows):
+		res.PieceID, err = writePushedPiece(ctx, db, sc, pieceCID, psize, dataSize, data, res.HeldUntil)
+		if err != nil {
+			return res, err
+		}
+		res.Uploaded = true
+	default:
+		return res, xerrors.Errorf("checking parked pieces: %w", err)
+	}
+
+	res.LinkedDeals, err = piece.LinkParkedPiece(ctx, db, pieceCID, res.PieceID)
+	if err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+const pushHoldReason = "pushed, waiting for a deal"
+
+// holdPushedPiece holds a pushed piece until heldUntil, extending the hold of an earlier push of the piece if there is
+// one, so that repeated pushes don't pile up holds.
+func holdPushedPiece(tx *harmonydb.Tx, pieceID int64, heldUntil time.Time) error {
+	n, err := tx.Exec(`UPDATE parked_piece_holds SET hold_until = GREATEST(hold_until, $3)
+		WHERE piece_id = $1 AND reason = $2`, pieceID, pushHoldReason, heldUntil)
+	if err != nil {
+		return xerrors.Errorf("extending parked piece hold: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`INSERT INTO parked_piece_holds (piece_id, reason, hold_until) VALUES ($1, $2, $3)`, pieceID, pushHoldReason, heldUntil)
+	if err != nil {
+		return xerrors.Errorf("adding parked piece hold: %w", err)
+	}
+	return nil
+}
+
+// writePushedPiece writes the piece to storage under a new parked piece ID, and only then adds the parked piece, so
+// that ParkPiece never sees it incomplete. Unless heldUntil is zero the piece is added together with a hold.
+func writePushedPiece(ctx context.Context, db *harmonydb.DB, sc *ffi.SealCalls, pieceCID cid.Cid, psize abi.PaddedPieceSize, dataSize int64, data io.Reader, heldUntil time.Time) (int64, error) {
+	var pieceID int64
+	if err := db.QueryRow(ctx, `SELECT nextval('parked_pieces_id_seq')`).Scan(&pieceID); err != nil {
+		return 0, xerrors.Errorf("allocating parked piece id: %w", err)
+	}
+
+	rawSize := int64(psize.Unpadded())
+	padded := io.MultiReader(io.LimitReader(data, dataSize), nullreader.Reader{})
+	verified := dealdata.NewPieceVerifyReader(padded, rawSize, pieceCID, psize)
+
+	pnum := storiface.PieceNumber(pieceID)
+	if err := sc.WritePiece(ctx, nil, pnum, rawSize, verified); err != nil {
+		return 0, xerrors.Errorf("writing piece: %w", err)
+	}
+
+	var added bool
+	_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(`INSERT INTO parked_pieces (id, piece_cid, piece_padded_size, piece_raw_size, complete)
+			VALUES ($1, $2, $3, $4, TRUE)
+			ON CONFLICT (piece_cid) DO NOTHING`, pieceID, pieceCID.String(), int64(psize), rawSize)
+		if err != nil {
+			return false, xerrors.Errorf("adding parked piece: %w", err)
+		}
+		added = n > 0
+		if !added || heldUntil.IsZero() {
+			return added, nil
+		}
+
+		if err := holdPushedPiece(tx, pieceID, heldUntil); err != nil {
+			return false, err
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil || !added {
+		// the piece was added concurrently, or the insert failed
+		if rerr := sc.RemovePiece(ctx, pnum); rerr != nil {
+			log.Errorw("removing pushed piece", "piece", pieceCID, "id", pieceID, "error", rerr)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return 0, errPieceParking
+	}
+
+	return pieceID, nil
+}
@@ -0,0 +1,175 @@
+package retrieval
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// unixfsDirectory is the UnixFS data type of directories, see the Data message of the UnixFS spec
+const unixfsDirectory = 1
+
+var errNoLink = xerrors.New("no link with the name")
+
+// identityData returns the data of a CID with an identity multihash, which holds the block data itself
+func identityData(c cid.Cid) ([]byte, bool) {
+	if c.Prefix().MhType != multihash.IDENTITY {
+		return nil, false
+	}
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return nil, false
+	}
+	return dmh.Digest, true
+}
+
+func decodePB(data []byte) (dagpb.PBNode, error) {
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, data); err != nil {
+		return nil, xerrors.Errorf("decoding dag-pb block: %w", err)
+	}
+	return nb.Build().(dagpb.PBNode), nil
+}
+
+// blockLinks returns the links of a dag-pb or dag-cbor block in the order of the block. Blocks of other codecs are
+// treated as leaves.
+func blockLinks(c cid.Cid, data []byte) ([]cid.Cid, error) {
+	switch c.Prefix().Codec {
+	case cid.DagProtobuf:
+		pn, err := decodePB(data)
+		if err != nil {
+			return nil, err
+		}
+
+		var links []cid.Cid
+		for itr := pn.FieldLinks().Iterator(); !itr.Done(); {
+			_, l := itr.Next()
+			links = append(links, l.FieldHash().Link().(cidlink.Link).Cid)
+		}
+		return links, nil
+	case cid.DagCBOR:
+		nb := basicnode.Prototype.Any.NewBuilder()
+		if err := dagcbor.Decode(nb, bytes.NewReader(data)); err != nil {
+			return nil, xerrors.Errorf("decoding dag-cbor block: %w", err)
+		}
+
+		lnks, err := traversal.SelectLinks(nb.Build())
+		if err != nil {
+			return nil, xerrors.Errorf("getting dag-cbor links: %w", err)
+		}
+		links := make([]cid.Cid, 0, len(lnks))
+		for _, l := range lnks {
+			links = append(links, l.(cidlink.Link).Cid)
+		}
+		return links, nil
+	default:
+		return nil, nil
+	}
+}
+
+// namedLink returns the target of the link with the given name in a dag-pb block, as used by UnixFS directories
+func namedLink(c cid.Cid, data []byte, name string) (cid.Cid, error) {
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return cid.Undef, xerrors.Errorf("can't resolve path segment %q in a block with codec 0x%x", name, c.Prefix().Codec)
+	}
+
+	pn, err := decodePB(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	for itr := pn.FieldLinks().Iterator(); !itr.Done(); {
+		_, l := itr.Next()
+		if l.FieldName().Exists() && l.FieldName().Must().String() == name {
+			return l.FieldHash().Link().(cidlink.Link).Cid, nil
+		}
+	}
+	return cid.Undef, xerrors.Errorf("%q: %w", name, errNoLink)
+}
+
+// unixfsType returns the UnixFS data type of a dag-pb block, -1 for other blocks
+func unixfsType(c cid.Cid, data []byte) int {
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return -1
+	}
+	pn, err := decodePB(data)
+	if err != nil || !pn.FieldData().Exists() {
+		return -1
+	}
+
+	// the Type field is field 1 of the Data message, a varint
+	d := pn.FieldData().Must().Bytes()
+	if len(d) < 2 || d[0] != 0x08 {
+		return -1
+	}
+	t, n := binary.Uvarint(d[1:])
+	if n <= 0 {
+		return -1
+	}
+	return int(t)
+}
+
+// writeCarHeader writes a CARv1 header with a single root
+func writeCarHeader(w io.Writer, root cid.Cid) error {
+	var buf bytes.Buffer
+	hdr := cbg.NewCborWriter(&buf)
+
+	if err := hdr.WriteMajorTypeHeader(cbg.MajMap, 2); err != nil {
+		return err
+	}
+	if err := hdr.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("roots"))); err != nil {
+		return err
+	}
+	if _, err := hdr.WriteString("roots"); err != nil {
+		return err
+	}
+	if err := hdr.WriteMajorTypeHeader(cbg.MajArray, 1); err != nil {
+		return err
+	}
+	if err := cbg.WriteCid(hdr, root); err != nil {
+		return err
+	}
+	if err := hdr.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("version"))); err != nil {
+		return err
+	}
+	if _, err := hdr.WriteString("version"); err != nil {
+		return err
+	}
+	if err := hdr.WriteMajorTypeHeader(cbg.MajUnsignedInt, 1); err != nil {
+		return err
+	}
+
+	return writeSection(w, buf.Bytes())
+}
+
+// writeCarBlock writes a CID and block section of a CARv1
+func writeCarBlock(w io.Writer, c cid.Cid, data []byte) error {
+	return writeSection(w, c.Bytes(), data)
+}
+
+func writeSection(w io.Writer, parts ...[]byte) error {
+	var l uint64
+	for _, p := range parts {
+		l += uint64(len(p))
+	}
+
+	var lbuf [binary.MaxVarintLen64]byte
+	if _, err := w.Write(lbuf[:binary.PutUvarint(lbuf[:], l)]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/tasks/indexing"
+)
+
+const (
+	mimeRaw = "application/vnd.ipld.raw"
+	mimeCar = "application/vnd.ipld.car"
+
+	immutableCacheControl = "public, max-age=29030400, immutable"
+)
+
+// DAG scopes of CAR responses, see the dag-scope parameter of the trustless gateway spec
+const (
+	scopeBlock  = "block"
+	scopeEntity = "entity"
+	scopeAll    = "all"
+)
+
+// gatewayRequest is a parsed trustless gateway request
+type gatewayRequest struct {
+	root  cid.Cid
+	path  []string
+	car   bool
+	scope string
+}
+
+// parseGatewayRequest parses the CID, path, response format and DAG scope of a trustless gateway request. The
+// format is taken from the format query parameter, then from the Accept header, and defaults to a raw block.
+func parseGatewayRequest(r *http.Request) (*gatewayRequest, error) {
+	root, err := cid.Parse(mux.Vars(r)["cid"])
+	if err != nil {
+		return nil, xerrors.Errorf("invalid CID: %w", err)
+	}
+
+	gr := &gatewayRequest{root: root, scope: scopeAll}
+	for _, seg := range strings.Split(mux.Vars(r)["path"], "/") {
+		if seg != "" {
+			gr.path = append(gr.path, seg)
+		}
+	}
+
+	q := r.URL.Query()
+	switch q.Get("format") {
+	case "car":
+		gr.car = true
+	case "raw":
+	case "":
+		for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+			mt := strings.TrimSpace(strings.Split(accept, ";")[0])
+			if mt == mimeCar {
+				gr.car = true
+				break
+			}
+			if mt == mimeRaw {
+				break
+			}
+		}
+	default:
+		return nil, xerrors.Errorf("unsupported format %q", q.Get("format"))
+	}
+
+	if q.Get("entity-bytes") != "" {
+		return nil, xerrors.Errorf("entity-bytes is not supported")
+	}
+
+	if s := q.Get("dag-scope"); s != "" {
+		if !gr.car {
+			return nil, xerrors.Errorf("dag-scope is only supported with CAR responses")
+		}
+		if s != scopeBlock && s != scopeEntity && s != scopeAll {
+			return nil, xerrors.Errorf("unsupported dag-scope %q", s)
+		}
+		gr.scope = s
+	}
+
+	if !gr.car && len(gr.path) > 0 {
+		return nil, xerrors.Errorf("paths are only supported with CAR responses")
+	}
+
+	return gr, nil
+}
+
+// serveGateway serves /ipfs/<cid>[/<path>] following the trustless gateway spec, with raw block and CAR responses
+// built from blocks found in the block index
+func (s *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, func(w http.ResponseWriter, r *http.Request) {
+		gr, err := parseGatewayRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if gr.car {
+			s.serveCar(w, r, gr)
+			return
+		}
+
+		data, err := s.gatewayBlock(r.Context(), gr.root)
+		if !s.blockFound(w, gr.root, err) {
+			return
+		}
+
+		w.Header().Set("Content-Type", mimeRaw)
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Header().Set("ETag", `"`+gr.root.String()+`.raw"`)
+		w.Header().Set("Cache-Control", immutableCacheControl)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write(data)
+	})
+}
+
+// blockFound writes the error response for a block which couldn't be read, returning false when it did
+func (s *Server) blockFound(w http.ResponseWriter, c cid.Cid, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, indexing.ErrBlockNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Errorw("reading block", "cid", c, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// gatewayBlock returns the data of a block, from the CID itself for identity CIDs
+func (s *Server) gatewayBlock(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if data, ok := identityData(c); ok {
+		return data, nil
+	}
+	return s.blocks.ReadBlock(ctx, c, false)
+}
+
+// serveCar writes a CARv1 with the blocks of the path from the root, followed by the blocks of the DAG scope of the
+// terminal block in depth-first order, without duplicates. Once the response started, errors can only be reported
+// by ending it early.
+func (s *Server) serveCar(w http.ResponseWriter, r *http.Request, gr *gatewayRequest) {
+	ctx := r.Context()
+
+	type pathBlock struct {
+		c    cid.Cid
+		data []byte
+	}
+	var path []pathBlock
+
+	c := gr.root
+	for _, seg := range gr.path {
+		data, err := s.gatewayBlock(ctx, c)
+		if !s.blockFound(w, c, err) {
+			return
+		}
+		path = append(path, pathBlock{c: c, data: data})
+
+		c, err = namedLink(c, data, seg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	data, err := s.gatewayBlock(ctx, c)
+	if !s.blockFound(w, c, err) {
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s.car.%s"`, gr.root, gr.scope)
+	if len(gr.path) > 0 {
+		etag = fmt.Sprintf(`"%s/%s.car.%s"`, gr.root, strings.Join(gr.path, "/"), gr.scope)
+	}
+
+	w.Header().Set("Content-Type", mimeCar+"; version=1; order=dfs; dups=n")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", immutableCacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.car"`, gr.root))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if err := writeCarHeader(w, gr.root); err != nil {
+		return
+	}
+
+	seen := map[cid.Cid]struct{}{}
+	for _, pb := range path {
+		if _, ok := seen[pb.c]; ok {
+			continue
+		}
+		seen[pb.c] = struct{}{}
+		if err := writeCarBlock(w, pb.c, pb.data); err != nil {
+			return
+		}
+	}
+
+	scope := gr.scope
+	if scope == scopeEntity {
+		// the entity of a UnixFS directory is its own block, other entities are their whole DAG
+		if unixfsType(c, data) == unixfsDirectory {
+			scope = scopeBlock
+		}
+	}
+
+	if err := s.writeDag(ctx, w, c, data, scope == scopeBlock, seen); err != nil {
+		log.Warnw("writing CAR response", "root", gr.root, "cid", c, "error", err)
+	}
+}
+
+// writeDag writes a block and, unless onlyRoot is set, the blocks it links to in depth-first order
+func (s *Server) writeDag(ctx context.Context, w http.ResponseWriter, c cid.Cid, data []byte, onlyRoot bool, seen map[cid.Cid]struct{}) error {
+	if _, ok := seen[c]; !ok {
+		seen[c] = struct{}{}
+		if err := writeCarBlock(w, c, data); err != nil {
+			return err
+		}
+	}
+	if onlyRoot {
+		return nil
+	}
+
+	links, err := blockLinks(c, data)
+	if err != nil {
+		return xerrors.Errorf("getting links of %s: %w", c, err)
+	}
+
+	for _, l := range links {
+		if _, ok := seen[l]; ok {
+			continue
+		}
+
+		ldata, err := s.gatewayBlock(ctx, l)
+		if err != nil {
+			return xerrors.Errorf("reading block %s: %w", l, err)
+		}
+		if err := s.writeDag(ctx, w, l, ldata, false, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package retrieval
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	minBurst = 64 << 10
+	maxBurst = 16 << 20
+
+	// limiterIdle is how long the limiter of a client is kept after its last request
+	limiterIdle = 10 * time.Minute
+)
+
+type clientLimiter struct {
+	lim      *rate.Limiter
+	lastUsed time.Time
+}
+
+// clientLimits holds a bandwidth limiter per client, so that concurrent requests of a client share its limit
+type clientLimits struct {
+	lk       sync.Mutex
+	limiters map[string]*clientLimiter
+}
+
+func newClientLimits() *clientLimits {
+	return &clientLimits{limiters: map[string]*clientLimiter{}}
+}
+
+// get returns the limiter of a client, nil when bps is zero
+func (l *clientLimits) get(key string, bps uint64) *rate.Limiter {
+	if bps == 0 {
+		return nil
+	}
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	now := time.Now()
+	for k, cl := range l.limiters {
+		if now.Sub(cl.lastUsed) > limiterIdle {
+			delete(l.limiters, k)
+		}
+	}
+
+	cl, ok := l.limiters[key]
+	if !ok || cl.lim.Limit() != rate.Limit(bps) {
+		cl = &clientLimiter{lim: rate.NewLimiter(rate.Limit(bps), int(min(max(bps, minBurst), maxBurst)))}
+		l.limiters[key] = cl
+	}
+	cl.lastUsed = now
+	return cl.lim
+}
+
+// limitedWriter limits the rate of writes to w and counts the bytes written
+type limitedWriter struct {
+	ctx context.Context
+	w   io.Writer
+	lim *rate.Limiter
+
+	written int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if lw.lim != nil {
+			n = min(n, lw.lim.Burst())
+			if err := lw.lim.WaitN(lw.ctx, n); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := lw.w.Write(p[:n])
+		written += n
+		lw.written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
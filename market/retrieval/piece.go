@@ -0,0 +1,159 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/yugabyte/pgx/v5"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/indexing"
+	"github.com/filecoin-project/curio/tasks/unseal"
+)
+
+var (
+	errPieceNotFound = xerrors.New("piece not found")
+	errUnsealing     = xerrors.New("piece is being unsealed")
+)
+
+type openFunc func(ctx context.Context, offset, size uint64) (io.ReadCloser, error)
+
+// openPiece returns a reader of the data of a piece, from a parked copy of the piece or an unsealed copy of a sector
+// holding it. The data is the raw data of the piece when its size is known, otherwise the unpadded piece.
+func (s *Server) openPiece(ctx context.Context, pieceCid cid.Cid) (io.ReadSeekCloser, error) {
+	var parked struct {
+		ID      int64 `db:"id"`
+		RawSize int64 `db:"piece_raw_size"`
+	}
+	err := s.db.QueryRow(ctx, `SELECT id, piece_raw_size FROM parked_pieces WHERE piece_cid = $1 AND complete = TRUE`, pieceCid.String()).Scan(&parked.ID, &parked.RawSize)
+	switch {
+	case err == nil:
+		return newRangeReadSeeker(ctx, parked.RawSize, func(ctx context.Context, offset, size uint64) (io.ReadCloser, error) {
+			return s.sc.PieceRangeReader(ctx, storiface.PieceNumber(parked.ID), offset, size)
+		}), nil
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, xerrors.Errorf("getting parked piece: %w", err)
+	}
+
+	var pieceSize int64
+	var rawSize *int64
+	err = s.db.QueryRow(ctx, `SELECT piece_size, raw_data_size FROM sectors_meta_pieces WHERE piece_cid = $1 LIMIT 1`, pieceCid.String()).Scan(&pieceSize, &rawSize)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errPieceNotFound
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("getting piece size: %w", err)
+	}
+	size := uint64(abi.PaddedPieceSize(pieceSize).Unpadded())
+	if rawSize != nil {
+		size = uint64(*rawSize)
+	}
+
+	sectors, err := indexing.PieceSectors(ctx, s.db, pieceCid.String())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ps := range sectors {
+		has, err := s.sc.IsUnsealed(ctx, ps.Sector, ffi.UnsealedChunks(ps.Offset, size))
+		if err != nil {
+			log.Warnw("checking unsealed piece", "piece_cid", pieceCid, "sector", ps.Sector.ID, "error", err)
+			continue
+		}
+		if !has {
+			continue
+		}
+
+		sector, pieceOffset := ps.Sector, ps.Offset
+		return newRangeReadSeeker(ctx, int64(size), func(ctx context.Context, offset, size uint64) (io.ReadCloser, error) {
+			return s.sc.UnsealedReader(ctx, sector, pieceOffset+storiface.UnpaddedByteIndex(offset), size)
+		}), nil
+	}
+
+	if !s.cfg.UnsealOnDemand || len(sectors) == 0 {
+		return nil, xerrors.Errorf("no unsealed copy of the piece: %w", errPieceNotFound)
+	}
+
+	ps := sectors[0]
+	r := ffi.UnsealedChunks(ps.Offset, size)
+	if err := unseal.RequestRange(ctx, s.db, ps.Sector.ID, r.Offset, r.Size); err != nil {
+		return nil, xerrors.Errorf("requesting unseal: %w", err)
+	}
+	return nil, errUnsealing
+}
+
+// rangeReadSeeker reads data with range readers, opening a new reader when reading from a different offset than
+// the current reader, so that http.ServeContent can serve ranges
+type rangeReadSeeker struct {
+	ctx  context.Context
+	size int64
+	open openFunc
+
+	pos   int64
+	rd    io.ReadCloser
+	rdPos int64
+}
+
+func newRangeReadSeeker(ctx context.Context, size int64, open openFunc) *rangeReadSeeker {
+	return &rangeReadSeeker{ctx: ctx, size: size, open: open}
+}
+
+func (r *rangeReadSeeker) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.rd == nil || r.rdPos != r.pos {
+		if err := r.closeReader(); err != nil {
+			return 0, err
+		}
+
+		rd, err := r.open(r.ctx, uint64(r.pos), uint64(r.size-r.pos))
+		if err != nil {
+			return 0, xerrors.Errorf("opening reader at %d: %w", r.pos, err)
+		}
+		r.rd, r.rdPos = rd, r.pos
+	}
+
+	n, err := r.rd.Read(p)
+	r.pos += int64(n)
+	r.rdPos += int64(n)
+	return n, err
+}
+
+func (r *rangeReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, xerrors.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, xerrors.Errorf("negative offset %d", offset)
+	}
+
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *rangeReadSeeker) closeReader() error {
+	if r.rd == nil {
+		return nil
+	}
+	err := r.rd.Close()
+	r.rd = nil
+	return err
+}
+
+func (r *rangeReadSeeker) Close() error {
+	return r.closeReader()
+}
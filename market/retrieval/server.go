@@ -0,0 +1,164 @@
+// Package retrieval implements the HTTP retrieval server, serving pieces and blocks of deal data to clients from
+// parked pieces and unsealed sector copies.
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/tasks/indexing"
+)
+
+var log = logging.Logger("retrieval")
+
+// UnsealRetryAfter is the Retry-After of responses for pieces which are being unsealed
+const UnsealRetryAfter = 10 * time.Minute
+
+type Server struct {
+	db     *harmonydb.DB
+	sc     *ffi.SealCalls
+	blocks *indexing.BlockReader
+	cfg    config.CurioRetrievalConfig
+
+	bandwidth uint64
+	limits    *clientLimits
+}
+
+func NewServer(db *harmonydb.DB, sc *ffi.SealCalls, cfg config.CurioRetrievalConfig) (*Server, error) {
+	s := &Server{
+		db:     db,
+		sc:     sc,
+		blocks: indexing.NewBlockReader(db, sc),
+		cfg:    cfg,
+		limits: newClientLimits(),
+	}
+
+	if cfg.ClientBandwidth != "" {
+		bw, err := units.RAMInBytes(cfg.ClientBandwidth)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing Retrieval.ClientBandwidth: %w", err)
+		}
+		s.bandwidth = uint64(bw)
+	}
+
+	return s, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	m := mux.NewRouter()
+	m.HandleFunc("/piece/{cid}", s.servePiece).Methods("GET", "HEAD")
+	m.HandleFunc("/ipfs/{cid}", s.serveGateway).Methods("GET", "HEAD")
+	m.HandleFunc("/ipfs/{cid}/{path:.*}", s.serveGateway).Methods("GET", "HEAD")
+	return m
+}
+
+// client is the caller of a request: a token, or a remote address for requests without a token
+type client struct {
+	key       string
+	token     *tokenInfo
+	bandwidth uint64
+}
+
+// authenticate checks the token of a request, writing an error response when the request can't be served
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*client, bool) {
+	token := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); h != "" {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+
+	if token == "" {
+		if s.cfg.RequireToken {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return nil, false
+		}
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return &client{key: "addr:" + host, bandwidth: s.bandwidth}, true
+	}
+
+	info, err := verifyToken(r.Context(), s.db, token)
+	if err != nil {
+		log.Warnw("retrieval token verification failed", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	c := &client{key: fmt.Sprintf("token:%d", info.ID), token: &info, bandwidth: s.bandwidth}
+	if info.Bandwidth > 0 {
+		c.bandwidth = info.Bandwidth
+	}
+	return c, true
+}
+
+// limitedResponse limits the bandwidth of the response body to the client's limit
+type limitedResponse struct {
+	http.ResponseWriter
+	lw *limitedWriter
+}
+
+func (l *limitedResponse) Write(p []byte) (int, error) {
+	return l.lw.Write(p)
+}
+
+// serve runs a handler with the response limited to the bandwidth of the client, and counts the bytes served to
+// the clients of tokens
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, handle func(w http.ResponseWriter, r *http.Request)) {
+	c, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	lw := &limitedWriter{ctx: r.Context(), w: w, lim: s.limits.get(c.key, c.bandwidth)}
+	handle(&limitedResponse{ResponseWriter: w, lw: lw}, r)
+
+	if c.token != nil {
+		recordServed(context.Background(), s.db, c.token.ID, lw.written)
+	}
+}
+
+func (s *Server) servePiece(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, func(w http.ResponseWriter, r *http.Request) {
+		pieceCid, err := cid.Parse(mux.Vars(r)["cid"])
+		if err != nil || pieceCid.Prefix().Codec != cid.FilCommitmentUnsealed {
+			http.Error(w, "invalid piece CID", http.StatusBadRequest)
+			return
+		}
+
+		rs, err := s.openPiece(r.Context(), pieceCid)
+		switch {
+		case errors.Is(err, errPieceNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, errUnsealing):
+			w.Header().Set("Retry-After", fmt.Sprint(int(UnsealRetryAfter.Seconds())))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			log.Errorw("opening piece", "piece_cid", pieceCid, "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		defer rs.Close() // nolint:errcheck
+
+		w.Header().Set("Content-Type", "application/piece")
+		w.Header().Set("ETag", `"`+pieceCid.String()+`"`)
+		http.ServeContent(w, r, "", time.Time{}, rs)
+	})
+}
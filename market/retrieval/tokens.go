@@ -0,0 +1,89 @@
+package retrieval
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+func hashToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return h[:]
+}
+
+// CreateToken issues a new retrieval token. Zero bandwidth means the token is limited by Retrieval.ClientBandwidth,
+// zero ttl means the token never expires. The token is only returned here, the database holds just its hash.
+func CreateToken(ctx context.Context, db *harmonydb.DB, name string, bandwidth uint64, ttl time.Duration) (int64, string, error) {
+	var buf [32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return 0, "", xerrors.Errorf("generating token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf[:])
+
+	var expires *time.Time
+	if ttl > 0 {
+		expires = lo.ToPtr(time.Now().Add(ttl))
+	}
+	var limit *int64
+	if bandwidth > 0 {
+		limit = lo.ToPtr(int64(bandwidth))
+	}
+
+	var id int64
+	err := db.QueryRow(ctx, `INSERT INTO retrieval_tokens (name, token_hash, bandwidth_limit, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`,
+		name, hashToken(token), limit, expires).Scan(&id)
+	if err != nil {
+		return 0, "", xerrors.Errorf("storing token: %w", err)
+	}
+	return id, token, nil
+}
+
+// tokenInfo is a verified token
+type tokenInfo struct {
+	ID        int64
+	Bandwidth uint64 // 0 = default
+}
+
+func verifyToken(ctx context.Context, db *harmonydb.DB, token string) (tokenInfo, error) {
+	var rows []struct {
+		ID        int64      `db:"id"`
+		Bandwidth *int64     `db:"bandwidth_limit"`
+		ExpiresAt *time.Time `db:"expires_at"`
+		Revoked   bool       `db:"revoked"`
+	}
+	err := db.Select(ctx, &rows, `SELECT id, bandwidth_limit, expires_at, revoked FROM retrieval_tokens WHERE token_hash = $1`, hashToken(token))
+	if err != nil {
+		return tokenInfo{}, xerrors.Errorf("looking up token: %w", err)
+	}
+	if len(rows) == 0 {
+		return tokenInfo{}, xerrors.Errorf("unknown token")
+	}
+	t := rows[0]
+	if t.Revoked {
+		return tokenInfo{}, xerrors.Errorf("token %d was revoked", t.ID)
+	}
+	if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
+		return tokenInfo{}, xerrors.Errorf("token %d expired at %s", t.ID, t.ExpiresAt)
+	}
+
+	info := tokenInfo{ID: t.ID}
+	if t.Bandwidth != nil {
+		info.Bandwidth = uint64(*t.Bandwidth)
+	}
+	return info, nil
+}
+
+// recordServed adds bytes sent to a token's client to its total
+func recordServed(ctx context.Context, db *harmonydb.DB, tokenID int64, n int64) {
+	_, err := db.Exec(ctx, `UPDATE retrieval_tokens SET last_used = CURRENT_TIMESTAMP, bytes_served = bytes_served + $2 WHERE id = $1`, tokenID, n)
+	if err != nil {
+		log.Warnw("recording bytes served", "token", tokenID, "error", err)
+	}
+}
@@ -0,0 +1,431 @@
+// Package actorevents indexes built-in actor events and proving deadline outcomes of the configured SPs into the
+// database, so that the web UI and alerts can read them without walking chain state.
+package actorevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/chainsched"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+)
+
+var log = logging.Logger("curio/actorevents")
+
+// InitialLookback is how far back events are indexed for SPs which weren't indexed before.
+var InitialLookback = abi.ChainEpoch(builtin.EpochsInDay)
+
+// MaxRangeEpochs bounds the epoch range of a single event query, chain nodes reject large ranges.
+const MaxRangeEpochs = 2000
+
+// cborCodec is the multicodec of CBOR encoded event entry values.
+const cborCodec = 0x51
+
+type IndexerAPI interface {
+	GetActorEventsRaw(ctx context.Context, filter *types.ActorEventFilter) ([]*types.ActorEvent, error)
+	ChainGetTipSetByHeight(context.Context, abi.ChainEpoch, types.TipSetKey) (*types.TipSet, error)
+	StateMinerProvingDeadline(context.Context, address.Address, types.TipSetKey) (*dline.Info, error)
+	StateMinerDeadlines(context.Context, address.Address, types.TipSetKey) ([]api.Deadline, error)
+	StateMinerPartitions(context.Context, address.Address, uint64, types.TipSetKey) ([]api.Partition, error)
+}
+
+type Indexer struct {
+	db  *harmonydb.DB
+	api IndexerAPI
+
+	miners []address.Address
+	spIDs  []int64
+
+	// the handler may be called again for a revert while indexing
+	lk sync.Mutex
+}
+
+// NewIndexer registers the indexer with the chain scheduler.
+func NewIndexer(db *harmonydb.DB, api IndexerAPI, pcs *chainsched.CurioChainSched, miners map[dtypes.MinerAddress]bool) (*Indexer, error) {
+	ix := &Indexer{
+		db:  db,
+		api: api,
+	}
+	for m := range miners {
+		maddr := address.Address(m)
+		id, err := address.IDFromAddress(maddr)
+		if err != nil {
+			return nil, xerrors.Errorf("miner ID of %s: %w", maddr, err)
+		}
+		ix.miners = append(ix.miners, maddr)
+		ix.spIDs = append(ix.spIDs, int64(id))
+	}
+
+	if err := pcs.AddHandler(ix.processHeadChange); err != nil {
+		return nil, err
+	}
+	if err := pcs.AddRevertHandler(ix.processRevert); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+func (ix *Indexer) processHeadChange(ctx context.Context, revert, apply *types.TipSet) error {
+	if len(ix.miners) == 0 {
+		return nil
+	}
+
+	ix.lk.Lock()
+	defer ix.lk.Unlock()
+
+	// messages in the head tipset are not executed yet
+	if err := ix.indexEvents(ctx, apply.Height()-1); err != nil {
+		return xerrors.Errorf("indexing actor events: %w", err)
+	}
+
+	for i, maddr := range ix.miners {
+		if err := ix.recordDeadline(ctx, apply, maddr, ix.spIDs[i]); err != nil {
+			return xerrors.Errorf("recording deadline of %s: %w", maddr, err)
+		}
+	}
+	return nil
+}
+
+// processRevert drops everything indexed from reverted epochs, the events are indexed again from the new chain.
+func (ix *Indexer) processRevert(ctx context.Context, reverted abi.ChainEpoch, apply *types.TipSet) error {
+	ix.lk.Lock()
+	defer ix.lk.Unlock()
+
+	_, err := ix.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		if _, err := tx.Exec(`DELETE FROM actor_events WHERE sp_id = ANY($1) AND epoch >= $2`, ix.spIDs, reverted); err != nil {
+			return false, xerrors.Errorf("deleting events: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM actor_deadlines WHERE sp_id = ANY($1) AND close_epoch >= $2`, ix.spIDs, reverted); err != nil {
+			return false, xerrors.Errorf("deleting deadlines: %w", err)
+		}
+		if _, err := tx.Exec(`UPDATE actor_event_index_state SET indexed_epoch = $2
+			WHERE sp_id = ANY($1) AND indexed_epoch >= $2`, ix.spIDs, reverted-1); err != nil {
+			return false, xerrors.Errorf("resetting index state: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
+}
+
+// indexEvents indexes events of the configured SPs from after the last indexed epoch up to epoch to.
+func (ix *Indexer) indexEvents(ctx context.Context, to abi.ChainEpoch) error {
+	var indexed []struct {
+		SpID  int64 `db:"sp_id"`
+		Epoch int64 `db:"indexed_epoch"`
+	}
+	if err := ix.db.Select(ctx, &indexed, `SELECT sp_id, indexed_epoch FROM actor_event_index_state WHERE sp_id = ANY($1)`, ix.spIDs); err != nil {
+		return xerrors.Errorf("getting index state: %w", err)
+	}
+
+	from := to - InitialLookback
+	if len(indexed) == len(ix.spIDs) {
+		// all SPs were indexed before, continue from the least indexed one
+		from = to
+		for _, s := range indexed {
+			if abi.ChainEpoch(s.Epoch)+1 < from {
+				from = abi.ChainEpoch(s.Epoch) + 1
+			}
+		}
+	}
+	if from < 0 {
+		from = 0
+	}
+
+	for start := from; start <= to; start += MaxRangeEpochs {
+		end := start + MaxRangeEpochs - 1
+		if end > to {
+			end = to
+		}
+
+		events, err := ix.fetchEvents(ctx, start, end)
+		if err != nil {
+			return err
+		}
+		if err := ix.storeEvents(ctx, events, end); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetchEvents gets events emitted by the SP miner actors, and deal events of the market actor with the SPs as the
+// provider.
+func (ix *Indexer) fetchEvents(ctx context.Context, from, to abi.ChainEpoch) ([]indexedEvent, error) {
+	minerEvents, err := ix.api.GetActorEventsRaw(ctx, &types.ActorEventFilter{
+		Addresses:  ix.miners,
+		FromHeight: &from,
+		ToHeight:   &to,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner actor events %d-%d: %w", from, to, err)
+	}
+
+	var providers []types.ActorEventBlock
+	for _, id := range ix.spIDs {
+		var buf bytes.Buffer
+		if err := cbg.CborWriteHeader(&buf, cbg.MajUnsignedInt, uint64(id)); err != nil {
+			return nil, err
+		}
+		providers = append(providers, types.ActorEventBlock{Codec: cborCodec, Value: buf.Bytes()})
+	}
+	marketEvents, err := ix.api.GetActorEventsRaw(ctx, &types.ActorEventFilter{
+		Addresses:  []address.Address{builtin.StorageMarketActorAddr},
+		Fields:     map[string][]types.ActorEventBlock{"provider": providers},
+		FromHeight: &from,
+		ToHeight:   &to,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("getting market actor events %d-%d: %w", from, to, err)
+	}
+
+	var out []indexedEvent
+	msgIndex := map[string]int{}
+	for _, e := range append(minerEvents, marketEvents...) {
+		if e.Reverted {
+			continue
+		}
+
+		ie, err := decodeEvent(e)
+		if err != nil {
+			log.Warnw("skipping undecodable actor event", "msg", e.MsgCid, "emitter", e.Emitter, "error", err)
+			continue
+		}
+
+		tsCid, err := e.TipSetKey.Cid()
+		if err != nil {
+			return nil, xerrors.Errorf("tipset key cid: %w", err)
+		}
+		ie.tipsetCid = tsCid.String()
+
+		key := ie.tipsetCid + "/" + e.MsgCid.String()
+		ie.index = msgIndex[key]
+		msgIndex[key]++
+
+		if e.Emitter == builtin.StorageMarketActorAddr {
+			provider, ok := ie.entries["provider"].(uint64)
+			if !ok {
+				continue
+			}
+			ie.spID = int64(provider)
+		} else {
+			id, err := address.IDFromAddress(e.Emitter)
+			if err != nil {
+				continue
+			}
+			ie.spID = int64(id)
+		}
+
+		out = append(out, ie)
+	}
+	return out, nil
+}
+
+type indexedEvent struct {
+	event     *types.ActorEvent
+	tipsetCid string
+	index     int
+	spID      int64
+
+	eventType string
+	entries   map[string]any
+}
+
+func (ix *Indexer) storeEvents(ctx context.Context, events []indexedEvent, indexedTo abi.ChainEpoch) error {
+	_, err := ix.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		for _, e := range events {
+			entries, err := json.Marshal(e.entries)
+			if err != nil {
+				return false, xerrors.Errorf("marshaling entries: %w", err)
+			}
+
+			var sector, deal *int64
+			if n, ok := e.entries["sector"].(uint64); ok {
+				v := int64(n)
+				sector = &v
+			}
+			if n, ok := e.entries["id"].(uint64); ok && e.event.Emitter == builtin.StorageMarketActorAddr {
+				v := int64(n)
+				deal = &v
+			}
+
+			_, err = tx.Exec(`INSERT INTO actor_events (sp_id, epoch, tipset_cid, msg_cid, event_index, event_type, sector_number, deal_id, entries)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT DO NOTHING`,
+				e.spID, e.event.Height, e.tipsetCid, e.event.MsgCid.String(), e.index, e.eventType, sector, deal, entries)
+			if err != nil {
+				return false, xerrors.Errorf("inserting event: %w", err)
+			}
+		}
+
+		_, err = tx.Exec(`INSERT INTO actor_event_index_state (sp_id, indexed_epoch) SELECT unnest($1::BIGINT[]), $2
+			ON CONFLICT (sp_id) DO UPDATE SET indexed_epoch = GREATEST(actor_event_index_state.indexed_epoch, EXCLUDED.indexed_epoch)`,
+			ix.spIDs, indexedTo)
+		if err != nil {
+			return false, xerrors.Errorf("updating index state: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
+}
+
+// recordDeadline records the outcome of the deadline which closed before the current one, once.
+func (ix *Indexer) recordDeadline(ctx context.Context, head *types.TipSet, maddr address.Address, spID int64) error {
+	di, err := ix.api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+	if err != nil {
+		return xerrors.Errorf("getting proving deadline: %w", err)
+	}
+
+	idx := (di.Index + di.WPoStPeriodDeadlines - 1) % di.WPoStPeriodDeadlines
+	closeEpoch := di.Open
+	pps := closeEpoch - di.WPoStChallengeWindow*abi.ChainEpoch(idx+1)
+	if pps < 0 {
+		return nil
+	}
+
+	var recorded bool
+	err = ix.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM actor_deadlines WHERE sp_id = $1 AND proving_period_start = $2 AND deadline = $3)`,
+		spID, pps, idx).Scan(&recorded)
+	if err != nil {
+		return xerrors.Errorf("checking recorded deadline: %w", err)
+	}
+	if recorded {
+		return nil
+	}
+
+	// PoSt submissions are cleared by the deadline end cron, read them from the last epoch of the deadline
+	lastTs, err := ix.api.ChainGetTipSetByHeight(ctx, closeEpoch-1, head.Key())
+	if err != nil {
+		return xerrors.Errorf("getting tipset at %d: %w", closeEpoch-1, err)
+	}
+	deadlines, err := ix.api.StateMinerDeadlines(ctx, maddr, lastTs.Key())
+	if err != nil {
+		return xerrors.Errorf("getting deadlines: %w", err)
+	}
+	if idx >= uint64(len(deadlines)) {
+		return xerrors.Errorf("deadline %d out of range", idx)
+	}
+	proven, err := deadlines[idx].PostSubmissions.Count()
+	if err != nil {
+		return xerrors.Errorf("counting PoSt submissions: %w", err)
+	}
+
+	partitions, err := ix.api.StateMinerPartitions(ctx, maddr, idx, head.Key())
+	if err != nil {
+		return xerrors.Errorf("getting partitions: %w", err)
+	}
+
+	var live, faulty, recovering uint64
+	for _, p := range partitions {
+		l, err := p.LiveSectors.Count()
+		if err != nil {
+			return xerrors.Errorf("counting live sectors: %w", err)
+		}
+		f, err := p.FaultySectors.Count()
+		if err != nil {
+			return xerrors.Errorf("counting faulty sectors: %w", err)
+		}
+		r, err := p.RecoveringSectors.Count()
+		if err != nil {
+			return xerrors.Errorf("counting recovering sectors: %w", err)
+		}
+		live, faulty, recovering = live+l, faulty+f, recovering+r
+	}
+
+	_, err = ix.db.Exec(ctx, `INSERT INTO actor_deadlines (sp_id, proving_period_start, deadline, close_epoch,
+			partitions, proven_partitions, live_sectors, faulty_sectors, recovering_sectors)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT DO NOTHING`,
+		spID, pps, idx, closeEpoch, len(partitions), proven, live, faulty, recovering)
+	if err != nil {
+		return xerrors.Errorf("inserting deadline: %w", err)
+	}
+	return nil
+}
+
+func decodeEvent(e *types.ActorEvent) (indexedEvent, error) {
+	ie := indexedEvent{
+		event:   e,
+		entries: map[string]any{},
+	}
+	for _, entry := range e.Entries {
+		if entry.Codec != cborCodec {
+			ie.entries[entry.Key] = hex.EncodeToString(entry.Value)
+			continue
+		}
+		v, err := decodeValue(entry.Value)
+		if err != nil {
+			return indexedEvent{}, xerrors.Errorf("decoding entry %s: %w", entry.Key, err)
+		}
+		ie.entries[entry.Key] = v
+	}
+
+	t, ok := ie.entries["$type"].(string)
+	if !ok {
+		return indexedEvent{}, xerrors.Errorf("event without a $type entry")
+	}
+	ie.eventType = t
+	return ie, nil
+}
+
+// decodeValue decodes the scalar CBOR values used in built-in actor events: integers, strings, bytes, CIDs and
+// simple values. Other values are returned hex encoded.
+func decodeValue(v []byte) (any, error) {
+	cr := cbg.NewCborReader(bytes.NewReader(v))
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	switch maj {
+	case cbg.MajUnsignedInt:
+		return extra, nil
+	case cbg.MajNegativeInt:
+		return -int64(extra) - 1, nil
+	case cbg.MajTextString, cbg.MajByteString:
+		if extra > uint64(len(v)) {
+			return nil, xerrors.Errorf("string length %d exceeds value length", extra)
+		}
+		buf := make([]byte, extra)
+		if _, err := io.ReadFull(cr, buf); err != nil {
+			return nil, err
+		}
+		if maj == cbg.MajTextString {
+			return string(buf), nil
+		}
+		return buf, nil
+	case cbg.MajTag:
+		if extra == 42 {
+			c, err := cbg.ReadCid(bytes.NewReader(v))
+			if err != nil {
+				return nil, err
+			}
+			return c.String(), nil
+		}
+	case cbg.MajOther:
+		switch extra {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+	}
+	return hex.EncodeToString(v), nil
+}
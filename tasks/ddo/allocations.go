@@ -0,0 +1,179 @@
+package ddo
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	verifregtypes "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var log = logging.Logger("ddo")
+
+// States of tracked allocations
+const (
+	StatePending  = "pending"
+	StateAssigned = "assigned"
+	StateClaimed  = "claimed"
+	StateExpired  = "expired"
+	StateFailed   = "failed"
+)
+
+// ExpirationAlertEpochs is how long before its expiration an allocation which isn't claimed yet raises an alert
+const ExpirationAlertEpochs = abi.ChainEpoch(2 * 2880)
+
+type AllocationAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+	StateGetAllocation(ctx context.Context, clientAddr address.Address, allocationId verifregtypes.AllocationId, tsk types.TipSetKey) (*verifregtypes.Allocation, error)
+	StateGetAllocations(ctx context.Context, clientAddr address.Address, tsk types.TipSetKey) (map[verifregtypes.AllocationId]verifregtypes.Allocation, error)
+	StateGetClaims(ctx context.Context, providerAddr address.Address, tsk types.TipSetKey) (map[verifregtypes.ClaimId]verifregtypes.Claim, error)
+}
+
+// TrackedAllocation is an allocation being onboarded by Curio
+type TrackedAllocation struct {
+	ClientID     int64     `db:"client_id"`
+	AllocationID int64     `db:"allocation_id"`
+	SpID         int64     `db:"sp_id"`
+	PieceCID     string    `db:"piece_cid"`
+	PieceSize    int64     `db:"piece_size"`
+	TermMin      int64     `db:"term_min"`
+	TermMax      int64     `db:"term_max"`
+	Expiration   int64     `db:"expiration"`
+	State        string    `db:"state"`
+	SectorNumber *int64    `db:"sector_number"`
+	ClaimID      *int64    `db:"claim_id"`
+	Error        *string   `db:"error"`
+	TaskID       *int64    `db:"task_id"`
+	CreatedAt    time.Time `db:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
+}
+
+// ClientAllocation is an on-chain allocation of a client, with its tracking state when Curio onboards it
+type ClientAllocation struct {
+	AllocationID int64
+	Provider     int64
+	PieceCID     string
+	PieceSize    int64
+	TermMin      int64
+	TermMax      int64
+	Expiration   int64
+
+	// Ours is set when the provider is one of the miners given to ClientAllocations
+	Ours bool
+	// State is the tracking state, empty when a claim wasn't requested
+	State string
+}
+
+// ClientAllocations lists the on-chain allocations of a client, marking the ones made to miners in ours.
+func ClientAllocations(ctx context.Context, db *harmonydb.DB, api AllocationAPI, client address.Address, ours map[abi.ActorID]bool) ([]ClientAllocation, error) {
+	clientID, err := api.StateLookupID(ctx, client, types.EmptyTSK)
+	if err != nil {
+		return nil, xerrors.Errorf("looking up client ID: %w", err)
+	}
+	clientActor, err := address.IDFromAddress(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	allocs, err := api.StateGetAllocations(ctx, clientID, types.EmptyTSK)
+	if err != nil {
+		return nil, xerrors.Errorf("getting allocations: %w", err)
+	}
+
+	var tracked []struct {
+		AllocationID int64  `db:"allocation_id"`
+		State        string `db:"state"`
+	}
+	err = db.Select(ctx, &tracked, `SELECT allocation_id, state FROM ddo_allocations WHERE client_id = $1`, int64(clientActor))
+	if err != nil {
+		return nil, xerrors.Errorf("getting tracked allocations: %w", err)
+	}
+	states := map[int64]string{}
+	for _, t := range tracked {
+		states[t.AllocationID] = t.State
+	}
+
+	out := make([]ClientAllocation, 0, len(allocs))
+	for id, a := range allocs {
+		out = append(out, ClientAllocation{
+			AllocationID: int64(id),
+			Provider:     int64(a.Provider),
+			PieceCID:     a.Data.String(),
+			PieceSize:    int64(a.Size),
+			TermMin:      int64(a.TermMin),
+			TermMax:      int64(a.TermMax),
+			Expiration:   int64(a.Expiration),
+			Ours:         ours[a.Provider],
+			State:        states[int64(id)],
+		})
+	}
+	return out, nil
+}
+
+// RequestClaim starts onboarding an allocation of a client to one of the miners in ours: once the piece of the
+// allocation is parked, e.g. pushed by the client, the DDOClaim task adds it to a sector, which claims the
+// allocation when it's proven. Requesting a claim of a failed allocation retries it.
+func RequestClaim(ctx context.Context, db *harmonydb.DB, api AllocationAPI, client address.Address, allocationID verifregtypes.AllocationId, ours map[abi.ActorID]bool) error {
+	clientID, err := api.StateLookupID(ctx, client, types.EmptyTSK)
+	if err != nil {
+		return xerrors.Errorf("looking up client ID: %w", err)
+	}
+	clientActor, err := address.IDFromAddress(clientID)
+	if err != nil {
+		return err
+	}
+
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	alloc, err := api.StateGetAllocation(ctx, clientID, allocationID, head.Key())
+	if err != nil {
+		return xerrors.Errorf("getting allocation: %w", err)
+	}
+	if alloc == nil {
+		return xerrors.Errorf("allocation %d of client %s not found", allocationID, client)
+	}
+	if !ours[alloc.Provider] {
+		return xerrors.Errorf("allocation %d is for provider f0%d, which isn't configured in Curio", allocationID, alloc.Provider)
+	}
+	if alloc.Expiration <= head.Height() {
+		return xerrors.Errorf("allocation %d expired at epoch %d", allocationID, alloc.Expiration)
+	}
+
+	n, err := db.Exec(ctx, `INSERT INTO ddo_allocations (client_id, allocation_id, sp_id, piece_cid, piece_size, term_min, term_max, expiration)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (client_id, allocation_id) DO UPDATE SET state = 'pending', error = NULL, updated_at = current_timestamp
+			WHERE ddo_allocations.state = 'failed'`,
+		int64(clientActor), int64(allocationID), int64(alloc.Provider), alloc.Data.String(), int64(alloc.Size), int64(alloc.TermMin),
+		int64(alloc.TermMax), int64(alloc.Expiration))
+	if err != nil {
+		return xerrors.Errorf("adding allocation: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("allocation %d is already being onboarded", allocationID)
+	}
+	return nil
+}
+
+// TrackedAllocations lists the allocations onboarded by Curio.
+func TrackedAllocations(ctx context.Context, db *harmonydb.DB) ([]TrackedAllocation, error) {
+	var out []TrackedAllocation
+	err := db.Select(ctx, &out, `SELECT client_id, allocation_id, sp_id, piece_cid, piece_size, term_min, term_max, expiration,
+			state, sector_number, claim_id, error, task_id, created_at, updated_at
+		FROM ddo_allocations ORDER BY expiration, client_id, allocation_id`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting tracked allocations: %w", err)
+	}
+	return out, nil
+}
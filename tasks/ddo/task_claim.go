@@ -0,0 +1,213 @@
+package ddo
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	miner2 "github.com/filecoin-project/go-state-types/builtin/v13/miner"
+	verifreg13 "github.com/filecoin-project/go-state-types/builtin/v13/verifreg"
+
+	"github.com/filecoin-project/curio/api"
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/market"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	lpiece "github.com/filecoin-project/lotus/storage/pipeline/piece"
+)
+
+const ClaimSchedInterval = time.Minute
+
+// ClaimTask adds the piece of a pending allocation to a sector, once the piece is parked. The allocation is claimed
+// when the sector is proven.
+type ClaimTask struct {
+	db  *harmonydb.DB
+	api api.Chain
+	cfg *config.CurioConfig
+
+	ingesters *market.Ingesters
+}
+
+func NewClaimTask(db *harmonydb.DB, api api.Chain, cfg *config.CurioConfig) *ClaimTask {
+	return &ClaimTask{
+		db:  db,
+		api: api,
+		cfg: cfg,
+
+		ingesters: market.NewIngesters(db, api, cfg),
+	}
+}
+
+func (c *ClaimTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var allocs []TrackedAllocation
+	err = c.db.Select(ctx, &allocs, `SELECT client_id, allocation_id, sp_id, piece_cid, piece_size, term_min, term_max, expiration,
+			state, sector_number, claim_id, error, task_id, created_at, updated_at
+		FROM ddo_allocations WHERE task_id = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting allocation: %w", err)
+	}
+	if len(allocs) != 1 {
+		return false, xerrors.Errorf("expected 1 allocation, got %d", len(allocs))
+	}
+	a := allocs[0]
+
+	head, err := c.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+	if head.Height() >= abi.ChainEpoch(a.Expiration) {
+		return c.finish(ctx, taskID, StateExpired, nil, nil)
+	}
+
+	var pieces []struct {
+		ID      int64 `db:"id"`
+		RawSize int64 `db:"piece_raw_size"`
+	}
+	err = c.db.Select(ctx, &pieces, `SELECT id, piece_raw_size FROM parked_pieces WHERE piece_cid = $1 AND complete = TRUE`, a.PieceCID)
+	if err != nil {
+		return false, xerrors.Errorf("getting parked piece: %w", err)
+	}
+	if len(pieces) == 0 {
+		// scheduled with a parked piece which was removed since, wait for it to be parked again
+		_, err := c.db.Exec(ctx, `UPDATE ddo_allocations SET task_id = NULL WHERE task_id = $1`, taskID)
+		if err != nil {
+			return false, xerrors.Errorf("updating allocation: %w", err)
+		}
+		return true, nil
+	}
+
+	pieceCID, err := cid.Parse(a.PieceCID)
+	if err != nil {
+		return false, xerrors.Errorf("parsing piece cid: %w", err)
+	}
+
+	maddr, err := address.NewIDAddress(uint64(a.SpID))
+	if err != nil {
+		return false, err
+	}
+
+	pin, err := c.ingesters.Get(maddr)
+	if err != nil {
+		return false, err
+	}
+
+	var refID int64
+	err = c.db.QueryRow(ctx, `INSERT INTO parked_piece_refs (piece_id, data_url) VALUES ($1, NULL) RETURNING ref_id`, pieces[0].ID).Scan(&refID)
+	if err != nil {
+		return false, xerrors.Errorf("adding piece ref: %w", err)
+	}
+
+	deal := lpiece.PieceDealInfo{
+		PieceActivationManifest: &miner.PieceActivationManifest{
+			CID:  pieceCID,
+			Size: abi.PaddedPieceSize(a.PieceSize),
+			VerifiedAllocationKey: &miner2.VerifiedAllocationKey{
+				Client: abi.ActorID(a.ClientID),
+				ID:     verifreg13.AllocationId(a.AllocationID),
+			},
+		},
+		// the sector has to be proven, claiming the allocation, before the allocation expires
+		DealSchedule: lpiece.DealSchedule{
+			StartEpoch: abi.ChainEpoch(a.Expiration),
+			EndEpoch:   abi.ChainEpoch(a.Expiration + a.TermMin),
+		},
+		KeepUnsealed: true,
+	}
+
+	source := url.URL{
+		Scheme: "pieceref",
+		Opaque: fmt.Sprintf("%d", refID),
+	}
+
+	so, err := pin.AllocatePieceToSector(ctx, maddr, deal, pieces[0].RawSize, source, nil)
+	if err != nil {
+		if _, derr := c.db.Exec(ctx, `DELETE FROM parked_piece_refs WHERE ref_id = $1`, refID); derr != nil {
+			log.Errorw("removing piece ref", "ref", refID, "error", derr)
+		}
+
+		msg := err.Error()
+		return c.finish(ctx, taskID, StateFailed, nil, &msg)
+	}
+
+	log.Infow("allocation assigned to sector", "client", a.ClientID, "allocation", a.AllocationID, "sp", a.SpID, "sector", so.Sector)
+
+	sector := int64(so.Sector)
+	return c.finish(ctx, taskID, StateAssigned, &sector, nil)
+}
+
+func (c *ClaimTask) finish(ctx context.Context, taskID harmonytask.TaskID, state string, sector *int64, errMsg *string) (bool, error) {
+	_, err := c.db.Exec(ctx, `UPDATE ddo_allocations SET state = $2, sector_number = $3, error = $4, task_id = NULL, updated_at = current_timestamp
+		WHERE task_id = $1`, taskID, state, sector, errMsg)
+	if err != nil {
+		return false, xerrors.Errorf("updating allocation: %w", err)
+	}
+	return true, nil
+}
+
+func (c *ClaimTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (c *ClaimTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(4),
+		Name: "DDOClaim",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		MaxFailures: 10,
+		IAmBored:    passcall.Every(ClaimSchedInterval, c.schedule),
+	}
+}
+
+func (c *ClaimTask) schedule(taskFunc harmonytask.AddTaskFunc) error {
+	// schedule one allocation with a parked piece when we're bored
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var allocs []struct {
+			ClientID     int64 `db:"client_id"`
+			AllocationID int64 `db:"allocation_id"`
+		}
+		err := tx.Select(&allocs, `SELECT a.client_id, a.allocation_id FROM ddo_allocations a
+			WHERE a.state = 'pending' AND a.task_id IS NULL
+			  AND EXISTS (SELECT 1 FROM parked_pieces pp WHERE pp.piece_cid = a.piece_cid AND pp.complete = TRUE)`)
+		if err != nil {
+			return false, xerrors.Errorf("getting pending allocations: %w", err)
+		}
+		if len(allocs) == 0 {
+			return false, nil
+		}
+
+		// pick at random in case there are a bunch of schedules across the cluster
+		a := allocs[rand.N(len(allocs))]
+
+		n, err := tx.Exec(`UPDATE ddo_allocations SET task_id = $1 WHERE client_id = $2 AND allocation_id = $3 AND task_id IS NULL`, id, a.ClientID, a.AllocationID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return n == 1, nil
+	})
+
+	return nil
+}
+
+func (c *ClaimTask) Adder(taskFunc harmonytask.AddTaskFunc) {}
+
+var _ = harmonytask.Reg(&ClaimTask{})
+var _ harmonytask.TaskInterface = &ClaimTask{}
@@ -0,0 +1,146 @@
+package ddo
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	verifregtypes "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+)
+
+const TrackInterval = 10 * time.Minute
+
+// TrackTask follows tracked allocations on chain, marking them claimed once the sector with their piece is proven,
+// or expired when they lapse before that.
+type TrackTask struct {
+	db  *harmonydb.DB
+	api AllocationAPI
+}
+
+func NewTrackTask(db *harmonydb.DB, api AllocationAPI) *TrackTask {
+	return &TrackTask{
+		db:  db,
+		api: api,
+	}
+}
+
+func (t *TrackTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var allocs []TrackedAllocation
+	err = t.db.Select(ctx, &allocs, `SELECT client_id, allocation_id, sp_id, piece_cid, piece_size, term_min, term_max, expiration,
+			state, sector_number, claim_id, error, task_id, created_at, updated_at
+		FROM ddo_allocations WHERE state IN ('pending', 'assigned', 'failed')`)
+	if err != nil {
+		return false, xerrors.Errorf("getting tracked allocations: %w", err)
+	}
+	if len(allocs) == 0 {
+		return true, nil
+	}
+
+	head, err := t.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	// claims are listed per provider, only get them once
+	claims := map[int64]map[verifregtypes.ClaimId]verifregtypes.Claim{}
+
+	for _, a := range allocs {
+		clientAddr, err := address.NewIDAddress(uint64(a.ClientID))
+		if err != nil {
+			return false, err
+		}
+
+		alloc, err := t.api.StateGetAllocation(ctx, clientAddr, verifregtypes.AllocationId(a.AllocationID), head.Key())
+		if err != nil {
+			return false, xerrors.Errorf("getting allocation %d of client f0%d: %w", a.AllocationID, a.ClientID, err)
+		}
+
+		if alloc != nil {
+			if head.Height() > abi.ChainEpoch(a.Expiration) {
+				if err := t.setState(ctx, a, StateExpired, nil); err != nil {
+					return false, err
+				}
+			}
+			continue
+		}
+
+		// the allocation was removed from the registry, either claimed or expired and removed
+		if _, ok := claims[a.SpID]; !ok {
+			maddr, err := address.NewIDAddress(uint64(a.SpID))
+			if err != nil {
+				return false, err
+			}
+			claims[a.SpID], err = t.api.StateGetClaims(ctx, maddr, head.Key())
+			if err != nil {
+				return false, xerrors.Errorf("getting claims of f0%d: %w", a.SpID, err)
+			}
+		}
+
+		var claimID *int64
+		for id, c := range claims[a.SpID] {
+			if int64(c.Client) != a.ClientID || c.Data.String() != a.PieceCID {
+				continue
+			}
+			if a.SectorNumber != nil && int64(c.Sector) != *a.SectorNumber {
+				continue
+			}
+			cid := int64(id)
+			claimID = &cid
+			break
+		}
+
+		if claimID != nil {
+			log.Infow("allocation claimed", "client", a.ClientID, "allocation", a.AllocationID, "sp", a.SpID, "claim", *claimID)
+			err = t.setState(ctx, a, StateClaimed, claimID)
+		} else {
+			err = t.setState(ctx, a, StateExpired, nil)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (t *TrackTask) setState(ctx context.Context, a TrackedAllocation, state string, claimID *int64) error {
+	_, err := t.db.Exec(ctx, `UPDATE ddo_allocations SET state = $3, claim_id = $4, updated_at = current_timestamp
+		WHERE client_id = $1 AND allocation_id = $2 AND state = $5`, a.ClientID, a.AllocationID, state, claimID, a.State)
+	if err != nil {
+		return xerrors.Errorf("updating allocation %d of client f0%d: %w", a.AllocationID, a.ClientID, err)
+	}
+	return nil
+}
+
+func (t *TrackTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (t *TrackTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "DDOTrack",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		IAmBored: harmonytask.SingletonTaskAdder(TrackInterval, t),
+	}
+}
+
+func (t *TrackTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ = harmonytask.Reg(&TrackTask{})
+var _ harmonytask.TaskInterface = &TrackTask{}
@@ -0,0 +1,379 @@
+// Package dealvalidate validates deal proposals handed to Curio by market adapters against chain state, either in
+// the adapter or, for adapters taking bursts of proposals, in DealValidate tasks on other nodes of the cluster.
+package dealvalidate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/yugabyte/pgx/v5"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+	verifregtypes "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/bls"
+	_ "github.com/filecoin-project/lotus/lib/sigs/delegated"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp"
+	lpiece "github.com/filecoin-project/lotus/storage/pipeline/piece"
+)
+
+var log = logging.Logger("dealvalidate")
+
+// waitInterval is how often the result of a proposal validated by a task is checked
+const waitInterval = 2 * time.Second
+
+type ValidateAPI interface {
+	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+	StateAccountKey(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+	StateMarketStorageDeal(context.Context, abi.DealID, types.TipSetKey) (*lapi.MarketDeal, error)
+	StateGetAllocation(ctx context.Context, clientAddr address.Address, allocationId verifregtypes.AllocationId, tsk types.TipSetKey) (*verifregtypes.Allocation, error)
+	StateGetAllocationForPendingDeal(ctx context.Context, dealId abi.DealID, tsk types.TipSetKey) (*verifregtypes.Allocation, error)
+	StateVerifiedClientStatus(context.Context, address.Address, types.TipSetKey) (*abi.StoragePower, error)
+}
+
+// Proposal is a deal handed to Curio by a market adapter
+type Proposal struct {
+	Miner address.Address
+	Deal  lpiece.PieceDealInfo
+
+	// ClientSignature is the signature of the client over the deal proposal. Published deals don't need it, the
+	// market actor checked it when the deal was published.
+	ClientSignature *crypto.Signature
+}
+
+// Result is the outcome of the validation of a proposal
+type Result struct {
+	ProposalID int64
+
+	Valid  bool
+	Reason string
+
+	// ClientID is the resolved client, undefined for DDO pieces without an allocation
+	ClientID address.Address
+}
+
+// Check validates a proposal right away and records the result
+func Check(ctx context.Context, db *harmonydb.DB, api ValidateAPI, p Proposal) (Result, error) {
+	res, err := validate(ctx, api, p)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res.ProposalID, err = insert(ctx, db, p, &res)
+	if err != nil {
+		return Result{}, err
+	}
+
+	logResult(p, res)
+	return res, nil
+}
+
+// Submit records a proposal to be validated by a DealValidate task, its result is returned by Wait
+func Submit(ctx context.Context, db *harmonydb.DB, p Proposal) (int64, error) {
+	return insert(ctx, db, p, nil)
+}
+
+// Wait waits for the result of a submitted proposal. It fails when the task validating the proposal failed for
+// good, e.g. because the chain node couldn't be reached.
+func Wait(ctx context.Context, db *harmonydb.DB, proposalID int64) (Result, error) {
+	for {
+		var rows []struct {
+			Valid    *bool   `db:"valid"`
+			Reason   *string `db:"reason"`
+			ClientID *int64  `db:"client_id"`
+			TaskID   *int64  `db:"task_id"`
+		}
+		err := db.Select(ctx, &rows, `SELECT valid, reason, client_id, task_id FROM market_deal_proposals WHERE proposal_id = $1`, proposalID)
+		if err != nil {
+			return Result{}, xerrors.Errorf("getting proposal validation: %w", err)
+		}
+		if len(rows) != 1 {
+			return Result{}, xerrors.Errorf("proposal %d not found", proposalID)
+		}
+		r := rows[0]
+
+		if r.Valid != nil {
+			res := Result{ProposalID: proposalID, Valid: *r.Valid}
+			if r.Reason != nil {
+				res.Reason = *r.Reason
+			}
+			if r.ClientID != nil {
+				res.ClientID, err = address.NewIDAddress(uint64(*r.ClientID))
+				if err != nil {
+					return Result{}, err
+				}
+			}
+			return res, nil
+		}
+
+		if r.TaskID != nil {
+			var taskErr string
+			err = db.QueryRow(ctx, `SELECT err FROM harmony_task_history WHERE task_id = $1 AND result = FALSE
+				AND NOT EXISTS (SELECT 1 FROM harmony_task WHERE id = $1)
+				ORDER BY work_end DESC LIMIT 1`, *r.TaskID).Scan(&taskErr)
+			if err == nil {
+				return Result{}, xerrors.Errorf("deal validation task failed: %s", taskErr)
+			}
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return Result{}, xerrors.Errorf("checking deal validation task: %w", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		case <-time.After(waitInterval):
+		}
+	}
+}
+
+func insert(ctx context.Context, db *harmonydb.DB, p Proposal, res *Result) (int64, error) {
+	mid, err := address.IDFromAddress(p.Miner)
+	if err != nil {
+		return 0, xerrors.Errorf("getting miner id: %w", err)
+	}
+
+	propJson, err := json.Marshal(p.Deal)
+	if err != nil {
+		return 0, xerrors.Errorf("marshaling proposal: %w", err)
+	}
+
+	var sig []byte
+	if p.ClientSignature != nil {
+		sig, err = p.ClientSignature.MarshalBinary()
+		if err != nil {
+			return 0, xerrors.Errorf("marshaling client signature: %w", err)
+		}
+	}
+
+	var dealID *int64
+	var client *string
+	var verified bool
+	var psize abi.PaddedPieceSize
+	if p.Deal.DealProposal != nil {
+		if p.Deal.PublishCid != nil {
+			id := int64(p.Deal.DealID)
+			dealID = &id
+		}
+		c := p.Deal.DealProposal.Client.String()
+		client = &c
+		verified = p.Deal.DealProposal.VerifiedDeal
+		psize = p.Deal.DealProposal.PieceSize
+	} else if p.Deal.PieceActivationManifest != nil {
+		psize = p.Deal.PieceActivationManifest.Size
+		if vak := p.Deal.PieceActivationManifest.VerifiedAllocationKey; vak != nil {
+			ca, err := address.NewIDAddress(uint64(vak.Client))
+			if err != nil {
+				return 0, err
+			}
+			c := ca.String()
+			client = &c
+			verified = true
+		}
+	}
+
+	var valid *bool
+	var reason *string
+	var clientID *int64
+	var validatedAt *time.Time
+	if res != nil {
+		now := time.Now()
+		valid, reason, validatedAt = &res.Valid, &res.Reason, &now
+		if res.ClientID != address.Undef {
+			id, err := address.IDFromAddress(res.ClientID)
+			if err != nil {
+				return 0, xerrors.Errorf("getting client id: %w", err)
+			}
+			cl := int64(id)
+			clientID = &cl
+		}
+	}
+
+	var id int64
+	err = db.QueryRow(ctx, `INSERT INTO market_deal_proposals (sp_id, piece_cid, piece_size, deal_id, client, verified,
+			proposal, client_signature, client_id, valid, reason, validated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING proposal_id`,
+		int64(mid), p.Deal.PieceCID().String(), int64(psize), dealID, client, verified,
+		propJson, sig, clientID, valid, reason, validatedAt).Scan(&id)
+	if err != nil {
+		return 0, xerrors.Errorf("recording proposal: %w", err)
+	}
+	return id, nil
+}
+
+func logResult(p Proposal, res Result) {
+	if !res.Valid {
+		log.Infow("deal proposal invalid", "proposal", res.ProposalID, "miner", p.Miner, "piece_cid", p.Deal.PieceCID(), "reason", res.Reason)
+	}
+}
+
+// validate checks a proposal against chain state. Invalid proposals are reported in the result, errors are only
+// returned when the proposal couldn't be checked.
+func validate(ctx context.Context, api ValidateAPI, p Proposal) (Result, error) {
+	deal := p.Deal
+	if (deal.DealProposal == nil) == (deal.PieceActivationManifest == nil) {
+		return invalid("deal info must have either deal proposal or piece manifest"), nil
+	}
+
+	if deal.PieceActivationManifest != nil {
+		return validateDDO(ctx, api, p)
+	}
+
+	prop := deal.DealProposal
+	if prop.Provider != p.Miner {
+		providerID, err := api.StateLookupID(ctx, prop.Provider, types.EmptyTSK)
+		if err != nil || providerID != p.Miner {
+			return invalid("deal is for provider %s, not %s", prop.Provider, p.Miner), nil
+		}
+	}
+
+	clientID, err := api.StateLookupID(ctx, prop.Client, types.EmptyTSK)
+	if err != nil {
+		// clients of deals have to exist on chain to have funds in the market actor
+		return invalid("client %s not found on chain: %s", prop.Client, err), nil
+	}
+	res := Result{Valid: true, ClientID: clientID}
+
+	if deal.PublishCid == nil {
+		// proposals which aren't published yet must be signed by the client, and verified deals covered by its datacap
+		if p.ClientSignature == nil {
+			return invalid("unpublished deal proposal has no client signature"), nil
+		}
+
+		key, err := api.StateAccountKey(ctx, clientID, types.EmptyTSK)
+		if err != nil {
+			return Result{}, xerrors.Errorf("getting key of client %s: %w", prop.Client, err)
+		}
+		buf, err := cborutil.Dump(prop)
+		if err != nil {
+			return Result{}, xerrors.Errorf("serializing deal proposal: %w", err)
+		}
+		if err := sigs.Verify(p.ClientSignature, key, buf); err != nil {
+			return invalidFor(clientID, "client signature doesn't match the deal proposal: %s", err), nil
+		}
+
+		if prop.VerifiedDeal {
+			dcap, err := api.StateVerifiedClientStatus(ctx, clientID, types.EmptyTSK)
+			if err != nil {
+				return Result{}, xerrors.Errorf("getting datacap of client %s: %w", prop.Client, err)
+			}
+			if dcap == nil || dcap.LessThan(abi.NewStoragePower(int64(prop.PieceSize))) {
+				return invalidFor(clientID, "client %s doesn't have datacap for the piece size of %d", prop.Client, prop.PieceSize), nil
+			}
+		}
+		return res, nil
+	}
+
+	// the market actor checked the signature of the client when the deal was published, so the published deal has
+	// to match the proposal
+	md, err := api.StateMarketStorageDeal(ctx, deal.DealID, types.EmptyTSK)
+	if err != nil {
+		return invalidFor(clientID, "deal %d not found on chain: %s", deal.DealID, err), nil
+	}
+	onChain := md.Proposal
+	switch {
+	case onChain.PieceCID != prop.PieceCID:
+		return invalidFor(clientID, "piece CID %s doesn't match %s of published deal %d", prop.PieceCID, onChain.PieceCID, deal.DealID), nil
+	case onChain.PieceSize != prop.PieceSize:
+		return invalidFor(clientID, "piece size %d doesn't match %d of published deal %d", prop.PieceSize, onChain.PieceSize, deal.DealID), nil
+	case onChain.StartEpoch != prop.StartEpoch || onChain.EndEpoch != prop.EndEpoch:
+		return invalidFor(clientID, "deal epochs don't match published deal %d", deal.DealID), nil
+	case onChain.VerifiedDeal != prop.VerifiedDeal:
+		return invalidFor(clientID, "verified flag doesn't match published deal %d", deal.DealID), nil
+	case md.State.SlashEpoch != -1:
+		return invalidFor(clientID, "published deal %d was slashed at epoch %d", deal.DealID, md.State.SlashEpoch), nil
+	}
+
+	onChainClient, err := api.StateLookupID(ctx, onChain.Client, types.EmptyTSK)
+	if err != nil {
+		return Result{}, xerrors.Errorf("looking up client of published deal %d: %w", deal.DealID, err)
+	}
+	if onChainClient != clientID {
+		return invalidFor(clientID, "client %s doesn't match %s of published deal %d", prop.Client, onChain.Client, deal.DealID), nil
+	}
+
+	if prop.VerifiedDeal {
+		// datacap of verified deals is spent on an allocation when the deal is published
+		alloc, err := api.StateGetAllocationForPendingDeal(ctx, deal.DealID, types.EmptyTSK)
+		if err != nil {
+			return Result{}, xerrors.Errorf("getting allocation of deal %d: %w", deal.DealID, err)
+		}
+		if alloc == nil {
+			return invalidFor(clientID, "verified deal %d has no allocation", deal.DealID), nil
+		}
+	}
+
+	return res, nil
+}
+
+func validateDDO(ctx context.Context, api ValidateAPI, p Proposal) (Result, error) {
+	pam := p.Deal.PieceActivationManifest
+	vak := pam.VerifiedAllocationKey
+	if vak == nil {
+		// pieces without an allocation have no client to check
+		return Result{Valid: true}, nil
+	}
+
+	clientID, err := address.NewIDAddress(uint64(vak.Client))
+	if err != nil {
+		return Result{}, err
+	}
+
+	alloc, err := api.StateGetAllocation(ctx, clientID, verifregtypes.AllocationId(vak.ID), types.EmptyTSK)
+	if err != nil {
+		return Result{}, xerrors.Errorf("getting allocation %d: %w", vak.ID, err)
+	}
+	if alloc == nil {
+		return invalidFor(clientID, "allocation %d of client %s not found", vak.ID, clientID), nil
+	}
+
+	mid, err := address.IDFromAddress(p.Miner)
+	if err != nil {
+		return Result{}, err
+	}
+	switch {
+	case uint64(alloc.Provider) != mid:
+		return invalidFor(clientID, "allocation %d is for provider %d, not %s", vak.ID, alloc.Provider, p.Miner), nil
+	case alloc.Data != pam.CID:
+		return invalidFor(clientID, "piece CID %s doesn't match %s of allocation %d", pam.CID, alloc.Data, vak.ID), nil
+	case alloc.Size != pam.Size:
+		return invalidFor(clientID, "piece size %d doesn't match %d of allocation %d", pam.Size, alloc.Size, vak.ID), nil
+	}
+
+	return Result{Valid: true, ClientID: clientID}, nil
+}
+
+func invalid(format string, args ...any) Result {
+	return Result{Valid: false, Reason: fmt.Sprintf(format, args...)}
+}
+
+func invalidFor(clientID address.Address, format string, args ...any) Result {
+	r := invalid(format, args...)
+	r.ClientID = clientID
+	return r
+}
+
+// parseSignature reads a client signature as stored in market_deal_proposals
+func parseSignature(b []byte) (*crypto.Signature, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var sig crypto.Signature
+	if err := sig.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return &sig, nil
+}
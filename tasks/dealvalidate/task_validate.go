@@ -0,0 +1,150 @@
+package dealvalidate
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+)
+
+// ValidateSchedInterval is short, market adapters wait for the results of submitted proposals
+const ValidateSchedInterval = 3 * time.Second
+
+// proposalBatch is the number of proposals validated by one task, so that bursts of proposals are spread over a
+// few tasks without scheduling a task per proposal
+const proposalBatch = 100
+
+// ValidateTask validates deal proposals submitted by market adapters with Ingest.OffloadDealValidation set, and
+// records the result of each proposal in market_deal_proposals.
+type ValidateTask struct {
+	db  *harmonydb.DB
+	api ValidateAPI
+	max int
+}
+
+func NewValidateTask(db *harmonydb.DB, api ValidateAPI, max int) *ValidateTask {
+	return &ValidateTask{
+		db:  db,
+		api: api,
+		max: max,
+	}
+}
+
+func (v *ValidateTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var proposals []struct {
+		ProposalID      int64  `db:"proposal_id"`
+		SpID            int64  `db:"sp_id"`
+		Proposal        []byte `db:"proposal"`
+		ClientSignature []byte `db:"client_signature"`
+	}
+	err = v.db.Select(ctx, &proposals, `SELECT proposal_id, sp_id, proposal, client_signature FROM market_deal_proposals
+		WHERE task_id = $1 AND validated_at IS NULL ORDER BY proposal_id`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting proposals: %w", err)
+	}
+
+	for _, pr := range proposals {
+		if !stillOwned() {
+			return false, nil
+		}
+
+		maddr, err := address.NewIDAddress(uint64(pr.SpID))
+		if err != nil {
+			return false, err
+		}
+
+		p := Proposal{Miner: maddr}
+		if err := json.Unmarshal(pr.Proposal, &p.Deal); err != nil {
+			return false, xerrors.Errorf("unmarshaling proposal %d: %w", pr.ProposalID, err)
+		}
+		p.ClientSignature, err = parseSignature(pr.ClientSignature)
+		if err != nil {
+			return false, xerrors.Errorf("unmarshaling client signature of proposal %d: %w", pr.ProposalID, err)
+		}
+
+		res, err := validate(ctx, v.api, p)
+		if err != nil {
+			return false, xerrors.Errorf("validating proposal %d: %w", pr.ProposalID, err)
+		}
+		res.ProposalID = pr.ProposalID
+
+		var clientID *int64
+		if res.ClientID != address.Undef {
+			id, err := address.IDFromAddress(res.ClientID)
+			if err != nil {
+				return false, err
+			}
+			cl := int64(id)
+			clientID = &cl
+		}
+
+		_, err = v.db.Exec(ctx, `UPDATE market_deal_proposals SET valid = $2, reason = $3, client_id = $4, validated_at = current_timestamp
+			WHERE proposal_id = $1`, pr.ProposalID, res.Valid, res.Reason, clientID)
+		if err != nil {
+			return false, xerrors.Errorf("recording result of proposal %d: %w", pr.ProposalID, err)
+		}
+
+		logResult(p, res)
+	}
+
+	return true, nil
+}
+
+func (v *ValidateTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (v *ValidateTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(v.max),
+		Name: "DealValidate",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored:    passcall.Every(ValidateSchedInterval, v.schedule),
+	}
+}
+
+func (v *ValidateTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (v *ValidateTask) schedule(taskFunc harmonytask.AddTaskFunc) error {
+	var stop bool
+	for !stop {
+		taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			stop = true
+
+			n, err := tx.Exec(`UPDATE market_deal_proposals SET task_id = $1 WHERE proposal_id IN (
+					SELECT proposal_id FROM market_deal_proposals WHERE validated_at IS NULL AND task_id IS NULL
+					ORDER BY proposal_id LIMIT $2)`, id, proposalBatch)
+			if err != nil {
+				return false, xerrors.Errorf("updating task id: %w", err)
+			}
+			if n == 0 {
+				return false, nil
+			}
+
+			stop = false
+			return true, nil
+		})
+	}
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&ValidateTask{})
+var _ harmonytask.TaskInterface = &ValidateTask{}
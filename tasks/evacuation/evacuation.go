@@ -0,0 +1,192 @@
+// Package evacuation moves all data out of storage paths which are being decommissioned, and then detaches them.
+package evacuation
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+var log = logging.Logger("evacuation")
+
+const (
+	StateMoving    = "moving"
+	StateDetaching = "detaching"
+	StateDone      = "done"
+	StateCancelled = "cancelled"
+)
+
+// DetachInterval is how often nodes look for emptied evacuated paths attached to them
+var DetachInterval = time.Minute
+
+// Start marks the storage path read-only and starts an evacuation, which moves all sector and piece files held
+// in it to other paths and then detaches it from every node.
+func Start(ctx context.Context, db *harmonydb.DB, id storiface.ID) (int64, error) {
+	var evacID int64
+	_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+		var archive bool
+		err := tx.QueryRow(`SELECT archive FROM storage_path WHERE storage_id = $1`, id).Scan(&archive)
+		if err != nil {
+			return false, xerrors.Errorf("storage path %s not found: %w", id, err)
+		}
+		if archive {
+			// files can't be removed from archives, so they can't be moved out either
+			return false, xerrors.Errorf("storage path %s is a read-only archive", id)
+		}
+
+		_, err = tx.Exec(`UPDATE storage_path SET read_only = TRUE WHERE storage_id = $1`, id)
+		if err != nil {
+			return false, xerrors.Errorf("marking path read-only: %w", err)
+		}
+
+		err = tx.QueryRow(`INSERT INTO storage_evacuations (storage_id) VALUES ($1) RETURNING evacuation_id`, id).Scan(&evacID)
+		if err != nil {
+			return false, xerrors.Errorf("recording evacuation: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		if harmonydb.IsErrUniqueContraint(err) {
+			return 0, xerrors.Errorf("storage path %s is already being evacuated", id)
+		}
+		return 0, err
+	}
+
+	return evacID, nil
+}
+
+// Cancel stops an evacuation of the storage path which hasn't started detaching it yet, and makes the path
+// writable again. Moves which already finished are not undone.
+func Cancel(ctx context.Context, db *harmonydb.DB, id storiface.ID) error {
+	_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+		var evacID int64
+		err := tx.QueryRow(`UPDATE storage_evacuations SET state = $2, complete_time = current_timestamp
+			WHERE storage_id = $1 AND state = $3 RETURNING evacuation_id`, id, StateCancelled, StateMoving).Scan(&evacID)
+		if err != nil {
+			return false, xerrors.Errorf("no evacuation of %s in progress: %w", id, err)
+		}
+
+		// moves already picked up by a task finish on their own
+		_, err = tx.Exec(`UPDATE storage_evacuation_moves SET error = 'cancelled', complete_time = current_timestamp
+			WHERE evacuation_id = $1 AND task_id IS NULL AND complete_time IS NULL`, evacID)
+		if err != nil {
+			return false, xerrors.Errorf("cancelling pending moves: %w", err)
+		}
+
+		_, err = tx.Exec(`UPDATE storage_path SET read_only = FALSE WHERE storage_id = $1`, id)
+		if err != nil {
+			return false, xerrors.Errorf("marking path writable: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
+}
+
+// plan records moves for all sectors still held in paths being evacuated, and marks evacuations of emptied
+// paths for detaching
+func plan(ctx context.Context, db *harmonydb.DB) error {
+	var evacs []struct {
+		EvacuationID int64  `db:"evacuation_id"`
+		StorageID    string `db:"storage_id"`
+	}
+	err := db.Select(ctx, &evacs, `SELECT evacuation_id, storage_id FROM storage_evacuations WHERE state = $1`, StateMoving)
+	if err != nil {
+		return xerrors.Errorf("getting evacuations: %w", err)
+	}
+
+	for _, e := range evacs {
+		// pieces don't have a sector record, they are always stored as 64GiB sectors of miner 0
+		_, err := db.Exec(ctx, `INSERT INTO storage_evacuation_moves (evacuation_id, sp_id, sector_number, reg_seal_proof, file_types)
+			SELECT $1, sl.miner_id, sl.sector_num,
+				CASE WHEN sl.miner_id = 0 THEN $3 ELSE COALESCE(sm.reg_seal_proof, sp.reg_seal_proof) END,
+				bit_or(sl.sector_filetype)
+			FROM sector_location sl
+				LEFT JOIN sectors_meta sm ON sm.sp_id = sl.miner_id AND sm.sector_num = sl.sector_num
+				LEFT JOIN sectors_sdr_pipeline sp ON sp.sp_id = sl.miner_id AND sp.sector_number = sl.sector_num
+			WHERE sl.storage_id = $2
+				AND NOT EXISTS (SELECT 1 FROM storage_evacuation_moves m
+					WHERE m.evacuation_id = $1 AND m.sp_id = sl.miner_id AND m.sector_number = sl.sector_num AND m.complete_time IS NULL)
+			GROUP BY sl.miner_id, sl.sector_num, sm.reg_seal_proof, sp.reg_seal_proof
+			HAVING sl.miner_id = 0 OR COALESCE(sm.reg_seal_proof, sp.reg_seal_proof) IS NOT NULL
+			ON CONFLICT DO NOTHING`, e.EvacuationID, e.StorageID, int64(storiface.PieceNumber(0).Ref().ProofType))
+		if err != nil {
+			return xerrors.Errorf("planning moves out of %s: %w", e.StorageID, err)
+		}
+
+		n, err := db.Exec(ctx, `UPDATE storage_evacuations SET state = $2 WHERE evacuation_id = $1 AND state = $3
+			AND NOT EXISTS (SELECT 1 FROM sector_location WHERE storage_id = $4)
+			AND NOT EXISTS (SELECT 1 FROM storage_evacuation_moves WHERE evacuation_id = $1 AND complete_time IS NULL)`,
+			e.EvacuationID, StateDetaching, StateMoving, e.StorageID)
+		if err != nil {
+			return xerrors.Errorf("checking if %s is empty: %w", e.StorageID, err)
+		}
+		if n > 0 {
+			log.Infow("evacuated storage path is empty, detaching", "storage", e.StorageID)
+		}
+	}
+
+	return nil
+}
+
+// StartDetacher detaches emptied evacuated paths attached to this node in the background. Every node with the
+// path attached detaches it, the evacuation is done once the path is gone from the index.
+func StartDetacher(ctx context.Context, db *harmonydb.DB, lstor *paths.Local) {
+	go func() {
+		for {
+			if err := detachEvacuated(ctx, db, lstor); err != nil {
+				log.Errorw("detaching evacuated storage paths", "error", err)
+			}
+
+			select {
+			case <-time.After(DetachInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func detachEvacuated(ctx context.Context, db *harmonydb.DB, lstor *paths.Local) error {
+	var detaching []storiface.ID
+	err := db.Select(ctx, &detaching, `SELECT storage_id FROM storage_evacuations WHERE state = $1`, StateDetaching)
+	if err != nil {
+		return xerrors.Errorf("getting evacuations: %w", err)
+	}
+	if len(detaching) == 0 {
+		return nil
+	}
+
+	locals, err := lstor.Local(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	local := map[storiface.ID]bool{}
+	for _, l := range locals {
+		local[l.ID] = true
+	}
+
+	for _, id := range detaching {
+		if local[id] {
+			if err := lstor.DetachPath(ctx, id); err != nil {
+				log.Errorw("detaching evacuated storage path", "storage", id, "error", err)
+				continue
+			}
+			log.Infow("detached evacuated storage path", "storage", id)
+		}
+
+		_, err := db.Exec(ctx, `UPDATE storage_evacuations SET state = $2, complete_time = current_timestamp
+			WHERE storage_id = $1 AND state = $3 AND NOT EXISTS (SELECT 1 FROM storage_path WHERE storage_id = $1)`,
+			id, StateDone, StateDetaching)
+		if err != nil {
+			return xerrors.Errorf("completing evacuation of %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,401 @@
+package evacuation
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+const MinSchedInterval = 10 * time.Second
+
+// PlanInterval is how often moves are planned for data left in evacuated paths. Failed moves are planned
+// again on the next pass.
+const PlanInterval = 5 * time.Minute
+
+const (
+	MethodMove = "move"
+	MethodDrop = "drop"
+)
+
+// StorageEvacuateTask moves the files of one sector out of a path being evacuated. It runs on a node with a
+// local path which can take the files. File types which have a durable copy in another path are only removed
+// from the evacuated path.
+type StorageEvacuateTask struct {
+	db    *harmonydb.DB
+	stor  *paths.Remote
+	lstor *paths.Local
+	index paths.SectorIndex
+	max   int
+
+	lastPlan time.Time
+}
+
+func NewStorageEvacuateTask(db *harmonydb.DB, stor *paths.Remote, lstor *paths.Local, index paths.SectorIndex, max int) *StorageEvacuateTask {
+	return &StorageEvacuateTask{
+		db:    db,
+		stor:  stor,
+		lstor: lstor,
+		index: index,
+		max:   max,
+	}
+}
+
+type evacMove struct {
+	MoveID       int64  `db:"move_id"`
+	SpID         int64  `db:"sp_id"`
+	SectorNumber int64  `db:"sector_number"`
+	RegSealProof int64  `db:"reg_seal_proof"`
+	FileTypes    int64  `db:"file_types"`
+	StorageID    string `db:"storage_id"`
+}
+
+func (mv evacMove) ref() storiface.SectorRef {
+	return storiface.SectorRef{
+		ID:        abi.SectorID{Miner: abi.ActorID(mv.SpID), Number: abi.SectorNumber(mv.SectorNumber)},
+		ProofType: abi.RegisteredSealProof(mv.RegSealProof),
+	}
+}
+
+func (t *StorageEvacuateTask) getMove(ctx context.Context, taskID harmonytask.TaskID) (*evacMove, error) {
+	var moves []evacMove
+	err := t.db.Select(ctx, &moves, `SELECT m.move_id, m.sp_id, m.sector_number, m.reg_seal_proof, m.file_types, e.storage_id
+		FROM storage_evacuation_moves m
+		INNER JOIN storage_evacuations e ON e.evacuation_id = m.evacuation_id
+		WHERE m.task_id = $1 AND m.complete_time IS NULL`, taskID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting evacuation move: %w", err)
+	}
+	if len(moves) != 1 {
+		return nil, xerrors.Errorf("expected 1 evacuation move, got %d", len(moves))
+	}
+	return &moves[0], nil
+}
+
+func (t *StorageEvacuateTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	tid := int64(taskID)
+	ctx := paths.WithReservationLease(context.Background(), paths.ReservationLeaseInfo{TaskID: &tid, Purpose: "path evacuation"})
+
+	mv, err := t.getMove(ctx, taskID)
+	if err != nil {
+		return false, err
+	}
+
+	// failed moves are completed with an error, the sector is planned again on a
+	// later pass if it's still in the evacuated path
+	var method, toID, errStr *string
+	m, dest, err := t.evacuate(ctx, *mv)
+	if err != nil {
+		log.Warnw("storage evacuation move failed", "move", mv.MoveID, "sp", mv.SpID, "sector", mv.SectorNumber, "from", mv.StorageID, "error", err)
+		es := err.Error()
+		errStr = &es
+	} else {
+		method = &m
+		if dest != "" {
+			id := string(dest)
+			toID = &id
+		}
+	}
+
+	_, err = t.db.Exec(ctx, `UPDATE storage_evacuation_moves SET method = $2, to_storage_id = $3, error = $4, complete_time = current_timestamp
+		WHERE move_id = $1`, mv.MoveID, method, toID, errStr)
+	if err != nil {
+		return false, xerrors.Errorf("marking evacuation move complete: %w", err)
+	}
+
+	return true, nil
+}
+
+// evacuate removes the sector files from the evacuated path, first moving files which don't have a durable copy
+// in another path to a local path. Returns the path files were moved to, if any were.
+func (t *StorageEvacuateTask) evacuate(ctx context.Context, mv evacMove) (string, storiface.ID, error) {
+	sref := mv.ref()
+	from := storiface.ID(mv.StorageID)
+	ft := storiface.SectorFileType(mv.FileTypes)
+
+	// the lock is held until the files are moved or dropped, so evacuations of other paths holding the sector
+	// don't count a copy this one is about to remove
+	lockCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	locked, err := t.index.StorageTryLock(lockCtx, sref.ID, storiface.FTNone, ft)
+	if err != nil {
+		return "", "", xerrors.Errorf("locking sector files: %w", err)
+	}
+	if !locked {
+		return "", "", xerrors.Errorf("sector files are locked")
+	}
+
+	toMove, toDrop, err := t.split(ctx, sref.ID, from, ft)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, f := range toDrop.AllSet() {
+		if err := t.dropCopy(ctx, sref.ID, from, f); err != nil {
+			return "", "", err
+		}
+	}
+
+	if toMove == storiface.FTNone {
+		return MethodDrop, "", nil
+	}
+
+	dest, err := t.move(ctx, sref, from, toMove)
+	if err != nil {
+		return "", "", err
+	}
+	return MethodMove, dest, nil
+}
+
+// split sorts the file types still in the evacuated path into the ones which have to be moved, and the ones
+// which have a durable copy in another path and are only removed
+func (t *StorageEvacuateTask) split(ctx context.Context, sid abi.SectorID, from storiface.ID, ft storiface.SectorFileType) (toMove, toDrop storiface.SectorFileType, err error) {
+	for _, f := range ft.AllSet() {
+		copies, err := t.index.StorageFindSector(ctx, sid, f, 0, false)
+		if err != nil {
+			return 0, 0, xerrors.Errorf("finding %s copies: %w", f, err)
+		}
+
+		var inFrom bool
+		for _, c := range copies {
+			if c.ID == from {
+				inFrom = true
+			}
+		}
+		if !inFrom {
+			// already gone, e.g. removed by the sealing pipeline
+			continue
+		}
+
+		durable, err := t.durableCopies(ctx, sid, from, f)
+		if err != nil {
+			return 0, 0, err
+		}
+		if durable > 0 {
+			toDrop |= f
+		} else {
+			toMove |= f
+		}
+	}
+
+	return toMove, toDrop, nil
+}
+
+// durableCopies counts the copies of a sector file outside the evacuated path which can be relied on once it's
+// removed there: in live, healthy paths which aren't evacuated themselves. Unsealed copies in the unsealed cache
+// are evicted at some point, unless they are in an archive path, so they don't count either.
+func (t *StorageEvacuateTask) durableCopies(ctx context.Context, sid abi.SectorID, from storiface.ID, ft storiface.SectorFileType) (int, error) {
+	var n int
+	err := t.db.QueryRow(ctx, `SELECT COUNT(*) FROM sector_location sl
+		INNER JOIN storage_path sp ON sp.storage_id = sl.storage_id
+		WHERE sl.miner_id = $1 AND sl.sector_num = $2 AND sl.sector_filetype = $3 AND sl.storage_id != $4
+			AND NOT sp.degraded AND sp.heartbeat_err IS NULL
+			AND NOW() - ($5 * INTERVAL '1 second') < sp.last_heartbeat
+			AND NOT EXISTS (SELECT 1 FROM storage_evacuations e
+				WHERE e.storage_id = sl.storage_id AND e.state IN ('moving', 'detaching'))
+			AND NOT (sl.sector_filetype = $6 AND NOT sp.archive AND EXISTS (SELECT 1 FROM unsealed_cache c
+				WHERE c.sp_id = sl.miner_id AND c.sector_num = sl.sector_num))`,
+		sid.Miner, sid.Number, int(ft), string(from), paths.SkippedHeartbeatThresh.Seconds(), int(storiface.FTUnsealed)).Scan(&n)
+	if err != nil {
+		return 0, xerrors.Errorf("counting durable %s copies: %w", ft, err)
+	}
+	return n, nil
+}
+
+// dropCopy removes the copy of a sector file held in the path, keeping all other copies. The caller holds the
+// sector lock and checked that a durable copy is left.
+func (t *StorageEvacuateTask) dropCopy(ctx context.Context, sid abi.SectorID, from storiface.ID, ft storiface.SectorFileType) error {
+	copies, err := t.index.StorageFindSector(ctx, sid, ft, 0, false)
+	if err != nil {
+		return xerrors.Errorf("finding %s copies: %w", ft, err)
+	}
+
+	var keep []storiface.ID
+	for _, c := range copies {
+		if c.ID != from {
+			keep = append(keep, c.ID)
+		}
+	}
+	if len(keep) == 0 {
+		return xerrors.Errorf("no other copy of %s left", ft)
+	}
+
+	if err := t.stor.Remove(ctx, sid, ft, true, keep); err != nil {
+		return xerrors.Errorf("removing %s from %s: %w", ft, from, err)
+	}
+	return nil
+}
+
+// move moves sector files held only in the evacuated path into a local path, like a storage tier move
+func (t *StorageEvacuateTask) move(ctx context.Context, sref storiface.SectorRef, from storiface.ID, ft storiface.SectorFileType) (storiface.ID, error) {
+	pathType, err := t.pathType(ctx, from)
+	if err != nil {
+		return "", err
+	}
+
+	dest, err := t.pickDest(ctx, sref, from, ft, pathType)
+	if err != nil {
+		return "", err
+	}
+	if dest == nil {
+		return "", xerrors.Errorf("no local path accepts %s for miner %d", ft, sref.ID.Miner)
+	}
+
+	if err := paths.MoveSectorInto(ctx, t.stor, t.lstor, t.index, sref, ft, *dest, pathType); err != nil {
+		return "", err
+	}
+
+	return dest.ID, nil
+}
+
+// pathType is the type of paths files of the evacuated path go to: store paths for store paths, sealing
+// paths otherwise
+func (t *StorageEvacuateTask) pathType(ctx context.Context, from storiface.ID) (storiface.PathType, error) {
+	si, err := t.index.StorageInfo(ctx, from)
+	if err != nil {
+		return "", xerrors.Errorf("getting storage info for %s: %w", from, err)
+	}
+	if si.CanStore {
+		return storiface.PathStorage, nil
+	}
+	return storiface.PathSealing, nil
+}
+
+// pickDest selects a local path other than the evacuated one for the files, in the order of the placement
+// policy. Read-only paths, including other paths being evacuated, are never picked.
+func (t *StorageEvacuateTask) pickDest(ctx context.Context, sref storiface.SectorRef, from storiface.ID, ft storiface.SectorFileType, pathType storiface.PathType) (*storiface.StoragePath, error) {
+	ssize, err := sref.ProofType.SectorSize()
+	if err != nil {
+		return nil, err
+	}
+
+	cands, err := t.index.StorageBestAlloc(ctx, ft, ssize, pathType, sref.ID.Miner)
+	if err != nil {
+		return nil, xerrors.Errorf("finding paths for %s: %w", ft, err)
+	}
+
+	locals, err := t.lstor.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	local := map[storiface.ID]storiface.StoragePath{}
+	for _, l := range locals {
+		local[l.ID] = l
+	}
+
+	for _, c := range cands {
+		l, ok := local[c.ID]
+		if !ok || c.ID == from || !ft.Allowed(c.AllowTypes, c.DenyTypes) {
+			continue
+		}
+		return &l, nil
+	}
+
+	return nil, nil
+}
+
+func (t *StorageEvacuateTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ctx := context.Background()
+
+	for _, id := range ids {
+		mv, err := t.getMove(ctx, id)
+		if err != nil {
+			// picked up by another node, or cancelled
+			continue
+		}
+
+		from := storiface.ID(mv.StorageID)
+		toMove, _, err := t.split(ctx, mv.ref().ID, from, storiface.SectorFileType(mv.FileTypes))
+		if err != nil {
+			return nil, err
+		}
+		if toMove == storiface.FTNone {
+			// drops can run anywhere
+			return &id, nil
+		}
+
+		pathType, err := t.pathType(ctx, from)
+		if err != nil {
+			return nil, err
+		}
+
+		dest, err := t.pickDest(ctx, mv.ref(), from, toMove, pathType)
+		if err != nil {
+			return nil, err
+		}
+		if dest != nil {
+			return &id, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (t *StorageEvacuateTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(t.max),
+		Name: "StorageEvacuate",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 128 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(MinSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return t.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (t *StorageEvacuateTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (t *StorageEvacuateTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	if time.Since(t.lastPlan) > PlanInterval {
+		t.lastPlan = time.Now()
+		if err := plan(ctx, t.db); err != nil {
+			log.Errorw("planning storage evacuation moves", "error", err)
+		}
+	}
+
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var moves []struct {
+			MoveID int64 `db:"move_id"`
+		}
+
+		err := tx.Select(&moves, `SELECT move_id FROM storage_evacuation_moves WHERE task_id IS NULL AND complete_time IS NULL LIMIT 20`)
+		if err != nil {
+			return false, xerrors.Errorf("getting evacuation moves: %w", err)
+		}
+
+		if len(moves) == 0 {
+			return false, nil
+		}
+
+		// pick at random in case there are a bunch of schedules across the cluster
+		mv := moves[rand.N(len(moves))]
+
+		_, err = tx.Exec(`UPDATE storage_evacuation_moves SET task_id = $1 WHERE move_id = $2 AND task_id IS NULL`, id, mv.MoveID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return true, nil
+	})
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&StorageEvacuateTask{})
+var _ harmonytask.TaskInterface = &StorageEvacuateTask{}
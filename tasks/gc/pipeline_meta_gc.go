@@ -169,6 +169,15 @@ func (s *PipelineGC) cleanupUnseal() error {
 		return xerrors.Errorf("failed to clean up unseal entries: %w", err)
 	}
 
+	// Unsealed ranges are kept for a day, so that retrievals waiting on them can see that they were unsealed
+	_, err = s.db.Exec(ctx, `DELETE FROM sectors_unseal_ranges
+									WHERE after_unseal = TRUE
+									AND unsealed_at < current_timestamp - INTERVAL '1 day';
+`)
+	if err != nil {
+		return xerrors.Errorf("failed to clean up unseal range entries: %w", err)
+	}
+
 	return nil
 }
 
@@ -77,6 +77,16 @@ func (s *StorageGCMark) Do(taskID harmonytask.TaskID, stillOwned func() bool) (d
 		return false, xerrors.Errorf("StorageList: %w", err)
 	}
 
+	// Files in read-only archive paths are never removed
+	var archives []string
+	err = s.db.Select(ctx, &archives, `SELECT storage_id FROM storage_path WHERE archive`)
+	if err != nil {
+		return false, xerrors.Errorf("select archive paths: %w", err)
+	}
+	for _, id := range archives {
+		delete(storageSectors, storiface.ID(id))
+	}
+
 	// ToRemove += InStorage - Precommits - Live - Unproven - Pinned - InPorepPipeline
 	toRemove := map[abi.ActorID]*bitfield.BitField{}
 	minerStates := map[abi.ActorID]miner.State{}
@@ -262,8 +272,10 @@ func (s *StorageGCMark) Do(taskID harmonytask.TaskID, stillOwned func() bool) (d
 
 		err = tx.Select(&unsealedSectors, `SELECT m.sector_num, m.sp_id, sl.storage_id FROM sectors_meta m
 			INNER JOIN sector_location sl ON m.sp_id = sl.miner_id AND m.sector_num = sl.sector_num
+			INNER JOIN storage_path sp ON sp.storage_id = sl.storage_id
 			LEFT JOIN sectors_unseal_pipeline sup ON m.sp_id = sup.sp_id AND m.sector_num = sup.sector_number
-			WHERE m.target_unseal_state = false AND sl.sector_filetype= 1 AND sup.sector_number IS NULL`) // FTUnsealed = 1
+			WHERE m.target_unseal_state = false AND sl.sector_filetype= 1 AND sup.sector_number IS NULL AND NOT sp.archive
+			  AND NOT EXISTS (SELECT 1 FROM sectors_unseal_ranges r WHERE r.sp_id = m.sp_id AND r.sector_number = m.sector_num AND NOT r.after_unseal)`) // FTUnsealed = 1
 		if err != nil {
 			return false, xerrors.Errorf("select unsealed sectors: %w", err)
 		}
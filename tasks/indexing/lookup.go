@@ -0,0 +1,225 @@
+package indexing
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/unseal"
+)
+
+// ErrBlockNotFound is returned when no indexed piece holds a block
+var ErrBlockNotFound = xerrors.New("block not found in the index")
+
+// BlockLocation is where the data of a block is in a piece, in unpadded bytes
+type BlockLocation struct {
+	PieceCID string
+	Offset   uint64
+	Size     uint64
+}
+
+// FindBlock returns the locations of a block in indexed pieces. Blocks are indexed by multihash, so blocks with the
+// same data but a different codec are found too.
+func FindBlock(ctx context.Context, db *harmonydb.DB, c cid.Cid) ([]BlockLocation, error) {
+	var rows []struct {
+		PieceCID string `db:"piece_cid"`
+		Offset   int64  `db:"block_offset"`
+		Size     int64  `db:"block_size"`
+	}
+	err := db.Select(ctx, &rows, `SELECT piece_cid, block_offset, block_size FROM piece_block_index WHERE multihash = $1`, []byte(c.Hash()))
+	if err != nil {
+		return nil, xerrors.Errorf("looking up block: %w", err)
+	}
+
+	locs := make([]BlockLocation, len(rows))
+	for i, r := range rows {
+		locs[i] = BlockLocation{PieceCID: r.PieceCID, Offset: uint64(r.Offset), Size: uint64(r.Size)}
+	}
+	return locs, nil
+}
+
+// PieceSector is a sector holding a piece
+type PieceSector struct {
+	Sector storiface.SectorRef
+
+	// Offset of the piece in the sector, in unpadded bytes
+	Offset storiface.UnpaddedByteIndex
+}
+
+// PieceSectors returns the sectors holding a piece. Pieces are placed in sectors in order, each one at the next
+// offset aligned to its padded size.
+func PieceSectors(ctx context.Context, db *harmonydb.DB, pieceCID string) ([]PieceSector, error) {
+	var pieces []struct {
+		SpID         int64  `db:"sp_id"`
+		SectorNum    int64  `db:"sector_num"`
+		RegSealProof int64  `db:"reg_seal_proof"`
+		PieceCID     string `db:"piece_cid"`
+		PieceSize    int64  `db:"piece_size"`
+	}
+	err := db.Select(ctx, &pieces, `SELECT p.sp_id, p.sector_num, m.reg_seal_proof, p.piece_cid, p.piece_size
+		FROM sectors_meta_pieces p
+		JOIN sectors_meta m ON m.sp_id = p.sp_id AND m.sector_num = p.sector_num
+		WHERE (p.sp_id, p.sector_num) IN (SELECT sp_id, sector_num FROM sectors_meta_pieces WHERE piece_cid = $1)
+		ORDER BY p.sp_id, p.sector_num, p.piece_num`, pieceCID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector pieces: %w", err)
+	}
+
+	var out []PieceSector
+	var prevSector abi.SectorID
+	var offset abi.PaddedPieceSize
+	for _, p := range pieces {
+		sid := abi.SectorID{Miner: abi.ActorID(p.SpID), Number: abi.SectorNumber(p.SectorNum)}
+		if sid != prevSector {
+			prevSector = sid
+			offset = 0
+		}
+
+		size := abi.PaddedPieceSize(p.PieceSize)
+		if size == 0 {
+			continue
+		}
+		offset = (offset + size - 1) / size * size
+
+		if p.PieceCID == pieceCID {
+			out = append(out, PieceSector{
+				Sector: storiface.SectorRef{ID: sid, ProofType: abi.RegisteredSealProof(p.RegSealProof)},
+				Offset: storiface.UnpaddedByteIndex(offset.Unpadded()),
+			})
+		}
+		offset += size
+	}
+
+	return out, nil
+}
+
+// BlockReader reads blocks found in the index from parked copies of pieces, or from unsealed copies of the sectors
+// holding the pieces. Only the fr32 chunks holding a block are read, whole pieces or sectors are never read.
+type BlockReader struct {
+	db *harmonydb.DB
+	sc *ffi.SealCalls
+}
+
+func NewBlockReader(db *harmonydb.DB, sc *ffi.SealCalls) *BlockReader {
+	return &BlockReader{db: db, sc: sc}
+}
+
+// ReadBlock returns the data of a block. Parked pieces are read first, then ranges of sectors which are already
+// unsealed. When unsealOnDemand is set and neither holds the block, the range of the block is unsealed in the first
+// sector holding it, waiting until it's unsealed. The data is checked against the CID.
+func (br *BlockReader) ReadBlock(ctx context.Context, c cid.Cid, unsealOnDemand bool) ([]byte, error) {
+	locs, err := FindBlock(ctx, br.db, c)
+	if err != nil {
+		return nil, err
+	}
+	if len(locs) == 0 {
+		return nil, ErrBlockNotFound
+	}
+
+	for _, loc := range locs {
+		data, err := br.readParked(ctx, loc)
+		if err != nil {
+			log.Warnw("reading block from parked piece", "cid", c, "piece_cid", loc.PieceCID, "error", err)
+			continue
+		}
+		if data != nil {
+			return verify(c, data)
+		}
+	}
+
+	type sectorRange struct {
+		sector PieceSector
+		loc    BlockLocation
+	}
+	var sealed []sectorRange
+
+	for _, loc := range locs {
+		sectors, err := PieceSectors(ctx, br.db, loc.PieceCID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range sectors {
+			offset := s.Offset + storiface.UnpaddedByteIndex(loc.Offset)
+
+			has, err := br.sc.IsUnsealed(ctx, s.Sector, ffi.UnsealedChunks(offset, loc.Size))
+			if err != nil {
+				log.Warnw("checking unsealed range", "cid", c, "sector", s.Sector.ID, "error", err)
+				continue
+			}
+			if !has {
+				sealed = append(sealed, sectorRange{sector: s, loc: loc})
+				continue
+			}
+
+			data, err := br.sc.ReadUnsealed(ctx, s.Sector, offset, loc.Size)
+			if err != nil {
+				log.Warnw("reading block from unsealed sector", "cid", c, "sector", s.Sector.ID, "error", err)
+				continue
+			}
+			return verify(c, data)
+		}
+	}
+
+	if !unsealOnDemand || len(sealed) == 0 {
+		return nil, xerrors.Errorf("block %s is not in a parked piece or an unsealed sector range: %w", c, ErrBlockNotFound)
+	}
+
+	s := sealed[0]
+	offset := s.sector.Offset + storiface.UnpaddedByteIndex(s.loc.Offset)
+	r := ffi.UnsealedChunks(offset, s.loc.Size)
+	if err := unseal.RequestRange(ctx, br.db, s.sector.Sector.ID, r.Offset, r.Size); err != nil {
+		return nil, err
+	}
+	if err := unseal.WaitRange(ctx, br.db, s.sector.Sector.ID, r.Offset, r.Size); err != nil {
+		return nil, err
+	}
+
+	data, err := br.sc.ReadUnsealed(ctx, s.sector.Sector, offset, s.loc.Size)
+	if err != nil {
+		return nil, xerrors.Errorf("reading block from unsealed sector: %w", err)
+	}
+	return verify(c, data)
+}
+
+// readParked reads a block from the parked copy of its piece, returning nil data when the piece isn't parked
+func (br *BlockReader) readParked(ctx context.Context, loc BlockLocation) ([]byte, error) {
+	var ids []int64
+	err := br.db.Select(ctx, &ids, `SELECT id FROM parked_pieces WHERE piece_cid = $1 AND complete = TRUE`, loc.PieceCID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting parked piece: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	rd, err := br.sc.PieceRangeReader(ctx, storiface.PieceNumber(ids[0]), loc.Offset, loc.Size)
+	if err != nil {
+		return nil, xerrors.Errorf("getting piece reader: %w", err)
+	}
+	defer rd.Close() // nolint:errcheck
+
+	data := make([]byte, loc.Size)
+	if _, err := io.ReadFull(rd, data); err != nil {
+		return nil, xerrors.Errorf("reading block: %w", err)
+	}
+	return data, nil
+}
+
+func verify(c cid.Cid, data []byte) ([]byte, error) {
+	got, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, xerrors.Errorf("hashing block: %w", err)
+	}
+	if !got.Equals(c) {
+		return nil, xerrors.Errorf("block data doesn't match %s, got %s", c, got)
+	}
+	return data, nil
+}
@@ -0,0 +1,204 @@
+package indexing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/carindex"
+	"github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+var log = logging.Logger("indexing")
+
+const IndexSchedInterval = 30 * time.Second
+
+// insertBatch is the number of blocks written to the index in one statement
+const insertBatch = 10000
+
+// Indexing states of pieces, see piece_index_state
+const (
+	StateIndexing = "indexing"
+	StateIndexed  = "indexed"
+	StateNotCar   = "not-car"
+	StateFailed   = "failed"
+)
+
+// IndexPieceTask reads the CARs of parked pieces and records the location of each block in piece_block_index, so
+// that blocks can be read from pieces with range reads. Pieces which aren't CARs are only marked as such.
+type IndexPieceTask struct {
+	db *harmonydb.DB
+	sc *ffi.SealCalls
+
+	max int
+}
+
+func NewIndexPieceTask(db *harmonydb.DB, sc *ffi.SealCalls, max int) *IndexPieceTask {
+	return &IndexPieceTask{
+		db:  db,
+		sc:  sc,
+		max: max,
+	}
+}
+
+func (i *IndexPieceTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var pieces []struct {
+		PieceCID string `db:"piece_cid"`
+		PieceID  *int64 `db:"id"`
+		RawSize  *int64 `db:"piece_raw_size"`
+	}
+	err = i.db.Select(ctx, &pieces, `SELECT s.piece_cid, pp.id, pp.piece_raw_size FROM piece_index_state s
+		LEFT JOIN parked_pieces pp ON pp.piece_cid = s.piece_cid AND pp.complete = TRUE
+		WHERE s.task_id = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting piece to index: %w", err)
+	}
+	if len(pieces) != 1 {
+		return false, xerrors.Errorf("expected 1 piece to index, got %d", len(pieces))
+	}
+	piece := pieces[0]
+
+	if piece.PieceID == nil {
+		return i.finish(ctx, taskID, piece.PieceCID, StateFailed, 0, "piece is no longer parked")
+	}
+
+	rd, err := i.sc.PieceReader(ctx, storiface.PieceNumber(*piece.PieceID))
+	if err != nil {
+		return false, xerrors.Errorf("getting piece reader: %w", err)
+	}
+	defer rd.Close() // nolint:errcheck
+
+	// blocks of a previous attempt
+	if _, err := i.db.Exec(ctx, `DELETE FROM piece_block_index WHERE piece_cid = $1`, piece.PieceCID); err != nil {
+		return false, xerrors.Errorf("removing old block index entries: %w", err)
+	}
+
+	var mhs [][]byte
+	var offsets, sizes []int64
+	var blocks int64
+
+	flush := func() error {
+		if len(mhs) == 0 {
+			return nil
+		}
+		_, err := i.db.Exec(ctx, `INSERT INTO piece_block_index (multihash, piece_cid, block_offset, block_size)
+			SELECT mh, $1, off, size FROM unnest($2::BYTEA[], $3::BIGINT[], $4::BIGINT[]) AS b(mh, off, size)
+			ON CONFLICT (multihash, piece_cid) DO NOTHING`, piece.PieceCID, mhs, offsets, sizes)
+		if err != nil {
+			return xerrors.Errorf("inserting block index entries: %w", err)
+		}
+		mhs, offsets, sizes = mhs[:0], offsets[:0], sizes[:0]
+		return nil
+	}
+
+	start := time.Now()
+	err = carindex.Walk(io.LimitReader(rd, *piece.RawSize), func(b carindex.Block) error {
+		mhs = append(mhs, b.Cid.Hash())
+		offsets = append(offsets, int64(b.Offset))
+		sizes = append(sizes, int64(b.Size))
+		blocks++
+
+		if len(mhs) >= insertBatch {
+			if !stillOwned() {
+				return xerrors.Errorf("task no longer owned")
+			}
+			return flush()
+		}
+		return nil
+	})
+	if errors.Is(err, carindex.ErrNotCar) {
+		return i.finish(ctx, taskID, piece.PieceCID, StateNotCar, 0, "")
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		log.Errorw("indexing piece", "piece_cid", piece.PieceCID, "error", err)
+		if _, derr := i.db.Exec(ctx, `DELETE FROM piece_block_index WHERE piece_cid = $1`, piece.PieceCID); derr != nil {
+			return false, xerrors.Errorf("removing block index entries of failed piece: %w", derr)
+		}
+		return i.finish(ctx, taskID, piece.PieceCID, StateFailed, 0, err.Error())
+	}
+
+	log.Infow("indexed piece", "piece_cid", piece.PieceCID, "blocks", blocks, "took", time.Since(start))
+	return i.finish(ctx, taskID, piece.PieceCID, StateIndexed, blocks, "")
+}
+
+func (i *IndexPieceTask) finish(ctx context.Context, taskID harmonytask.TaskID, pieceCID, state string, blocks int64, errMsg string) (bool, error) {
+	var e *string
+	if errMsg != "" {
+		e = &errMsg
+	}
+
+	_, err := i.db.Exec(ctx, `UPDATE piece_index_state SET state = $3, blocks = $4, error = $5, task_id = NULL, indexed_at = current_timestamp
+		WHERE piece_cid = $1 AND task_id = $2`, pieceCID, taskID, state, blocks, e)
+	if err != nil {
+		return false, xerrors.Errorf("updating piece index state: %w", err)
+	}
+	return true, nil
+}
+
+func (i *IndexPieceTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (i *IndexPieceTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(i.max),
+		Name: "IndexPiece",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 128 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored:    passcall.Every(IndexSchedInterval, i.schedule),
+	}
+}
+
+func (i *IndexPieceTask) schedule(taskFunc harmonytask.AddTaskFunc) error {
+	// index one parked piece which wasn't indexed yet when we're bored
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		// pieces whose task gave up are picked up again first
+		n, err := tx.Exec(`UPDATE piece_index_state SET task_id = $1
+			WHERE piece_cid = (SELECT s.piece_cid FROM piece_index_state s
+				WHERE s.state = 'indexing' AND (s.task_id IS NULL OR NOT EXISTS (SELECT 1 FROM harmony_task t WHERE t.id = s.task_id))
+				LIMIT 1)`, id)
+		if err != nil {
+			return false, xerrors.Errorf("retrying piece index: %w", err)
+		}
+		if n == 1 {
+			return true, nil
+		}
+
+		n, err = tx.Exec(`INSERT INTO piece_index_state (piece_cid, task_id)
+			SELECT pp.piece_cid, $1 FROM parked_pieces pp
+			WHERE pp.complete = TRUE AND NOT EXISTS (SELECT 1 FROM piece_index_state s WHERE s.piece_cid = pp.piece_cid)
+			ORDER BY pp.id LIMIT 1
+			ON CONFLICT (piece_cid) DO NOTHING`, id)
+		if err != nil {
+			return false, xerrors.Errorf("adding piece to index: %w", err)
+		}
+
+		return n == 1, nil
+	})
+
+	return nil
+}
+
+func (i *IndexPieceTask) Adder(taskFunc harmonytask.AddTaskFunc) {}
+
+var _ = harmonytask.Reg(&IndexPieceTask{})
+var _ harmonytask.TaskInterface = &IndexPieceTask{}
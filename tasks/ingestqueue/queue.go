@@ -0,0 +1,241 @@
+package ingestqueue
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	miner12 "github.com/filecoin-project/go-state-types/builtin/v12/miner"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+var log = logging.Logger("ingestqueue")
+
+// States of queued pieces
+const (
+	StateQueued   = "queued"
+	StateAssigned = "assigned"
+	StateFailed   = "failed"
+)
+
+// Provider is an SP taking pieces from the shared ingest queue
+type Provider struct {
+	SpID              int64      `db:"sp_id"`
+	Region            *string    `db:"region"`
+	MinDurationEpochs int64      `db:"min_duration_epochs"`
+	MaxDurationEpochs int64      `db:"max_duration_epochs"`
+	MaxPipeline       int64      `db:"max_pipeline"`
+	LastAssignedAt    *time.Time `db:"last_assigned_at"`
+	CreatedAt         time.Time  `db:"created_at"`
+}
+
+// QueuedPiece is a piece in the shared ingest queue
+type QueuedPiece struct {
+	ID             int64      `db:"id"`
+	PieceCID       string     `db:"piece_cid"`
+	PieceSize      int64      `db:"piece_size"`
+	RawSize        int64      `db:"raw_size"`
+	DataURL        string     `db:"data_url"`
+	KeepUnsealed   bool       `db:"keep_unsealed"`
+	DurationEpochs int64      `db:"duration_epochs"`
+	Region         *string    `db:"region"`
+	State          string     `db:"state"`
+	SpID           *int64     `db:"sp_id"`
+	SectorNumber   *int64     `db:"sector_number"`
+	AttemptedAt    *time.Time `db:"attempted_at"`
+	WaitingReason  *string    `db:"waiting_reason"`
+	Error          *string    `db:"error"`
+	TaskID         *int64     `db:"task_id"`
+	CreatedAt      time.Time  `db:"created_at"`
+	AssignedAt     *time.Time `db:"assigned_at"`
+}
+
+// Piece is a piece to add to the shared ingest queue
+type Piece struct {
+	PieceCID cid.Cid
+	Size     abi.PaddedPieceSize
+	RawSize  int64
+
+	DataURL     url.URL
+	DataHeaders http.Header
+
+	// DurationDays is the time the piece has to be stored for, the commitment of the sector it's added to
+	DurationDays int64
+	// Region restricts the piece to providers in the region, empty for any provider
+	Region string
+
+	KeepUnsealed bool
+}
+
+func durationEpochs(days int64) (int64, error) {
+	d := days * builtin.EpochsInDay
+	if d > int64(miner12.MaxSectorExpirationExtension) {
+		return 0, xerrors.Errorf("duration exceeds max allowed: %d > %d", d, miner12.MaxSectorExpirationExtension)
+	}
+	if d < int64(miner12.MinSectorExpiration) {
+		return 0, xerrors.Errorf("duration is too short: %d < %d", d, miner12.MinSectorExpiration)
+	}
+	return d, nil
+}
+
+// SetProvider makes a miner take pieces from the shared ingest queue: pieces without a region, or in the region of
+// the miner, with a duration between minDays and maxDays, while the miner has fewer than maxPipeline sectors in its
+// sealing pipeline.
+func SetProvider(ctx context.Context, db *harmonydb.DB, maddr address.Address, region string, minDays, maxDays, maxPipeline int64) error {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner id: %w", err)
+	}
+	if maxPipeline <= 0 {
+		return xerrors.Errorf("max pipeline must be positive")
+	}
+
+	minDuration, err := durationEpochs(minDays)
+	if err != nil {
+		return xerrors.Errorf("min duration: %w", err)
+	}
+	maxDuration, err := durationEpochs(maxDays)
+	if err != nil {
+		return xerrors.Errorf("max duration: %w", err)
+	}
+	if minDuration > maxDuration {
+		return xerrors.Errorf("min duration is longer than max duration")
+	}
+
+	var reg *string
+	if region != "" {
+		reg = &region
+	}
+
+	_, err = db.Exec(ctx, `INSERT INTO ingest_queue_providers (sp_id, region, min_duration_epochs, max_duration_epochs, max_pipeline)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (sp_id) DO UPDATE SET region = excluded.region, min_duration_epochs = excluded.min_duration_epochs,
+			max_duration_epochs = excluded.max_duration_epochs, max_pipeline = excluded.max_pipeline`,
+		int64(mid), reg, minDuration, maxDuration, maxPipeline)
+	if err != nil {
+		return xerrors.Errorf("setting ingest queue provider: %w", err)
+	}
+	return nil
+}
+
+// RemoveProvider stops assigning queued pieces to a miner. Pieces already assigned stay in its sectors.
+func RemoveProvider(ctx context.Context, db *harmonydb.DB, maddr address.Address) error {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner id: %w", err)
+	}
+
+	n, err := db.Exec(ctx, `DELETE FROM ingest_queue_providers WHERE sp_id = $1`, int64(mid))
+	if err != nil {
+		return xerrors.Errorf("removing ingest queue provider: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("%s doesn't take pieces from the ingest queue", maddr)
+	}
+	return nil
+}
+
+// Providers lists the SPs taking pieces from the shared ingest queue.
+func Providers(ctx context.Context, db *harmonydb.DB) ([]Provider, error) {
+	var out []Provider
+	err := db.Select(ctx, &out, `SELECT sp_id, region, min_duration_epochs, max_duration_epochs, max_pipeline, last_assigned_at, created_at
+		FROM ingest_queue_providers ORDER BY sp_id`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting ingest queue providers: %w", err)
+	}
+	return out, nil
+}
+
+// Enqueue adds a piece to the shared ingest queue. The IngestQueue task adds it to a sector of a provider
+// matching the duration and region of the piece, which has the most room in its pipeline.
+//
+// Queued pieces are onboarded as direct data without a deal: market deals and verified allocations name their
+// provider on chain, and go through the ingest of that provider instead.
+func Enqueue(ctx context.Context, db *harmonydb.DB, p Piece) (int64, error) {
+	if err := p.Size.Validate(); err != nil {
+		return 0, xerrors.Errorf("invalid piece size: %w", err)
+	}
+	if p.Size != padreader.PaddedSize(uint64(p.RawSize)).Padded() {
+		return 0, xerrors.Errorf("raw size doesn't match padded piece size")
+	}
+	if p.DataURL.Scheme != "http" && p.DataURL.Scheme != "https" {
+		return 0, xerrors.Errorf("data url must be http or https")
+	}
+
+	duration, err := durationEpochs(p.DurationDays)
+	if err != nil {
+		return 0, err
+	}
+
+	hdr := p.DataHeaders
+	if hdr == nil {
+		hdr = http.Header{}
+	}
+	hdrJson, err := json.Marshal(hdr)
+	if err != nil {
+		return 0, xerrors.Errorf("marshaling headers: %w", err)
+	}
+
+	var region *string
+	if p.Region != "" {
+		region = &p.Region
+	}
+
+	var id int64
+	err = db.QueryRow(ctx, `INSERT INTO ingest_queue (piece_cid, piece_size, raw_size, data_url, data_headers, keep_unsealed, duration_epochs, region)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		p.PieceCID.String(), int64(p.Size), p.RawSize, p.DataURL.String(), hdrJson, p.KeepUnsealed, duration, region).Scan(&id)
+	if err != nil {
+		return 0, xerrors.Errorf("queueing piece: %w", err)
+	}
+	return id, nil
+}
+
+// Queue lists the pieces in the shared ingest queue, oldest first. Assigned pieces are listed when withAssigned is
+// set.
+func Queue(ctx context.Context, db *harmonydb.DB, withAssigned bool) ([]QueuedPiece, error) {
+	var out []QueuedPiece
+	err := db.Select(ctx, &out, `SELECT id, piece_cid, piece_size, raw_size, data_url, keep_unsealed, duration_epochs, region,
+			state, sp_id, sector_number, attempted_at, waiting_reason, error, task_id, created_at, assigned_at
+		FROM ingest_queue WHERE state != 'assigned' OR $1 ORDER BY created_at, id`, withAssigned)
+	if err != nil {
+		return nil, xerrors.Errorf("getting ingest queue: %w", err)
+	}
+	return out, nil
+}
+
+// Retry queues a failed piece again.
+func Retry(ctx context.Context, db *harmonydb.DB, id int64) error {
+	n, err := db.Exec(ctx, `UPDATE ingest_queue SET state = 'queued', error = NULL, attempted_at = NULL, waiting_reason = NULL
+		WHERE id = $1 AND state = 'failed'`, id)
+	if err != nil {
+		return xerrors.Errorf("retrying queued piece: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("piece %d isn't failed", id)
+	}
+	return nil
+}
+
+// Remove takes a piece which wasn't assigned out of the queue.
+func Remove(ctx context.Context, db *harmonydb.DB, id int64) error {
+	n, err := db.Exec(ctx, `DELETE FROM ingest_queue WHERE id = $1 AND state != 'assigned' AND task_id IS NULL`, id)
+	if err != nil {
+		return xerrors.Errorf("removing queued piece: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("piece %d is assigned, being assigned or doesn't exist", id)
+	}
+	return nil
+}
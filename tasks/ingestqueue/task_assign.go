@@ -0,0 +1,287 @@
+package ingestqueue
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+
+	"github.com/filecoin-project/curio/api"
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/market"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	lpiece "github.com/filecoin-project/lotus/storage/pipeline/piece"
+)
+
+const AssignSchedInterval = time.Minute
+
+// StartEpochDelay is how far ahead of the assignment a queued piece starts, the time its sector has to be sealed in
+const StartEpochDelay = abi.ChainEpoch(7 * builtin.EpochsInDay)
+
+// IngestQueueTask assigns pieces of the shared ingest queue to the provider with the most room in its sealing
+// pipeline among the providers accepting the piece.
+type IngestQueueTask struct {
+	db  *harmonydb.DB
+	api api.Chain
+	cfg *config.CurioConfig
+
+	ingesters *market.Ingesters
+}
+
+func NewIngestQueueTask(db *harmonydb.DB, api api.Chain, cfg *config.CurioConfig) *IngestQueueTask {
+	return &IngestQueueTask{
+		db:  db,
+		api: api,
+		cfg: cfg,
+
+		ingesters: market.NewIngesters(db, api, cfg),
+	}
+}
+
+type queued struct {
+	ID             int64   `db:"id"`
+	PieceCID       string  `db:"piece_cid"`
+	PieceSize      int64   `db:"piece_size"`
+	RawSize        int64   `db:"raw_size"`
+	DataURL        string  `db:"data_url"`
+	DataHeaders    []byte  `db:"data_headers"`
+	KeepUnsealed   bool    `db:"keep_unsealed"`
+	DurationEpochs int64   `db:"duration_epochs"`
+	Region         *string `db:"region"`
+}
+
+type candidate struct {
+	SpID        int64 `db:"sp_id"`
+	MaxPipeline int64 `db:"max_pipeline"`
+	InPipeline  int64 `db:"in_pipeline"`
+}
+
+func (i *IngestQueueTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var pieces []queued
+	err = i.db.Select(ctx, &pieces, `SELECT id, piece_cid, piece_size, raw_size, data_url, data_headers, keep_unsealed, duration_epochs, region
+		FROM ingest_queue WHERE task_id = $1 AND state = 'queued'`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting queued piece: %w", err)
+	}
+	if len(pieces) != 1 {
+		return false, xerrors.Errorf("expected 1 queued piece, got %d", len(pieces))
+	}
+	p := pieces[0]
+
+	sp, reason, err := i.pickProvider(ctx, p)
+	if err != nil {
+		return false, err
+	}
+	if sp == 0 {
+		_, err := i.db.Exec(ctx, `UPDATE ingest_queue SET task_id = NULL, attempted_at = current_timestamp, waiting_reason = $2
+			WHERE task_id = $1`, taskID, reason)
+		if err != nil {
+			return false, xerrors.Errorf("updating queued piece: %w", err)
+		}
+		return true, nil
+	}
+
+	pieceCID, err := cid.Parse(p.PieceCID)
+	if err != nil {
+		return false, xerrors.Errorf("parsing piece cid: %w", err)
+	}
+	source, err := url.Parse(p.DataURL)
+	if err != nil {
+		return false, xerrors.Errorf("parsing data url: %w", err)
+	}
+	var hdr http.Header
+	if err := json.Unmarshal(p.DataHeaders, &hdr); err != nil {
+		return false, xerrors.Errorf("unmarshaling data headers: %w", err)
+	}
+
+	head, err := i.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	maddr, err := address.NewIDAddress(uint64(sp))
+	if err != nil {
+		return false, err
+	}
+
+	pin, err := i.ingesters.Get(maddr)
+	if err != nil {
+		return false, err
+	}
+
+	start := head.Height() + StartEpochDelay
+	deal := lpiece.PieceDealInfo{
+		PieceActivationManifest: &miner.PieceActivationManifest{
+			CID:  pieceCID,
+			Size: abi.PaddedPieceSize(p.PieceSize),
+		},
+		DealSchedule: lpiece.DealSchedule{
+			StartEpoch: start,
+			EndEpoch:   start + abi.ChainEpoch(p.DurationEpochs),
+		},
+		KeepUnsealed: p.KeepUnsealed,
+	}
+
+	so, err := pin.AllocatePieceToSector(ctx, maddr, deal, p.RawSize, *source, hdr)
+	if err != nil {
+		_, uerr := i.db.Exec(ctx, `UPDATE ingest_queue SET state = 'failed', sp_id = $2, error = $3, task_id = NULL,
+				attempted_at = current_timestamp, waiting_reason = NULL
+			WHERE task_id = $1`, taskID, sp, err.Error())
+		if uerr != nil {
+			return false, xerrors.Errorf("updating queued piece: %w", uerr)
+		}
+		return true, nil
+	}
+
+	log.Infow("queued piece assigned to sector", "id", p.ID, "piece", p.PieceCID, "sp", sp, "sector", so.Sector)
+
+	_, err = i.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`UPDATE ingest_queue SET state = 'assigned', sp_id = $2, sector_number = $3, task_id = NULL,
+				attempted_at = current_timestamp, assigned_at = current_timestamp, waiting_reason = NULL
+			WHERE task_id = $1`, taskID, sp, int64(so.Sector))
+		if err != nil {
+			return false, xerrors.Errorf("updating queued piece: %w", err)
+		}
+		_, err = tx.Exec(`UPDATE ingest_queue_providers SET last_assigned_at = current_timestamp WHERE sp_id = $1`, sp)
+		if err != nil {
+			return false, xerrors.Errorf("updating provider: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Queries of providers accepting a piece, with their pipeline depth: sectors in the pipeline, and deal sectors still
+// open for pieces. The SQL is assembled from constants, harmonydb only takes constant queries.
+const (
+	candidatesQueryHead = `SELECT sp_id, max_pipeline, in_pipeline FROM (
+			SELECT p.sp_id, p.max_pipeline, p.last_assigned_at, `
+	candidatesQueryTail = ` AS in_pipeline
+			FROM ingest_queue_providers p
+			WHERE ($1::TEXT IS NULL OR p.region = $1) AND $2 BETWEEN p.min_duration_epochs AND p.max_duration_epochs
+			  AND NOT EXISTS (SELECT 1 FROM sealing_pauses sp WHERE sp.sp_id = p.sp_id)
+		) c ORDER BY max_pipeline - in_pipeline DESC, last_assigned_at NULLS FIRST, sp_id`
+
+	sealPipelineDepth = `(SELECT COUNT(*) FROM sectors_sdr_pipeline s
+				WHERE s.sp_id = p.sp_id AND NOT s.failed AND NOT (s.after_commit_msg_success AND s.after_move_storage))
+			+ (SELECT COUNT(DISTINCT sip.sector_number) FROM sectors_sdr_initial_pieces sip
+				WHERE sip.sp_id = p.sp_id AND NOT EXISTS (SELECT 1 FROM sectors_sdr_pipeline s
+					WHERE s.sp_id = sip.sp_id AND s.sector_number = sip.sector_number))`
+	snapPipelineDepth = `(SELECT COUNT(*) FROM sectors_snap_pipeline s
+				WHERE s.sp_id = p.sp_id AND NOT s.failed AND NOT s.after_move_storage)
+			+ (SELECT COUNT(DISTINCT sip.sector_number) FROM sectors_snap_initial_pieces sip
+				WHERE sip.sp_id = p.sp_id AND NOT EXISTS (SELECT 1 FROM sectors_snap_pipeline s
+					WHERE s.sp_id = sip.sp_id AND s.sector_number = sip.sector_number))`
+)
+
+// pickProvider returns the provider accepting the piece with the most room in its pipeline, or why there is none
+func (i *IngestQueueTask) pickProvider(ctx context.Context, p queued) (int64, string, error) {
+	var cands []candidate
+	var err error
+	if i.cfg.Ingest.DoSnap {
+		err = i.db.Select(ctx, &cands, candidatesQueryHead+snapPipelineDepth+candidatesQueryTail, p.Region, p.DurationEpochs)
+	} else {
+		err = i.db.Select(ctx, &cands, candidatesQueryHead+sealPipelineDepth+candidatesQueryTail, p.Region, p.DurationEpochs)
+	}
+	if err != nil {
+		return 0, "", xerrors.Errorf("getting providers: %w", err)
+	}
+	if len(cands) == 0 {
+		return 0, "no provider accepts the piece region and duration, or their pipelines are paused", nil
+	}
+
+	for _, c := range cands {
+		if c.InPipeline >= c.MaxPipeline {
+			// ordered by room in the pipeline
+			break
+		}
+
+		maddr, err := address.NewIDAddress(uint64(c.SpID))
+		if err != nil {
+			return 0, "", err
+		}
+		mi, err := i.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return 0, "", xerrors.Errorf("getting miner info of %s: %w", maddr, err)
+		}
+		if abi.PaddedPieceSize(mi.SectorSize) < abi.PaddedPieceSize(p.PieceSize) {
+			continue
+		}
+
+		return c.SpID, "", nil
+	}
+
+	return 0, "no matching provider has room in its pipeline for the piece", nil
+}
+
+func (i *IngestQueueTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (i *IngestQueueTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		// one at a time, pipeline depths change with every assignment
+		Max:  taskhelp.Max(1),
+		Name: "IngestQueue",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		MaxFailures: 10,
+		IAmBored:    passcall.Every(AssignSchedInterval, i.schedule),
+	}
+}
+
+func (i *IngestQueueTask) schedule(taskFunc harmonytask.AddTaskFunc) error {
+	// schedule the oldest queued piece when we're bored, pieces no provider could take are tried again after 5 minutes
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var pieces []struct {
+			ID int64 `db:"id"`
+		}
+		err := tx.Select(&pieces, `SELECT id FROM ingest_queue
+			WHERE state = 'queued' AND task_id IS NULL AND (attempted_at IS NULL OR attempted_at < current_timestamp - INTERVAL '5 minutes')
+			ORDER BY created_at, id LIMIT 1`)
+		if err != nil {
+			return false, xerrors.Errorf("getting queued pieces: %w", err)
+		}
+		if len(pieces) == 0 {
+			return false, nil
+		}
+
+		n, err := tx.Exec(`UPDATE ingest_queue SET task_id = $1 WHERE id = $2 AND task_id IS NULL`, id, pieces[0].ID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return n == 1, nil
+	})
+
+	return nil
+}
+
+func (i *IngestQueueTask) Adder(taskFunc harmonytask.AddTaskFunc) {}
+
+var _ = harmonytask.Reg(&IngestQueueTask{})
+var _ harmonytask.TaskInterface = &IngestQueueTask{}
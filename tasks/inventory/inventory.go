@@ -0,0 +1,102 @@
+// Package inventory cross-checks the storage index against the files in storage paths, and against sectors live
+// on chain, reporting missing, orphan and duplicate files and lost sectors.
+package inventory
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+var log = logging.Logger("inventory")
+
+const (
+	KindMissing   = "missing"
+	KindOrphan    = "orphan"
+	KindDuplicate = "duplicate"
+	KindLost      = "lost"
+)
+
+// MaxIssues bounds the number of issues recorded by a single check
+const MaxIssues = 10000
+
+// RetainChecks is how long completed checks and their issues are kept
+var RetainChecks = 30 * 24 * time.Hour
+
+// Start schedules inventory checks of a storage path, or of every storage path and chain state if id is empty.
+// Returns the IDs of the new checks, paths which already have a pending check are skipped. Reconcile fixes the
+// index for missing and orphan files found by the checks.
+func Start(ctx context.Context, db *harmonydb.DB, id storiface.ID, reconcile bool) ([]int64, error) {
+	if id != "" {
+		var n int
+		err := db.QueryRow(ctx, `SELECT COUNT(*) FROM storage_path WHERE storage_id = $1`, id).Scan(&n)
+		if err != nil {
+			return nil, xerrors.Errorf("getting storage path: %w", err)
+		}
+		if n == 0 {
+			return nil, xerrors.Errorf("storage path %s not found", id)
+		}
+	}
+
+	checks := []int64{}
+	err := db.Select(ctx, &checks, `INSERT INTO storage_inventory_checks (storage_id, reconcile)
+		SELECT t.storage_id, $2 FROM (
+			SELECT storage_id FROM storage_path WHERE $1 = '' OR storage_id = $1
+			UNION ALL
+			SELECT NULL WHERE $1 = ''
+		) t
+		ON CONFLICT DO NOTHING
+		RETURNING check_id`, string(id), reconcile)
+	if err != nil {
+		return nil, xerrors.Errorf("scheduling inventory checks: %w", err)
+	}
+	if id != "" && len(checks) == 0 {
+		return nil, xerrors.Errorf("storage path %s already has a pending inventory check", id)
+	}
+
+	return checks, nil
+}
+
+// plan schedules checks of storage paths and chain state which weren't checked within the interval, and removes
+// old checks
+func plan(ctx context.Context, db *harmonydb.DB, interval time.Duration, reconcile bool) error {
+	_, err := db.Exec(ctx, `DELETE FROM storage_inventory_checks WHERE complete_time < current_timestamp - make_interval(secs => $1)`,
+		RetainChecks.Seconds())
+	if err != nil {
+		return xerrors.Errorf("removing old inventory checks: %w", err)
+	}
+
+	// checks whose task is gone, e.g. because the node running it died, are picked up again
+	_, err = db.Exec(ctx, `UPDATE storage_inventory_checks SET task_id = NULL
+		WHERE complete_time IS NULL AND task_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM harmony_task WHERE id = task_id)`)
+	if err != nil {
+		return xerrors.Errorf("clearing stale inventory check tasks: %w", err)
+	}
+
+	if interval <= 0 {
+		return nil
+	}
+
+	n, err := db.Exec(ctx, `INSERT INTO storage_inventory_checks (storage_id, reconcile)
+		SELECT t.storage_id, $2 FROM (
+			SELECT storage_id FROM storage_path
+			UNION ALL
+			SELECT NULL
+		) t
+		WHERE NOT EXISTS (SELECT 1 FROM storage_inventory_checks c WHERE c.storage_id IS NOT DISTINCT FROM t.storage_id
+			AND (c.complete_time IS NULL OR c.create_time > current_timestamp - make_interval(secs => $1)))
+		ON CONFLICT DO NOTHING`, interval.Seconds(), reconcile)
+	if err != nil {
+		return xerrors.Errorf("scheduling inventory checks: %w", err)
+	}
+	if n > 0 {
+		log.Infow("scheduled storage inventory checks", "count", n)
+	}
+
+	return nil
+}
@@ -0,0 +1,462 @@
+package inventory
+
+import (
+	"context"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/curiochain"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const MinSchedInterval = 10 * time.Second
+
+// PlanInterval is how often storage paths and chain state which are due for a check get one scheduled
+const PlanInterval = 5 * time.Minute
+
+type StorageInventoryNodeAPI interface {
+	StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error)
+}
+
+// StorageInventoryTask runs one inventory check. Checks of a storage path run on a node which has the path
+// attached and compare the index with the files in the path. Chain checks look for sectors live on chain which
+// have no complete sealed or updated copy in the index.
+type StorageInventoryTask struct {
+	db     *harmonydb.DB
+	lstor  *paths.Local
+	index  paths.SectorIndex
+	api    StorageInventoryNodeAPI
+	bstore curiochain.CurioBlockstore
+	cfg    config.StorageInventoryConfig
+	max    int
+
+	lastPlan time.Time
+}
+
+func NewStorageInventoryTask(db *harmonydb.DB, lstor *paths.Local, index paths.SectorIndex, api StorageInventoryNodeAPI, bstore curiochain.CurioBlockstore, cfg config.StorageInventoryConfig, max int) *StorageInventoryTask {
+	return &StorageInventoryTask{
+		db:     db,
+		lstor:  lstor,
+		index:  index,
+		api:    api,
+		bstore: bstore,
+		cfg:    cfg,
+		max:    max,
+	}
+}
+
+type inventoryCheck struct {
+	CheckID   int64   `db:"check_id"`
+	StorageID *string `db:"storage_id"`
+	Reconcile bool    `db:"reconcile"`
+}
+
+type issue struct {
+	kind       string
+	sector     abi.SectorID
+	ft         storiface.SectorFileType
+	storage    storiface.ID
+	reconciled bool
+}
+
+func (t *StorageInventoryTask) getCheck(ctx context.Context, taskID harmonytask.TaskID) (*inventoryCheck, error) {
+	var checks []inventoryCheck
+	err := t.db.Select(ctx, &checks, `SELECT check_id, storage_id, reconcile FROM storage_inventory_checks
+		WHERE task_id = $1 AND complete_time IS NULL`, taskID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting inventory check: %w", err)
+	}
+	if len(checks) != 1 {
+		return nil, xerrors.Errorf("expected 1 inventory check, got %d", len(checks))
+	}
+	return &checks[0], nil
+}
+
+func (t *StorageInventoryTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	chk, err := t.getCheck(ctx, taskID)
+	if err != nil {
+		return false, err
+	}
+
+	var issues []issue
+	if chk.StorageID == nil {
+		issues, err = t.checkChain(ctx)
+	} else {
+		issues, err = t.checkPath(ctx, storiface.ID(*chk.StorageID), chk.Reconcile)
+	}
+
+	// failed checks are completed with an error, the path is checked again on the next interval
+	var errStr *string
+	if err != nil {
+		log.Warnw("storage inventory check failed", "check", chk.CheckID, "storage", chk.StorageID, "error", err)
+		es := err.Error()
+		errStr = &es
+	}
+
+	if len(issues) > 0 {
+		log.Warnw("storage inventory check found issues", "check", chk.CheckID, "storage", chk.StorageID, "issues", len(issues))
+	}
+	if len(issues) > MaxIssues {
+		issues = issues[:MaxIssues]
+	}
+
+	_, err = t.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+		for _, is := range issues {
+			var storageID *string
+			if is.storage != "" {
+				s := string(is.storage)
+				storageID = &s
+			}
+
+			_, err := tx.Exec(`INSERT INTO storage_inventory_issues (check_id, kind, sp_id, sector_num, sector_filetype, storage_id, reconciled)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`, chk.CheckID, is.kind, is.sector.Miner, is.sector.Number, int64(is.ft), storageID, is.reconciled)
+			if err != nil {
+				return false, xerrors.Errorf("recording inventory issue: %w", err)
+			}
+		}
+
+		_, err := tx.Exec(`UPDATE storage_inventory_checks SET complete_time = current_timestamp, error = $2 WHERE check_id = $1`,
+			chk.CheckID, errStr)
+		if err != nil {
+			return false, xerrors.Errorf("marking inventory check complete: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// localPath returns the storage path if it is attached to this node
+func (t *StorageInventoryTask) localPath(ctx context.Context, id storiface.ID) (*storiface.StoragePath, error) {
+	locals, err := t.lstor.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	for _, l := range locals {
+		if l.ID == id && l.LocalPath != "" {
+			return &l, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkPath compares the files in a local storage path with the index
+func (t *StorageInventoryTask) checkPath(ctx context.Context, id storiface.ID, reconcile bool) ([]issue, error) {
+	lp, err := t.localPath(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if lp == nil {
+		return nil, xerrors.Errorf("storage path %s is not attached to this node", id)
+	}
+
+	var rows []struct {
+		MinerID   int64 `db:"miner_id"`
+		SectorNum int64 `db:"sector_num"`
+		FileType  int64 `db:"sector_filetype"`
+	}
+	err = t.db.Select(ctx, &rows, `SELECT miner_id, sector_num, sector_filetype FROM sector_location WHERE storage_id = $1`, id)
+	if err != nil {
+		return nil, xerrors.Errorf("getting indexed sectors: %w", err)
+	}
+
+	indexed := map[storiface.Decl]struct{}{}
+	for _, r := range rows {
+		indexed[storiface.Decl{
+			SectorID:       abi.SectorID{Miner: abi.ActorID(r.MinerID), Number: abi.SectorNumber(r.SectorNum)},
+			SectorFileType: storiface.SectorFileType(r.FileType),
+		}] = struct{}{}
+	}
+
+	var issues []issue
+
+	// files modified within the grace period may still be written by a task which hasn't declared them yet
+	cutoff := time.Now().Add(-time.Duration(t.cfg.GracePeriod))
+
+	onDisk := map[storiface.Decl]struct{}{}
+	for _, ft := range storiface.PathTypes {
+		ents, err := os.ReadDir(filepath.Join(lp.LocalPath, ft.String()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, xerrors.Errorf("listing %s files: %w", ft, err)
+		}
+
+		for _, ent := range ents {
+			if ent.Name() == paths.FetchTempSubdir {
+				continue
+			}
+
+			sid, err := storiface.ParseSectorID(ent.Name())
+			if err != nil {
+				log.Debugw("skipping unknown file in storage path", "storage", id, "file", filepath.Join(ft.String(), ent.Name()))
+				continue
+			}
+
+			d := storiface.Decl{SectorID: sid, SectorFileType: ft}
+			onDisk[d] = struct{}{}
+			if _, ok := indexed[d]; ok {
+				continue
+			}
+
+			info, err := ent.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+
+			issues = append(issues, issue{kind: KindOrphan, sector: sid, ft: ft, storage: id})
+		}
+	}
+
+	for d := range indexed {
+		if _, ok := onDisk[d]; ok {
+			continue
+		}
+
+		// the file may have been moved into the path while it was listed
+		if _, err := os.Stat(filepath.Join(lp.LocalPath, d.SectorFileType.String(), storiface.SectorName(d.SectorID))); err == nil {
+			continue
+		}
+
+		issues = append(issues, issue{kind: KindMissing, sector: d.SectorID, ft: d.SectorFileType, storage: id})
+	}
+
+	// parked pieces are replicated on purpose, only sector files are reported as duplicates
+	var dups []struct {
+		MinerID   int64 `db:"miner_id"`
+		SectorNum int64 `db:"sector_num"`
+		FileType  int64 `db:"sector_filetype"`
+	}
+	err = t.db.Select(ctx, &dups, `SELECT sl.miner_id, sl.sector_num, sl.sector_filetype FROM sector_location sl
+		WHERE sl.storage_id = $1 AND sl.miner_id != 0 AND EXISTS (SELECT 1 FROM sector_location o
+			WHERE o.miner_id = sl.miner_id AND o.sector_num = sl.sector_num AND o.sector_filetype = sl.sector_filetype
+				AND o.storage_id != sl.storage_id)`, id)
+	if err != nil {
+		return nil, xerrors.Errorf("getting duplicate sectors: %w", err)
+	}
+	for _, d := range dups {
+		issues = append(issues, issue{
+			kind:    KindDuplicate,
+			sector:  abi.SectorID{Miner: abi.ActorID(d.MinerID), Number: abi.SectorNumber(d.SectorNum)},
+			ft:      storiface.SectorFileType(d.FileType),
+			storage: id,
+		})
+	}
+
+	if reconcile {
+		for i, is := range issues {
+			switch is.kind {
+			case KindMissing:
+				err = t.index.StorageDropSector(ctx, id, is.sector, is.ft)
+			case KindOrphan:
+				err = t.index.StorageDeclareSector(ctx, id, is.sector, is.ft, lp.CanStore)
+			default:
+				continue
+			}
+			if err != nil {
+				log.Errorw("reconciling storage index", "storage", id, "sector", is.sector, "type", is.ft, "kind", is.kind, "error", err)
+				continue
+			}
+			issues[i].reconciled = true
+		}
+	}
+
+	return issues, nil
+}
+
+// checkChain looks for sectors live on chain which have no complete sealed or updated copy in the index
+func (t *StorageInventoryTask) checkChain(ctx context.Context) ([]issue, error) {
+	var miners []int64
+	err := t.db.Select(ctx, &miners, `SELECT DISTINCT sp_id FROM sectors_meta`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting miners: %w", err)
+	}
+
+	astor := adt.WrapStore(ctx, cbor.NewCborStore(t.bstore))
+
+	var issues []issue
+	for _, mid := range miners {
+		maddr, err := address.NewIDAddress(uint64(mid))
+		if err != nil {
+			return nil, xerrors.Errorf("NewIDAddress: %w", err)
+		}
+
+		mact, err := t.api.StateGetActor(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("get miner actor %s: %w", maddr, err)
+		}
+
+		mas, err := miner.Load(astor, mact)
+		if err != nil {
+			return nil, xerrors.Errorf("load miner actor state %s: %w", maddr, err)
+		}
+
+		live := bitfield.New()
+		err = mas.ForEachDeadline(func(idx uint64, dl miner.Deadline) error {
+			return dl.ForEachPartition(func(idx uint64, part miner.Partition) error {
+				ls, err := part.LiveSectors()
+				if err != nil {
+					return xerrors.Errorf("getting live sectors: %w", err)
+				}
+
+				live, err = bitfield.MergeBitFields(live, ls)
+				return err
+			})
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("iterating deadlines for miner %s: %w", maddr, err)
+		}
+
+		var rows []struct {
+			SectorNum int64 `db:"sector_num"`
+			FileTypes int64 `db:"file_types"`
+		}
+		err = t.db.Select(ctx, &rows, `SELECT sector_num, bit_or(sector_filetype) AS file_types FROM sector_location
+			WHERE miner_id = $1 GROUP BY sector_num`, mid)
+		if err != nil {
+			return nil, xerrors.Errorf("getting indexed sectors of miner %s: %w", maddr, err)
+		}
+
+		has := map[abi.SectorNumber]storiface.SectorFileType{}
+		for _, r := range rows {
+			has[abi.SectorNumber(r.SectorNum)] = storiface.SectorFileType(r.FileTypes)
+		}
+
+		const sealedCopy = storiface.FTSealed | storiface.FTCache
+		const updatedCopy = storiface.FTUpdate | storiface.FTUpdateCache
+
+		err = live.ForEach(func(n uint64) error {
+			ft := has[abi.SectorNumber(n)]
+			if ft&sealedCopy == sealedCopy || ft&updatedCopy == updatedCopy {
+				return nil
+			}
+
+			// report the missing files of the updated copy if the sector was updated
+			missing := sealedCopy &^ ft
+			if ft&updatedCopy != 0 {
+				missing = updatedCopy &^ ft
+			}
+
+			issues = append(issues, issue{
+				kind:   KindLost,
+				sector: abi.SectorID{Miner: abi.ActorID(mid), Number: abi.SectorNumber(n)},
+				ft:     missing,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("checking live sectors of miner %s: %w", maddr, err)
+		}
+	}
+
+	return issues, nil
+}
+
+func (t *StorageInventoryTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ctx := context.Background()
+
+	for _, id := range ids {
+		chk, err := t.getCheck(ctx, id)
+		if err != nil {
+			// picked up by another node
+			continue
+		}
+
+		if chk.StorageID == nil {
+			return &id, nil
+		}
+
+		lp, err := t.localPath(ctx, storiface.ID(*chk.StorageID))
+		if err != nil {
+			return nil, err
+		}
+		if lp != nil {
+			return &id, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (t *StorageInventoryTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(t.max),
+		Name: "StorageInventory",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 256 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(MinSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return t.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (t *StorageInventoryTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (t *StorageInventoryTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	if time.Since(t.lastPlan) > PlanInterval {
+		t.lastPlan = time.Now()
+		if err := plan(ctx, t.db, time.Duration(t.cfg.Interval), t.cfg.AutoReconcile); err != nil {
+			log.Errorw("planning storage inventory checks", "error", err)
+		}
+	}
+
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var checks []struct {
+			CheckID int64 `db:"check_id"`
+		}
+
+		err := tx.Select(&checks, `SELECT check_id FROM storage_inventory_checks WHERE task_id IS NULL AND complete_time IS NULL LIMIT 20`)
+		if err != nil {
+			return false, xerrors.Errorf("getting inventory checks: %w", err)
+		}
+
+		if len(checks) == 0 {
+			return false, nil
+		}
+
+		// pick at random in case there are a bunch of schedules across the cluster
+		chk := checks[rand.N(len(checks))]
+
+		_, err = tx.Exec(`UPDATE storage_inventory_checks SET task_id = $1 WHERE check_id = $2 AND task_id IS NULL`, id, chk.CheckID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return true, nil
+	})
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&StorageInventoryTask{})
+var _ harmonytask.TaskInterface = &StorageInventoryTask{}
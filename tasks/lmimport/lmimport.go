@@ -0,0 +1,329 @@
+package lmimport
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+var log = logging.Logger("lmimport")
+
+// Statuses of imported sectors
+const (
+	StatusPending  = "pending"
+	StatusImported = "imported"
+	StatusInvalid  = "invalid"
+	StatusSkipped  = "skipped"
+)
+
+// SectorMeta is a lotus-miner sector converted to its sectors_meta row
+type SectorMeta struct {
+	SectorNum    int64
+	RegSealProof int64
+
+	TicketEpoch int64
+	TicketValue []byte
+
+	OrigSealedCID   *string
+	OrigUnsealedCID *string
+	CurSealedCID    *string
+	CurUnsealedCID  *string
+
+	MsgCidPrecommit *string
+	MsgCidCommit    *string
+	MsgCidUpdate    *string
+
+	SeedEpoch int64
+	SeedValue []byte
+
+	Pieces []PieceMeta
+}
+
+// PieceMeta is a piece of a lotus-miner sector converted to its sectors_meta_pieces row
+type PieceMeta struct {
+	PieceNum  int64
+	PieceCID  string
+	PieceSize int64
+
+	RequestedKeepData bool
+	StartEpoch        int64
+	OrigEndEpoch      int64
+
+	F05DealID       int64
+	DDOPam          *string
+	F05DealProposal *string
+}
+
+// StagedSector is a lotus-miner sector to import. Sectors with a SkipReason are recorded as skipped without being
+// imported.
+type StagedSector struct {
+	State      string
+	SkipReason string
+	Meta       SectorMeta
+}
+
+// StoragePath is a storage path of the lotus-miner
+type StoragePath struct {
+	ID       string
+	Path     string
+	CanSeal  bool
+	CanStore bool
+}
+
+// Import is an import of a lotus-miner with the progress of its sectors and storage paths
+type Import struct {
+	ID            int64      `db:"import_id"`
+	SpID          int64      `db:"sp_id"`
+	MinerRepo     string     `db:"miner_repo"`
+	StagedBy      string     `db:"staged_by"`
+	CreatedAt     time.Time  `db:"created_at"`
+	SectorsDoneAt *time.Time `db:"sectors_done_at"`
+	CompletedAt   *time.Time `db:"completed_at"`
+	TaskID        *int64     `db:"task_id"`
+
+	Pending  int64 `db:"pending"`
+	Imported int64 `db:"imported"`
+	Invalid  int64 `db:"invalid"`
+	Skipped  int64 `db:"skipped"`
+
+	StoragePaths      int64 `db:"storage_paths"`
+	StorageRedeclared int64 `db:"storage_redeclared"`
+}
+
+// ImportSector is the audit record of a sector of an import
+type ImportSector struct {
+	SectorNum   int64      `db:"sector_num"`
+	LMState     string     `db:"lm_state"`
+	Status      string     `db:"status"`
+	Error       *string    `db:"error"`
+	ValidatedAt *time.Time `db:"validated_at"`
+}
+
+// ImportStorage is a storage path of an import. Attached is set when the path is attached to a Curio node.
+type ImportStorage struct {
+	StorageID    string     `db:"storage_id"`
+	Path         string     `db:"path"`
+	CanSeal      bool       `db:"can_seal"`
+	CanStore     bool       `db:"can_store"`
+	Attached     bool       `db:"attached"`
+	RedeclaredAt *time.Time `db:"redeclared_at"`
+	RedeclaredBy *string    `db:"redeclared_by"`
+	Error        *string    `db:"error"`
+}
+
+// Stage records the sectors and storage paths of a lotus-miner as a new import. The LMImport task validates the
+// sectors against chain state and imports them, and once the storage paths are attached to Curio nodes the
+// LMImportRedeclare task scans them for the sector files.
+func Stage(ctx context.Context, db *harmonydb.DB, maddr address.Address, minerRepo, stagedBy string, sectors []StagedSector, storage []StoragePath) (int64, error) {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return 0, xerrors.Errorf("getting miner id: %w", err)
+	}
+
+	var importID int64
+	_, err = db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		err = tx.QueryRow(`INSERT INTO lm_imports (sp_id, miner_repo, staged_by) VALUES ($1, $2, $3) RETURNING import_id`,
+			int64(mid), minerRepo, stagedBy).Scan(&importID)
+		if err != nil {
+			return false, xerrors.Errorf("inserting import: %w", err)
+		}
+
+		for _, s := range sectors {
+			meta, err := json.Marshal(s.Meta)
+			if err != nil {
+				return false, xerrors.Errorf("marshaling sector %d: %w", s.Meta.SectorNum, err)
+			}
+
+			status := StatusPending
+			var errStr *string
+			if s.SkipReason != "" {
+				status = StatusSkipped
+				errStr = &s.SkipReason
+			}
+
+			_, err = tx.Exec(`INSERT INTO lm_import_sectors (import_id, sector_num, lm_state, meta, status, error, validated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $5 = 'pending' THEN NULL ELSE current_timestamp END)`,
+				importID, s.Meta.SectorNum, s.State, string(meta), status, errStr)
+			if err != nil {
+				return false, xerrors.Errorf("staging sector %d: %w", s.Meta.SectorNum, err)
+			}
+		}
+
+		for _, p := range storage {
+			_, err = tx.Exec(`INSERT INTO lm_import_storage (import_id, storage_id, path, can_seal, can_store) VALUES ($1, $2, $3, $4, $5)`,
+				importID, p.ID, p.Path, p.CanSeal, p.CanStore)
+			if err != nil {
+				return false, xerrors.Errorf("staging storage path %s: %w", p.ID, err)
+			}
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return 0, err
+	}
+
+	return importID, nil
+}
+
+// InsertSectorMeta writes a lotus-miner sector to sectors_meta and sectors_meta_pieces, replacing existing rows.
+func InsertSectorMeta(tx *harmonydb.Tx, spID int64, s SectorMeta) error {
+	_, err := tx.Exec(`
+        INSERT INTO sectors_meta (sp_id, sector_num, reg_seal_proof, ticket_epoch, ticket_value,
+                                  orig_sealed_cid, orig_unsealed_cid, cur_sealed_cid, cur_unsealed_cid,
+                                  msg_cid_precommit, msg_cid_commit, msg_cid_update, seed_epoch, seed_value)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+        ON CONFLICT (sp_id, sector_num) DO UPDATE
+        SET reg_seal_proof = excluded.reg_seal_proof, ticket_epoch = excluded.ticket_epoch, ticket_value = excluded.ticket_value,
+            orig_sealed_cid = excluded.orig_sealed_cid, orig_unsealed_cid = excluded.orig_unsealed_cid, cur_sealed_cid = excluded.cur_sealed_cid,
+            cur_unsealed_cid = excluded.cur_unsealed_cid, msg_cid_precommit = excluded.msg_cid_precommit, msg_cid_commit = excluded.msg_cid_commit,
+            msg_cid_update = excluded.msg_cid_update, seed_epoch = excluded.seed_epoch, seed_value = excluded.seed_value`,
+		spID,
+		s.SectorNum,
+		s.RegSealProof,
+		s.TicketEpoch,
+		s.TicketValue,
+		s.OrigSealedCID,
+		s.OrigUnsealedCID,
+		s.CurSealedCID,
+		s.CurUnsealedCID,
+		s.MsgCidPrecommit,
+		s.MsgCidCommit,
+		s.MsgCidUpdate,
+		s.SeedEpoch,
+		s.SeedValue,
+	)
+	if err != nil {
+		return xerrors.Errorf("inserting/updating sectors_meta for sector %d: %w", s.SectorNum, err)
+	}
+
+	for _, piece := range s.Pieces {
+		_, err = tx.Exec(`
+			INSERT INTO sectors_meta_pieces (
+				sp_id, sector_num, piece_num, piece_cid, piece_size,
+				requested_keep_data, raw_data_size, start_epoch, orig_end_epoch,
+				f05_deal_id, ddo_pam, f05_deal_proposal
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			ON CONFLICT (sp_id, sector_num, piece_num) DO UPDATE
+			SET
+				piece_cid = excluded.piece_cid,
+				piece_size = excluded.piece_size,
+				requested_keep_data = excluded.requested_keep_data,
+				raw_data_size = excluded.raw_data_size,
+				start_epoch = excluded.start_epoch,
+				orig_end_epoch = excluded.orig_end_epoch,
+				f05_deal_id = excluded.f05_deal_id,
+				ddo_pam = excluded.ddo_pam,
+				f05_deal_proposal = excluded.f05_deal_proposal`,
+			spID,
+			s.SectorNum,
+			piece.PieceNum,
+			piece.PieceCID,
+			piece.PieceSize,
+			piece.RequestedKeepData,
+			nil, // raw_data_size might be calculated based on the piece size, or retrieved if available
+			piece.StartEpoch,
+			piece.OrigEndEpoch,
+			piece.F05DealID,
+			piece.DDOPam,
+			piece.F05DealProposal,
+		)
+		if err != nil {
+			return xerrors.Errorf("inserting/updating sector_meta_pieces for sector %d, piece %d: %w", s.SectorNum, piece.PieceNum, err)
+		}
+	}
+
+	return nil
+}
+
+// Imports lists the lotus-miner imports, newest first.
+func Imports(ctx context.Context, db *harmonydb.DB) ([]Import, error) {
+	var out []Import
+	err := db.Select(ctx, &out, `SELECT i.import_id, i.sp_id, i.miner_repo, i.staged_by, i.created_at, i.sectors_done_at, i.completed_at, i.task_id,
+			(SELECT COUNT(*) FROM lm_import_sectors s WHERE s.import_id = i.import_id AND s.status = 'pending') AS pending,
+			(SELECT COUNT(*) FROM lm_import_sectors s WHERE s.import_id = i.import_id AND s.status = 'imported') AS imported,
+			(SELECT COUNT(*) FROM lm_import_sectors s WHERE s.import_id = i.import_id AND s.status = 'invalid') AS invalid,
+			(SELECT COUNT(*) FROM lm_import_sectors s WHERE s.import_id = i.import_id AND s.status = 'skipped') AS skipped,
+			(SELECT COUNT(*) FROM lm_import_storage st WHERE st.import_id = i.import_id) AS storage_paths,
+			(SELECT COUNT(*) FROM lm_import_storage st WHERE st.import_id = i.import_id AND st.redeclared_at IS NOT NULL) AS storage_redeclared
+		FROM lm_imports i ORDER BY i.import_id DESC`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting imports: %w", err)
+	}
+	return out, nil
+}
+
+// Sectors lists the sectors of an import, all of them when status is empty.
+func Sectors(ctx context.Context, db *harmonydb.DB, importID int64, status string) ([]ImportSector, error) {
+	var out []ImportSector
+	err := db.Select(ctx, &out, `SELECT sector_num, lm_state, status, error, validated_at FROM lm_import_sectors
+		WHERE import_id = $1 AND ($2 = '' OR status = $2) ORDER BY sector_num`, importID, status)
+	if err != nil {
+		return nil, xerrors.Errorf("getting import sectors: %w", err)
+	}
+	return out, nil
+}
+
+// Storage lists the storage paths of an import.
+func Storage(ctx context.Context, db *harmonydb.DB, importID int64) ([]ImportStorage, error) {
+	var out []ImportStorage
+	err := db.Select(ctx, &out, `SELECT st.storage_id, st.path, st.can_seal, st.can_store,
+			EXISTS (SELECT 1 FROM storage_path sp WHERE sp.storage_id = st.storage_id) AS attached,
+			st.redeclared_at, st.redeclared_by, st.error
+		FROM lm_import_storage st WHERE st.import_id = $1 ORDER BY st.storage_id`, importID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting import storage: %w", err)
+	}
+	return out, nil
+}
+
+// Retry validates the invalid sectors of an import again, e.g. after the chain node caught up, and redeclares
+// storage paths whose scan failed. The import is resumed by the LMImport task.
+func Retry(ctx context.Context, db *harmonydb.DB, importID int64) error {
+	_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(`UPDATE lm_import_sectors SET status = 'pending', error = NULL, validated_at = NULL
+			WHERE import_id = $1 AND status = 'invalid'`, importID)
+		if err != nil {
+			return false, xerrors.Errorf("resetting invalid sectors: %w", err)
+		}
+
+		m, err := tx.Exec(`UPDATE lm_import_storage SET error = NULL, redeclared_at = NULL, redeclared_by = NULL
+			WHERE import_id = $1 AND error IS NOT NULL`, importID)
+		if err != nil {
+			return false, xerrors.Errorf("resetting failed storage paths: %w", err)
+		}
+
+		if n+m == 0 {
+			return false, xerrors.Errorf("import %d has no invalid sectors or failed storage paths", importID)
+		}
+
+		_, err = tx.Exec(`UPDATE lm_imports SET completed_at = NULL,
+				sectors_done_at = CASE WHEN $2 > 0 THEN NULL ELSE sectors_done_at END
+			WHERE import_id = $1`, importID, n)
+		if err != nil {
+			return false, xerrors.Errorf("resuming import: %w", err)
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
+}
+
+// markComplete sets completed_at once the sectors of an import are done and all of its storage paths are
+// redeclared.
+func markComplete(tx *harmonydb.Tx, importID int64) error {
+	_, err := tx.Exec(`UPDATE lm_imports SET completed_at = current_timestamp
+		WHERE import_id = $1 AND sectors_done_at IS NOT NULL AND completed_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM lm_import_storage WHERE import_id = $1 AND redeclared_at IS NULL)`, importID)
+	if err != nil {
+		return xerrors.Errorf("marking import complete: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package lmimport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const ImportSchedInterval = 30 * time.Second
+
+// sectorBatch is the number of sectors validated by one LMImport task, so that large imports are split into tasks
+// which can be resumed
+const sectorBatch = 1000
+
+type ImportNodeAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateSectorGetInfo(ctx context.Context, maddr address.Address, sectorNumber abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error)
+}
+
+// ImportTask validates the staged sectors of lotus-miner imports against chain state, and writes the sectors which
+// match to sectors_meta. The outcome of each sector is recorded in lm_import_sectors.
+type ImportTask struct {
+	db  *harmonydb.DB
+	api ImportNodeAPI
+}
+
+func NewImportTask(db *harmonydb.DB, api ImportNodeAPI) *ImportTask {
+	return &ImportTask{
+		db:  db,
+		api: api,
+	}
+}
+
+func (t *ImportTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var imports []struct {
+		ImportID int64 `db:"import_id"`
+		SpID     int64 `db:"sp_id"`
+	}
+	err = t.db.Select(ctx, &imports, `SELECT import_id, sp_id FROM lm_imports WHERE task_id = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting import: %w", err)
+	}
+	if len(imports) != 1 {
+		return false, xerrors.Errorf("expected 1 import, got %d", len(imports))
+	}
+	imp := imports[0]
+
+	maddr, err := address.NewIDAddress(uint64(imp.SpID))
+	if err != nil {
+		return false, err
+	}
+
+	var sectors []struct {
+		SectorNum int64  `db:"sector_num"`
+		Meta      []byte `db:"meta"`
+	}
+	err = t.db.Select(ctx, &sectors, `SELECT sector_num, meta FROM lm_import_sectors
+		WHERE import_id = $1 AND status = 'pending' ORDER BY sector_num LIMIT $2`, imp.ImportID, sectorBatch)
+	if err != nil {
+		return false, xerrors.Errorf("getting pending sectors: %w", err)
+	}
+
+	head, err := t.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	var imported, invalid int
+	for _, s := range sectors {
+		if !stillOwned() {
+			return false, nil
+		}
+
+		var meta SectorMeta
+		if err := json.Unmarshal(s.Meta, &meta); err != nil {
+			return false, xerrors.Errorf("unmarshaling sector %d: %w", s.SectorNum, err)
+		}
+
+		reason, err := t.validate(ctx, maddr, head.Key(), meta)
+		if err != nil {
+			return false, err
+		}
+
+		_, err = t.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+			if reason != "" {
+				_, err = tx.Exec(`UPDATE lm_import_sectors SET status = 'invalid', error = $3, validated_at = current_timestamp
+					WHERE import_id = $1 AND sector_num = $2`, imp.ImportID, s.SectorNum, reason)
+				if err != nil {
+					return false, xerrors.Errorf("recording invalid sector: %w", err)
+				}
+				return true, nil
+			}
+
+			if err := InsertSectorMeta(tx, imp.SpID, meta); err != nil {
+				return false, err
+			}
+
+			_, err = tx.Exec(`UPDATE lm_import_sectors SET status = 'imported', error = NULL, validated_at = current_timestamp
+				WHERE import_id = $1 AND sector_num = $2`, imp.ImportID, s.SectorNum)
+			if err != nil {
+				return false, xerrors.Errorf("recording imported sector: %w", err)
+			}
+			return true, nil
+		}, harmonydb.OptionRetry())
+		if err != nil {
+			return false, xerrors.Errorf("importing sector %d: %w", s.SectorNum, err)
+		}
+
+		if reason != "" {
+			log.Warnw("lotus-miner sector doesn't match chain state", "import", imp.ImportID, "miner", maddr, "sector", s.SectorNum, "reason", reason)
+			invalid++
+		} else {
+			imported++
+		}
+	}
+
+	// release the import, the next batch is picked up by a new task, or mark its sectors done
+	_, err = t.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`UPDATE lm_imports SET task_id = NULL,
+				sectors_done_at = CASE WHEN EXISTS (SELECT 1 FROM lm_import_sectors WHERE import_id = $1 AND status = 'pending')
+					THEN NULL ELSE current_timestamp END
+			WHERE import_id = $1`, imp.ImportID)
+		if err != nil {
+			return false, xerrors.Errorf("updating import: %w", err)
+		}
+		return true, markComplete(tx, imp.ImportID)
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return false, err
+	}
+
+	log.Infow("imported lotus-miner sectors", "import", imp.ImportID, "miner", maddr, "imported", imported, "invalid", invalid)
+	return true, nil
+}
+
+// validate checks a sector against its on-chain info, returning why it doesn't match or an empty string when it does
+func (t *ImportTask) validate(ctx context.Context, maddr address.Address, tsk types.TipSetKey, meta SectorMeta) (string, error) {
+	if meta.CurSealedCID == nil || meta.CurUnsealedCID == nil {
+		return "sector has no sealed or unsealed CID in lotus-miner", nil
+	}
+
+	onChain, err := t.api.StateSectorGetInfo(ctx, maddr, abi.SectorNumber(meta.SectorNum), tsk)
+	if err != nil {
+		return "", xerrors.Errorf("getting on-chain info of sector %d: %w", meta.SectorNum, err)
+	}
+	if onChain == nil {
+		return "sector isn't live on chain, it expired or was terminated", nil
+	}
+
+	if int64(onChain.SealProof) != meta.RegSealProof {
+		return fmt.Sprintf("seal proof %d doesn't match %d on chain", meta.RegSealProof, onChain.SealProof), nil
+	}
+	if onChain.SealedCID.String() != *meta.CurSealedCID {
+		return fmt.Sprintf("sealed CID %s doesn't match %s on chain", *meta.CurSealedCID, onChain.SealedCID), nil
+	}
+
+	return "", nil
+}
+
+func (t *ImportTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (t *ImportTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "LMImport",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 128 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(ImportSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return t.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (t *ImportTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (t *ImportTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	var stop bool
+	for !stop {
+		taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			stop = true
+
+			// imports whose task failed for good are picked up again, so that a stopped import resumes
+			var imports []struct {
+				ImportID int64 `db:"import_id"`
+			}
+			err := tx.Select(&imports, `SELECT import_id FROM lm_imports i
+				WHERE sectors_done_at IS NULL
+				  AND (task_id IS NULL OR NOT EXISTS (SELECT 1 FROM harmony_task h WHERE h.id = i.task_id))
+				ORDER BY import_id LIMIT 1`)
+			if err != nil {
+				return false, xerrors.Errorf("getting imports: %w", err)
+			}
+			if len(imports) == 0 {
+				return false, nil
+			}
+
+			_, err = tx.Exec(`UPDATE lm_imports SET task_id = $1 WHERE import_id = $2`, id, imports[0].ImportID)
+			if err != nil {
+				return false, xerrors.Errorf("updating task id: %w", err)
+			}
+
+			stop = false
+			return true, nil
+		})
+	}
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&ImportTask{})
+var _ harmonytask.TaskInterface = &ImportTask{}
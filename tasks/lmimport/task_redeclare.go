@@ -0,0 +1,161 @@
+package lmimport
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// RedeclareTask scans the storage paths of a lotus-miner import for sector files once its sectors are imported,
+// declaring them in the sector index. A path is scanned by a node it is attached to; paths which aren't attached
+// to any node yet wait for `curio storage attach`.
+type RedeclareTask struct {
+	db    *harmonydb.DB
+	lstor *paths.Local
+	host  string
+}
+
+func NewRedeclareTask(db *harmonydb.DB, lstor *paths.Local, host string) *RedeclareTask {
+	return &RedeclareTask{
+		db:    db,
+		lstor: lstor,
+		host:  host,
+	}
+}
+
+func (t *RedeclareTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var rows []struct {
+		ImportID  int64  `db:"import_id"`
+		StorageID string `db:"storage_id"`
+	}
+	err = t.db.Select(ctx, &rows, `SELECT import_id, storage_id FROM lm_import_storage WHERE task_id = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting storage path: %w", err)
+	}
+	if len(rows) != 1 {
+		return false, xerrors.Errorf("expected 1 storage path, got %d", len(rows))
+	}
+	p := rows[0]
+
+	id := storiface.ID(p.StorageID)
+	var errStr *string
+	if err := t.lstor.Redeclare(ctx, &id, false); err != nil {
+		log.Errorw("redeclaring imported storage path", "import", p.ImportID, "storage", p.StorageID, "error", err)
+		es := err.Error()
+		errStr = &es
+	}
+
+	_, err = t.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`UPDATE lm_import_storage SET task_id = NULL, error = $3, redeclared_by = $4,
+				redeclared_at = CASE WHEN $3::TEXT IS NULL THEN current_timestamp ELSE NULL END
+			WHERE import_id = $1 AND storage_id = $2`, p.ImportID, p.StorageID, errStr, t.host)
+		if err != nil {
+			return false, xerrors.Errorf("updating storage path: %w", err)
+		}
+		return true, markComplete(tx, p.ImportID)
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CanAccept takes the scan of a path attached to this node
+func (t *RedeclareTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ctx := context.Background()
+
+	locals, err := t.lstor.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	local := map[string]bool{}
+	for _, l := range locals {
+		local[string(l.ID)] = true
+	}
+
+	var tasks []struct {
+		TaskID    harmonytask.TaskID `db:"task_id"`
+		StorageID string             `db:"storage_id"`
+	}
+	err = t.db.Select(ctx, &tasks, `SELECT task_id, storage_id FROM lm_import_storage WHERE task_id = ANY($1)`, ids)
+	if err != nil {
+		return nil, xerrors.Errorf("getting storage paths: %w", err)
+	}
+
+	for _, task := range tasks {
+		if local[task.StorageID] {
+			return &task.TaskID, nil
+		}
+	}
+	return nil, nil
+}
+
+func (t *RedeclareTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "LMImportRedecl",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(ImportSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return t.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (t *RedeclareTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (t *RedeclareTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	var stop bool
+	for !stop {
+		taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			stop = true
+
+			// paths are scanned once the sectors are imported and the path is attached to a node, failed scans
+			// wait for a retry of the import
+			var rows []struct {
+				ImportID  int64  `db:"import_id"`
+				StorageID string `db:"storage_id"`
+			}
+			err := tx.Select(&rows, `SELECT st.import_id, st.storage_id FROM lm_import_storage st
+				JOIN lm_imports i ON i.import_id = st.import_id
+				WHERE i.sectors_done_at IS NOT NULL AND st.redeclared_at IS NULL AND st.error IS NULL AND st.task_id IS NULL
+				  AND EXISTS (SELECT 1 FROM storage_path sp WHERE sp.storage_id = st.storage_id)
+				ORDER BY st.import_id, st.storage_id LIMIT 1`)
+			if err != nil {
+				return false, xerrors.Errorf("getting storage paths: %w", err)
+			}
+			if len(rows) == 0 {
+				return false, nil
+			}
+
+			_, err = tx.Exec(`UPDATE lm_import_storage SET task_id = $1 WHERE import_id = $2 AND storage_id = $3`,
+				id, rows[0].ImportID, rows[0].StorageID)
+			if err != nil {
+				return false, xerrors.Errorf("updating task id: %w", err)
+			}
+
+			stop = false
+			return true, nil
+		})
+	}
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&RedeclareTask{})
+var _ harmonytask.TaskInterface = &RedeclareTask{}
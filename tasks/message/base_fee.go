@@ -0,0 +1,81 @@
+package message
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// baseFeeHistory keeps the base fees of recent epochs, fetching only tipsets it didn't see before. Each user keeps
+// its own history, as the epochs kept depend on the lookback.
+type baseFeeHistory struct {
+	api GasOracleAPI
+
+	lk       sync.Mutex
+	baseFees map[abi.ChainEpoch]abi.TokenAmount
+}
+
+func newBaseFeeHistory(api GasOracleAPI) *baseFeeHistory {
+	return &baseFeeHistory{
+		api:      api,
+		baseFees: map[abi.ChainEpoch]abi.TokenAmount{},
+	}
+}
+
+// fees returns the current head and the base fees of the last lookback epochs, sorted ascending.
+func (h *baseFeeHistory) fees(ctx context.Context, lookback abi.ChainEpoch) (*types.TipSet, []abi.TokenAmount, error) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	head, err := h.api.ChainHead(ctx)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+	oldest := head.Height() - lookback
+
+	// walk back until an epoch seen before, only new tipsets are fetched on later calls
+	ts := head
+	for ts.Height() > oldest {
+		if _, ok := h.baseFees[ts.Height()]; ok {
+			break
+		}
+		h.baseFees[ts.Height()] = ts.Blocks()[0].ParentBaseFee
+
+		ts, err = h.api.ChainGetTipSet(ctx, ts.Parents())
+		if err != nil {
+			return nil, nil, xerrors.Errorf("getting parent tipset: %w", err)
+		}
+	}
+
+	fees := make([]abi.TokenAmount, 0, len(h.baseFees))
+	for e, f := range h.baseFees {
+		if e <= oldest {
+			delete(h.baseFees, e)
+			continue
+		}
+		fees = append(fees, f)
+	}
+	if len(fees) == 0 {
+		return nil, nil, xerrors.Errorf("no base fees in lookback")
+	}
+
+	sort.Slice(fees, func(i, j int) bool {
+		return fees[i].LessThan(fees[j])
+	})
+	return head, fees, nil
+}
+
+// percentileOf returns the pct percentile of sorted fees.
+func percentileOf(sorted []abi.TokenAmount, pct int) abi.TokenAmount {
+	idx := (len(sorted)*pct+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
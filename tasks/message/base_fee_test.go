@@ -0,0 +1,70 @@
+package message
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+)
+
+func tokens(vs ...int64) []abi.TokenAmount {
+	out := make([]abi.TokenAmount, len(vs))
+	for i, v := range vs {
+		out[i] = big.NewInt(v)
+	}
+	return out
+}
+
+func TestBaseFeeHistory(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100)
+	h := newBaseFeeHistory(chain)
+
+	head, fees, err := h.fees(ctx, 4)
+	require.NoError(t, err)
+	require.Equal(t, abi.ChainEpoch(9), head.Height())
+	require.Equal(t, tokens(70, 80, 90, 100), fees)
+	require.Equal(t, 4, chain.gets)
+
+	// nothing new to fetch at the same head
+	_, fees, err = h.fees(ctx, 4)
+	require.NoError(t, err)
+	require.Equal(t, tokens(70, 80, 90, 100), fees)
+	require.Equal(t, 4, chain.gets)
+
+	// only the new tipset is fetched, and epochs out of the lookback are dropped
+	chain.extend(t, 5)
+	head, fees, err = h.fees(ctx, 4)
+	require.NoError(t, err)
+	require.Equal(t, abi.ChainEpoch(10), head.Height())
+	require.Equal(t, tokens(5, 80, 90, 100), fees)
+	require.Equal(t, 5, chain.gets)
+	require.Len(t, h.baseFees, 4)
+}
+
+func TestPercentileOf(t *testing.T) {
+	fees := tokens(10, 20, 30, 40, 50)
+
+	cases := []struct {
+		pct  int
+		want int64
+	}{
+		{0, 10},
+		{1, 10},
+		{20, 10},
+		{21, 20},
+		{50, 30},
+		{80, 40},
+		{99, 50},
+		{100, 50},
+	}
+	for _, c := range cases {
+		require.Equal(t, big.NewInt(c.want), percentileOf(fees, c.pct), "percentile %d", c.pct)
+	}
+
+	require.Equal(t, big.NewInt(7), percentileOf(tokens(7), 1))
+	require.Equal(t, big.NewInt(7), percentileOf(tokens(7), 100))
+}
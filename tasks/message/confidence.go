@@ -0,0 +1,111 @@
+package message
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/deps/config"
+
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const (
+	// ConfidenceIncluded records executions as soon as the message is executed on the current head.
+	ConfidenceIncluded = "included"
+	// ConfidenceFinalized records executions once the execution tipset is final, per F3 when the chain node runs
+	// it, otherwise after EC finality.
+	ConfidenceFinalized = "finalized"
+)
+
+// Confidence is how settled a message execution must be before it is recorded in message_waits, which is when
+// the tasks waiting for the message act on it.
+type Confidence struct {
+	// Epochs is the number of epochs the execution tipset must be below the head.
+	Epochs abi.ChainEpoch
+	// Finalized requires the execution tipset to be finalized.
+	Finalized bool
+}
+
+// DefaultConfidence is used for messages sent for reasons without a configured confidence.
+var DefaultConfidence = Confidence{Epochs: MinConfidence}
+
+func (c Confidence) String() string {
+	switch {
+	case c.Finalized:
+		return ConfidenceFinalized
+	case c.Epochs == 0:
+		return ConfidenceIncluded
+	default:
+		return strconv.FormatInt(int64(c.Epochs), 10)
+	}
+}
+
+// ParseConfidence parses 'included', 'finalized' or a number of epochs.
+func ParseConfidence(s string) (Confidence, error) {
+	switch s = strings.TrimSpace(strings.ToLower(s)); s {
+	case ConfidenceIncluded:
+		return Confidence{}, nil
+	case ConfidenceFinalized:
+		return Confidence{Finalized: true}, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return Confidence{}, xerrors.Errorf("expected '%s', '%s' or a number of epochs, got '%s'", ConfidenceIncluded, ConfidenceFinalized, s)
+	}
+	return Confidence{Epochs: abi.ChainEpoch(n)}, nil
+}
+
+// NewConfidences maps send reasons to the confidence their message executions are recorded with.
+func NewConfidences(cfg []config.MessageConfidenceConfig) (map[string]Confidence, error) {
+	out := map[string]Confidence{}
+	for _, c := range cfg {
+		conf, err := ParseConfidence(c.Confidence)
+		if err != nil {
+			return nil, xerrors.Errorf("message confidence for %v: %w", c.Reasons, err)
+		}
+
+		for _, reason := range c.Reasons {
+			if _, ok := out[reason]; ok {
+				return nil, xerrors.Errorf("send reason '%s' has more than one confidence", reason)
+			}
+			out[reason] = conf
+		}
+	}
+	return out, nil
+}
+
+// reached returns whether an execution at epoch exec is settled enough with the given head and finalized epochs.
+func (c Confidence) reached(exec, head, finalized abi.ChainEpoch) bool {
+	if c.Finalized {
+		return exec <= finalized
+	}
+	return head-exec >= c.Epochs
+}
+
+// finalizedEpoch returns the highest finalized epoch: the head of the latest F3 certificate when F3 is running on
+// the chain node, EC finality otherwise.
+func (mw *MessageWatcher) finalizedEpoch(ctx context.Context, head *types.TipSet) abi.ChainEpoch {
+	ecFinal := head.Height() - policy.ChainFinality
+
+	cert, err := mw.api.F3GetLatestCertificate(ctx)
+	if err != nil || cert == nil || cert.ECChain.IsZero() {
+		if err != nil {
+			log.Debugw("no F3 finality certificate, using EC finality", "error", err)
+		}
+		return ecFinal
+	}
+
+	f3Final := abi.ChainEpoch(cert.ECChain.Head().Epoch)
+	if f3Final < ecFinal {
+		// F3 is behind, EC finality still holds
+		return ecFinal
+	}
+	return f3Final
+}
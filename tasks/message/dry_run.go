@@ -0,0 +1,138 @@
+package message
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/deps/config"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// DryRun selects messages which are captured in message_dry_runs instead of being sent: all messages, or the
+// messages of address groups with dry-run enabled.
+type DryRun struct {
+	all bool
+
+	// spIDs are the ID addresses of all configured miners, to attribute captured messages
+	spIDs map[address.Address]int64
+	// targets are ID addresses of miners and funds multisigs of dry-run address groups
+	targets map[address.Address]bool
+	// sources are key addresses of top-up sources of dry-run address groups
+	sources map[address.Address]bool
+}
+
+// NewDryRun creates the dry-run selection, nil when dry-run is disabled everywhere.
+func NewDryRun(ctx context.Context, api SenderAPI, all bool, addrs []config.CurioAddresses) (*DryRun, error) {
+	d := &DryRun{
+		all:     all,
+		spIDs:   map[address.Address]int64{},
+		targets: map[address.Address]bool{},
+		sources: map[address.Address]bool{},
+	}
+
+	var enabled bool
+	for _, a := range addrs {
+		enabled = enabled || a.DryRunSends
+
+		for _, s := range a.MinerAddresses {
+			maddr, err := address.NewFromString(s)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing miner address %s: %w", s, err)
+			}
+			id, err := address.IDFromAddress(maddr)
+			if err != nil {
+				return nil, xerrors.Errorf("miner ID of %s: %w", maddr, err)
+			}
+			d.spIDs[maddr] = int64(id)
+			if a.DryRunSends {
+				d.targets[maddr] = true
+			}
+		}
+
+		if !a.DryRunSends {
+			continue
+		}
+		if a.FundsMultisig != "" {
+			msig, err := address.NewFromString(a.FundsMultisig)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing funds multisig %s: %w", a.FundsMultisig, err)
+			}
+			id, err := api.StateLookupID(ctx, msig, types.EmptyTSK)
+			if err != nil {
+				return nil, xerrors.Errorf("looking up ID of %s: %w", msig, err)
+			}
+			d.targets[id] = true
+		}
+		if a.TopUp.Source != "" {
+			src, err := address.NewFromString(a.TopUp.Source)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing top-up source %s: %w", a.TopUp.Source, err)
+			}
+			key, err := api.StateAccountKey(ctx, src, types.EmptyTSK)
+			if err != nil {
+				return nil, xerrors.Errorf("getting key address of %s: %w", src, err)
+			}
+			d.sources[key] = true
+		}
+	}
+
+	if !all && !enabled {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// SetDryRun sets which messages are captured instead of sent.
+func (s *Sender) SetDryRun(d *DryRun) {
+	s.dryRun = d
+}
+
+// dryRunOf returns whether msg, with a key From address, is to be captured, and the SP it is sent for if known.
+func (s *Sender) dryRunOf(ctx context.Context, msg *types.Message) (bool, *int64) {
+	d := s.dryRun
+	if d == nil {
+		return false, nil
+	}
+
+	to := msg.To
+	if id, err := s.api.StateLookupID(ctx, msg.To, types.EmptyTSK); err == nil {
+		to = id
+	}
+
+	var spID *int64
+	if id, ok := d.spIDs[to]; ok {
+		spID = &id
+	}
+
+	return d.all || d.targets[to] || d.sources[msg.From], spID
+}
+
+// captureDryRun records msg in message_dry_runs. The unsigned message CID is returned in place of a signed message
+// CID, it never lands on chain.
+func (s *Sender) captureDryRun(ctx context.Context, msg *types.Message, reason string, spID *int64) (cid.Cid, error) {
+	unsBytes := new(bytes.Buffer)
+	if err := msg.MarshalCBOR(unsBytes); err != nil {
+		return cid.Undef, xerrors.Errorf("marshaling message: %w", err)
+	}
+	jsonBytes, err := msg.MarshalJSON()
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("marshaling message: %w", err)
+	}
+
+	_, err = s.db.Exec(ctx, `INSERT INTO message_dry_runs (from_key, to_addr, send_reason, sp_id, unsigned_cid, unsigned_data, unsigned_json)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		msg.From.String(), msg.To.String(), reason, spID, msg.Cid().String(), unsBytes.Bytes(), string(jsonBytes))
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("recording dry-run message: %w", err)
+	}
+
+	log.Warnw("dry-run: captured message instead of sending it", "from", msg.From, "to", msg.To, "reason", reason,
+		"method", msg.Method, "value", types.FIL(msg.Value).Short(), "cid", msg.Cid())
+	return msg.Cid(), nil
+}
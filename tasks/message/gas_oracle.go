@@ -0,0 +1,192 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/deps/config"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const (
+	GasStrategyEstimate   = "estimate"
+	GasStrategyPercentile = "base-fee-percentile"
+	GasStrategyExternal   = "external"
+)
+
+// GasOracle prices messages. Price is called with a message already estimated by the chain node, and may change
+// its GasFeeCap and GasPremium.
+type GasOracle interface {
+	Price(ctx context.Context, msg *types.Message) error
+}
+
+type GasOracleAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	ChainGetTipSet(context.Context, types.TipSetKey) (*types.TipSet, error)
+}
+
+// NewGasOracles creates the gas oracles configured for send reasons.
+func NewGasOracles(api GasOracleAPI, cfg []config.GasPricingConfig) (map[string]GasOracle, error) {
+	out := map[string]GasOracle{}
+	for _, c := range cfg {
+		var o GasOracle
+		switch c.Strategy {
+		case GasStrategyEstimate, "":
+			o = &estimateOracle{premiumMul: c.PremiumMultiplier}
+		case GasStrategyPercentile:
+			if c.Percentile <= 0 || c.Percentile > 100 {
+				return nil, xerrors.Errorf("gas pricing percentile must be in (0, 100], got %d", c.Percentile)
+			}
+			if c.Lookback <= 0 {
+				return nil, xerrors.Errorf("gas pricing lookback must be positive, got %d", c.Lookback)
+			}
+			o = &percentileOracle{
+				api:        api,
+				percentile: c.Percentile,
+				lookback:   abi.ChainEpoch(c.Lookback),
+				feeCapMul:  c.FeeCapMultiplier,
+				premiumMul: c.PremiumMultiplier,
+				history:    newBaseFeeHistory(api),
+			}
+		case GasStrategyExternal:
+			if c.URL == "" {
+				return nil, xerrors.Errorf("gas pricing strategy %s requires a URL", c.Strategy)
+			}
+			o = &externalOracle{url: c.URL, client: &http.Client{Timeout: 10 * time.Second}}
+		default:
+			return nil, xerrors.Errorf("unknown gas pricing strategy '%s'", c.Strategy)
+		}
+
+		for _, r := range c.Reasons {
+			if _, ok := out[r]; ok {
+				return nil, xerrors.Errorf("send reason '%s' has more than one gas pricing strategy", r)
+			}
+			out[r] = o
+		}
+	}
+	return out, nil
+}
+
+func mulBig(v big.Int, m float64) big.Int {
+	if m <= 0 || m == 1 {
+		return v
+	}
+	// 1/1000 precision is plenty for fee multipliers
+	return big.Div(big.Mul(v, big.NewInt(int64(math.Round(m*1000)))), big.NewInt(1000))
+}
+
+// estimateOracle uses the chain node estimate, optionally scaling the premium.
+type estimateOracle struct {
+	premiumMul float64
+}
+
+func (e *estimateOracle) Price(ctx context.Context, msg *types.Message) error {
+	msg.GasPremium = mulBig(msg.GasPremium, e.premiumMul)
+	msg.GasFeeCap = big.Max(msg.GasFeeCap, msg.GasPremium)
+	return nil
+}
+
+// percentileOracle sets the fee cap from a percentile of base fees over recent epochs.
+type percentileOracle struct {
+	api        GasOracleAPI
+	percentile int
+	lookback   abi.ChainEpoch
+	feeCapMul  float64
+	premiumMul float64
+
+	history *baseFeeHistory
+}
+
+func (p *percentileOracle) Price(ctx context.Context, msg *types.Message) error {
+	baseFee, err := p.baseFeePercentile(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting base fee percentile: %w", err)
+	}
+
+	msg.GasPremium = mulBig(msg.GasPremium, p.premiumMul)
+	msg.GasFeeCap = big.Add(mulBig(baseFee, p.feeCapMul), msg.GasPremium)
+	return nil
+}
+
+func (p *percentileOracle) baseFeePercentile(ctx context.Context) (abi.TokenAmount, error) {
+	_, fees, err := p.history.fees(ctx, p.lookback)
+	if err != nil {
+		return big.Zero(), err
+	}
+	return percentileOf(fees, p.percentile), nil
+}
+
+// externalOracle fetches the fee cap and premium from an HTTP endpoint.
+type externalOracle struct {
+	url    string
+	client *http.Client
+}
+
+// ExternalGasPrice is the response expected from external gas pricing endpoints, in attoFIL per gas unit.
+type ExternalGasPrice struct {
+	GasFeeCap string
+	// GasPremium is optional, the chain node estimate is kept when empty
+	GasPremium string
+}
+
+func (e *externalOracle) Price(ctx context.Context, msg *types.Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url, nil)
+	if err != nil {
+		return xerrors.Errorf("creating request: %w", err)
+	}
+	q := req.URL.Query()
+	q.Set("from", msg.From.String())
+	q.Set("to", msg.To.String())
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("requesting gas price: %w", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		return xerrors.Errorf("gas price endpoint returned %d", resp.StatusCode)
+	}
+
+	var price ExternalGasPrice
+	if err := json.NewDecoder(resp.Body).Decode(&price); err != nil {
+		return xerrors.Errorf("decoding gas price: %w", err)
+	}
+
+	feeCap, err := big.FromString(price.GasFeeCap)
+	if err != nil {
+		return xerrors.Errorf("parsing fee cap: %w", err)
+	}
+	if price.GasPremium != "" {
+		premium, err := big.FromString(price.GasPremium)
+		if err != nil {
+			return xerrors.Errorf("parsing premium: %w", err)
+		}
+		msg.GasPremium = premium
+	}
+	msg.GasFeeCap = big.Max(feeCap, msg.GasPremium)
+	return nil
+}
+
+// capGasFee keeps the fee of msg within maxFee, like the chain node does for its estimates.
+func capGasFee(msg *types.Message, maxFee abi.TokenAmount) {
+	if maxFee.NilOrZero() || msg.GasLimit == 0 {
+		return
+	}
+
+	gl := big.NewInt(msg.GasLimit)
+	if big.Mul(msg.GasFeeCap, gl).GreaterThan(maxFee) {
+		msg.GasFeeCap = big.Div(maxFee, gl)
+		msg.GasPremium = big.Min(msg.GasFeeCap, msg.GasPremium)
+	}
+}
@@ -0,0 +1,239 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+
+	"github.com/filecoin-project/curio/deps/config"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// testChain is a chain with one block per epoch, starting at epoch 0.
+type testChain struct {
+	head  *types.TipSet
+	byKey map[types.TipSetKey]*types.TipSet
+	gets  int
+}
+
+// newTestChain creates a chain with the given parent base fees, one per epoch.
+func newTestChain(t *testing.T, baseFees ...int64) *testChain {
+	c := &testChain{byKey: map[types.TipSetKey]*types.TipSet{}}
+	for _, f := range baseFees {
+		c.extend(t, f)
+	}
+	return c
+}
+
+// extend adds a tipset with the given parent base fee on top of the chain.
+func (c *testChain) extend(t *testing.T, baseFee int64) {
+	empty := cid.MustParse("bafkqaaa") // identity CID of no data
+
+	var parents []cid.Cid
+	var height abi.ChainEpoch
+	if c.head != nil {
+		parents = c.head.Cids()
+		height = c.head.Height() + 1
+	}
+
+	ts, err := types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 builtin.SystemActorAddr,
+		Parents:               parents,
+		Height:                height,
+		ParentStateRoot:       empty,
+		ParentMessageReceipts: empty,
+		Messages:              empty,
+		ParentBaseFee:         types.NewInt(uint64(baseFee)),
+	}})
+	require.NoError(t, err)
+
+	c.head = ts
+	c.byKey[ts.Key()] = ts
+}
+
+func (c *testChain) ChainHead(context.Context) (*types.TipSet, error) {
+	return c.head, nil
+}
+
+func (c *testChain) ChainGetTipSet(_ context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	c.gets++
+	ts, ok := c.byKey[tsk]
+	if !ok {
+		return nil, xerrors.Errorf("tipset %s not found", tsk)
+	}
+	return ts, nil
+}
+
+func fil(v int64) types.FIL {
+	return types.FIL(big.NewInt(v))
+}
+
+func TestNewGasOracles(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  config.GasPricingConfig
+		err  string
+	}{
+		{"estimate", config.GasPricingConfig{Reasons: []string{"a"}, Strategy: GasStrategyEstimate}, ""},
+		{"default strategy", config.GasPricingConfig{Reasons: []string{"a"}}, ""},
+		{"percentile", config.GasPricingConfig{Reasons: []string{"a"}, Strategy: GasStrategyPercentile, Percentile: 100, Lookback: 10}, ""},
+		{"percentile zero", config.GasPricingConfig{Strategy: GasStrategyPercentile, Lookback: 10}, "percentile must be in (0, 100]"},
+		{"percentile above 100", config.GasPricingConfig{Strategy: GasStrategyPercentile, Percentile: 101, Lookback: 10}, "percentile must be in (0, 100]"},
+		{"no lookback", config.GasPricingConfig{Strategy: GasStrategyPercentile, Percentile: 50}, "lookback must be positive"},
+		{"external", config.GasPricingConfig{Strategy: GasStrategyExternal, URL: "http://localhost/gas"}, ""},
+		{"external without url", config.GasPricingConfig{Strategy: GasStrategyExternal}, "requires a URL"},
+		{"unknown", config.GasPricingConfig{Strategy: "cheapest"}, "unknown gas pricing strategy 'cheapest'"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewGasOracles(newTestChain(t, 1), []config.GasPricingConfig{c.cfg})
+			if c.err != "" {
+				require.ErrorContains(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+
+	oracles, err := NewGasOracles(newTestChain(t, 1), []config.GasPricingConfig{
+		{Reasons: []string{"a", "b"}},
+		{Reasons: []string{"c"}, Strategy: GasStrategyPercentile, Percentile: 50, Lookback: 10},
+	})
+	require.NoError(t, err)
+	require.Len(t, oracles, 3)
+	require.IsType(t, &estimateOracle{}, oracles["a"])
+	require.Same(t, oracles["a"], oracles["b"])
+	require.IsType(t, &percentileOracle{}, oracles["c"])
+
+	_, err = NewGasOracles(newTestChain(t, 1), []config.GasPricingConfig{{Reasons: []string{"a"}}, {Reasons: []string{"a"}}})
+	require.ErrorContains(t, err, "send reason 'a' has more than one gas pricing strategy")
+}
+
+func TestMulBig(t *testing.T) {
+	cases := []struct {
+		v    int64
+		m    float64
+		want int64
+	}{
+		{1000, 0, 1000},
+		{1000, -1, 1000},
+		{1000, 1, 1000},
+		{1000, 1.25, 1250},
+		{1000, 0.5, 500},
+		{3, 0.5, 1},
+		{7, 3, 21},
+	}
+	for _, c := range cases {
+		require.Equal(t, big.NewInt(c.want), mulBig(big.NewInt(c.v), c.m), "%d * %f", c.v, c.m)
+	}
+}
+
+func TestOraclePrice(t *testing.T) {
+	// base fees 70, 80, 90, 100 in the last 4 epochs
+	chain := newTestChain(t, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100)
+
+	cases := []struct {
+		name              string
+		oracle            GasOracle
+		feeCap, premium   int64
+		wantCap, wantPrem int64
+	}{
+		{"estimate", &estimateOracle{}, 200, 100, 200, 100},
+		{"estimate premium multiplier", &estimateOracle{premiumMul: 1.5}, 120, 100, 150, 150},
+		{"percentile", &percentileOracle{percentile: 50, lookback: 4, feeCapMul: 2, history: newBaseFeeHistory(chain)}, 1, 50, 210, 50},
+		{"percentile max", &percentileOracle{percentile: 100, lookback: 4, premiumMul: 2, history: newBaseFeeHistory(chain)}, 1, 50, 200, 100},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg := &types.Message{GasFeeCap: big.NewInt(c.feeCap), GasPremium: big.NewInt(c.premium)}
+			require.NoError(t, c.oracle.Price(context.Background(), msg))
+			require.Equal(t, big.NewInt(c.wantCap), msg.GasFeeCap)
+			require.Equal(t, big.NewInt(c.wantPrem), msg.GasPremium)
+		})
+	}
+}
+
+func TestExternalOracle(t *testing.T) {
+	from, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	to, err := address.NewIDAddress(1002)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fee-cap", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, from.String(), r.URL.Query().Get("from"))
+		require.Equal(t, to.String(), r.URL.Query().Get("to"))
+		_ = json.NewEncoder(w).Encode(ExternalGasPrice{GasFeeCap: "500"})
+	})
+	mux.HandleFunc("/premium", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(ExternalGasPrice{GasFeeCap: "500", GasPremium: "700"})
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no prices", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/garbage", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(ExternalGasPrice{GasFeeCap: "cheap"})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cases := []struct {
+		path              string
+		wantCap, wantPrem int64
+		err               string
+	}{
+		{"/fee-cap", 500, 100, ""},
+		{"/premium", 700, 700, ""},
+		{"/broken", 0, 0, "returned 503"},
+		{"/garbage", 0, 0, "parsing fee cap"},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			o := &externalOracle{url: srv.URL + c.path, client: srv.Client()}
+			msg := &types.Message{From: from, To: to, GasFeeCap: big.NewInt(200), GasPremium: big.NewInt(100)}
+			err := o.Price(context.Background(), msg)
+			if c.err != "" {
+				require.ErrorContains(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, big.NewInt(c.wantCap), msg.GasFeeCap)
+			require.Equal(t, big.NewInt(c.wantPrem), msg.GasPremium)
+		})
+	}
+}
+
+func TestCapGasFee(t *testing.T) {
+	cases := []struct {
+		name              string
+		gasLimit          int64
+		maxFee            abi.TokenAmount
+		wantCap, wantPrem int64
+	}{
+		{"no max fee", 100, big.Zero(), 50, 30},
+		{"no gas limit", 0, big.NewInt(1), 50, 30},
+		{"within max fee", 100, big.NewInt(5000), 50, 30},
+		{"fee cap lowered", 100, big.NewInt(4000), 40, 30},
+		{"premium lowered", 100, big.NewInt(1000), 10, 10},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg := &types.Message{GasLimit: c.gasLimit, GasFeeCap: big.NewInt(50), GasPremium: big.NewInt(30)}
+			capGasFee(msg, c.maxFee)
+			require.Equal(t, big.NewInt(c.wantCap), msg.GasFeeCap)
+			require.Equal(t, big.NewInt(c.wantPrem), msg.GasPremium)
+		})
+	}
+}
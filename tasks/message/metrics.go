@@ -0,0 +1,46 @@
+package message
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	reasonTag, _ = tag.NewKey("reason")
+	pre          = "message_"
+)
+
+// SendWindowMeasures groups metrics of messages deferred by send windows.
+var SendWindowMeasures = struct {
+	Deferred       *stats.Int64Measure
+	DeferredTime   *stats.Float64Measure
+	BaseFeeSavings *stats.Float64Measure
+}{
+	Deferred:       stats.Int64(pre+"deferred", "Total number of messages deferred by send windows.", stats.UnitDimensionless),
+	DeferredTime:   stats.Float64(pre+"deferred_seconds", "Total time messages were held by send windows.", stats.UnitSeconds),
+	BaseFeeSavings: stats.Float64(pre+"base_fee_savings_fil", "Base fee saved by deferred messages versus sending them immediately, in FIL. Negative when the base fee rose.", stats.UnitDimensionless),
+}
+
+func init() {
+	err := view.Register(
+		&view.View{
+			Measure:     SendWindowMeasures.Deferred,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{reasonTag},
+		},
+		&view.View{
+			Measure:     SendWindowMeasures.DeferredTime,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{reasonTag},
+		},
+		&view.View{
+			Measure:     SendWindowMeasures.BaseFeeSavings,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{reasonTag},
+		},
+	)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,345 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/bls"
+	_ "github.com/filecoin-project/lotus/lib/sigs/delegated"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp"
+)
+
+type OfflineSignAPI interface {
+	StateAccountKey(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+	MpoolGetNonce(context.Context, address.Address) (uint64, error)
+	GasEstimateMessageGas(context.Context, *types.Message, *lapi.MessageSendSpec, types.TipSetKey) (*types.Message, error)
+}
+
+// OfflineBundle is a set of messages exported for signing on an air-gapped machine. The same bundle, with
+// Signature filled in, is imported back.
+type OfflineBundle struct {
+	Messages []OfflineMessage
+}
+
+type OfflineMessage struct {
+	SendTaskID int64
+	Reason     string
+	QueuedAt   time.Time
+
+	Message *types.Message
+	// MessageCBOR is the serialized message, for signers which take the message itself.
+	MessageCBOR []byte
+	Cid         cid.Cid
+
+	// SigningBytes are the hex encoded bytes to sign, e.g. with 'lotus wallet sign <From> <SigningBytes>'.
+	SigningBytes string
+
+	// Signature is the hex encoded signature type byte followed by the signature, as printed by
+	// 'lotus wallet sign'. Empty in exported bundles.
+	Signature string `json:",omitempty"`
+}
+
+// NewOfflineSigners resolves the key addresses of addresses whose messages are signed offline.
+func NewOfflineSigners(ctx context.Context, api OfflineSignAPI, addrs []string) (map[address.Address]bool, error) {
+	out := map[address.Address]bool{}
+	for _, s := range addrs {
+		a, err := address.NewFromString(s)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing offline sign address %s: %w", s, err)
+		}
+		ka, err := api.StateAccountKey(ctx, a, types.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("getting key address of %s: %w", a, err)
+		}
+		out[ka] = true
+	}
+	return out, nil
+}
+
+// awaitingOfflineSignature returns the send tasks among ids whose message wasn't signed offline yet.
+func awaitingOfflineSignature(ctx context.Context, db *harmonydb.DB, ids []int64) (map[int64]bool, error) {
+	var pending []int64
+	err := db.Select(ctx, &pending, `SELECT send_task_id FROM message_offline_signs
+		WHERE send_task_id = ANY($1) AND signed_at IS NULL`, ids)
+	if err != nil {
+		return nil, xerrors.Errorf("getting messages awaiting offline signature: %w", err)
+	}
+
+	out := map[int64]bool{}
+	for _, id := range pending {
+		out[id] = true
+	}
+	return out, nil
+}
+
+type offlineRow struct {
+	SendTaskID   int64     `db:"send_task_id"`
+	FromKey      string    `db:"from_key"`
+	Reason       string    `db:"send_reason"`
+	UnsignedData []byte    `db:"unsigned_data"`
+	Nonce        *uint64   `db:"nonce"`
+	MaxFee       *string   `db:"max_fee"`
+	QueuedAt     time.Time `db:"queued_at"`
+}
+
+// ExportOfflineMessages exports the messages awaiting offline signature, optionally only from one address.
+// Messages exported for the first time are assigned nonces following the last nonce sent or reserved from their
+// address, so that they can be signed in any order and broadcast as soon as their signatures are imported. Their gas
+// is estimated again by the chain node, within the max fee they were sent with, as the estimate from when they were
+// queued may be days old. Later exports return the same messages, which may have been signed already.
+func ExportOfflineMessages(ctx context.Context, db *harmonydb.DB, api OfflineSignAPI, from address.Address) (*OfflineBundle, error) {
+	fromKey := ""
+	if from != address.Undef {
+		ka, err := api.StateAccountKey(ctx, from, types.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("getting key address of %s: %w", from, err)
+		}
+		fromKey = ka.String()
+	}
+
+	var rows []offlineRow
+	err := db.Select(ctx, &rows, `SELECT o.send_task_id, o.from_key, s.send_reason, s.unsigned_data, s.nonce, s.max_fee::TEXT AS max_fee, o.queued_at
+		FROM message_offline_signs o
+		JOIN message_sends s ON s.send_task_id = o.send_task_id AND s.from_key = o.from_key
+		WHERE o.signed_at IS NULL AND ($1 = '' OR o.from_key = $1)
+		ORDER BY o.send_task_id`, fromKey)
+	if err != nil {
+		return nil, xerrors.Errorf("getting messages awaiting offline signature: %w", err)
+	}
+
+	mpoolNonces := map[string]uint64{}
+	for i, r := range rows {
+		if r.Nonce != nil {
+			continue
+		}
+
+		// the estimate from when the message was queued is stale
+		if err := rows[i].estimate(ctx, api); err != nil {
+			return nil, err
+		}
+
+		if _, ok := mpoolNonces[r.FromKey]; ok {
+			continue
+		}
+		a, err := address.NewFromString(r.FromKey)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing from address %s: %w", r.FromKey, err)
+		}
+		n, err := api.MpoolGetNonce(ctx, a)
+		if err != nil {
+			return nil, xerrors.Errorf("getting nonce of %s from mpool: %w", a, err)
+		}
+		mpoolNonces[r.FromKey] = n
+	}
+
+	_, err = db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		for i, r := range rows {
+			if r.Nonce != nil {
+				continue
+			}
+
+			// nonces of messages not yet broadcast, but signed or exported for signing, are taken
+			var dbNonce *uint64
+			err := tx.QueryRow(`SELECT MAX(nonce) FROM message_sends WHERE from_key = $1 AND send_success IS NOT FALSE`,
+				r.FromKey).Scan(&dbNonce)
+			if err != nil {
+				return false, xerrors.Errorf("getting nonce from db: %w", err)
+			}
+
+			nonce := mpoolNonces[r.FromKey]
+			if dbNonce != nil && *dbNonce+1 > nonce {
+				nonce = *dbNonce + 1
+			}
+
+			msg, err := r.message()
+			if err != nil {
+				return false, err
+			}
+
+			n, err := tx.Exec(`UPDATE message_sends SET nonce = $1, unsigned_data = $2, unsigned_cid = $3
+				WHERE send_task_id = $4 AND from_key = $5 AND nonce IS NULL`,
+				nonce, r.UnsignedData, msg.Cid().String(), r.SendTaskID, r.FromKey)
+			if err != nil {
+				return false, xerrors.Errorf("assigning nonce: %w", err)
+			}
+			if n != 1 {
+				return false, xerrors.Errorf("assigning nonce: expected 1 row to be affected, got %d", n)
+			}
+			rows[i].Nonce = &nonce
+		}
+
+		_, err = tx.Exec(`UPDATE message_offline_signs SET exported_at = CURRENT_TIMESTAMP
+			WHERE signed_at IS NULL AND ($1 = '' OR from_key = $1)`, fromKey)
+		if err != nil {
+			return false, xerrors.Errorf("marking messages exported: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &OfflineBundle{Messages: []OfflineMessage{}}
+	for _, r := range rows {
+		msg, err := r.message()
+		if err != nil {
+			return nil, err
+		}
+
+		mb := new(bytes.Buffer)
+		if err := msg.MarshalCBOR(mb); err != nil {
+			return nil, xerrors.Errorf("marshaling message: %w", err)
+		}
+
+		bundle.Messages = append(bundle.Messages, OfflineMessage{
+			SendTaskID:   r.SendTaskID,
+			Reason:       r.Reason,
+			QueuedAt:     r.QueuedAt,
+			Message:      msg,
+			MessageCBOR:  mb.Bytes(),
+			Cid:          msg.Cid(),
+			SigningBytes: hex.EncodeToString(msg.Cid().Bytes()),
+		})
+	}
+	return bundle, nil
+}
+
+// estimate estimates the gas of a message which wasn't exported yet again, bounded by its max fee.
+func (r *offlineRow) estimate(ctx context.Context, api OfflineSignAPI) error {
+	msg, err := r.message()
+	if err != nil {
+		return err
+	}
+
+	maxFee := big.Zero()
+	if r.MaxFee != nil {
+		maxFee, err = big.FromString(*r.MaxFee)
+		if err != nil {
+			return xerrors.Errorf("parsing max fee of send task %d: %w", r.SendTaskID, err)
+		}
+	}
+
+	msg.GasLimit, msg.GasFeeCap, msg.GasPremium = 0, big.Zero(), big.Zero()
+	msg, err = api.GasEstimateMessageGas(ctx, msg, &lapi.MessageSendSpec{MaxFee: maxFee}, types.EmptyTSK)
+	if err != nil {
+		return xerrors.Errorf("estimating gas of send task %d: %w", r.SendTaskID, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := msg.MarshalCBOR(buf); err != nil {
+		return xerrors.Errorf("marshaling message of send task %d: %w", r.SendTaskID, err)
+	}
+	r.UnsignedData = buf.Bytes()
+	return nil
+}
+
+// message returns the queued message with its assigned nonce.
+func (r offlineRow) message() (*types.Message, error) {
+	var msg types.Message
+	if err := msg.UnmarshalCBOR(bytes.NewReader(r.UnsignedData)); err != nil {
+		return nil, xerrors.Errorf("unmarshaling message of send task %d: %w", r.SendTaskID, err)
+	}
+	if r.Nonce != nil {
+		msg.Nonce = *r.Nonce
+	}
+	return &msg, nil
+}
+
+// ImportOfflineSignatures imports the signatures of bundle messages signed offline. Signatures are checked against
+// the queued messages before they are stored, after which the send tasks broadcast the signed messages. Messages
+// without a signature are skipped. It returns the number of imported signatures.
+func ImportOfflineSignatures(ctx context.Context, db *harmonydb.DB, bundle *OfflineBundle) (int, error) {
+	var imported int
+	for _, om := range bundle.Messages {
+		if om.Signature == "" {
+			continue
+		}
+
+		var rows []offlineRow
+		err := db.Select(ctx, &rows, `SELECT o.send_task_id, o.from_key, s.send_reason, s.unsigned_data, s.nonce, s.max_fee::TEXT AS max_fee, o.queued_at
+			FROM message_offline_signs o
+			JOIN message_sends s ON s.send_task_id = o.send_task_id AND s.from_key = o.from_key
+			WHERE o.send_task_id = $1 AND o.signed_at IS NULL`, om.SendTaskID)
+		if err != nil {
+			return imported, xerrors.Errorf("getting message of send task %d: %w", om.SendTaskID, err)
+		}
+		if len(rows) == 0 {
+			log.Warnw("skipping signature of a message not awaiting offline signature", "send_task_id", om.SendTaskID)
+			continue
+		}
+		r := rows[0]
+		if r.Nonce == nil {
+			return imported, xerrors.Errorf("message of send task %d was not exported, it has no nonce", om.SendTaskID)
+		}
+
+		msg, err := r.message()
+		if err != nil {
+			return imported, err
+		}
+		if om.Cid.Defined() && !om.Cid.Equals(msg.Cid()) {
+			return imported, xerrors.Errorf("send task %d: signed message %s doesn't match queued message %s", om.SendTaskID, om.Cid, msg.Cid())
+		}
+
+		sigBytes, err := hex.DecodeString(om.Signature)
+		if err != nil {
+			return imported, xerrors.Errorf("send task %d: decoding signature: %w", om.SendTaskID, err)
+		}
+		var sig crypto.Signature
+		if err := sig.UnmarshalBinary(sigBytes); err != nil {
+			return imported, xerrors.Errorf("send task %d: parsing signature: %w", om.SendTaskID, err)
+		}
+		if err := sigs.Verify(&sig, msg.From, msg.Cid().Bytes()); err != nil {
+			return imported, xerrors.Errorf("send task %d: invalid signature: %w", om.SendTaskID, err)
+		}
+
+		sigMsg := &types.SignedMessage{Message: *msg, Signature: sig}
+		data, err := sigMsg.Serialize()
+		if err != nil {
+			return imported, xerrors.Errorf("serializing message: %w", err)
+		}
+		jsonBytes, err := sigMsg.MarshalJSON()
+		if err != nil {
+			return imported, xerrors.Errorf("marshaling message: %w", err)
+		}
+
+		_, err = db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+			n, err := tx.Exec(`UPDATE message_sends SET signed_data = $1, signed_json = $2, signed_cid = $3
+				WHERE send_task_id = $4 AND from_key = $5 AND nonce = $6 AND signed_data IS NULL`,
+				data, string(jsonBytes), sigMsg.Cid().String(), r.SendTaskID, r.FromKey, msg.Nonce)
+			if err != nil {
+				return false, xerrors.Errorf("storing signed message: %w", err)
+			}
+			if n != 1 {
+				return false, xerrors.Errorf("storing signed message: expected 1 row to be affected, got %d", n)
+			}
+
+			_, err = tx.Exec(`UPDATE message_offline_signs SET signed_at = CURRENT_TIMESTAMP WHERE send_task_id = $1 AND from_key = $2`,
+				r.SendTaskID, r.FromKey)
+			if err != nil {
+				return false, xerrors.Errorf("marking message signed: %w", err)
+			}
+			return true, nil
+		}, harmonydb.OptionRetry())
+		if err != nil {
+			return imported, xerrors.Errorf("send task %d: %w", om.SendTaskID, err)
+		}
+
+		log.Infow("imported offline signature", "send_task_id", r.SendTaskID, "from", msg.From, "nonce", msg.Nonce, "cid", sigMsg.Cid())
+		imported++
+	}
+	return imported, nil
+}
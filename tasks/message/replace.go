@@ -0,0 +1,240 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const ReplaceStuckInterval = time.Minute
+
+// ReplaceByFeePercent is the gas premium of a replacement relative to the replaced message. Lotus accepts
+// replacements with at least its configured ReplaceByFeeRatio, 1.25 by default.
+var ReplaceByFeePercent = big.NewInt(125)
+
+// queueAtNonce queues msg for sending at its (already set) nonce, in place of the message currently holding that
+// nonce. The replaced message is marked as failed with replacedErr, and the message wait of the original message
+// records the replacement.
+func (s *Sender) queueAtNonce(ctx context.Context, msg *types.Message, reason, replacedErr string) error {
+	if dryRun, spID := s.dryRunOf(ctx, msg); dryRun {
+		_, err := s.captureDryRun(ctx, msg, reason, spID)
+		return err
+	}
+
+	unsBytes := new(bytes.Buffer)
+	if err := msg.MarshalCBOR(unsBytes); err != nil {
+		return xerrors.Errorf("marshaling message: %w", err)
+	}
+
+	taskAdder := s.sendTask.sendTF.Val(ctx)
+
+	var queued bool
+	taskAdder(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var prev []struct {
+			SignedCid *string `db:"signed_cid"`
+			HeldCid   *string `db:"held_cid"`
+			Replaces  *string `db:"replaces_signed_cid"`
+		}
+		err := tx.Select(&prev, `SELECT signed_cid, held_cid, replaces_signed_cid FROM message_sends
+			WHERE from_key = $1 AND nonce = $2 AND send_success = TRUE`, msg.From.String(), msg.Nonce)
+		if err != nil {
+			return false, xerrors.Errorf("getting replaced message: %w", err)
+		}
+
+		// message waits are keyed by the first message sent at the nonce, or the CID Send returned for it if it
+		// was held
+		var original *string
+		if len(prev) > 0 {
+			original = prev[0].Replaces
+			if original == nil {
+				original = prev[0].HeldCid
+			}
+			if original == nil {
+				original = prev[0].SignedCid
+			}
+		}
+
+		// release the nonce held by the replaced message, the replacement takes it over
+		_, err = tx.Exec(`UPDATE message_sends SET send_success = FALSE, send_error = $3
+			WHERE from_key = $1 AND nonce = $2 AND send_success = TRUE`, msg.From.String(), msg.Nonce, replacedErr)
+		if err != nil {
+			return false, xerrors.Errorf("marking replaced message: %w", err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO message_sends (from_key, to_addr, send_reason, unsigned_data, unsigned_cid, nonce, replaces_signed_cid, send_task_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			msg.From.String(), msg.To.String(), reason, unsBytes.Bytes(), msg.Cid().String(), msg.Nonce, original, id)
+		if err != nil {
+			return false, xerrors.Errorf("inserting message into db: %w", err)
+		}
+
+		if original != nil {
+			_, err = tx.Exec(`UPDATE message_waits SET replaced_by_send_task_id = $2, replacements = replacements + 1
+				WHERE signed_message_cid = $1`, *original, id)
+			if err != nil {
+				return false, xerrors.Errorf("recording replacement in message wait: %w", err)
+			}
+		}
+
+		queued = true
+		return true, nil
+	})
+	if !queued {
+		return xerrors.Errorf("failed to add send task for nonce %d", msg.Nonce)
+	}
+
+	return nil
+}
+
+// ReplaceByFee queues a replacement of a pending message, paying a gas premium at least ReplaceByFeePercent of
+// the replaced one. The fee of the replacement (fee cap * gas limit) is bounded by maxFee.
+func (s *Sender) ReplaceByFee(ctx context.Context, pending *types.Message, reason string, maxFee abi.TokenAmount) error {
+	est := *pending
+	est.GasLimit, est.GasFeeCap, est.GasPremium = 0, big.Zero(), big.Zero()
+
+	estMsg, err := s.api.GasEstimateMessageGas(ctx, &est, &api.MessageSendSpec{MaxFee: maxFee}, types.EmptyTSK)
+	if err != nil {
+		return xerrors.Errorf("estimating gas: %w", err)
+	}
+	s.priceMessage(ctx, estMsg, reason, maxFee)
+
+	minPremium := big.Add(big.Div(big.Mul(pending.GasPremium, ReplaceByFeePercent), big.NewInt(100)), big.NewInt(1))
+
+	rep := *pending
+	rep.GasLimit = estMsg.GasLimit
+	rep.GasPremium = big.Max(estMsg.GasPremium, minPremium)
+	rep.GasFeeCap = big.Max(big.Max(estMsg.GasFeeCap, pending.GasFeeCap), rep.GasPremium)
+
+	if rep.RequiredFunds().GreaterThan(maxFee) {
+		return xerrors.Errorf("replacement fee %s is over the limit of %s", types.FIL(rep.RequiredFunds()).Short(), types.FIL(maxFee).Short())
+	}
+
+	if err := s.queueAtNonce(ctx, &rep, reason, "not included, replaced with a higher fee"); err != nil {
+		return err
+	}
+
+	log.Infow("queued fee bump replacement", "from", rep.From, "nonce", rep.Nonce, "reason", reason,
+		"premium", rep.GasPremium, "feecap", rep.GasFeeCap, "prev-premium", pending.GasPremium, "prev-feecap", pending.GasFeeCap)
+	return nil
+}
+
+type ReplaceStuckAPI interface {
+	StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error)
+}
+
+// ReplaceStuckTask replaces sent messages which are not included on chain after Fees.ReplaceStuck.After with
+// messages paying a higher fee.
+type ReplaceStuckTask struct {
+	db     *harmonydb.DB
+	api    ReplaceStuckAPI
+	sender *Sender
+	cfg    config.MessageReplaceConfig
+}
+
+func NewReplaceStuckTask(db *harmonydb.DB, api ReplaceStuckAPI, sender *Sender, cfg config.MessageReplaceConfig) *ReplaceStuckTask {
+	return &ReplaceStuckTask{db: db, api: api, sender: sender, cfg: cfg}
+}
+
+func (r *ReplaceStuckTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var pending []struct {
+		FromKey    string `db:"from_key"`
+		Nonce      uint64 `db:"nonce"`
+		SendReason string `db:"send_reason"`
+		SignedData []byte `db:"signed_data"`
+	}
+	err = r.db.Select(ctx, &pending, `SELECT s.from_key, s.nonce, s.send_reason, s.signed_data FROM message_sends s
+		WHERE s.send_success = TRUE AND s.nonce IS NOT NULL AND s.signed_data IS NOT NULL
+		  AND s.send_time < NOW() - make_interval(secs => $1) AND s.send_time > NOW() - INTERVAL '7 days'
+		  AND NOT EXISTS (SELECT 1 FROM message_waits w
+		      WHERE w.signed_message_cid = COALESCE(s.replaces_signed_cid, s.held_cid, s.signed_cid) AND w.executed_tsk_epoch IS NOT NULL)
+		ORDER BY s.from_key, s.nonce`, time.Duration(r.cfg.After).Seconds())
+	if err != nil {
+		return false, xerrors.Errorf("getting pending messages: %w", err)
+	}
+
+	maxFees := map[string]abi.TokenAmount{}
+	for _, l := range r.cfg.ReasonMaxFees {
+		maxFees[l.Reason] = abi.TokenAmount(l.MaxFee)
+	}
+
+	chainNonces := map[string]uint64{}
+	for _, p := range pending {
+		if !stillOwned() {
+			return false, xerrors.Errorf("lost ownership of task")
+		}
+
+		chainNonce, ok := chainNonces[p.FromKey]
+		if !ok {
+			from, err := address.NewFromString(p.FromKey)
+			if err != nil {
+				return false, xerrors.Errorf("parsing sender address %s: %w", p.FromKey, err)
+			}
+			act, err := r.api.StateGetActor(ctx, from, types.EmptyTSK)
+			if err != nil {
+				return false, xerrors.Errorf("getting actor %s: %w", from, err)
+			}
+			chainNonce = act.Nonce
+			chainNonces[p.FromKey] = chainNonce
+		}
+		if p.Nonce < chainNonce {
+			// executed, the message wait didn't catch up yet or nobody waits for it
+			continue
+		}
+
+		sm, err := types.DecodeSignedMessage(p.SignedData)
+		if err != nil {
+			return false, xerrors.Errorf("decoding signed message %s/%d: %w", p.FromKey, p.Nonce, err)
+		}
+
+		maxFee, ok := maxFees[p.SendReason]
+		if !ok {
+			maxFee = sm.Message.RequiredFunds()
+		}
+
+		if err := r.sender.ReplaceByFee(ctx, &sm.Message, p.SendReason, maxFee); err != nil {
+			log.Warnw("replacing stuck message", "from", p.FromKey, "nonce", p.Nonce, "reason", p.SendReason, "error", err)
+		}
+	}
+
+	return true, nil
+}
+
+func (r *ReplaceStuckTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	return &ids[0], nil
+}
+
+func (r *ReplaceStuckTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "ReplaceStuckMsg",
+		Cost: resources.Resources{
+			Cpu: 0,
+			Gpu: 0,
+			Ram: 16 << 20,
+		},
+		IAmBored: harmonytask.SingletonTaskAdder(ReplaceStuckInterval, r),
+	}
+}
+
+func (r *ReplaceStuckTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ = harmonytask.Reg(&ReplaceStuckTask{})
+var _ harmonytask.TaskInterface = &ReplaceStuckTask{}
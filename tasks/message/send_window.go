@@ -0,0 +1,363 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build/buildconstants"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var SendWindowPollInterval = 30 * time.Second
+
+// NeverDeferred are send reasons of proving messages, which are never held back by send windows.
+var NeverDeferred = []string{"wdpost", "declare-recoveries"}
+
+// SendWindow holds messages sent for deferrable reasons until the base fee is low enough and the current time is
+// within the allowed hours, or until they waited for the maximum delay. Held messages are queued as deferred, their
+// send tasks run when the window opens.
+type SendWindow struct {
+	api GasOracleAPI
+
+	maxBaseFee abi.TokenAmount
+	hours      []dayRange
+	maxDelay   time.Duration
+
+	// base fee dips, the window opens when the base fee is at or below a percentile of recent base fees
+	percentile int
+	lookback   abi.ChainEpoch
+	history    *baseFeeHistory
+
+	lk        sync.Mutex
+	lastCheck time.Time
+	last      windowState
+}
+
+type windowState struct {
+	open    bool
+	why     string
+	baseFee abi.TokenAmount
+}
+
+// Deferral describes how long a message was held by a send window, and the base fees when it was queued and
+// released.
+type Deferral struct {
+	Delay           time.Duration
+	QueuedBaseFee   abi.TokenAmount
+	ReleasedBaseFee abi.TokenAmount
+}
+
+// Savings returns the base fee saved by deferring a message with the given gas limit, compared to sending it when
+// it was queued. Negative when the base fee rose while the message was held.
+func (d Deferral) Savings(gasLimit int64) abi.TokenAmount {
+	if d.QueuedBaseFee.Nil() || d.ReleasedBaseFee.Nil() {
+		return big.Zero()
+	}
+	return big.Mul(big.Sub(d.QueuedBaseFee, d.ReleasedBaseFee), big.NewInt(gasLimit))
+}
+
+type dayRange struct {
+	start, end int // minutes since midnight, UTC
+}
+
+func (r dayRange) contains(minute int) bool {
+	if r.start <= r.end {
+		return minute >= r.start && minute < r.end
+	}
+	// wraps around midnight
+	return minute >= r.start || minute < r.end
+}
+
+func parseDayRange(s string) (dayRange, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return dayRange{}, xerrors.Errorf("expected 'HH:MM-HH:MM', got '%s'", s)
+	}
+
+	parse := func(hm string) (int, error) {
+		t, err := time.Parse("15:04", strings.TrimSpace(hm))
+		if err != nil {
+			return 0, xerrors.Errorf("parsing time '%s': %w", hm, err)
+		}
+		return t.Hour()*60 + t.Minute(), nil
+	}
+
+	start, err := parse(from)
+	if err != nil {
+		return dayRange{}, err
+	}
+	end, err := parse(to)
+	if err != nil {
+		return dayRange{}, err
+	}
+	return dayRange{start: start, end: end}, nil
+}
+
+// NewSendWindows creates the send windows configured for send reasons.
+func NewSendWindows(api GasOracleAPI, cfg []config.SendWindowConfig) (map[string]*SendWindow, error) {
+	out := map[string]*SendWindow{}
+	for _, c := range cfg {
+		if c.MaxDelay <= 0 {
+			return nil, xerrors.Errorf("send window for %v needs a positive MaxDelay", c.Reasons)
+		}
+
+		if c.BaseFeePercentile < 0 || c.BaseFeePercentile > 100 {
+			return nil, xerrors.Errorf("send window base fee percentile must be in [0, 100], got %d", c.BaseFeePercentile)
+		}
+		if c.BaseFeePercentile > 0 && c.BaseFeeLookback <= 0 {
+			return nil, xerrors.Errorf("send window base fee percentile needs a positive BaseFeeLookback")
+		}
+
+		w := &SendWindow{
+			api:        api,
+			maxBaseFee: abi.TokenAmount(c.MaxBaseFee),
+			maxDelay:   time.Duration(c.MaxDelay),
+			percentile: c.BaseFeePercentile,
+			lookback:   abi.ChainEpoch(c.BaseFeeLookback),
+			history:    newBaseFeeHistory(api),
+		}
+		for _, h := range c.Hours {
+			r, err := parseDayRange(h)
+			if err != nil {
+				return nil, xerrors.Errorf("send window hours: %w", err)
+			}
+			w.hours = append(w.hours, r)
+		}
+
+		for _, reason := range c.Reasons {
+			for _, nd := range NeverDeferred {
+				if reason == nd {
+					return nil, xerrors.Errorf("messages sent for '%s' can't be deferred", reason)
+				}
+			}
+			if _, ok := out[reason]; ok {
+				return nil, xerrors.Errorf("send reason '%s' has more than one send window", reason)
+			}
+			out[reason] = w
+		}
+	}
+	return out, nil
+}
+
+// open returns whether messages can be sent now, why not when they can't, and the current base fee.
+func (w *SendWindow) open(ctx context.Context, now time.Time) (windowState, error) {
+	var baseFee abi.TokenAmount
+	if !w.maxBaseFee.NilOrZero() || w.percentile > 0 {
+		var head *types.TipSet
+		var fees []abi.TokenAmount
+		var err error
+		if w.percentile > 0 {
+			head, fees, err = w.history.fees(ctx, w.lookback)
+		} else {
+			head, err = w.api.ChainHead(ctx)
+		}
+		if err != nil {
+			return windowState{}, xerrors.Errorf("getting base fees: %w", err)
+		}
+		baseFee = head.Blocks()[0].ParentBaseFee
+
+		if !w.maxBaseFee.NilOrZero() && baseFee.GreaterThan(w.maxBaseFee) {
+			return windowState{why: fmt.Sprintf("base fee %s above %s", types.FIL(baseFee).Short(), types.FIL(w.maxBaseFee).Short()), baseFee: baseFee}, nil
+		}
+		if w.percentile > 0 {
+			if dip := percentileOf(fees, w.percentile); baseFee.GreaterThan(dip) {
+				return windowState{why: fmt.Sprintf("base fee %s above the %d percentile %s", types.FIL(baseFee).Short(), w.percentile, types.FIL(dip).Short()), baseFee: baseFee}, nil
+			}
+		}
+	}
+
+	if len(w.hours) > 0 {
+		now = now.UTC()
+		minute := now.Hour()*60 + now.Minute()
+
+		var inHours bool
+		for _, r := range w.hours {
+			if r.contains(minute) {
+				inHours = true
+				break
+			}
+		}
+		if !inHours {
+			return windowState{why: "outside of send hours", baseFee: baseFee}, nil
+		}
+	}
+
+	return windowState{open: true, baseFee: baseFee}, nil
+}
+
+// state returns the window state, checking it at most once per poll interval.
+func (w *SendWindow) state(ctx context.Context) (windowState, error) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if time.Since(w.lastCheck) >= SendWindowPollInterval {
+		st, err := w.open(ctx, time.Now())
+		if err != nil {
+			return windowState{}, err
+		}
+		if st.open && !w.last.open && !w.lastCheck.IsZero() {
+			log.Infow("send window opened, releasing deferred messages", "base-fee", types.FIL(st.baseFee).Short())
+		}
+		w.lastCheck, w.last = time.Now(), st
+	}
+	return w.last, nil
+}
+
+// holds returns whether a message queued at queuedAt is still held by the window, which is until the window opens
+// or the message waited for the maximum delay.
+func (w *SendWindow) holds(ctx context.Context, queuedAt time.Time) (bool, windowState, error) {
+	st, err := w.state(ctx)
+	if err != nil {
+		return false, windowState{}, err
+	}
+	return !st.open && time.Since(queuedAt) < w.maxDelay, st, nil
+}
+
+type deferralRow struct {
+	SendTaskID    int64     `db:"send_task_id"`
+	Reason        string    `db:"send_reason"`
+	QueuedAt      time.Time `db:"queued_at"`
+	QueuedBaseFee *string   `db:"queued_base_fee"`
+	MaxFee        *string   `db:"max_fee"`
+}
+
+// deferredTasks returns the send tasks among ids whose message is still held by its send window or spend cap.
+// Messages whose window or cap isn't configured on this node, or can't be checked, are not held.
+func (s *Sender) deferredTasks(ctx context.Context, ids []int64) (map[int64]bool, error) {
+	var rows []deferralRow
+	err := s.db.Select(ctx, &rows, `SELECT send_task_id, send_reason, queued_at FROM message_deferrals
+		WHERE send_task_id = ANY($1)`, ids)
+	if err != nil {
+		return nil, xerrors.Errorf("getting deferred messages: %w", err)
+	}
+
+	out := map[int64]bool{}
+	for _, r := range rows {
+		if w, ok := s.sendWindows[r.Reason]; ok {
+			held, _, err := w.holds(ctx, r.QueuedAt)
+			if err != nil {
+				log.Warnw("checking send window failed, sending", "reason", r.Reason, "error", err)
+			}
+			if held {
+				out[r.SendTaskID] = true
+				continue
+			}
+		}
+		if c, ok := s.spendCaps[r.Reason]; ok {
+			held, _, err := c.holds(ctx, r.Reason, s.alert)
+			if err != nil {
+				log.Warnw("checking spend cap failed, sending", "reason", r.Reason, "error", err)
+			}
+			if held {
+				out[r.SendTaskID] = true
+			}
+		}
+	}
+	return out, nil
+}
+
+// releaseDeferred prepares a message deferred by a send window or spend cap for sending, and records how long it was
+// held.
+// Unless estimate is false, e.g. for messages which already have a nonce, the gas of the message is estimated again
+// as the estimate from when it was queued is stale. Messages which weren't deferred are left as they are.
+func (s *Sender) releaseDeferred(ctx context.Context, taskID harmonytask.TaskID, fromKey string, msg *types.Message, estimate bool) error {
+	var rows []deferralRow
+	err := s.db.Select(ctx, &rows, `SELECT d.send_task_id, d.send_reason, d.queued_at,
+			d.queued_base_fee::TEXT AS queued_base_fee, s.max_fee::TEXT AS max_fee
+		FROM message_deferrals d
+		JOIN message_sends s ON s.send_task_id = d.send_task_id AND s.from_key = d.from_key
+		WHERE d.send_task_id = $1 AND d.from_key = $2`, taskID, fromKey)
+	if err != nil {
+		return xerrors.Errorf("getting message deferral: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	r := rows[0]
+
+	if estimate {
+		maxFee := big.Zero()
+		if r.MaxFee != nil {
+			maxFee, err = big.FromString(*r.MaxFee)
+			if err != nil {
+				return xerrors.Errorf("parsing max fee: %w", err)
+			}
+		}
+
+		est := *msg
+		est.GasLimit, est.GasFeeCap, est.GasPremium = 0, big.Zero(), big.Zero()
+		estMsg, err := s.estimate(ctx, &est, &api.MessageSendSpec{MaxFee: maxFee}, r.Reason)
+		if err != nil {
+			return xerrors.Errorf("estimating deferred message: %w", err)
+		}
+		*msg = *estMsg
+	}
+
+	unsBytes := new(bytes.Buffer)
+	if err := msg.MarshalCBOR(unsBytes); err != nil {
+		return xerrors.Errorf("marshaling message: %w", err)
+	}
+
+	_, err = s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`UPDATE message_sends SET unsigned_data = $1, unsigned_cid = $2 WHERE send_task_id = $3 AND from_key = $4`,
+			unsBytes.Bytes(), msg.Cid().String(), taskID, fromKey)
+		if err != nil {
+			return false, xerrors.Errorf("updating deferred message: %w", err)
+		}
+		_, err = tx.Exec(`DELETE FROM message_deferrals WHERE send_task_id = $1 AND from_key = $2`, taskID, fromKey)
+		if err != nil {
+			return false, xerrors.Errorf("deleting message deferral: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return err
+	}
+
+	d := Deferral{Delay: time.Since(r.QueuedAt)}
+	if r.QueuedBaseFee != nil {
+		d.QueuedBaseFee, err = big.FromString(*r.QueuedBaseFee)
+		if err != nil {
+			return xerrors.Errorf("parsing queued base fee: %w", err)
+		}
+	}
+	w, ok := s.sendWindows[r.Reason]
+	if !ok {
+		log.Infow("paused message released", "reason", r.Reason, "paused", d.Delay.Round(time.Second))
+		return nil
+	}
+	if st, err := w.state(ctx); err == nil {
+		d.ReleasedBaseFee = st.baseFee
+	}
+	recordDeferral(r.Reason, &d, msg.GasLimit)
+	return nil
+}
+
+// recordDeferral records the metrics of a message released by a send window.
+func recordDeferral(reason string, d *Deferral, gasLimit int64) {
+	savings := d.Savings(gasLimit)
+	savingsFil := types.BigDivFloat(savings, types.NewInt(buildconstants.FilecoinPrecision))
+
+	log.Infow("deferred message released", "reason", reason, "deferred", d.Delay.Round(time.Second), "base-fee-savings", types.FIL(savings).Short())
+
+	_ = stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(reasonTag, reason)},
+		SendWindowMeasures.Deferred.M(1),
+		SendWindowMeasures.DeferredTime.M(d.Delay.Seconds()),
+		SendWindowMeasures.BaseFeeSavings.M(savingsFil))
+}
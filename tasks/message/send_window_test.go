@@ -0,0 +1,195 @@
+package message
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/deps/config"
+)
+
+func TestParseDayRange(t *testing.T) {
+	cases := []struct {
+		in   string
+		want dayRange
+		err  bool
+	}{
+		{"08:00-17:30", dayRange{start: 480, end: 1050}, false},
+		{" 22:00 - 06:00 ", dayRange{start: 1320, end: 360}, false},
+		{"00:00-23:59", dayRange{start: 0, end: 1439}, false},
+		{"08:00", dayRange{}, true},
+		{"8am-5pm", dayRange{}, true},
+		{"25:00-26:00", dayRange{}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			r, err := parseDayRange(c.in)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.want, r)
+		})
+	}
+}
+
+func TestDayRangeContains(t *testing.T) {
+	day := dayRange{start: 480, end: 1050}
+	night := dayRange{start: 1320, end: 360}
+
+	cases := []struct {
+		r      dayRange
+		minute int
+		want   bool
+	}{
+		{day, 480, true},
+		{day, 1049, true},
+		{day, 1050, false},
+		{day, 0, false},
+		{night, 1320, true},
+		{night, 1439, true},
+		{night, 0, true},
+		{night, 359, true},
+		{night, 360, false},
+		{night, 720, false},
+		{dayRange{start: 480, end: 480}, 480, false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.want, c.r.contains(c.minute), "%v contains %d", c.r, c.minute)
+	}
+}
+
+func TestNewSendWindows(t *testing.T) {
+	hour := config.Duration(time.Hour)
+
+	cases := []struct {
+		name string
+		cfg  config.SendWindowConfig
+		err  string
+	}{
+		{"hours", config.SendWindowConfig{Reasons: []string{"precommit"}, Hours: []string{"22:00-06:00"}, MaxDelay: hour}, ""},
+		{"base fee dips", config.SendWindowConfig{Reasons: []string{"precommit"}, BaseFeePercentile: 30, BaseFeeLookback: 100, MaxDelay: hour}, ""},
+		{"no max delay", config.SendWindowConfig{Reasons: []string{"precommit"}}, "needs a positive MaxDelay"},
+		{"negative percentile", config.SendWindowConfig{BaseFeePercentile: -1, BaseFeeLookback: 100, MaxDelay: hour}, "percentile must be in [0, 100]"},
+		{"percentile above 100", config.SendWindowConfig{BaseFeePercentile: 101, BaseFeeLookback: 100, MaxDelay: hour}, "percentile must be in [0, 100]"},
+		{"percentile without lookback", config.SendWindowConfig{BaseFeePercentile: 30, MaxDelay: hour}, "needs a positive BaseFeeLookback"},
+		{"bad hours", config.SendWindowConfig{Hours: []string{"night"}, MaxDelay: hour}, "send window hours"},
+		{"proving messages", config.SendWindowConfig{Reasons: []string{"wdpost"}, MaxDelay: hour}, "'wdpost' can't be deferred"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewSendWindows(newTestChain(t, 1), []config.SendWindowConfig{c.cfg})
+			if c.err != "" {
+				require.ErrorContains(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+
+	windows, err := NewSendWindows(newTestChain(t, 1), []config.SendWindowConfig{
+		{Reasons: []string{"precommit", "commit"}, MaxDelay: hour},
+		{Reasons: []string{"precommit"}, MaxDelay: hour},
+	})
+	require.ErrorContains(t, err, "send reason 'precommit' has more than one send window")
+	require.Nil(t, windows)
+}
+
+func TestSendWindowOpen(t *testing.T) {
+	// base fees 70, 80, 90, 100 in the last 4 epochs, 100 at the head
+	chain := newTestChain(t, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100)
+	noon := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		cfg     config.SendWindowConfig
+		now     time.Time
+		why     string // empty when open
+		baseFee int64  // 0 when not checked
+	}{
+		{"always open", config.SendWindowConfig{}, noon, "", 0},
+		{"in hours", config.SendWindowConfig{Hours: []string{"08:00-17:00"}}, noon, "", 0},
+		{"out of hours", config.SendWindowConfig{Hours: []string{"08:00-17:00"}}, noon.Add(6 * time.Hour), "outside of send hours", 0},
+		{"in any of the hours", config.SendWindowConfig{Hours: []string{"08:00-09:00", "22:00-02:00"}}, noon.Add(13 * time.Hour), "", 0},
+		{"hours in UTC", config.SendWindowConfig{Hours: []string{"11:00-13:00"}}, noon.In(time.FixedZone("UTC+5", 5*3600)), "", 0},
+		{"base fee at max", config.SendWindowConfig{MaxBaseFee: fil(100)}, noon, "", 100},
+		{"base fee above max", config.SendWindowConfig{MaxBaseFee: fil(99)}, noon, "base fee", 100},
+		{"base fee at percentile", config.SendWindowConfig{BaseFeePercentile: 100, BaseFeeLookback: 4}, noon, "", 100},
+		{"base fee above percentile", config.SendWindowConfig{BaseFeePercentile: 50, BaseFeeLookback: 4}, noon, "above the 50 percentile", 100},
+		{"base fee ok out of hours", config.SendWindowConfig{MaxBaseFee: fil(100), Hours: []string{"08:00-09:00"}}, noon, "outside of send hours", 100},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.cfg.Reasons = []string{"precommit"}
+			c.cfg.MaxDelay = config.Duration(time.Hour)
+			windows, err := NewSendWindows(chain, []config.SendWindowConfig{c.cfg})
+			require.NoError(t, err)
+
+			st, err := windows["precommit"].open(context.Background(), c.now)
+			require.NoError(t, err)
+			require.Equal(t, c.why == "", st.open)
+			if c.why != "" {
+				require.Contains(t, st.why, c.why)
+			}
+			if c.baseFee == 0 {
+				require.True(t, st.baseFee.Nil())
+			} else {
+				require.Equal(t, big.NewInt(c.baseFee), st.baseFee)
+			}
+		})
+	}
+}
+
+func TestSendWindowHolds(t *testing.T) {
+	ctx := context.Background()
+	chain := newTestChain(t, 100)
+
+	windows, err := NewSendWindows(chain, []config.SendWindowConfig{
+		{Reasons: []string{"open"}, MaxBaseFee: fil(100), MaxDelay: config.Duration(time.Hour)},
+		{Reasons: []string{"closed"}, MaxBaseFee: fil(50), MaxDelay: config.Duration(time.Hour)},
+	})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		reason   string
+		queuedAt time.Time
+		held     bool
+	}{
+		{"open window", "open", time.Now(), false},
+		{"closed window", "closed", time.Now(), true},
+		{"closed window, waited for the maximum delay", "closed", time.Now().Add(-time.Hour), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			held, st, err := windows[c.reason].holds(ctx, c.queuedAt)
+			require.NoError(t, err)
+			require.Equal(t, c.held, held)
+			require.Equal(t, big.NewInt(100), st.baseFee)
+		})
+	}
+}
+
+func TestDeferralSavings(t *testing.T) {
+	cases := []struct {
+		name     string
+		d        Deferral
+		gasLimit int64
+		want     int64
+	}{
+		{"not held", Deferral{}, 1000, 0},
+		{"no released fee", Deferral{QueuedBaseFee: big.NewInt(100)}, 1000, 0},
+		{"fee dropped", Deferral{QueuedBaseFee: big.NewInt(100), ReleasedBaseFee: big.NewInt(70)}, 10, 300},
+		{"fee rose", Deferral{QueuedBaseFee: big.NewInt(70), ReleasedBaseFee: big.NewInt(100)}, 10, -300},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, big.NewInt(c.want), c.d.Savings(c.gasLimit))
+		})
+	}
+}
@@ -8,10 +8,12 @@ import (
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
+	"github.com/samber/lo"
 	"go.uber.org/multierr"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 
 	"github.com/filecoin-project/curio/harmony/harmonydb"
@@ -30,10 +32,12 @@ var SendLockedWait = 100 * time.Millisecond
 
 type SenderAPI interface {
 	StateAccountKey(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error)
+	StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error)
 	GasEstimateMessageGas(ctx context.Context, msg *types.Message, spec *api.MessageSendSpec, tsk types.TipSetKey) (*types.Message, error)
 	WalletBalance(ctx context.Context, addr address.Address) (big.Int, error)
 	MpoolGetNonce(context.Context, address.Address) (uint64, error)
 	MpoolPush(context.Context, *types.SignedMessage) (cid.Cid, error)
+	StateCall(context.Context, *types.Message, types.TipSetKey) (*api.InvocResult, error)
 }
 
 type SignerAPI interface {
@@ -50,6 +54,14 @@ type Sender struct {
 	sendTask *SendTask
 
 	db *harmonydb.DB
+
+	gasOracles  map[string]GasOracle
+	sendWindows map[string]*SendWindow
+	spendCaps   map[string]*SpendCap
+	alert       Alerter
+
+	offline map[address.Address]bool
+	dryRun  *DryRun
 }
 
 type SendTask struct {
@@ -59,6 +71,9 @@ type SendTask struct {
 	signer SignerAPI
 
 	db *harmonydb.DB
+
+	// sender checks and estimates deferred messages again when they are released
+	sender *Sender
 }
 
 func (s *SendTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
@@ -79,10 +94,10 @@ func (s *SendTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done b
 	}
 
 	err = s.db.QueryRow(ctx, `
-		SELECT from_key, nonce, to_addr, unsigned_data, unsigned_cid 
+		SELECT from_key, nonce, to_addr, unsigned_data, unsigned_cid, signed_data 
 		FROM message_sends 
 		WHERE send_task_id = $1`, taskID).Scan(
-		&dbMsg.FromKey, &dbMsg.Nonce, &dbMsg.ToAddr, &dbMsg.UnsignedData, &dbMsg.UnsignedCid)
+		&dbMsg.FromKey, &dbMsg.Nonce, &dbMsg.ToAddr, &dbMsg.UnsignedData, &dbMsg.UnsignedCid, &dbMsg.SignedData)
 	if err != nil {
 		return false, xerrors.Errorf("getting message from db: %w", err)
 	}
@@ -134,28 +149,47 @@ func (s *SendTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done b
 		}
 	}()
 
+	// messages signed offline are only sent once the signature is imported
+	pending, err := awaitingOfflineSignature(ctx, s.db, []int64{int64(taskID)})
+	if err != nil {
+		return false, err
+	}
+	if pending[int64(taskID)] {
+		return false, xerrors.Errorf("message is awaiting offline signature")
+	}
+
+	// deferred messages are estimated again before they get a nonce
+	if err := s.sender.releaseDeferred(ctx, taskID, dbMsg.FromKey, &msg, dbMsg.Nonce == nil); err != nil {
+		return false, xerrors.Errorf("releasing deferred message: %w", err)
+	}
+
 	// assign nonce IF NOT ASSIGNED (max(api.MpoolGetNonce, db nonce+1))
 	var sigMsg *types.SignedMessage
 
-	if dbMsg.Nonce == nil {
-		msgNonce, err := s.api.MpoolGetNonce(ctx, msg.From)
-		if err != nil {
-			return false, xerrors.Errorf("getting nonce from mpool: %w", err)
-		}
+	if dbMsg.Nonce == nil || dbMsg.SignedData == nil {
+		if dbMsg.Nonce == nil {
+			msgNonce, err := s.api.MpoolGetNonce(ctx, msg.From)
+			if err != nil {
+				return false, xerrors.Errorf("getting nonce from mpool: %w", err)
+			}
 
-		// get nonce from db
-		var dbNonce *uint64
-		r := s.db.QueryRow(ctx, `
-			SELECT MAX(nonce) FROM message_sends WHERE from_key = $1 AND send_success = true`, msg.From.String())
-		if err := r.Scan(&dbNonce); err != nil {
-			return false, xerrors.Errorf("getting nonce from db: %w", err)
-		}
+			// get nonce from db
+			var dbNonce *uint64
+			r := s.db.QueryRow(ctx, `
+				SELECT MAX(nonce) FROM message_sends WHERE from_key = $1 AND send_success = true`, msg.From.String())
+			if err := r.Scan(&dbNonce); err != nil {
+				return false, xerrors.Errorf("getting nonce from db: %w", err)
+			}
 
-		if dbNonce != nil && *dbNonce+1 > msgNonce {
-			msgNonce = *dbNonce + 1
-		}
+			if dbNonce != nil && *dbNonce+1 > msgNonce {
+				msgNonce = *dbNonce + 1
+			}
 
-		msg.Nonce = msgNonce
+			msg.Nonce = msgNonce
+		} else {
+			// the nonce was assigned when the message was queued, e.g. for a replacement
+			msg.Nonce = *dbMsg.Nonce
+		}
 
 		// sign message
 		sigMsg, err = s.signer.WalletSignMessage(ctx, msg.From, &msg)
@@ -232,7 +266,27 @@ func (s *SendTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskE
 		return nil, nil
 	}
 
-	return &ids[0], nil
+	tids := make([]int64, len(ids))
+	for i, id := range ids {
+		tids[i] = int64(id)
+	}
+	ctx := context.Background()
+
+	pending, err := awaitingOfflineSignature(ctx, s.db, tids)
+	if err != nil {
+		return nil, err
+	}
+	deferred, err := s.sender.deferredTasks(ctx, tids)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if !pending[int64(id)] && !deferred[int64(id)] {
+			return &id, nil
+		}
+	}
+
+	return nil, nil
 }
 
 func (s *SendTask) TypeDetails() harmonytask.TaskTypeDetails {
@@ -270,12 +324,71 @@ func NewSender(api SenderAPI, signer SignerAPI, db *harmonydb.DB) (*Sender, *Sen
 		db:     db,
 	}
 
-	return &Sender{
+	s := &Sender{
 		api: api,
 		db:  db,
 
 		sendTask: st,
-	}, st
+	}
+	st.sender = s
+
+	return s, st
+}
+
+// SetGasOracles sets gas oracles which price messages by send reason, "*" applies to all other reasons. Messages
+// without an oracle use the chain node estimate.
+func (s *Sender) SetGasOracles(oracles map[string]GasOracle) {
+	s.gasOracles = oracles
+}
+
+// SetSendWindows sets send windows which defer messages by send reason until sending is allowed. Send returns
+// deferred messages once they are queued, without waiting for them to be sent.
+func (s *Sender) SetSendWindows(windows map[string]*SendWindow) {
+	s.sendWindows = windows
+}
+
+// SetSpendCaps sets spend caps which pause messages by send reason while too much was spent on gas. Paused messages
+// are queued as deferred, like messages held by send windows. Reached caps are reported to alert.
+func (s *Sender) SetSpendCaps(caps map[string]*SpendCap, alert Alerter) {
+	s.spendCaps = caps
+	s.alert = alert
+}
+
+// SetOfflineSigners sets the key addresses whose messages are signed offline, see ExportOfflineMessages. Send returns
+// their messages once they are queued, without waiting for the signature.
+func (s *Sender) SetOfflineSigners(addrs map[address.Address]bool) {
+	s.offline = addrs
+}
+
+// priceMessage applies the gas oracle of the send reason to a chain node estimated message.
+func (s *Sender) priceMessage(ctx context.Context, msg *types.Message, reason string, maxFee abi.TokenAmount) {
+	o, ok := s.gasOracles[reason]
+	if !ok {
+		o, ok = s.gasOracles["*"]
+	}
+	if !ok {
+		return
+	}
+
+	priced := *msg
+	if err := o.Price(ctx, &priced); err != nil {
+		log.Warnw("gas oracle failed, using chain node estimate", "reason", reason, "error", err)
+		return
+	}
+	capGasFee(&priced, maxFee)
+
+	msg.GasFeeCap, msg.GasPremium = priced.GasFeeCap, priced.GasPremium
+}
+
+// estimate estimates the gas of msg, bounded by mss.MaxFee, and prices it with the gas oracle of the send reason.
+func (s *Sender) estimate(ctx context.Context, msg *types.Message, mss *api.MessageSendSpec, reason string) (*types.Message, error) {
+	msg, err := s.api.GasEstimateMessageGas(ctx, msg, mss, types.EmptyTSK)
+	if err != nil {
+		return nil, xerrors.Errorf("GasEstimateMessageGas error: %w", err)
+	}
+
+	s.priceMessage(ctx, msg, reason, mss.MaxFee)
+	return msg, nil
 }
 
 // Send atomically assigns a nonce, signs, and pushes a message
@@ -285,6 +398,9 @@ func NewSender(api SenderAPI, signer SignerAPI, db *harmonydb.DB) (*Sender, *Sen
 // When maxFee is set to 0, Send will guess appropriate fee
 // based on current chain conditions
 //
+// Messages deferred by a send window or spend cap, or signed offline, are queued and Send returns right away, with the CID of the unsigned message
+// in place of the signed one. Message waits keyed by that CID resolve once the message is sent and executed.
+//
 // Send behaves much like fullnodeApi.MpoolPushMessage, but it coordinates
 // through HarmonyDB, making it safe to broadcast messages from multiple independent
 // API nodes
@@ -309,9 +425,17 @@ func (s *Sender) Send(ctx context.Context, msg *types.Message, mss *api.MessageS
 		return cid.Undef, xerrors.Errorf("Send expects message nonce to be 0, was %d", msg.Nonce)
 	}
 
-	msg, err = s.api.GasEstimateMessageGas(ctx, msg, mss, types.EmptyTSK)
+	// dry-run messages are estimated as usual, but captured instead of queued
+	dryRun, dryRunSP := s.dryRunOf(ctx, msg)
+
+	// don't pay for messages which are known to fail
+	if err := s.simulate(ctx, msg); err != nil {
+		return cid.Undef, err
+	}
+
+	msg, err = s.estimate(ctx, msg, mss, reason)
 	if err != nil {
-		return cid.Undef, xerrors.Errorf("GasEstimateMessageGas error: %w", err)
+		return cid.Undef, err
 	}
 
 	b, err := s.api.WalletBalance(ctx, msg.From)
@@ -324,6 +448,32 @@ func (s *Sender) Send(ctx context.Context, msg *types.Message, mss *api.MessageS
 		return cid.Undef, xerrors.Errorf("mpool push: not enough funds: %s < %s", b, requiredFunds)
 	}
 
+	if dryRun {
+		return s.captureDryRun(ctx, msg, reason, dryRunSP)
+	}
+
+	// messages sent while their send window is closed or their spend cap is reached are queued as deferred, the send
+	// task runs once they are released
+	var deferral *windowState
+	if w, ok := s.sendWindows[reason]; ok {
+		st, err := w.state(ctx)
+		if err != nil {
+			log.Warnw("checking send window failed, sending", "reason", reason, "error", err)
+		} else if !st.open {
+			deferral = &st
+		}
+	}
+	if c, ok := s.spendCaps[reason]; ok {
+		held, over, err := c.holds(ctx, reason, s.alert)
+		if err != nil {
+			log.Warnw("checking spend cap failed, sending", "reason", reason, "error", err)
+		} else if held && deferral == nil {
+			deferral = &windowState{why: over}
+		} else if held {
+			deferral.why += ", " + over
+		}
+	}
+
 	// push the task
 	taskAdder := s.sendTask.sendTF.Val(ctx)
 
@@ -333,14 +483,45 @@ func (s *Sender) Send(ctx context.Context, msg *types.Message, mss *api.MessageS
 		return cid.Undef, xerrors.Errorf("marshaling message: %w", err)
 	}
 
+	// held messages are estimated again when they're released or exported, within the same max fee
+	held := deferral != nil || s.offline[msg.From]
+	var heldCid, maxFee *string
+	if held {
+		heldCid = lo.ToPtr(msg.Cid().String())
+		maxFee = lo.ToPtr("0")
+		if !mss.MaxFee.Nil() {
+			maxFee = lo.ToPtr(mss.MaxFee.String())
+		}
+	}
+
 	var sendTaskID *harmonytask.TaskID
 	taskAdder(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
-		_, err := tx.Exec(`insert into message_sends (from_key, to_addr, send_reason, unsigned_data, unsigned_cid, send_task_id) values ($1, $2, $3, $4, $5, $6)`,
-			msg.From.String(), msg.To.String(), reason, unsBytes.Bytes(), msg.Cid().String(), id)
+		_, err := tx.Exec(`insert into message_sends (from_key, to_addr, send_reason, unsigned_data, unsigned_cid, send_task_id, held_cid, max_fee)
+			values ($1, $2, $3, $4, $5, $6, $7, $8::NUMERIC)`,
+			msg.From.String(), msg.To.String(), reason, unsBytes.Bytes(), msg.Cid().String(), id, heldCid, maxFee)
 		if err != nil {
 			return false, xerrors.Errorf("inserting message into db: %w", err)
 		}
 
+		if deferral != nil {
+			var queuedBaseFee *string
+			if !deferral.baseFee.Nil() {
+				queuedBaseFee = lo.ToPtr(deferral.baseFee.String())
+			}
+			_, err = tx.Exec(`insert into message_deferrals (send_task_id, from_key, send_reason, queued_base_fee) values ($1, $2, $3, $4::NUMERIC)`,
+				id, msg.From.String(), reason, queuedBaseFee)
+			if err != nil {
+				return false, xerrors.Errorf("deferring message: %w", err)
+			}
+		}
+
+		if s.offline[msg.From] {
+			_, err = tx.Exec(`insert into message_offline_signs (send_task_id, from_key) values ($1, $2)`, id, msg.From.String())
+			if err != nil {
+				return false, xerrors.Errorf("queueing message for offline signing: %w", err)
+			}
+		}
+
 		sendTaskID = &id
 
 		return true, nil
@@ -350,6 +531,16 @@ func (s *Sender) Send(ctx context.Context, msg *types.Message, mss *api.MessageS
 		return cid.Undef, xerrors.Errorf("failed to add task")
 	}
 
+	if deferral != nil {
+		log.Infow("deferring message", "task_id", *sendTaskID, "reason", reason, "why", deferral.why, "cid", *heldCid)
+	}
+	if s.offline[msg.From] {
+		log.Infow("message awaiting offline signature, export it with 'curio offline-sign export'", "task_id", *sendTaskID, "from", msg.From, "reason", reason, "cid", *heldCid)
+	}
+	if held {
+		return msg.Cid(), nil
+	}
+
 	// wait for exec
 	var (
 		pollInterval    = 50 * time.Millisecond
@@ -0,0 +1,93 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// SimulationError is returned by Send when a message fails when executed against the current chain state. Nothing
+// is queued for such messages, so no gas is paid for them.
+type SimulationError struct {
+	ExitCode exitcode.ExitCode
+	// Reason is the decoded EVM revert reason, or the error reported by the VM
+	Reason string
+}
+
+func (e *SimulationError) Error() string {
+	return fmt.Sprintf("message would fail with exit code %s: %s", e.ExitCode, e.Reason)
+}
+
+// simulate executes msg on top of the chain head, and returns a *SimulationError when it fails.
+func (s *Sender) simulate(ctx context.Context, msg *types.Message) error {
+	sim := *msg
+	res, err := s.api.StateCall(ctx, &sim, types.EmptyTSK)
+	if err != nil {
+		return xerrors.Errorf("simulating message: %w", err)
+	}
+	if res.MsgRct == nil {
+		return xerrors.Errorf("simulating message: no receipt")
+	}
+	if res.MsgRct.ExitCode.IsSuccess() {
+		return nil
+	}
+
+	return &SimulationError{
+		ExitCode: res.MsgRct.ExitCode,
+		Reason:   revertReason(res),
+	}
+}
+
+func revertReason(res *api.InvocResult) string {
+	if r := evmRevertReason(res.MsgRct.Return); r != "" {
+		return r
+	}
+	if res.Error != "" {
+		return res.Error
+	}
+	return "no revert reason"
+}
+
+// evmErrorSelector is the selector of the Solidity Error(string) revert.
+var evmErrorSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+
+// evmRevertReason decodes an Error(string) revert returned by an EVM actor, or returns an empty string.
+func evmRevertReason(ret []byte) string {
+	// EVM actors return the revert data as a CBOR byte string
+	data, err := cbg.ReadByteArray(bytes.NewReader(ret), uint64(len(ret)))
+	if err != nil {
+		return ""
+	}
+
+	// selector, offset of the string, string length, string data
+	if len(data) < 4+32+32 || !bytes.Equal(data[:4], evmErrorSelector) {
+		return ""
+	}
+	args := data[4:]
+	if !isZero(args[32:56]) {
+		return ""
+	}
+	l := binary.BigEndian.Uint64(args[56:64])
+	if l > uint64(len(args)-64) {
+		return ""
+	}
+	return string(args[64 : 64+l])
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var SpendCapPollInterval = time.Minute
+
+// SpendCapAlertInterval is the minimum time between alerts about a reached spend cap.
+var SpendCapAlertInterval = time.Hour
+
+// Alerter raises alerts, see alertmanager.AlertNow.
+type Alerter interface {
+	AddAlert(msg string)
+}
+
+// SpendCap limits the gas spent on messages sent for a group of reasons over the last day and week.
+type SpendCap struct {
+	db *harmonydb.DB
+
+	reasons       []string
+	daily, weekly abi.TokenAmount
+
+	lk        sync.Mutex
+	lastAlert time.Time
+	lastCheck time.Time
+	over      string // reached cap as of lastCheck, empty when under the caps
+}
+
+// NewSpendCaps creates the spend caps configured for send reasons.
+func NewSpendCaps(db *harmonydb.DB, cfg []config.SpendCapConfig) (map[string]*SpendCap, error) {
+	out := map[string]*SpendCap{}
+	for _, c := range cfg {
+		sc := &SpendCap{
+			db:      db,
+			reasons: c.Reasons,
+			daily:   abi.TokenAmount(c.Daily),
+			weekly:  abi.TokenAmount(c.Weekly),
+		}
+		if sc.daily.NilOrZero() && sc.weekly.NilOrZero() {
+			return nil, xerrors.Errorf("spend cap for %v needs a daily or weekly cap", c.Reasons)
+		}
+
+		for _, reason := range c.Reasons {
+			if _, ok := out[reason]; ok {
+				return nil, xerrors.Errorf("send reason '%s' has more than one spend cap", reason)
+			}
+			out[reason] = sc
+		}
+	}
+	return out, nil
+}
+
+// SpentSince returns the gas spent on executed messages sent for reasons since the given time.
+func SpentSince(ctx context.Context, db *harmonydb.DB, reasons []string, since time.Time) (abi.TokenAmount, error) {
+	var spent []struct {
+		Spent string `db:"spent"`
+	}
+	err := db.Select(ctx, &spent, `SELECT COALESCE(SUM(w.executed_gas_cost), 0)::TEXT AS spent FROM message_waits w
+		JOIN message_sends s ON COALESCE(s.held_cid, s.signed_cid) = w.signed_message_cid
+		WHERE s.send_reason = ANY($1) AND w.executed_at > $2`, reasons, since)
+	if err != nil {
+		return big.Zero(), xerrors.Errorf("getting spend: %w", err)
+	}
+	if len(spent) == 0 {
+		return big.Zero(), nil
+	}
+	return big.FromString(spent[0].Spent)
+}
+
+// exceeded returns a description of the reached cap, or an empty string when the spend is under the caps.
+func (c *SpendCap) exceeded(ctx context.Context) (string, error) {
+	check := func(limit abi.TokenAmount, window time.Duration, name string) (string, error) {
+		if limit.NilOrZero() {
+			return "", nil
+		}
+		spent, err := SpentSince(ctx, c.db, c.reasons, time.Now().Add(-window))
+		if err != nil {
+			return "", err
+		}
+		if spent.GreaterThanEqual(limit) {
+			return fmt.Sprintf("spent %s in the last %s, cap %s", types.FIL(spent).Short(), name, types.FIL(limit).Short()), nil
+		}
+		return "", nil
+	}
+
+	if over, err := check(c.daily, 24*time.Hour, "day"); over != "" || err != nil {
+		return over, err
+	}
+	return check(c.weekly, 7*24*time.Hour, "week")
+}
+
+func (c *SpendCap) alert(alert Alerter, over string) {
+	if alert == nil {
+		return
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if time.Since(c.lastAlert) < SpendCapAlertInterval {
+		return
+	}
+	c.lastAlert = time.Now()
+
+	alert.AddAlert(fmt.Sprintf("Spend cap for messages sent for %s reached: %s. Messages for these reasons are paused, except for proving messages.",
+		strings.Join(c.reasons, ", "), over))
+}
+
+// holds returns whether messages sent for reason are paused by the cap, and the reached cap. The spend is checked
+// at most once per poll interval. Proving messages are never held, reaching the cap only alerts.
+func (c *SpendCap) holds(ctx context.Context, reason string, alert Alerter) (bool, string, error) {
+	c.lk.Lock()
+	if time.Since(c.lastCheck) >= SpendCapPollInterval {
+		over, err := c.exceeded(ctx)
+		if err != nil {
+			c.lk.Unlock()
+			return false, "", err
+		}
+		if over == "" && c.over != "" {
+			log.Infow("spend under cap, releasing paused messages", "reasons", c.reasons)
+		}
+		c.lastCheck, c.over = time.Now(), over
+	}
+	over := c.over
+	c.lk.Unlock()
+
+	if over == "" {
+		return false, "", nil
+	}
+
+	c.alert(alert, over)
+
+	if lo.Contains(NeverDeferred, reason) {
+		log.Warnw("spend cap reached, sending proving message anyway", "reason", reason, "over", over)
+		return false, over, nil
+	}
+	return true, over, nil
+}
+
+const (
+	gasOveruseNum   = 11
+	gasOveruseDenom = 10
+)
+
+// gasCost is the amount paid by the sender of an executed message for gas: the base fee burn, over-estimation burn
+// and miner tip, computed like lotus vm.ComputeGasOutputs. baseFee is the base fee of the tipset including the
+// message.
+func gasCost(msg *types.Message, gasUsed int64, baseFee abi.TokenAmount) abi.TokenAmount {
+	baseFeeToPay := big.Min(baseFee, msg.GasFeeCap)
+	tip := big.Min(msg.GasPremium, big.Sub(msg.GasFeeCap, baseFeeToPay))
+
+	var gasToBurn int64
+	if gasUsed == 0 {
+		gasToBurn = msg.GasLimit
+	} else if over := msg.GasLimit - (gasOveruseNum*gasUsed)/gasOveruseDenom; over > 0 {
+		if over > gasUsed {
+			over = gasUsed
+		}
+		gasToBurn = big.Div(big.Mul(big.NewInt(msg.GasLimit-gasUsed), big.NewInt(over)), big.NewInt(gasUsed)).Int64()
+	}
+
+	cost := big.Mul(baseFeeToPay, big.NewInt(gasUsed+gasToBurn))
+	return big.Add(cost, big.Mul(tip, big.NewInt(msg.GasLimit)))
+}
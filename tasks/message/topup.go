@@ -0,0 +1,318 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const WalletTopUpInterval = 10 * time.Minute
+
+type WalletTopUpAPI interface {
+	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
+	StateAccountKey(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+	WalletBalance(context.Context, address.Address) (types.BigInt, error)
+}
+
+// topUpGroup is a top-up source with the miners whose addresses it funds.
+type topUpGroup struct {
+	source address.Address
+	miners []address.Address
+	extra  []address.Address // configured control addresses
+
+	below, target, maxTransfer, maxDaily abi.TokenAmount
+}
+
+// WalletTopUpTask sends funds from source wallets to worker and control addresses whose balance fell below a
+// threshold, within per-transfer and per-day limits. Every transfer is recorded in wallet_topups.
+type WalletTopUpTask struct {
+	db     *harmonydb.DB
+	api    WalletTopUpAPI
+	sender *Sender
+
+	groups []topUpGroup
+}
+
+// NewWalletTopUpTask creates the top-up task for address groups with a top-up source, nil if there are none.
+func NewWalletTopUpTask(db *harmonydb.DB, api WalletTopUpAPI, sender *Sender, addrs []config.CurioAddresses) (*WalletTopUpTask, error) {
+	t := &WalletTopUpTask{db: db, api: api, sender: sender}
+
+	for _, a := range addrs {
+		c := a.TopUp
+		if c.Source == "" {
+			continue
+		}
+
+		g := topUpGroup{
+			below:       abi.TokenAmount(c.Below),
+			target:      abi.TokenAmount(c.Target),
+			maxTransfer: abi.TokenAmount(c.MaxTransfer),
+			maxDaily:    abi.TokenAmount(c.MaxDaily),
+		}
+		var err error
+		if g.source, err = address.NewFromString(c.Source); err != nil {
+			return nil, xerrors.Errorf("parsing top-up source %s: %w", c.Source, err)
+		}
+		for _, l := range []abi.TokenAmount{g.target, g.maxTransfer, g.maxDaily} {
+			if l.NilOrZero() {
+				return nil, xerrors.Errorf("top-up from %s needs Target, MaxTransfer and MaxDaily", c.Source)
+			}
+		}
+		if !g.below.Nil() && g.target.LessThan(g.below) {
+			return nil, xerrors.Errorf("top-up Target of %s is below the Below threshold", c.Source)
+		}
+
+		for _, s := range a.MinerAddresses {
+			m, err := address.NewFromString(s)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing miner address %s: %w", s, err)
+			}
+			g.miners = append(g.miners, m)
+		}
+		for _, ctl := range [][]string{a.PreCommitControl, a.CommitControl, a.TerminateControl} {
+			for _, s := range ctl {
+				ca, err := address.NewFromString(s)
+				if err != nil {
+					return nil, xerrors.Errorf("parsing control address %s: %w", s, err)
+				}
+				g.extra = append(g.extra, ca)
+			}
+		}
+
+		t.groups = append(t.groups, g)
+	}
+
+	if len(t.groups) == 0 {
+		return nil, nil
+	}
+	return t, nil
+}
+
+func (t *WalletTopUpTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	thresholds, err := t.thresholds(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, g := range t.groups {
+		if !stillOwned() {
+			return false, xerrors.Errorf("lost ownership of task")
+		}
+		if err := t.topUpGroup(ctx, g, thresholds); err != nil {
+			log.Errorw("topping up addresses", "source", g.source, "error", err)
+		}
+	}
+
+	return true, nil
+}
+
+// thresholds returns the per-address low balance thresholds set in the web UI, by key address.
+func (t *WalletTopUpTask) thresholds(ctx context.Context) (map[address.Address]abi.TokenAmount, error) {
+	var rows []struct {
+		Address    string `db:"address"`
+		MinBalance string `db:"min_balance"`
+	}
+	if err := t.db.Select(ctx, &rows, `SELECT address, min_balance::TEXT AS min_balance FROM wallet_balance_thresholds`); err != nil {
+		return nil, xerrors.Errorf("getting balance thresholds: %w", err)
+	}
+
+	out := map[address.Address]abi.TokenAmount{}
+	for _, r := range rows {
+		a, err := address.NewFromString(r.Address)
+		if err != nil {
+			continue
+		}
+		if ka, err := t.api.StateAccountKey(ctx, a, types.EmptyTSK); err == nil {
+			a = ka
+		}
+		v, err := big.FromString(r.MinBalance)
+		if err != nil {
+			continue
+		}
+		out[a] = v
+	}
+	return out, nil
+}
+
+func (t *WalletTopUpTask) topUpGroup(ctx context.Context, g topUpGroup, thresholds map[address.Address]abi.TokenAmount) error {
+	sourceKey, err := t.api.StateAccountKey(ctx, g.source, types.EmptyTSK)
+	if err != nil {
+		return xerrors.Errorf("getting source key address: %w", err)
+	}
+
+	type target struct {
+		addr address.Address
+		spID uint64
+	}
+	seen := map[address.Address]bool{sourceKey: true}
+	var targets []target
+
+	add := func(a address.Address, spID uint64) {
+		key, err := t.api.StateAccountKey(ctx, a, types.EmptyTSK)
+		if err != nil {
+			log.Warnw("skipping top-up of address without a key", "address", a, "error", err)
+			return
+		}
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		targets = append(targets, target{addr: key, spID: spID})
+	}
+
+	for _, m := range g.miners {
+		spID, err := address.IDFromAddress(m)
+		if err != nil {
+			return xerrors.Errorf("miner ID of %s: %w", m, err)
+		}
+		mi, err := t.api.StateMinerInfo(ctx, m, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info of %s: %w", m, err)
+		}
+
+		add(mi.Worker, spID)
+		for _, ca := range mi.ControlAddresses {
+			add(ca, spID)
+		}
+		for _, ca := range g.extra {
+			add(ca, spID)
+		}
+	}
+
+	for _, tg := range targets {
+		threshold := g.below
+		if th, ok := thresholds[tg.addr]; ok {
+			threshold = th
+		}
+		if threshold.NilOrZero() {
+			continue
+		}
+
+		bal, err := t.api.WalletBalance(ctx, tg.addr)
+		if err != nil {
+			return xerrors.Errorf("getting balance of %s: %w", tg.addr, err)
+		}
+		if bal.GreaterThanEqual(threshold) {
+			continue
+		}
+
+		if err := t.topUp(ctx, g, sourceKey, tg.addr, tg.spID, bal, threshold); err != nil {
+			return xerrors.Errorf("topping up %s: %w", tg.addr, err)
+		}
+	}
+	return nil
+}
+
+func (t *WalletTopUpTask) topUp(ctx context.Context, g topUpGroup, source, to address.Address, spID uint64, bal, threshold abi.TokenAmount) error {
+	// a top-up which didn't land yet already covers the address
+	var pending bool
+	err := t.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM wallet_topups t
+			LEFT JOIN message_waits w ON w.signed_message_cid = t.signed_cid
+			WHERE t.target = $1 AND w.executed_tsk_epoch IS NULL AND t.created_at > NOW() - INTERVAL '1 day')`,
+		to.String()).Scan(&pending)
+	if err != nil {
+		return xerrors.Errorf("checking pending top-ups: %w", err)
+	}
+	if pending {
+		return nil
+	}
+
+	var sentToday string
+	err = t.db.QueryRow(ctx, `SELECT COALESCE(SUM(amount), 0)::TEXT FROM wallet_topups
+			WHERE source = $1 AND created_at > NOW() - INTERVAL '1 day'`, source.String()).Scan(&sentToday)
+	if err != nil {
+		return xerrors.Errorf("getting top-ups sent today: %w", err)
+	}
+	sent, err := big.FromString(sentToday)
+	if err != nil {
+		return xerrors.Errorf("parsing top-ups sent today: %w", err)
+	}
+
+	amount := big.Sub(g.target, bal)
+	var limitedBy *string
+	if amount.GreaterThan(g.maxTransfer) {
+		amount = g.maxTransfer
+		l := "max-transfer"
+		limitedBy = &l
+	}
+	if remaining := big.Sub(g.maxDaily, sent); amount.GreaterThan(remaining) {
+		amount = remaining
+		l := "max-daily"
+		limitedBy = &l
+	}
+	if amount.LessThanEqual(big.Zero()) {
+		log.Warnw("daily top-up limit reached, not topping up", "source", source, "target", to, "balance", types.FIL(bal).Short(), "sent-today", types.FIL(sent).Short())
+		return nil
+	}
+
+	msg := &types.Message{
+		From:   source,
+		To:     to,
+		Value:  amount,
+		Method: builtin.MethodSend,
+	}
+	mcid, err := t.sender.Send(ctx, msg, &api.MessageSendSpec{}, "wallet-top-up")
+	if err != nil {
+		return xerrors.Errorf("sending top-up: %w", err)
+	}
+
+	_, err = t.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`INSERT INTO wallet_topups (source, target, sp_id, amount, balance_before, threshold, limited_by, signed_cid)
+			VALUES ($1, $2, $3, $4::NUMERIC, $5::NUMERIC, $6::NUMERIC, $7, $8)`,
+			source.String(), to.String(), spID, amount.String(), bal.String(), threshold.String(), limitedBy, mcid.String())
+		if err != nil {
+			return false, xerrors.Errorf("recording top-up: %w", err)
+		}
+		_, err = tx.Exec(`INSERT INTO message_waits (signed_message_cid) VALUES ($1)`, mcid.String())
+		if err != nil {
+			return false, xerrors.Errorf("inserting into message_waits: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return err
+	}
+
+	log.Infow("topped up address", "source", source, "target", to, "amount", types.FIL(amount).Short(), "balance", types.FIL(bal).Short(), "cid", mcid)
+	return nil
+}
+
+func (t *WalletTopUpTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	return &ids[0], nil
+}
+
+func (t *WalletTopUpTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "WalletTopUp",
+		Cost: resources.Resources{
+			Cpu: 0,
+			Gpu: 0,
+			Ram: 16 << 20,
+		},
+		IAmBored: harmonytask.SingletonTaskAdder(WalletTopUpInterval, t),
+	}
+}
+
+func (t *WalletTopUpTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ = harmonytask.Reg(&WalletTopUpTask{})
+var _ harmonytask.TaskInterface = &WalletTopUpTask{}
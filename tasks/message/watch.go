@@ -6,8 +6,10 @@ import (
 	"sync/atomic"
 
 	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-f3/certs"
 	"github.com/filecoin-project/go-state-types/abi"
 
 	"github.com/filecoin-project/curio/harmony/harmonydb"
@@ -26,6 +28,7 @@ type MessageWaiterApi interface {
 	ChainGetTipSet(context.Context, types.TipSetKey) (*types.TipSet, error)
 	StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error)
 	ChainGetMessage(ctx context.Context, mc cid.Cid) (*types.Message, error)
+	F3GetLatestCertificate(ctx context.Context) (*certs.FinalityCertificate, error)
 }
 
 type MessageWatcher struct {
@@ -37,16 +40,21 @@ type MessageWatcher struct {
 
 	updateCh chan struct{}
 	bestTs   atomic.Pointer[types.TipSetKey]
+
+	confidence map[string]Confidence
 }
 
-func NewMessageWatcher(db *harmonydb.DB, ht *harmonytask.TaskEngine, pcs *chainsched.CurioChainSched, api MessageWaiterApi) (*MessageWatcher, error) {
+// NewMessageWatcher creates a watcher recording executions of sent messages in message_waits. Executions of messages
+// sent for reasons in confidence are recorded with that confidence, others with DefaultConfidence.
+func NewMessageWatcher(db *harmonydb.DB, ht *harmonytask.TaskEngine, pcs *chainsched.CurioChainSched, api MessageWaiterApi, confidence map[string]Confidence) (*MessageWatcher, error) {
 	mw := &MessageWatcher{
-		db:       db,
-		ht:       ht,
-		api:      api,
-		stopping: make(chan struct{}),
-		stopped:  make(chan struct{}),
-		updateCh: make(chan struct{}),
+		db:         db,
+		ht:         ht,
+		api:        api,
+		confidence: confidence,
+		stopping:   make(chan struct{}),
+		stopped:    make(chan struct{}),
+		updateCh:   make(chan struct{}),
 	}
 	go mw.run()
 	if err := pcs.AddHandler(mw.processHeadChange); err != nil {
@@ -80,13 +88,6 @@ func (mw *MessageWatcher) update() {
 		return
 	}
 
-	lbts, err := mw.api.ChainGetTipSetByHeight(ctx, ts.Height()-MinConfidence, tsk)
-	if err != nil {
-		log.Errorf("failed to get tipset: %+v", err)
-		return
-	}
-	lbtsk := lbts.Key()
-
 	machineID := mw.ht.ResourcesAvailable().MachineID
 
 	// first if we see pending messages with null owner, assign them to ourselves
@@ -103,17 +104,20 @@ func (mw *MessageWatcher) update() {
 
 	// get messages assigned to us
 	var msgs []struct {
-		Cid   string `db:"signed_message_cid"`
-		From  string `db:"from_key"`
-		Nonce uint64 `db:"nonce"`
+		Cid       string  `db:"signed_message_cid"`
+		SignedCid string  `db:"signed_cid"`
+		From      string  `db:"from_key"`
+		Nonce     uint64  `db:"nonce"`
+		Reason    *string `db:"send_reason"`
 
 		FromAddr address.Address `db:"-"`
 	}
 
 	// really large limit in case of things getting stuck and backlogging severely
-	err = mw.db.Select(ctx, &msgs, `SELECT signed_message_cid, from_key, nonce FROM message_waits
-                          JOIN message_sends ON signed_message_cid = signed_cid
-                          WHERE waiter_machine_id = $1 LIMIT 10000`, machineID)
+	// waits of held messages are keyed by the CID Send returned for them, they are looked up once signed
+	err = mw.db.Select(ctx, &msgs, `SELECT signed_message_cid, signed_cid, from_key, nonce, send_reason FROM message_waits
+                          JOIN message_sends ON signed_message_cid = COALESCE(held_cid, signed_cid)
+                          WHERE waiter_machine_id = $1 AND signed_cid IS NOT NULL LIMIT 10000`, machineID)
 	if err != nil {
 		log.Errorf("failed to get assigned messages: %+v", err)
 		return
@@ -133,7 +137,7 @@ func (mw *MessageWatcher) update() {
 
 	// get the nonce for each address
 	for addr := range toCheck {
-		act, err := mw.api.StateGetActor(ctx, addr, lbtsk)
+		act, err := mw.api.StateGetActor(ctx, addr, tsk)
 		if err != nil {
 			log.Errorf("failed to get actor: %+v", err)
 			return
@@ -142,13 +146,16 @@ func (mw *MessageWatcher) update() {
 		toCheck[addr] = act.Nonce
 	}
 
-	// check if any of the messages we have assigned to us are now on chain, and have been for MinConfidence epochs
+	finalized := abi.ChainEpoch(-1) // fetched when needed
+
+	// check if any of the messages we have assigned to us are now on chain, with the confidence required for their
+	// send reason
 	for _, msg := range msgs {
 		if msg.Nonce > toCheck[msg.FromAddr] {
 			continue // definitely not on chain yet
 		}
 
-		look, err := mw.api.StateSearchMsg(ctx, lbtsk, cid.MustParse(msg.Cid), api.LookbackNoLimit, true)
+		look, err := mw.api.StateSearchMsg(ctx, tsk, cid.MustParse(msg.SignedCid), api.LookbackNoLimit, true)
 		if err != nil {
 			log.Errorf("failed to search for message: %+v", err)
 			continue
@@ -158,6 +165,19 @@ func (mw *MessageWatcher) update() {
 			continue // not on chain yet (or not executed yet)
 		}
 
+		conf := DefaultConfidence
+		if msg.Reason != nil {
+			if c, ok := mw.confidence[*msg.Reason]; ok {
+				conf = c
+			}
+		}
+		if conf.Finalized && finalized < 0 {
+			finalized = mw.finalizedEpoch(ctx, ts)
+		}
+		if !conf.reached(look.Height, ts.Height(), finalized) {
+			continue // executed, but not settled enough yet
+		}
+
 		tskCid, err := look.TipSet.Cid()
 		if err != nil {
 			log.Errorf("failed to get tipset cid: %+v", err)
@@ -176,16 +196,25 @@ func (mw *MessageWatcher) update() {
 			continue
 		}
 
+		var cost *string
+		if baseFee, err := mw.inclusionBaseFee(ctx, look.TipSet); err != nil {
+			log.Errorw("failed to get base fee, not recording gas cost", "msg", msg.Cid, "error", err)
+		} else {
+			c := gasCost(emsg, look.Receipt.GasUsed, baseFee).String()
+			cost = &c
+		}
+
 		// record in db
 		_, err = mw.db.Exec(ctx, `UPDATE message_waits SET
 			waiter_machine_id = NULL,
 			executed_tsk_cid = $1, executed_tsk_epoch = $2,
 			executed_msg_cid = $3, executed_msg_data = $4,
-			executed_rcpt_exitcode = $5, executed_rcpt_return = $6, executed_rcpt_gas_used = $7
-                     WHERE signed_message_cid = $8`, tskCid, look.Height,
+			executed_rcpt_exitcode = $5, executed_rcpt_return = $6, executed_rcpt_gas_used = $7,
+			executed_gas_cost = $8::NUMERIC, executed_at = CURRENT_TIMESTAMP
+                     WHERE signed_message_cid = $9`, tskCid, look.Height,
 			look.Message, execMsg,
 			look.Receipt.ExitCode, look.Receipt.Return, look.Receipt.GasUsed,
-			msg.Cid)
+			cost, msg.Cid)
 		if err != nil {
 			log.Errorf("failed to update message wait: %+v", err)
 			continue
@@ -193,6 +222,20 @@ func (mw *MessageWatcher) update() {
 	}
 }
 
+// inclusionBaseFee returns the base fee paid by messages executed in the tipset with the given key, which is the
+// base fee of the parent tipset including them.
+func (mw *MessageWatcher) inclusionBaseFee(ctx context.Context, execTsk types.TipSetKey) (abi.TokenAmount, error) {
+	ets, err := mw.api.ChainGetTipSet(ctx, execTsk)
+	if err != nil {
+		return abi.TokenAmount{}, xerrors.Errorf("getting execution tipset: %w", err)
+	}
+	its, err := mw.api.ChainGetTipSet(ctx, ets.Parents())
+	if err != nil {
+		return abi.TokenAmount{}, xerrors.Errorf("getting inclusion tipset: %w", err)
+	}
+	return its.Blocks()[0].ParentBaseFee, nil
+}
+
 func (mw *MessageWatcher) Stop(ctx context.Context) error {
 	close(mw.stopping)
 	select {
@@ -0,0 +1,315 @@
+package mk12
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/curio/api"
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/market"
+	"github.com/filecoin-project/curio/market/clientlimit"
+
+	lapi "github.com/filecoin-project/lotus/api"
+	lmarket "github.com/filecoin-project/lotus/chain/actors/builtin/market"
+	"github.com/filecoin-project/lotus/chain/proofs"
+	"github.com/filecoin-project/lotus/chain/types"
+	lpiece "github.com/filecoin-project/lotus/storage/pipeline/piece"
+)
+
+const AddDealSchedInterval = time.Minute
+
+// AddDealTask reads the deal IDs of deals published by a PublishStorageDeals message once it landed on chain, and
+// adds the deals to sectors with their parked data.
+type AddDealTask struct {
+	db  *harmonydb.DB
+	api api.Chain
+	cfg *config.CurioConfig
+	cl  *clientlimit.Limiter
+
+	ingesters *market.Ingesters
+}
+
+func NewAddDealTask(db *harmonydb.DB, api api.Chain, cfg *config.CurioConfig) (*AddDealTask, error) {
+	cl, err := clientlimit.New(db, api, cfg.Ingest.ClientLimits)
+	if err != nil {
+		return nil, xerrors.Errorf("creating client limiter: %w", err)
+	}
+
+	return &AddDealTask{
+		db:  db,
+		api: api,
+		cfg: cfg,
+		cl:  cl,
+
+		ingesters: market.NewIngesters(db, api, cfg),
+	}, nil
+}
+
+func (a *AddDealTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var deals []struct {
+		UUID         string `db:"uuid"`
+		SpID         int64  `db:"sp_id"`
+		Proposal     []byte `db:"proposal"`
+		RawSize      int64  `db:"raw_size"`
+		StartEpoch   int64  `db:"start_epoch"`
+		EndEpoch     int64  `db:"end_epoch"`
+		KeepUnsealed bool   `db:"keep_unsealed"`
+		PieceRef     int64  `db:"piece_ref"`
+		PublishCid   string `db:"publish_cid"`
+		PublishIndex int64  `db:"publish_index"`
+		DealID       *int64 `db:"deal_id"`
+	}
+	err = a.db.Select(ctx, &deals, `SELECT uuid, sp_id, proposal, raw_size, start_epoch, end_epoch, keep_unsealed, piece_ref,
+			publish_cid, publish_index, deal_id
+		FROM market_mk12_deals WHERE add_task_id = $1 AND NOT complete ORDER BY publish_index`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting deals: %w", err)
+	}
+	if len(deals) == 0 {
+		return true, nil
+	}
+
+	publishCid, err := cid.Parse(deals[0].PublishCid)
+	if err != nil {
+		return false, xerrors.Errorf("parsing publish cid: %w", err)
+	}
+
+	var exec []struct {
+		ExitCode *int64 `db:"executed_rcpt_exitcode"`
+		Return   []byte `db:"executed_rcpt_return"`
+	}
+	err = a.db.Select(ctx, &exec, `SELECT executed_rcpt_exitcode, executed_rcpt_return FROM message_waits
+		WHERE signed_message_cid = $1 AND executed_tsk_epoch IS NOT NULL`, deals[0].PublishCid)
+	if err != nil {
+		return false, xerrors.Errorf("getting publish message result: %w", err)
+	}
+	if len(exec) != 1 || exec[0].ExitCode == nil {
+		return false, xerrors.Errorf("publish message %s didn't land on chain yet", publishCid)
+	}
+
+	if exitcode.ExitCode(*exec[0].ExitCode) != exitcode.Ok {
+		for _, d := range deals {
+			reason := fmt.Sprintf("publish message %s failed with exit code %s", publishCid, exitcode.ExitCode(*exec[0].ExitCode))
+			if err := failDeal(ctx, a.db, a.cl, d.UUID, reason); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	nv, err := a.api.StateNetworkVersion(ctx, types.EmptyTSK)
+	if err != nil {
+		return false, xerrors.Errorf("getting network version: %w", err)
+	}
+	ret, err := lmarket.DecodePublishStorageDealsReturn(exec[0].Return, nv)
+	if err != nil {
+		return false, xerrors.Errorf("decoding publish message return: %w", err)
+	}
+	dealIDs, err := ret.DealIDs()
+	if err != nil {
+		return false, xerrors.Errorf("getting published deal ids: %w", err)
+	}
+
+	for _, d := range deals {
+		if d.DealID == nil {
+			valid, idx, err := ret.IsDealValid(uint64(d.PublishIndex))
+			if err != nil {
+				return false, xerrors.Errorf("checking published deal %s: %w", d.UUID, err)
+			}
+			if !valid || idx >= len(dealIDs) {
+				if err := failDeal(ctx, a.db, a.cl, d.UUID, "deal was rejected by the market actor"); err != nil {
+					return false, err
+				}
+				continue
+			}
+
+			id := int64(dealIDs[idx])
+			_, err = a.db.Exec(ctx, `UPDATE market_mk12_deals SET deal_id = $2 WHERE uuid = $1`, d.UUID, id)
+			if err != nil {
+				return false, xerrors.Errorf("recording deal id: %w", err)
+			}
+			d.DealID = &id
+		}
+
+		var prop lmarket.DealProposal
+		if err := json.Unmarshal(d.Proposal, &prop); err != nil {
+			return false, xerrors.Errorf("unmarshaling proposal of deal %s: %w", d.UUID, err)
+		}
+
+		maddr, err := address.NewIDAddress(uint64(d.SpID))
+		if err != nil {
+			return false, err
+		}
+		pin, err := a.ingesters.Get(maddr)
+		if err != nil {
+			return false, err
+		}
+
+		deal := lpiece.PieceDealInfo{
+			PublishCid:   &publishCid,
+			DealID:       abi.DealID(*d.DealID),
+			DealProposal: &prop,
+			DealSchedule: lpiece.DealSchedule{
+				StartEpoch: abi.ChainEpoch(d.StartEpoch),
+				EndEpoch:   abi.ChainEpoch(d.EndEpoch),
+			},
+			KeepUnsealed: d.KeepUnsealed,
+		}
+		pieceIDUrl := url.URL{
+			Scheme: "pieceref",
+			Opaque: fmt.Sprintf("%d", d.PieceRef),
+		}
+
+		// deals which were added are complete, a retry continues with the next one. A deal which was added to a
+		// sector by an attempt failing before it was marked complete is only marked complete.
+		so, err := allocatedSector(ctx, a.db, d.SpID, pieceIDUrl)
+		if err != nil {
+			return false, err
+		}
+		if so == nil {
+			added, err := pin.AllocatePieceToSector(ctx, maddr, deal, d.RawSize, pieceIDUrl, nil)
+			if err != nil {
+				return false, xerrors.Errorf("adding deal %s to sector: %w", d.UUID, err)
+			}
+			so = &added
+		}
+
+		_, err = a.db.Exec(ctx, `UPDATE market_mk12_deals SET sector_number = $2, sector_offset = $3, complete = TRUE, add_task_id = NULL
+			WHERE uuid = $1`, d.UUID, int64(so.Sector), int64(so.Offset))
+		if err != nil {
+			return false, xerrors.Errorf("recording deal sector: %w", err)
+		}
+
+		log.Infow("deal added to sector", "uuid", d.UUID, "deal", *d.DealID, "miner", maddr, "sector", so.Sector, "offset", so.Offset)
+	}
+
+	return true, nil
+}
+
+// allocatedSector returns the sector and offset of a piece already added to a sector of the miner from the data
+// url, or nil if it wasn't added. Pieces of deals are added from the parked piece ref of the deal, so the url
+// identifies the deal.
+func allocatedSector(ctx context.Context, db *harmonydb.DB, spID int64, dataURL url.URL) (*lapi.SectorOffset, error) {
+	// the pieces of the sector the piece was added to, up to the piece
+	var pieces []struct {
+		Sector  int64  `db:"sector_number"`
+		Size    int64  `db:"piece_size"`
+		DataURL string `db:"data_url"`
+	}
+	err := db.Select(ctx, &pieces, `WITH pieces AS (
+			SELECT 'open' AS src, sector_number, piece_index, piece_size, data_url FROM open_sector_pieces WHERE sp_id = $1
+			UNION ALL
+			SELECT 'sdr', sector_number, piece_index, piece_size, data_url FROM sectors_sdr_initial_pieces WHERE sp_id = $1
+			UNION ALL
+			SELECT 'snap', sector_number, piece_index, piece_size, data_url FROM sectors_snap_initial_pieces WHERE sp_id = $1
+		), added AS (
+			SELECT src, sector_number, piece_index FROM pieces WHERE data_url = $2 LIMIT 1
+		)
+		SELECT p.sector_number, p.piece_size, p.data_url FROM pieces p
+			JOIN added a ON a.src = p.src AND a.sector_number = p.sector_number AND p.piece_index <= a.piece_index
+		ORDER BY p.piece_index`, spID, dataURL.String())
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector pieces: %w", err)
+	}
+	if len(pieces) == 0 {
+		return nil, nil
+	}
+
+	var sizes []abi.PaddedPieceSize
+	for _, p := range pieces {
+		sizes = append(sizes, abi.PaddedPieceSize(p.Size))
+		if p.DataURL == dataURL.String() {
+			break
+		}
+	}
+
+	return &lapi.SectorOffset{
+		Sector: abi.SectorNumber(pieces[0].Sector),
+		Offset: lastPieceOffset(sizes),
+	}, nil
+}
+
+// lastPieceOffset returns the offset of the last of pieces laid out in a sector in order, the way pieces are
+// added to open sectors
+func lastPieceOffset(sizes []abi.PaddedPieceSize) abi.PaddedPieceSize {
+	var offset abi.UnpaddedPieceSize
+	for i, size := range sizes {
+		_, padLength := proofs.GetRequiredPadding(offset.Padded(), size)
+		offset += padLength.Unpadded()
+		if i == len(sizes)-1 {
+			break
+		}
+		offset += size.Unpadded()
+	}
+	return offset.Padded()
+}
+
+func (a *AddDealTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (a *AddDealTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		// one at a time, deals of one miner are added to the same open sectors
+		Max:  taskhelp.Max(1),
+		Name: "MK12AddDeal",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		MaxFailures: 10,
+		IAmBored:    passcall.Every(AddDealSchedInterval, a.schedule),
+	}
+}
+
+func (a *AddDealTask) Adder(taskFunc harmonytask.AddTaskFunc) {}
+
+func (a *AddDealTask) schedule(taskFunc harmonytask.AddTaskFunc) error {
+	// add the deals of a publish message which landed on chain
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var msgs []struct {
+			PublishCid string `db:"publish_cid"`
+		}
+		err := tx.Select(&msgs, `SELECT d.publish_cid FROM market_mk12_deals d
+				JOIN message_waits mw ON mw.signed_message_cid = d.publish_cid
+			WHERE NOT d.complete AND d.add_task_id IS NULL AND mw.executed_tsk_epoch IS NOT NULL
+			ORDER BY d.created_at LIMIT 1`)
+		if err != nil {
+			return false, xerrors.Errorf("getting published deals: %w", err)
+		}
+		if len(msgs) == 0 {
+			return false, nil
+		}
+
+		n, err := tx.Exec(`UPDATE market_mk12_deals SET add_task_id = $1 WHERE publish_cid = $2 AND NOT complete AND add_task_id IS NULL`,
+			id, msgs[0].PublishCid)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return n > 0, nil
+	})
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&AddDealTask{})
+var _ harmonytask.TaskInterface = &AddDealTask{}
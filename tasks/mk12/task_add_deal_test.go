@@ -0,0 +1,26 @@
+package mk12
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+func TestLastPieceOffset(t *testing.T) {
+	cases := []struct {
+		sizes  []abi.PaddedPieceSize
+		offset abi.PaddedPieceSize
+	}{
+		{[]abi.PaddedPieceSize{2048}, 0},
+		{[]abi.PaddedPieceSize{2048, 2048}, 2048},
+		{[]abi.PaddedPieceSize{128, 256}, 256},
+		{[]abi.PaddedPieceSize{1024, 2048}, 2048},
+		{[]abi.PaddedPieceSize{1024, 1024, 4096}, 4096},
+		{[]abi.PaddedPieceSize{4096, 128, 128, 1024}, 5120},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.offset, lastPieceOffset(c.sizes), "sizes %v", c.sizes)
+	}
+}
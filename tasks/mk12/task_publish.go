@@ -0,0 +1,345 @@
+package mk12
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+
+	"github.com/filecoin-project/curio/api"
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/multictladdr"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/market/clientlimit"
+	"github.com/filecoin-project/curio/tasks/message"
+
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var log = logging.Logger("mk12")
+
+const PublishSchedInterval = time.Minute
+
+// MinPublishStartDelay is how far ahead the start epoch of a deal has to be when it's published. Deals which would
+// start sooner are failed instead, their sectors wouldn't be sealed in time.
+const MinPublishStartDelay = abi.ChainEpoch(4 * builtin.EpochsInHour)
+
+// PublishTask publishes deals accepted over the libp2p deal protocol, whose data was parked, in batched
+// PublishStorageDeals messages. The messages are sent from the deal publish control address of the miner.
+type PublishTask struct {
+	db     *harmonydb.DB
+	api    api.Chain
+	sender *message.Sender
+	as     *multictladdr.MultiAddressSelector
+	cfg    *config.CurioConfig
+	cl     *clientlimit.Limiter
+}
+
+func NewPublishTask(db *harmonydb.DB, api api.Chain, sender *message.Sender, as *multictladdr.MultiAddressSelector, cfg *config.CurioConfig) (*PublishTask, error) {
+	cl, err := clientlimit.New(db, api, cfg.Ingest.ClientLimits)
+	if err != nil {
+		return nil, xerrors.Errorf("creating client limiter: %w", err)
+	}
+
+	return &PublishTask{
+		db:     db,
+		api:    api,
+		sender: sender,
+		as:     as,
+		cfg:    cfg,
+		cl:     cl,
+	}, nil
+}
+
+func (p *PublishTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var deals []publishDeal
+	err = p.db.Select(ctx, &deals, `SELECT uuid, sp_id, proposal, client_signature, start_epoch, limit_id
+		FROM market_mk12_deals WHERE publish_task_id = $1 AND NOT complete ORDER BY created_at, uuid`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting deals: %w", err)
+	}
+	if len(deals) == 0 {
+		return true, nil
+	}
+
+	head, err := p.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	params, batch, late, err := publishBatch(deals, head.Height())
+	if err != nil {
+		return false, err
+	}
+	for _, d := range late {
+		if err := failDeal(ctx, p.db, p.cl, d.UUID, "deal start epoch is too close to publish the deal"); err != nil {
+			return false, err
+		}
+	}
+	if len(batch) == 0 {
+		return true, nil
+	}
+
+	var published []string
+	for _, d := range batch {
+		published = append(published, d.UUID)
+		if d.LimitID != nil {
+			// the data of deals is parked before they are published
+			p.cl.Transferred(ctx, *d.LimitID)
+		}
+	}
+
+	maddr, err := address.NewIDAddress(uint64(deals[0].SpID))
+	if err != nil {
+		return false, err
+	}
+	mi, err := p.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+	if err != nil {
+		return false, xerrors.Errorf("getting miner info: %w", err)
+	}
+	from, _, err := p.as.AddressFor(ctx, p.api, maddr, mi, lapi.DealPublishAddr, big.Zero(), big.Zero())
+	if err != nil {
+		return false, xerrors.Errorf("selecting deal publish address: %w", err)
+	}
+
+	enc, err := actors.SerializeParams(&params)
+	if err != nil {
+		return false, xerrors.Errorf("serializing params: %w", err)
+	}
+
+	msg := &types.Message{
+		To:     builtin.StorageMarketActorAddr,
+		From:   from,
+		Value:  big.Zero(),
+		Method: builtin.MethodsMarket.PublishStorageDeals,
+		Params: enc,
+	}
+
+	mcid, err := p.sender.Send(ctx, msg, &lapi.MessageSendSpec{MaxFee: abi.TokenAmount(p.cfg.Fees.MaxPublishDealsFee)}, "publish-deals")
+	if err != nil {
+		return false, xerrors.Errorf("sending message: %w", err)
+	}
+
+	_, err = p.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		for i, id := range published {
+			_, err = tx.Exec(`UPDATE market_mk12_deals SET publish_cid = $2, publish_index = $3, publish_task_id = NULL
+				WHERE uuid = $1`, id, mcid.String(), i)
+			if err != nil {
+				return false, xerrors.Errorf("updating deal %s: %w", id, err)
+			}
+		}
+		_, err = tx.Exec(`INSERT INTO message_waits (signed_message_cid) VALUES ($1)`, mcid)
+		if err != nil {
+			return false, xerrors.Errorf("inserting into message_waits: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return false, xerrors.Errorf("recording published deals: %w", err)
+	}
+
+	log.Infow("published deals", "miner", maddr, "deals", len(published), "cid", mcid)
+	return true, nil
+}
+
+type publishDeal struct {
+	UUID            string `db:"uuid"`
+	SpID            int64  `db:"sp_id"`
+	Proposal        []byte `db:"proposal"`
+	ClientSignature []byte `db:"client_signature"`
+	StartEpoch      int64  `db:"start_epoch"`
+	LimitID         *int64 `db:"limit_id"`
+}
+
+// publishBatch builds the PublishStorageDeals params for deals in order, returning the deals in the message. Deals
+// which start too soon after height to be sealed in time are left out and returned as late.
+func publishBatch(deals []publishDeal, height abi.ChainEpoch) (params market.PublishStorageDealsParams, batch, late []publishDeal, err error) {
+	for _, d := range deals {
+		if abi.ChainEpoch(d.StartEpoch) < height+MinPublishStartDelay {
+			late = append(late, d)
+			continue
+		}
+
+		var cdp market.ClientDealProposal
+		if err := json.Unmarshal(d.Proposal, &cdp.Proposal); err != nil {
+			return params, nil, nil, xerrors.Errorf("unmarshaling proposal of deal %s: %w", d.UUID, err)
+		}
+		if err := cdp.ClientSignature.UnmarshalBinary(d.ClientSignature); err != nil {
+			return params, nil, nil, xerrors.Errorf("unmarshaling client signature of deal %s: %w", d.UUID, err)
+		}
+
+		params.Deals = append(params.Deals, cdp)
+		batch = append(batch, d)
+	}
+	return params, batch, late, nil
+}
+
+func (p *PublishTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (p *PublishTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "MK12Publish",
+		Cost: resources.Resources{
+			Cpu: 0,
+			Ram: 64 << 20,
+		},
+		MaxFailures: 5,
+		IAmBored:    passcall.Every(PublishSchedInterval, p.schedule),
+	}
+}
+
+func (p *PublishTask) Adder(taskFunc harmonytask.AddTaskFunc) {}
+
+func (p *PublishTask) schedule(taskFunc harmonytask.AddTaskFunc) error {
+	ctx := context.Background()
+
+	if err := p.failStuckDeals(ctx); err != nil {
+		return err
+	}
+
+	lcfg := p.cfg.Ingest.Libp2p
+
+	// publish the deals of one miner whose data was parked, once enough of them are waiting or the oldest one waited
+	// long enough
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var ready []struct {
+			SpID int64 `db:"sp_id"`
+		}
+		err := tx.Select(&ready, `SELECT d.sp_id FROM market_mk12_deals d
+				JOIN parked_piece_refs r ON r.ref_id = d.piece_ref
+				JOIN parked_pieces pp ON pp.id = r.piece_id
+			WHERE NOT d.complete AND d.publish_cid IS NULL AND d.publish_task_id IS NULL AND pp.complete
+			GROUP BY d.sp_id
+			HAVING COUNT(*) >= $1 OR MIN(d.created_at) < current_timestamp - make_interval(secs => $2)
+			ORDER BY MIN(d.created_at) LIMIT 1`, lcfg.MaxDealsPerPublishMsg, time.Duration(lcfg.PublishMsgPeriod).Seconds())
+		if err != nil {
+			return false, xerrors.Errorf("getting deals ready to publish: %w", err)
+		}
+		if len(ready) == 0 {
+			return false, nil
+		}
+
+		n, err := tx.Exec(`UPDATE market_mk12_deals SET publish_task_id = $1 WHERE uuid IN (
+				SELECT d.uuid FROM market_mk12_deals d
+					JOIN parked_piece_refs r ON r.ref_id = d.piece_ref
+					JOIN parked_pieces pp ON pp.id = r.piece_id
+				WHERE d.sp_id = $2 AND NOT d.complete AND d.publish_cid IS NULL AND d.publish_task_id IS NULL AND pp.complete
+				ORDER BY d.created_at, d.uuid LIMIT $3)`, id, ready[0].SpID, lcfg.MaxDealsPerPublishMsg)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return n > 0, nil
+	})
+
+	return nil
+}
+
+// failStuckDeals fails unpublished deals whose data couldn't be fetched, or which would start too soon to be
+// published
+func (p *PublishTask) failStuckDeals(ctx context.Context) error {
+	var failed []struct {
+		UUID   string  `db:"uuid"`
+		TaskID int64   `db:"task_id"`
+		Err    *string `db:"err"`
+	}
+	// ParkPiece tasks which failed for good are gone from harmony_task
+	err := p.db.Select(ctx, &failed, `SELECT d.uuid, pp.task_id,
+			(SELECT h.err FROM harmony_task_history h WHERE h.task_id = pp.task_id ORDER BY h.work_end DESC LIMIT 1) AS err
+		FROM market_mk12_deals d
+			JOIN parked_piece_refs r ON r.ref_id = d.piece_ref
+			JOIN parked_pieces pp ON pp.id = r.piece_id
+		WHERE NOT d.complete AND d.publish_cid IS NULL AND NOT pp.complete AND pp.task_id IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM harmony_task t WHERE t.id = pp.task_id)`)
+	if err != nil {
+		return xerrors.Errorf("getting deals with failed transfers: %w", err)
+	}
+	for _, f := range failed {
+		reason := "fetching deal data failed"
+		if f.Err != nil {
+			reason += ": " + *f.Err
+		}
+		if err := failDeal(ctx, p.db, p.cl, f.UUID, reason); err != nil {
+			return err
+		}
+	}
+
+	head, err := p.api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	var late []struct {
+		UUID string `db:"uuid"`
+	}
+	err = p.db.Select(ctx, &late, `SELECT uuid FROM market_mk12_deals
+		WHERE NOT complete AND publish_cid IS NULL AND publish_task_id IS NULL AND start_epoch < $1`, int64(head.Height()+MinPublishStartDelay))
+	if err != nil {
+		return xerrors.Errorf("getting late deals: %w", err)
+	}
+	for _, l := range late {
+		if err := failDeal(ctx, p.db, p.cl, l.UUID, "deal wasn't published in time before its start epoch"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// failDeal marks a deal failed, releasing its parked piece ref and its place in the client limits
+func failDeal(ctx context.Context, db *harmonydb.DB, cl *clientlimit.Limiter, uuid string, reason string) error {
+	var limitID *int64
+	_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		var ref *int64
+		err = tx.QueryRow(`SELECT piece_ref, limit_id FROM market_mk12_deals WHERE uuid = $1`, uuid).Scan(&ref, &limitID)
+		if err != nil {
+			return false, xerrors.Errorf("getting deal: %w", err)
+		}
+
+		_, err = tx.Exec(`UPDATE market_mk12_deals SET error = $2, complete = TRUE, piece_ref = NULL,
+				publish_task_id = NULL, add_task_id = NULL WHERE uuid = $1`, uuid, reason)
+		if err != nil {
+			return false, xerrors.Errorf("updating deal: %w", err)
+		}
+		if ref != nil {
+			_, err = tx.Exec(`DELETE FROM parked_piece_refs WHERE ref_id = $1`, *ref)
+			if err != nil {
+				return false, xerrors.Errorf("removing parked piece ref: %w", err)
+			}
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return xerrors.Errorf("failing deal %s: %w", uuid, err)
+	}
+
+	if limitID != nil {
+		cl.Failed(ctx, *limitID)
+	}
+
+	log.Warnw("deal failed", "uuid", uuid, "reason", reason)
+	return nil
+}
+
+var _ = harmonytask.Reg(&PublishTask{})
+var _ harmonytask.TaskInterface = &PublishTask{}
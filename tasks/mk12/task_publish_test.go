@@ -0,0 +1,86 @@
+package mk12
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/filecoin-project/go-state-types/crypto"
+)
+
+func testPublishDeal(t *testing.T, uuid string, start abi.ChainEpoch) publishDeal {
+	client, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	provider, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	prop, err := json.Marshal(market.DealProposal{
+		PieceSize:  2048,
+		Client:     client,
+		Provider:   provider,
+		Label:      market.EmptyDealLabel,
+		StartEpoch: start,
+		EndEpoch:   start + 518400,
+	})
+	require.NoError(t, err)
+
+	sig := crypto.Signature{Type: crypto.SigTypeSecp256k1, Data: []byte(uuid)}
+	sigBytes, err := sig.MarshalBinary()
+	require.NoError(t, err)
+
+	return publishDeal{
+		UUID:            uuid,
+		SpID:            1000,
+		Proposal:        prop,
+		ClientSignature: sigBytes,
+		StartEpoch:      int64(start),
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	height := abi.ChainEpoch(10000)
+	minStart := height + MinPublishStartDelay
+
+	deals := []publishDeal{
+		testPublishDeal(t, "a", minStart+100),
+		testPublishDeal(t, "late", minStart-1),
+		testPublishDeal(t, "b", minStart),
+	}
+
+	params, batch, late, err := publishBatch(deals, height)
+	require.NoError(t, err)
+
+	require.Len(t, late, 1)
+	require.Equal(t, "late", late[0].UUID)
+
+	// deals keep their order, the message return is matched to them by index
+	require.Len(t, batch, 2)
+	require.Equal(t, "a", batch[0].UUID)
+	require.Equal(t, "b", batch[1].UUID)
+
+	require.Len(t, params.Deals, 2)
+	require.Equal(t, minStart+100, params.Deals[0].Proposal.StartEpoch)
+	require.Equal(t, minStart, params.Deals[1].Proposal.StartEpoch)
+	require.Equal(t, []byte("a"), params.Deals[0].ClientSignature.Data)
+	require.Equal(t, []byte("b"), params.Deals[1].ClientSignature.Data)
+}
+
+func TestPublishBatchAllLate(t *testing.T) {
+	params, batch, late, err := publishBatch([]publishDeal{testPublishDeal(t, "late", 10)}, 10000)
+	require.NoError(t, err)
+	require.Empty(t, batch)
+	require.Empty(t, params.Deals)
+	require.Len(t, late, 1)
+}
+
+func TestPublishBatchBadSignature(t *testing.T) {
+	d := testPublishDeal(t, "a", 100000)
+	d.ClientSignature = nil
+
+	_, _, _, err := publishBatch([]publishDeal{d}, 10000)
+	require.ErrorContains(t, err, "unmarshaling client signature of deal a")
+}
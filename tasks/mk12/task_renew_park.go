@@ -0,0 +1,218 @@
+package mk12
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/yugabyte/pgx/v5"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/api"
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/market"
+	"github.com/filecoin-project/curio/market/clientlimit"
+	"github.com/filecoin-project/curio/tasks/indexing"
+)
+
+const RenewParkSchedInterval = time.Minute
+
+// RenewParkTask parks the piece of an accepted renewal deal from an unsealed copy of a sector holding it, when the
+// piece isn't parked anymore, so that the renewal deal is published and sealed without transferring the data again.
+type RenewParkTask struct {
+	db  *harmonydb.DB
+	sc  *ffi.SealCalls
+	cl  *clientlimit.Limiter
+	max int
+}
+
+func NewRenewParkTask(db *harmonydb.DB, api api.Chain, sc *ffi.SealCalls, cfg *config.CurioConfig, max int) (*RenewParkTask, error) {
+	cl, err := clientlimit.New(db, api, cfg.Ingest.ClientLimits)
+	if err != nil {
+		return nil, xerrors.Errorf("creating client limiter: %w", err)
+	}
+
+	return &RenewParkTask{
+		db:  db,
+		sc:  sc,
+		cl:  cl,
+		max: max,
+	}, nil
+}
+
+func (r *RenewParkTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var renewals []struct {
+		ID        int64   `db:"id"`
+		SpID      int64   `db:"sp_id"`
+		Client    string  `db:"client"`
+		PieceCID  string  `db:"piece_cid"`
+		PieceSize int64   `db:"piece_size"`
+		DealUUID  *string `db:"deal_uuid"`
+	}
+	err = r.db.Select(ctx, &renewals, `SELECT id, sp_id, client, piece_cid, piece_size, deal_uuid FROM market_deal_renewals
+		WHERE park_task_id = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting renewal: %w", err)
+	}
+	if len(renewals) != 1 {
+		return false, xerrors.Errorf("expected one renewal, got %d", len(renewals))
+	}
+	rn := renewals[0]
+	if rn.DealUUID == nil {
+		return false, xerrors.Errorf("renewal %d has no deal", rn.ID)
+	}
+
+	pieceCID, err := cid.Parse(rn.PieceCID)
+	if err != nil {
+		return false, xerrors.Errorf("parsing piece cid: %w", err)
+	}
+	psize := abi.PaddedPieceSize(rn.PieceSize)
+
+	// the piece may have been parked for another deal in the meantime
+	var pieceID int64
+	err = r.db.QueryRow(ctx, `SELECT id FROM parked_pieces WHERE piece_cid = $1`, rn.PieceCID).Scan(&pieceID)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		pieceID, err = r.parkFromSector(ctx, rn.SpID, pieceCID, psize)
+		if err != nil {
+			return false, err
+		}
+		if pieceID == 0 {
+			if err := failDeal(ctx, r.db, r.cl, *rn.DealUUID, "no unsealed copy of the renewed piece is left"); err != nil {
+				return false, err
+			}
+			_, err = r.db.Exec(ctx, `UPDATE market_deal_renewals SET needs_park = FALSE, park_task_id = NULL WHERE id = $1`, rn.ID)
+			if err != nil {
+				return false, xerrors.Errorf("updating renewal: %w", err)
+			}
+			return true, nil
+		}
+	default:
+		return false, xerrors.Errorf("checking parked piece: %w", err)
+	}
+
+	_, err = r.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		var refID int64
+		err = tx.QueryRow(`INSERT INTO parked_piece_refs (piece_id, data_url, client, sp_id)
+			VALUES ($1, NULL, $2, $3) RETURNING ref_id`, pieceID, rn.Client, rn.SpID).Scan(&refID)
+		if err != nil {
+			return false, xerrors.Errorf("adding parked piece ref: %w", err)
+		}
+
+		n, err := tx.Exec(`UPDATE market_mk12_deals SET piece_ref = $2 WHERE uuid = $1 AND piece_ref IS NULL AND NOT complete`, *rn.DealUUID, refID)
+		if err != nil {
+			return false, xerrors.Errorf("setting deal piece ref: %w", err)
+		}
+		if n == 0 {
+			// the deal failed meanwhile, the ref isn't needed
+			_, err = tx.Exec(`DELETE FROM parked_piece_refs WHERE ref_id = $1`, refID)
+			if err != nil {
+				return false, xerrors.Errorf("removing parked piece ref: %w", err)
+			}
+		}
+
+		_, err = tx.Exec(`UPDATE market_deal_renewals SET needs_park = FALSE, park_task_id = NULL WHERE id = $1`, rn.ID)
+		if err != nil {
+			return false, xerrors.Errorf("updating renewal: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// parkFromSector parks a piece from an unsealed copy of a sector of the miner holding it. It returns 0 when there is
+// no unsealed copy of the piece.
+func (r *RenewParkTask) parkFromSector(ctx context.Context, spID int64, pieceCID cid.Cid, psize abi.PaddedPieceSize) (int64, error) {
+	sectors, err := indexing.PieceSectors(ctx, r.db, pieceCID.String())
+	if err != nil {
+		return 0, err
+	}
+
+	size := uint64(psize.Unpadded())
+	for _, ps := range sectors {
+		if int64(ps.Sector.ID.Miner) != spID {
+			continue
+		}
+
+		has, err := r.sc.IsUnsealed(ctx, ps.Sector, ffi.UnsealedChunks(ps.Offset, size))
+		if err != nil {
+			log.Warnw("checking unsealed piece", "piece_cid", pieceCID, "sector", ps.Sector.ID, "error", err)
+			continue
+		}
+		if !has {
+			continue
+		}
+
+		rd, err := r.sc.UnsealedReader(ctx, ps.Sector, ps.Offset, size)
+		if err != nil {
+			return 0, xerrors.Errorf("opening unsealed piece in sector %s: %w", ps.Sector.ID, err)
+		}
+
+		start := time.Now()
+		res, err := market.ParkPieceData(ctx, r.db, r.sc, pieceCID, psize, rd)
+		_ = rd.Close()
+		if err != nil {
+			return 0, xerrors.Errorf("parking piece from sector %s: %w", ps.Sector.ID, err)
+		}
+
+		log.Infow("renewed piece parked from unsealed sector", "piece_cid", pieceCID, "sector", ps.Sector.ID, "id", res.PieceID,
+			"took", time.Since(start))
+		return res.PieceID, nil
+	}
+
+	return 0, nil
+}
+
+func (r *RenewParkTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (r *RenewParkTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(r.max),
+		Name: "MK12RenewPark",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		MaxFailures: 10,
+		IAmBored:    passcall.Every(RenewParkSchedInterval, r.schedule),
+	}
+}
+
+func (r *RenewParkTask) Adder(taskFunc harmonytask.AddTaskFunc) {}
+
+func (r *RenewParkTask) schedule(taskFunc harmonytask.AddTaskFunc) error {
+	// park the piece of the oldest accepted renewal which isn't parked
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		n, err := tx.Exec(`UPDATE market_deal_renewals SET park_task_id = $1 WHERE id = (
+				SELECT id FROM market_deal_renewals WHERE state = 'accepted' AND needs_park AND park_task_id IS NULL
+				ORDER BY accepted_at LIMIT 1)`, id)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+		return n > 0, nil
+	})
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&RenewParkTask{})
+var _ harmonytask.TaskInterface = &RenewParkTask{}
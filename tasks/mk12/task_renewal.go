@@ -0,0 +1,227 @@
+package mk12
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+
+	"github.com/filecoin-project/curio/api"
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const RenewalInterval = time.Hour
+
+// RenewalTask offers renewals of deals which end within Ingest.Libp2p.Renewal.Window to their clients. Every run it
+// records an offer in market_deal_renewals for each deal of the miners whose data is still held, moves offers which
+// would start too soon to a new schedule, and expires the offers of deals which ended.
+type RenewalTask struct {
+	db     *harmonydb.DB
+	api    api.Chain
+	cfg    *config.CurioConfig
+	miners []address.Address
+}
+
+func NewRenewalTask(db *harmonydb.DB, api api.Chain, cfg *config.CurioConfig, miners []address.Address) *RenewalTask {
+	return &RenewalTask{
+		db:     db,
+		api:    api,
+		cfg:    cfg,
+		miners: miners,
+	}
+}
+
+func (r *RenewalTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	head, err := r.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	n, err := r.db.Exec(ctx, `UPDATE market_deal_renewals SET state = 'expired' WHERE state = 'offered' AND deal_end_epoch <= $1`, int64(head.Height()))
+	if err != nil {
+		return false, xerrors.Errorf("expiring renewal offers: %w", err)
+	}
+	if n > 0 {
+		log.Infow("renewal offers expired", "offers", n)
+	}
+
+	for _, maddr := range r.miners {
+		// a failing miner doesn't hold back the others
+		if err := r.offerRenewals(ctx, maddr, head.Height()); err != nil {
+			log.Errorw("offering deal renewals", "miner", maddr, "error", err)
+		}
+	}
+
+	return true, nil
+}
+
+type collateralKey struct {
+	size     abi.PaddedPieceSize
+	verified bool
+}
+
+func (r *RenewalTask) offerRenewals(ctx context.Context, maddr address.Address, height abi.ChainEpoch) error {
+	lcfg := r.cfg.Ingest.Libp2p
+
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner id: %w", err)
+	}
+
+	minStart := height + durationEpochs(lcfg.MinStartDelay)
+	start := max(height+durationEpochs(lcfg.Renewal.StartDelay), minStart)
+
+	// offers which would start too soon to be accepted move to a new schedule
+	var stale []struct {
+		ID    int64           `db:"id"`
+		Offer json.RawMessage `db:"offer"`
+	}
+	err = r.db.Select(ctx, &stale, `SELECT id, offer FROM market_deal_renewals
+		WHERE sp_id = $1 AND state = 'offered' AND offer_start_epoch < $2`, int64(mid), int64(minStart))
+	if err != nil {
+		return xerrors.Errorf("getting stale offers: %w", err)
+	}
+	for _, s := range stale {
+		var prop market.DealProposal
+		if err := json.Unmarshal(s.Offer, &prop); err != nil {
+			return xerrors.Errorf("unmarshaling offer %d: %w", s.ID, err)
+		}
+		prop.EndEpoch = start + (prop.EndEpoch - prop.StartEpoch)
+		prop.StartEpoch = start
+
+		offer, err := json.Marshal(prop)
+		if err != nil {
+			return xerrors.Errorf("marshaling offer %d: %w", s.ID, err)
+		}
+		_, err = r.db.Exec(ctx, `UPDATE market_deal_renewals SET offer = $2, offer_start_epoch = $3 WHERE id = $1 AND state = 'offered'`,
+			s.ID, offer, int64(start))
+		if err != nil {
+			return xerrors.Errorf("updating offer %d: %w", s.ID, err)
+		}
+	}
+
+	// deals ending within the window whose data is parked or in an unsealed sector copy
+	var deals []struct {
+		SectorNum int64           `db:"sector_num"`
+		DealID    int64           `db:"f05_deal_id"`
+		Proposal  json.RawMessage `db:"f05_deal_proposal"`
+		PieceCID  string          `db:"piece_cid"`
+		PieceSize int64           `db:"piece_size"`
+		EndEpoch  int64           `db:"orig_end_epoch"`
+	}
+	err = r.db.Select(ctx, &deals, `SELECT p.sector_num, p.f05_deal_id, p.f05_deal_proposal, p.piece_cid, p.piece_size, p.orig_end_epoch
+		FROM sectors_meta_pieces p
+		WHERE p.sp_id = $1 AND p.f05_deal_id IS NOT NULL AND p.f05_deal_proposal IS NOT NULL
+			AND p.orig_end_epoch > $2 AND p.orig_end_epoch <= $3
+			AND NOT EXISTS (SELECT 1 FROM market_deal_renewals r WHERE r.sp_id = p.sp_id AND r.deal_id = p.f05_deal_id)
+			AND (EXISTS (SELECT 1 FROM parked_pieces pp WHERE pp.piece_cid = p.piece_cid AND pp.complete)
+				OR EXISTS (SELECT 1 FROM sector_location sl
+					WHERE sl.miner_id = p.sp_id AND sl.sector_num = p.sector_num AND sl.sector_filetype = 1)) -- 1 is unsealed
+		ORDER BY p.orig_end_epoch, p.f05_deal_id
+		LIMIT $4`, int64(mid), int64(height), int64(height+durationEpochs(lcfg.Renewal.Window)), lcfg.Renewal.MaxOffersPerRun)
+	if err != nil {
+		return xerrors.Errorf("getting expiring deals: %w", err)
+	}
+
+	collateral := map[collateralKey]abi.TokenAmount{}
+	clients := map[address.Address]address.Address{}
+
+	var offered int
+	for _, d := range deals {
+		var orig market.DealProposal
+		if err := json.Unmarshal(d.Proposal, &orig); err != nil {
+			log.Warnw("unmarshaling proposal of expiring deal", "miner", maddr, "deal", d.DealID, "error", err)
+			continue
+		}
+
+		client, ok := clients[orig.Client]
+		if !ok {
+			client, err = r.api.StateLookupID(ctx, orig.Client, types.EmptyTSK)
+			if err != nil {
+				return xerrors.Errorf("looking up client %s: %w", orig.Client, err)
+			}
+			clients[orig.Client] = client
+		}
+
+		ck := collateralKey{size: orig.PieceSize, verified: orig.VerifiedDeal}
+		pc, ok := collateral[ck]
+		if !ok {
+			bounds, err := r.api.StateDealProviderCollateralBounds(ctx, orig.PieceSize, orig.VerifiedDeal, types.EmptyTSK)
+			if err != nil {
+				return xerrors.Errorf("getting provider collateral bounds: %w", err)
+			}
+			// some margin, so that the offer stays valid when the minimum grows before it's accepted
+			pc = big.Div(big.Mul(bounds.Min, big.NewInt(12)), big.NewInt(10))
+			collateral[ck] = pc
+		}
+
+		prop := orig
+		prop.Client = client
+		prop.Provider = maddr
+		prop.StartEpoch = start
+		prop.EndEpoch = start + (orig.EndEpoch - orig.StartEpoch)
+		prop.ProviderCollateral = pc
+
+		offer, err := json.Marshal(prop)
+		if err != nil {
+			return xerrors.Errorf("marshaling offer: %w", err)
+		}
+
+		n, err := r.db.Exec(ctx, `INSERT INTO market_deal_renewals (sp_id, client, piece_cid, piece_size, deal_id, sector_num,
+				deal_end_epoch, offer, offer_start_epoch)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT DO NOTHING`,
+			int64(mid), client.String(), d.PieceCID, d.PieceSize, d.DealID, d.SectorNum, d.EndEpoch, offer, int64(start))
+		if err != nil {
+			return xerrors.Errorf("recording renewal offer: %w", err)
+		}
+		offered += n
+	}
+
+	if offered > 0 || len(stale) > 0 {
+		log.Infow("deal renewals offered", "miner", maddr, "offers", offered, "rescheduled", len(stale))
+	}
+	return nil
+}
+
+// durationEpochs returns the number of epochs in a duration
+func durationEpochs(d config.Duration) abi.ChainEpoch {
+	return abi.ChainEpoch(time.Duration(d) / (builtin.EpochDurationSeconds * time.Second))
+}
+
+func (r *RenewalTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (r *RenewalTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "MK12Renewal",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 128 << 20,
+		},
+		IAmBored: harmonytask.SingletonTaskAdder(RenewalInterval, r),
+	}
+}
+
+func (r *RenewalTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ = harmonytask.Reg(&RenewalTask{})
+var _ harmonytask.TaskInterface = &RenewalTask{}
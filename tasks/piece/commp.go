@@ -0,0 +1,116 @@
+package piece
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+// CommPSource is data whose piece commitment is calculated by a CommP task. Data is read either from an URL by any
+// node, or from a file in a storage path by a node the path is attached to.
+type CommPSource struct {
+	DataURL     string
+	DataHeaders http.Header
+
+	StorageID   string
+	StorageFile string
+
+	// RawSize and ContentHash, the hex encoded sha256 of the data, are checked against the data when set. With
+	// ContentHash set, data seen before isn't read again.
+	RawSize     int64
+	ContentHash string
+}
+
+// CommPRequest is a piece commitment calculation
+type CommPRequest struct {
+	ID           int64      `db:"request_id"`
+	DataURL      *string    `db:"data_url"`
+	StorageID    *string    `db:"storage_id"`
+	StorageFile  *string    `db:"storage_file"`
+	RawSize      *int64     `db:"raw_size"`
+	ContentHash  *string    `db:"content_hash"`
+	CreateTime   time.Time  `db:"create_time"`
+	PieceCID     *string    `db:"piece_cid"`
+	PieceSize    *int64     `db:"piece_size"`
+	Cached       bool       `db:"cached"`
+	Error        *string    `db:"error"`
+	CompleteTime *time.Time `db:"complete_time"`
+	TaskID       *int64     `db:"task_id"`
+
+	// TaskError is the error of the last failed attempt of the CommP task
+	TaskError *string `db:"task_error"`
+}
+
+// RequestCommP queues the piece commitment calculation of some data. Data with a content hash found in the
+// cache completes right away.
+func RequestCommP(ctx context.Context, db *harmonydb.DB, src CommPSource) (int64, error) {
+	if (src.DataURL == "") == (src.StorageID == "") {
+		return 0, xerrors.Errorf("expected either a data URL or a storage path")
+	}
+	if src.StorageID != "" {
+		f := filepath.Clean(src.StorageFile)
+		if src.StorageFile == "" || filepath.IsAbs(f) || f == ".." || strings.HasPrefix(f, "../") {
+			return 0, xerrors.Errorf("storage file must be a path inside the storage path")
+		}
+		src.StorageFile = f
+	}
+	if src.ContentHash != "" {
+		h, err := hex.DecodeString(src.ContentHash)
+		if err != nil || len(h) != 32 {
+			return 0, xerrors.Errorf("content hash must be a hex encoded sha256")
+		}
+		src.ContentHash = hex.EncodeToString(h)
+	}
+
+	if src.DataHeaders == nil {
+		src.DataHeaders = http.Header{}
+	}
+	hdrJson, err := json.Marshal(src.DataHeaders)
+	if err != nil {
+		return 0, xerrors.Errorf("marshaling headers: %w", err)
+	}
+
+	var id int64
+	err = db.QueryRow(ctx, `INSERT INTO commp_requests (data_url, data_headers, storage_id, storage_file, raw_size, content_hash)
+		VALUES (NULLIF($1, ''), $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, 0), NULLIF($6, '')) RETURNING request_id`,
+		src.DataURL, hdrJson, src.StorageID, src.StorageFile, src.RawSize, src.ContentHash).Scan(&id)
+	if err != nil {
+		return 0, xerrors.Errorf("queueing commp request: %w", err)
+	}
+
+	if src.ContentHash != "" {
+		_, err = db.Exec(ctx, `UPDATE commp_requests r SET piece_cid = c.piece_cid, piece_size = c.piece_size, raw_size = c.raw_size,
+				cached = TRUE, complete_time = current_timestamp
+			FROM commp_cache c
+			WHERE r.request_id = $1 AND c.content_hash = r.content_hash AND (r.raw_size IS NULL OR r.raw_size = c.raw_size)`, id)
+		if err != nil {
+			return 0, xerrors.Errorf("checking commp cache: %w", err)
+		}
+	}
+
+	return id, nil
+}
+
+// GetCommPRequest returns a piece commitment calculation
+func GetCommPRequest(ctx context.Context, db *harmonydb.DB, id int64) (CommPRequest, error) {
+	var reqs []CommPRequest
+	err := db.Select(ctx, &reqs, `SELECT request_id, data_url, storage_id, storage_file, raw_size, content_hash, create_time,
+			piece_cid, piece_size, cached, error, complete_time, task_id,
+			(SELECT h.err FROM harmony_task_history h WHERE h.task_id = r.task_id AND NOT h.result ORDER BY h.work_end DESC LIMIT 1) AS task_error
+		FROM commp_requests r WHERE request_id = $1`, id)
+	if err != nil {
+		return CommPRequest{}, xerrors.Errorf("getting commp request: %w", err)
+	}
+	if len(reqs) != 1 {
+		return CommPRequest{}, xerrors.Errorf("commp request %d not found", id)
+	}
+	return reqs[0], nil
+}
@@ -0,0 +1,79 @@
+package piece
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+// LinkParkedPiece points pending deal pieces which fetch their data from an URL at a complete parked piece with the
+// same piece CID, adding a piece ref for each of them, so that the data is read from the park instead of being
+// downloaded again for every sector. Pieces of sectors past TreeD or snap encoding don't need the data anymore.
+func LinkParkedPiece(ctx context.Context, db *harmonydb.DB, pieceCID cid.Cid, pieceID int64) (int, error) {
+	var linked int
+
+	_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		linked = 0
+
+		var pending []struct {
+			Table        string `db:"tbl"`
+			SpID         int64  `db:"sp_id"`
+			SectorNumber int64  `db:"sector_number"`
+			PieceIndex   int64  `db:"piece_index"`
+		}
+		err = tx.Select(&pending, `
+			SELECT 'open' AS tbl, sp_id, sector_number, piece_index FROM open_sector_pieces
+				WHERE piece_cid = $1 AND data_url NOT LIKE 'pieceref:%'
+			UNION ALL
+			SELECT 'sdr' AS tbl, ip.sp_id, ip.sector_number, ip.piece_index FROM sectors_sdr_initial_pieces ip
+				JOIN sectors_sdr_pipeline p ON p.sp_id = ip.sp_id AND p.sector_number = ip.sector_number
+				WHERE ip.piece_cid = $1 AND ip.data_url NOT LIKE 'pieceref:%' AND NOT p.after_tree_d
+			UNION ALL
+			SELECT 'snap' AS tbl, ip.sp_id, ip.sector_number, ip.piece_index FROM sectors_snap_initial_pieces ip
+				JOIN sectors_snap_pipeline p ON p.sp_id = ip.sp_id AND p.sector_number = ip.sector_number
+				WHERE ip.piece_cid = $1 AND ip.data_url NOT LIKE 'pieceref:%' AND NOT p.after_encode`, pieceCID.String())
+		if err != nil {
+			return false, xerrors.Errorf("getting pending deal pieces: %w", err)
+		}
+
+		for _, p := range pending {
+			var refID int64
+			err := tx.QueryRow(`INSERT INTO parked_piece_refs (piece_id, data_url) VALUES ($1, NULL) RETURNING ref_id`, pieceID).Scan(&refID)
+			if err != nil {
+				return false, xerrors.Errorf("adding piece ref: %w", err)
+			}
+			dataURL := fmt.Sprintf("pieceref:%d", refID)
+
+			var n int
+			switch p.Table {
+			case "open":
+				n, err = tx.Exec(`UPDATE open_sector_pieces SET data_url = $1, data_headers = '{}'
+					WHERE sp_id = $2 AND sector_number = $3 AND piece_index = $4`, dataURL, p.SpID, p.SectorNumber, p.PieceIndex)
+			case "sdr":
+				n, err = tx.Exec(`UPDATE sectors_sdr_initial_pieces SET data_url = $1, data_headers = '{}'
+					WHERE sp_id = $2 AND sector_number = $3 AND piece_index = $4`, dataURL, p.SpID, p.SectorNumber, p.PieceIndex)
+			case "snap":
+				n, err = tx.Exec(`UPDATE sectors_snap_initial_pieces SET data_url = $1, data_headers = '{}'
+					WHERE sp_id = $2 AND sector_number = $3 AND piece_index = $4`, dataURL, p.SpID, p.SectorNumber, p.PieceIndex)
+			}
+			if err != nil {
+				return false, xerrors.Errorf("linking %s piece %d of sector %d/%d: %w", p.Table, p.PieceIndex, p.SpID, p.SectorNumber, err)
+			}
+			if n != 1 {
+				return false, xerrors.Errorf("linking %s piece %d of sector %d/%d: updated %d rows", p.Table, p.PieceIndex, p.SpID, p.SectorNumber, n)
+			}
+			linked++
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return 0, xerrors.Errorf("linking pending deals: %w", err)
+	}
+
+	return linked, nil
+}
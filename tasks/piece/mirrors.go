@@ -0,0 +1,85 @@
+package piece
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+// Mirror is an alternative source of the data of a parked piece
+type Mirror struct {
+	PieceCID    string          `db:"piece_cid"`
+	MirrorIndex int64           `db:"mirror_index"`
+	DataURL     string          `db:"data_url"`
+	DataHeaders json.RawMessage `db:"data_headers"`
+	Complete    bool            `db:"complete"`
+}
+
+// AddMirror adds a mirror URL to a piece known to Curio. Mirrors are used by ParkPiece, in the order they were
+// added, when downloading from the data URL of the piece fails. Parking of the piece is restarted if it failed.
+func AddMirror(ctx context.Context, db *harmonydb.DB, pieceCID cid.Cid, dataURL string, headers http.Header) error {
+	if headers == nil {
+		headers = http.Header{}
+	}
+	hdrJson, err := json.Marshal(headers)
+	if err != nil {
+		return xerrors.Errorf("marshaling headers: %w", err)
+	}
+
+	n, err := db.Exec(ctx, `INSERT INTO parked_piece_mirrors (piece_id, mirror_index, data_url, data_headers)
+		SELECT pp.id, COALESCE((SELECT MAX(m.mirror_index) + 1 FROM parked_piece_mirrors m WHERE m.piece_id = pp.id), 0), $2, $3
+		FROM parked_pieces pp WHERE pp.piece_cid = $1
+		ON CONFLICT (piece_id, data_url) DO UPDATE SET data_headers = EXCLUDED.data_headers`, pieceCID.String(), dataURL, hdrJson)
+	if err != nil {
+		return xerrors.Errorf("adding mirror: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("piece %s not found in parked pieces", pieceCID)
+	}
+
+	// restart parking of pieces whose ParkPiece task ran out of retries, so that the new mirror gets used
+	_, err = db.Exec(ctx, `UPDATE parked_pieces SET task_id = NULL
+		WHERE piece_cid = $1 AND complete = FALSE AND task_id IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM harmony_task WHERE id = parked_pieces.task_id)`, pieceCID.String())
+	if err != nil {
+		return xerrors.Errorf("restarting piece parking: %w", err)
+	}
+	return nil
+}
+
+// RemoveMirror removes a mirror URL of a piece.
+func RemoveMirror(ctx context.Context, db *harmonydb.DB, pieceCID cid.Cid, dataURL string) error {
+	n, err := db.Exec(ctx, `DELETE FROM parked_piece_mirrors m USING parked_pieces pp
+		WHERE m.piece_id = pp.id AND pp.piece_cid = $1 AND m.data_url = $2`, pieceCID.String(), dataURL)
+	if err != nil {
+		return xerrors.Errorf("removing mirror: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("mirror %s of piece %s not found", dataURL, pieceCID)
+	}
+	return nil
+}
+
+// ListMirrors lists the mirrors of all pieces, or of one piece when pieceCID is defined.
+func ListMirrors(ctx context.Context, db *harmonydb.DB, pieceCID cid.Cid) ([]Mirror, error) {
+	var pcid *string
+	if pieceCID.Defined() {
+		s := pieceCID.String()
+		pcid = &s
+	}
+
+	var out []Mirror
+	err := db.Select(ctx, &out, `SELECT pp.piece_cid, m.mirror_index, m.data_url, m.data_headers, pp.complete
+		FROM parked_piece_mirrors m JOIN parked_pieces pp ON pp.id = m.piece_id
+		WHERE $1::TEXT IS NULL OR pp.piece_cid = $1
+		ORDER BY pp.piece_cid, m.mirror_index`, pcid)
+	if err != nil {
+		return nil, xerrors.Errorf("listing mirrors: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,92 @@
+package piece
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+// pieceGCRemovable matches parked pieces which can be removed: unreferenced for longer than their grace period
+// and without active holds or a running replication. $2 is the default grace period in seconds.
+const pieceGCRemovable = `parked_pieces.replicate_task_id IS NULL
+	AND NOT EXISTS (SELECT 1 FROM parked_piece_refs WHERE piece_id = parked_pieces.id)
+	AND NOT EXISTS (SELECT 1 FROM parked_piece_holds h WHERE h.piece_id = parked_pieces.id AND (h.hold_until IS NULL OR h.hold_until > current_timestamp))
+	AND COALESCE(parked_pieces.unreferenced_at, parked_pieces.created_at) + COALESCE(parked_pieces.gc_grace_period, make_interval(secs => $2)) <= current_timestamp`
+
+// GCCandidate is an unreferenced parked piece, with the decision whether piece GC removes it
+type GCCandidate struct {
+	PieceID        int64     `db:"id"`
+	PieceCID       string    `db:"piece_cid"`
+	PaddedSize     int64     `db:"piece_padded_size"`
+	UnreferencedAt time.Time `db:"unreferenced_at"`
+	GraceUntil     time.Time `db:"grace_until"`
+	Holds          *string   `db:"holds"`
+
+	Remove bool   `db:"-"`
+	Reason string `db:"-"`
+}
+
+// GCCandidates lists parked pieces without references, and why each of them would be kept or removed
+// with the given default grace period
+func GCCandidates(ctx context.Context, db *harmonydb.DB, grace time.Duration) ([]GCCandidate, error) {
+	var cands []GCCandidate
+	err := db.Select(ctx, &cands, `SELECT p.id, p.piece_cid, p.piece_padded_size,
+			COALESCE(p.unreferenced_at, p.created_at) AS unreferenced_at,
+			COALESCE(p.unreferenced_at, p.created_at) + COALESCE(p.gc_grace_period, make_interval(secs => $1)) AS grace_until,
+			(SELECT string_agg(h.reason, '; ' ORDER BY h.hold_id) FROM parked_piece_holds h
+				WHERE h.piece_id = p.id AND (h.hold_until IS NULL OR h.hold_until > current_timestamp)) AS holds
+		FROM parked_pieces p
+		WHERE p.cleanup_task_id IS NULL AND NOT EXISTS (SELECT 1 FROM parked_piece_refs r WHERE r.piece_id = p.id)
+		ORDER BY p.id`, grace.Seconds())
+	if err != nil {
+		return nil, xerrors.Errorf("listing unreferenced parked pieces: %w", err)
+	}
+
+	now := time.Now()
+	for i := range cands {
+		c := &cands[i]
+		switch {
+		case c.Holds != nil:
+			c.Reason = "held: " + *c.Holds
+		case now.Before(c.GraceUntil):
+			c.Reason = fmt.Sprintf("in grace period until %s", c.GraceUntil.Format(time.RFC3339))
+		default:
+			c.Remove = true
+			c.Reason = fmt.Sprintf("unreferenced since %s", c.UnreferencedAt.Format(time.RFC3339))
+		}
+	}
+
+	return cands, nil
+}
+
+// HoldPiece keeps a parked piece from being removed until the hold is released, or until the given time
+// if until is not nil
+func HoldPiece(ctx context.Context, db *harmonydb.DB, pieceID int64, reason string, until *time.Time) (int64, error) {
+	if reason == "" {
+		return 0, xerrors.Errorf("hold reason is required")
+	}
+
+	var holdID int64
+	err := db.QueryRow(ctx, `INSERT INTO parked_piece_holds (piece_id, reason, hold_until) VALUES ($1, $2, $3) RETURNING hold_id`,
+		pieceID, reason, until).Scan(&holdID)
+	if err != nil {
+		return 0, xerrors.Errorf("adding parked piece hold: %w", err)
+	}
+	return holdID, nil
+}
+
+// ReleasePieceHold removes a parked piece hold
+func ReleasePieceHold(ctx context.Context, db *harmonydb.DB, holdID int64) error {
+	n, err := db.Exec(ctx, `DELETE FROM parked_piece_holds WHERE hold_id = $1`, holdID)
+	if err != nil {
+		return xerrors.Errorf("removing parked piece hold: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("hold %d not found", holdID)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package piece
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+)
+
+// CheckReplicasTask periodically counts the live copies of parked pieces and schedules ReplicatePiece tasks for
+// pieces with too few of them. It is a singleton, so only one node in the cluster runs the check per interval.
+type CheckReplicasTask struct {
+	rt *ReplicatePieceTask
+}
+
+func NewCheckReplicasTask(rt *ReplicatePieceTask) *CheckReplicasTask {
+	return &CheckReplicasTask{rt: rt}
+}
+
+func (c *CheckReplicasTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	if err := c.rt.checkReplicas(context.Background()); err != nil {
+		return false, xerrors.Errorf("checking parked piece replicas: %w", err)
+	}
+
+	return true, nil
+}
+
+func (c *CheckReplicasTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (c *CheckReplicasTask) TypeDetails() harmonytask.TaskTypeDetails {
+	var interval time.Duration
+	if c.rt != nil {
+		interval = time.Duration(c.rt.cfg.CheckInterval)
+	}
+
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "CheckReplicas",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		IAmBored: harmonytask.SingletonTaskAdder(interval, c),
+	}
+}
+
+func (c *CheckReplicasTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ harmonytask.TaskInterface = &CheckReplicasTask{}
+var _ = harmonytask.Reg(&CheckReplicasTask{})
@@ -20,14 +20,20 @@ type CleanupPieceTask struct {
 	db  *harmonydb.DB
 	sc  *ffi.SealCalls
 
+	grace  time.Duration
+	dryRun bool
+
 	TF promise.Promise[harmonytask.AddTaskFunc]
 }
 
-func NewCleanupPieceTask(db *harmonydb.DB, sc *ffi.SealCalls, max int) *CleanupPieceTask {
+func NewCleanupPieceTask(db *harmonydb.DB, sc *ffi.SealCalls, max int, grace time.Duration, dryRun bool) *CleanupPieceTask {
 	pt := &CleanupPieceTask{
 		db: db,
 		sc: sc,
 
+		grace:  grace,
+		dryRun: dryRun,
+
 		max: max,
 	}
 	go pt.pollCleanupTasks(context.Background())
@@ -35,19 +41,33 @@ func NewCleanupPieceTask(db *harmonydb.DB, sc *ffi.SealCalls, max int) *CleanupP
 }
 
 func (c *CleanupPieceTask) pollCleanupTasks(ctx context.Context) {
+	// pieces already reported in dry-run mode
+	reported := map[int64]struct{}{}
+
 	for {
 		// select pieces with no refs and null cleanup_task_id
-		var pieceIDs []struct {
-			ID storiface.PieceNumber `db:"id"`
-		}
-
-		err := c.db.Select(ctx, &pieceIDs, `SELECT id FROM parked_pieces WHERE cleanup_task_id IS NULL AND (SELECT count(*) FROM parked_piece_refs WHERE piece_id = parked_pieces.id) = 0`)
+		cands, err := GCCandidates(ctx, c.db, c.grace)
 		if err != nil {
 			log.Errorf("failed to get parked pieces: %s", err)
 			time.Sleep(PieceParkPollInterval)
 			continue
 		}
 
+		var pieceIDs []int64
+		for _, cand := range cands {
+			if !cand.Remove {
+				continue
+			}
+			if c.dryRun {
+				if _, ok := reported[cand.PieceID]; !ok {
+					log.Infow("piece GC dry run: would remove parked piece", "piece_id", cand.PieceID, "piece_cid", cand.PieceCID, "reason", cand.Reason)
+					reported[cand.PieceID] = struct{}{}
+				}
+				continue
+			}
+			pieceIDs = append(pieceIDs, cand.PieceID)
+		}
+
 		if len(pieceIDs) == 0 {
 			time.Sleep(PieceParkPollInterval)
 			continue
@@ -59,7 +79,7 @@ func (c *CleanupPieceTask) pollCleanupTasks(ctx context.Context) {
 			// create a task for each piece
 			c.TF.Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, err error) {
 				// update
-				n, err := tx.Exec(`UPDATE parked_pieces SET cleanup_task_id = $1 WHERE id = $2 AND (SELECT count(*) FROM parked_piece_refs WHERE piece_id = parked_pieces.id) = 0`, id, pieceID.ID)
+				n, err := tx.Exec(`UPDATE parked_pieces SET cleanup_task_id = $1 WHERE id = $3 AND `+pieceGCRemovable, id, c.grace.Seconds(), pieceID)
 				if err != nil {
 					return false, xerrors.Errorf("updating parked piece: %w", err)
 				}
@@ -82,10 +102,10 @@ func (c *CleanupPieceTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 		return false, xerrors.Errorf("query parked_piece: %w", err)
 	}
 
-	// delete from parked_pieces where id = $1 where ref count = 0
+	// delete from parked_pieces where id = $1 where ref count = 0, the grace period passed and there are no holds
 	// note: we delete from the db first because that guarantees that the piece is no longer in use
 	// if storage delete fails, it will be retried later is other cleanup tasks
-	n, err := c.db.Exec(ctx, "DELETE FROM parked_pieces WHERE id = $1 AND (SELECT count(*) FROM parked_piece_refs WHERE piece_id = $1) = 0", pieceID)
+	n, err := c.db.Exec(ctx, `DELETE FROM parked_pieces WHERE id = $1 AND `+pieceGCRemovable, pieceID, c.grace.Seconds())
 	if err != nil {
 		return false, xerrors.Errorf("delete parked_piece: %w", err)
 	}
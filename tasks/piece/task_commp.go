@@ -0,0 +1,290 @@
+package piece
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/dealdata"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/proof"
+)
+
+const CommPSchedInterval = 10 * time.Second
+
+// CommPTask calculates the piece commitment of data requested with RequestCommP, along with its sha256, which is
+// cached. Requests for data in a storage path are only taken by nodes the path is attached to, other requests by
+// any node with the task enabled.
+type CommPTask struct {
+	db    *harmonydb.DB
+	lstor *paths.Local
+	max   int
+}
+
+func NewCommPTask(db *harmonydb.DB, lstor *paths.Local, max int) *CommPTask {
+	return &CommPTask{
+		db:    db,
+		lstor: lstor,
+		max:   max,
+	}
+}
+
+func (c *CommPTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var reqs []struct {
+		RequestID   int64           `db:"request_id"`
+		DataURL     *string         `db:"data_url"`
+		DataHeaders json.RawMessage `db:"data_headers"`
+		StorageID   *string         `db:"storage_id"`
+		StorageFile *string         `db:"storage_file"`
+		RawSize     *int64          `db:"raw_size"`
+		ContentHash *string         `db:"content_hash"`
+	}
+	err = c.db.Select(ctx, &reqs, `SELECT request_id, data_url, data_headers, storage_id, storage_file, raw_size, content_hash
+		FROM commp_requests WHERE task_id = $1 AND complete_time IS NULL`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting commp request: %w", err)
+	}
+	if len(reqs) != 1 {
+		return false, xerrors.Errorf("expected 1 commp request, got %d", len(reqs))
+	}
+	req := reqs[0]
+
+	// the same data may have been seen since the request was made
+	if req.ContentHash != nil {
+		n, err := c.db.Exec(ctx, `UPDATE commp_requests r SET piece_cid = cc.piece_cid, piece_size = cc.piece_size, raw_size = cc.raw_size,
+				cached = TRUE, complete_time = current_timestamp
+			FROM commp_cache cc
+			WHERE r.request_id = $1 AND cc.content_hash = r.content_hash AND (r.raw_size IS NULL OR r.raw_size = cc.raw_size)`, req.RequestID)
+		if err != nil {
+			return false, xerrors.Errorf("checking commp cache: %w", err)
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+
+	var data io.ReadCloser
+	switch {
+	case req.StorageID != nil:
+		data, err = c.openStorageFile(ctx, *req.StorageID, *req.StorageFile)
+	case req.DataURL != nil:
+		var hdrs http.Header
+		if err := json.Unmarshal(req.DataHeaders, &hdrs); err != nil {
+			return false, xerrors.Errorf("parsing data headers: %w", err)
+		}
+		data, err = openURL(ctx, *req.DataURL, hdrs, req.RawSize)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer data.Close() // nolint:errcheck
+
+	start := time.Now()
+
+	cw := new(proof.DataCidWriter)
+	hw := sha256.New()
+	n, err := io.CopyBuffer(io.MultiWriter(cw, hw), data, make([]byte, proof.CommPBuf))
+	if err != nil {
+		return false, xerrors.Errorf("reading data: %w", err)
+	}
+
+	dc, err := cw.Sum()
+	if err != nil {
+		return false, xerrors.Errorf("computing commp: %w", err)
+	}
+	contentHash := hex.EncodeToString(hw.Sum(nil))
+
+	// data which doesn't match what was requested fails the request, reading it again won't help
+	var reqErr *string
+	switch {
+	case req.RawSize != nil && *req.RawSize != n:
+		e := xerrors.Errorf("data is %d bytes, expected %d", n, *req.RawSize).Error()
+		reqErr = &e
+	case req.ContentHash != nil && *req.ContentHash != contentHash:
+		e := xerrors.Errorf("data has sha256 %s, expected %s", contentHash, *req.ContentHash).Error()
+		reqErr = &e
+	}
+	if reqErr != nil {
+		_, err = c.db.Exec(ctx, `UPDATE commp_requests SET error = $2, complete_time = current_timestamp WHERE request_id = $1`, req.RequestID, *reqErr)
+		if err != nil {
+			return false, xerrors.Errorf("recording commp request error: %w", err)
+		}
+		return true, nil
+	}
+
+	log.Infow("computed commp", "request", req.RequestID, "piece_cid", dc.PieceCID, "raw_size", n, "took", time.Since(start),
+		"MiB/s", float64(n)/(1<<20)/time.Since(start).Seconds())
+
+	_, err = c.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`INSERT INTO commp_cache (content_hash, raw_size, piece_cid, piece_size) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (content_hash) DO NOTHING`, contentHash, n, dc.PieceCID.String(), int64(dc.PieceSize))
+		if err != nil {
+			return false, xerrors.Errorf("caching commp: %w", err)
+		}
+
+		_, err = tx.Exec(`UPDATE commp_requests SET piece_cid = $2, piece_size = $3, raw_size = $4, content_hash = $5,
+				complete_time = current_timestamp
+			WHERE request_id = $1`, req.RequestID, dc.PieceCID.String(), int64(dc.PieceSize), n, contentHash)
+		if err != nil {
+			return false, xerrors.Errorf("recording commp: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *CommPTask) openStorageFile(ctx context.Context, storageID, file string) (io.ReadCloser, error) {
+	locals, err := c.lstor.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	for _, l := range locals {
+		if string(l.ID) != storageID {
+			continue
+		}
+
+		f, err := os.Open(filepath.Join(l.LocalPath, file))
+		if err != nil {
+			return nil, xerrors.Errorf("opening %s in storage path %s: %w", file, storageID, err)
+		}
+		return f, nil
+	}
+
+	return nil, xerrors.Errorf("storage path %s is not attached to this node", storageID)
+}
+
+// openURL reads data from an URL, with retries resuming the download when the size of the data is known
+func openURL(ctx context.Context, u string, hdrs http.Header, rawSize *int64) (io.ReadCloser, error) {
+	if rawSize != nil {
+		return dealdata.NewUrlReader(ctx, u, hdrs, *rawSize), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("creating request: %w", err)
+	}
+	if hdrs != nil {
+		req.Header = hdrs.Clone()
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("requesting %s: %w", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, xerrors.Errorf("requesting %s: status %d", u, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// CanAccept takes requests for data in storage paths attached to this node first, then requests for data at URLs
+func (c *CommPTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ctx := context.Background()
+
+	var tasks []struct {
+		TaskID    harmonytask.TaskID `db:"task_id"`
+		StorageID *string            `db:"storage_id"`
+	}
+	err := c.db.Select(ctx, &tasks, `SELECT task_id, storage_id FROM commp_requests WHERE task_id = ANY($1)`, ids)
+	if err != nil {
+		return nil, xerrors.Errorf("getting commp requests: %w", err)
+	}
+
+	locals, err := c.lstor.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	local := map[string]bool{}
+	for _, l := range locals {
+		local[string(l.ID)] = true
+	}
+
+	var anyURL *harmonytask.TaskID
+	for _, t := range tasks {
+		t := t
+		if t.StorageID == nil {
+			if anyURL == nil {
+				anyURL = &t.TaskID
+			}
+			continue
+		}
+		if local[*t.StorageID] {
+			return &t.TaskID, nil
+		}
+	}
+	return anyURL, nil
+}
+
+func (c *CommPTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(c.max),
+		Name: "CommP",
+		Cost: resources.Resources{
+			// the commp writer hashes leaves in parallel, with a buffer per CPU
+			Cpu: 4,
+			Ram: 1 << 30,
+		},
+		MaxFailures: 5,
+		IAmBored:    passcall.Every(CommPSchedInterval, c.schedule),
+	}
+}
+
+func (c *CommPTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (c *CommPTask) schedule(taskFunc harmonytask.AddTaskFunc) error {
+	var stop bool
+	for !stop {
+		taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			stop = true
+
+			var reqs []struct {
+				RequestID int64 `db:"request_id"`
+			}
+			// data in storage paths waits until the path is attached to a node
+			err := tx.Select(&reqs, `SELECT request_id FROM commp_requests r WHERE complete_time IS NULL AND task_id IS NULL
+				  AND (storage_id IS NULL OR EXISTS (SELECT 1 FROM storage_path sp WHERE sp.storage_id = r.storage_id))
+				ORDER BY request_id LIMIT 1`)
+			if err != nil {
+				return false, xerrors.Errorf("getting commp requests: %w", err)
+			}
+			if len(reqs) == 0 {
+				return false, nil
+			}
+
+			_, err = tx.Exec(`UPDATE commp_requests SET task_id = $1 WHERE request_id = $2`, id, reqs[0].RequestID)
+			if err != nil {
+				return false, xerrors.Errorf("updating task id: %w", err)
+			}
+
+			stop = false
+			return true, nil
+		})
+	}
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&CommPTask{})
+var _ harmonytask.TaskInterface = &CommPTask{}
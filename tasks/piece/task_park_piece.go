@@ -3,13 +3,18 @@ package piece
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-state-types/abi"
+
 	"github.com/filecoin-project/curio/harmony/harmonydb"
 	"github.com/filecoin-project/curio/harmony/harmonytask"
 	"github.com/filecoin-project/curio/harmony/resources"
@@ -136,11 +141,17 @@ func (p *ParkPieceTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (d
 		DataHeaders json.RawMessage `db:"data_headers"`
 	}
 
-	// Now, select the first reference data that has a URL.
+	// Now, select the reference data that has a URL, followed by the mirrors of the piece.
 	err = p.db.Select(ctx, &refData, `
-        SELECT data_url, data_headers
-        FROM parked_piece_refs
-        WHERE piece_id = $1 AND data_url IS NOT NULL`, pieceData.PieceID)
+        SELECT data_url, data_headers FROM (
+            SELECT data_url, data_headers, 0 AS kind, ref_id AS ord
+            FROM parked_piece_refs
+            WHERE piece_id = $1 AND data_url IS NOT NULL AND data_url != ''
+            UNION ALL
+            SELECT data_url, data_headers, 1 AS kind, mirror_index AS ord
+            FROM parked_piece_mirrors
+            WHERE piece_id = $1
+        ) s ORDER BY kind, ord`, pieceData.PieceID)
 	if err != nil {
 		return false, xerrors.Errorf("fetching reference data: %w", err)
 	}
@@ -155,34 +166,79 @@ func (p *ParkPieceTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (d
 		return false, xerrors.Errorf("parsing piece raw size: %w", err)
 	}
 
+	pieceCID, err := cid.Parse(pieceData.PieceCID)
+	if err != nil {
+		return false, xerrors.Errorf("parsing piece cid: %w", err)
+	}
+
+	var sources []dealdata.PieceSource
+	for _, ref := range refData {
+		var hdrs http.Header
+		if err := json.Unmarshal(ref.DataHeaders, &hdrs); err != nil {
+			return false, xerrors.Errorf("parsing data headers of %s: %w", ref.DataURL, err)
+		}
+		sources = append(sources, dealdata.PieceSource{Url: ref.DataURL, Headers: hdrs})
+	}
+
+	pnum := storiface.PieceNumber(pieceData.PieceID)
+
 	var merr error
 
-	for i := range refData {
-		if refData[i].DataURL != "" {
-			upr := dealdata.NewUrlReader(refData[i].DataURL, pieceRawSize)
-			defer func() {
-				_ = upr.Close()
-			}()
+	// The reader fails over to the following sources when one fails. Data which doesn't match the piece CID can't
+	// be attributed to a single source, so the download is repeated starting from each of the sources in turn.
+	for i := range sources {
+		rotated := append(append([]dealdata.PieceSource{}, sources[i:]...), sources[:i]...)
 
-			pnum := storiface.PieceNumber(pieceData.PieceID)
+		upr := dealdata.NewUrlReader(ctx, rotated[0].Url, rotated[0].Headers, pieceRawSize, rotated[1:]...)
+		data := dealdata.NewPieceVerifyReader(upr, pieceRawSize, pieceCID, abi.PaddedPieceSize(pieceData.PiecePaddedSize))
 
-			if err := p.sc.WritePiece(ctx, &taskID, pnum, pieceRawSize, upr); err != nil {
-				merr = multierror.Append(merr, xerrors.Errorf("write piece: %w", err))
+		start := time.Now()
+		err := p.sc.WritePiece(ctx, &taskID, pnum, pieceRawSize, data)
+		_ = upr.Close()
+		p.recordTransfer(ctx, taskID, pieceData.PieceID, pieceData.PieceCID, upr.ReadSoFar(), start, err == nil)
+		if err != nil {
+			merr = multierror.Append(merr, xerrors.Errorf("write piece: %w", err))
+			if errors.Is(err, dealdata.ErrPieceCIDMismatch) {
 				continue
 			}
 
-			// Update the piece as complete after a successful write.
-			_, err = p.db.Exec(ctx, `UPDATE parked_pieces SET complete = TRUE, task_id = NULL WHERE id = $1`, pieceData.PieceID)
-			if err != nil {
-				return false, xerrors.Errorf("marking piece as complete: %w", err)
-			}
+			// all sources were tried by the reader
+			break
+		}
 
-			return true, nil
+		// Update the piece as complete after a successful write.
+		_, err = p.db.Exec(ctx, `UPDATE parked_pieces SET complete = TRUE, task_id = NULL WHERE id = $1`, pieceData.PieceID)
+		if err != nil {
+			return false, xerrors.Errorf("marking piece as complete: %w", err)
 		}
+
+		// deals for the same piece which were added with their own data URL can read the parked data now
+		linked, err := LinkParkedPiece(ctx, p.db, pieceCID, pieceData.PieceID)
+		if err != nil {
+			log.Errorw("linking parked piece to pending deals", "piece_cid", pieceCID, "error", err)
+		} else if linked > 0 {
+			log.Infow("linked parked piece to pending deals", "piece_cid", pieceCID, "deals", linked)
+		}
+
+		return true, nil
 	}
 
-	// If no URL is found, this indicates an issue since at least one URL is expected.
-	return false, xerrors.Errorf("no suitable data URL found for piece_id %d: %w", pieceData.PieceID, merr)
+	return false, xerrors.Errorf("fetching data of piece_id %d from %d sources: %w", pieceData.PieceID, len(sources), merr)
+}
+
+// recordTransfer records the data received by a transfer attempt in piece_transfers, for the client, SP and deal of
+// the first ref of the piece added by a market adapter
+func (p *ParkPieceTask) recordTransfer(ctx context.Context, taskID harmonytask.TaskID, pieceID int64, pieceCID string, bytes int64, start time.Time, success bool) {
+	_, err := p.db.Exec(ctx, `INSERT INTO piece_transfers (task_id, piece_id, piece_cid, machine, client, sp_id, deal_id, bytes, start_time, end_time, success)
+		SELECT $1, $2, $3, hm.host_and_port, r.client, r.sp_id, r.deal_id, $4, $5, CURRENT_TIMESTAMP, $6
+		FROM harmony_task t
+			INNER JOIN harmony_machines hm ON hm.id = t.owner_id
+			LEFT JOIN LATERAL (SELECT client, sp_id, deal_id FROM parked_piece_refs
+				WHERE piece_id = $2 ORDER BY client IS NULL, ref_id LIMIT 1) r ON TRUE
+		WHERE t.id = $1`, taskID, pieceID, pieceCID, bytes, start, success)
+	if err != nil {
+		log.Errorw("recording piece transfer", "task_id", taskID, "piece_id", pieceID, "error", err)
+	}
 }
 
 func (p *ParkPieceTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
@@ -0,0 +1,299 @@
+package piece
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/promise"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// ReplicatePieceTask keeps Storage.PieceReplication.Copies copies of referenced parked pieces. Live copies are
+// counted from the storage index, ignoring degraded paths and paths which stopped sending heartbeats. Pieces
+// with missing copies get a task, which copies the piece into a local path of the node running it.
+type ReplicatePieceTask struct {
+	db    *harmonydb.DB
+	stor  *paths.Remote
+	lstor *paths.Local
+	index paths.SectorIndex
+
+	cfg config.StoragePieceReplicationConfig
+	max int
+
+	TF promise.Promise[harmonytask.AddTaskFunc]
+}
+
+func NewReplicatePieceTask(db *harmonydb.DB, stor *paths.Remote, lstor *paths.Local, index paths.SectorIndex, cfg config.StoragePieceReplicationConfig, max int) *ReplicatePieceTask {
+	return &ReplicatePieceTask{
+		db:    db,
+		stor:  stor,
+		lstor: lstor,
+		index: index,
+
+		cfg: cfg,
+		max: max,
+	}
+}
+
+// checkReplicas records the number of live copies of every complete parked piece, and schedules replication
+// of referenced pieces with too few copies
+func (r *ReplicatePieceTask) checkReplicas(ctx context.Context) error {
+	// with DistinctMachines, paths attached to the same set of nodes have the same urls and count once
+	_, err := r.db.Exec(ctx, `UPDATE parked_pieces p SET replicas = c.n, replicas_checked_at = current_timestamp
+		FROM (
+			SELECT pp.id, COUNT(DISTINCT CASE WHEN $3 THEN sp.urls ELSE sp.storage_id END) AS n
+			FROM parked_pieces pp
+			LEFT JOIN sector_location sl ON sl.miner_id = 0 AND sl.sector_num = pp.id AND sl.sector_filetype = $1
+			LEFT JOIN storage_path sp ON sp.storage_id = sl.storage_id AND NOT sp.degraded
+				AND NOW() - ($2 * INTERVAL '1 second') < sp.last_heartbeat
+			WHERE pp.complete
+			GROUP BY pp.id
+		) c
+		WHERE p.id = c.id`, int(storiface.FTPiece), paths.SkippedHeartbeatThresh.Seconds(), r.cfg.DistinctMachines)
+	if err != nil {
+		return xerrors.Errorf("counting piece copies: %w", err)
+	}
+
+	// replications which failed too many times don't keep the piece from being replicated again, or removed
+	_, err = r.db.Exec(ctx, `UPDATE parked_pieces SET replicate_task_id = NULL
+		WHERE replicate_task_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM harmony_task WHERE id = replicate_task_id)`)
+	if err != nil {
+		return xerrors.Errorf("clearing failed replications: %w", err)
+	}
+
+	var pieces []struct {
+		ID       int64 `db:"id"`
+		Replicas int   `db:"replicas"`
+	}
+	err = r.db.Select(ctx, &pieces, `SELECT id, replicas FROM parked_pieces
+		WHERE complete AND replicas < $1 AND replicate_task_id IS NULL AND cleanup_task_id IS NULL
+		  AND EXISTS (SELECT 1 FROM parked_piece_refs WHERE piece_id = parked_pieces.id)`, r.cfg.Copies)
+	if err != nil {
+		return xerrors.Errorf("getting under-replicated pieces: %w", err)
+	}
+
+	for _, p := range pieces {
+		if p.Replicas == 0 {
+			log.Errorw("parked piece has no live copies left", "piece_id", p.ID)
+			continue
+		}
+
+		pieceID := p.ID
+		r.TF.Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, err error) {
+			n, err := tx.Exec(`UPDATE parked_pieces SET replicate_task_id = $1
+				WHERE id = $2 AND replicate_task_id IS NULL AND cleanup_task_id IS NULL`, id, pieceID)
+			if err != nil {
+				return false, xerrors.Errorf("updating parked piece: %w", err)
+			}
+
+			// commit only if we updated the piece
+			return n > 0, nil
+		})
+	}
+
+	return nil
+}
+
+func (r *ReplicatePieceTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var pieces []struct {
+		ID         int64 `db:"id"`
+		PaddedSize int64 `db:"piece_padded_size"`
+	}
+	err = r.db.Select(ctx, &pieces, `SELECT id, piece_padded_size FROM parked_pieces WHERE replicate_task_id = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting parked piece: %w", err)
+	}
+	if len(pieces) != 1 {
+		return false, xerrors.Errorf("expected 1 parked piece, got %d", len(pieces))
+	}
+	pnum := storiface.PieceNumber(pieces[0].ID)
+
+	dst, src, err := r.replicaTarget(ctx, pnum, pieces[0].PaddedSize)
+	if err != nil {
+		return false, err
+	}
+	if dst == nil {
+		return false, xerrors.Errorf("no local path can hold another copy of piece %d", pnum)
+	}
+
+	if err := r.replicate(ctx, pnum, *dst, src); err != nil {
+		return false, xerrors.Errorf("copying piece %d to %s: %w", pnum, dst.ID, err)
+	}
+
+	log.Infow("replicated parked piece", "piece_id", pnum, "storage", dst.ID)
+
+	_, err = r.db.Exec(ctx, `UPDATE parked_pieces SET replicate_task_id = NULL, replicas = COALESCE(replicas, 0) + 1 WHERE id = $1`, int64(pnum))
+	if err != nil {
+		return false, xerrors.Errorf("marking replication complete: %w", err)
+	}
+
+	return true, nil
+}
+
+// replicate writes a copy of the piece into dst, copying from src when it is a local path holding a copy,
+// fetching from another node otherwise
+func (r *ReplicatePieceTask) replicate(ctx context.Context, pnum storiface.PieceNumber, dst storiface.StoragePath, src *storiface.StoragePath) error {
+	ref := pnum.Ref()
+	dstPath := filepath.Join(dst.LocalPath, storiface.FTPiece.String(), storiface.SectorName(ref.ID))
+
+	var ids storiface.SectorPaths
+	storiface.SetPathByType(&ids, storiface.FTPiece, string(dst.ID))
+
+	// like WritePiece this doesn't reserve space, reservations are sized by the proof type which for pieces is
+	// always 64GiB. replicaTarget only picks paths with space for the piece.
+
+	if src == nil {
+		var pws storiface.PathsWithIDs
+		pws.IDs = ids
+		storiface.SetPathByType(&pws.Paths, storiface.FTPiece, dstPath)
+
+		// the fetch declares the new copy in the index
+		_, _, err := r.stor.AcquireSector(ctx, ref, storiface.FTPiece, storiface.FTNone, storiface.PathSealing, storiface.AcquireCopy, storiface.AcquireInto(pws))
+		if err != nil {
+			return xerrors.Errorf("fetching piece: %w", err)
+		}
+		return nil
+	}
+
+	srcPath := filepath.Join(src.LocalPath, storiface.FTPiece.String(), storiface.SectorName(ref.ID))
+	if err := copyPieceFile(srcPath, dstPath); err != nil {
+		return xerrors.Errorf("copying from %s: %w", src.ID, err)
+	}
+
+	if err := r.index.StorageDeclareSector(ctx, dst.ID, ref.ID, storiface.FTPiece, false); err != nil {
+		return xerrors.Errorf("declaring piece copy: %w", err)
+	}
+	return nil
+}
+
+// replicaTarget picks a local path which doesn't hold a copy of the piece to place a new copy in, in the order
+// of the placement policy. src is a local path holding a copy, if there is one. dst is nil when no local path
+// can take a copy, or, with DistinctMachines, when this node already has one.
+func (r *ReplicatePieceTask) replicaTarget(ctx context.Context, pnum storiface.PieceNumber, size int64) (dst, src *storiface.StoragePath, err error) {
+	copies, err := r.index.StorageFindSector(ctx, pnum.Ref().ID, storiface.FTPiece, 0, false)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("finding piece copies: %w", err)
+	}
+	held := map[storiface.ID]bool{}
+	for _, c := range copies {
+		held[c.ID] = true
+	}
+
+	locals, err := r.lstor.Local(ctx)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	local := map[storiface.ID]storiface.StoragePath{}
+	for _, l := range locals {
+		local[l.ID] = l
+		if held[l.ID] && src == nil {
+			l := l
+			src = &l
+		}
+	}
+
+	if src != nil && r.cfg.DistinctMachines {
+		return nil, src, nil
+	}
+
+	cands, err := r.index.StorageBestAlloc(ctx, storiface.FTPiece, abi.SectorSize(size), storiface.PathSealing, paths.NoMinerFilter)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("finding paths for a piece copy: %w", err)
+	}
+	for _, c := range cands {
+		l, ok := local[c.ID]
+		if !ok || held[c.ID] || !storiface.FTPiece.Allowed(c.AllowTypes, c.DenyTypes) {
+			continue
+		}
+		return &l, src, nil
+	}
+
+	return nil, src, nil
+}
+
+// copyPieceFile copies a piece file through a temporary file, so that a partial copy is never found in dst
+func copyPieceFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close() // nolint:errcheck
+
+	tmp := dst + storiface.TempSuffix
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyBuffer(out, in, make([]byte, 8<<20)); err != nil {
+		_ = out.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dst)
+}
+
+func (r *ReplicatePieceTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ctx := context.Background()
+
+	for _, id := range ids {
+		var pieces []struct {
+			ID         int64 `db:"id"`
+			PaddedSize int64 `db:"piece_padded_size"`
+		}
+		err := r.db.Select(ctx, &pieces, `SELECT id, piece_padded_size FROM parked_pieces WHERE replicate_task_id = $1`, id)
+		if err != nil {
+			return nil, xerrors.Errorf("getting parked piece: %w", err)
+		}
+		if len(pieces) != 1 {
+			continue
+		}
+
+		dst, _, err := r.replicaTarget(ctx, storiface.PieceNumber(pieces[0].ID), pieces[0].PaddedSize)
+		if err != nil {
+			return nil, err
+		}
+		if dst != nil {
+			return &id, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *ReplicatePieceTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(r.max),
+		Name: "ReplicatePiece",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		MaxFailures: 10,
+	}
+}
+
+func (r *ReplicatePieceTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+	r.TF.Set(taskFunc)
+}
+
+var _ harmonytask.TaskInterface = &ReplicatePieceTask{}
+var _ = harmonytask.Reg(&ReplicatePieceTask{})
@@ -0,0 +1,87 @@
+package pledge
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/builtin"
+	miner12 "github.com/filecoin-project/go-state-types/builtin/v12/miner"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+// Schedule is the CC pledge schedule of a miner
+type Schedule struct {
+	SpID           int64      `db:"sp_id"`
+	SectorsPerDay  int64      `db:"sectors_per_day"`
+	MaxPipeline    int64      `db:"max_pipeline"`
+	DurationEpochs *int64     `db:"duration_epochs"`
+	LastRun        *time.Time `db:"last_run"`
+	LastPledged    int64      `db:"last_pledged"`
+	LimitedBy      *string    `db:"limited_by"`
+	CreatedAt      time.Time  `db:"created_at"`
+}
+
+// SetSchedule adds or updates the CC pledge schedule of a miner. durationDays is the commitment duration of pledged
+// sectors, 0 for the default.
+func SetSchedule(ctx context.Context, db *harmonydb.DB, maddr address.Address, sectorsPerDay, maxPipeline, durationDays int64) error {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner id: %w", err)
+	}
+	if sectorsPerDay <= 0 || maxPipeline <= 0 {
+		return xerrors.Errorf("sectors per day and max pipeline must be positive")
+	}
+
+	var duration *int64
+	if durationDays > 0 {
+		d := durationDays * builtin.EpochsInDay
+		if d > int64(miner12.MaxSectorExpirationExtension) {
+			return xerrors.Errorf("duration exceeds max allowed: %d > %d", d, miner12.MaxSectorExpirationExtension)
+		}
+		if d < int64(miner12.MinSectorExpiration) {
+			return xerrors.Errorf("duration is too short: %d < %d", d, miner12.MinSectorExpiration)
+		}
+		duration = &d
+	}
+
+	_, err = db.Exec(ctx, `INSERT INTO cc_pledge_schedules (sp_id, sectors_per_day, max_pipeline, duration_epochs)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (sp_id) DO UPDATE SET sectors_per_day = excluded.sectors_per_day, max_pipeline = excluded.max_pipeline,
+			duration_epochs = excluded.duration_epochs`, int64(mid), sectorsPerDay, maxPipeline, duration)
+	if err != nil {
+		return xerrors.Errorf("setting pledge schedule: %w", err)
+	}
+	return nil
+}
+
+// RemoveSchedule stops pledging CC sectors for a miner. Sectors already pledged continue through the pipeline.
+func RemoveSchedule(ctx context.Context, db *harmonydb.DB, maddr address.Address) error {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner id: %w", err)
+	}
+
+	n, err := db.Exec(ctx, `DELETE FROM cc_pledge_schedules WHERE sp_id = $1`, int64(mid))
+	if err != nil {
+		return xerrors.Errorf("removing pledge schedule: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("no pledge schedule for %s", maddr)
+	}
+	return nil
+}
+
+// Schedules lists the CC pledge schedules.
+func Schedules(ctx context.Context, db *harmonydb.DB) ([]Schedule, error) {
+	var out []Schedule
+	err := db.Select(ctx, &out, `SELECT sp_id, sectors_per_day, max_pipeline, duration_epochs, last_run, last_pledged, limited_by, created_at
+		FROM cc_pledge_schedules ORDER BY sp_id`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting pledge schedules: %w", err)
+	}
+	return out, nil
+}
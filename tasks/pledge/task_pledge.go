@@ -0,0 +1,286 @@
+package pledge
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	miner12 "github.com/filecoin-project/go-state-types/builtin/v12/miner"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/seal"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var log = logging.Logger("pledge")
+
+const PledgeInterval = 5 * time.Minute
+
+// Reasons for pledging fewer sectors than scheduled, recorded in cc_pledge_schedules.limited_by
+const (
+	LimitedByPause      = "pipeline paused"
+	LimitedByStorage    = "storage"
+	LimitedByCollateral = "collateral"
+)
+
+type PledgeAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
+	StateNetworkVersion(context.Context, types.TipSetKey) (network.Version, error)
+	StateMinerAllocated(context.Context, address.Address, types.TipSetKey) (*bitfield.BitField, error)
+	StateMinerAvailableBalance(context.Context, address.Address, types.TipSetKey) (types.BigInt, error)
+	StateMinerInitialPledgeForSector(ctx context.Context, sectorDuration abi.ChainEpoch, sectorSize abi.SectorSize, verifiedSize uint64, tsk types.TipSetKey) (types.BigInt, error)
+	WalletBalance(context.Context, address.Address) (big.Int, error)
+}
+
+// PledgeTask keeps the sealing pipelines of SPs with a CC pledge schedule busy: every run it adds CC sectors up to
+// the scheduled number of sectors per day, as long as the pipeline is shallower than the schedule's maximum and
+// there is long-term storage and collateral for all sectors in the pipeline.
+type PledgeTask struct {
+	db  *harmonydb.DB
+	api PledgeAPI
+	cfg *config.CurioConfig
+}
+
+func NewPledgeTask(db *harmonydb.DB, api PledgeAPI, cfg *config.CurioConfig) *PledgeTask {
+	return &PledgeTask{
+		db:  db,
+		api: api,
+		cfg: cfg,
+	}
+}
+
+type schedule struct {
+	SpID           int64  `db:"sp_id"`
+	SectorsPerDay  int64  `db:"sectors_per_day"`
+	MaxPipeline    int64  `db:"max_pipeline"`
+	DurationEpochs *int64 `db:"duration_epochs"`
+	Paused         bool   `db:"paused"`
+}
+
+func (p *PledgeTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var schedules []schedule
+	err = p.db.Select(ctx, &schedules, `SELECT s.sp_id, s.sectors_per_day, s.max_pipeline, s.duration_epochs,
+			EXISTS (SELECT 1 FROM sealing_pauses sp WHERE sp.sp_id = s.sp_id) AS paused
+		FROM cc_pledge_schedules s ORDER BY s.sp_id`)
+	if err != nil {
+		return false, xerrors.Errorf("getting pledge schedules: %w", err)
+	}
+
+	for _, s := range schedules {
+		pledged, limitedBy, err := p.pledge(ctx, s)
+		if err != nil {
+			return false, xerrors.Errorf("pledging sectors of f0%d: %w", s.SpID, err)
+		}
+
+		var limit *string
+		if limitedBy != "" {
+			limit = &limitedBy
+		}
+		_, err = p.db.Exec(ctx, `UPDATE cc_pledge_schedules SET last_run = current_timestamp, last_pledged = $2, limited_by = $3
+			WHERE sp_id = $1`, s.SpID, pledged, limit)
+		if err != nil {
+			return false, xerrors.Errorf("updating pledge schedule of f0%d: %w", s.SpID, err)
+		}
+	}
+
+	return true, nil
+}
+
+// pledge adds the CC sectors due for one SP, returning how many were added and why fewer were added than the
+// schedule allows, if they were.
+func (p *PledgeTask) pledge(ctx context.Context, s schedule) (int, string, error) {
+	if s.Paused {
+		return 0, LimitedByPause, nil
+	}
+
+	var pipeline struct {
+		InPipeline   int64 `db:"in_pipeline"`
+		NotStored    int64 `db:"not_stored"`
+		NotCommitted int64 `db:"not_committed"`
+		LastDay      int64 `db:"last_day"`
+	}
+	err := p.db.QueryRow(ctx, `SELECT
+			COUNT(*) FILTER (WHERE NOT failed AND NOT (after_commit_msg_success AND after_move_storage)) AS in_pipeline,
+			COUNT(*) FILTER (WHERE NOT failed AND NOT after_move_storage) AS not_stored,
+			COUNT(*) FILTER (WHERE NOT failed AND NOT after_commit_msg_success) AS not_committed,
+			COUNT(*) FILTER (WHERE create_time > current_timestamp - INTERVAL '1 day') AS last_day
+		FROM sectors_sdr_pipeline WHERE sp_id = $1`, s.SpID).Scan(&pipeline.InPipeline, &pipeline.NotStored, &pipeline.NotCommitted, &pipeline.LastDay)
+	if err != nil {
+		return 0, "", xerrors.Errorf("getting pipeline depth: %w", err)
+	}
+
+	due := min(s.SectorsPerDay-pipeline.LastDay, s.MaxPipeline-pipeline.InPipeline)
+	if due <= 0 {
+		return 0, "", nil
+	}
+
+	maddr, err := address.NewIDAddress(uint64(s.SpID))
+	if err != nil {
+		return 0, "", err
+	}
+
+	head, err := p.api.ChainHead(ctx)
+	if err != nil {
+		return 0, "", xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	mi, err := p.api.StateMinerInfo(ctx, maddr, head.Key())
+	if err != nil {
+		return 0, "", xerrors.Errorf("getting miner info: %w", err)
+	}
+	nv, err := p.api.StateNetworkVersion(ctx, head.Key())
+	if err != nil {
+		return 0, "", xerrors.Errorf("getting network version: %w", err)
+	}
+	synth, err := seal.NewSynthPolicy(p.cfg)
+	if err != nil {
+		return 0, "", err
+	}
+	spt, err := synth.SealProofType(nv, abi.ActorID(s.SpID), mi.WindowPoStProofType)
+	if err != nil {
+		return 0, "", xerrors.Errorf("getting seal proof type: %w", err)
+	}
+
+	var limitedBy string
+
+	// long-term storage for the sealed sectors which aren't in it yet
+	perSector, err := (storiface.FTSealed | storiface.FTCache).StoreSpaceUse(mi.SectorSize)
+	if err != nil {
+		return 0, "", xerrors.Errorf("getting sector space use: %w", err)
+	}
+	var available int64
+	err = p.db.QueryRow(ctx, `SELECT COALESCE(SUM(available), 0) FROM storage_path WHERE can_store = TRUE`).Scan(&available)
+	if err != nil {
+		return 0, "", xerrors.Errorf("getting available storage: %w", err)
+	}
+	if fit := available/int64(perSector) - pipeline.NotStored; fit < due {
+		due = max(fit, 0)
+		limitedBy = LimitedByStorage
+	}
+
+	// collateral for the sectors which aren't committed yet
+	duration := abi.ChainEpoch(miner12.MaxSectorExpirationExtension)
+	if s.DurationEpochs != nil {
+		duration = abi.ChainEpoch(*s.DurationEpochs)
+	}
+	pledge, err := p.api.StateMinerInitialPledgeForSector(ctx, duration, mi.SectorSize, 0, head.Key())
+	if err != nil {
+		return 0, "", xerrors.Errorf("getting initial pledge: %w", err)
+	}
+	funds, err := p.collateralFunds(ctx, maddr, head.Key())
+	if err != nil {
+		return 0, "", err
+	}
+	if !pledge.IsZero() {
+		if fit := big.Div(funds, pledge).Int64() - pipeline.NotCommitted; fit < due {
+			due = max(fit, 0)
+			limitedBy = LimitedByCollateral
+		}
+	}
+
+	if due == 0 {
+		log.Warnw("not pledging sectors", "miner", maddr, "limited_by", limitedBy)
+		return 0, limitedBy, nil
+	}
+
+	numbers, err := seal.AllocateSectorNumbers(ctx, p.api, p.db, maddr, int(due), func(tx *harmonydb.Tx, numbers []abi.SectorNumber) (bool, error) {
+		for _, n := range numbers {
+			_, err := tx.Exec(`INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, user_sector_duration_epochs)
+				VALUES ($1, $2, $3, $4)`, s.SpID, n, spt, s.DurationEpochs)
+			if err != nil {
+				return false, xerrors.Errorf("inserting into sectors_sdr_pipeline: %w", err)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return 0, "", xerrors.Errorf("allocating sector numbers: %w", err)
+	}
+
+	log.Infow("pledged CC sectors", "miner", maddr, "count", len(numbers), "limited_by", limitedBy)
+	return len(numbers), limitedBy, nil
+}
+
+// collateralFunds returns the funds which commit messages of the miner can use for collateral: the available balance
+// of the miner and, unless sending collateral from wallets is disabled, the balance of its commit control wallets.
+func (p *PledgeTask) collateralFunds(ctx context.Context, maddr address.Address, tsk types.TipSetKey) (big.Int, error) {
+	funds, err := p.api.StateMinerAvailableBalance(ctx, maddr, tsk)
+	if err != nil {
+		return big.Zero(), xerrors.Errorf("getting miner available balance: %w", err)
+	}
+
+	if p.cfg.Fees.CollateralFromMinerBalance && p.cfg.Fees.DisableCollateralFallback {
+		return funds, nil
+	}
+
+	for _, addrs := range p.cfg.Addresses {
+		var ours bool
+		for _, m := range addrs.MinerAddresses {
+			a, err := address.NewFromString(m)
+			if err != nil {
+				return big.Zero(), xerrors.Errorf("parsing miner address %s: %w", m, err)
+			}
+			if a == maddr {
+				ours = true
+				break
+			}
+		}
+		if !ours {
+			continue
+		}
+
+		for _, c := range addrs.CommitControl {
+			a, err := address.NewFromString(c)
+			if err != nil {
+				return big.Zero(), xerrors.Errorf("parsing commit control address %s: %w", c, err)
+			}
+			bal, err := p.api.WalletBalance(ctx, a)
+			if err != nil {
+				return big.Zero(), xerrors.Errorf("getting balance of %s: %w", a, err)
+			}
+			funds = big.Add(funds, bal)
+		}
+	}
+
+	return funds, nil
+}
+
+func (p *PledgeTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (p *PledgeTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "CCPledge",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		IAmBored: harmonytask.SingletonTaskAdder(PledgeInterval, p),
+	}
+}
+
+func (p *PledgeTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ = harmonytask.Reg(&PledgeTask{})
+var _ harmonytask.TaskInterface = &PledgeTask{}
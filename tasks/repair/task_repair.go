@@ -0,0 +1,422 @@
+package repair
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+var log = logging.Logger("repair")
+
+const MinSchedInterval = 10 * time.Second
+
+const (
+	DetectedByScrub  = "scrub"
+	DetectedByManual = "manual"
+
+	MethodFetch      = "fetch"
+	MethodRegenerate = "regenerate"
+)
+
+// damagedSuffix is appended to damaged files while they are being replaced
+const damagedSuffix = ".damaged"
+
+// Schedule records a repair of the copy of a sector's files held in a storage path.
+// Scheduling a repair of a copy which already has one pending is a no-op.
+func Schedule(ctx context.Context, db *harmonydb.DB, sid abi.SectorID, storageID storiface.ID, ft storiface.SectorFileType, detectedBy, reason string) error {
+	_, err := db.Exec(ctx, `INSERT INTO sector_repairs (sp_id, sector_number, storage_id, file_types, detected_by, reason)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`, sid.Miner, sid.Number, storageID, int64(ft), detectedBy, reason)
+	if err != nil {
+		return xerrors.Errorf("scheduling repair: %w", err)
+	}
+	return nil
+}
+
+// RepairTask replaces damaged sector file copies queued in sector_repairs. It
+// runs on the node which has the damaged path attached.
+//
+// Damaged copies are replaced with a copy from another path which isn't known
+// to be damaged. Unsealed copies without a good replica are regenerated from the
+// sealed data by the unseal pipeline.
+type RepairTask struct {
+	db    *harmonydb.DB
+	stor  *paths.Remote
+	lstor *paths.Local
+	index paths.SectorIndex
+	max   int
+}
+
+func NewRepairTask(db *harmonydb.DB, stor *paths.Remote, lstor *paths.Local, index paths.SectorIndex, max int) *RepairTask {
+	return &RepairTask{
+		db:    db,
+		stor:  stor,
+		lstor: lstor,
+		index: index,
+		max:   max,
+	}
+}
+
+func (r *RepairTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	tid := int64(taskID)
+	ctx := paths.WithReservationLease(context.Background(), paths.ReservationLeaseInfo{TaskID: &tid, Purpose: "sector repair"})
+
+	var repairs []struct {
+		RepairID     int64  `db:"repair_id"`
+		SpID         int64  `db:"sp_id"`
+		SectorNumber int64  `db:"sector_number"`
+		StorageID    string `db:"storage_id"`
+		FileTypes    int64  `db:"file_types"`
+		RegSealProof int64  `db:"reg_seal_proof"`
+		IsCC         bool   `db:"is_cc"`
+	}
+	err = r.db.Select(ctx, &repairs, `SELECT r.repair_id, r.sp_id, r.sector_number, r.storage_id, r.file_types, sm.reg_seal_proof, sm.is_cc
+		FROM sector_repairs r
+		INNER JOIN sectors_meta sm ON sm.sp_id = r.sp_id AND sm.sector_num = r.sector_number
+		WHERE r.task_id = $1 AND r.complete_time IS NULL`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting repair: %w", err)
+	}
+	if len(repairs) != 1 {
+		return false, xerrors.Errorf("expected 1 repair, got %d", len(repairs))
+	}
+	rp := repairs[0]
+
+	sref := storiface.SectorRef{
+		ID:        abi.SectorID{Miner: abi.ActorID(rp.SpID), Number: abi.SectorNumber(rp.SectorNumber)},
+		ProofType: abi.RegisteredSealProof(rp.RegSealProof),
+	}
+	damaged := storiface.ID(rp.StorageID)
+
+	var method, errStr, sourceID *string
+	for _, f := range storiface.SectorFileType(rp.FileTypes).AllSet() {
+		m, src, err := r.repair(ctx, sref, damaged, f, rp.IsCC)
+		if err != nil {
+			log.Errorw("sector repair failed", "repair", rp.RepairID, "sector", sref.ID, "storage", damaged, "type", f, "error", err)
+			es := xerrors.Errorf("repairing %s: %w", f, err).Error()
+			errStr = &es
+			break
+		}
+
+		method = &m
+		if src != "" {
+			s := string(src)
+			sourceID = &s
+		}
+	}
+
+	_, err = r.db.Exec(ctx, `UPDATE sector_repairs SET method = $2, source_storage_id = $3, error = $4, complete_time = current_timestamp
+		WHERE repair_id = $1`, rp.RepairID, method, sourceID, errStr)
+	if err != nil {
+		return false, xerrors.Errorf("marking repair complete: %w", err)
+	}
+
+	return true, nil
+}
+
+// repair replaces a single damaged file of a sector, returning the repair method
+// and the path a good copy was taken from
+func (r *RepairTask) repair(ctx context.Context, sref storiface.SectorRef, damaged storiface.ID, ft storiface.SectorFileType, isCC bool) (string, storiface.ID, error) {
+	si, err := r.index.StorageInfo(ctx, damaged)
+	if err != nil {
+		return "", "", xerrors.Errorf("getting storage info: %w", err)
+	}
+	if si.Archive {
+		return "", "", xerrors.Errorf("storage path %s is a read-only archive, damaged copies in it can't be replaced", damaged)
+	}
+
+	local, err := r.localPath(ctx, damaged)
+	if err != nil {
+		return "", "", err
+	}
+	dst := filepath.Join(local, ft.String(), storiface.SectorName(sref.ID))
+
+	copies, err := r.index.StorageFindSector(ctx, sref.ID, ft, 0, false)
+	if err != nil {
+		return "", "", xerrors.Errorf("finding sector copies: %w", err)
+	}
+
+	var wasPrimary bool
+	var good []storiface.SectorStorageInfo
+	for _, c := range copies {
+		if c.ID == damaged {
+			wasPrimary = c.Primary
+			continue
+		}
+
+		bad, err := r.knownDamaged(ctx, sref.ID, c.ID)
+		if err != nil {
+			return "", "", err
+		}
+		if !bad {
+			good = append(good, c)
+		}
+	}
+
+	if len(good) == 0 {
+		if ft == storiface.FTUnsealed && !isCC {
+			return MethodRegenerate, "", r.regenerateUnsealed(ctx, sref.ID, damaged, dst)
+		}
+		return "", "", xerrors.Errorf("no good copy of %s found", ft)
+	}
+
+	// move the damaged copy aside so that it isn't found while fetching, and can
+	// be put back if the repair fails
+	if err := os.Rename(dst, dst+damagedSuffix); err != nil && !os.IsNotExist(err) {
+		return "", "", xerrors.Errorf("moving damaged copy aside: %w", err)
+	}
+	if err := r.index.StorageDropSector(ctx, damaged, sref.ID, ft); err != nil {
+		return "", "", xerrors.Errorf("dropping damaged copy from the index: %w", err)
+	}
+
+	src, err := r.replace(ctx, sref, damaged, ft, dst, good)
+	if err != nil {
+		_ = os.RemoveAll(dst)
+		if rerr := os.Rename(dst+damagedSuffix, dst); rerr == nil {
+			if derr := r.index.StorageDeclareSector(ctx, damaged, sref.ID, ft, wasPrimary); derr != nil {
+				log.Errorw("re-declaring damaged copy after failed repair", "sector", sref.ID, "storage", damaged, "error", derr)
+			}
+		}
+		return "", "", err
+	}
+
+	if err := r.index.StorageDeclareSector(ctx, damaged, sref.ID, ft, wasPrimary); err != nil {
+		return "", "", xerrors.Errorf("declaring repaired copy: %w", err)
+	}
+	if err := os.RemoveAll(dst + damagedSuffix); err != nil {
+		log.Warnw("removing damaged copy", "path", dst+damagedSuffix, "error", err)
+	}
+
+	return MethodFetch, src, nil
+}
+
+// replace writes a good copy of the file into dst, copying from another local
+// path when one holds it, fetching from a remote node otherwise. Returns the
+// source path when known.
+func (r *RepairTask) replace(ctx context.Context, sref storiface.SectorRef, into storiface.ID, ft storiface.SectorFileType, dst string, good []storiface.SectorStorageInfo) (storiface.ID, error) {
+	locals, err := r.lstor.Local(ctx)
+	if err != nil {
+		return "", xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	for _, g := range good {
+		for _, l := range locals {
+			if l.ID == g.ID {
+				src := filepath.Join(l.LocalPath, ft.String(), storiface.SectorName(sref.ID))
+				if err := copyPath(src, dst); err != nil {
+					return "", xerrors.Errorf("copying from %s: %w", g.ID, err)
+				}
+				return g.ID, nil
+			}
+		}
+	}
+
+	var ids storiface.SectorPaths
+	storiface.SetPathByType(&ids, ft, string(into))
+
+	release, err := r.lstor.Reserve(ctx, sref, ft, ids, storiface.FsOverheadFinalized, paths.MinFreeStoragePercentage)
+	if err != nil {
+		return "", xerrors.Errorf("reserving space: %w", err)
+	}
+	defer release()
+
+	var pws storiface.PathsWithIDs
+	pws.IDs = ids
+	storiface.SetPathByType(&pws.Paths, ft, dst)
+
+	got, _, err := r.stor.AcquireSector(ctx, sref, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireCopy, storiface.AcquireInto(pws))
+	if err != nil {
+		return "", xerrors.Errorf("fetching good copy: %w", err)
+	}
+	if storiface.PathByType(got, ft) != dst {
+		return "", xerrors.Errorf("another local copy at %s is not known to be good", storiface.PathByType(got, ft))
+	}
+
+	// the fetch goes to whichever remote copy responds first, so the source isn't known here
+	return "", nil
+}
+
+// regenerateUnsealed removes a damaged unsealed copy and requests the sector to
+// be unsealed again, which rebuilds the copy from the sealed data
+func (r *RepairTask) regenerateUnsealed(ctx context.Context, sid abi.SectorID, damaged storiface.ID, dst string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return xerrors.Errorf("removing damaged unsealed copy: %w", err)
+	}
+	if err := r.index.StorageDropSector(ctx, damaged, sid, storiface.FTUnsealed); err != nil {
+		return xerrors.Errorf("dropping damaged copy from the index: %w", err)
+	}
+
+	// updating sectors_meta queues the sector in the unseal pipeline once no unsealed copy is left
+	n, err := r.db.Exec(ctx, `UPDATE sectors_meta SET target_unseal_state = TRUE WHERE sp_id = $1 AND sector_num = $2`, sid.Miner, sid.Number)
+	if err != nil {
+		return xerrors.Errorf("setting target unseal state: %w", err)
+	}
+	if n != 1 {
+		return xerrors.Errorf("sector not found in sectors_meta")
+	}
+
+	return nil
+}
+
+// knownDamaged checks whether a copy of the sector in the path has a pending
+// repair or failed its latest scrub check
+func (r *RepairTask) knownDamaged(ctx context.Context, sid abi.SectorID, id storiface.ID) (bool, error) {
+	var bad bool
+	err := r.db.QueryRow(ctx, `SELECT
+			EXISTS (SELECT 1 FROM sector_repairs WHERE sp_id = $1 AND sector_number = $2 AND storage_id = $3 AND complete_time IS NULL)
+			OR COALESCE((SELECT NOT ok FROM scrub_sealed_checks WHERE sp_id = $1 AND sector_number = $2 AND storage_id = $3 AND complete_time IS NOT NULL
+				ORDER BY complete_time DESC LIMIT 1), FALSE)`, sid.Miner, sid.Number, id).Scan(&bad)
+	if err != nil {
+		return false, xerrors.Errorf("checking copy in %s: %w", id, err)
+	}
+	return bad, nil
+}
+
+func (r *RepairTask) localPath(ctx context.Context, id storiface.ID) (string, error) {
+	locals, err := r.lstor.Local(ctx)
+	if err != nil {
+		return "", xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	for _, p := range locals {
+		if p.ID == id {
+			return p.LocalPath, nil
+		}
+	}
+	return "", xerrors.Errorf("storage path %s is not attached to this node", id)
+}
+
+// copyPath copies a file, or the regular files of a directory
+func copyPath(src, dst string) error {
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	copyFile := func(src, dst string) error {
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close() // nolint:errcheck
+
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyBuffer(out, in, make([]byte, 1<<20)); err != nil {
+			_ = out.Close()
+			return err
+		}
+		return out.Close()
+	}
+
+	if !st.IsDir() {
+		return copyFile(src, dst)
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	ents, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, ent := range ents {
+		if !ent.Type().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, ent.Name()), filepath.Join(dst, ent.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RepairTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ctx := context.Background()
+
+	locals, err := r.lstor.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	localIDs := make([]string, len(locals))
+	for i, p := range locals {
+		localIDs[i] = string(p.ID)
+	}
+
+	var accepted []harmonytask.TaskID
+	err = r.db.Select(ctx, &accepted, `SELECT task_id FROM sector_repairs
+		WHERE task_id = ANY($1) AND storage_id = ANY($2) LIMIT 1`, ids, localIDs)
+	if err != nil {
+		return nil, xerrors.Errorf("getting repairs: %w", err)
+	}
+	if len(accepted) == 0 {
+		return nil, nil
+	}
+
+	return &accepted[0], nil
+}
+
+func (r *RepairTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(r.max),
+		Name: "SectorRepair",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 128 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(MinSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return r.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (r *RepairTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (r *RepairTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var repairs []struct {
+			RepairID int64 `db:"repair_id"`
+		}
+
+		err := tx.Select(&repairs, `SELECT repair_id FROM sector_repairs WHERE task_id IS NULL AND complete_time IS NULL LIMIT 20`)
+		if err != nil {
+			return false, xerrors.Errorf("getting repairs: %w", err)
+		}
+
+		if len(repairs) == 0 {
+			return false, nil
+		}
+
+		// pick at random in case there are a bunch of schedules across the cluster
+		rp := repairs[rand.N(len(repairs))]
+
+		_, err = tx.Exec(`UPDATE sector_repairs SET task_id = $1 WHERE repair_id = $2 AND task_id IS NULL`, id, rp.RepairID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return true, nil
+	})
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&RepairTask{})
+var _ harmonytask.TaskInterface = &RepairTask{}
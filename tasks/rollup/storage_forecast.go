@@ -0,0 +1,172 @@
+package rollup
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+// Storage path classes
+const (
+	PathClassSealStore = "Seal/Store"
+	PathClassSeal      = "Seal"
+	PathClassStore     = "Store"
+	PathClassNone      = "None"
+)
+
+type StorageForecast struct {
+	ID    string // storage ID, or the class for class aggregates
+	Class string
+
+	Capacity  int64
+	Available int64
+
+	// GrowthPerDay is the trend of used bytes per day over the forecast window, negative when usage shrinks.
+	GrowthPerDay float64
+
+	// DaysUntilFull is nil when usage isn't growing or there isn't enough history.
+	DaysUntilFull *float64
+}
+
+type usageSample struct {
+	Bucket    time.Time `db:"bucket"`
+	StorageID string    `db:"storage_id"`
+	Capacity  int64     `db:"capacity"`
+	Available int64     `db:"available"`
+}
+
+func pathClass(canSeal, canStore bool) string {
+	switch {
+	case canSeal && canStore:
+		return PathClassSealStore
+	case canSeal:
+		return PathClassSeal
+	case canStore:
+		return PathClassStore
+	default:
+		return PathClassNone
+	}
+}
+
+// ForecastStorage projects when storage paths fill up from the trend of their usage over the window,
+// per path and per path class. Usage history is recorded hourly by the ChartRollup task.
+func ForecastStorage(ctx context.Context, db *harmonydb.DB, window time.Duration) (paths, classes []StorageForecast, err error) {
+	var current []struct {
+		StorageID string `db:"storage_id"`
+		CanSeal   bool   `db:"can_seal"`
+		CanStore  bool   `db:"can_store"`
+		Capacity  int64  `db:"capacity"`
+		Available int64  `db:"available"`
+	}
+	err = db.Select(ctx, &current, `SELECT storage_id, COALESCE(can_seal, FALSE) AS can_seal, COALESCE(can_store, FALSE) AS can_store,
+			COALESCE(capacity, 0) AS capacity, COALESCE(available, 0) AS available
+		FROM storage_path
+		ORDER BY storage_id`)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting storage paths: %w", err)
+	}
+
+	var samples []usageSample
+	err = db.Select(ctx, &samples, `SELECT bucket, storage_id, capacity, available FROM chart_storage_hourly
+		WHERE bucket >= $1 ORDER BY bucket`, time.Now().Add(-window))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting storage usage history: %w", err)
+	}
+
+	byPath := map[string][]usageSample{}
+	for _, s := range samples {
+		byPath[s.StorageID] = append(byPath[s.StorageID], s)
+	}
+
+	classOf := map[string]string{}
+	classAgg := map[string]*StorageForecast{}
+	for _, p := range current {
+		class := pathClass(p.CanSeal, p.CanStore)
+		classOf[p.StorageID] = class
+
+		f := StorageForecast{ID: p.StorageID, Class: class, Capacity: p.Capacity, Available: p.Available}
+		f.GrowthPerDay = usageTrend(byPath[p.StorageID])
+		f.DaysUntilFull = daysUntilFull(f.Available, f.GrowthPerDay)
+		paths = append(paths, f)
+
+		ca, ok := classAgg[class]
+		if !ok {
+			ca = &StorageForecast{ID: class, Class: class}
+			classAgg[class] = ca
+		}
+		ca.Capacity += p.Capacity
+		ca.Available += p.Available
+	}
+
+	// class trends are computed from the summed usage of the class in each bucket
+	type classBucket struct {
+		class  string
+		bucket time.Time
+	}
+	sums := map[classBucket]*usageSample{}
+	for _, s := range samples {
+		class, ok := classOf[s.StorageID]
+		if !ok {
+			continue // path was removed
+		}
+		k := classBucket{class, s.Bucket}
+		if sums[k] == nil {
+			sums[k] = &usageSample{Bucket: s.Bucket, StorageID: class}
+		}
+		sums[k].Capacity += s.Capacity
+		sums[k].Available += s.Available
+	}
+	classSamples := map[string][]usageSample{}
+	for k, s := range sums {
+		classSamples[k.class] = append(classSamples[k.class], *s)
+	}
+
+	for class, ca := range classAgg {
+		cs := classSamples[class]
+		sort.Slice(cs, func(i, j int) bool { return cs[i].Bucket.Before(cs[j].Bucket) })
+		ca.GrowthPerDay = usageTrend(cs)
+		ca.DaysUntilFull = daysUntilFull(ca.Available, ca.GrowthPerDay)
+		classes = append(classes, *ca)
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i].Class < classes[j].Class })
+
+	return paths, classes, nil
+}
+
+// usageTrend returns the least squares slope of used bytes over time, in bytes per day. At least a day of
+// history is needed for a trend.
+func usageTrend(samples []usageSample) float64 {
+	if len(samples) < 2 || samples[len(samples)-1].Bucket.Sub(samples[0].Bucket) < 24*time.Hour {
+		return 0
+	}
+
+	t0 := samples[0].Bucket
+	var sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		x := s.Bucket.Sub(t0).Hours() / 24
+		y := float64(s.Capacity - s.Available)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+
+	n := float64(len(samples))
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / den
+}
+
+func daysUntilFull(available int64, growthPerDay float64) *float64 {
+	if growthPerDay <= 0 {
+		return nil
+	}
+	d := float64(available) / growthPerDay
+	return &d
+}
@@ -0,0 +1,319 @@
+package rollup
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+
+	"github.com/filecoin-project/lotus/build/buildconstants"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var log = logging.Logger("rollup")
+
+const ChartRollupInterval = 15 * time.Minute
+
+// GasRollupLookback is how far back from the most recent gas bucket gas buckets are recomputed. Messages are only
+// recorded as executed once they are settled, and the waiter may be behind, so messages keep arriving for buckets
+// of days which already passed.
+const GasRollupLookback = 3 * 24 * time.Hour
+
+type ChartRollupAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+}
+
+// ChartRollupTask aggregates history tables into the chart_* tables read by the web UI.
+// Each run recomputes buckets starting at the most recent bucket already present, so the
+// latest (partial) bucket is always brought up to date.
+type ChartRollupTask struct {
+	db  *harmonydb.DB
+	api ChartRollupAPI
+}
+
+func NewChartRollupTask(db *harmonydb.DB, api ChartRollupAPI) *ChartRollupTask {
+	return &ChartRollupTask{db: db, api: api}
+}
+
+func (c *ChartRollupTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	if err := c.rollupTasks(ctx); err != nil {
+		return false, xerrors.Errorf("task rollup: %w", err)
+	}
+	if err := c.rollupSealed(ctx); err != nil {
+		return false, xerrors.Errorf("sealed rollup: %w", err)
+	}
+
+	head, err := c.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+	genesis := genesisTime(head)
+
+	if err := c.rollupGas(ctx, genesis); err != nil {
+		return false, xerrors.Errorf("gas rollup: %w", err)
+	}
+	if err := c.rollupTaskGas(ctx, genesis); err != nil {
+		return false, xerrors.Errorf("task gas rollup: %w", err)
+	}
+	if err := c.rollupStorage(ctx); err != nil {
+		return false, xerrors.Errorf("storage rollup: %w", err)
+	}
+	if err := c.rollupTransfers(ctx); err != nil {
+		return false, xerrors.Errorf("transfer rollup: %w", err)
+	}
+
+	return true, nil
+}
+
+func (c *ChartRollupTask) rollupTasks(ctx context.Context) error {
+	n, err := c.db.Exec(ctx, `INSERT INTO chart_task_hourly (bucket, task_name, completed, failed, avg_duration_s, max_duration_s)
+		SELECT date_trunc('hour', work_end), name,
+			COUNT(*) FILTER (WHERE result),
+			COUNT(*) FILTER (WHERE NOT result),
+			AVG(EXTRACT(EPOCH FROM work_end - work_start)),
+			MAX(EXTRACT(EPOCH FROM work_end - work_start))
+		FROM harmony_task_history
+		WHERE work_end >= (SELECT COALESCE(MAX(bucket), 'epoch'::TIMESTAMPTZ) FROM chart_task_hourly)
+		GROUP BY 1, 2
+		ON CONFLICT (bucket, task_name) DO UPDATE SET
+			completed = EXCLUDED.completed,
+			failed = EXCLUDED.failed,
+			avg_duration_s = EXCLUDED.avg_duration_s,
+			max_duration_s = EXCLUDED.max_duration_s`)
+	if err != nil {
+		return err
+	}
+
+	log.Debugw("task rollup done", "buckets", n)
+	return nil
+}
+
+func (c *ChartRollupTask) rollupSealed(ctx context.Context) error {
+	var rows []struct {
+		Bucket       time.Time `db:"bucket"`
+		SpID         int64     `db:"sp_id"`
+		RegSealProof int64     `db:"reg_seal_proof"`
+		Sectors      int64     `db:"sectors"`
+	}
+
+	// Sectors count as sealed on the day their commit (or snap update) message was sent
+	err := c.db.Select(ctx, &rows, `SELECT date_trunc('day', h.work_end) AS bucket, e.sp_id,
+			COALESCE(sm.reg_seal_proof, p.reg_seal_proof) AS reg_seal_proof,
+			COUNT(DISTINCT e.sector_number) AS sectors
+		FROM harmony_task_history h
+		JOIN sectors_pipeline_events e ON e.task_history_id = h.id
+		LEFT JOIN sectors_meta sm ON sm.sp_id = e.sp_id AND sm.sector_num = e.sector_number
+		LEFT JOIN sectors_sdr_pipeline p ON p.sp_id = e.sp_id AND p.sector_number = e.sector_number
+		WHERE h.name IN ('CommitSubmit', 'UpdateSubmit') AND h.result
+		  AND COALESCE(sm.reg_seal_proof, p.reg_seal_proof) IS NOT NULL
+		  AND h.work_end >= (SELECT COALESCE(MAX(bucket), 'epoch'::TIMESTAMPTZ) FROM chart_sealed_daily)
+		GROUP BY 1, 2, 3`)
+	if err != nil {
+		return err
+	}
+
+	type key struct {
+		bucket time.Time
+		spID   int64
+	}
+	type agg struct {
+		sectors, bytes int64
+	}
+	out := map[key]*agg{}
+
+	for _, r := range rows {
+		ssize, err := abi.RegisteredSealProof(r.RegSealProof).SectorSize()
+		if err != nil {
+			log.Warnw("unknown seal proof in sealed rollup", "sp", r.SpID, "proof", r.RegSealProof, "error", err)
+			continue
+		}
+
+		k := key{bucket: r.Bucket, spID: r.SpID}
+		if out[k] == nil {
+			out[k] = &agg{}
+		}
+		out[k].sectors += r.Sectors
+		out[k].bytes += r.Sectors * int64(ssize)
+	}
+
+	for k, a := range out {
+		_, err := c.db.Exec(ctx, `INSERT INTO chart_sealed_daily (bucket, sp_id, sectors, bytes) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (bucket, sp_id) DO UPDATE SET sectors = EXCLUDED.sectors, bytes = EXCLUDED.bytes`, k.bucket, k.spID, a.sectors, a.bytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// genesisTime is the unix time of the genesis of the chain, epochs land BlockDelaySecs apart including null rounds.
+func genesisTime(head *types.TipSet) int64 {
+	return int64(head.MinTimestamp()) - int64(head.Height())*int64(buildconstants.BlockDelaySecs)
+}
+
+// gasFromEpoch is the first landing epoch of messages rolled up again: GasRollupLookback before the most recent
+// bucket, or genesis when there are no buckets yet.
+func gasFromEpoch(latest *time.Time, genesis int64) abi.ChainEpoch {
+	if latest == nil {
+		return 0
+	}
+	from := latest.Add(-GasRollupLookback).Unix() - genesis
+	if from < 0 {
+		return 0
+	}
+	return abi.ChainEpoch(from / int64(buildconstants.BlockDelaySecs))
+}
+
+// rollupGas aggregates executed messages by the day they landed on chain. gas_cost is what the sender paid in
+// attoFIL: gas used at the base fee plus the miner tip and over-estimation burn, recorded when the message executed.
+func (c *ChartRollupTask) rollupGas(ctx context.Context, genesis int64) error {
+	var latest *time.Time
+	if err := c.db.QueryRow(ctx, `SELECT MAX(bucket) FROM chart_gas_daily`).Scan(&latest); err != nil {
+		return xerrors.Errorf("getting latest gas bucket: %w", err)
+	}
+
+	_, err := c.db.Exec(ctx, `INSERT INTO chart_gas_daily (bucket, send_reason, messages, gas_used, gas_cost)
+		SELECT date_trunc('day', to_timestamp($1::BIGINT + w.executed_tsk_epoch * $2::BIGINT)), s.send_reason,
+			COUNT(*), COALESCE(SUM(w.executed_rcpt_gas_used), 0), COALESCE(SUM(w.executed_gas_cost), 0)
+		FROM message_sends s
+		JOIN message_waits w ON w.signed_message_cid = COALESCE(s.held_cid, s.signed_cid)
+		WHERE s.send_success AND w.executed_tsk_epoch >= $3
+		GROUP BY 1, 2
+		ON CONFLICT (bucket, send_reason) DO UPDATE SET
+			messages = EXCLUDED.messages,
+			gas_used = EXCLUDED.gas_used,
+			gas_cost = EXCLUDED.gas_cost`, genesis, buildconstants.BlockDelaySecs, gasFromEpoch(latest, genesis))
+	return err
+}
+
+// sendReasonTasks maps send reasons to the task types sending messages for them.
+var sendReasonTasks = map[string]string{
+	"precommit":          "PreCommitSubmit",
+	"commit":             "CommitSubmit",
+	"update":             "UpdateSubmit",
+	"wdpost":             "WdPostSubmit",
+	"declare-recoveries": "WdPostRecover",
+	"extend-sectors":     "ExtendSectors",
+	"wallet-top-up":      "WalletTopUp",
+}
+
+// rollupTaskGas attributes the gas of executed messages to the sending task type and SP, by the day the messages
+// landed on chain. Messages to miner actors count for that SP, wallet top-ups for the SP whose address was topped up.
+func (c *ChartRollupTask) rollupTaskGas(ctx context.Context, genesis int64) error {
+	var latest *time.Time
+	if err := c.db.QueryRow(ctx, `SELECT MAX(bucket) FROM chart_gas_task_daily`).Scan(&latest); err != nil {
+		return xerrors.Errorf("getting latest task gas bucket: %w", err)
+	}
+
+	var reasons, tasks []string
+	for r, t := range sendReasonTasks {
+		reasons = append(reasons, r)
+		tasks = append(tasks, t)
+	}
+
+	_, err := c.db.Exec(ctx, `INSERT INTO chart_gas_task_daily (bucket, sp_id, task_name, messages, gas_used, gas_cost)
+		SELECT date_trunc('day', to_timestamp($3::BIGINT + w.executed_tsk_epoch * $4::BIGINT)),
+			COALESCE(t.sp_id, CASE WHEN s.to_addr ~ '^[ft]0[0-9]+$' THEN substring(s.to_addr FROM 3)::BIGINT END, 0),
+			COALESCE(r.task_name, s.send_reason),
+			COUNT(*), COALESCE(SUM(w.executed_rcpt_gas_used), 0), COALESCE(SUM(w.executed_gas_cost), 0)
+		FROM message_sends s
+		JOIN message_waits w ON w.signed_message_cid = COALESCE(s.held_cid, s.signed_cid)
+		LEFT JOIN unnest($1::TEXT[], $2::TEXT[]) AS r(send_reason, task_name) ON r.send_reason = s.send_reason
+		LEFT JOIN wallet_topups t ON t.signed_cid = COALESCE(s.held_cid, s.signed_cid)
+		WHERE s.send_success AND w.executed_tsk_epoch >= $5
+		GROUP BY 1, 2, 3
+		ON CONFLICT (bucket, sp_id, task_name) DO UPDATE SET
+			messages = EXCLUDED.messages,
+			gas_used = EXCLUDED.gas_used,
+			gas_cost = EXCLUDED.gas_cost`, reasons, tasks, genesis, buildconstants.BlockDelaySecs, gasFromEpoch(latest, genesis))
+	return err
+}
+
+// rollupStorage snapshots the current usage of storage paths into the current hour.
+func (c *ChartRollupTask) rollupStorage(ctx context.Context) error {
+	_, err := c.db.Exec(ctx, `INSERT INTO chart_storage_hourly (bucket, storage_id, capacity, available)
+		SELECT date_trunc('hour', current_timestamp), storage_id, capacity, available
+		FROM storage_path
+		WHERE capacity IS NOT NULL AND available IS NOT NULL
+		ON CONFLICT (bucket, storage_id) DO UPDATE SET
+			capacity = EXCLUDED.capacity,
+			available = EXCLUDED.available`)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(ctx, `DELETE FROM chart_storage_hourly WHERE bucket < current_timestamp - INTERVAL '90 days'`)
+	return err
+}
+
+// rollupTransfers aggregates inbound piece transfers per client, SP and machine, and per deal. Transfer records
+// are kept for 30 days.
+func (c *ChartRollupTask) rollupTransfers(ctx context.Context) error {
+	_, err := c.db.Exec(ctx, `INSERT INTO chart_transfer_hourly (bucket, client, sp_id, machine, transfers, failed, bytes, transfer_s)
+		SELECT date_trunc('hour', end_time), COALESCE(client, ''), COALESCE(sp_id, 0), machine,
+			COUNT(*), COUNT(*) FILTER (WHERE NOT success), SUM(bytes),
+			SUM(EXTRACT(EPOCH FROM end_time - start_time))
+		FROM piece_transfers
+		WHERE end_time >= (SELECT COALESCE(MAX(bucket), 'epoch'::TIMESTAMPTZ) FROM chart_transfer_hourly)
+		GROUP BY 1, 2, 3, 4
+		ON CONFLICT (bucket, client, sp_id, machine) DO UPDATE SET
+			transfers = EXCLUDED.transfers,
+			failed = EXCLUDED.failed,
+			bytes = EXCLUDED.bytes,
+			transfer_s = EXCLUDED.transfer_s`)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(ctx, `INSERT INTO chart_transfer_deals_daily (bucket, sp_id, piece_cid, deal_id, client, transfers, bytes)
+		SELECT date_trunc('day', end_time), COALESCE(sp_id, 0), piece_cid, COALESCE(deal_id, 0), COALESCE(MAX(client), ''),
+			COUNT(*), SUM(bytes)
+		FROM piece_transfers
+		WHERE end_time >= (SELECT COALESCE(MAX(bucket), 'epoch'::TIMESTAMPTZ) FROM chart_transfer_deals_daily)
+		GROUP BY 1, 2, 3, 4
+		ON CONFLICT (bucket, sp_id, piece_cid, deal_id) DO UPDATE SET
+			client = EXCLUDED.client,
+			transfers = EXCLUDED.transfers,
+			bytes = EXCLUDED.bytes`)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(ctx, `DELETE FROM piece_transfers WHERE end_time < current_timestamp - INTERVAL '30 days'`)
+	return err
+}
+
+func (c *ChartRollupTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (c *ChartRollupTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "ChartRollup",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+			Gpu: 0,
+		},
+		IAmBored: harmonytask.SingletonTaskAdder(ChartRollupInterval, c),
+	}
+}
+
+func (c *ChartRollupTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ = harmonytask.Reg(&ChartRollupTask{})
+var _ harmonytask.TaskInterface = &ChartRollupTask{}
@@ -0,0 +1,444 @@
+package scrub
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	ffi "github.com/filecoin-project/filecoin-ffi"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/alertmanager"
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/repair"
+)
+
+var log = logging.Logger("scrub")
+
+// SealedPlanInterval is how often the scrub policy looks for sector copies due for a check.
+const SealedPlanInterval = 10 * time.Minute
+
+// scrubbedFileTypes are the sector files checked by sealed data scrubbing.
+var scrubbedFileTypes = []storiface.SectorFileType{storiface.FTSealed, storiface.FTCache, storiface.FTUpdate, storiface.FTUpdateCache}
+
+// ScrubSealedTask verifies sealed sector data in storage paths to catch silent
+// corruption before a PoSt does. Checks are planned per sector copy into
+// scrub_sealed_checks and executed by the node which has the path attached.
+//
+// Sampled checks generate vanilla PoSt proofs for random challenges, which fails
+// when the challenged nodes don't match the sector commitments. Full reads
+// checksum every file of the copy and compare with checksums recorded by the
+// first full read.
+type ScrubSealedTask struct {
+	db    *harmonydb.DB
+	stor  *paths.Remote
+	lstor *paths.Local
+	index paths.SectorIndex
+	alert *alertmanager.AlertNow
+	cfg   config.StorageScrubConfig
+	max   int
+
+	lastPlan time.Time
+}
+
+func NewScrubSealedTask(db *harmonydb.DB, stor *paths.Remote, lstor *paths.Local, index paths.SectorIndex, alert *alertmanager.AlertNow, cfg config.StorageScrubConfig, max int) *ScrubSealedTask {
+	return &ScrubSealedTask{
+		db:    db,
+		stor:  stor,
+		lstor: lstor,
+		index: index,
+		alert: alert,
+		cfg:   cfg,
+		max:   max,
+	}
+}
+
+type sealedCheck struct {
+	CheckID      int64  `db:"check_id"`
+	SpID         int64  `db:"sp_id"`
+	SectorNumber int64  `db:"sector_number"`
+	StorageID    string `db:"storage_id"`
+	FileTypes    int64  `db:"file_types"`
+	FullRead     bool   `db:"full_read"`
+	RegSealProof int64  `db:"reg_seal_proof"`
+	OrigSealed   string `db:"orig_sealed_cid"`
+	CurSealed    string `db:"cur_sealed_cid"`
+}
+
+func (s *ScrubSealedTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var checks []sealedCheck
+	err = s.db.Select(ctx, &checks, `
+		SELECT c.check_id, c.sp_id, c.sector_number, c.storage_id, c.file_types, c.full_read,
+		       sm.reg_seal_proof, sm.orig_sealed_cid, sm.cur_sealed_cid
+		FROM scrub_sealed_checks c
+		INNER JOIN sectors_meta sm ON sm.sp_id = c.sp_id AND sm.sector_num = c.sector_number
+		WHERE c.task_id = $1 AND c.complete_time IS NULL
+	`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("fetching check: %w", err)
+	}
+	if len(checks) != 1 {
+		return false, xerrors.Errorf("expected 1 scrub check, got %d", len(checks))
+	}
+	check := checks[0]
+
+	sref := storiface.SectorRef{
+		ID:        abi.SectorID{Miner: abi.ActorID(check.SpID), Number: abi.SectorNumber(check.SectorNumber)},
+		ProofType: abi.RegisteredSealProof(check.RegSealProof),
+	}
+	ft := storiface.SectorFileType(check.FileTypes)
+
+	// hold a read lock so the files don't get moved or removed while checked
+	lockCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	locked, err := s.index.StorageTryLock(lockCtx, sref.ID, ft, storiface.FTNone)
+	if err != nil {
+		return false, xerrors.Errorf("locking sector files: %w", err)
+	}
+	if !locked {
+		return false, xerrors.Errorf("sector files are locked for writing")
+	}
+
+	var message string
+	if check.FullRead {
+		message, err = s.fullRead(ctx, check, sref, ft)
+	} else {
+		message, err = s.sample(ctx, check, sref, ft)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ok := message == ""
+	_, err = s.db.Exec(ctx, `UPDATE scrub_sealed_checks SET ok = $2, message = $3, complete_time = current_timestamp
+		WHERE check_id = $1`, check.CheckID, ok, message)
+	if err != nil {
+		return false, xerrors.Errorf("storing check result: %w", err)
+	}
+
+	if !ok {
+		log.Errorw("sealed data scrub found corruption", "sector", sref.ID, "storage", check.StorageID, "full", check.FullRead, "message", message)
+		s.alert.AddAlert(fmt.Sprintf("Scrub: sector %d of f0%d in storage path %s failed verification: %s", sref.ID.Number, sref.ID.Miner, check.StorageID, message))
+
+		if s.cfg.AutoRepair {
+			if err := repair.Schedule(ctx, s.db, sref.ID, storiface.ID(check.StorageID), ft, repair.DetectedByScrub, message); err != nil {
+				log.Errorw("scheduling repair of corrupted sector", "sector", sref.ID, "storage", check.StorageID, "error", err)
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// sample generates a vanilla PoSt proof for random challenges, returning a
+// non-empty message when the data doesn't check out
+func (s *ScrubSealedTask) sample(ctx context.Context, check sealedCheck, sref storiface.SectorRef, ft storiface.SectorFileType) (string, error) {
+	update := ft.Has(storiface.FTUpdate)
+
+	commRStr := check.OrigSealed
+	if update {
+		commRStr = check.CurSealed
+	}
+	commR, err := cid.Parse(commRStr)
+	if err != nil {
+		return "", xerrors.Errorf("parsing sealed CID: %w", err)
+	}
+
+	pp, err := sref.ProofType.RegisteredWindowPoStProof()
+	if err != nil {
+		return "", xerrors.Errorf("getting PoSt proof type: %w", err)
+	}
+
+	var postRand abi.PoStRandomness = make([]byte, abi.RandomnessLength)
+	_, _ = rand.Read(postRand)
+	postRand[31] &= 0x3f
+
+	ch, err := ffi.GeneratePoStFallbackSectorChallenges(pp, sref.ID.Miner, postRand, []abi.SectorNumber{sref.ID.Number})
+	if err != nil {
+		return "", xerrors.Errorf("generating fallback challenges: %w", err)
+	}
+
+	_, err = s.stor.GenerateSingleVanillaProof(ctx, sref.ID.Miner, storiface.PostSectorChallenge{
+		SealProof:    sref.ProofType,
+		SectorNumber: sref.ID.Number,
+		SealedCID:    commR,
+		Challenge:    ch.Challenges[sref.ID.Number],
+		Update:       update,
+	}, pp)
+	if err != nil {
+		return fmt.Sprintf("generating vanilla proof: %s", err), nil
+	}
+
+	return "", nil
+}
+
+// fullRead checksums all files of the sector copy in the check's path and
+// compares with previously recorded checksums, recording them on the first read
+func (s *ScrubSealedTask) fullRead(ctx context.Context, check sealedCheck, sref storiface.SectorRef, ft storiface.SectorFileType) (string, error) {
+	local, err := s.localPath(ctx, storiface.ID(check.StorageID))
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range ft.AllSet() {
+		p := filepath.Join(local, f.String(), storiface.SectorName(sref.ID))
+
+		sum, err := checksumPath(p)
+		if err != nil {
+			return fmt.Sprintf("reading %s: %s", f, err), nil
+		}
+
+		var recorded string
+		err = s.db.QueryRow(ctx, `INSERT INTO scrub_sealed_checksums (sp_id, sector_number, file_type, storage_id, checksum)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (sp_id, sector_number, file_type, storage_id) DO UPDATE SET sp_id = EXCLUDED.sp_id
+			RETURNING checksum`, check.SpID, check.SectorNumber, int64(f), check.StorageID, sum).Scan(&recorded)
+		if err != nil {
+			return "", xerrors.Errorf("recording %s checksum: %w", f, err)
+		}
+
+		if recorded != sum {
+			return fmt.Sprintf("%s checksum mismatch, recorded %s, read %s", f, recorded, sum), nil
+		}
+	}
+
+	return "", nil
+}
+
+func (s *ScrubSealedTask) localPath(ctx context.Context, id storiface.ID) (string, error) {
+	locals, err := s.lstor.Local(ctx)
+	if err != nil {
+		return "", xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	for _, p := range locals {
+		if p.ID == id {
+			return p.LocalPath, nil
+		}
+	}
+	return "", xerrors.Errorf("storage path %s is not attached to this node", id)
+}
+
+// checksumPath returns the hex sha256 of a file, or of the names and contents
+// of all files in a directory in name order
+func checksumPath(p string) (string, error) {
+	st, err := os.Stat(p)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+
+	hashFile := func(name string) error {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close() // nolint:errcheck
+
+		_, err = io.CopyBuffer(h, f, make([]byte, 1<<20))
+		return err
+	}
+
+	if !st.IsDir() {
+		if err := hashFile(p); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	ents, err := os.ReadDir(p)
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(ents, func(i, j int) bool {
+		return ents[i].Name() < ents[j].Name()
+	})
+
+	for _, ent := range ents {
+		if !ent.Type().IsRegular() {
+			continue
+		}
+		_, _ = h.Write([]byte(ent.Name()))
+		if err := hashFile(filepath.Join(p, ent.Name())); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *ScrubSealedTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ctx := context.Background()
+
+	locals, err := s.lstor.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+	localIDs := make([]string, len(locals))
+	for i, p := range locals {
+		localIDs[i] = string(p.ID)
+	}
+
+	var accepted []harmonytask.TaskID
+	err = s.db.Select(ctx, &accepted, `SELECT task_id FROM scrub_sealed_checks
+		WHERE task_id = ANY($1) AND storage_id = ANY($2) LIMIT 1`, ids, localIDs)
+	if err != nil {
+		return nil, xerrors.Errorf("getting scrub checks: %w", err)
+	}
+	if len(accepted) == 0 {
+		return nil, nil
+	}
+
+	return &accepted[0], nil
+}
+
+func (s *ScrubSealedTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(s.max),
+		Name: "ScrubSealed",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 256 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(MinSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return s.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (s *ScrubSealedTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (s *ScrubSealedTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	if time.Since(s.lastPlan) > SealedPlanInterval {
+		s.lastPlan = time.Now()
+		if err := s.plan(ctx); err != nil {
+			log.Errorw("planning sealed data scrub checks", "error", err)
+		}
+	}
+
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var checks []struct {
+			CheckID int64 `db:"check_id"`
+		}
+
+		err := tx.Select(&checks, `SELECT check_id FROM scrub_sealed_checks WHERE task_id IS NULL AND complete_time IS NULL LIMIT 20`)
+		if err != nil {
+			return false, xerrors.Errorf("getting tasks: %w", err)
+		}
+
+		if len(checks) == 0 {
+			return false, nil
+		}
+
+		// pick at random in case there are a bunch of schedules across the cluster
+		check := checks[mrand.N(len(checks))]
+
+		_, err = tx.Exec(`UPDATE scrub_sealed_checks SET task_id = $1 WHERE check_id = $2 AND task_id IS NULL`, id, check.CheckID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return true, nil
+	})
+
+	return nil
+}
+
+// plan records checks for sector copies which weren't checked within the
+// configured intervals, least recently checked first
+func (s *ScrubSealedTask) plan(ctx context.Context) error {
+	var pending int
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM scrub_sealed_checks WHERE complete_time IS NULL`).Scan(&pending)
+	if err != nil {
+		return xerrors.Errorf("counting pending checks: %w", err)
+	}
+	room := s.cfg.MaxPendingChecks - pending
+
+	fileTypes := make([]int64, len(scrubbedFileTypes))
+	for i, ft := range scrubbedFileTypes {
+		fileTypes[i] = int64(ft)
+	}
+
+	// full reads cover every file, samples need the sealed or update file of the copy
+	modes := []struct {
+		full     bool
+		interval config.Duration
+		need     storiface.SectorFileType
+	}{
+		{full: true, interval: s.cfg.FullReadInterval, need: storiface.FTSealed | storiface.FTCache | storiface.FTUpdate | storiface.FTUpdateCache},
+		{full: false, interval: s.cfg.SampleInterval, need: storiface.FTSealed | storiface.FTUpdate},
+	}
+
+	for _, mode := range modes {
+		if room <= 0 {
+			return nil
+		}
+		if mode.interval <= 0 {
+			continue
+		}
+
+		var copies []struct {
+			SpID         int64  `db:"sp_id"`
+			SectorNumber int64  `db:"sector_number"`
+			StorageID    string `db:"storage_id"`
+			FileTypes    int64  `db:"file_types"`
+		}
+		err := s.db.Select(ctx, &copies, `
+			WITH last AS (
+				SELECT sp_id, sector_number, storage_id, MAX(create_time) AS last_check
+				FROM scrub_sealed_checks WHERE full_read = $1
+				GROUP BY sp_id, sector_number, storage_id
+			)
+			SELECT sl.miner_id AS sp_id, sl.sector_num AS sector_number, sl.storage_id, bit_or(sl.sector_filetype) AS file_types
+			FROM sector_location sl
+				JOIN sectors_meta sm ON sm.sp_id = sl.miner_id AND sm.sector_num = sl.sector_num
+				LEFT JOIN last l ON l.sp_id = sl.miner_id AND l.sector_number = sl.sector_num AND l.storage_id = sl.storage_id
+			WHERE sl.sector_filetype = ANY($2) AND (l.last_check IS NULL OR l.last_check < NOW() - $3::interval)
+			GROUP BY sl.miner_id, sl.sector_num, sl.storage_id, l.last_check
+			HAVING (bit_or(sl.sector_filetype) & $4) != 0
+			ORDER BY l.last_check ASC NULLS FIRST
+			LIMIT $5`, mode.full, fileTypes, strconv.FormatInt(int64(time.Duration(mode.interval).Seconds()), 10)+" seconds", int64(mode.need), room)
+		if err != nil {
+			return xerrors.Errorf("getting sector copies to scrub: %w", err)
+		}
+
+		for _, c := range copies {
+			n, err := s.db.Exec(ctx, `INSERT INTO scrub_sealed_checks (sp_id, sector_number, storage_id, file_types, full_read)
+				VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`, c.SpID, c.SectorNumber, c.StorageID, c.FileTypes, mode.full)
+			if err != nil {
+				return xerrors.Errorf("recording scrub check: %w", err)
+			}
+			room -= n
+		}
+	}
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&ScrubSealedTask{})
+var _ harmonytask.TaskInterface = &ScrubSealedTask{}
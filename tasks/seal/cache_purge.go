@@ -0,0 +1,51 @@
+package seal
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/deps/config"
+)
+
+// Sealing intermediates tracked in sector_cache_purges
+const (
+	CachePartLayers   = "layers"
+	CachePartTrees    = "trees"
+	CachePartUnsealed = "unsealed"
+)
+
+// CachePurgePolicy is the parsed Seal.CachePurge config. A nil delay purges the part at finalize, otherwise the part
+// is purged that long after the commit message landed.
+type CachePurgePolicy struct {
+	Layers   *time.Duration
+	Trees    *time.Duration
+	Unsealed *time.Duration
+}
+
+func ParseCachePurge(cfg config.CachePurgeConfig) (CachePurgePolicy, error) {
+	var p CachePurgePolicy
+	for _, f := range []struct {
+		name string
+		val  string
+		out  **time.Duration
+	}{
+		{"Layers", cfg.Layers, &p.Layers},
+		{"Trees", cfg.Trees, &p.Trees},
+		{"Unsealed", cfg.Unsealed, &p.Unsealed},
+	} {
+		switch f.val {
+		case "", "finalize":
+		case "commit":
+			var d time.Duration
+			*f.out = &d
+		default:
+			d, err := time.ParseDuration(f.val)
+			if err != nil || d < 0 {
+				return CachePurgePolicy{}, xerrors.Errorf("Seal.CachePurge.%s: expected 'finalize', 'commit' or a duration, got %q", f.name, f.val)
+			}
+			*f.out = &d
+		}
+	}
+	return p, nil
+}
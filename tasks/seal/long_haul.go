@@ -0,0 +1,75 @@
+package seal
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+)
+
+// Long-haul sealing lets machines which reach the cluster over a WAN link, e.g. at a remote site or a sealing service,
+// seal sectors. A sector whose SDR runs on a machine with Seal.LongHaul enabled stays on that machine until it is
+// finalized, so only the sealed file, the tree-r-last files and the unsealed copy cross the link, uploaded by the
+// LongHaulUpload task instead of MoveStorage. Long-haul machines don't take tasks of other sectors.
+
+func (s *SealPoller) pollStartLongHaulUpload(ctx context.Context, task pollTask) {
+	if s.pollers[pollerLongHaulUpload].IsSet() && task.LongHaulHost != nil && task.afterFinalize() && !task.CachePurgePending && !task.AfterMoveStorage && task.TaskLongHaulUpload == nil {
+		s.pollers[pollerLongHaulUpload].Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_long_haul_upload = $1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_long_haul_upload IS NULL`, id, task.SpID, task.SectorNumber)
+			if err != nil {
+				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+			}
+			if n != 1 {
+				return false, xerrors.Errorf("expected to update 1 row, updated %d", n)
+			}
+
+			return true, nil
+		})
+	}
+}
+
+// longHaulHostValue returns the value of the long_haul_host column of sectors which run SDR on this machine
+func (s *SealPoller) longHaulHostValue() *string {
+	if s.longHaulHost == "" {
+		return nil
+	}
+	return &s.longHaulHost
+}
+
+// acceptLongHaul filters sectors_sdr_pipeline tasks down to those this machine may run: tasks of sectors sealed on a
+// long-haul machine only run on that machine, which doesn't take tasks of other sectors.
+func (s *SealPoller) acceptLongHaul(ctx context.Context, ids []harmonytask.TaskID) ([]harmonytask.TaskID, error) {
+	var tasks []struct {
+		TaskID harmonytask.TaskID `db:"task_id"`
+		Host   *string            `db:"long_haul_host"`
+	}
+
+	indIDs := make([]int64, len(ids))
+	for i, id := range ids {
+		indIDs[i] = int64(id)
+	}
+
+	// task IDs are unique across task types, so the tasks can be matched against all task columns
+	err := s.db.Select(ctx, &tasks, `SELECT t.id AS task_id, s.long_haul_host FROM sectors_sdr_pipeline s
+		JOIN unnest($1::BIGINT[]) AS t(id) ON t.id IN (s.task_id_tree_d, s.task_id_tree_r, s.task_id_synth, s.task_id_porep, s.task_id_long_haul_upload)`, indIDs)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector long-haul hosts: %w", err)
+	}
+
+	hosts := map[harmonytask.TaskID]string{}
+	for _, t := range tasks {
+		if t.Host != nil {
+			hosts[t.TaskID] = *t.Host
+		}
+	}
+
+	out := make([]harmonytask.TaskID, 0, len(ids))
+	for _, id := range ids {
+		if hosts[id] == s.longHaulHost {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,24 @@
+package seal
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+// PausedSPs returns the SPs whose sealing pipeline is paused in sealing_pauses. Sectors of paused SPs don't start
+// SDR and aren't precommitted, sectors past those stages finish the pipeline.
+func PausedSPs(ctx context.Context, db *harmonydb.DB) (map[int64]bool, error) {
+	var spIDs []int64
+	if err := db.Select(ctx, &spIDs, `SELECT sp_id FROM sealing_pauses`); err != nil {
+		return nil, xerrors.Errorf("getting paused SPs: %w", err)
+	}
+
+	out := map[int64]bool{}
+	for _, id := range spIDs {
+		out[id] = true
+	}
+	return out, nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 
+	"github.com/filecoin-project/curio/deps/config"
 	"github.com/filecoin-project/curio/harmony/harmonydb"
 	"github.com/filecoin-project/curio/harmony/harmonytask"
 	"github.com/filecoin-project/curio/lib/promise"
@@ -30,6 +31,7 @@ const (
 	pollerCommitMsg
 	pollerFinalize
 	pollerMoveStorage
+	pollerLongHaulUpload
 
 	numPollers
 )
@@ -47,13 +49,24 @@ type SealPoller struct {
 	db  *harmonydb.DB
 	api SealPollerAPI
 
+	precommitBatching batchPolicy
+	commitBatching    batchPolicy
+
+	// longHaulHost is the host_and_port of this machine when it seals with Seal.LongHaul, empty otherwise
+	longHaulHost string
+
 	pollers [numPollers]promise.Promise[harmonytask.AddTaskFunc]
 }
 
-func NewPoller(db *harmonydb.DB, api SealPollerAPI) *SealPoller {
+func NewPoller(db *harmonydb.DB, api SealPollerAPI, batching config.CurioBatchingConfig, longHaulHost string) *SealPoller {
 	return &SealPoller{
 		db:  db,
 		api: api,
+
+		longHaulHost: longHaulHost,
+
+		precommitBatching: newBatchPolicy(BatchPreCommit, batching.PreCommit),
+		commitBatching:    newBatchPolicy(BatchCommit, batching.Commit),
 	}
 }
 
@@ -117,6 +130,14 @@ type pollTask struct {
 	TaskMoveStorage  *int64 `db:"task_id_move_storage"`
 	AfterMoveStorage bool   `db:"after_move_storage"`
 
+	// LongHaulHost is set for sectors sealed on a long-haul machine, which are uploaded by LongHaulUpload instead of
+	// being moved by MoveStorage
+	LongHaulHost       *string `db:"long_haul_host"`
+	TaskLongHaulUpload *int64  `db:"task_id_long_haul_upload"`
+
+	// CachePurgePending is set while layers or trees kept by the cache purge policy are still in sealing storage
+	CachePurgePending bool `db:"cache_purge_pending"`
+
 	TaskCommitMsg  *int64 `db:"task_id_commit_msg"`
 	AfterCommitMsg bool   `db:"after_commit_msg"`
 
@@ -141,14 +162,22 @@ func (s *SealPoller) poll(ctx context.Context) error {
        task_id_porep, porep_proof, after_porep,
        task_id_finalize, after_finalize,
        task_id_move_storage, after_move_storage,
+       long_haul_host, task_id_long_haul_upload,
        task_id_commit_msg, after_commit_msg,
        after_commit_msg_success,
-       failed, failed_reason
+       failed, failed_reason,
+       EXISTS (SELECT 1 FROM sector_cache_purges c WHERE c.sp_id = sectors_sdr_pipeline.sp_id AND c.sector_number = sectors_sdr_pipeline.sector_number
+           AND c.part IN ('layers', 'trees') AND c.purged_at IS NULL) AS cache_purge_pending
     FROM sectors_sdr_pipeline WHERE after_commit_msg_success != TRUE OR after_move_storage != TRUE`)
 	if err != nil {
 		return err
 	}
 
+	paused, err := PausedSPs(ctx, s.db)
+	if err != nil {
+		return err
+	}
+
 	for _, task := range tasks {
 		task := task
 		if task.Failed {
@@ -160,19 +189,23 @@ func (s *SealPoller) poll(ctx context.Context) error {
 			return xerrors.Errorf("getting chain head: %w", err)
 		}
 
-		s.pollStartSDR(ctx, task)
+		if !paused[task.SpID] {
+			s.pollStartSDR(ctx, task)
+		}
 		s.pollStartSDRTreeD(ctx, task)
 		s.pollStartSDRTreeRC(ctx, task)
 		s.pollStartSynth(ctx, task)
-		s.pollStartPrecommitMsg(ctx, task)
 		s.mustPoll(s.pollPrecommitMsgLanded(ctx, task))
 		s.pollStartPoRep(ctx, task, ts)
 		s.pollStartFinalize(ctx, task, ts)
 		s.pollStartMoveStorage(ctx, task)
-		s.pollStartCommitMsg(ctx, task)
+		s.pollStartLongHaulUpload(ctx, task)
 		s.mustPoll(s.pollCommitMsgLanded(ctx, task))
 	}
 
+	// precommit and commit messages are started for batches of sectors
+	s.mustPoll(s.pollStartBatches(ctx, paused))
+
 	return nil
 }
 
@@ -309,7 +342,7 @@ func (t pollTask) afterFinalize() bool {
 }
 
 func (s *SealPoller) pollStartMoveStorage(ctx context.Context, task pollTask) {
-	if s.pollers[pollerMoveStorage].IsSet() && task.afterFinalize() && !task.AfterMoveStorage && task.TaskMoveStorage == nil {
+	if s.pollers[pollerMoveStorage].IsSet() && task.afterFinalize() && !task.CachePurgePending && !task.AfterMoveStorage && task.TaskMoveStorage == nil && task.LongHaulHost == nil {
 		s.pollers[pollerMoveStorage].Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
 			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_move_storage = $1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_move_storage IS NULL`, id, task.SpID, task.SectorNumber)
 			if err != nil {
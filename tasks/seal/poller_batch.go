@@ -0,0 +1,364 @@
+package seal
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const (
+	BatchPreCommit = "precommit"
+	BatchCommit    = "commit"
+)
+
+// conditions of sectors_sdr_pipeline rows ready for their precommit / commit message, matching pollTask.afterSynth
+// and pollTask.afterPoRep
+const precommitReadyCond = `after_sdr = TRUE AND after_tree_d = TRUE AND after_tree_c = TRUE AND after_tree_r = TRUE
+	AND after_synth = TRUE AND task_id_precommit_msg IS NULL AND after_precommit_msg = FALSE AND failed = FALSE`
+
+const commitReadyCond = `after_sdr = TRUE AND after_tree_d = TRUE AND after_tree_c = TRUE AND after_tree_r = TRUE
+	AND after_synth = TRUE AND after_precommit_msg = TRUE AND after_precommit_msg_success = TRUE
+	AND after_porep = TRUE AND length(porep_proof) > 0
+	AND task_id_commit_msg IS NULL AND after_commit_msg = FALSE AND failed = FALSE`
+
+// PendingBatch is a batch of sectors ready for their precommit or commit message.
+type PendingBatch struct {
+	Message      string // BatchPreCommit or BatchCommit
+	SpID         int64
+	RegSealProof abi.RegisteredSealProof
+	Sectors      []int64
+
+	OldestReadyAt time.Time
+	// SendBy is when MaxWait sends the batch regardless of its size.
+	SendBy time.Time
+	// SendReason is why the batch is sent at the next poll, empty while the batch waits to fill.
+	SendReason string
+	// Paused is set for precommit batches of SPs with a paused sealing pipeline, they aren't sent until resumed.
+	Paused bool
+}
+
+type batchSector struct {
+	SpID         int64                   `db:"sp_id"`
+	SectorNumber int64                   `db:"sector_number"`
+	RegSealProof abi.RegisteredSealProof `db:"reg_seal_proof"`
+	ReadyAt      time.Time               `db:"ready_at"`
+}
+
+type batchPolicy struct {
+	message string
+
+	maxSectors       int
+	minSectors       int
+	maxWait          time.Duration
+	baseFeeThreshold abi.TokenAmount
+}
+
+func newBatchPolicy(message string, cfg config.BatchingConfig) batchPolicy {
+	p := batchPolicy{
+		message:          message,
+		maxSectors:       cfg.MaxSectors,
+		minSectors:       cfg.MinSectors,
+		maxWait:          time.Duration(cfg.MaxWait),
+		baseFeeThreshold: abi.TokenAmount(cfg.BaseFeeThreshold),
+	}
+	if p.maxSectors < 1 {
+		p.maxSectors = 1
+	}
+	if p.baseFeeThreshold.Nil() {
+		p.baseFeeThreshold = big.Zero()
+	}
+	return p
+}
+
+// plan splits ready sectors into batches of sectors of the same SP and seal proof, oldest sectors first. Full
+// batches are sent right away, the last partial batch of each SP waits for MinSectors, MaxWait or a base fee below
+// BaseFeeThreshold.
+func (p batchPolicy) plan(ready []batchSector, baseFee abi.TokenAmount, now time.Time) []PendingBatch {
+	type groupKey struct {
+		spID  int64
+		proof abi.RegisteredSealProof
+	}
+	groups := map[groupKey][]batchSector{}
+	var keys []groupKey
+	for _, s := range ready {
+		k := groupKey{spID: s.SpID, proof: s.RegSealProof}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], s)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].spID != keys[j].spID {
+			return keys[i].spID < keys[j].spID
+		}
+		return keys[i].proof < keys[j].proof
+	})
+
+	cheap := baseFee.LessThan(p.baseFeeThreshold)
+
+	var out []PendingBatch
+	for _, k := range keys {
+		sectors := groups[k]
+		sort.Slice(sectors, func(i, j int) bool {
+			if !sectors[i].ReadyAt.Equal(sectors[j].ReadyAt) {
+				return sectors[i].ReadyAt.Before(sectors[j].ReadyAt)
+			}
+			return sectors[i].SectorNumber < sectors[j].SectorNumber
+		})
+
+		for start := 0; start < len(sectors); start += p.maxSectors {
+			end := start + p.maxSectors
+			if end > len(sectors) {
+				end = len(sectors)
+			}
+			chunk := sectors[start:end]
+
+			b := PendingBatch{
+				Message:       p.message,
+				SpID:          k.spID,
+				RegSealProof:  k.proof,
+				OldestReadyAt: chunk[0].ReadyAt,
+				SendBy:        chunk[0].ReadyAt.Add(p.maxWait),
+			}
+			for _, s := range chunk {
+				b.Sectors = append(b.Sectors, s.SectorNumber)
+			}
+
+			switch {
+			case p.maxSectors == 1:
+				b.SendReason = "unbatched"
+			case len(chunk) == p.maxSectors:
+				b.SendReason = "full"
+			case p.minSectors > 0 && len(chunk) >= p.minSectors:
+				b.SendReason = "min-sectors"
+			case !now.Before(b.SendBy):
+				b.SendReason = "max-wait"
+			case cheap:
+				b.SendReason = "low-base-fee"
+			}
+
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// batchMaxFee returns the max fee of a message with nSectors: the single sector fee for unbatched messages, otherwise
+// Base + PerSector * nSectors.
+func batchMaxFee(single types.FIL, batch config.BatchFeeConfig, nSectors int) abi.TokenAmount {
+	if nSectors <= 1 {
+		return abi.TokenAmount(single)
+	}
+	return big.Add(abi.TokenAmount(batch.Base), big.Mul(abi.TokenAmount(batch.PerSector), big.NewInt(int64(nSectors))))
+}
+
+func readyForBatch(ctx context.Context, db *harmonydb.DB, message string) ([]batchSector, error) {
+	var ready []batchSector
+	var err error
+	switch message {
+	case BatchPreCommit:
+		err = db.Select(ctx, &ready, `SELECT sp_id, sector_number, reg_seal_proof, COALESCE(precommit_ready_at, NOW()) AS ready_at
+			FROM sectors_sdr_pipeline WHERE `+precommitReadyCond)
+	case BatchCommit:
+		err = db.Select(ctx, &ready, `SELECT sp_id, sector_number, reg_seal_proof, COALESCE(commit_ready_at, NOW()) AS ready_at
+			FROM sectors_sdr_pipeline WHERE `+commitReadyCond)
+	default:
+		return nil, xerrors.Errorf("unknown batch message %s", message)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("getting sectors ready for %s: %w", message, err)
+	}
+	return ready, nil
+}
+
+// pollStartBatches creates precommit and commit message tasks for batches which are ready to be sent. Sectors of
+// paused SPs aren't precommitted.
+func (s *SealPoller) pollStartBatches(ctx context.Context, paused map[int64]bool) error {
+	if !s.pollers[pollerPrecommitMsg].IsSet() && !s.pollers[pollerCommitMsg].IsSet() {
+		return nil
+	}
+
+	ts, err := s.api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+	baseFee := ts.MinTicketBlock().ParentBaseFee
+
+	if s.pollers[pollerPrecommitMsg].IsSet() {
+		_, err := s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET precommit_ready_at = NOW()
+			WHERE precommit_ready_at IS NULL AND `+precommitReadyCond)
+		if err != nil {
+			return xerrors.Errorf("marking sectors ready for precommit: %w", err)
+		}
+		if err := s.startBatches(ctx, s.precommitBatching, baseFee, pollerPrecommitMsg, paused); err != nil {
+			return err
+		}
+	}
+
+	if s.pollers[pollerCommitMsg].IsSet() {
+		_, err := s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET commit_ready_at = NOW()
+			WHERE commit_ready_at IS NULL AND `+commitReadyCond)
+		if err != nil {
+			return xerrors.Errorf("marking sectors ready for commit: %w", err)
+		}
+		if err := s.startBatches(ctx, s.commitBatching, baseFee, pollerCommitMsg, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SealPoller) startBatches(ctx context.Context, p batchPolicy, baseFee abi.TokenAmount, poller int, paused map[int64]bool) error {
+	all, err := readyForBatch(ctx, s.db, p.message)
+	if err != nil {
+		return err
+	}
+
+	ready := all[:0]
+	for _, sector := range all {
+		if !paused[sector.SpID] {
+			ready = append(ready, sector)
+		}
+	}
+
+	for _, b := range p.plan(ready, baseFee, time.Now()) {
+		if b.SendReason == "" {
+			continue
+		}
+
+		b := b
+		s.pollers[poller].Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			var n int
+			var err error
+			if b.Message == BatchPreCommit {
+				n, err = tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_precommit_msg = $1
+					WHERE sp_id = $2 AND sector_number = ANY($3) AND task_id_precommit_msg IS NULL AND after_synth = TRUE`, id, b.SpID, b.Sectors)
+			} else {
+				n, err = tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_commit_msg = $1
+					WHERE sp_id = $2 AND sector_number = ANY($3) AND task_id_commit_msg IS NULL`, id, b.SpID, b.Sectors)
+			}
+			if err != nil {
+				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+			}
+			if n != len(b.Sectors) {
+				return false, xerrors.Errorf("expected to update %d rows, updated %d", len(b.Sectors), n)
+			}
+
+			return true, nil
+		})
+
+		if len(b.Sectors) > 1 {
+			log.Infow("starting batch", "message", b.Message, "sp", b.SpID, "sectors", len(b.Sectors), "reason", b.SendReason)
+		}
+	}
+
+	return nil
+}
+
+// InFlightBatch is a precommit or commit message task which didn't send its message yet.
+type InFlightBatch struct {
+	Message string    `db:"message"`
+	TaskID  int64     `db:"task_id"`
+	SpID    int64     `db:"sp_id"`
+	Sectors int       `db:"sectors"`
+	Posted  time.Time `db:"posted_time"`
+	Owner   *string   `db:"owner"`
+
+	MaxFee string
+	// Aggregated is whether the proofs of a commit batch are aggregated at the current base fee.
+	Aggregated bool
+}
+
+// BatchStatus is the state of precommit and commit batching.
+type BatchStatus struct {
+	BaseFee  string
+	Pending  []PendingBatch
+	InFlight []InFlightBatch
+}
+
+// GetBatchStatus returns the batches waiting to be sent under cfg at the current base fee, and the batch tasks
+// which didn't send their message yet.
+func GetBatchStatus(ctx context.Context, db *harmonydb.DB, ts *types.TipSet, cfg *config.CurioConfig) (*BatchStatus, error) {
+	baseFee := ts.MinTicketBlock().ParentBaseFee
+	out := &BatchStatus{
+		BaseFee:  types.FIL(baseFee).Short(),
+		Pending:  []PendingBatch{},
+		InFlight: []InFlightBatch{},
+	}
+
+	paused, err := PausedSPs(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, p := range []batchPolicy{
+		newBatchPolicy(BatchPreCommit, cfg.Batching.PreCommit),
+		newBatchPolicy(BatchCommit, cfg.Batching.Commit),
+	} {
+		ready, err := readyForBatch(ctx, db, p.message)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range p.plan(ready, baseFee, now) {
+			if b.Message == BatchPreCommit && paused[b.SpID] {
+				b.Paused = true
+				b.SendReason = ""
+			}
+			out.Pending = append(out.Pending, b)
+		}
+	}
+
+	var inFlight []InFlightBatch
+	err = db.Select(ctx, &inFlight, `SELECT 'precommit' AS message, p.task_id_precommit_msg AS task_id, p.sp_id,
+			COUNT(*) AS sectors, t.posted_time, hm.host_and_port AS owner
+		FROM sectors_sdr_pipeline p
+		JOIN harmony_task t ON t.id = p.task_id_precommit_msg
+		LEFT JOIN harmony_machines hm ON hm.id = t.owner_id
+		GROUP BY p.task_id_precommit_msg, p.sp_id, t.posted_time, hm.host_and_port
+		UNION ALL
+		SELECT 'commit' AS message, p.task_id_commit_msg AS task_id, p.sp_id,
+			COUNT(*) AS sectors, t.posted_time, hm.host_and_port AS owner
+		FROM sectors_sdr_pipeline p
+		JOIN harmony_task t ON t.id = p.task_id_commit_msg
+		LEFT JOIN harmony_machines hm ON hm.id = t.owner_id
+		GROUP BY p.task_id_commit_msg, p.sp_id, t.posted_time, hm.host_and_port
+		ORDER BY posted_time`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting in-flight batches: %w", err)
+	}
+
+	for i := range inFlight {
+		b := &inFlight[i]
+		if b.Message == BatchPreCommit {
+			b.MaxFee = types.FIL(batchMaxFee(cfg.Fees.MaxPreCommitGasFee, cfg.Fees.MaxPreCommitBatchGasFee, b.Sectors)).Short()
+			continue
+		}
+		b.MaxFee = types.FIL(batchMaxFee(cfg.Fees.MaxCommitGasFee, cfg.Fees.MaxCommitBatchGasFee, b.Sectors)).Short()
+		b.Aggregated = aggregateCommit(b.Sectors, baseFee, abi.TokenAmount(cfg.Batching.Commit.BaseFeeThreshold))
+	}
+	out.InFlight = append(out.InFlight, inFlight...)
+
+	return out, nil
+}
+
+// aggregateCommit returns whether the proofs of a commit batch of nSectors are aggregated at baseFee.
+func aggregateCommit(nSectors int, baseFee, threshold abi.TokenAmount) bool {
+	if nSectors < miner.MinAggregatedSectors {
+		return false
+	}
+	return threshold.Nil() || !baseFee.LessThan(threshold)
+}
@@ -0,0 +1,90 @@
+package seal
+
+import (
+	"github.com/docker/go-units"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/curio/deps/config"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+)
+
+// SynthPolicy decides whether new sectors use synthetic PoRep, from the Seal.SyntheticPoRep rules with
+// Subsystems.UseSyntheticPoRep as the default
+type SynthPolicy struct {
+	def   bool
+	rules []synthRule
+}
+
+type synthRule struct {
+	miners map[abi.ActorID]bool // nil for all miners
+	sizes  map[abi.SectorSize]bool
+	enable bool
+}
+
+func NewSynthPolicy(cfg *config.CurioConfig) (SynthPolicy, error) {
+	p := SynthPolicy{def: cfg.Subsystems.UseSyntheticPoRep}
+
+	for i, r := range cfg.Seal.SyntheticPoRep {
+		rule := synthRule{enable: r.Enable}
+
+		if len(r.Miners) > 0 {
+			rule.miners = map[abi.ActorID]bool{}
+			for _, m := range r.Miners {
+				maddr, err := address.NewFromString(m)
+				if err != nil {
+					return SynthPolicy{}, xerrors.Errorf("Seal.SyntheticPoRep[%d]: parsing miner %q: %w", i, m, err)
+				}
+				mid, err := address.IDFromAddress(maddr)
+				if err != nil {
+					return SynthPolicy{}, xerrors.Errorf("Seal.SyntheticPoRep[%d]: miner %q must be an ID address: %w", i, m, err)
+				}
+				rule.miners[abi.ActorID(mid)] = true
+			}
+		}
+
+		if len(r.SectorSizes) > 0 {
+			rule.sizes = map[abi.SectorSize]bool{}
+			for _, s := range r.SectorSizes {
+				ssize, err := units.RAMInBytes(s)
+				if err != nil {
+					return SynthPolicy{}, xerrors.Errorf("Seal.SyntheticPoRep[%d]: parsing sector size %q: %w", i, s, err)
+				}
+				rule.sizes[abi.SectorSize(ssize)] = true
+			}
+		}
+
+		p.rules = append(p.rules, rule)
+	}
+
+	return p, nil
+}
+
+// Use returns whether new sectors of a miner with a sector size use synthetic PoRep
+func (p SynthPolicy) Use(sp abi.ActorID, ssize abi.SectorSize) bool {
+	for _, r := range p.rules {
+		if r.miners != nil && !r.miners[sp] {
+			continue
+		}
+		if r.sizes != nil && !r.sizes[ssize] {
+			continue
+		}
+		return r.enable
+	}
+	return p.def
+}
+
+// SealProofType returns the seal proof type of new sectors of a miner, which records whether the sector uses
+// synthetic PoRep
+func (p SynthPolicy) SealProofType(nv network.Version, sp abi.ActorID, wpt abi.RegisteredPoStProof) (abi.RegisteredSealProof, error) {
+	ssize, err := wpt.SectorSize()
+	if err != nil {
+		return 0, xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	return miner.PreferredSealProofTypeFromWindowPoStType(nv, wpt, p.Use(sp, ssize))
+}
@@ -2,6 +2,7 @@ package seal
 
 import (
 	"context"
+	"time"
 
 	"golang.org/x/xerrors"
 
@@ -24,9 +25,11 @@ type FinalizeTask struct {
 
 	// Batch, nillable!
 	slots *slotmgr.SlotMgr
+
+	purge CachePurgePolicy
 }
 
-func NewFinalizeTask(max int, sp *SealPoller, sc *ffi.SealCalls, db *harmonydb.DB, slots *slotmgr.SlotMgr) *FinalizeTask {
+func NewFinalizeTask(max int, sp *SealPoller, sc *ffi.SealCalls, db *harmonydb.DB, slots *slotmgr.SlotMgr, purge CachePurgePolicy) *FinalizeTask {
 	return &FinalizeTask{
 		max: max,
 		sp:  sp,
@@ -34,6 +37,8 @@ func NewFinalizeTask(max int, sp *SealPoller, sc *ffi.SealCalls, db *harmonydb.D
 		db:  db,
 
 		slots: slots,
+
+		purge: purge,
 	}
 }
 
@@ -81,11 +86,17 @@ func (f *FinalizeTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (do
 	task := tasks[0]
 
 	var keepUnsealed bool
+	var pieces int64
 
-	if err := f.db.QueryRow(ctx, `SELECT COALESCE(BOOL_OR(NOT data_delete_on_finalize), FALSE) FROM sectors_sdr_initial_pieces WHERE sp_id = $1 AND sector_number = $2`, task.SpID, task.SectorNumber).Scan(&keepUnsealed); err != nil {
+	if err := f.db.QueryRow(ctx, `SELECT COALESCE(BOOL_OR(NOT data_delete_on_finalize), FALSE), COUNT(*) FROM sectors_sdr_initial_pieces WHERE sp_id = $1 AND sector_number = $2`, task.SpID, task.SectorNumber).Scan(&keepUnsealed, &pieces); err != nil {
 		return false, err
 	}
 
+	// unsealed copies of sectors with deals can be kept for a while even when no deal asked for one, CC sectors
+	// have nothing worth keeping
+	purgeUnsealed := pieces > 0 && !keepUnsealed
+	deferUnsealed := purgeUnsealed && f.purge.Unsealed != nil
+
 	sector := storiface.SectorRef{
 		ID: abi.SectorID{
 			Miner:  abi.ActorID(task.SpID),
@@ -140,11 +151,18 @@ func (f *FinalizeTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (do
 		}
 	}
 
-	err = f.sc.FinalizeSector(ctx, sector, keepUnsealed)
+	usage, err := f.sc.FinalizeSector(ctx, sector, keepUnsealed || deferUnsealed, ffi.CacheKeep{
+		Layers: f.purge.Layers != nil,
+		Trees:  f.purge.Trees != nil,
+	})
 	if err != nil {
 		return false, xerrors.Errorf("finalizing sector: %w", err)
 	}
 
+	if err := f.recordCachePurges(ctx, sector, usage, purgeUnsealed); err != nil {
+		return false, xerrors.Errorf("recording cache purges: %w", err)
+	}
+
 	if err := DropSectorPieceRefs(ctx, f.db, sector.ID); err != nil {
 		return false, xerrors.Errorf("dropping sector piece refs: %w", err)
 	}
@@ -199,6 +217,48 @@ func (f *FinalizeTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (do
 	return true, nil
 }
 
+// recordCachePurges records the sealing intermediates of a finalized sector in sector_cache_purges, as purged for the
+// parts removed at finalize and as pending for the parts kept by the purge policy
+func (f *FinalizeTask) recordCachePurges(ctx context.Context, sector storiface.SectorRef, usage ffi.CacheUsage, purgeUnsealed bool) error {
+	type cachePart struct {
+		part  string
+		bytes int64
+		delay *time.Duration
+	}
+	parts := []cachePart{
+		{CachePartLayers, usage.Layers, f.purge.Layers},
+		{CachePartTrees, usage.Trees, f.purge.Trees},
+	}
+	if purgeUnsealed {
+		ssize, err := sector.ProofType.SectorSize()
+		if err != nil {
+			return xerrors.Errorf("getting sector size: %w", err)
+		}
+		parts = append(parts, cachePart{CachePartUnsealed, int64(ssize), f.purge.Unsealed})
+	}
+
+	for _, p := range parts {
+		var delay *int64
+		if p.delay != nil {
+			d := int64(p.delay.Seconds())
+			delay = &d
+		}
+
+		// a retried finalize keeps the sizes measured by the first attempt
+		_, err := f.db.Exec(ctx, `INSERT INTO sector_cache_purges (sp_id, sector_number, part, bytes, delay_seconds, due_at, purged_at)
+			SELECT $1, $2, $3, $4, $5::BIGINT,
+				CASE WHEN $5::BIGINT IS NOT NULL AND p.after_commit_msg_success THEN current_timestamp + make_interval(secs => $5::BIGINT) END,
+				CASE WHEN $5::BIGINT IS NULL THEN current_timestamp END
+			FROM sectors_sdr_pipeline p WHERE p.sp_id = $1 AND p.sector_number = $2
+			ON CONFLICT (sp_id, sector_number, part) DO NOTHING`, sector.ID.Miner, sector.ID.Number, p.part, p.bytes, delay)
+		if err != nil {
+			return xerrors.Errorf("recording %s: %w", p.part, err)
+		}
+	}
+
+	return nil
+}
+
 func (f *FinalizeTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
 	var tasks []struct {
 		TaskID       harmonytask.TaskID `db:"task_id_finalize"`
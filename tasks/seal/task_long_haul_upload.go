@@ -0,0 +1,265 @@
+package seal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	ffi2 "github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// uploadBusyFraction is the fraction of Seal.LongHaul.UploadBandwidth used by running uploads above which no new
+// uploads are started
+const uploadBusyFraction = 0.9
+
+// LongHaulUploadTask uploads finalized sectors sealed on this long-haul machine to storage of another node, in place
+// of MoveStorage.
+type LongHaulUploadTask struct {
+	sp *SealPoller
+	sc *ffi2.SealCalls
+	db *harmonydb.DB
+
+	max       int
+	chunkSize int64
+
+	// bandwidth is the upload bandwidth in bytes per second shared by all uploads, 0 when unlimited
+	bandwidth int64
+	limiter   *rate.Limiter
+	meter     *bandwidthMeter
+}
+
+func NewLongHaulUploadTask(sp *SealPoller, sc *ffi2.SealCalls, db *harmonydb.DB, cfg config.LongHaulConfig) (*LongHaulUploadTask, error) {
+	t := &LongHaulUploadTask{
+		sp:    sp,
+		sc:    sc,
+		db:    db,
+		max:   cfg.MaxParallelUploads,
+		meter: &bandwidthMeter{},
+	}
+
+	if cfg.ChunkSize != "" {
+		cs, err := units.RAMInBytes(cfg.ChunkSize)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing Seal.LongHaul.ChunkSize: %w", err)
+		}
+		t.chunkSize = cs
+	}
+
+	if cfg.UploadBandwidth != "" {
+		bw, err := units.RAMInBytes(cfg.UploadBandwidth)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing Seal.LongHaul.UploadBandwidth: %w", err)
+		}
+		if bw > 0 {
+			t.bandwidth = bw
+			t.limiter = rate.NewLimiter(rate.Limit(bw), int(min(bw, 1<<20)))
+		}
+	}
+
+	return t, nil
+}
+
+func (l *LongHaulUploadTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var tasks []struct {
+		SpID         int64   `db:"sp_id"`
+		SectorNumber int64   `db:"sector_number"`
+		RegSealProof int64   `db:"reg_seal_proof"`
+		Dest         *string `db:"long_haul_dest"`
+	}
+
+	err = l.db.Select(ctx, &tasks, `
+		SELECT sp_id, sector_number, reg_seal_proof, long_haul_dest FROM sectors_sdr_pipeline WHERE task_id_long_haul_upload = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting task: %w", err)
+	}
+	if len(tasks) != 1 {
+		return false, xerrors.Errorf("expected one task")
+	}
+	task := tasks[0]
+
+	sector := storiface.SectorRef{
+		ID: abi.SectorID{
+			Miner:  abi.ActorID(task.SpID),
+			Number: abi.SectorNumber(task.SectorNumber),
+		},
+		ProofType: abi.RegisteredSealProof(task.RegSealProof),
+	}
+
+	toUpload, err := l.sc.LongHaulTypes(ctx, sector)
+	if err != nil {
+		return false, xerrors.Errorf("getting sector files to upload: %w", err)
+	}
+
+	if toUpload != storiface.FTNone {
+		// the destination is kept, so that a retried upload resumes where the last one stopped
+		var dest storiface.ID
+		if task.Dest != nil {
+			dest = storiface.ID(*task.Dest)
+		} else {
+			dest, err = l.sc.UploadDestination(ctx, sector, toUpload)
+			if err != nil {
+				return false, err
+			}
+
+			_, err = l.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET long_haul_dest = $2 WHERE task_id_long_haul_upload = $1`, taskID, string(dest))
+			if err != nil {
+				return false, xerrors.Errorf("storing upload destination: %w", err)
+			}
+		}
+
+		start := time.Now()
+		var sent int64
+		err = l.sc.UploadSector(ctx, sector, toUpload, dest, paths.UploadOptions{
+			ChunkSize: l.chunkSize,
+			Limiter:   l.limiter,
+			Progress: func(n int64) {
+				l.meter.add(n)
+				sent += n
+			},
+		})
+		if errors.Is(err, paths.ErrUploadDestRejected) {
+			// pick another destination on retry
+			_, derr := l.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET long_haul_dest = NULL WHERE task_id_long_haul_upload = $1`, taskID)
+			if derr != nil {
+				return false, xerrors.Errorf("clearing upload destination: %w (upload: %w)", derr, err)
+			}
+		}
+		if err != nil {
+			return false, xerrors.Errorf("uploading sector: %w", err)
+		}
+
+		log.Infow("long-haul sector uploaded", "sector", sector.ID, "types", toUpload, "dest", dest,
+			"sent", units.BytesSize(float64(sent)), "took", time.Since(start))
+	}
+
+	_, err = l.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_move_storage = TRUE, task_id_long_haul_upload = NULL WHERE task_id_long_haul_upload = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("updating task: %w", err)
+	}
+
+	return true, nil
+}
+
+func (l *LongHaulUploadTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ids, err := l.sp.acceptLongHaul(context.Background(), ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// WAN bandwidth is what uploads are limited by, more uploads would only slow down the running ones
+	if l.bandwidth > 0 {
+		if r := l.meter.rate(); float64(r) >= uploadBusyFraction*float64(l.bandwidth) {
+			log.Debugw("did not accept task", "name", "LongHaulUpload", "reason", "upload bandwidth in use",
+				"rate", units.BytesSize(float64(r)), "bandwidth", units.BytesSize(float64(l.bandwidth)))
+			return nil, nil
+		}
+	}
+
+	id := ids[0]
+	return &id, nil
+}
+
+func (l *LongHaulUploadTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(l.max),
+		Name: "LongHaulUpload",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Gpu: 0,
+			Ram: 128 << 20,
+		},
+		MaxFailures: 10,
+	}
+}
+
+func (l *LongHaulUploadTask) GetSpid(db *harmonydb.DB, taskID int64) string {
+	sid, err := l.GetSectorID(db, taskID)
+	if err != nil {
+		log.Errorf("getting sector id: %s", err)
+		return ""
+	}
+	return sid.Miner.String()
+}
+
+func (l *LongHaulUploadTask) GetSectorID(db *harmonydb.DB, taskID int64) (*abi.SectorID, error) {
+	var spId, sectorNumber uint64
+	err := db.QueryRow(context.Background(), `SELECT sp_id,sector_number FROM sectors_sdr_pipeline WHERE task_id_long_haul_upload = $1`, taskID).Scan(&spId, &sectorNumber)
+	if err != nil {
+		return nil, err
+	}
+	return &abi.SectorID{
+		Miner:  abi.ActorID(spId),
+		Number: abi.SectorNumber(sectorNumber),
+	}, nil
+}
+
+func (l *LongHaulUploadTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+	l.sp.pollers[pollerLongHaulUpload].Set(taskFunc)
+}
+
+var _ = harmonytask.Reg(&LongHaulUploadTask{})
+var _ harmonytask.TaskInterface = &LongHaulUploadTask{}
+
+// meterWindow is the number of seconds bandwidthMeter averages over
+const meterWindow = 10
+
+// bandwidthMeter measures the throughput of uploads over the last meterWindow seconds
+type bandwidthMeter struct {
+	lk      sync.Mutex
+	buckets [meterWindow]int64 // bytes sent in each second of the window
+	last    int64              // unix second of the latest bucket
+}
+
+func (m *bandwidthMeter) add(n int64) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.advance(time.Now().Unix())
+	m.buckets[m.last%meterWindow] += n
+}
+
+// rate returns the average throughput in bytes per second
+func (m *bandwidthMeter) rate() int64 {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.advance(time.Now().Unix())
+	var total int64
+	for _, b := range m.buckets {
+		total += b
+	}
+	return total / meterWindow
+}
+
+// advance clears the buckets of seconds which passed since the latest one
+func (m *bandwidthMeter) advance(now int64) {
+	if now-m.last >= meterWindow {
+		m.buckets = [meterWindow]int64{}
+	} else {
+		for s := m.last + 1; s <= now; s++ {
+			m.buckets[s%meterWindow] = 0
+		}
+	}
+	if now > m.last {
+		m.last = now
+	}
+}
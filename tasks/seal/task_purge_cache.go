@@ -0,0 +1,228 @@
+package seal
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/lib/passcall"
+	storiface "github.com/filecoin-project/curio/lib/storiface"
+)
+
+const purgeCachePollInterval = time.Minute
+
+// PurgeCacheTask removes the sealing intermediates kept by the cache purge policy once they are due. Layers and
+// trees are removed from the sealing cache by the node holding it, unsealed copies are marked for removal, approved
+// by policy, wherever they are.
+type PurgeCacheTask struct {
+	sc  *ffi.SealCalls
+	db  *harmonydb.DB
+	max int
+}
+
+func NewPurgeCacheTask(sc *ffi.SealCalls, db *harmonydb.DB, max int) *PurgeCacheTask {
+	return &PurgeCacheTask{
+		sc:  sc,
+		db:  db,
+		max: max,
+	}
+}
+
+func (p *PurgeCacheTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var parts []struct {
+		SpID         int64  `db:"sp_id"`
+		SectorNumber int64  `db:"sector_number"`
+		Part         string `db:"part"`
+		RegSealProof int64  `db:"reg_seal_proof"`
+	}
+	err = p.db.Select(ctx, &parts, `SELECT c.sp_id, c.sector_number, c.part, s.reg_seal_proof FROM sector_cache_purges c
+		INNER JOIN sectors_sdr_pipeline s ON s.sp_id = c.sp_id AND s.sector_number = c.sector_number
+		WHERE c.task_id = $1 AND c.purged_at IS NULL`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting cache purges: %w", err)
+	}
+	if len(parts) == 0 {
+		return true, nil
+	}
+
+	sector := storiface.SectorRef{
+		ID: abi.SectorID{
+			Miner:  abi.ActorID(parts[0].SpID),
+			Number: abi.SectorNumber(parts[0].SectorNumber),
+		},
+		ProofType: abi.RegisteredSealProof(parts[0].RegSealProof),
+	}
+
+	var layers, trees, unsealed bool
+	for _, part := range parts {
+		switch part.Part {
+		case CachePartLayers:
+			layers = true
+		case CachePartTrees:
+			trees = true
+		case CachePartUnsealed:
+			unsealed = true
+		}
+	}
+
+	if layers || trees {
+		// the rest of the cache is cleared with the last intermediates
+		var otherPending bool
+		err := p.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sector_cache_purges WHERE sp_id = $1 AND sector_number = $2
+			AND part IN ('layers', 'trees') AND purged_at IS NULL AND task_id IS DISTINCT FROM $3)`,
+			sector.ID.Miner, sector.ID.Number, taskID).Scan(&otherPending)
+		if err != nil {
+			return false, xerrors.Errorf("checking other pending purges: %w", err)
+		}
+
+		if err := p.sc.PurgeSectorCache(ctx, sector, layers, trees, !otherPending); err != nil {
+			return false, xerrors.Errorf("purging sector cache: %w", err)
+		}
+	}
+
+	_, err = p.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		var note *string
+		if unsealed {
+			// copies requested since finalize, e.g. for retrievals, are left to the unsealed cache
+			var wanted bool
+			err = tx.QueryRow(`SELECT COALESCE(BOOL_OR(target_unseal_state), FALSE) FROM sectors_meta WHERE sp_id = $1 AND sector_num = $2`,
+				sector.ID.Miner, sector.ID.Number).Scan(&wanted)
+			if err != nil {
+				return false, xerrors.Errorf("getting target unseal state: %w", err)
+			}
+
+			if wanted {
+				n := "unsealed copy is requested, left to the unsealed cache"
+				note = &n
+			} else {
+				_, err = tx.Exec(`INSERT INTO storage_removal_marks (sp_id, sector_num, sector_filetype, storage_id, approved, approved_at)
+					SELECT sl.miner_id, sl.sector_num, sl.sector_filetype, sl.storage_id, TRUE, current_timestamp FROM sector_location sl
+					INNER JOIN storage_path sp ON sp.storage_id = sl.storage_id
+					WHERE sl.miner_id = $1 AND sl.sector_num = $2 AND sl.sector_filetype = 1 AND NOT sp.archive
+					ON CONFLICT (sp_id, sector_num, sector_filetype, storage_id) DO UPDATE SET approved = TRUE, approved_at = current_timestamp`,
+					sector.ID.Miner, sector.ID.Number) // FTUnsealed = 1
+				if err != nil {
+					return false, xerrors.Errorf("marking unsealed copies for removal: %w", err)
+				}
+			}
+		}
+
+		_, err = tx.Exec(`UPDATE sector_cache_purges SET purged_at = current_timestamp, task_id = NULL,
+				note = CASE WHEN part = 'unsealed' THEN $2 ELSE note END
+			WHERE task_id = $1`, taskID, note)
+		if err != nil {
+			return false, xerrors.Errorf("marking parts purged: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (p *PurgeCacheTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ctx := context.Background()
+
+	indIDs := make([]int64, len(ids))
+	for i, id := range ids {
+		indIDs[i] = int64(id)
+	}
+
+	// layers and trees are only purged by the node with the sealing cache, like finalize
+	var tasks []struct {
+		TaskID     harmonytask.TaskID `db:"task_id"`
+		NeedsLocal bool               `db:"needs_local"`
+		StorageID  *string            `db:"storage_id"`
+	}
+	err := p.db.Select(ctx, &tasks, `SELECT c.task_id, BOOL_OR(c.part IN ('layers', 'trees')) AS needs_local, MIN(l.storage_id) AS storage_id
+		FROM sector_cache_purges c
+		LEFT JOIN sector_location l ON l.miner_id = c.sp_id AND l.sector_num = c.sector_number AND l.sector_filetype = 4
+		WHERE c.task_id = ANY ($1)
+		GROUP BY c.task_id`, indIDs) // FTCache = 4
+	if err != nil {
+		return nil, xerrors.Errorf("getting tasks: %w", err)
+	}
+
+	ls, err := p.sc.LocalStorage(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage: %w", err)
+	}
+
+	for _, t := range tasks {
+		if !t.NeedsLocal {
+			return &t.TaskID, nil
+		}
+		if t.StorageID == nil {
+			continue
+		}
+		for _, l := range ls {
+			if string(l.ID) == *t.StorageID {
+				return &t.TaskID, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (p *PurgeCacheTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(p.max),
+		Name: "PurgeCache",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		MaxFailures: 10,
+		IAmBored: passcall.Every(purgeCachePollInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return p.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (p *PurgeCacheTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var due []struct {
+			SpID         int64 `db:"sp_id"`
+			SectorNumber int64 `db:"sector_number"`
+		}
+		err := tx.Select(&due, `SELECT sp_id, sector_number FROM sector_cache_purges
+			WHERE purged_at IS NULL AND task_id IS NULL AND due_at <= current_timestamp
+			ORDER BY due_at LIMIT 1`)
+		if err != nil {
+			return false, xerrors.Errorf("getting due cache purges: %w", err)
+		}
+		if len(due) == 0 {
+			return false, nil
+		}
+
+		// all due parts of the sector are purged by one task
+		_, err = tx.Exec(`UPDATE sector_cache_purges SET task_id = $1
+			WHERE sp_id = $2 AND sector_number = $3 AND purged_at IS NULL AND task_id IS NULL AND due_at <= current_timestamp`,
+			id, due[0].SpID, due[0].SectorNumber)
+		if err != nil {
+			return false, xerrors.Errorf("assigning cache purges: %w", err)
+		}
+		return true, nil
+	})
+
+	return nil
+}
+
+func (p *PurgeCacheTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ harmonytask.TaskInterface = &PurgeCacheTask{}
+var _ = harmonytask.Reg(&PurgeCacheTask{})
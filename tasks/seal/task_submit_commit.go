@@ -6,8 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
 
+	ffi "github.com/filecoin-project/filecoin-ffi"
 	"github.com/filecoin-project/go-address"
 	cborutil "github.com/filecoin-project/go-cbor-util"
 	"github.com/filecoin-project/go-state-types/abi"
@@ -16,6 +18,8 @@ import (
 	miner2 "github.com/filecoin-project/go-state-types/builtin/v13/miner"
 	verifreg13 "github.com/filecoin-project/go-state-types/builtin/v13/verifreg"
 	verifregtypes9 "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/go-state-types/proof"
 
 	"github.com/filecoin-project/curio/deps/config"
 	"github.com/filecoin-project/curio/harmony/harmonydb"
@@ -28,10 +32,15 @@ import (
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/market"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/storage/ctladdr"
 )
 
+// MaxCommitBatchFailures is how many times a sector of a commit batch can fail to be prepared, being put back for
+// another batch each time, before it's marked failed
+const MaxCommitBatchFailures = 5
+
 type SubmitCommitAPI interface {
 	ChainHead(context.Context) (*types.TipSet, error)
 	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
@@ -40,11 +49,14 @@ type SubmitCommitAPI interface {
 	StateGetAllocation(ctx context.Context, clientAddr address.Address, allocationId verifregtypes9.AllocationId, tsk types.TipSetKey) (*verifregtypes9.Allocation, error)
 	StateGetAllocationIdForPendingDeal(ctx context.Context, dealId abi.DealID, tsk types.TipSetKey) (verifregtypes9.AllocationId, error)
 	StateMinerAvailableBalance(context.Context, address.Address, types.TipSetKey) (big.Int, error)
+	StateNetworkVersion(context.Context, types.TipSetKey) (network.Version, error)
 	ctladdr.NodeApi
 }
 
 type commitConfig struct {
 	maxFee                     types.FIL
+	maxBatchFee                config.BatchFeeConfig
+	aggregateAboveBaseFee      abi.TokenAmount
 	RequireActivationSuccess   bool
 	RequireNotificationSuccess bool
 	CollateralFromMinerBalance bool
@@ -65,6 +77,8 @@ func NewSubmitCommitTask(sp *SealPoller, db *harmonydb.DB, api SubmitCommitAPI,
 
 	cnfg := commitConfig{
 		maxFee:                     cfg.Fees.MaxCommitGasFee,
+		maxBatchFee:                cfg.Fees.MaxCommitBatchGasFee,
+		aggregateAboveBaseFee:      abi.TokenAmount(cfg.Batching.Commit.BaseFeeThreshold),
 		RequireActivationSuccess:   cfg.Subsystems.RequireActivationSuccess,
 		RequireNotificationSuccess: cfg.Subsystems.RequireNotificationSuccess,
 		CollateralFromMinerBalance: cfg.Fees.CollateralFromMinerBalance,
@@ -104,28 +118,225 @@ func (s *SubmitCommitTask) GetSectorID(db *harmonydb.DB, taskID int64) (*abi.Sec
 
 var _ = harmonytask.Reg(&SubmitCommitTask{})
 
+type commitSectorParams struct {
+	SpID         int64                   `db:"sp_id"`
+	SectorNumber int64                   `db:"sector_number"`
+	RegSealProof abi.RegisteredSealProof `db:"reg_seal_proof"`
+	Proof        []byte                  `db:"porep_proof"`
+	TicketValue  []byte                  `db:"ticket_value"`
+	SeedValue    []byte                  `db:"seed_value"`
+	UnsealedCID  string                  `db:"tree_d_cid"`
+}
+
 func (s *SubmitCommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
 	ctx := context.Background()
 
-	var sectorParamsArr []struct {
-		SpID         int64  `db:"sp_id"`
-		SectorNumber int64  `db:"sector_number"`
-		Proof        []byte `db:"porep_proof"`
-	}
+	var sectorParamsArr []commitSectorParams
 
 	err = s.db.Select(ctx, &sectorParamsArr, `
-		SELECT sp_id, sector_number, porep_proof
+		SELECT sp_id, sector_number, reg_seal_proof, porep_proof, ticket_value, seed_value, tree_d_cid
 		FROM sectors_sdr_pipeline
-		WHERE task_id_commit_msg = $1`, taskID)
+		WHERE task_id_commit_msg = $1 ORDER BY sector_number`, taskID)
 	if err != nil {
 		return false, xerrors.Errorf("getting sector params: %w", err)
 	}
 
-	if len(sectorParamsArr) != 1 {
-		return false, xerrors.Errorf("expected 1 sector params, got %d", len(sectorParamsArr))
+	if len(sectorParamsArr) == 0 {
+		return false, xerrors.Errorf("expected at least 1 sector params, got 0")
+	}
+	spID := sectorParamsArr[0].SpID
+	for _, sp := range sectorParamsArr {
+		if sp.SpID != spID || sp.RegSealProof != sectorParamsArr[0].RegSealProof {
+			return false, xerrors.Errorf("batch contains sectors of different SPs or seal proofs")
+		}
+	}
+
+	maddr, err := address.NewIDAddress(uint64(spID))
+	if err != nil {
+		return false, xerrors.Errorf("getting miner address: %w", err)
+	}
+
+	ts, err := s.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	mi, err := s.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+	if err != nil {
+		return false, xerrors.Errorf("getting miner info: %w", err)
+	}
+
+	params := miner.ProveCommitSectors3Params{
+		RequireActivationSuccess:   s.cfg.RequireActivationSuccess,
+		RequireNotificationSuccess: s.cfg.RequireNotificationSuccess,
+	}
+
+	collateral := big.Zero()
+	var sectors []int64
+	var infos []proof.AggregateSealVerifyInfo
+
+	for _, sectorParams := range sectorParamsArr {
+		cs, err := s.prepareSector(ctx, ts, maddr, sectorParams)
+		if err != nil {
+			if len(sectorParamsArr) == 1 {
+				return false, err
+			}
+
+			if perr := s.dropFromBatch(ctx, taskID, spID, sectorParams.SectorNumber, err); perr != nil {
+				return false, perr
+			}
+			continue
+		}
+
+		params.SectorActivations = append(params.SectorActivations, cs.activation)
+		params.SectorProofs = append(params.SectorProofs, sectorParams.Proof)
+		infos = append(infos, cs.info)
+		sectors = append(sectors, sectorParams.SectorNumber)
+		collateral = big.Add(collateral, cs.collateral)
 	}
-	sectorParams := sectorParamsArr[0]
 
+	if len(sectors) == 0 {
+		// the sectors went back to the poller, or were failed
+		log.Warnw("no sectors left in the commit batch", "sp", spID)
+		return true, nil
+	}
+
+	baseFee := ts.MinTicketBlock().ParentBaseFee
+	if aggregateCommit(len(sectors), baseFee, s.cfg.aggregateAboveBaseFee) {
+		nv, err := s.api.StateNetworkVersion(ctx, ts.Key())
+		if err != nil {
+			return false, xerrors.Errorf("getting network version: %w", err)
+		}
+
+		arp := abi.RegisteredAggregationProof_SnarkPackV2
+		params.AggregateProof, err = ffi.AggregateSealProofs(proof.AggregateSealVerifyProofAndInfos{
+			Miner:          abi.ActorID(spID),
+			SealProof:      sectorParamsArr[0].RegSealProof,
+			AggregateProof: arp,
+			Infos:          infos,
+		}, params.SectorProofs)
+		if err != nil {
+			return false, xerrors.Errorf("aggregating proofs: %w", err)
+		}
+		params.AggregateProofType = &arp
+		params.SectorProofs = nil // can't be set when aggregating
+
+		aggFee, err := policy.AggregateProveCommitNetworkFee(nv, len(sectors), baseFee)
+		if err != nil {
+			return false, xerrors.Errorf("getting aggregate commit network fee: %w", err)
+		}
+		// leave room for the base fee to rise before the message lands
+		collateral = big.Add(collateral, big.Div(big.Mul(aggFee, big.NewInt(110)), big.NewInt(100)))
+	}
+
+	enc := new(bytes.Buffer)
+	if err := params.MarshalCBOR(enc); err != nil {
+		return false, xerrors.Errorf("could not serialize commit params: %w", err)
+	}
+
+	if s.cfg.CollateralFromMinerBalance {
+		if s.cfg.DisableCollateralFallback {
+			collateral = big.Zero()
+		}
+		balance, err := s.api.StateMinerAvailableBalance(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			if err != nil {
+				return false, xerrors.Errorf("getting miner balance: %w", err)
+			}
+		}
+		collateral = big.Sub(collateral, balance)
+		if collateral.LessThan(big.Zero()) {
+			collateral = big.Zero()
+		}
+	}
+
+	a, _, err := s.as.AddressFor(ctx, s.api, maddr, mi, api.CommitAddr, collateral, big.Zero())
+	if err != nil {
+		return false, xerrors.Errorf("getting address for precommit: %w", err)
+	}
+
+	msg := &types.Message{
+		To:     maddr,
+		From:   a,
+		Method: builtin.MethodsMiner.ProveCommitSectors3,
+		Params: enc.Bytes(),
+		Value:  collateral,
+	}
+
+	// funded messages are proposed to the funds multisig when one is configured
+	msg, _, err = s.as.MultisigProposal(maddr, msg)
+	if err != nil {
+		return false, xerrors.Errorf("creating multisig proposal: %w", err)
+	}
+
+	mss := &api.MessageSendSpec{
+		MaxFee: batchMaxFee(s.cfg.maxFee, s.cfg.maxBatchFee, len(sectors)),
+	}
+
+	mcid, err := s.sender.Send(ctx, msg, mss, "commit")
+	if err != nil {
+		return false, xerrors.Errorf("pushing message to mpool: %w", err)
+	}
+
+	_, err = s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET commit_msg_cid = $1, after_commit_msg = TRUE, task_id_commit_msg = NULL
+		WHERE task_id_commit_msg = $2 AND sp_id = $3 AND sector_number = ANY($4)`, mcid, taskID, spID, sectors)
+	if err != nil {
+		return false, xerrors.Errorf("updating commit_msg_cid: %w", err)
+	}
+
+	_, err = s.db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid) VALUES ($1)`, mcid)
+	if err != nil {
+		return false, xerrors.Errorf("inserting into message_waits: %w", err)
+	}
+
+	for _, sn := range sectors {
+		if err := s.transferFinalizedSectorData(ctx, spID, sn); err != nil {
+			return false, xerrors.Errorf("transferring finalized sector data: %w", err)
+		}
+	}
+
+	if len(sectors) > 1 {
+		log.Infow("sent commit batch", "sp", spID, "sectors", len(sectors), "aggregated", params.AggregateProofType != nil, "cid", mcid)
+	}
+
+	return true, nil
+}
+
+// dropFromBatch removes a sector which couldn't be prepared from the commit batch. The sector goes back to the
+// poller to be retried in another batch, until it failed MaxCommitBatchFailures times and is marked failed.
+func (s *SubmitCommitTask) dropFromBatch(ctx context.Context, taskID harmonytask.TaskID, spID, sectorNumber int64, cause error) error {
+	var failures int
+	err := s.db.QueryRow(ctx, `UPDATE sectors_sdr_pipeline SET task_id_commit_msg = NULL, commit_batch_failures = commit_batch_failures + 1
+		WHERE task_id_commit_msg = $1 AND sp_id = $2 AND sector_number = $3 RETURNING commit_batch_failures`,
+		taskID, spID, sectorNumber).Scan(&failures)
+	if err != nil {
+		return xerrors.Errorf("removing sector %d from batch: %w", sectorNumber, err)
+	}
+
+	if failures < MaxCommitBatchFailures {
+		log.Errorw("removed sector from commit batch", "sp", spID, "sector", sectorNumber, "failures", failures, "error", cause)
+		return nil
+	}
+
+	_, err = s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline
+		SET failed = TRUE, failed_at = NOW(), failed_reason = 'commit-prepare', failed_reason_msg = $3
+		WHERE sp_id = $1 AND sector_number = $2`, spID, sectorNumber, cause.Error())
+	if err != nil {
+		return xerrors.Errorf("persisting commit failure: %w", err)
+	}
+	log.Errorw("sector commit failed", "sp", spID, "sector", sectorNumber, "failures", failures, "error", cause)
+	return nil
+}
+
+type commitSector struct {
+	activation miner.SectorActivationManifest
+	info       proof.AggregateSealVerifyInfo // for proof aggregation
+	collateral abi.TokenAmount
+}
+
+// prepareSector returns the activation manifest of a sector, its proof aggregation info and the collateral needed
+// to commit it.
+func (s *SubmitCommitTask) prepareSector(ctx context.Context, ts *types.TipSet, maddr address.Address, sectorParams commitSectorParams) (*commitSector, error) {
 	var pieces []struct {
 		PieceIndex int64           `db:"piece_index"`
 		PieceCID   string          `db:"piece_cid"`
@@ -135,7 +346,7 @@ func (s *SubmitCommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 		DealID     abi.DealID      `db:"f05_deal_id"`
 	}
 
-	err = s.db.Select(ctx, &pieces, `
+	err := s.db.Select(ctx, &pieces, `
 		SELECT piece_index,
 		       piece_cid,
 		       piece_size,
@@ -145,39 +356,18 @@ func (s *SubmitCommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 		FROM sectors_sdr_initial_pieces
 		WHERE sp_id = $1 AND sector_number = $2 ORDER BY piece_index ASC`, sectorParams.SpID, sectorParams.SectorNumber)
 	if err != nil {
-		return false, xerrors.Errorf("getting pieces: %w", err)
-	}
-
-	maddr, err := address.NewIDAddress(uint64(sectorParams.SpID))
-	if err != nil {
-		return false, xerrors.Errorf("getting miner address: %w", err)
-	}
-
-	ts, err := s.api.ChainHead(ctx)
-	if err != nil {
-		return false, xerrors.Errorf("getting chain head: %w", err)
+		return nil, xerrors.Errorf("getting pieces: %w", err)
 	}
 
 	pci, err := s.api.StateSectorPreCommitInfo(ctx, maddr, abi.SectorNumber(sectorParams.SectorNumber), ts.Key())
 	if err != nil {
-		return false, xerrors.Errorf("getting precommit info: %w", err)
+		return nil, xerrors.Errorf("getting precommit info: %w", err)
 	}
 	if pci == nil {
-		return false, xerrors.Errorf("precommit info not found on chain")
-	}
-
-	mi, err := s.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
-	if err != nil {
-		return false, xerrors.Errorf("getting miner info: %w", err)
+		return nil, xerrors.Errorf("precommit info not found on chain")
 	}
 
 	var verifiedSize int64
-
-	params := miner.ProveCommitSectors3Params{
-		RequireActivationSuccess:   s.cfg.RequireActivationSuccess,
-		RequireNotificationSuccess: s.cfg.RequireNotificationSuccess,
-	}
-
 	var pams []miner.PieceActivationManifest
 
 	for _, piece := range pieces {
@@ -185,20 +375,20 @@ func (s *SubmitCommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 			var prop *market.DealProposal
 			err = json.Unmarshal(piece.Proposal, &prop)
 			if err != nil {
-				return false, xerrors.Errorf("marshalling json to deal proposal: %w", err)
+				return nil, xerrors.Errorf("marshalling json to deal proposal: %w", err)
 			}
 			alloc, err := s.api.StateGetAllocationIdForPendingDeal(ctx, piece.DealID, types.EmptyTSK)
 			if err != nil {
-				return false, xerrors.Errorf("getting allocation for deal %d: %w", piece.DealID, err)
+				return nil, xerrors.Errorf("getting allocation for deal %d: %w", piece.DealID, err)
 			}
 			clid, err := s.api.StateLookupID(ctx, prop.Client, types.EmptyTSK)
 			if err != nil {
-				return false, xerrors.Errorf("getting client address for deal %d: %w", piece.DealID, err)
+				return nil, xerrors.Errorf("getting client address for deal %d: %w", piece.DealID, err)
 			}
 
 			clientId, err := address.IDFromAddress(clid)
 			if err != nil {
-				return false, xerrors.Errorf("getting client address for deal %d: %w", piece.DealID, err)
+				return nil, xerrors.Errorf("getting client address for deal %d: %w", piece.DealID, err)
 			}
 
 			var vac *miner2.VerifiedAllocationKey
@@ -212,7 +402,7 @@ func (s *SubmitCommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 
 			payload, err := cborutil.Dump(piece.DealID)
 			if err != nil {
-				return false, xerrors.Errorf("serializing deal id: %w", err)
+				return nil, xerrors.Errorf("serializing deal id: %w", err)
 			}
 
 			pams = append(pams, miner.PieceActivationManifest{
@@ -230,35 +420,24 @@ func (s *SubmitCommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 			var pam *miner.PieceActivationManifest
 			err = json.Unmarshal(piece.Manifest, &pam)
 			if err != nil {
-				return false, xerrors.Errorf("marshalling json to PieceManifest: %w", err)
+				return nil, xerrors.Errorf("marshalling json to PieceManifest: %w", err)
 			}
 			_, err = AllocationCheck(ctx, s.api, pam, pci.Info.Expiration, abi.ActorID(sectorParams.SpID), ts)
 			if err != nil {
-				return false, err
+				return nil, err
 			}
 			pams = append(pams, *pam)
 		}
 	}
 
-	params.SectorActivations = append(params.SectorActivations, miner.SectorActivationManifest{
-		SectorNumber: abi.SectorNumber(sectorParams.SectorNumber),
-		Pieces:       pams,
-	})
-	params.SectorProofs = append(params.SectorProofs, sectorParams.Proof)
-
-	enc := new(bytes.Buffer)
-	if err := params.MarshalCBOR(enc); err != nil {
-		return false, xerrors.Errorf("could not serialize commit params: %w", err)
-	}
-
 	ssize, err := pci.Info.SealProof.SectorSize()
 	if err != nil {
-		return false, xerrors.Errorf("could not get sector size: %w", err)
+		return nil, xerrors.Errorf("could not get sector size: %w", err)
 	}
 
 	collateral, err := s.api.StateMinerInitialPledgeForSector(ctx, pci.Info.Expiration-ts.Height(), ssize, uint64(verifiedSize), ts.Key())
 	if err != nil {
-		return false, xerrors.Errorf("getting initial pledge collateral: %w", err)
+		return nil, xerrors.Errorf("getting initial pledge collateral: %w", err)
 	}
 
 	collateral = big.Sub(collateral, pci.PreCommitDeposit)
@@ -266,59 +445,25 @@ func (s *SubmitCommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 		collateral = big.Zero()
 	}
 
-	if s.cfg.CollateralFromMinerBalance {
-		if s.cfg.DisableCollateralFallback {
-			collateral = big.Zero()
-		}
-		balance, err := s.api.StateMinerAvailableBalance(ctx, maddr, types.EmptyTSK)
-		if err != nil {
-			if err != nil {
-				return false, xerrors.Errorf("getting miner balance: %w", err)
-			}
-		}
-		collateral = big.Sub(collateral, balance)
-		if collateral.LessThan(big.Zero()) {
-			collateral = big.Zero()
-		}
-	}
-
-	a, _, err := s.as.AddressFor(ctx, s.api, maddr, mi, api.CommitAddr, collateral, big.Zero())
+	unsealedCID, err := cid.Parse(sectorParams.UnsealedCID)
 	if err != nil {
-		return false, xerrors.Errorf("getting address for precommit: %w", err)
+		return nil, xerrors.Errorf("parsing unsealed CID: %w", err)
 	}
 
-	msg := &types.Message{
-		To:     maddr,
-		From:   a,
-		Method: builtin.MethodsMiner.ProveCommitSectors3,
-		Params: enc.Bytes(),
-		Value:  collateral,
-	}
-
-	mss := &api.MessageSendSpec{
-		MaxFee: abi.TokenAmount(s.cfg.maxFee),
-	}
-
-	mcid, err := s.sender.Send(ctx, msg, mss, "commit")
-	if err != nil {
-		return false, xerrors.Errorf("pushing message to mpool: %w", err)
-	}
-
-	_, err = s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET commit_msg_cid = $1, after_commit_msg = TRUE, task_id_commit_msg = NULL WHERE sp_id = $2 AND sector_number = $3`, mcid, sectorParams.SpID, sectorParams.SectorNumber)
-	if err != nil {
-		return false, xerrors.Errorf("updating commit_msg_cid: %w", err)
-	}
-
-	_, err = s.db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid) VALUES ($1)`, mcid)
-	if err != nil {
-		return false, xerrors.Errorf("inserting into message_waits: %w", err)
-	}
-
-	if err := s.transferFinalizedSectorData(ctx, sectorParams.SpID, sectorParams.SectorNumber); err != nil {
-		return false, xerrors.Errorf("transferring finalized sector data: %w", err)
-	}
-
-	return true, nil
+	return &commitSector{
+		activation: miner.SectorActivationManifest{
+			SectorNumber: abi.SectorNumber(sectorParams.SectorNumber),
+			Pieces:       pams,
+		},
+		info: proof.AggregateSealVerifyInfo{
+			Number:                abi.SectorNumber(sectorParams.SectorNumber),
+			Randomness:            sectorParams.TicketValue,
+			InteractiveRandomness: sectorParams.SeedValue,
+			SealedCID:             pci.Info.SealedCID,
+			UnsealedCID:           unsealedCID,
+		},
+		collateral: collateral,
+	}, nil
 }
 
 func (s *SubmitCommitTask) transferFinalizedSectorData(ctx context.Context, spID, sectorNum int64) error {
@@ -15,6 +15,7 @@ import (
 	miner12 "github.com/filecoin-project/go-state-types/builtin/v12/miner"
 	"github.com/filecoin-project/go-state-types/network"
 
+	"github.com/filecoin-project/curio/deps/config"
 	"github.com/filecoin-project/curio/harmony/harmonydb"
 	"github.com/filecoin-project/curio/harmony/harmonytask"
 	"github.com/filecoin-project/curio/harmony/resources"
@@ -47,10 +48,11 @@ type SubmitPrecommitTask struct {
 	CollateralFromMinerBalance bool
 	DisableCollateralFallback  bool
 
-	maxFee types.FIL
+	maxFee      types.FIL
+	maxBatchFee config.BatchFeeConfig
 }
 
-func NewSubmitPrecommitTask(sp *SealPoller, db *harmonydb.DB, api SubmitPrecommitTaskApi, sender *message.Sender, as *multictladdr.MultiAddressSelector, maxFee types.FIL, CollateralFromMinerBalance, DisableCollateralFallback bool) *SubmitPrecommitTask {
+func NewSubmitPrecommitTask(sp *SealPoller, db *harmonydb.DB, api SubmitPrecommitTaskApi, sender *message.Sender, as *multictladdr.MultiAddressSelector, maxFee types.FIL, maxBatchFee config.BatchFeeConfig, CollateralFromMinerBalance, DisableCollateralFallback bool) *SubmitPrecommitTask {
 	return &SubmitPrecommitTask{
 		sp:     sp,
 		db:     db,
@@ -59,6 +61,7 @@ func NewSubmitPrecommitTask(sp *SealPoller, db *harmonydb.DB, api SubmitPrecommi
 		as:     as,
 
 		maxFee:                     maxFee,
+		maxBatchFee:                maxBatchFee,
 		CollateralFromMinerBalance: CollateralFromMinerBalance,
 		DisableCollateralFallback:  DisableCollateralFallback,
 	}
@@ -105,54 +108,82 @@ func (s *SubmitPrecommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bo
 	err = s.db.Select(ctx, &sectorParamsArr, `
 		SELECT sp_id, sector_number, reg_seal_proof, user_sector_duration_epochs, ticket_epoch, tree_r_cid, tree_d_cid
 		FROM sectors_sdr_pipeline
-		WHERE task_id_precommit_msg = $1`, taskID)
+		WHERE task_id_precommit_msg = $1 ORDER BY sector_number`, taskID)
 	if err != nil {
 		return false, xerrors.Errorf("getting sector params: %w", err)
 	}
 
-	if len(sectorParamsArr) != 1 {
-		return false, xerrors.Errorf("expected 1 sector params, got %d", len(sectorParamsArr))
+	if len(sectorParamsArr) == 0 {
+		return false, xerrors.Errorf("expected at least 1 sector params, got 0")
+	}
+	spID := sectorParamsArr[0].SpID
+	for _, sp := range sectorParamsArr {
+		if sp.SpID != spID {
+			return false, xerrors.Errorf("batch contains sectors of SPs %d and %d", spID, sp.SpID)
+		}
 	}
-	sectorParams := sectorParamsArr[0]
 
-	maddr, err := address.NewIDAddress(uint64(sectorParams.SpID))
+	maddr, err := address.NewIDAddress(uint64(spID))
 	if err != nil {
 		return false, xerrors.Errorf("getting miner address: %w", err)
 	}
 
-	sealedCID, err := cid.Parse(sectorParams.SealedCID)
+	// 2. Prepare message params
+
+	head, err := s.api.ChainHead(ctx)
 	if err != nil {
-		return false, xerrors.Errorf("parsing sealed CID: %w", err)
+		return false, xerrors.Errorf("getting chain head: %w", err)
 	}
 
-	unsealedCID, err := cid.Parse(sectorParams.UnsealedCID)
+	nv, err := s.api.StateNetworkVersion(ctx, types.EmptyTSK)
 	if err != nil {
-		return false, xerrors.Errorf("parsing unsealed CID: %w", err)
+		return false, xerrors.Errorf("getting network version: %w", err)
 	}
-
-	// 2. Prepare message params
-
-	head, err := s.api.ChainHead(ctx)
+	av, err := actorstypes.VersionForNetwork(nv)
 	if err != nil {
-		return false, xerrors.Errorf("getting chain head: %w", err)
+		return false, xerrors.Errorf("failed to get actors version: %w", err)
 	}
 
 	params := miner.PreCommitSectorBatchParams2{}
 
-	expiration := sectorParams.TicketEpoch + miner12.MaxSectorExpirationExtension
-	if sectorParams.UserSectorDurationEpochs != nil {
-		expiration = sectorParams.TicketEpoch + abi.ChainEpoch(*sectorParams.UserSectorDurationEpochs)
+	// failSector marks a sector of the batch failed, the rest of the batch is still precommitted
+	var lastFailure error
+	failSector := func(sectorNumber int64, reason string, ferr error) error {
+		_, perr := s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline
+			SET failed = TRUE, failed_at = NOW(), failed_reason = $1, failed_reason_msg = $2, task_id_precommit_msg = NULL
+			WHERE task_id_precommit_msg = $3 AND sp_id = $4 AND sector_number = $5`, reason, ferr.Error(), taskID, spID, sectorNumber)
+		if perr != nil {
+			return xerrors.Errorf("persisting precommit failure: %w", perr)
+		}
+		log.Errorw("sector precommit failed", "sp", spID, "sector", sectorNumber, "error", ferr)
+		lastFailure = ferr
+		return nil
 	}
 
-	params.Sectors = append(params.Sectors, miner.SectorPreCommitInfo{
-		SealProof:     sectorParams.RegSealProof,
-		SectorNumber:  abi.SectorNumber(sectorParams.SectorNumber),
-		SealedCID:     sealedCID,
-		SealRandEpoch: sectorParams.TicketEpoch,
-		Expiration:    expiration,
-	})
+	for _, sectorParams := range sectorParamsArr {
+		sealedCID, err := cid.Parse(sectorParams.SealedCID)
+		if err != nil {
+			return false, xerrors.Errorf("parsing sealed CID: %w", err)
+		}
+
+		unsealedCID, err := cid.Parse(sectorParams.UnsealedCID)
+		if err != nil {
+			return false, xerrors.Errorf("parsing unsealed CID: %w", err)
+		}
+
+		expiration := sectorParams.TicketEpoch + miner12.MaxSectorExpirationExtension
+		if sectorParams.UserSectorDurationEpochs != nil {
+			expiration = sectorParams.TicketEpoch + abi.ChainEpoch(*sectorParams.UserSectorDurationEpochs)
+		}
+
+		info := miner.SectorPreCommitInfo{
+			SealProof:     sectorParams.RegSealProof,
+			SectorNumber:  abi.SectorNumber(sectorParams.SectorNumber),
+			SealedCID:     sealedCID,
+			SealRandEpoch: sectorParams.TicketEpoch,
+			Expiration:    expiration,
+		}
 
-	{
 		var pieces []struct {
 			PieceIndex     int64  `db:"piece_index"`
 			PieceCID       string `db:"piece_cid"`
@@ -175,61 +206,54 @@ func (s *SubmitPrecommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bo
 
 		if len(pieces) > 0 {
 			var endEpoch abi.ChainEpoch
-			params.Sectors[0].UnsealedCid = &unsealedCID
+			var startPast bool
+			info.UnsealedCid = &unsealedCID
 			for _, p := range pieces {
 				if p.DealStartEpoch > 0 && abi.ChainEpoch(p.DealStartEpoch) < head.Height() {
-					// deal start epoch is in the past, can't precommit this sector anymore
-					_, perr := s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline
-					SET failed = TRUE, failed_at = NOW(), failed_reason = 'past-start-epoch', failed_reason_msg = 'precommit: start epoch is in the past', task_id_precommit_msg = NULL
-					WHERE task_id_precommit_msg = $1`, taskID)
-					if perr != nil {
-						return false, xerrors.Errorf("persisting precommit start epoch expiry: %w", perr)
-					}
-					return true, xerrors.Errorf("deal start epoch is in the past")
+					startPast = true
+					break
 				}
 				if p.DealEndEpoch > 0 && abi.ChainEpoch(p.DealEndEpoch) > endEpoch {
 					endEpoch = abi.ChainEpoch(p.DealEndEpoch)
 				}
 			}
-			if endEpoch != params.Sectors[0].Expiration {
-				params.Sectors[0].Expiration = endEpoch
+			if startPast {
+				// deal start epoch is in the past, can't precommit this sector anymore
+				if err := failSector(sectorParams.SectorNumber, "past-start-epoch", xerrors.Errorf("precommit: start epoch is in the past")); err != nil {
+					return false, err
+				}
+				continue
+			}
+			if endEpoch != info.Expiration {
+				info.Expiration = endEpoch
 			}
 		}
-	}
 
-	nv, err := s.api.StateNetworkVersion(ctx, types.EmptyTSK)
-	if err != nil {
-		return false, xerrors.Errorf("getting network version: %w", err)
-	}
-	av, err := actorstypes.VersionForNetwork(nv)
-	if err != nil {
-		return false, xerrors.Errorf("failed to get actors version: %w", err)
-	}
-	msd, err := policy.GetMaxProveCommitDuration(av, sectorParams.RegSealProof)
-	if err != nil {
-		return false, xerrors.Errorf("failed to get max prove commit duration: %w", err)
-	}
+		msd, err := policy.GetMaxProveCommitDuration(av, sectorParams.RegSealProof)
+		if err != nil {
+			return false, xerrors.Errorf("failed to get max prove commit duration: %w", err)
+		}
 
-	if minExpiration := sectorParams.TicketEpoch + policy.MaxPreCommitRandomnessLookback + msd + miner.MinSectorExpiration; params.Sectors[0].Expiration < minExpiration {
-		params.Sectors[0].Expiration = minExpiration
-	}
+		if minExpiration := sectorParams.TicketEpoch + policy.MaxPreCommitRandomnessLookback + msd + miner.MinSectorExpiration; info.Expiration < minExpiration {
+			info.Expiration = minExpiration
+		}
 
-	// 3. Check precommit
+		// 3. Check precommit
 
-	{
-		record, err := s.checkPrecommit(ctx, params)
-		if err != nil {
-			if record {
-				_, perr := s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline
-					SET failed = TRUE, failed_at = NOW(), failed_reason = 'precommit-check', failed_reason_msg = $1, task_id_precommit_msg = NULL
-					WHERE task_id_precommit_msg = $2`, err.Error(), taskID)
-				if perr != nil {
-					return false, xerrors.Errorf("persisting precommit check error: %w", perr)
-				}
+		if err := s.checkPrecommit(info, head.Height()); err != nil {
+			if err := failSector(sectorParams.SectorNumber, "precommit-check", err); err != nil {
+				return false, err
 			}
-
-			return record, xerrors.Errorf("checking precommit: %w", err)
+			continue
 		}
+
+		params.Sectors = append(params.Sectors, info)
+	}
+
+	if len(params.Sectors) == 0 {
+		// the failures are recorded on the sectors, nothing is left for this task to do
+		log.Warnw("no sectors left to precommit", "sp", spID, "last_error", lastFailure)
+		return true, nil
 	}
 
 	// 4. Prepare and send message
@@ -239,9 +263,13 @@ func (s *SubmitPrecommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bo
 		return false, xerrors.Errorf("serializing params: %w", err)
 	}
 
-	collateral, err := s.api.StateMinerPreCommitDepositForPower(ctx, maddr, params.Sectors[0], types.EmptyTSK)
-	if err != nil {
-		return false, xerrors.Errorf("getting precommit deposit: %w", err)
+	collateral := big.Zero()
+	for _, info := range params.Sectors {
+		deposit, err := s.api.StateMinerPreCommitDepositForPower(ctx, maddr, info, types.EmptyTSK)
+		if err != nil {
+			return false, xerrors.Errorf("getting precommit deposit: %w", err)
+		}
+		collateral = big.Add(collateral, deposit)
 	}
 
 	mi, err := s.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
@@ -278,8 +306,14 @@ func (s *SubmitPrecommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bo
 		Value:  collateral,
 	}
 
+	// funded messages are proposed to the funds multisig when one is configured
+	msg, _, err = s.as.MultisigProposal(maddr, msg)
+	if err != nil {
+		return false, xerrors.Errorf("creating multisig proposal: %w", err)
+	}
+
 	mss := &api.MessageSendSpec{
-		MaxFee: abi.TokenAmount(s.maxFee),
+		MaxFee: batchMaxFee(s.maxFee, s.maxBatchFee, len(params.Sectors)),
 	}
 
 	mcid, err := s.sender.Send(ctx, msg, mss, "precommit")
@@ -300,30 +334,22 @@ func (s *SubmitPrecommitTask) Do(taskID harmonytask.TaskID, stillOwned func() bo
 		return false, xerrors.Errorf("inserting into message_waits: %w", err)
 	}
 
-	return true, nil
-}
-
-func (s *SubmitPrecommitTask) checkPrecommit(ctx context.Context, params miner.PreCommitSectorBatchParams2) (record bool, err error) {
-	if len(params.Sectors) != 1 {
-		return false, xerrors.Errorf("expected 1 sector")
+	if len(params.Sectors) > 1 {
+		log.Infow("sent precommit batch", "sp", spID, "sectors", len(params.Sectors), "cid", mcid)
 	}
 
-	preCommitInfo := params.Sectors[0]
-
-	head, err := s.api.ChainHead(ctx)
-	if err != nil {
-		return false, xerrors.Errorf("getting chain head: %w", err)
-	}
-	height := head.Height()
+	return true, nil
+}
 
+func (s *SubmitPrecommitTask) checkPrecommit(preCommitInfo miner.SectorPreCommitInfo, height abi.ChainEpoch) error {
 	//never commit P2 message before, check ticket expiration
 	ticketEarliest := height - policy.MaxPreCommitRandomnessLookback
 
 	if preCommitInfo.SealRandEpoch < ticketEarliest {
-		return true, xerrors.Errorf("ticket expired: seal height: %d, head: %d", preCommitInfo.SealRandEpoch+policy.SealRandomnessLookback, height)
+		return xerrors.Errorf("ticket expired: seal height: %d, head: %d", preCommitInfo.SealRandEpoch+policy.SealRandomnessLookback, height)
 	}
 
-	return true, nil
+	return nil
 }
 
 func (s *SubmitPrecommitTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
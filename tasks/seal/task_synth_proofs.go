@@ -143,6 +143,14 @@ func (s *SyntheticProofTask) markFinished(ctx context.Context, spid, sector int6
 }
 
 func (s *SyntheticProofTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ids, err := s.sp.acceptLongHaul(context.Background(), ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
 	id := ids[0]
 	return &id, nil
 }
@@ -22,18 +22,76 @@ type TreeDTask struct {
 	sc *ffi2.SealCalls
 
 	max int
+
+	// split lets TreeD run on nodes without a GPU, see Seal.SplitTrees
+	split bool
 }
 
 func (t *TreeDTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ids, err := t.sp.acceptLongHaul(context.Background(), ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
 	if IsDevnet {
 		return &ids[0], nil
 	}
+	if t.split {
+		return t.acceptSplit(ids)
+	}
 	if engine.Resources().Gpu > 0 {
 		return &ids[0], nil
 	}
 	return nil, nil
 }
 
+// acceptSplit prefers sectors with the SDR layers in local storage, so that layers are only moved once, to the
+// TreeRC node, and otherwise takes any sector.
+func (t *TreeDTask) acceptSplit(ids []harmonytask.TaskID) (*harmonytask.TaskID, error) {
+	var tasks []struct {
+		TaskID    harmonytask.TaskID `db:"task_id_tree_d"`
+		StorageID string             `db:"storage_id"`
+	}
+
+	if storiface.FTCache != 4 {
+		panic("storiface.FTCache != 4")
+	}
+
+	ctx := context.Background()
+
+	indIDs := make([]int64, len(ids))
+	for i, id := range ids {
+		indIDs[i] = int64(id)
+	}
+
+	err := t.db.Select(ctx, &tasks, `
+		SELECT p.task_id_tree_d, l.storage_id FROM sectors_sdr_pipeline p
+			INNER JOIN sector_location l ON p.sp_id = l.miner_id AND p.sector_number = l.sector_num
+			WHERE task_id_tree_d = ANY ($1) AND l.sector_filetype = 4
+`, indIDs)
+	if err != nil {
+		return nil, xerrors.Errorf("getting tasks: %w", err)
+	}
+
+	ls, err := t.sc.LocalStorage(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage: %w", err)
+	}
+
+	for _, t := range tasks {
+		for _, l := range ls {
+			if string(l.ID) == t.StorageID {
+				return &t.TaskID, nil
+			}
+		}
+	}
+
+	return &ids[0], nil
+}
+
 func (t *TreeDTask) TypeDetails() harmonytask.TaskTypeDetails {
 	ssize := abi.SectorSize(32 << 30) // todo task details needs taskID to get correct sector size
 	if IsDevnet {
@@ -96,13 +154,14 @@ func (t *TreeDTask) Adder(taskFunc harmonytask.AddTaskFunc) {
 	t.sp.pollers[pollerTreeD].Set(taskFunc)
 }
 
-func NewTreeDTask(sp *SealPoller, db *harmonydb.DB, sc *ffi2.SealCalls, maxTrees int) *TreeDTask {
+func NewTreeDTask(sp *SealPoller, db *harmonydb.DB, sc *ffi2.SealCalls, maxTrees int, split bool) *TreeDTask {
 	return &TreeDTask{
 		sp: sp,
 		db: db,
 		sc: sc,
 
-		max: maxTrees,
+		max:   maxTrees,
+		split: split,
 	}
 }
 
@@ -2,6 +2,7 @@ package seal
 
 import (
 	"context"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
@@ -18,21 +19,29 @@ import (
 	storiface "github.com/filecoin-project/curio/lib/storiface"
 )
 
+// SplitTreesFallbackWait is how long a TreeRC task waits for a node holding the sector cache, with Seal.SplitTrees,
+// before a node without the cache takes it and fetches the cache from the TreeD node
+var SplitTreesFallbackWait = 5 * time.Minute
+
 type TreeRCTask struct {
 	sp *SealPoller
 	db *harmonydb.DB
 	sc *ffi2.SealCalls
 
 	max int
+
+	// split lets TreeRC run on nodes without the sector cache, which fetch it from the TreeD node, see Seal.SplitTrees
+	split bool
 }
 
-func NewTreeRCTask(sp *SealPoller, db *harmonydb.DB, sc *ffi2.SealCalls, maxTrees int) *TreeRCTask {
+func NewTreeRCTask(sp *SealPoller, db *harmonydb.DB, sc *ffi2.SealCalls, maxTrees int, split bool) *TreeRCTask {
 	return &TreeRCTask{
 		sp: sp,
 		db: db,
 		sc: sc,
 
-		max: maxTrees,
+		max:   maxTrees,
+		split: split,
 	}
 }
 
@@ -106,6 +115,14 @@ func (t *TreeRCTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 }
 
 func (t *TreeRCTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ids, err := t.sp.acceptLongHaul(context.Background(), ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
 	var tasks []struct {
 		TaskID       harmonytask.TaskID `db:"task_id_tree_c"`
 		SpID         int64              `db:"sp_id"`
@@ -124,7 +141,7 @@ func (t *TreeRCTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.Tas
 		indIDs[i] = int64(id)
 	}
 
-	err := t.db.Select(ctx, &tasks, `
+	err = t.db.Select(ctx, &tasks, `
 		SELECT p.task_id_tree_c, p.sp_id, p.sector_number, l.storage_id FROM sectors_sdr_pipeline p
 			INNER JOIN sector_location l ON p.sp_id = l.miner_id AND p.sector_number = l.sector_num
 			WHERE task_id_tree_r = ANY ($1) AND l.sector_filetype = 4
@@ -156,9 +173,51 @@ func (t *TreeRCTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.Tas
 		}
 	}
 
+	if t.split {
+		// no sector has the cache here, fetch one from the node which built its TreeD
+		return t.fetchingCandidate(ctx, ids)
+	}
+
 	return nil, nil
 }
 
+// fetchingCandidate picks a task for a node without the sector cache. Nodes holding the cache are preferred, so a task
+// is only taken once it waited SplitTreesFallbackWait for one of them, or when none of them can run TreeRC.
+func (t *TreeRCTask) fetchingCandidate(ctx context.Context, ids []harmonytask.TaskID) (*harmonytask.TaskID, error) {
+	indIDs := make([]int64, len(ids))
+	for i, id := range ids {
+		indIDs[i] = int64(id)
+	}
+
+	var candidates []harmonytask.TaskID
+	err := t.db.Select(ctx, &candidates, `
+		SELECT ht.id FROM harmony_task ht
+			INNER JOIN sectors_sdr_pipeline p ON p.task_id_tree_r = ht.id
+			WHERE ht.id = ANY ($1) AND (
+				ht.posted_time < NOW() - ($2 * INTERVAL '1 second')
+				OR NOT EXISTS (
+					SELECT 1 FROM sector_location l
+						INNER JOIN storage_path sp ON sp.storage_id = l.storage_id
+						INNER JOIN harmony_machines m ON sp.urls LIKE '%' || m.host_and_port || '%'
+						INNER JOIN harmony_machine_details d ON d.machine_id = m.id
+						WHERE l.miner_id = p.sp_id AND l.sector_num = p.sector_number AND l.sector_filetype = 4
+							AND 'TreeRC' = ANY (string_to_array(d.tasks, ','))
+							AND NOT m.unschedulable
+							AND m.last_contact > NOW() - ($3 * INTERVAL '1 second')
+				)
+			)
+			ORDER BY ht.posted_time
+			LIMIT 1`, indIDs, SplitTreesFallbackWait.Seconds(), resources.LOOKS_DEAD_TIMEOUT.Seconds())
+	if err != nil {
+		return nil, xerrors.Errorf("getting tasks to fetch the cache for: %w", err)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	return &candidates[0], nil
+}
+
 func (t *TreeRCTask) TypeDetails() harmonytask.TaskTypeDetails {
 	ssize := abi.SectorSize(32 << 30) // todo task details needs taskID to get correct sector size
 	if IsDevnet {
@@ -41,6 +41,14 @@ type TopologyConfig struct {
 	SectorConfigs   []SectorConfig
 }
 
+// SupraSealTuning restricts the CPU cores used by the batch sealer on a machine
+type SupraSealTuning struct {
+	// NUMANode is the NUMA node (CPU socket) whose cores are used
+	NUMANode int
+	// MaxCores limits the number of cores used, rounded down to whole CCXs, 0 uses all cores of the node
+	MaxCores int
+}
+
 type SupraSealConfig struct {
 	NVMeDevices []string
 	Topology    TopologyConfig
@@ -51,6 +59,13 @@ type SupraSealConfig struct {
 	RequiredCores   int
 	UnoccupiedCores int
 
+	// FirstCore is the first core of the NUMA node used by the batch sealer, core numbers in Topology include it
+	FirstCore int
+	// Cores is the number of cores available to the batch sealer, starting at FirstCore
+	Cores int
+	// UsedThreads is the number of CPU threads on the cores used by the batch sealer
+	UsedThreads int
+
 	P2WrRdOverlap   bool
 	P2HsP1WrOverlap bool
 	P2HcP2RdOverlap bool
@@ -130,7 +145,21 @@ func GetSystemInfo() (*SystemInfo, error) {
 	return info, nil
 }
 
-func GenerateSupraSealConfig(info SystemInfo, dualHashers bool, batchSize int, nvmeDevices []string) (SupraSealConfig, error) {
+func GenerateSupraSealConfig(info SystemInfo, dualHashers bool, batchSize int, nvmeDevices []string, tuning SupraSealTuning) (SupraSealConfig, error) {
+	if tuning.NUMANode < 0 || tuning.NUMANode >= max(info.ProcessorCount, 1) {
+		return SupraSealConfig{}, fmt.Errorf("NUMA node %d doesn't exist, the machine has %d", tuning.NUMANode, info.ProcessorCount)
+	}
+
+	// the system info describes the first socket, the others are the same
+	firstCore := tuning.NUMANode * info.CoreCount
+
+	if tuning.MaxCores > 0 && tuning.MaxCores < info.CoreCount {
+		info.CoreCount = tuning.MaxCores / info.CoresPerL3 * info.CoresPerL3
+		if info.CoreCount == 0 {
+			return SupraSealConfig{}, fmt.Errorf("max cores %d is less than one CCX (%d cores)", tuning.MaxCores, info.CoresPerL3)
+		}
+	}
+
 	config := SupraSealConfig{
 		NVMeDevices: nvmeDevices,
 		Topology: TopologyConfig{
@@ -253,9 +282,28 @@ func GenerateSupraSealConfig(info SystemInfo, dualHashers bool, batchSize int, n
 
 	config.Topology.SectorConfigs = append(config.Topology.SectorConfigs, sectorConfig)
 
+	config.Cores = info.CoreCount
+	config.UsedThreads = (info.CoreCount - config.UnoccupiedCores) * info.ThreadsPerCore
+	config.offsetCores(firstCore)
+
 	return config, nil
 }
 
+// offsetCores moves the topology to the cores starting at firstCore
+func (c *SupraSealConfig) offsetCores(firstCore int) {
+	c.FirstCore = firstCore
+
+	t := &c.Topology
+	for _, core := range []*int{&t.PC1Writer, &t.PC1Reader, &t.PC1Orchestrator, &t.PC2Reader, &t.PC2Hasher, &t.PC2HasherCPU, &t.PC2Writer, &t.C1Reader} {
+		*core += firstCore
+	}
+	for i := range t.SectorConfigs {
+		for j := range t.SectorConfigs[i].Coordinators {
+			t.SectorConfigs[i].Coordinators[j].Core += firstCore
+		}
+	}
+}
+
 func FormatSupraSealConfig(config SupraSealConfig, system SystemInfo, additionalInfo AdditionalSystemInfo) string {
 	var sb strings.Builder
 
@@ -281,6 +329,8 @@ func FormatSupraSealConfig(config SupraSealConfig, system SystemInfo, additional
 	w(fmt.Sprintf("# Required CCX: %d", config.RequiredCCX))
 	w(fmt.Sprintf("# Required Cores: %d", config.RequiredCores))
 	w(fmt.Sprintf("# Unoccupied Cores: %d", config.UnoccupiedCores))
+	w(fmt.Sprintf("# First Core: %d", config.FirstCore))
+	w(fmt.Sprintf("# Used Threads: %d", config.UsedThreads))
 	w(fmt.Sprintf("# P2 Writer/Reader Overlap: %v", config.P2WrRdOverlap))
 	w(fmt.Sprintf("# P2 Hasher/P1 Writer Overlap: %v", config.P2HsP1WrOverlap))
 	w(fmt.Sprintf("# P2 Hasher CPU/P2 Reader Overlap: %v", config.P2HcP2RdOverlap))
@@ -400,27 +450,27 @@ func ExtractAdditionalSystemInfo() (AdditionalSystemInfo, error) {
 	return info, nil
 }
 
-func GenerateSupraSealConfigString(dualHashers bool, batchSize int, nvmeDevices []string) (string, error) {
+func GenerateSupraSealConfigString(dualHashers bool, batchSize int, nvmeDevices []string, tuning SupraSealTuning) (string, SupraSealConfig, error) {
 	// Get system information
 	sysInfo, err := GetSystemInfo()
 	if err != nil {
-		return "", fmt.Errorf("failed to get system info: %v", err)
+		return "", SupraSealConfig{}, fmt.Errorf("failed to get system info: %v", err)
 	}
 
 	// Generate SupraSealConfig
-	config, err := GenerateSupraSealConfig(*sysInfo, dualHashers, batchSize, nvmeDevices)
+	config, err := GenerateSupraSealConfig(*sysInfo, dualHashers, batchSize, nvmeDevices, tuning)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate SupraSeal config: %v", err)
+		return "", SupraSealConfig{}, fmt.Errorf("failed to generate SupraSeal config: %v", err)
 	}
 
 	// Get additional system information
 	additionalInfo, err := ExtractAdditionalSystemInfo()
 	if err != nil {
-		return "", fmt.Errorf("failed to extract additional system info: %v", err)
+		return "", SupraSealConfig{}, fmt.Errorf("failed to extract additional system info: %v", err)
 	}
 
 	// Format the config
 	configString := FormatSupraSealConfig(config, *sysInfo, additionalInfo)
 
-	return configString, nil
+	return configString, config, nil
 }
@@ -53,13 +53,17 @@ type SupraSeal struct {
 	sectors   int // sectors in a batch
 	spt       abi.RegisteredSealProof
 
+	// cpu is the number of CPU threads claimed by a batch, so that the cores pinned by supraseal aren't handed
+	// to other tasks on this machine
+	cpu int
+
 	inSDR  *pipelinePhase // Phase 1
 	outSDR *pipelinePhase // Phase 2
 
 	slots *slotmgr.SlotMgr
 }
 
-func NewSupraSeal(sectorSize string, batchSize, pipelines int, dualHashers bool, nvmeDevices []string, machineHostAndPort string,
+func NewSupraSeal(sectorSize string, batchSize, pipelines int, dualHashers bool, nvmeDevices []string, tuning SupraSealTuning, machineHostAndPort string,
 	slots *slotmgr.SlotMgr, db *harmonydb.DB, api SupraSealNodeAPI, storage *paths.Remote, sindex paths.SectorIndex) (*SupraSeal, error) {
 	var spt abi.RegisteredSealProof
 	switch sectorSize {
@@ -75,15 +79,19 @@ func NewSupraSeal(sectorSize string, batchSize, pipelines int, dualHashers bool,
 	}
 
 	log.Infow("start supraseal init")
+	cpu := 1
 	var configFile string
 	if configFile = os.Getenv(suprasealConfigEnv); configFile == "" {
 		// not set from env (should be the case in most cases), auto-generate a config
 
-		cstr, err := GenerateSupraSealConfigString(dualHashers, batchSize, nvmeDevices)
+		cstr, scfg, err := GenerateSupraSealConfigString(dualHashers, batchSize, nvmeDevices, tuning)
 		if err != nil {
 			return nil, xerrors.Errorf("generating supraseal config: %w", err)
 		}
 
+		// batches in different pipelines share the cores, one is in PC1 while the other is in PC2
+		cpu = max(scfg.UsedThreads/max(pipelines, 1), 1)
+
 		cfgFile, err := os.CreateTemp("", "supraseal-config-*.cfg")
 		if err != nil {
 			return nil, xerrors.Errorf("creating temp file: %w", err)
@@ -151,6 +159,7 @@ func NewSupraSeal(sectorSize string, batchSize, pipelines int, dualHashers bool,
 		spt:       spt,
 		pipelines: pipelines,
 		sectors:   batchSize,
+		cpu:       cpu,
 
 		inSDR:  &pipelinePhase{phaseNum: 1},
 		outSDR: &pipelinePhase{phaseNum: 2},
@@ -429,7 +438,7 @@ func (s *SupraSeal) TypeDetails() harmonytask.TaskTypeDetails {
 		Max:  taskhelp.Max(s.pipelines),
 		Name: fmt.Sprintf("Batch%d-%s", s.sectors, ssizeToName[must.One(s.spt.SectorSize())]),
 		Cost: resources.Resources{
-			Cpu: 1,
+			Cpu: max(s.cpu, 1),
 			Gpu: 0,
 			Ram: 16 << 30,
 		},
@@ -461,7 +470,8 @@ func (s *SupraSeal) schedule(taskFunc harmonytask.AddTaskFunc) error {
 
 		err := tx.Select(&sectors, `SELECT sp_id, sector_number, task_id_sdr FROM sectors_sdr_pipeline
                                          LEFT JOIN harmony_task ht on sectors_sdr_pipeline.task_id_sdr = ht.id
-                                         WHERE after_sdr = FALSE AND (task_id_sdr IS NULL OR (ht.owner_id IS NULL AND ht.name = 'SDR')) LIMIT $1`, s.sectors)
+                                         WHERE after_sdr = FALSE AND (task_id_sdr IS NULL OR (ht.owner_id IS NULL AND ht.name = 'SDR'))
+                                           AND sp_id NOT IN (SELECT sp_id FROM sealing_pauses) LIMIT $1`, s.sectors)
 		if err != nil {
 			return false, xerrors.Errorf("getting tasks: %w", err)
 		}
@@ -0,0 +1,136 @@
+package sectorops
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	miner13 "github.com/filecoin-project/go-state-types/builtin/v13/miner"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+)
+
+// AutoExtendPolicy is the automatic extension policy of a miner
+type AutoExtendPolicy struct {
+	SpID             int64   `db:"sp_id"`
+	WindowDays       int64   `db:"window_days"`
+	ExtensionDays    int64   `db:"extension_days"`
+	MinRewardRatio   float64 `db:"min_reward_ratio"`
+	IncludeCC        bool    `db:"include_cc"`
+	IncludeDeals     bool    `db:"include_deals"`
+	DropClaims       bool    `db:"drop_claims"`
+	MaxSectorsPerRun int64   `db:"max_sectors_per_run"`
+
+	LastRun          *time.Time `db:"last_run"`
+	LastCandidates   int64      `db:"last_candidates"`
+	LastQueued       int64      `db:"last_queued"`
+	LastUnprofitable int64      `db:"last_unprofitable"`
+	LastSkipped      int64      `db:"last_skipped"`
+	LastOpID         *int64     `db:"last_op_id"`
+	LastError        *string    `db:"last_error"`
+	CreatedAt        time.Time  `db:"created_at"`
+}
+
+// SetAutoExtendPolicy adds or updates the automatic extension policy of a miner. The outcome of the last run is kept.
+func SetAutoExtendPolicy(ctx context.Context, db *harmonydb.DB, maddr address.Address, p AutoExtendPolicy) error {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner id: %w", err)
+	}
+	if p.WindowDays <= 0 || p.ExtensionDays <= 0 {
+		return xerrors.Errorf("window and extension days must be positive")
+	}
+	if p.ExtensionDays*builtin.EpochsInDay > int64(miner13.MaxSectorExpirationExtension) {
+		return xerrors.Errorf("extension exceeds max allowed: %d days > %d", p.ExtensionDays, miner13.MaxSectorExpirationExtension/builtin.EpochsInDay)
+	}
+	if p.MinRewardRatio < 0 {
+		return xerrors.Errorf("min reward ratio can't be negative")
+	}
+	if !p.IncludeCC && !p.IncludeDeals {
+		return xerrors.Errorf("policy must include CC sectors, sectors with deals or both")
+	}
+	if p.MaxSectorsPerRun <= 0 {
+		p.MaxSectorsPerRun = 10000
+	}
+
+	_, err = db.Exec(ctx, `INSERT INTO sector_auto_extend_policies (sp_id, window_days, extension_days, min_reward_ratio,
+			include_cc, include_deals, drop_claims, max_sectors_per_run)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (sp_id) DO UPDATE SET window_days = excluded.window_days, extension_days = excluded.extension_days,
+			min_reward_ratio = excluded.min_reward_ratio, include_cc = excluded.include_cc,
+			include_deals = excluded.include_deals, drop_claims = excluded.drop_claims,
+			max_sectors_per_run = excluded.max_sectors_per_run`,
+		int64(mid), p.WindowDays, p.ExtensionDays, p.MinRewardRatio, p.IncludeCC, p.IncludeDeals, p.DropClaims, p.MaxSectorsPerRun)
+	if err != nil {
+		return xerrors.Errorf("setting auto extend policy: %w", err)
+	}
+	return nil
+}
+
+// RemoveAutoExtendPolicy stops extending sectors of a miner automatically. Extensions already queued are still sent.
+func RemoveAutoExtendPolicy(ctx context.Context, db *harmonydb.DB, maddr address.Address) error {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner id: %w", err)
+	}
+
+	n, err := db.Exec(ctx, `DELETE FROM sector_auto_extend_policies WHERE sp_id = $1`, int64(mid))
+	if err != nil {
+		return xerrors.Errorf("removing auto extend policy: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("no auto extend policy for %s", maddr)
+	}
+	return nil
+}
+
+// AutoExtendPolicies lists the automatic extension policies.
+func AutoExtendPolicies(ctx context.Context, db *harmonydb.DB) ([]AutoExtendPolicy, error) {
+	var out []AutoExtendPolicy
+	err := db.Select(ctx, &out, `SELECT sp_id, window_days, extension_days, min_reward_ratio, include_cc, include_deals,
+			drop_claims, max_sectors_per_run, last_run, last_candidates, last_queued, last_unprofitable, last_skipped,
+			last_op_id, last_error, created_at
+		FROM sector_auto_extend_policies ORDER BY sp_id`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting auto extend policies: %w", err)
+	}
+	return out, nil
+}
+
+// SectorQAPower returns the current quality adjusted power of a sector. With dropClaims the power of the sector after
+// its verified claims are dropped is returned instead, which is its raw power for sectors without other deals.
+func SectorQAPower(si *miner.SectorOnChainInfo, dropClaims bool) (abi.StoragePower, error) {
+	size, err := si.SealProof.SectorSize()
+	if err != nil {
+		return big.Zero(), xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	verified := si.VerifiedDealWeight
+	if dropClaims {
+		verified = big.Zero()
+	}
+	return miner13.QAPowerForWeight(size, si.Expiration-si.PowerBaseEpoch, si.DealWeight, verified), nil
+}
+
+// ExtensionReward estimates the block reward a sector earns over epochs, assuming it keeps its share of network
+// quality adjusted power and the reward per epoch stays at its current value.
+func ExtensionReward(qaPower, networkQAPower abi.StoragePower, epochReward abi.TokenAmount, epochs abi.ChainEpoch) abi.TokenAmount {
+	if networkQAPower.IsZero() || epochs <= 0 {
+		return big.Zero()
+	}
+	return big.Div(big.Mul(big.Mul(epochReward, qaPower), big.NewInt(int64(epochs))), networkQAPower)
+}
+
+// Profitable returns whether the reward of an extension is at least minRatio times its cost.
+func Profitable(reward, cost abi.TokenAmount, minRatio float64) bool {
+	// the ratio is applied in millionths to stay in integer arithmetic
+	threshold := big.Div(big.Mul(cost, big.NewInt(int64(minRatio*1e6))), big.NewInt(1e6))
+	return reward.GreaterThanEqual(threshold)
+}
@@ -0,0 +1,173 @@
+package sectorops
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+// Actions of deal cleanup policies
+const (
+	// CleanupReport only records the sectors whose deals all ended
+	CleanupReport = "report"
+	// CleanupExpire keeps the sectors from automatic extension, they expire and storage GC removes their files
+	CleanupExpire = "expire"
+	// CleanupTerminate queues the sectors for termination with their files marked for removal
+	CleanupTerminate = "terminate"
+)
+
+// DealCleanupPolicy is the cleanup policy for sectors of a miner whose deals all ended
+type DealCleanupPolicy struct {
+	SpID              int64   `db:"sp_id"`
+	Action            string  `db:"action"`
+	GraceDays         int64   `db:"grace_days"`
+	MaxTerminationFee *string `db:"max_termination_fee"`
+	MaxSectorsPerRun  int64   `db:"max_sectors_per_run"`
+
+	LastRun        *time.Time `db:"last_run"`
+	LastCandidates int64      `db:"last_candidates"`
+	LastActed      int64      `db:"last_acted"`
+	LastOpID       *int64     `db:"last_op_id"`
+	LastError      *string    `db:"last_error"`
+	CreatedAt      time.Time  `db:"created_at"`
+}
+
+// DealCleanupCandidate is a sector with deals which all ended
+type DealCleanupCandidate struct {
+	SpID            int64      `db:"sp_id"`
+	SectorNum       int64      `db:"sector_num"`
+	Pieces          int64      `db:"pieces"`
+	DealsEndEpoch   int64      `db:"deals_end_epoch"`
+	ExpirationEpoch int64      `db:"expiration_epoch"`
+	Action          string     `db:"action"`
+	TerminateOpID   *int64     `db:"terminate_op_id"`
+	Note            *string    `db:"note"`
+	FoundAt         time.Time  `db:"found_at"`
+	ActedAt         *time.Time `db:"acted_at"`
+}
+
+// SetDealCleanupPolicy adds or updates the deal cleanup policy of a miner. The outcome of the last run is kept.
+// Sectors already acted on stay as they are when the action changes.
+func SetDealCleanupPolicy(ctx context.Context, db *harmonydb.DB, maddr address.Address, p DealCleanupPolicy) error {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner id: %w", err)
+	}
+	switch p.Action {
+	case CleanupReport, CleanupExpire, CleanupTerminate:
+	default:
+		return xerrors.Errorf("unknown action '%s', expected '%s', '%s' or '%s'", p.Action, CleanupReport, CleanupExpire, CleanupTerminate)
+	}
+	if p.GraceDays < 0 {
+		return xerrors.Errorf("grace days can't be negative")
+	}
+	if p.MaxTerminationFee != nil {
+		fee, err := big.FromString(*p.MaxTerminationFee)
+		if err != nil {
+			return xerrors.Errorf("parsing max termination fee: %w", err)
+		}
+		if fee.LessThan(big.Zero()) {
+			return xerrors.Errorf("max termination fee can't be negative")
+		}
+	}
+	if p.MaxSectorsPerRun <= 0 {
+		p.MaxSectorsPerRun = 1000
+	}
+
+	_, err = db.Exec(ctx, `INSERT INTO sector_deal_cleanup_policies (sp_id, action, grace_days, max_termination_fee, max_sectors_per_run)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (sp_id) DO UPDATE SET action = excluded.action, grace_days = excluded.grace_days,
+			max_termination_fee = excluded.max_termination_fee, max_sectors_per_run = excluded.max_sectors_per_run`,
+		int64(mid), p.Action, p.GraceDays, p.MaxTerminationFee, p.MaxSectorsPerRun)
+	if err != nil {
+		return xerrors.Errorf("setting deal cleanup policy: %w", err)
+	}
+	return nil
+}
+
+// RemoveDealCleanupPolicy stops looking for sectors of a miner whose deals all ended. Reported candidates are
+// dropped, sectors kept from extension stay so until they expire, queued terminations are still sent.
+func RemoveDealCleanupPolicy(ctx context.Context, db *harmonydb.DB, maddr address.Address) error {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner id: %w", err)
+	}
+
+	_, err = db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(`DELETE FROM sector_deal_cleanup_policies WHERE sp_id = $1`, int64(mid))
+		if err != nil {
+			return false, xerrors.Errorf("removing deal cleanup policy: %w", err)
+		}
+		if n == 0 {
+			return false, xerrors.Errorf("no deal cleanup policy for %s", maddr)
+		}
+
+		_, err = tx.Exec(`DELETE FROM sector_deal_cleanup_candidates WHERE sp_id = $1 AND action = $2`, int64(mid), CleanupReport)
+		if err != nil {
+			return false, xerrors.Errorf("removing reported sectors: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
+}
+
+// DealCleanupPolicies lists the deal cleanup policies.
+func DealCleanupPolicies(ctx context.Context, db *harmonydb.DB) ([]DealCleanupPolicy, error) {
+	var out []DealCleanupPolicy
+	err := db.Select(ctx, &out, `SELECT sp_id, action, grace_days, max_termination_fee, max_sectors_per_run,
+			last_run, last_candidates, last_acted, last_op_id, last_error, created_at
+		FROM sector_deal_cleanup_policies ORDER BY sp_id`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting deal cleanup policies: %w", err)
+	}
+	return out, nil
+}
+
+// DealCleanupCandidates lists the recorded sectors of a miner whose deals all ended.
+func DealCleanupCandidates(ctx context.Context, db *harmonydb.DB, maddr address.Address) ([]DealCleanupCandidate, error) {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner id: %w", err)
+	}
+
+	var out []DealCleanupCandidate
+	err = db.Select(ctx, &out, `SELECT sp_id, sector_num, pieces, deals_end_epoch, expiration_epoch, action,
+			terminate_op_id, note, found_at, acted_at
+		FROM sector_deal_cleanup_candidates WHERE sp_id = $1 ORDER BY sector_num`, int64(mid))
+	if err != nil {
+		return nil, xerrors.Errorf("getting deal cleanup candidates: %w", err)
+	}
+	return out, nil
+}
+
+// FindDealCleanupCandidates returns live sectors of a miner with deal pieces which all ended at least graceDays
+// before epoch. Sectors with a piece without a known end, already recorded with an action or in an open
+// termination are left out.
+func FindDealCleanupCandidates(ctx context.Context, db *harmonydb.DB, spID int64, epoch abi.ChainEpoch, graceDays, limit int64) ([]DealCleanupCandidate, error) {
+	var out []DealCleanupCandidate
+	err := db.Select(ctx, &out, `SELECT m.sp_id, m.sector_num, COUNT(*) AS pieces, MAX(p.orig_end_epoch) AS deals_end_epoch,
+			m.expiration_epoch, 'report' AS action, NOW() AS found_at
+		FROM sectors_meta m
+			INNER JOIN sectors_meta_pieces p ON p.sp_id = m.sp_id AND p.sector_num = m.sector_num
+		WHERE m.sp_id = $1 AND m.is_cc IS NOT TRUE AND m.expiration_epoch > $2
+			AND NOT EXISTS (SELECT 1 FROM sector_deal_cleanup_candidates c
+				WHERE c.sp_id = m.sp_id AND c.sector_num = m.sector_num AND c.action != 'report')
+			AND NOT EXISTS (SELECT 1 FROM sector_terminate_items i INNER JOIN sector_terminate_ops o ON o.op_id = i.op_id
+				WHERE o.sp_id = m.sp_id AND i.sector_number = m.sector_num AND i.state IN ('pending', 'sent'))
+		GROUP BY m.sp_id, m.sector_num, m.expiration_epoch
+		HAVING COUNT(p.orig_end_epoch) = COUNT(*) AND MAX(p.orig_end_epoch) <= $3
+		ORDER BY m.sector_num
+		LIMIT $4`, spID, epoch, int64(epoch)-graceDays*builtin.EpochsInDay, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("finding sectors with ended deals: %w", err)
+	}
+	return out, nil
+}
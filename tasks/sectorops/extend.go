@@ -0,0 +1,313 @@
+package sectorops
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/curio/lib/curiochain"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/verifreg"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ExtendTolerance is how much earlier than requested a sector may be extended to, so that sectors in
+// the same partition share one expiration and one declaration. Sectors which would be extended by
+// less than this are skipped.
+const ExtendTolerance = 7 * builtin.EpochsInDay
+
+type ExtendNodeAPI interface {
+	ChainHead(ctx context.Context) (*types.TipSet, error)
+	StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error)
+	StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error)
+	StateMinerInfo(ctx context.Context, maddr address.Address, tsk types.TipSetKey) (api.MinerInfo, error)
+	GasEstimateMessageGas(ctx context.Context, msg *types.Message, spec *api.MessageSendSpec, tsk types.TipSetKey) (*types.Message, error)
+}
+
+// ExtendRequest describes a sector expiration extension. Exactly one of Extension and NewExpiration
+// must be set.
+type ExtendRequest struct {
+	Sectors []abi.SectorNumber
+
+	Extension     abi.ChainEpoch // added to the current expiration of each sector
+	NewExpiration abi.ChainEpoch
+
+	// DropClaims allows dropping verified claims which end before the new expiration, where FIP-0045
+	// permits it. Sectors with such claims are skipped otherwise.
+	DropClaims bool
+}
+
+type SectorExtension struct {
+	SectorNumber abi.SectorNumber
+	Deadline     uint64
+	Partition    uint64
+
+	Expiration    abi.ChainEpoch
+	NewExpiration abi.ChainEpoch // zero when skipped
+
+	MaintainClaims int
+	DropClaims     int
+
+	Skipped string `json:",omitempty"`
+}
+
+type ExtendPlan struct {
+	Epoch   abi.ChainEpoch
+	Sectors []SectorExtension
+
+	// Params holds the ExtendSectorExpiration2 parameters of each message needed
+	Params []miner.ExtendSectorExpiration2Params
+}
+
+// Extended returns the number of sectors which will be extended.
+func (p *ExtendPlan) Extended() int {
+	var n int
+	for _, s := range p.Sectors {
+		if s.Skipped == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// PlanExtension computes ExtendSectorExpiration2 messages extending the requested sectors, clamped to the
+// maximum sector lifetime and extension allowed by the network.
+func PlanExtension(ctx context.Context, full ExtendNodeAPI, bstore curiochain.CurioBlockstore, maddr address.Address, req ExtendRequest) (*ExtendPlan, error) {
+	if (req.Extension > 0) == (req.NewExpiration > 0) {
+		return nil, xerrors.Errorf("exactly one of extension and new expiration must be set")
+	}
+
+	head, err := full.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+	nv, err := full.StateNetworkVersion(ctx, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting network version: %w", err)
+	}
+
+	astor := adt.WrapStore(ctx, cbor.NewCborStore(bstore))
+
+	mact, err := full.StateGetActor(ctx, maddr, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner actor: %w", err)
+	}
+	mas, err := miner.Load(astor, mact)
+	if err != nil {
+		return nil, xerrors.Errorf("loading miner state: %w", err)
+	}
+
+	vact, err := full.StateGetActor(ctx, builtin.VerifiedRegistryActorAddr, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting verifreg actor: %w", err)
+	}
+	vst, err := verifreg.Load(astor, vact)
+	if err != nil {
+		return nil, xerrors.Errorf("loading verifreg state: %w", err)
+	}
+	claims, err := vst.GetClaims(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting claims: %w", err)
+	}
+	claimsBySector, err := vst.GetClaimIdsBySector(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting claim IDs by sector: %w", err)
+	}
+
+	maxExtension, err := policy.GetMaxSectorExpirationExtension(nv)
+	if err != nil {
+		return nil, xerrors.Errorf("getting max extension: %w", err)
+	}
+	sectorsMax, err := policy.GetAddressedSectorsMax(nv)
+	if err != nil {
+		return nil, xerrors.Errorf("getting addressed sectors max: %w", err)
+	}
+	declMax, err := policy.GetDeclarationsMax(nv)
+	if err != nil {
+		return nil, xerrors.Errorf("getting declarations max: %w", err)
+	}
+
+	sectors := append([]abi.SectorNumber(nil), req.Sectors...)
+	sort.Slice(sectors, func(i, j int) bool { return sectors[i] < sectors[j] })
+
+	// extensions grouped by partition, then by new expiration
+	type group struct {
+		loc        miner.SectorLocation
+		expiration abi.ChainEpoch
+		plain      []uint64
+		withClaims []miner.SectorClaim
+	}
+	var groups []*group
+
+	plan := &ExtendPlan{Epoch: head.Height()}
+	for _, num := range sectors {
+		se := SectorExtension{SectorNumber: num}
+
+		si, err := mas.GetSector(num)
+		if err != nil {
+			return nil, xerrors.Errorf("getting sector %d: %w", num, err)
+		}
+		if si == nil {
+			se.Skipped = "sector is not live on chain"
+			plan.Sectors = append(plan.Sectors, se)
+			continue
+		}
+		se.Expiration = si.Expiration
+
+		loc, err := mas.FindSector(num)
+		if err != nil {
+			return nil, xerrors.Errorf("finding sector %d: %w", num, err)
+		}
+		se.Deadline, se.Partition = loc.Deadline, loc.Partition
+
+		newExp := req.NewExpiration
+		if req.Extension > 0 {
+			newExp = si.Expiration + req.Extension
+		}
+		if limit := head.Height() + maxExtension; newExp > limit {
+			newExp = limit
+		}
+		if limit := si.Activation + policy.GetSectorMaxLifetime(si.SealProof, nv); newExp > limit {
+			newExp = limit
+		}
+
+		// join a group with a slightly earlier expiration in the same partition if there is one
+		var g *group
+		for _, cand := range groups {
+			if cand.loc == *loc && cand.expiration <= newExp && newExp-cand.expiration <= ExtendTolerance && cand.expiration-si.Expiration >= ExtendTolerance {
+				g = cand
+				newExp = cand.expiration
+				break
+			}
+		}
+
+		if newExp-si.Expiration < ExtendTolerance {
+			se.Skipped = fmt.Sprintf("can't be extended by at least %d epochs, max new expiration is %d", ExtendTolerance, newExp)
+			plan.Sectors = append(plan.Sectors, se)
+			continue
+		}
+
+		var maintain, drop []verifreg.ClaimId
+		for _, claimID := range claimsBySector[num] {
+			claim, ok := claims[claimID]
+			if !ok {
+				return nil, xerrors.Errorf("claim %d of sector %d not found", claimID, num)
+			}
+			if claim.TermStart+claim.TermMax > newExp {
+				maintain = append(maintain, claimID)
+				continue
+			}
+
+			// FIP-0045 only allows dropping claims past their minimum term, in the last days of the sector
+			if !req.DropClaims || head.Height() <= claim.TermStart+claim.TermMin || head.Height() <= si.Expiration-builtin.EndOfLifeClaimDropPeriod {
+				se.Skipped = fmt.Sprintf("claim %d (client f0%d) ends at %d, before the new expiration", claimID, claim.Client, claim.TermStart+claim.TermMax)
+				break
+			}
+			drop = append(drop, claimID)
+		}
+		if se.Skipped != "" {
+			plan.Sectors = append(plan.Sectors, se)
+			continue
+		}
+
+		if g == nil {
+			g = &group{loc: *loc, expiration: newExp}
+			groups = append(groups, g)
+		}
+		if len(maintain)+len(drop) == 0 {
+			g.plain = append(g.plain, uint64(num))
+		} else {
+			g.withClaims = append(g.withClaims, miner.SectorClaim{
+				SectorNumber:   num,
+				MaintainClaims: maintain,
+				DropClaims:     drop,
+			})
+		}
+
+		se.NewExpiration = newExp
+		se.MaintainClaims, se.DropClaims = len(maintain), len(drop)
+		plan.Sectors = append(plan.Sectors, se)
+	}
+
+	var p miner.ExtendSectorExpiration2Params
+	var addressed int
+	for _, g := range groups {
+		n := len(g.plain) + len(g.withClaims)
+		if len(p.Extensions) > 0 && (addressed+n > sectorsMax || len(p.Extensions) >= declMax) {
+			plan.Params = append(plan.Params, p)
+			p = miner.ExtendSectorExpiration2Params{}
+			addressed = 0
+		}
+
+		p.Extensions = append(p.Extensions, miner.ExpirationExtension2{
+			Deadline:          g.loc.Deadline,
+			Partition:         g.loc.Partition,
+			Sectors:           bitfield.NewFromSet(g.plain),
+			SectorsWithClaims: g.withClaims,
+			NewExpiration:     g.expiration,
+		})
+		addressed += n
+	}
+	if len(p.Extensions) > 0 {
+		plan.Params = append(plan.Params, p)
+	}
+
+	return plan, nil
+}
+
+// ParamsSectorCount returns the number of sectors extended by one message.
+func ParamsSectorCount(p *miner.ExtendSectorExpiration2Params) (int, error) {
+	var n int
+	for _, ext := range p.Extensions {
+		c, err := ext.Sectors.Count()
+		if err != nil {
+			return 0, xerrors.Errorf("counting sectors: %w", err)
+		}
+		n += int(c) + len(ext.SectorsWithClaims)
+	}
+	return n, nil
+}
+
+// ExtendMessages returns the messages executing the plan, sent from the miner worker address.
+func ExtendMessages(ctx context.Context, full ExtendNodeAPI, maddr address.Address, plan *ExtendPlan) ([]*types.Message, error) {
+	if len(plan.Params) == 0 {
+		return nil, nil
+	}
+
+	mi, err := full.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner info: %w", err)
+	}
+
+	msgs := make([]*types.Message, 0, len(plan.Params))
+	for i := range plan.Params {
+		enc, aerr := actors.SerializeParams(&plan.Params[i])
+		if aerr != nil {
+			return nil, xerrors.Errorf("serializing params: %w", aerr)
+		}
+
+		msgs = append(msgs, &types.Message{
+			From:   mi.Worker,
+			To:     maddr,
+			Method: builtin.MethodsMiner.ExtendSectorExpiration2,
+			Value:  big.Zero(),
+			Params: enc,
+		})
+	}
+	return msgs, nil
+}
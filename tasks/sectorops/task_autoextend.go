@@ -0,0 +1,276 @@
+package sectorops
+
+import (
+	"context"
+	"time"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/curiochain"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/reward"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const AutoExtendInterval = 6 * time.Hour
+
+type AutoExtendNodeAPI interface {
+	ExtendNodeAPI
+	StateMinerPower(context.Context, address.Address, types.TipSetKey) (*api.MinerPower, error)
+}
+
+// AutoExtendTask applies the automatic extension policies in sector_auto_extend_policies. Every run it plans an
+// extension of the sectors of each SP which expire within the policy window, and queues the sectors whose expected
+// block reward over the extension is worth their share of the message fees in sector_extend_ops.
+//
+// Queued extensions are sent by the ExtendSectors task. No new extension is queued for an SP while an earlier one
+// is still open. Sectors which a deal cleanup policy lets expire or terminates aren't extended.
+type AutoExtendTask struct {
+	db     *harmonydb.DB
+	api    AutoExtendNodeAPI
+	bstore curiochain.CurioBlockstore
+	cfg    *config.CurioConfig
+}
+
+func NewAutoExtendTask(db *harmonydb.DB, api AutoExtendNodeAPI, bstore curiochain.CurioBlockstore, cfg *config.CurioConfig) *AutoExtendTask {
+	return &AutoExtendTask{
+		db:     db,
+		api:    api,
+		bstore: bstore,
+		cfg:    cfg,
+	}
+}
+
+type autoExtendResult struct {
+	candidates   int
+	queued       int
+	unprofitable int
+	skipped      int
+	opID         *int64
+}
+
+func (a *AutoExtendTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var policies []AutoExtendPolicy
+	err = a.db.Select(ctx, &policies, `SELECT sp_id, window_days, extension_days, min_reward_ratio, include_cc, include_deals,
+			drop_claims, max_sectors_per_run
+		FROM sector_auto_extend_policies p
+		WHERE NOT EXISTS (SELECT 1 FROM sector_extend_ops o WHERE o.sp_id = p.sp_id AND o.auto_extend AND o.complete_time IS NULL)
+		ORDER BY sp_id`)
+	if err != nil {
+		return false, xerrors.Errorf("getting auto extend policies: %w", err)
+	}
+
+	for _, p := range policies {
+		// a failing SP doesn't hold back the others, its error is shown with the policy
+		res, err := a.apply(ctx, p)
+		var errStr *string
+		if err != nil {
+			log.Errorw("applying auto extend policy", "sp", p.SpID, "error", err)
+			s := err.Error()
+			errStr = &s
+		}
+
+		_, err = a.db.Exec(ctx, `UPDATE sector_auto_extend_policies SET last_run = current_timestamp, last_candidates = $2,
+				last_queued = $3, last_unprofitable = $4, last_skipped = $5, last_op_id = COALESCE($6, last_op_id), last_error = $7
+			WHERE sp_id = $1`, p.SpID, res.candidates, res.queued, res.unprofitable, res.skipped, res.opID, errStr)
+		if err != nil {
+			return false, xerrors.Errorf("updating auto extend policy of f0%d: %w", p.SpID, err)
+		}
+	}
+
+	return true, nil
+}
+
+func (a *AutoExtendTask) apply(ctx context.Context, p AutoExtendPolicy) (autoExtendResult, error) {
+	var res autoExtendResult
+
+	maddr, err := address.NewIDAddress(uint64(p.SpID))
+	if err != nil {
+		return res, err
+	}
+
+	head, err := a.api.ChainHead(ctx)
+	if err != nil {
+		return res, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	var nums []int64
+	err = a.db.Select(ctx, &nums, `SELECT sector_num FROM sectors_meta
+		WHERE sp_id = $1 AND expiration_epoch > $2 AND expiration_epoch <= $3
+			AND (($4 AND is_cc IS TRUE) OR ($5 AND is_cc IS NOT TRUE))
+			AND NOT EXISTS (SELECT 1 FROM sector_deal_cleanup_candidates c
+				WHERE c.sp_id = sectors_meta.sp_id AND c.sector_num = sectors_meta.sector_num AND c.action != 'report')
+		ORDER BY expiration_epoch, sector_num
+		LIMIT $6`, p.SpID, head.Height(), int64(head.Height())+p.WindowDays*builtin.EpochsInDay, p.IncludeCC, p.IncludeDeals, p.MaxSectorsPerRun)
+	if err != nil {
+		return res, xerrors.Errorf("getting expiring sectors: %w", err)
+	}
+	res.candidates = len(nums)
+	if len(nums) == 0 {
+		return res, nil
+	}
+
+	req := ExtendRequest{
+		Extension:  abi.ChainEpoch(p.ExtensionDays * builtin.EpochsInDay),
+		DropClaims: p.DropClaims,
+	}
+	for _, n := range nums {
+		req.Sectors = append(req.Sectors, abi.SectorNumber(n))
+	}
+
+	plan, err := PlanExtension(ctx, a.api, a.bstore, maddr, req)
+	if err != nil {
+		return res, xerrors.Errorf("planning extension: %w", err)
+	}
+	msgs, err := ExtendMessages(ctx, a.api, maddr, plan)
+	if err != nil {
+		return res, xerrors.Errorf("creating messages: %w", err)
+	}
+
+	// each sector pays an equal share of the worst case fee of its message
+	costs := map[abi.SectorNumber]abi.TokenAmount{}
+	spec := &api.MessageSendSpec{MaxFee: abi.TokenAmount(a.cfg.Fees.MaxExtendGasFee)}
+	for i, msg := range msgs {
+		est, err := a.api.GasEstimateMessageGas(ctx, msg, spec, head.Key())
+		if err != nil {
+			return res, xerrors.Errorf("estimating gas of message %d: %w", i, err)
+		}
+
+		sectors, err := paramsSectors(&plan.Params[i])
+		if err != nil {
+			return res, err
+		}
+		share := big.Div(est.RequiredFunds(), big.NewInt(int64(len(sectors))))
+		for _, s := range sectors {
+			costs[s] = share
+		}
+	}
+
+	astor := adt.WrapStore(ctx, cbor.NewCborStore(a.bstore))
+	mact, err := a.api.StateGetActor(ctx, maddr, head.Key())
+	if err != nil {
+		return res, xerrors.Errorf("getting miner actor: %w", err)
+	}
+	mas, err := miner.Load(astor, mact)
+	if err != nil {
+		return res, xerrors.Errorf("loading miner state: %w", err)
+	}
+	ract, err := a.api.StateGetActor(ctx, builtin.RewardActorAddr, head.Key())
+	if err != nil {
+		return res, xerrors.Errorf("getting reward actor: %w", err)
+	}
+	rst, err := reward.Load(astor, ract)
+	if err != nil {
+		return res, xerrors.Errorf("loading reward state: %w", err)
+	}
+	epochReward, err := rst.ThisEpochReward()
+	if err != nil {
+		return res, xerrors.Errorf("getting epoch reward: %w", err)
+	}
+	power, err := a.api.StateMinerPower(ctx, maddr, head.Key())
+	if err != nil {
+		return res, xerrors.Errorf("getting network power: %w", err)
+	}
+
+	var queue []int64
+	for _, se := range plan.Sectors {
+		if se.Skipped != "" {
+			res.skipped++
+			continue
+		}
+
+		si, err := mas.GetSector(se.SectorNumber)
+		if err != nil {
+			return res, xerrors.Errorf("getting sector %d: %w", se.SectorNumber, err)
+		}
+		if si == nil {
+			res.skipped++
+			continue
+		}
+
+		qa, err := SectorQAPower(si, se.DropClaims > 0)
+		if err != nil {
+			return res, err
+		}
+		gain := ExtensionReward(qa, power.TotalPower.QualityAdjPower, epochReward, se.NewExpiration-se.Expiration)
+		if !Profitable(gain, costs[se.SectorNumber], p.MinRewardRatio) {
+			res.unprofitable++
+			continue
+		}
+		queue = append(queue, int64(se.SectorNumber))
+	}
+
+	if len(queue) == 0 {
+		return res, nil
+	}
+
+	var opID int64
+	err = a.db.QueryRow(ctx, `INSERT INTO sector_extend_ops (sp_id, sectors, extension, drop_claims, max_fee, auto_extend)
+		VALUES ($1, $2, $3, $4, $5, TRUE) RETURNING op_id`,
+		p.SpID, queue, int64(req.Extension), p.DropClaims, abi.TokenAmount(a.cfg.Fees.MaxExtendGasFee).String()).Scan(&opID)
+	if err != nil {
+		return res, xerrors.Errorf("queueing extension: %w", err)
+	}
+	res.queued, res.opID = len(queue), &opID
+
+	log.Infow("queued automatic sector extension", "miner", maddr, "op", opID, "sectors", len(queue),
+		"unprofitable", res.unprofitable, "skipped", res.skipped)
+	return res, nil
+}
+
+// paramsSectors returns the sectors extended by one message
+func paramsSectors(p *miner.ExtendSectorExpiration2Params) ([]abi.SectorNumber, error) {
+	var out []abi.SectorNumber
+	for _, ext := range p.Extensions {
+		err := ext.Sectors.ForEach(func(n uint64) error {
+			out = append(out, abi.SectorNumber(n))
+			return nil
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("iterating sectors: %w", err)
+		}
+		for _, sc := range ext.SectorsWithClaims {
+			out = append(out, sc.SectorNumber)
+		}
+	}
+	return out, nil
+}
+
+func (a *AutoExtendTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (a *AutoExtendTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "AutoExtend",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 512 << 20,
+		},
+		IAmBored: harmonytask.SingletonTaskAdder(AutoExtendInterval, a),
+	}
+}
+
+func (a *AutoExtendTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ = harmonytask.Reg(&AutoExtendTask{})
+var _ harmonytask.TaskInterface = &AutoExtendTask{}
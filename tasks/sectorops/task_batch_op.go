@@ -0,0 +1,307 @@
+package sectorops
+
+import (
+	"context"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/unseal"
+)
+
+var log = logging.Logger("sectorops")
+
+const MinSchedInterval = 10 * time.Second
+
+const (
+	ActionRemove      = "remove"
+	ActionUnseal      = "unseal"
+	ActionUnsealCache = "unseal-cache"
+	ActionRedeclare   = "redeclare"
+	ActionRetry       = "retry"
+	ActionRegen       = "regen-unsealed"
+)
+
+// Pipeline stages which can be retried with ActionRetry
+const (
+	StageSDR         = "sdr"
+	StageTrees       = "trees"
+	StagePoRep       = "porep"
+	StageFinalize    = "finalize"
+	StageMoveStorage = "move_storage"
+)
+
+var Actions = []string{ActionRemove, ActionUnseal, ActionUnsealCache, ActionRedeclare, ActionRetry, ActionRegen}
+var Stages = []string{StageSDR, StageTrees, StagePoRep, StageFinalize, StageMoveStorage}
+
+// BatchOpTask executes batch sector operations queued in sector_batch_ops.
+//
+// Note that the redeclare action only rescans storage paths attached to the
+// node which executes the batch.
+type BatchOpTask struct {
+	db    *harmonydb.DB
+	stor  *paths.Remote
+	lstor *paths.Local
+	index paths.SectorIndex
+}
+
+func NewBatchOpTask(db *harmonydb.DB, stor *paths.Remote, lstor *paths.Local, index paths.SectorIndex) *BatchOpTask {
+	return &BatchOpTask{
+		db:    db,
+		stor:  stor,
+		lstor: lstor,
+		index: index,
+	}
+}
+
+func (b *BatchOpTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var ops []struct {
+		OpID   int64  `db:"op_id"`
+		Action string `db:"action"`
+		Stage  string `db:"stage"`
+	}
+	err = b.db.Select(ctx, &ops, `SELECT op_id, action, stage FROM sector_batch_ops WHERE task_id = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting batch op: %w", err)
+	}
+	if len(ops) != 1 {
+		return false, xerrors.Errorf("expected 1 batch op, got %d", len(ops))
+	}
+	op := ops[0]
+
+	var items []struct {
+		SpID         int64 `db:"sp_id"`
+		SectorNumber int64 `db:"sector_number"`
+	}
+	err = b.db.Select(ctx, &items, `SELECT sp_id, sector_number FROM sector_batch_op_items
+		WHERE op_id = $1 AND complete = FALSE ORDER BY sp_id, sector_number`, op.OpID)
+	if err != nil {
+		return false, xerrors.Errorf("getting batch op items: %w", err)
+	}
+
+	for _, item := range items {
+		if !stillOwned() {
+			return false, nil
+		}
+
+		sid := abi.SectorID{Miner: abi.ActorID(item.SpID), Number: abi.SectorNumber(item.SectorNumber)}
+
+		var errStr *string
+		if err := b.apply(ctx, op.Action, op.Stage, sid); err != nil {
+			log.Warnw("batch sector op failed", "op", op.OpID, "action", op.Action, "sector", sid, "error", err)
+			es := err.Error()
+			errStr = &es
+		}
+
+		_, err = b.db.Exec(ctx, `UPDATE sector_batch_op_items SET complete = TRUE, error = $4
+			WHERE op_id = $1 AND sp_id = $2 AND sector_number = $3`, op.OpID, item.SpID, item.SectorNumber, errStr)
+		if err != nil {
+			return false, xerrors.Errorf("updating batch op item: %w", err)
+		}
+	}
+
+	_, err = b.db.Exec(ctx, `UPDATE sector_batch_ops SET complete_time = current_timestamp WHERE op_id = $1`, op.OpID)
+	if err != nil {
+		return false, xerrors.Errorf("marking batch op complete: %w", err)
+	}
+
+	return true, nil
+}
+
+func (b *BatchOpTask) apply(ctx context.Context, action, stage string, sid abi.SectorID) error {
+	switch action {
+	case ActionRemove:
+		return b.remove(ctx, sid)
+	case ActionUnseal:
+		n, err := b.db.Exec(ctx, `UPDATE sectors_meta SET target_unseal_state = TRUE WHERE sp_id = $1 AND sector_num = $2`, sid.Miner, sid.Number)
+		if err != nil {
+			return xerrors.Errorf("setting target unseal state: %w", err)
+		}
+		if n != 1 {
+			return xerrors.Errorf("sector not found in sectors_meta")
+		}
+		// pinned sectors aren't evicted from the unsealed cache
+		return unseal.Uncache(ctx, b.db, sid)
+	case ActionUnsealCache:
+		_, err := unseal.CacheSector(ctx, b.db, sid)
+		return err
+	case ActionRedeclare:
+		return b.redeclare(ctx, sid)
+	case ActionRetry:
+		return b.retry(ctx, stage, sid)
+	case ActionRegen:
+		return unseal.ScheduleRegen(ctx, b.db, sid, unseal.RegenByManual, "batch sector operation")
+	default:
+		return xerrors.Errorf("unknown action %q", action)
+	}
+}
+
+func (b *BatchOpTask) remove(ctx context.Context, sid abi.SectorID) error {
+	_, err := b.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`DELETE FROM batch_sector_refs WHERE sp_id = $1 AND sector_number = $2`, sid.Miner, sid.Number)
+		if err != nil {
+			return false, xerrors.Errorf("removing sector batch refs: %w", err)
+		}
+
+		_, err = tx.Exec(`DELETE FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = $2`, sid.Miner, sid.Number)
+		if err != nil {
+			return false, xerrors.Errorf("removing sector from pipeline: %w", err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO storage_removal_marks (sp_id, sector_num, sector_filetype, storage_id, created_at, approved, approved_at)
+			SELECT miner_id, sector_num, sector_filetype, storage_id, current_timestamp, FALSE, NULL FROM sector_location
+			WHERE miner_id = $1 AND sector_num = $2
+			ON CONFLICT DO NOTHING`, sid.Miner, sid.Number)
+		if err != nil {
+			return false, xerrors.Errorf("marking sector for removal: %w", err)
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
+}
+
+// redeclare re-syncs the sector index with sector files present in local storage paths
+func (b *BatchOpTask) redeclare(ctx context.Context, sid abi.SectorID) error {
+	locals, err := b.lstor.Local(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	for _, p := range locals {
+		for _, ft := range storiface.PathTypes {
+			_, err := os.Stat(filepath.Join(p.LocalPath, ft.String(), storiface.SectorName(sid)))
+			switch {
+			case err == nil:
+				if err := b.index.StorageDeclareSector(ctx, p.ID, sid, ft, p.CanStore); err != nil {
+					return xerrors.Errorf("declaring %s in %s: %w", ft, p.ID, err)
+				}
+			case os.IsNotExist(err):
+				if err := b.index.StorageDropSector(ctx, p.ID, sid, ft); err != nil {
+					return xerrors.Errorf("dropping %s from %s: %w", ft, p.ID, err)
+				}
+			default:
+				return xerrors.Errorf("stat %s in %s: %w", ft, p.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *BatchOpTask) retry(ctx context.Context, stage string, sid abi.SectorID) error {
+	var n int
+	var err error
+
+	switch stage {
+	case StageSDR:
+		n, err = b.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_sdr = FALSE, after_tree_d = FALSE, after_tree_c = FALSE, after_tree_r = FALSE
+			WHERE sp_id = $1 AND sector_number = $2 AND after_precommit_msg = FALSE`, sid.Miner, sid.Number)
+	case StageTrees:
+		n, err = b.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_tree_d = FALSE, after_tree_c = FALSE, after_tree_r = FALSE
+			WHERE sp_id = $1 AND sector_number = $2 AND after_precommit_msg = FALSE`, sid.Miner, sid.Number)
+	case StagePoRep:
+		n, err = b.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_porep = FALSE
+			WHERE sp_id = $1 AND sector_number = $2 AND after_commit_msg = FALSE`, sid.Miner, sid.Number)
+	case StageFinalize:
+		n, err = b.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_finalize = FALSE
+			WHERE sp_id = $1 AND sector_number = $2 AND after_move_storage = FALSE`, sid.Miner, sid.Number)
+	case StageMoveStorage:
+		n, err = b.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_move_storage = FALSE
+			WHERE sp_id = $1 AND sector_number = $2 AND after_finalize = TRUE`, sid.Miner, sid.Number)
+	default:
+		return xerrors.Errorf("unknown pipeline stage %q", stage)
+	}
+	if err != nil {
+		return xerrors.Errorf("resetting %s stage: %w", stage, err)
+	}
+	if n != 1 {
+		return xerrors.Errorf("sector not in the pipeline or already past the %s stage", stage)
+	}
+
+	if stage == StageSDR {
+		if err := b.stor.Remove(ctx, sid, storiface.FTCache, true, nil); err != nil {
+			return xerrors.Errorf("removing cache file: %w", err)
+		}
+		if err := b.stor.Remove(ctx, sid, storiface.FTSealed, true, nil); err != nil {
+			return xerrors.Errorf("removing sealed file: %w", err)
+		}
+	}
+
+	_, err = b.db.Exec(ctx, `SELECT unset_task_id($1, $2)`, sid.Miner, sid.Number)
+	if err != nil {
+		return xerrors.Errorf("unsetting pipeline task ids: %w", err)
+	}
+
+	return nil
+}
+
+func (b *BatchOpTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (b *BatchOpTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "SectorBatchOp",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(MinSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return b.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (b *BatchOpTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (b *BatchOpTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var ops []struct {
+			OpID int64 `db:"op_id"`
+		}
+
+		err := tx.Select(&ops, `SELECT op_id FROM sector_batch_ops WHERE task_id IS NULL AND complete_time IS NULL LIMIT 20`)
+		if err != nil {
+			return false, xerrors.Errorf("getting batch ops: %w", err)
+		}
+
+		if len(ops) == 0 {
+			return false, nil
+		}
+
+		// pick at random in case there are a bunch of schedules across the cluster
+		op := ops[rand.N(len(ops))]
+
+		_, err = tx.Exec(`UPDATE sector_batch_ops SET task_id = $1 WHERE op_id = $2 AND task_id IS NULL`, id, op.OpID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return true, nil
+	})
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&BatchOpTask{})
+var _ harmonytask.TaskInterface = &BatchOpTask{}
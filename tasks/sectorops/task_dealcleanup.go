@@ -0,0 +1,248 @@
+package sectorops
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/curiochain"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const DealCleanupInterval = 6 * time.Hour
+
+// DealCleanupTask applies the deal cleanup policies in sector_deal_cleanup_policies. Every run it finds the sectors of
+// each SP whose deals all ended and, following the policy, reports them, keeps them from automatic extension, or
+// queues their termination in sector_terminate_ops, sent by the TerminateSectors task.
+type DealCleanupTask struct {
+	db     *harmonydb.DB
+	api    TerminateNodeAPI
+	bstore curiochain.CurioBlockstore
+	cfg    *config.CurioConfig
+}
+
+func NewDealCleanupTask(db *harmonydb.DB, api TerminateNodeAPI, bstore curiochain.CurioBlockstore, cfg *config.CurioConfig) *DealCleanupTask {
+	return &DealCleanupTask{
+		db:     db,
+		api:    api,
+		bstore: bstore,
+		cfg:    cfg,
+	}
+}
+
+type dealCleanupResult struct {
+	candidates int
+	acted      int
+	opID       *int64
+}
+
+func (d *DealCleanupTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	policies, err := DealCleanupPolicies(ctx, d.db)
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range policies {
+		// a failing SP doesn't hold back the others, its error is shown with the policy
+		res, err := d.apply(ctx, p)
+		var errStr *string
+		if err != nil {
+			log.Errorw("applying deal cleanup policy", "sp", p.SpID, "error", err)
+			s := err.Error()
+			errStr = &s
+		}
+
+		_, err = d.db.Exec(ctx, `UPDATE sector_deal_cleanup_policies SET last_run = current_timestamp, last_candidates = $2,
+				last_acted = $3, last_op_id = COALESCE($4, last_op_id), last_error = $5
+			WHERE sp_id = $1`, p.SpID, res.candidates, res.acted, res.opID, errStr)
+		if err != nil {
+			return false, xerrors.Errorf("updating deal cleanup policy of f0%d: %w", p.SpID, err)
+		}
+	}
+
+	return true, nil
+}
+
+func (d *DealCleanupTask) apply(ctx context.Context, p DealCleanupPolicy) (dealCleanupResult, error) {
+	var res dealCleanupResult
+
+	maddr, err := address.NewIDAddress(uint64(p.SpID))
+	if err != nil {
+		return res, err
+	}
+
+	head, err := d.api.ChainHead(ctx)
+	if err != nil {
+		return res, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	cands, err := FindDealCleanupCandidates(ctx, d.db, p.SpID, head.Height(), p.GraceDays, p.MaxSectorsPerRun)
+	if err != nil {
+		return res, err
+	}
+	res.candidates = len(cands)
+
+	switch p.Action {
+	case CleanupExpire:
+		for i := range cands {
+			cands[i].Action = CleanupExpire
+		}
+	case CleanupTerminate:
+		if err := d.planTermination(ctx, maddr, p, cands); err != nil {
+			return res, err
+		}
+	}
+
+	var terminate []int64
+	for _, c := range cands {
+		if c.Action != CleanupReport {
+			res.acted++
+		}
+		if c.Action == CleanupTerminate {
+			terminate = append(terminate, c.SectorNum)
+		}
+	}
+
+	_, err = d.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		// reported sectors are found again on every run, those whose deals were renewed or which ended drop out
+		_, err = tx.Exec(`DELETE FROM sector_deal_cleanup_candidates WHERE sp_id = $1 AND action = $2`, p.SpID, CleanupReport)
+		if err != nil {
+			return false, xerrors.Errorf("removing reported sectors: %w", err)
+		}
+
+		var opID *int64
+		if len(terminate) > 0 {
+			var id int64
+			err = tx.QueryRow(`INSERT INTO sector_terminate_ops (sp_id, remove_data, max_fee) VALUES ($1, TRUE, $2) RETURNING op_id`,
+				p.SpID, abi.TokenAmount(d.cfg.Fees.MaxTerminateGasFee).String()).Scan(&id)
+			if err != nil {
+				return false, xerrors.Errorf("queueing termination: %w", err)
+			}
+			_, err = tx.Exec(`INSERT INTO sector_terminate_items (op_id, sector_number)
+				SELECT $1, unnest($2::BIGINT[]) ON CONFLICT DO NOTHING`, id, terminate)
+			if err != nil {
+				return false, xerrors.Errorf("queueing sectors: %w", err)
+			}
+			opID = &id
+		}
+
+		for _, c := range cands {
+			var actedAt *time.Time
+			var op *int64
+			if c.Action != CleanupReport {
+				now := time.Now()
+				actedAt = &now
+			}
+			if c.Action == CleanupTerminate {
+				op = opID
+			}
+
+			_, err = tx.Exec(`INSERT INTO sector_deal_cleanup_candidates (sp_id, sector_num, pieces, deals_end_epoch,
+					expiration_epoch, action, terminate_op_id, note, acted_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+				ON CONFLICT (sp_id, sector_num) DO NOTHING`,
+				c.SpID, c.SectorNum, c.Pieces, c.DealsEndEpoch, c.ExpirationEpoch, c.Action, op, c.Note, actedAt)
+			if err != nil {
+				return false, xerrors.Errorf("recording sector %d: %w", c.SectorNum, err)
+			}
+		}
+
+		res.opID = opID
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return res, err
+	}
+
+	if res.acted > 0 {
+		log.Infow("cleaned up sectors with ended deals", "miner", maddr, "action", p.Action, "sectors", res.acted,
+			"candidates", res.candidates, "op", res.opID)
+	}
+	return res, nil
+}
+
+// planTermination marks the candidates which can be terminated within the fee limit of the policy, the others are
+// reported with the reason
+func (d *DealCleanupTask) planTermination(ctx context.Context, maddr address.Address, p DealCleanupPolicy, cands []DealCleanupCandidate) error {
+	if len(cands) == 0 {
+		return nil
+	}
+
+	maxFee := big.NewInt(-1)
+	if p.MaxTerminationFee != nil {
+		var err error
+		maxFee, err = big.FromString(*p.MaxTerminationFee)
+		if err != nil {
+			return xerrors.Errorf("parsing max termination fee: %w", err)
+		}
+	}
+
+	nums := make([]abi.SectorNumber, len(cands))
+	for i, c := range cands {
+		nums[i] = abi.SectorNumber(c.SectorNum)
+	}
+	plan, err := PlanTermination(ctx, d.api, d.bstore, maddr, nums)
+	if err != nil {
+		return xerrors.Errorf("planning termination: %w", err)
+	}
+	byNum := map[int64]SectorTermination{}
+	for _, st := range plan.Sectors {
+		byNum[int64(st.SectorNumber)] = st
+	}
+
+	for i := range cands {
+		st := byNum[cands[i].SectorNum]
+
+		var note string
+		switch {
+		case st.Skipped != "":
+			note = st.Skipped
+		case !maxFee.LessThan(big.Zero()) && st.Fee.GreaterThan(maxFee):
+			note = fmt.Sprintf("termination fee %s is above the policy limit of %s", types.FIL(st.Fee).Short(), types.FIL(maxFee).Short())
+		}
+		if note != "" {
+			cands[i].Note = &note
+			continue
+		}
+		// deferred sectors are terminated once their deadline can be changed
+		cands[i].Action = CleanupTerminate
+	}
+	return nil
+}
+
+func (d *DealCleanupTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (d *DealCleanupTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "DealCleanup",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 512 << 20,
+		},
+		IAmBored: harmonytask.SingletonTaskAdder(DealCleanupInterval, d),
+	}
+}
+
+func (d *DealCleanupTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ = harmonytask.Reg(&DealCleanupTask{})
+var _ harmonytask.TaskInterface = &DealCleanupTask{}
@@ -0,0 +1,209 @@
+package sectorops
+
+import (
+	"context"
+	"math/rand/v2"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/curiochain"
+	"github.com/filecoin-project/curio/lib/multictladdr"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/tasks/message"
+
+	"github.com/filecoin-project/lotus/api"
+)
+
+// ExtendSectorsTask sends ExtendSectorExpiration2 messages for extensions queued in sector_extend_ops.
+//
+// Extensions are planned when the task runs, so sectors are extended according to chain state at that
+// point rather than at the time of the request.
+type ExtendSectorsTask struct {
+	db     *harmonydb.DB
+	api    ExtendNodeAPI
+	bstore curiochain.CurioBlockstore
+	sender *message.Sender
+	as     *multictladdr.MultiAddressSelector
+}
+
+func NewExtendSectorsTask(db *harmonydb.DB, api ExtendNodeAPI, bstore curiochain.CurioBlockstore, sender *message.Sender, as *multictladdr.MultiAddressSelector) *ExtendSectorsTask {
+	return &ExtendSectorsTask{
+		db:     db,
+		api:    api,
+		bstore: bstore,
+		sender: sender,
+		as:     as,
+	}
+}
+
+func (e *ExtendSectorsTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var ops []struct {
+		OpID          int64   `db:"op_id"`
+		SpID          int64   `db:"sp_id"`
+		Sectors       []int64 `db:"sectors"`
+		Extension     *int64  `db:"extension"`
+		NewExpiration *int64  `db:"new_expiration"`
+		DropClaims    bool    `db:"drop_claims"`
+		MaxFee        string  `db:"max_fee"`
+		Sent          int     `db:"sent"`
+	}
+	err = e.db.Select(ctx, &ops, `SELECT op_id, sp_id, sectors, extension, new_expiration, drop_claims, max_fee,
+			cardinality(message_cids) AS sent
+		FROM sector_extend_ops WHERE task_id = $1 AND complete_time IS NULL`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting extend op: %w", err)
+	}
+	if len(ops) != 1 {
+		return false, xerrors.Errorf("expected 1 extend op, got %d", len(ops))
+	}
+	op := ops[0]
+
+	if op.Sent > 0 {
+		// A previous attempt failed after sending messages, planning again would send the same extensions.
+		_, err = e.db.Exec(ctx, `UPDATE sector_extend_ops SET complete_time = current_timestamp,
+			error = 'interrupted after sending some messages, check the sectors and request the rest again' WHERE op_id = $1`, op.OpID)
+		if err != nil {
+			return false, xerrors.Errorf("marking extend op complete: %w", err)
+		}
+		return true, nil
+	}
+
+	maddr, err := address.NewIDAddress(uint64(op.SpID))
+	if err != nil {
+		return false, xerrors.Errorf("making miner address: %w", err)
+	}
+	maxFee, err := big.FromString(op.MaxFee)
+	if err != nil {
+		return false, xerrors.Errorf("parsing max fee: %w", err)
+	}
+
+	req := ExtendRequest{DropClaims: op.DropClaims}
+	for _, s := range op.Sectors {
+		req.Sectors = append(req.Sectors, abi.SectorNumber(s))
+	}
+	if op.Extension != nil {
+		req.Extension = abi.ChainEpoch(*op.Extension)
+	}
+	if op.NewExpiration != nil {
+		req.NewExpiration = abi.ChainEpoch(*op.NewExpiration)
+	}
+
+	plan, err := PlanExtension(ctx, e.api, e.bstore, maddr, req)
+	if err != nil {
+		return false, xerrors.Errorf("planning extension: %w", err)
+	}
+	msgs, err := ExtendMessages(ctx, e.api, maddr, plan)
+	if err != nil {
+		return false, xerrors.Errorf("creating messages: %w", err)
+	}
+
+	var extended int
+	var sendErr *string
+	if len(msgs) == 0 {
+		sendErr = lo.ToPtr("none of the selected sectors can be extended")
+	}
+
+	for i, msg := range msgs {
+		msg, _, err := e.as.MultisigProposal(maddr, msg)
+		if err != nil {
+			return false, xerrors.Errorf("creating multisig proposal: %w", err)
+		}
+
+		mcid, err := e.sender.Send(ctx, msg, &api.MessageSendSpec{MaxFee: abi.TokenAmount(maxFee)}, "extend-sectors")
+		if err != nil {
+			// Earlier messages are already out, so the op is not retried as a whole. Sectors from the
+			// failed messages can be extended with a new request.
+			log.Errorw("sending extend message failed", "op", op.OpID, "message", i, "error", err)
+			sendErr = lo.ToPtr(xerrors.Errorf("sending message %d of %d: %w", i+1, len(msgs), err).Error())
+			break
+		}
+
+		_, err = e.db.Exec(ctx, `UPDATE sector_extend_ops SET message_cids = array_append(message_cids, $2) WHERE op_id = $1`, op.OpID, mcid.String())
+		if err != nil {
+			return false, xerrors.Errorf("recording message cid: %w", err)
+		}
+		_, err = e.db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid) VALUES ($1)`, mcid)
+		if err != nil {
+			return false, xerrors.Errorf("inserting into message_waits: %w", err)
+		}
+
+		n, err := ParamsSectorCount(&plan.Params[i])
+		if err != nil {
+			return false, err
+		}
+		extended += n
+	}
+
+	_, err = e.db.Exec(ctx, `UPDATE sector_extend_ops SET complete_time = current_timestamp, extended = $2, error = $3 WHERE op_id = $1`,
+		op.OpID, extended, sendErr)
+	if err != nil {
+		return false, xerrors.Errorf("marking extend op complete: %w", err)
+	}
+
+	return true, nil
+}
+
+func (e *ExtendSectorsTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (e *ExtendSectorsTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "ExtendSectors",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 256 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(MinSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return e.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (e *ExtendSectorsTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (e *ExtendSectorsTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var ops []struct {
+			OpID int64 `db:"op_id"`
+		}
+
+		err := tx.Select(&ops, `SELECT op_id FROM sector_extend_ops WHERE task_id IS NULL AND complete_time IS NULL LIMIT 20`)
+		if err != nil {
+			return false, xerrors.Errorf("getting extend ops: %w", err)
+		}
+
+		if len(ops) == 0 {
+			return false, nil
+		}
+
+		op := ops[rand.N(len(ops))]
+
+		_, err = tx.Exec(`UPDATE sector_extend_ops SET task_id = $1 WHERE op_id = $2 AND task_id IS NULL`, id, op.OpID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return true, nil
+	})
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&ExtendSectorsTask{})
+var _ harmonytask.TaskInterface = &ExtendSectorsTask{}
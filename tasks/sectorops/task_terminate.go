@@ -0,0 +1,344 @@
+package sectorops
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/curiochain"
+	"github.com/filecoin-project/curio/lib/multictladdr"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/tasks/message"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// TerminateMaxAttempts is how many times a sector is sent for termination before it's marked failed
+const TerminateMaxAttempts = 3
+
+// TerminateRecheckInterval is how long a termination op waits before checking sent messages and deferred sectors
+// again
+const TerminateRecheckInterval = 5 * time.Minute
+
+// Termination states of sectors in sector_terminate_items
+const (
+	TerminatePending    = "pending"
+	TerminateSent       = "sent"
+	TerminateTerminated = "terminated"
+	TerminateSkipped    = "skipped"
+	TerminateFailed     = "failed"
+)
+
+// TerminateSectorsTask terminates sectors queued in sector_terminate_ops.
+//
+// Each run first records the outcome of messages sent by earlier runs, returning sectors of failed messages to
+// pending, then sends TerminateSectors messages for pending sectors whose deadlines can be changed. Ops stay open,
+// and are picked up again after TerminateRecheckInterval, until no sector is pending or waiting for a message.
+type TerminateSectorsTask struct {
+	db     *harmonydb.DB
+	api    TerminateNodeAPI
+	bstore curiochain.CurioBlockstore
+	sender *message.Sender
+	as     *multictladdr.MultiAddressSelector
+}
+
+func NewTerminateSectorsTask(db *harmonydb.DB, api TerminateNodeAPI, bstore curiochain.CurioBlockstore, sender *message.Sender, as *multictladdr.MultiAddressSelector) *TerminateSectorsTask {
+	return &TerminateSectorsTask{
+		db:     db,
+		api:    api,
+		bstore: bstore,
+		sender: sender,
+		as:     as,
+	}
+}
+
+func (t *TerminateSectorsTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var ops []struct {
+		OpID       int64  `db:"op_id"`
+		SpID       int64  `db:"sp_id"`
+		RemoveData bool   `db:"remove_data"`
+		MaxFee     string `db:"max_fee"`
+	}
+	err = t.db.Select(ctx, &ops, `SELECT op_id, sp_id, remove_data, max_fee FROM sector_terminate_ops
+		WHERE task_id = $1 AND complete_time IS NULL`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting terminate op: %w", err)
+	}
+	if len(ops) != 1 {
+		return false, xerrors.Errorf("expected 1 terminate op, got %d", len(ops))
+	}
+	op := ops[0]
+
+	if err := t.checkSent(ctx, op.OpID, op.SpID, op.RemoveData); err != nil {
+		return false, xerrors.Errorf("checking sent messages: %w", err)
+	}
+
+	var pending []int64
+	err = t.db.Select(ctx, &pending, `SELECT sector_number FROM sector_terminate_items WHERE op_id = $1 AND state = $2
+		ORDER BY sector_number`, op.OpID, TerminatePending)
+	if err != nil {
+		return false, xerrors.Errorf("getting pending sectors: %w", err)
+	}
+
+	if len(pending) > 0 {
+		if !stillOwned() {
+			return false, xerrors.Errorf("task no longer owned")
+		}
+		if err := t.send(ctx, op.OpID, op.SpID, op.MaxFee, pending); err != nil {
+			return false, err
+		}
+	}
+
+	var open int
+	err = t.db.QueryRow(ctx, `SELECT COUNT(*) FROM sector_terminate_items WHERE op_id = $1 AND state IN ($2, $3)`,
+		op.OpID, TerminatePending, TerminateSent).Scan(&open)
+	if err != nil {
+		return false, xerrors.Errorf("counting open sectors: %w", err)
+	}
+
+	if open == 0 {
+		_, err = t.db.Exec(ctx, `UPDATE sector_terminate_ops SET complete_time = current_timestamp, task_id = NULL WHERE op_id = $1`, op.OpID)
+	} else {
+		_, err = t.db.Exec(ctx, `UPDATE sector_terminate_ops SET task_id = NULL, next_attempt = $2 WHERE op_id = $1`,
+			op.OpID, time.Now().Add(TerminateRecheckInterval))
+	}
+	if err != nil {
+		return false, xerrors.Errorf("updating terminate op: %w", err)
+	}
+
+	return true, nil
+}
+
+// checkSent records the outcome of the landed messages of an op. Sectors of failed messages go back to pending
+// until they run out of attempts.
+func (t *TerminateSectorsTask) checkSent(ctx context.Context, opID, spID int64, removeData bool) error {
+	var landed []struct {
+		Cid      string `db:"signed_message_cid"`
+		ExitCode int64  `db:"executed_rcpt_exitcode"`
+	}
+	err := t.db.Select(ctx, &landed, `SELECT m.signed_message_cid, w.executed_rcpt_exitcode FROM sector_terminate_messages m
+		JOIN message_waits w ON w.signed_message_cid = m.signed_message_cid
+		WHERE m.op_id = $1 AND m.exit_code IS NULL AND w.executed_rcpt_exitcode IS NOT NULL`, opID)
+	if err != nil {
+		return xerrors.Errorf("getting landed messages: %w", err)
+	}
+
+	for _, l := range landed {
+		var burnt *string
+		if l.ExitCode == 0 {
+			mcid, err := cid.Parse(l.Cid)
+			if err != nil {
+				return xerrors.Errorf("parsing message cid: %w", err)
+			}
+			res, err := t.api.StateReplay(ctx, types.EmptyTSK, mcid)
+			if err != nil {
+				log.Warnw("replaying termination message, burnt fee not recorded", "message", l.Cid, "error", err)
+			} else {
+				b := BurntFunds(res.ExecutionTrace).String()
+				burnt = &b
+			}
+		}
+
+		_, err = t.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+			_, err = tx.Exec(`UPDATE sector_terminate_messages SET exit_code = $2, fee_burnt = $3 WHERE signed_message_cid = $1`,
+				l.Cid, l.ExitCode, burnt)
+			if err != nil {
+				return false, xerrors.Errorf("recording message outcome: %w", err)
+			}
+
+			if l.ExitCode == 0 {
+				_, err = tx.Exec(`UPDATE sector_terminate_items SET state = $3, terminated_at = current_timestamp
+					WHERE op_id = $1 AND signed_message_cid = $2`, opID, l.Cid, TerminateTerminated)
+				if err != nil {
+					return false, xerrors.Errorf("marking sectors terminated: %w", err)
+				}
+
+				if removeData {
+					_, err = tx.Exec(`INSERT INTO storage_removal_marks (sp_id, sector_num, sector_filetype, storage_id, created_at, approved, approved_at)
+						SELECT l.miner_id, l.sector_num, l.sector_filetype, l.storage_id, current_timestamp, FALSE, NULL
+						FROM sector_location l
+						JOIN sector_terminate_items i ON i.sector_number = l.sector_num
+						WHERE l.miner_id = $1 AND i.op_id = $2 AND i.signed_message_cid = $3
+						ON CONFLICT DO NOTHING`, spID, opID, l.Cid)
+					if err != nil {
+						return false, xerrors.Errorf("marking sector files for removal: %w", err)
+					}
+				}
+				return true, nil
+			}
+
+			msgErr := fmt.Sprintf("message %s failed with exit code %d", l.Cid, l.ExitCode)
+			_, err = tx.Exec(`UPDATE sector_terminate_items
+				SET state = CASE WHEN attempts >= $3 THEN $4 ELSE $5 END, signed_message_cid = NULL, error = $6
+				WHERE op_id = $1 AND signed_message_cid = $2`, opID, l.Cid, TerminateMaxAttempts, TerminateFailed, TerminatePending, msgErr)
+			if err != nil {
+				return false, xerrors.Errorf("returning sectors of failed message: %w", err)
+			}
+			return true, nil
+		}, harmonydb.OptionRetry())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send plans and sends termination messages for pending sectors. Each message is recorded as soon as it's sent, so
+// that a failure half way through only leaves the remaining sectors pending.
+func (t *TerminateSectorsTask) send(ctx context.Context, opID, spID int64, maxFeeStr string, pending []int64) error {
+	maddr, err := address.NewIDAddress(uint64(spID))
+	if err != nil {
+		return xerrors.Errorf("making miner address: %w", err)
+	}
+	maxFee, err := big.FromString(maxFeeStr)
+	if err != nil {
+		return xerrors.Errorf("parsing max fee: %w", err)
+	}
+
+	sectors := make([]abi.SectorNumber, len(pending))
+	for i, s := range pending {
+		sectors[i] = abi.SectorNumber(s)
+	}
+
+	plan, err := PlanTermination(ctx, t.api, t.bstore, maddr, sectors)
+	if err != nil {
+		return xerrors.Errorf("planning termination: %w", err)
+	}
+
+	fees := map[abi.SectorNumber]abi.TokenAmount{}
+	for _, st := range plan.Sectors {
+		fees[st.SectorNumber] = st.Fee
+		if st.Skipped == "" {
+			continue
+		}
+
+		_, err := t.db.Exec(ctx, `UPDATE sector_terminate_items SET state = $3, error = $4 WHERE op_id = $1 AND sector_number = $2`,
+			opID, st.SectorNumber, TerminateSkipped, st.Skipped)
+		if err != nil {
+			return xerrors.Errorf("marking sector skipped: %w", err)
+		}
+	}
+
+	for i, tm := range plan.Messages {
+		msg, _, err := t.as.MultisigProposal(maddr, tm.Msg)
+		if err != nil {
+			return xerrors.Errorf("creating multisig proposal: %w", err)
+		}
+
+		mcid, err := t.sender.Send(ctx, msg, &api.MessageSendSpec{MaxFee: abi.TokenAmount(maxFee)}, "terminate-sectors")
+		if err != nil {
+			// sectors of this and later messages stay pending for the next run
+			log.Errorw("sending terminate message failed", "op", opID, "message", i, "error", err)
+			_, uerr := t.db.Exec(ctx, `UPDATE sector_terminate_ops SET error = $2 WHERE op_id = $1`, opID,
+				xerrors.Errorf("sending message %d of %d: %w", i+1, len(plan.Messages), err).Error())
+			if uerr != nil {
+				return xerrors.Errorf("recording send error: %w", uerr)
+			}
+			return nil
+		}
+
+		nums := make([]int64, len(tm.Sectors))
+		msgFees := make([]string, len(tm.Sectors))
+		for j, s := range tm.Sectors {
+			nums[j] = int64(s)
+			msgFees[j] = fees[s].String()
+		}
+
+		_, err = t.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+			_, err = tx.Exec(`INSERT INTO sector_terminate_messages (signed_message_cid, op_id, sectors) VALUES ($1, $2, $3)`,
+				mcid.String(), opID, len(tm.Sectors))
+			if err != nil {
+				return false, xerrors.Errorf("recording message: %w", err)
+			}
+			_, err = tx.Exec(`INSERT INTO message_waits (signed_message_cid) VALUES ($1)`, mcid)
+			if err != nil {
+				return false, xerrors.Errorf("inserting into message_waits: %w", err)
+			}
+			_, err = tx.Exec(`UPDATE sector_terminate_items i SET state = $2, signed_message_cid = $3, attempts = i.attempts + 1,
+					termination_fee = f.fee, error = NULL
+				FROM unnest($4::BIGINT[], $5::TEXT[]) AS f(sector_number, fee)
+				WHERE i.op_id = $1 AND i.sector_number = f.sector_number`, opID, TerminateSent, mcid.String(), nums, msgFees)
+			if err != nil {
+				return false, xerrors.Errorf("marking sectors sent: %w", err)
+			}
+			return true, nil
+		}, harmonydb.OptionRetry())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *TerminateSectorsTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (t *TerminateSectorsTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "TerminateSectors",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 256 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(MinSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return t.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (t *TerminateSectorsTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (t *TerminateSectorsTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var ops []struct {
+			OpID int64 `db:"op_id"`
+		}
+
+		err := tx.Select(&ops, `SELECT op_id FROM sector_terminate_ops
+			WHERE task_id IS NULL AND complete_time IS NULL AND (next_attempt IS NULL OR next_attempt <= current_timestamp)
+			LIMIT 20`)
+		if err != nil {
+			return false, xerrors.Errorf("getting terminate ops: %w", err)
+		}
+
+		if len(ops) == 0 {
+			return false, nil
+		}
+
+		op := ops[rand.N(len(ops))]
+
+		_, err = tx.Exec(`UPDATE sector_terminate_ops SET task_id = $1 WHERE op_id = $2 AND task_id IS NULL`, id, op.OpID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return true, nil
+	})
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&TerminateSectorsTask{})
+var _ harmonytask.TaskInterface = &TerminateSectorsTask{}
@@ -0,0 +1,265 @@
+package sectorops
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	miner13 "github.com/filecoin-project/go-state-types/builtin/v13/miner"
+	"github.com/filecoin-project/go-state-types/dline"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/curio/lib/curiochain"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// TerminateMargin is how many epochs before a deadline becomes immutable its sectors stop being terminated, so that
+// termination messages land while the deadline can still be changed
+const TerminateMargin = 20
+
+type TerminateNodeAPI interface {
+	ChainHead(ctx context.Context) (*types.TipSet, error)
+	StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error)
+	StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error)
+	StateMinerInfo(ctx context.Context, maddr address.Address, tsk types.TipSetKey) (api.MinerInfo, error)
+	StateMinerProvingDeadline(ctx context.Context, maddr address.Address, tsk types.TipSetKey) (*dline.Info, error)
+	StateCall(ctx context.Context, msg *types.Message, tsk types.TipSetKey) (*api.InvocResult, error)
+	StateReplay(ctx context.Context, tsk types.TipSetKey, mc cid.Cid) (*api.InvocResult, error)
+}
+
+type SectorTermination struct {
+	SectorNumber abi.SectorNumber
+	Deadline     uint64
+	Partition    uint64
+
+	// Fee is the termination fee of the sector alone, from a simulated termination at the planning epoch
+	Fee abi.TokenAmount
+
+	// Deferred is set for sectors in deadlines which can't be changed at the planning epoch
+	Deferred bool
+	Skipped  string `json:",omitempty"`
+}
+
+// TerminateMessage is one TerminateSectors message of a termination plan
+type TerminateMessage struct {
+	Msg     *types.Message
+	Sectors []abi.SectorNumber
+}
+
+type TerminatePlan struct {
+	Epoch    abi.ChainEpoch
+	Sectors  []SectorTermination
+	Messages []TerminateMessage
+}
+
+// PlanTermination computes TerminateSectors messages for the live sectors among the requested ones, within the
+// declaration and addressed sector limits of the network. Sectors in deadlines which can't be changed at the current
+// epoch are deferred.
+func PlanTermination(ctx context.Context, full TerminateNodeAPI, bstore curiochain.CurioBlockstore, maddr address.Address, sectors []abi.SectorNumber) (*TerminatePlan, error) {
+	head, err := full.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+	nv, err := full.StateNetworkVersion(ctx, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting network version: %w", err)
+	}
+	mi, err := full.StateMinerInfo(ctx, maddr, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner info: %w", err)
+	}
+	di, err := full.StateMinerProvingDeadline(ctx, maddr, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting proving deadline: %w", err)
+	}
+
+	mact, err := full.StateGetActor(ctx, maddr, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner actor: %w", err)
+	}
+	mas, err := miner.Load(adt.WrapStore(ctx, cbor.NewCborStore(bstore)), mact)
+	if err != nil {
+		return nil, xerrors.Errorf("loading miner state: %w", err)
+	}
+	live, err := miner.AllPartSectors(mas, miner.Partition.LiveSectors)
+	if err != nil {
+		return nil, xerrors.Errorf("getting live sectors: %w", err)
+	}
+
+	sectorsMax, err := policy.GetAddressedSectorsMax(nv)
+	if err != nil {
+		return nil, xerrors.Errorf("getting addressed sectors max: %w", err)
+	}
+	declMax, err := policy.GetDeclarationsMax(nv)
+	if err != nil {
+		return nil, xerrors.Errorf("getting declarations max: %w", err)
+	}
+
+	sectors = append([]abi.SectorNumber(nil), sectors...)
+	sort.Slice(sectors, func(i, j int) bool { return sectors[i] < sectors[j] })
+
+	var parts []*partitionSectors
+	byLoc := map[miner.SectorLocation]*partitionSectors{}
+
+	plan := &TerminatePlan{Epoch: head.Height()}
+	for _, num := range sectors {
+		st := SectorTermination{SectorNumber: num, Fee: big.Zero()}
+
+		isLive, err := live.IsSet(uint64(num))
+		if err != nil {
+			return nil, xerrors.Errorf("checking if sector %d is live: %w", num, err)
+		}
+		if !isLive {
+			st.Skipped = "sector is not live on chain"
+			plan.Sectors = append(plan.Sectors, st)
+			continue
+		}
+
+		loc, err := mas.FindSector(num)
+		if err != nil {
+			return nil, xerrors.Errorf("finding sector %d: %w", num, err)
+		}
+		st.Deadline, st.Partition = loc.Deadline, loc.Partition
+
+		if !deadlineMutable(di, loc.Deadline, head.Height()) {
+			st.Deferred = true
+			plan.Sectors = append(plan.Sectors, st)
+			continue
+		}
+
+		single := &miner13.TerminateSectorsParams{Terminations: []miner13.TerminationDeclaration{{
+			Deadline: loc.Deadline, Partition: loc.Partition, Sectors: bitfield.NewFromSet([]uint64{uint64(num)}),
+		}}}
+		st.Fee, err = simulateTermination(ctx, full, head.Key(), mi.Worker, maddr, single)
+		if err != nil {
+			return nil, xerrors.Errorf("simulating termination of sector %d: %w", num, err)
+		}
+		plan.Sectors = append(plan.Sectors, st)
+
+		p, ok := byLoc[*loc]
+		if !ok {
+			p = &partitionSectors{loc: *loc}
+			byLoc[*loc] = p
+			parts = append(parts, p)
+		}
+		p.sectors = append(p.sectors, num)
+	}
+
+	for _, b := range batchTerminations(parts, declMax, sectorsMax) {
+		msg, err := terminateMessage(mi.Worker, maddr, &b.params)
+		if err != nil {
+			return nil, err
+		}
+		plan.Messages = append(plan.Messages, TerminateMessage{Msg: msg, Sectors: b.sectors})
+	}
+
+	return plan, nil
+}
+
+type partitionSectors struct {
+	loc     miner.SectorLocation
+	sectors []abi.SectorNumber
+}
+
+type terminateBatch struct {
+	params  miner13.TerminateSectorsParams
+	sectors []abi.SectorNumber
+}
+
+// batchTerminations packs the sectors of partitions into messages of at most declMax declarations and sectorsMax
+// sectors, splitting large partitions over messages
+func batchTerminations(parts []*partitionSectors, declMax, sectorsMax int) []terminateBatch {
+	var out []terminateBatch
+	var cur terminateBatch
+
+	for _, p := range parts {
+		for rest := p.sectors; len(rest) > 0; {
+			if len(cur.params.Terminations) >= declMax || len(cur.sectors) >= sectorsMax {
+				out = append(out, cur)
+				cur = terminateBatch{}
+			}
+
+			n := min(len(rest), sectorsMax-len(cur.sectors))
+			nums := make([]uint64, n)
+			for i, s := range rest[:n] {
+				nums[i] = uint64(s)
+			}
+
+			cur.params.Terminations = append(cur.params.Terminations, miner13.TerminationDeclaration{
+				Deadline:  p.loc.Deadline,
+				Partition: p.loc.Partition,
+				Sectors:   bitfield.NewFromSet(nums),
+			})
+			cur.sectors = append(cur.sectors, rest[:n]...)
+			rest = rest[n:]
+		}
+	}
+	if len(cur.params.Terminations) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// deadlineMutable mirrors the check of the miner actor, which rejects terminations of sectors in the current deadline
+// and in deadlines whose challenge window is less than a challenge window away, with TerminateMargin to spare
+func deadlineMutable(cur *dline.Info, dlIdx uint64, epoch abi.ChainEpoch) bool {
+	di := dline.NewInfo(cur.PeriodStart, dlIdx, epoch, cur.WPoStPeriodDeadlines, cur.WPoStProvingPeriod,
+		cur.WPoStChallengeWindow, cur.WPoStChallengeLookback, cur.FaultDeclarationCutoff).NextNotElapsed()
+	return epoch+TerminateMargin < di.Challenge-cur.WPoStChallengeWindow
+}
+
+func terminateMessage(from, maddr address.Address, params *miner13.TerminateSectorsParams) (*types.Message, error) {
+	enc, aerr := actors.SerializeParams(params)
+	if aerr != nil {
+		return nil, xerrors.Errorf("serializing params: %w", aerr)
+	}
+	return &types.Message{
+		From:   from,
+		To:     maddr,
+		Method: builtin.MethodsMiner.TerminateSectors,
+		Value:  big.Zero(),
+		Params: enc,
+	}, nil
+}
+
+func simulateTermination(ctx context.Context, full TerminateNodeAPI, tsk types.TipSetKey, from, maddr address.Address, params *miner13.TerminateSectorsParams) (abi.TokenAmount, error) {
+	msg, err := terminateMessage(from, maddr, params)
+	if err != nil {
+		return big.Zero(), err
+	}
+	res, err := full.StateCall(ctx, msg, tsk)
+	if err != nil {
+		return big.Zero(), err
+	}
+	if res.MsgRct != nil && res.MsgRct.ExitCode.IsError() {
+		return big.Zero(), xerrors.Errorf("termination fails with exit code %d: %s", res.MsgRct.ExitCode, res.Error)
+	}
+	return BurntFunds(res.ExecutionTrace), nil
+}
+
+// BurntFunds returns the funds a message sent to the burnt funds actor, which for TerminateSectors is the early
+// termination fee. Gas isn't included.
+func BurntFunds(trace types.ExecutionTrace) abi.TokenAmount {
+	burnt := big.Zero()
+	for _, sc := range trace.Subcalls {
+		if sc.Msg.To == builtin.BurntFundsActorAddr && sc.MsgRct.ExitCode.IsSuccess() {
+			burnt = big.Add(burnt, sc.Msg.Value)
+		}
+		burnt = big.Add(burnt, BurntFunds(sc))
+	}
+	return burnt
+}
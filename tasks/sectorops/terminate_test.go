@@ -0,0 +1,140 @@
+package sectorops
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/dline"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestDeadlineMutable(t *testing.T) {
+	// 48 deadlines of 60 epochs, challenged 20 epochs before they open
+	info := func(epoch abi.ChainEpoch) *dline.Info {
+		return dline.NewInfo(0, 0, epoch, 48, 2880, 60, 20, 70)
+	}
+
+	cases := []struct {
+		name     string
+		epoch    abi.ChainEpoch
+		deadline uint64
+		want     bool
+	}{
+		{"current deadline", 0, 0, false},
+		{"next deadline", 0, 1, false},
+		{"far enough", 0, 2, true},
+		{"last deadline", 0, 47, true},
+		{"just before the margin", 19, 2, true},
+		{"within the margin", 20, 2, false},
+		{"current deadline later", 200, 3, false},
+		{"upcoming deadline", 200, 5, false},
+		{"later deadline", 200, 6, true},
+		{"elapsed deadline", 200, 1, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.want, deadlineMutable(info(c.epoch), c.deadline, c.epoch))
+		})
+	}
+}
+
+func TestBatchTerminations(t *testing.T) {
+	nums := func(ns ...abi.SectorNumber) []abi.SectorNumber { return ns }
+	parts := []*partitionSectors{
+		{loc: miner.SectorLocation{Deadline: 1, Partition: 0}, sectors: nums(1, 2, 3)},
+		{loc: miner.SectorLocation{Deadline: 1, Partition: 1}, sectors: nums(4)},
+		{loc: miner.SectorLocation{Deadline: 2, Partition: 0}, sectors: nums(5, 6)},
+	}
+
+	type decl struct {
+		deadline, partition uint64
+		sectors             []uint64
+	}
+	cases := []struct {
+		name                string
+		parts               []*partitionSectors
+		declMax, sectorsMax int
+		want                [][]decl
+	}{
+		{"nothing", nil, 10, 100, nil},
+		{"one message", parts, 10, 100, [][]decl{
+			{{1, 0, []uint64{1, 2, 3}}, {1, 1, []uint64{4}}, {2, 0, []uint64{5, 6}}},
+		}},
+		{"declaration limit", parts, 2, 100, [][]decl{
+			{{1, 0, []uint64{1, 2, 3}}, {1, 1, []uint64{4}}},
+			{{2, 0, []uint64{5, 6}}},
+		}},
+		{"sector limit splits partitions", parts, 10, 2, [][]decl{
+			{{1, 0, []uint64{1, 2}}},
+			{{1, 0, []uint64{3}}, {1, 1, []uint64{4}}},
+			{{2, 0, []uint64{5, 6}}},
+		}},
+		{"one declaration per message", parts, 1, 100, [][]decl{
+			{{1, 0, []uint64{1, 2, 3}}},
+			{{1, 1, []uint64{4}}},
+			{{2, 0, []uint64{5, 6}}},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			batches := batchTerminations(c.parts, c.declMax, c.sectorsMax)
+			require.Len(t, batches, len(c.want))
+
+			for i, b := range batches {
+				var got []decl
+				var sectors []abi.SectorNumber
+				for _, d := range b.params.Terminations {
+					s, err := d.Sectors.All(uint64(c.sectorsMax))
+					require.NoError(t, err)
+					got = append(got, decl{d.Deadline, d.Partition, s})
+					for _, n := range s {
+						sectors = append(sectors, abi.SectorNumber(n))
+					}
+				}
+				require.Equal(t, c.want[i], got)
+				require.Equal(t, sectors, b.sectors)
+			}
+		})
+	}
+}
+
+func TestBurntFunds(t *testing.T) {
+	other, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	call := func(to address.Address, value int64, code exitcode.ExitCode, subcalls ...types.ExecutionTrace) types.ExecutionTrace {
+		return types.ExecutionTrace{
+			Msg:      types.MessageTrace{To: to, Value: big.NewInt(value)},
+			MsgRct:   types.ReturnTrace{ExitCode: code},
+			Subcalls: subcalls,
+		}
+	}
+
+	cases := []struct {
+		name  string
+		trace types.ExecutionTrace
+		want  int64
+	}{
+		{"no subcalls", call(other, 0, exitcode.Ok), 0},
+		{"burn", call(other, 0, exitcode.Ok, call(builtin.BurntFundsActorAddr, 100, exitcode.Ok)), 100},
+		{"other transfers", call(other, 0, exitcode.Ok, call(other, 1000, exitcode.Ok)), 0},
+		{"failed burn", call(other, 0, exitcode.Ok, call(builtin.BurntFundsActorAddr, 100, exitcode.ErrForbidden)), 0},
+		{"nested burns", call(other, 0, exitcode.Ok,
+			call(builtin.BurntFundsActorAddr, 100, exitcode.Ok),
+			call(other, 1000, exitcode.Ok, call(builtin.BurntFundsActorAddr, 50, exitcode.Ok)),
+		), 150},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.want, BurntFunds(c.trace).Int64())
+		})
+	}
+}
@@ -331,6 +331,12 @@ func (s *SubmitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 		Value:  collateral, // todo config for pulling from miner balance!!
 	}
 
+	// funded messages are proposed to the funds multisig when one is configured
+	msg, _, err = s.as.MultisigProposal(maddr, msg)
+	if err != nil {
+		return false, xerrors.Errorf("creating multisig proposal: %w", err)
+	}
+
 	mss := &api.MessageSendSpec{
 		MaxFee: abi.TokenAmount(s.cfg.maxFee),
 	}
@@ -0,0 +1,207 @@
+// Package storagehealth probes storage paths from every node and marks paths which respond badly as degraded.
+package storagehealth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+var log = logging.Logger("storagehealth")
+
+// MaxParallelProbes bounds the number of storage URLs probed concurrently by a node
+const MaxParallelProbes = 16
+
+// ProbeTimeout bounds a single probe, probes which take longer count as failed
+var ProbeTimeout = 30 * time.Second
+
+// ProbeWindow is the number of probe intervals for which probe results are taken into account when
+// deciding if a path is degraded
+const ProbeWindow = 3
+
+type prober struct {
+	db     *harmonydb.DB
+	remote *paths.Remote
+	name   string
+	cfg    config.StorageHealthProbeConfig
+}
+
+// StartProber starts probing all storage paths from this node in the background. name identifies this node
+// in probe results.
+func StartProber(ctx context.Context, db *harmonydb.DB, remote *paths.Remote, name string, cfg config.StorageHealthProbeConfig) {
+	if cfg.Interval <= 0 {
+		return
+	}
+
+	p := &prober{
+		db:     db,
+		remote: remote,
+		name:   name,
+		cfg:    cfg,
+	}
+	go p.run(ctx)
+}
+
+func (p *prober) run(ctx context.Context) {
+	for {
+		if err := p.probeAll(ctx); err != nil {
+			log.Errorw("probing storage paths", "error", err)
+		}
+		if err := p.updateDegraded(ctx); err != nil {
+			log.Errorw("updating degraded storage paths", "error", err)
+		}
+
+		select {
+		case <-time.After(time.Duration(p.cfg.Interval)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type probeResult struct {
+	storageID storiface.ID
+	url       string
+
+	probe paths.StorageProbe
+	err   error
+}
+
+func (p *prober) probeAll(ctx context.Context) error {
+	var storage []struct {
+		StorageID storiface.ID `db:"storage_id"`
+		Urls      string       `db:"urls"`
+	}
+	err := p.db.Select(ctx, &storage, `SELECT storage_id, urls FROM storage_path`)
+	if err != nil {
+		return xerrors.Errorf("getting storage paths: %w", err)
+	}
+
+	var results []probeResult
+	var resultLk sync.Mutex
+	var wg sync.WaitGroup
+	throttle := make(chan struct{}, MaxParallelProbes)
+
+	for _, s := range storage {
+		for _, u := range strings.Split(s.Urls, paths.URLSeparator) {
+			if u == "" {
+				continue
+			}
+
+			select {
+			case throttle <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			wg.Add(1)
+			go func(id storiface.ID, u string) {
+				defer wg.Done()
+				defer func() { <-throttle }()
+
+				pctx, cancel := context.WithTimeout(ctx, ProbeTimeout)
+				defer cancel()
+
+				probe, err := p.remote.ProbeUrl(pctx, u, id)
+
+				resultLk.Lock()
+				results = append(results, probeResult{storageID: id, url: u, probe: probe, err: err})
+				resultLk.Unlock()
+			}(s.StorageID, u)
+		}
+	}
+	wg.Wait()
+
+	now := time.Now()
+	slow := time.Duration(p.cfg.SlowThreshold)
+
+	_, err = p.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+		for _, r := range results {
+			ok := r.err == nil
+			var errStr *string
+			var latency, readTime, readBytes, available *int64
+
+			if r.err != nil {
+				e := r.err.Error()
+				errStr = &e
+			} else {
+				latency = ptr(r.probe.Latency.Milliseconds())
+				readTime = ptr(r.probe.ReadTime.Milliseconds())
+				readBytes = ptr(r.probe.ReadBytes)
+				available = ptr(r.probe.Stat.Available)
+
+				if slow > 0 && (r.probe.Latency > slow || r.probe.ReadTime > slow) {
+					ok = false
+					e := "slow: latency " + r.probe.Latency.Round(time.Millisecond).String() + ", read " + r.probe.ReadTime.Round(time.Millisecond).String()
+					errStr = &e
+				}
+			}
+
+			if !ok {
+				log.Warnw("storage path probe failed", "storage", r.storageID, "url", r.url, "error", *errStr)
+			}
+
+			// the path may have been detached since it was listed
+			_, err := tx.Exec(`INSERT INTO storage_path_probes (storage_id, url, prober, probe_time, ok, latency_ms, read_ms, read_bytes, available, error)
+				SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10 WHERE EXISTS (SELECT 1 FROM storage_path WHERE storage_id = $1)
+				ON CONFLICT (storage_id, url, prober) DO UPDATE SET probe_time = EXCLUDED.probe_time, ok = EXCLUDED.ok,
+					latency_ms = EXCLUDED.latency_ms, read_ms = EXCLUDED.read_ms, read_bytes = EXCLUDED.read_bytes,
+					available = EXCLUDED.available, error = EXCLUDED.error`,
+				r.storageID, r.url, p.name, now, ok, latency, readTime, readBytes, available, errStr)
+			if err != nil {
+				return false, xerrors.Errorf("recording probe result: %w", err)
+			}
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return xerrors.Errorf("recording probe results: %w", err)
+	}
+
+	return nil
+}
+
+// updateDegraded marks paths with more failed than successful recent probes as degraded, considering the latest
+// probe of every URL from every node
+func (p *prober) updateDegraded(ctx context.Context) error {
+	window := (time.Duration(p.cfg.Interval) * ProbeWindow).Seconds()
+
+	_, err := p.db.Exec(ctx, `UPDATE storage_path sp SET degraded = d.degraded, degraded_reason = CASE WHEN d.degraded THEN d.reason END
+		FROM (
+			SELECT storage_id,
+				COUNT(*) FILTER (WHERE NOT ok) * 2 > COUNT(*) AS degraded,
+				string_agg(prober || ' -> ' || url || ': ' || error, '; ') FILTER (WHERE NOT ok) AS reason
+			FROM storage_path_probes
+			WHERE probe_time > NOW() - ($1 * INTERVAL '1 second')
+			GROUP BY storage_id
+		) d
+		WHERE sp.storage_id = d.storage_id
+		  AND (sp.degraded != d.degraded OR sp.degraded_reason IS DISTINCT FROM CASE WHEN d.degraded THEN d.reason END)`, window)
+	if err != nil {
+		return xerrors.Errorf("marking degraded paths: %w", err)
+	}
+
+	// paths nobody probed recently, e.g. because probing was disabled, are not considered degraded
+	_, err = p.db.Exec(ctx, `UPDATE storage_path SET degraded = FALSE, degraded_reason = NULL
+		WHERE degraded AND NOT EXISTS (
+			SELECT 1 FROM storage_path_probes pr
+			WHERE pr.storage_id = storage_path.storage_id AND pr.probe_time > NOW() - ($1 * INTERVAL '1 second'))`, window)
+	if err != nil {
+		return xerrors.Errorf("clearing unprobed paths: %w", err)
+	}
+
+	return nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
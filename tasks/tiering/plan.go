@@ -0,0 +1,133 @@
+package tiering
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/build"
+	"github.com/filecoin-project/curio/lib/storiface"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+)
+
+// tieredFileTypes are the sector files moved between tiers. Unsealed copies are
+// left where they are as they serve retrievals.
+var tieredFileTypes = []storiface.SectorFileType{storiface.FTSealed, storiface.FTCache, storiface.FTUpdate, storiface.FTUpdateCache}
+
+// plan records moves of sectors which have been sitting in the hot tier for
+// longer than MigrateAfter into the cold tier. Sectors in the currently open
+// deadline and the PinDeadlines deadlines after it are left in place so that
+// WindowPoSt doesn't race with the move.
+func (t *StorageTierMoveTask) plan(ctx context.Context) error {
+	cfg := t.cfg
+
+	var pending int
+	err := t.db.QueryRow(ctx, `SELECT COUNT(*) FROM storage_tier_moves WHERE complete_time IS NULL`).Scan(&pending)
+	if err != nil {
+		return xerrors.Errorf("counting pending moves: %w", err)
+	}
+	room := cfg.MaxPendingMoves - pending
+	if room <= 0 {
+		return nil
+	}
+
+	var haveCold bool
+	err = t.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM storage_path WHERE tier = $1 AND can_store)`, cfg.ColdTier).Scan(&haveCold)
+	if err != nil {
+		return xerrors.Errorf("checking for %s tier paths: %w", cfg.ColdTier, err)
+	}
+	if !haveCold {
+		return nil
+	}
+
+	head, err := t.api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	minAge := abi.ChainEpoch(time.Duration(cfg.MigrateAfter) / (time.Duration(build.BlockDelaySecs) * time.Second))
+	cutoff := head.Height() - minAge
+
+	fileTypes := make([]int64, len(tieredFileTypes))
+	for i, ft := range tieredFileTypes {
+		fileTypes[i] = int64(ft)
+	}
+
+	var candidates []struct {
+		SpID         int64  `db:"sp_id"`
+		SectorNumber int64  `db:"sector_number"`
+		RegSealProof int64  `db:"reg_seal_proof"`
+		FileTypes    int64  `db:"file_types"`
+		StorageID    string `db:"storage_id"`
+		SeedEpoch    int64  `db:"seed_epoch"`
+		Deadline     *int64 `db:"deadline"`
+	}
+
+	// fetch more than there is room for, some of the candidates may be pinned
+	err = t.db.Select(ctx, &candidates, `SELECT sl.miner_id AS sp_id, sl.sector_num AS sector_number, sm.reg_seal_proof,
+			bit_or(sl.sector_filetype) AS file_types, MIN(sl.storage_id) AS storage_id, sm.seed_epoch, sm.deadline
+		FROM sector_location sl
+			JOIN storage_path sp ON sp.storage_id = sl.storage_id
+			JOIN sectors_meta sm ON sm.sp_id = sl.miner_id AND sm.sector_num = sl.sector_num
+		WHERE sp.tier = $1 AND NOT sp.archive AND sl.is_primary AND sl.sector_filetype = ANY($2) AND sm.seed_epoch < $3
+			AND NOT EXISTS (SELECT 1 FROM storage_tier_moves m
+				WHERE m.sp_id = sl.miner_id AND m.sector_number = sl.sector_num AND m.complete_time IS NULL)
+		GROUP BY sl.miner_id, sl.sector_num, sm.reg_seal_proof, sm.seed_epoch, sm.deadline
+		ORDER BY sm.seed_epoch ASC
+		LIMIT $4`, cfg.HotTier, fileTypes, cutoff, room*4)
+	if err != nil {
+		return xerrors.Errorf("getting tiering candidates: %w", err)
+	}
+
+	openDeadline := map[int64]uint64{}
+
+	for _, c := range candidates {
+		if room <= 0 {
+			break
+		}
+
+		// sectors with an unknown deadline can't be checked against the pin window
+		if c.Deadline == nil {
+			continue
+		}
+
+		dl, ok := openDeadline[c.SpID]
+		if !ok {
+			maddr, err := address.NewIDAddress(uint64(c.SpID))
+			if err != nil {
+				return err
+			}
+
+			di, err := t.api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+			if err != nil {
+				return xerrors.Errorf("getting proving deadline of %s: %w", maddr, err)
+			}
+			dl = di.Index
+			openDeadline[c.SpID] = dl
+		}
+
+		if (uint64(*c.Deadline)+miner.WPoStPeriodDeadlines-dl)%miner.WPoStPeriodDeadlines <= uint64(cfg.PinDeadlines) {
+			continue
+		}
+
+		age := time.Duration(head.Height()-abi.ChainEpoch(c.SeedEpoch)) * time.Duration(build.BlockDelaySecs) * time.Second
+		reason := fmt.Sprintf("committed %s ago, migrate after %s", age.Truncate(time.Hour), time.Duration(cfg.MigrateAfter))
+
+		n, err := t.db.Exec(ctx, `INSERT INTO storage_tier_moves (sp_id, sector_number, reg_seal_proof, file_types, from_storage_id, from_tier, to_tier, reason)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT DO NOTHING`,
+			c.SpID, c.SectorNumber, c.RegSealProof, c.FileTypes, c.StorageID, cfg.HotTier, cfg.ColdTier, reason)
+		if err != nil {
+			return xerrors.Errorf("recording tier move: %w", err)
+		}
+
+		room -= n
+	}
+
+	return nil
+}
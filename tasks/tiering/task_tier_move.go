@@ -0,0 +1,297 @@
+package tiering
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var log = logging.Logger("tiering")
+
+const MinSchedInterval = 10 * time.Second
+
+// PlanInterval is how often the tiering policy looks for sectors to migrate.
+const PlanInterval = 5 * time.Minute
+
+type TierNodeAPI interface {
+	ChainHead(ctx context.Context) (*types.TipSet, error)
+	StateMinerProvingDeadline(ctx context.Context, maddr address.Address, tsk types.TipSetKey) (*dline.Info, error)
+}
+
+// StorageTierMoveTask migrates sectors between storage tiers. Moves are
+// planned by the tiering policy into storage_tier_moves and executed by a node
+// which has a local path in the destination tier.
+type StorageTierMoveTask struct {
+	db    *harmonydb.DB
+	api   TierNodeAPI
+	stor  *paths.Remote
+	lstor *paths.Local
+	index paths.SectorIndex
+	cfg   config.StorageTieringConfig
+	max   int
+
+	lastPlan time.Time
+}
+
+func NewStorageTierMoveTask(db *harmonydb.DB, api TierNodeAPI, stor *paths.Remote, lstor *paths.Local, index paths.SectorIndex, cfg config.StorageTieringConfig, max int) *StorageTierMoveTask {
+	return &StorageTierMoveTask{
+		db:    db,
+		api:   api,
+		stor:  stor,
+		lstor: lstor,
+		index: index,
+		cfg:   cfg,
+		max:   max,
+	}
+}
+
+type tierMove struct {
+	MoveID       int64  `db:"move_id"`
+	SpID         int64  `db:"sp_id"`
+	SectorNumber int64  `db:"sector_number"`
+	RegSealProof int64  `db:"reg_seal_proof"`
+	FileTypes    int64  `db:"file_types"`
+	ToTier       string `db:"to_tier"`
+}
+
+func (t *StorageTierMoveTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	tid := int64(taskID)
+	ctx := paths.WithReservationLease(context.Background(), paths.ReservationLeaseInfo{TaskID: &tid, Purpose: "tier move"})
+
+	var moves []tierMove
+	err = t.db.Select(ctx, &moves, `SELECT move_id, sp_id, sector_number, reg_seal_proof, file_types, to_tier
+		FROM storage_tier_moves WHERE task_id = $1 AND complete_time IS NULL`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting tier move: %w", err)
+	}
+	if len(moves) != 1 {
+		return false, xerrors.Errorf("expected 1 tier move, got %d", len(moves))
+	}
+	mv := moves[0]
+
+	// failed moves are completed with an error, the policy will plan the sector
+	// again on a later pass if it's still in the source tier
+	var toID *string
+	var errStr *string
+	dest, err := t.move(ctx, mv)
+	if err != nil {
+		log.Warnw("storage tier move failed", "move", mv.MoveID, "sp", mv.SpID, "sector", mv.SectorNumber, "to", mv.ToTier, "error", err)
+		es := err.Error()
+		errStr = &es
+	} else {
+		id := string(dest)
+		toID = &id
+	}
+
+	_, err = t.db.Exec(ctx, `UPDATE storage_tier_moves SET to_storage_id = $2, error = $3, complete_time = current_timestamp
+		WHERE move_id = $1`, mv.MoveID, toID, errStr)
+	if err != nil {
+		return false, xerrors.Errorf("marking tier move complete: %w", err)
+	}
+
+	return true, nil
+}
+
+func (t *StorageTierMoveTask) move(ctx context.Context, mv tierMove) (storiface.ID, error) {
+	sref := storiface.SectorRef{
+		ID:        abi.SectorID{Miner: abi.ActorID(mv.SpID), Number: abi.SectorNumber(mv.SectorNumber)},
+		ProofType: abi.RegisteredSealProof(mv.RegSealProof),
+	}
+	ft := storiface.SectorFileType(mv.FileTypes)
+
+	dest, err := t.pickDest(ctx, mv.ToTier, ft, sref.ID.Miner)
+	if err != nil {
+		return "", err
+	}
+
+	if err := paths.MoveSectorInto(ctx, t.stor, t.lstor, t.index, sref, ft, dest, storiface.PathStorage); err != nil {
+		return "", err
+	}
+
+	return dest.ID, nil
+}
+
+type tierPath struct {
+	storiface.StoragePath
+	info storiface.StorageInfo
+}
+
+// tierPaths returns local store paths in the given tier
+func (t *StorageTierMoveTask) tierPaths(ctx context.Context, tier string) ([]tierPath, error) {
+	locals, err := t.lstor.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local storage paths: %w", err)
+	}
+
+	var out []tierPath
+	for _, p := range locals {
+		if !p.CanStore {
+			continue
+		}
+
+		si, err := t.index.StorageInfo(ctx, p.ID)
+		if err != nil {
+			return nil, xerrors.Errorf("getting storage info for %s: %w", p.ID, err)
+		}
+		if si.Tier == tier {
+			out = append(out, tierPath{StoragePath: p, info: si})
+		}
+	}
+
+	return out, nil
+}
+
+// pickDest selects the local path in the tier with the most available space
+// which accepts the file types and the miner
+func (t *StorageTierMoveTask) pickDest(ctx context.Context, tier string, ft storiface.SectorFileType, miner abi.ActorID) (storiface.StoragePath, error) {
+	candidates, err := t.tierPaths(ctx, tier)
+	if err != nil {
+		return storiface.StoragePath{}, err
+	}
+
+	var best storiface.StoragePath
+	var bestAvail int64 = -1
+
+	for _, p := range candidates {
+		if !ft.Allowed(p.info.AllowTypes, p.info.DenyTypes) {
+			continue
+		}
+		if ok, _, err := paths.MinerFilter(p.info.AllowMiners, p.info.DenyMiners, miner); err != nil || !ok {
+			continue
+		}
+
+		st, err := t.lstor.FsStat(ctx, p.ID)
+		if err != nil {
+			log.Warnw("stat tier path", "path", p.ID, "error", err)
+			continue
+		}
+		if st.Available > bestAvail {
+			best, bestAvail = p.StoragePath, st.Available
+		}
+	}
+
+	if bestAvail < 0 {
+		return storiface.StoragePath{}, xerrors.Errorf("no local %q tier path accepts %s for miner %d", tier, ft, miner)
+	}
+
+	return best, nil
+}
+
+func (t *StorageTierMoveTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	ctx := context.Background()
+
+	var moves []struct {
+		TaskID harmonytask.TaskID `db:"task_id"`
+		ToTier string             `db:"to_tier"`
+	}
+	err := t.db.Select(ctx, &moves, `SELECT task_id, to_tier FROM storage_tier_moves WHERE task_id = ANY($1)`, ids)
+	if err != nil {
+		return nil, xerrors.Errorf("getting tier moves: %w", err)
+	}
+
+	have := map[string]bool{}
+	for _, mv := range moves {
+		ok, seen := have[mv.ToTier]
+		if !seen {
+			ps, err := t.tierPaths(ctx, mv.ToTier)
+			if err != nil {
+				return nil, err
+			}
+			ok = len(ps) > 0
+			have[mv.ToTier] = ok
+		}
+
+		if ok {
+			id := mv.TaskID
+			return &id, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (t *StorageTierMoveTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(t.max),
+		Name: "StorageTierMove",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 128 << 20,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(MinSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return t.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (t *StorageTierMoveTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (t *StorageTierMoveTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	if time.Since(t.lastPlan) > PlanInterval {
+		t.lastPlan = time.Now()
+		if err := t.plan(ctx); err != nil {
+			log.Errorw("planning storage tier moves", "error", err)
+		}
+	}
+
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var moves []struct {
+			MoveID int64 `db:"move_id"`
+		}
+
+		err := tx.Select(&moves, `SELECT move_id FROM storage_tier_moves WHERE task_id IS NULL AND complete_time IS NULL LIMIT 20`)
+		if err != nil {
+			return false, xerrors.Errorf("getting tier moves: %w", err)
+		}
+
+		if len(moves) == 0 {
+			return false, nil
+		}
+
+		// pick at random in case there are a bunch of schedules across the cluster
+		mv := moves[rand.N(len(moves))]
+
+		_, err = tx.Exec(`UPDATE storage_tier_moves SET task_id = $1 WHERE move_id = $2 AND task_id IS NULL`, id, mv.MoveID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return true, nil
+	})
+
+	return nil
+}
+
+func (t *StorageTierMoveTask) GetSpid(db *harmonydb.DB, taskID int64) string {
+	var spID int64
+	err := db.QueryRow(context.Background(), `SELECT sp_id FROM storage_tier_moves WHERE task_id = $1`, taskID).Scan(&spID)
+	if err != nil {
+		log.Errorf("getting sp id: %s", err)
+		return ""
+	}
+	return strconv.FormatInt(spID, 10)
+}
+
+var _ = harmonytask.Reg(&StorageTierMoveTask{})
+var _ harmonytask.TaskInterface = &StorageTierMoveTask{}
@@ -0,0 +1,102 @@
+package unseal
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/paths"
+)
+
+// AccessFlushInterval is how often reads of unsealed files on this node are recorded as accesses of cached
+// unsealed copies
+var AccessFlushInterval = time.Minute
+
+// CacheSector requests an unsealed copy of the sector which is kept in the unsealed cache, and removed once it
+// is among the least recently read copies when the cache is over its budget. Sectors which are already set to be
+// kept unsealed are left as they are. Returns whether the sector was added to the cache.
+func CacheSector(ctx context.Context, db *harmonydb.DB, sid abi.SectorID) (bool, error) {
+	var added bool
+	_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+		n, err := tx.Exec(`UPDATE sectors_meta SET target_unseal_state = TRUE
+			WHERE sp_id = $1 AND sector_num = $2 AND target_unseal_state IS DISTINCT FROM TRUE`, sid.Miner, sid.Number)
+		if err != nil {
+			return false, xerrors.Errorf("setting target unseal state: %w", err)
+		}
+		if n == 0 {
+			var exists bool
+			err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM sectors_meta WHERE sp_id = $1 AND sector_num = $2)`, sid.Miner, sid.Number).Scan(&exists)
+			if err != nil {
+				return false, xerrors.Errorf("checking sector: %w", err)
+			}
+			if !exists {
+				return false, xerrors.Errorf("sector not found in sectors_meta")
+			}
+
+			// already kept unsealed, either pinned or cached
+			return false, nil
+		}
+
+		_, err = tx.Exec(`INSERT INTO unsealed_cache (sp_id, sector_num) VALUES ($1, $2)
+			ON CONFLICT (sp_id, sector_num) DO UPDATE SET last_access = current_timestamp`, sid.Miner, sid.Number)
+		if err != nil {
+			return false, xerrors.Errorf("adding sector to unsealed cache: %w", err)
+		}
+
+		added = true
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return added, err
+}
+
+// Uncache removes the sector from the unsealed cache without changing its target unseal state, for sectors
+// whose target state is set by hand
+func Uncache(ctx context.Context, db *harmonydb.DB, sid abi.SectorID) error {
+	_, err := db.Exec(ctx, `DELETE FROM unsealed_cache WHERE sp_id = $1 AND sector_num = $2`, sid.Miner, sid.Number)
+	if err != nil {
+		return xerrors.Errorf("removing sector from unsealed cache: %w", err)
+	}
+	return nil
+}
+
+// StartAccessRecorder records reads of unsealed files through the local store as accesses of cached unsealed
+// copies, in the background
+func StartAccessRecorder(ctx context.Context, db *harmonydb.DB, lstor *paths.Local) {
+	go func() {
+		for {
+			select {
+			case <-time.After(AccessFlushInterval):
+			case <-ctx.Done():
+				return
+			}
+
+			if err := recordAccesses(ctx, db, lstor.TakeUnsealedAccesses()); err != nil {
+				log.Errorw("recording unsealed cache accesses", "error", err)
+			}
+		}
+	}()
+}
+
+func recordAccesses(ctx context.Context, db *harmonydb.DB, accesses map[abi.SectorID]time.Time) error {
+	if len(accesses) == 0 {
+		return nil
+	}
+
+	spIDs := make([]int64, 0, len(accesses))
+	sectorNums := make([]int64, 0, len(accesses))
+	times := make([]time.Time, 0, len(accesses))
+	for sid, t := range accesses {
+		spIDs = append(spIDs, int64(sid.Miner))
+		sectorNums = append(sectorNums, int64(sid.Number))
+		times = append(times, t)
+	}
+
+	_, err := db.Exec(ctx, `UPDATE unsealed_cache c SET last_access = GREATEST(c.last_access, a.t)
+		FROM unnest($1::BIGINT[], $2::BIGINT[], $3::TIMESTAMPTZ[]) AS a(sp_id, sector_num, t)
+		WHERE c.sp_id = a.sp_id AND c.sector_num = a.sector_num`, spIDs, sectorNums, times)
+	return err
+}
@@ -0,0 +1,154 @@
+package unseal
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/go-units"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+)
+
+// UnsealedCacheEvictTask removes the least recently read unsealed copies in the unsealed cache while the cache is
+// over its budget
+type UnsealedCacheEvictTask struct {
+	db       *harmonydb.DB
+	budget   int64
+	interval time.Duration
+}
+
+func NewUnsealedCacheEvictTask(db *harmonydb.DB, cfg config.StorageUnsealedCacheConfig) (*UnsealedCacheEvictTask, error) {
+	var budget int64
+	if cfg.Budget != "" {
+		b, err := units.RAMInBytes(cfg.Budget)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing unsealed cache budget: %w", err)
+		}
+		budget = b
+	}
+
+	return &UnsealedCacheEvictTask{
+		db:       db,
+		budget:   budget,
+		interval: time.Duration(cfg.EvictInterval),
+	}, nil
+}
+
+func (u *UnsealedCacheEvictTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	// entries of sectors which are gone, e.g. expired and removed, or set to a target state by hand
+	_, err = u.db.Exec(ctx, `DELETE FROM unsealed_cache c WHERE NOT EXISTS (
+			SELECT 1 FROM sectors_meta m WHERE m.sp_id = c.sp_id AND m.sector_num = c.sector_num AND m.target_unseal_state = TRUE)`)
+	if err != nil {
+		return false, xerrors.Errorf("removing stale unsealed cache entries: %w", err)
+	}
+
+	if u.budget <= 0 {
+		return true, nil
+	}
+
+	// only copies which are already unsealed count against the budget, the rest are still being unsealed
+	var entries []struct {
+		SpID         int64     `db:"sp_id"`
+		SectorNum    int64     `db:"sector_num"`
+		RegSealProof int64     `db:"reg_seal_proof"`
+		LastAccess   time.Time `db:"last_access"`
+	}
+	err = u.db.Select(ctx, &entries, `SELECT c.sp_id, c.sector_num, m.reg_seal_proof, c.last_access FROM unsealed_cache c
+			INNER JOIN sectors_meta m ON m.sp_id = c.sp_id AND m.sector_num = c.sector_num
+			WHERE EXISTS (SELECT 1 FROM sector_location l WHERE l.miner_id = c.sp_id AND l.sector_num = c.sector_num AND l.sector_filetype = 1)
+			ORDER BY c.last_access DESC`) // FTUnsealed = 1
+	if err != nil {
+		return false, xerrors.Errorf("listing unsealed cache entries: %w", err)
+	}
+
+	var total int64
+	var evict []abi.SectorID
+	for _, e := range entries {
+		ssize, err := abi.RegisteredSealProof(e.RegSealProof).SectorSize()
+		if err != nil {
+			return false, xerrors.Errorf("getting sector size of %d/%d: %w", e.SpID, e.SectorNum, err)
+		}
+
+		total += int64(ssize)
+		if total > u.budget {
+			evict = append(evict, abi.SectorID{Miner: abi.ActorID(e.SpID), Number: abi.SectorNumber(e.SectorNum)})
+		}
+	}
+
+	if len(evict) == 0 {
+		return true, nil
+	}
+
+	log.Infow("evicting unsealed cache entries", "count", len(evict), "cached", units.BytesSize(float64(total)), "budget", units.BytesSize(float64(u.budget)), "task", taskID)
+
+	for _, sid := range evict {
+		if !stillOwned() {
+			return false, xerrors.Errorf("task no longer owned")
+		}
+
+		_, err := u.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+			n, err := tx.Exec(`DELETE FROM unsealed_cache WHERE sp_id = $1 AND sector_num = $2`, sid.Miner, sid.Number)
+			if err != nil {
+				return false, xerrors.Errorf("removing unsealed cache entry: %w", err)
+			}
+			if n == 0 {
+				// removed concurrently, e.g. by setting the target state by hand
+				return false, nil
+			}
+
+			_, err = tx.Exec(`UPDATE sectors_meta SET target_unseal_state = FALSE WHERE sp_id = $1 AND sector_num = $2`, sid.Miner, sid.Number)
+			if err != nil {
+				return false, xerrors.Errorf("updating target unseal state: %w", err)
+			}
+
+			// the copies are evicted by policy, so the removal marks don't need manual approval
+			_, err = tx.Exec(`INSERT INTO storage_removal_marks (sp_id, sector_num, sector_filetype, storage_id, approved, approved_at)
+				SELECT sl.miner_id, sl.sector_num, sl.sector_filetype, sl.storage_id, TRUE, current_timestamp FROM sector_location sl
+				INNER JOIN storage_path sp ON sp.storage_id = sl.storage_id
+				WHERE sl.miner_id = $1 AND sl.sector_num = $2 AND sl.sector_filetype = 1 AND NOT sp.archive
+				ON CONFLICT (sp_id, sector_num, sector_filetype, storage_id) DO UPDATE SET approved = TRUE, approved_at = current_timestamp`, sid.Miner, sid.Number)
+			if err != nil {
+				return false, xerrors.Errorf("marking unsealed copies for removal: %w", err)
+			}
+
+			return true, nil
+		}, harmonydb.OptionRetry())
+		if err != nil {
+			return false, xerrors.Errorf("evicting sector %d/%d: %w", sid.Miner, sid.Number, err)
+		}
+	}
+
+	return true, nil
+}
+
+func (u *UnsealedCacheEvictTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (u *UnsealedCacheEvictTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(1),
+		Name: "UnsealCacheEvict",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 64 << 20,
+		},
+		IAmBored: harmonytask.SingletonTaskAdder(u.interval, u),
+	}
+}
+
+func (u *UnsealedCacheEvictTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+var _ harmonytask.TaskInterface = &UnsealedCacheEvictTask{}
+var _ = harmonytask.Reg(&UnsealedCacheEvictTask{})
@@ -0,0 +1,274 @@
+package unseal
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/dealdata"
+	"github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+const (
+	RegenByManual = "manual"
+	RegenByAuto   = "auto"
+
+	RegenPresent = "present"
+	RegenFetch   = "fetch"
+	RegenUnseal  = "unseal"
+)
+
+// ScheduleRegen requests the unsealed copy of a sector to be rebuilt if it is missing. Requesting a sector which
+// already has an open request is a no-op.
+func ScheduleRegen(ctx context.Context, db *harmonydb.DB, sid abi.SectorID, requestedBy, reason string) error {
+	var isCC bool
+	err := db.QueryRow(ctx, `SELECT is_cc FROM sectors_meta WHERE sp_id = $1 AND sector_num = $2`, sid.Miner, sid.Number).Scan(&isCC)
+	if err != nil {
+		return xerrors.Errorf("getting sector: %w", err)
+	}
+	if isCC {
+		return xerrors.Errorf("sector is CC, it has no data to unseal")
+	}
+
+	_, err = db.Exec(ctx, `INSERT INTO unsealed_regens (sp_id, sector_number, requested_by, reason)
+		VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`, sid.Miner, sid.Number, requestedBy, reason)
+	if err != nil {
+		return xerrors.Errorf("scheduling unsealed copy regeneration: %w", err)
+	}
+	return nil
+}
+
+// UnsealedRegenTask rebuilds missing unsealed copies of sectors requested in unsealed_regens. When all pieces of
+// the sector are still in parked piece storage the copy is written from the piece data, which is much cheaper
+// than unsealing. Otherwise the sector is queued in the unseal pipeline, which decodes the sealed data with the
+// regenerated sector key.
+//
+// With Storage.UnsealedRegen.Auto set, sectors which should have an unsealed copy, because they are set to be
+// kept unsealed or one of their deals asked for it, but have none in the storage index are requested
+// automatically, a bounded number per scan.
+type UnsealedRegenTask struct {
+	db  *harmonydb.DB
+	sc  *ffi.SealCalls
+	max int
+
+	cfg config.StorageUnsealedRegenConfig
+
+	lk       sync.Mutex
+	lastScan time.Time
+}
+
+func NewUnsealedRegenTask(db *harmonydb.DB, sc *ffi.SealCalls, cfg config.StorageUnsealedRegenConfig, max int) *UnsealedRegenTask {
+	return &UnsealedRegenTask{
+		db:  db,
+		sc:  sc,
+		max: max,
+		cfg: cfg,
+	}
+}
+
+func (u *UnsealedRegenTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var regens []struct {
+		RegenID        int64  `db:"regen_id"`
+		SpID           int64  `db:"sp_id"`
+		SectorNumber   int64  `db:"sector_number"`
+		RegSealProof   int64  `db:"reg_seal_proof"`
+		IsCC           bool   `db:"is_cc"`
+		CurUnsealedCID string `db:"cur_unsealed_cid"`
+	}
+	err = u.db.Select(ctx, &regens, `SELECT r.regen_id, r.sp_id, r.sector_number, sm.reg_seal_proof, sm.is_cc, sm.cur_unsealed_cid
+		FROM unsealed_regens r
+		INNER JOIN sectors_meta sm ON sm.sp_id = r.sp_id AND sm.sector_num = r.sector_number
+		WHERE r.task_id = $1 AND r.complete_time IS NULL`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting regen request: %w", err)
+	}
+	if len(regens) != 1 {
+		return false, xerrors.Errorf("expected 1 regen request, got %d", len(regens))
+	}
+	rg := regens[0]
+
+	sref := storiface.SectorRef{
+		ID:        abi.SectorID{Miner: abi.ActorID(rg.SpID), Number: abi.SectorNumber(rg.SectorNumber)},
+		ProofType: abi.RegisteredSealProof(rg.RegSealProof),
+	}
+
+	var method, errStr *string
+	m, err := u.regen(ctx, taskID, sref, rg.IsCC, rg.CurUnsealedCID)
+	if err != nil {
+		log.Errorw("unsealed copy regeneration failed", "regen", rg.RegenID, "sector", sref.ID, "error", err)
+		es := err.Error()
+		errStr = &es
+	} else {
+		method = &m
+	}
+
+	_, err = u.db.Exec(ctx, `UPDATE unsealed_regens SET method = $2, error = $3, complete_time = current_timestamp
+		WHERE regen_id = $1`, rg.RegenID, method, errStr)
+	if err != nil {
+		return false, xerrors.Errorf("marking regen request complete: %w", err)
+	}
+
+	return true, nil
+}
+
+func (u *UnsealedRegenTask) regen(ctx context.Context, taskID harmonytask.TaskID, sref storiface.SectorRef, isCC bool, unsealedCID string) (string, error) {
+	if isCC {
+		return "", xerrors.Errorf("sector is CC, it has no data to unseal")
+	}
+
+	var present bool
+	err := u.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sector_location WHERE miner_id = $1 AND sector_num = $2 AND sector_filetype = 1)`,
+		sref.ID.Miner, sref.ID.Number).Scan(&present)
+	if err != nil {
+		return "", xerrors.Errorf("finding unsealed copies: %w", err)
+	}
+	if present {
+		return RegenPresent, nil
+	}
+
+	commD, err := cid.Parse(unsealedCID)
+	if err != nil {
+		return "", xerrors.Errorf("parsing unsealed cid: %w", err)
+	}
+
+	dd, err := dealdata.DealDataParked(ctx, u.db, u.sc, int64(sref.ID.Miner), int64(sref.ID.Number), sref.ProofType)
+	switch {
+	case err == nil:
+		defer dd.Close()
+
+		// pieces of snapped sectors, or with a different layout, can't rebuild this copy
+		if dd.CommD != commD {
+			log.Warnw("sector data rebuilt from parked pieces doesn't match the unsealed cid, unsealing instead", "sector", sref.ID, "commD", dd.CommD, "expected", commD)
+			break
+		}
+
+		if err := u.sc.WriteUnsealed(ctx, taskID, sref, commD, dd.Data); err != nil {
+			return "", xerrors.Errorf("writing unsealed copy from parked pieces: %w", err)
+		}
+		return RegenFetch, nil
+	case errors.Is(err, dealdata.ErrPiecesNotParked):
+	default:
+		return "", xerrors.Errorf("getting parked piece data: %w", err)
+	}
+
+	// the unseal pipeline removes its entry once the copy is decoded
+	_, err = u.db.Exec(ctx, `INSERT INTO sectors_unseal_pipeline (sp_id, sector_number, reg_seal_proof)
+		VALUES ($1, $2, $3) ON CONFLICT (sp_id, sector_number) DO NOTHING`, sref.ID.Miner, sref.ID.Number, sref.ProofType)
+	if err != nil {
+		return "", xerrors.Errorf("queueing sector for unsealing: %w", err)
+	}
+	return RegenUnseal, nil
+}
+
+func (u *UnsealedRegenTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (u *UnsealedRegenTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(u.max),
+		Name: "UnsealedRegen",
+		Cost: resources.Resources{
+			Cpu: 1,
+			Ram: 1 << 30,
+		},
+		MaxFailures: 3,
+		IAmBored: passcall.Every(MinSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			if err := u.scan(context.Background()); err != nil {
+				log.Errorw("scanning for missing unsealed copies", "error", err)
+			}
+			return u.schedule(context.Background(), taskFunc)
+		}),
+	}
+}
+
+func (u *UnsealedRegenTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+// scan requests regeneration of missing unsealed copies of sectors which should have one. Sectors in the unseal or
+// snap pipelines already get a new copy. The number of requests per scan is bounded, so that a detached storage
+// path doesn't queue unsealing of every sector it held at once.
+func (u *UnsealedRegenTask) scan(ctx context.Context) error {
+	if !u.cfg.Auto || u.cfg.MaxPerScan <= 0 {
+		return nil
+	}
+
+	u.lk.Lock()
+	if time.Since(u.lastScan) < time.Duration(u.cfg.ScanInterval) {
+		u.lk.Unlock()
+		return nil
+	}
+	u.lastScan = time.Now()
+	u.lk.Unlock()
+
+	n, err := u.db.Exec(ctx, `INSERT INTO unsealed_regens (sp_id, sector_number, requested_by, reason)
+		SELECT sm.sp_id, sm.sector_num, 'auto',
+			CASE WHEN sm.target_unseal_state THEN 'sector is set to be kept unsealed' ELSE 'a deal asked to keep an unsealed copy' END
+		FROM sectors_meta sm
+		WHERE NOT sm.is_cc AND sm.target_unseal_state IS DISTINCT FROM FALSE
+		  AND (sm.target_unseal_state OR EXISTS (
+				SELECT 1 FROM sectors_meta_pieces p WHERE p.sp_id = sm.sp_id AND p.sector_num = sm.sector_num AND p.requested_keep_data))
+		  AND NOT EXISTS (SELECT 1 FROM sector_location sl WHERE sl.miner_id = sm.sp_id AND sl.sector_num = sm.sector_num AND sl.sector_filetype = 1)
+		  AND NOT EXISTS (SELECT 1 FROM sectors_unseal_pipeline sup WHERE sup.sp_id = sm.sp_id AND sup.sector_number = sm.sector_num)
+		  AND NOT EXISTS (SELECT 1 FROM sectors_snap_pipeline snp WHERE snp.sp_id = sm.sp_id AND snp.sector_number = sm.sector_num)
+		  AND NOT EXISTS (SELECT 1 FROM unsealed_regens r WHERE r.sp_id = sm.sp_id AND r.sector_number = sm.sector_num AND r.complete_time IS NULL)
+		ORDER BY sm.sp_id, sm.sector_num
+		LIMIT $1
+		ON CONFLICT DO NOTHING`, u.cfg.MaxPerScan)
+	if err != nil {
+		return xerrors.Errorf("requesting missing unsealed copies: %w", err)
+	}
+	if n > 0 {
+		log.Infow("requested regeneration of missing unsealed copies", "sectors", n)
+	}
+	return nil
+}
+
+func (u *UnsealedRegenTask) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var regens []struct {
+			RegenID int64 `db:"regen_id"`
+		}
+
+		err := tx.Select(&regens, `SELECT regen_id FROM unsealed_regens WHERE task_id IS NULL AND complete_time IS NULL LIMIT 20`)
+		if err != nil {
+			return false, xerrors.Errorf("getting regen requests: %w", err)
+		}
+
+		if len(regens) == 0 {
+			return false, nil
+		}
+
+		// pick at random in case there are a bunch of schedules across the cluster
+		rg := regens[rand.N(len(regens))]
+
+		_, err = tx.Exec(`UPDATE unsealed_regens SET task_id = $1 WHERE regen_id = $2 AND task_id IS NULL`, id, rg.RegenID)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return true, nil
+	})
+
+	return nil
+}
+
+var _ = harmonytask.Reg(&UnsealedRegenTask{})
+var _ harmonytask.TaskInterface = &UnsealedRegenTask{}
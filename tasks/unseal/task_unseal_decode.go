@@ -66,31 +66,70 @@ func (t *TaskUnsealDecode) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 
 	sectorParams := sectorParamsArr[0]
 
+	commK, commD, commR, err := decodeComms(ctx, t.db, sectorParams.SpID, sectorParams.SectorNumber)
+	if err != nil {
+		return false, err
+	}
+
+	sref := storiface.SectorRef{
+		ID: abi.SectorID{
+			Miner:  abi.ActorID(sectorParams.SpID),
+			Number: abi.SectorNumber(sectorParams.SectorNumber),
+		},
+		ProofType: abi.RegisteredSealProof(sectorParams.RegSealProof),
+	}
+
+	isSnap := commK != commR
+	log.Infow("unseal decode", "snap", isSnap, "task", taskID, "commK", commK, "commR", commR, "commD", commD)
+	if isSnap {
+		err := t.sc.DecodeSnap(ctx, taskID, commD, commK, sref)
+		if err != nil {
+			return false, xerrors.Errorf("DecodeSnap: %w", err)
+		}
+	} else {
+		err = t.sc.DecodeSDR(ctx, taskID, sref)
+		if err != nil {
+			return false, xerrors.Errorf("DecodeSDR: %w", err)
+		}
+	}
+
+	// NOTE: Decode.. drops the sector key at the end
+
+	_, err = t.db.Exec(ctx, `UPDATE sectors_unseal_pipeline SET after_decode_sector = TRUE, task_id_decode_sector = NULL WHERE task_id_decode_sector = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("updating task: %w", err)
+	}
+
+	return true, nil
+}
+
+// decodeComms returns the commitments used to decode the sector: commK of the sector key, and commD and commR of
+// the current sector data and replica. Sectors which were snapped have commK != commR.
+func decodeComms(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (commK, commD, commR cid.Cid, err error) {
 	var sectorMeta []struct {
 		TicketValue    []byte `db:"ticket_value"`
 		OrigSealedCID  string `db:"orig_sealed_cid"`
 		CurSealedCID   string `db:"cur_sealed_cid"`
 		CurUnsealedCID string `db:"cur_unsealed_cid"`
 	}
-	err = t.db.Select(ctx, &sectorMeta, `
+	err = db.Select(ctx, &sectorMeta, `
 		SELECT ticket_value, orig_sealed_cid, cur_sealed_cid, cur_unsealed_cid
 		FROM sectors_meta
-		WHERE sp_id = $1 AND sector_num = $2`, sectorParams.SpID, sectorParams.SectorNumber)
+		WHERE sp_id = $1 AND sector_num = $2`, spID, sectorNumber)
 	if err != nil {
-		return false, xerrors.Errorf("getting sector meta: %w", err)
+		return cid.Undef, cid.Undef, cid.Undef, xerrors.Errorf("getting sector meta: %w", err)
 	}
 
 	if len(sectorMeta) != 1 {
-		return false, xerrors.Errorf("expected 1 sector meta, got %d", len(sectorMeta))
+		return cid.Undef, cid.Undef, cid.Undef, xerrors.Errorf("expected 1 sector meta, got %d", len(sectorMeta))
 	}
 
 	smeta := sectorMeta[0]
-	commK, err := cid.Decode(smeta.OrigSealedCID)
+	commK, err = cid.Decode(smeta.OrigSealedCID)
 	if err != nil {
-		return false, xerrors.Errorf("decoding OrigSealedCID: %w", err)
+		return cid.Undef, cid.Undef, cid.Undef, xerrors.Errorf("decoding OrigSealedCID: %w", err)
 	}
 
-	var commD, commR cid.Cid
 	if smeta.CurSealedCID == "" || smeta.CurSealedCID == "b" {
 		// https://github.com/filecoin-project/curio/issues/191
 		// <workaround>
@@ -98,58 +137,29 @@ func (t *TaskUnsealDecode) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 		// "unsealed" actually stores the sealed CID, "sealed" is empty
 		commR, err = cid.Decode(smeta.CurUnsealedCID)
 		if err != nil {
-			return false, xerrors.Errorf("decoding CurSealedCID: %w", err)
+			return cid.Undef, cid.Undef, cid.Undef, xerrors.Errorf("decoding CurSealedCID: %w", err)
 		}
 
-		commD, err = dealdata.UnsealedCidFromPieces(ctx, t.db, sectorParams.SpID, sectorParams.SectorNumber)
+		commD, err = dealdata.UnsealedCidFromPieces(ctx, db, spID, sectorNumber)
 		if err != nil {
-			return false, xerrors.Errorf("getting deal data CID: %w", err)
+			return cid.Undef, cid.Undef, cid.Undef, xerrors.Errorf("getting deal data CID: %w", err)
 		}
 
-		log.Warnw("workaround for issue #191", "task", taskID, "commD", commD, "commK", commK, "commR", commR)
+		log.Warnw("workaround for issue #191", "sp", spID, "sector", sectorNumber, "commD", commD, "commK", commK, "commR", commR)
 
 		// </workaround>
 	} else {
 		commD, err = cid.Decode(smeta.CurUnsealedCID)
 		if err != nil {
-			return false, xerrors.Errorf("decoding CurUnsealedCID (%s): %w", smeta.CurUnsealedCID, err)
+			return cid.Undef, cid.Undef, cid.Undef, xerrors.Errorf("decoding CurUnsealedCID (%s): %w", smeta.CurUnsealedCID, err)
 		}
 		commR, err = cid.Decode(smeta.CurSealedCID)
 		if err != nil {
-			return false, xerrors.Errorf("decoding CurSealedCID: %w", err)
-		}
-	}
-
-	sref := storiface.SectorRef{
-		ID: abi.SectorID{
-			Miner:  abi.ActorID(sectorParams.SpID),
-			Number: abi.SectorNumber(sectorParams.SectorNumber),
-		},
-		ProofType: abi.RegisteredSealProof(sectorParams.RegSealProof),
-	}
-
-	isSnap := commK != commR
-	log.Infow("unseal decode", "snap", isSnap, "task", taskID, "commK", commK, "commR", commR, "commD", commD)
-	if isSnap {
-		err := t.sc.DecodeSnap(ctx, taskID, commD, commK, sref)
-		if err != nil {
-			return false, xerrors.Errorf("DecodeSnap: %w", err)
-		}
-	} else {
-		err = t.sc.DecodeSDR(ctx, taskID, sref)
-		if err != nil {
-			return false, xerrors.Errorf("DecodeSDR: %w", err)
+			return cid.Undef, cid.Undef, cid.Undef, xerrors.Errorf("decoding CurSealedCID: %w", err)
 		}
 	}
 
-	// NOTE: Decode.. drops the sector key at the end
-
-	_, err = t.db.Exec(ctx, `UPDATE sectors_unseal_pipeline SET after_decode_sector = TRUE, task_id_decode_sector = NULL WHERE task_id_decode_sector = $1`, taskID)
-	if err != nil {
-		return false, xerrors.Errorf("updating task: %w", err)
-	}
-
-	return true, nil
+	return commK, commD, commR, nil
 }
 
 func (t *TaskUnsealDecode) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
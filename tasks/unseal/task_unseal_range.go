@@ -0,0 +1,296 @@
+package unseal
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/harmony/harmonytask"
+	"github.com/filecoin-project/curio/harmony/resources"
+	"github.com/filecoin-project/curio/harmony/taskhelp"
+	"github.com/filecoin-project/curio/lib/ffi"
+	"github.com/filecoin-project/curio/lib/passcall"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+// RangeWaitPollInterval is how often WaitRange checks whether a requested range was unsealed
+var RangeWaitPollInterval = 10 * time.Second
+
+// RequestRange requests an on-demand unseal of a range of sector data, in padded bytes. The range is decoded
+// into the unsealed file of the sector, which is then kept in the unsealed cache. Requesting a range which was
+// already unsealed requests it again, e.g. after its copy was evicted from the cache.
+func RequestRange(ctx context.Context, db *harmonydb.DB, sid abi.SectorID, offset storiface.PaddedByteIndex, size abi.PaddedPieceSize) error {
+	n, err := db.Exec(ctx, `INSERT INTO sectors_unseal_ranges (sp_id, sector_number, reg_seal_proof, piece_offset, piece_size)
+		SELECT sp_id, sector_num, reg_seal_proof, $3, $4 FROM sectors_meta WHERE sp_id = $1 AND sector_num = $2
+		ON CONFLICT (sp_id, sector_number, piece_offset, piece_size) DO UPDATE SET after_unseal = FALSE, unsealed_at = NULL, create_time = current_timestamp
+			WHERE sectors_unseal_ranges.after_unseal`, sid.Miner, sid.Number, offset, size)
+	if err != nil {
+		return xerrors.Errorf("requesting unseal of range: %w", err)
+	}
+	if n == 0 {
+		var exists bool
+		err := db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sectors_meta WHERE sp_id = $1 AND sector_num = $2)`, sid.Miner, sid.Number).Scan(&exists)
+		if err != nil {
+			return xerrors.Errorf("checking sector: %w", err)
+		}
+		if !exists {
+			return xerrors.Errorf("sector not found in sectors_meta")
+		}
+
+		// already requested and still pending
+	}
+	return nil
+}
+
+// WaitRange waits until a range requested with RequestRange is unsealed.
+func WaitRange(ctx context.Context, db *harmonydb.DB, sid abi.SectorID, offset storiface.PaddedByteIndex, size abi.PaddedPieceSize) error {
+	for {
+		var done []bool
+		err := db.Select(ctx, &done, `SELECT after_unseal FROM sectors_unseal_ranges
+			WHERE sp_id = $1 AND sector_number = $2 AND piece_offset = $3 AND piece_size = $4`, sid.Miner, sid.Number, offset, size)
+		if err != nil {
+			return xerrors.Errorf("getting unseal state of range: %w", err)
+		}
+		if len(done) == 0 {
+			return xerrors.Errorf("unseal of range %d+%d of sector %d/%d was not requested", offset, size, sid.Miner, sid.Number)
+		}
+		if done[0] {
+			return nil
+		}
+
+		select {
+		case <-time.After(RangeWaitPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TaskUnsealRange unseals requested ranges of a sector: the sector key is regenerated and the ranges which aren't
+// unsealed yet are decoded into the unsealed file of the sector, which is then added to the unsealed cache
+type TaskUnsealRange struct {
+	max int
+
+	sc *ffi.SealCalls
+	db *harmonydb.DB
+}
+
+func NewTaskUnsealRange(sc *ffi.SealCalls, db *harmonydb.DB, max int) *TaskUnsealRange {
+	return &TaskUnsealRange{
+		max: max,
+		sc:  sc,
+		db:  db,
+	}
+}
+
+func (t *TaskUnsealRange) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var ranges []struct {
+		SpID         int64 `db:"sp_id"`
+		SectorNumber int64 `db:"sector_number"`
+		RegSealProof int64 `db:"reg_seal_proof"`
+		PieceOffset  int64 `db:"piece_offset"`
+		PieceSize    int64 `db:"piece_size"`
+	}
+
+	err = t.db.Select(ctx, &ranges, `
+		SELECT sp_id, sector_number, reg_seal_proof, piece_offset, piece_size
+		FROM sectors_unseal_ranges
+		WHERE task_id = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("getting unseal ranges: %w", err)
+	}
+
+	if len(ranges) == 0 {
+		return false, xerrors.Errorf("no unseal ranges")
+	}
+
+	sref := storiface.SectorRef{
+		ID: abi.SectorID{
+			Miner:  abi.ActorID(ranges[0].SpID),
+			Number: abi.SectorNumber(ranges[0].SectorNumber),
+		},
+		ProofType: abi.RegisteredSealProof(ranges[0].RegSealProof),
+	}
+
+	// ranges may have been unsealed since they were requested, e.g. by the unseal pipeline
+	var missing []ffi.UnsealRange
+	for _, r := range ranges {
+		ur := ffi.UnsealRange{Offset: storiface.PaddedByteIndex(r.PieceOffset), Size: abi.PaddedPieceSize(r.PieceSize)}
+
+		has, err := t.sc.IsUnsealed(ctx, sref, ur)
+		if err != nil {
+			return false, xerrors.Errorf("checking if range %d+%d is unsealed: %w", ur.Offset, ur.Size, err)
+		}
+		if !has {
+			missing = append(missing, ur)
+		}
+	}
+
+	if len(missing) > 0 {
+		var ticket []byte
+		var origUnsealedCID string
+		err = t.db.QueryRow(ctx, `SELECT ticket_value, orig_unsealed_cid FROM sectors_meta WHERE sp_id = $1 AND sector_num = $2`,
+			sref.ID.Miner, sref.ID.Number).Scan(&ticket, &origUnsealedCID)
+		if err != nil {
+			return false, xerrors.Errorf("getting sector meta: %w", err)
+		}
+
+		// NOTE: Even for snap sectors for SDR we need the original unsealed CID
+		origCommD, err := cid.Decode(origUnsealedCID)
+		if err != nil {
+			return false, xerrors.Errorf("decoding orig commd: %w", err)
+		}
+
+		if len(ticket) != abi.RandomnessLength {
+			return false, xerrors.Errorf("invalid ticket value length %d", len(ticket))
+		}
+
+		commK, commD, commR, err := decodeComms(ctx, t.db, int64(sref.ID.Miner), int64(sref.ID.Number))
+		if err != nil {
+			return false, err
+		}
+
+		isSnap := commK != commR
+		log.Infow("unseal ranges", "sector", sref.ID, "ranges", len(missing), "snap", isSnap, "task", taskID)
+
+		if err := t.sc.GenerateSDR(ctx, taskID, storiface.FTKey, sref, ticket, origCommD); err != nil {
+			return false, xerrors.Errorf("generate sdr: %w", err)
+		}
+
+		// NOTE: DecodeRanges drops the sector key at the end
+		if err := t.sc.DecodeRanges(ctx, taskID, sref, isSnap, commD, commK, missing); err != nil {
+			return false, xerrors.Errorf("decoding ranges: %w", err)
+		}
+
+		// keep the unsealed copy per the unsealed cache policy, unless it is already set to be kept
+		if _, err := CacheSector(ctx, t.db, sref.ID); err != nil {
+			return false, xerrors.Errorf("adding sector to unsealed cache: %w", err)
+		}
+	}
+
+	_, err = t.db.Exec(ctx, `UPDATE sectors_unseal_ranges SET after_unseal = TRUE, unsealed_at = current_timestamp, task_id = NULL WHERE task_id = $1`, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("updating task: %w", err)
+	}
+
+	return true, nil
+}
+
+func (t *TaskUnsealRange) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (t *TaskUnsealRange) TypeDetails() harmonytask.TaskTypeDetails {
+	ssize := abi.SectorSize(32 << 30) // todo task details needs taskID to get correct sector size
+	if isDevnet {
+		ssize = abi.SectorSize(2 << 20)
+	}
+
+	res := harmonytask.TaskTypeDetails{
+		Max:  taskhelp.Max(t.max),
+		Name: "UnsealRange",
+		Cost: resources.Resources{
+			Cpu:     4, // todo multicore sdr
+			Gpu:     0,
+			Ram:     54 << 30,
+			Storage: t.sc.Storage(t.taskToSector, storiface.FTKey, storiface.FTNone, ssize, storiface.PathSealing, paths.MinFreeStoragePercentage),
+		},
+		MaxFailures: 2,
+		IAmBored: passcall.Every(MinSchedInterval, func(taskFunc harmonytask.AddTaskFunc) error {
+			return t.schedule(context.Background(), taskFunc)
+		}),
+	}
+
+	if isDevnet {
+		res.Cost.Ram = 1 << 30
+	}
+
+	return res
+}
+
+func (t *TaskUnsealRange) schedule(ctx context.Context, taskFunc harmonytask.AddTaskFunc) error {
+	// schedule at most one sector when we're bored, with all of its pending ranges
+
+	taskFunc(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		var sectors []struct {
+			SpID         int64 `db:"sp_id"`
+			SectorNumber int64 `db:"sector_number"`
+		}
+
+		err := tx.Select(&sectors, `SELECT DISTINCT sp_id, sector_number FROM sectors_unseal_ranges r
+			WHERE after_unseal = FALSE AND task_id IS NULL
+			  AND NOT EXISTS (SELECT 1 FROM sectors_unseal_ranges o
+			                  WHERE o.sp_id = r.sp_id AND o.sector_number = r.sector_number AND o.task_id IS NOT NULL)`)
+		if err != nil {
+			return false, xerrors.Errorf("getting sectors: %w", err)
+		}
+
+		if len(sectors) == 0 {
+			return false, nil
+		}
+
+		// pick at random in case there are a bunch of schedules across the cluster
+		s := sectors[rand.N(len(sectors))]
+
+		_, err = tx.Exec(`UPDATE sectors_unseal_ranges SET task_id = $1 WHERE sp_id = $2 AND sector_number = $3 AND after_unseal = FALSE AND task_id IS NULL`, id, s.SpID, s.SectorNumber)
+		if err != nil {
+			return false, xerrors.Errorf("updating task id: %w", err)
+		}
+
+		return true, nil
+	})
+
+	return nil
+}
+
+func (t *TaskUnsealRange) Adder(taskFunc harmonytask.AddTaskFunc) {
+}
+
+func (t *TaskUnsealRange) GetSpid(db *harmonydb.DB, taskID int64) string {
+	sid, err := t.GetSectorID(db, taskID)
+	if err != nil {
+		log.Errorf("getting sector id: %s", err)
+		return ""
+	}
+	return sid.Miner.String()
+}
+
+func (t *TaskUnsealRange) GetSectorID(db *harmonydb.DB, taskID int64) (*abi.SectorID, error) {
+	var spId, sectorNumber uint64
+	err := db.QueryRow(context.Background(), `SELECT sp_id, sector_number FROM sectors_unseal_ranges WHERE task_id = $1 LIMIT 1`, taskID).Scan(&spId, &sectorNumber)
+	if err != nil {
+		return nil, err
+	}
+	return &abi.SectorID{
+		Miner:  abi.ActorID(spId),
+		Number: abi.SectorNumber(sectorNumber),
+	}, nil
+}
+
+func (t *TaskUnsealRange) taskToSector(id harmonytask.TaskID) (ffi.SectorRef, error) {
+	var refs []ffi.SectorRef
+
+	err := t.db.Select(context.Background(), &refs, `SELECT DISTINCT sp_id, sector_number, reg_seal_proof FROM sectors_unseal_ranges WHERE task_id = $1`, id)
+	if err != nil {
+		return ffi.SectorRef{}, xerrors.Errorf("getting sector ref: %w", err)
+	}
+
+	if len(refs) != 1 {
+		return ffi.SectorRef{}, xerrors.Errorf("expected 1 sector ref, got %d", len(refs))
+	}
+
+	return refs[0], nil
+}
+
+var _ = harmonytask.Reg(&TaskUnsealRange{})
+var _ harmonytask.TaskInterface = &TaskUnsealRange{}
@@ -116,6 +116,9 @@ func NewWdPostTask(db *harmonydb.DB,
 		if err := pcs.AddHandler(t.processHeadChange); err != nil {
 			return nil, err
 		}
+		if err := pcs.AddRevertHandler(t.processRevert); err != nil {
+			return nil, err
+		}
 	}
 
 	return t, nil
@@ -443,6 +446,61 @@ func (t *WdPostTask) processHeadChange(ctx context.Context, revert, apply *types
 	return nil
 }
 
+// processRevert drops computed proofs which weren't picked up for submission yet, when the challenge epoch of their
+// deadline was reorged out. Those proofs were generated over a challenge tipset which is no longer on chain; removing
+// the partition task identity lets processHeadChange schedule the computation again.
+func (t *WdPostTask) processRevert(ctx context.Context, reverted abi.ChainEpoch, apply *types.TipSet) error {
+	var proofs []struct {
+		SpID               int64  `db:"sp_id"`
+		ProvingPeriodStart int64  `db:"proving_period_start"`
+		Deadline           uint64 `db:"deadline"`
+		Partition          uint64 `db:"partition"`
+	}
+	err := t.db.Select(ctx, &proofs, `SELECT sp_id, proving_period_start, deadline, partition
+		FROM wdpost_proofs WHERE submit_task_id IS NULL AND message_cid IS NULL`)
+	if err != nil {
+		return xerrors.Errorf("getting unsubmitted proofs: %w", err)
+	}
+
+	for _, p := range proofs {
+		di := NewDeadlineInfo(abi.ChainEpoch(p.ProvingPeriodStart), p.Deadline, apply.Height())
+		if di.Challenge < reverted {
+			continue
+		}
+
+		log.Warnw("dropping windowPoSt proof after its challenge epoch was reorged out",
+			"sp", p.SpID, "deadline", p.Deadline, "partition", p.Partition, "challenge", di.Challenge, "reverted", reverted)
+
+		_, err := t.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+			n, err := tx.Exec(`DELETE FROM wdpost_proofs
+				WHERE sp_id = $1 AND proving_period_start = $2 AND deadline = $3 AND partition = $4
+				  AND submit_task_id IS NULL AND message_cid IS NULL`,
+				p.SpID, p.ProvingPeriodStart, p.Deadline, p.Partition)
+			if err != nil {
+				return false, xerrors.Errorf("deleting proof: %w", err)
+			}
+			if n == 0 {
+				// picked up for submission in the meantime
+				return false, nil
+			}
+
+			_, err = tx.Exec(`DELETE FROM wdpost_partition_tasks
+				WHERE sp_id = $1 AND proving_period_start = $2 AND deadline_index = $3 AND partition_index = $4
+				  AND task_id NOT IN (SELECT id FROM harmony_task)`,
+				p.SpID, p.ProvingPeriodStart, p.Deadline, p.Partition)
+			if err != nil {
+				return false, xerrors.Errorf("deleting partition task: %w", err)
+			}
+			return true, nil
+		}, harmonydb.OptionRetry())
+		if err != nil {
+			return xerrors.Errorf("invalidating proof for sp %d deadline %d partition %d: %w", p.SpID, p.Deadline, p.Partition, err)
+		}
+	}
+
+	return nil
+}
+
 func (t *WdPostTask) addTaskToDB(taskId harmonytask.TaskID, taskIdent wdTaskIdentity, tx *harmonydb.Tx) (bool, error) {
 
 	_, err := tx.Exec(
@@ -0,0 +1,250 @@
+// Package apiauth implements scoped API tokens for the web API.
+package apiauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc/auth"
+
+	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+var log = logging.Logger("curio/web/apiauth")
+
+const (
+	// ScopeRead allows reading all cluster state. Every token has it.
+	ScopeRead auth.Permission = "read"
+	// ScopeTasksWrite allows operations on tasks, pipelines, sectors and machines.
+	ScopeTasksWrite auth.Permission = "tasks:write"
+	// ScopeConfigWrite allows changing configuration layers.
+	ScopeConfigWrite auth.Permission = "config:write"
+	// ScopeAlertsWrite allows acknowledging and silencing alerts.
+	ScopeAlertsWrite auth.Permission = "alerts:write"
+	// ScopeAdmin allows everything, including token management.
+	ScopeAdmin auth.Permission = "admin"
+)
+
+var AllScopes = []auth.Permission{ScopeRead, ScopeTasksWrite, ScopeConfigWrite, ScopeAlertsWrite, ScopeAdmin}
+
+// TokenCookie can carry the token for browsers, which can't set headers on websocket connections.
+const TokenCookie = "curio_token"
+
+type Auth struct {
+	db *harmonydb.DB
+
+	// requireToken makes requests without a token fail. Otherwise
+	// requests without a token get all scopes.
+	requireToken bool
+
+	limits *rateLimits
+}
+
+func New(db *harmonydb.DB, cfg config.CurioWebConfig) *Auth {
+	return &Auth{
+		db:           db,
+		requireToken: cfg.RequireTokenAuth,
+		limits:       newRateLimits(cfg.RateLimit, cfg.RateLimitBurst),
+	}
+}
+
+// Middleware verifies the request token and attaches the token scopes and caller identity to the
+// request context. Each request counts against the caller's rate limit.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		c := &caller{auth: a, remote: r.RemoteAddr}
+
+		token := tokenFromRequest(r)
+		switch {
+		case token != "":
+			id, name, scopes, err := a.verify(ctx, token)
+			if err != nil {
+				log.Warnw("web api token verification failed", "remote", r.RemoteAddr, "error", err)
+				writeErr(w, http.StatusUnauthorized, "invalid token")
+				return
+			}
+			c.tokenID, c.tokenName = &id, name
+			ctx = auth.WithPerm(ctx, scopes)
+		case a.requireToken:
+			writeErr(w, http.StatusUnauthorized, "missing token")
+			return
+		default:
+			ctx = auth.WithPerm(ctx, AllScopes)
+		}
+
+		c.limiter = a.limits.get(c.limitKey())
+		if !c.allow() {
+			writeErr(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		ctx = context.WithValue(ctx, callerKey{}, c)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tokenFromRequest returns the token of a request, from a bearer Authorization header, the token query parameter or
+// the token cookie. Authorization headers of other schemes carry no token.
+func tokenFromRequest(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if t, ok := strings.CutPrefix(h, "Bearer "); ok {
+			return t
+		}
+		return ""
+	}
+	if t := r.URL.Query().Get("token"); t != "" {
+		return t
+	}
+	if c, err := r.Cookie(TokenCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func (a *Auth) verify(ctx context.Context, token string) (int64, string, []auth.Permission, error) {
+	hash := hashToken(token)
+
+	var rows []struct {
+		ID        int64      `db:"id"`
+		Name      string     `db:"name"`
+		Scopes    []string   `db:"scopes"`
+		ExpiresAt *time.Time `db:"expires_at"`
+		Revoked   bool       `db:"revoked"`
+	}
+	err := a.db.Select(ctx, &rows, `SELECT id, name, scopes, expires_at, revoked FROM web_api_tokens WHERE token_hash = $1`, hash)
+	if err != nil {
+		return 0, "", nil, xerrors.Errorf("looking up token: %w", err)
+	}
+	if len(rows) == 0 {
+		return 0, "", nil, xerrors.Errorf("unknown token")
+	}
+	t := rows[0]
+	if t.Revoked {
+		return 0, "", nil, xerrors.Errorf("token %d was revoked", t.ID)
+	}
+	if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
+		return 0, "", nil, xerrors.Errorf("token %d expired at %s", t.ID, t.ExpiresAt)
+	}
+
+	if _, err := a.db.Exec(ctx, `UPDATE web_api_tokens SET last_used = CURRENT_TIMESTAMP WHERE id = $1`, t.ID); err != nil {
+		log.Warnw("updating token last_used", "id", t.ID, "error", err)
+	}
+
+	return t.ID, t.Name, expandScopes(lo.Map(t.Scopes, func(s string, _ int) auth.Permission { return auth.Permission(s) })), nil
+}
+
+// expandScopes adds implied scopes: admin implies everything and every token can read.
+func expandScopes(scopes []auth.Permission) []auth.Permission {
+	if lo.Contains(scopes, ScopeAdmin) {
+		return AllScopes
+	}
+	if !lo.Contains(scopes, ScopeRead) {
+		scopes = append(scopes, ScopeRead)
+	}
+	return scopes
+}
+
+// HasScope returns whether the request context carries the given scope.
+func HasScope(ctx context.Context, scope auth.Permission) bool {
+	return auth.HasPerm(ctx, nil, scope)
+}
+
+// RequireScope returns an error if the request context doesn't carry the given scope.
+//
+// Web RPC calls requiring more than the read scope are recorded in the audit log (see RPCTracer)
+// and count against the caller's rate limit.
+func RequireScope(ctx context.Context, scope auth.Permission) error {
+	if scope != ScopeRead {
+		if c := callerFrom(ctx); c != nil {
+			markAudited(ctx)
+			if !c.allow() {
+				return xerrors.Errorf("rate limit exceeded")
+			}
+		}
+	}
+
+	if !HasScope(ctx, scope) {
+		return xerrors.Errorf("missing required scope '%s'", scope)
+	}
+	return nil
+}
+
+// Require wraps an http handler, rejecting requests without the given scope. Requests to handlers
+// requiring more than the read scope are recorded in the audit log.
+func Require(scope auth.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if scope != ScopeRead {
+			if c := callerFrom(r.Context()); c != nil {
+				auditHTTP(c, w, r, func(w http.ResponseWriter, r *http.Request) {
+					requireScope(scope, next, w, r)
+				})
+				return
+			}
+		}
+
+		requireScope(scope, next, w, r)
+	}
+}
+
+func requireScope(scope auth.Permission, next http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	if !HasScope(r.Context(), scope) {
+		writeErr(w, http.StatusForbidden, "missing required scope '"+string(scope)+"'")
+		return
+	}
+	next(w, r)
+}
+
+func writeErr(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct{ Error string }{msg})
+}
+
+func hashToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return h[:]
+}
+
+// CreateToken issues a new token with the given scopes. Zero ttl means the token never expires.
+// The token is only returned here, the database holds just its hash.
+func CreateToken(ctx context.Context, db *harmonydb.DB, name string, scopes []auth.Permission, ttl time.Duration) (int64, string, error) {
+	for _, s := range scopes {
+		if !lo.Contains(AllScopes, s) {
+			return 0, "", xerrors.Errorf("unknown scope '%s'", s)
+		}
+	}
+	if len(scopes) == 0 {
+		return 0, "", xerrors.Errorf("at least one scope is required")
+	}
+
+	var buf [32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return 0, "", xerrors.Errorf("generating token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf[:])
+
+	var expires *time.Time
+	if ttl > 0 {
+		expires = lo.ToPtr(time.Now().Add(ttl))
+	}
+
+	var id int64
+	err := db.QueryRow(ctx, `INSERT INTO web_api_tokens (name, token_hash, scopes, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`,
+		name, hashToken(token), lo.Map(scopes, func(s auth.Permission, _ int) string { return string(s) }), expires).Scan(&id)
+	if err != nil {
+		return 0, "", xerrors.Errorf("storing token: %w", err)
+	}
+
+	return id, token, nil
+}
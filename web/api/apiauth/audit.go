@@ -0,0 +1,274 @@
+package apiauth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opencensus.io/tag"
+	"golang.org/x/time/rate"
+	"golang.org/x/xerrors"
+
+	rpcmetrics "github.com/filecoin-project/go-jsonrpc/metrics"
+)
+
+// caller identifies who made a request. Middleware attaches it to the request context.
+type caller struct {
+	auth *Auth
+
+	tokenID   *int64 // nil for requests without a token
+	tokenName string
+	remote    string
+
+	limiter *rate.Limiter // nil when rate limiting is disabled
+}
+
+type callerKey struct{}
+
+func callerFrom(ctx context.Context) *caller {
+	c, _ := ctx.Value(callerKey{}).(*caller)
+	return c
+}
+
+// CallerToken returns the name of the token a request was made with, empty for requests without a token.
+func CallerToken(ctx context.Context) string {
+	c := callerFrom(ctx)
+	if c == nil || c.tokenID == nil {
+		return ""
+	}
+	return c.tokenName
+}
+
+// limitKey is the key the caller's rate limit is tracked under. Requests with a token are limited per
+// token, requests without one per remote host.
+func (c *caller) limitKey() string {
+	if c.tokenID != nil {
+		return "token:" + strconv.FormatInt(*c.tokenID, 10)
+	}
+	host, _, err := net.SplitHostPort(c.remote)
+	if err != nil {
+		host = c.remote
+	}
+	return "addr:" + host
+}
+
+func (c *caller) allow() bool {
+	return c.limiter == nil || c.limiter.Allow()
+}
+
+// limiterIdle is how long the limiter of a caller is kept after its last request
+const limiterIdle = 10 * time.Minute
+
+type callerLimiter struct {
+	lim      *rate.Limiter
+	lastUsed time.Time
+}
+
+type rateLimits struct {
+	limit rate.Limit
+	burst int
+
+	lk       sync.Mutex
+	limiters map[string]*callerLimiter
+}
+
+// newRateLimits returns nil when perSecond is zero, which disables rate limiting.
+func newRateLimits(perSecond float64, burst int) *rateLimits {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(perSecond))
+	}
+	return &rateLimits{
+		limit:    rate.Limit(perSecond),
+		burst:    burst,
+		limiters: map[string]*callerLimiter{},
+	}
+}
+
+func (l *rateLimits) get(key string) *rate.Limiter {
+	if l == nil {
+		return nil
+	}
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	// limiters of callers which stopped making requests are dropped, a limiter
+	// idle for longer than it takes to refill is the same as a new one
+	now := time.Now()
+	for k, cl := range l.limiters {
+		if now.Sub(cl.lastUsed) > limiterIdle && cl.lim.TokensAt(now) >= float64(l.burst) {
+			delete(l.limiters, k)
+		}
+	}
+
+	cl, ok := l.limiters[key]
+	if !ok {
+		cl = &callerLimiter{lim: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = cl
+	}
+	cl.lastUsed = now
+	return cl.lim
+}
+
+// audited holds contexts of in-flight web RPC calls which passed through RequireScope with a scope
+// beyond read. The RPC server creates a context for each call, so the tracer can tell which calls
+// to record. Notifications (calls without an ID) don't reach the tracer, they are recorded without
+// their params and result when their context ends.
+var audited sync.Map
+
+func markAudited(ctx context.Context) {
+	if _, loaded := audited.LoadOrStore(ctx, struct{}{}); loaded {
+		return
+	}
+
+	// the RPC server cancels the context of a call once it's handled, the entry
+	// is gone by then unless the tracer didn't see the call
+	context.AfterFunc(ctx, func() {
+		if _, ok := audited.LoadAndDelete(ctx); !ok {
+			return
+		}
+		c := callerFrom(ctx)
+		if c == nil {
+			return
+		}
+
+		method := "unknown"
+		if m, ok := tag.FromContext(ctx).Value(rpcmetrics.RPCMethod); ok {
+			method = m
+		}
+		c.record("rpc notification "+method, nil, nil)
+	})
+}
+
+// RPCTracer records web RPC calls marked by RequireScope in the audit log. It's meant to be passed
+// to jsonrpc.WithTracer.
+func RPCTracer(method string, params []reflect.Value, results []reflect.Value, err error) {
+	// params[0] is the receiver, params[1] the context for methods taking one
+	if len(params) < 2 {
+		return
+	}
+	ctx, ok := params[1].Interface().(context.Context)
+	if !ok {
+		return
+	}
+	if _, ok := audited.LoadAndDelete(ctx); !ok {
+		return
+	}
+	c := callerFrom(ctx)
+	if c == nil {
+		return
+	}
+
+	args := make([]interface{}, 0, len(params)-2)
+	for _, p := range params[2:] {
+		args = append(args, p.Interface())
+	}
+	payload, merr := json.Marshal(args)
+	if merr != nil {
+		payload = []byte(fmt.Sprint(args...))
+	}
+
+	if err == nil && len(results) > 0 {
+		last := results[len(results)-1]
+		if last.Kind() == reflect.Interface && !last.IsNil() {
+			err, _ = last.Interface().(error)
+		}
+	}
+
+	c.record("rpc "+method, payload, err)
+}
+
+// auditHTTP runs the handler and records the request in the audit log, including requests which
+// end in a panic (as apihelper.OrHTTPFail does).
+func auditHTTP(c *caller, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "reading request body")
+		return
+	}
+	_ = r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	endpoint := r.Method + " " + r.URL.Path
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			c.record(endpoint, body, xerrors.Errorf("handler failed: %v", rec))
+			panic(rec)
+		}
+
+		var herr error
+		if sw.status >= http.StatusBadRequest {
+			herr = xerrors.Errorf("HTTP %d", sw.status)
+		}
+		c.record(endpoint, body, herr)
+	}()
+
+	next(sw, r)
+}
+
+func (c *caller) record(endpoint string, payload []byte, err error) {
+	hash := sha256.Sum256(payload)
+
+	status := "ok"
+	var errStr *string
+	if err != nil {
+		status = "error"
+		es := err.Error()
+		errStr = &es
+	}
+
+	_, derr := c.auth.db.Exec(context.Background(), `INSERT INTO web_api_audit (token_id, token_name, remote, endpoint, payload_hash, status, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, c.tokenID, c.tokenName, c.remote, endpoint, hash[:], status, errStr)
+	if derr != nil {
+		log.Errorw("recording web api audit entry", "endpoint", endpoint, "token", c.tokenName, "error", derr)
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, xerrors.Errorf("response writer doesn't support hijacking")
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,191 @@
+// Package charts serves pre-aggregated time series for the web UI charts.
+package charts
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/deps"
+	"github.com/filecoin-project/curio/web/api/apihelper"
+)
+
+// postTasks are the task types included in the PoSt duration series
+var postTasks = []string{"WdPost", "WinPost"}
+
+type cfg struct {
+	*deps.Deps
+}
+
+// Routes registers the chart series endpoints. All of them accept optional
+// 'from' and 'to' query parameters (RFC3339), defaulting to the last 7 days.
+func Routes(r *mux.Router, deps *deps.Deps) {
+	c := &cfg{deps}
+	r.Methods("GET").Path("/tasks").HandlerFunc(c.taskSeries)
+	r.Methods("GET").Path("/post").HandlerFunc(c.postSeries)
+	r.Methods("GET").Path("/sealed").HandlerFunc(c.sealedSeries)
+	r.Methods("GET").Path("/gas").HandlerFunc(c.gasSeries)
+	r.Methods("GET").Path("/transfers").HandlerFunc(c.transferSeries)
+	r.Methods("GET").Path("/transfers/deals").HandlerFunc(c.transferDealSeries)
+}
+
+func timeRange(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now()
+	from := to.Add(-7 * 24 * time.Hour)
+
+	if s := r.URL.Query().Get("from"); s != "" {
+		var err error
+		from, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, xerrors.Errorf("parsing from: %w", err)
+		}
+	}
+	if s := r.URL.Query().Get("to"); s != "" {
+		var err error
+		to, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, xerrors.Errorf("parsing to: %w", err)
+		}
+	}
+
+	return from, to, nil
+}
+
+type taskPoint struct {
+	Bucket      time.Time `db:"bucket"`
+	Task        string    `db:"task_name"`
+	Completed   int64     `db:"completed"`
+	Failed      int64     `db:"failed"`
+	AvgDuration float64   `db:"avg_duration_s"`
+	MaxDuration float64   `db:"max_duration_s"`
+}
+
+// taskSeries returns hourly completed/failed task counts. The optional 'task' parameter selects a single task type.
+func (c *cfg) taskSeries(w http.ResponseWriter, r *http.Request) {
+	from, to, err := timeRange(r)
+	apihelper.OrHTTPFail(w, err)
+
+	var points []taskPoint
+	err = c.DB.Select(r.Context(), &points, `SELECT bucket, task_name, completed, failed, avg_duration_s, max_duration_s
+		FROM chart_task_hourly
+		WHERE bucket >= $1 AND bucket <= $2 AND ($3 = '' OR task_name = $3)
+		ORDER BY bucket, task_name`, from, to, r.URL.Query().Get("task"))
+	apihelper.OrHTTPFail(w, err)
+
+	apihelper.OrHTTPFail(w, json.NewEncoder(w).Encode(points))
+}
+
+// postSeries returns hourly PoSt task durations.
+func (c *cfg) postSeries(w http.ResponseWriter, r *http.Request) {
+	from, to, err := timeRange(r)
+	apihelper.OrHTTPFail(w, err)
+
+	var points []taskPoint
+	err = c.DB.Select(r.Context(), &points, `SELECT bucket, task_name, completed, failed, avg_duration_s, max_duration_s
+		FROM chart_task_hourly
+		WHERE bucket >= $1 AND bucket <= $2 AND task_name = ANY($3)
+		ORDER BY bucket, task_name`, from, to, postTasks)
+	apihelper.OrHTTPFail(w, err)
+
+	apihelper.OrHTTPFail(w, json.NewEncoder(w).Encode(points))
+}
+
+type sealedPoint struct {
+	Bucket  time.Time `db:"bucket"`
+	SpID    int64     `db:"sp_id"`
+	Sectors int64     `db:"sectors"`
+	Bytes   int64     `db:"bytes"`
+}
+
+// sealedSeries returns daily sealed sector counts and raw bytes per miner.
+func (c *cfg) sealedSeries(w http.ResponseWriter, r *http.Request) {
+	from, to, err := timeRange(r)
+	apihelper.OrHTTPFail(w, err)
+
+	var points []sealedPoint
+	err = c.DB.Select(r.Context(), &points, `SELECT bucket, sp_id, sectors, bytes FROM chart_sealed_daily
+		WHERE bucket >= $1 AND bucket <= $2
+		ORDER BY bucket, sp_id`, from, to)
+	apihelper.OrHTTPFail(w, err)
+
+	apihelper.OrHTTPFail(w, json.NewEncoder(w).Encode(points))
+}
+
+type gasPoint struct {
+	Bucket   time.Time `db:"bucket"`
+	Reason   string    `db:"send_reason"`
+	Messages int64     `db:"messages"`
+	GasUsed  int64     `db:"gas_used"`
+	GasCost  string    `db:"gas_cost"` // attoFIL
+}
+
+// gasSeries returns daily message counts, gas used and gas cost by send reason, by the day messages landed on chain.
+func (c *cfg) gasSeries(w http.ResponseWriter, r *http.Request) {
+	from, to, err := timeRange(r)
+	apihelper.OrHTTPFail(w, err)
+
+	var points []gasPoint
+	err = c.DB.Select(r.Context(), &points, `SELECT bucket, send_reason, messages, gas_used, gas_cost::TEXT AS gas_cost FROM chart_gas_daily
+		WHERE bucket >= $1 AND bucket <= $2
+		ORDER BY bucket, send_reason`, from, to)
+	apihelper.OrHTTPFail(w, err)
+
+	apihelper.OrHTTPFail(w, json.NewEncoder(w).Encode(points))
+}
+
+type transferPoint struct {
+	Bucket    time.Time `db:"bucket"`
+	Client    string    `db:"client"`
+	SpID      int64     `db:"sp_id"`
+	Machine   string    `db:"machine"`
+	Transfers int64     `db:"transfers"`
+	Failed    int64     `db:"failed"`
+	Bytes     int64     `db:"bytes"`
+	Seconds   float64   `db:"transfer_s"`
+}
+
+// transferSeries returns hourly inbound piece data transfers per client, SP and machine. Transfers which couldn't be
+// attributed to a deal have an empty client and SP 0. The optional 'client' and 'machine' parameters select a single
+// client or machine.
+func (c *cfg) transferSeries(w http.ResponseWriter, r *http.Request) {
+	from, to, err := timeRange(r)
+	apihelper.OrHTTPFail(w, err)
+
+	var points []transferPoint
+	err = c.DB.Select(r.Context(), &points, `SELECT bucket, client, sp_id, machine, transfers, failed, bytes, transfer_s
+		FROM chart_transfer_hourly
+		WHERE bucket >= $1 AND bucket <= $2 AND ($3 = '' OR client = $3) AND ($4 = '' OR machine = $4)
+		ORDER BY bucket, client, sp_id, machine`, from, to, r.URL.Query().Get("client"), r.URL.Query().Get("machine"))
+	apihelper.OrHTTPFail(w, err)
+
+	apihelper.OrHTTPFail(w, json.NewEncoder(w).Encode(points))
+}
+
+type transferDealPoint struct {
+	Bucket    time.Time `db:"bucket"`
+	SpID      int64     `db:"sp_id"`
+	PieceCID  string    `db:"piece_cid"`
+	DealID    int64     `db:"deal_id"`
+	Client    string    `db:"client"`
+	Transfers int64     `db:"transfers"`
+	Bytes     int64     `db:"bytes"`
+}
+
+// transferDealSeries returns daily inbound piece data transfers per deal. DDO pieces have deal ID 0. The optional
+// 'client' parameter selects the deals of a single client.
+func (c *cfg) transferDealSeries(w http.ResponseWriter, r *http.Request) {
+	from, to, err := timeRange(r)
+	apihelper.OrHTTPFail(w, err)
+
+	var points []transferDealPoint
+	err = c.DB.Select(r.Context(), &points, `SELECT bucket, sp_id, piece_cid, deal_id, client, transfers, bytes
+		FROM chart_transfer_deals_daily
+		WHERE bucket >= date_trunc('day', $1::TIMESTAMPTZ) AND bucket <= $2 AND ($3 = '' OR client = $3)
+		ORDER BY bucket, client, sp_id, piece_cid`, from, to, r.URL.Query().Get("client"))
+	apihelper.OrHTTPFail(w, err)
+
+	apihelper.OrHTTPFail(w, json.NewEncoder(w).Encode(points))
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/filecoin-project/curio/deps"
 	"github.com/filecoin-project/curio/deps/config"
+	"github.com/filecoin-project/curio/web/api/apiauth"
 	"github.com/filecoin-project/curio/web/api/apihelper"
 )
 
@@ -33,8 +34,8 @@ func Routes(r *mux.Router, deps *deps.Deps) {
 	// At edit.html:
 	r.Methods("GET").Path("/schema").HandlerFunc(getSch)
 	r.Methods("GET").Path("/layers/{layer}").HandlerFunc(c.getLayer)
-	r.Methods("POST").Path("/addlayer").HandlerFunc(c.addLayer)
-	r.Methods("POST").Path("/layers/{layer}").HandlerFunc(c.setLayer)
+	r.Methods("POST").Path("/addlayer").HandlerFunc(apiauth.Require(apiauth.ScopeConfigWrite, c.addLayer))
+	r.Methods("POST").Path("/layers/{layer}").HandlerFunc(apiauth.Require(apiauth.ScopeConfigWrite, c.setLayer))
 	r.Methods("GET").Path("/default").HandlerFunc(c.def)
 }
 
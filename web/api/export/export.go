@@ -0,0 +1,358 @@
+// Package export serves CSV and JSON exports of cluster data for reporting.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/build"
+	"github.com/filecoin-project/curio/deps"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/web/api/apihelper"
+)
+
+var log = logging.Logger("curio/web/export")
+
+type cfg struct {
+	*deps.Deps
+}
+
+// Routes registers the export endpoints. All of them accept:
+//   - format: csv (default) or json
+//   - from, to: RFC3339 time range, defaulting to the last 30 days
+//
+// Output is streamed, so exports of large clusters don't need to fit in memory.
+func Routes(r *mux.Router, deps *deps.Deps) {
+	c := &cfg{deps}
+	r.Methods("GET").Path("/sectors").HandlerFunc(c.sectors)
+	r.Methods("GET").Path("/deals").HandlerFunc(c.deals)
+	r.Methods("GET").Path("/gas").HandlerFunc(c.gas)
+	r.Methods("GET").Path("/gas-by-task").HandlerFunc(c.gasByTask)
+	r.Methods("GET").Path("/proving").HandlerFunc(c.proving)
+}
+
+type exportRange struct {
+	from, to time.Time
+
+	// fromEpoch and toEpoch are the chain epochs matching from and to, for tables which only record epochs
+	fromEpoch, toEpoch abi.ChainEpoch
+}
+
+func (c *cfg) parseRange(r *http.Request, epochs bool) (exportRange, error) {
+	rng := exportRange{to: time.Now()}
+	rng.from = rng.to.Add(-30 * 24 * time.Hour)
+
+	if s := r.URL.Query().Get("from"); s != "" {
+		var err error
+		rng.from, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return exportRange{}, xerrors.Errorf("parsing from: %w", err)
+		}
+	}
+	if s := r.URL.Query().Get("to"); s != "" {
+		var err error
+		rng.to, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return exportRange{}, xerrors.Errorf("parsing to: %w", err)
+		}
+	}
+	if rng.to.Before(rng.from) {
+		return exportRange{}, xerrors.Errorf("invalid range, %s is before %s", rng.to, rng.from)
+	}
+
+	if epochs {
+		head, err := c.Chain.ChainHead(r.Context())
+		if err != nil {
+			return exportRange{}, xerrors.Errorf("getting chain head: %w", err)
+		}
+		toEpoch := func(t time.Time) abi.ChainEpoch {
+			return head.Height() - abi.ChainEpoch((int64(head.MinTimestamp())-t.Unix())/int64(build.BlockDelaySecs))
+		}
+		rng.fromEpoch, rng.toEpoch = toEpoch(rng.from), toEpoch(rng.to)
+	}
+
+	return rng, nil
+}
+
+// writeRows streams query results as CSV or JSON. T must be a struct with db tags, which also name the
+// CSV columns and JSON fields.
+func writeRows[T any](w http.ResponseWriter, r *http.Request, name string, q *harmonydb.Query) {
+	defer q.Close()
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		apihelper.OrHTTPFail(w, xerrors.Errorf("unknown format %q, expected csv or json", format))
+	}
+
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	var cols []string
+	for i := 0; i < typ.NumField(); i++ {
+		cols = append(cols, typ.Field(i).Tag.Get("db"))
+	}
+
+	fname := fmt.Sprintf("curio-%s-%s.%s", name, time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fname+`"`)
+
+	// Errors after the first row has been written can't change the response status anymore, they are
+	// logged and the output is cut short.
+	var err error
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		if err = cw.Write(cols); err != nil {
+			break
+		}
+		for q.Next() {
+			var row T
+			if err = q.StructScan(&row); err != nil {
+				break
+			}
+			if err = cw.Write(csvRecord(reflect.ValueOf(row))); err != nil {
+				break
+			}
+		}
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		if _, err = w.Write([]byte("[")); err != nil {
+			break
+		}
+		enc := json.NewEncoder(w)
+		first := true
+		for q.Next() {
+			var row T
+			if err = q.StructScan(&row); err != nil {
+				break
+			}
+			if !first {
+				if _, err = w.Write([]byte(",")); err != nil {
+					break
+				}
+			}
+			first = false
+
+			obj := map[string]any{}
+			v := reflect.ValueOf(row)
+			for i, col := range cols {
+				obj[col] = v.Field(i).Interface()
+			}
+			if err = enc.Encode(obj); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			_, err = w.Write([]byte("]"))
+		}
+	}
+	if err == nil {
+		err = q.Err()
+	}
+	if err != nil {
+		log.Errorw("export failed", "export", name, "error", err)
+	}
+}
+
+func csvRecord(v reflect.Value) []string {
+	rec := make([]string, v.NumField())
+	for i := range rec {
+		f := v.Field(i)
+		if f.Kind() == reflect.Pointer {
+			if f.IsNil() {
+				continue
+			}
+			f = f.Elem()
+		}
+
+		switch fv := f.Interface().(type) {
+		case time.Time:
+			rec[i] = fv.UTC().Format(time.RFC3339)
+		case string:
+			rec[i] = fv
+		case int64:
+			rec[i] = strconv.FormatInt(fv, 10)
+		case bool:
+			rec[i] = strconv.FormatBool(fv)
+		default:
+			rec[i] = fmt.Sprint(fv)
+		}
+	}
+	return rec
+}
+
+type sectorRow struct {
+	SpID            int64   `db:"sp_id"`
+	SectorNum       int64   `db:"sector_num"`
+	RegSealProof    int64   `db:"reg_seal_proof"`
+	IsCC            *bool   `db:"is_cc"`
+	SealedCID       string  `db:"cur_sealed_cid"`
+	UnsealedCID     string  `db:"cur_unsealed_cid"`
+	SeedEpoch       int64   `db:"seed_epoch"`
+	ExpirationEpoch *int64  `db:"expiration_epoch"`
+	Deadline        *int64  `db:"deadline"`
+	Partition       *int64  `db:"partition"`
+	PrecommitMsg    *string `db:"msg_cid_precommit"`
+	CommitMsg       *string `db:"msg_cid_commit"`
+	UpdateMsg       *string `db:"msg_cid_update"`
+}
+
+// sectors exports sectors which were sealed (reached their seed epoch) within the range.
+func (c *cfg) sectors(w http.ResponseWriter, r *http.Request) {
+	rng, err := c.parseRange(r, true)
+	apihelper.OrHTTPFail(w, err)
+
+	q, err := c.DB.Query(r.Context(), `SELECT sp_id, sector_num, reg_seal_proof, is_cc, cur_sealed_cid, cur_unsealed_cid,
+			seed_epoch, expiration_epoch, deadline, partition, msg_cid_precommit, msg_cid_commit, msg_cid_update
+		FROM sectors_meta
+		WHERE seed_epoch >= $1 AND seed_epoch <= $2
+		ORDER BY sp_id, sector_num`, rng.fromEpoch, rng.toEpoch)
+	apihelper.OrHTTPFail(w, err)
+
+	writeRows[sectorRow](w, r, "sectors", q)
+}
+
+type dealRow struct {
+	SpID          int64   `db:"sp_id"`
+	SectorNum     int64   `db:"sector_num"`
+	PieceIndex    int64   `db:"piece_num"`
+	PieceCID      string  `db:"piece_cid"`
+	PieceSize     int64   `db:"piece_size"`
+	DealID        *int64  `db:"f05_deal_id"`
+	Client        *string `db:"client"`
+	Verified      *bool   `db:"verified"`
+	PricePerEpoch *string `db:"price_per_epoch"`
+	StartEpoch    *int64  `db:"start_epoch"`
+	EndEpoch      *int64  `db:"orig_end_epoch"`
+	SeedEpoch     int64   `db:"seed_epoch"`
+}
+
+// deals exports pieces in sectors sealed within the range, including f05 deal terms where available.
+func (c *cfg) deals(w http.ResponseWriter, r *http.Request) {
+	rng, err := c.parseRange(r, true)
+	apihelper.OrHTTPFail(w, err)
+
+	q, err := c.DB.Query(r.Context(), `SELECT mp.sp_id, mp.sector_num, mp.piece_num, mp.piece_cid, mp.piece_size, mp.f05_deal_id,
+			mp.f05_deal_proposal->>'Client' AS client,
+			(mp.f05_deal_proposal->>'VerifiedDeal')::BOOLEAN AS verified,
+			mp.f05_deal_proposal->>'StoragePricePerEpoch' AS price_per_epoch,
+			mp.start_epoch, mp.orig_end_epoch, sm.seed_epoch
+		FROM sectors_meta_pieces mp
+		JOIN sectors_meta sm ON sm.sp_id = mp.sp_id AND sm.sector_num = mp.sector_num
+		WHERE sm.seed_epoch >= $1 AND sm.seed_epoch <= $2
+		ORDER BY mp.sp_id, mp.sector_num, mp.piece_num`, rng.fromEpoch, rng.toEpoch)
+	apihelper.OrHTTPFail(w, err)
+
+	writeRows[dealRow](w, r, "deals", q)
+}
+
+type gasRow struct {
+	SendTime    time.Time `db:"send_time"`
+	From        string    `db:"from_key"`
+	To          string    `db:"to_addr"`
+	Reason      string    `db:"send_reason"`
+	SignedCID   *string   `db:"signed_cid"`
+	Nonce       *int64    `db:"nonce"`
+	Value       *string   `db:"value"`
+	GasLimit    *int64    `db:"gas_limit"`
+	GasFeeCap   *string   `db:"gas_fee_cap"`
+	GasPremium  *string   `db:"gas_premium"`
+	LandedEpoch *int64    `db:"executed_tsk_epoch"`
+	ExitCode    *int64    `db:"executed_rcpt_exitcode"`
+	GasUsed     *int64    `db:"executed_rcpt_gas_used"`
+}
+
+// gas exports messages sent within the range with their gas parameters and usage. Amounts are in attoFIL.
+func (c *cfg) gas(w http.ResponseWriter, r *http.Request) {
+	rng, err := c.parseRange(r, false)
+	apihelper.OrHTTPFail(w, err)
+
+	q, err := c.DB.Query(r.Context(), `SELECT s.send_time, s.from_key, s.to_addr, s.send_reason, s.signed_cid, s.nonce,
+			s.signed_json->'Message'->>'Value' AS value,
+			(s.signed_json->'Message'->>'GasLimit')::BIGINT AS gas_limit,
+			s.signed_json->'Message'->>'GasFeeCap' AS gas_fee_cap,
+			s.signed_json->'Message'->>'GasPremium' AS gas_premium,
+			w.executed_tsk_epoch, w.executed_rcpt_exitcode, w.executed_rcpt_gas_used
+		FROM message_sends s
+		LEFT JOIN message_waits w ON w.signed_message_cid = COALESCE(s.held_cid, s.signed_cid)
+		WHERE s.send_success AND s.send_time >= $1 AND s.send_time <= $2
+		ORDER BY s.send_time`, rng.from, rng.to)
+	apihelper.OrHTTPFail(w, err)
+
+	writeRows[gasRow](w, r, "gas", q)
+}
+
+type taskGasRow struct {
+	Day      time.Time `db:"bucket"`
+	SpID     int64     `db:"sp_id"`
+	TaskName string    `db:"task_name"`
+	Messages int64     `db:"messages"`
+	GasUsed  int64     `db:"gas_used"`
+	GasCost  string    `db:"gas_cost"`
+}
+
+// gasByTask exports daily gas of executed messages by sending task type and SP, optionally of one SP (sp_id).
+// Amounts are in attoFIL.
+func (c *cfg) gasByTask(w http.ResponseWriter, r *http.Request) {
+	rng, err := c.parseRange(r, false)
+	apihelper.OrHTTPFail(w, err)
+
+	spID := int64(-1)
+	if s := r.URL.Query().Get("sp_id"); s != "" {
+		spID, err = strconv.ParseInt(s, 10, 64)
+		apihelper.OrHTTPFail(w, err)
+	}
+
+	q, err := c.DB.Query(r.Context(), `SELECT bucket, sp_id, task_name, messages, gas_used, gas_cost::TEXT AS gas_cost
+		FROM chart_gas_task_daily
+		WHERE bucket >= date_trunc('day', $1::TIMESTAMPTZ) AND bucket <= $2 AND ($3 < 0 OR sp_id = $3)
+		ORDER BY bucket, sp_id, task_name`, rng.from, rng.to, spID)
+	apihelper.OrHTTPFail(w, err)
+
+	writeRows[taskGasRow](w, r, "gas-by-task", q)
+}
+
+type provingRow struct {
+	SpID               int64   `db:"sp_id"`
+	ProvingPeriodStart int64   `db:"proving_period_start"`
+	Deadline           int64   `db:"deadline"`
+	Partition          int64   `db:"partition"`
+	SubmitAtEpoch      int64   `db:"submit_at_epoch"`
+	SubmitByEpoch      int64   `db:"submit_by_epoch"`
+	MessageCID         *string `db:"message_cid"`
+	LandedEpoch        *int64  `db:"executed_tsk_epoch"`
+	ExitCode           *int64  `db:"executed_rcpt_exitcode"`
+	GasUsed            *int64  `db:"executed_rcpt_gas_used"`
+}
+
+// proving exports WindowPoSt submissions scheduled within the range with their on-chain result.
+func (c *cfg) proving(w http.ResponseWriter, r *http.Request) {
+	rng, err := c.parseRange(r, true)
+	apihelper.OrHTTPFail(w, err)
+
+	q, err := c.DB.Query(r.Context(), `SELECT p.sp_id, p.proving_period_start, p.deadline, p.partition, p.submit_at_epoch, p.submit_by_epoch,
+			p.message_cid, w.executed_tsk_epoch, w.executed_rcpt_exitcode, w.executed_rcpt_gas_used
+		FROM wdpost_proofs p
+		LEFT JOIN message_waits w ON w.signed_message_cid = p.message_cid
+		WHERE p.submit_at_epoch >= $1 AND p.submit_at_epoch <= $2
+		ORDER BY p.submit_at_epoch, p.sp_id, p.deadline, p.partition`, rng.fromEpoch, rng.toEpoch)
+	apihelper.OrHTTPFail(w, err)
+
+	writeRows[provingRow](w, r, "proving", q)
+}
@@ -0,0 +1,590 @@
+// Package graphql serves a read-only GraphQL view of the cluster state.
+package graphql
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	gographql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/deps"
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+)
+
+//go:embed schema.graphql
+var schema string
+
+const (
+	maxLimit = 1000
+
+	// maxCost bounds the work a single request makes the database do. Every query costs one and every row it returns
+	// one more, so a list nested in another list is paid for once per parent it is resolved for.
+	maxCost = 20000
+
+	// requestTimeout bounds how long the queries of a single request may run.
+	requestTimeout = 30 * time.Second
+)
+
+func Routes(r *mux.Router, deps *deps.Deps) {
+	if !deps.Cfg.Web.EnableGraphQL {
+		return
+	}
+
+	r.Methods("POST").Handler(Handler(deps.DB, maxCost))
+}
+
+// Handler serves GraphQL queries against db. Each request can cost at most cost, see maxCost.
+func Handler(db *harmonydb.DB, cost int64) http.Handler {
+	s := gographql.MustParseSchema(schema, &resolver{db: db},
+		gographql.UseFieldResolvers(),
+		gographql.MaxDepth(8))
+
+	return limited(&relay.Handler{Schema: s}, cost, requestTimeout)
+}
+
+// limited gives every request served by next a cost budget and a deadline.
+func limited(next http.Handler, cost int64, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(withBudget(ctx, cost)))
+	})
+}
+
+type budgetKey struct{}
+
+type budget struct {
+	limit int64
+	left  atomic.Int64
+}
+
+func withBudget(ctx context.Context, cost int64) context.Context {
+	b := &budget{limit: cost}
+	b.left.Store(cost)
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+// charge takes cost out of the budget of the request, it fails once the budget is used up. Resolvers charge before
+// querying the database, so no more queries are made for a request over its budget.
+func charge(ctx context.Context, cost int) error {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	if !ok {
+		return nil
+	}
+	if b.left.Add(-int64(cost)) < 0 {
+		return xerrors.Errorf("query exceeds the cost limit of %d", b.limit)
+	}
+	return nil
+}
+
+// Int64 is a 64-bit integer scalar, GraphQL Int is 32-bit only.
+type Int64 int64
+
+func (Int64) ImplementsGraphQLType(name string) bool {
+	return name == "Int64"
+}
+
+func (i *Int64) UnmarshalGraphQL(input interface{}) error {
+	switch v := input.(type) {
+	case int32:
+		*i = Int64(v)
+	case int64:
+		*i = Int64(v)
+	case float64:
+		*i = Int64(v)
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*i = Int64(n)
+	default:
+		return xerrors.Errorf("wrong type for Int64: %T", input)
+	}
+	return nil
+}
+
+func gqlTime(t time.Time) gographql.Time {
+	return gographql.Time{Time: t}
+}
+
+func gqlTimePtr(t *time.Time) *gographql.Time {
+	if t == nil {
+		return nil
+	}
+	return &gographql.Time{Time: *t}
+}
+
+func limitOffset(limit, offset *int32) (int32, int32) {
+	l, o := int32(100), int32(0)
+	if limit != nil && *limit > 0 {
+		l = min(*limit, maxLimit)
+	}
+	if offset != nil && *offset > 0 {
+		o = *offset
+	}
+	return l, o
+}
+
+type resolver struct {
+	db *harmonydb.DB
+}
+
+/////// Machines
+
+type machine struct {
+	db *harmonydb.DB
+
+	ID            int32     `db:"id"`
+	Address       string    `db:"host_and_port"`
+	Name          *string   `db:"machine_name"`
+	CPU           int32     `db:"cpu"`
+	RAM           Int64     `db:"ram"`
+	GPU           float64   `db:"gpu"`
+	Contact       time.Time `db:"last_contact"`
+	Unschedulable bool      `db:"unschedulable"`
+	TaskList      string    `db:"tasks"`
+	LayerList     string    `db:"layers"`
+}
+
+func (m *machine) LastContact() gographql.Time {
+	return gqlTime(m.Contact)
+}
+
+func (m *machine) TaskTypes() []string {
+	return splitList(m.TaskList)
+}
+
+func (m *machine) Layers() []string {
+	return splitList(m.LayerList)
+}
+
+func (m *machine) Tasks(ctx context.Context) ([]*task, error) {
+	ownerID := m.ID
+	return (&resolver{db: m.db}).Tasks(ctx, struct {
+		Name    *string
+		OwnerID *int32
+		Limit   *int32
+		Offset  *int32
+	}{OwnerID: &ownerID})
+}
+
+func (m *machine) StoragePaths(ctx context.Context) ([]*storagePath, error) {
+	all, err := (&resolver{db: m.db}).StoragePaths(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return lo.Filter(all, func(sp *storagePath, _ int) bool {
+		return lo.ContainsBy(sp.URLs(), func(u string) bool {
+			pu, err := url.Parse(u)
+			return err == nil && pu.Host == m.Address
+		})
+	}), nil
+}
+
+func (r *resolver) selectMachines(ctx context.Context, id *int32) ([]*machine, error) {
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	var machines []*machine
+	err := r.db.Select(ctx, &machines, `SELECT m.id, m.host_and_port, d.machine_name, m.cpu, m.ram, m.gpu, m.last_contact, m.unschedulable,
+			COALESCE(d.tasks, '') AS tasks, COALESCE(d.layers, '') AS layers
+		FROM harmony_machines m
+		LEFT JOIN harmony_machine_details d ON d.machine_id = m.id
+		WHERE ($1::INT IS NULL OR m.id = $1)
+		ORDER BY m.id`, id)
+	if err != nil {
+		return nil, xerrors.Errorf("getting machines: %w", err)
+	}
+	if err := charge(ctx, len(machines)); err != nil {
+		return nil, err
+	}
+	for _, m := range machines {
+		m.db = r.db
+	}
+	return machines, nil
+}
+
+func (r *resolver) Machines(ctx context.Context) ([]*machine, error) {
+	return r.selectMachines(ctx, nil)
+}
+
+func (r *resolver) Machine(ctx context.Context, args struct{ ID int32 }) (*machine, error) {
+	machines, err := r.selectMachines(ctx, &args.ID)
+	if err != nil || len(machines) == 0 {
+		return nil, err
+	}
+	return machines[0], nil
+}
+
+/////// Tasks
+
+type task struct {
+	db *harmonydb.DB
+
+	ID      int32     `db:"id"`
+	Name    string    `db:"name"`
+	Posted  time.Time `db:"posted_time"`
+	Updated time.Time `db:"update_time"`
+	OwnerID *int32    `db:"owner_id"`
+}
+
+func (t *task) PostedTime() gographql.Time {
+	return gqlTime(t.Posted)
+}
+
+func (t *task) UpdateTime() gographql.Time {
+	return gqlTime(t.Updated)
+}
+
+func (t *task) Owner(ctx context.Context) (*machine, error) {
+	if t.OwnerID == nil {
+		return nil, nil
+	}
+	return (&resolver{db: t.db}).Machine(ctx, struct{ ID int32 }{ID: *t.OwnerID})
+}
+
+func (r *resolver) Tasks(ctx context.Context, args struct {
+	Name    *string
+	OwnerID *int32
+	Limit   *int32
+	Offset  *int32
+}) ([]*task, error) {
+	limit, offset := limitOffset(args.Limit, args.Offset)
+
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	var tasks []*task
+	err := r.db.Select(ctx, &tasks, `SELECT id, name, posted_time, update_time, owner_id FROM harmony_task
+		WHERE ($1::TEXT IS NULL OR name = $1) AND ($2::INT IS NULL OR owner_id = $2)
+		ORDER BY id
+		LIMIT $3 OFFSET $4`, args.Name, args.OwnerID, limit, offset)
+	if err != nil {
+		return nil, xerrors.Errorf("getting tasks: %w", err)
+	}
+	if err := charge(ctx, len(tasks)); err != nil {
+		return nil, err
+	}
+	for _, t := range tasks {
+		t.db = r.db
+	}
+	return tasks, nil
+}
+
+/////// Sectors
+
+type sector struct {
+	db *harmonydb.DB
+
+	SpID              Int64  `db:"sp_id"`
+	Number            Int64  `db:"sector_num"`
+	RegSealProof      int32  `db:"reg_seal_proof"`
+	SealedCid         string `db:"cur_sealed_cid"`
+	UnsealedCid       string `db:"cur_unsealed_cid"`
+	IsCC              *bool  `db:"is_cc"`
+	ExpirationEpoch   *Int64 `db:"expiration_epoch"`
+	Deadline          *Int64 `db:"deadline"`
+	Partition         *Int64 `db:"partition"`
+	TargetUnsealState *bool  `db:"target_unseal_state"`
+}
+
+func (s *sector) Pieces(ctx context.Context) ([]*deal, error) {
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	var deals []*deal
+	err := s.db.Select(ctx, &deals, `SELECT sp_id, sector_num, piece_num, piece_cid, piece_size, f05_deal_id, start_epoch, orig_end_epoch
+		FROM sectors_meta_pieces WHERE sp_id = $1 AND sector_num = $2 ORDER BY piece_num`, s.SpID, s.Number)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector pieces: %w", err)
+	}
+	if err := charge(ctx, len(deals)); err != nil {
+		return nil, err
+	}
+	for _, d := range deals {
+		d.db = s.db
+	}
+	return deals, nil
+}
+
+func (s *sector) Locations(ctx context.Context) ([]*sectorLocation, error) {
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	var locs []*sectorLocation
+	err := s.db.Select(ctx, &locs, `SELECT sector_filetype, storage_id, COALESCE(is_primary, FALSE) AS is_primary
+		FROM sector_location WHERE miner_id = $1 AND sector_num = $2 ORDER BY sector_filetype, storage_id`, s.SpID, s.Number)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector locations: %w", err)
+	}
+	if err := charge(ctx, len(locs)); err != nil {
+		return nil, err
+	}
+	for _, l := range locs {
+		l.db = s.db
+	}
+	return locs, nil
+}
+
+func (r *resolver) Sectors(ctx context.Context, args struct {
+	SpID       *Int64
+	FromNumber *Int64
+	ToNumber   *Int64
+	Limit      *int32
+	Offset     *int32
+}) ([]*sector, error) {
+	limit, offset := limitOffset(args.Limit, args.Offset)
+
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	var sectors []*sector
+	err := r.db.Select(ctx, &sectors, `SELECT sp_id, sector_num, reg_seal_proof, cur_sealed_cid, cur_unsealed_cid,
+			is_cc, expiration_epoch, deadline, partition, target_unseal_state
+		FROM sectors_meta
+		WHERE ($1::BIGINT IS NULL OR sp_id = $1)
+		  AND ($2::BIGINT IS NULL OR sector_num >= $2)
+		  AND ($3::BIGINT IS NULL OR sector_num <= $3)
+		ORDER BY sp_id, sector_num
+		LIMIT $4 OFFSET $5`, args.SpID, args.FromNumber, args.ToNumber, limit, offset)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sectors: %w", err)
+	}
+	if err := charge(ctx, len(sectors)); err != nil {
+		return nil, err
+	}
+	for _, s := range sectors {
+		s.db = r.db
+	}
+	return sectors, nil
+}
+
+func (r *resolver) Sector(ctx context.Context, args struct {
+	SpID   Int64
+	Number Int64
+}) (*sector, error) {
+	sectors, err := r.Sectors(ctx, struct {
+		SpID       *Int64
+		FromNumber *Int64
+		ToNumber   *Int64
+		Limit      *int32
+		Offset     *int32
+	}{SpID: &args.SpID, FromNumber: &args.Number, ToNumber: &args.Number})
+	if err != nil || len(sectors) == 0 {
+		return nil, err
+	}
+	return sectors[0], nil
+}
+
+type sectorLocation struct {
+	db *harmonydb.DB
+
+	FileTypeNum int64  `db:"sector_filetype"`
+	StorageID   string `db:"storage_id"`
+	Primary     bool   `db:"is_primary"`
+}
+
+func (l *sectorLocation) FileType() string {
+	return storiface.SectorFileType(l.FileTypeNum).String()
+}
+
+func (l *sectorLocation) Storage(ctx context.Context) (*storagePath, error) {
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	var sps []*storagePath
+	err := l.db.Select(ctx, &sps, `SELECT storage_id, COALESCE(urls, '') AS urls, weight, COALESCE(can_seal, FALSE) AS can_seal,
+			COALESCE(can_store, FALSE) AS can_store, capacity, available, used, reserved, last_heartbeat, heartbeat_err
+		FROM storage_path WHERE storage_id = $1`, l.StorageID)
+	if err != nil || len(sps) == 0 {
+		return nil, err
+	}
+	return sps[0], nil
+}
+
+/////// Storage
+
+type storagePath struct {
+	ID           string     `db:"storage_id"`
+	URLList      string     `db:"urls"`
+	Weight       *Int64     `db:"weight"`
+	CanSeal      bool       `db:"can_seal"`
+	CanStore     bool       `db:"can_store"`
+	Capacity     *Int64     `db:"capacity"`
+	Available    *Int64     `db:"available"`
+	Used         *Int64     `db:"used"`
+	Reserved     *Int64     `db:"reserved"`
+	Heartbeat    *time.Time `db:"last_heartbeat"`
+	HeartbeatErr *string    `db:"heartbeat_err"`
+}
+
+func (s *storagePath) URLs() []string {
+	if s.URLList == "" {
+		return []string{}
+	}
+	return strings.Split(s.URLList, paths.URLSeparator)
+}
+
+func (s *storagePath) LastHeartbeat() *gographql.Time {
+	return gqlTimePtr(s.Heartbeat)
+}
+
+func (r *resolver) StoragePaths(ctx context.Context) ([]*storagePath, error) {
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	var sps []*storagePath
+	err := r.db.Select(ctx, &sps, `SELECT storage_id, COALESCE(urls, '') AS urls, weight, COALESCE(can_seal, FALSE) AS can_seal,
+			COALESCE(can_store, FALSE) AS can_store, capacity, available, used, reserved, last_heartbeat, heartbeat_err
+		FROM storage_path ORDER BY storage_id`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting storage paths: %w", err)
+	}
+	if err := charge(ctx, len(sps)); err != nil {
+		return nil, err
+	}
+	return sps, nil
+}
+
+/////// Deals
+
+type deal struct {
+	db *harmonydb.DB
+
+	SpID         Int64  `db:"sp_id"`
+	SectorNumber Int64  `db:"sector_num"`
+	PieceIndex   Int64  `db:"piece_num"`
+	PieceCid     string `db:"piece_cid"`
+	PieceSize    Int64  `db:"piece_size"`
+	DealID       *Int64 `db:"f05_deal_id"`
+	StartEpoch   *Int64 `db:"start_epoch"`
+	EndEpoch     *Int64 `db:"orig_end_epoch"`
+}
+
+func (d *deal) Sector(ctx context.Context) (*sector, error) {
+	return (&resolver{db: d.db}).Sector(ctx, struct {
+		SpID   Int64
+		Number Int64
+	}{SpID: d.SpID, Number: d.SectorNumber})
+}
+
+func (r *resolver) Deals(ctx context.Context, args struct {
+	SpID   *Int64
+	Limit  *int32
+	Offset *int32
+}) ([]*deal, error) {
+	limit, offset := limitOffset(args.Limit, args.Offset)
+
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	var deals []*deal
+	err := r.db.Select(ctx, &deals, `SELECT sp_id, sector_num, piece_num, piece_cid, piece_size, f05_deal_id, start_epoch, orig_end_epoch
+		FROM sectors_meta_pieces
+		WHERE ($1::BIGINT IS NULL OR sp_id = $1)
+		ORDER BY sp_id, sector_num, piece_num
+		LIMIT $2 OFFSET $3`, args.SpID, limit, offset)
+	if err != nil {
+		return nil, xerrors.Errorf("getting deals: %w", err)
+	}
+	if err := charge(ctx, len(deals)); err != nil {
+		return nil, err
+	}
+	for _, d := range deals {
+		d.db = r.db
+	}
+	return deals, nil
+}
+
+type pendingDeal struct {
+	SpID         Int64     `db:"sp_id"`
+	SectorNumber Int64     `db:"sector_number"`
+	PieceCid     string    `db:"piece_cid"`
+	PieceSize    Int64     `db:"piece_size"`
+	Created      time.Time `db:"created_at"`
+	IsSnap       bool      `db:"is_snap"`
+}
+
+func (p *pendingDeal) CreatedAt() gographql.Time {
+	return gqlTime(p.Created)
+}
+
+func (r *resolver) PendingDeals(ctx context.Context) ([]*pendingDeal, error) {
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	var deals []*pendingDeal
+	err := r.db.Select(ctx, &deals, `SELECT sp_id, sector_number, piece_cid, piece_size, created_at, is_snap
+		FROM open_sector_pieces ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting pending deals: %w", err)
+	}
+	if err := charge(ctx, len(deals)); err != nil {
+		return nil, err
+	}
+	return deals, nil
+}
+
+/////// Messages
+
+type message struct {
+	FromKey     string     `db:"from_key"`
+	ToAddr      string     `db:"to_addr"`
+	SendReason  string     `db:"send_reason"`
+	TaskID      Int64      `db:"send_task_id"`
+	Nonce       *Int64     `db:"nonce"`
+	SignedCid   *string    `db:"signed_cid"`
+	Sent        *time.Time `db:"send_time"`
+	SendSuccess *bool      `db:"send_success"`
+	SendError   *string    `db:"send_error"`
+}
+
+func (m *message) SendTime() *gographql.Time {
+	return gqlTimePtr(m.Sent)
+}
+
+func (r *resolver) Messages(ctx context.Context, args struct {
+	FromKey *string
+	Limit   *int32
+	Offset  *int32
+}) ([]*message, error) {
+	limit, offset := limitOffset(args.Limit, args.Offset)
+
+	if err := charge(ctx, 1); err != nil {
+		return nil, err
+	}
+	var msgs []*message
+	err := r.db.Select(ctx, &msgs, `SELECT from_key, to_addr, send_reason, send_task_id, nonce, signed_cid, send_time, send_success, send_error
+		FROM message_sends
+		WHERE ($1::TEXT IS NULL OR from_key = $1)
+		ORDER BY send_task_id DESC
+		LIMIT $2 OFFSET $3`, args.FromKey, limit, offset)
+	if err != nil {
+		return nil, xerrors.Errorf("getting messages: %w", err)
+	}
+	if err := charge(ctx, len(msgs)); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+func splitList(s string) []string {
+	return lo.Filter(strings.Split(s, ","), func(item string, _ int) bool {
+		return item != ""
+	})
+}
@@ -0,0 +1,106 @@
+package graphql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gographql "github.com/graph-gophers/graphql-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+)
+
+func TestLimitOffset(t *testing.T) {
+	i32 := func(v int32) *int32 { return &v }
+
+	cases := []struct {
+		name          string
+		limit, offset *int32
+		wantL, wantO  int32
+	}{
+		{"defaults", nil, nil, 100, 0},
+		{"set", i32(10), i32(20), 10, 20},
+		{"capped", i32(maxLimit + 1), nil, maxLimit, 0},
+		{"zero limit", i32(0), nil, 100, 0},
+		{"negative", i32(-5), i32(-5), 100, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l, o := limitOffset(c.limit, c.offset)
+			require.Equal(t, c.wantL, l)
+			require.Equal(t, c.wantO, o)
+		})
+	}
+}
+
+func TestInt64Unmarshal(t *testing.T) {
+	cases := []struct {
+		in   interface{}
+		want Int64
+		err  bool
+	}{
+		{int32(7), 7, false},
+		{int64(1 << 40), 1 << 40, false},
+		{float64(12), 12, false},
+		{"9007199254740993", 9007199254740993, false},
+		{"x", 0, true},
+		{true, 0, true},
+	}
+	for _, c := range cases {
+		var i Int64
+		err := i.UnmarshalGraphQL(c.in)
+		if c.err {
+			require.Error(t, err, "%v", c.in)
+			continue
+		}
+		require.NoError(t, err, "%v", c.in)
+		require.Equal(t, c.want, i)
+	}
+}
+
+func TestSplitLists(t *testing.T) {
+	require.Equal(t, []string{"SDR", "TreeRC"}, splitList("SDR,,TreeRC,"))
+	require.Empty(t, splitList(""))
+
+	require.Equal(t, []string{}, (&storagePath{}).URLs())
+	require.Equal(t, []string{"http://a/remote", "http://b/remote"}, (&storagePath{URLList: "http://a/remote,http://b/remote"}).URLs())
+}
+
+func TestCharge(t *testing.T) {
+	// without a budget nothing is limited
+	require.NoError(t, charge(context.Background(), 1<<30))
+
+	ctx := withBudget(context.Background(), 10)
+	require.NoError(t, charge(ctx, 4))
+	require.NoError(t, charge(ctx, 6))
+	require.ErrorContains(t, charge(ctx, 1), "cost limit of 10")
+	require.Error(t, charge(ctx, 0))
+}
+
+func TestLimitedHandler(t *testing.T) {
+	var deadline time.Time
+	h := limited(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ok bool
+		deadline, ok = r.Context().Deadline()
+		require.True(t, ok)
+		require.NoError(t, charge(r.Context(), 5))
+		require.Error(t, charge(r.Context(), 1))
+	}), 5, time.Minute)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	require.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+}
+
+func testSchema(db *harmonydb.DB) *gographql.Schema {
+	return gographql.MustParseSchema(schema, &resolver{db: db}, gographql.UseFieldResolvers(), gographql.MaxDepth(8))
+}
+
+func TestOverBudgetQueriesNothing(t *testing.T) {
+	// the resolver has no database, reaching it would panic
+	res := testSchema(nil).Exec(withBudget(context.Background(), 0), `{ machines { id } }`, "", nil)
+	require.Len(t, res.Errors, 1)
+	require.Contains(t, res.Errors[0].Message, "cost limit of 0")
+}
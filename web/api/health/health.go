@@ -0,0 +1,372 @@
+// Package health serves an aggregated readiness report of the node and the cluster for load balancers and
+// uptime monitors.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/samber/lo"
+	"github.com/snadrus/must"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/build"
+	"github.com/filecoin-project/curio/deps"
+	"github.com/filecoin-project/curio/lib/fastparamfetch"
+	"github.com/filecoin-project/curio/lib/paths"
+
+	proofparams "github.com/filecoin-project/lotus/build/proof-params"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var log = logging.Logger("curio/web/health")
+
+// checkTimeout bounds each check, so that a hung dependency is reported instead of hanging the report.
+const checkTimeout = 10 * time.Second
+
+// deadlineRiskEpochs is how close to the end of the current deadline unproven partitions are reported.
+const deadlineRiskEpochs = 20
+
+type Status string
+
+const (
+	// StatusOK means the check passed.
+	StatusOK Status = "ok"
+	// StatusWarn means the node works, but something needs attention.
+	StatusWarn Status = "warn"
+	// StatusFail means the node isn't ready.
+	StatusFail Status = "fail"
+)
+
+var statusOrder = map[Status]int{StatusOK: 0, StatusWarn: 1, StatusFail: 2}
+
+type Check struct {
+	Name    string
+	Status  Status
+	Message string   `json:",omitempty"`
+	Details []string `json:",omitempty"`
+}
+
+// Report is the readiness report. Status is the worst status of all checks.
+type Report struct {
+	Status Status
+	Node   string
+	Time   time.Time
+	Checks []Check
+}
+
+type health struct {
+	*deps.Deps
+}
+
+type checkFunc func(ctx context.Context) Check
+
+// Routes registers the /health endpoint. It responds with 200 when no check fails and 503 otherwise, so it
+// can be used as a load balancer readiness probe. The report is JSON, with details of each check.
+//
+// Load balancers and uptime monitors don't carry API tokens, so the endpoint is meant to be registered
+// outside of token auth. The report only contains public chain state and the health of the cluster.
+func Routes(r *mux.Router, deps *deps.Deps) {
+	h := &health{deps}
+	r.Methods("GET").Path("/health").HandlerFunc(h.serve)
+}
+
+func (h *health) serve(w http.ResponseWriter, r *http.Request) {
+	rep := h.report(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if rep.Status == StatusFail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(rep); err != nil {
+		log.Warnw("writing health report", "error", err)
+	}
+}
+
+func (h *health) report(ctx context.Context) *Report {
+	checks := []checkFunc{h.checkDB, h.checkChain, h.checkStorage, h.checkParams, h.checkBalances, h.checkDeadlines}
+
+	rep := &Report{Status: StatusOK, Node: h.ListenAddr, Time: time.Now(), Checks: make([]Check, len(checks))}
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c checkFunc) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			rep.Checks[i] = c(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, c := range rep.Checks {
+		if statusOrder[c.Status] > statusOrder[rep.Status] {
+			rep.Status = c.Status
+		}
+	}
+	return rep
+}
+
+func failed(name string, err error) Check {
+	return Check{Name: name, Status: StatusFail, Message: err.Error()}
+}
+
+func (h *health) checkDB(ctx context.Context) Check {
+	const name = "Database"
+
+	var one int
+	if err := h.DB.QueryRow(ctx, `SELECT 1`).Scan(&one); err != nil {
+		return failed(name, xerrors.Errorf("querying database: %w", err))
+	}
+	return Check{Name: name, Status: StatusOK}
+}
+
+func (h *health) checkChain(ctx context.Context) Check {
+	const name = "ChainSync"
+
+	head, err := h.Chain.ChainHead(ctx)
+	if err != nil {
+		return failed(name, xerrors.Errorf("getting chain head: %w", err))
+	}
+
+	behind := time.Since(time.Unix(int64(head.MinTimestamp()), 0)).Truncate(time.Second)
+	msg := fmt.Sprintf("head %d, %s behind", head.Height(), behind)
+
+	// same thresholds as the chain sync alert
+	switch {
+	case behind < time.Duration(build.BlockDelaySecs*3/2)*time.Second:
+		return Check{Name: name, Status: StatusOK, Message: msg}
+	case behind < time.Duration(build.BlockDelaySecs*5)*time.Second:
+		return Check{Name: name, Status: StatusWarn, Message: msg}
+	default:
+		return Check{Name: name, Status: StatusFail, Message: msg}
+	}
+}
+
+func (h *health) checkStorage(ctx context.Context) Check {
+	const name = "Storage"
+
+	var spaths []struct {
+		ID            string     `db:"storage_id"`
+		Urls          string     `db:"urls"`
+		LastHeartbeat *time.Time `db:"last_heartbeat"`
+		HeartbeatErr  *string    `db:"heartbeat_err"`
+	}
+	err := h.DB.Select(ctx, &spaths, `SELECT storage_id, urls, last_heartbeat, heartbeat_err FROM storage_path ORDER BY storage_id`)
+	if err != nil {
+		return failed(name, xerrors.Errorf("getting storage paths: %w", err))
+	}
+	if len(spaths) == 0 {
+		return Check{Name: name, Status: StatusWarn, Message: "no storage paths attached"}
+	}
+
+	var details []string
+	for _, p := range spaths {
+		urls := strings.Join(paths.UrlsFromString(p.Urls), ", ")
+		switch {
+		case p.HeartbeatErr != nil && *p.HeartbeatErr != "":
+			details = append(details, fmt.Sprintf("%s (%s): %s", p.ID, urls, *p.HeartbeatErr))
+		case p.LastHeartbeat == nil:
+			details = append(details, fmt.Sprintf("%s (%s): no heartbeat", p.ID, urls))
+		case time.Since(*p.LastHeartbeat) > paths.SkippedHeartbeatThresh:
+			details = append(details, fmt.Sprintf("%s (%s): no heartbeat for %s", p.ID, urls, time.Since(*p.LastHeartbeat).Truncate(time.Second)))
+		}
+	}
+
+	c := Check{Name: name, Status: StatusOK, Message: fmt.Sprintf("%d of %d paths healthy", len(spaths)-len(details), len(spaths)), Details: details}
+	switch {
+	case len(details) == len(spaths):
+		c.Status = StatusFail
+	case len(details) > 0:
+		c.Status = StatusWarn
+	}
+	return c
+}
+
+// checkParams checks that proof parameters are present on nodes running tasks which need them.
+func (h *health) checkParams(ctx context.Context) Check {
+	const name = "ProofParams"
+
+	s := h.Cfg.Subsystems
+	if !s.EnableWindowPost && !s.EnableWinningPost && !s.EnablePoRepProof && !s.EnableUpdateProve {
+		return Check{Name: name, Status: StatusOK, Message: "no proving tasks enabled"}
+	}
+
+	var details []string
+	for spt := range h.ProofTypes {
+		ssize := must.One(spt.SectorSize())
+		missing, err := fastparamfetch.MissingParams(proofparams.ParametersJSON(), proofparams.SrsJSON(), uint64(ssize))
+		if err != nil {
+			return failed(name, xerrors.Errorf("checking params for %s sectors: %w", ssize.ShortString(), err))
+		}
+		details = append(details, missing...)
+	}
+	// verification keys and SRS files are shared by sector sizes
+	details = lo.Uniq(details)
+	sort.Strings(details)
+
+	if len(details) > 0 {
+		return Check{Name: name, Status: StatusFail, Message: fmt.Sprintf("%d parameter files missing", len(details)), Details: details}
+	}
+	return Check{Name: name, Status: StatusOK}
+}
+
+// checkBalances checks balances of the sender addresses of miners configured on this node against the
+// wallet balance thresholds, falling back to Alerting.MinimumWalletBalance.
+func (h *health) checkBalances(ctx context.Context) Check {
+	const name = "SenderBalances"
+
+	senders := map[address.Address]struct{}{}
+	for _, a := range h.Cfg.Addresses {
+		for _, s := range lo.Flatten([][]string{a.PreCommitControl, a.CommitControl, a.TerminateControl}) {
+			if s == "" {
+				continue
+			}
+			addr, err := address.NewFromString(s)
+			if err != nil {
+				return failed(name, xerrors.Errorf("parsing address %s: %w", s, err))
+			}
+			senders[addr] = struct{}{}
+		}
+	}
+	for maddr := range h.Maddrs {
+		info, err := h.Chain.StateMinerInfo(ctx, address.Address(maddr), types.EmptyTSK)
+		if err != nil {
+			return failed(name, xerrors.Errorf("getting miner info for %s: %w", address.Address(maddr), err))
+		}
+		senders[info.Worker] = struct{}{}
+		for _, c := range info.ControlAddresses {
+			senders[c] = struct{}{}
+		}
+	}
+	if len(senders) == 0 {
+		return Check{Name: name, Status: StatusOK, Message: "no sender addresses"}
+	}
+
+	var thresholdRows []struct {
+		Address    string `db:"address"`
+		MinBalance string `db:"min_balance"`
+	}
+	err := h.DB.Select(ctx, &thresholdRows, `SELECT address, min_balance::TEXT AS min_balance FROM wallet_balance_thresholds`)
+	if err != nil {
+		return failed(name, xerrors.Errorf("getting wallet thresholds: %w", err))
+	}
+	thresholds := map[string]abi.TokenAmount{}
+	for _, t := range thresholdRows {
+		minBalance, err := big.FromString(t.MinBalance)
+		if err != nil {
+			return failed(name, xerrors.Errorf("parsing threshold for %s: %w", t.Address, err))
+		}
+		thresholds[t.Address] = minBalance
+	}
+
+	var details []string
+	for addr := range senders {
+		balance, err := h.Chain.WalletBalance(ctx, addr)
+		if err != nil {
+			return failed(name, xerrors.Errorf("getting balance of %s: %w", addr, err))
+		}
+
+		minBalance, ok := thresholds[addr.String()]
+		if !ok {
+			minBalance = abi.TokenAmount(h.Cfg.Alerting.MinimumWalletBalance)
+		}
+		if balance.LessThan(minBalance) {
+			details = append(details, fmt.Sprintf("%s: %s, below %s", addr, types.FIL(balance).Short(), types.FIL(minBalance).Short()))
+		}
+	}
+	sort.Strings(details)
+
+	if len(details) > 0 {
+		return Check{Name: name, Status: StatusWarn, Message: fmt.Sprintf("%d of %d senders below threshold", len(details), len(senders)), Details: details}
+	}
+	return Check{Name: name, Status: StatusOK, Message: fmt.Sprintf("%d senders", len(senders))}
+}
+
+// checkDeadlines reports faulty sectors in the current and next proving deadline of each miner, and
+// partitions not yet proven close to the end of the current deadline.
+func (h *health) checkDeadlines(ctx context.Context) Check {
+	const name = "Deadlines"
+
+	if len(h.Maddrs) == 0 {
+		return Check{Name: name, Status: StatusOK, Message: "no miners"}
+	}
+
+	head, err := h.Chain.ChainHead(ctx)
+	if err != nil {
+		return failed(name, xerrors.Errorf("getting chain head: %w", err))
+	}
+
+	var details []string
+	for ma := range h.Maddrs {
+		maddr := address.Address(ma)
+
+		di, err := h.Chain.StateMinerProvingDeadline(ctx, maddr, head.Key())
+		if err != nil {
+			return failed(name, xerrors.Errorf("getting proving deadline of %s: %w", maddr, err))
+		}
+
+		for _, dlIdx := range []uint64{di.Index, (di.Index + 1) % di.WPoStPeriodDeadlines} {
+			parts, err := h.Chain.StateMinerPartitions(ctx, maddr, dlIdx, head.Key())
+			if err != nil {
+				return failed(name, xerrors.Errorf("getting partitions of %s deadline %d: %w", maddr, dlIdx, err))
+			}
+
+			var faulty, needProof uint64
+			for _, p := range parts {
+				f, err := p.FaultySectors.Count()
+				if err != nil {
+					return failed(name, xerrors.Errorf("counting faulty sectors: %w", err))
+				}
+				live, err := p.LiveSectors.Count()
+				if err != nil {
+					return failed(name, xerrors.Errorf("counting live sectors: %w", err))
+				}
+				faulty += f
+				if live > f {
+					needProof++
+				}
+			}
+			if faulty > 0 {
+				details = append(details, fmt.Sprintf("%s deadline %d: %d faulty sectors", maddr, dlIdx, faulty))
+			}
+
+			if dlIdx != di.Index || needProof == 0 || di.Close-head.Height() > deadlineRiskEpochs {
+				continue
+			}
+
+			dls, err := h.Chain.StateMinerDeadlines(ctx, maddr, head.Key())
+			if err != nil {
+				return failed(name, xerrors.Errorf("getting deadlines of %s: %w", maddr, err))
+			}
+			proven, err := dls[dlIdx].PostSubmissions.Count()
+			if err != nil {
+				return failed(name, xerrors.Errorf("counting post submissions: %w", err))
+			}
+			if proven < needProof {
+				details = append(details, fmt.Sprintf("%s deadline %d: %d of %d partitions proven, closes in %d epochs",
+					maddr, dlIdx, proven, needProof, di.Close-head.Height()))
+			}
+		}
+	}
+	sort.Strings(details)
+
+	if len(details) > 0 {
+		return Check{Name: name, Status: StatusWarn, Message: fmt.Sprintf("%d deadlines at risk", len(details)), Details: details}
+	}
+	return Check{Name: name, Status: StatusOK, Message: fmt.Sprintf("%d miners", len(h.Maddrs))}
+}
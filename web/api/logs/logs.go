@@ -0,0 +1,91 @@
+// Package logs proxies log streams from cluster machines to the web UI.
+package logs
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/deps"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+	"github.com/filecoin-project/curio/web/api/apihelper"
+)
+
+type cfg struct {
+	*deps.Deps
+}
+
+func Routes(r *mux.Router, deps *deps.Deps) {
+	c := &cfg{deps}
+	// Streams are proxied with admin credentials of the target node, so require admin scope here too.
+	r.Methods("GET").Path("/{machine}").HandlerFunc(apiauth.Require(apiauth.ScopeAdmin, c.streamLogs))
+}
+
+// streamLogs proxies the server-sent event log stream of a cluster machine.
+// The level and subsystem query parameters are passed through to the machine.
+func (c *cfg) streamLogs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.ParseInt(mux.Vars(r)["machine"], 10, 64)
+	apihelper.OrHTTPFail(w, err)
+
+	var hostAndPort string
+	err = c.DB.QueryRow(ctx, `SELECT host_and_port FROM harmony_machines WHERE id = $1`, id).Scan(&hostAndPort)
+	if err != nil {
+		apihelper.OrHTTPFail(w, xerrors.Errorf("getting machine address: %w", err))
+	}
+
+	sa, err := deps.StorageAuth(c.Cfg.Apis.StorageRPCSecret)
+	apihelper.OrHTTPFail(w, err)
+
+	q := url.Values{}
+	q.Set("level", r.URL.Query().Get("level"))
+	q.Set("subsystem", r.URL.Query().Get("subsystem"))
+	u := url.URL{Scheme: "http", Host: hostAndPort, Path: "/debug/logs", RawQuery: q.Encode()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	apihelper.OrHTTPFail(w, err)
+	req.Header = http.Header(sa).Clone()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		apihelper.OrHTTPFail(w, xerrors.Errorf("connecting to %s: %w", hostAndPort, err))
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write(body)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
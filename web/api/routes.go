@@ -5,13 +5,24 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/filecoin-project/curio/deps"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+	"github.com/filecoin-project/curio/web/api/charts"
 	"github.com/filecoin-project/curio/web/api/config"
+	"github.com/filecoin-project/curio/web/api/export"
+	"github.com/filecoin-project/curio/web/api/graphql"
+	"github.com/filecoin-project/curio/web/api/logs"
 	"github.com/filecoin-project/curio/web/api/sector"
 	"github.com/filecoin-project/curio/web/api/webrpc"
 )
 
 func Routes(r *mux.Router, deps *deps.Deps, debug bool) {
+	r.Use(apiauth.New(deps.DB, deps.Cfg.Web).Middleware)
+
 	webrpc.Routes(r.PathPrefix("/webrpc").Subrouter(), deps, debug)
 	config.Routes(r.PathPrefix("/config").Subrouter(), deps)
 	sector.Routes(r.PathPrefix("/sector").Subrouter(), deps)
+	logs.Routes(r.PathPrefix("/logs").Subrouter(), deps)
+	graphql.Routes(r.PathPrefix("/graphql").Subrouter(), deps)
+	charts.Routes(r.PathPrefix("/charts").Subrouter(), deps)
+	export.Routes(r.PathPrefix("/export").Subrouter(), deps)
 }
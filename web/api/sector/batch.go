@@ -0,0 +1,233 @@
+package sector
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/tasks/sectorops"
+	"github.com/filecoin-project/curio/web/api/apihelper"
+)
+
+// batchSelector selects the sectors a batch operation applies to.
+//
+// Filter is an optional comma-separated list of key=value terms, all of which
+// must match. Supported keys: failed, in_pipeline, is_cc (true/false) and
+// deadline, partition (numbers).
+type batchSelector struct {
+	MinerAddress string
+	SectorFrom   int64
+	SectorTo     int64 // inclusive, 0 = no upper bound
+	Filter       string
+}
+
+type batchFilter struct {
+	Failed     *bool
+	InPipeline *bool
+	IsCC       *bool
+	Deadline   *int64
+	Partition  *int64
+}
+
+func parseBatchFilter(expr string) (batchFilter, error) {
+	var f batchFilter
+
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(term, "=")
+		if !ok {
+			return batchFilter{}, xerrors.Errorf("invalid filter term %q, expected key=value", term)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+
+		switch k {
+		case "failed", "in_pipeline", "is_cc":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return batchFilter{}, xerrors.Errorf("parsing %s: %w", k, err)
+			}
+			switch k {
+			case "failed":
+				f.Failed = &b
+			case "in_pipeline":
+				f.InPipeline = &b
+			case "is_cc":
+				f.IsCC = &b
+			}
+		case "deadline", "partition":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return batchFilter{}, xerrors.Errorf("parsing %s: %w", k, err)
+			}
+			if k == "deadline" {
+				f.Deadline = &n
+			} else {
+				f.Partition = &n
+			}
+		default:
+			return batchFilter{}, xerrors.Errorf("unknown filter key %q", k)
+		}
+	}
+
+	return f, nil
+}
+
+type batchRequest struct {
+	Action   string
+	Stage    string // only for retry
+	Selector batchSelector
+}
+
+type batchProgress struct {
+	ID           int64      `db:"op_id"`
+	Action       string     `db:"action"`
+	Stage        string     `db:"stage"`
+	CreateTime   time.Time  `db:"create_time"`
+	CompleteTime *time.Time `db:"complete_time"`
+	TaskID       *int64     `db:"task_id"`
+
+	Total     int64 `db:"total"`
+	Completed int64 `db:"completed"`
+	Failed    int64 `db:"failed"`
+
+	Errors []batchItemError `db:"-"`
+}
+
+type batchItemError struct {
+	SpID         int64  `db:"sp_id"`
+	SectorNumber int64  `db:"sector_number"`
+	Error        string `db:"error"`
+}
+
+// createBatch queues a batch operation and returns its ID which can be used to poll progress.
+func (c *cfg) createBatch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	apihelper.OrHTTPFail(w, json.NewDecoder(r.Body).Decode(&req))
+
+	if !lo.Contains(sectorops.Actions, req.Action) {
+		apihelper.OrHTTPFail(w, xerrors.Errorf("unknown action %q, expected one of %v", req.Action, sectorops.Actions))
+	}
+	if req.Action == sectorops.ActionRetry {
+		if !lo.Contains(sectorops.Stages, req.Stage) {
+			apihelper.OrHTTPFail(w, xerrors.Errorf("unknown stage %q, expected one of %v", req.Stage, sectorops.Stages))
+		}
+	} else {
+		req.Stage = ""
+	}
+
+	maddr, err := address.NewFromString(req.Selector.MinerAddress)
+	apihelper.OrHTTPFail(w, err)
+	spID, err := address.IDFromAddress(maddr)
+	apihelper.OrHTTPFail(w, err)
+
+	sectorTo := req.Selector.SectorTo
+	if sectorTo == 0 {
+		sectorTo = math.MaxInt64
+	}
+	if sectorTo < req.Selector.SectorFrom {
+		apihelper.OrHTTPFail(w, xerrors.Errorf("invalid sector range %d-%d", req.Selector.SectorFrom, sectorTo))
+	}
+
+	filter, err := parseBatchFilter(req.Selector.Filter)
+	apihelper.OrHTTPFail(w, err)
+
+	selector, err := json.Marshal(req.Selector)
+	apihelper.OrHTTPFail(w, err)
+
+	var opID, total int64
+	_, err = c.DB.BeginTransaction(r.Context(), func(tx *harmonydb.Tx) (commit bool, err error) {
+		err = tx.QueryRow(`INSERT INTO sector_batch_ops (action, stage, selector) VALUES ($1, $2, $3) RETURNING op_id`,
+			req.Action, req.Stage, string(selector)).Scan(&opID)
+		if err != nil {
+			return false, xerrors.Errorf("inserting batch op: %w", err)
+		}
+
+		n, err := tx.Exec(`INSERT INTO sector_batch_op_items (op_id, sp_id, sector_number)
+			SELECT $1, s.sp_id, s.sector_number FROM (
+				SELECT sp_id, sector_num AS sector_number FROM sectors_meta
+				UNION
+				SELECT sp_id, sector_number FROM sectors_sdr_pipeline
+			) s
+			LEFT JOIN sectors_meta sm ON sm.sp_id = s.sp_id AND sm.sector_num = s.sector_number
+			LEFT JOIN sectors_sdr_pipeline p ON p.sp_id = s.sp_id AND p.sector_number = s.sector_number
+			WHERE s.sp_id = $2 AND s.sector_number >= $3 AND s.sector_number <= $4
+			  AND ($5::BOOLEAN IS NULL OR COALESCE(p.failed, FALSE) = $5)
+			  AND ($6::BOOLEAN IS NULL OR (p.sp_id IS NOT NULL) = $6)
+			  AND ($7::BOOLEAN IS NULL OR sm.is_cc = $7)
+			  AND ($8::BIGINT IS NULL OR sm.deadline = $8)
+			  AND ($9::BIGINT IS NULL OR sm.partition = $9)`,
+			opID, spID, req.Selector.SectorFrom, sectorTo,
+			filter.Failed, filter.InPipeline, filter.IsCC, filter.Deadline, filter.Partition)
+		if err != nil {
+			return false, xerrors.Errorf("selecting sectors: %w", err)
+		}
+		if n == 0 {
+			return false, xerrors.Errorf("selector matched no sectors")
+		}
+		total = int64(n)
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	apihelper.OrHTTPFail(w, err)
+
+	apihelper.OrHTTPFail(w, json.NewEncoder(w).Encode(map[string]int64{"ID": opID, "Total": total}))
+}
+
+// listBatches returns recent batch operations with their progress.
+func (c *cfg) listBatches(w http.ResponseWriter, r *http.Request) {
+	var ops []batchProgress
+	err := c.DB.Select(r.Context(), &ops, `SELECT o.op_id, o.action, o.stage, o.create_time, o.complete_time, o.task_id,
+			COUNT(i.op_id) AS total,
+			COUNT(i.op_id) FILTER (WHERE i.complete) AS completed,
+			COUNT(i.op_id) FILTER (WHERE i.error IS NOT NULL) AS failed
+		FROM sector_batch_ops o
+		LEFT JOIN sector_batch_op_items i ON i.op_id = o.op_id
+		GROUP BY o.op_id
+		ORDER BY o.op_id DESC
+		LIMIT 100`)
+	apihelper.OrHTTPFail(w, err)
+
+	apihelper.OrHTTPFail(w, json.NewEncoder(w).Encode(ops))
+}
+
+// getBatch returns progress of a single batch operation, including per-sector errors.
+func (c *cfg) getBatch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	apihelper.OrHTTPFail(w, err)
+
+	var ops []batchProgress
+	err = c.DB.Select(r.Context(), &ops, `SELECT o.op_id, o.action, o.stage, o.create_time, o.complete_time, o.task_id,
+			COUNT(i.op_id) AS total,
+			COUNT(i.op_id) FILTER (WHERE i.complete) AS completed,
+			COUNT(i.op_id) FILTER (WHERE i.error IS NOT NULL) AS failed
+		FROM sector_batch_ops o
+		LEFT JOIN sector_batch_op_items i ON i.op_id = o.op_id
+		WHERE o.op_id = $1
+		GROUP BY o.op_id`, id)
+	apihelper.OrHTTPFail(w, err)
+	if len(ops) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	op := ops[0]
+
+	err = c.DB.Select(r.Context(), &op.Errors, `SELECT sp_id, sector_number, error FROM sector_batch_op_items
+		WHERE op_id = $1 AND error IS NOT NULL ORDER BY sp_id, sector_number LIMIT 1000`, id)
+	apihelper.OrHTTPFail(w, err)
+
+	apihelper.OrHTTPFail(w, json.NewEncoder(w).Encode(op))
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/filecoin-project/curio/deps"
 	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/web/api/apiauth"
 	"github.com/filecoin-project/curio/web/api/apihelper"
 
 	"github.com/filecoin-project/lotus/blockstore"
@@ -56,7 +57,11 @@ func Routes(r *mux.Router, deps *deps.Deps) {
 	c := &cfg{deps}
 	// At menu.html:
 	r.Methods("GET").Path("/all").HandlerFunc(c.getSectors)
-	r.Methods("POST").Path("/terminate").HandlerFunc(c.terminateSectors)
+	r.Methods("POST").Path("/terminate").HandlerFunc(apiauth.Require(apiauth.ScopeTasksWrite, c.terminateSectors))
+
+	r.Methods("POST").Path("/batch").HandlerFunc(apiauth.Require(apiauth.ScopeTasksWrite, c.createBatch))
+	r.Methods("GET").Path("/batch").HandlerFunc(c.listBatches)
+	r.Methods("GET").Path("/batch/{id}").HandlerFunc(c.getBatch)
 }
 
 func (c *cfg) terminateSectors(w http.ResponseWriter, r *http.Request) {
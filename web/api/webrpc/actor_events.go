@@ -0,0 +1,73 @@
+package webrpc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+)
+
+type ActorEvent struct {
+	Epoch        int64           `db:"epoch"`
+	MsgCid       string          `db:"msg_cid"`
+	Type         string          `db:"event_type"`
+	SectorNumber *int64          `db:"sector_number"`
+	DealID       *int64          `db:"deal_id"`
+	Entries      json.RawMessage `db:"entries"`
+}
+
+type ActorDeadlineRecord struct {
+	ProvingPeriodStart int64     `db:"proving_period_start"`
+	Deadline           int64     `db:"deadline"`
+	CloseEpoch         int64     `db:"close_epoch"`
+	Partitions         int64     `db:"partitions"`
+	ProvenPartitions   int64     `db:"proven_partitions"`
+	LiveSectors        int64     `db:"live_sectors"`
+	FaultySectors      int64     `db:"faulty_sectors"`
+	RecoveringSectors  int64     `db:"recovering_sectors"`
+	RecordedAt         time.Time `db:"recorded_at"`
+}
+
+// ActorEvents returns the latest indexed actor events of a miner, optionally only of one event type, e.g.
+// 'sector-terminated' or 'deal-activated'.
+func (a *WebRPC) ActorEvents(ctx context.Context, maddr address.Address, eventType string, limit int) ([]ActorEvent, error) {
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("id from %s: %w", maddr, err)
+	}
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	events := []ActorEvent{}
+	err = a.deps.DB.Select(ctx, &events, `SELECT epoch, msg_cid, event_type, sector_number, deal_id, entries
+		FROM actor_events WHERE sp_id = $1 AND ($2 = '' OR event_type = $2)
+		ORDER BY epoch DESC, msg_cid, event_index LIMIT $3`, int64(spID), eventType, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("getting actor events: %w", err)
+	}
+	return events, nil
+}
+
+// ActorDeadlines returns the recorded outcomes of the latest closed proving deadlines of a miner.
+func (a *WebRPC) ActorDeadlines(ctx context.Context, maddr address.Address, limit int) ([]ActorDeadlineRecord, error) {
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("id from %s: %w", maddr, err)
+	}
+	if limit <= 0 || limit > 1000 {
+		limit = 96
+	}
+
+	deadlines := []ActorDeadlineRecord{}
+	err = a.deps.DB.Select(ctx, &deadlines, `SELECT proving_period_start, deadline, close_epoch, partitions, proven_partitions,
+			live_sectors, faulty_sectors, recovering_sectors, recorded_at
+		FROM actor_deadlines WHERE sp_id = $1 ORDER BY close_epoch DESC LIMIT $2`, int64(spID), limit)
+	if err != nil {
+		return nil, xerrors.Errorf("getting actor deadlines: %w", err)
+	}
+	return deadlines, nil
+}
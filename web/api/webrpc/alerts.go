@@ -0,0 +1,172 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type AlertEntry struct {
+	ID         int64      `db:"id"`
+	Name       string     `db:"name"`
+	Message    string     `db:"message"`
+	FirstSeen  time.Time  `db:"first_seen"`
+	LastSeen   time.Time  `db:"last_seen"`
+	ResolvedAt *time.Time `db:"resolved_at"`
+	AckedAt    *time.Time `db:"acked_at"`
+
+	SilencedUntil *time.Time `db:"silenced_until"`
+}
+
+type AlertSilence struct {
+	Name          string    `db:"name"`
+	SilencedUntil time.Time `db:"silenced_until"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+// AlertsActive returns alerts reported by the last alert check run.
+func (a *WebRPC) AlertsActive(ctx context.Context) ([]AlertEntry, error) {
+	var alerts []AlertEntry
+	err := a.deps.DB.Select(ctx, &alerts, `SELECT h.id, h.name, h.message, h.first_seen, h.last_seen, h.resolved_at, h.acked_at,
+			s.silenced_until
+		FROM alert_history h
+		LEFT JOIN alert_silences s ON s.name = h.name AND s.silenced_until > current_timestamp
+		WHERE h.resolved_at IS NULL
+		ORDER BY h.first_seen DESC`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting active alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+// AlertsHistory returns the most recent alerts, including resolved ones. An empty name returns all alerts.
+func (a *WebRPC) AlertsHistory(ctx context.Context, name string, limit int) ([]AlertEntry, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+
+	var alerts []AlertEntry
+	err := a.deps.DB.Select(ctx, &alerts, `SELECT h.id, h.name, h.message, h.first_seen, h.last_seen, h.resolved_at, h.acked_at,
+			s.silenced_until
+		FROM alert_history h
+		LEFT JOIN alert_silences s ON s.name = h.name AND s.silenced_until > current_timestamp
+		WHERE ($1 = '' OR h.name = $1)
+		ORDER BY h.first_seen DESC
+		LIMIT $2`, name, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("getting alert history: %w", err)
+	}
+	return alerts, nil
+}
+
+type alertHistoryRow struct {
+	AlertEntry
+	Total int `db:"total"`
+}
+
+// AlertsHistoryPage returns a page of the alert history. An empty name returns all alerts. Sortable by Name,
+// FirstSeen (default, newest first), LastSeen and ResolvedAt.
+func (a *WebRPC) AlertsHistoryPage(ctx context.Context, name string, req PageRequest) (*Page[AlertEntry], error) {
+	req = req.normalize()
+	if err := req.checkSort("Name", "FirstSeen", "LastSeen", "ResolvedAt"); err != nil {
+		return nil, err
+	}
+
+	desc := req.Desc
+	if req.Sort == "" {
+		desc = !desc
+	}
+
+	var rows []alertHistoryRow
+	err := a.deps.DB.Select(ctx, &rows, `SELECT h.id, h.name, h.message, h.first_seen, h.last_seen, h.resolved_at, h.acked_at,
+			s.silenced_until, COUNT(*) OVER () AS total
+		FROM alert_history h
+		LEFT JOIN alert_silences s ON s.name = h.name AND s.silenced_until > current_timestamp
+		WHERE ($1 = '' OR h.name = $1)
+		ORDER BY
+			CASE WHEN $2 = 'name' AND NOT $3 THEN h.name END ASC,
+			CASE WHEN $2 = 'name' AND $3 THEN h.name END DESC,
+			CASE WHEN $2 = 'lastseen' AND NOT $3 THEN h.last_seen END ASC,
+			CASE WHEN $2 = 'lastseen' AND $3 THEN h.last_seen END DESC,
+			CASE WHEN $2 = 'resolvedat' AND NOT $3 THEN h.resolved_at END ASC NULLS FIRST,
+			CASE WHEN $2 = 'resolvedat' AND $3 THEN h.resolved_at END DESC NULLS LAST,
+			CASE WHEN NOT $3 THEN h.first_seen END ASC,
+			h.first_seen DESC,
+			h.id DESC
+		LIMIT $4 OFFSET $5`, name, req.sqlSort(), desc, req.Limit, req.Offset)
+	if err != nil {
+		return nil, xerrors.Errorf("getting alert history: %w", err)
+	}
+
+	out := &Page[AlertEntry]{Items: make([]AlertEntry, 0, len(rows))}
+	for _, r := range rows {
+		out.Total = r.Total
+		out.Items = append(out.Items, r.AlertEntry)
+	}
+	return out, nil
+}
+
+// AlertAck acknowledges an active alert. Acknowledged alerts are not sent to alert plugins until they resolve.
+func (a *WebRPC) AlertAck(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAlertsWrite); err != nil {
+		return err
+	}
+
+	n, err := a.deps.DB.Exec(ctx, `UPDATE alert_history SET acked_at = current_timestamp WHERE id = $1 AND resolved_at IS NULL`, id)
+	if err != nil {
+		return xerrors.Errorf("acknowledging alert: %w", err)
+	}
+	if n != 1 {
+		return xerrors.Errorf("alert %d is not active", id)
+	}
+	return nil
+}
+
+// AlertSilence stops sending an alert to alert plugins for the given duration (e.g. "4h").
+func (a *WebRPC) AlertSilence(ctx context.Context, name string, duration string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAlertsWrite); err != nil {
+		return err
+	}
+
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return xerrors.Errorf("parsing duration: %w", err)
+	}
+	if d <= 0 {
+		return xerrors.Errorf("duration must be positive")
+	}
+
+	_, err = a.deps.DB.Exec(ctx, `INSERT INTO alert_silences (name, silenced_until) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET silenced_until = EXCLUDED.silenced_until, created_at = current_timestamp`, name, time.Now().Add(d))
+	if err != nil {
+		return xerrors.Errorf("silencing alert: %w", err)
+	}
+	return nil
+}
+
+// AlertUnsilence removes an alert silence.
+func (a *WebRPC) AlertUnsilence(ctx context.Context, name string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAlertsWrite); err != nil {
+		return err
+	}
+
+	_, err := a.deps.DB.Exec(ctx, `DELETE FROM alert_silences WHERE name = $1`, name)
+	if err != nil {
+		return xerrors.Errorf("removing alert silence: %w", err)
+	}
+	return nil
+}
+
+// AlertSilences lists active silences.
+func (a *WebRPC) AlertSilences(ctx context.Context) ([]AlertSilence, error) {
+	var silences []AlertSilence
+	err := a.deps.DB.Select(ctx, &silences, `SELECT name, silenced_until, created_at FROM alert_silences
+		WHERE silenced_until > current_timestamp ORDER BY silenced_until`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting alert silences: %w", err)
+	}
+	return silences, nil
+}
@@ -0,0 +1,110 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/tasks/indexing"
+)
+
+type PieceIndexState struct {
+	PieceCID  string     `db:"piece_cid"`
+	State     string     `db:"state"`
+	TaskID    *int64     `db:"task_id"`
+	Blocks    int64      `db:"blocks"`
+	Error     *string    `db:"error"`
+	CreatedAt time.Time  `db:"created_at"`
+	IndexedAt *time.Time `db:"indexed_at"`
+	Total     int        `db:"total" json:"-"`
+}
+
+// PieceIndexStates returns a page of the block indexing state of parked pieces, most recently added first.
+func (a *WebRPC) PieceIndexStates(ctx context.Context, req PageRequest) (*Page[PieceIndexState], error) {
+	req = req.normalize()
+	if req.Sort != "" {
+		return nil, xerrors.Errorf("piece index states can't be sorted")
+	}
+
+	var states []PieceIndexState
+	err := a.deps.DB.Select(ctx, &states, `SELECT piece_cid, state, task_id, blocks, error, created_at, indexed_at, COUNT(*) OVER () AS total
+		FROM piece_index_state ORDER BY created_at DESC LIMIT $1 OFFSET $2`, req.Limit, req.Offset)
+	if err != nil {
+		return nil, xerrors.Errorf("getting piece index states: %w", err)
+	}
+
+	out := &Page[PieceIndexState]{Items: []PieceIndexState{}}
+	for _, s := range states {
+		out.Total = s.Total
+		out.Items = append(out.Items, s)
+	}
+	return out, nil
+}
+
+type BlockLocation struct {
+	PieceCID string
+	Offset   uint64
+	Size     uint64
+
+	Parked  bool
+	Sectors []BlockSector
+}
+
+type BlockSector struct {
+	Miner        string
+	SectorNumber uint64
+
+	// Offset of the block in the sector, in unpadded bytes
+	Offset uint64
+}
+
+// BlockSearch returns the pieces holding a block, from the block index, and the sectors holding those pieces.
+func (a *WebRPC) BlockSearch(ctx context.Context, blockCid string) ([]BlockLocation, error) {
+	c, err := cid.Parse(blockCid)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing CID: %w", err)
+	}
+
+	locs, err := indexing.FindBlock(ctx, a.deps.DB, c)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []BlockLocation{}
+	for _, loc := range locs {
+		bl := BlockLocation{
+			PieceCID: loc.PieceCID,
+			Offset:   loc.Offset,
+			Size:     loc.Size,
+			Sectors:  []BlockSector{},
+		}
+
+		err := a.deps.DB.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM parked_pieces WHERE piece_cid = $1 AND complete = TRUE)`, loc.PieceCID).Scan(&bl.Parked)
+		if err != nil {
+			return nil, xerrors.Errorf("checking parked piece: %w", err)
+		}
+
+		sectors, err := indexing.PieceSectors(ctx, a.deps.DB, loc.PieceCID)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sectors {
+			maddr, err := address.NewIDAddress(uint64(s.Sector.ID.Miner))
+			if err != nil {
+				return nil, err
+			}
+			bl.Sectors = append(bl.Sectors, BlockSector{
+				Miner:        maddr.String(),
+				SectorNumber: uint64(s.Sector.ID.Number),
+				Offset:       uint64(s.Offset) + loc.Offset,
+			})
+		}
+
+		out = append(out, bl)
+	}
+	return out, nil
+}
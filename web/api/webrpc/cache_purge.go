@@ -0,0 +1,91 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type CachePurgeReport struct {
+	Part string `db:"part"`
+
+	PurgedCount   int64 `db:"purged_count"`
+	PurgedBytes   int64 `db:"purged_bytes"`
+	Purged7dBytes int64 `db:"purged_7d_bytes"`
+
+	PendingCount int64      `db:"pending_count"`
+	PendingBytes int64      `db:"pending_bytes"`
+	AwaitCommit  int64      `db:"await_commit"` // pending until the commit message lands
+	NextDue      *time.Time `db:"next_due"`
+
+	PurgedStr   string `db:"-"`
+	Purged7dStr string `db:"-"`
+	PendingStr  string `db:"-"`
+}
+
+// CachePurgeReport summarizes, per sealing intermediate, the space reclaimed by cache purging and the space still
+// held by parts the Seal.CachePurge config keeps.
+func (a *WebRPC) CachePurgeReport(ctx context.Context) ([]CachePurgeReport, error) {
+	var report []CachePurgeReport
+	err := a.deps.DB.Select(ctx, &report, `SELECT part,
+			COUNT(*) FILTER (WHERE purged_at IS NOT NULL) AS purged_count,
+			COALESCE(SUM(bytes) FILTER (WHERE purged_at IS NOT NULL), 0) AS purged_bytes,
+			COALESCE(SUM(bytes) FILTER (WHERE purged_at > current_timestamp - INTERVAL '7 days'), 0) AS purged_7d_bytes,
+			COUNT(*) FILTER (WHERE purged_at IS NULL) AS pending_count,
+			COALESCE(SUM(bytes) FILTER (WHERE purged_at IS NULL), 0) AS pending_bytes,
+			COUNT(*) FILTER (WHERE purged_at IS NULL AND due_at IS NULL) AS await_commit,
+			MIN(due_at) FILTER (WHERE purged_at IS NULL) AS next_due
+		FROM sector_cache_purges GROUP BY part ORDER BY part`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting cache purge report: %w", err)
+	}
+
+	for i := range report {
+		report[i].PurgedStr = types.SizeStr(types.NewInt(uint64(report[i].PurgedBytes)))
+		report[i].Purged7dStr = types.SizeStr(types.NewInt(uint64(report[i].Purged7dBytes)))
+		report[i].PendingStr = types.SizeStr(types.NewInt(uint64(report[i].PendingBytes)))
+	}
+	return report, nil
+}
+
+type CachePurgePending struct {
+	SpID         int64      `db:"sp_id"`
+	SectorNumber int64      `db:"sector_number"`
+	Part         string     `db:"part"`
+	Bytes        int64      `db:"bytes"`
+	DueAt        *time.Time `db:"due_at"`
+	TaskID       *int64     `db:"task_id"`
+
+	Miner    string `db:"-"`
+	BytesStr string `db:"-"`
+}
+
+// CachePurgePending lists the sealing intermediates kept in storage which are yet to be purged, soonest due first.
+func (a *WebRPC) CachePurgePending(ctx context.Context, limit int) ([]CachePurgePending, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var pending []CachePurgePending
+	err := a.deps.DB.Select(ctx, &pending, `SELECT sp_id, sector_number, part, bytes, due_at, task_id
+		FROM sector_cache_purges WHERE purged_at IS NULL
+		ORDER BY due_at NULLS LAST, sp_id, sector_number, part LIMIT $1`, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("getting pending cache purges: %w", err)
+	}
+
+	for i := range pending {
+		maddr, err := address.NewIDAddress(uint64(pending[i].SpID))
+		if err != nil {
+			return nil, err
+		}
+		pending[i].Miner = maddr.String()
+		pending[i].BytesStr = types.SizeStr(types.NewInt(uint64(pending[i].Bytes)))
+	}
+	return pending, nil
+}
@@ -0,0 +1,26 @@
+package webrpc
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/market/clientlimit"
+)
+
+// ClientIngestUsage lists what each client with deals over the last 30 days counts against its Ingest.ClientLimits.
+func (a *WebRPC) ClientIngestUsage(ctx context.Context) ([]clientlimit.Usage, error) {
+	head, err := a.deps.Chain.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	usage, err := clientlimit.ClientUsage(ctx, a.deps.DB, head.Height())
+	if err != nil {
+		return nil, err
+	}
+	if usage == nil {
+		usage = []clientlimit.Usage{}
+	}
+	return usage, nil
+}
@@ -2,13 +2,21 @@ package webrpc
 
 import (
 	"context"
+	"encoding/json"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/samber/lo"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/lib/hugepageutil"
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/web/api/apiauth"
 )
 
 type MachineSummary struct {
@@ -23,6 +31,9 @@ type MachineSummary struct {
 	Gpu          int
 	Layers       string
 	Uptime       string
+
+	Unschedulable    bool
+	RestartRequested bool
 }
 
 func (a *WebRPC) ClusterMachines(ctx context.Context) ([]MachineSummary, error) {
@@ -38,7 +49,9 @@ func (a *WebRPC) ClusterMachines(ctx context.Context) ([]MachineSummary, error)
 							hmd.machine_name,
 							hmd.tasks,
 							hmd.layers,
-							hmd.startup_time
+							hmd.startup_time,
+							hm.unschedulable,
+							hm.restart_request IS NOT NULL AS restart_requested
 						FROM 
 							harmony_machines hm
 						LEFT JOIN 
@@ -57,7 +70,7 @@ func (a *WebRPC) ClusterMachines(ctx context.Context) ([]MachineSummary, error)
 		var ram int64
 		var uptime time.Time
 
-		if err := rows.Scan(&m.ID, &m.Address, &lastContact, &m.Cpu, &ram, &m.Gpu, &m.Name, &m.Tasks, &m.Layers, &uptime); err != nil {
+		if err := rows.Scan(&m.ID, &m.Address, &lastContact, &m.Cpu, &ram, &m.Gpu, &m.Name, &m.Tasks, &m.Layers, &uptime, &m.Unschedulable, &m.RestartRequested); err != nil {
 			return nil, err // Handle error
 		}
 		m.SinceContact = lastContact.Round(time.Second).String()
@@ -71,6 +84,15 @@ func (a *WebRPC) ClusterMachines(ctx context.Context) ([]MachineSummary, error)
 	return summaries, nil
 }
 
+// ClusterMachinesPage returns a page of ClusterMachines, sortable by any MachineSummary field.
+func (a *WebRPC) ClusterMachinesPage(ctx context.Context, req PageRequest) (*Page[MachineSummary], error) {
+	machines, err := a.ClusterMachines(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pageOf(machines, req)
+}
+
 type TaskHistorySummary struct {
 	Name   string
 	TaskID int64
@@ -100,23 +122,79 @@ func (a *WebRPC) ClusterTaskHistory(ctx context.Context) ([]TaskHistorySummary,
 			return nil, err // Handle error
 		}
 
-		t.Posted = posted.Local().Round(time.Second).Format("02 Jan 06 15:04")
-		t.Start = start.Local().Round(time.Second).Format("02 Jan 06 15:04")
-		//t.End = end.Local().Round(time.Second).Format("02 Jan 06 15:04")
+		t.setTimes(posted, start, end)
+		summaries = append(summaries, t)
+	}
+	return summaries, nil
+}
 
-		t.Queued = start.Sub(posted).Round(time.Second).String()
-		if t.Queued == "0s" {
-			t.Queued = start.Sub(posted).Round(time.Millisecond).String()
-		}
+// ClusterTaskHistoryPage returns a page of the task history. Sortable by Name, Posted, Start, End (default,
+// newest first) and Result.
+func (a *WebRPC) ClusterTaskHistoryPage(ctx context.Context, req PageRequest) (*Page[TaskHistorySummary], error) {
+	req = req.normalize()
+	if err := req.checkSort("Name", "Posted", "Start", "End", "Result"); err != nil {
+		return nil, err
+	}
+
+	// without an explicit sort the newest tasks come first, Desc reverses that
+	desc := req.Desc
+	if req.Sort == "" {
+		desc = !desc
+	}
 
-		t.Took = end.Sub(start).Round(time.Second).String()
-		if t.Took == "0s" {
-			t.Took = end.Sub(start).Round(time.Millisecond).String()
+	rows, err := a.deps.DB.Query(ctx, `SELECT task_id, name, posted, work_start, work_end, result, err, completed_by_host_and_port,
+			COUNT(*) OVER () AS total
+		FROM harmony_task_history
+		ORDER BY
+			CASE WHEN $1 = 'name' AND NOT $2 THEN name END ASC,
+			CASE WHEN $1 = 'name' AND $2 THEN name END DESC,
+			CASE WHEN $1 = 'posted' AND NOT $2 THEN posted END ASC,
+			CASE WHEN $1 = 'posted' AND $2 THEN posted END DESC,
+			CASE WHEN $1 = 'start' AND NOT $2 THEN work_start END ASC,
+			CASE WHEN $1 = 'start' AND $2 THEN work_start END DESC,
+			CASE WHEN $1 = 'result' AND NOT $2 THEN result END ASC,
+			CASE WHEN $1 = 'result' AND $2 THEN result END DESC,
+			CASE WHEN NOT $2 THEN work_end END ASC,
+			work_end DESC
+		LIMIT $3 OFFSET $4`, req.sqlSort(), desc, req.Limit, req.Offset)
+	if err != nil {
+		return nil, xerrors.Errorf("querying task history: %w", err)
+	}
+	defer rows.Close()
+
+	out := &Page[TaskHistorySummary]{Items: []TaskHistorySummary{}}
+	for rows.Next() {
+		var t TaskHistorySummary
+		var posted, start, end time.Time
+
+		if err := rows.Scan(&t.TaskID, &t.Name, &posted, &start, &end, &t.Result, &t.Err, &t.CompletedBy, &out.Total); err != nil {
+			return nil, xerrors.Errorf("scanning task history: %w", err)
 		}
 
-		summaries = append(summaries, t)
+		t.setTimes(posted, start, end)
+		out.Items = append(out.Items, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("iterating task history: %w", err)
+	}
+
+	return out, nil
+}
+
+func (t *TaskHistorySummary) setTimes(posted, start, end time.Time) {
+	t.Posted = posted.Local().Round(time.Second).Format("02 Jan 06 15:04")
+	t.Start = start.Local().Round(time.Second).Format("02 Jan 06 15:04")
+	//t.End = end.Local().Round(time.Second).Format("02 Jan 06 15:04")
+
+	t.Queued = start.Sub(posted).Round(time.Second).String()
+	if t.Queued == "0s" {
+		t.Queued = start.Sub(posted).Round(time.Millisecond).String()
+	}
+
+	t.Took = end.Sub(start).Round(time.Second).String()
+	if t.Took == "0s" {
+		t.Took = end.Sub(start).Round(time.Millisecond).String()
 	}
-	return summaries, nil
 }
 
 type MachineInfo struct {
@@ -129,8 +207,14 @@ type MachineInfo struct {
 		Memory      int64
 		GPU         int64
 		Layers      string
+
+		Unschedulable    bool
+		RestartRequested bool
 	}
 
+	// Memory is the huge page and NUMA setup of the machine, nil before it reports it
+	Memory *hugepageutil.MemoryStatus
+
 	// Storage
 	Storage []struct {
 		ID            string
@@ -149,6 +233,7 @@ type MachineInfo struct {
 		Used          int64
 		AllowMiners   string
 		DenyMiners    string
+		Tier          string
 		LastHeartbeat time.Time
 		HeartbeatErr  *string
 
@@ -199,7 +284,10 @@ func (a *WebRPC) ClusterNodeInfo(ctx context.Context, id int64) (*MachineInfo, e
 							hm.ram,
 							hm.gpu,
 							hmd.machine_name,
-							hmd.layers
+							hmd.layers,
+							hm.unschedulable,
+							hm.restart_request IS NOT NULL AS restart_requested,
+							hmd.memory_status
 						FROM 
 							harmony_machines hm
 						LEFT JOIN 
@@ -218,11 +306,19 @@ func (a *WebRPC) ClusterNodeInfo(ctx context.Context, id int64) (*MachineInfo, e
 	if rows.Next() {
 		var m MachineInfo
 		var lastContact time.Time
+		var memStatus []byte
 
-		if err := rows.Scan(&m.Info.ID, &m.Info.Host, &lastContact, &m.Info.CPU, &m.Info.Memory, &m.Info.GPU, &m.Info.Name, &m.Info.Layers); err != nil {
+		if err := rows.Scan(&m.Info.ID, &m.Info.Host, &lastContact, &m.Info.CPU, &m.Info.Memory, &m.Info.GPU, &m.Info.Name, &m.Info.Layers, &m.Info.Unschedulable, &m.Info.RestartRequested, &memStatus); err != nil {
 			return nil, err
 		}
 
+		if len(memStatus) > 0 {
+			m.Memory = new(hugepageutil.MemoryStatus)
+			if err := json.Unmarshal(memStatus, m.Memory); err != nil {
+				return nil, xerrors.Errorf("unmarshaling memory status: %w", err)
+			}
+		}
+
 		m.Info.LastContact = time.Since(lastContact).Round(time.Second).String()
 
 		summaries = append(summaries, m)
@@ -233,7 +329,7 @@ func (a *WebRPC) ClusterNodeInfo(ctx context.Context, id int64) (*MachineInfo, e
 	}
 
 	// query storage info
-	rows2, err := a.deps.DB.Query(ctx, "SELECT storage_id, weight, max_storage, can_seal, can_store, groups, allow_to, allow_types, deny_types, capacity, available, fs_available, reserved, used, allow_miners, deny_miners, last_heartbeat, heartbeat_err FROM storage_path WHERE urls LIKE '%' || $1 || '%'", summaries[0].Info.Host)
+	rows2, err := a.deps.DB.Query(ctx, "SELECT storage_id, weight, max_storage, can_seal, can_store, groups, allow_to, allow_types, deny_types, capacity, available, fs_available, reserved, used, allow_miners, deny_miners, tier, last_heartbeat, heartbeat_err FROM storage_path WHERE urls LIKE '%' || $1 || '%'", summaries[0].Info.Host)
 	if err != nil {
 		return nil, err
 	}
@@ -258,13 +354,14 @@ func (a *WebRPC) ClusterNodeInfo(ctx context.Context, id int64) (*MachineInfo, e
 			Used          int64
 			AllowMiners   string
 			DenyMiners    string
+			Tier          string
 			LastHeartbeat time.Time
 			HeartbeatErr  *string
 
 			UsedPercent     float64
 			ReservedPercent float64
 		}
-		if err := rows2.Scan(&s.ID, &s.Weight, &s.MaxStorage, &s.CanSeal, &s.CanStore, &s.Groups, &s.AllowTo, &s.AllowTypes, &s.DenyTypes, &s.Capacity, &s.Available, &s.FSAvailable, &s.Reserved, &s.Used, &s.AllowMiners, &s.DenyMiners, &s.LastHeartbeat, &s.HeartbeatErr); err != nil {
+		if err := rows2.Scan(&s.ID, &s.Weight, &s.MaxStorage, &s.CanSeal, &s.CanStore, &s.Groups, &s.AllowTo, &s.AllowTypes, &s.DenyTypes, &s.Capacity, &s.Available, &s.FSAvailable, &s.Reserved, &s.Used, &s.AllowMiners, &s.DenyMiners, &s.Tier, &s.LastHeartbeat, &s.HeartbeatErr); err != nil {
 			return nil, err
 		}
 
@@ -379,3 +476,138 @@ func (a *WebRPC) ClusterNodeInfo(ctx context.Context, id int64) (*MachineInfo, e
 
 	return &summaries[0], nil
 }
+
+// ClusterMachineCordon stops the machine from accepting new tasks. Running tasks are allowed to finish.
+func (a *WebRPC) ClusterMachineCordon(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+	return a.setMachineUnschedulable(ctx, id, true)
+}
+
+// ClusterMachineUncordon allows the machine to accept new tasks again.
+func (a *WebRPC) ClusterMachineUncordon(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+	return a.setMachineUnschedulable(ctx, id, false)
+}
+
+func (a *WebRPC) setMachineUnschedulable(ctx context.Context, id int64, unschedulable bool) error {
+	n, err := a.deps.DB.Exec(ctx, `UPDATE harmony_machines SET unschedulable = $2 WHERE id = $1`, id, unschedulable)
+	if err != nil {
+		return xerrors.Errorf("updating machine: %w", err)
+	}
+	if n != 1 {
+		return xerrors.Errorf("machine %d not found", id)
+	}
+	return nil
+}
+
+// ClusterMachineRestart requests a graceful restart of the machine. The machine stops accepting
+// new tasks, waits for running tasks to finish and then exits, relying on the service manager
+// (e.g. systemd with Restart=always) to start it again. The request is cleared when the machine
+// registers again on startup.
+func (a *WebRPC) ClusterMachineRestart(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+	n, err := a.deps.DB.Exec(ctx, `UPDATE harmony_machines SET restart_request = CURRENT_TIMESTAMP WHERE id = $1 AND restart_request IS NULL`, id)
+	if err != nil {
+		return xerrors.Errorf("requesting restart: %w", err)
+	}
+	if n != 1 {
+		return xerrors.Errorf("machine %d not found or restart already requested", id)
+	}
+	return nil
+}
+
+// ClusterMachineAbortRestart cancels a pending restart request.
+func (a *WebRPC) ClusterMachineAbortRestart(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+	_, err := a.deps.DB.Exec(ctx, `UPDATE harmony_machines SET restart_request = NULL WHERE id = $1`, id)
+	return err
+}
+
+// ClusterMachineDecommission removes a machine from the cluster. The machine must be cordoned and
+// must not own any tasks. Storage URLs served by the machine are removed from the storage index;
+// paths which were only reachable through this machine are dropped together with their sector
+// locations. Note that a machine which is still running will register itself again on next start.
+func (a *WebRPC) ClusterMachineDecommission(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+	var host string
+	var unschedulable bool
+	err := a.deps.DB.QueryRow(ctx, `SELECT host_and_port, unschedulable FROM harmony_machines WHERE id = $1`, id).Scan(&host, &unschedulable)
+	if err != nil {
+		return xerrors.Errorf("getting machine %d: %w", id, err)
+	}
+	if !unschedulable {
+		return xerrors.Errorf("machine %d must be cordoned before it can be decommissioned", id)
+	}
+
+	comm, err := a.deps.DB.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		var owned int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM harmony_task WHERE owner_id = $1`, id).Scan(&owned); err != nil {
+			return false, xerrors.Errorf("counting owned tasks: %w", err)
+		}
+		if owned > 0 {
+			return false, xerrors.Errorf("machine %d still owns %d tasks", id, owned)
+		}
+
+		var storagePaths []struct {
+			StorageID string `db:"storage_id"`
+			Urls      string `db:"urls"`
+		}
+		if err := tx.Select(&storagePaths, `SELECT storage_id, urls FROM storage_path`); err != nil {
+			return false, xerrors.Errorf("getting storage paths: %w", err)
+		}
+
+		for _, sp := range storagePaths {
+			urls := strings.Split(sp.Urls, paths.URLSeparator)
+			keep := lo.Reject(urls, func(u string, _ int) bool {
+				pu, err := url.Parse(u)
+				return err == nil && pu.Host == host
+			})
+			if len(keep) == len(urls) {
+				continue
+			}
+
+			if len(keep) == 0 {
+				if _, err := tx.Exec(`DELETE FROM sector_location WHERE storage_id = $1`, sp.StorageID); err != nil {
+					return false, xerrors.Errorf("deleting sector locations: %w", err)
+				}
+				if _, err := tx.Exec(`DELETE FROM storage_path WHERE storage_id = $1`, sp.StorageID); err != nil {
+					return false, xerrors.Errorf("deleting storage path: %w", err)
+				}
+				continue
+			}
+
+			if _, err := tx.Exec(`UPDATE storage_path SET urls = $1 WHERE storage_id = $2`, strings.Join(keep, paths.URLSeparator), sp.StorageID); err != nil {
+				return false, xerrors.Errorf("updating storage path urls: %w", err)
+			}
+			if _, err := tx.Exec(`DELETE FROM sector_path_url_liveness WHERE storage_id = $1 AND url = ANY($2)`, sp.StorageID, lo.Without(urls, keep...)); err != nil {
+				return false, xerrors.Errorf("deleting url liveness: %w", err)
+			}
+		}
+
+		// harmony_machine_details references harmony_machines with ON DELETE CASCADE
+		if _, err := tx.Exec(`DELETE FROM harmony_machines WHERE id = $1`, id); err != nil {
+			return false, xerrors.Errorf("deleting machine: %w", err)
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return xerrors.Errorf("decommissioning machine %d: %w", id, err)
+	}
+	if !comm {
+		return xerrors.Errorf("decommissioning machine %d: transaction didn't commit", id)
+	}
+
+	log.Infow("Decommissioned machine", "id", id, "host", host)
+	return nil
+}
@@ -0,0 +1,94 @@
+package webrpc
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	verifregtypes "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+
+	"github.com/filecoin-project/curio/tasks/ddo"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+// DDOClientAllocations lists the verified allocations of a client, marking the ones made to miners of this cluster.
+func (a *WebRPC) DDOClientAllocations(ctx context.Context, client string) ([]ddo.ClientAllocation, error) {
+	caddr, err := address.NewFromString(client)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing client address: %w", err)
+	}
+
+	ours, err := a.clusterMiners()
+	if err != nil {
+		return nil, err
+	}
+
+	allocs, err := ddo.ClientAllocations(ctx, a.deps.DB, a.deps.Chain, caddr, ours)
+	if err != nil {
+		return nil, err
+	}
+	if allocs == nil {
+		allocs = []ddo.ClientAllocation{}
+	}
+	return allocs, nil
+}
+
+// DDOClaimAllocation starts onboarding an allocation of a client, which is added to a sector once its piece is
+// parked.
+func (a *WebRPC) DDOClaimAllocation(ctx context.Context, client string, allocationID uint64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	caddr, err := address.NewFromString(client)
+	if err != nil {
+		return xerrors.Errorf("parsing client address: %w", err)
+	}
+
+	ours, err := a.clusterMiners()
+	if err != nil {
+		return err
+	}
+
+	return ddo.RequestClaim(ctx, a.deps.DB, a.deps.Chain, caddr, verifregtypes.AllocationId(allocationID), ours)
+}
+
+// DDOTrackedAllocations lists the allocations onboarded by the cluster.
+func (a *WebRPC) DDOTrackedAllocations(ctx context.Context) ([]ddo.TrackedAllocation, error) {
+	allocs, err := ddo.TrackedAllocations(ctx, a.deps.DB)
+	if err != nil {
+		return nil, err
+	}
+	if allocs == nil {
+		allocs = []ddo.TrackedAllocation{}
+	}
+	return allocs, nil
+}
+
+// clusterMiners returns the miners configured in any config layer.
+func (a *WebRPC) clusterMiners() (map[abi.ActorID]bool, error) {
+	out := map[abi.ActorID]bool{}
+
+	err := forEachConfig(a, func(name string, info minimalActorInfo) error {
+		for _, aset := range info.Addresses {
+			for _, addr := range aset.MinerAddresses {
+				maddr, err := address.NewFromString(addr)
+				if err != nil {
+					return xerrors.Errorf("parsing address: %w", err)
+				}
+				id, err := address.IDFromAddress(maddr)
+				if err != nil {
+					return xerrors.Errorf("getting miner ID: %w", err)
+				}
+				out[abi.ActorID(id)] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
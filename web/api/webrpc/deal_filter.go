@@ -0,0 +1,62 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type DealFilterDecision struct {
+	ID         int64     `db:"id"`
+	SpID       int64     `db:"sp_id"`
+	PieceCID   string    `db:"piece_cid"`
+	PieceSize  int64     `db:"piece_size"`
+	DealID     *int64    `db:"deal_id"`
+	Client     *string   `db:"client"`
+	StartEpoch int64     `db:"start_epoch"`
+	EndEpoch   int64     `db:"end_epoch"`
+	Accepted   bool      `db:"accepted"`
+	Filter     string    `db:"filter"`
+	Reason     string    `db:"reason"`
+	CreatedAt  time.Time `db:"created_at"`
+	Total      int       `db:"total" json:"-"`
+
+	Miner        string
+	PieceSizeStr string
+}
+
+// DealFilterDecisionsPage returns a page of the decisions of the deal filter on deals from market adapters, newest
+// first.
+func (a *WebRPC) DealFilterDecisionsPage(ctx context.Context, req PageRequest) (*Page[DealFilterDecision], error) {
+	req = req.normalize()
+	if req.Sort != "" {
+		return nil, xerrors.Errorf("deal filter decisions can't be sorted")
+	}
+
+	var decisions []DealFilterDecision
+	err := a.deps.DB.Select(ctx, &decisions, `SELECT id, sp_id, piece_cid, piece_size, deal_id, client, start_epoch, end_epoch,
+			accepted, filter, reason, created_at, COUNT(*) OVER () AS total
+		FROM market_deal_filter_decisions ORDER BY id DESC LIMIT $1 OFFSET $2`, req.Limit, req.Offset)
+	if err != nil {
+		return nil, xerrors.Errorf("getting deal filter decisions: %w", err)
+	}
+
+	out := &Page[DealFilterDecision]{Items: []DealFilterDecision{}}
+	for _, d := range decisions {
+		maddr, err := address.NewIDAddress(uint64(d.SpID))
+		if err != nil {
+			return nil, err
+		}
+		d.Miner = maddr.String()
+		d.PieceSizeStr = types.SizeStr(types.NewInt(uint64(d.PieceSize)))
+
+		out.Total = d.Total
+		out.Items = append(out.Items, d)
+	}
+	return out, nil
+}
@@ -0,0 +1,63 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type DealProposalValidation struct {
+	ProposalID  int64      `db:"proposal_id"`
+	SpID        int64      `db:"sp_id"`
+	PieceCID    string     `db:"piece_cid"`
+	PieceSize   int64      `db:"piece_size"`
+	DealID      *int64     `db:"deal_id"`
+	Client      *string    `db:"client"`
+	ClientID    *int64     `db:"client_id"`
+	Verified    bool       `db:"verified"`
+	Valid       *bool      `db:"valid"`
+	Reason      *string    `db:"reason"`
+	CreateTime  time.Time  `db:"create_time"`
+	ValidatedAt *time.Time `db:"validated_at"`
+	TaskID      *int64     `db:"task_id"`
+	Total       int        `db:"total" json:"-"`
+
+	Miner        string
+	PieceSizeStr string
+}
+
+// DealProposalsPage returns a page of the deal proposals validated for market adapters, newest first. Proposals
+// waiting for a DealValidate task have no result yet.
+func (a *WebRPC) DealProposalsPage(ctx context.Context, req PageRequest) (*Page[DealProposalValidation], error) {
+	req = req.normalize()
+	if req.Sort != "" {
+		return nil, xerrors.Errorf("deal proposals can't be sorted")
+	}
+
+	var proposals []DealProposalValidation
+	err := a.deps.DB.Select(ctx, &proposals, `SELECT proposal_id, sp_id, piece_cid, piece_size, deal_id, client, client_id, verified,
+			valid, reason, create_time, validated_at, task_id, COUNT(*) OVER () AS total
+		FROM market_deal_proposals ORDER BY proposal_id DESC LIMIT $1 OFFSET $2`, req.Limit, req.Offset)
+	if err != nil {
+		return nil, xerrors.Errorf("getting deal proposals: %w", err)
+	}
+
+	out := &Page[DealProposalValidation]{Items: []DealProposalValidation{}}
+	for _, p := range proposals {
+		maddr, err := address.NewIDAddress(uint64(p.SpID))
+		if err != nil {
+			return nil, err
+		}
+		p.Miner = maddr.String()
+		p.PieceSizeStr = types.SizeStr(types.NewInt(uint64(p.PieceSize)))
+
+		out.Total = p.Total
+		out.Items = append(out.Items, p)
+	}
+	return out, nil
+}
@@ -0,0 +1,37 @@
+package webrpc
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/market/mk12"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+// DealRenewals lists the renewal offers of deals of a miner which are about to end, with the unsigned proposal
+// offered to the client, and the renewal deals clients proposed with their progress.
+func (a *WebRPC) DealRenewals(ctx context.Context, miner string) ([]mk12.Renewal, error) {
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	return mk12.Renewals(ctx, a.deps.DB, maddr)
+}
+
+// DealRenewalDecline withdraws a renewal offer, it isn't offered again for the same deal.
+func (a *WebRPC) DealRenewalDecline(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	if err := mk12.DeclineRenewal(ctx, a.deps.DB, id); err != nil {
+		return err
+	}
+
+	log.Infow("deal renewal offer declined", "id", id)
+	return nil
+}
@@ -0,0 +1,274 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Stages of the deal pipeline, in order
+const (
+	DealStageReceived  = "received"
+	DealStageIngested  = "ingested"
+	DealStageSealed    = "sealed"
+	DealStageActivated = "activated"
+	DealStageIndexed   = "indexed"
+)
+
+var dealStages = []string{DealStageReceived, DealStageIngested, DealStageSealed, DealStageActivated, DealStageIndexed}
+
+// dealFailedStages maps the failed_stage of market_deal_stages to the stage which wasn't reached
+var dealFailedStages = map[string]string{
+	"ingest":   DealStageIngested,
+	"seal":     DealStageSealed,
+	"activate": DealStageActivated,
+}
+
+// DealStage is a stage of the deal pipeline of a deal. At is nil for stages the deal didn't reach yet. A failed
+// stage is the stage the deal was failing to reach, with the reason of the failure.
+type DealStage struct {
+	Stage  string
+	At     *time.Time
+	Failed bool
+	Reason string
+}
+
+type DealStages struct {
+	SpID         int64
+	SectorNumber int64
+	PieceIndex   int64
+	PieceCID     string
+	PieceSize    int64
+	DealID       *int64
+	IsSnap       bool
+
+	Miner        string
+	PieceSizeStr string
+
+	Stages []DealStage
+
+	// FailedStage is the stage a failed deal didn't reach, empty for deals which didn't fail
+	FailedStage string
+}
+
+type dealStagesRow struct {
+	SpID         int64  `db:"sp_id"`
+	SectorNumber int64  `db:"sector_number"`
+	PieceIndex   int64  `db:"piece_index"`
+	PieceCID     string `db:"piece_cid"`
+	PieceSize    int64  `db:"piece_size"`
+	DealID       *int64 `db:"deal_id"`
+	IsSnap       bool   `db:"is_snap"`
+
+	ReceivedAt  time.Time  `db:"received_at"`
+	IngestedAt  *time.Time `db:"ingested_at"`
+	SealedAt    *time.Time `db:"sealed_at"`
+	ActivatedAt *time.Time `db:"activated_at"`
+
+	FailedAt     *time.Time `db:"failed_at"`
+	FailedStage  *string    `db:"failed_stage"`
+	FailedReason *string    `db:"failed_reason"`
+
+	IndexState *string    `db:"index_state"`
+	IndexedAt  *time.Time `db:"indexed_at"`
+	IndexError *string    `db:"index_error"`
+
+	Total int `db:"total"`
+}
+
+const dealStagesQuery = `SELECT d.sp_id, d.sector_number, d.piece_index, d.piece_cid, d.piece_size, d.deal_id, d.is_snap,
+		d.received_at, d.ingested_at, d.sealed_at, d.activated_at, d.failed_at, d.failed_stage, d.failed_reason,
+		i.state AS index_state, i.indexed_at, i.error AS index_error, COUNT(*) OVER () AS total
+	FROM market_deal_stages d
+	LEFT JOIN piece_index_state i ON i.piece_cid = d.piece_cid`
+
+func (r *dealStagesRow) stages() (DealStages, error) {
+	maddr, err := address.NewIDAddress(uint64(r.SpID))
+	if err != nil {
+		return DealStages{}, err
+	}
+
+	ds := DealStages{
+		SpID:         r.SpID,
+		SectorNumber: r.SectorNumber,
+		PieceIndex:   r.PieceIndex,
+		PieceCID:     r.PieceCID,
+		PieceSize:    r.PieceSize,
+		DealID:       r.DealID,
+		IsSnap:       r.IsSnap,
+		Miner:        maddr.String(),
+		PieceSizeStr: types.SizeStr(types.NewInt(uint64(r.PieceSize))),
+	}
+
+	// pieces which aren't CARs have nothing to index, they are done once that's known
+	var indexedAt *time.Time
+	var indexNote string
+	if r.IndexState != nil && (*r.IndexState == "indexed" || *r.IndexState == "not-car") {
+		indexedAt = r.IndexedAt
+		if *r.IndexState == "not-car" {
+			indexNote = "piece is not a CAR"
+		}
+	}
+
+	received := r.ReceivedAt
+	for i, at := range []*time.Time{&received, r.IngestedAt, r.SealedAt, r.ActivatedAt, indexedAt} {
+		ds.Stages = append(ds.Stages, DealStage{Stage: dealStages[i], At: at})
+	}
+	ds.Stages[len(ds.Stages)-1].Reason = indexNote
+
+	switch {
+	case r.FailedAt != nil && r.FailedStage != nil:
+		ds.FailedStage = dealFailedStages[*r.FailedStage]
+		for i := range ds.Stages {
+			if ds.Stages[i].Stage == ds.FailedStage {
+				ds.Stages[i].At = r.FailedAt
+				ds.Stages[i].Failed = true
+				if r.FailedReason != nil {
+					ds.Stages[i].Reason = *r.FailedReason
+				}
+			}
+		}
+	case r.IndexState != nil && *r.IndexState == "failed":
+		ds.FailedStage = DealStageIndexed
+		last := &ds.Stages[len(ds.Stages)-1]
+		last.Failed = true
+		if r.IndexError != nil {
+			last.Reason = *r.IndexError
+		}
+	}
+
+	return ds, nil
+}
+
+// DealStagesPage returns a page of deals with the times at which they reached each stage of the deal pipeline,
+// most recently received first.
+func (a *WebRPC) DealStagesPage(ctx context.Context, req PageRequest) (*Page[DealStages], error) {
+	req = req.normalize()
+	if req.Sort != "" {
+		return nil, xerrors.Errorf("deal stages can't be sorted")
+	}
+
+	var rows []dealStagesRow
+	err := a.deps.DB.Select(ctx, &rows, dealStagesQuery+` ORDER BY d.received_at DESC LIMIT $1 OFFSET $2`, req.Limit, req.Offset)
+	if err != nil {
+		return nil, xerrors.Errorf("getting deal stages: %w", err)
+	}
+
+	out := &Page[DealStages]{Items: []DealStages{}}
+	for _, r := range rows {
+		ds, err := r.stages()
+		if err != nil {
+			return nil, err
+		}
+		out.Total = r.Total
+		out.Items = append(out.Items, ds)
+	}
+	return out, nil
+}
+
+// DealStagesByPiece returns the pipeline stages of the deals for a piece
+func (a *WebRPC) DealStagesByPiece(ctx context.Context, pieceCid string) ([]DealStages, error) {
+	var rows []dealStagesRow
+	err := a.deps.DB.Select(ctx, &rows, dealStagesQuery+` WHERE d.piece_cid = $1 ORDER BY d.received_at DESC`, pieceCid)
+	if err != nil {
+		return nil, xerrors.Errorf("getting deal stages: %w", err)
+	}
+
+	out := []DealStages{}
+	for _, r := range rows {
+		ds, err := r.stages()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ds)
+	}
+	return out, nil
+}
+
+type DealFunnelStage struct {
+	Stage string
+
+	// Reached is the number of deals which reached the stage, Failed the number which failed to reach it, and
+	// Pending the number still on their way to it
+	Reached int
+	Failed  int
+	Pending int
+
+	// AvgFromPrev is the average time in seconds from the previous stage to this one, over deals which reached it
+	AvgFromPrev float64
+}
+
+type DealFunnel struct {
+	From time.Time
+	To   time.Time
+
+	// Rejected is the number of deals the deal filter rejected, which never enter the pipeline
+	Rejected int
+
+	Stages []DealFunnelStage
+}
+
+// DealFunnel counts the deals received in the last windowHours hours by the furthest stage of the deal pipeline
+// they reached, and where the failed ones died.
+func (a *WebRPC) DealFunnel(ctx context.Context, windowHours int) (*DealFunnel, error) {
+	if windowHours <= 0 || windowHours > 24*90 {
+		windowHours = 24 * 7
+	}
+	to := time.Now()
+	from := to.Add(-time.Duration(windowHours) * time.Hour)
+
+	var rows []dealStagesRow
+	err := a.deps.DB.Select(ctx, &rows, dealStagesQuery+` WHERE d.received_at >= $1`, from)
+	if err != nil {
+		return nil, xerrors.Errorf("getting deal stages: %w", err)
+	}
+
+	out := &DealFunnel{From: from, To: to}
+	err = a.deps.DB.QueryRow(ctx, `SELECT COUNT(*) FROM market_deal_filter_decisions WHERE NOT accepted AND created_at >= $1`, from).Scan(&out.Rejected)
+	if err != nil {
+		return nil, xerrors.Errorf("counting rejected deals: %w", err)
+	}
+
+	out.Stages = make([]DealFunnelStage, len(dealStages))
+	sums := make([]float64, len(dealStages))
+	for i, st := range dealStages {
+		out.Stages[i].Stage = st
+	}
+
+	for _, r := range rows {
+		ds, err := r.stages()
+		if err != nil {
+			return nil, err
+		}
+
+		for i, st := range ds.Stages {
+			switch {
+			case st.Failed:
+				out.Stages[i].Failed++
+			case st.At != nil:
+				out.Stages[i].Reached++
+				if i > 0 && ds.Stages[i-1].At != nil {
+					sums[i] += st.At.Sub(*ds.Stages[i-1].At).Seconds()
+				}
+				continue
+			default:
+				out.Stages[i].Pending++
+			}
+			// later stages can't be reached without this one
+			break
+		}
+	}
+
+	for i := range out.Stages {
+		if i > 0 && out.Stages[i].Reached > 0 {
+			out.Stages[i].AvgFromPrev = sums[i] / float64(out.Stages[i].Reached)
+		}
+	}
+
+	return out, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 
 	"github.com/filecoin-project/curio/market"
+	"github.com/filecoin-project/curio/web/api/apiauth"
 
 	"github.com/filecoin-project/lotus/chain/types"
 )
@@ -46,7 +47,19 @@ func (a *WebRPC) DealsPending(ctx context.Context) ([]OpenDealInfo, error) {
 	return deals, nil
 }
 
+// DealsPendingPage returns a page of DealsPending, sortable by any OpenDealInfo field.
+func (a *WebRPC) DealsPendingPage(ctx context.Context, req PageRequest) (*Page[OpenDealInfo], error) {
+	deals, err := a.DealsPending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pageOf(deals, req)
+}
+
 func (a *WebRPC) DealsSealNow(ctx context.Context, spId, sectorNumber uint64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	maddr, err := address.NewIDAddress(spId)
 	if err != nil {
 		return err
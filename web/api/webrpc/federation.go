@@ -0,0 +1,194 @@
+package webrpc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+
+	"github.com/filecoin-project/curio/deps/config"
+)
+
+const federationTimeout = 15 * time.Second
+
+const localClusterName = "local"
+
+// federatedClient is the subset of the web RPC API of other clusters used by federated views.
+type federatedClient struct {
+	Version              func(ctx context.Context) (string, error)
+	ClusterMachines      func(ctx context.Context) ([]MachineSummary, error)
+	ActorSummary         func(ctx context.Context) ([]ActorSummary, error)
+	PorepPipelineSummary func(ctx context.Context) ([]PorepPipelineSummary, error)
+	AlertsActive         func(ctx context.Context) ([]AlertEntry, error)
+}
+
+type federatedCluster struct {
+	name, url string
+	api       federatedClient
+}
+
+func newFederation(ctx context.Context, clusters []config.FederatedCluster) ([]*federatedCluster, error) {
+	var out []*federatedCluster
+	for _, c := range clusters {
+		if c.Name == "" || c.URL == "" {
+			return nil, xerrors.Errorf("federated cluster %q: name and URL are required", c.Name)
+		}
+
+		hdr := http.Header{}
+		if c.Token != "" {
+			hdr.Set("Authorization", "Bearer "+c.Token)
+		}
+
+		fc := &federatedCluster{name: c.Name, url: c.URL}
+		// HTTP clients don't hold a connection, so the closer doesn't need to be called
+		_, err := jsonrpc.NewMergeClient(ctx, strings.TrimSuffix(c.URL, "/")+"/api/webrpc/v0", "CurioWeb",
+			[]interface{}{&fc.api}, hdr)
+		if err != nil {
+			return nil, xerrors.Errorf("creating client for federated cluster %s: %w", c.Name, err)
+		}
+		out = append(out, fc)
+	}
+	return out, nil
+}
+
+// Clustered is a value of a federated view, tagged with the cluster it comes from.
+type Clustered[T any] struct {
+	Cluster string
+	Value   T
+}
+
+type FederationError struct {
+	Cluster string
+	Error   string
+}
+
+// FederatedView merges values from this cluster and federated clusters. Clusters which couldn't be
+// queried are listed in Errors, their values are missing.
+type FederatedView[T any] struct {
+	Items  []Clustered[T]
+	Errors []FederationError
+}
+
+func (a *WebRPC) clusterName() string {
+	if a.deps.Cfg.Web.ClusterName != "" {
+		return a.deps.Cfg.Web.ClusterName
+	}
+	return localClusterName
+}
+
+// federate queries this cluster and all federated clusters concurrently, preserving cluster order.
+func federate[T any](ctx context.Context, a *WebRPC, local func(context.Context) ([]T, error), remote func(*federatedClient) func(context.Context) ([]T, error)) *FederatedView[T] {
+	type result struct {
+		items []T
+		err   error
+	}
+	results := make([]result, len(a.federation)+1)
+
+	ctx, cancel := context.WithTimeout(ctx, federationTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(len(results))
+	go func() {
+		defer wg.Done()
+		results[0].items, results[0].err = local(ctx)
+	}()
+	for i, c := range a.federation {
+		go func(i int, c *federatedCluster) {
+			defer wg.Done()
+			results[i+1].items, results[i+1].err = remote(&c.api)(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	out := &FederatedView[T]{Items: []Clustered[T]{}}
+	for i, r := range results {
+		name := a.clusterName()
+		if i > 0 {
+			name = a.federation[i-1].name
+		}
+
+		if r.err != nil {
+			log.Warnw("federated query failed", "cluster", name, "error", r.err)
+			out.Errors = append(out.Errors, FederationError{Cluster: name, Error: r.err.Error()})
+			continue
+		}
+		for _, item := range r.items {
+			out.Items = append(out.Items, Clustered[T]{Cluster: name, Value: item})
+		}
+	}
+	return out
+}
+
+type FederatedClusterStatus struct {
+	Name    string
+	URL     string
+	Local   bool
+	Version string
+	Error   string
+}
+
+// FederationClusters returns this cluster and the federated clusters with their reachability.
+func (a *WebRPC) FederationClusters(ctx context.Context) ([]FederatedClusterStatus, error) {
+	out := make([]FederatedClusterStatus, len(a.federation)+1)
+
+	version, err := a.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out[0] = FederatedClusterStatus{Name: a.clusterName(), Local: true, Version: version}
+
+	ctx, cancel := context.WithTimeout(ctx, federationTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i, c := range a.federation {
+		wg.Add(1)
+		go func(i int, c *federatedCluster) {
+			defer wg.Done()
+			st := FederatedClusterStatus{Name: c.name, URL: c.url}
+			var err error
+			st.Version, err = c.api.Version(ctx)
+			if err != nil {
+				st.Error = err.Error()
+			}
+			out[i+1] = st
+		}(i, c)
+	}
+	wg.Wait()
+
+	return out, nil
+}
+
+// FederatedMachines returns machines of all clusters.
+func (a *WebRPC) FederatedMachines(ctx context.Context) (*FederatedView[MachineSummary], error) {
+	return federate(ctx, a, a.ClusterMachines, func(c *federatedClient) func(context.Context) ([]MachineSummary, error) {
+		return c.ClusterMachines
+	}), nil
+}
+
+// FederatedActorSummary returns power, balances and deadlines of the miners of all clusters.
+func (a *WebRPC) FederatedActorSummary(ctx context.Context) (*FederatedView[ActorSummary], error) {
+	return federate(ctx, a, a.ActorSummary, func(c *federatedClient) func(context.Context) ([]ActorSummary, error) {
+		return c.ActorSummary
+	}), nil
+}
+
+// FederatedPipelineSummary returns per-miner sealing pipeline sector counts of all clusters.
+func (a *WebRPC) FederatedPipelineSummary(ctx context.Context) (*FederatedView[PorepPipelineSummary], error) {
+	return federate(ctx, a, a.PorepPipelineSummary, func(c *federatedClient) func(context.Context) ([]PorepPipelineSummary, error) {
+		return c.PorepPipelineSummary
+	}), nil
+}
+
+// FederatedAlerts returns active alerts of all clusters.
+func (a *WebRPC) FederatedAlerts(ctx context.Context) (*FederatedView[AlertEntry], error) {
+	return federate(ctx, a, a.AlertsActive, func(c *federatedClient) func(context.Context) ([]AlertEntry, error) {
+		return c.AlertsActive
+	}), nil
+}
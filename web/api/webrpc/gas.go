@@ -0,0 +1,121 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type TaskGas struct {
+	Day      time.Time `db:"bucket"`
+	SpID     int64     `db:"sp_id"`
+	Miner    string
+	TaskName string `db:"task_name"`
+	Messages int64  `db:"messages"`
+	GasUsed  int64  `db:"gas_used"`
+	GasCost  string `db:"gas_cost"`
+}
+
+type TaskGasTotal struct {
+	SpID     int64 `db:"sp_id"`
+	Miner    string
+	TaskName string `db:"task_name"`
+	Messages int64  `db:"messages"`
+	GasUsed  int64  `db:"gas_used"`
+	GasCost  string `db:"gas_cost"`
+}
+
+// GasByTask returns daily gas of executed messages by sending task type and SP over the last days days. An empty
+// miner returns all SPs, including messages not sent for an SP (SP ID 0).
+func (a *WebRPC) GasByTask(ctx context.Context, miner string, days int) ([]TaskGas, error) {
+	spID, err := gasSpID(miner)
+	if err != nil {
+		return nil, err
+	}
+	if days <= 0 || days > 365 {
+		days = 30
+	}
+
+	var out []TaskGas
+	err = a.deps.DB.Select(ctx, &out, `SELECT bucket, sp_id, task_name, messages, gas_used, gas_cost::TEXT AS gas_cost
+		FROM chart_gas_task_daily
+		WHERE bucket >= date_trunc('day', current_timestamp) - make_interval(days => $1) AND ($2 < 0 OR sp_id = $2)
+		ORDER BY bucket DESC, sp_id, task_name`, days, spID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting gas by task: %w", err)
+	}
+
+	for i := range out {
+		if err := fillTaskGas(out[i].SpID, &out[i].Miner, &out[i].GasCost); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// GasByTaskTotals sums gas of executed messages by sending task type and SP between from and to, e.g. to see what
+// WindowPoSt cost over the last month.
+func (a *WebRPC) GasByTaskTotals(ctx context.Context, miner string, from, to time.Time) ([]TaskGasTotal, error) {
+	spID, err := gasSpID(miner)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []TaskGasTotal
+	err = a.deps.DB.Select(ctx, &out, `SELECT sp_id, task_name, SUM(messages) AS messages, SUM(gas_used) AS gas_used,
+			SUM(gas_cost)::TEXT AS gas_cost
+		FROM chart_gas_task_daily
+		WHERE bucket >= date_trunc('day', $1::TIMESTAMPTZ) AND bucket < $2 AND ($3 < 0 OR sp_id = $3)
+		GROUP BY sp_id, task_name
+		ORDER BY sp_id, SUM(gas_cost) DESC`, from, to, spID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting gas totals by task: %w", err)
+	}
+
+	for i := range out {
+		if err := fillTaskGas(out[i].SpID, &out[i].Miner, &out[i].GasCost); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// gasSpID returns the SP ID of a miner address, -1 for an empty address.
+func gasSpID(miner string) (int64, error) {
+	if miner == "" {
+		return -1, nil
+	}
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return 0, xerrors.Errorf("parsing miner address: %w", err)
+	}
+	id, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return 0, xerrors.Errorf("id from %s: %w", maddr, err)
+	}
+	return int64(id), nil
+}
+
+// fillTaskGas sets the miner address of an SP ID and formats an attoFIL gas cost.
+func fillTaskGas(spID int64, miner, cost *string) error {
+	if spID != 0 {
+		maddr, err := address.NewIDAddress(uint64(spID))
+		if err != nil {
+			return err
+		}
+		*miner = maddr.String()
+	}
+
+	v, err := big.FromString(*cost)
+	if err != nil {
+		return xerrors.Errorf("parsing gas cost: %w", err)
+	}
+	*cost = types.FIL(v).Short()
+	return nil
+}
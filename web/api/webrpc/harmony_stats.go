@@ -82,3 +82,54 @@ func (a *WebRPC) HarmonyTaskHistory(ctx context.Context, taskName string, fails
 	}
 	return stats, nil
 }
+
+type harmonyTaskHistoryRow struct {
+	HarmonyTaskHistory
+	Total int `db:"total"`
+}
+
+// HarmonyTaskHistoryPage returns a page of the history of a task type over the last day. Sortable by
+// TaskID, WorkStart, WorkEnd (default, newest first), Result and CompletedBy.
+func (a *WebRPC) HarmonyTaskHistoryPage(ctx context.Context, taskName string, fails bool, req PageRequest) (*Page[HarmonyTaskHistory], error) {
+	req = req.normalize()
+	if err := req.checkSort("TaskID", "WorkStart", "WorkEnd", "Result", "CompletedBy"); err != nil {
+		return nil, err
+	}
+
+	desc := req.Desc
+	if req.Sort == "" {
+		desc = !desc
+	}
+
+	var rows []harmonyTaskHistoryRow
+	err := a.deps.DB.Select(ctx, &rows, `SELECT
+	hist.task_id, hist.name, hist.work_start, hist.work_end, hist.posted, hist.result, hist.err,
+	hist.completed_by_host_and_port, mach.id as completed_by_machine, hmd.machine_name as completed_by_machine_name,
+	COUNT(*) OVER () AS total
+    FROM harmony_task_history hist
+    LEFT JOIN harmony_machines mach ON hist.completed_by_host_and_port = mach.host_and_port
+    LEFT JOIN curio.harmony_machine_details hmd on mach.id = hmd.machine_id
+    WHERE name = $1 AND work_end > current_timestamp - interval '1 day' AND ($2 OR NOT hist.result)
+    ORDER BY
+		CASE WHEN $3 = 'taskid' AND NOT $4 THEN hist.task_id END ASC,
+		CASE WHEN $3 = 'taskid' AND $4 THEN hist.task_id END DESC,
+		CASE WHEN $3 = 'workstart' AND NOT $4 THEN hist.work_start END ASC,
+		CASE WHEN $3 = 'workstart' AND $4 THEN hist.work_start END DESC,
+		CASE WHEN $3 = 'result' AND NOT $4 THEN hist.result END ASC,
+		CASE WHEN $3 = 'result' AND $4 THEN hist.result END DESC,
+		CASE WHEN $3 = 'completedby' AND NOT $4 THEN hist.completed_by_host_and_port END ASC,
+		CASE WHEN $3 = 'completedby' AND $4 THEN hist.completed_by_host_and_port END DESC,
+		CASE WHEN NOT $4 THEN hist.work_end END ASC,
+		hist.work_end DESC
+    LIMIT $5 OFFSET $6`, taskName, !fails, req.sqlSort(), desc, req.Limit, req.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Page[HarmonyTaskHistory]{Items: make([]HarmonyTaskHistory, 0, len(rows))}
+	for _, r := range rows {
+		out.Total = r.Total
+		out.Items = append(out.Items, r.HarmonyTaskHistory)
+	}
+	return out, nil
+}
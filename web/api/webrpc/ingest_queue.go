@@ -0,0 +1,162 @@
+package webrpc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/market/clientlimit"
+	"github.com/filecoin-project/curio/tasks/ingestqueue"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type IngestQueueProvider struct {
+	ingestqueue.Provider
+	Miner string
+}
+
+// IngestQueueProviders lists the miners taking pieces from the shared ingest queue.
+func (a *WebRPC) IngestQueueProviders(ctx context.Context) ([]IngestQueueProvider, error) {
+	providers, err := ingestqueue.Providers(ctx, a.deps.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]IngestQueueProvider, 0, len(providers))
+	for _, p := range providers {
+		maddr, err := address.NewIDAddress(uint64(p.SpID))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, IngestQueueProvider{Provider: p, Miner: maddr.String()})
+	}
+	return out, nil
+}
+
+// IngestQueueProviderSet makes a miner take pieces from the shared ingest queue which are in its region, or without
+// a region, and are stored for minDays to maxDays, while fewer than maxPipeline of its sectors are in the pipeline.
+// An empty region only takes pieces without a region.
+func (a *WebRPC) IngestQueueProviderSet(ctx context.Context, miner, region string, minDays, maxDays, maxPipeline int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	if err := ingestqueue.SetProvider(ctx, a.deps.DB, maddr, region, minDays, maxDays, maxPipeline); err != nil {
+		return err
+	}
+
+	log.Infow("ingest queue provider set", "miner", miner, "region", region, "min_days", minDays, "max_days", maxDays, "max_pipeline", maxPipeline)
+	return nil
+}
+
+// IngestQueueProviderRemove stops assigning queued pieces to a miner.
+func (a *WebRPC) IngestQueueProviderRemove(ctx context.Context, miner string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	return ingestqueue.RemoveProvider(ctx, a.deps.DB, maddr)
+}
+
+// IngestQueueAdd adds a piece to the shared ingest queue, returning its queue ID. The data is fetched from dataURL
+// with headers once the piece is assigned to a miner.
+func (a *WebRPC) IngestQueueAdd(ctx context.Context, pieceCid string, pieceSize uint64, rawSize int64, dataURL string,
+	headers http.Header, durationDays int64, region string, keepUnsealed bool) (int64, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return 0, err
+	}
+
+	pcid, err := cid.Parse(pieceCid)
+	if err != nil {
+		return 0, xerrors.Errorf("parsing piece cid: %w", err)
+	}
+	u, err := url.Parse(dataURL)
+	if err != nil {
+		return 0, xerrors.Errorf("parsing data url: %w", err)
+	}
+
+	// pieces added with a token count against the client limits of the token
+	var cl *clientlimit.Limiter
+	var limitID int64
+	if token := apiauth.CallerToken(ctx); token != "" {
+		cl, err = clientlimit.New(a.deps.DB, a.deps.Chain, a.deps.Cfg.Ingest.ClientLimits)
+		if err != nil {
+			return 0, xerrors.Errorf("setting up client limits: %w", err)
+		}
+		var rejected string
+		limitID, rejected, err = cl.Begin(ctx, clientlimit.Deal{
+			Client:    clientlimit.TokenPrefix + token,
+			PieceCID:  pcid.String(),
+			PieceSize: abi.PaddedPieceSize(pieceSize),
+			RawSize:   rawSize,
+		})
+		if err != nil {
+			return 0, xerrors.Errorf("checking client limits: %w", err)
+		}
+		if rejected != "" {
+			return 0, xerrors.Errorf("piece rejected by client limits: %s", rejected)
+		}
+	}
+
+	id, err := ingestqueue.Enqueue(ctx, a.deps.DB, ingestqueue.Piece{
+		PieceCID:     pcid,
+		Size:         abi.PaddedPieceSize(pieceSize),
+		RawSize:      rawSize,
+		DataURL:      *u,
+		DataHeaders:  headers,
+		DurationDays: durationDays,
+		Region:       region,
+		KeepUnsealed: keepUnsealed,
+	})
+	if err != nil {
+		if cl != nil {
+			cl.Failed(ctx, limitID)
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// IngestQueue lists the pieces in the shared ingest queue, with the reason queued pieces are waiting.
+func (a *WebRPC) IngestQueue(ctx context.Context, withAssigned bool) ([]ingestqueue.QueuedPiece, error) {
+	pieces, err := ingestqueue.Queue(ctx, a.deps.DB, withAssigned)
+	if err != nil {
+		return nil, err
+	}
+	if pieces == nil {
+		pieces = []ingestqueue.QueuedPiece{}
+	}
+	return pieces, nil
+}
+
+// IngestQueueRetry queues a piece which failed to be added to a sector again.
+func (a *WebRPC) IngestQueueRetry(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+	return ingestqueue.Retry(ctx, a.deps.DB, id)
+}
+
+// IngestQueueRemove takes a piece which wasn't assigned yet out of the shared ingest queue.
+func (a *WebRPC) IngestQueueRemove(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+	return ingestqueue.Remove(ctx, a.deps.DB, id)
+}
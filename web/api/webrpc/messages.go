@@ -0,0 +1,226 @@
+package webrpc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type MessageSummary struct {
+	SignedCID   *string `db:"signed_cid"`
+	UnsignedCID string  `db:"unsigned_cid"`
+	From        string  `db:"from_key"`
+	To          string  `db:"to_addr"`
+	Reason      string  `db:"send_reason"`
+	SendTaskID  int64   `db:"send_task_id"`
+	Nonce       *int64  `db:"nonce"`
+
+	SendTime    *time.Time `db:"send_time"`
+	SendSuccess *bool      `db:"send_success"`
+	SendError   *string    `db:"send_error"`
+
+	ExecutedEpoch *int64 `db:"executed_tsk_epoch"`
+	ExitCode      *int64 `db:"executed_rcpt_exitcode"`
+	GasUsed       *int64 `db:"executed_rcpt_gas_used"`
+}
+
+type MessageDetail struct {
+	MessageSummary
+
+	// Message is the signed message as JSON, nil until the send task signs it
+	Message json.RawMessage `db:"-"`
+
+	ExecutedTSK    *string `db:"executed_tsk_cid"`
+	ExecutedMsgCID *string `db:"executed_msg_cid"` // differs from SignedCID when the message was replaced
+	WaiterMachine  *string `db:"waiter_machine"`
+
+	// ReplacesCID is the first message sent at the same nonce, set for fee bumps
+	ReplacesCID  *string `db:"replaces_signed_cid"`
+	Replacements *int64  `db:"replacements"` // replacements queued for the first message at the nonce
+
+	SendAttempts []MessageSendAttempt `db:"-"`
+	Context      []MessageContext     `db:"-"`
+}
+
+// MessageSendAttempt is a SendMessage task run for the message.
+type MessageSendAttempt struct {
+	Machine   string    `db:"completed_by_host_and_port"`
+	WorkStart time.Time `db:"work_start"`
+	WorkEnd   time.Time `db:"work_end"`
+	Result    bool      `db:"result"`
+	Err       *string   `db:"err"`
+}
+
+// MessageContext links a message to the pipeline entry or proof it was sent for.
+type MessageContext struct {
+	Kind string `db:"kind"` // precommit, commit, update or wdpost
+
+	SpID         int64  `db:"sp_id"`
+	SectorNumber *int64 `db:"sector_number"`
+	Deadline     *int64 `db:"deadline"`
+	Partition    *int64 `db:"partition"`
+
+	// TaskID is the task which submitted the message, when still known
+	TaskID *int64 `db:"task_id"`
+}
+
+// MessageList returns recently sent messages, newest first. Empty fromKey and reason match all messages.
+func (a *WebRPC) MessageList(ctx context.Context, fromKey, reason string, limit, offset int) ([]MessageSummary, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var msgs []MessageSummary
+	err := a.deps.DB.Select(ctx, &msgs, `SELECT s.signed_cid, s.unsigned_cid, s.from_key, s.to_addr, s.send_reason, s.send_task_id, s.nonce,
+			s.send_time, s.send_success, s.send_error,
+			w.executed_tsk_epoch, w.executed_rcpt_exitcode, w.executed_rcpt_gas_used
+		FROM message_sends s
+		LEFT JOIN message_waits w ON w.signed_message_cid = COALESCE(s.replaces_signed_cid, s.held_cid, s.signed_cid)
+		WHERE ($1 = '' OR s.from_key = $1) AND ($2 = '' OR s.send_reason = $2)
+		ORDER BY s.send_task_id DESC
+		LIMIT $3 OFFSET $4`, fromKey, reason, limit, offset)
+	if err != nil {
+		return nil, xerrors.Errorf("listing messages: %w", err)
+	}
+	return msgs, nil
+}
+
+// MessageInfo looks up a message by its signed, unsigned or executed CID and returns it with the send
+// attempts, execution result and the sectors or proofs it was sent for.
+func (a *WebRPC) MessageInfo(ctx context.Context, msgCid string) (*MessageDetail, error) {
+	var msgs []struct {
+		MessageDetail
+		SignedJSON *string `db:"signed_json"`
+	}
+	err := a.deps.DB.Select(ctx, &msgs, `SELECT s.signed_cid, s.unsigned_cid, s.from_key, s.to_addr, s.send_reason, s.send_task_id, s.nonce,
+			s.send_time, s.send_success, s.send_error, s.signed_json::TEXT AS signed_json,
+			w.executed_tsk_epoch, w.executed_rcpt_exitcode, w.executed_rcpt_gas_used,
+			w.executed_tsk_cid, w.executed_msg_cid, m.host_and_port AS waiter_machine,
+			s.replaces_signed_cid, w.replacements
+		FROM message_sends s
+		LEFT JOIN message_waits w ON w.signed_message_cid = COALESCE(s.replaces_signed_cid, s.held_cid, s.signed_cid)
+		LEFT JOIN harmony_machines m ON m.id = w.waiter_machine_id
+		WHERE s.signed_cid = $1 OR s.unsigned_cid = $1 OR s.held_cid = $1 OR w.executed_msg_cid = $1
+		ORDER BY s.send_task_id DESC
+		LIMIT 1`, msgCid)
+	if err != nil {
+		return nil, xerrors.Errorf("getting message: %w", err)
+	}
+	if len(msgs) == 0 {
+		return nil, xerrors.Errorf("message %s not found", msgCid)
+	}
+	msg := msgs[0].MessageDetail
+	if msgs[0].SignedJSON != nil {
+		msg.Message = json.RawMessage(*msgs[0].SignedJSON)
+	}
+
+	err = a.deps.DB.Select(ctx, &msg.SendAttempts, `SELECT completed_by_host_and_port, work_start, work_end, result, err
+		FROM harmony_task_history
+		WHERE task_id = $1 AND name = 'SendMessage'
+		ORDER BY work_end`, msg.SendTaskID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting send attempts: %w", err)
+	}
+
+	if msg.SignedCID == nil {
+		return &msg, nil
+	}
+
+	// Pipeline tables hold the submitting task while the sector is in the pipeline, sectors_meta
+	// keeps the message CIDs afterwards.
+	var mctx []MessageContext
+	err = a.deps.DB.Select(ctx, &mctx, `SELECT 'precommit' AS kind, sp_id, sector_number, NULL::BIGINT AS deadline, NULL::BIGINT AS partition, task_id_precommit_msg AS task_id
+			FROM sectors_sdr_pipeline WHERE precommit_msg_cid = $1
+		UNION ALL
+		SELECT 'commit', sp_id, sector_number, NULL, NULL, task_id_commit_msg
+			FROM sectors_sdr_pipeline WHERE commit_msg_cid = $1
+		UNION ALL
+		SELECT 'update', sp_id, sector_number, NULL, NULL, task_id_submit
+			FROM sectors_snap_pipeline WHERE prove_msg_cid = $1
+		UNION ALL
+		SELECT 'precommit', sp_id, sector_num, NULL, NULL, NULL
+			FROM sectors_meta WHERE msg_cid_precommit = $1
+		UNION ALL
+		SELECT 'commit', sp_id, sector_num, NULL, NULL, NULL
+			FROM sectors_meta WHERE msg_cid_commit = $1
+		UNION ALL
+		SELECT 'update', sp_id, sector_num, NULL, NULL, NULL
+			FROM sectors_meta WHERE msg_cid_update = $1
+		UNION ALL
+		SELECT 'wdpost', sp_id, NULL, deadline, partition, submit_task_id
+			FROM wdpost_proofs WHERE message_cid = $1
+		ORDER BY task_id NULLS LAST`, *msg.SignedCID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting message context: %w", err)
+	}
+
+	// rows with a task ID come first, so sectors_meta duplicates of pipeline rows are dropped
+	type contextKey struct {
+		kind   string
+		spID   int64
+		sector int64
+		dl, pt int64
+	}
+	msg.Context = lo.UniqBy(mctx, func(c MessageContext) contextKey {
+		return contextKey{c.Kind, c.SpID, lo.FromPtrOr(c.SectorNumber, -1), lo.FromPtrOr(c.Deadline, -1), lo.FromPtrOr(c.Partition, -1)}
+	})
+
+	return &msg, nil
+}
+
+// DryRunMessage is a message captured instead of sent while dry-run was enabled.
+type DryRunMessage struct {
+	ID     int64  `db:"id"`
+	From   string `db:"from_key"`
+	To     string `db:"to_addr"`
+	Reason string `db:"send_reason"`
+	SpID   *int64 `db:"sp_id"`
+	CID    string `db:"unsigned_cid"`
+
+	// Message is the unsigned message as JSON, including gas parameters
+	Message json.RawMessage `db:"unsigned_json"`
+
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// DryRunMessages returns messages captured in dry-run, newest first. A negative spID matches all messages.
+func (a *WebRPC) DryRunMessages(ctx context.Context, spID int64, limit, offset int) ([]DryRunMessage, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	msgs := []DryRunMessage{}
+	err := a.deps.DB.Select(ctx, &msgs, `SELECT id, from_key, to_addr, send_reason, sp_id, unsigned_cid, unsigned_json, created_at
+		FROM message_dry_runs
+		WHERE $1 < 0 OR sp_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3`, spID, limit, offset)
+	if err != nil {
+		return nil, xerrors.Errorf("listing dry-run messages: %w", err)
+	}
+	return msgs, nil
+}
+
+// DryRunClear deletes reviewed dry-run messages up to and including id.
+func (a *WebRPC) DryRunClear(ctx context.Context, id int64) (int, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return 0, err
+	}
+
+	n, err := a.deps.DB.Exec(ctx, `DELETE FROM message_dry_runs WHERE id <= $1`, id)
+	if err != nil {
+		return 0, xerrors.Errorf("clearing dry-run messages: %w", err)
+	}
+	return n, nil
+}
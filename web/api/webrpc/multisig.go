@@ -0,0 +1,176 @@
+package webrpc
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/builtin"
+	msig13 "github.com/filecoin-project/go-state-types/builtin/v13/multisig"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/multisig"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// MultisigProposal is a message proposed to a funds multisig (Addresses.FundsMultisig).
+type MultisigProposal struct {
+	Multisig    string
+	Proposer    string
+	SendReason  string
+	SendTaskID  int64
+	SignedCID   *string
+	SendTime    *time.Time
+	SendSuccess *bool
+
+	// The proposed call
+	To     string
+	Method uint64
+	Value  string
+
+	TxnID *int64
+	// Status is one of 'queued', 'pending' (proposal not on chain yet), 'awaiting-approval', 'applied', 'failed'
+	// or 'resolved' (no longer pending in the multisig, approved or cancelled by signers)
+	Status    string
+	ExitCode  *int64 // of the proposal, or of the proposed call once applied
+	Approvals int
+	Threshold uint64
+}
+
+// MultisigProposals returns messages recently proposed to funds multisigs, newest first, with their approval status.
+func (a *WebRPC) MultisigProposals(ctx context.Context) ([]MultisigProposal, error) {
+	out := []MultisigProposal{}
+
+	seen := map[address.Address]bool{}
+	for _, mf := range a.deps.As.MultisigMap {
+		if seen[mf.Multisig] {
+			continue
+		}
+		seen[mf.Multisig] = true
+
+		props, err := a.multisigProposals(ctx, mf.Multisig)
+		if err != nil {
+			return nil, xerrors.Errorf("getting proposals of %s: %w", mf.Multisig, err)
+		}
+		out = append(out, props...)
+	}
+
+	return out, nil
+}
+
+func (a *WebRPC) multisigProposals(ctx context.Context, msig address.Address) ([]MultisigProposal, error) {
+	var rows []struct {
+		FromKey      string     `db:"from_key"`
+		SendReason   string     `db:"send_reason"`
+		SendTaskID   int64      `db:"send_task_id"`
+		UnsignedData []byte     `db:"unsigned_data"`
+		SignedCID    *string    `db:"signed_cid"`
+		SendTime     *time.Time `db:"send_time"`
+		SendSuccess  *bool      `db:"send_success"`
+
+		ExecutedEpoch *int64 `db:"executed_tsk_epoch"`
+		ExitCode      *int64 `db:"executed_rcpt_exitcode"`
+		Return        []byte `db:"executed_rcpt_return"`
+	}
+	err := a.deps.DB.Select(ctx, &rows, `SELECT s.from_key, s.send_reason, s.send_task_id, s.unsigned_data, s.signed_cid,
+			s.send_time, s.send_success, w.executed_tsk_epoch, w.executed_rcpt_exitcode, w.executed_rcpt_return
+		FROM message_sends s
+		LEFT JOIN message_waits w ON w.signed_message_cid = COALESCE(s.replaces_signed_cid, s.held_cid, s.signed_cid)
+		WHERE s.to_addr = $1 AND s.send_success IS NOT FALSE
+		ORDER BY s.send_task_id DESC
+		LIMIT 200`, msig.String())
+	if err != nil {
+		return nil, xerrors.Errorf("getting proposals: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	act, err := a.deps.Chain.StateGetActor(ctx, msig, types.EmptyTSK)
+	if err != nil {
+		return nil, xerrors.Errorf("getting multisig actor: %w", err)
+	}
+	mst, err := multisig.Load(a.stor, act)
+	if err != nil {
+		return nil, xerrors.Errorf("loading multisig state: %w", err)
+	}
+	threshold, err := mst.Threshold()
+	if err != nil {
+		return nil, xerrors.Errorf("getting multisig threshold: %w", err)
+	}
+	pending := map[int64]multisig.Transaction{}
+	err = mst.ForEachPendingTxn(func(id int64, txn multisig.Transaction) error {
+		pending[id] = txn
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("getting pending transactions: %w", err)
+	}
+
+	var out []MultisigProposal
+	for _, r := range rows {
+		p := MultisigProposal{
+			Multisig:    msig.String(),
+			Proposer:    r.FromKey,
+			SendReason:  r.SendReason,
+			SendTaskID:  r.SendTaskID,
+			SignedCID:   r.SignedCID,
+			SendTime:    r.SendTime,
+			SendSuccess: r.SendSuccess,
+			Threshold:   threshold,
+		}
+
+		var msg types.Message
+		if err := msg.UnmarshalCBOR(bytes.NewReader(r.UnsignedData)); err != nil {
+			return nil, xerrors.Errorf("unmarshaling message of task %d: %w", r.SendTaskID, err)
+		}
+		if msg.Method != builtin.MethodsMultisig.Propose {
+			continue
+		}
+		var params msig13.ProposeParams
+		if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+			return nil, xerrors.Errorf("unmarshaling propose params of task %d: %w", r.SendTaskID, err)
+		}
+		p.To = params.To.String()
+		p.Method = uint64(params.Method)
+		p.Value = types.FIL(params.Value).Short()
+
+		switch {
+		case r.SendSuccess == nil:
+			p.Status = "queued"
+		case r.ExecutedEpoch == nil:
+			p.Status = "pending"
+		case r.ExitCode != nil && exitcode.ExitCode(*r.ExitCode) != exitcode.Ok:
+			p.Status = "failed"
+			p.ExitCode = r.ExitCode
+		default:
+			var ret msig13.ProposeReturn
+			if err := ret.UnmarshalCBOR(bytes.NewReader(r.Return)); err != nil {
+				return nil, xerrors.Errorf("unmarshaling propose return of task %d: %w", r.SendTaskID, err)
+			}
+			txnID := int64(ret.TxnID)
+			p.TxnID = &txnID
+
+			if ret.Applied {
+				code := int64(ret.Code)
+				p.ExitCode = &code
+				p.Status = "applied"
+				if ret.Code != exitcode.Ok {
+					p.Status = "failed"
+				}
+			} else if txn, ok := pending[txnID]; ok {
+				p.Status = "awaiting-approval"
+				p.Approvals = len(txn.Approved)
+			} else {
+				p.Status = "resolved"
+			}
+		}
+
+		out = append(out, p)
+	}
+
+	return out, nil
+}
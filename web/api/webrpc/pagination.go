@@ -0,0 +1,158 @@
+package webrpc
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// PageRequest selects a page of a list. List methods which can return large results have a Page variant
+// taking a PageRequest, e.g. ClusterMachinesPage for ClusterMachines.
+type PageRequest struct {
+	Limit  int // defaults to 100, at most 1000
+	Offset int
+
+	// Sort is the name of a field of the listed items, e.g. "Name". Empty keeps the default order of the list.
+	Sort string
+	Desc bool
+}
+
+// Page is a page of a list, Total is the number of items in the whole list. Lists paged in SQL count items
+// along with the page, so Total is zero for pages past the end of those.
+type Page[T any] struct {
+	Total int
+	Items []T
+}
+
+func (p PageRequest) normalize() PageRequest {
+	if p.Limit <= 0 {
+		p.Limit = defaultPageSize
+	}
+	if p.Limit > maxPageSize {
+		p.Limit = maxPageSize
+	}
+	if p.Offset < 0 {
+		p.Offset = 0
+	}
+	return p
+}
+
+// checkSort validates the sort field of a page sorted in SQL, where only some columns can be sorted by.
+func (p PageRequest) checkSort(fields ...string) error {
+	if p.Sort == "" {
+		return nil
+	}
+	for _, f := range fields {
+		if strings.EqualFold(p.Sort, f) {
+			return nil
+		}
+	}
+	return xerrors.Errorf("unknown sort field %q, expected one of %s", p.Sort, strings.Join(fields, ", "))
+}
+
+// sqlSort returns the sort field as passed to queries, lower cased with empty meaning the default order.
+func (p PageRequest) sqlSort() string {
+	return strings.ToLower(p.Sort)
+}
+
+// pageOf sorts and pages a list computed in full.
+func pageOf[T any](items []T, req PageRequest) (*Page[T], error) {
+	req = req.normalize()
+
+	if req.Sort != "" {
+		if err := sortByField(items, req.Sort, req.Desc); err != nil {
+			return nil, err
+		}
+	} else if req.Desc {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	out := &Page[T]{Total: len(items), Items: []T{}}
+	if req.Offset < len(items) {
+		end := req.Offset + req.Limit
+		if end > len(items) {
+			end = len(items)
+		}
+		out.Items = items[req.Offset:end]
+	}
+	return out, nil
+}
+
+// sortByField stably sorts structs by an exported field, including fields of embedded structs. Nil pointers
+// sort first.
+func sortByField[T any](items []T, field string, desc bool) error {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		return xerrors.Errorf("can't sort %s by field", typ)
+	}
+	sf, ok := typ.FieldByNameFunc(func(n string) bool { return strings.EqualFold(n, field) })
+	if !ok || !sf.IsExported() {
+		return xerrors.Errorf("unknown sort field %q", field)
+	}
+
+	ft := sf.Type
+	if ft.Kind() == reflect.Pointer {
+		ft = ft.Elem()
+	}
+	less, err := lessFunc(ft)
+	if err != nil {
+		return xerrors.Errorf("can't sort by %s: %w", sf.Name, err)
+	}
+
+	value := func(i int) (reflect.Value, bool) {
+		v := reflect.ValueOf(&items[i]).Elem().FieldByIndex(sf.Index)
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		return v, true
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, aok := value(i)
+		b, bok := value(j)
+		if desc {
+			a, aok, b, bok = b, bok, a, aok
+		}
+		switch {
+		case !aok || !bok:
+			return !aok && bok
+		default:
+			return less(a, b)
+		}
+	})
+	return nil
+}
+
+func lessFunc(t reflect.Type) (func(a, b reflect.Value) bool, error) {
+	if t == reflect.TypeOf(time.Time{}) {
+		return func(a, b reflect.Value) bool { return a.Interface().(time.Time).Before(b.Interface().(time.Time)) }, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(a, b reflect.Value) bool { return a.Int() < b.Int() }, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }, nil
+	case reflect.Float32, reflect.Float64:
+		return func(a, b reflect.Value) bool { return a.Float() < b.Float() }, nil
+	case reflect.String:
+		return func(a, b reflect.Value) bool { return a.String() < b.String() }, nil
+	case reflect.Bool:
+		return func(a, b reflect.Value) bool { return !a.Bool() && b.Bool() }, nil
+	default:
+		return nil, xerrors.Errorf("unsupported field type %s", t)
+	}
+}
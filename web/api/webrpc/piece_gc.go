@@ -0,0 +1,52 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/tasks/piece"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+// PieceGCPreview lists parked pieces without references, and whether piece GC would remove each of them and why.
+// It reflects what the cleanup task does with the current config, including in dry-run mode.
+func (a *WebRPC) PieceGCPreview(ctx context.Context) ([]piece.GCCandidate, error) {
+	cands, err := piece.GCCandidates(ctx, a.deps.DB, time.Duration(a.deps.Cfg.Storage.PieceGC.GracePeriod))
+	if err != nil {
+		return nil, err
+	}
+	if cands == nil {
+		cands = []piece.GCCandidate{}
+	}
+	return cands, nil
+}
+
+// PieceGCHold keeps a parked piece from being removed by piece GC. holdHours of zero holds the piece until
+// the hold is released with PieceGCReleaseHold.
+func (a *WebRPC) PieceGCHold(ctx context.Context, pieceID int64, reason string, holdHours int) (int64, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return 0, err
+	}
+	if holdHours < 0 {
+		return 0, xerrors.Errorf("hold duration can't be negative")
+	}
+
+	var until *time.Time
+	if holdHours > 0 {
+		t := time.Now().Add(time.Duration(holdHours) * time.Hour)
+		until = &t
+	}
+
+	return piece.HoldPiece(ctx, a.deps.DB, pieceID, reason, until)
+}
+
+// PieceGCReleaseHold removes a parked piece hold added with PieceGCHold.
+func (a *WebRPC) PieceGCReleaseHold(ctx context.Context, holdID int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	return piece.ReleasePieceHold(ctx, a.deps.DB, holdID)
+}
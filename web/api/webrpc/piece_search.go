@@ -0,0 +1,189 @@
+package webrpc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/lib/paths"
+	"github.com/filecoin-project/curio/lib/storiface"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Piece states reported by PieceSearch
+const (
+	PieceStatePending  = "pending"  // in an open deal sector waiting for more deals
+	PieceStateSealing  = "sealing"  // in the PoRep pipeline
+	PieceStateSnapping = "snapping" // in the snap deals pipeline
+	PieceStateSealed   = "sealed"
+)
+
+// Retrieval states reported by PieceSearch
+const (
+	RetrievalAvailable  = "available"   // an unsealed copy of the sector exists
+	RetrievalUnsealing  = "unsealing"   // unsealing was requested
+	RetrievalSealedOnly = "sealed-only" // only the sealed copy exists
+	RetrievalNotSealed  = "not-sealed"  // sector isn't sealed yet
+)
+
+type PieceSearchResult struct {
+	State string `db:"state"`
+
+	SpID         int64  `db:"sp_id"`
+	Miner        string `db:"-"`
+	SectorNumber int64  `db:"sector_number"`
+	PieceIndex   int64  `db:"piece_index"`
+
+	PieceCID  string `db:"piece_cid"`
+	PieceSize int64  `db:"piece_size"`
+
+	DealID     *int64  `db:"deal_id"`
+	Client     *string `db:"client"`
+	Label      *string `db:"label"`
+	StartEpoch *int64  `db:"start_epoch"`
+	EndEpoch   *int64  `db:"end_epoch"`
+
+	TargetUnseal *bool `db:"target_unseal_state"`
+
+	Retrieval string              `db:"-"`
+	Locations []PieceFileLocation `db:"-"`
+}
+
+type PieceFileLocation struct {
+	FileType  string
+	StorageID string
+	Urls      []string
+}
+
+// PieceSearch finds pieces by piece CID, data CID (deal label), f05 deal ID or client address, returning
+// the sectors containing them, where the sector files are stored and whether the data can be retrieved.
+func (a *WebRPC) PieceSearch(ctx context.Context, query string) ([]PieceSearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, xerrors.Errorf("empty query")
+	}
+
+	var (
+		dealID  *int64
+		cidStr  *string
+		clients []string
+	)
+
+	if id, err := strconv.ParseInt(query, 10, 64); err == nil {
+		dealID = &id
+	} else if c, err := cid.Parse(query); err == nil {
+		cidStr = lo.ToPtr(c.String())
+	} else if addr, err := address.NewFromString(query); err == nil {
+		// proposals may carry either the ID or the robust address of the client
+		clients = append(clients, addr.String())
+		if idAddr, err := a.deps.Chain.StateLookupID(ctx, addr, types.EmptyTSK); err == nil && idAddr != addr {
+			clients = append(clients, idAddr.String())
+		}
+		if keyAddr, err := a.deps.Chain.StateAccountKey(ctx, addr, types.EmptyTSK); err == nil && keyAddr != addr {
+			clients = append(clients, keyAddr.String())
+		}
+	} else {
+		return nil, xerrors.Errorf("query %q is not a deal ID, CID or address", query)
+	}
+
+	var results []PieceSearchResult
+	err := a.deps.DB.Select(ctx, &results, `SELECT * FROM (
+			SELECT 'sealed' AS state, mp.sp_id, mp.sector_num AS sector_number, mp.piece_num AS piece_index, mp.piece_cid, mp.piece_size,
+				mp.f05_deal_id AS deal_id, mp.f05_deal_proposal->>'Client' AS client, mp.f05_deal_proposal->>'Label' AS label,
+				mp.start_epoch, mp.orig_end_epoch AS end_epoch, sm.target_unseal_state
+			FROM sectors_meta_pieces mp
+			JOIN sectors_meta sm ON sm.sp_id = mp.sp_id AND sm.sector_num = mp.sector_num
+			UNION ALL
+			SELECT 'sealing', sp_id, sector_number, piece_index, piece_cid, piece_size,
+				f05_deal_id, f05_deal_proposal->>'Client', f05_deal_proposal->>'Label',
+				COALESCE(f05_deal_start_epoch, direct_start_epoch), COALESCE(f05_deal_end_epoch, direct_end_epoch), NULL
+			FROM sectors_sdr_initial_pieces
+			UNION ALL
+			SELECT 'snapping', sp_id, sector_number, piece_index, piece_cid, piece_size,
+				NULL, NULL, NULL, direct_start_epoch, direct_end_epoch, NULL
+			FROM sectors_snap_initial_pieces
+			UNION ALL
+			SELECT 'pending', sp_id, sector_number, piece_index, piece_cid, piece_size,
+				f05_deal_id, f05_deal_proposal->>'Client', f05_deal_proposal->>'Label',
+				COALESCE(f05_deal_start_epoch, direct_start_epoch), COALESCE(f05_deal_end_epoch, direct_end_epoch), NULL
+			FROM open_sector_pieces
+		) p
+		WHERE ($1::BIGINT IS NULL OR p.deal_id = $1)
+		  AND ($2::TEXT IS NULL OR p.piece_cid = $2 OR p.label = $2)
+		  AND ($3::TEXT[] IS NULL OR p.client = ANY($3))
+		ORDER BY p.sp_id, p.sector_number, p.piece_index
+		LIMIT 500`, dealID, cidStr, clients)
+	if err != nil {
+		return nil, xerrors.Errorf("searching pieces: %w", err)
+	}
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	spIDs := lo.Uniq(lo.Map(results, func(r PieceSearchResult, _ int) int64 { return r.SpID }))
+	sectorNums := lo.Uniq(lo.Map(results, func(r PieceSearchResult, _ int) int64 { return r.SectorNumber }))
+
+	var locs []struct {
+		SpID         int64  `db:"miner_id"`
+		SectorNumber int64  `db:"sector_num"`
+		FileType     int64  `db:"sector_filetype"`
+		StorageID    string `db:"storage_id"`
+		Urls         string `db:"urls"`
+	}
+	err = a.deps.DB.Select(ctx, &locs, `SELECT l.miner_id, l.sector_num, l.sector_filetype, l.storage_id, COALESCE(p.urls, '') AS urls
+		FROM sector_location l
+		LEFT JOIN storage_path p ON p.storage_id = l.storage_id
+		WHERE l.miner_id = ANY($1) AND l.sector_num = ANY($2)
+		ORDER BY l.sector_filetype, l.storage_id`, spIDs, sectorNums)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector locations: %w", err)
+	}
+
+	type sectorKey struct{ sp, num int64 }
+	bySector := map[sectorKey][]PieceFileLocation{}
+	unsealed := map[sectorKey]bool{}
+	for _, l := range locs {
+		k := sectorKey{l.SpID, l.SectorNumber}
+		ft := storiface.SectorFileType(l.FileType)
+		bySector[k] = append(bySector[k], PieceFileLocation{
+			FileType:  ft.String(),
+			StorageID: l.StorageID,
+			Urls:      lo.Filter(strings.Split(l.Urls, paths.URLSeparator), func(u string, _ int) bool { return u != "" }),
+		})
+		if ft == storiface.FTUnsealed {
+			unsealed[k] = true
+		}
+	}
+
+	for i := range results {
+		r := &results[i]
+		k := sectorKey{r.SpID, r.SectorNumber}
+
+		maddr, err := address.NewIDAddress(uint64(r.SpID))
+		if err != nil {
+			return nil, xerrors.Errorf("making miner address: %w", err)
+		}
+		r.Miner = maddr.String()
+		r.Locations = bySector[k]
+
+		switch {
+		case r.State != PieceStateSealed:
+			r.Retrieval = RetrievalNotSealed
+		case unsealed[k]:
+			r.Retrieval = RetrievalAvailable
+		case lo.FromPtr(r.TargetUnseal):
+			r.Retrieval = RetrievalUnsealing
+		default:
+			r.Retrieval = RetrievalSealedOnly
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,112 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type SealingPause struct {
+	SpID     int64     `db:"sp_id"`
+	Miner    string    `db:"-"`
+	Reason   string    `db:"reason"`
+	PausedAt time.Time `db:"paused_at"`
+
+	// Waiting is the number of sectors held before SDR or precommit by the pause.
+	Waiting int64 `db:"waiting"`
+	// InFlight is the number of sectors past those stages, which still finish the pipeline.
+	InFlight int64 `db:"in_flight"`
+}
+
+// PipelinePauses lists the SPs whose sealing pipeline is paused.
+func (a *WebRPC) PipelinePauses(ctx context.Context) ([]SealingPause, error) {
+	var pauses []SealingPause
+	err := a.deps.DB.Select(ctx, &pauses, `SELECT p.sp_id, p.reason, p.paused_at,
+			COUNT(s.sector_number) FILTER (WHERE (s.after_sdr = FALSE AND s.task_id_sdr IS NULL)
+				OR (s.after_synth = TRUE AND s.after_precommit_msg = FALSE AND s.task_id_precommit_msg IS NULL)) AS waiting,
+			COUNT(s.sector_number) FILTER (WHERE NOT ((s.after_sdr = FALSE AND s.task_id_sdr IS NULL)
+				OR (s.after_synth = TRUE AND s.after_precommit_msg = FALSE AND s.task_id_precommit_msg IS NULL))) AS in_flight
+		FROM sealing_pauses p
+		LEFT JOIN sectors_sdr_pipeline s ON s.sp_id = p.sp_id AND s.failed = FALSE
+			AND NOT (s.after_commit_msg_success = TRUE AND s.after_move_storage = TRUE)
+		GROUP BY p.sp_id, p.reason, p.paused_at
+		ORDER BY p.sp_id`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sealing pauses: %w", err)
+	}
+
+	for i := range pauses {
+		maddr, err := address.NewIDAddress(uint64(pauses[i].SpID))
+		if err != nil {
+			return nil, err
+		}
+		pauses[i].Miner = maddr.String()
+	}
+	if pauses == nil {
+		pauses = []SealingPause{}
+	}
+	return pauses, nil
+}
+
+// PipelinePause pauses the sealing pipeline of a miner: its sectors don't start SDR and aren't precommitted, while
+// sectors past those stages finish. Other SPs on the cluster are not affected. Pausing a paused SP updates the
+// reason.
+func (a *WebRPC) PipelinePause(ctx context.Context, miner string, reason string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	spID, err := pipelinePauseSpID(miner)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.deps.DB.Exec(ctx, `INSERT INTO sealing_pauses (sp_id, reason) VALUES ($1, $2)
+		ON CONFLICT (sp_id) DO UPDATE SET reason = excluded.reason`, spID, reason)
+	if err != nil {
+		return xerrors.Errorf("pausing sealing pipeline: %w", err)
+	}
+
+	log.Warnw("sealing pipeline paused", "miner", miner, "reason", reason)
+	return nil
+}
+
+// PipelineResume resumes the sealing pipeline of a paused miner.
+func (a *WebRPC) PipelineResume(ctx context.Context, miner string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	spID, err := pipelinePauseSpID(miner)
+	if err != nil {
+		return err
+	}
+
+	n, err := a.deps.DB.Exec(ctx, `DELETE FROM sealing_pauses WHERE sp_id = $1`, spID)
+	if err != nil {
+		return xerrors.Errorf("resuming sealing pipeline: %w", err)
+	}
+	if n == 0 {
+		return xerrors.Errorf("sealing pipeline of %s is not paused", miner)
+	}
+
+	log.Infow("sealing pipeline resumed", "miner", miner)
+	return nil
+}
+
+func pipelinePauseSpID(miner string) (int64, error) {
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return 0, xerrors.Errorf("parsing miner address: %w", err)
+	}
+	id, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return 0, xerrors.Errorf("miner ID of %s: %w", maddr, err)
+	}
+	return int64(id), nil
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/filecoin-project/go-bitfield"
 	"github.com/filecoin-project/go-state-types/abi"
 
+	"github.com/filecoin-project/curio/web/api/apiauth"
+
 	"github.com/filecoin-project/lotus/chain/actors/adt"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/types"
@@ -22,6 +24,8 @@ type PipelineTask struct {
 
 	CreateTime time.Time `db:"create_time"`
 
+	RegSealProof int64 `db:"reg_seal_proof"`
+
 	TaskSDR    *int64 `db:"task_id_sdr"`
 	AfterSDR   bool   `db:"after_sdr"`
 	StartedSDR bool   `db:"started_sdr"`
@@ -81,6 +85,9 @@ type sectorListEntry struct {
 	CreateTime string
 	AfterSeed  bool
 
+	// Synthetic is set for sectors sealed with synthetic PoRep, which generate synthetic proofs after trees
+	Synthetic bool
+
 	ChainAlloc, ChainSector, ChainActive, ChainUnproven, ChainFaulty bool
 }
 
@@ -95,6 +102,7 @@ func (a *WebRPC) PipelinePorepSectors(ctx context.Context) ([]sectorListEntry, e
 												sp.sp_id, 
 												sp.sector_number,
 												sp.create_time,
+												sp.reg_seal_proof,
 												sp.task_id_sdr, 
 												sp.after_sdr,
 												sp.task_id_tree_d, 
@@ -304,6 +312,7 @@ func (a *WebRPC) PipelinePorepSectors(ctx context.Context) ([]sectorListEntry, e
 			Address:      addr,
 			CreateTime:   task.CreateTime.Format(time.DateTime),
 			AfterSeed:    afterSeed,
+			Synthetic:    abi.Synthetic[abi.RegisteredSealProof(task.RegSealProof)],
 
 			ChainAlloc:    must.One(mbf.alloc.IsSet(uint64(task.SectorNumber))),
 			ChainSector:   must.One(mbf.sectorSet.IsSet(uint64(task.SectorNumber))),
@@ -316,6 +325,16 @@ func (a *WebRPC) PipelinePorepSectors(ctx context.Context) ([]sectorListEntry, e
 	return sectorList, nil
 }
 
+// PipelinePorepSectorsPage returns a page of PipelinePorepSectors, sortable by sector fields, e.g.
+// SectorNumber or CreateTime.
+func (a *WebRPC) PipelinePorepSectorsPage(ctx context.Context, req PageRequest) (*Page[sectorListEntry], error) {
+	sectors, err := a.PipelinePorepSectors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pageOf(sectors, req)
+}
+
 func (a *WebRPC) getMinerBitfields(ctx context.Context, addr address.Address, stor adt.Store) (minerBitfields, error) {
 	act, err := a.deps.Chain.StateGetActor(ctx, addr, types.EmptyTSK)
 	if err != nil {
@@ -421,6 +440,9 @@ func (a *WebRPC) PorepPipelineSummary(ctx context.Context) ([]PorepPipelineSumma
 }
 
 func (a *WebRPC) PipelinePorepRestartAll(ctx context.Context) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	missing, err := a.pipelinePorepMissingTasks(ctx)
 	if err != nil {
 		return err
@@ -0,0 +1,293 @@
+package webrpc
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/build"
+)
+
+type PipelineStageStats struct {
+	Stage   string
+	Samples int
+
+	// dwell times in seconds, from the end of the previous stage to the end of this one
+	AvgDwell float64
+	P50Dwell float64
+	P90Dwell float64
+	MaxDwell float64
+
+	// Machines is empty for chain wait stages
+	Machines []PipelineStageMachine
+}
+
+type PipelineStageMachine struct {
+	Machine  string
+	Samples  int
+	AvgDwell float64
+	AvgWork  float64 // seconds spent executing the task, excluding queue time
+}
+
+type PipelineBottlenecks struct {
+	From    time.Time
+	To      time.Time
+	Sectors int
+
+	Stages []PipelineStageStats
+
+	// LimitingStage is the machine-executed stage with the highest average dwell time. Chain wait stages
+	// (precommit_wait, wait_seed, commit_wait) are reported but not considered, capacity can't shorten them.
+	LimitingStage   string
+	LimitingMachine string
+}
+
+type pipelineRun struct {
+	SpID         int64     `db:"sp_id"`
+	SectorNumber int64     `db:"sector_number"`
+	Name         string    `db:"name"`
+	Posted       time.Time `db:"posted"`
+	WorkStart    time.Time `db:"work_start"`
+	WorkEnd      time.Time `db:"work_end"`
+	Machine      string    `db:"completed_by_host_and_port"`
+}
+
+type sectorTimeline struct {
+	runs map[string]pipelineRun // last successful run by task name
+
+	precommitLanded, commitLanded *time.Time
+}
+
+// pipelineStage describes how to derive one stage of the PoRep pipeline from a sector timeline.
+type pipelineStage struct {
+	name string
+	// span returns the stage start and end, and the run which executed it if any
+	span func(t *sectorTimeline, prevEnd *time.Time) (start, end *time.Time, run *pipelineRun)
+}
+
+func taskStage(name, task string) pipelineStage {
+	return pipelineStage{name: name, span: func(t *sectorTimeline, prevEnd *time.Time) (*time.Time, *time.Time, *pipelineRun) {
+		r, ok := t.runs[task]
+		if !ok {
+			return nil, nil, nil
+		}
+		return prevEnd, &r.WorkEnd, &r
+	}}
+}
+
+func chainStage(name string, landed func(t *sectorTimeline) *time.Time) pipelineStage {
+	return pipelineStage{name: name, span: func(t *sectorTimeline, prevEnd *time.Time) (*time.Time, *time.Time, *pipelineRun) {
+		return prevEnd, landed(t), nil
+	}}
+}
+
+var porepStages = []pipelineStage{
+	{name: "sdr", span: func(t *sectorTimeline, _ *time.Time) (*time.Time, *time.Time, *pipelineRun) {
+		r, ok := t.runs["SDR"]
+		if !ok {
+			return nil, nil, nil
+		}
+		return &r.Posted, &r.WorkEnd, &r
+	}},
+	{name: "trees", span: func(t *sectorTimeline, prevEnd *time.Time) (*time.Time, *time.Time, *pipelineRun) {
+		rc, okRC := t.runs["TreeRC"]
+		d, okD := t.runs["TreeD"]
+		switch {
+		case okRC && okD:
+			if d.WorkEnd.After(rc.WorkEnd) {
+				return prevEnd, &d.WorkEnd, &rc
+			}
+			return prevEnd, &rc.WorkEnd, &rc
+		case okRC:
+			return prevEnd, &rc.WorkEnd, &rc
+		default:
+			return nil, nil, nil
+		}
+	}},
+	taskStage("synthetic", "SyntheticProofs"),
+	taskStage("precommit", "PreCommitSubmit"),
+	chainStage("precommit_wait", func(t *sectorTimeline) *time.Time { return t.precommitLanded }),
+	{name: "wait_seed", span: func(t *sectorTimeline, prevEnd *time.Time) (*time.Time, *time.Time, *pipelineRun) {
+		r, ok := t.runs["PoRep"]
+		if !ok {
+			return nil, nil, nil
+		}
+		return prevEnd, &r.Posted, nil
+	}},
+	taskStage("porep", "PoRep"),
+	taskStage("commit", "CommitSubmit"),
+	chainStage("commit_wait", func(t *sectorTimeline) *time.Time { return t.commitLanded }),
+}
+
+// PipelineBottlenecks computes per-stage dwell times of the PoRep pipeline for stages which finished in the
+// last windowHours hours, broken down by the machine which executed each stage.
+func (a *WebRPC) PipelineBottlenecks(ctx context.Context, windowHours int) (*PipelineBottlenecks, error) {
+	if windowHours <= 0 || windowHours > 24*30 {
+		windowHours = 24
+	}
+	to := time.Now()
+	from := to.Add(-time.Duration(windowHours) * time.Hour)
+
+	var runs []pipelineRun
+	err := a.deps.DB.Select(ctx, &runs, `WITH s AS (
+			SELECT DISTINCT e.sp_id, e.sector_number FROM sectors_pipeline_events e
+			JOIN harmony_task_history h ON h.id = e.task_history_id
+			WHERE h.work_end >= $1
+		)
+		SELECT e.sp_id, e.sector_number, h.name, h.posted, h.work_start, h.work_end, h.completed_by_host_and_port
+		FROM s
+		JOIN sectors_pipeline_events e ON e.sp_id = s.sp_id AND e.sector_number = s.sector_number
+		JOIN harmony_task_history h ON h.id = e.task_history_id
+		WHERE h.result
+		ORDER BY h.work_end`, from)
+	if err != nil {
+		return nil, xerrors.Errorf("getting pipeline task history: %w", err)
+	}
+
+	var landings []struct {
+		SpID           int64  `db:"sp_id"`
+		SectorNumber   int64  `db:"sector_number"`
+		PrecommitEpoch *int64 `db:"precommit_epoch"`
+		CommitEpoch    *int64 `db:"commit_epoch"`
+	}
+	err = a.deps.DB.Select(ctx, &landings, `WITH s AS (
+			SELECT DISTINCT e.sp_id, e.sector_number FROM sectors_pipeline_events e
+			JOIN harmony_task_history h ON h.id = e.task_history_id
+			WHERE h.work_end >= $1
+		)
+		SELECT s.sp_id, s.sector_number, pw.executed_tsk_epoch AS precommit_epoch, cw.executed_tsk_epoch AS commit_epoch
+		FROM s
+		LEFT JOIN sectors_sdr_pipeline p ON p.sp_id = s.sp_id AND p.sector_number = s.sector_number
+		LEFT JOIN sectors_meta sm ON sm.sp_id = s.sp_id AND sm.sector_num = s.sector_number
+		LEFT JOIN message_waits pw ON pw.signed_message_cid = COALESCE(p.precommit_msg_cid, sm.msg_cid_precommit)
+		LEFT JOIN message_waits cw ON cw.signed_message_cid = COALESCE(p.commit_msg_cid, sm.msg_cid_commit)`, from)
+	if err != nil {
+		return nil, xerrors.Errorf("getting message landing epochs: %w", err)
+	}
+
+	type sectorKey struct{ sp, num int64 }
+	timelines := map[sectorKey]*sectorTimeline{}
+	for _, r := range runs {
+		k := sectorKey{r.SpID, r.SectorNumber}
+		t, ok := timelines[k]
+		if !ok {
+			t = &sectorTimeline{runs: map[string]pipelineRun{}}
+			timelines[k] = t
+		}
+		t.runs[r.Name] = r // ordered by work_end, so the last successful run wins
+	}
+
+	if len(landings) > 0 {
+		head, err := a.deps.Chain.ChainHead(ctx)
+		if err != nil {
+			return nil, xerrors.Errorf("getting chain head: %w", err)
+		}
+		epochTime := func(e *int64) *time.Time {
+			if e == nil {
+				return nil
+			}
+			t := time.Unix(int64(head.MinTimestamp())-int64(build.BlockDelaySecs)*(int64(head.Height())-*e), 0)
+			return &t
+		}
+
+		for _, l := range landings {
+			t, ok := timelines[sectorKey{l.SpID, l.SectorNumber}]
+			if !ok {
+				continue
+			}
+			t.precommitLanded = epochTime(l.PrecommitEpoch)
+			t.commitLanded = epochTime(l.CommitEpoch)
+		}
+	}
+
+	type machineAgg struct {
+		dwell, work float64
+		n           int
+	}
+	dwells := make([][]float64, len(porepStages))
+	machines := make([]map[string]*machineAgg, len(porepStages))
+	for i := range machines {
+		machines[i] = map[string]*machineAgg{}
+	}
+
+	for _, t := range timelines {
+		var prevEnd *time.Time
+		for i, st := range porepStages {
+			start, end, run := st.span(t, prevEnd)
+			if end == nil {
+				// later stages are measured from the last known stage end
+				continue
+			}
+			prevEnd = end
+
+			if start == nil || end.Before(*start) || end.Before(from) || end.After(to) {
+				continue
+			}
+
+			d := end.Sub(*start).Seconds()
+			dwells[i] = append(dwells[i], d)
+
+			if run != nil {
+				m, ok := machines[i][run.Machine]
+				if !ok {
+					m = &machineAgg{}
+					machines[i][run.Machine] = m
+				}
+				m.dwell += d
+				m.work += run.WorkEnd.Sub(run.WorkStart).Seconds()
+				m.n++
+			}
+		}
+	}
+
+	out := &PipelineBottlenecks{
+		From:    from,
+		To:      to,
+		Sectors: len(timelines),
+	}
+
+	var limitingAvg float64
+	for i, st := range porepStages {
+		ds := dwells[i]
+		stats := PipelineStageStats{Stage: st.name, Samples: len(ds)}
+		if len(ds) > 0 {
+			sort.Float64s(ds)
+			var sum float64
+			for _, d := range ds {
+				sum += d
+			}
+			stats.AvgDwell = sum / float64(len(ds))
+			stats.P50Dwell = ds[len(ds)/2]
+			stats.P90Dwell = ds[len(ds)*9/10]
+			stats.MaxDwell = ds[len(ds)-1]
+		}
+
+		var slowest float64
+		var slowestMachine string
+		for name, m := range machines[i] {
+			pm := PipelineStageMachine{
+				Machine:  name,
+				Samples:  m.n,
+				AvgDwell: m.dwell / float64(m.n),
+				AvgWork:  m.work / float64(m.n),
+			}
+			stats.Machines = append(stats.Machines, pm)
+			if pm.AvgDwell > slowest {
+				slowest, slowestMachine = pm.AvgDwell, name
+			}
+		}
+		sort.Slice(stats.Machines, func(a, b int) bool { return stats.Machines[a].AvgDwell > stats.Machines[b].AvgDwell })
+
+		if len(machines[i]) > 0 && stats.AvgDwell > limitingAvg {
+			limitingAvg = stats.AvgDwell
+			out.LimitingStage = st.name
+			out.LimitingMachine = slowestMachine
+		}
+
+		out.Stages = append(out.Stages, stats)
+	}
+
+	return out, nil
+}
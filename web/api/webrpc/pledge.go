@@ -0,0 +1,70 @@
+package webrpc
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/tasks/pledge"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type PledgeSchedule struct {
+	pledge.Schedule
+	Miner string
+}
+
+// PledgeSchedules lists the CC pledge schedules with the outcome of their last run.
+func (a *WebRPC) PledgeSchedules(ctx context.Context) ([]PledgeSchedule, error) {
+	schedules, err := pledge.Schedules(ctx, a.deps.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]PledgeSchedule, 0, len(schedules))
+	for _, s := range schedules {
+		maddr, err := address.NewIDAddress(uint64(s.SpID))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, PledgeSchedule{Schedule: s, Miner: maddr.String()})
+	}
+	return out, nil
+}
+
+// PledgeScheduleSet makes the CCPledge task add up to sectorsPerDay CC sectors per day to the sealing pipeline of a
+// miner, while fewer than maxPipeline of its sectors are in the pipeline. durationDays is the commitment duration of
+// the sectors, 0 for the default.
+func (a *WebRPC) PledgeScheduleSet(ctx context.Context, miner string, sectorsPerDay, maxPipeline, durationDays int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	if err := pledge.SetSchedule(ctx, a.deps.DB, maddr, sectorsPerDay, maxPipeline, durationDays); err != nil {
+		return err
+	}
+
+	log.Infow("CC pledge schedule set", "miner", miner, "sectors_per_day", sectorsPerDay, "max_pipeline", maxPipeline, "duration_days", durationDays)
+	return nil
+}
+
+// PledgeScheduleRemove stops pledging CC sectors for a miner.
+func (a *WebRPC) PledgeScheduleRemove(ctx context.Context, miner string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	return pledge.RemoveSchedule(ctx, a.deps.DB, maddr)
+}
@@ -0,0 +1,87 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/repair"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type SectorRepair struct {
+	RepairID        int64      `db:"repair_id"`
+	SpID            int64      `db:"sp_id"`
+	SectorNumber    int64      `db:"sector_number"`
+	StorageID       string     `db:"storage_id"`
+	FileTypes       int64      `db:"file_types"`
+	DetectedBy      string     `db:"detected_by"`
+	Reason          string     `db:"reason"`
+	Method          *string    `db:"method"`
+	SourceStorageID *string    `db:"source_storage_id"`
+	CreateTime      time.Time  `db:"create_time"`
+	CompleteTime    *time.Time `db:"complete_time"`
+	TaskID          *int64     `db:"task_id"`
+	Error           *string    `db:"error"`
+
+	Miner string   `db:"-"`
+	Files []string `db:"-"`
+}
+
+// SectorRepairs returns a page of sector repairs, newest first.
+func (a *WebRPC) SectorRepairs(ctx context.Context, req PageRequest) (*Page[SectorRepair], error) {
+	req = req.normalize()
+	if err := req.checkSort(); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		SectorRepair
+		Total int `db:"total"`
+	}
+	err := a.deps.DB.Select(ctx, &rows, `SELECT repair_id, sp_id, sector_number, storage_id, file_types, detected_by, reason, method,
+			source_storage_id, create_time, complete_time, task_id, error, COUNT(*) OVER () AS total
+		FROM sector_repairs ORDER BY repair_id DESC LIMIT $1 OFFSET $2`, req.Limit, req.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Page[SectorRepair]{Items: make([]SectorRepair, 0, len(rows))}
+	for _, r := range rows {
+		maddr, err := address.NewIDAddress(uint64(r.SpID))
+		if err != nil {
+			return nil, err
+		}
+		r.Miner = maddr.String()
+		r.Files = storiface.SectorFileType(r.FileTypes).Strings()
+
+		out.Total = r.Total
+		out.Items = append(out.Items, r.SectorRepair)
+	}
+	return out, nil
+}
+
+// SectorRepairCopy schedules a repair of all files of the sector held in the storage path.
+func (a *WebRPC) SectorRepairCopy(ctx context.Context, spID, sectorNum int64, storageID string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	var ft *int64
+	err := a.deps.DB.QueryRow(ctx, `SELECT bit_or(sector_filetype) FROM sector_location
+		WHERE miner_id = $1 AND sector_num = $2 AND storage_id = $3`, spID, sectorNum, storageID).Scan(&ft)
+	if err != nil {
+		return xerrors.Errorf("getting sector files in %s: %w", storageID, err)
+	}
+	if ft == nil {
+		return xerrors.Errorf("storage path %s holds no files of sector %d", storageID, sectorNum)
+	}
+
+	sid := abi.SectorID{Miner: abi.ActorID(spID), Number: abi.SectorNumber(sectorNum)}
+	return repair.Schedule(ctx, a.deps.DB, sid, storiface.ID(storageID), storiface.SectorFileType(*ft), repair.DetectedByManual, "requested through the web API")
+}
@@ -0,0 +1,93 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/go-units"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/market/retrieval"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type RetrievalToken struct {
+	ID          int64      `db:"id"`
+	Name        string     `db:"name"`
+	Bandwidth   *int64     `db:"bandwidth_limit"`
+	CreatedAt   time.Time  `db:"created_at"`
+	ExpiresAt   *time.Time `db:"expires_at"`
+	LastUsed    *time.Time `db:"last_used"`
+	Revoked     bool       `db:"revoked"`
+	BytesServed int64      `db:"bytes_served"`
+
+	BandwidthStr   string
+	BytesServedStr string
+}
+
+// RetrievalTokenCreate issues a new token for clients of the retrieval server. bandwidth is a size per second
+// (e.g. "50MiB"), empty means Retrieval.ClientBandwidth applies. ttl is a duration string (e.g. "720h"), empty means
+// no expiry. The returned token is not stored and can't be retrieved again.
+func (a *WebRPC) RetrievalTokenCreate(ctx context.Context, name string, bandwidth string, ttl string) (string, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAdmin); err != nil {
+		return "", err
+	}
+
+	var bw int64
+	if bandwidth != "" {
+		var err error
+		bw, err = units.RAMInBytes(bandwidth)
+		if err != nil {
+			return "", xerrors.Errorf("parsing bandwidth: %w", err)
+		}
+	}
+
+	var d time.Duration
+	if ttl != "" {
+		var err error
+		d, err = time.ParseDuration(ttl)
+		if err != nil {
+			return "", xerrors.Errorf("parsing ttl: %w", err)
+		}
+	}
+
+	id, token, err := retrieval.CreateToken(ctx, a.deps.DB, name, uint64(bw), d)
+	if err != nil {
+		return "", err
+	}
+
+	log.Infow("Created retrieval token", "id", id, "name", name, "bandwidth", bandwidth, "ttl", ttl)
+	return token, nil
+}
+
+func (a *WebRPC) RetrievalTokenList(ctx context.Context) ([]RetrievalToken, error) {
+	var tokens []RetrievalToken
+	err := a.deps.DB.Select(ctx, &tokens, `SELECT id, name, bandwidth_limit, created_at, expires_at, last_used, revoked, bytes_served
+		FROM retrieval_tokens ORDER BY id`)
+	if err != nil {
+		return nil, xerrors.Errorf("listing retrieval tokens: %w", err)
+	}
+
+	for i, t := range tokens {
+		if t.Bandwidth != nil {
+			tokens[i].BandwidthStr = units.BytesSize(float64(*t.Bandwidth)) + "/s"
+		}
+		tokens[i].BytesServedStr = units.BytesSize(float64(t.BytesServed))
+	}
+	return tokens, nil
+}
+
+func (a *WebRPC) RetrievalTokenRevoke(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAdmin); err != nil {
+		return err
+	}
+
+	n, err := a.deps.DB.Exec(ctx, `UPDATE retrieval_tokens SET revoked = TRUE WHERE id = $1`, id)
+	if err != nil {
+		return xerrors.Errorf("revoking retrieval token: %w", err)
+	}
+	if n != 1 {
+		return xerrors.Errorf("retrieval token %d not found", id)
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/filecoin-project/curio/build"
 	"github.com/filecoin-project/curio/deps"
 	"github.com/filecoin-project/curio/lib/curiochain"
+	"github.com/filecoin-project/curio/web/api/apiauth"
 
 	"github.com/filecoin-project/lotus/blockstore"
 	"github.com/filecoin-project/lotus/chain/actors/adt"
@@ -24,6 +25,8 @@ type WebRPC struct {
 	deps      *deps.Deps
 	taskSPIDs map[string]SpidGetter
 	stor      adt.Store
+
+	federation []*federatedCluster
 }
 
 func (a *WebRPC) Version(context.Context) (string, error) {
@@ -41,15 +44,20 @@ func Routes(r *mux.Router, deps *deps.Deps, debug bool) {
 		taskSPIDs: makeTaskSPIDs(),
 	}
 
-	opt := []jsonrpc.ServerOption{}
-	if debug {
-		opt = append(opt, jsonrpc.WithTracer(func(method string, params []reflect.Value, results []reflect.Value, err error) {
+	federation, err := newFederation(context.Background(), deps.Cfg.Web.Federation)
+	if err != nil {
+		log.Errorw("federated views will only include this cluster", "error", err)
+	}
+	handler.federation = federation
+
+	rpcSrv := jsonrpc.NewServer(jsonrpc.WithTracer(func(method string, params []reflect.Value, results []reflect.Value, err error) {
+		apiauth.RPCTracer(method, params, results, err)
+
+		if debug {
 			resNil := len(results) == 0 || (len(results) == 1 && results[0].IsNil())
 			log.Infow("WebRPC call", "method", method, "params", params, "resultsNil?", resNil, "error", err)
-		}))
-
-	}
-	rpcSrv := jsonrpc.NewServer(opt...)
+		}
+	}))
 	rpcSrv.Register("CurioWeb", handler)
 	r.Handle("/v0", rpcSrv)
 }
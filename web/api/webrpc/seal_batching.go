@@ -0,0 +1,20 @@
+package webrpc
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/tasks/seal"
+)
+
+// SealBatchStatus returns the precommit and commit batches waiting to be sent, with when and why they will be sent
+// under the Batching config of this node, and the batch tasks which didn't send their message yet.
+func (a *WebRPC) SealBatchStatus(ctx context.Context) (*seal.BatchStatus, error) {
+	head, err := a.deps.Chain.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	return seal.GetBatchStatus(ctx, a.deps.DB, head, a.deps.Cfg)
+}
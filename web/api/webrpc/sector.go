@@ -15,6 +15,7 @@ import (
 
 	"github.com/filecoin-project/curio/lib/paths"
 	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/web/api/apiauth"
 
 	"github.com/filecoin-project/lotus/chain/types"
 )
@@ -115,7 +116,7 @@ func (a *WebRPC) SectorInfo(ctx context.Context, sp string, intid int64) (*Secto
 
 	err = a.deps.DB.Select(ctx, &tasks, `SELECT 
        sp_id, sector_number,
-       create_time,
+       create_time, reg_seal_proof,
        task_id_sdr, after_sdr,
        task_id_tree_d, after_tree_d,
        task_id_tree_c, after_tree_c,
@@ -155,6 +156,7 @@ func (a *WebRPC) SectorInfo(ctx context.Context, sp string, intid int64) (*Secto
 		sle = &sectorListEntry{
 			PipelineTask: tasks[0],
 			AfterSeed:    task.SeedEpoch != nil && *task.SeedEpoch <= int64(epoch),
+			Synthetic:    abi.Synthetic[abi.RegisteredSealProof(task.RegSealProof)],
 
 			ChainAlloc:    must.One(mbf.alloc.IsSet(uint64(task.SectorNumber))),
 			ChainSector:   must.One(mbf.sectorSet.IsSet(uint64(task.SectorNumber))),
@@ -415,6 +417,9 @@ func (a *WebRPC) SectorInfo(ctx context.Context, sp string, intid int64) (*Secto
 }
 
 func (a *WebRPC) SectorResume(ctx context.Context, spid, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	_, err := a.deps.DB.Exec(ctx, `SELECT unset_task_id($1, $2)`, spid, id)
 	if err != nil {
 		return xerrors.Errorf("failed to resume sector: %w", err)
@@ -423,6 +428,9 @@ func (a *WebRPC) SectorResume(ctx context.Context, spid, id int64) error {
 }
 
 func (a *WebRPC) SectorRemove(ctx context.Context, spid, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	_, err := a.deps.DB.Exec(ctx, `DELETE FROM batch_sector_refs WHERE sp_id = $1 AND sector_number = $2`, spid, id)
 	if err != nil {
 		return xerrors.Errorf("failed to remove sector batch refs: %w", err)
@@ -444,6 +452,9 @@ func (a *WebRPC) SectorRemove(ctx context.Context, spid, id int64) error {
 }
 
 func (a *WebRPC) SectorRestart(ctx context.Context, spid, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	_, err := a.deps.DB.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_sdr = false, after_tree_d = false, after_tree_c = false,
                                 after_tree_r = false WHERE sp_id = $1 AND sector_number = $2`, spid, id)
 	if err != nil {
@@ -0,0 +1,71 @@
+package webrpc
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/curio/tasks/sectorops"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type AutoExtendPolicy struct {
+	sectorops.AutoExtendPolicy
+	Miner string
+}
+
+// AutoExtendPolicies lists the automatic sector extension policies with the outcome of their last run.
+func (a *WebRPC) AutoExtendPolicies(ctx context.Context) ([]AutoExtendPolicy, error) {
+	policies, err := sectorops.AutoExtendPolicies(ctx, a.deps.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]AutoExtendPolicy, 0, len(policies))
+	for _, p := range policies {
+		maddr, err := address.NewIDAddress(uint64(p.SpID))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, AutoExtendPolicy{AutoExtendPolicy: p, Miner: maddr.String()})
+	}
+	return out, nil
+}
+
+// AutoExtendPolicySet makes the AutoExtend task extend sectors of a miner expiring within WindowDays by
+// ExtensionDays, when the expected block reward over the extension is at least MinRewardRatio times the message
+// fees. Only the policy fields of p are used.
+func (a *WebRPC) AutoExtendPolicySet(ctx context.Context, miner string, p sectorops.AutoExtendPolicy) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	if err := sectorops.SetAutoExtendPolicy(ctx, a.deps.DB, maddr, p); err != nil {
+		return err
+	}
+
+	log.Infow("auto extend policy set", "miner", miner, "window_days", p.WindowDays, "extension_days", p.ExtensionDays,
+		"min_reward_ratio", p.MinRewardRatio, "include_cc", p.IncludeCC, "include_deals", p.IncludeDeals, "drop_claims", p.DropClaims)
+	return nil
+}
+
+// AutoExtendPolicyRemove stops extending sectors of a miner automatically.
+func (a *WebRPC) AutoExtendPolicyRemove(ctx context.Context, miner string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	return sectorops.RemoveAutoExtendPolicy(ctx, a.deps.DB, maddr)
+}
@@ -0,0 +1,150 @@
+package webrpc
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/tasks/sectorops"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type DealCleanupPolicy struct {
+	sectorops.DealCleanupPolicy
+	Miner string
+}
+
+// DealCleanupPolicies lists the deal cleanup policies with the outcome of their last run.
+func (a *WebRPC) DealCleanupPolicies(ctx context.Context) ([]DealCleanupPolicy, error) {
+	policies, err := sectorops.DealCleanupPolicies(ctx, a.deps.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]DealCleanupPolicy, 0, len(policies))
+	for _, p := range policies {
+		maddr, err := address.NewIDAddress(uint64(p.SpID))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, DealCleanupPolicy{DealCleanupPolicy: p, Miner: maddr.String()})
+	}
+	return out, nil
+}
+
+// DealCleanupPolicySet makes the DealCleanup task look for sectors of a miner whose deals all ended GraceDays ago,
+// and report them, let them expire or terminate them depending on Action. Only the policy fields of p are used.
+func (a *WebRPC) DealCleanupPolicySet(ctx context.Context, miner string, p sectorops.DealCleanupPolicy) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	if err := sectorops.SetDealCleanupPolicy(ctx, a.deps.DB, maddr, p); err != nil {
+		return err
+	}
+
+	log.Infow("deal cleanup policy set", "miner", miner, "action", p.Action, "grace_days", p.GraceDays,
+		"max_termination_fee", p.MaxTerminationFee)
+	return nil
+}
+
+// DealCleanupPolicyRemove stops looking for sectors of a miner whose deals all ended.
+func (a *WebRPC) DealCleanupPolicyRemove(ctx context.Context, miner string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	return sectorops.RemoveDealCleanupPolicy(ctx, a.deps.DB, maddr)
+}
+
+// DealCleanupCandidates returns the sectors of a miner found by the last runs of its deal cleanup policy, with the
+// action taken on each.
+func (a *WebRPC) DealCleanupCandidates(ctx context.Context, miner string) ([]sectorops.DealCleanupCandidate, error) {
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	return sectorops.DealCleanupCandidates(ctx, a.deps.DB, maddr)
+}
+
+type DealCleanupPreview struct {
+	Epoch   int64
+	Sectors []DealCleanupPreviewSector
+
+	// TotalFee is the sum of the termination fees of the sectors which can be terminated now, each simulated alone
+	TotalFee string
+}
+
+type DealCleanupPreviewSector struct {
+	sectorops.DealCleanupCandidate
+	Termination sectorops.SectorTermination
+}
+
+// DealCleanupPreview finds the sectors of a miner whose deals all ended graceDays ago, up to limit, and simulates
+// their termination, without recording or sending anything.
+func (a *WebRPC) DealCleanupPreview(ctx context.Context, miner string, graceDays, limit int64) (*DealCleanupPreview, error) {
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing miner address: %w", err)
+	}
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("id from %s: %w", maddr, err)
+	}
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	head, err := a.deps.Chain.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	cands, err := sectorops.FindDealCleanupCandidates(ctx, a.deps.DB, int64(spID), head.Height(), graceDays, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &DealCleanupPreview{Epoch: int64(head.Height()), TotalFee: types.FIL(big.Zero()).Short()}
+	if len(cands) == 0 {
+		return out, nil
+	}
+
+	nums := make([]abi.SectorNumber, len(cands))
+	for i, c := range cands {
+		nums[i] = abi.SectorNumber(c.SectorNum)
+	}
+	plan, err := sectorops.PlanTermination(ctx, a.deps.Chain, a.deps.Bstore, maddr, nums)
+	if err != nil {
+		return nil, err
+	}
+	byNum := map[int64]sectorops.SectorTermination{}
+	total := big.Zero()
+	for _, st := range plan.Sectors {
+		byNum[int64(st.SectorNumber)] = st
+		total = big.Add(total, st.Fee)
+	}
+
+	for _, c := range cands {
+		out.Sectors = append(out.Sectors, DealCleanupPreviewSector{DealCleanupCandidate: c, Termination: byNum[c.SectorNum]})
+	}
+	out.TotalFee = types.FIL(total).Short()
+	return out, nil
+}
@@ -0,0 +1,281 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+
+	"github.com/filecoin-project/curio/tasks/sectorops"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/verifreg"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type SectorExpirationEntry struct {
+	SectorNum    int64  `db:"sector_num"`
+	RegSealProof int64  `db:"reg_seal_proof"`
+	IsCC         *bool  `db:"is_cc"`
+	Expiration   int64  `db:"expiration_epoch"`
+	Deadline     *int64 `db:"deadline"`
+	Partition    *int64 `db:"partition"`
+
+	Days int64 `db:"-"`
+
+	// Verified claims in the sector. ClaimsMaxTerm is the earliest end of the maximum term of the claims,
+	// the sector can't be extended past it without dropping claims.
+	Claims        int    `db:"-"`
+	ClaimedBytes  int64  `db:"-"`
+	ClaimsMaxTerm *int64 `db:"-"`
+}
+
+// SectorExpirationList returns sectors of a miner expiring between the from and to epochs, with their
+// verified claims. A zero from is the current epoch, a zero to 60 days after from.
+func (a *WebRPC) SectorExpirationList(ctx context.Context, sp string, from, to int64, limit int) ([]SectorExpirationEntry, error) {
+	maddr, err := address.NewFromString(sp)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing miner address: %w", err)
+	}
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("id from %s: %w", maddr, err)
+	}
+	if limit <= 0 || limit > 10000 {
+		limit = 1000
+	}
+
+	head, err := a.deps.Chain.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+	if from == 0 {
+		from = int64(head.Height())
+	}
+	if to == 0 {
+		to = from + 60*builtin.EpochsInDay
+	}
+
+	var sectors []SectorExpirationEntry
+	err = a.deps.DB.Select(ctx, &sectors, `SELECT sector_num, reg_seal_proof, is_cc, expiration_epoch, deadline, partition
+		FROM sectors_meta
+		WHERE sp_id = $1 AND expiration_epoch >= $2 AND expiration_epoch <= $3
+		ORDER BY expiration_epoch, sector_num
+		LIMIT $4`, spID, from, to, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sectors: %w", err)
+	}
+	if len(sectors) == 0 {
+		return sectors, nil
+	}
+
+	vact, err := a.deps.Chain.StateGetActor(ctx, builtin.VerifiedRegistryActorAddr, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting verifreg actor: %w", err)
+	}
+	vst, err := verifreg.Load(a.stor, vact)
+	if err != nil {
+		return nil, xerrors.Errorf("loading verifreg state: %w", err)
+	}
+	claims, err := vst.GetClaims(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting claims: %w", err)
+	}
+	claimsBySector, err := vst.GetClaimIdsBySector(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting claim IDs by sector: %w", err)
+	}
+
+	for i := range sectors {
+		s := &sectors[i]
+		s.Days = (s.Expiration - int64(head.Height())) * builtin.EpochDurationSeconds / (60 * 60 * 24)
+
+		for _, id := range claimsBySector[abi.SectorNumber(s.SectorNum)] {
+			claim, ok := claims[id]
+			if !ok {
+				continue
+			}
+			s.Claims++
+			s.ClaimedBytes += int64(claim.Size)
+
+			end := int64(claim.TermStart + claim.TermMax)
+			if s.ClaimsMaxTerm == nil || end < *s.ClaimsMaxTerm {
+				s.ClaimsMaxTerm = &end
+			}
+		}
+	}
+
+	return sectors, nil
+}
+
+// SectorExtendParams selects sectors to extend. Exactly one of ExtensionDays and NewExpiration must be set.
+type SectorExtendParams struct {
+	Miner   string
+	Sectors []int64
+
+	ExtensionDays int64
+	NewExpiration int64
+
+	DropClaims bool
+}
+
+func (p *SectorExtendParams) request() (address.Address, sectorops.ExtendRequest, error) {
+	maddr, err := address.NewFromString(p.Miner)
+	if err != nil {
+		return address.Undef, sectorops.ExtendRequest{}, xerrors.Errorf("parsing miner address: %w", err)
+	}
+	if len(p.Sectors) == 0 {
+		return address.Undef, sectorops.ExtendRequest{}, xerrors.Errorf("no sectors selected")
+	}
+	if (p.ExtensionDays > 0) == (p.NewExpiration > 0) {
+		return address.Undef, sectorops.ExtendRequest{}, xerrors.Errorf("exactly one of extension days and new expiration must be set")
+	}
+
+	req := sectorops.ExtendRequest{
+		Extension:     abi.ChainEpoch(p.ExtensionDays * builtin.EpochsInDay),
+		NewExpiration: abi.ChainEpoch(p.NewExpiration),
+		DropClaims:    p.DropClaims,
+	}
+	for _, s := range p.Sectors {
+		req.Sectors = append(req.Sectors, abi.SectorNumber(s))
+	}
+	return maddr, req, nil
+}
+
+type SectorExtendMessage struct {
+	Sectors    int
+	GasLimit   int64
+	GasFeeCap  string
+	GasPremium string
+	MaxFee     string // fee cap times gas limit, what the message can cost at most
+}
+
+type SectorExtendPreview struct {
+	Epoch   int64
+	Sectors []sectorops.SectorExtension
+
+	Extended    int
+	Messages    []SectorExtendMessage
+	TotalMaxFee string
+}
+
+// SectorExtendPreview plans an extension without sending anything, returning the new expiration of each
+// sector, the reason for skipping sectors which can't be extended and the estimated cost of the messages.
+func (a *WebRPC) SectorExtendPreview(ctx context.Context, p SectorExtendParams) (*SectorExtendPreview, error) {
+	maddr, req, err := p.request()
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := sectorops.PlanExtension(ctx, a.deps.Chain, a.deps.Bstore, maddr, req)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := sectorops.ExtendMessages(ctx, a.deps.Chain, maddr, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &SectorExtendPreview{
+		Epoch:    int64(plan.Epoch),
+		Sectors:  plan.Sectors,
+		Extended: plan.Extended(),
+	}
+
+	spec := &api.MessageSendSpec{MaxFee: abi.TokenAmount(a.deps.Cfg.Fees.MaxExtendGasFee)}
+	total := big.Zero()
+	for i, msg := range msgs {
+		est, err := a.deps.Chain.GasEstimateMessageGas(ctx, msg, spec, types.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("estimating gas of message %d: %w", i, err)
+		}
+
+		sectors, err := sectorops.ParamsSectorCount(&plan.Params[i])
+		if err != nil {
+			return nil, err
+		}
+
+		maxFee := est.RequiredFunds()
+		total = big.Add(total, maxFee)
+		out.Messages = append(out.Messages, SectorExtendMessage{
+			Sectors:    sectors,
+			GasLimit:   est.GasLimit,
+			GasFeeCap:  types.FIL(est.GasFeeCap).Short(),
+			GasPremium: types.FIL(est.GasPremium).Short(),
+			MaxFee:     types.FIL(maxFee).Short(),
+		})
+	}
+	out.TotalMaxFee = types.FIL(total).Short()
+
+	return out, nil
+}
+
+// SectorExtend queues an extension executed by the ExtendSectors task. Returns the ID of the queued
+// operation.
+func (a *WebRPC) SectorExtend(ctx context.Context, p SectorExtendParams) (int64, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return 0, err
+	}
+
+	maddr, req, err := p.request()
+	if err != nil {
+		return 0, err
+	}
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return 0, xerrors.Errorf("id from %s: %w", maddr, err)
+	}
+
+	var extension, newExpiration *int64
+	if req.Extension > 0 {
+		extension = (*int64)(&req.Extension)
+	} else {
+		newExpiration = (*int64)(&req.NewExpiration)
+	}
+
+	var opID int64
+	err = a.deps.DB.QueryRow(ctx, `INSERT INTO sector_extend_ops (sp_id, sectors, extension, new_expiration, drop_claims, max_fee)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING op_id`,
+		spID, p.Sectors, extension, newExpiration, req.DropClaims, abi.TokenAmount(a.deps.Cfg.Fees.MaxExtendGasFee).String()).Scan(&opID)
+	if err != nil {
+		return 0, xerrors.Errorf("queueing extension: %w", err)
+	}
+
+	return opID, nil
+}
+
+type SectorExtendOp struct {
+	OpID          int64      `db:"op_id"`
+	SpID          int64      `db:"sp_id"`
+	Sectors       int64      `db:"sectors"`
+	Extension     *int64     `db:"extension"`
+	NewExpiration *int64     `db:"new_expiration"`
+	DropClaims    bool       `db:"drop_claims"`
+	AutoExtend    bool       `db:"auto_extend"`
+	CreateTime    time.Time  `db:"create_time"`
+	CompleteTime  *time.Time `db:"complete_time"`
+	TaskID        *int64     `db:"task_id"`
+	Extended      *int64     `db:"extended"`
+	MessageCIDs   []string   `db:"message_cids"`
+	Error         *string    `db:"error"`
+}
+
+// SectorExtendOps returns recent extension operations with the messages they sent.
+func (a *WebRPC) SectorExtendOps(ctx context.Context) ([]SectorExtendOp, error) {
+	var ops []SectorExtendOp
+	err := a.deps.DB.Select(ctx, &ops, `SELECT op_id, sp_id, cardinality(sectors) AS sectors, extension, new_expiration, drop_claims,
+			auto_extend, create_time, complete_time, task_id, extended, message_cids, error
+		FROM sector_extend_ops
+		ORDER BY op_id DESC
+		LIMIT 100`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting extend ops: %w", err)
+	}
+	return ops, nil
+}
@@ -0,0 +1,194 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/tasks/sectorops"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// SectorTerminateParams selects sectors to terminate. With RemoveData the files of terminated sectors are marked
+// for removal, pending approval like other removals.
+type SectorTerminateParams struct {
+	Miner      string
+	Sectors    []int64
+	RemoveData bool
+}
+
+func (p *SectorTerminateParams) request() (address.Address, []abi.SectorNumber, error) {
+	maddr, err := address.NewFromString(p.Miner)
+	if err != nil {
+		return address.Undef, nil, xerrors.Errorf("parsing miner address: %w", err)
+	}
+	if len(p.Sectors) == 0 {
+		return address.Undef, nil, xerrors.Errorf("no sectors selected")
+	}
+
+	sectors := make([]abi.SectorNumber, len(p.Sectors))
+	for i, s := range p.Sectors {
+		sectors[i] = abi.SectorNumber(s)
+	}
+	return maddr, sectors, nil
+}
+
+type SectorTerminatePreview struct {
+	Epoch    int64
+	Sectors  []sectorops.SectorTermination
+	Messages int
+
+	// TotalFee is the sum of the termination fees of the sectors terminated now, each simulated alone
+	TotalFee string
+}
+
+// SectorTerminatePreview plans a termination without sending anything, returning the simulated termination fee of
+// each sector and which sectors would be skipped or deferred.
+func (a *WebRPC) SectorTerminatePreview(ctx context.Context, p SectorTerminateParams) (*SectorTerminatePreview, error) {
+	maddr, sectors, err := p.request()
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := sectorops.PlanTermination(ctx, a.deps.Chain, a.deps.Bstore, maddr, sectors)
+	if err != nil {
+		return nil, err
+	}
+
+	total := big.Zero()
+	for _, s := range plan.Sectors {
+		total = big.Add(total, s.Fee)
+	}
+
+	return &SectorTerminatePreview{
+		Epoch:    int64(plan.Epoch),
+		Sectors:  plan.Sectors,
+		Messages: len(plan.Messages),
+		TotalFee: types.FIL(total).Short(),
+	}, nil
+}
+
+// SectorTerminate queues a termination executed by the TerminateSectors task. Returns the ID of the queued
+// operation.
+func (a *WebRPC) SectorTerminate(ctx context.Context, p SectorTerminateParams) (int64, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return 0, err
+	}
+
+	maddr, _, err := p.request()
+	if err != nil {
+		return 0, err
+	}
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return 0, xerrors.Errorf("id from %s: %w", maddr, err)
+	}
+
+	var opID int64
+	_, err = a.deps.DB.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		err = tx.QueryRow(`INSERT INTO sector_terminate_ops (sp_id, remove_data, max_fee) VALUES ($1, $2, $3) RETURNING op_id`,
+			spID, p.RemoveData, abi.TokenAmount(a.deps.Cfg.Fees.MaxTerminateGasFee).String()).Scan(&opID)
+		if err != nil {
+			return false, xerrors.Errorf("queueing termination: %w", err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO sector_terminate_items (op_id, sector_number)
+			SELECT $1, unnest($2::BIGINT[]) ON CONFLICT DO NOTHING`, opID, p.Sectors)
+		if err != nil {
+			return false, xerrors.Errorf("queueing sectors: %w", err)
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return 0, err
+	}
+
+	return opID, nil
+}
+
+type SectorTerminateOp struct {
+	OpID         int64      `db:"op_id"`
+	SpID         int64      `db:"sp_id"`
+	RemoveData   bool       `db:"remove_data"`
+	CreateTime   time.Time  `db:"create_time"`
+	CompleteTime *time.Time `db:"complete_time"`
+	TaskID       *int64     `db:"task_id"`
+	NextAttempt  *time.Time `db:"next_attempt"`
+	Error        *string    `db:"error"`
+
+	// Sector counts by state
+	Pending    int64 `db:"pending"`
+	Sent       int64 `db:"sent"`
+	Terminated int64 `db:"terminated"`
+	Skipped    int64 `db:"skipped"`
+	Failed     int64 `db:"failed"`
+
+	Messages int64 `db:"messages"`
+
+	// FeeBurnt is the termination fee burnt by landed messages, in attoFIL
+	FeeBurnt    string `db:"fee_burnt"`
+	FeeBurntStr string `db:"-"`
+}
+
+// SectorTerminateOps returns recent termination operations with the number of sectors in each state and the
+// termination fees paid so far.
+func (a *WebRPC) SectorTerminateOps(ctx context.Context) ([]SectorTerminateOp, error) {
+	var ops []SectorTerminateOp
+	err := a.deps.DB.Select(ctx, &ops, `SELECT o.op_id, o.sp_id, o.remove_data, o.create_time, o.complete_time, o.task_id,
+			o.next_attempt, o.error,
+			(SELECT COUNT(*) FROM sector_terminate_items i WHERE i.op_id = o.op_id AND i.state = 'pending') AS pending,
+			(SELECT COUNT(*) FROM sector_terminate_items i WHERE i.op_id = o.op_id AND i.state = 'sent') AS sent,
+			(SELECT COUNT(*) FROM sector_terminate_items i WHERE i.op_id = o.op_id AND i.state = 'terminated') AS terminated,
+			(SELECT COUNT(*) FROM sector_terminate_items i WHERE i.op_id = o.op_id AND i.state = 'skipped') AS skipped,
+			(SELECT COUNT(*) FROM sector_terminate_items i WHERE i.op_id = o.op_id AND i.state = 'failed') AS failed,
+			(SELECT COUNT(*) FROM sector_terminate_messages m WHERE m.op_id = o.op_id) AS messages,
+			(SELECT COALESCE(SUM(m.fee_burnt::NUMERIC), 0)::TEXT FROM sector_terminate_messages m WHERE m.op_id = o.op_id) AS fee_burnt
+		FROM sector_terminate_ops o
+		ORDER BY o.op_id DESC
+		LIMIT 100`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting terminate ops: %w", err)
+	}
+
+	for i := range ops {
+		fee, err := big.FromString(ops[i].FeeBurnt)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing burnt fee of op %d: %w", ops[i].OpID, err)
+		}
+		ops[i].FeeBurntStr = types.FIL(fee).Short()
+	}
+	return ops, nil
+}
+
+type SectorTerminateItem struct {
+	SectorNumber     int64      `db:"sector_number"`
+	State            string     `db:"state"`
+	Attempts         int64      `db:"attempts"`
+	SignedMessageCID *string    `db:"signed_message_cid"`
+	TerminationFee   *string    `db:"termination_fee"`
+	TerminatedAt     *time.Time `db:"terminated_at"`
+	Error            *string    `db:"error"`
+}
+
+// SectorTerminateItems returns the sectors of a termination operation with their state and the simulated
+// termination fee recorded when they were sent.
+func (a *WebRPC) SectorTerminateItems(ctx context.Context, opID int64) ([]SectorTerminateItem, error) {
+	var items []SectorTerminateItem
+	err := a.deps.DB.Select(ctx, &items, `SELECT sector_number, state, attempts, signed_message_cid, termination_fee,
+			terminated_at, error
+		FROM sector_terminate_items
+		WHERE op_id = $1
+		ORDER BY sector_number`, opID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting terminate items: %w", err)
+	}
+	return items, nil
+}
@@ -0,0 +1,68 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/evacuation"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type StorageEvacuation struct {
+	EvacuationID int64      `db:"evacuation_id"`
+	StorageID    string     `db:"storage_id"`
+	State        string     `db:"state"`
+	CreateTime   time.Time  `db:"create_time"`
+	CompleteTime *time.Time `db:"complete_time"`
+
+	// sectors with files left in the path
+	Remaining int64 `db:"remaining"`
+
+	Moved   int64 `db:"moved"`
+	Dropped int64 `db:"dropped"`
+	Failed  int64 `db:"failed"`
+	Pending int64 `db:"pending"`
+
+	LastError *string `db:"last_error"`
+}
+
+// StorageEvacuations returns storage path evacuations with their progress, newest first.
+func (a *WebRPC) StorageEvacuations(ctx context.Context) ([]StorageEvacuation, error) {
+	out := []StorageEvacuation{}
+	err := a.deps.DB.Select(ctx, &out, `SELECT e.evacuation_id, e.storage_id, e.state, e.create_time, e.complete_time,
+			(SELECT COUNT(DISTINCT (sl.miner_id, sl.sector_num)) FROM sector_location sl WHERE sl.storage_id = e.storage_id) AS remaining,
+			COUNT(m.move_id) FILTER (WHERE m.method = $1) AS moved,
+			COUNT(m.move_id) FILTER (WHERE m.method = $2) AS dropped,
+			COUNT(m.move_id) FILTER (WHERE m.error IS NOT NULL) AS failed,
+			COUNT(m.move_id) FILTER (WHERE m.complete_time IS NULL) AS pending,
+			(array_agg(m.error ORDER BY m.complete_time DESC) FILTER (WHERE m.error IS NOT NULL))[1] AS last_error
+		FROM storage_evacuations e
+		LEFT JOIN storage_evacuation_moves m ON m.evacuation_id = e.evacuation_id
+		GROUP BY e.evacuation_id
+		ORDER BY e.evacuation_id DESC`, evacuation.MethodMove, evacuation.MethodDrop)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StorageEvacuate marks the storage path read-only and starts moving all of its data to other paths. The path
+// is detached from all nodes once it is empty.
+func (a *WebRPC) StorageEvacuate(ctx context.Context, storageID string) (int64, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return 0, err
+	}
+
+	return evacuation.Start(ctx, a.deps.DB, storiface.ID(storageID))
+}
+
+// StorageEvacuationCancel stops the evacuation of a storage path and makes it writable again. Data already
+// moved out of the path stays where it was moved.
+func (a *WebRPC) StorageEvacuationCancel(ctx context.Context, storageID string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
+
+	return evacuation.Cancel(ctx, a.deps.DB, storiface.ID(storageID))
+}
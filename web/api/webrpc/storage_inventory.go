@@ -0,0 +1,87 @@
+package webrpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/inventory"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type StorageInventoryCheck struct {
+	CheckID      int64      `db:"check_id"`
+	StorageID    *string    `db:"storage_id"` // nil for chain state checks
+	Reconcile    bool       `db:"reconcile"`
+	CreateTime   time.Time  `db:"create_time"`
+	CompleteTime *time.Time `db:"complete_time"`
+	TaskID       *int64     `db:"task_id"`
+	Error        *string    `db:"error"`
+
+	Missing    int64 `db:"missing"`
+	Orphan     int64 `db:"orphan"`
+	Duplicate  int64 `db:"duplicate"`
+	Lost       int64 `db:"lost"`
+	Reconciled int64 `db:"reconciled"`
+}
+
+type StorageInventoryIssue struct {
+	Kind       string  `db:"kind"`
+	SpID       int64   `db:"sp_id"`
+	SectorNum  int64   `db:"sector_num"`
+	FileType   int64   `db:"sector_filetype"`
+	StorageID  *string `db:"storage_id"`
+	Reconciled bool    `db:"reconciled"`
+
+	FileTypeStr string `db:"-"`
+}
+
+// StorageInventoryChecks returns the latest storage inventory checks with the number of issues of each kind found,
+// newest first.
+func (a *WebRPC) StorageInventoryChecks(ctx context.Context) ([]StorageInventoryCheck, error) {
+	out := []StorageInventoryCheck{}
+	err := a.deps.DB.Select(ctx, &out, `SELECT c.check_id, c.storage_id, c.reconcile, c.create_time, c.complete_time, c.task_id, c.error,
+			COUNT(i.kind) FILTER (WHERE i.kind = $1) AS missing,
+			COUNT(i.kind) FILTER (WHERE i.kind = $2) AS orphan,
+			COUNT(i.kind) FILTER (WHERE i.kind = $3) AS duplicate,
+			COUNT(i.kind) FILTER (WHERE i.kind = $4) AS lost,
+			COUNT(i.kind) FILTER (WHERE i.reconciled) AS reconciled
+		FROM storage_inventory_checks c
+		LEFT JOIN storage_inventory_issues i ON i.check_id = c.check_id
+		GROUP BY c.check_id
+		ORDER BY c.check_id DESC
+		LIMIT 200`, inventory.KindMissing, inventory.KindOrphan, inventory.KindDuplicate, inventory.KindLost)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StorageInventoryIssues returns a page of the issues found by an inventory check.
+func (a *WebRPC) StorageInventoryIssues(ctx context.Context, checkID int64, req PageRequest) (*Page[StorageInventoryIssue], error) {
+	var issues []StorageInventoryIssue
+	err := a.deps.DB.Select(ctx, &issues, `SELECT kind, sp_id, sector_num, sector_filetype, storage_id, reconciled
+		FROM storage_inventory_issues WHERE check_id = $1
+		ORDER BY kind, sp_id, sector_num, sector_filetype`, checkID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range issues {
+		issues[i].FileTypeStr = strings.Join(storiface.SectorFileType(issues[i].FileType).Strings(), ",")
+	}
+
+	return pageOf(issues, req)
+}
+
+// StorageInventoryStart schedules an inventory check of a storage path, or of all storage paths and chain state
+// if storageID is empty. Reconcile drops index entries of files missing on disk, and declares files on disk which
+// aren't in the index.
+func (a *WebRPC) StorageInventoryStart(ctx context.Context, storageID string, reconcile bool) ([]int64, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return nil, err
+	}
+
+	return inventory.Start(ctx, a.deps.DB, storiface.ID(storageID), reconcile)
+}
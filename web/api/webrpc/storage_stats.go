@@ -8,11 +8,15 @@ import (
 
 	"github.com/samber/lo"
 	"github.com/snadrus/must"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
 
 	"github.com/filecoin-project/curio/lib/paths"
 	"github.com/filecoin-project/curio/lib/storiface"
+	"github.com/filecoin-project/curio/tasks/rollup"
+	"github.com/filecoin-project/curio/web/api/apiauth"
 
 	"github.com/filecoin-project/lotus/chain/types"
 )
@@ -112,34 +116,96 @@ func (a *WebRPC) StorageGCMarks(ctx context.Context) ([]StorageGCMarks, error) {
 		return nil, err
 	}
 
-	for i, m := range marks {
-		marks[i].TypeName = storiface.SectorFileType(m.FileType).String()
-
-		var pathRole []string
-		if m.CanSeal {
-			pathRole = append(pathRole, "Scratch")
-		}
-		if m.CanStore {
-			pathRole = append(pathRole, "Store")
+	for i := range marks {
+		if err := marks[i].fill(); err != nil {
+			return nil, err
 		}
-		marks[i].PathType = strings.Join(pathRole, "/")
+	}
 
-		us := paths.UrlsFromString(m.Urls)
-		us = lo.Map(us, func(u string, _ int) string {
-			return must.One(url.Parse(u)).Host
-		})
-		marks[i].Urls = strings.Join(us, ", ")
-		maddr, err := address.NewIDAddress(uint64(marks[i].Actor))
-		if err != nil {
+	return marks, nil
+}
+
+type storageGCMarkRow struct {
+	StorageGCMarks
+	Total int `db:"total"`
+}
+
+// StorageGCMarksPage returns a page of GC marks. Sortable by Actor, SectorNum, StorageID, CreatedAt
+// (default, newest first) and Approved.
+func (a *WebRPC) StorageGCMarksPage(ctx context.Context, req PageRequest) (*Page[StorageGCMarks], error) {
+	req = req.normalize()
+	if err := req.checkSort("Actor", "SectorNum", "StorageID", "CreatedAt", "Approved"); err != nil {
+		return nil, err
+	}
+
+	desc := req.Desc
+	if req.Sort == "" {
+		desc = !desc
+	}
+
+	var rows []storageGCMarkRow
+	err := a.deps.DB.Select(ctx, &rows, `
+		SELECT m.sp_id, m.sector_num, m.sector_filetype, m.storage_id, m.created_at, m.approved, m.approved_at, sl.can_seal, sl.can_store, sl.urls,
+				COUNT(*) OVER () AS total
+			FROM storage_removal_marks m LEFT JOIN storage_path sl ON m.storage_id = sl.storage_id
+			ORDER BY
+				CASE WHEN $1 = 'actor' AND NOT $2 THEN m.sp_id END ASC,
+				CASE WHEN $1 = 'actor' AND $2 THEN m.sp_id END DESC,
+				CASE WHEN $1 = 'sectornum' AND NOT $2 THEN m.sector_num END ASC,
+				CASE WHEN $1 = 'sectornum' AND $2 THEN m.sector_num END DESC,
+				CASE WHEN $1 = 'storageid' AND NOT $2 THEN m.storage_id END ASC,
+				CASE WHEN $1 = 'storageid' AND $2 THEN m.storage_id END DESC,
+				CASE WHEN $1 = 'approved' AND NOT $2 THEN m.approved END ASC,
+				CASE WHEN $1 = 'approved' AND $2 THEN m.approved END DESC,
+				CASE WHEN NOT $2 THEN m.created_at END ASC,
+				m.created_at DESC,
+				m.sp_id, m.sector_num, m.sector_filetype, m.storage_id
+			LIMIT $3 OFFSET $4`, req.sqlSort(), desc, req.Limit, req.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Page[StorageGCMarks]{Items: make([]StorageGCMarks, 0, len(rows))}
+	for _, r := range rows {
+		if err := r.fill(); err != nil {
 			return nil, err
 		}
-		marks[i].Miner = maddr.String()
+		out.Total = r.Total
+		out.Items = append(out.Items, r.StorageGCMarks)
 	}
+	return out, nil
+}
 
-	return marks, nil
+// fill sets display fields of a mark read from the database.
+func (m *StorageGCMarks) fill() error {
+	m.TypeName = storiface.SectorFileType(m.FileType).String()
+
+	var pathRole []string
+	if m.CanSeal {
+		pathRole = append(pathRole, "Scratch")
+	}
+	if m.CanStore {
+		pathRole = append(pathRole, "Store")
+	}
+	m.PathType = strings.Join(pathRole, "/")
+
+	us := paths.UrlsFromString(m.Urls)
+	us = lo.Map(us, func(u string, _ int) string {
+		return must.One(url.Parse(u)).Host
+	})
+	m.Urls = strings.Join(us, ", ")
+	maddr, err := address.NewIDAddress(uint64(m.Actor))
+	if err != nil {
+		return err
+	}
+	m.Miner = maddr.String()
+	return nil
 }
 
 func (a *WebRPC) StorageGCApprove(ctx context.Context, actor int64, sectorNum int64, fileType int64, storageID string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	now := time.Now()
 	_, err := a.deps.DB.Exec(ctx, `UPDATE storage_removal_marks SET approved = true, approved_at = $1 WHERE sp_id = $2 AND sector_num = $3 AND sector_filetype = $4 AND storage_id = $5`, now, actor, sectorNum, fileType, storageID)
 	if err != nil {
@@ -149,6 +215,9 @@ func (a *WebRPC) StorageGCApprove(ctx context.Context, actor int64, sectorNum in
 }
 
 func (a *WebRPC) StorageGCApproveAll(ctx context.Context) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	now := time.Now()
 	_, err := a.deps.DB.Exec(ctx, `UPDATE storage_removal_marks SET approved = true, approved_at = $1 WHERE approved = false`, now)
 	if err != nil {
@@ -158,9 +227,316 @@ func (a *WebRPC) StorageGCApproveAll(ctx context.Context) error {
 }
 
 func (a *WebRPC) StorageGCUnapproveAll(ctx context.Context) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	_, err := a.deps.DB.Exec(ctx, `UPDATE storage_removal_marks SET approved = false, approved_at = NULL WHERE approved = true`)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+type StorageForecasts struct {
+	WindowDays int
+	Paths      []rollup.StorageForecast
+	Classes    []rollup.StorageForecast
+}
+
+// StorageForecast projects days until storage paths and path classes fill up from their usage trend over
+// the last windowDays days.
+func (a *WebRPC) StorageForecast(ctx context.Context, windowDays int) (*StorageForecasts, error) {
+	if windowDays <= 0 || windowDays > 90 {
+		windowDays = 7
+	}
+
+	paths, classes, err := rollup.ForecastStorage(ctx, a.deps.DB, time.Duration(windowDays)*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StorageForecasts{
+		WindowDays: windowDays,
+		Paths:      paths,
+		Classes:    classes,
+	}, nil
+}
+
+type StorageTierMove struct {
+	MoveID        int64      `db:"move_id"`
+	SpID          int64      `db:"sp_id"`
+	SectorNumber  int64      `db:"sector_number"`
+	FileTypes     int64      `db:"file_types"`
+	FromStorageID string     `db:"from_storage_id"`
+	FromTier      string     `db:"from_tier"`
+	ToTier        string     `db:"to_tier"`
+	ToStorageID   *string    `db:"to_storage_id"`
+	Reason        string     `db:"reason"`
+	CreateTime    time.Time  `db:"create_time"`
+	CompleteTime  *time.Time `db:"complete_time"`
+	TaskID        *int64     `db:"task_id"`
+	Error         *string    `db:"error"`
+
+	Miner string   `db:"-"`
+	Files []string `db:"-"`
+}
+
+// StorageTierMoves returns a page of storage tiering placement decisions, newest first.
+func (a *WebRPC) StorageTierMoves(ctx context.Context, req PageRequest) (*Page[StorageTierMove], error) {
+	req = req.normalize()
+	if err := req.checkSort(); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		StorageTierMove
+		Total int `db:"total"`
+	}
+	err := a.deps.DB.Select(ctx, &rows, `SELECT move_id, sp_id, sector_number, file_types, from_storage_id, from_tier, to_tier, to_storage_id,
+			reason, create_time, complete_time, task_id, error, COUNT(*) OVER () AS total
+		FROM storage_tier_moves ORDER BY move_id DESC LIMIT $1 OFFSET $2`, req.Limit, req.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Page[StorageTierMove]{Items: make([]StorageTierMove, 0, len(rows))}
+	for _, r := range rows {
+		maddr, err := address.NewIDAddress(uint64(r.SpID))
+		if err != nil {
+			return nil, err
+		}
+		r.Miner = maddr.String()
+		r.Files = storiface.SectorFileType(r.FileTypes).Strings()
+
+		out.Total = r.Total
+		out.Items = append(out.Items, r.StorageTierMove)
+	}
+	return out, nil
+}
+
+type ScrubPathSummary struct {
+	StorageID string     `db:"storage_id"`
+	Checked   int64      `db:"checked"`
+	Failed    int64      `db:"failed"`
+	Pending   int64      `db:"pending"`
+	LastCheck *time.Time `db:"last_check"`
+	LastFail  *time.Time `db:"last_fail"`
+}
+
+// StorageScrubSummary returns sealed data scrub results aggregated per storage path.
+func (a *WebRPC) StorageScrubSummary(ctx context.Context) ([]ScrubPathSummary, error) {
+	out := []ScrubPathSummary{}
+	err := a.deps.DB.Select(ctx, &out, `SELECT storage_id,
+			COUNT(*) FILTER (WHERE complete_time IS NOT NULL) AS checked,
+			COUNT(*) FILTER (WHERE ok = FALSE) AS failed,
+			COUNT(*) FILTER (WHERE complete_time IS NULL) AS pending,
+			MAX(complete_time) AS last_check,
+			MAX(complete_time) FILTER (WHERE ok = FALSE) AS last_fail
+		FROM scrub_sealed_checks GROUP BY storage_id ORDER BY storage_id`)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type ScrubCheck struct {
+	CheckID      int64      `db:"check_id"`
+	SpID         int64      `db:"sp_id"`
+	SectorNumber int64      `db:"sector_number"`
+	StorageID    string     `db:"storage_id"`
+	FullRead     bool       `db:"full_read"`
+	CreateTime   time.Time  `db:"create_time"`
+	CompleteTime *time.Time `db:"complete_time"`
+	OK           *bool      `db:"ok"`
+	Message      *string    `db:"message"`
+
+	Miner string `db:"-"`
+}
+
+// StorageScrubFailures returns a page of failed sealed data scrub checks, newest first.
+func (a *WebRPC) StorageScrubFailures(ctx context.Context, req PageRequest) (*Page[ScrubCheck], error) {
+	req = req.normalize()
+	if err := req.checkSort(); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ScrubCheck
+		Total int `db:"total"`
+	}
+	err := a.deps.DB.Select(ctx, &rows, `SELECT check_id, sp_id, sector_number, storage_id, full_read, create_time, complete_time, ok, message,
+			COUNT(*) OVER () AS total
+		FROM scrub_sealed_checks WHERE ok = FALSE ORDER BY check_id DESC LIMIT $1 OFFSET $2`, req.Limit, req.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Page[ScrubCheck]{Items: make([]ScrubCheck, 0, len(rows))}
+	for _, r := range rows {
+		maddr, err := address.NewIDAddress(uint64(r.SpID))
+		if err != nil {
+			return nil, err
+		}
+		r.Miner = maddr.String()
+
+		out.Total = r.Total
+		out.Items = append(out.Items, r.ScrubCheck)
+	}
+	return out, nil
+}
+
+type PlacementPreviewStep struct {
+	Allocation int
+	StorageID  string
+	Tier       string
+	URLs       []string
+	Available  int64
+}
+
+// StoragePlacementPreview simulates where the next count sectors of the miner would be allocated under the
+// storage placement policy. fileType is a sector file type such as 'sealed', sealing selects sealing paths
+// instead of long-term storage paths.
+func (a *WebRPC) StoragePlacementPreview(ctx context.Context, miner string, fileType string, sealing bool, count int) ([]PlacementPreviewStep, error) {
+	if count <= 0 || count > 1000 {
+		return nil, xerrors.Errorf("count must be between 1 and 1000")
+	}
+
+	dbi, ok := a.deps.Si.(*paths.DBIndex)
+	if !ok {
+		return nil, xerrors.Errorf("sector index doesn't support placement previews")
+	}
+
+	maddr, err := address.NewFromString(miner)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing miner address: %w", err)
+	}
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner ID: %w", err)
+	}
+
+	ft, err := storiface.TypeFromString(fileType)
+	if err != nil {
+		return nil, err
+	}
+
+	mi, err := a.deps.Chain.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner info: %w", err)
+	}
+
+	pathType := storiface.PathStorage
+	if sealing {
+		pathType = storiface.PathSealing
+	}
+
+	steps, err := dbi.StoragePlacementPreview(ctx, ft, mi.SectorSize, pathType, abi.ActorID(mid), count)
+	if err != nil {
+		return nil, xerrors.Errorf("simulating placement: %w", err)
+	}
+
+	infos := map[storiface.ID]storiface.StorageInfo{}
+	out := []PlacementPreviewStep{}
+	for i, s := range steps {
+		si, ok := infos[s.ID]
+		if !ok {
+			si, err = dbi.StorageInfo(ctx, s.ID)
+			if err != nil {
+				return nil, xerrors.Errorf("getting storage info for %s: %w", s.ID, err)
+			}
+			infos[s.ID] = si
+		}
+
+		out = append(out, PlacementPreviewStep{
+			Allocation: i + 1,
+			StorageID:  string(s.ID),
+			Tier:       si.Tier,
+			URLs:       si.URLs,
+			Available:  s.Available,
+		})
+	}
+	return out, nil
+}
+
+type StorageReservation struct {
+	LeaseID       int64     `db:"lease_id"`
+	StorageID     string    `db:"storage_id"`
+	Holder        string    `db:"holder"`
+	SpID          int64     `db:"sp_id"`
+	SectorNumber  int64     `db:"sector_number"`
+	FileType      int64     `db:"file_type"`
+	ReservedBytes int64     `db:"reserved_bytes"`
+	OwnerTaskID   *int64    `db:"owner_task_id"`
+	OwnerTaskName *string   `db:"owner_task_name"`
+	Purpose       string    `db:"purpose"`
+	Exclusive     bool      `db:"exclusive"`
+	CreateTime    time.Time `db:"create_time"`
+	ExpireTime    time.Time `db:"expire_time"`
+
+	Miner string `db:"-"`
+	File  string `db:"-"`
+}
+
+// StorageReservations returns a page of storage space reservation leases held by nodes, oldest first.
+// Reservations whose owner task no longer exists have a nil OwnerTaskName.
+func (a *WebRPC) StorageReservations(ctx context.Context, req PageRequest) (*Page[StorageReservation], error) {
+	req = req.normalize()
+	if err := req.checkSort(); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		StorageReservation
+		Total int `db:"total"`
+	}
+	err := a.deps.DB.Select(ctx, &rows, `SELECT r.lease_id, r.storage_id, r.holder, r.sp_id, r.sector_number, r.file_type, r.reserved_bytes,
+			r.owner_task_id, t.name AS owner_task_name, r.purpose, r.exclusive, r.create_time, r.expire_time, COUNT(*) OVER () AS total
+		FROM storage_reservations r LEFT JOIN harmony_task t ON t.id = r.owner_task_id
+		ORDER BY r.create_time, r.lease_id LIMIT $1 OFFSET $2`, req.Limit, req.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Page[StorageReservation]{Items: make([]StorageReservation, 0, len(rows))}
+	for _, r := range rows {
+		maddr, err := address.NewIDAddress(uint64(r.SpID))
+		if err != nil {
+			return nil, err
+		}
+		r.Miner = maddr.String()
+		r.File = storiface.SectorFileType(r.FileType).String()
+
+		out.Total = r.Total
+		out.Items = append(out.Items, r.StorageReservation)
+	}
+	return out, nil
+}
+
+type StorageHealthProbe struct {
+	StorageID      string    `db:"storage_id"`
+	URL            string    `db:"url"`
+	Prober         string    `db:"prober"`
+	ProbeTime      time.Time `db:"probe_time"`
+	OK             bool      `db:"ok"`
+	LatencyMs      *int64    `db:"latency_ms"`
+	ReadMs         *int64    `db:"read_ms"`
+	ReadBytes      *int64    `db:"read_bytes"`
+	Available      *int64    `db:"available"`
+	Error          *string   `db:"error"`
+	Degraded       bool      `db:"degraded"`
+	DegradedReason *string   `db:"degraded_reason"`
+}
+
+// StorageHealthProbes returns the latest health probe of every storage path URL from every node, with the
+// degraded state of the path.
+func (a *WebRPC) StorageHealthProbes(ctx context.Context) ([]StorageHealthProbe, error) {
+	out := []StorageHealthProbe{}
+	err := a.deps.DB.Select(ctx, &out, `SELECT p.storage_id, p.url, p.prober, p.probe_time, p.ok, p.latency_ms, p.read_ms, p.read_bytes,
+			p.available, p.error, sp.degraded, sp.degraded_reason
+		FROM storage_path_probes p JOIN storage_path sp ON sp.storage_id = p.storage_id
+		ORDER BY sp.degraded DESC, p.storage_id, p.url, p.prober`)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -41,29 +41,46 @@ func (a *WebRPC) ClusterTaskSummary(ctx context.Context) ([]TaskSummary, error)
 	for i := range ts {
 		ts[i].SincePostedStr = time.Since(ts[i].SincePosted).Truncate(time.Second).String()
 
-		if v, ok := a.taskSPIDs[ts[i].Name]; ok {
-			ts[i].SpID = v.GetSpid(a.deps.DB, ts[i].ID)
+		if err := a.fillTaskMiner(&ts[i]); err != nil {
+			return nil, err
 		}
+	}
 
-		if ts[i].SpID != "" {
-			spid, err := strconv.ParseInt(ts[i].SpID, 10, 64)
-			if err != nil {
-				return nil, err
-			}
+	return ts, nil
+}
 
-			if spid > 0 {
-				maddr, err := address.NewIDAddress(uint64(spid))
-				if err != nil {
-					return nil, err
-				}
-				ts[i].Miner = maddr.String()
-			} else {
-				ts[i].Miner = ""
+// ClusterTaskSummaryPage returns a page of ClusterTaskSummary, sortable by any TaskSummary field.
+func (a *WebRPC) ClusterTaskSummaryPage(ctx context.Context, req PageRequest) (*Page[TaskSummary], error) {
+	ts, err := a.ClusterTaskSummary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pageOf(ts, req)
+}
+
+func (a *WebRPC) fillTaskMiner(ts *TaskSummary) error {
+	if v, ok := a.taskSPIDs[ts.Name]; ok {
+		ts.SpID = v.GetSpid(a.deps.DB, ts.ID)
+	}
+
+	if ts.SpID != "" {
+		spid, err := strconv.ParseInt(ts.SpID, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if spid > 0 {
+			maddr, err := address.NewIDAddress(uint64(spid))
+			if err != nil {
+				return err
 			}
+			ts.Miner = maddr.String()
+		} else {
+			ts.Miner = ""
 		}
 	}
 
-	return ts, nil
+	return nil
 }
 
 type SpidGetter interface {
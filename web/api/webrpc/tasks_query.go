@@ -0,0 +1,219 @@
+package webrpc
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/curio/harmony/harmonydb"
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+// maxTaskQueryCandidates bounds the number of tasks a bulk action applies to, and the number of tasks
+// resolved to an SP when filtering by SP, which takes a query per task.
+const maxTaskQueryCandidates = 10000
+
+// TaskQuery selects tasks in the queue. Zero values match everything.
+type TaskQuery struct {
+	Names       []string
+	Machine     string // host:port of the owner, or "unassigned"
+	SpID        int64
+	MinAge      string // duration since the task was posted, e.g. "1h"
+	MinFailures int64
+
+	Sort string // id, name, posted (default), failures or machine
+	Desc bool
+
+	Limit  int
+	Offset int
+}
+
+type TaskQueueEntry struct {
+	TaskSummary
+
+	PostedTime time.Time `db:"posted_time"`
+	Failures   int64     `db:"retries"`
+}
+
+type taskQueueRow struct {
+	TaskQueueEntry
+	Total int64 `db:"total"`
+}
+
+type TaskQueryResult struct {
+	Total int64
+	Tasks []TaskQueueEntry
+}
+
+// ClusterTaskQuery returns a page of queued tasks matching the query.
+func (a *WebRPC) ClusterTaskQuery(ctx context.Context, q TaskQuery) (*TaskQueryResult, error) {
+	if q.Limit <= 0 || q.Limit > 1000 {
+		q.Limit = 100
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+
+	tasks, total, err := a.queryTasks(ctx, q, true)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tasks {
+		tasks[i].SincePostedStr = time.Since(tasks[i].SincePosted).Truncate(time.Second).String()
+		if q.SpID == 0 {
+			if err := a.fillTaskMiner(&tasks[i].TaskSummary); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &TaskQueryResult{Total: total, Tasks: tasks}, nil
+}
+
+// Bulk task actions
+const (
+	// TaskActionRetry resets the failure count of a task, which clears its retry backoff and restores its
+	// failure budget.
+	TaskActionRetry = "retry"
+	// TaskActionAbort removes a task from the queue, recording it as failed in the task history.
+	TaskActionAbort = "abort"
+)
+
+// ClusterTaskBulk applies an action to all tasks matching the query, ignoring Limit and Offset. Tasks
+// owned by a machine are skipped as they may be running. Returns the number of tasks acted on.
+func (a *WebRPC) ClusterTaskBulk(ctx context.Context, q TaskQuery, action string) (int, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return 0, err
+	}
+	if action != TaskActionRetry && action != TaskActionAbort {
+		return 0, xerrors.Errorf("unknown action %q, expected %s or %s", action, TaskActionRetry, TaskActionAbort)
+	}
+
+	tasks, _, err := a.queryTasks(ctx, q, false)
+	if err != nil {
+		return 0, err
+	}
+	ids := lo.Map(tasks, func(t TaskQueueEntry, _ int) int64 { return t.ID })
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var n int
+	switch action {
+	case TaskActionRetry:
+		n, err = a.deps.DB.Exec(ctx, `UPDATE harmony_task SET retries = 0, update_time = CURRENT_TIMESTAMP
+			WHERE id = ANY($1) AND owner_id IS NULL`, ids)
+		if err != nil {
+			return 0, xerrors.Errorf("resetting task retries: %w", err)
+		}
+	case TaskActionAbort:
+		_, err = a.deps.DB.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+			_, err = tx.Exec(`INSERT INTO harmony_task_history (task_id, name, posted, work_start, work_end, result, err, completed_by_host_and_port)
+				SELECT id, name, posted_time, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, FALSE, 'aborted from the web UI', 'web'
+				FROM harmony_task WHERE id = ANY($1) AND owner_id IS NULL`, ids)
+			if err != nil {
+				return false, xerrors.Errorf("recording aborted tasks: %w", err)
+			}
+
+			n, err = tx.Exec(`DELETE FROM harmony_task WHERE id = ANY($1) AND owner_id IS NULL`, ids)
+			if err != nil {
+				return false, xerrors.Errorf("deleting tasks: %w", err)
+			}
+			return true, nil
+		}, harmonydb.OptionRetry())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	log.Infow("Bulk task action", "action", action, "matched", len(ids), "applied", n)
+	return n, nil
+}
+
+// queryTasks returns tasks matching q and the total number of matches. The SP filter needs a query per task,
+// so it is applied here after the SQL filters, with a bound on the number of candidates.
+func (a *WebRPC) queryTasks(ctx context.Context, q TaskQuery, paginate bool) ([]TaskQueueEntry, int64, error) {
+	postedBefore := time.Now()
+	if q.MinAge != "" {
+		d, err := time.ParseDuration(q.MinAge)
+		if err != nil {
+			return nil, 0, xerrors.Errorf("parsing min age: %w", err)
+		}
+		postedBefore = postedBefore.Add(-d)
+	}
+
+	var names []string
+	if len(q.Names) > 0 {
+		names = q.Names
+	}
+
+	limit, offset := q.Limit, q.Offset
+	if !paginate || q.SpID != 0 {
+		limit, offset = maxTaskQueryCandidates+1, 0
+	}
+
+	var rows []taskQueueRow
+	err := a.deps.DB.Select(ctx, &rows, `SELECT t.id, t.name, t.update_time AS since_posted, t.posted_time, t.retries,
+			t.owner_id, hm.host_and_port AS owner, COUNT(*) OVER () AS total
+		FROM harmony_task t
+		LEFT JOIN harmony_machines hm ON hm.id = t.owner_id
+		WHERE ($1::TEXT[] IS NULL OR t.name = ANY($1))
+		  AND ($2 = '' OR ($2 = 'unassigned' AND t.owner_id IS NULL) OR hm.host_and_port = $2)
+		  AND t.posted_time <= $3
+		  AND t.retries >= $4
+		ORDER BY
+			CASE WHEN $5 = 'name' AND NOT $6 THEN t.name END ASC,
+			CASE WHEN $5 = 'name' AND $6 THEN t.name END DESC,
+			CASE WHEN $5 = 'failures' AND NOT $6 THEN t.retries END ASC,
+			CASE WHEN $5 = 'failures' AND $6 THEN t.retries END DESC,
+			CASE WHEN $5 = 'machine' AND NOT $6 THEN hm.host_and_port END ASC,
+			CASE WHEN $5 = 'machine' AND $6 THEN hm.host_and_port END DESC,
+			CASE WHEN $5 IN ('', 'posted') AND NOT $6 THEN t.posted_time END ASC,
+			CASE WHEN $5 IN ('', 'posted') AND $6 THEN t.posted_time END DESC,
+			CASE WHEN NOT $6 THEN t.id END ASC,
+			t.id DESC
+		LIMIT $7 OFFSET $8`, names, q.Machine, postedBefore, q.MinFailures, q.Sort, q.Desc, limit, offset)
+	if err != nil {
+		return nil, 0, xerrors.Errorf("querying tasks: %w", err)
+	}
+
+	var total int64
+	if len(rows) > 0 {
+		total = rows[0].Total
+	}
+	tasks := lo.Map(rows, func(r taskQueueRow, _ int) TaskQueueEntry { return r.TaskQueueEntry })
+
+	if q.SpID == 0 {
+		if !paginate && total > maxTaskQueryCandidates {
+			return nil, 0, xerrors.Errorf("query matches %d tasks, more than the %d which can be acted on at once", total, maxTaskQueryCandidates)
+		}
+		return tasks, total, nil
+	}
+
+	if total > maxTaskQueryCandidates {
+		return nil, 0, xerrors.Errorf("query matches %d tasks, narrow it down to at most %d to filter by SP", total, maxTaskQueryCandidates)
+	}
+
+	var filtered []TaskQueueEntry
+	for _, t := range tasks {
+		if _, ok := a.taskSPIDs[t.Name]; !ok {
+			continue
+		}
+		if err := a.fillTaskMiner(&t.TaskSummary); err != nil {
+			return nil, 0, err
+		}
+		if t.SpID == strconv.FormatInt(q.SpID, 10) {
+			filtered = append(filtered, t)
+		}
+	}
+
+	total = int64(len(filtered))
+	if paginate {
+		filtered = lo.Slice(filtered, q.Offset, q.Offset+q.Limit)
+	}
+	return filtered, total, nil
+}
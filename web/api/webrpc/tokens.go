@@ -0,0 +1,111 @@
+package webrpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc/auth"
+
+	"github.com/filecoin-project/curio/web/api/apiauth"
+)
+
+type WebToken struct {
+	ID        int64      `db:"id"`
+	Name      string     `db:"name"`
+	Scopes    []string   `db:"scopes"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt *time.Time `db:"expires_at"`
+	LastUsed  *time.Time `db:"last_used"`
+	Revoked   bool       `db:"revoked"`
+}
+
+// WebTokenCreate issues a new web API token. ttl is a duration string (e.g. "720h"), empty means no expiry.
+// The returned token is not stored and can't be retrieved again.
+func (a *WebRPC) WebTokenCreate(ctx context.Context, name string, scopes []string, ttl string) (string, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAdmin); err != nil {
+		return "", err
+	}
+
+	var d time.Duration
+	if ttl != "" {
+		var err error
+		d, err = time.ParseDuration(ttl)
+		if err != nil {
+			return "", xerrors.Errorf("parsing ttl: %w", err)
+		}
+	}
+
+	id, token, err := apiauth.CreateToken(ctx, a.deps.DB, name, lo.Map(scopes, func(s string, _ int) auth.Permission { return auth.Permission(s) }), d)
+	if err != nil {
+		return "", err
+	}
+
+	log.Infow("Created web API token", "id", id, "name", name, "scopes", scopes, "ttl", ttl)
+	return token, nil
+}
+
+func (a *WebRPC) WebTokenList(ctx context.Context) ([]WebToken, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAdmin); err != nil {
+		return nil, err
+	}
+
+	var tokens []WebToken
+	err := a.deps.DB.Select(ctx, &tokens, `SELECT id, name, scopes, created_at, expires_at, last_used, revoked FROM web_api_tokens ORDER BY id`)
+	if err != nil {
+		return nil, xerrors.Errorf("listing tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (a *WebRPC) WebTokenRevoke(ctx context.Context, id int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAdmin); err != nil {
+		return err
+	}
+
+	n, err := a.deps.DB.Exec(ctx, `UPDATE web_api_tokens SET revoked = TRUE WHERE id = $1`, id)
+	if err != nil {
+		return xerrors.Errorf("revoking token: %w", err)
+	}
+	if n != 1 {
+		return xerrors.Errorf("token %d not found", id)
+	}
+	return nil
+}
+
+type WebAuditEntry struct {
+	ID        int64     `db:"id"`
+	At        time.Time `db:"at"`
+	TokenID   *int64    `db:"token_id"`
+	TokenName string    `db:"token_name"`
+	Remote    string    `db:"remote"`
+	Endpoint  string    `db:"endpoint"`
+	Payload   string    `db:"payload_hash"`
+	Status    string    `db:"status"`
+	Error     *string   `db:"error"`
+}
+
+// WebAuditLog returns the most recent audit log entries, newest first. tokenID of 0 returns entries for all
+// tokens, including requests made without a token.
+func (a *WebRPC) WebAuditLog(ctx context.Context, tokenID int64, limit int) ([]WebAuditEntry, error) {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAdmin); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+
+	var entries []WebAuditEntry
+	err := a.deps.DB.Select(ctx, &entries, `SELECT id, at, token_id, token_name, remote, endpoint, encode(payload_hash, 'hex') AS payload_hash, status, error
+		FROM web_api_audit
+		WHERE ($1 = 0 OR token_id = $1)
+		ORDER BY id DESC
+		LIMIT $2`, tokenID, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("listing audit log: %w", err)
+	}
+	return entries, nil
+}
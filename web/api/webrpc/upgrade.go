@@ -9,6 +9,7 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 
 	"github.com/filecoin-project/curio/tasks/snap"
+	"github.com/filecoin-project/curio/web/api/apiauth"
 )
 
 type UpgradeSector struct {
@@ -54,12 +55,27 @@ func (a *WebRPC) UpgradeSectors(ctx context.Context) ([]UpgradeSector, error) {
 	return sectors, nil
 }
 
+// UpgradeSectorsPage returns a page of UpgradeSectors, sortable by any UpgradeSector field.
+func (a *WebRPC) UpgradeSectorsPage(ctx context.Context, req PageRequest) (*Page[UpgradeSector], error) {
+	sectors, err := a.UpgradeSectors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pageOf(sectors, req)
+}
+
 func (a *WebRPC) UpgradeResetTaskIDs(ctx context.Context, spid, sectorNum int64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	_, err := a.deps.DB.Exec(ctx, `SELECT unset_task_id_snap($1, $2)`, spid, sectorNum)
 	return err
 }
 
 func (a *WebRPC) UpgradeDelete(ctx context.Context, spid, sectorNum uint64) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	if err := snap.DropSectorPieceRefsSnap(ctx, a.deps.DB, abi.SectorID{Miner: abi.ActorID(spid), Number: abi.SectorNumber(sectorNum)}); err != nil {
 		// bad, but still do best we can and continue
 		log.Errorw("failed to drop sector piece refs", "error", err)
@@ -133,6 +149,9 @@ func (a *WebRPC) pipelineSnapMissingTasks(ctx context.Context) ([]snapMissingTas
 }
 
 func (a *WebRPC) PipelineSnapRestartAll(ctx context.Context) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeTasksWrite); err != nil {
+		return err
+	}
 	missing, err := a.pipelineSnapMissingTasks(ctx)
 	if err != nil {
 		return err
@@ -0,0 +1,367 @@
+package webrpc
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/curio/web/api/apiauth"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type WalletInfo struct {
+	Address    string
+	KeyAddress string
+	Roles      []string // e.g. "worker f01234", "precommit-control (layer base)"
+
+	Balance    string
+	MinBalance string
+	Low        bool
+
+	// ChainNonce is the next nonce according to the chain node's mpool, LocalNonce the next nonce
+	// after messages sent by curio. PendingMessages were sent but haven't landed yet.
+	ChainNonce      uint64
+	LocalNonce      *uint64
+	PendingMessages int64
+}
+
+type WalletSpend struct {
+	Day      time.Time `db:"day"`
+	From     string    `db:"from_key"`
+	Reason   string    `db:"send_reason"`
+	Messages int64     `db:"messages"`
+	GasUsed  int64     `db:"gas_used"`
+	Value    string    `db:"value"` // FIL sent with the messages, excluding fees
+}
+
+type WalletThreshold struct {
+	Address    string    `db:"address"`
+	MinBalance string    `db:"min_balance"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+type WalletTopUp struct {
+	Source        string    `db:"source"`
+	Target        string    `db:"target"`
+	SpID          int64     `db:"sp_id"`
+	Amount        string    `db:"amount"`
+	BalanceBefore string    `db:"balance_before"`
+	Threshold     string    `db:"threshold"`
+	LimitedBy     *string   `db:"limited_by"`
+	SignedCid     string    `db:"signed_cid"`
+	Landed        bool      `db:"landed"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+type walletAddressConfig struct {
+	Addresses []struct {
+		PreCommitControl []string
+		CommitControl    []string
+		TerminateControl []string
+		MinerAddresses   []string
+	}
+}
+
+// WalletSummary returns owner, worker and control addresses of all configured miners, and control
+// addresses from config layers, with their balances and nonce state.
+func (a *WebRPC) WalletSummary(ctx context.Context) ([]WalletInfo, error) {
+	roles := map[address.Address][]string{}
+	miners := map[address.Address]struct{}{}
+
+	addRole := func(addr, role string) error {
+		if addr == "" {
+			return nil
+		}
+		maddr, err := address.NewFromString(addr)
+		if err != nil {
+			return xerrors.Errorf("parsing address %s: %w", addr, err)
+		}
+		roles[maddr] = append(roles[maddr], role)
+		return nil
+	}
+
+	err := forEachConfig(a, func(name string, info walletAddressConfig) error {
+		for _, aset := range info.Addresses {
+			for _, addr := range aset.PreCommitControl {
+				if err := addRole(addr, "precommit-control (layer "+name+")"); err != nil {
+					return err
+				}
+			}
+			for _, addr := range aset.CommitControl {
+				if err := addRole(addr, "commit-control (layer "+name+")"); err != nil {
+					return err
+				}
+			}
+			for _, addr := range aset.TerminateControl {
+				if err := addRole(addr, "terminate-control (layer "+name+")"); err != nil {
+					return err
+				}
+			}
+			for _, addr := range aset.MinerAddresses {
+				maddr, err := address.NewFromString(addr)
+				if err != nil {
+					return xerrors.Errorf("parsing miner address %s: %w", addr, err)
+				}
+				miners[maddr] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for maddr := range miners {
+		mi, err := a.deps.Chain.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("getting miner info for %s: %w", maddr, err)
+		}
+		roles[mi.Owner] = append(roles[mi.Owner], "owner "+maddr.String())
+		roles[mi.Worker] = append(roles[mi.Worker], "worker "+maddr.String())
+		for _, ca := range mi.ControlAddresses {
+			roles[ca] = append(roles[ca], "control "+maddr.String())
+		}
+	}
+
+	thresholds, err := a.walletThresholds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defaultMin := abi.TokenAmount(a.deps.Cfg.Alerting.MinimumWalletBalance)
+
+	// Roles are keyed by whatever form the address was configured in, merge them by key address.
+	byKey := map[address.Address]*WalletInfo{}
+	for addr, r := range roles {
+		keyAddr, err := a.deps.Chain.StateAccountKey(ctx, addr, types.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("getting account key for %s: %w", addr, err)
+		}
+
+		if wi, ok := byKey[keyAddr]; ok {
+			wi.Roles = append(wi.Roles, r...)
+			continue
+		}
+
+		balance, err := a.deps.Chain.WalletBalance(ctx, keyAddr)
+		if err != nil {
+			return nil, xerrors.Errorf("getting balance of %s: %w", keyAddr, err)
+		}
+
+		minBalance, ok := thresholds[addr.String()]
+		if !ok {
+			minBalance, ok = thresholds[keyAddr.String()]
+		}
+		if !ok {
+			minBalance = defaultMin
+		}
+
+		nonce, err := a.deps.Chain.MpoolGetNonce(ctx, keyAddr)
+		if err != nil {
+			return nil, xerrors.Errorf("getting nonce of %s: %w", keyAddr, err)
+		}
+
+		byKey[keyAddr] = &WalletInfo{
+			Address:    addr.String(),
+			KeyAddress: keyAddr.String(),
+			Roles:      r,
+			Balance:    types.FIL(balance).Short(),
+			MinBalance: types.FIL(minBalance).Short(),
+			Low:        balance.LessThan(minBalance),
+			ChainNonce: nonce,
+		}
+	}
+
+	var local []struct {
+		From      string `db:"from_key"`
+		NextNonce *int64 `db:"next_nonce"`
+		Pending   int64  `db:"pending"`
+	}
+	err = a.deps.DB.Select(ctx, &local, `SELECT s.from_key, MAX(s.nonce) + 1 AS next_nonce,
+			COUNT(*) FILTER (WHERE s.send_success AND w.executed_tsk_epoch IS NULL) AS pending
+		FROM message_sends s
+		LEFT JOIN message_waits w ON w.signed_message_cid = COALESCE(s.held_cid, s.signed_cid)
+		WHERE s.send_success IS NOT FALSE
+		GROUP BY s.from_key`)
+	if err != nil {
+		return nil, xerrors.Errorf("getting local nonces: %w", err)
+	}
+	for _, l := range local {
+		from, err := address.NewFromString(l.From)
+		if err != nil {
+			continue
+		}
+		if wi, ok := byKey[from]; ok {
+			if l.NextNonce != nil {
+				wi.LocalNonce = lo.ToPtr(uint64(*l.NextNonce))
+			}
+			wi.PendingMessages = l.Pending
+		}
+	}
+
+	out := make([]WalletInfo, 0, len(byKey))
+	for _, wi := range byKey {
+		sort.Strings(wi.Roles)
+		out = append(out, *wi)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].KeyAddress < out[j].KeyAddress })
+
+	return out, nil
+}
+
+// WalletSpendHistory returns daily message counts, gas used and value sent by sending address and reason
+// over the last days days. An empty address returns all senders.
+func (a *WebRPC) WalletSpendHistory(ctx context.Context, addr string, days int) ([]WalletSpend, error) {
+	if days <= 0 || days > 365 {
+		days = 30
+	}
+
+	if addr != "" {
+		maddr, err := address.NewFromString(addr)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing address: %w", err)
+		}
+		// message_sends stores key addresses
+		keyAddr, err := a.deps.Chain.StateAccountKey(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("getting account key: %w", err)
+		}
+		addr = keyAddr.String()
+	}
+
+	var spend []WalletSpend
+	err := a.deps.DB.Select(ctx, &spend, `SELECT date_trunc('day', s.send_time) AS day, s.from_key, s.send_reason,
+			COUNT(*) AS messages,
+			COALESCE(SUM(w.executed_rcpt_gas_used), 0) AS gas_used,
+			COALESCE(SUM((s.signed_json->'Message'->>'Value')::NUMERIC), 0)::TEXT AS value
+		FROM message_sends s
+		LEFT JOIN message_waits w ON w.signed_message_cid = COALESCE(s.held_cid, s.signed_cid)
+		WHERE s.send_success AND s.send_time >= current_timestamp - make_interval(days => $1)
+		  AND ($2 = '' OR s.from_key = $2)
+		GROUP BY 1, 2, 3
+		ORDER BY 1 DESC, 2, 3`, days, addr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting spend history: %w", err)
+	}
+
+	for i := range spend {
+		v, err := big.FromString(spend[i].Value)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing value sum: %w", err)
+		}
+		spend[i].Value = types.FIL(v).Short()
+	}
+
+	return spend, nil
+}
+
+func (a *WebRPC) walletThresholds(ctx context.Context) (map[string]abi.TokenAmount, error) {
+	var rows []WalletThreshold
+	if err := a.deps.DB.Select(ctx, &rows, `SELECT address, min_balance::TEXT AS min_balance, updated_at FROM wallet_balance_thresholds`); err != nil {
+		return nil, xerrors.Errorf("getting wallet thresholds: %w", err)
+	}
+
+	out := map[string]abi.TokenAmount{}
+	for _, r := range rows {
+		v, err := big.FromString(r.MinBalance)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing threshold for %s: %w", r.Address, err)
+		}
+		out[r.Address] = v
+	}
+	return out, nil
+}
+
+// WalletThresholds returns per-address low balance thresholds. Addresses without one use
+// Alerting.MinimumWalletBalance.
+func (a *WebRPC) WalletThresholds(ctx context.Context) ([]WalletThreshold, error) {
+	var rows []WalletThreshold
+	if err := a.deps.DB.Select(ctx, &rows, `SELECT address, min_balance::TEXT AS min_balance, updated_at FROM wallet_balance_thresholds ORDER BY address`); err != nil {
+		return nil, xerrors.Errorf("getting wallet thresholds: %w", err)
+	}
+	for i := range rows {
+		v, err := big.FromString(rows[i].MinBalance)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing threshold for %s: %w", rows[i].Address, err)
+		}
+		rows[i].MinBalance = types.FIL(v).Short()
+	}
+	return rows, nil
+}
+
+// WalletSetThreshold sets the balance below which the balance alert fires for an address. minBalance is
+// a FIL amount, e.g. "10" or "500 mFIL".
+func (a *WebRPC) WalletSetThreshold(ctx context.Context, addr string, minBalance string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAlertsWrite); err != nil {
+		return err
+	}
+
+	maddr, err := address.NewFromString(addr)
+	if err != nil {
+		return xerrors.Errorf("parsing address: %w", err)
+	}
+	fil, err := types.ParseFIL(minBalance)
+	if err != nil {
+		return xerrors.Errorf("parsing balance: %w", err)
+	}
+
+	_, err = a.deps.DB.Exec(ctx, `INSERT INTO wallet_balance_thresholds (address, min_balance) VALUES ($1, $2::NUMERIC)
+		ON CONFLICT (address) DO UPDATE SET min_balance = EXCLUDED.min_balance, updated_at = CURRENT_TIMESTAMP`,
+		maddr.String(), abi.TokenAmount(fil).String())
+	if err != nil {
+		return xerrors.Errorf("setting threshold: %w", err)
+	}
+	return nil
+}
+
+// WalletRemoveThreshold removes the per-address threshold, reverting to Alerting.MinimumWalletBalance.
+func (a *WebRPC) WalletRemoveThreshold(ctx context.Context, addr string) error {
+	if err := apiauth.RequireScope(ctx, apiauth.ScopeAlertsWrite); err != nil {
+		return err
+	}
+
+	n, err := a.deps.DB.Exec(ctx, `DELETE FROM wallet_balance_thresholds WHERE address = $1`, addr)
+	if err != nil {
+		return xerrors.Errorf("removing threshold: %w", err)
+	}
+	if n != 1 {
+		return xerrors.Errorf("no threshold set for %s", addr)
+	}
+	return nil
+}
+
+// WalletTopUps returns the latest transfers sent by the wallet top-up task.
+func (a *WebRPC) WalletTopUps(ctx context.Context, limit int) ([]WalletTopUp, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	rows := []WalletTopUp{}
+	err := a.deps.DB.Select(ctx, &rows, `SELECT t.source, t.target, t.sp_id, t.amount::TEXT AS amount,
+			t.balance_before::TEXT AS balance_before, t.threshold::TEXT AS threshold, t.limited_by, t.signed_cid,
+			w.executed_tsk_epoch IS NOT NULL AS landed, t.created_at
+		FROM wallet_topups t
+		LEFT JOIN message_waits w ON w.signed_message_cid = t.signed_cid
+		ORDER BY t.created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("getting top-ups: %w", err)
+	}
+
+	for i := range rows {
+		for _, v := range []*string{&rows[i].Amount, &rows[i].BalanceBefore, &rows[i].Threshold} {
+			n, err := big.FromString(*v)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing amount: %w", err)
+			}
+			*v = types.FIL(n).Short()
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,102 @@
+package web
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// The static UI refers to pages, scripts and API endpoints with root-absolute URLs. When served under a
+// base path, those URLs are rewritten in text files served to the browser.
+
+var rewrittenExts = map[string]bool{".html": true, ".mjs": true, ".js": true, ".css": true}
+
+type basePathRewriter struct {
+	base string
+	re   *regexp.Regexp
+
+	// cache of rewritten files, not used in web dev mode where files change on disk
+	cache sync.Map
+	dev   bool
+}
+
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// newBasePathRewriter builds a rewriter for URLs pointing at the API and entries in the root of the
+// static file system.
+func newBasePathRewriter(base string, static fs.FS, root string, dev bool) (*basePathRewriter, error) {
+	root = strings.Trim(root, "/")
+	if root == "" {
+		root = "."
+	}
+	entries, err := fs.ReadDir(static, root)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{"api"}
+	for _, e := range entries {
+		names = append(names, regexp.QuoteMeta(e.Name()))
+	}
+
+	// a quote or url( followed by either a root entry, or a bare "/" link to the index
+	re, err := regexp.Compile("([\"'`(])/((?:" + strings.Join(names, "|") + ")\\b|[\"'`?#])")
+	if err != nil {
+		return nil, err
+	}
+
+	return &basePathRewriter{base: base, re: re, dev: dev}, nil
+}
+
+func (b *basePathRewriter) rewrite(content []byte) []byte {
+	return b.re.ReplaceAll(content, []byte("${1}"+b.base+"/${2}"))
+}
+
+// serve writes a static file, rewriting it if it's a text file which may contain URLs.
+func (b *basePathRewriter) serve(w http.ResponseWriter, r *http.Request, name string, fi fs.FileInfo, f fs.File) {
+	if !rewrittenExts[path.Ext(fi.Name())] {
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f.(io.ReadSeeker))
+		return
+	}
+
+	var content []byte
+	if c, ok := b.cache.Load(name); ok && !b.dev {
+		content = c.([]byte)
+	} else {
+		raw, err := io.ReadAll(f)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("500 Internal Server Error"))
+			return
+		}
+		content = b.rewrite(raw)
+		if !b.dev {
+			b.cache.Store(name, content)
+		}
+	}
+
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), bytes.NewReader(content))
+}
+
+// stripBasePath serves h under base, redirecting the bare base path to the index.
+func stripBasePath(base string, h http.Handler) http.Handler {
+	stripped := http.StripPrefix(base, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == base {
+			http.Redirect(w, r, base+"/", http.StatusMovedPermanently)
+			return
+		}
+		stripped.ServeHTTP(w, r)
+	})
+}
@@ -24,6 +24,7 @@ import (
 
 	"github.com/filecoin-project/curio/deps"
 	"github.com/filecoin-project/curio/web/api"
+	"github.com/filecoin-project/curio/web/api/health"
 
 	"github.com/filecoin-project/lotus/metrics"
 )
@@ -42,6 +43,8 @@ var webDev = os.Getenv("CURIO_WEB_DEV") == "1"
 func GetSrv(ctx context.Context, deps *deps.Deps, devMode bool) (*http.Server, error) {
 	mx := mux.NewRouter()
 	if !devMode {
+		// health is registered ahead of the API, outside of token auth
+		health.Routes(mx.PathPrefix("/api").Subrouter(), deps)
 		api.Routes(mx.PathPrefix("/api").Subrouter(), deps, webDev)
 	} else {
 		if err := setupDevModeProxy(mx); err != nil {
@@ -63,6 +66,16 @@ func GetSrv(ctx context.Context, deps *deps.Deps, devMode bool) (*http.Server, e
 		})
 	}
 
+	prefix := normalizeBasePath(deps.Cfg.Web.BasePath)
+	var rewriter *basePathRewriter
+	if prefix != "" {
+		var err error
+		rewriter, err = newBasePathRewriter(prefix, static, basePath, webDev || devMode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up base path: %w", err)
+		}
+	}
+
 	mx.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// If the request is for a directory, redirect to the index file.
 		if strings.HasSuffix(r.URL.Path, "/") {
@@ -84,11 +97,21 @@ func GetSrv(ctx context.Context, deps *deps.Deps, devMode bool) (*http.Server, e
 			return
 		}
 
+		if rewriter != nil {
+			rewriter.serve(w, r, r.URL.Path, fileInfo, file)
+			return
+		}
+
 		http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file.(io.ReadSeeker))
 	})
 
+	handler := withCORS(deps.Cfg.Web.CORSAllowedOrigins, mx)
+	if prefix != "" {
+		handler = stripBasePath(prefix, handler)
+	}
+
 	return &http.Server{
-		Handler: http.HandlerFunc(mx.ServeHTTP),
+		Handler: handler,
 		BaseContext: func(listener net.Listener) context.Context {
 			ctx, _ := tag.New(context.Background(), tag.Upsert(metrics.APIInterface, "curio"))
 			return ctx
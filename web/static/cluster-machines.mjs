@@ -35,6 +35,7 @@ customElements.define('cluster-machines', class ClusterMachines extends LitEleme
                                 <th>Uptime</th>
                                 <th>Tasks Supported</th>
                                 <th>Layers Enabled</th>
+                                <th>Status</th>
                             </tr>
                             </thead>
                             <tbody>
@@ -50,6 +51,7 @@ customElements.define('cluster-machines', class ClusterMachines extends LitEleme
                                     <td>${item.Uptime}</td>
                                     <td>${item.Tasks.split(',').map((item) => html`<a href="/task/?name=${item}">${item}</a> `)}</td>
                                     <td>${item.Layers.split(',').map((item) => html`<a href="/config/edit.html?layer=${item}">${item}</a> `)}</td>
+                                    <td>${item.RestartRequested ? 'Restarting' : (item.Unschedulable ? 'Cordoned' : 'Active')}</td>
                                 </tr>
                             `)}
                             </tbody>
@@ -0,0 +1,121 @@
+import { LitElement, html } from 'https://cdn.jsdelivr.net/gh/lit/dist@3/all/lit-all.min.js';
+import RPCCall from '/lib/jsonrpc.mjs';
+
+class BlockIndex extends LitElement {
+    static properties = {
+        states: { type: Array },
+        total: { type: Number },
+        query: { type: String },
+        results: { type: Array },
+        searchError: { type: String },
+    };
+
+    constructor() {
+        super();
+        this.states = [];
+        this.total = 0;
+        this.query = '';
+        this.results = null;
+        this.searchError = '';
+        this.loadData();
+    }
+
+    async loadData() {
+        try {
+            const page = await RPCCall('PieceIndexStates', [{ Limit: 50 }]);
+            this.states = page.Items;
+            this.total = page.Total;
+        } catch (error) {
+            console.error('Error loading piece index states:', error);
+        }
+        setTimeout(() => this.loadData(), 10000);
+    }
+
+    async search(e) {
+        e.preventDefault();
+        this.searchError = '';
+        try {
+            this.results = await RPCCall('BlockSearch', [this.query.trim()]);
+        } catch (error) {
+            this.results = null;
+            this.searchError = error.message;
+        }
+    }
+
+    renderResults() {
+        if (this.searchError) {
+            return html`<p class="error">${this.searchError}</p>`;
+        }
+        if (this.results === null) {
+            return '';
+        }
+        if (this.results.length === 0) {
+            return html`<p>The block is not in any indexed piece.</p>`;
+        }
+        return html`
+            <table class="table table-dark">
+                <thead>
+                <tr>
+                    <th>Piece CID</th>
+                    <th>Offset</th>
+                    <th>Size</th>
+                    <th>Parked</th>
+                    <th>Sectors (block offset)</th>
+                </tr>
+                </thead>
+                <tbody>
+                ${this.results.map(r => html`
+                    <tr>
+                        <td>${r.PieceCID}</td>
+                        <td>${r.Offset}</td>
+                        <td>${r.Size}</td>
+                        <td>${r.Parked ? 'Yes' : 'No'}</td>
+                        <td>${r.Sectors.map(s => html`<div><a href="/pages/sector/?sp=${s.Miner}&id=${s.SectorNumber}">${s.Miner}/${s.SectorNumber}</a> (${s.Offset})</div>`)}</td>
+                    </tr>
+                `)}
+                </tbody>
+            </table>
+        `;
+    }
+
+    render() {
+        return html`
+            <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.1.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-1BmE4kWBq78iYhFldvKuhfTAU6auU8tT94WrHftjDbrCEXSU1oBoqyl2QvZ6jIW3" crossorigin="anonymous">
+            <link rel="stylesheet" href="/ux/main.css">
+            <form class="row g-2 mb-3" @submit=${this.search}>
+                <div class="col-auto">
+                    <input class="form-control" size="64" placeholder="Block CID" .value=${this.query} @input=${e => this.query = e.target.value}>
+                </div>
+                <div class="col-auto">
+                    <button class="btn btn-primary" type="submit">Find Block</button>
+                </div>
+            </form>
+            ${this.renderResults()}
+
+            <p>Latest ${this.states.length} of ${this.total} indexed pieces.</p>
+            <table class="table table-dark">
+                <thead>
+                <tr>
+                    <th>Piece CID</th>
+                    <th>State</th>
+                    <th>Blocks</th>
+                    <th>Indexed</th>
+                    <th>Error</th>
+                </tr>
+                </thead>
+                <tbody>
+                ${this.states.map(s => html`
+                    <tr>
+                        <td>${s.PieceCID}</td>
+                        <td>${s.State === 'failed' ? html`<span class="error">failed</span>` : s.State}${s.TaskID ? html` (task ${s.TaskID})` : ''}</td>
+                        <td>${s.Blocks}</td>
+                        <td>${s.IndexedAt ? new Date(s.IndexedAt).toLocaleString() : ''}</td>
+                        <td>${s.Error ?? ''}</td>
+                    </tr>
+                `)}
+                </tbody>
+            </table>
+        `;
+    }
+}
+customElements.define('block-index', BlockIndex);
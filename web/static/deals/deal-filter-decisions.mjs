@@ -0,0 +1,66 @@
+import { LitElement, html } from 'https://cdn.jsdelivr.net/gh/lit/dist@3/all/lit-all.min.js';
+import RPCCall from '/lib/jsonrpc.mjs';
+
+class DealFilterDecisions extends LitElement {
+    static properties = {
+        data: { type: Array },
+        total: { type: Number },
+    };
+
+    constructor() {
+        super();
+        this.data = [];
+        this.total = 0;
+        this.loadData();
+    }
+
+    async loadData() {
+        try {
+            const page = await RPCCall('DealFilterDecisionsPage', [{ Limit: 100 }]);
+            this.data = page.Items;
+            this.total = page.Total;
+        } catch (error) {
+            console.error('Error loading deal filter decisions:', error);
+        }
+        setTimeout(() => this.loadData(), 10000);
+    }
+
+    render() {
+        return html`
+            <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.1.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-1BmE4kWBq78iYhFldvKuhfTAU6auU8tT94WrHftjDbrCEXSU1oBoqyl2QvZ6jIW3" crossorigin="anonymous">
+            <link rel="stylesheet" href="/ux/main.css">
+            <p>Latest ${this.data.length} of ${this.total} decisions on deals from market adapters, see Ingest.DealFilter.</p>
+            <table class="table table-dark">
+                <thead>
+                <tr>
+                    <th>Time</th>
+                    <th>Address</th>
+                    <th>Deal ID</th>
+                    <th>Client</th>
+                    <th>Piece CID</th>
+                    <th>Piece Size</th>
+                    <th>Decision</th>
+                    <th>Filter</th>
+                    <th>Reason</th>
+                </tr>
+                </thead>
+                <tbody>
+                ${this.data.map(entry => html`
+                    <tr>
+                        <td>${new Date(entry.CreatedAt).toLocaleString()}</td>
+                        <td>${entry.Miner}</td>
+                        <td>${entry.DealID ?? 'DDO'}</td>
+                        <td>${entry.Client ?? ''}</td>
+                        <td>${entry.PieceCID}</td>
+                        <td>${entry.PieceSizeStr}</td>
+                        <td>${entry.Accepted ? html`<span class="success">Accepted</span>` : html`<span class="error">Rejected</span>`}</td>
+                        <td>${entry.Filter}</td>
+                        <td>${entry.Reason}</td>
+                    </tr>
+                    `)}
+                </tbody>
+            </table>
+        `;
+    }
+}
+customElements.define('deal-filter-decisions', DealFilterDecisions);
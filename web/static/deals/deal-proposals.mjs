@@ -0,0 +1,73 @@
+import { LitElement, html } from 'https://cdn.jsdelivr.net/gh/lit/dist@3/all/lit-all.min.js';
+import RPCCall from '/lib/jsonrpc.mjs';
+
+class DealProposals extends LitElement {
+    static properties = {
+        data: { type: Array },
+        total: { type: Number },
+    };
+
+    constructor() {
+        super();
+        this.data = [];
+        this.total = 0;
+        this.loadData();
+    }
+
+    async loadData() {
+        try {
+            const page = await RPCCall('DealProposalsPage', [{ Limit: 100 }]);
+            this.data = page.Items;
+            this.total = page.Total;
+        } catch (error) {
+            console.error('Error loading deal proposals:', error);
+        }
+        setTimeout(() => this.loadData(), 10000);
+    }
+
+    renderResult(entry) {
+        if (entry.Valid === null) {
+            return entry.TaskID ? html`<span class="warning">Validating</span>` : html`<span class="warning">Queued</span>`;
+        }
+        return entry.Valid ? html`<span class="success">Valid</span>` : html`<span class="error">Invalid</span>`;
+    }
+
+    render() {
+        return html`
+            <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.1.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-1BmE4kWBq78iYhFldvKuhfTAU6auU8tT94WrHftjDbrCEXSU1oBoqyl2QvZ6jIW3" crossorigin="anonymous">
+            <link rel="stylesheet" href="/ux/main.css">
+            <p>Latest ${this.data.length} of ${this.total} deal proposals from market adapters, see Ingest.OffloadDealValidation.</p>
+            <table class="table table-dark">
+                <thead>
+                <tr>
+                    <th>Time</th>
+                    <th>Address</th>
+                    <th>Deal ID</th>
+                    <th>Client</th>
+                    <th>Piece CID</th>
+                    <th>Piece Size</th>
+                    <th>Verified</th>
+                    <th>Result</th>
+                    <th>Reason</th>
+                </tr>
+                </thead>
+                <tbody>
+                ${this.data.map(entry => html`
+                    <tr>
+                        <td>${new Date(entry.CreateTime).toLocaleString()}</td>
+                        <td>${entry.Miner}</td>
+                        <td>${entry.DealID ?? ''}</td>
+                        <td>${entry.Client ?? ''}</td>
+                        <td>${entry.PieceCID}</td>
+                        <td>${entry.PieceSizeStr}</td>
+                        <td>${entry.Verified ? 'Yes' : 'No'}</td>
+                        <td>${this.renderResult(entry)}</td>
+                        <td>${entry.Reason ?? ''}</td>
+                    </tr>
+                    `)}
+                </tbody>
+            </table>
+        `;
+    }
+}
+customElements.define('deal-proposals', DealProposals);
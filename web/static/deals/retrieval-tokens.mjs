@@ -0,0 +1,100 @@
+import { LitElement, html } from 'https://cdn.jsdelivr.net/gh/lit/dist@3/all/lit-all.min.js';
+import RPCCall from '/lib/jsonrpc.mjs';
+
+class RetrievalTokens extends LitElement {
+    static properties = {
+        tokens: { type: Array },
+        name: { type: String },
+        bandwidth: { type: String },
+        ttl: { type: String },
+        created: { type: String },
+        error: { type: String },
+    };
+
+    constructor() {
+        super();
+        this.tokens = [];
+        this.name = '';
+        this.bandwidth = '';
+        this.ttl = '';
+        this.created = '';
+        this.error = '';
+        this.loadData();
+    }
+
+    async loadData() {
+        try {
+            this.tokens = (await RPCCall('RetrievalTokenList')) || [];
+        } catch (error) {
+            console.error('Error loading retrieval tokens:', error);
+        }
+        setTimeout(() => this.loadData(), 10000);
+    }
+
+    async create() {
+        this.error = '';
+        this.created = '';
+        try {
+            this.created = await RPCCall('RetrievalTokenCreate', [this.name, this.bandwidth, this.ttl]);
+            this.name = '';
+            this.tokens = (await RPCCall('RetrievalTokenList')) || [];
+        } catch (error) {
+            this.error = error.message || String(error);
+        }
+    }
+
+    async revoke(id) {
+        this.error = '';
+        try {
+            await RPCCall('RetrievalTokenRevoke', [id]);
+            this.tokens = (await RPCCall('RetrievalTokenList')) || [];
+        } catch (error) {
+            this.error = error.message || String(error);
+        }
+    }
+
+    render() {
+        return html`
+            <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.1.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-1BmE4kWBq78iYhFldvKuhfTAU6auU8tT94WrHftjDbrCEXSU1oBoqyl2QvZ6jIW3" crossorigin="anonymous">
+            <link rel="stylesheet" href="/ux/main.css">
+            <p>Tokens of clients of the retrieval server (Retrieval.ListenAddress). Clients pass them in the 'Authorization: Bearer' header or the 'token' query parameter.</p>
+            <table class="table table-dark">
+                <thead>
+                <tr>
+                    <th>ID</th>
+                    <th>Name</th>
+                    <th>Bandwidth</th>
+                    <th>Served</th>
+                    <th>Created</th>
+                    <th>Expires</th>
+                    <th>Last Used</th>
+                    <th></th>
+                </tr>
+                </thead>
+                <tbody>
+                ${this.tokens.map(t => html`
+                    <tr>
+                        <td>${t.ID}</td>
+                        <td>${t.Name}</td>
+                        <td>${t.BandwidthStr || 'default'}</td>
+                        <td>${t.BytesServedStr}</td>
+                        <td>${new Date(t.CreatedAt).toLocaleString()}</td>
+                        <td>${t.ExpiresAt ? new Date(t.ExpiresAt).toLocaleString() : 'never'}</td>
+                        <td>${t.LastUsed ? new Date(t.LastUsed).toLocaleString() : ''}</td>
+                        <td>${t.Revoked ? html`<span class="error">revoked</span>` : html`<button class="btn btn-sm btn-danger" @click=${() => this.revoke(t.ID)}>Revoke</button>`}</td>
+                    </tr>
+                `)}
+                </tbody>
+            </table>
+            <div class="row g-2">
+                <div class="col-auto"><input class="form-control" placeholder="Name" .value=${this.name} @input=${e => this.name = e.target.value}></div>
+                <div class="col-auto"><input class="form-control" placeholder="Bandwidth/s (e.g. 50MiB)" .value=${this.bandwidth} @input=${e => this.bandwidth = e.target.value}></div>
+                <div class="col-auto"><input class="form-control" placeholder="TTL (e.g. 720h)" .value=${this.ttl} @input=${e => this.ttl = e.target.value}></div>
+                <div class="col-auto"><button class="btn btn-primary" @click=${() => this.create()}>Create Token</button></div>
+            </div>
+            ${this.created ? html`<p class="mt-2">New token, it won't be shown again: <code>${this.created}</code></p>` : ''}
+            ${this.error ? html`<p class="error mt-2">${this.error}</p>` : ''}
+        `;
+    }
+}
+customElements.define('retrieval-tokens', RetrievalTokens);
@@ -3,20 +3,33 @@ import RPCCall from '/lib/jsonrpc.mjs';
 
 class StorageGCStats extends LitElement {
     static properties = {
-        data: { type: Array }
+        data: { type: Array },
+        total: { type: Number },
+        offset: { type: Number }
     };
 
+    static pageSize = 100;
+
     constructor() {
         super();
         this.data = [];
+        this.total = 0;
+        this.offset = 0;
         this.loadData();
     }
 
     async loadData() {
-        this.data = await RPCCall('StorageGCMarks');
+        const page = await RPCCall('StorageGCMarksPage', [{ Limit: StorageGCStats.pageSize, Offset: this.offset }]);
+        this.data = page.Items;
+        this.total = page.Total;
         this.requestUpdate();
     }
 
+    changePage(delta) {
+        this.offset = Math.max(0, this.offset + delta * StorageGCStats.pageSize);
+        this.loadData();
+    }
+
     async approveEntry(entry) {
         await RPCCall('StorageGCApprove', [entry.Actor, entry.SectorNum, entry.FileType, entry.StorageID]);
         this.loadData();
@@ -64,6 +77,13 @@ class StorageGCStats extends LitElement {
                     `)}
                 </tbody>
             </table>
+            ${this.total > StorageGCStats.pageSize ? html`
+                <div>
+                    <button class="btn btn-secondary btn-sm" ?disabled=${this.offset === 0} @click="${() => this.changePage(-1)}">Previous</button>
+                    ${this.offset + 1}-${this.offset + this.data.length} of ${this.total}
+                    <button class="btn btn-secondary btn-sm" ?disabled=${this.offset + this.data.length >= this.total} @click="${() => this.changePage(1)}">Next</button>
+                </div>
+            ` : ''}
         `;
     }
 }
@@ -0,0 +1,133 @@
+import { LitElement, html, css } from 'https://cdn.jsdelivr.net/gh/lit/dist@3/all/lit-all.min.js';
+import RPCCall from '/lib/jsonrpc.mjs';
+
+customElements.define('alerts-console', class AlertsConsole extends LitElement {
+    constructor() {
+        super();
+        this.active = [];
+        this.history = [];
+        this.silences = [];
+        this.loadData();
+    }
+
+    async loadData() {
+        this.active = await RPCCall('AlertsActive') || [];
+        this.history = await RPCCall('AlertsHistory', ['', 100]) || [];
+        this.silences = await RPCCall('AlertSilences') || [];
+        this.requestUpdate();
+
+        setTimeout(() => this.loadData(), 10000);
+    }
+
+    async ack(id) {
+        await RPCCall('AlertAck', [id]);
+        this.active = await RPCCall('AlertsActive') || [];
+        this.requestUpdate();
+    }
+
+    async silence(name) {
+        const duration = prompt(`Silence "${name}" for how long? (e.g. 30m, 4h, 72h)`, '4h');
+        if (!duration) {
+            return;
+        }
+        await RPCCall('AlertSilence', [name, duration]);
+        this.silences = await RPCCall('AlertSilences') || [];
+        this.active = await RPCCall('AlertsActive') || [];
+        this.requestUpdate();
+    }
+
+    async unsilence(name) {
+        await RPCCall('AlertUnsilence', [name]);
+        this.silences = await RPCCall('AlertSilences') || [];
+        this.active = await RPCCall('AlertsActive') || [];
+        this.requestUpdate();
+    }
+
+    static styles = css`
+        .message {
+            white-space: pre-wrap;
+            max-width: 800px;
+        }
+    `;
+
+    render() {
+        return html`
+            <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-QWTKZyjpPEjISv5WaRU9OFeRpok6YctnYmDr5pNlyT2bRjXh0JMhjY6hW+ALEwIH" crossorigin="anonymous">
+            <link rel="stylesheet" href="/ux/main.css">
+
+            <h2>Active</h2>
+            <table class="table table-dark">
+                <thead>
+                <tr>
+                    <th>Alert</th>
+                    <th>Message</th>
+                    <th>First Seen</th>
+                    <th>Last Seen</th>
+                    <th>State</th>
+                    <th></th>
+                </tr>
+                </thead>
+                <tbody>
+                ${this.active.map(a => html`
+                    <tr>
+                        <td>${a.Name}</td>
+                        <td class="message">${a.Message}</td>
+                        <td>${new Date(a.FirstSeen).toLocaleString()}</td>
+                        <td>${new Date(a.LastSeen).toLocaleString()}</td>
+                        <td>
+                            ${a.AckedAt ? html`<span class="badge bg-secondary">Acknowledged</span>` : ''}
+                            ${a.SilencedUntil ? html`<span class="badge bg-secondary">Silenced until ${new Date(a.SilencedUntil).toLocaleString()}</span>` : ''}
+                        </td>
+                        <td>
+                            ${a.AckedAt ? '' : html`<button class="btn btn-secondary btn-sm" @click=${() => this.ack(a.ID)}>Ack</button>`}
+                            <button class="btn btn-secondary btn-sm" @click=${() => this.silence(a.Name)}>Silence</button>
+                        </td>
+                    </tr>
+                `)}
+                </tbody>
+            </table>
+
+            <h2>Silences</h2>
+            <table class="table table-dark">
+                <thead>
+                <tr>
+                    <th>Alert</th>
+                    <th>Until</th>
+                    <th></th>
+                </tr>
+                </thead>
+                <tbody>
+                ${this.silences.map(s => html`
+                    <tr>
+                        <td>${s.Name}</td>
+                        <td>${new Date(s.SilencedUntil).toLocaleString()}</td>
+                        <td><button class="btn btn-secondary btn-sm" @click=${() => this.unsilence(s.Name)}>Remove</button></td>
+                    </tr>
+                `)}
+                </tbody>
+            </table>
+
+            <h2>History</h2>
+            <table class="table table-dark">
+                <thead>
+                <tr>
+                    <th>Alert</th>
+                    <th>Message</th>
+                    <th>First Seen</th>
+                    <th>Resolved</th>
+                </tr>
+                </thead>
+                <tbody>
+                ${this.history.map(a => html`
+                    <tr>
+                        <td>${a.Name}</td>
+                        <td class="message">${a.Message}</td>
+                        <td>${new Date(a.FirstSeen).toLocaleString()}</td>
+                        <td>${a.ResolvedAt ? new Date(a.ResolvedAt).toLocaleString() : 'Active'}</td>
+                    </tr>
+                `)}
+                </tbody>
+            </table>
+        `;
+    }
+});
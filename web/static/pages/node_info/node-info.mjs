@@ -12,6 +12,16 @@ customElements.define('node-info',class NodeInfoElement extends LitElement {
 
         setTimeout(() => this.loadData(), 2500);
     }
+    async machineAction(method) {
+        await RPCCall(method, [ this.data.Info.ID ]);
+    }
+    async decommission() {
+        if (!confirm(`Decommission ${this.data.Info.Name}? Storage paths only reachable through this machine will be removed from the index.`)) {
+            return;
+        }
+        await RPCCall('ClusterMachineDecommission', [ this.data.Info.ID ]);
+        window.location.href = '/';
+    }
     render() {
         if (!this.data) {
             return html`<div>Loading...</div>`;
@@ -46,6 +56,16 @@ customElements.define('node-info',class NodeInfoElement extends LitElement {
                     </td>
                 </tr>
             </table>
+            <div>
+                Status: ${this.data.Info.RestartRequested ? 'Restart requested' : (this.data.Info.Unschedulable ? 'Cordoned' : 'Active')}
+                ${this.data.Info.Unschedulable
+                    ? html`<button class="btn btn-secondary btn-sm" @click=${() => this.machineAction('ClusterMachineUncordon')}>Uncordon</button>
+                           <button class="btn btn-danger btn-sm" @click=${() => this.decommission()}>Decommission</button>`
+                    : html`<button class="btn btn-secondary btn-sm" @click=${() => this.machineAction('ClusterMachineCordon')}>Cordon</button>`}
+                ${this.data.Info.RestartRequested
+                    ? html`<button class="btn btn-secondary btn-sm" @click=${() => this.machineAction('ClusterMachineAbortRestart')}>Abort Restart</button>`
+                    : html`<button class="btn btn-warning btn-sm" @click=${() => this.machineAction('ClusterMachineRestart')}>Restart</button>`}
+            </div>
             <hr>
             <h2>Configuration</h2>
             <table class="table table-dark">
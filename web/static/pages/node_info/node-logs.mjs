@@ -0,0 +1,83 @@
+import { LitElement, html, css } from 'https://cdn.jsdelivr.net/gh/lit/dist@3/all/lit-all.min.js';
+
+const maxLines = 500;
+
+customElements.define('node-logs', class NodeLogsElement extends LitElement {
+    static properties = {
+        subsystem: { type: String },
+        level: { type: String },
+    };
+
+    constructor() {
+        super();
+        this.subsystem = '';
+        this.level = 'info';
+        this.lines = [];
+        this.source = null;
+    }
+
+    disconnectedCallback() {
+        super.disconnectedCallback();
+        this.stop();
+    }
+
+    start() {
+        this.stop();
+        const id = new URLSearchParams(window.location.search).get('id');
+        const params = new URLSearchParams({ level: this.level, subsystem: this.subsystem });
+        this.source = new EventSource(`/api/logs/${id|0}?${params}`);
+        this.source.onmessage = (ev) => {
+            let entry;
+            try {
+                entry = JSON.parse(ev.data);
+            } catch (e) {
+                return;
+            }
+            this.lines.push(entry);
+            if (this.lines.length > maxLines) {
+                this.lines.splice(0, this.lines.length - maxLines);
+            }
+            this.requestUpdate();
+        };
+        this.source.onerror = () => {
+            this.stop();
+        };
+        this.requestUpdate();
+    }
+
+    stop() {
+        if (this.source) {
+            this.source.close();
+            this.source = null;
+            this.requestUpdate();
+        }
+    }
+
+    static styles = css`
+        pre {
+            max-height: 500px;
+            overflow-y: auto;
+            font-size: 0.8em;
+        }
+    `;
+
+    render() {
+        return html`
+            <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.1.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-1BmE4kWBq78iYhFldvKuhfTAU6auU8tT94WrHftjDbrCEXSU1oBoqyl2QvZ6jIW3" crossorigin="anonymous">
+            <link rel="stylesheet" href="/ux/main.css">
+
+            <div class="d-flex gap-2 mb-2">
+                <input class="form-control form-control-sm" style="width: 300px" placeholder="subsystems (comma separated)"
+                       .value=${this.subsystem} @input=${e => this.subsystem = e.target.value}>
+                <select class="form-select form-select-sm" style="width: 120px" @change=${e => this.level = e.target.value}>
+                    ${['debug', 'info', 'warn', 'error'].map(l => html`<option value=${l} ?selected=${l === this.level}>${l}</option>`)}
+                </select>
+                ${this.source
+                    ? html`<button class="btn btn-secondary btn-sm" @click=${() => this.stop()}>Stop</button>`
+                    : html`<button class="btn btn-primary btn-sm" @click=${() => this.start()}>Tail</button>`}
+                <button class="btn btn-secondary btn-sm" @click=${() => { this.lines = []; this.requestUpdate(); }}>Clear</button>
+            </div>
+            <pre>${this.lines.map(l => `${l.ts} ${(l.level || '').toUpperCase()} ${l.logger} ${l.caller || ''} ${l.msg}\n`)}</pre>
+        `;
+    }
+});
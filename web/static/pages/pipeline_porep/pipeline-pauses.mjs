@@ -0,0 +1,91 @@
+import { LitElement, html } from 'https://cdn.jsdelivr.net/gh/lit/dist@3/all/lit-all.min.js';
+import RPCCall from '/lib/jsonrpc.mjs';
+
+class PipelinePauses extends LitElement {
+    static properties = {
+        pauses: { type: Array },
+        miner: { type: String },
+        reason: { type: String },
+        error: { type: String },
+    };
+
+    constructor() {
+        super();
+        this.pauses = [];
+        this.miner = '';
+        this.reason = '';
+        this.error = '';
+        this.loadData();
+    }
+
+    async loadData() {
+        try {
+            this.pauses = await RPCCall('PipelinePauses');
+        } catch (error) {
+            console.error('Error loading pipeline pauses:', error);
+        }
+        setTimeout(() => this.loadData(), 5000);
+    }
+
+    async pause() {
+        this.error = '';
+        try {
+            await RPCCall('PipelinePause', [this.miner, this.reason]);
+            this.miner = '';
+            this.reason = '';
+            this.pauses = await RPCCall('PipelinePauses');
+        } catch (error) {
+            this.error = error.message || String(error);
+        }
+    }
+
+    async resume(miner) {
+        this.error = '';
+        try {
+            await RPCCall('PipelineResume', [miner]);
+            this.pauses = await RPCCall('PipelinePauses');
+        } catch (error) {
+            this.error = error.message || String(error);
+        }
+    }
+
+    render() {
+        return html`
+      <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.1.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-1BmE4kWBq78iYhFldvKuhfTAU6auU8tT94WrHftjDbrCEXSU1oBoqyl2QvZ6jIW3" crossorigin="anonymous">
+      <link rel="stylesheet" href="/ux/main.css">
+      <p>Paused SPs don't start SDR or precommit new sectors, sectors past those stages finish the pipeline.</p>
+      <table class="table table-dark">
+        <thead>
+          <tr>
+            <th>SP</th>
+            <th>Reason</th>
+            <th>Paused At</th>
+            <th>Held Sectors</th>
+            <th>Finishing Sectors</th>
+            <th></th>
+          </tr>
+        </thead>
+        <tbody>
+          ${this.pauses.map((p) => html`
+            <tr>
+              <td>${p.Miner}</td>
+              <td>${p.Reason}</td>
+              <td>${new Date(p.PausedAt).toLocaleString()}</td>
+              <td>${p.Waiting}</td>
+              <td>${p.InFlight}</td>
+              <td><button class="btn btn-primary btn-sm" @click="${() => this.resume(p.Miner)}">Resume</button></td>
+            </tr>
+          `)}
+          <tr>
+            <td><input class="form-control form-control-sm" placeholder="f0..." .value="${this.miner}" @input="${(e) => this.miner = e.target.value}"></td>
+            <td colspan="4"><input class="form-control form-control-sm" placeholder="reason" .value="${this.reason}" @input="${(e) => this.reason = e.target.value}"></td>
+            <td><button class="btn btn-warning btn-sm" ?disabled="${!this.miner}" @click="${this.pause}">Pause</button></td>
+          </tr>
+        </tbody>
+      </table>
+      ${this.error ? html`<div class="alert alert-danger">${this.error}</div>` : ''}
+    `;
+    }
+}
+
+customElements.define('pipeline-pauses', PipelinePauses);
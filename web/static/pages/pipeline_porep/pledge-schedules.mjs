@@ -0,0 +1,101 @@
+import { LitElement, html } from 'https://cdn.jsdelivr.net/gh/lit/dist@3/all/lit-all.min.js';
+import RPCCall from '/lib/jsonrpc.mjs';
+
+class PledgeSchedules extends LitElement {
+    static properties = {
+        schedules: { type: Array },
+        miner: { type: String },
+        perDay: { type: Number },
+        maxPipeline: { type: Number },
+        durationDays: { type: Number },
+        error: { type: String },
+    };
+
+    constructor() {
+        super();
+        this.schedules = [];
+        this.miner = '';
+        this.perDay = 0;
+        this.maxPipeline = 0;
+        this.durationDays = 0;
+        this.error = '';
+        this.loadData();
+    }
+
+    async loadData() {
+        try {
+            this.schedules = await RPCCall('PledgeSchedules');
+        } catch (error) {
+            console.error('Error loading pledge schedules:', error);
+        }
+        setTimeout(() => this.loadData(), 5000);
+    }
+
+    async set() {
+        this.error = '';
+        try {
+            await RPCCall('PledgeScheduleSet', [this.miner, this.perDay, this.maxPipeline, this.durationDays]);
+            this.miner = '';
+            this.schedules = await RPCCall('PledgeSchedules');
+        } catch (error) {
+            this.error = error.message || String(error);
+        }
+    }
+
+    async remove(miner) {
+        this.error = '';
+        try {
+            await RPCCall('PledgeScheduleRemove', [miner]);
+            this.schedules = await RPCCall('PledgeSchedules');
+        } catch (error) {
+            this.error = error.message || String(error);
+        }
+    }
+
+    render() {
+        return html`
+      <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.1.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-1BmE4kWBq78iYhFldvKuhfTAU6auU8tT94WrHftjDbrCEXSU1oBoqyl2QvZ6jIW3" crossorigin="anonymous">
+      <link rel="stylesheet" href="/ux/main.css">
+      <p>CC sectors are added to the pipeline at the target rate by nodes with Subsystems.EnableCCPledge, while fewer sectors than the maximum are in the pipeline and there is storage and collateral for them.</p>
+      <table class="table table-dark">
+        <thead>
+          <tr>
+            <th>SP</th>
+            <th>Sectors/Day</th>
+            <th>Max Pipeline</th>
+            <th>Duration (days)</th>
+            <th>Last Run</th>
+            <th>Last Pledged</th>
+            <th>Limited By</th>
+            <th></th>
+          </tr>
+        </thead>
+        <tbody>
+          ${this.schedules.map((s) => html`
+            <tr>
+              <td>${s.Miner}</td>
+              <td>${s.SectorsPerDay}</td>
+              <td>${s.MaxPipeline}</td>
+              <td>${s.DurationEpochs ? s.DurationEpochs / 2880 : 'default'}</td>
+              <td>${s.LastRun ? new Date(s.LastRun).toLocaleString() : ''}</td>
+              <td>${s.LastPledged}</td>
+              <td>${s.LimitedBy || ''}</td>
+              <td><button class="btn btn-primary btn-sm" @click="${() => this.remove(s.Miner)}">Remove</button></td>
+            </tr>
+          `)}
+          <tr>
+            <td><input class="form-control form-control-sm" placeholder="f0..." .value="${this.miner}" @input="${(e) => this.miner = e.target.value}"></td>
+            <td><input class="form-control form-control-sm" type="number" min="1" @input="${(e) => this.perDay = parseInt(e.target.value) || 0}"></td>
+            <td><input class="form-control form-control-sm" type="number" min="1" @input="${(e) => this.maxPipeline = parseInt(e.target.value) || 0}"></td>
+            <td><input class="form-control form-control-sm" type="number" min="0" placeholder="default" @input="${(e) => this.durationDays = parseInt(e.target.value) || 0}"></td>
+            <td colspan="3"></td>
+            <td><button class="btn btn-warning btn-sm" ?disabled="${!this.miner || !this.perDay || !this.maxPipeline}" @click="${this.set}">Set</button></td>
+          </tr>
+        </tbody>
+      </table>
+      ${this.error ? html`<div class="alert alert-danger">${this.error}</div>` : ''}
+    `;
+    }
+}
+
+customElements.define('pledge-schedules', PledgeSchedules);
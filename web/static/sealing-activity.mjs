@@ -0,0 +1,87 @@
+import { LitElement, html, css } from 'https://cdn.jsdelivr.net/gh/lit/dist@3/all/lit-all.min.js';
+
+const TiB = 1024 ** 4;
+
+customElements.define('sealing-activity', class SealingActivity extends LitElement {
+    static styles = css`
+        :host {
+            display: block;
+            width: 600px;
+            height: 250px;
+        }
+    `;
+
+    constructor() {
+        super();
+        this.loadData();
+    }
+
+    async loadData() {
+        const from = new Date(Date.now() - 30 * 24 * 3600 * 1000).toISOString();
+        const [sealed, gas] = await Promise.all([
+            fetch(`/api/charts/sealed?from=${from}`).then(r => r.json()),
+            fetch(`/api/charts/gas?from=${from}`).then(r => r.json()),
+        ]);
+
+        const days = {};
+        const day = (b) => new Date(b).toLocaleDateString();
+        (sealed || []).forEach(p => {
+            days[day(p.Bucket)] = days[day(p.Bucket)] || { bytes: 0, messages: 0 };
+            days[day(p.Bucket)].bytes += p.Bytes;
+        });
+        (gas || []).forEach(p => {
+            days[day(p.Bucket)] = days[day(p.Bucket)] || { bytes: 0, messages: 0 };
+            days[day(p.Bucket)].messages += p.Messages;
+        });
+        this.days = days;
+        this.renderChart();
+
+        setTimeout(() => this.loadData(), 15 * 60 * 1000);
+    }
+
+    renderChart() {
+        const labels = Object.keys(this.days).sort((a, b) => new Date(a) - new Date(b));
+        const config = {
+            type: 'bar',
+            data: {
+                labels,
+                datasets: [
+                    {
+                        label: 'Sealed (TiB)',
+                        backgroundColor: 'rgba(75, 192, 192, 0.6)',
+                        yAxisID: 'y',
+                        data: labels.map(l => this.days[l].bytes / TiB),
+                    },
+                    {
+                        label: 'Messages',
+                        type: 'line',
+                        borderColor: 'rgb(255, 99, 132)',
+                        pointRadius: 2,
+                        yAxisID: 'y1',
+                        data: labels.map(l => this.days[l].messages),
+                    },
+                ],
+            },
+            options: {
+                responsive: true,
+                maintainAspectRatio: false,
+                scales: {
+                    y: { beginAtZero: true, title: { display: true, text: 'TiB' } },
+                    y1: { beginAtZero: true, position: 'right', grid: { drawOnChartArea: false }, title: { display: true, text: 'Messages' } },
+                },
+            },
+        };
+
+        if (!this.chart) {
+            const ctx = this.shadowRoot.querySelector('canvas').getContext('2d');
+            this.chart = new Chart(ctx, config);
+        } else {
+            this.chart.data = config.data;
+            this.chart.update();
+        }
+    }
+
+    render() {
+        return html`<canvas></canvas>`;
+    }
+});
@@ -176,6 +176,12 @@ class CurioUX extends LitElement {
                 <span>Deals</span>
               </a>
             </li>
+            <li>
+              <a href="/pages/alerts/" class="nav-link text-white ${active=='/pages/alerts/'? 'active':''}">
+                <svg class="bi me-2" width="16" height="16"><use xlink:href="#grid"></use></svg>
+                <span>Alerts</span>
+              </a>
+            </li>
             <li>
               <a href="https://docs.curiostorage.org/" target="_blank" class="nav-link text-white">
               <svg class="bi me-2" xmlns="http://www.w3.org/2000/svg" width="16" height="16" fill="currentColor" class="bi bi-book-half" viewBox="0 0 16 16">